@@ -3,7 +3,6 @@ package main
 import (
 	"fmt"
 	"os"
-	"path/filepath"
 
 	"github.com/c0dev0id/notesd/notes-cli/internal/client"
 	"github.com/c0dev0id/notesd/notes-cli/internal/store"
@@ -17,8 +16,7 @@ func main() {
 		os.Exit(1)
 	}
 
-	dbPath := filepath.Join(cl.ConfigDir(), "notes.db")
-	st, err := store.Open(dbPath)
+	st, err := store.OpenCache(cl.ConfigDir())
 	if err != nil {
 		fmt.Fprintf(os.Stderr, "notes-tui: open store: %v\n", err)
 		os.Exit(1)
@@ -3,6 +3,7 @@ module github.com/c0dev0id/notesd/notes-cli
 go 1.25.0
 
 require (
+	github.com/fsnotify/fsnotify v1.10.1
 	github.com/spf13/cobra v1.10.2
 	golang.org/x/term v0.39.0
 )
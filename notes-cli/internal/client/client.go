@@ -2,13 +2,22 @@ package client
 
 import (
 	"bytes"
+	"context"
 	"encoding/json"
 	"fmt"
 	"io"
+	"mime"
+	"mime/multipart"
+	"net"
 	"net/http"
+	"net/url"
 	"os"
 	"path/filepath"
+	"strconv"
+	"strings"
 	"time"
+
+	"github.com/c0dev0id/notesd/notes-cli/internal/model"
 )
 
 type Client struct {
@@ -48,10 +57,10 @@ func NewWithDir(configDir string) (*Client, error) {
 		return nil, fmt.Errorf("create config dir: %w", err)
 	}
 
-	c := &Client{
-		httpClient: &http.Client{Timeout: 30 * time.Second},
-		configDir:  configDir,
-	}
+	c := &Client{configDir: configDir}
+	transport := http.DefaultTransport.(*http.Transport).Clone()
+	transport.DialContext = c.dial
+	c.httpClient = &http.Client{Timeout: 30 * time.Second, Transport: transport}
 
 	// Load config
 	cfg, _ := c.loadConfig()
@@ -74,6 +83,29 @@ func NewWithDir(configDir string) (*Client, error) {
 	return c, nil
 }
 
+// unixHost stands in for the host in requests to a unix:// server URL.
+const unixHost = "notesd.sock"
+
+// url returns the request URL for path on the server. A server URL such as
+// unix:///run/notesd.sock names the server's Unix socket; requests to it
+// are plain HTTP over a connection to that file.
+func (c *Client) url(path string) string {
+	if strings.HasPrefix(c.BaseURL, "unix://") {
+		return "http://" + unixHost + path
+	}
+	return c.BaseURL + path
+}
+
+// dial connects to the server's socket for a unix:// server URL and to
+// addr otherwise.
+func (c *Client) dial(ctx context.Context, network, addr string) (net.Conn, error) {
+	var d net.Dialer
+	if sock, ok := strings.CutPrefix(c.BaseURL, "unix://"); ok && addr == unixHost+":80" {
+		return d.DialContext(ctx, "unix", sock)
+	}
+	return d.DialContext(ctx, network, addr)
+}
+
 func (c *Client) IsLoggedIn() bool {
 	return c.session != nil && c.session.AccessToken != ""
 }
@@ -113,8 +145,7 @@ func (c *Client) doJSONOnce(method, path string, body, result any) (int, error)
 		bodyReader = bytes.NewReader(b)
 	}
 
-	url := c.BaseURL + path
-	req, err := http.NewRequest(method, url, bodyReader)
+	req, err := http.NewRequest(method, c.url(path), bodyReader)
 	if err != nil {
 		return 0, fmt.Errorf("create request: %w", err)
 	}
@@ -147,6 +178,338 @@ func (c *Client) doJSONOnce(method, path string, body, result any) (int, error)
 	return resp.StatusCode, nil
 }
 
+// VersionInfo matches the server's GET /api/v1/version response.
+type VersionInfo struct {
+	Version       string   `json:"version"`
+	Commit        string   `json:"commit,omitempty"`
+	GoVersion     string   `json:"go_version"`
+	SchemaVersion int      `json:"schema_version"`
+	Capabilities  []string `json:"capabilities"`
+}
+
+// ServerVersion fetches build and capability information from the server.
+func (c *Client) ServerVersion() (*VersionInfo, error) {
+	var v VersionInfo
+	if _, err := c.DoJSON("GET", "/api/v1/version", nil, &v); err != nil {
+		return nil, err
+	}
+	return &v, nil
+}
+
+// NoteList matches the server's paginated note listing.
+type NoteList struct {
+	Notes []model.Note `json:"notes"`
+	Total int          `json:"total"`
+}
+
+// SearchNotes runs a search on the server.
+func (c *Client) SearchNotes(query string, limit int) (*NoteList, error) {
+	q := url.Values{"q": {query}, "limit": {strconv.Itoa(limit)}}
+	var list NoteList
+	if _, err := c.DoJSON("GET", "/api/v1/notes/search?"+q.Encode(), nil, &list); err != nil {
+		return nil, err
+	}
+	return &list, nil
+}
+
+// SearchResult is one match of the server's search across notes and
+// todos. Type is "note" or "todo"; Title is a todo's content.
+type SearchResult struct {
+	Type       string     `json:"type"`
+	ID         string     `json:"id"`
+	Title      string     `json:"title"`
+	Snippet    string     `json:"snippet"`
+	NoteID     *string    `json:"note_id,omitempty"`
+	Completed  *bool      `json:"completed,omitempty"`
+	DueDate    *time.Time `json:"due_date,omitempty"`
+	AllDay     bool       `json:"all_day,omitempty"`
+	ModifiedAt time.Time  `json:"modified_at"`
+}
+
+// SearchResults matches the server's paginated search response.
+type SearchResults struct {
+	Results []SearchResult `json:"results"`
+	Total   int            `json:"total"`
+}
+
+// Search searches notes and todos on the server, best matches first.
+func (c *Client) Search(query string, limit int) (*SearchResults, error) {
+	q := url.Values{"q": {query}, "limit": {strconv.Itoa(limit)}}
+	var res SearchResults
+	if _, err := c.DoJSON("GET", "/api/v1/search?"+q.Encode(), nil, &res); err != nil {
+		return nil, err
+	}
+	return &res, nil
+}
+
+// Statistics matches the server's GET /stats response.
+type Statistics struct {
+	NotesByType map[string]int64 `json:"notes_by_type"`
+	Todos       struct {
+		Open      int64 `json:"open"`
+		Completed int64 `json:"completed"`
+		Overdue   int64 `json:"overdue"`
+	} `json:"todos"`
+	ContentBytes    int64 `json:"content_bytes"`
+	AttachmentBytes int64 `json:"attachment_bytes"`
+	WordsPerWeek    []struct {
+		Week  string `json:"week"`
+		Words int64  `json:"words"`
+	} `json:"words_per_week"`
+	TopTags []model.TagUsage `json:"top_tags"`
+}
+
+// Stats fetches account statistics covering the last weeks weeks and the
+// tags most used tags.
+func (c *Client) Stats(weeks, tags int) (*Statistics, error) {
+	q := url.Values{"weeks": {strconv.Itoa(weeks)}, "tags": {strconv.Itoa(tags)}}
+	var st Statistics
+	if _, err := c.DoJSON("GET", "/api/v1/stats?"+q.Encode(), nil, &st); err != nil {
+		return nil, err
+	}
+	return &st, nil
+}
+
+// ActivityEntry is one change in the server's GET /activity feed.
+type ActivityEntry struct {
+	ID         int64     `json:"id"`
+	EntityType string    `json:"entity_type"`
+	EntityID   string    `json:"entity_id"`
+	Action     string    `json:"action"`
+	Title      string    `json:"title"`
+	DeviceID   string    `json:"device_id"`
+	At         time.Time `json:"at"`
+}
+
+// Activity matches the server's paginated activity response.
+type Activity struct {
+	Entries []ActivityEntry `json:"entries"`
+	Total   int             `json:"total"`
+}
+
+// Activity fetches the account's changes to notes and todos, newest
+// first, skipping the offset most recent.
+func (c *Client) Activity(limit, offset int) (*Activity, error) {
+	q := url.Values{"limit": {strconv.Itoa(limit)}, "offset": {strconv.Itoa(offset)}}
+	var a Activity
+	if _, err := c.DoJSON("GET", "/api/v1/activity?"+q.Encode(), nil, &a); err != nil {
+		return nil, err
+	}
+	return &a, nil
+}
+
+// TodoSummary matches the server's GET /todos/summary response.
+type TodoSummary struct {
+	Days []struct {
+		Date  string `json:"date"`
+		Count int    `json:"count"`
+	} `json:"days"`
+	Completed      int      `json:"completed"`
+	CurrentStreak  int      `json:"current_streak"`
+	OnTime         int      `json:"on_time"`
+	Late           int      `json:"late"`
+	AvgDaysFromDue *float64 `json:"avg_days_from_due"`
+}
+
+// TodoSummary fetches todo completions over the last days days, by date
+// in the IANA time zone tz, or UTC when tz is empty.
+func (c *Client) TodoSummary(days int, tz string) (*TodoSummary, error) {
+	q := url.Values{"days": {strconv.Itoa(days)}}
+	if tz != "" {
+		q.Set("tz", tz)
+	}
+	var s TodoSummary
+	if _, err := c.DoJSON("GET", "/api/v1/todos/summary?"+q.Encode(), nil, &s); err != nil {
+		return nil, err
+	}
+	return &s, nil
+}
+
+// JournalEntry returns the journal note for date (YYYY-MM-DD), which the
+// server creates from the user's journal settings if it does not exist.
+func (c *Client) JournalEntry(date string) (*model.Note, error) {
+	var n model.Note
+	body := map[string]string{"device_id": c.deviceID}
+	if _, err := c.DoJSON("POST", "/api/v1/journal/"+url.PathEscape(date), body, &n); err != nil {
+		return nil, err
+	}
+	return &n, nil
+}
+
+// CreateBookmark saves the page at rawURL as a bookmark note. The server
+// fetches the page for its title, description and icon and, with archive,
+// keeps its readable text. An empty title takes the page's.
+func (c *Client) CreateBookmark(rawURL, title string, tags []string, archive bool) (*model.Note, error) {
+	var n model.Note
+	body := map[string]any{
+		"url":       rawURL,
+		"title":     title,
+		"tags":      tags,
+		"archive":   archive,
+		"device_id": c.deviceID,
+	}
+	if _, err := c.DoJSON("POST", "/api/v1/bookmarks", body, &n); err != nil {
+		return nil, err
+	}
+	return &n, nil
+}
+
+// Backlinks returns the notes on the server that wiki-link to a note.
+func (c *Client) Backlinks(noteID string) ([]model.Note, error) {
+	var notes []model.Note
+	if _, err := c.DoJSON("GET", "/api/v1/notes/"+url.PathEscape(noteID)+"/backlinks", nil, &notes); err != nil {
+		return nil, err
+	}
+	return notes, nil
+}
+
+// ListAttachments returns the attachments of a note.
+func (c *Client) ListAttachments(noteID string) ([]model.Attachment, error) {
+	var list []model.Attachment
+	if _, err := c.DoJSON("GET", "/api/v1/notes/"+url.PathEscape(noteID)+"/attachments", nil, &list); err != nil {
+		return nil, err
+	}
+	return list, nil
+}
+
+// UploadAttachment attaches the file at path to a note.
+func (c *Client) UploadAttachment(noteID, path string) (*model.Attachment, error) {
+	f, err := os.Open(path)
+	if err != nil {
+		return nil, err
+	}
+	defer f.Close()
+
+	// Buffered so the body can be sent again after a token refresh.
+	var buf bytes.Buffer
+	mw := multipart.NewWriter(&buf)
+	fw, err := mw.CreateFormFile("file", filepath.Base(path))
+	if err != nil {
+		return nil, err
+	}
+	if _, err := io.Copy(fw, f); err != nil {
+		return nil, fmt.Errorf("read %s: %w", path, err)
+	}
+	if err := mw.Close(); err != nil {
+		return nil, err
+	}
+
+	resp, err := c.doRaw("POST", "/api/v1/notes/"+url.PathEscape(noteID)+"/attachments",
+		mw.FormDataContentType(), buf.Bytes())
+	if err != nil {
+		return nil, err
+	}
+	defer resp.Body.Close()
+	var at model.Attachment
+	if err := json.NewDecoder(resp.Body).Decode(&at); err != nil {
+		return nil, fmt.Errorf("decode response: %w", err)
+	}
+	return &at, nil
+}
+
+// DownloadAttachment opens an attachment's content. It returns the body,
+// which the caller closes, and the file name sent by the server.
+func (c *Client) DownloadAttachment(id string) (io.ReadCloser, string, error) {
+	resp, err := c.doRaw("GET", "/api/v1/attachments/"+url.PathEscape(id), "", nil)
+	if err != nil {
+		return nil, "", err
+	}
+	var filename string
+	if _, params, err := mime.ParseMediaType(resp.Header.Get("Content-Disposition")); err == nil {
+		filename = params["filename"]
+	}
+	return resp.Body, filename, nil
+}
+
+// Export opens a tar.gz archive of all the user's notes, todos and
+// attachments. The caller closes the returned body.
+func (c *Client) Export() (io.ReadCloser, error) {
+	resp, err := c.doRaw("GET", "/api/v1/export?format=tar.gz", "", nil)
+	if err != nil {
+		return nil, err
+	}
+	return resp.Body, nil
+}
+
+// Import sends an import file in the given format (notesd, enex or
+// markdown) to the server.
+func (c *Client) Import(format string, body []byte) (*model.ImportSummary, error) {
+	contentType := "application/zip"
+	if format == "enex" {
+		contentType = "application/xml"
+	}
+	resp, err := c.doRaw("POST", "/api/v1/import?format="+url.QueryEscape(format), contentType, body)
+	if err != nil {
+		return nil, err
+	}
+	defer resp.Body.Close()
+	var summary model.ImportSummary
+	if err := json.NewDecoder(resp.Body).Decode(&summary); err != nil {
+		return nil, fmt.Errorf("decode response: %w", err)
+	}
+	return &summary, nil
+}
+
+// PurgeNote permanently deletes a note on the server.
+func (c *Client) PurgeNote(id string) error {
+	resp, err := c.doRaw("DELETE", "/api/v1/notes/"+url.PathEscape(id)+"?purge=true", "", nil)
+	if err != nil {
+		return err
+	}
+	resp.Body.Close()
+	return nil
+}
+
+// doRaw makes an authenticated request with a non-JSON body or response,
+// refreshing the access token once on 401. On success the caller closes
+// the response body.
+func (c *Client) doRaw(method, path, contentType string, body []byte) (*http.Response, error) {
+	resp, err := c.doRawOnce(method, path, contentType, body)
+	if err == nil && resp.StatusCode == http.StatusUnauthorized &&
+		c.session != nil && c.session.RefreshToken != "" {
+		if refreshErr := c.refreshTokens(); refreshErr == nil {
+			resp.Body.Close()
+			resp, err = c.doRawOnce(method, path, contentType, body)
+		}
+	}
+	if err != nil {
+		return nil, err
+	}
+	if resp.StatusCode >= 400 {
+		defer resp.Body.Close()
+		var errResp struct {
+			Error string `json:"error"`
+		}
+		if err := json.NewDecoder(resp.Body).Decode(&errResp); err == nil && errResp.Error != "" {
+			return nil, fmt.Errorf("%s", errResp.Error)
+		}
+		return nil, fmt.Errorf("HTTP %d", resp.StatusCode)
+	}
+	return resp, nil
+}
+
+func (c *Client) doRawOnce(method, path, contentType string, body []byte) (*http.Response, error) {
+	var bodyReader io.Reader
+	if body != nil {
+		bodyReader = bytes.NewReader(body)
+	}
+	req, err := http.NewRequest(method, c.url(path), bodyReader)
+	if err != nil {
+		return nil, fmt.Errorf("create request: %w", err)
+	}
+	if contentType != "" {
+		req.Header.Set("Content-Type", contentType)
+	}
+	if c.session != nil && c.session.AccessToken != "" {
+		req.Header.Set("Authorization", "Bearer "+c.session.AccessToken)
+	}
+	resp, err := c.httpClient.Do(req)
+	if err != nil {
+		return nil, fmt.Errorf("request %s %s: %w", method, path, err)
+	}
+	return resp, nil
+}
+
 // Auth types matching the server API
 
 type AuthResponse struct {
@@ -195,14 +558,20 @@ func (c *Client) Login(serverURL, email, password, deviceID string) error {
 	return nil
 }
 
-func (c *Client) Register(serverURL, email, password, displayName string) error {
+// Register creates an account. inviteCode is only needed on servers that
+// accept registrations by invite; pass "" otherwise.
+func (c *Client) Register(serverURL, email, password, displayName, inviteCode string) error {
 	c.BaseURL = serverURL
 
-	status, err := c.doJSONOnce("POST", "/api/v1/auth/register", map[string]string{
+	body := map[string]string{
 		"email":        email,
 		"password":     password,
 		"display_name": displayName,
-	}, nil)
+	}
+	if inviteCode != "" {
+		body["invite_code"] = inviteCode
+	}
+	status, err := c.doJSONOnce("POST", "/api/v1/auth/register", body, nil)
 	if err != nil {
 		return err
 	}
@@ -220,6 +589,53 @@ func (c *Client) Logout() error {
 	return c.deleteSession()
 }
 
+// ChangePassword sets a new password for the logged-in user. The server
+// revokes the sessions of all other devices; this one stays logged in.
+func (c *Client) ChangePassword(current, newPassword string) error {
+	status, err := c.DoJSON("POST", "/api/v1/auth/password", map[string]string{
+		"current_password": current,
+		"new_password":     newPassword,
+	}, nil)
+	if err != nil {
+		return err
+	}
+	if status != http.StatusNoContent {
+		return fmt.Errorf("change password failed (HTTP %d)", status)
+	}
+	return nil
+}
+
+// ListTokens returns the account's personal access tokens, without their
+// secrets.
+func (c *Client) ListTokens() ([]model.AccessToken, error) {
+	var list []model.AccessToken
+	if _, err := c.DoJSON("GET", "/api/v1/auth/tokens", nil, &list); err != nil {
+		return nil, err
+	}
+	return list, nil
+}
+
+// CreateToken creates a personal access token with scope "read" or
+// "write". A nil expiresAt makes it last until revoked.
+func (c *Client) CreateToken(name, scope string, expiresAt *time.Time) (*model.AccessToken, error) {
+	var tok model.AccessToken
+	_, err := c.DoJSON("POST", "/api/v1/auth/tokens", map[string]any{
+		"name":       name,
+		"scope":      scope,
+		"expires_at": expiresAt,
+	}, &tok)
+	if err != nil {
+		return nil, err
+	}
+	return &tok, nil
+}
+
+// RevokeToken deletes a personal access token.
+func (c *Client) RevokeToken(id string) error {
+	_, err := c.DoJSON("DELETE", "/api/v1/auth/tokens/"+url.PathEscape(id), nil, nil)
+	return err
+}
+
 func (c *Client) refreshTokens() error {
 	var resp AuthResponse
 	status, err := c.doJSONOnce("POST", "/api/v1/auth/refresh", map[string]string{
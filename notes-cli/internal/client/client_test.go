@@ -2,9 +2,12 @@ package client
 
 import (
 	"encoding/json"
+	"io"
+	"net"
 	"net/http"
 	"net/http/httptest"
 	"os"
+	"path/filepath"
 	"sync/atomic"
 	"testing"
 )
@@ -75,6 +78,37 @@ func TestLoginSuccess(t *testing.T) {
 	t.Logf("persisted session: access_token=%s refresh_token=%s", s2.AccessToken, s2.RefreshToken)
 }
 
+func TestLoginUnixSocket(t *testing.T) {
+	// Arrange: a server listening on a Unix socket only
+	sock := filepath.Join(t.TempDir(), "notesd.sock")
+	ln, err := net.Listen("unix", sock)
+	if err != nil {
+		t.Fatalf("listen: %v", err)
+	}
+	srv := httptest.NewUnstartedServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.URL.Path != "/api/v1/auth/login" {
+			t.Errorf("unexpected request: %s %s", r.Method, r.URL.Path)
+		}
+		writeJSON(w, http.StatusOK, authResp("uid1", "user@example.com", "User"))
+	}))
+	srv.Listener = ln
+	srv.Start()
+	defer srv.Close()
+	c := newTestClient(t, nil)
+
+	// Act
+	err = c.Login("unix://"+sock, "user@example.com", "pass", "dev1")
+
+	// Assert
+	if err != nil {
+		t.Fatalf("Login over %s: %v", sock, err)
+	}
+	t.Logf("logged in over %s: user=%s", sock, c.session.UserID)
+	if c.session.ServerURL != "unix://"+sock {
+		t.Errorf("server URL: got %q", c.session.ServerURL)
+	}
+}
+
 func TestLoginWrongPassword(t *testing.T) {
 	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
 		writeJSON(w, http.StatusUnauthorized, map[string]string{"error": "invalid credentials"})
@@ -99,12 +133,18 @@ func TestRegisterSuccess(t *testing.T) {
 		if r.URL.Path != "/api/v1/auth/register" {
 			t.Errorf("unexpected path: %s", r.URL.Path)
 		}
+		var body map[string]string
+		json.NewDecoder(r.Body).Decode(&body)
+		t.Logf("register body: %v", body)
+		if body["invite_code"] != "abc123" {
+			t.Errorf("invite_code: got %q", body["invite_code"])
+		}
 		w.WriteHeader(http.StatusCreated)
 	}))
 	defer srv.Close()
 
 	c := newTestClient(t, srv)
-	if err := c.Register(srv.URL, "new@example.com", "pass1234", "New User"); err != nil {
+	if err := c.Register(srv.URL, "new@example.com", "pass1234", "New User", "abc123"); err != nil {
 		t.Fatalf("Register: %v", err)
 	}
 	t.Log("register: success")
@@ -117,7 +157,7 @@ func TestRegisterDuplicate(t *testing.T) {
 	defer srv.Close()
 
 	c := newTestClient(t, srv)
-	err := c.Register(srv.URL, "dup@example.com", "pass1234", "Dup")
+	err := c.Register(srv.URL, "dup@example.com", "pass1234", "Dup", "")
 	t.Logf("duplicate register error: %v", err)
 	if err == nil {
 		t.Error("expected error for duplicate registration")
@@ -158,6 +198,72 @@ func TestLogoutClearsSession(t *testing.T) {
 	t.Log("session file deleted: ok")
 }
 
+func TestChangePassword(t *testing.T) {
+	var got map[string]string
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.Method != http.MethodPost || r.URL.Path != "/api/v1/auth/password" {
+			t.Errorf("unexpected request: %s %s", r.Method, r.URL.Path)
+		}
+		json.NewDecoder(r.Body).Decode(&got)
+		if got["current_password"] != "oldpassword" {
+			writeJSON(w, http.StatusForbidden, map[string]string{"error": "current password is incorrect"})
+			return
+		}
+		w.WriteHeader(http.StatusNoContent)
+	}))
+	defer srv.Close()
+
+	c := newTestClient(t, srv)
+	c.session = &Session{AccessToken: "tok", RefreshToken: "ref", ServerURL: srv.URL}
+
+	if err := c.ChangePassword("oldpassword", "newpassword"); err != nil {
+		t.Fatalf("ChangePassword: %v", err)
+	}
+	t.Logf("request body: %v", got)
+	if got["new_password"] != "newpassword" {
+		t.Errorf("new_password: got %q", got["new_password"])
+	}
+
+	err := c.ChangePassword("wrong", "newpassword")
+	t.Logf("wrong current password: %v", err)
+	if err == nil || err.Error() != "current password is incorrect" {
+		t.Errorf("expected the server's error message, got %v", err)
+	}
+	if !c.IsLoggedIn() {
+		t.Error("should stay logged in after changing the password")
+	}
+}
+
+func TestCreateToken(t *testing.T) {
+	var got map[string]any
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.Method != http.MethodPost || r.URL.Path != "/api/v1/auth/tokens" {
+			t.Errorf("unexpected request: %s %s", r.Method, r.URL.Path)
+		}
+		json.NewDecoder(r.Body).Decode(&got)
+		writeJSON(w, http.StatusCreated, map[string]any{
+			"id": "tok1", "name": got["name"], "scope": got["scope"], "token": "notesd_pat_secret",
+			"created_at": "2026-01-02T03:04:05Z",
+		})
+	}))
+	defer srv.Close()
+
+	c := newTestClient(t, srv)
+	c.session = &Session{AccessToken: "tok", RefreshToken: "ref", ServerURL: srv.URL}
+
+	tok, err := c.CreateToken("cron", "read", nil)
+	if err != nil {
+		t.Fatalf("CreateToken: %v", err)
+	}
+	t.Logf("request body: %v, token: %+v", got, tok)
+	if got["name"] != "cron" || got["scope"] != "read" || got["expires_at"] != nil {
+		t.Errorf("unexpected request body %v", got)
+	}
+	if tok.ID != "tok1" || tok.Token != "notesd_pat_secret" {
+		t.Errorf("unexpected token %+v", tok)
+	}
+}
+
 // --- Token refresh ---
 
 func TestDoJSONRefreshOnUnauthorized(t *testing.T) {
@@ -351,3 +457,211 @@ func TestNewWithDirLoadsExistingSession(t *testing.T) {
 		t.Errorf("BaseURL from session: got %q", c.BaseURL)
 	}
 }
+
+// --- Version ---
+
+func TestServerVersion(t *testing.T) {
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.URL.Path != "/api/v1/version" {
+			t.Errorf("unexpected request: %s %s", r.Method, r.URL.Path)
+		}
+		writeJSON(w, http.StatusOK, VersionInfo{
+			Version: "v1.2.3", GoVersion: "go1.24", SchemaVersion: 1, Capabilities: []string{"tags"},
+		})
+	}))
+	defer srv.Close()
+
+	c := newTestClient(t, srv)
+	v, err := c.ServerVersion()
+	if err != nil {
+		t.Fatalf("ServerVersion: %v", err)
+	}
+	t.Logf("version: %+v", v)
+	if v.Version != "v1.2.3" || v.SchemaVersion != 1 || len(v.Capabilities) != 1 {
+		t.Errorf("unexpected version info: %+v", v)
+	}
+}
+
+func TestSearchNotes(t *testing.T) {
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		t.Logf("request: %s", r.URL)
+		if r.URL.Path != "/api/v1/notes/search" || r.URL.Query().Get("q") != "a&b" {
+			t.Errorf("unexpected request: %s", r.URL)
+		}
+		writeJSON(w, http.StatusOK, map[string]any{
+			"notes": []map[string]string{{"id": "n1", "title": "A and B"}},
+			"total": 1,
+		})
+	}))
+	defer srv.Close()
+
+	c := newTestClient(t, srv)
+	list, err := c.SearchNotes("a&b", 20)
+	if err != nil {
+		t.Fatalf("SearchNotes: %v", err)
+	}
+	if list.Total != 1 || len(list.Notes) != 1 || list.Notes[0].ID != "n1" {
+		t.Errorf("unexpected result: %+v", list)
+	}
+}
+
+func TestSearch(t *testing.T) {
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		t.Logf("request: %s", r.URL)
+		if r.URL.Path != "/api/v1/search" || r.URL.Query().Get("q") != "milk" || r.URL.Query().Get("limit") != "5" {
+			t.Errorf("unexpected request: %s", r.URL)
+		}
+		writeJSON(w, http.StatusOK, map[string]any{
+			"results": []map[string]any{
+				{"type": "todo", "id": "t1", "title": "buy milk", "snippet": "buy milk", "completed": false},
+				{"type": "note", "id": "n1", "title": "Shopping", "snippet": "and milk"},
+			},
+			"total": 2,
+		})
+	}))
+	defer srv.Close()
+
+	// Act
+	c := newTestClient(t, srv)
+	res, err := c.Search("milk", 5)
+
+	// Assert
+	if err != nil {
+		t.Fatalf("Search: %v", err)
+	}
+	t.Logf("results: %+v", res)
+	if res.Total != 2 || len(res.Results) != 2 || res.Results[0].Type != "todo" || res.Results[0].Completed == nil || res.Results[1].Snippet != "and milk" {
+		t.Errorf("unexpected result: %+v", res)
+	}
+}
+
+func TestActivity(t *testing.T) {
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		t.Logf("request: %s", r.URL)
+		if r.URL.Path != "/api/v1/activity" || r.URL.Query().Get("limit") != "10" || r.URL.Query().Get("offset") != "20" {
+			t.Errorf("unexpected request: %s", r.URL)
+		}
+		writeJSON(w, http.StatusOK, map[string]any{
+			"entries": []map[string]any{
+				{"id": 3, "entity_type": "todo", "entity_id": "t1", "action": "complete", "title": "buy milk", "at": "2026-10-17T09:00:00Z"},
+			},
+			"total": 21,
+		})
+	}))
+	defer srv.Close()
+
+	// Act
+	c := newTestClient(t, srv)
+	a, err := c.Activity(10, 20)
+
+	// Assert
+	if err != nil {
+		t.Fatalf("Activity: %v", err)
+	}
+	t.Logf("activity: %+v", a)
+	if a.Total != 21 || len(a.Entries) != 1 || a.Entries[0].Action != "complete" || a.Entries[0].At.IsZero() {
+		t.Errorf("unexpected result: %+v", a)
+	}
+}
+
+func TestJournalEntry(t *testing.T) {
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		var body map[string]string
+		json.NewDecoder(r.Body).Decode(&body)
+		t.Logf("request: %s %s %v", r.Method, r.URL.Path, body)
+		if r.Method != "POST" || r.URL.Path != "/api/v1/journal/2026-03-05" || body["device_id"] == "" {
+			t.Errorf("unexpected request: %s %s %v", r.Method, r.URL.Path, body)
+		}
+		writeJSON(w, http.StatusCreated, map[string]string{"id": "n1", "title": "2026-03-05"})
+	}))
+	defer srv.Close()
+
+	// Act
+	c := newTestClient(t, srv)
+	n, err := c.JournalEntry("2026-03-05")
+
+	// Assert
+	if err != nil {
+		t.Fatalf("JournalEntry: %v", err)
+	}
+	if n.ID != "n1" || n.Title != "2026-03-05" {
+		t.Errorf("unexpected note: %+v", n)
+	}
+}
+
+func TestCreateBookmark(t *testing.T) {
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		var body map[string]any
+		json.NewDecoder(r.Body).Decode(&body)
+		t.Logf("request: %s %s %v", r.Method, r.URL.Path, body)
+		if r.Method != "POST" || r.URL.Path != "/api/v1/bookmarks" ||
+			body["url"] != "https://example.com/post" || body["archive"] != true || body["device_id"] == "" {
+			t.Errorf("unexpected request: %s %s %v", r.Method, r.URL.Path, body)
+		}
+		writeJSON(w, http.StatusCreated, map[string]string{"id": "n1", "title": "A post", "type": "bookmark"})
+	}))
+	defer srv.Close()
+
+	// Act
+	c := newTestClient(t, srv)
+	n, err := c.CreateBookmark("https://example.com/post", "", []string{"reading"}, true)
+
+	// Assert
+	if err != nil {
+		t.Fatalf("CreateBookmark: %v", err)
+	}
+	if n.ID != "n1" || n.Type != "bookmark" {
+		t.Errorf("unexpected note: %+v", n)
+	}
+}
+
+func TestAttachmentUploadAndDownload(t *testing.T) {
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		t.Logf("request: %s %s", r.Method, r.URL.Path)
+		switch {
+		case r.Method == "POST" && r.URL.Path == "/api/v1/notes/n1/attachments":
+			f, hdr, err := r.FormFile("file")
+			if err != nil {
+				t.Errorf("form file: %v", err)
+				return
+			}
+			data, _ := io.ReadAll(f)
+			writeJSON(w, http.StatusCreated, map[string]any{
+				"id": "a1", "note_id": "n1", "filename": hdr.Filename, "size": len(data),
+			})
+		case r.Method == "GET" && r.URL.Path == "/api/v1/attachments/a1":
+			w.Header().Set("Content-Disposition", `attachment; filename="hello.txt"`)
+			w.Write([]byte("hello"))
+		default:
+			writeJSON(w, http.StatusNotFound, map[string]string{"error": "attachment not found"})
+		}
+	}))
+	defer srv.Close()
+
+	c := newTestClient(t, srv)
+	path := filepath.Join(t.TempDir(), "hello.txt")
+	os.WriteFile(path, []byte("hello"), 0600)
+
+	at, err := c.UploadAttachment("n1", path)
+	if err != nil {
+		t.Fatalf("UploadAttachment: %v", err)
+	}
+	t.Logf("uploaded: %+v", at)
+	if at.ID != "a1" || at.Filename != "hello.txt" || at.Size != 5 {
+		t.Errorf("unexpected metadata: %+v", at)
+	}
+
+	body, name, err := c.DownloadAttachment("a1")
+	if err != nil {
+		t.Fatalf("DownloadAttachment: %v", err)
+	}
+	data, _ := io.ReadAll(body)
+	body.Close()
+	if name != "hello.txt" || string(data) != "hello" {
+		t.Errorf("download: name=%q data=%q", name, data)
+	}
+
+	if _, _, err := c.DownloadAttachment("missing"); err == nil || err.Error() != "attachment not found" {
+		t.Errorf("expected server error message, got %v", err)
+	}
+}
@@ -0,0 +1,54 @@
+package cmd
+
+import (
+	"fmt"
+
+	"github.com/spf13/cobra"
+)
+
+var activityCmd = &cobra.Command{
+	Use:   "activity",
+	Short: "Show recent changes to notes and todos",
+	Long: `Show the account's changes to notes and todos as recorded by the server,
+newest first: what was created, edited, completed, deleted, restored or
+purged, when, and from which device. Use --page for older changes.
+Changes not yet synced are not included, and the server may drop old
+entries after its audit retention period.`,
+	Args: cobra.NoArgs,
+	RunE: runActivity,
+}
+
+func init() {
+	activityCmd.Flags().IntP("limit", "l", 20, "Number of changes per page")
+	activityCmd.Flags().IntP("page", "p", 1, "Page to show, 1 being the most recent")
+}
+
+func runActivity(cmd *cobra.Command, args []string) error {
+	limit, _ := cmd.Flags().GetInt("limit")
+	page, _ := cmd.Flags().GetInt("page")
+	if limit < 1 || page < 1 {
+		return fmt.Errorf("--limit and --page must be at least 1")
+	}
+	a, err := cl.Activity(limit, (page-1)*limit)
+	if err != nil {
+		return err
+	}
+	if ok, err := printStructured(a.Entries, activityHeader, activityRecords(a.Entries)); ok {
+		return err
+	}
+	if len(a.Entries) == 0 {
+		fmt.Println("No activity.")
+		return nil
+	}
+	for _, e := range a.Entries {
+		title := e.Title
+		if title == "" {
+			title = "(untitled)"
+		}
+		fmt.Printf("%s  %-8s  %-4s  %-38s  %s\n", e.At.Local().Format("2006-01-02 15:04"), e.Action, e.EntityType, e.EntityID, title)
+	}
+	if shown := (page-1)*limit + len(a.Entries); shown < a.Total {
+		fmt.Printf("\n%d of %d changes; more with --page %d\n", shown, a.Total, page+1)
+	}
+	return nil
+}
@@ -0,0 +1,93 @@
+package cmd
+
+import (
+	"fmt"
+	"strings"
+	"time"
+
+	"github.com/c0dev0id/notesd/notes-cli/internal/model"
+	"github.com/spf13/cobra"
+)
+
+var addCmd = &cobra.Command{
+	Use:   "add <text>",
+	Short: "Capture a todo or a note",
+	Long: `Captures text quickly. If it names a day, it becomes a todo due that day
+and the date is dropped from its text; a time of day right after the date
+makes it due at that time and sets a reminder. Otherwise the text becomes a note titled with it. Dates are
+read as by todos create --due:
+
+  notes-cli add "call Bob on fri"          # todo due next Friday
+  notes-cli add "dentist jan 15 at 9:30"   # todo with a reminder
+  notes-cli add "ideas for the garden"     # note
+
+--todo and --note decide the kind explicitly.`,
+	Args: cobra.MinimumNArgs(1),
+	RunE: runAdd,
+}
+
+func init() {
+	addCmd.Flags().BoolP("todo", "t", false, "Create a todo even without a date")
+	addCmd.Flags().BoolP("note", "n", false, "Create a note, ignoring dates")
+	addCmd.MarkFlagsMutuallyExclusive("todo", "note")
+}
+
+func runAdd(cmd *cobra.Command, args []string) error {
+	text := strings.Join(args, " ")
+	asTodo, _ := cmd.Flags().GetBool("todo")
+	asNote, _ := cmd.Flags().GetBool("note")
+
+	var due, remind *time.Time
+	allDay := false
+	if !asNote {
+		if rest, w, ok := extractDue(text, time.Now()); ok && rest != "" {
+			text, due, allDay, asTodo = rest, &w.day, true, true
+			if w.hasTime {
+				at := w.at(time.Now())
+				due, allDay, remind = &at, false, &at
+			}
+		}
+	}
+
+	now := model.NowMillis()
+	if asTodo {
+		t := &model.Todo{
+			ID:               model.NewID(),
+			UserID:           userID(),
+			Content:          text,
+			DueDate:          due,
+			AllDay:           allDay,
+			ReminderAt:       remind,
+			ModifiedAt:       now,
+			ModifiedByDevice: cl.DeviceID(),
+			CreatedAt:        now,
+		}
+		if err := st.CreateTodo(t); err != nil {
+			return err
+		}
+		switch {
+		case remind != nil:
+			fmt.Printf("Created todo %s due %s, reminder set\n", t.ID, t.DueText())
+		case due != nil:
+			fmt.Printf("Created todo %s due %s\n", t.ID, t.DueText())
+		default:
+			fmt.Printf("Created todo %s\n", t.ID)
+		}
+	} else {
+		n := &model.Note{
+			ID:               model.NewID(),
+			UserID:           userID(),
+			Title:            text,
+			Type:             "note",
+			ModifiedAt:       now,
+			ModifiedByDevice: cl.DeviceID(),
+			CreatedAt:        now,
+		}
+		if err := st.CreateNote(n); err != nil {
+			return err
+		}
+		fmt.Printf("Created note %s\n", n.ID)
+	}
+	go syncQuietly()
+	return nil
+}
@@ -0,0 +1,120 @@
+package cmd
+
+import (
+	"fmt"
+	"io"
+	"os"
+	"path/filepath"
+
+	"github.com/spf13/cobra"
+)
+
+var notesAttachCmd = &cobra.Command{
+	Use:   "attach <note-id> <file>",
+	Short: "Upload a file as an attachment to a note",
+	Args:  cobra.ExactArgs(2),
+	RunE:  runNotesAttach,
+}
+
+var notesAttachmentsCmd = &cobra.Command{
+	Use:   "attachments <note-id>",
+	Short: "List the attachments of a note",
+	Args:  cobra.ExactArgs(1),
+	RunE:  runNotesAttachments,
+}
+
+var notesDownloadCmd = &cobra.Command{
+	Use:   "download <attachment-id>",
+	Short: "Download an attachment",
+	Long: `Download an attachment into the current directory under its original
+name. Use -o to choose another path, or -o - to write to stdout.`,
+	Args: cobra.ExactArgs(1),
+	RunE: runNotesDownload,
+}
+
+func init() {
+	notesCmd.AddCommand(notesAttachCmd, notesAttachmentsCmd, notesDownloadCmd)
+
+	notesDownloadCmd.Flags().StringP("output", "o", "", "Output file (- for stdout)")
+}
+
+func runNotesAttach(cmd *cobra.Command, args []string) error {
+	id, err := noteID(args[0])
+	if err != nil {
+		return err
+	}
+	path := args[1]
+
+	// Attachments live on the server only, so a note created offline has
+	// to reach it first.
+	if pending, err := st.IsPending("note", id); err != nil {
+		return err
+	} else if pending {
+		if _, err := sy.Sync(); err != nil {
+			return fmt.Errorf("note not yet on server: %w", err)
+		}
+	}
+
+	at, err := cl.UploadAttachment(id, path)
+	if err != nil {
+		return err
+	}
+	fmt.Printf("Attached %s (%s, %d bytes) as %s\n", at.Filename, at.ContentType, at.Size, at.ID)
+	return nil
+}
+
+func runNotesAttachments(cmd *cobra.Command, args []string) error {
+	id, err := noteID(args[0])
+	if err != nil {
+		return err
+	}
+	list, err := cl.ListAttachments(id)
+	if err != nil {
+		return err
+	}
+	if len(list) == 0 {
+		fmt.Println("No attachments.")
+		return nil
+	}
+	for _, at := range list {
+		fmt.Printf("%-38s  %10d  %s  %s\n",
+			at.ID, at.Size, at.CreatedAt.Local().Format("2006-01-02 15:04"), at.Filename)
+	}
+	return nil
+}
+
+func runNotesDownload(cmd *cobra.Command, args []string) error {
+	output, _ := cmd.Flags().GetString("output")
+
+	body, filename, err := cl.DownloadAttachment(args[0])
+	if err != nil {
+		return err
+	}
+	defer body.Close()
+
+	if output == "-" {
+		_, err := io.Copy(os.Stdout, body)
+		return err
+	}
+	if output == "" {
+		// Never trust a server-supplied name with a directory part.
+		output = filepath.Base(filename)
+		if output == "." || output == string(filepath.Separator) {
+			output = args[0]
+		}
+	}
+	f, err := os.OpenFile(output, os.O_WRONLY|os.O_CREATE|os.O_EXCL, 0644)
+	if err != nil {
+		return err
+	}
+	n, err := io.Copy(f, body)
+	if cerr := f.Close(); err == nil {
+		err = cerr
+	}
+	if err != nil {
+		os.Remove(output)
+		return err
+	}
+	fmt.Printf("Saved %s (%d bytes)\n", output, n)
+	return nil
+}
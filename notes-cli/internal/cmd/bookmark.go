@@ -0,0 +1,41 @@
+package cmd
+
+import (
+	"fmt"
+
+	"github.com/spf13/cobra"
+)
+
+var bookmarkCmd = &cobra.Command{
+	Use:   "bookmark <url>",
+	Short: "Save a web page as a bookmark note",
+	Long: `Saves the page at the URL as a bookmark note. The server fetches the page
+and keeps its title, description and icon in the note's front matter, so
+it needs to be reachable. With --archive it also keeps the page's readable
+text, to search and read later. A page that cannot be fetched is saved by
+its URL alone.`,
+	Args: cobra.ExactArgs(1),
+	RunE: runBookmark,
+}
+
+func init() {
+	bookmarkCmd.Flags().StringP("title", "t", "", "Title instead of the page's")
+	bookmarkCmd.Flags().StringArray("tag", nil, "Tag the bookmark (repeatable)")
+	bookmarkCmd.Flags().Bool("archive", false, "Keep the page's readable text")
+}
+
+func runBookmark(cmd *cobra.Command, args []string) error {
+	title, _ := cmd.Flags().GetString("title")
+	tags, _ := cmd.Flags().GetStringArray("tag")
+	archive, _ := cmd.Flags().GetBool("archive")
+
+	n, err := cl.CreateBookmark(args[0], title, tags, archive)
+	if err != nil {
+		return err
+	}
+	if _, err := st.UpsertNote(n); err != nil {
+		return err
+	}
+	fmt.Printf("Created bookmark %s: %s\n", n.ID, n.Title)
+	return nil
+}
@@ -0,0 +1,144 @@
+package cmd
+
+import (
+	"slices"
+	"strings"
+
+	"github.com/c0dev0id/notesd/notes-cli/internal/model"
+	"github.com/c0dev0id/notesd/notes-cli/internal/store"
+	"github.com/spf13/cobra"
+)
+
+// maxCompletions caps the items offered for one completion; the most
+// recently modified come first.
+const maxCompletions = 50
+
+// Shell completion comes from cobra's completion command. The functions
+// here complete note and todo IDs, with titles as descriptions, from the
+// local store, so they are quick and work offline.
+func init() {
+	for _, c := range []*cobra.Command{notesShowCmd, notesEditCmd, notesAppendCmd, notesBacklinksCmd, notesAttachmentsCmd} {
+		c.ValidArgsFunction = completeOne(completeNoteIDs)
+	}
+	notesDeleteCmd.ValidArgsFunction = completeNoteIDs
+	notesAttachCmd.ValidArgsFunction = completeNoteThenFile
+	notesExportCmd.ValidArgsFunction = completeNoteThenFile
+
+	for _, c := range []*cobra.Command{todosShowCmd, todosEditCmd} {
+		c.ValidArgsFunction = completeOne(completeTodoIDs)
+	}
+	todosCompleteCmd.ValidArgsFunction = completeTodoIDs
+	todosUncompleteCmd.ValidArgsFunction = completeDoneTodoIDs
+	todosDeleteCmd.ValidArgsFunction = completeTodoIDs
+
+	fsSyncCmd.ValidArgsFunction = func(cmd *cobra.Command, args []string, toComplete string) ([]cobra.Completion, cobra.ShellCompDirective) {
+		return nil, cobra.ShellCompDirectiveFilterDirs
+	}
+}
+
+// completeOne limits a completion to the first argument.
+func completeOne(f cobra.CompletionFunc) cobra.CompletionFunc {
+	return func(cmd *cobra.Command, args []string, toComplete string) ([]cobra.Completion, cobra.ShellCompDirective) {
+		if len(args) > 0 {
+			return nil, cobra.ShellCompDirectiveNoFileComp
+		}
+		return f(cmd, args, toComplete)
+	}
+}
+
+// completeNoteThenFile completes a note ID and then a file name.
+func completeNoteThenFile(cmd *cobra.Command, args []string, toComplete string) ([]cobra.Completion, cobra.ShellCompDirective) {
+	if len(args) == 0 {
+		return completeNoteIDs(cmd, args, toComplete)
+	}
+	return nil, cobra.ShellCompDirectiveDefault
+}
+
+// completeNoteIDs offers the local notes whose ID starts with toComplete,
+// leaving out those already given.
+func completeNoteIDs(cmd *cobra.Command, args []string, toComplete string) ([]cobra.Completion, cobra.ShellCompDirective) {
+	if st == nil {
+		return nil, cobra.ShellCompDirectiveNoFileComp
+	}
+	var notes []model.Note
+	var err error
+	if toComplete == "" {
+		notes, _, err = st.ListNotes(userID(), store.NoteFilter{}, maxCompletions, 0)
+	} else {
+		notes, err = st.MatchNotes(userID(), toComplete, maxCompletions)
+	}
+	if err != nil {
+		return nil, cobra.ShellCompDirectiveError
+	}
+	var out []cobra.Completion
+	for _, n := range notes {
+		if strings.HasPrefix(n.ID, toComplete) && !slices.Contains(args, n.ID) {
+			out = append(out, cobra.CompletionWithDesc(n.ID, n.Title))
+		}
+	}
+	return out, cobra.ShellCompDirectiveNoFileComp
+}
+
+// completeTodoIDs offers the open local todos whose ID starts with
+// toComplete, leaving out those already given; completeDoneTodoIDs offers
+// the completed ones.
+var (
+	completeTodoIDs     = completeTodos(false)
+	completeDoneTodoIDs = completeTodos(true)
+)
+
+func completeTodos(done bool) cobra.CompletionFunc {
+	return func(cmd *cobra.Command, args []string, toComplete string) ([]cobra.Completion, cobra.ShellCompDirective) {
+		if st == nil {
+			return nil, cobra.ShellCompDirectiveNoFileComp
+		}
+		var todos []model.Todo
+		var err error
+		if toComplete == "" {
+			todos, _, err = st.ListTodos(userID(), store.TodoFilter{Completed: &done}, maxCompletions, 0)
+		} else {
+			todos, err = st.MatchTodos(userID(), toComplete, maxCompletions)
+		}
+		if err != nil {
+			return nil, cobra.ShellCompDirectiveError
+		}
+		var out []cobra.Completion
+		for _, t := range todos {
+			if t.Completed == done && strings.HasPrefix(t.ID, toComplete) && !slices.Contains(args, t.ID) {
+				out = append(out, cobra.CompletionWithDesc(t.ID, t.Content))
+			}
+		}
+		return out, cobra.ShellCompDirectiveNoFileComp
+	}
+}
+
+// completeNoteTypes completes the note types cached by the last sync.
+func completeNoteTypes(cmd *cobra.Command, args []string, toComplete string) ([]cobra.Completion, cobra.ShellCompDirective) {
+	if st == nil {
+		return nil, cobra.ShellCompDirectiveNoFileComp
+	}
+	var out []cobra.Completion
+	for _, t := range noteTypes(st) {
+		if strings.HasPrefix(t, toComplete) {
+			out = append(out, t)
+		}
+	}
+	return out, cobra.ShellCompDirectiveNoFileComp
+}
+
+func completeTags(cmd *cobra.Command, args []string, toComplete string) ([]cobra.Completion, cobra.ShellCompDirective) {
+	if st == nil {
+		return nil, cobra.ShellCompDirectiveNoFileComp
+	}
+	tags, err := st.ListTags(userID())
+	if err != nil {
+		return nil, cobra.ShellCompDirectiveError
+	}
+	var out []cobra.Completion
+	for _, t := range tags {
+		if strings.HasPrefix(t.Name, toComplete) {
+			out = append(out, t.Name)
+		}
+	}
+	return out, cobra.ShellCompDirectiveNoFileComp
+}
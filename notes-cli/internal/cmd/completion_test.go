@@ -0,0 +1,53 @@
+package cmd
+
+import (
+	"path/filepath"
+	"slices"
+	"testing"
+
+	"github.com/c0dev0id/notesd/notes-cli/internal/model"
+	"github.com/c0dev0id/notesd/notes-cli/internal/store"
+	"github.com/spf13/cobra"
+)
+
+func TestCompleteTodoIDs(t *testing.T) {
+	// Arrange: a store for the logged-out user "" with two open todos and a
+	// completed one
+	s, err := store.Open(filepath.Join(t.TempDir(), "cache.db"))
+	if err != nil {
+		t.Fatalf("open store: %v", err)
+	}
+	t.Cleanup(func() { s.Close(); st = nil })
+	st = s
+	now := model.NowMillis()
+	for _, td := range []struct {
+		id, content string
+		done        bool
+	}{{"aa11", "Buy milk", false}, {"aa22", "Call Bob", false}, {"bb33", "Pay rent", true}} {
+		if err := s.CreateTodo(&model.Todo{ID: td.id, Content: td.content, Completed: td.done,
+			ModifiedAt: now, CreatedAt: now}); err != nil {
+			t.Fatalf("CreateTodo: %v", err)
+		}
+	}
+	cases := []struct {
+		args       []string
+		toComplete string
+		want       []string
+	}{
+		{nil, "", []string{"aa11\tBuy milk", "aa22\tCall Bob"}},
+		{nil, "aa2", []string{"aa22\tCall Bob"}},
+		{[]string{"aa11"}, "aa", []string{"aa22\tCall Bob"}},
+		{nil, "bb", nil},
+	}
+	for _, c := range cases {
+		// Act
+		got, dir := completeTodoIDs(todosCompleteCmd, c.args, c.toComplete)
+
+		// Assert
+		t.Logf("args=%v %q -> %q", c.args, c.toComplete, got)
+		slices.Sort(got)
+		if !slices.Equal(got, c.want) || dir != cobra.ShellCompDirectiveNoFileComp {
+			t.Errorf("args=%v %q: got %q (%v), want %q", c.args, c.toComplete, got, dir, c.want)
+		}
+	}
+}
@@ -0,0 +1,358 @@
+package cmd
+
+import (
+	"fmt"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// weekdays maps the names of the days, full and abbreviated, to their
+// weekday.
+var weekdays = map[string]time.Weekday{
+	"sunday": time.Sunday, "sun": time.Sunday,
+	"monday": time.Monday, "mon": time.Monday,
+	"tuesday": time.Tuesday, "tue": time.Tuesday, "tues": time.Tuesday,
+	"wednesday": time.Wednesday, "wed": time.Wednesday,
+	"thursday": time.Thursday, "thu": time.Thursday, "thur": time.Thursday, "thurs": time.Thursday,
+	"friday": time.Friday, "fri": time.Friday,
+	"saturday": time.Saturday, "sat": time.Saturday,
+}
+
+// wordlike are the weekday abbreviations that are also English words. In
+// captured text they are only taken as dates where a date is expected.
+var wordlike = map[string]bool{"sun": true, "mon": true, "wed": true, "sat": true}
+
+var months = map[string]time.Month{
+	"january": time.January, "jan": time.January,
+	"february": time.February, "feb": time.February,
+	"march": time.March, "mar": time.March,
+	"april": time.April, "apr": time.April,
+	"may":  time.May,
+	"june": time.June, "jun": time.June,
+	"july": time.July, "jul": time.July,
+	"august": time.August, "aug": time.August,
+	"september": time.September, "sep": time.September, "sept": time.September,
+	"october": time.October, "oct": time.October,
+	"november": time.November, "nov": time.November,
+	"december": time.December, "dec": time.December,
+}
+
+// dateConnectors are words that introduce a date in captured text, as in
+// "call Bob on fri"; they are dropped along with it.
+var dateConnectors = map[string]bool{"on": true, "by": true, "due": true}
+
+// defaultReminderHour is the time of day of a reminder given only a date.
+const defaultReminderHour = 9
+
+// when is a date, a time of day or both, as read by parseWhen. Day is the
+// calendar date at midnight UTC, the way due dates are stored.
+type when struct {
+	day          time.Time
+	hour, minute int
+	hasDate      bool
+	hasTime      bool
+}
+
+// parseWhen reads s relative to now, in now's time zone. It understands
+//
+//	dates  today, tomorrow, [next] friday, next week|month,
+//	       in 3 days|weeks|months, 2026-01-15, jan 15 [2027], 15 jan
+//	times  [at] 5pm, 5:30pm, 17:30, noon, midnight
+//	both   in 2 hours|minutes, and a date followed or preceded by a time
+//
+// A weekday is the next one after today, and a month and day without a
+// year the next such date from today on.
+func parseWhen(s string, now time.Time) (when, error) {
+	var w when
+	words := strings.Fields(strings.ToLower(strings.NewReplacer(",", " ").Replace(s)))
+	if len(words) == 0 {
+		return w, fmt.Errorf("no date given")
+	}
+	if len(words) == 3 && words[0] == "in" {
+		if n, ok := count(words[1]); ok {
+			var d time.Duration
+			switch strings.TrimSuffix(words[2], "s") {
+			case "hour", "hr":
+				d = time.Duration(n) * time.Hour
+			case "minute", "min":
+				d = time.Duration(n) * time.Minute
+			}
+			if d != 0 {
+				t := now.Add(d)
+				y, m, dd := t.Date()
+				return when{day: time.Date(y, m, dd, 0, 0, 0, 0, time.UTC), hour: t.Hour(), minute: t.Minute(),
+					hasDate: true, hasTime: true}, nil
+			}
+		}
+	}
+	for i := 0; i < len(words); {
+		if day, n, _ := readDate(words, i, now); n > 0 && !w.hasDate {
+			w.day, w.hasDate = day, true
+			i += n
+			continue
+		}
+		if h, m, n := readTime(words, i); n > 0 && !w.hasTime {
+			w.hour, w.minute, w.hasTime = h, m, true
+			i += n
+			continue
+		}
+		return w, fmt.Errorf("cannot read %q as a date or time", s)
+	}
+	return w, nil
+}
+
+// parseDue reads a due date with parseWhen. A date alone is all-day, at
+// midnight UTC; with a time of day it is that moment in now's time zone,
+// and a time alone means its next occurrence.
+func parseDue(s string, now time.Time) (due time.Time, allDay bool, err error) {
+	w, err := parseWhen(s, now)
+	if err != nil {
+		return time.Time{}, false, fmt.Errorf(`%w (try "tomorrow", "fri 14:00", "jan 15" or YYYY-MM-DD)`, err)
+	}
+	if !w.hasTime {
+		return w.day, true, nil
+	}
+	return w.at(now), false, nil
+}
+
+// dueDay returns the local date of a parsed due date, at midnight UTC.
+func dueDay(due time.Time, allDay bool) time.Time {
+	if allDay {
+		return due
+	}
+	y, m, d := due.Date()
+	return time.Date(y, m, d, 0, 0, 0, 0, time.UTC)
+}
+
+// parseReminder reads a reminder time with parseWhen, in now's time zone.
+// A date alone means 9:00 that day; a time alone means its next
+// occurrence.
+func parseReminder(s string, now time.Time) (time.Time, error) {
+	w, err := parseWhen(s, now)
+	if err != nil {
+		return time.Time{}, fmt.Errorf(`%w (try "tomorrow 9am", "fri 17:30" or "in 2 hours")`, err)
+	}
+	return w.at(now), nil
+}
+
+// at returns the moment w names in now's time zone; see parseReminder.
+func (w when) at(now time.Time) time.Time {
+	if !w.hasTime {
+		w.hour, w.minute = defaultReminderHour, 0
+	}
+	day := w.day
+	if !w.hasDate {
+		y, m, d := now.Date()
+		day = time.Date(y, m, d, 0, 0, 0, 0, time.UTC)
+	}
+	t := time.Date(day.Year(), day.Month(), day.Day(), w.hour, w.minute, 0, 0, now.Location())
+	if !w.hasDate && t.Before(now) {
+		t = t.AddDate(0, 0, 1)
+	}
+	return t
+}
+
+// readDate reads a date from words[i:] and returns it with the number of
+// words used, 0 if there is none. Ambiguous reports a lone weekday
+// abbreviation that may just be a word.
+func readDate(words []string, i int, now time.Time) (day time.Time, n int, ambiguous bool) {
+	y, m, d := now.Date()
+	today := time.Date(y, m, d, 0, 0, 0, 0, time.UTC)
+	word := func(j int) string {
+		if i+j < len(words) {
+			return strings.ToLower(strings.TrimRight(words[i+j], ",.;!"))
+		}
+		return ""
+	}
+	nextWeekday := func(wd time.Weekday) time.Time {
+		return today.AddDate(0, 0, (int(wd)-int(today.Weekday())+6)%7+1)
+	}
+
+	switch w := word(0); {
+	case w == "today":
+		return today, 1, false
+	case w == "tomorrow" || w == "tmrw":
+		return today.AddDate(0, 0, 1), 1, false
+	case w == "next":
+		if wd, ok := weekdays[word(1)]; ok {
+			return nextWeekday(wd), 2, false
+		}
+		switch word(1) {
+		case "week":
+			return today.AddDate(0, 0, 7), 2, false
+		case "month":
+			return today.AddDate(0, 1, 0), 2, false
+		}
+	case w == "in":
+		if k, ok := count(word(1)); ok {
+			switch strings.TrimSuffix(word(2), "s") {
+			case "day":
+				return today.AddDate(0, 0, k), 3, false
+			case "week":
+				return today.AddDate(0, 0, 7*k), 3, false
+			case "month":
+				return today.AddDate(0, k, 0), 3, false
+			}
+		}
+	}
+	if wd, ok := weekdays[word(0)]; ok {
+		return nextWeekday(wd), 1, wordlike[word(0)]
+	}
+	if t, err := time.Parse(time.DateOnly, word(0)); err == nil {
+		return t, 1, false
+	}
+
+	// jan 15 [2027] or 15 jan [2027]
+	month, mok := months[word(0)]
+	dom, dok := dayOfMonth(word(1))
+	if !mok || !dok {
+		month, mok = months[word(1)]
+		dom, dok = dayOfMonth(word(0))
+	}
+	if !mok || !dok {
+		return time.Time{}, 0, false
+	}
+	n = 2
+	year := today.Year()
+	if yy, err := strconv.Atoi(word(2)); err == nil && len(word(2)) == 4 {
+		year, n = yy, 3
+	}
+	day = time.Date(year, month, dom, 0, 0, 0, 0, time.UTC)
+	if day.Day() != dom {
+		return time.Time{}, 0, false
+	}
+	if n == 2 && day.Before(today) {
+		day = day.AddDate(1, 0, 0)
+	}
+	return day, n, false
+}
+
+// readTime reads a time of day from words[i:], optionally after "at", and
+// returns it with the number of words used, 0 if there is none.
+func readTime(words []string, i int) (hour, minute, n int) {
+	if i < len(words) && strings.EqualFold(words[i], "at") {
+		if h, m, k := readTime(words, i+1); k > 0 {
+			return h, m, k + 1
+		}
+		return 0, 0, 0
+	}
+	if i >= len(words) {
+		return 0, 0, 0
+	}
+	w, n := strings.ToLower(words[i]), 1
+	switch w {
+	case "noon":
+		return 12, 0, 1
+	case "midnight":
+		return 0, 0, 1
+	}
+	suffix := ""
+	for _, s := range []string{"am", "pm"} {
+		if strings.HasSuffix(w, s) {
+			w, suffix = strings.TrimSuffix(w, s), s
+		} else if i+1 < len(words) && strings.ToLower(words[i+1]) == s {
+			suffix, n = s, 2
+		}
+	}
+	hs, ms, colon := strings.Cut(w, ":")
+	if !colon && suffix == "" {
+		return 0, 0, 0
+	}
+	hour, err := strconv.Atoi(hs)
+	if err != nil || len(hs) > 2 {
+		return 0, 0, 0
+	}
+	if colon {
+		if minute, err = strconv.Atoi(ms); err != nil || len(ms) != 2 || minute > 59 {
+			return 0, 0, 0
+		}
+	}
+	switch {
+	case suffix != "" && (hour < 1 || hour > 12):
+		return 0, 0, 0
+	case suffix == "am" && hour == 12:
+		hour = 0
+	case suffix == "pm" && hour != 12:
+		hour += 12
+	case hour > 23:
+		return 0, 0, 0
+	}
+	return hour, minute, n
+}
+
+// parseShift reads a duration in days, weeks or months: 3d, 2w, 1m,
+// "3 days", "a week", "2 months".
+func parseShift(s string) (days, months int, ok bool) {
+	s = strings.ToLower(strings.TrimSpace(s))
+	i := strings.IndexFunc(s, func(r rune) bool { return r < '0' || r > '9' })
+	num, unit := s, ""
+	if i >= 0 {
+		num, unit = s[:i], strings.TrimSpace(s[i:])
+	}
+	if num == "" {
+		num, unit, _ = strings.Cut(s, " ")
+	}
+	n, ok := count(num)
+	if !ok {
+		return 0, 0, false
+	}
+	switch strings.TrimSuffix(unit, "s") {
+	case "d", "day":
+		return n, 0, true
+	case "w", "week":
+		return 7 * n, 0, true
+	case "m", "month":
+		return 0, n, true
+	}
+	return 0, 0, false
+}
+
+// count reads a small positive number: digits, "a" or "an".
+func count(s string) (int, bool) {
+	if s == "a" || s == "an" {
+		return 1, true
+	}
+	n, err := strconv.Atoi(s)
+	return n, err == nil && n > 0
+}
+
+// dayOfMonth reads 15 or 15th.
+func dayOfMonth(s string) (int, bool) {
+	for _, suffix := range []string{"st", "nd", "rd", "th"} {
+		s = strings.TrimSuffix(s, suffix)
+	}
+	d, err := strconv.Atoi(s)
+	return d, err == nil && d >= 1 && d <= 31
+}
+
+// extractDue finds a date in captured text, with a time of day right
+// after it, and returns the text without them and any connector before
+// the date. A lone weekday abbreviation that is also a word, such as
+// "sat", counts only at the end of the text or after a connector.
+func extractDue(text string, now time.Time) (rest string, due when, ok bool) {
+	words := strings.Fields(text)
+	for i := range words {
+		day, n, ambiguous := readDate(words, i, now)
+		if n == 0 {
+			continue
+		}
+		connector := i > 0 && dateConnectors[strings.ToLower(words[i-1])]
+		end := i + n
+		if ambiguous && !connector && end != len(words) {
+			continue
+		}
+		due = when{day: day, hasDate: true}
+		if h, m, k := readTime(words, end); k > 0 {
+			due.hour, due.minute, due.hasTime = h, m, true
+			end += k
+		}
+		start := i
+		if connector {
+			start--
+		}
+		kept := append(words[:start:start], words[end:]...)
+		return strings.Join(kept, " "), due, true
+	}
+	return text, when{}, false
+}
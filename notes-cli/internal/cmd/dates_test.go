@@ -0,0 +1,150 @@
+package cmd
+
+import (
+	"testing"
+	"time"
+)
+
+// testNow is a Wednesday afternoon in Berlin.
+var testNow = time.Date(2026, 3, 4, 15, 0, 0, 0, time.FixedZone("CET", 3600))
+
+func TestExtractDue(t *testing.T) {
+	cases := []struct {
+		text, rest, due string
+	}{
+		{"call Bob tomorrow", "call Bob", "2026-03-05"},
+		{"call Bob on fri", "call Bob", "2026-03-06"},
+		{"pay rent by 2026-04-01", "pay rent", "2026-04-01"},
+		{"review today, then ship", "review then ship", "2026-03-04"},
+		{"standup wednesday", "standup", "2026-03-11"},
+		{"water plants Sun", "water plants", "2026-03-08"},
+		{"call mom next monday", "call mom", "2026-03-09"},
+		{"renew passport in 2 weeks", "renew passport", "2026-03-18"},
+		{"dentist jan 15 at 9:30", "dentist", "2027-01-15 09:30"},
+		{"sat down with the sun out", "sat down with the sun out", ""},
+		{"ideas for the garden", "ideas for the garden", ""},
+	}
+	for _, c := range cases {
+		// Act
+		rest, due, ok := extractDue(c.text, testNow)
+
+		// Assert
+		got := ""
+		if ok {
+			got = due.day.Format(time.DateOnly)
+		}
+		if due.hasTime {
+			got = due.at(testNow).Format("2006-01-02 15:04")
+		}
+		t.Logf("%q -> %q due %q", c.text, rest, got)
+		if rest != c.rest || got != c.due {
+			t.Errorf("extractDue(%q) = %q, %q; want %q, %q", c.text, rest, got, c.rest, c.due)
+		}
+	}
+}
+
+func TestParseDue(t *testing.T) {
+	cases := []struct {
+		input, want string
+	}{
+		{"today", "2026-03-04"},
+		{"Tomorrow", "2026-03-05"},
+		{"fri", "2026-03-06"},
+		{"wed", "2026-03-11"},
+		{"next week", "2026-03-11"},
+		{"in 3 days", "2026-03-07"},
+		{"in a month", "2026-04-04"},
+		{"jan 15", "2027-01-15"},
+		{"15th march", "2026-03-15"},
+		{"Dec 24, 2026", "2026-12-24"},
+		{"2026-02-29", ""},
+		{"feb 30", ""},
+		{"tomorrow 5pm", "2026-03-05 17:00"},
+		{"fri 14:00", "2026-03-06 14:00"},
+		{"9am", "2026-03-05 09:00"},
+		{"someday", ""},
+	}
+	for _, c := range cases {
+		// Act
+		got, allDay, err := parseDue(c.input, testNow)
+
+		// Assert
+		s := ""
+		switch {
+		case err != nil:
+		case allDay:
+			s = got.Format(time.DateOnly)
+		default:
+			s = got.In(testNow.Location()).Format("2006-01-02 15:04")
+		}
+		t.Logf("%q -> %q all-day=%v (err=%v)", c.input, s, allDay, err)
+		if s != c.want {
+			t.Errorf("parseDue(%q) = %q, want %q (err=%v)", c.input, s, c.want, err)
+		}
+		if allDay && got.Location() != time.UTC {
+			t.Errorf("parseDue(%q) is not at midnight UTC: %v", c.input, got)
+		}
+	}
+}
+
+func TestParseReminder(t *testing.T) {
+	cases := []struct {
+		input, want string
+	}{
+		{"2026-03-15 09:30", "2026-03-15 09:30 +0100"},
+		{"tomorrow 5pm", "2026-03-05 17:00 +0100"},
+		{"jan 15 5:30 pm", "2027-01-15 17:30 +0100"},
+		{"at noon fri", "2026-03-06 12:00 +0100"},
+		{"fri", "2026-03-06 09:00 +0100"},
+		{"16:00", "2026-03-04 16:00 +0100"},
+		{"9am", "2026-03-05 09:00 +0100"},
+		{"12am tomorrow", "2026-03-05 00:00 +0100"},
+		{"in 2 hours", "2026-03-04 17:00 +0100"},
+		{"in 90 minutes", "2026-03-04 16:30 +0100"},
+		{"13pm", ""},
+		{"25:00", ""},
+		{"tomorrow tomorrow", ""},
+	}
+	for _, c := range cases {
+		// Act
+		got, err := parseReminder(c.input, testNow)
+
+		// Assert
+		s := ""
+		if err == nil {
+			s = got.Format("2006-01-02 15:04 -0700")
+		}
+		t.Logf("%q -> %q (err=%v)", c.input, s, err)
+		if s != c.want {
+			t.Errorf("parseReminder(%q) = %q, want %q (err=%v)", c.input, s, c.want, err)
+		}
+	}
+}
+
+func TestParseShift(t *testing.T) {
+	cases := []struct {
+		input        string
+		days, months int
+		ok           bool
+	}{
+		{"3d", 3, 0, true},
+		{"2w", 14, 0, true},
+		{"1m", 0, 1, true},
+		{"3 days", 3, 0, true},
+		{"a week", 7, 0, true},
+		{"2 Months", 0, 2, true},
+		{"fri", 0, 0, false},
+		{"3", 0, 0, false},
+		{"0d", 0, 0, false},
+	}
+	for _, c := range cases {
+		// Act
+		days, months, ok := parseShift(c.input)
+
+		// Assert
+		t.Logf("%q -> %d days, %d months, ok=%v", c.input, days, months, ok)
+		if days != c.days || months != c.months || ok != c.ok {
+			t.Errorf("parseShift(%q) = %d, %d, %v; want %d, %d, %v", c.input, days, months, ok, c.days, c.months, c.ok)
+		}
+	}
+}
@@ -0,0 +1,95 @@
+package cmd
+
+import (
+	"archive/tar"
+	"compress/gzip"
+	"fmt"
+	"io"
+	"os"
+	"path/filepath"
+	"strings"
+
+	"github.com/spf13/cobra"
+)
+
+var exportCmd = &cobra.Command{
+	Use:   "export <dir>",
+	Short: "Export all notes, todos and attachments into a directory",
+	Long: `Download an archive of everything on the server and unpack it into dir:
+notes as Markdown files with front matter under notes/, todos in
+todos.json, attachments under attachments/ and an index in manifest.json.
+Existing files are never overwritten.`,
+	Args: cobra.ExactArgs(1),
+	RunE: runExport,
+}
+
+func runExport(cmd *cobra.Command, args []string) error {
+	body, err := cl.Export()
+	if err != nil {
+		return err
+	}
+	defer body.Close()
+
+	counts, err := unpackExport(body, args[0])
+	if err != nil {
+		return err
+	}
+	fmt.Printf("Exported %d notes, %d attachments and todos.json to %s\n",
+		counts["notes"], counts["attachments"], args[0])
+	return nil
+}
+
+// unpackExport extracts a tar.gz export into dir as it is read. Only regular
+// files with local paths are written. It returns the number of files per
+// top-level directory.
+func unpackExport(r io.Reader, dir string) (map[string]int, error) {
+	gz, err := gzip.NewReader(r)
+	if err != nil {
+		return nil, fmt.Errorf("read export: %w", err)
+	}
+	defer gz.Close()
+
+	counts := map[string]int{}
+	tr := tar.NewReader(gz)
+	for {
+		hdr, err := tr.Next()
+		if err == io.EOF {
+			return counts, nil
+		}
+		if err != nil {
+			return nil, fmt.Errorf("read export: %w", err)
+		}
+		if hdr.Typeflag != tar.TypeReg {
+			continue
+		}
+		if !filepath.IsLocal(hdr.Name) {
+			return nil, fmt.Errorf("export contains unsafe path %q", hdr.Name)
+		}
+		path := filepath.Join(dir, filepath.FromSlash(hdr.Name))
+		if err := writeExportFile(path, tr); err != nil {
+			return nil, err
+		}
+		os.Chtimes(path, hdr.ModTime, hdr.ModTime)
+		if top, _, ok := strings.Cut(hdr.Name, "/"); ok {
+			counts[top]++
+		}
+	}
+}
+
+func writeExportFile(path string, r io.Reader) error {
+	if err := os.MkdirAll(filepath.Dir(path), 0755); err != nil {
+		return err
+	}
+	f, err := os.OpenFile(path, os.O_WRONLY|os.O_CREATE|os.O_EXCL, 0644)
+	if err != nil {
+		return err
+	}
+	_, err = io.Copy(f, r)
+	if cerr := f.Close(); err == nil {
+		err = cerr
+	}
+	if err != nil {
+		os.Remove(path)
+	}
+	return err
+}
@@ -0,0 +1,101 @@
+package cmd
+
+import (
+	"archive/tar"
+	"bytes"
+	"compress/gzip"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+)
+
+func tarGz(t *testing.T, files map[string]string) []byte {
+	t.Helper()
+	var buf bytes.Buffer
+	gz := gzip.NewWriter(&buf)
+	tw := tar.NewWriter(gz)
+	for name, content := range files {
+		hdr := &tar.Header{Name: name, Mode: 0644, Size: int64(len(content)),
+			ModTime: time.Date(2026, 3, 1, 12, 0, 0, 0, time.UTC), Typeflag: tar.TypeReg}
+		if err := tw.WriteHeader(hdr); err != nil {
+			t.Fatal(err)
+		}
+		tw.Write([]byte(content))
+	}
+	tw.Close()
+	gz.Close()
+	return buf.Bytes()
+}
+
+func TestUnpackExport(t *testing.T) {
+	// Arrange
+	dir := t.TempDir()
+	archive := tarGz(t, map[string]string{
+		"manifest.json":           `{"format":"notesd-export"}`,
+		"notes/Plan-0123abcd.md":  "---\ntitle: \"Plan\"\n---\nbody",
+		"notes/Other-4567ef01.md": "x",
+		"todos.json":              "[]",
+		"attachments/a1/pic.png":  "png",
+	})
+
+	// Act
+	counts, err := unpackExport(bytes.NewReader(archive), dir)
+
+	// Assert
+	if err != nil {
+		t.Fatalf("unpack: %v", err)
+	}
+	t.Logf("counts: %v", counts)
+	if counts["notes"] != 2 || counts["attachments"] != 1 {
+		t.Errorf("counts = %v, want 2 notes and 1 attachment", counts)
+	}
+	data, err := os.ReadFile(filepath.Join(dir, "notes", "Plan-0123abcd.md"))
+	if err != nil || string(data) != "---\ntitle: \"Plan\"\n---\nbody" {
+		t.Errorf("note file = %q, %v", data, err)
+	}
+	fi, err := os.Stat(filepath.Join(dir, "attachments", "a1", "pic.png"))
+	if err != nil {
+		t.Fatalf("attachment: %v", err)
+	}
+	t.Logf("attachment mod time: %v", fi.ModTime().UTC())
+	if !fi.ModTime().Equal(time.Date(2026, 3, 1, 12, 0, 0, 0, time.UTC)) {
+		t.Errorf("mod time = %v, want archive time", fi.ModTime())
+	}
+}
+
+func TestUnpackExportRejectsUnsafePaths(t *testing.T) {
+	for _, name := range []string{"../evil.md", "/etc/evil", "notes/../../evil"} {
+		// Arrange
+		dir := t.TempDir()
+		archive := tarGz(t, map[string]string{name: "x"})
+
+		// Act
+		_, err := unpackExport(bytes.NewReader(archive), dir)
+
+		// Assert
+		t.Logf("%s: %v", name, err)
+		if err == nil {
+			t.Errorf("%s: unpacked, want error", name)
+		}
+	}
+}
+
+func TestUnpackExportKeepsExistingFiles(t *testing.T) {
+	// Arrange
+	dir := t.TempDir()
+	os.WriteFile(filepath.Join(dir, "todos.json"), []byte("mine"), 0644)
+	archive := tarGz(t, map[string]string{"todos.json": "[]"})
+
+	// Act
+	_, err := unpackExport(bytes.NewReader(archive), dir)
+
+	// Assert
+	t.Logf("err: %v", err)
+	if err == nil {
+		t.Error("overwrote an existing file")
+	}
+	if data, _ := os.ReadFile(filepath.Join(dir, "todos.json")); string(data) != "mine" {
+		t.Errorf("todos.json = %q, want untouched", data)
+	}
+}
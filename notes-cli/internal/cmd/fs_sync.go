@@ -0,0 +1,467 @@
+package cmd
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"io/fs"
+	"os"
+	"os/signal"
+	"path/filepath"
+	"slices"
+	"strings"
+	"syscall"
+	"time"
+	"unicode"
+
+	"github.com/c0dev0id/notesd/notes-cli/internal/delta"
+	"github.com/c0dev0id/notesd/notes-cli/internal/model"
+	"github.com/c0dev0id/notesd/notes-cli/internal/store"
+	"github.com/c0dev0id/notesd/notes-cli/internal/sync"
+	"github.com/fsnotify/fsnotify"
+	"github.com/spf13/cobra"
+)
+
+var fsSyncCmd = &cobra.Command{
+	Use:   "fs-sync <dir>",
+	Short: "Mirror notes to a directory of Markdown files",
+	Long: `Writes every note into dir as a Markdown file with front matter, as
+notes export does, then watches the directory and keeps both sides in step
+until interrupted: edits to the files are saved to the notes and synced,
+new files become notes, deleted files delete their notes, and changes
+arriving from the server are written to the files. This allows notes to be
+edited with any editor, or kept as an Obsidian vault.
+
+When a file and its note both changed since they were last in step, the
+note wins and the file's version is kept beside it as
+<name>.conflict-<time>.md. Conflict files and hidden files are ignored.
+The server is asked for changes every --interval; with --once the
+directory is brought up to date a single time.`,
+	Args: cobra.ExactArgs(1),
+	RunE: runFsSync,
+}
+
+func init() {
+	fsSyncCmd.Flags().Bool("once", false, "Bring the directory up to date and exit")
+	fsSyncCmd.Flags().Duration("interval", 30*time.Second, "How often to check the server for changes")
+}
+
+// fsSyncState is the file, kept in the mirrored directory, recording what
+// each note and its file looked like when they were last in step.
+const fsSyncState = ".notesd-fs-sync.json"
+
+// fsSyncDebounce is how long the directory must be quiet after a change
+// before it is read; editors often write a file in several steps.
+const fsSyncDebounce = 500 * time.Millisecond
+
+func runFsSync(cmd *cobra.Command, args []string) error {
+	once, _ := cmd.Flags().GetBool("once")
+	interval, _ := cmd.Flags().GetDuration("interval")
+	if interval <= 0 {
+		return fmt.Errorf("--interval must be positive")
+	}
+	if err := os.MkdirAll(args[0], 0755); err != nil {
+		return err
+	}
+	m, err := openMirror(args[0], st, userID(), cl.DeviceID(), os.Stdout)
+	if err != nil {
+		return err
+	}
+	offline := false
+	cycle := func() error {
+		if err := m.readFiles(); err != nil {
+			return err
+		}
+		res, err := sy.Sync()
+		switch {
+		case sync.IsOffline(err):
+			if !offline {
+				fmt.Fprintf(os.Stderr, "offline: changes stay queued until the server is reachable\n")
+			}
+			offline, res = true, nil
+		case err != nil:
+			fmt.Fprintf(os.Stderr, "sync: %v\n", err)
+			res = nil
+		default:
+			offline = false
+		}
+		var lost []string
+		if res != nil {
+			for _, c := range res.Conflicts {
+				if c.Type == "note" {
+					lost = append(lost, c.ID)
+				}
+			}
+		}
+		return m.writeFiles(lost)
+	}
+	if err := cycle(); err != nil || once {
+		return err
+	}
+
+	w, err := fsnotify.NewWatcher()
+	if err != nil {
+		return err
+	}
+	defer w.Close()
+	if err := w.Add(m.dir); err != nil {
+		return err
+	}
+	ctx, stop := signal.NotifyContext(context.Background(), os.Interrupt, syscall.SIGTERM)
+	defer stop()
+	fmt.Fprintf(os.Stderr, "Watching %s, press Ctrl-C to stop\n", m.dir)
+
+	tick := time.NewTicker(interval)
+	defer tick.Stop()
+	debounce := time.NewTimer(0)
+	<-debounce.C
+	for {
+		select {
+		case <-ctx.Done():
+			return nil
+		case ev := <-w.Events:
+			if mirroredFile(filepath.Base(ev.Name)) {
+				debounce.Reset(fsSyncDebounce)
+			}
+			continue
+		case err := <-w.Errors:
+			return err
+		case <-debounce.C:
+		case <-tick.C:
+		}
+		if err := cycle(); err != nil {
+			return err
+		}
+	}
+}
+
+// mirror keeps a directory of Markdown files and the notes in the local
+// store in step. It only touches the store; pushing and pulling is left to
+// the caller.
+type mirror struct {
+	dir      string
+	st       *store.Store
+	userID   string
+	deviceID string
+	out      io.Writer
+	files    map[string]mirroredNote // by note ID
+}
+
+// mirroredNote is a note's file and what both looked like when last in
+// step: a hash of the file and the note's modified time in milliseconds.
+// Edited is set while the note's latest change is one read from the file.
+type mirroredNote struct {
+	Name     string `json:"name"`
+	Hash     string `json:"hash"`
+	Modified int64  `json:"modified"`
+	Edited   bool   `json:"edited,omitempty"`
+}
+
+func openMirror(dir string, s *store.Store, userID, deviceID string, out io.Writer) (*mirror, error) {
+	m := &mirror{dir: dir, st: s, userID: userID, deviceID: deviceID, out: out, files: map[string]mirroredNote{}}
+	data, err := os.ReadFile(filepath.Join(dir, fsSyncState))
+	if errors.Is(err, fs.ErrNotExist) {
+		return m, nil
+	}
+	if err != nil {
+		return nil, err
+	}
+	if err := json.Unmarshal(data, &m.files); err != nil {
+		return nil, fmt.Errorf("read %s: %w", fsSyncState, err)
+	}
+	return m, nil
+}
+
+// mirroredFile reports whether a file name in the directory holds a note.
+func mirroredFile(name string) bool {
+	return strings.HasSuffix(name, ".md") && !strings.HasPrefix(name, ".") && !strings.Contains(name, ".conflict-")
+}
+
+// readFiles saves the files changed since they were last in step to their
+// notes, creates notes for new files and deletes those whose file is gone.
+func (m *mirror) readFiles() error {
+	entries, err := os.ReadDir(m.dir)
+	if err != nil {
+		return err
+	}
+	byName := make(map[string]string, len(m.files))
+	for id, f := range m.files {
+		byName[f.Name] = id
+	}
+	present := map[string]bool{}
+	for _, e := range entries {
+		if !e.Type().IsRegular() || !mirroredFile(e.Name()) {
+			continue
+		}
+		present[e.Name()] = true
+	}
+	for _, e := range entries {
+		if !present[e.Name()] {
+			continue
+		}
+		if err := m.readFile(e.Name(), byName, present); err != nil {
+			return fmt.Errorf("%s: %w", e.Name(), err)
+		}
+	}
+
+	for id, f := range m.files {
+		if present[f.Name] {
+			continue
+		}
+		delete(m.files, id)
+		n, err := m.st.GetNote(id, m.userID)
+		if errors.Is(err, store.ErrNotFound) {
+			continue
+		}
+		if err != nil {
+			return err
+		}
+		if n.ModifiedAt.UnixMilli() != f.Modified {
+			// Changed since; it is written out again instead.
+			continue
+		}
+		if err := m.st.DeleteNote(id, m.userID, model.NowMillis().UnixMilli(), m.deviceID); err != nil {
+			return err
+		}
+		fmt.Fprintf(m.out, "Deleted note %s, %s was removed\n", id, f.Name)
+	}
+	return m.save()
+}
+
+// readFile applies one file to its note if it changed.
+func (m *mirror) readFile(name string, byName map[string]string, present map[string]bool) error {
+	data, err := os.ReadFile(filepath.Join(m.dir, name))
+	if err != nil {
+		return err
+	}
+	hash := delta.Hash(string(data))
+	id, tracked := byName[name]
+	if tracked && m.files[id].Hash == hash {
+		return nil
+	}
+
+	fm, content := parseFrontMatter(string(data))
+	if !tracked {
+		// A renamed file keeps its note; a copy becomes a new one.
+		id = fm.get("id")
+		if f, ok := m.files[id]; ok && present[f.Name] {
+			id = ""
+		}
+	}
+	title := fm.get("title")
+	if title == "" {
+		title = strings.TrimSuffix(name, ".md")
+	}
+	var tags []string
+	for _, t := range fm["tags"] {
+		if t = strings.TrimPrefix(strings.TrimSpace(t), "#"); t != "" {
+			tags = append(tags, t)
+		}
+	}
+	noteType := fm.get("type")
+	if !slices.Contains(noteTypes(m.st), noteType) {
+		noteType = "note"
+	}
+
+	var n *model.Note
+	if id != "" {
+		if n, err = m.st.GetNoteAny(id, m.userID); err != nil && !errors.Is(err, store.ErrNotFound) {
+			return err
+		}
+	}
+	now := model.NowMillis()
+	if n == nil || n.DeletedAt != nil {
+		if n != nil {
+			// Deleted elsewhere but edited here: keep the text as a new note.
+			id = ""
+		}
+		if id == "" {
+			id = model.NewID()
+		}
+		n = &model.Note{
+			ID: id, UserID: m.userID, Title: title, Content: content, Type: noteType, Tags: tags,
+			ModifiedAt: now, ModifiedByDevice: m.deviceID, CreatedAt: now,
+		}
+		if err := m.st.CreateNote(n); err != nil {
+			return err
+		}
+		fmt.Fprintf(m.out, "Created note %s from %s\n", n.ID, name)
+		// Written out again with its ID in the front matter.
+		m.files[id] = mirroredNote{Name: name}
+		return nil
+	}
+
+	since, ok := parseFrontMatterTime(fm.get("modified"))
+	if f, known := m.files[id]; known {
+		since, ok = time.UnixMilli(f.Modified), true
+	}
+	if !ok || n.ModifiedAt.After(since) {
+		if err := m.keepConflict(name, data); err != nil {
+			return err
+		}
+		m.files[id] = mirroredNote{Name: name}
+		return nil
+	}
+
+	edited := m.files[id].Edited
+	if n.Title != title || n.Content != content || n.Type != noteType || !slicesEqualFold(n.Tags, tags) {
+		n.Title, n.Content, n.Type, n.Tags = title, content, noteType, tags
+		n.ModifiedAt, n.ModifiedByDevice = now, m.deviceID
+		if err := m.st.UpdateNote(n); err != nil {
+			return err
+		}
+		fmt.Fprintf(m.out, "Updated note %s from %s\n", n.ID, name)
+		edited = true
+	}
+	m.files[id] = mirroredNote{Name: name, Hash: hash, Modified: n.ModifiedAt.UnixMilli(), Edited: edited}
+	return nil
+}
+
+// writeFiles writes the notes changed since they were last in step to
+// their files, and removes the files of deleted notes. Lost lists notes
+// whose local edit was discarded by a sync conflict; if the edit came from
+// the file, the file's version is kept as a conflict file.
+func (m *mirror) writeFiles(lost []string) error {
+	live := map[string]bool{}
+	err := m.st.EachNote(m.userID, func(n *model.Note) error {
+		live[n.ID] = true
+		f, tracked := m.files[n.ID]
+		if tracked && f.Modified == n.ModifiedAt.UnixMilli() && f.Hash != "" {
+			return nil
+		}
+		if !tracked {
+			f.Name = m.freeName(n)
+		} else if changed, data, err := m.changedSince(f); err != nil {
+			return err
+		} else if changed || f.Edited && slices.Contains(lost, n.ID) {
+			if err := m.keepConflict(f.Name, data); err != nil {
+				return err
+			}
+		}
+		data := noteMarkdown(n)
+		if err := writeFileAtomic(filepath.Join(m.dir, f.Name), []byte(data)); err != nil {
+			return err
+		}
+		fmt.Fprintf(m.out, "Wrote %s\n", f.Name)
+		m.files[n.ID] = mirroredNote{Name: f.Name, Hash: delta.Hash(data), Modified: n.ModifiedAt.UnixMilli()}
+		return nil
+	})
+	if err != nil {
+		return err
+	}
+
+	for id, f := range m.files {
+		if live[id] {
+			continue
+		}
+		delete(m.files, id)
+		changed, data, err := m.changedSince(f)
+		if err != nil {
+			return err
+		}
+		if changed {
+			if err := m.keepConflict(f.Name, data); err != nil {
+				return err
+			}
+		}
+		if err := os.Remove(filepath.Join(m.dir, f.Name)); err != nil && !errors.Is(err, fs.ErrNotExist) {
+			return err
+		}
+		fmt.Fprintf(m.out, "Removed %s, its note was deleted\n", f.Name)
+	}
+	return m.save()
+}
+
+// changedSince reads a note's file and reports whether it differs from
+// when it was last in step.
+func (m *mirror) changedSince(f mirroredNote) (bool, []byte, error) {
+	data, err := os.ReadFile(filepath.Join(m.dir, f.Name))
+	if errors.Is(err, fs.ErrNotExist) {
+		return false, nil, nil
+	}
+	if err != nil {
+		return false, nil, err
+	}
+	return f.Hash != "" && delta.Hash(string(data)) != f.Hash, data, nil
+}
+
+// keepConflict saves a file's version that lost to its note beside it.
+func (m *mirror) keepConflict(name string, data []byte) error {
+	if data == nil {
+		return nil
+	}
+	conflict := strings.TrimSuffix(name, ".md") + ".conflict-" + time.Now().Format("20060102-150405") + ".md"
+	if err := writeFileAtomic(filepath.Join(m.dir, conflict), data); err != nil {
+		return err
+	}
+	fmt.Fprintf(m.out, "Conflict: %s and its note both changed, kept the file's version as %s\n", name, conflict)
+	return nil
+}
+
+// freeName picks the file name for a note not yet mirrored: its title,
+// with the start of its ID if another file has the name.
+func (m *mirror) freeName(n *model.Note) string {
+	stem := fileStem(n.Title)
+	if stem == "" {
+		return n.ID + ".md"
+	}
+	taken := func(name string) bool {
+		for _, f := range m.files {
+			if strings.EqualFold(f.Name, name) {
+				return true
+			}
+		}
+		_, err := os.Lstat(filepath.Join(m.dir, name))
+		return err == nil
+	}
+	if name := stem + ".md"; !taken(name) {
+		return name
+	}
+	return stem + "-" + n.ID[:8] + ".md"
+}
+
+// fileStem turns a title into a file name, dropping characters that are
+// not allowed on common file systems. At most 80 bytes are kept.
+func fileStem(title string) string {
+	var b strings.Builder
+	for _, r := range strings.TrimSpace(title) {
+		if strings.ContainsRune(`/\:*?"<>|`, r) || unicode.IsControl(r) {
+			r = '-'
+		}
+		if b.Len()+len(string(r)) > 80 {
+			break
+		}
+		b.WriteRune(r)
+	}
+	return strings.Trim(b.String(), " .-")
+}
+
+func (m *mirror) save() error {
+	data, err := json.MarshalIndent(m.files, "", "  ")
+	if err != nil {
+		return err
+	}
+	return writeFileAtomic(filepath.Join(m.dir, fsSyncState), data)
+}
+
+// writeFileAtomic replaces path through a temporary file, so that editors
+// watching it never read it half written.
+func writeFileAtomic(path string, data []byte) error {
+	f, err := os.CreateTemp(filepath.Dir(path), ".tmp-*")
+	if err != nil {
+		return err
+	}
+	_, err = f.Write(data)
+	if cerr := f.Close(); err == nil {
+		err = cerr
+	}
+	if err == nil {
+		err = os.Rename(f.Name(), path)
+	}
+	if err != nil {
+		os.Remove(f.Name())
+	}
+	return err
+}
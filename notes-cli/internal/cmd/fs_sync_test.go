@@ -0,0 +1,167 @@
+package cmd
+
+import (
+	"io"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/c0dev0id/notesd/notes-cli/internal/model"
+	"github.com/c0dev0id/notesd/notes-cli/internal/store"
+)
+
+func TestMirror(t *testing.T) {
+	// Arrange: a store with one note, mirrored into an empty directory
+	s, err := store.Open(filepath.Join(t.TempDir(), "cache.db"))
+	if err != nil {
+		t.Fatalf("open store: %v", err)
+	}
+	t.Cleanup(func() { s.Close() })
+	now := model.NowMillis()
+	if err := s.CreateNote(&model.Note{ID: "0197e0a4-aaaa", Title: "Shopping", Content: "milk\n", Type: "note",
+		ModifiedAt: now, CreatedAt: now}); err != nil {
+		t.Fatalf("CreateNote: %v", err)
+	}
+	dir := t.TempDir()
+	m, err := openMirror(dir, s, "", "dev", io.Discard)
+	if err != nil {
+		t.Fatalf("openMirror: %v", err)
+	}
+	shopping := filepath.Join(dir, "Shopping.md")
+	read := func(path string) string {
+		t.Helper()
+		b, err := os.ReadFile(path)
+		if err != nil {
+			t.Fatalf("read %s: %v", path, err)
+		}
+		return string(b)
+	}
+	note := func(id string) *model.Note {
+		t.Helper()
+		n, err := s.GetNoteAny(id, "")
+		if err != nil {
+			t.Fatalf("GetNoteAny(%s): %v", id, err)
+		}
+		return n
+	}
+
+	t.Run("writes notes", func(t *testing.T) {
+		// Act
+		err := m.writeFiles(nil)
+
+		// Assert
+		if err != nil {
+			t.Fatalf("writeFiles: %v", err)
+		}
+		got := read(shopping)
+		t.Logf("Shopping.md:\n%s", got)
+		if !strings.Contains(got, `id: "0197e0a4-aaaa"`) || !strings.HasSuffix(got, "---\nmilk\n") {
+			t.Errorf("unexpected file:\n%s", got)
+		}
+	})
+
+	t.Run("reads an edited file", func(t *testing.T) {
+		// Arrange
+		os.WriteFile(shopping, []byte(strings.Replace(read(shopping), "milk", "milk\neggs", 1)), 0644)
+
+		// Act
+		err := m.readFiles()
+
+		// Assert
+		if err != nil {
+			t.Fatalf("readFiles: %v", err)
+		}
+		if n := note("0197e0a4-aaaa"); n.Content != "milk\neggs\n" {
+			t.Errorf("content = %q, want the file's", n.Content)
+		}
+	})
+
+	t.Run("creates a note from a new file", func(t *testing.T) {
+		// Arrange
+		os.WriteFile(filepath.Join(dir, "Idea.md"), []byte("a thought\n"), 0644)
+
+		// Act
+		err := m.readFiles()
+		if err == nil {
+			err = m.writeFiles(nil)
+		}
+
+		// Assert
+		if err != nil {
+			t.Fatalf("sync: %v", err)
+		}
+		fm, content := parseFrontMatter(read(filepath.Join(dir, "Idea.md")))
+		t.Logf("Idea.md front matter %q", fm)
+		n := note(fm.get("id"))
+		if n.Title != "Idea" || n.Content != "a thought\n" || content != n.Content {
+			t.Errorf("note %q %q, file content %q", n.Title, n.Content, content)
+		}
+	})
+
+	t.Run("keeps a conflicting file", func(t *testing.T) {
+		// Arrange: the note and its file both change
+		n := note("0197e0a4-aaaa")
+		n.Content, n.ModifiedAt = "bread\n", n.ModifiedAt.Add(time.Second)
+		if err := s.UpdateNote(n); err != nil {
+			t.Fatalf("UpdateNote: %v", err)
+		}
+		os.WriteFile(shopping, []byte(read(shopping)+"butter\n"), 0644)
+
+		// Act
+		err := m.readFiles()
+		if err == nil {
+			err = m.writeFiles(nil)
+		}
+
+		// Assert
+		if err != nil {
+			t.Fatalf("sync: %v", err)
+		}
+		conflicts, _ := filepath.Glob(filepath.Join(dir, "Shopping.conflict-*.md"))
+		t.Logf("conflict files %v", conflicts)
+		if len(conflicts) != 1 || !strings.HasSuffix(read(conflicts[0]), "eggs\nbutter\n") {
+			t.Fatalf("want one conflict file with the file's version, got %v", conflicts)
+		}
+		if got := read(shopping); !strings.HasSuffix(got, "---\nbread\n") {
+			t.Errorf("Shopping.md should hold the note's version, got:\n%s", got)
+		}
+	})
+
+	t.Run("deletes the note of a removed file", func(t *testing.T) {
+		// Arrange
+		os.Remove(shopping)
+
+		// Act
+		err := m.readFiles()
+
+		// Assert
+		if err != nil {
+			t.Fatalf("readFiles: %v", err)
+		}
+		if n := note("0197e0a4-aaaa"); n.DeletedAt == nil {
+			t.Errorf("note not deleted")
+		}
+	})
+}
+
+func TestFileStem(t *testing.T) {
+	cases := map[string]string{
+		"Shopping":              "Shopping",
+		"  a/b: c?  ":           "a-b- c",
+		"...":                   "",
+		"Größe & Gewicht":       "Größe & Gewicht",
+		strings.Repeat("x", 90): strings.Repeat("x", 80),
+	}
+	for title, want := range cases {
+		// Act
+		got := fileStem(title)
+
+		// Assert
+		t.Logf("%q -> %q", title, got)
+		if got != want {
+			t.Errorf("fileStem(%q) = %q, want %q", title, got, want)
+		}
+	}
+}
@@ -0,0 +1,107 @@
+package cmd
+
+import (
+	"fmt"
+	"regexp"
+	"strings"
+
+	"github.com/c0dev0id/notesd/notes-cli/internal/model"
+	"github.com/charmbracelet/lipgloss"
+	"github.com/spf13/cobra"
+)
+
+var grepCmd = &cobra.Command{
+	Use:   "grep <pattern>",
+	Short: "Search note titles and content with a regular expression",
+	Long: `Searches every local note, title and content, line by line for a regular
+expression (Go RE2 syntax) and prints the matching lines as
+
+  <note-id>:<line>:<text>
+
+Line 0 is the title. Unlike search, this needs no server and matches
+anything a regular expression can describe, e.g.
+
+  notes-cli grep -i 'TODO|FIXME'
+  notes-cli grep '^\s*- \[ \]'          # open Markdown task items`,
+	Args: cobra.ExactArgs(1),
+	RunE: runGrep,
+}
+
+// Styles of grep output, as grep --color uses them; lipgloss drops them
+// when output is not a terminal.
+var (
+	grepID    = lipgloss.NewStyle().Foreground(lipgloss.Color("5"))
+	grepLine  = lipgloss.NewStyle().Foreground(lipgloss.Color("2"))
+	grepMatch = lipgloss.NewStyle().Bold(true).Foreground(lipgloss.Color("1"))
+)
+
+func init() {
+	grepCmd.Flags().BoolP("ignore-case", "i", false, "Match case-insensitively")
+	grepCmd.Flags().BoolP("files-with-matches", "l", false, "Print only the IDs and titles of matching notes")
+}
+
+func runGrep(cmd *cobra.Command, args []string) error {
+	pattern := args[0]
+	if fold, _ := cmd.Flags().GetBool("ignore-case"); fold {
+		pattern = "(?i)" + pattern
+	}
+	re, err := regexp.Compile(pattern)
+	if err != nil {
+		return fmt.Errorf("invalid pattern: %w", err)
+	}
+	listOnly, _ := cmd.Flags().GetBool("files-with-matches")
+
+	return st.EachNote(userID(), func(n *model.Note) error {
+		matches := grepNote(re, n)
+		if len(matches) == 0 {
+			return nil
+		}
+		if listOnly {
+			fmt.Printf("%s  %s\n", grepID.Render(n.ID), n.Title)
+			return nil
+		}
+		for _, m := range matches {
+			fmt.Printf("%s:%s:%s\n", grepID.Render(n.ID), grepLine.Render(fmt.Sprint(m.line)), highlight(re, m.text))
+		}
+		return nil
+	})
+}
+
+// grepMatchLine is a line of a note matching a pattern. Line 0 is the
+// title, the content starts at line 1.
+type grepMatchLine struct {
+	line int
+	text string
+}
+
+func grepNote(re *regexp.Regexp, n *model.Note) []grepMatchLine {
+	var out []grepMatchLine
+	if re.MatchString(n.Title) {
+		out = append(out, grepMatchLine{0, n.Title})
+	}
+	if n.Content == "" {
+		return out
+	}
+	for i, line := range strings.Split(strings.TrimSuffix(n.Content, "\n"), "\n") {
+		if re.MatchString(line) {
+			out = append(out, grepMatchLine{i + 1, line})
+		}
+	}
+	return out
+}
+
+// highlight styles the parts of s that re matches.
+func highlight(re *regexp.Regexp, s string) string {
+	var b strings.Builder
+	last := 0
+	for _, loc := range re.FindAllStringIndex(s, -1) {
+		if loc[0] == loc[1] {
+			continue
+		}
+		b.WriteString(s[last:loc[0]])
+		b.WriteString(grepMatch.Render(s[loc[0]:loc[1]]))
+		last = loc[1]
+	}
+	b.WriteString(s[last:])
+	return b.String()
+}
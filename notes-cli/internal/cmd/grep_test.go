@@ -0,0 +1,37 @@
+package cmd
+
+import (
+	"regexp"
+	"slices"
+	"testing"
+
+	"github.com/c0dev0id/notesd/notes-cli/internal/model"
+)
+
+func TestGrepNote(t *testing.T) {
+	// Arrange
+	n := &model.Note{Title: "Todo list", Content: "- [ ] buy milk\n- [x] call Bob\n- [ ] todo: fix bike\n"}
+	cases := []struct {
+		pattern string
+		want    []int
+	}{
+		{`^- \[ \]`, []int{1, 3}},
+		{`(?i)todo`, []int{0, 3}},
+		{`Todo`, []int{0}},
+		{`walrus`, nil},
+	}
+	for _, c := range cases {
+		// Act
+		matches := grepNote(regexp.MustCompile(c.pattern), n)
+
+		// Assert
+		var lines []int
+		for _, m := range matches {
+			lines = append(lines, m.line)
+		}
+		t.Logf("%q -> lines %v", c.pattern, lines)
+		if !slices.Equal(lines, c.want) {
+			t.Errorf("grepNote(%q) matched lines %v, want %v", c.pattern, lines, c.want)
+		}
+	}
+}
@@ -0,0 +1,144 @@
+package cmd
+
+import (
+	"bufio"
+	"errors"
+	"fmt"
+	"io"
+	"os"
+	"slices"
+	"strings"
+
+	"github.com/c0dev0id/notesd/notes-cli/internal/model"
+	"golang.org/x/term"
+)
+
+// idArgs returns the IDs a command acts on: its arguments, or, when there
+// are none or the only one is "-", the IDs piped on stdin.
+func idArgs(args []string) ([]string, error) {
+	if len(args) == 1 && args[0] == "-" || len(args) == 0 && !term.IsTerminal(int(os.Stdin.Fd())) {
+		args, err := readIDs(os.Stdin)
+		if err != nil {
+			return nil, err
+		}
+		if len(args) == 0 {
+			return nil, errors.New("no IDs on stdin")
+		}
+		return args, nil
+	}
+	if len(args) == 0 {
+		return nil, errors.New("no IDs given")
+	}
+	return uniqueIDs(args), nil
+}
+
+// readIDs reads one ID per line from r, taking the first word of each line
+// so that trailing columns are ignored. Blank lines and lines starting with
+// # are skipped.
+func readIDs(r io.Reader) ([]string, error) {
+	var ids []string
+	sc := bufio.NewScanner(r)
+	for sc.Scan() {
+		fields := strings.Fields(sc.Text())
+		if len(fields) == 0 || strings.HasPrefix(fields[0], "#") {
+			continue
+		}
+		ids = append(ids, fields[0])
+	}
+	if err := sc.Err(); err != nil {
+		return nil, err
+	}
+	return uniqueIDs(ids), nil
+}
+
+// uniqueIDs drops repeated IDs, keeping the first occurrence.
+func uniqueIDs(ids []string) []string {
+	var out []string
+	for _, id := range ids {
+		if !slices.Contains(out, id) {
+			out = append(out, id)
+		}
+	}
+	return out
+}
+
+// maxCandidates is the number of matches listed for an ambiguous reference.
+const maxCandidates = 10
+
+// candidate is a note or todo that a reference may mean.
+type candidate struct{ id, label string }
+
+// noteID resolves ref to the ID of a local note: ref may be an ID, a
+// unique prefix of one or a unique part of a title. A ref matching nothing
+// is returned as given, so that the caller's lookup reports it.
+func noteID(ref string) (string, error) {
+	notes, err := st.MatchNotes(userID(), ref, maxCandidates+1)
+	if err != nil {
+		return "", err
+	}
+	cs := make([]candidate, len(notes))
+	for i, n := range notes {
+		cs[i] = candidate{n.ID, n.Title}
+	}
+	return pickID("note", ref, cs)
+}
+
+// todoID is noteID for todos, matching their content.
+func todoID(ref string) (string, error) {
+	todos, err := st.MatchTodos(userID(), ref, maxCandidates+1)
+	if err != nil {
+		return "", err
+	}
+	cs := make([]candidate, len(todos))
+	for i, t := range todos {
+		cs[i] = candidate{t.ID, t.Content}
+	}
+	return pickID("todo", ref, cs)
+}
+
+func getNote(ref string) (*model.Note, error) {
+	id, err := noteID(ref)
+	if err != nil {
+		return nil, err
+	}
+	return st.GetNote(id, userID())
+}
+
+func getTodo(ref string) (*model.Todo, error) {
+	id, err := todoID(ref)
+	if err != nil {
+		return nil, err
+	}
+	return st.GetTodo(id, userID())
+}
+
+// pickID returns the ID of the only candidate, or of the only one labelled
+// exactly ref, and otherwise fails listing them.
+func pickID(kind, ref string, cs []candidate) (string, error) {
+	switch len(cs) {
+	case 0:
+		return ref, nil
+	case 1:
+		return cs[0].id, nil
+	}
+	var exact []string
+	for _, c := range cs {
+		if strings.EqualFold(c.label, ref) {
+			exact = append(exact, c.id)
+		}
+	}
+	if len(exact) == 1 {
+		return exact[0], nil
+	}
+
+	var b strings.Builder
+	fmt.Fprintf(&b, "%q matches several %ss:", ref, kind)
+	for i, c := range cs {
+		if i == maxCandidates {
+			b.WriteString("\n  ...")
+			break
+		}
+		fmt.Fprintf(&b, "\n  %s  %s", c.id, c.label)
+	}
+	return "", errors.New(b.String())
+}
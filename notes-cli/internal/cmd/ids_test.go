@@ -0,0 +1,52 @@
+package cmd
+
+import (
+	"slices"
+	"strings"
+	"testing"
+)
+
+func TestReadIDs(t *testing.T) {
+	// Arrange
+	input := "a1\n\n  b2  Shopping list\n# comment\na1\nc3\n"
+
+	// Act
+	ids, err := readIDs(strings.NewReader(input))
+
+	// Assert
+	if err != nil {
+		t.Fatalf("readIDs: %v", err)
+	}
+	want := []string{"a1", "b2", "c3"}
+	if !slices.Equal(ids, want) {
+		t.Errorf("ids = %v, want %v", ids, want)
+	}
+	t.Logf("read %v", ids)
+}
+
+func TestPickID(t *testing.T) {
+	// Arrange
+	lists := []candidate{{"a1", "Shopping list"}, {"a2", "Packing list"}, {"a3", "list"}}
+	cases := []struct {
+		name    string
+		ref     string
+		cs      []candidate
+		want    string
+		wantErr bool
+	}{
+		{"no match keeps ref", "zz", nil, "zz", false},
+		{"single match", "a1", lists[:1], "a1", false},
+		{"exact label wins", "LIST", lists, "a3", false},
+		{"ambiguous", "a", lists, "", true},
+	}
+	for _, c := range cases {
+		// Act
+		got, err := pickID("note", c.ref, c.cs)
+
+		// Assert
+		t.Logf("%s: %q -> %q, err=%v", c.name, c.ref, got, err)
+		if got != c.want || (err != nil) != c.wantErr {
+			t.Errorf("%s: got %q, %v; want %q, error %v", c.name, got, err, c.want, c.wantErr)
+		}
+	}
+}
@@ -0,0 +1,142 @@
+package cmd
+
+import (
+	"archive/zip"
+	"bytes"
+	"fmt"
+	"io/fs"
+	"os"
+	"path/filepath"
+	"strings"
+
+	"github.com/spf13/cobra"
+)
+
+var importCmd = &cobra.Command{
+	Use:   "import <path>",
+	Short: "Import notes from a notesd export, Evernote or Markdown files",
+	Long: `Import notes into your account. path is an Evernote .enex file, a zip
+file or a directory: a notesd export (as written by notesd export) or a
+folder of Markdown files, such as an Obsidian vault. Directories are zipped
+before upload. Without --format the format is guessed from path.
+
+Notes with the same title and content as an existing note are skipped, so
+an import can safely be repeated.`,
+	Args: cobra.ExactArgs(1),
+	RunE: runImport,
+}
+
+func init() {
+	importCmd.Flags().String("format", "", "Import format: notesd, enex or markdown")
+}
+
+func runImport(cmd *cobra.Command, args []string) error {
+	path := args[0]
+	format, _ := cmd.Flags().GetString("format")
+
+	info, err := os.Stat(path)
+	if err != nil {
+		return err
+	}
+	if format == "" {
+		if format, err = guessImportFormat(path, info.IsDir()); err != nil {
+			return err
+		}
+	}
+	if format != "notesd" && format != "enex" && format != "markdown" {
+		return fmt.Errorf("unknown format %q: use notesd, enex or markdown", format)
+	}
+
+	var body []byte
+	if info.IsDir() {
+		if format == "enex" {
+			return fmt.Errorf("enex imports need a file, not a directory")
+		}
+		body, err = zipDir(path)
+	} else {
+		body, err = os.ReadFile(path)
+	}
+	if err != nil {
+		return err
+	}
+
+	summary, err := cl.Import(format, body)
+	if err != nil {
+		return err
+	}
+	fmt.Printf("Imported %d notes, %d todos and %d attachments (%d already present)\n",
+		summary.Notes, summary.Todos, summary.Attachments, summary.Skipped)
+	syncQuietly()
+	return nil
+}
+
+// guessImportFormat picks enex for .enex files, notesd when a manifest.json
+// sits at the top of the directory or zip, and markdown otherwise.
+func guessImportFormat(path string, dir bool) (string, error) {
+	if dir {
+		if _, err := os.Stat(filepath.Join(path, "manifest.json")); err == nil {
+			return "notesd", nil
+		}
+		return "markdown", nil
+	}
+	switch strings.ToLower(filepath.Ext(path)) {
+	case ".enex":
+		return "enex", nil
+	case ".zip":
+		zr, err := zip.OpenReader(path)
+		if err != nil {
+			return "", err
+		}
+		defer zr.Close()
+		for _, f := range zr.File {
+			if f.Name == "manifest.json" {
+				return "notesd", nil
+			}
+		}
+		return "markdown", nil
+	}
+	return "", fmt.Errorf("cannot tell the format of %s, use --format", path)
+}
+
+// zipDir packs the regular files below dir into a zip with slash-separated
+// paths relative to dir, keeping modification times.
+func zipDir(dir string) ([]byte, error) {
+	var buf bytes.Buffer
+	zw := zip.NewWriter(&buf)
+	err := filepath.WalkDir(dir, func(path string, d fs.DirEntry, err error) error {
+		if err != nil || !d.Type().IsRegular() {
+			return err
+		}
+		rel, err := filepath.Rel(dir, path)
+		if err != nil {
+			return err
+		}
+		info, err := d.Info()
+		if err != nil {
+			return err
+		}
+		hdr, err := zip.FileInfoHeader(info)
+		if err != nil {
+			return err
+		}
+		hdr.Name = filepath.ToSlash(rel)
+		hdr.Method = zip.Deflate
+		w, err := zw.CreateHeader(hdr)
+		if err != nil {
+			return err
+		}
+		data, err := os.ReadFile(path)
+		if err != nil {
+			return err
+		}
+		_, err = w.Write(data)
+		return err
+	})
+	if err != nil {
+		return nil, err
+	}
+	if err := zw.Close(); err != nil {
+		return nil, err
+	}
+	return buf.Bytes(), nil
+}
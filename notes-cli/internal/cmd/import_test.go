@@ -0,0 +1,73 @@
+package cmd
+
+import (
+	"archive/zip"
+	"bytes"
+	"io"
+	"os"
+	"path/filepath"
+	"testing"
+)
+
+func TestZipDir(t *testing.T) {
+	// Arrange
+	dir := t.TempDir()
+	os.MkdirAll(filepath.Join(dir, "notes"), 0755)
+	os.WriteFile(filepath.Join(dir, "manifest.json"), []byte("{}"), 0644)
+	os.WriteFile(filepath.Join(dir, "notes", "a.md"), []byte("# A\n"), 0644)
+
+	// Act
+	data, err := zipDir(dir)
+
+	// Assert
+	if err != nil {
+		t.Fatalf("zipDir: %v", err)
+	}
+	zr, err := zip.NewReader(bytes.NewReader(data), int64(len(data)))
+	if err != nil {
+		t.Fatalf("read zip: %v", err)
+	}
+	files := map[string]string{}
+	for _, f := range zr.File {
+		rc, _ := f.Open()
+		b, _ := io.ReadAll(rc)
+		rc.Close()
+		files[f.Name] = string(b)
+	}
+	t.Logf("zip entries: %q", files)
+	if len(files) != 2 || files["manifest.json"] != "{}" || files["notes/a.md"] != "# A\n" {
+		t.Errorf("unexpected entries %q", files)
+	}
+}
+
+func TestGuessImportFormat(t *testing.T) {
+	// Arrange
+	export := t.TempDir()
+	os.WriteFile(filepath.Join(export, "manifest.json"), []byte("{}"), 0644)
+	vault := t.TempDir()
+	zipped := filepath.Join(t.TempDir(), "export.zip")
+	data, _ := zipDir(export)
+	os.WriteFile(zipped, data, 0644)
+
+	cases := []struct {
+		path string
+		dir  bool
+		want string
+	}{
+		{export, true, "notesd"},
+		{vault, true, "markdown"},
+		{zipped, false, "notesd"},
+		{"My Notes.ENEX", false, "enex"},
+		{"notes.txt", false, ""},
+	}
+	for _, c := range cases {
+		// Act
+		got, err := guessImportFormat(c.path, c.dir)
+
+		// Assert
+		t.Logf("%s: %q, %v", filepath.Base(c.path), got, err)
+		if got != c.want || (c.want == "") != (err != nil) {
+			t.Errorf("%s: got %q, %v; want %q", c.path, got, err, c.want)
+		}
+	}
+}
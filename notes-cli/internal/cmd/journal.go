@@ -0,0 +1,40 @@
+package cmd
+
+import (
+	"fmt"
+	"time"
+
+	"github.com/spf13/cobra"
+)
+
+var journalCmd = &cobra.Command{
+	Use:   "journal [YYYY-MM-DD]",
+	Short: "Edit the journal entry for today or a given date",
+	Long: `Opens the journal note for today, or for the given date, in $EDITOR. The
+server creates the note on first use, titled and filled in according to the
+journal settings, so it needs to be reachable.`,
+	Args: cobra.MaximumNArgs(1),
+	RunE: runJournal,
+}
+
+func runJournal(cmd *cobra.Command, args []string) error {
+	date := time.Now().Format("2006-01-02")
+	if len(args) == 1 {
+		if _, err := time.Parse("2006-01-02", args[0]); err != nil {
+			return fmt.Errorf("invalid date (use YYYY-MM-DD): %w", err)
+		}
+		date = args[0]
+	}
+
+	n, err := cl.JournalEntry(date)
+	if err != nil {
+		return err
+	}
+	// Keep a newer local version of the note, if there is one.
+	if local, err := st.UpsertNote(n); err != nil {
+		return err
+	} else if local != nil {
+		n = local
+	}
+	return editNote(n)
+}
@@ -23,8 +23,12 @@ var registerCmd = &cobra.Command{
 	RunE:  runRegister,
 }
 
+// stdinReader is shared by all prompts so that piped input with several
+// lines is not swallowed by the first prompt's buffer.
+var stdinReader = bufio.NewReader(os.Stdin)
+
 func init() {
-	loginCmd.Flags().StringP("server", "s", "", "Server URL (e.g. http://localhost:8080)")
+	loginCmd.Flags().StringP("server", "s", "", "Server URL (e.g. http://localhost:8080 or unix:///run/notesd.sock)")
 	loginCmd.Flags().StringP("email", "e", "", "Email address")
 	loginCmd.Flags().StringP("password", "p", "", "Password (omit to prompt)")
 	loginCmd.Flags().StringP("device", "d", "", "Device ID (default: hostname)")
@@ -33,6 +37,7 @@ func init() {
 	registerCmd.Flags().StringP("email", "e", "", "Email address")
 	registerCmd.Flags().StringP("password", "p", "", "Password (omit to prompt)")
 	registerCmd.Flags().StringP("name", "n", "", "Display name")
+	registerCmd.Flags().StringP("invite", "i", "", "Invite code, for servers that require one")
 }
 
 func runLogin(cmd *cobra.Command, args []string) error {
@@ -42,7 +47,7 @@ func runLogin(cmd *cobra.Command, args []string) error {
 		return err
 	}
 
-	reader := bufio.NewReader(os.Stdin)
+	reader := stdinReader
 
 	serverURL, _ := cmd.Flags().GetString("server")
 	if serverURL == "" {
@@ -88,7 +93,7 @@ func runRegister(cmd *cobra.Command, args []string) error {
 		return err
 	}
 
-	reader := bufio.NewReader(os.Stdin)
+	reader := stdinReader
 
 	serverURL, _ := cmd.Flags().GetString("server")
 	if serverURL == "" {
@@ -122,7 +127,8 @@ func runRegister(cmd *cobra.Command, args []string) error {
 		}
 	}
 
-	if err := cl.Register(serverURL, email, password, displayName); err != nil {
+	invite, _ := cmd.Flags().GetString("invite")
+	if err := cl.Register(serverURL, email, password, displayName, invite); err != nil {
 		return fmt.Errorf("registration failed: %w", err)
 	}
 
@@ -147,8 +153,7 @@ func promptPassword(label string) string {
 		return string(b)
 	}
 	// Fallback for piped input
-	reader := bufio.NewReader(os.Stdin)
-	line, _ := reader.ReadString('\n')
+	line, _ := stdinReader.ReadString('\n')
 	fmt.Fprintln(os.Stderr)
 	return strings.TrimSpace(line)
 }
@@ -2,12 +2,15 @@ package cmd
 
 import (
 	"fmt"
+	"io"
 	"os"
 	"os/exec"
+	"slices"
 	"strings"
 	"time"
 
 	"github.com/c0dev0id/notesd/notes-cli/internal/model"
+	"github.com/c0dev0id/notesd/notes-cli/internal/store"
 	"github.com/spf13/cobra"
 )
 
@@ -43,31 +46,72 @@ var notesEditCmd = &cobra.Command{
 }
 
 var notesDeleteCmd = &cobra.Command{
-	Use:   "delete <id>",
-	Short: "Delete a note",
-	Args:  cobra.ExactArgs(1),
-	RunE:  runNotesDelete,
+	Use:   "delete <id>...",
+	Short: "Delete notes",
+	Long: `Deletes the given notes. Without IDs, or with -, the IDs are read from
+stdin, one per line. Every note is looked up first; if one is missing,
+none is deleted.`,
+	RunE: runNotesDelete,
+}
+
+var notesAppendCmd = &cobra.Command{
+	Use:   "append <id>",
+	Short: "Append stdin to a note",
+	Long: `Appends the text read from stdin to the note's content, on a new line,
+for example: date | notes-cli notes append <id>`,
+	Args: cobra.ExactArgs(1),
+	RunE: runNotesAppend,
+}
+
+var notesBacklinksCmd = &cobra.Command{
+	Use:   "backlinks <id>",
+	Short: "List notes that link to a note",
+	Long: `Lists the notes whose [[wiki links]] point at the note's title. The server
+is asked first; with --offline, or when it cannot be reached, the local
+notes are scanned instead.`,
+	Args: cobra.ExactArgs(1),
+	RunE: runNotesBacklinks,
 }
 
 func init() {
-	notesCmd.AddCommand(notesListCmd, notesShowCmd, notesCreateCmd, notesEditCmd, notesDeleteCmd)
+	notesCmd.AddCommand(notesListCmd, notesShowCmd, notesCreateCmd, notesEditCmd, notesDeleteCmd,
+		notesAppendCmd, notesBacklinksCmd)
 
 	notesListCmd.Flags().IntP("limit", "l", 20, "Number of notes to show")
 	notesListCmd.Flags().IntP("offset", "o", 0, "Offset for pagination")
+	notesListCmd.Flags().Bool("snoozed", false, "Show only snoozed notes")
+	notesListCmd.Flags().String("tag", "", "Show only notes with this tag")
+	notesListCmd.RegisterFlagCompletionFunc("tag", completeTags)
+
+	notesShowCmd.Flags().BoolP("render", "r", false, "Render markdown for the terminal")
+	notesShowCmd.Flags().Bool("raw", false, "Print only the content, as stored")
+	notesShowCmd.MarkFlagsMutuallyExclusive("render", "raw")
 
 	notesCreateCmd.Flags().StringP("title", "t", "", "Note title")
 	notesCreateCmd.Flags().StringP("content", "c", "", "Note content")
-	notesCreateCmd.Flags().String("type", "note", "Note type (note, todo_list)")
+	notesCreateCmd.Flags().String("type", "note", "Note type (note, todo_list, bookmark or one the server adds)")
+	notesCreateCmd.RegisterFlagCompletionFunc("type", completeNoteTypes)
+	notesCreateCmd.Flags().Bool("stdin", false, "Read the content from stdin; without --title the first line is the title")
+
+	notesDeleteCmd.Flags().Bool("purge", false, "Delete permanently on the server, including attachments")
+
+	notesBacklinksCmd.Flags().Bool("offline", false, "Scan local notes without contacting the server")
 }
 
 func runNotesList(cmd *cobra.Command, args []string) error {
 	limit, _ := cmd.Flags().GetInt("limit")
 	offset, _ := cmd.Flags().GetInt("offset")
+	snoozed, _ := cmd.Flags().GetBool("snoozed")
+	tag, _ := cmd.Flags().GetString("tag")
 
-	notes, total, err := st.ListNotes(userID(), limit, offset)
+	f := store.NoteFilter{Snoozed: snoozed, Tag: strings.TrimSpace(tag)}
+	notes, total, err := st.ListNotes(userID(), f, limit, offset)
 	if err != nil {
 		return err
 	}
+	if ok, err := printStructured(notes, noteHeader, noteRecords(notes)); ok {
+		return err
+	}
 	if len(notes) == 0 {
 		fmt.Println("No notes.")
 		return nil
@@ -87,18 +131,73 @@ func runNotesList(cmd *cobra.Command, args []string) error {
 }
 
 func runNotesShow(cmd *cobra.Command, args []string) error {
-	n, err := st.GetNote(args[0], userID())
+	n, err := getNote(args[0])
 	if err != nil {
 		return err
 	}
+	if raw, _ := cmd.Flags().GetBool("raw"); raw {
+		_, err := io.WriteString(os.Stdout, n.Content)
+		return err
+	}
+	if ok, err := printStructured(n, noteHeader, noteRecords([]model.Note{*n})); ok {
+		return err
+	}
 	fmt.Printf("ID:       %s\n", n.ID)
 	fmt.Printf("Title:    %s\n", n.Title)
 	fmt.Printf("Type:     %s\n", n.Type)
 	fmt.Printf("Modified: %s\n", n.ModifiedAt.Local().Format(time.RFC3339))
 	fmt.Printf("Created:  %s\n", n.CreatedAt.Local().Format(time.RFC3339))
+	if n.SnoozedUntil != nil {
+		fmt.Printf("Snoozed:  %s\n", n.SnoozedUntil.Local().Format(time.RFC3339))
+	}
+	if len(n.Tags) > 0 {
+		fmt.Printf("Tags:     %s\n", strings.Join(n.Tags, ", "))
+	}
 	if n.Content != "" {
 		fmt.Println()
-		fmt.Println(n.Content)
+		if render, _ := cmd.Flags().GetBool("render"); render {
+			fmt.Println(renderMarkdown(n.Content))
+		} else {
+			fmt.Println(n.Content)
+		}
+	}
+	return nil
+}
+
+func runNotesBacklinks(cmd *cobra.Command, args []string) error {
+	offline, _ := cmd.Flags().GetBool("offline")
+	id, err := noteID(args[0])
+	if err != nil {
+		return err
+	}
+
+	var notes []model.Note
+	if !offline {
+		notes, err = cl.Backlinks(id)
+		if err != nil {
+			fmt.Fprintf(os.Stderr, "server backlinks failed (%v), using local notes\n", err)
+			offline = true
+		}
+	}
+	if offline {
+		notes, err = st.Backlinks(id, userID())
+		if err != nil {
+			return err
+		}
+	}
+	if ok, err := printStructured(notes, noteHeader, noteRecords(notes)); ok {
+		return err
+	}
+	if len(notes) == 0 {
+		fmt.Println("No backlinks.")
+		return nil
+	}
+	for _, n := range notes {
+		title := n.Title
+		if title == "" {
+			title = "(untitled)"
+		}
+		fmt.Printf("%-38s  %s  %s\n", n.ID, n.ModifiedAt.Local().Format("2006-01-02"), title)
 	}
 	return nil
 }
@@ -107,8 +206,20 @@ func runNotesCreate(cmd *cobra.Command, args []string) error {
 	title, _ := cmd.Flags().GetString("title")
 	content, _ := cmd.Flags().GetString("content")
 	noteType, _ := cmd.Flags().GetString("type")
+	if types := noteTypes(st); !slices.Contains(types, noteType) {
+		return fmt.Errorf("unknown note type %q (the server takes %s)", noteType, strings.Join(types, ", "))
+	}
 
-	if content == "" && title == "" {
+	if stdin, _ := cmd.Flags().GetBool("stdin"); stdin {
+		data, err := io.ReadAll(os.Stdin)
+		if err != nil {
+			return err
+		}
+		content = string(data)
+		if title == "" {
+			title, content = splitTitle(content)
+		}
+	} else if content == "" && title == "" {
 		var err error
 		title, content, err = editInEditor("", "")
 		if err != nil {
@@ -135,11 +246,64 @@ func runNotesCreate(cmd *cobra.Command, args []string) error {
 	return nil
 }
 
+// noteTypes returns the note types the server accepts, as cached by the
+// last sync, or the built-in ones before the first.
+func noteTypes(s *store.Store) []string {
+	if types, err := s.GetNoteTypes(); err == nil && types != nil {
+		return types
+	}
+	return []string{"note", "todo_list", "bookmark"}
+}
+
+// splitTitle takes the first line of text as a title, without the marks
+// of a Markdown heading, and returns the rest as content.
+func splitTitle(text string) (title, content string) {
+	first, rest, _ := strings.Cut(text, "\n")
+	return strings.TrimSpace(strings.TrimLeft(first, "#")), rest
+}
+
+func runNotesAppend(cmd *cobra.Command, args []string) error {
+	n, err := getNote(args[0])
+	if err != nil {
+		return err
+	}
+	data, err := io.ReadAll(os.Stdin)
+	if err != nil {
+		return err
+	}
+	if len(data) == 0 {
+		return fmt.Errorf("nothing to append on stdin")
+	}
+	n.Content = appendText(n.Content, string(data))
+	n.ModifiedAt = model.NowMillis()
+	n.ModifiedByDevice = cl.DeviceID()
+	if err := st.UpdateNote(n); err != nil {
+		return err
+	}
+	fmt.Printf("Appended to note %s\n", n.ID)
+	go syncQuietly()
+	return nil
+}
+
+// appendText adds text to content on a line of its own.
+func appendText(content, text string) string {
+	if content != "" && !strings.HasSuffix(content, "\n") {
+		content += "\n"
+	}
+	return content + text
+}
+
 func runNotesEdit(cmd *cobra.Command, args []string) error {
-	n, err := st.GetNote(args[0], userID())
+	n, err := getNote(args[0])
 	if err != nil {
 		return err
 	}
+	return editNote(n)
+}
+
+// editNote opens n in $EDITOR and saves the result locally for the next
+// sync.
+func editNote(n *model.Note) error {
 	newTitle, newContent, err := editInEditor(n.Title, n.Content)
 	if err != nil {
 		return err
@@ -161,21 +325,85 @@ func runNotesEdit(cmd *cobra.Command, args []string) error {
 }
 
 func runNotesDelete(cmd *cobra.Command, args []string) error {
-	now := model.NowMillis()
-	if err := st.DeleteNote(args[0], userID(), now.UnixMilli(), cl.DeviceID()); err != nil {
+	ids, err := idArgs(args)
+	if err != nil {
 		return err
 	}
-	fmt.Printf("Deleted note %s\n", args[0])
+	for i, ref := range ids {
+		if ids[i], err = noteID(ref); err != nil {
+			return err
+		}
+	}
+	if purge, _ := cmd.Flags().GetBool("purge"); purge {
+		for _, id := range ids {
+			if err := purgeNote(id); err != nil {
+				return err
+			}
+		}
+		return nil
+	}
+
+	for _, id := range ids {
+		if _, err := st.GetNote(id, userID()); err != nil {
+			return fmt.Errorf("note %s: %w", id, err)
+		}
+	}
+	now := model.NowMillis()
+	for _, id := range ids {
+		if err := st.DeleteNote(id, userID(), now.UnixMilli(), cl.DeviceID()); err != nil {
+			return err
+		}
+		fmt.Printf("Deleted note %s\n", id)
+	}
 	go syncQuietly()
 	return nil
 }
 
+// purgeNote deletes a note on the server without leaving a tombstone, then
+// drops it locally. Unlike a plain delete it needs the server to be
+// reachable.
+func purgeNote(id string) error {
+	if _, err := st.GetNoteAny(id, userID()); err != nil {
+		return err
+	}
+	if pending, err := st.IsPending("note", id); err != nil {
+		return err
+	} else if pending {
+		if _, err := sy.Sync(); err != nil {
+			return fmt.Errorf("note not yet on server: %w", err)
+		}
+	}
+
+	if err := cl.PurgeNote(id); err != nil {
+		return err
+	}
+	if err := st.Purge("note", id); err != nil {
+		return err
+	}
+	fmt.Printf("Purged note %s\n", id)
+	return nil
+}
+
 // editInEditor opens $EDITOR with note content in the format:
 //
 //	Title: <title>
 //	---
 //	<content>
 func editInEditor(title, content string) (string, string, error) {
+	initial := fmt.Sprintf("Title: %s\n---\n%s", title, content)
+	data, err := runEditor(initial, "notesd-*.md")
+	if err != nil {
+		return "", "", err
+	}
+	if data == initial {
+		return title, content, nil
+	}
+	return parseEditorContent(data)
+}
+
+// runEditor writes initial to a temp file named after pattern, opens it in
+// $EDITOR (falling back to $VISUAL, then vi) and returns the saved text.
+func runEditor(initial, pattern string) (string, error) {
 	editor := os.Getenv("EDITOR")
 	if editor == "" {
 		editor = os.Getenv("VISUAL")
@@ -184,38 +412,32 @@ func editInEditor(title, content string) (string, string, error) {
 		editor = "vi"
 	}
 
-	tmpfile, err := os.CreateTemp("", "notesd-*.md")
+	tmpfile, err := os.CreateTemp("", pattern)
 	if err != nil {
-		return "", "", fmt.Errorf("create temp file: %w", err)
+		return "", fmt.Errorf("create temp file: %w", err)
 	}
 	tmpPath := tmpfile.Name()
 	defer os.Remove(tmpPath)
 
-	initial := fmt.Sprintf("Title: %s\n---\n%s", title, content)
 	if _, err := tmpfile.WriteString(initial); err != nil {
 		tmpfile.Close()
-		return "", "", err
+		return "", err
 	}
 	tmpfile.Close()
 
-	initialData, _ := os.ReadFile(tmpPath)
-
 	c := exec.Command(editor, tmpPath)
 	c.Stdin = os.Stdin
 	c.Stdout = os.Stdout
 	c.Stderr = os.Stderr
 	if err := c.Run(); err != nil {
-		return "", "", fmt.Errorf("editor: %w", err)
+		return "", fmt.Errorf("editor: %w", err)
 	}
 
 	data, err := os.ReadFile(tmpPath)
 	if err != nil {
-		return "", "", fmt.Errorf("read temp file: %w", err)
-	}
-	if string(data) == string(initialData) {
-		return title, content, nil
+		return "", fmt.Errorf("read temp file: %w", err)
 	}
-	return parseEditorContent(string(data))
+	return string(data), nil
 }
 
 func parseEditorContent(s string) (string, string, error) {
@@ -0,0 +1,307 @@
+package cmd
+
+import (
+	"errors"
+	"fmt"
+	"io"
+	"io/fs"
+	"os"
+	"path/filepath"
+	"slices"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/c0dev0id/notesd/notes-cli/internal/model"
+	"github.com/c0dev0id/notesd/notes-cli/internal/store"
+	"github.com/spf13/cobra"
+)
+
+var notesExportCmd = &cobra.Command{
+	Use:   "export <id> [file.md]",
+	Short: "Write a note as a Markdown file with front matter",
+	Long: `Writes the note as Markdown with YAML front matter holding its ID, title,
+type, tags and times, in the format of the account export. Without a file,
+or with -, it goes to stdout. An existing file is only replaced with
+--force.`,
+	Args: cobra.RangeArgs(1, 2),
+	RunE: runNotesExport,
+}
+
+var notesImportCmd = &cobra.Command{
+	Use:   "import <file.md>",
+	Short: "Create or update a note from a Markdown file",
+	Long: `Reads a Markdown file, such as one written by notes export, and updates
+the note named by the id in its front matter, or creates a new note when
+there is none. The title comes from the front matter or the file name, and
+tags from the front matter. - reads stdin.
+
+A note changed since the file's modified time is not overwritten without
+--force, so that an old copy does not undo newer edits.`,
+	Args: cobra.ExactArgs(1),
+	RunE: runNotesImport,
+}
+
+func init() {
+	notesCmd.AddCommand(notesExportCmd, notesImportCmd)
+
+	notesExportCmd.Flags().BoolP("force", "f", false, "Replace an existing file")
+	notesImportCmd.Flags().BoolP("force", "f", false, "Overwrite a note changed since the file was exported")
+}
+
+func runNotesExport(cmd *cobra.Command, args []string) error {
+	n, err := getNote(args[0])
+	if err != nil {
+		return err
+	}
+	data := noteMarkdown(n)
+	if len(args) == 1 || args[1] == "-" {
+		_, err := io.WriteString(os.Stdout, data)
+		return err
+	}
+
+	flags := os.O_WRONLY | os.O_CREATE | os.O_EXCL
+	if force, _ := cmd.Flags().GetBool("force"); force {
+		flags = os.O_WRONLY | os.O_CREATE | os.O_TRUNC
+	}
+	f, err := os.OpenFile(args[1], flags, 0644)
+	if errors.Is(err, fs.ErrExist) {
+		return fmt.Errorf("%s exists; use --force to replace it", args[1])
+	}
+	if err != nil {
+		return err
+	}
+	_, err = io.WriteString(f, data)
+	if cerr := f.Close(); err == nil {
+		err = cerr
+	}
+	if err != nil {
+		return err
+	}
+	fmt.Fprintf(os.Stderr, "Exported note %s to %s\n", n.ID, args[1])
+	return nil
+}
+
+func runNotesImport(cmd *cobra.Command, args []string) error {
+	var data []byte
+	var err error
+	if args[0] == "-" {
+		data, err = io.ReadAll(os.Stdin)
+	} else {
+		data, err = os.ReadFile(args[0])
+	}
+	if err != nil {
+		return err
+	}
+	fm, content := parseFrontMatter(string(data))
+
+	title := fm.get("title")
+	if title == "" && args[0] != "-" {
+		title = strings.TrimSuffix(filepath.Base(args[0]), filepath.Ext(args[0]))
+	}
+	var tags []string
+	for _, t := range fm["tags"] {
+		if t = strings.TrimPrefix(strings.TrimSpace(t), "#"); t != "" {
+			tags = append(tags, t)
+		}
+	}
+	noteType := fm.get("type")
+	if !slices.Contains(noteTypes(st), noteType) {
+		noteType = "note"
+	}
+
+	now := model.NowMillis()
+	id := fm.get("id")
+	var n *model.Note
+	if id != "" {
+		if n, err = st.GetNoteAny(id, userID()); err != nil && !errors.Is(err, store.ErrNotFound) {
+			return err
+		}
+	}
+	if n == nil {
+		// An unknown ID is kept, so that importing the file again updates
+		// the same note.
+		if id == "" {
+			id = model.NewID()
+		}
+		n = &model.Note{
+			ID: id, UserID: userID(), Title: title, Content: content, Type: noteType, Tags: tags,
+			ModifiedAt: now, ModifiedByDevice: cl.DeviceID(), CreatedAt: now,
+		}
+		if t, ok := parseFrontMatterTime(fm.get("created")); ok {
+			n.CreatedAt = t
+		}
+		if err := st.CreateNote(n); err != nil {
+			return err
+		}
+		fmt.Printf("Created note %s\n", n.ID)
+		go syncQuietly()
+		return nil
+	}
+	if n.DeletedAt != nil {
+		return fmt.Errorf("note %s was deleted; remove id from the front matter to import it as a new note", id)
+	}
+	if force, _ := cmd.Flags().GetBool("force"); !force {
+		if t, ok := parseFrontMatterTime(fm.get("modified")); !ok || n.ModifiedAt.After(t) {
+			return fmt.Errorf("note %s was changed after this file was exported; use --force to overwrite it", id)
+		}
+	}
+	if n.Title == title && n.Content == content && n.Type == noteType && slicesEqualFold(n.Tags, tags) {
+		fmt.Println("No changes.")
+		return nil
+	}
+	n.Title, n.Content, n.Type, n.Tags = title, content, noteType, tags
+	n.ModifiedAt, n.ModifiedByDevice = now, cl.DeviceID()
+	if err := st.UpdateNote(n); err != nil {
+		return err
+	}
+	fmt.Printf("Updated note %s\n", n.ID)
+	go syncQuietly()
+	return nil
+}
+
+func slicesEqualFold(a, b []string) bool {
+	if len(a) != len(b) {
+		return false
+	}
+	for i := range a {
+		if !strings.EqualFold(a[i], b[i]) {
+			return false
+		}
+	}
+	return true
+}
+
+// noteMarkdown renders a note as Markdown with YAML front matter, as the
+// server's export does. Strings are written as double-quoted scalars,
+// which YAML shares with Go.
+func noteMarkdown(n *model.Note) string {
+	var b strings.Builder
+	b.WriteString("---\n")
+	fmt.Fprintf(&b, "id: %s\n", strconv.Quote(n.ID))
+	fmt.Fprintf(&b, "title: %s\n", strconv.Quote(n.Title))
+	fmt.Fprintf(&b, "type: %s\n", strconv.Quote(n.Type))
+	quoted := make([]string, len(n.Tags))
+	for i, t := range n.Tags {
+		quoted[i] = strconv.Quote(t)
+	}
+	fmt.Fprintf(&b, "tags: [%s]\n", strings.Join(quoted, ", "))
+	fmt.Fprintf(&b, "created: %s\n", n.CreatedAt.UTC().Format(time.RFC3339Nano))
+	fmt.Fprintf(&b, "modified: %s\n", n.ModifiedAt.UTC().Format(time.RFC3339Nano))
+	if n.SnoozedUntil != nil {
+		fmt.Fprintf(&b, "snoozed_until: %s\n", n.SnoozedUntil.UTC().Format(time.RFC3339Nano))
+	}
+	b.WriteString("---\n")
+	b.WriteString(n.Content)
+	return b.String()
+}
+
+// frontMatter holds the keys of a YAML front matter block, lower-cased.
+// Scalars have one value and lists several. Like the server's import, only
+// the flat key/value and list forms that note apps write are understood.
+type frontMatter map[string][]string
+
+func (fm frontMatter) get(key string) string {
+	if v := fm[key]; len(v) > 0 {
+		return v[0]
+	}
+	return ""
+}
+
+// parseFrontMatter splits a leading ---/--- block off a Markdown document.
+// Without a terminated block it returns the document unchanged.
+func parseFrontMatter(s string) (frontMatter, string) {
+	s = strings.TrimPrefix(s, "\ufeff")
+	lines := strings.SplitAfter(s, "\n")
+	if strings.TrimRight(lines[0], "\r\n") != "---" {
+		return nil, s
+	}
+	fm := frontMatter{}
+	offset := len(lines[0])
+	var last string
+	for _, line := range lines[1:] {
+		offset += len(line)
+		l := strings.TrimRight(line, "\r\n")
+		if l == "---" || l == "..." {
+			return fm, s[offset:]
+		}
+		trimmed := strings.TrimSpace(l)
+		switch {
+		case trimmed == "" || strings.HasPrefix(trimmed, "#"):
+		case strings.HasPrefix(trimmed, "- ") && last != "":
+			fm[last] = append(fm[last], yamlScalar(trimmed[2:]))
+		default:
+			key, value, ok := strings.Cut(l, ":")
+			if !ok {
+				continue
+			}
+			last = strings.ToLower(strings.TrimSpace(key))
+			value = strings.TrimSpace(value)
+			switch {
+			case value == "":
+				fm[last] = nil
+			case strings.HasPrefix(value, "[") && strings.HasSuffix(value, "]"):
+				fm[last] = yamlFlowList(value[1 : len(value)-1])
+			default:
+				fm[last] = []string{yamlScalar(value)}
+			}
+		}
+	}
+	return nil, s
+}
+
+// yamlFlowList splits the inside of [a, "b, c"] at commas outside quotes.
+func yamlFlowList(s string) []string {
+	var items []string
+	var quote byte
+	start := 0
+	for i := 0; i <= len(s); i++ {
+		if i < len(s) {
+			c := s[i]
+			switch {
+			case quote != 0 && c == '\\' && quote == '"':
+				i++
+				continue
+			case quote != 0 && c == quote:
+				quote = 0
+				continue
+			case quote == 0 && (c == '"' || c == '\''):
+				quote = c
+				continue
+			case quote != 0 || c != ',':
+				continue
+			}
+		}
+		if item := strings.TrimSpace(s[start:i]); item != "" {
+			items = append(items, yamlScalar(item))
+		}
+		start = i + 1
+	}
+	return items
+}
+
+// yamlScalar unquotes a single- or double-quoted YAML scalar. Double-quoted
+// escapes are read as Go escapes, which cover those noteMarkdown writes.
+func yamlScalar(v string) string {
+	if len(v) >= 2 && v[0] == '"' && v[len(v)-1] == '"' {
+		if s, err := strconv.Unquote(v); err == nil {
+			return s
+		}
+		return v[1 : len(v)-1]
+	}
+	if len(v) >= 2 && v[0] == '\'' && v[len(v)-1] == '\'' {
+		return strings.ReplaceAll(v[1:len(v)-1], "''", "'")
+	}
+	return v
+}
+
+// parseFrontMatterTime reads the created and modified times of front
+// matter: RFC 3339 as written by noteMarkdown, or a plain date.
+func parseFrontMatterTime(s string) (time.Time, bool) {
+	for _, layout := range []string{time.RFC3339Nano, time.DateOnly} {
+		if t, err := time.Parse(layout, strings.TrimSpace(s)); err == nil {
+			return t, true
+		}
+	}
+	return time.Time{}, false
+}
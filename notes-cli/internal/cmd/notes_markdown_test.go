@@ -0,0 +1,77 @@
+package cmd
+
+import (
+	"slices"
+	"testing"
+	"time"
+
+	"github.com/c0dev0id/notesd/notes-cli/internal/model"
+)
+
+func TestNoteMarkdownRoundTrip(t *testing.T) {
+	// Arrange
+	n := &model.Note{
+		ID:         "0197e0a4-5b6c-7d8e-9f00-112233445566",
+		Title:      `Quotes "and" colons: here`,
+		Content:    "# Heading\n\n---\n\nbody\n",
+		Type:       "note",
+		Tags:       []string{"work", "a, b"},
+		CreatedAt:  time.Date(2026, 3, 1, 8, 0, 0, 0, time.UTC),
+		ModifiedAt: time.Date(2026, 3, 4, 14, 30, 0, 123e6, time.UTC),
+	}
+
+	// Act
+	md := noteMarkdown(n)
+	fm, content := parseFrontMatter(md)
+
+	// Assert
+	t.Logf("markdown:\n%s", md)
+	if got := fm.get("id"); got != n.ID {
+		t.Errorf("id = %q, want %q", got, n.ID)
+	}
+	if got := fm.get("title"); got != n.Title {
+		t.Errorf("title = %q, want %q", got, n.Title)
+	}
+	if !slices.Equal(fm["tags"], n.Tags) {
+		t.Errorf("tags = %q, want %q", fm["tags"], n.Tags)
+	}
+	if got, ok := parseFrontMatterTime(fm.get("modified")); !ok || !got.Equal(n.ModifiedAt) {
+		t.Errorf("modified = %v, want %v", got, n.ModifiedAt)
+	}
+	if content != n.Content {
+		t.Errorf("content = %q, want %q", content, n.Content)
+	}
+}
+
+func TestParseFrontMatter(t *testing.T) {
+	cases := []struct {
+		name        string
+		input       string
+		wantTitle   string
+		wantTags    []string
+		wantContent string
+	}{
+		{"none", "just text\n", "", nil, "just text\n"},
+		{"unterminated", "---\ntitle: x\n", "", nil, "---\ntitle: x\n"},
+		{"block list", "---\ntitle: Plain\ntags:\n  - one\n  - 'two'\n---\nbody", "Plain", []string{"one", "two"}, "body"},
+		{"crlf", "---\r\ntitle: \"Win\"\r\n---\r\nbody\r\n", "Win", nil, "body\r\n"},
+	}
+	for _, c := range cases {
+		t.Run(c.name, func(t *testing.T) {
+			// Act
+			fm, content := parseFrontMatter(c.input)
+
+			// Assert
+			t.Logf("front matter %q, content %q", fm, content)
+			if got := fm.get("title"); got != c.wantTitle {
+				t.Errorf("title = %q, want %q", got, c.wantTitle)
+			}
+			if !slices.Equal(fm["tags"], c.wantTags) {
+				t.Errorf("tags = %q, want %q", fm["tags"], c.wantTags)
+			}
+			if content != c.wantContent {
+				t.Errorf("content = %q, want %q", content, c.wantContent)
+			}
+		})
+	}
+}
@@ -71,3 +71,44 @@ func TestParseEditorContent(t *testing.T) {
 		})
 	}
 }
+
+func TestSplitTitle(t *testing.T) {
+	cases := []struct {
+		input, title, content string
+	}{
+		{"# Meeting notes\n\n- agenda\n", "Meeting notes", "\n- agenda\n"},
+		{"Just a title", "Just a title", ""},
+		{"  plain  \nbody", "plain", "body"},
+		{"", "", ""},
+	}
+	for _, c := range cases {
+		// Act
+		title, content := splitTitle(c.input)
+
+		// Assert
+		t.Logf("%q -> title=%q content=%q", c.input, title, content)
+		if title != c.title || content != c.content {
+			t.Errorf("splitTitle(%q) = %q, %q; want %q, %q", c.input, title, content, c.title, c.content)
+		}
+	}
+}
+
+func TestAppendText(t *testing.T) {
+	cases := []struct {
+		content, text, want string
+	}{
+		{"", "new\n", "new\n"},
+		{"old", "new\n", "old\nnew\n"},
+		{"old\n", "new", "old\nnew"},
+	}
+	for _, c := range cases {
+		// Act
+		got := appendText(c.content, c.text)
+
+		// Assert
+		t.Logf("%q + %q -> %q", c.content, c.text, got)
+		if got != c.want {
+			t.Errorf("appendText(%q, %q) = %q, want %q", c.content, c.text, got, c.want)
+		}
+	}
+}
@@ -0,0 +1,143 @@
+package cmd
+
+import (
+	"encoding/csv"
+	"encoding/json"
+	"fmt"
+	"io"
+	"os"
+	"reflect"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/c0dev0id/notesd/notes-cli/internal/client"
+	"github.com/c0dev0id/notesd/notes-cli/internal/model"
+)
+
+// Output formats selected by --output or $NOTESD_OUTPUT. Table is the
+// layout meant for people; json and csv are for scripts and spreadsheets.
+const (
+	outputTable = "table"
+	outputJSON  = "json"
+	outputCSV   = "csv"
+)
+
+var outputFormat string
+
+func init() {
+	def := os.Getenv("NOTESD_OUTPUT")
+	if def == "" {
+		def = outputTable
+	}
+	rootCmd.PersistentFlags().StringVar(&outputFormat, "output", def,
+		"Output format of list and show commands: table, json or csv ($NOTESD_OUTPUT)")
+}
+
+func checkOutputFormat() error {
+	switch outputFormat {
+	case outputTable, outputJSON, outputCSV:
+		return nil
+	}
+	return fmt.Errorf("unknown output format %q (use table, json or csv)", outputFormat)
+}
+
+// printStructured prints v as JSON with --output json, or header and
+// records as CSV with --output csv, and reports whether it did; with table
+// output the caller prints its own layout. A nil header means the command
+// has no CSV form.
+func printStructured(v any, header []string, records [][]string) (bool, error) {
+	return writeStructured(os.Stdout, outputFormat, v, header, records)
+}
+
+func writeStructured(w io.Writer, format string, v any, header []string, records [][]string) (bool, error) {
+	switch format {
+	case outputJSON:
+		// An empty list is [] rather than null, so that jq can iterate it.
+		if rv := reflect.ValueOf(v); rv.Kind() == reflect.Slice && rv.IsNil() {
+			v = []struct{}{}
+		}
+		enc := json.NewEncoder(w)
+		enc.SetIndent("", "  ")
+		return true, enc.Encode(v)
+	case outputCSV:
+		if header == nil {
+			return true, fmt.Errorf("--output csv is not supported by this command")
+		}
+		cw := csv.NewWriter(w)
+		cw.Write(header)
+		cw.WriteAll(records)
+		return true, cw.Error()
+	}
+	return false, nil
+}
+
+var noteHeader = []string{"id", "title", "type", "tags", "snoozed_until", "modified_at", "created_at", "content"}
+
+func noteRecords(notes []model.Note) [][]string {
+	rs := make([][]string, len(notes))
+	for i, n := range notes {
+		rs[i] = []string{n.ID, n.Title, n.Type, strings.Join(n.Tags, ","), csvTime(n.SnoozedUntil),
+			csvTime(&n.ModifiedAt), csvTime(&n.CreatedAt), n.Content}
+	}
+	return rs
+}
+
+var todoHeader = []string{"id", "content", "completed", "due_date", "reminder_at", "note_id", "tags", "modified_at", "created_at"}
+
+func todoRecords(todos []model.Todo) [][]string {
+	rs := make([][]string, len(todos))
+	for i, t := range todos {
+		rs[i] = []string{t.ID, t.Content, strconv.FormatBool(t.Completed), csvDue(t.DueDate, t.AllDay), csvTime(t.ReminderAt),
+			csvString(t.NoteID), strings.Join(t.Tags, ","), csvTime(&t.ModifiedAt), csvTime(&t.CreatedAt)}
+	}
+	return rs
+}
+
+var searchHeader = []string{"type", "id", "title", "snippet", "note_id", "completed", "due_date", "modified_at"}
+
+func searchRecords(results []client.SearchResult) [][]string {
+	rs := make([][]string, len(results))
+	for i, r := range results {
+		completed := ""
+		if r.Completed != nil {
+			completed = strconv.FormatBool(*r.Completed)
+		}
+		rs[i] = []string{r.Type, r.ID, r.Title, r.Snippet, csvString(r.NoteID), completed, csvDue(r.DueDate, r.AllDay),
+			csvTime(&r.ModifiedAt)}
+	}
+	return rs
+}
+
+var activityHeader = []string{"at", "action", "entity_type", "entity_id", "title", "device_id"}
+
+func activityRecords(entries []client.ActivityEntry) [][]string {
+	rs := make([][]string, len(entries))
+	for i, e := range entries {
+		rs[i] = []string{csvTime(&e.At), e.Action, e.EntityType, e.EntityID, e.Title, e.DeviceID}
+	}
+	return rs
+}
+
+// csvTime formats t in RFC 3339, UTC; a missing time is empty.
+func csvTime(t *time.Time) string {
+	if t == nil {
+		return ""
+	}
+	return t.UTC().Format(time.RFC3339)
+}
+
+// csvDue renders a due date: YYYY-MM-DD when all-day, a time otherwise.
+func csvDue(due *time.Time, allDay bool) string {
+	if due != nil && allDay {
+		return due.UTC().Format(time.DateOnly)
+	}
+	return csvTime(due)
+}
+
+func csvString(s *string) string {
+	if s == nil {
+		return ""
+	}
+	return *s
+}
@@ -0,0 +1,68 @@
+package cmd
+
+import (
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/c0dev0id/notesd/notes-cli/internal/model"
+)
+
+func TestWriteStructured(t *testing.T) {
+	// Arrange
+	due := time.Date(2026, 3, 5, 0, 0, 0, 0, time.UTC)
+	created := time.Date(2026, 3, 1, 9, 30, 0, 0, time.UTC)
+	at := due.Add(14 * time.Hour)
+	todos := []model.Todo{{
+		ID: "t1", Content: "Buy milk, eggs", DueDate: &due, AllDay: true, Tags: []string{"home", "shop"},
+		ModifiedAt: created, CreatedAt: created,
+	}, {
+		ID: "t2", Content: "Call", DueDate: &at, Tags: []string{}, ModifiedAt: created, CreatedAt: created,
+	}}
+	cases := []struct {
+		name   string
+		format string
+		v      any
+		header []string
+		want   string
+		ok     bool
+	}{
+		{"table", outputTable, todos, todoHeader, "", false},
+		{"csv", outputCSV, todos, todoHeader,
+			"id,content,completed,due_date,reminder_at,note_id,tags,modified_at,created_at\n" +
+				`t1,"Buy milk, eggs",false,2026-03-05,,,"home,shop",2026-03-01T09:30:00Z,2026-03-01T09:30:00Z` + "\n" +
+				`t2,Call,false,2026-03-05T14:00:00Z,,,,2026-03-01T09:30:00Z,2026-03-01T09:30:00Z` + "\n", true},
+		{"json empty list", outputJSON, []model.Todo(nil), todoHeader, "[]\n", true},
+		{"json object", outputJSON, model.TagUsage{Name: "work", Notes: 2}, nil,
+			"{\n  \"name\": \"work\",\n  \"notes\": 2,\n  \"todos\": 0\n}\n", true},
+	}
+	for _, c := range cases {
+		var b strings.Builder
+
+		// Act
+		ok, err := writeStructured(&b, c.format, c.v, c.header, todoRecords(todos))
+
+		// Assert
+		t.Logf("%s: ok=%v %q", c.name, ok, b.String())
+		if err != nil {
+			t.Fatalf("%s: %v", c.name, err)
+		}
+		if ok != c.ok || b.String() != c.want {
+			t.Errorf("%s: got ok=%v %q, want ok=%v %q", c.name, ok, b.String(), c.ok, c.want)
+		}
+	}
+}
+
+func TestWriteStructuredNoCSV(t *testing.T) {
+	// Arrange
+	var b strings.Builder
+
+	// Act
+	ok, err := writeStructured(&b, outputCSV, struct{}{}, nil, nil)
+
+	// Assert
+	t.Logf("ok=%v err=%v", ok, err)
+	if !ok || err == nil {
+		t.Errorf("got ok=%v err=%v, want an error for a command without CSV output", ok, err)
+	}
+}
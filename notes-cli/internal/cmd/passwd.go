@@ -0,0 +1,25 @@
+package cmd
+
+import (
+	"fmt"
+
+	"github.com/spf13/cobra"
+)
+
+var passwdCmd = &cobra.Command{
+	Use:   "passwd",
+	Short: "Change your password and log out other devices",
+	RunE: func(cmd *cobra.Command, args []string) error {
+		current := promptPassword("Current password: ")
+		newPassword := promptPassword("New password: ")
+		if newPassword != promptPassword("Confirm new password: ") {
+			return fmt.Errorf("passwords do not match")
+		}
+
+		if err := cl.ChangePassword(current, newPassword); err != nil {
+			return fmt.Errorf("change password: %w", err)
+		}
+		fmt.Println("Password changed. Other devices have to log in again.")
+		return nil
+	},
+}
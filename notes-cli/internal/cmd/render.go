@@ -0,0 +1,146 @@
+package cmd
+
+import (
+	"os"
+	"regexp"
+	"strings"
+
+	"github.com/charmbracelet/lipgloss"
+	"golang.org/x/term"
+)
+
+// Markdown styles for `notes show --render`. lipgloss drops the colours when
+// output is not a terminal, leaving the plain layout.
+var (
+	mdHeading = lipgloss.NewStyle().Bold(true).Foreground(lipgloss.Color("12"))
+	mdH1      = mdHeading.Underline(true)
+	mdCode    = lipgloss.NewStyle().Foreground(lipgloss.Color("11"))
+	mdQuote   = lipgloss.NewStyle().Italic(true).Foreground(lipgloss.Color("8"))
+	mdMuted   = lipgloss.NewStyle().Foreground(lipgloss.Color("8"))
+	mdBold    = lipgloss.NewStyle().Bold(true)
+	mdItalic  = lipgloss.NewStyle().Italic(true)
+	mdStrike  = lipgloss.NewStyle().Strikethrough(true)
+	mdLink    = lipgloss.NewStyle().Underline(true).Foreground(lipgloss.Color("12"))
+	mdWiki    = lipgloss.NewStyle().Foreground(lipgloss.Color("10"))
+)
+
+var (
+	mdHeadingRe = regexp.MustCompile(`^(#{1,6})\s+(.*?)\s*#*\s*$`)
+	mdListRe    = regexp.MustCompile(`^(\s*)([-*+]|\d+[.)])\s+(?:\[([ xX])\]\s+)?(.*)$`)
+	mdRuleRe    = regexp.MustCompile(`^\s*(?:(?:-\s*){3,}|(?:\*\s*){3,}|(?:_\s*){3,})$`)
+	mdBoldRe    = regexp.MustCompile(`\*\*([^*]+)\*\*|__([^_]+)__`)
+	mdItalicRe  = regexp.MustCompile(`\*([^*\s][^*]*)\*|\b_([^_\s][^_]*)_\b`)
+	mdStrikeRe  = regexp.MustCompile(`~~([^~]+)~~`)
+	mdLinkRe    = regexp.MustCompile(`!?\[([^\]]*)\]\(([^)\s]+)\)`)
+	mdWikiRe    = regexp.MustCompile(`\[\[([^\[\]|]+)(?:\|([^\[\]]*))?\]\]`)
+)
+
+// renderMarkdown styles markdown for the terminal: headings, emphasis, code,
+// lists and task items, quotes, rules and links. Anything else is printed
+// as written.
+func renderMarkdown(src string) string {
+	var out []string
+	fence := ""
+	for _, line := range strings.Split(strings.TrimRight(src, "\n"), "\n") {
+		trimmed := strings.TrimSpace(line)
+		if fence != "" {
+			if strings.HasPrefix(trimmed, fence) {
+				fence = ""
+				continue
+			}
+			out = append(out, "    "+mdCode.Render(line))
+			continue
+		}
+		if strings.HasPrefix(trimmed, "```") || strings.HasPrefix(trimmed, "~~~") {
+			fence = trimmed[:3]
+			continue
+		}
+
+		switch {
+		case mdHeadingRe.MatchString(line):
+			m := mdHeadingRe.FindStringSubmatch(line)
+			style := mdHeading
+			if len(m[1]) == 1 {
+				style = mdH1
+			}
+			out = append(out, style.Render(m[2]))
+		case mdRuleRe.MatchString(line):
+			out = append(out, mdMuted.Render(strings.Repeat("─", termWidth())))
+		case strings.HasPrefix(trimmed, ">"):
+			text := strings.TrimSpace(strings.TrimLeft(trimmed, "> "))
+			out = append(out, mdMuted.Render("│ ")+mdQuote.Render(renderInline(text)))
+		case mdListRe.MatchString(line):
+			m := mdListRe.FindStringSubmatch(line)
+			marker := "•"
+			if m[2][0] >= '0' && m[2][0] <= '9' {
+				marker = m[2]
+			}
+			switch m[3] {
+			case " ":
+				marker = "☐"
+			case "x", "X":
+				marker = "☑"
+			}
+			out = append(out, m[1]+marker+" "+renderInline(m[4]))
+		default:
+			out = append(out, renderInline(line))
+		}
+	}
+	return strings.Join(out, "\n")
+}
+
+// renderInline styles the inline markup of one line. Code spans are left
+// untouched apart from their colour.
+func renderInline(s string) string {
+	parts := strings.Split(s, "`")
+	for i, p := range parts {
+		if i%2 == 1 && i < len(parts)-1 {
+			parts[i] = mdCode.Render(p)
+			continue
+		}
+		if i%2 == 1 {
+			// Unclosed backtick
+			p = "`" + p
+		}
+		p = mdWikiRe.ReplaceAllStringFunc(p, func(m string) string {
+			sub := mdWikiRe.FindStringSubmatch(m)
+			label := sub[1]
+			if sub[2] != "" {
+				label = sub[2]
+			}
+			return mdWiki.Render(label)
+		})
+		p = mdLinkRe.ReplaceAllStringFunc(p, func(m string) string {
+			sub := mdLinkRe.FindStringSubmatch(m)
+			if sub[1] == "" || sub[1] == sub[2] {
+				return mdLink.Render(sub[2])
+			}
+			return mdLink.Render(sub[1]) + mdMuted.Render(" ("+sub[2]+")")
+		})
+		p = replaceStyled(p, mdBoldRe, mdBold)
+		p = replaceStyled(p, mdStrikeRe, mdStrike)
+		p = replaceStyled(p, mdItalicRe, mdItalic)
+		parts[i] = p
+	}
+	return strings.Join(parts, "")
+}
+
+// replaceStyled renders the text captured by the first matching group of re
+// with style, dropping the markup around it.
+func replaceStyled(s string, re *regexp.Regexp, style lipgloss.Style) string {
+	return re.ReplaceAllStringFunc(s, func(m string) string {
+		for _, g := range re.FindStringSubmatch(m)[1:] {
+			if g != "" {
+				return style.Render(g)
+			}
+		}
+		return m
+	})
+}
+
+func termWidth() int {
+	if w, _, err := term.GetSize(int(os.Stdout.Fd())); err == nil && w > 0 {
+		return min(w, 80)
+	}
+	return 40
+}
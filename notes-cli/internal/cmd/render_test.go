@@ -0,0 +1,58 @@
+package cmd
+
+import (
+	"strings"
+	"testing"
+)
+
+func TestRenderMarkdown(t *testing.T) {
+	// Arrange: output is not a terminal in tests, so styles render as
+	// plain text and only the layout is checked.
+	src := strings.Join([]string{
+		"# Plan ##",
+		"Some **bold**, *italic*, ~~gone~~ and `a*b*c` text with snake_case_name.",
+		"- [ ] open task",
+		"- [x] done task",
+		"  * nested",
+		"1. first",
+		"> quoted [[Other Note|other]]",
+		"---",
+		"```go",
+		"# not a heading",
+		"```",
+		"See [the docs](https://example.com/docs).",
+	}, "\n")
+
+	// Act
+	got := renderMarkdown(src)
+
+	// Assert
+	t.Logf("rendered:\n%s", got)
+	want := []string{
+		"Plan",
+		"Some bold, italic, gone and a*b*c text with snake_case_name.",
+		"☐ open task",
+		"☑ done task",
+		"  • nested",
+		"1. first",
+		"│ quoted other",
+		"    # not a heading",
+		"See the docs (https://example.com/docs).",
+	}
+	lines := strings.Split(got, "\n")
+	for _, w := range want {
+		found := false
+		for _, l := range lines {
+			if l == w {
+				found = true
+				break
+			}
+		}
+		if !found {
+			t.Errorf("missing line %q", w)
+		}
+	}
+	if strings.Contains(got, "```") {
+		t.Error("code fences should be dropped")
+	}
+}
@@ -3,7 +3,6 @@ package cmd
 import (
 	"fmt"
 	"os"
-	"path/filepath"
 
 	"github.com/c0dev0id/notesd/notes-cli/internal/client"
 	"github.com/c0dev0id/notesd/notes-cli/internal/store"
@@ -20,9 +19,16 @@ var rootCmd = &cobra.Command{
 	Short:        "notes-cli — offline-first notes and todo client",
 	SilenceUsage: true,
 	PersistentPreRunE: func(cmd *cobra.Command, args []string) error {
+		if err := checkOutputFormat(); err != nil {
+			return err
+		}
 		if cmd.Name() == "login" || cmd.Name() == "register" {
 			return nil
 		}
+		// Printing a completion script needs no account.
+		if cmd.HasParent() && cmd.Parent().Name() == "completion" {
+			return nil
+		}
 		var err error
 		cl, err = client.New()
 		if err != nil {
@@ -32,8 +38,7 @@ var rootCmd = &cobra.Command{
 			return fmt.Errorf("not logged in — run: notes-cli login")
 		}
 
-		dbPath := filepath.Join(cl.ConfigDir(), "notes.db")
-		st, err = store.Open(dbPath)
+		st, err = store.OpenCache(cl.ConfigDir())
 		if err != nil {
 			return fmt.Errorf("open local store: %w", err)
 		}
@@ -52,10 +57,23 @@ func init() {
 	rootCmd.AddCommand(loginCmd)
 	rootCmd.AddCommand(registerCmd)
 	rootCmd.AddCommand(logoutCmd)
+	rootCmd.AddCommand(passwdCmd)
+	rootCmd.AddCommand(tokenCmd)
+	rootCmd.AddCommand(addCmd)
 	rootCmd.AddCommand(notesCmd)
 	rootCmd.AddCommand(todosCmd)
 	rootCmd.AddCommand(searchCmd)
+	rootCmd.AddCommand(grepCmd)
+	rootCmd.AddCommand(journalCmd)
+	rootCmd.AddCommand(bookmarkCmd)
+	rootCmd.AddCommand(tagsCmd)
 	rootCmd.AddCommand(syncCmd)
+	rootCmd.AddCommand(fsSyncCmd)
+	rootCmd.AddCommand(statusCmd)
+	rootCmd.AddCommand(statsCmd)
+	rootCmd.AddCommand(activityCmd)
+	rootCmd.AddCommand(exportCmd)
+	rootCmd.AddCommand(importCmd)
 }
 
 func userID() string {
@@ -66,12 +84,23 @@ func userID() string {
 }
 
 // syncQuietly runs a sync after a write command. Errors go to stderr; success
-// is silent so as not to clutter command output.
+// is silent so as not to clutter command output. When the server is
+// unreachable the change stays queued for the next sync.
 func syncQuietly() {
 	if sy == nil {
 		return
 	}
-	if _, err := sy.Sync(); err != nil {
+	res, err := sy.Sync()
+	if sync.IsOffline(err) {
+		n, _ := st.PendingCount()
+		fmt.Fprintf(os.Stderr, "offline: %d change(s) queued, run notes-cli sync when connected\n", n)
+		return
+	}
+	if err != nil {
 		fmt.Fprintf(os.Stderr, "sync: %v\n", err)
+		return
+	}
+	for _, c := range res.Conflicts {
+		fmt.Fprintf(os.Stderr, "sync: local change to %s %s (%q) lost to a newer server version\n", c.Type, c.ID, c.Title)
 	}
 }
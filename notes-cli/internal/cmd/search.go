@@ -2,41 +2,112 @@ package cmd
 
 import (
 	"fmt"
+	"os"
 	"strings"
 
+	"github.com/c0dev0id/notesd/notes-cli/internal/client"
+	"github.com/c0dev0id/notesd/notes-cli/internal/store"
 	"github.com/spf13/cobra"
 )
 
 var searchCmd = &cobra.Command{
 	Use:   "search <query>",
-	Short: "Search notes by title and content",
-	Args:  cobra.MinimumNArgs(1),
-	RunE:  runSearch,
+	Short: "Search notes and todos",
+	Long: `Searches notes and todos on the server and lists them together, best
+matches first. The query may use operators:
+
+  tag:work  type:note|todo|todo_list  title:word  before:2026-01-31
+  after:2026-01-01  "quoted phrase"  -word (negation)
+
+With --offline, or when the server cannot be reached, the local store is
+searched instead, for the query as plain text: notes through the full-text
+index, which covers every synced note and is updated as notes are edited
+and synced, followed by todos.`,
+	Args: cobra.MinimumNArgs(1),
+	RunE: runSearch,
 }
 
 func init() {
 	searchCmd.Flags().IntP("limit", "l", 20, "Number of results")
+	searchCmd.Flags().Bool("offline", false, "Search the local store without contacting the server")
 }
 
 func runSearch(cmd *cobra.Command, args []string) error {
 	query := strings.Join(args, " ")
 	limit, _ := cmd.Flags().GetInt("limit")
+	offline, _ := cmd.Flags().GetBool("offline")
 
-	notes, total, err := st.SearchNotes(userID(), query, limit, 0)
-	if err != nil {
+	var res *client.SearchResults
+	if !offline {
+		var err error
+		if res, err = cl.Search(query, limit); err != nil {
+			fmt.Fprintf(os.Stderr, "server search failed (%v), using local index\n", err)
+			offline = true
+		}
+	}
+	if offline {
+		var err error
+		if res, err = searchLocal(query, limit); err != nil {
+			return err
+		}
+	}
+	if ok, err := printStructured(res.Results, searchHeader, searchRecords(res.Results)); ok {
 		return err
 	}
-	if len(notes) == 0 {
+	if len(res.Results) == 0 {
 		fmt.Println("No results.")
 		return nil
 	}
-	fmt.Printf("Found %d notes matching %q:\n\n", total, query)
+	fmt.Printf("Found %d results matching %q:\n\n", res.Total, query)
+	for _, r := range res.Results {
+		printSearchResult(r)
+	}
+	return nil
+}
+
+// searchLocal searches the local store: notes by relevance, then todos
+// most recently modified first, up to limit results in all.
+func searchLocal(query string, limit int) (*client.SearchResults, error) {
+	notes, noteTotal, err := st.SearchNotes(userID(), query, store.NoteFilter{}, limit, 0)
+	if err != nil {
+		return nil, err
+	}
+	todos, todoTotal, err := st.ListTodos(userID(), store.TodoFilter{Contains: query}, limit-len(notes), 0)
+	if err != nil {
+		return nil, err
+	}
+
+	res := &client.SearchResults{Total: noteTotal + todoTotal}
 	for _, n := range notes {
-		title := n.Title
-		if title == "" {
-			title = "(untitled)"
+		res.Results = append(res.Results, client.SearchResult{
+			Type: "note", ID: n.ID, Title: n.Title, ModifiedAt: n.ModifiedAt,
+		})
+	}
+	for _, t := range todos {
+		res.Results = append(res.Results, client.SearchResult{
+			Type: "todo", ID: t.ID, Title: t.Content, NoteID: t.NoteID,
+			Completed: &t.Completed, DueDate: t.DueDate, AllDay: t.AllDay, ModifiedAt: t.ModifiedAt,
+		})
+	}
+	return res, nil
+}
+
+// printSearchResult prints a result on one line, todos with their check
+// box, followed by the snippet when it adds to the title.
+func printSearchResult(r client.SearchResult) {
+	title := r.Title
+	if r.Type == "todo" && r.Completed != nil {
+		check := "[ ] "
+		if *r.Completed {
+			check = "[x] "
 		}
-		fmt.Printf("%-38s  %s  %s\n", n.ID, n.ModifiedAt.Local().Format("2006-01-02"), title)
+		title = check + title
+	}
+	if title == "" {
+		title = "(untitled)"
+	}
+	fmt.Printf("%-4s  %-38s  %s  %s\n", r.Type, r.ID, r.ModifiedAt.Local().Format("2006-01-02"), title)
+	if r.Snippet != "" && r.Snippet != r.Title {
+		fmt.Printf("      %s\n", r.Snippet)
 	}
-	return nil
 }
@@ -0,0 +1,78 @@
+package cmd
+
+import (
+	"fmt"
+	"sort"
+	"strings"
+
+	"github.com/spf13/cobra"
+)
+
+var statsCmd = &cobra.Command{
+	Use:   "stats",
+	Short: "Show note and todo counts, words written per week and top tags",
+	Long: `Show statistics computed by the server: notes by type, open, overdue and
+completed todos, storage used, the words in the notes created each week
+and the most used tags. Changes not yet synced are not included.`,
+	Args: cobra.NoArgs,
+	RunE: runStats,
+}
+
+func init() {
+	statsCmd.Flags().IntP("weeks", "w", 8, "Number of weeks of writing activity to show")
+	statsCmd.Flags().IntP("tags", "t", 5, "Number of top tags to show")
+}
+
+func runStats(cmd *cobra.Command, args []string) error {
+	weeks, _ := cmd.Flags().GetInt("weeks")
+	tags, _ := cmd.Flags().GetInt("tags")
+	s, err := cl.Stats(weeks, tags)
+	if err != nil {
+		return err
+	}
+	if ok, err := printStructured(s, nil, nil); ok {
+		return err
+	}
+
+	var total int64
+	types := make([]string, 0, len(s.NotesByType))
+	for t, n := range s.NotesByType {
+		total += n
+		types = append(types, t)
+	}
+	sort.Strings(types)
+	for i, t := range types {
+		types[i] = fmt.Sprintf("%d %s", s.NotesByType[t], t)
+	}
+	fmt.Printf("Notes:     %d", total)
+	if len(types) > 0 {
+		fmt.Printf(" (%s)", strings.Join(types, ", "))
+	}
+	fmt.Println()
+	fmt.Printf("Todos:     %d open (%d overdue), %d completed\n", s.Todos.Open, s.Todos.Overdue, s.Todos.Completed)
+	fmt.Printf("Storage:   %d bytes of text, %d bytes of attachments\n", s.ContentBytes, s.AttachmentBytes)
+
+	if len(s.WordsPerWeek) > 0 {
+		var most int64
+		for _, w := range s.WordsPerWeek {
+			most = max(most, w.Words)
+		}
+		fmt.Println("Words written per week:")
+		for _, w := range s.WordsPerWeek {
+			bar := 0
+			if most > 0 {
+				bar = int(w.Words * 30 / most)
+			}
+			line := fmt.Sprintf("  %s  %7d  %s", w.Week, w.Words, strings.Repeat("#", bar))
+			fmt.Println(strings.TrimRight(line, " "))
+		}
+	}
+
+	if len(s.TopTags) > 0 {
+		fmt.Println("Top tags:")
+		for _, t := range s.TopTags {
+			fmt.Printf("  %-30s  %d notes, %d todos\n", t.Name, t.Notes, t.Todos)
+		}
+	}
+	return nil
+}
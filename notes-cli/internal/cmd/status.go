@@ -0,0 +1,57 @@
+package cmd
+
+import (
+	"fmt"
+	"strings"
+	"time"
+
+	"github.com/spf13/cobra"
+)
+
+var statusCmd = &cobra.Command{
+	Use:   "status",
+	Short: "Show account, sync and server version information",
+	RunE:  runStatus,
+}
+
+func runStatus(cmd *cobra.Command, args []string) error {
+	s := cl.SessionInfo()
+	fmt.Printf("Server:    %s\n", cl.BaseURL)
+	fmt.Printf("User:      %s (%s)\n", s.Email, s.DisplayName)
+	fmt.Printf("Device:    %s\n", cl.DeviceID())
+
+	last, err := st.GetLastSyncAt()
+	if err != nil {
+		return err
+	}
+	if last == 0 {
+		fmt.Println("Last sync: never")
+	} else {
+		fmt.Printf("Last sync: %s\n", time.UnixMilli(last).Local().Format(time.RFC3339))
+	}
+	pending, err := st.PendingCount()
+	if err != nil {
+		return err
+	}
+	fmt.Printf("Pending:   %d change(s)\n", pending)
+
+	v, err := cl.ServerVersion()
+	if err != nil {
+		fmt.Printf("Version:   unavailable (%v)\n", err)
+		return nil
+	}
+	version := v.Version
+	if v.Commit != "" {
+		version += " (" + shortCommit(v.Commit) + ")"
+	}
+	fmt.Printf("Version:   %s, %s, schema %d\n", version, v.GoVersion, v.SchemaVersion)
+	fmt.Printf("Features:  %s\n", strings.Join(v.Capabilities, ", "))
+	return nil
+}
+
+func shortCommit(c string) string {
+	if len(c) > 12 {
+		return c[:12]
+	}
+	return c
+}
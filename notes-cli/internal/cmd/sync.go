@@ -10,10 +10,24 @@ import (
 var syncCmd = &cobra.Command{
 	Use:   "sync",
 	Short: "Synchronise local store with the server",
-	Long: `Pull server changes, push local changes, and resolve any conflicts.
-Prints a detailed summary of what was transferred.`,
+	Long: `Pull server changes, push queued local changes, and resolve any
+conflicts. Prints a detailed summary of what was transferred, including
+local changes discarded in favour of a newer server version.
+
+With --verify, the local store is instead compared with the server's list
+of item versions, and only the notes and todos that differ are fetched.
+This repairs a store that has drifted, e.g. after restoring it from a
+backup, without downloading everything.`,
 	RunE: func(cmd *cobra.Command, args []string) error {
-		result, err := sy.Sync()
+		run := sy.Sync
+		if verify, _ := cmd.Flags().GetBool("verify"); verify {
+			run = sy.Verify
+		}
+		result, err := run()
+		if internalsync.IsOffline(err) {
+			n, _ := st.PendingCount()
+			return fmt.Errorf("server unreachable, %d change(s) remain queued: %w", n, err)
+		}
 		if err != nil {
 			return fmt.Errorf("sync failed: %w", err)
 		}
@@ -21,3 +35,7 @@ Prints a detailed summary of what was transferred.`,
 		return nil
 	},
 }
+
+func init() {
+	syncCmd.Flags().Bool("verify", false, "Compare with the server and fetch only the items that differ")
+}
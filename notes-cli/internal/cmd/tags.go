@@ -0,0 +1,38 @@
+package cmd
+
+import (
+	"fmt"
+	"strconv"
+
+	"github.com/spf13/cobra"
+)
+
+var tagsCmd = &cobra.Command{
+	Use:   "tags",
+	Short: "List tags with the number of notes and todos using them",
+	Args:  cobra.NoArgs,
+	RunE:  runTags,
+}
+
+func runTags(cmd *cobra.Command, args []string) error {
+	tags, err := st.ListTags(userID())
+	if err != nil {
+		return err
+	}
+	records := make([][]string, len(tags))
+	for i, t := range tags {
+		records[i] = []string{t.Name, strconv.Itoa(t.Notes), strconv.Itoa(t.Todos)}
+	}
+	if ok, err := printStructured(tags, []string{"name", "notes", "todos"}, records); ok {
+		return err
+	}
+	if len(tags) == 0 {
+		fmt.Println("No tags.")
+		return nil
+	}
+	fmt.Printf("%-30s  %5s  %5s\n", "TAG", "NOTES", "TODOS")
+	for _, t := range tags {
+		fmt.Printf("%-30s  %5d  %5d\n", t.Name, t.Notes, t.Todos)
+	}
+	return nil
+}
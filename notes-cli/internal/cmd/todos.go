@@ -6,6 +6,7 @@ import (
 	"time"
 
 	"github.com/c0dev0id/notesd/notes-cli/internal/model"
+	"github.com/c0dev0id/notesd/notes-cli/internal/store"
 	"github.com/spf13/cobra"
 )
 
@@ -20,6 +21,15 @@ var todosListCmd = &cobra.Command{
 	RunE:  runTodosList,
 }
 
+var todosSearchCmd = &cobra.Command{
+	Use:   "search <query>",
+	Short: "Search todos by content",
+	Long: `Searches the content of the todos in the local store, open ones unless
+--all or --done is given.`,
+	Args: cobra.MinimumNArgs(1),
+	RunE: runTodosSearch,
+}
+
 var todosShowCmd = &cobra.Command{
 	Use:   "show <id>",
 	Short: "Show a todo",
@@ -35,28 +45,64 @@ var todosCreateCmd = &cobra.Command{
 }
 
 var todosCompleteCmd = &cobra.Command{
-	Use:   "complete <id>",
-	Short: "Mark a todo as completed",
-	Args:  cobra.ExactArgs(1),
-	RunE:  runTodosComplete,
+	Use:   "complete <id>...",
+	Short: "Mark todos as completed",
+	Long: `Marks the given todos as completed. Without IDs, or with -, the IDs are
+read from stdin, one per line. Every todo is looked up first; if one is
+missing, none is changed.`,
+	RunE: runTodosComplete,
+}
+
+var todosUncompleteCmd = &cobra.Command{
+	Use:   "uncomplete <id>...",
+	Short: "Mark todos as open again",
+	Long:  `Marks the given todos as not completed. IDs are taken as by complete.`,
+	RunE:  runTodosUncomplete,
 }
 
 var todosDeleteCmd = &cobra.Command{
-	Use:   "delete <id>",
-	Short: "Delete a todo",
-	Args:  cobra.ExactArgs(1),
-	RunE:  runTodosDelete,
+	Use:   "delete <id>...",
+	Short: "Delete todos",
+	Long: `Deletes the given todos. Without IDs, or with -, the IDs are read from
+stdin, one per line. Every todo is looked up first; if one is missing,
+none is deleted.`,
+	RunE: runTodosDelete,
 }
 
 func init() {
-	todosCmd.AddCommand(todosListCmd, todosShowCmd, todosCreateCmd, todosCompleteCmd, todosDeleteCmd)
+	todosCmd.AddCommand(todosListCmd, todosSearchCmd, todosShowCmd, todosCreateCmd, todosCompleteCmd, todosUncompleteCmd,
+		todosDeleteCmd)
 
 	todosListCmd.Flags().Bool("overdue", false, "Show only overdue todos")
 	todosListCmd.Flags().IntP("limit", "l", 20, "Number of todos to show")
 	todosListCmd.Flags().IntP("offset", "o", 0, "Offset for pagination")
+	todosListCmd.Flags().Bool("today", false, "Show only todos due today")
+	todosListCmd.Flags().Bool("week", false, "Show only todos due in the seven days starting today")
+	todosListCmd.Flags().String("due-after", "", "Show only todos due on or after this date (YYYY-MM-DD, \"today\", \"fri\", ...)")
+	todosListCmd.Flags().String("due-before", "", "Show only todos due before this date (YYYY-MM-DD, \"next week\", ...)")
+	todosListCmd.Flags().Bool("all", false, "Show open and completed todos")
+	todosListCmd.Flags().Bool("open", false, "Show only open todos (the default)")
+	todosListCmd.Flags().Bool("done", false, "Show only completed todos")
+	todosListCmd.Flags().String("note", "", "Show only todos attached to this note ID")
+	todosListCmd.RegisterFlagCompletionFunc("note", completeNoteIDs)
+	todosListCmd.Flags().Bool("orphaned", false, "Show only todos whose note was deleted")
+	todosListCmd.MarkFlagsMutuallyExclusive("note", "orphaned")
+	todosListCmd.MarkFlagsMutuallyExclusive("overdue", "today", "week")
+	todosListCmd.MarkFlagsMutuallyExclusive("all", "open", "done")
 
-	todosCreateCmd.Flags().StringP("due", "d", "", "Due date (YYYY-MM-DD)")
+	todosSearchCmd.Flags().IntP("limit", "l", 20, "Number of results")
+	todosSearchCmd.Flags().String("due-after", "", "Show only todos due on or after this date (YYYY-MM-DD, \"today\", \"fri\", ...)")
+	todosSearchCmd.Flags().String("due-before", "", "Show only todos due before this date (YYYY-MM-DD, \"next week\", ...)")
+	todosSearchCmd.Flags().Bool("all", false, "Search open and completed todos")
+	todosSearchCmd.Flags().Bool("open", false, "Search only open todos (the default)")
+	todosSearchCmd.Flags().Bool("done", false, "Search only completed todos")
+	todosSearchCmd.MarkFlagsMutuallyExclusive("all", "open", "done")
+
+	todosCreateCmd.Flags().StringP("due", "d", "", `Due date, with an optional time ("tomorrow", "fri 14:00", "in 3 days", "jan 15" or YYYY-MM-DD)`)
 	todosCreateCmd.Flags().String("note", "", "Attach to note ID")
+	todosCreateCmd.RegisterFlagCompletionFunc("note", completeNoteIDs)
+	todosCreateCmd.Flags().String("remind", "", `Reminder time, local ("tomorrow 9am", "fri 17:30", "in 2 hours")`)
+	todosCreateCmd.Flags().StringArrayP("item", "i", nil, "Add a checklist item (repeatable)")
 }
 
 func runTodosList(cmd *cobra.Command, args []string) error {
@@ -66,6 +112,9 @@ func runTodosList(cmd *cobra.Command, args []string) error {
 		if err != nil {
 			return err
 		}
+		if ok, err := printStructured(todos, todoHeader, todoRecords(todos)); ok {
+			return err
+		}
 		if len(todos) == 0 {
 			fmt.Println("No overdue todos.")
 			return nil
@@ -76,10 +125,24 @@ func runTodosList(cmd *cobra.Command, args []string) error {
 
 	limit, _ := cmd.Flags().GetInt("limit")
 	offset, _ := cmd.Flags().GetInt("offset")
-	todos, total, err := st.ListTodos(userID(), limit, offset)
+	f, err := dueFilter(cmd)
+	if err != nil {
+		return err
+	}
+	statusFilter(cmd, &f)
+	if ref, _ := cmd.Flags().GetString("note"); ref != "" {
+		if f.NoteID, err = noteID(ref); err != nil {
+			return err
+		}
+	}
+	f.Orphaned, _ = cmd.Flags().GetBool("orphaned")
+	todos, total, err := st.ListTodos(userID(), f, limit, offset)
 	if err != nil {
 		return err
 	}
+	if ok, err := printStructured(todos, todoHeader, todoRecords(todos)); ok {
+		return err
+	}
 	if len(todos) == 0 {
 		fmt.Println("No todos.")
 		return nil
@@ -91,11 +154,83 @@ func runTodosList(cmd *cobra.Command, args []string) error {
 	return nil
 }
 
+func runTodosSearch(cmd *cobra.Command, args []string) error {
+	query := strings.Join(args, " ")
+	limit, _ := cmd.Flags().GetInt("limit")
+	f, err := dueFilter(cmd)
+	if err != nil {
+		return err
+	}
+	statusFilter(cmd, &f)
+	f.Contains = query
+	todos, total, err := st.ListTodos(userID(), f, limit, 0)
+	if err != nil {
+		return err
+	}
+	if ok, err := printStructured(todos, todoHeader, todoRecords(todos)); ok {
+		return err
+	}
+	if len(todos) == 0 {
+		fmt.Println("No results.")
+		return nil
+	}
+	fmt.Printf("Found %d todos matching %q:\n\n", total, query)
+	printTodos(todos)
+	return nil
+}
+
+// dueFilter builds the due-date filter of `todos list` from its flags. The
+// filter takes days as midnight UTC, so today is the local date at
+// midnight UTC, and a time given in --due-after or --due-before stands for
+// its day.
+func dueFilter(cmd *cobra.Command) (store.TodoFilter, error) {
+	var f store.TodoFilter
+	y, m, d := time.Now().Date()
+	today := time.Date(y, m, d, 0, 0, 0, 0, time.UTC)
+	if on, _ := cmd.Flags().GetBool("today"); on {
+		end := today.AddDate(0, 0, 1)
+		f.DueAfter, f.DueBefore = &today, &end
+	}
+	if on, _ := cmd.Flags().GetBool("week"); on {
+		end := today.AddDate(0, 0, 7)
+		f.DueAfter, f.DueBefore = &today, &end
+	}
+	for _, b := range []struct {
+		flag string
+		dst  **time.Time
+	}{{"due-after", &f.DueAfter}, {"due-before", &f.DueBefore}} {
+		s, _ := cmd.Flags().GetString(b.flag)
+		if s == "" {
+			continue
+		}
+		t, allDay, err := parseDue(s, time.Now())
+		if err != nil {
+			return f, fmt.Errorf("invalid --%s date: %w", b.flag, err)
+		}
+		day := dueDay(t, allDay)
+		*b.dst = &day
+	}
+	return f, nil
+}
+
+// statusFilter applies --all, --open and --done to f; without any of them
+// only open todos are listed.
+func statusFilter(cmd *cobra.Command, f *store.TodoFilter) {
+	if all, _ := cmd.Flags().GetBool("all"); all {
+		return
+	}
+	done, _ := cmd.Flags().GetBool("done")
+	f.Completed = &done
+}
+
 func runTodosShow(cmd *cobra.Command, args []string) error {
-	t, err := st.GetTodo(args[0], userID())
+	t, err := getTodo(args[0])
 	if err != nil {
 		return err
 	}
+	if ok, err := printStructured(t, todoHeader, todoRecords([]model.Todo{*t})); ok {
+		return err
+	}
 	check := "[ ]"
 	if t.Completed {
 		check = "[x]"
@@ -104,11 +239,24 @@ func runTodosShow(cmd *cobra.Command, args []string) error {
 	fmt.Printf("Status:    %s\n", check)
 	fmt.Printf("Content:   %s\n", t.Content)
 	if t.DueDate != nil {
-		fmt.Printf("Due:       %s\n", t.DueDate.Local().Format("2006-01-02"))
+		fmt.Printf("Due:       %s\n", t.DueText())
+	}
+	if t.ReminderAt != nil {
+		fmt.Printf("Remind:    %s\n", t.ReminderAt.Local().Format("2006-01-02 15:04"))
 	}
 	if t.NoteID != nil {
 		fmt.Printf("Note:      %s\n", *t.NoteID)
 	}
+	if len(t.Checklist) > 0 {
+		fmt.Printf("Checklist: %s done\n", t.ChecklistText())
+		for i, it := range t.Checklist {
+			check := "[ ]"
+			if it.Done {
+				check = "[x]"
+			}
+			fmt.Printf("           %d. %s %s\n", i+1, check, it.Text)
+		}
+	}
 	fmt.Printf("Modified:  %s\n", t.ModifiedAt.Local().Format(time.RFC3339))
 	fmt.Printf("Created:   %s\n", t.CreatedAt.Local().Format(time.RFC3339))
 	return nil
@@ -129,16 +277,35 @@ func runTodosCreate(cmd *cobra.Command, args []string) error {
 
 	dueStr, _ := cmd.Flags().GetString("due")
 	if dueStr != "" {
-		due, err := time.Parse("2006-01-02", dueStr)
+		due, allDay, err := parseDue(dueStr, time.Now())
+		if err != nil {
+			return fmt.Errorf("invalid due date: %w", err)
+		}
+		t.DueDate, t.AllDay = &due, allDay
+	}
+
+	remindStr, _ := cmd.Flags().GetString("remind")
+	if remindStr != "" {
+		remind, err := parseReminder(remindStr, time.Now())
+		if err != nil {
+			return fmt.Errorf("invalid reminder time: %w", err)
+		}
+		t.ReminderAt = &remind
+	}
+
+	if ref, _ := cmd.Flags().GetString("note"); ref != "" {
+		id, err := noteID(ref)
 		if err != nil {
-			return fmt.Errorf("invalid due date (use YYYY-MM-DD): %w", err)
+			return err
 		}
-		t.DueDate = &due
+		t.NoteID = &id
 	}
 
-	noteID, _ := cmd.Flags().GetString("note")
-	if noteID != "" {
-		t.NoteID = &noteID
+	items, _ := cmd.Flags().GetStringArray("item")
+	for _, text := range items {
+		if text = strings.TrimSpace(text); text != "" {
+			t.Checklist = append(t.Checklist, model.ChecklistItem{ID: model.NewID(), Text: text})
+		}
 	}
 
 	if err := st.CreateTodo(t); err != nil {
@@ -150,41 +317,91 @@ func runTodosCreate(cmd *cobra.Command, args []string) error {
 }
 
 func runTodosComplete(cmd *cobra.Command, args []string) error {
-	t, err := st.GetTodo(args[0], userID())
+	todos, err := todoArgs(args)
 	if err != nil {
 		return err
 	}
-	t.Completed = true
-	t.ModifiedAt = model.NowMillis()
-	t.ModifiedByDevice = cl.DeviceID()
-	if err := st.UpdateTodo(t); err != nil {
+	now := model.NowMillis()
+	for _, t := range todos {
+		t.Completed = true
+		t.ModifiedAt = now
+		t.ModifiedByDevice = cl.DeviceID()
+		if err := st.UpdateTodo(t); err != nil {
+			return err
+		}
+		fmt.Printf("Completed: %s\n", t.Content)
+	}
+	go syncQuietly()
+	return nil
+}
+
+func runTodosUncomplete(cmd *cobra.Command, args []string) error {
+	todos, err := todoArgs(args)
+	if err != nil {
 		return err
 	}
-	fmt.Printf("Completed: %s\n", t.Content)
+	now := model.NowMillis()
+	for _, t := range todos {
+		t.Completed = false
+		t.ModifiedAt = now
+		t.ModifiedByDevice = cl.DeviceID()
+		if err := st.UpdateTodo(t); err != nil {
+			return err
+		}
+		fmt.Printf("Reopened: %s\n", t.Content)
+	}
 	go syncQuietly()
 	return nil
 }
 
 func runTodosDelete(cmd *cobra.Command, args []string) error {
-	now := model.NowMillis()
-	if err := st.DeleteTodo(args[0], userID(), now.UnixMilli(), cl.DeviceID()); err != nil {
+	todos, err := todoArgs(args)
+	if err != nil {
 		return err
 	}
-	fmt.Printf("Deleted todo %s\n", args[0])
+	now := model.NowMillis()
+	for _, t := range todos {
+		if err := st.DeleteTodo(t.ID, userID(), now.UnixMilli(), cl.DeviceID()); err != nil {
+			return err
+		}
+		fmt.Printf("Deleted todo %s\n", t.ID)
+	}
 	go syncQuietly()
 	return nil
 }
 
+// todoArgs looks up the todos named by args (see idArgs), failing if any
+// is missing.
+func todoArgs(args []string) ([]*model.Todo, error) {
+	ids, err := idArgs(args)
+	if err != nil {
+		return nil, err
+	}
+	todos := make([]*model.Todo, len(ids))
+	for i, id := range ids {
+		if todos[i], err = getTodo(id); err != nil {
+			return nil, fmt.Errorf("todo %s: %w", id, err)
+		}
+	}
+	return todos, nil
+}
+
+// printTodos lists todos one per line, the due column as wide as the
+// widest due date: a day, or a day and time.
 func printTodos(todos []model.Todo) {
+	width := len(time.DateOnly)
+	for i := range todos {
+		width = max(width, len(todos[i].DueText()))
+	}
 	for _, t := range todos {
 		check := "[ ]"
 		if t.Completed {
 			check = "[x]"
 		}
-		due := "          "
-		if t.DueDate != nil {
-			due = t.DueDate.Local().Format("2006-01-02")
+		content := t.Content
+		if progress := t.ChecklistText(); progress != "" {
+			content += " (" + progress + ")"
 		}
-		fmt.Printf("%s  %s  %s  %s\n", check, t.ID, due, t.Content)
+		fmt.Printf("%s  %s  %-*s  %s\n", check, t.ID, width, t.DueText(), content)
 	}
 }
@@ -0,0 +1,106 @@
+package cmd
+
+import (
+	"fmt"
+	"slices"
+	"strconv"
+	"strings"
+
+	"github.com/c0dev0id/notesd/notes-cli/internal/model"
+	"github.com/spf13/cobra"
+)
+
+var todosCheckCmd = &cobra.Command{
+	Use:   "check <id> [<item>...]",
+	Short: "Check off or edit the checklist of a todo",
+	Long: `Toggles the given checklist items of the todo, numbered from 1 as todos
+show lists them. --remove drops items by number and --add appends new ones;
+numbers refer to the checklist as it was before the command. Without items
+or flags, the checklist is shown.`,
+	Args: cobra.MinimumNArgs(1),
+	RunE: runTodosCheck,
+}
+
+func init() {
+	todosCmd.AddCommand(todosCheckCmd)
+	todosCheckCmd.ValidArgsFunction = completeOne(completeTodoIDs)
+	todosCheckCmd.Flags().StringArrayP("add", "a", nil, "Append an item (repeatable)")
+	todosCheckCmd.Flags().IntSlice("remove", nil, "Remove items by number")
+}
+
+func runTodosCheck(cmd *cobra.Command, args []string) error {
+	t, err := getTodo(args[0])
+	if err != nil {
+		return err
+	}
+	add, _ := cmd.Flags().GetStringArray("add")
+	remove, _ := cmd.Flags().GetIntSlice("remove")
+	if len(args) == 1 && len(add) == 0 && len(remove) == 0 {
+		printChecklist(t)
+		return nil
+	}
+
+	items := slices.Clone(t.Checklist)
+	for _, arg := range args[1:] {
+		i, err := checklistIndex(items, arg)
+		if err != nil {
+			return err
+		}
+		items[i].Done = !items[i].Done
+	}
+	drop := make([]bool, len(items))
+	for _, n := range remove {
+		i, err := checklistIndex(items, strconv.Itoa(n))
+		if err != nil {
+			return err
+		}
+		drop[i] = true
+	}
+	var kept []model.ChecklistItem
+	for i, it := range items {
+		if !drop[i] {
+			kept = append(kept, it)
+		}
+	}
+	for _, text := range add {
+		text = strings.TrimSpace(text)
+		if text == "" {
+			return fmt.Errorf("checklist item text must not be empty")
+		}
+		kept = append(kept, model.ChecklistItem{ID: model.NewID(), Text: text})
+	}
+	if kept == nil {
+		kept = []model.ChecklistItem{}
+	}
+	t.Checklist = kept
+	if err := saveTodo(t); err != nil {
+		return err
+	}
+	printChecklist(t)
+	return nil
+}
+
+// checklistIndex returns the index of the item numbered n, counting from 1.
+func checklistIndex(items []model.ChecklistItem, n string) (int, error) {
+	i, err := strconv.Atoi(n)
+	if err != nil || i < 1 || i > len(items) {
+		return 0, fmt.Errorf("no checklist item %s (the todo has %d)", n, len(items))
+	}
+	return i - 1, nil
+}
+
+// printChecklist prints the numbered checklist of t.
+func printChecklist(t *model.Todo) {
+	if len(t.Checklist) == 0 {
+		fmt.Println("No checklist.")
+		return
+	}
+	fmt.Printf("%s (%s)\n", t.Content, t.ChecklistText())
+	for i, it := range t.Checklist {
+		check := "[ ]"
+		if it.Done {
+			check = "[x]"
+		}
+		fmt.Printf("%3d. %s %s\n", i+1, check, it.Text)
+	}
+}
@@ -0,0 +1,361 @@
+package cmd
+
+import (
+	"fmt"
+	"strings"
+	"time"
+
+	"github.com/c0dev0id/notesd/notes-cli/internal/model"
+	"github.com/spf13/cobra"
+)
+
+var todosEditCmd = &cobra.Command{
+	Use:   "edit [id]",
+	Short: "Edit a todo, or todos as a text list in $EDITOR",
+	Long: `Opens todos in $EDITOR, one per line in a todo.txt-like format:
+
+  x Buy milk due:2026-03-05 id:<id>
+
+A leading "x " marks the todo completed, due:YYYY-MM-DD sets the due date
+(due:YYYY-MM-DDTHH:MM a local due time) and id: ties the line to an
+existing todo. On save, edited lines update
+their todo, lines without id: are created and removed lines are deleted.
+
+Pass a todo ID to edit one todo, or --all to edit every todo matching the
+filter flags.
+
+With --content, --due or --remind, the todo given by ID is changed directly
+instead; "none" clears the due date or reminder:
+
+  notes-cli todos edit <id> --due fri --remind "thu 5pm"`,
+	Args: cobra.MaximumNArgs(1),
+	RunE: runTodosEdit,
+}
+
+func init() {
+	todosCmd.AddCommand(todosEditCmd)
+
+	todosEditCmd.Flags().Bool("all", false, "Edit all todos matching the filters")
+	todosEditCmd.Flags().Bool("open", false, "Only incomplete todos")
+	todosEditCmd.Flags().Bool("overdue", false, "Only overdue todos")
+	todosEditCmd.Flags().String("note", "", "Only todos attached to this note ID")
+	todosEditCmd.Flags().StringP("content", "c", "", "Set the content")
+	todosEditCmd.Flags().StringP("due", "d", "", `Set the due date ("none" to clear)`)
+	todosEditCmd.Flags().String("remind", "", `Set the reminder time ("none" to clear)`)
+	todosEditCmd.MarkFlagsMutuallyExclusive("all", "content")
+	todosEditCmd.MarkFlagsMutuallyExclusive("all", "due")
+	todosEditCmd.MarkFlagsMutuallyExclusive("all", "remind")
+}
+
+const todoEditHeader = `# One todo per line: [x ]<content> [due:YYYY-MM-DD[THH:MM]] [id:<id>]
+# Remove a line to delete the todo, add a line without id: to create one.
+# Lines starting with # are ignored.
+`
+
+func runTodosEdit(cmd *cobra.Command, args []string) error {
+	all, _ := cmd.Flags().GetBool("all")
+	if all == (len(args) == 1) {
+		return fmt.Errorf("pass either a todo ID or --all")
+	}
+
+	var todos []model.Todo
+	if len(args) == 1 {
+		t, err := getTodo(args[0])
+		if err != nil {
+			return err
+		}
+		if cmd.Flags().Changed("content") || cmd.Flags().Changed("due") || cmd.Flags().Changed("remind") {
+			return editTodoFields(cmd, t)
+		}
+		todos = []model.Todo{*t}
+	} else {
+		var err error
+		todos, err = st.GetAllTodos(userID())
+		if err != nil {
+			return err
+		}
+		todos = filterTodos(cmd, todos)
+	}
+
+	initial := todoEditHeader + formatTodoLines(todos)
+	data, err := runEditor(initial, "notesd-todos-*.txt")
+	if err != nil {
+		return err
+	}
+	if data == initial {
+		fmt.Println("No changes.")
+		return nil
+	}
+
+	lines, err := parseTodoLines(data)
+	if err != nil {
+		return err
+	}
+	ch, err := diffTodos(todos, lines)
+	if err != nil {
+		return err
+	}
+	if ch.empty() {
+		fmt.Println("No changes.")
+		return nil
+	}
+
+	now := model.NowMillis()
+	device := cl.DeviceID()
+	for _, l := range ch.create {
+		t := &model.Todo{
+			ID:               model.NewID(),
+			UserID:           userID(),
+			Content:          l.content,
+			DueDate:          l.due,
+			AllDay:           l.allDay,
+			Completed:        l.completed,
+			ModifiedAt:       now,
+			ModifiedByDevice: device,
+			CreatedAt:        now,
+		}
+		if err := st.CreateTodo(t); err != nil {
+			return err
+		}
+	}
+	for i := range ch.update {
+		t := &ch.update[i]
+		t.ModifiedAt = now
+		t.ModifiedByDevice = device
+		if err := st.UpdateTodo(t); err != nil {
+			return err
+		}
+	}
+	for _, id := range ch.delete {
+		if err := st.DeleteTodo(id, userID(), now.UnixMilli(), device); err != nil {
+			return err
+		}
+	}
+
+	fmt.Printf("Created %d, updated %d, deleted %d todos\n",
+		len(ch.create), len(ch.update), len(ch.delete))
+	go syncQuietly()
+	return nil
+}
+
+// editTodoFields applies the --content, --due and --remind flags to t.
+func editTodoFields(cmd *cobra.Command, t *model.Todo) error {
+	now := time.Now()
+	if cmd.Flags().Changed("content") {
+		content, _ := cmd.Flags().GetString("content")
+		if strings.TrimSpace(content) == "" {
+			return fmt.Errorf("content cannot be empty")
+		}
+		t.Content = content
+	}
+	if cmd.Flags().Changed("due") {
+		s, _ := cmd.Flags().GetString("due")
+		t.DueDate = nil
+		if s != "none" {
+			due, allDay, err := parseDue(s, now)
+			if err != nil {
+				return fmt.Errorf("invalid due date: %w", err)
+			}
+			t.DueDate, t.AllDay = &due, allDay
+		}
+	}
+	if cmd.Flags().Changed("remind") {
+		s, _ := cmd.Flags().GetString("remind")
+		t.ReminderAt = nil
+		if s != "none" {
+			at, err := parseReminder(s, now)
+			if err != nil {
+				return fmt.Errorf("invalid reminder time: %w", err)
+			}
+			t.ReminderAt = &at
+		}
+	}
+	if err := saveTodo(t); err != nil {
+		return err
+	}
+	fmt.Printf("Updated todo %s\n", t.ID)
+	return nil
+}
+
+// saveTodo stores a local edit of t for the next sync.
+func saveTodo(t *model.Todo) error {
+	t.ModifiedAt = model.NowMillis()
+	t.ModifiedByDevice = cl.DeviceID()
+	if err := st.UpdateTodo(t); err != nil {
+		return err
+	}
+	go syncQuietly()
+	return nil
+}
+
+func filterTodos(cmd *cobra.Command, todos []model.Todo) []model.Todo {
+	open, _ := cmd.Flags().GetBool("open")
+	overdue, _ := cmd.Flags().GetBool("overdue")
+	noteID, _ := cmd.Flags().GetString("note")
+
+	now := time.Now()
+	var out []model.Todo
+	for _, t := range todos {
+		if (open || overdue) && t.Completed {
+			continue
+		}
+		if overdue && !t.Overdue(now) {
+			continue
+		}
+		if noteID != "" && (t.NoteID == nil || *t.NoteID != noteID) {
+			continue
+		}
+		out = append(out, t)
+	}
+	return out
+}
+
+// todoLine is one parsed line of the edit buffer.
+type todoLine struct {
+	id        string
+	content   string
+	due       *time.Time
+	allDay    bool
+	dueText   string
+	completed bool
+}
+
+// Due dates in the edit buffer are days, or local times without the space
+// a todo.txt tag cannot hold.
+const (
+	todoDueLayout     = "2006-01-02"
+	todoDueTimeLayout = "2006-01-02T15:04"
+)
+
+// todoDueText renders the due date of t for the edit buffer.
+func todoDueText(t *model.Todo) string {
+	switch {
+	case t.DueDate == nil:
+		return ""
+	case t.AllDay:
+		return t.DueDate.UTC().Format(todoDueLayout)
+	}
+	return t.DueDate.Local().Format(todoDueTimeLayout)
+}
+
+// formatTodoLines renders todos one per line, see todosEditCmd.
+func formatTodoLines(todos []model.Todo) string {
+	var b strings.Builder
+	for _, t := range todos {
+		if t.Completed {
+			b.WriteString("x ")
+		}
+		b.WriteString(strings.Join(strings.Fields(t.Content), " "))
+		if t.DueDate != nil {
+			b.WriteString(" due:" + todoDueText(&t))
+		}
+		b.WriteString(" id:" + t.ID + "\n")
+	}
+	return b.String()
+}
+
+// parseTodoLines parses an edit buffer. Blank lines and # comments are
+// skipped; errors name the offending line.
+func parseTodoLines(s string) ([]todoLine, error) {
+	var lines []todoLine
+	for n, raw := range strings.Split(s, "\n") {
+		raw = strings.TrimSpace(raw)
+		if raw == "" || strings.HasPrefix(raw, "#") {
+			continue
+		}
+
+		var l todoLine
+		if raw == "x" || strings.HasPrefix(raw, "x ") {
+			l.completed = true
+			raw = strings.TrimPrefix(raw, "x")
+		}
+
+		var words []string
+		for _, w := range strings.Fields(raw) {
+			switch {
+			case strings.HasPrefix(w, "id:"):
+				l.id = strings.TrimPrefix(w, "id:")
+			case strings.HasPrefix(w, "due:"):
+				l.dueText = strings.TrimPrefix(w, "due:")
+				due, err := time.Parse(todoDueLayout, l.dueText)
+				l.allDay = err == nil
+				if err != nil {
+					due, err = time.ParseInLocation(todoDueTimeLayout, l.dueText, time.Local)
+				}
+				if err != nil {
+					return nil, fmt.Errorf("line %d: invalid due date %q (use YYYY-MM-DD or YYYY-MM-DDTHH:MM)", n+1, l.dueText)
+				}
+				l.due = &due
+			default:
+				words = append(words, w)
+			}
+		}
+		l.content = strings.Join(words, " ")
+		if l.content == "" {
+			return nil, fmt.Errorf("line %d: empty todo", n+1)
+		}
+		lines = append(lines, l)
+	}
+	return lines, nil
+}
+
+type todoChanges struct {
+	create []todoLine
+	update []model.Todo
+	delete []string
+}
+
+func (c todoChanges) empty() bool {
+	return len(c.create)+len(c.update)+len(c.delete) == 0
+}
+
+// diffTodos compares the edited lines against the todos that were opened.
+// Content is compared with whitespace collapsed, as formatTodoLines writes
+// it, and due dates by their text, so untouched lines never update.
+func diffTodos(orig []model.Todo, lines []todoLine) (todoChanges, error) {
+	var ch todoChanges
+	byID := make(map[string]model.Todo, len(orig))
+	for _, t := range orig {
+		byID[t.ID] = t
+	}
+
+	seen := map[string]bool{}
+	for _, l := range lines {
+		if l.id == "" {
+			ch.create = append(ch.create, l)
+			continue
+		}
+		t, ok := byID[l.id]
+		if !ok {
+			return ch, fmt.Errorf("unknown todo id %q", l.id)
+		}
+		if seen[l.id] {
+			return ch, fmt.Errorf("todo id %q appears more than once", l.id)
+		}
+		seen[l.id] = true
+
+		changed := false
+		if l.content != strings.Join(strings.Fields(t.Content), " ") {
+			t.Content = l.content
+			changed = true
+		}
+		if l.dueText != todoDueText(&t) {
+			t.DueDate, t.AllDay = l.due, l.allDay
+			changed = true
+		}
+		if l.completed != t.Completed {
+			t.Completed = l.completed
+			changed = true
+		}
+		if changed {
+			ch.update = append(ch.update, t)
+		}
+	}
+
+	for _, t := range orig {
+		if !seen[t.ID] {
+			ch.delete = append(ch.delete, t.ID)
+		}
+	}
+	return ch, nil
+}
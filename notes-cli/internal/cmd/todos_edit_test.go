@@ -0,0 +1,116 @@
+package cmd
+
+import (
+	"testing"
+	"time"
+
+	"github.com/c0dev0id/notesd/notes-cli/internal/model"
+)
+
+func TestParseTodoLines(t *testing.T) {
+	cases := []struct {
+		name    string
+		input   string
+		want    []todoLine
+		wantErr bool
+	}{
+		{
+			name:  "completed with due and id",
+			input: "x Buy milk due:2026-03-05 id:abc",
+			want:  []todoLine{{id: "abc", content: "Buy milk", dueText: "2026-03-05", allDay: true, completed: true}},
+		},
+		{
+			name:  "due time",
+			input: "Standup due:2026-03-05T09:30",
+			want:  []todoLine{{content: "Standup", dueText: "2026-03-05T09:30"}},
+		},
+		{
+			name:  "comments and blank lines skipped",
+			input: "# header\n\nCall bank\n",
+			want:  []todoLine{{content: "Call bank"}},
+		},
+		{
+			name:  "x inside a word is content",
+			input: "xray appointment",
+			want:  []todoLine{{content: "xray appointment"}},
+		},
+		{name: "bad due date", input: "Task due:tomorrow", wantErr: true},
+		{name: "only tokens", input: "x id:abc", wantErr: true},
+	}
+
+	for _, tc := range cases {
+		t.Run(tc.name, func(t *testing.T) {
+			got, err := parseTodoLines(tc.input)
+			t.Logf("input=%q lines=%+v err=%v", tc.input, got, err)
+			if tc.wantErr {
+				if err == nil {
+					t.Fatal("expected error")
+				}
+				return
+			}
+			if err != nil {
+				t.Fatalf("unexpected error: %v", err)
+			}
+			if len(got) != len(tc.want) {
+				t.Fatalf("got %d lines, want %d", len(got), len(tc.want))
+			}
+			for i := range got {
+				g, w := got[i], tc.want[i]
+				if g.id != w.id || g.content != w.content || g.dueText != w.dueText || g.allDay != w.allDay ||
+					g.completed != w.completed {
+					t.Errorf("line %d: got %+v, want %+v", i, g, w)
+				}
+			}
+		})
+	}
+}
+
+func TestDiffTodos(t *testing.T) {
+	// Arrange
+	due := time.Date(2026, 3, 5, 0, 0, 0, 0, time.UTC)
+	at := time.Date(2026, 3, 5, 14, 0, 0, 0, time.Local)
+	orig := []model.Todo{
+		{ID: "keep", Content: "Unchanged  spacing", DueDate: &due, AllDay: true},
+		{ID: "timed", Content: "Call", DueDate: &at},
+		{ID: "done", Content: "Finish report"},
+		{ID: "gone", Content: "Obsolete"},
+	}
+	// keep and timed are untouched, done is completed, gone is removed,
+	// one line added
+	edited := "Unchanged spacing due:2026-03-05 id:keep\n" +
+		"Call due:2026-03-05T14:00 id:timed\n" +
+		"x Finish report id:done\n" +
+		"New task due:2026-04-01\n"
+	t.Logf("original buffer:\n%sedited buffer:\n%s", formatTodoLines(orig), edited)
+
+	// Act
+	lines, err := parseTodoLines(edited)
+	if err != nil {
+		t.Fatalf("parseTodoLines: %v", err)
+	}
+	ch, err := diffTodos(orig, lines)
+	if err != nil {
+		t.Fatalf("diffTodos: %v", err)
+	}
+
+	// Assert
+	t.Logf("create=%d update=%d delete=%v", len(ch.create), len(ch.update), ch.delete)
+	if len(ch.create) != 1 || ch.create[0].content != "New task" || ch.create[0].due == nil || !ch.create[0].allDay {
+		t.Errorf("create: got %+v", ch.create)
+	}
+	if len(ch.update) != 1 || ch.update[0].ID != "done" || !ch.update[0].Completed {
+		t.Errorf("update: got %+v", ch.update)
+	}
+	if len(ch.delete) != 1 || ch.delete[0] != "gone" {
+		t.Errorf("delete: got %v", ch.delete)
+	}
+}
+
+func TestDiffTodosRejectsUnknownID(t *testing.T) {
+	lines, _ := parseTodoLines("Something id:nope")
+	_, err := diffTodos(nil, lines)
+	t.Logf("err=%v", err)
+	if err == nil {
+		t.Error("expected error for unknown id")
+	}
+}
@@ -0,0 +1,65 @@
+package cmd
+
+import (
+	"fmt"
+
+	"github.com/spf13/cobra"
+)
+
+var todosMoveCmd = &cobra.Command{
+	Use:   "move <id> <note|none>",
+	Short: "Attach a todo to another note",
+	Long: `Attaches the todo to the given note, by ID, ID prefix or title, or
+detaches it with "none". --line sets the line reference within the note.
+Todos left on deleted notes are listed by todos list --orphaned.`,
+	Args: cobra.ExactArgs(2),
+	RunE: runTodosMove,
+}
+
+func init() {
+	todosCmd.AddCommand(todosMoveCmd)
+	todosMoveCmd.Flags().String("line", "", "Line reference within the note")
+	todosMoveCmd.ValidArgsFunction = func(cmd *cobra.Command, args []string, toComplete string) ([]cobra.Completion, cobra.ShellCompDirective) {
+		switch len(args) {
+		case 0:
+			return completeTodoIDs(cmd, args, toComplete)
+		case 1:
+			return completeNoteIDs(cmd, args, toComplete)
+		}
+		return nil, cobra.ShellCompDirectiveNoFileComp
+	}
+}
+
+func runTodosMove(cmd *cobra.Command, args []string) error {
+	t, err := getTodo(args[0])
+	if err != nil {
+		return err
+	}
+	line, _ := cmd.Flags().GetString("line")
+
+	if args[1] == "none" {
+		if line != "" {
+			return fmt.Errorf("--line needs a note")
+		}
+		t.NoteID, t.LineRef = nil, nil
+		if err := saveTodo(t); err != nil {
+			return err
+		}
+		fmt.Printf("Detached: %s\n", t.Content)
+		return nil
+	}
+
+	n, err := getNote(args[1])
+	if err != nil {
+		return err
+	}
+	t.NoteID, t.LineRef = &n.ID, nil
+	if line != "" {
+		t.LineRef = &line
+	}
+	if err := saveTodo(t); err != nil {
+		return err
+	}
+	fmt.Printf("Moved to %q: %s\n", n.Title, t.Content)
+	return nil
+}
@@ -0,0 +1,70 @@
+package cmd
+
+import (
+	"fmt"
+	"strings"
+	"time"
+
+	"github.com/spf13/cobra"
+)
+
+var todosSnoozeCmd = &cobra.Command{
+	Use:   "snooze <id> <duration|date>",
+	Short: "Move a todo's due date",
+	Long: `Moves the todo's due date later by a duration such as 1d, 3 days, 2w or
+1 month. The duration counts from the due date, or from today if that has
+passed or is not set, and a reminder moves along by the same number of
+days. A due time keeps its time of day. A date ("fri", "jan 15", see todos
+create --due) sets the due date to that day instead.`,
+	Args: cobra.MinimumNArgs(2),
+	RunE: runTodosSnooze,
+}
+
+func init() {
+	todosCmd.AddCommand(todosSnoozeCmd)
+	todosSnoozeCmd.ValidArgsFunction = completeOne(completeTodoIDs)
+}
+
+func runTodosSnooze(cmd *cobra.Command, args []string) error {
+	t, err := getTodo(args[0])
+	if err != nil {
+		return err
+	}
+	when := strings.Join(args[1:], " ")
+
+	now := time.Now()
+	y, m, d := now.Date()
+	from, fromAllDay := time.Date(y, m, d, 0, 0, 0, 0, time.UTC), true
+	if t.DueDate != nil {
+		switch {
+		case !t.Overdue(now):
+			from, fromAllDay = t.DueDate.Local(), t.AllDay
+		case !t.AllDay:
+			// Overdue at a time of day: count from that time today.
+			at := t.DueDate.Local()
+			from, fromAllDay = time.Date(y, m, d, at.Hour(), at.Minute(), 0, 0, time.Local), false
+		}
+	}
+	due, allDay := from, fromAllDay
+	if days, months, ok := parseShift(when); ok {
+		due = from.AddDate(0, months, days)
+	} else if due, allDay, err = parseDue(when, now); err != nil {
+		return fmt.Errorf("invalid duration or date: %w", err)
+	}
+
+	if t.ReminderAt != nil {
+		ref, refAllDay := from, fromAllDay
+		if t.DueDate != nil {
+			ref, refAllDay = t.DueDate.Local(), t.AllDay
+		}
+		days := int(dueDay(due, allDay).Sub(dueDay(ref, refAllDay)).Hours() / 24)
+		shifted := t.ReminderAt.Local().AddDate(0, 0, days)
+		t.ReminderAt = &shifted
+	}
+	t.DueDate, t.AllDay = &due, allDay
+	if err := saveTodo(t); err != nil {
+		return err
+	}
+	fmt.Printf("Due %s: %s\n", t.DueText(), t.Content)
+	return nil
+}
@@ -0,0 +1,101 @@
+package cmd
+
+import (
+	"fmt"
+	"os"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/spf13/cobra"
+)
+
+var todosSummaryCmd = &cobra.Command{
+	Use:   "summary",
+	Short: "Show todos completed per day, the current streak and timeliness",
+	Long: `Shows a sparkline of the todos completed on each of the last days, the
+current streak of days with at least one completion and how completions
+compare with due dates. Days follow the local time zone. The figures come
+from the server, so completions not yet synced are not included.`,
+	Args: cobra.NoArgs,
+	RunE: runTodosSummary,
+}
+
+func init() {
+	todosCmd.AddCommand(todosSummaryCmd)
+
+	todosSummaryCmd.Flags().IntP("days", "d", 30, "Number of days to show")
+}
+
+func runTodosSummary(cmd *cobra.Command, args []string) error {
+	days, _ := cmd.Flags().GetInt("days")
+	s, err := cl.TodoSummary(days, localZone())
+	if err != nil {
+		return err
+	}
+	records := make([][]string, len(s.Days))
+	for i, d := range s.Days {
+		records[i] = []string{d.Date, strconv.Itoa(d.Count)}
+	}
+	if ok, err := printStructured(s, []string{"date", "count"}, records); ok {
+		return err
+	}
+
+	counts := make([]int, len(s.Days))
+	for i, d := range s.Days {
+		counts[i] = d.Count
+	}
+	if len(s.Days) > 0 {
+		fmt.Printf("%s  %s  %s\n", s.Days[0].Date, sparkline(counts), s.Days[len(s.Days)-1].Date)
+	}
+	fmt.Printf("Completed: %d in %d days\n", s.Completed, len(s.Days))
+	fmt.Printf("Streak:    %d day(s)\n", s.CurrentStreak)
+	if s.AvgDaysFromDue != nil {
+		timing := "on the due date"
+		switch avg := *s.AvgDaysFromDue; {
+		case avg < 0:
+			timing = fmt.Sprintf("%.1f days early", -avg)
+		case avg > 0:
+			timing = fmt.Sprintf("%.1f days late", avg)
+		}
+		fmt.Printf("Due dates: %d on time, %d late; on average %s\n", s.OnTime, s.Late, timing)
+	}
+	return nil
+}
+
+var sparks = []rune("▁▂▃▄▅▆▇█")
+
+// sparkline draws counts as bars scaled to the largest; zero is a space so
+// that idle days stand out.
+func sparkline(counts []int) string {
+	most := 0
+	for _, c := range counts {
+		most = max(most, c)
+	}
+	var b strings.Builder
+	for _, c := range counts {
+		if c == 0 {
+			b.WriteRune(' ')
+			continue
+		}
+		b.WriteRune(sparks[(c*len(sparks)-1)/most])
+	}
+	return b.String()
+}
+
+// localZone returns the IANA name of the local time zone, from $TZ or the
+// /etc/localtime link, or "" when it cannot be told.
+func localZone() string {
+	name := strings.TrimPrefix(os.Getenv("TZ"), ":")
+	if name == "" {
+		link, err := os.Readlink("/etc/localtime")
+		if err != nil {
+			return ""
+		}
+		_, name, _ = strings.Cut(link, "zoneinfo/")
+	}
+	if _, err := time.LoadLocation(name); err != nil || name == "" {
+		return ""
+	}
+	return name
+}
@@ -0,0 +1,48 @@
+package cmd
+
+import "testing"
+
+func TestSparkline(t *testing.T) {
+	cases := []struct {
+		counts []int
+		want   string
+	}{
+		{[]int{0, 1, 2, 4, 8}, " ▁▂▄█"},
+		{[]int{3, 0, 3}, "█ █"},
+		{[]int{0, 0}, "  "},
+		{nil, ""},
+	}
+	for _, c := range cases {
+		// Act
+		got := sparkline(c.counts)
+
+		// Assert
+		t.Logf("%v -> %q", c.counts, got)
+		if got != c.want {
+			t.Errorf("sparkline(%v) = %q, want %q", c.counts, got, c.want)
+		}
+	}
+}
+
+func TestLocalZone(t *testing.T) {
+	cases := []struct {
+		tz, want string
+	}{
+		{"Europe/Berlin", "Europe/Berlin"},
+		{":America/New_York", "America/New_York"},
+		{"Not/AZone", ""},
+	}
+	for _, c := range cases {
+		// Arrange
+		t.Setenv("TZ", c.tz)
+
+		// Act
+		got := localZone()
+
+		// Assert
+		t.Logf("TZ=%q -> %q", c.tz, got)
+		if got != c.want {
+			t.Errorf("localZone() with TZ=%q = %q, want %q", c.tz, got, c.want)
+		}
+	}
+}
@@ -0,0 +1,201 @@
+// Package delta computes and applies line-based patches of note content, so
+// sync can send what changed instead of the whole note.
+package delta
+
+import (
+	"crypto/sha256"
+	"encoding/hex"
+	"errors"
+	"strings"
+)
+
+// Hash returns the hex SHA-256 of content. Both sides compare it to check
+// that a patch applies to the content it was made from.
+func Hash(content string) string {
+	sum := sha256.Sum256([]byte(content))
+	return hex.EncodeToString(sum[:])
+}
+
+// Hunk replaces Delete lines of the base, starting at line At (0-based), with
+// Insert. Lines keep their trailing newline.
+type Hunk struct {
+	At     int      `json:"at"`
+	Delete int      `json:"del,omitempty"`
+	Insert []string `json:"ins,omitempty"`
+}
+
+// Patch is a list of hunks in ascending, non-overlapping order.
+type Patch []Hunk
+
+// ErrMismatch means a patch does not fit the content it is applied to.
+var ErrMismatch = errors.New("patch does not apply")
+
+// maxEdits bounds the diff search. Beyond it the changed region is sent as
+// one hunk, which is still no larger than the new content.
+const maxEdits = 1000
+
+// Diff returns a patch turning a into b.
+func Diff(a, b string) Patch {
+	x, y := lines(a), lines(b)
+
+	pre := 0
+	for pre < len(x) && pre < len(y) && x[pre] == y[pre] {
+		pre++
+	}
+	suf := 0
+	for suf < len(x)-pre && suf < len(y)-pre && x[len(x)-1-suf] == y[len(y)-1-suf] {
+		suf++
+	}
+	x, y = x[pre:len(x)-suf], y[pre:len(y)-suf]
+	if len(x) == 0 && len(y) == 0 {
+		return Patch{}
+	}
+
+	ops, ok := myers(x, y)
+	if !ok {
+		return Patch{{At: pre, Delete: len(x), Insert: y}}
+	}
+
+	var p Patch
+	var cur *Hunk
+	ai, bi := 0, 0
+	for _, op := range ops {
+		switch op {
+		case keep:
+			cur = nil
+			ai++
+			bi++
+			continue
+		case del:
+			if cur == nil {
+				p = append(p, Hunk{At: pre + ai})
+				cur = &p[len(p)-1]
+			}
+			cur.Delete++
+			ai++
+		case ins:
+			if cur == nil {
+				p = append(p, Hunk{At: pre + ai})
+				cur = &p[len(p)-1]
+			}
+			cur.Insert = append(cur.Insert, y[bi])
+			bi++
+		}
+	}
+	return p
+}
+
+// Apply applies p to base.
+func Apply(base string, p Patch) (string, error) {
+	x := lines(base)
+	var b strings.Builder
+	pos := 0
+	for _, h := range p {
+		if h.At < pos || h.Delete < 0 || h.At+h.Delete > len(x) {
+			return "", ErrMismatch
+		}
+		for _, l := range x[pos:h.At] {
+			b.WriteString(l)
+		}
+		for _, l := range h.Insert {
+			b.WriteString(l)
+		}
+		pos = h.At + h.Delete
+	}
+	for _, l := range x[pos:] {
+		b.WriteString(l)
+	}
+	return b.String(), nil
+}
+
+// lines splits s after each newline; joining the result gives s back.
+func lines(s string) []string {
+	if s == "" {
+		return nil
+	}
+	l := strings.SplitAfter(s, "\n")
+	if l[len(l)-1] == "" {
+		l = l[:len(l)-1]
+	}
+	return l
+}
+
+type op uint8
+
+const (
+	keep op = iota
+	del
+	ins
+)
+
+// myers returns the shortest edit script from a to b (Myers 1986), or false
+// if it needs more than maxEdits edits.
+func myers(a, b []string) ([]op, bool) {
+	n, m := len(a), len(b)
+	max := n + m
+	off := max + 1
+	v := make([]int, 2*max+2)
+	var trace [][]int
+
+	for d := 0; d <= max && d <= maxEdits; d++ {
+		for k := -d; k <= d; k += 2 {
+			var x int
+			if k == -d || (k != d && v[off+k-1] < v[off+k+1]) {
+				x = v[off+k+1]
+			} else {
+				x = v[off+k-1] + 1
+			}
+			y := x - k
+			for x < n && y < m && a[x] == b[y] {
+				x++
+				y++
+			}
+			v[off+k] = x
+			if x >= n && y >= m {
+				trace = append(trace, append([]int(nil), v[off-d:off+d+1]...))
+				return backtrack(trace, n, m), true
+			}
+		}
+		trace = append(trace, append([]int(nil), v[off-d:off+d+1]...))
+	}
+	return nil, false
+}
+
+// backtrack walks the trace from (n, m) back to the origin. trace[d][k+d]
+// is the furthest x reached on diagonal k after d edits.
+func backtrack(trace [][]int, n, m int) []op {
+	var ops []op
+	x, y := n, m
+	for d := len(trace) - 1; d > 0; d-- {
+		prev := trace[d-1]
+		k := x - y
+		var pk int
+		if k == -d || (k != d && prev[k-1+d-1] < prev[k+1+d-1]) {
+			pk = k + 1
+		} else {
+			pk = k - 1
+		}
+		px := prev[pk+d-1]
+		py := px - pk
+		for x > px && y > py {
+			ops = append(ops, keep)
+			x--
+			y--
+		}
+		if pk == k+1 {
+			ops = append(ops, ins)
+		} else {
+			ops = append(ops, del)
+		}
+		x, y = px, py
+	}
+	for x > 0 && y > 0 {
+		ops = append(ops, keep)
+		x--
+		y--
+	}
+	for i, j := 0, len(ops)-1; i < j; i, j = i+1, j-1 {
+		ops[i], ops[j] = ops[j], ops[i]
+	}
+	return ops
+}
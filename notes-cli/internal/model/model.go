@@ -4,6 +4,8 @@ import (
 	"crypto/rand"
 	"fmt"
 	"time"
+
+	"github.com/c0dev0id/notesd/notes-cli/internal/delta"
 )
 
 // NewID generates a UUID v4 string.
@@ -29,22 +31,139 @@ type Note struct {
 	Title            string     `json:"title"`
 	Content          string     `json:"content"`
 	Type             string     `json:"type"`
+	SnoozedUntil     *time.Time `json:"snoozed_until,omitempty"`
+	Tags             []string   `json:"tags"`
 	ModifiedAt       time.Time  `json:"modified_at"`
 	ModifiedByDevice string     `json:"modified_by_device"`
 	DeletedAt        *time.Time `json:"deleted_at,omitempty"`
 	CreatedAt        time.Time  `json:"created_at"`
+
+	// Delta sync only: content sent as ContentPatch against the content
+	// hashing to BaseHash. Not stored locally.
+	ContentHash  string      `json:"content_hash,omitempty"`
+	BaseHash     string      `json:"base_hash,omitempty"`
+	ContentPatch delta.Patch `json:"content_patch,omitempty"`
 }
 
+// Todo is a todo as cached and synced. An AllDay due date is a calendar
+// day at midnight UTC rather than a moment. A nil Checklist is unknown, as
+// for todos cached before checklists, and pushing it leaves the server's
+// checklist alone.
 type Todo struct {
-	ID               string     `json:"id"`
-	UserID           string     `json:"user_id"`
-	NoteID           *string    `json:"note_id,omitempty"`
-	LineRef          *string    `json:"line_ref,omitempty"`
-	Content          string     `json:"content"`
-	DueDate          *time.Time `json:"due_date,omitempty"`
-	Completed        bool       `json:"completed"`
-	ModifiedAt       time.Time  `json:"modified_at"`
-	ModifiedByDevice string     `json:"modified_by_device"`
-	DeletedAt        *time.Time `json:"deleted_at,omitempty"`
-	CreatedAt        time.Time  `json:"created_at"`
+	ID               string          `json:"id"`
+	UserID           string          `json:"user_id"`
+	NoteID           *string         `json:"note_id,omitempty"`
+	LineRef          *string         `json:"line_ref,omitempty"`
+	Content          string          `json:"content"`
+	DueDate          *time.Time      `json:"due_date,omitempty"`
+	AllDay           bool            `json:"all_day"`
+	ReminderAt       *time.Time      `json:"reminder_at,omitempty"`
+	Completed        bool            `json:"completed"`
+	Tags             []string        `json:"tags"`
+	Checklist        []ChecklistItem `json:"checklist"`
+	ModifiedAt       time.Time       `json:"modified_at"`
+	ModifiedByDevice string          `json:"modified_by_device"`
+	DeletedAt        *time.Time      `json:"deleted_at,omitempty"`
+	CreatedAt        time.Time       `json:"created_at"`
+	// FieldTimes records when the fields merged one by one during sync
+	// were last changed. Missing times mean ModifiedAt.
+	FieldTimes *TodoFieldTimes `json:"field_times,omitempty"`
+}
+
+// DueText renders the due date: the day for all-day todos, which are
+// stored at midnight UTC, and the local day and time otherwise. It is
+// empty without a due date.
+func (t *Todo) DueText() string {
+	switch {
+	case t.DueDate == nil:
+		return ""
+	case t.AllDay:
+		return t.DueDate.UTC().Format(time.DateOnly)
+	}
+	return t.DueDate.Local().Format("2006-01-02 15:04")
+}
+
+// Overdue reports whether the todo is open and past due at now: past its
+// due time, or for all-day todos due before the local date.
+func (t *Todo) Overdue(now time.Time) bool {
+	switch {
+	case t.Completed || t.DueDate == nil:
+		return false
+	case t.AllDay:
+		y, m, d := now.Date()
+		return t.DueDate.Before(time.Date(y, m, d, 0, 0, 0, 0, time.UTC))
+	}
+	return t.DueDate.Before(now)
+}
+
+// ChecklistItem is a step of a todo, checked off on its own.
+type ChecklistItem struct {
+	ID   string `json:"id"`
+	Text string `json:"text"`
+	Done bool   `json:"done"`
+}
+
+// ChecklistText renders the checklist progress as "done/total", empty
+// without a checklist.
+func (t *Todo) ChecklistText() string {
+	if len(t.Checklist) == 0 {
+		return ""
+	}
+	done := 0
+	for _, it := range t.Checklist {
+		if it.Done {
+			done++
+		}
+	}
+	return fmt.Sprintf("%d/%d", done, len(t.Checklist))
+}
+
+// TodoFieldTimes holds per-field modification times of a todo. The note
+// time covers both note_id and line_ref.
+type TodoFieldTimes struct {
+	Content   time.Time `json:"content"`
+	DueDate   time.Time `json:"due_date"`
+	Completed time.Time `json:"completed"`
+	NoteID    time.Time `json:"note_id"`
+}
+
+// Attachment is the metadata of a file attached to a note on the server.
+type Attachment struct {
+	ID          string     `json:"id"`
+	NoteID      string     `json:"note_id"`
+	Filename    string     `json:"filename"`
+	ContentType string     `json:"content_type"`
+	Size        int64      `json:"size"`
+	SHA256      string     `json:"sha256"`
+	ModifiedAt  time.Time  `json:"modified_at"`
+	DeletedAt   *time.Time `json:"deleted_at,omitempty"`
+	CreatedAt   time.Time  `json:"created_at"`
+}
+
+// TagUsage is a tag with the number of live notes and todos carrying it.
+type TagUsage struct {
+	Name  string `json:"name"`
+	Notes int    `json:"notes"`
+	Todos int    `json:"todos"`
+}
+
+// ImportSummary is what the server created from an import. Skipped counts
+// notes and todos that already existed.
+type ImportSummary struct {
+	Notes       int `json:"notes"`
+	Todos       int `json:"todos"`
+	Attachments int `json:"attachments"`
+	Skipped     int `json:"skipped"`
+}
+
+// AccessToken is a personal access token for scripts. Token, the secret, is
+// only set when the token is created.
+type AccessToken struct {
+	ID         string     `json:"id"`
+	Name       string     `json:"name"`
+	Scope      string     `json:"scope"`
+	Token      string     `json:"token,omitempty"`
+	ExpiresAt  *time.Time `json:"expires_at,omitempty"`
+	LastUsedAt *time.Time `json:"last_used_at,omitempty"`
+	CreatedAt  time.Time  `json:"created_at"`
 }
@@ -0,0 +1,58 @@
+package store
+
+import (
+	"fmt"
+	"regexp"
+	"strings"
+
+	"github.com/c0dev0id/notesd/notes-cli/internal/model"
+)
+
+// wikiLinkRe matches [[Target]] and [[Target|label]] links, as on the server.
+var wikiLinkRe = regexp.MustCompile(`\[\[([^\[\]|]+)(?:\|[^\[\]]*)?\]\]`)
+
+// linksTo reports whether content has a wiki link to the title key key.
+func linksTo(content, key string) bool {
+	for _, m := range wikiLinkRe.FindAllStringSubmatch(content, -1) {
+		if strings.ToLower(strings.TrimSpace(m[1])) == key {
+			return true
+		}
+	}
+	return false
+}
+
+// Backlinks returns the local live notes whose wiki links point at the note
+// by its title, most recently modified first. It is the offline counterpart
+// of GET /api/v1/notes/{id}/backlinks.
+func (s *Store) Backlinks(id, userID string) ([]model.Note, error) {
+	n, err := s.GetNote(id, userID)
+	if err != nil {
+		return nil, err
+	}
+	key := strings.ToLower(strings.TrimSpace(n.Title))
+	if key == "" {
+		return nil, nil
+	}
+
+	rows, err := s.db.Query(
+		`SELECT `+noteColumns+`
+		 FROM notes WHERE user_id = ? AND deleted_at IS NULL AND id != ? AND instr(content, '[[') > 0
+		 ORDER BY modified_at DESC`,
+		userID, id,
+	)
+	if err != nil {
+		return nil, fmt.Errorf("get backlinks: %w", err)
+	}
+	defer rows.Close()
+	candidates, err := scanNotes(rows)
+	if err != nil {
+		return nil, err
+	}
+	var notes []model.Note
+	for _, c := range candidates {
+		if linksTo(c.Content, key) {
+			notes = append(notes, c)
+		}
+	}
+	return notes, nil
+}
@@ -0,0 +1,53 @@
+package store
+
+import (
+	"fmt"
+
+	"github.com/c0dev0id/notesd/notes-cli/internal/model"
+)
+
+// MatchNotes returns up to limit live notes whose ID starts with ref or,
+// when there are none, whose title contains it (ASCII case-insensitively),
+// most recently modified first. It lets commands take short IDs and titles.
+func (s *Store) MatchNotes(userID, ref string, limit int) ([]model.Note, error) {
+	var notes []model.Note
+	for _, cond := range []string{`substr(id, 1, length(?1)) = ?1`, `instr(lower(title), lower(?1)) > 0`} {
+		rows, err := s.db.Query(
+			`SELECT `+noteColumns+`
+			 FROM notes WHERE user_id = ?2 AND deleted_at IS NULL AND `+cond+`
+			 ORDER BY modified_at DESC LIMIT ?3`,
+			ref, userID, limit,
+		)
+		if err != nil {
+			return nil, fmt.Errorf("match notes: %w", err)
+		}
+		notes, err = scanNotes(rows)
+		rows.Close()
+		if err != nil || len(notes) > 0 {
+			return notes, err
+		}
+	}
+	return nil, nil
+}
+
+// MatchTodos is MatchNotes for todos, matching their content.
+func (s *Store) MatchTodos(userID, ref string, limit int) ([]model.Todo, error) {
+	var todos []model.Todo
+	for _, cond := range []string{`substr(id, 1, length(?1)) = ?1`, `instr(lower(content), lower(?1)) > 0`} {
+		rows, err := s.db.Query(
+			`SELECT `+todoColumns+`
+			 FROM todos WHERE user_id = ?2 AND deleted_at IS NULL AND `+cond+`
+			 ORDER BY modified_at DESC LIMIT ?3`,
+			ref, userID, limit,
+		)
+		if err != nil {
+			return nil, fmt.Errorf("match todos: %w", err)
+		}
+		todos, err = scanTodos(rows)
+		rows.Close()
+		if err != nil || len(todos) > 0 {
+			return todos, err
+		}
+	}
+	return nil, nil
+}
@@ -4,16 +4,27 @@ import (
 	"database/sql"
 	"errors"
 	"fmt"
+	"strings"
 
 	"github.com/c0dev0id/notesd/notes-cli/internal/model"
 )
 
+// CreateNote inserts a note and queues it for the next push.
 func (s *Store) CreateNote(n *model.Note) error {
-	_, err := s.db.Exec(
+	return s.withTx(func(tx *sql.Tx) error {
+		if err := insertNote(tx, n); err != nil {
+			return err
+		}
+		return enqueue(tx, "note", n.ID)
+	})
+}
+
+func insertNote(db execer, n *model.Note) error {
+	_, err := db.Exec(
 		`INSERT INTO notes
-		 (id, user_id, title, content, type, modified_at, modified_by_device, deleted_at, created_at)
-		 VALUES (?, ?, ?, ?, ?, ?, ?, ?, ?)`,
-		n.ID, n.UserID, n.Title, n.Content, n.Type,
+		 (`+noteColumns+`)
+		 VALUES (?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?)`,
+		n.ID, n.UserID, n.Title, n.Content, n.Type, toNullMillis(n.SnoozedUntil), joinTags(n.Tags),
 		toMillis(n.ModifiedAt), n.ModifiedByDevice,
 		toNullMillis(n.DeletedAt), toMillis(n.CreatedAt),
 	)
@@ -25,7 +36,7 @@ func (s *Store) CreateNote(n *model.Note) error {
 
 func (s *Store) GetNote(id, userID string) (*model.Note, error) {
 	row := s.db.QueryRow(
-		`SELECT id, user_id, title, content, type, modified_at, modified_by_device, deleted_at, created_at
+		`SELECT `+noteColumns+`
 		 FROM notes WHERE id = ? AND user_id = ? AND deleted_at IS NULL`, id, userID,
 	)
 	return scanNote(row)
@@ -33,26 +44,49 @@ func (s *Store) GetNote(id, userID string) (*model.Note, error) {
 
 func (s *Store) GetNoteAny(id, userID string) (*model.Note, error) {
 	row := s.db.QueryRow(
-		`SELECT id, user_id, title, content, type, modified_at, modified_by_device, deleted_at, created_at
+		`SELECT `+noteColumns+`
 		 FROM notes WHERE id = ? AND user_id = ?`, id, userID,
 	)
 	return scanNote(row)
 }
 
-func (s *Store) ListNotes(userID string, limit, offset int) ([]model.Note, int, error) {
+// NoteFilter narrows note listings. The zero value lists every live note
+// that is not currently snoozed, matching the server's default.
+type NoteFilter struct {
+	// Snoozed selects only currently snoozed notes instead of hiding them.
+	Snoozed bool
+	// Tag, when set, selects only notes carrying that tag (any case).
+	Tag string
+}
+
+func (f NoteFilter) where(args *[]any) string {
+	*args = append(*args, model.NowMillis().UnixMilli())
+	cond := `(snoozed_until IS NULL OR snoozed_until <= ?)`
+	if f.Snoozed {
+		cond = `snoozed_until > ?`
+	}
+	if f.Tag != "" {
+		cond += ` AND ` + tagMatch("notes.tags")
+		*args = append(*args, f.Tag)
+	}
+	return cond
+}
+
+func (s *Store) ListNotes(userID string, f NoteFilter, limit, offset int) ([]model.Note, int, error) {
+	args := []any{userID}
+	cond := `user_id = ? AND deleted_at IS NULL AND ` + f.where(&args)
+
 	var total int
-	err := s.db.QueryRow(
-		`SELECT COUNT(*) FROM notes WHERE user_id = ? AND deleted_at IS NULL`, userID,
-	).Scan(&total)
+	err := s.db.QueryRow(`SELECT COUNT(*) FROM notes WHERE `+cond, args...).Scan(&total)
 	if err != nil {
 		return nil, 0, fmt.Errorf("count notes: %w", err)
 	}
 
 	rows, err := s.db.Query(
-		`SELECT id, user_id, title, content, type, modified_at, modified_by_device, deleted_at, created_at
-		 FROM notes WHERE user_id = ? AND deleted_at IS NULL
+		`SELECT `+noteColumns+`
+		 FROM notes WHERE `+cond+`
 		 ORDER BY modified_at DESC LIMIT ? OFFSET ?`,
-		userID, limit, offset,
+		append(args, limit, offset)...,
 	)
 	if err != nil {
 		return nil, 0, fmt.Errorf("list notes: %w", err)
@@ -62,11 +96,23 @@ func (s *Store) ListNotes(userID string, limit, offset int) ([]model.Note, int,
 	return notes, total, err
 }
 
+// UpdateNote writes a local edit and queues it for the next push.
 func (s *Store) UpdateNote(n *model.Note) error {
-	res, err := s.db.Exec(
-		`UPDATE notes SET title = ?, content = ?, type = ?, modified_at = ?, modified_by_device = ?
+	return s.withTx(func(tx *sql.Tx) error {
+		if err := updateNote(tx, n); err != nil {
+			return err
+		}
+		return enqueue(tx, "note", n.ID)
+	})
+}
+
+func updateNote(db execer, n *model.Note) error {
+	res, err := db.Exec(
+		`UPDATE notes SET title = ?, content = ?, type = ?, snoozed_until = ?, tags = ?,
+		 modified_at = ?, modified_by_device = ?
 		 WHERE id = ? AND user_id = ? AND deleted_at IS NULL`,
-		n.Title, n.Content, n.Type, toMillis(n.ModifiedAt), n.ModifiedByDevice,
+		n.Title, n.Content, n.Type, toNullMillis(n.SnoozedUntil), joinTags(n.Tags),
+		toMillis(n.ModifiedAt), n.ModifiedByDevice,
 		n.ID, n.UserID,
 	)
 	if err != nil {
@@ -75,36 +121,47 @@ func (s *Store) UpdateNote(n *model.Note) error {
 	return checkRowsAffected(res)
 }
 
+// DeleteNote soft-deletes a note and queues the deletion for the next push.
 func (s *Store) DeleteNote(id, userID string, deletedAt int64, deviceID string) error {
-	res, err := s.db.Exec(
-		`UPDATE notes SET deleted_at = ?, modified_at = ?, modified_by_device = ?
-		 WHERE id = ? AND user_id = ? AND deleted_at IS NULL`,
-		deletedAt, deletedAt, deviceID, id, userID,
-	)
-	if err != nil {
-		return fmt.Errorf("delete note: %w", err)
-	}
-	return checkRowsAffected(res)
+	return s.withTx(func(tx *sql.Tx) error {
+		res, err := tx.Exec(
+			`UPDATE notes SET deleted_at = ?, modified_at = ?, modified_by_device = ?
+			 WHERE id = ? AND user_id = ? AND deleted_at IS NULL`,
+			deletedAt, deletedAt, deviceID, id, userID,
+		)
+		if err != nil {
+			return fmt.Errorf("delete note: %w", err)
+		}
+		if err := checkRowsAffected(res); err != nil {
+			return err
+		}
+		return enqueue(tx, "note", id)
+	})
 }
 
-func (s *Store) SearchNotes(userID, query string, limit, offset int) ([]model.Note, int, error) {
-	pattern := "%" + query + "%"
+// SearchNotes looks up notes in the local full-text index. Every word of the
+// query must match, the last one as a prefix; results are ranked by
+// relevance with title matches weighted higher.
+func (s *Store) SearchNotes(userID, query string, f NoteFilter, limit, offset int) ([]model.Note, int, error) {
+	match := ftsQuery(query)
+	if match == "" {
+		return nil, 0, nil
+	}
+	args := []any{match, userID}
+	cond := `notes_fts MATCH ? AND user_id = ? AND deleted_at IS NULL AND ` + f.where(&args)
+	from := `notes_fts JOIN notes ON notes.id = notes_fts.id`
 
 	var total int
-	err := s.db.QueryRow(
-		`SELECT COUNT(*) FROM notes
-		 WHERE user_id = ? AND deleted_at IS NULL AND (title LIKE ? OR content LIKE ?)`,
-		userID, pattern, pattern,
-	).Scan(&total)
+	err := s.db.QueryRow(`SELECT COUNT(*) FROM `+from+` WHERE `+cond, args...).Scan(&total)
 	if err != nil {
 		return nil, 0, fmt.Errorf("count search: %w", err)
 	}
 
 	rows, err := s.db.Query(
-		`SELECT id, user_id, title, content, type, modified_at, modified_by_device, deleted_at, created_at
-		 FROM notes WHERE user_id = ? AND deleted_at IS NULL AND (title LIKE ? OR content LIKE ?)
-		 ORDER BY modified_at DESC LIMIT ? OFFSET ?`,
-		userID, pattern, pattern, limit, offset,
+		`SELECT `+qualifiedNoteColumns+`
+		 FROM `+from+` WHERE `+cond+`
+		 ORDER BY bm25(notes_fts, 0, 10, 1), notes.modified_at DESC LIMIT ? OFFSET ?`,
+		append(args, limit, offset)...,
 	)
 	if err != nil {
 		return nil, 0, fmt.Errorf("search notes: %w", err)
@@ -114,10 +171,24 @@ func (s *Store) SearchNotes(userID, query string, limit, offset int) ([]model.No
 	return notes, total, err
 }
 
+// ftsQuery turns free text into an FTS5 query. Each word is quoted so
+// punctuation and FTS operators in the input are taken literally.
+func ftsQuery(query string) string {
+	words := strings.Fields(query)
+	for i, w := range words {
+		words[i] = `"` + strings.ReplaceAll(w, `"`, `""`) + `"`
+	}
+	if len(words) == 0 {
+		return ""
+	}
+	words[len(words)-1] += "*"
+	return strings.Join(words, " ")
+}
+
 // GetNoteChangesSince returns all notes (including deleted) modified after sinceMs.
 func (s *Store) GetNoteChangesSince(userID string, sinceMs int64) ([]model.Note, error) {
 	rows, err := s.db.Query(
-		`SELECT id, user_id, title, content, type, modified_at, modified_by_device, deleted_at, created_at
+		`SELECT `+noteColumns+`
 		 FROM notes WHERE user_id = ? AND modified_at > ?
 		 ORDER BY modified_at ASC`,
 		userID, sinceMs,
@@ -129,24 +200,58 @@ func (s *Store) GetNoteChangesSince(userID string, sinceMs int64) ([]model.Note,
 	return scanNotes(rows)
 }
 
+// EachNote calls fn with every live note, snoozed ones included, most
+// recently modified first, without loading them all at once. It stops at
+// the first error fn returns.
+func (s *Store) EachNote(userID string, fn func(*model.Note) error) error {
+	rows, err := s.db.Query(
+		`SELECT `+noteColumns+`
+		 FROM notes WHERE user_id = ? AND deleted_at IS NULL
+		 ORDER BY modified_at DESC`,
+		userID,
+	)
+	if err != nil {
+		return fmt.Errorf("each note: %w", err)
+	}
+	defer rows.Close()
+	for rows.Next() {
+		n, err := scanNoteRow(rows)
+		if err != nil {
+			return fmt.Errorf("scan note row: %w", err)
+		}
+		if err := fn(n); err != nil {
+			return err
+		}
+	}
+	return rows.Err()
+}
+
 // UpsertNote stores a note using LWW: incoming wins if newer, or equal timestamp
 // with lexicographically higher device ID. Returns the existing note if it wins.
+// Used for server versions, so nothing is queued for push.
 func (s *Store) UpsertNote(n *model.Note) (*model.Note, error) {
 	existing, err := s.GetNoteAny(n.ID, n.UserID)
 	if errors.Is(err, ErrNotFound) {
-		return nil, s.CreateNote(n)
+		if err := insertNote(s.db, n); err != nil {
+			return nil, err
+		}
+		return nil, s.SetSyncedContent(n.ID, n.Content)
 	}
 	if err != nil {
 		return nil, err
 	}
+	// The server has this content whichever version wins locally.
+	if err := s.SetSyncedContent(n.ID, n.Content); err != nil {
+		return nil, err
+	}
 
 	if n.ModifiedAt.After(existing.ModifiedAt) ||
 		(n.ModifiedAt.Equal(existing.ModifiedAt) && n.ModifiedByDevice > existing.ModifiedByDevice) {
 		_, err := s.db.Exec(
-			`UPDATE notes SET title = ?, content = ?, type = ?, modified_at = ?,
-			 modified_by_device = ?, deleted_at = ?
+			`UPDATE notes SET title = ?, content = ?, type = ?, snoozed_until = ?, tags = ?,
+			 modified_at = ?, modified_by_device = ?, deleted_at = ?
 			 WHERE id = ? AND user_id = ?`,
-			n.Title, n.Content, n.Type, toMillis(n.ModifiedAt),
+			n.Title, n.Content, n.Type, toNullMillis(n.SnoozedUntil), joinTags(n.Tags), toMillis(n.ModifiedAt),
 			n.ModifiedByDevice, toNullMillis(n.DeletedAt),
 			n.ID, n.UserID,
 		)
@@ -159,42 +264,89 @@ func (s *Store) UpsertNote(n *model.Note) (*model.Note, error) {
 	return existing, nil
 }
 
-func scanNote(row *sql.Row) (*model.Note, error) {
+// noteColumns is the column list matching scanNoteRow.
+// SyncedContent returns the content the server last had for a note. ok is
+// false if the note was never synced.
+func (s *Store) SyncedContent(id string) (content string, ok bool, err error) {
+	var c sql.NullString
+	err = s.db.QueryRow(`SELECT synced_content FROM notes WHERE id = ?`, id).Scan(&c)
+	if errors.Is(err, sql.ErrNoRows) {
+		return "", false, nil
+	}
+	if err != nil {
+		return "", false, fmt.Errorf("get synced content: %w", err)
+	}
+	return c.String, c.Valid, nil
+}
+
+// SetSyncedContent records the content the server has for a note, after a
+// pull or an accepted push.
+func (s *Store) SetSyncedContent(id, content string) error {
+	if _, err := s.db.Exec(`UPDATE notes SET synced_content = ? WHERE id = ?`, content, id); err != nil {
+		return fmt.Errorf("set synced content: %w", err)
+	}
+	return nil
+}
+
+const noteColumns = `id, user_id, title, content, type, snoozed_until, tags,
+	modified_at, modified_by_device, deleted_at, created_at`
+
+// qualifiedNoteColumns is noteColumns for queries that join notes_fts,
+// which has id, title and content columns of its own.
+var qualifiedNoteColumns = qualifyColumns("notes", noteColumns)
+
+func qualifyColumns(table, columns string) string {
+	cols := strings.Split(columns, ",")
+	for i, c := range cols {
+		cols[i] = table + "." + strings.TrimSpace(c)
+	}
+	return strings.Join(cols, ", ")
+}
+
+// rowScanner is satisfied by both *sql.Row and *sql.Rows.
+type rowScanner interface {
+	Scan(dest ...any) error
+}
+
+func scanNoteRow(s rowScanner) (*model.Note, error) {
 	var n model.Note
 	var modifiedAt, createdAt int64
-	var deletedAt sql.NullInt64
-	err := row.Scan(
-		&n.ID, &n.UserID, &n.Title, &n.Content, &n.Type,
+	var deletedAt, snoozedUntil sql.NullInt64
+	var tags sql.NullString
+	err := s.Scan(
+		&n.ID, &n.UserID, &n.Title, &n.Content, &n.Type, &snoozedUntil, &tags,
 		&modifiedAt, &n.ModifiedByDevice, &deletedAt, &createdAt,
 	)
-	if errors.Is(err, sql.ErrNoRows) {
-		return nil, ErrNotFound
-	}
 	if err != nil {
-		return nil, fmt.Errorf("scan note: %w", err)
+		return nil, err
 	}
 	n.ModifiedAt = fromMillis(modifiedAt)
+	n.SnoozedUntil = fromNullMillis(snoozedUntil)
+	n.Tags = splitTags(tags)
 	n.DeletedAt = fromNullMillis(deletedAt)
 	n.CreatedAt = fromMillis(createdAt)
 	return &n, nil
 }
 
+func scanNote(row *sql.Row) (*model.Note, error) {
+	n, err := scanNoteRow(row)
+	if errors.Is(err, sql.ErrNoRows) {
+		return nil, ErrNotFound
+	}
+	if err != nil {
+		return nil, fmt.Errorf("scan note: %w", err)
+	}
+	return n, nil
+}
+
 func scanNotes(rows *sql.Rows) ([]model.Note, error) {
 	var notes []model.Note
 	for rows.Next() {
-		var n model.Note
-		var modifiedAt, createdAt int64
-		var deletedAt sql.NullInt64
-		if err := rows.Scan(
-			&n.ID, &n.UserID, &n.Title, &n.Content, &n.Type,
-			&modifiedAt, &n.ModifiedByDevice, &deletedAt, &createdAt,
-		); err != nil {
+		n, err := scanNoteRow(rows)
+		if err != nil {
 			return nil, fmt.Errorf("scan note row: %w", err)
 		}
-		n.ModifiedAt = fromMillis(modifiedAt)
-		n.DeletedAt = fromNullMillis(deletedAt)
-		n.CreatedAt = fromMillis(createdAt)
-		notes = append(notes, n)
+		notes = append(notes, *n)
 	}
 	return notes, rows.Err()
 }
@@ -0,0 +1,151 @@
+package store
+
+import (
+	"database/sql"
+	"fmt"
+	"time"
+
+	"github.com/c0dev0id/notesd/notes-cli/internal/model"
+)
+
+// Local writes are queued in pending_changes until a push has delivered
+// them. The queue holds only item references: the row itself is the payload,
+// so several edits to one item while offline push as its latest version.
+
+// execer is satisfied by both *sql.DB and *sql.Tx.
+type execer interface {
+	Exec(query string, args ...any) (sql.Result, error)
+}
+
+func (s *Store) withTx(fn func(tx *sql.Tx) error) error {
+	tx, err := s.db.Begin()
+	if err != nil {
+		return fmt.Errorf("begin tx: %w", err)
+	}
+	if err := fn(tx); err != nil {
+		tx.Rollback()
+		return err
+	}
+	return tx.Commit()
+}
+
+func enqueue(db execer, itemType, id string) error {
+	_, err := db.Exec(
+		`INSERT INTO pending_changes (item_type, item_id) VALUES (?, ?)
+		 ON CONFLICT DO NOTHING`, itemType, id,
+	)
+	if err != nil {
+		return fmt.Errorf("queue %s: %w", itemType, err)
+	}
+	return nil
+}
+
+// PendingNotes returns queued notes, including deleted ones.
+func (s *Store) PendingNotes(userID string) ([]model.Note, error) {
+	rows, err := s.db.Query(
+		`SELECT `+noteColumns+`
+		 FROM notes WHERE user_id = ?
+		   AND id IN (SELECT item_id FROM pending_changes WHERE item_type = 'note')
+		 ORDER BY modified_at ASC`, userID,
+	)
+	if err != nil {
+		return nil, fmt.Errorf("pending notes: %w", err)
+	}
+	defer rows.Close()
+	return scanNotes(rows)
+}
+
+// PendingTodos returns queued todos, including deleted ones.
+func (s *Store) PendingTodos(userID string) ([]model.Todo, error) {
+	rows, err := s.db.Query(
+		`SELECT `+todoColumns+`
+		 FROM todos WHERE user_id = ?
+		   AND id IN (SELECT item_id FROM pending_changes WHERE item_type = 'todo')
+		 ORDER BY modified_at ASC`, userID,
+	)
+	if err != nil {
+		return nil, fmt.Errorf("pending todos: %w", err)
+	}
+	defer rows.Close()
+	return scanTodos(rows)
+}
+
+// PendingCount returns the number of items waiting to be pushed.
+func (s *Store) PendingCount() (int, error) {
+	var n int
+	err := s.db.QueryRow(`SELECT COUNT(*) FROM pending_changes`).Scan(&n)
+	return n, err
+}
+
+// IsPending reports whether an item has local changes not yet pushed.
+func (s *Store) IsPending(itemType, id string) (bool, error) {
+	var n int
+	err := s.db.QueryRow(
+		`SELECT COUNT(*) FROM pending_changes WHERE item_type = ? AND item_id = ?`,
+		itemType, id,
+	).Scan(&n)
+	return n > 0, err
+}
+
+// ClearPushed removes an item from the queue after a push, unless it was
+// edited again after the pushed version was read.
+func (s *Store) ClearPushed(itemType, id string, pushed time.Time) error {
+	table := "notes"
+	if itemType == "todo" {
+		table = "todos"
+	}
+	_, err := s.db.Exec(
+		`DELETE FROM pending_changes WHERE item_type = ? AND item_id = ?
+		 AND NOT EXISTS (SELECT 1 FROM `+table+` WHERE id = ? AND modified_at > ?)`,
+		itemType, id, id, toMillis(pushed),
+	)
+	return err
+}
+
+// Dequeue drops an item from the queue. Used when the server's version won
+// and the local change is discarded.
+func (s *Store) Dequeue(itemType, id string) error {
+	_, err := s.db.Exec(
+		`DELETE FROM pending_changes WHERE item_type = ? AND item_id = ?`, itemType, id,
+	)
+	return err
+}
+
+// Purge removes an item the server deleted permanently, together with any
+// queued local change to it.
+func (s *Store) Purge(itemType, id string) error {
+	table := "notes"
+	if itemType == "todo" {
+		table = "todos"
+	}
+	return s.withTx(func(tx *sql.Tx) error {
+		if _, err := tx.Exec(`DELETE FROM `+table+` WHERE id = ?`, id); err != nil {
+			return fmt.Errorf("purge %s: %w", itemType, err)
+		}
+		_, err := tx.Exec(
+			`DELETE FROM pending_changes WHERE item_type = ? AND item_id = ?`, itemType, id,
+		)
+		return err
+	})
+}
+
+// DropSynced removes every cached note and todo without queued changes.
+// Used before applying a full resync, so items the server no longer has
+// disappear while unpushed local work is kept.
+func (s *Store) DropSynced(userID string) error {
+	return s.withTx(func(tx *sql.Tx) error {
+		if _, err := tx.Exec(
+			`DELETE FROM notes WHERE user_id = ?
+			 AND id NOT IN (SELECT item_id FROM pending_changes WHERE item_type = 'note')`, userID,
+		); err != nil {
+			return fmt.Errorf("drop synced notes: %w", err)
+		}
+		if _, err := tx.Exec(
+			`DELETE FROM todos WHERE user_id = ?
+			 AND id NOT IN (SELECT item_id FROM pending_changes WHERE item_type = 'todo')`, userID,
+		); err != nil {
+			return fmt.Errorf("drop synced todos: %w", err)
+		}
+		return nil
+	})
+}
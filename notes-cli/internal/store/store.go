@@ -5,6 +5,8 @@ import (
 	"errors"
 	"fmt"
 	"log/slog"
+	"os"
+	"path/filepath"
 	"time"
 
 	_ "modernc.org/sqlite"
@@ -30,18 +32,48 @@ func Open(path string) (*Store, error) {
 	return s, nil
 }
 
+// OpenCache opens the local cache in the config directory. A store left
+// under the pre-1.0 name notes.db is renamed to cache.db first.
+func OpenCache(configDir string) (*Store, error) {
+	path := filepath.Join(configDir, "cache.db")
+	legacy := filepath.Join(configDir, "notes.db")
+	if _, err := os.Stat(path); os.IsNotExist(err) {
+		if _, err := os.Stat(legacy); err == nil {
+			for _, suffix := range []string{"", "-wal", "-shm"} {
+				if err := os.Rename(legacy+suffix, path+suffix); err != nil && !os.IsNotExist(err) {
+					return nil, fmt.Errorf("move %s: %w", legacy+suffix, err)
+				}
+			}
+		}
+	}
+	return Open(path)
+}
+
 func (s *Store) Close() error {
 	return s.db.Close()
 }
 
 func (s *Store) migrate() error {
-	_, err := s.db.Exec(`
+	hasFTS, err := s.hasTable("notes_fts")
+	if err != nil {
+		return err
+	}
+	hasQueue, err := s.hasTable("pending_changes")
+	if err != nil {
+		return err
+	}
+
+	_, err = s.db.Exec(`
 		CREATE TABLE IF NOT EXISTS notes (
 			id                TEXT PRIMARY KEY,
 			user_id           TEXT NOT NULL,
 			title             TEXT NOT NULL DEFAULT '',
 			content           TEXT NOT NULL DEFAULT '',
+			-- Content as the server last had it, the base for delta sync.
+			synced_content    TEXT,
 			type              TEXT NOT NULL DEFAULT 'note',
+			snoozed_until     INTEGER,
+			tags              TEXT,
 			modified_at       INTEGER NOT NULL,
 			modified_by_device TEXT NOT NULL DEFAULT '',
 			deleted_at        INTEGER,
@@ -55,11 +87,22 @@ func (s *Store) migrate() error {
 			line_ref          TEXT,
 			content           TEXT NOT NULL DEFAULT '',
 			due_date          INTEGER,
+			-- 1 when due_date is a calendar day at midnight UTC.
+			all_day           INTEGER NOT NULL DEFAULT 0,
+			reminder_at       INTEGER,
 			completed         INTEGER NOT NULL DEFAULT 0,
+			tags              TEXT,
+			-- The checklist as a JSON array; NULL when unknown.
+			checklist         TEXT,
 			modified_at       INTEGER NOT NULL,
 			modified_by_device TEXT NOT NULL DEFAULT '',
 			deleted_at        INTEGER,
-			created_at        INTEGER NOT NULL
+			created_at        INTEGER NOT NULL,
+			-- Per-field change times for sync merges; 0 means modified_at.
+			content_modified_at   INTEGER NOT NULL DEFAULT 0,
+			due_date_modified_at  INTEGER NOT NULL DEFAULT 0,
+			completed_modified_at INTEGER NOT NULL DEFAULT 0,
+			note_id_modified_at   INTEGER NOT NULL DEFAULT 0
 		);
 
 		CREATE TABLE IF NOT EXISTS sync_state (
@@ -67,14 +110,125 @@ func (s *Store) migrate() error {
 			value TEXT NOT NULL
 		);
 
+		-- Local writes waiting to be pushed.
+		CREATE TABLE IF NOT EXISTS pending_changes (
+			item_type TEXT NOT NULL,
+			item_id   TEXT NOT NULL,
+			PRIMARY KEY (item_type, item_id)
+		);
+
 		CREATE INDEX IF NOT EXISTS idx_notes_user_modified
 			ON notes(user_id, modified_at);
 		CREATE INDEX IF NOT EXISTS idx_todos_user_modified
 			ON todos(user_id, modified_at);
 		CREATE INDEX IF NOT EXISTS idx_todos_due_date
 			ON todos(due_date) WHERE due_date IS NOT NULL;
+
+		-- Full-text index over note titles and content for offline search.
+		-- Triggers keep it current as notes are written locally or by sync.
+		CREATE VIRTUAL TABLE IF NOT EXISTS notes_fts USING fts5(
+			id UNINDEXED, title, content, tokenize = 'unicode61 remove_diacritics 2'
+		);
+
+		CREATE TRIGGER IF NOT EXISTS notes_fts_insert AFTER INSERT ON notes BEGIN
+			INSERT INTO notes_fts (id, title, content) VALUES (new.id, new.title, new.content);
+		END;
+		CREATE TRIGGER IF NOT EXISTS notes_fts_update AFTER UPDATE OF title, content ON notes BEGIN
+			DELETE FROM notes_fts WHERE id = old.id;
+			INSERT INTO notes_fts (id, title, content) VALUES (new.id, new.title, new.content);
+		END;
+		CREATE TRIGGER IF NOT EXISTS notes_fts_delete AFTER DELETE ON notes BEGIN
+			DELETE FROM notes_fts WHERE id = old.id;
+		END;
 	`)
-	return err
+	if err != nil {
+		return err
+	}
+
+	// Columns added after the first release are added to older caches,
+	// running fill once when one is.
+	for _, c := range []struct{ table, column, decl, fill string }{
+		{"notes", "snoozed_until", "INTEGER", ""},
+		{"notes", "tags", "TEXT", ""},
+		{"todos", "tags", "TEXT", ""},
+		{"todos", "reminder_at", "INTEGER", ""},
+		{"notes", "synced_content", "TEXT", ""},
+		{"todos", "content_modified_at", "INTEGER NOT NULL DEFAULT 0", ""},
+		{"todos", "due_date_modified_at", "INTEGER NOT NULL DEFAULT 0", ""},
+		{"todos", "completed_modified_at", "INTEGER NOT NULL DEFAULT 0", ""},
+		{"todos", "note_id_modified_at", "INTEGER NOT NULL DEFAULT 0", ""},
+		// Due dates were days until times of day came along.
+		{"todos", "all_day", "INTEGER NOT NULL DEFAULT 0",
+			`UPDATE todos SET all_day = 1 WHERE due_date % 86400000 = 0`},
+		{"todos", "checklist", "TEXT", ""},
+	} {
+		added, err := s.addColumn(c.table, c.column, c.decl)
+		if err != nil {
+			return err
+		}
+		if added && c.fill != "" {
+			if _, err := s.db.Exec(c.fill); err != nil {
+				return fmt.Errorf("fill %s.%s: %w", c.table, c.column, err)
+			}
+		}
+	}
+
+	// Caches created before the index existed are indexed once.
+	if !hasFTS {
+		_, err = s.db.Exec(`INSERT INTO notes_fts (id, title, content) SELECT id, title, content FROM notes`)
+		if err != nil {
+			return fmt.Errorf("build fts index: %w", err)
+		}
+	}
+
+	// Caches from before the queue existed pushed whatever changed since the
+	// last sync; queue exactly that so nothing is lost on upgrade.
+	if !hasQueue {
+		last, err := s.GetLastSyncAt()
+		if err != nil {
+			return fmt.Errorf("read last sync: %w", err)
+		}
+		_, err = s.db.Exec(
+			`INSERT INTO pending_changes (item_type, item_id)
+			 SELECT 'note', id FROM notes WHERE modified_at > ?
+			 UNION ALL
+			 SELECT 'todo', id FROM todos WHERE modified_at > ?`, last, last,
+		)
+		if err != nil {
+			return fmt.Errorf("seed pending changes: %w", err)
+		}
+	}
+	return nil
+}
+
+func (s *Store) hasTable(name string) (bool, error) {
+	var n int
+	err := s.db.QueryRow(
+		`SELECT COUNT(*) FROM sqlite_master WHERE type = 'table' AND name = ?`, name,
+	).Scan(&n)
+	if err != nil {
+		return false, fmt.Errorf("check %s: %w", name, err)
+	}
+	return n > 0, nil
+}
+
+// addColumn adds a column to a table unless it already exists, and
+// reports whether it did.
+func (s *Store) addColumn(table, column, decl string) (bool, error) {
+	var n int
+	err := s.db.QueryRow(
+		`SELECT COUNT(*) FROM pragma_table_info(?) WHERE name = ?`, table, column,
+	).Scan(&n)
+	if err != nil {
+		return false, fmt.Errorf("inspect %s: %w", table, err)
+	}
+	if n > 0 {
+		return false, nil
+	}
+	if _, err := s.db.Exec(`ALTER TABLE ` + table + ` ADD COLUMN ` + column + ` ` + decl); err != nil {
+		return false, fmt.Errorf("add %s.%s: %w", table, column, err)
+	}
+	return true, nil
 }
 
 // timestamp helpers
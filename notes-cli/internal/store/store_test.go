@@ -2,6 +2,8 @@ package store
 
 import (
 	"os"
+	"slices"
+	"strings"
 	"testing"
 	"time"
 
@@ -76,7 +78,7 @@ func TestListNotes(t *testing.T) {
 			t.Fatalf("CreateNote %d: %v", i, err)
 		}
 	}
-	notes, total, err := s.ListNotes(testUser, 10, 0)
+	notes, total, err := s.ListNotes(testUser, NoteFilter{}, 10, 0)
 	if err != nil {
 		t.Fatalf("ListNotes: %v", err)
 	}
@@ -86,6 +88,41 @@ func TestListNotes(t *testing.T) {
 	}
 }
 
+func TestListNotesHidesSnoozed(t *testing.T) {
+	s := openTestStore(t)
+	now := model.NowMillis()
+	until := now.Add(time.Hour)
+	// A snoozed note arriving via sync must keep its snooze date.
+	if _, err := s.UpsertNote(&model.Note{
+		ID: model.NewID(), UserID: testUser, Title: "Later", Type: "note",
+		SnoozedUntil: &until, ModifiedAt: now, ModifiedByDevice: testDevice, CreatedAt: now,
+	}); err != nil {
+		t.Fatalf("UpsertNote: %v", err)
+	}
+	if err := s.CreateNote(&model.Note{
+		ID: model.NewID(), UserID: testUser, Title: "Now", Type: "note",
+		ModifiedAt: now, ModifiedByDevice: testDevice, CreatedAt: now,
+	}); err != nil {
+		t.Fatalf("CreateNote: %v", err)
+	}
+
+	visible, _, err := s.ListNotes(testUser, NoteFilter{}, 10, 0)
+	if err != nil {
+		t.Fatalf("ListNotes: %v", err)
+	}
+	snoozed, _, err := s.ListNotes(testUser, NoteFilter{Snoozed: true}, 10, 0)
+	if err != nil {
+		t.Fatalf("ListNotes snoozed: %v", err)
+	}
+	t.Logf("visible=%d snoozed=%d", len(visible), len(snoozed))
+	if len(visible) != 1 || visible[0].Title != "Now" {
+		t.Errorf("default list should hide snoozed note, got %v", visible)
+	}
+	if len(snoozed) != 1 || snoozed[0].SnoozedUntil == nil || !snoozed[0].SnoozedUntil.Equal(until) {
+		t.Errorf("snoozed list: got %v", snoozed)
+	}
+}
+
 func TestUpdateNote(t *testing.T) {
 	s := openTestStore(t)
 	now := model.NowMillis()
@@ -217,6 +254,79 @@ func TestUpsertNoteLWW(t *testing.T) {
 	}
 }
 
+func TestUpsertTodoFieldMerge(t *testing.T) {
+	s := openTestStore(t)
+	base := time.Date(2024, 1, 1, 12, 0, 0, 0, time.UTC)
+	due := base.Add(72 * time.Hour)
+
+	// Arrange — a synced todo, rescheduled locally
+	todo := &model.Todo{
+		ID: model.NewID(), UserID: testUser, Content: "Call plumber",
+		ModifiedAt: base, ModifiedByDevice: "server", CreatedAt: base,
+	}
+	if _, _, err := s.UpsertTodo(todo); err != nil {
+		t.Fatalf("UpsertTodo: %v", err)
+	}
+	local := *todo
+	local.DueDate = &due
+	local.ModifiedAt = base.Add(2 * time.Minute)
+	local.ModifiedByDevice = testDevice
+	if err := s.UpdateTodo(&local); err != nil {
+		t.Fatalf("UpdateTodo: %v", err)
+	}
+
+	// Act — the server version completed it in between
+	server := *todo
+	server.Completed = true
+	server.ModifiedAt = base.Add(time.Minute)
+	server.FieldTimes = &model.TodoFieldTimes{
+		Content: base, DueDate: base, Completed: base.Add(time.Minute), NoteID: base,
+	}
+	kept, applied, err := s.UpsertTodo(&server)
+
+	// Assert — both changes are kept and the local edit still differs
+	if err != nil {
+		t.Fatalf("UpsertTodo: %v", err)
+	}
+	got, _ := s.GetTodo(todo.ID, testUser)
+	t.Logf("merged: completed=%v due=%v applied=%v kept=%v", got.Completed, got.DueDate, applied, kept != nil)
+	if !got.Completed || got.DueDate == nil || !got.DueDate.Equal(due) {
+		t.Errorf("expected completion and due date, got completed=%v due=%v", got.Completed, got.DueDate)
+	}
+	if !applied || kept == nil {
+		t.Errorf("expected a merge keeping local fields, got applied=%v kept=%v", applied, kept)
+	}
+	if !got.ModifiedAt.Equal(local.ModifiedAt) || !got.FieldTimes.DueDate.Equal(local.ModifiedAt) {
+		t.Errorf("local edit times lost: modified=%v fields=%+v", got.ModifiedAt, got.FieldTimes)
+	}
+}
+
+func TestMergeTodoTies(t *testing.T) {
+	// Arrange — two versions whose every field changed at the same moment
+	at := time.Date(2024, 1, 1, 12, 0, 0, 0, time.UTC)
+	due, later := at.Add(24*time.Hour), at.Add(48*time.Hour)
+	note := "n1"
+	times := &model.TodoFieldTimes{Content: at, DueDate: at, Completed: at, NoteID: at}
+	phone := &model.Todo{ID: "t1", Content: "Pay rent in cash", DueDate: &later, Completed: true,
+		ModifiedAt: at, ModifiedByDevice: "phone", FieldTimes: times}
+	laptop := &model.Todo{ID: "t1", Content: "Pay rent", DueDate: &due, NoteID: &note,
+		ModifiedAt: at, ModifiedByDevice: "laptop", FieldTimes: times}
+
+	// Act — merge either way round
+	a, _, _ := mergeTodo(phone, laptop)
+	b, _, _ := mergeTodo(laptop, phone)
+
+	// Assert — the same greater value of each field wins both times, as on
+	// the server
+	for _, m := range []model.Todo{a, b} {
+		t.Logf("merged: content=%q due=%v completed=%v note=%v", m.Content, m.DueDate, m.Completed, m.NoteID)
+		if m.Content != "Pay rent in cash" || m.DueDate == nil || !m.DueDate.Equal(later) || !m.Completed ||
+			m.NoteID == nil || *m.NoteID != "n1" {
+			t.Errorf("unexpected merge: %+v", m)
+		}
+	}
+}
+
 func TestNoteChangesSince(t *testing.T) {
 	s := openTestStore(t)
 	base := model.NowMillis()
@@ -260,7 +370,7 @@ func TestSearchNotes(t *testing.T) {
 		}
 	}
 
-	results, total, err := s.SearchNotes(testUser, "Meeting", 10, 0)
+	results, total, err := s.SearchNotes(testUser, "Meeting", NoteFilter{}, 10, 0)
 	if err != nil {
 		t.Fatalf("SearchNotes: %v", err)
 	}
@@ -270,6 +380,114 @@ func TestSearchNotes(t *testing.T) {
 	}
 }
 
+func TestSearchIndexFollowsChanges(t *testing.T) {
+	s := openTestStore(t)
+	now := model.NowMillis()
+	body := &model.Note{
+		ID: model.NewID(), UserID: testUser, Title: "Groceries", Content: "remember the avocados",
+		Type: "note", ModifiedAt: now, ModifiedByDevice: testDevice, CreatedAt: now,
+	}
+	titled := &model.Note{
+		ID: model.NewID(), UserID: testUser, Title: "Avocado recipes", Type: "note",
+		ModifiedAt: now, ModifiedByDevice: testDevice, CreatedAt: now,
+	}
+	for _, n := range []*model.Note{body, titled} {
+		if err := s.CreateNote(n); err != nil {
+			t.Fatalf("seed: %v", err)
+		}
+	}
+
+	// Prefix match on the last word, title hits ranked first
+	results, _, err := s.SearchNotes(testUser, "avoc", NoteFilter{}, 10, 0)
+	if err != nil {
+		t.Fatalf("SearchNotes: %v", err)
+	}
+	t.Logf("search 'avoc': %d results", len(results))
+	if len(results) != 2 || results[0].ID != titled.ID {
+		t.Fatalf("expected title match first, got %+v", results)
+	}
+
+	// A newer version arriving through sync replaces the indexed text
+	incoming := *body
+	incoming.Content = "bananas only"
+	incoming.ModifiedAt = now.Add(time.Second)
+	if _, err := s.UpsertNote(&incoming); err != nil {
+		t.Fatalf("UpsertNote: %v", err)
+	}
+	results, _, _ = s.SearchNotes(testUser, "avocados", NoteFilter{}, 10, 0)
+	t.Logf("search 'avocados' after upsert: %d results", len(results))
+	if len(results) != 0 {
+		t.Errorf("stale content still indexed")
+	}
+	results, _, _ = s.SearchNotes(testUser, "bananas", NoteFilter{}, 10, 0)
+	if len(results) != 1 {
+		t.Errorf("expected new content to be indexed, got %d results", len(results))
+	}
+
+	// Deleted notes and FTS syntax in the query are handled
+	if err := s.DeleteNote(titled.ID, testUser, now.UnixMilli()+2000, testDevice); err != nil {
+		t.Fatalf("DeleteNote: %v", err)
+	}
+	results, _, err = s.SearchNotes(testUser, `avocado OR "`, NoteFilter{}, 10, 0)
+	t.Logf("search with operators: %d results err=%v", len(results), err)
+	if err != nil || len(results) != 0 {
+		t.Errorf("expected no results and no error")
+	}
+}
+
+func TestNoteTags(t *testing.T) {
+	s := openTestStore(t)
+	now := model.NowMillis()
+	work := &model.Note{
+		ID: model.NewID(), UserID: testUser, Title: "Standup", Type: "note", Tags: []string{"urgent", "Work"},
+		ModifiedAt: now, ModifiedByDevice: testDevice, CreatedAt: now,
+	}
+	unknown := &model.Note{
+		ID: model.NewID(), UserID: testUser, Title: "Old", Type: "note",
+		ModifiedAt: now, ModifiedByDevice: testDevice, CreatedAt: now,
+	}
+	todo := &model.Todo{
+		ID: model.NewID(), UserID: testUser, Content: "Report", Tags: []string{"work"},
+		ModifiedAt: now, ModifiedByDevice: testDevice, CreatedAt: now,
+	}
+	for _, n := range []*model.Note{work, unknown} {
+		if err := s.CreateNote(n); err != nil {
+			t.Fatalf("CreateNote: %v", err)
+		}
+	}
+	if err := s.CreateTodo(todo); err != nil {
+		t.Fatalf("CreateTodo: %v", err)
+	}
+
+	// Tags round-trip; nil stays nil so a push leaves server tags alone
+	got, _ := s.GetNote(unknown.ID, testUser)
+	t.Logf("untagged note tags: %#v", got.Tags)
+	if got.Tags != nil {
+		t.Errorf("expected nil tags, got %#v", got.Tags)
+	}
+
+	notes, total, err := s.ListNotes(testUser, NoteFilter{Tag: "work"}, 10, 0)
+	if err != nil {
+		t.Fatalf("ListNotes: %v", err)
+	}
+	t.Logf("notes tagged work: %d", total)
+	if total != 1 || notes[0].ID != work.ID {
+		t.Errorf("tag filter: got %+v", notes)
+	}
+	if _, total, _ := s.ListNotes(testUser, NoteFilter{Tag: "wor"}, 10, 0); total != 0 {
+		t.Errorf("partial tag name matched %d notes", total)
+	}
+
+	tags, err := s.ListTags(testUser)
+	if err != nil {
+		t.Fatalf("ListTags: %v", err)
+	}
+	t.Logf("tags: %+v", tags)
+	if len(tags) != 2 || tags[1].Name != "Work" || tags[1].Notes != 1 || tags[1].Todos != 1 {
+		t.Errorf("unexpected tag usage: %+v", tags)
+	}
+}
+
 // --- Todo tests ---
 
 func TestCreateAndGetTodo(t *testing.T) {
@@ -317,6 +535,49 @@ func TestCompleteTodo(t *testing.T) {
 	}
 }
 
+func TestTodoChecklist(t *testing.T) {
+	s := openTestStore(t)
+	now := model.NowMillis()
+
+	// Arrange: a todo with a checklist, and one cached before checklists
+	td := &model.Todo{
+		ID: model.NewID(), UserID: testUser, Content: "Pack",
+		ModifiedAt: now, ModifiedByDevice: testDevice, CreatedAt: now,
+		Checklist: []model.ChecklistItem{{ID: "a", Text: "passport", Done: true}, {ID: "b", Text: "socks"}},
+	}
+	old := &model.Todo{
+		ID: model.NewID(), UserID: testUser, Content: "Old",
+		ModifiedAt: now, ModifiedByDevice: testDevice, CreatedAt: now,
+	}
+	for _, x := range []*model.Todo{td, old} {
+		if err := s.CreateTodo(x); err != nil {
+			t.Fatalf("CreateTodo: %v", err)
+		}
+	}
+
+	// Act: the server sends a newer version without a checklist
+	pulled := *td
+	pulled.Checklist = nil
+	pulled.Content = "Pack bags"
+	pulled.ModifiedAt = now.Add(time.Minute)
+	pulled.FieldTimes = nil
+	if _, _, err := s.UpsertTodo(&pulled); err != nil {
+		t.Fatalf("UpsertTodo: %v", err)
+	}
+
+	// Assert
+	got, _ := s.GetTodo(td.ID, testUser)
+	t.Logf("merged: %q %+v %s", got.Content, got.Checklist, got.ChecklistText())
+	if got.Content != "Pack bags" || len(got.Checklist) != 2 || got.ChecklistText() != "1/2" {
+		t.Errorf("expected content taken and checklist kept, got %+v", got)
+	}
+	gotOld, _ := s.GetTodo(old.ID, testUser)
+	t.Logf("old: %+v", gotOld.Checklist)
+	if gotOld.Checklist != nil || gotOld.ChecklistText() != "" {
+		t.Error("expected no checklist to stay unknown")
+	}
+}
+
 func TestGetOverdueTodos(t *testing.T) {
 	s := openTestStore(t)
 	now := model.NowMillis()
@@ -331,7 +592,14 @@ func TestGetOverdueTodos(t *testing.T) {
 		ID: model.NewID(), UserID: testUser, Content: "Upcoming",
 		DueDate: &future, ModifiedAt: now, ModifiedByDevice: testDevice, CreatedAt: now,
 	}
-	for _, td := range []*model.Todo{overdue, upcoming} {
+	// An all-day todo due today is not overdue until the day ends.
+	y, m, d := time.Now().Date()
+	today := time.Date(y, m, d, 0, 0, 0, 0, time.UTC)
+	allDay := &model.Todo{
+		ID: model.NewID(), UserID: testUser, Content: "Today",
+		DueDate: &today, AllDay: true, ModifiedAt: now, ModifiedByDevice: testDevice, CreatedAt: now,
+	}
+	for _, td := range []*model.Todo{overdue, upcoming, allDay} {
 		if err := s.CreateTodo(td); err != nil {
 			t.Fatalf("seed: %v", err)
 		}
@@ -389,3 +657,368 @@ func TestSyncStateRoundtrip(t *testing.T) {
 		t.Errorf("expected %d after update, got %d", ts2, got)
 	}
 }
+
+func TestNoteTypesCache(t *testing.T) {
+	s := openTestStore(t)
+
+	// Initial state: never asked
+	types, err := s.GetNoteTypes()
+	if err != nil {
+		t.Fatalf("GetNoteTypes initial: %v", err)
+	}
+	t.Logf("initial note types: %v", types)
+	if types != nil {
+		t.Errorf("expected nil initially, got %v", types)
+	}
+
+	// Set and get back
+	want := []string{"note", "todo_list", "journal"}
+	if err := s.SetNoteTypes(want); err != nil {
+		t.Fatalf("SetNoteTypes: %v", err)
+	}
+	types, err = s.GetNoteTypes()
+	if err != nil {
+		t.Fatalf("GetNoteTypes after set: %v", err)
+	}
+	t.Logf("note types after set: %v", types)
+	if !slices.Equal(types, want) {
+		t.Errorf("expected %v, got %v", want, types)
+	}
+}
+
+func TestPendingQueue(t *testing.T) {
+	s := openTestStore(t)
+	now := model.NowMillis()
+
+	// Local writes are queued
+	local := &model.Note{
+		ID: model.NewID(), UserID: testUser, Title: "Local", Type: "note",
+		ModifiedAt: now, ModifiedByDevice: testDevice, CreatedAt: now,
+	}
+	if err := s.CreateNote(local); err != nil {
+		t.Fatalf("CreateNote: %v", err)
+	}
+	todo := &model.Todo{
+		ID: model.NewID(), UserID: testUser, Content: "Offline todo",
+		ModifiedAt: now, ModifiedByDevice: testDevice, CreatedAt: now,
+	}
+	if err := s.CreateTodo(todo); err != nil {
+		t.Fatalf("CreateTodo: %v", err)
+	}
+
+	// Pulled server versions are not
+	pulled := &model.Note{
+		ID: model.NewID(), UserID: testUser, Title: "From server", Type: "note",
+		ModifiedAt: now, ModifiedByDevice: "other-device", CreatedAt: now,
+	}
+	if _, err := s.UpsertNote(pulled); err != nil {
+		t.Fatalf("UpsertNote: %v", err)
+	}
+
+	notes, err := s.PendingNotes(testUser)
+	if err != nil {
+		t.Fatalf("PendingNotes: %v", err)
+	}
+	todos, err := s.PendingTodos(testUser)
+	if err != nil {
+		t.Fatalf("PendingTodos: %v", err)
+	}
+	t.Logf("pending: %d notes, %d todos", len(notes), len(todos))
+	if len(notes) != 1 || notes[0].ID != local.ID {
+		t.Fatalf("expected only the local note pending, got %+v", notes)
+	}
+	if len(todos) != 1 || todos[0].ID != todo.ID {
+		t.Fatalf("expected the local todo pending, got %+v", todos)
+	}
+
+	// An edit made after the push read the row keeps it queued
+	pushed := notes[0].ModifiedAt
+	local.Title = "Edited during push"
+	local.ModifiedAt = now.Add(time.Second)
+	if err := s.UpdateNote(local); err != nil {
+		t.Fatalf("UpdateNote: %v", err)
+	}
+	if err := s.ClearPushed("note", local.ID, pushed); err != nil {
+		t.Fatalf("ClearPushed: %v", err)
+	}
+	if p, _ := s.IsPending("note", local.ID); !p {
+		t.Error("note edited during push should stay queued")
+	}
+
+	// Once the latest version is pushed the queue empties
+	if err := s.ClearPushed("note", local.ID, local.ModifiedAt); err != nil {
+		t.Fatalf("ClearPushed: %v", err)
+	}
+	if err := s.Dequeue("todo", todo.ID); err != nil {
+		t.Fatalf("Dequeue: %v", err)
+	}
+	n, err := s.PendingCount()
+	if err != nil {
+		t.Fatalf("PendingCount: %v", err)
+	}
+	t.Logf("pending after clear: %d", n)
+	if n != 0 {
+		t.Errorf("expected empty queue, got %d", n)
+	}
+
+	// Deletions are queued too
+	if err := s.DeleteNote(local.ID, testUser, now.Add(2*time.Second).UnixMilli(), testDevice); err != nil {
+		t.Fatalf("DeleteNote: %v", err)
+	}
+	notes, _ = s.PendingNotes(testUser)
+	if len(notes) != 1 || notes[0].DeletedAt == nil {
+		t.Errorf("expected queued deletion, got %+v", notes)
+	}
+}
+
+func TestPurgeAndDropSynced(t *testing.T) {
+	s := openTestStore(t)
+	now := model.NowMillis()
+	local := &model.Note{
+		ID: model.NewID(), UserID: testUser, Title: "Unpushed", Type: "note",
+		ModifiedAt: now, ModifiedByDevice: testDevice, CreatedAt: now,
+	}
+	if err := s.CreateNote(local); err != nil {
+		t.Fatalf("CreateNote: %v", err)
+	}
+	synced := &model.Note{
+		ID: model.NewID(), UserID: testUser, Title: "Synced", Type: "note",
+		ModifiedAt: now, ModifiedByDevice: "other-device", CreatedAt: now,
+	}
+	if _, err := s.UpsertNote(synced); err != nil {
+		t.Fatalf("UpsertNote: %v", err)
+	}
+
+	// Act / Assert: a full resync keeps only unpushed work
+	if err := s.DropSynced(testUser); err != nil {
+		t.Fatalf("DropSynced: %v", err)
+	}
+	if _, err := s.GetNoteAny(synced.ID, testUser); err != ErrNotFound {
+		t.Errorf("synced note should be dropped, got %v", err)
+	}
+	if _, err := s.GetNoteAny(local.ID, testUser); err != nil {
+		t.Errorf("unpushed note should be kept: %v", err)
+	}
+
+	// A purge removes the item and its queued change
+	if err := s.Purge("note", local.ID); err != nil {
+		t.Fatalf("Purge: %v", err)
+	}
+	_, err := s.GetNoteAny(local.ID, testUser)
+	n, _ := s.PendingCount()
+	t.Logf("after purge: get=%v pending=%d", err, n)
+	if err != ErrNotFound || n != 0 {
+		t.Errorf("expected purged note gone and dequeued, got %v, %d pending", err, n)
+	}
+}
+
+func TestSyncedContent(t *testing.T) {
+	s := openTestStore(t)
+	now := model.NowMillis()
+
+	// Arrange: one local note, one pulled from the server
+	local := &model.Note{
+		ID: model.NewID(), UserID: testUser, Title: "Local", Content: "draft\n", Type: "note",
+		ModifiedAt: now, ModifiedByDevice: testDevice, CreatedAt: now,
+	}
+	if err := s.CreateNote(local); err != nil {
+		t.Fatalf("CreateNote: %v", err)
+	}
+	pulled := &model.Note{
+		ID: model.NewID(), UserID: testUser, Title: "Server", Content: "from server\n", Type: "note",
+		ModifiedAt: now, ModifiedByDevice: "other-device", CreatedAt: now,
+	}
+	if _, err := s.UpsertNote(pulled); err != nil {
+		t.Fatalf("UpsertNote: %v", err)
+	}
+
+	// Act: edit the pulled note locally
+	pulled.Content = "edited locally\n"
+	pulled.ModifiedAt = now.Add(time.Second)
+	if err := s.UpdateNote(pulled); err != nil {
+		t.Fatalf("UpdateNote: %v", err)
+	}
+
+	// Assert: the synced base is what the server sent, not the local edit
+	if _, ok, _ := s.SyncedContent(local.ID); ok {
+		t.Error("never-synced note should have no synced content")
+	}
+	base, ok, err := s.SyncedContent(pulled.ID)
+	t.Logf("synced content: %q ok=%v", base, ok)
+	if err != nil || !ok || base != "from server\n" {
+		t.Errorf("expected server content as base, got %q, %v, %v", base, ok, err)
+	}
+
+	// An older server version losing LWW still updates the base
+	stale := *pulled
+	stale.Content = "server moved on\n"
+	stale.ModifiedAt = now
+	if winner, _ := s.UpsertNote(&stale); winner == nil {
+		t.Fatal("local edit should win")
+	}
+	if base, _, _ := s.SyncedContent(pulled.ID); base != "server moved on\n" {
+		t.Errorf("base after lost upsert: %q", base)
+	}
+}
+
+func TestBacklinks(t *testing.T) {
+	s := openTestStore(t)
+	now := model.NowMillis()
+	newNote := func(title, content string) *model.Note {
+		n := &model.Note{
+			ID: model.NewID(), UserID: testUser, Title: title, Content: content, Type: "note",
+			ModifiedAt: now, ModifiedByDevice: testDevice, CreatedAt: now,
+		}
+		if err := s.CreateNote(n); err != nil {
+			t.Fatalf("CreateNote: %v", err)
+		}
+		return n
+	}
+
+	// Arrange
+	target := newNote("Beta", "self [[Beta]]")
+	linker := newNote("Alpha", "see [[ beta |b]]")
+	newNote("Gamma", "see [[Betamax]] and [Beta]")
+	deleted := newNote("Delta", "[[Beta]]")
+	if err := s.DeleteNote(deleted.ID, testUser, now.UnixMilli(), testDevice); err != nil {
+		t.Fatalf("DeleteNote: %v", err)
+	}
+
+	// Act
+	back, err := s.Backlinks(target.ID, testUser)
+
+	// Assert
+	t.Logf("backlinks: %d, err=%v", len(back), err)
+	if err != nil {
+		t.Fatalf("Backlinks: %v", err)
+	}
+	if len(back) != 1 || back[0].ID != linker.ID {
+		t.Errorf("expected only Alpha, got %+v", back)
+	}
+}
+
+func TestListTodosDueFilter(t *testing.T) {
+	s := openTestStore(t)
+	now := model.NowMillis()
+	day := func(n int) *time.Time {
+		d := time.Date(2026, 3, n, 0, 0, 0, 0, time.UTC)
+		return &d
+	}
+
+	// Arrange: due on the 2nd, 4th and 9th, plus one at 23:00 local time on
+	// the 8th and one without a due date
+	late := time.Date(2026, 3, 8, 23, 0, 0, 0, time.Local)
+	for _, td := range []struct {
+		content string
+		due     *time.Time
+	}{{"ninth", day(9)}, {"fourth", day(4)}, {"second", day(2)}, {"eighth", &late}, {"undated", nil}} {
+		if err := s.CreateTodo(&model.Todo{
+			ID: model.NewID(), UserID: testUser, Content: td.content, DueDate: td.due,
+			AllDay: td.due != nil && td.due != &late, ModifiedAt: now, ModifiedByDevice: testDevice, CreatedAt: now,
+		}); err != nil {
+			t.Fatalf("CreateTodo: %v", err)
+		}
+	}
+
+	// Act
+	todos, total, err := s.ListTodos(testUser, TodoFilter{DueAfter: day(2), DueBefore: day(9)}, 10, 0)
+
+	// Assert
+	var got []string
+	for _, td := range todos {
+		got = append(got, td.Content)
+	}
+	t.Logf("filtered: %v (total %d, err %v)", got, total, err)
+	if err != nil {
+		t.Fatalf("ListTodos: %v", err)
+	}
+	if strings.Join(got, ",") != "second,fourth,eighth" || total != 3 {
+		t.Errorf("expected [second fourth eighth] in due order, got %v (total %d)", got, total)
+	}
+}
+
+func TestListTodosStatusAndNoteFilter(t *testing.T) {
+	s := openTestStore(t)
+	now := model.NowMillis()
+	noteID := model.NewID()
+
+	// Arrange: on the note an open and a completed todo, elsewhere an open one
+	for _, td := range []struct {
+		content   string
+		noteID    *string
+		completed bool
+	}{{"open on note", &noteID, false}, {"done on note", &noteID, true}, {"open loose", nil, false}} {
+		if err := s.CreateTodo(&model.Todo{
+			ID: model.NewID(), UserID: testUser, NoteID: td.noteID, Content: td.content,
+			Completed: td.completed, ModifiedAt: now, ModifiedByDevice: testDevice, CreatedAt: now,
+		}); err != nil {
+			t.Fatalf("CreateTodo: %v", err)
+		}
+	}
+	open, done := false, true
+	count := func(f TodoFilter) int {
+		_, total, err := s.ListTodos(testUser, f, 10, 0)
+		if err != nil {
+			t.Fatalf("ListTodos: %v", err)
+		}
+		return total
+	}
+
+	// Act
+	openAll := count(TodoFilter{Completed: &open})
+	doneAll := count(TodoFilter{Completed: &done})
+	onNote := count(TodoFilter{NoteID: noteID})
+	openOnNote := count(TodoFilter{NoteID: noteID, Completed: &open})
+	openMatching := count(TodoFilter{Contains: "ON NOTE", Completed: &open})
+	orphaned := count(TodoFilter{Orphaned: true})
+
+	// Assert
+	t.Logf("open=%d done=%d on note=%d open on note=%d open matching=%d", openAll, doneAll, onNote, openOnNote, openMatching)
+	if openAll != 2 || doneAll != 1 || onNote != 2 || openOnNote != 1 {
+		t.Errorf("expected 2 open, 1 done, 2 on the note and 1 open on it")
+	}
+	if openMatching != 1 {
+		t.Errorf("content search: got %d open todos containing %q, want 1", openMatching, "on note")
+	}
+	t.Logf("orphaned=%d", orphaned)
+	if orphaned != 2 {
+		t.Errorf("orphaned: got %d, want the 2 todos of the note missing from the store", orphaned)
+	}
+}
+
+func TestMatchNotes(t *testing.T) {
+	s := openTestStore(t)
+	now := model.NowMillis()
+
+	// Arrange: IDs sharing a prefix, and a deleted note that must not match
+	for _, n := range []struct{ id, title string }{
+		{"ab12-0001", "Shopping list"}, {"ab34-0002", "Packing list"}, {"cd56-0003", "Ideas"}, {"ab99-0004", "Old list"},
+	} {
+		if err := s.CreateNote(&model.Note{
+			ID: n.id, UserID: testUser, Title: n.title, Type: "note",
+			ModifiedAt: now, ModifiedByDevice: testDevice, CreatedAt: now,
+		}); err != nil {
+			t.Fatalf("CreateNote: %v", err)
+		}
+	}
+	if err := s.DeleteNote("ab99-0004", testUser, now.UnixMilli(), testDevice); err != nil {
+		t.Fatalf("DeleteNote: %v", err)
+	}
+
+	for _, c := range []struct {
+		ref  string
+		want int
+	}{{"ab", 2}, {"ab1", 1}, {"cd56-0003", 1}, {"LIST", 2}, {"shop", 1}, {"old", 0}, {"%", 0}} {
+		// Act
+		got, err := s.MatchNotes(testUser, c.ref, 10)
+
+		// Assert
+		t.Logf("%q -> %d notes, err=%v", c.ref, len(got), err)
+		if err != nil {
+			t.Fatalf("MatchNotes(%q): %v", c.ref, err)
+		}
+		if len(got) != c.want {
+			t.Errorf("MatchNotes(%q) = %d notes, want %d", c.ref, len(got), c.want)
+		}
+	}
+}
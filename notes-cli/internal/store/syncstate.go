@@ -4,9 +4,13 @@ import (
 	"database/sql"
 	"errors"
 	"strconv"
+	"strings"
 )
 
-const keySyncAt = "last_sync_at"
+const (
+	keySyncAt    = "last_sync_at"
+	keyNoteTypes = "note_types"
+)
 
 // GetLastSyncAt returns the last successful sync timestamp in unix milliseconds.
 // Returns 0 if no sync has occurred yet.
@@ -33,3 +37,29 @@ func (s *Store) SetLastSyncAt(ms int64) error {
 	)
 	return err
 }
+
+// GetNoteTypes returns the note types the server accepts, as cached by the
+// last sync. Returns nil if the server has not been asked yet.
+func (s *Store) GetNoteTypes() ([]string, error) {
+	var val string
+	err := s.db.QueryRow(
+		`SELECT value FROM sync_state WHERE key = ?`, keyNoteTypes,
+	).Scan(&val)
+	if errors.Is(err, sql.ErrNoRows) || val == "" {
+		return nil, nil
+	}
+	if err != nil {
+		return nil, err
+	}
+	return strings.Split(val, ","), nil
+}
+
+// SetNoteTypes caches the note types the server accepts.
+func (s *Store) SetNoteTypes(types []string) error {
+	_, err := s.db.Exec(
+		`INSERT INTO sync_state(key, value) VALUES(?, ?)
+		 ON CONFLICT(key) DO UPDATE SET value = excluded.value`,
+		keyNoteTypes, strings.Join(types, ","),
+	)
+	return err
+}
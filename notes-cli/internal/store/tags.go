@@ -0,0 +1,83 @@
+package store
+
+import (
+	"database/sql"
+	"fmt"
+	"sort"
+	"strings"
+
+	"github.com/c0dev0id/notesd/notes-cli/internal/model"
+)
+
+// tagSep separates tag names in the tags column, as on the server.
+const tagSep = "\x1f"
+
+// joinTags encodes tags for the tags column. nil is stored as NULL: the
+// item's tags are unknown, and pushing it leaves the server's tags alone.
+func joinTags(tags []string) sql.NullString {
+	if tags == nil {
+		return sql.NullString{}
+	}
+	return sql.NullString{String: strings.Join(tags, tagSep), Valid: true}
+}
+
+func splitTags(s sql.NullString) []string {
+	if !s.Valid {
+		return nil
+	}
+	if s.String == "" {
+		return []string{}
+	}
+	return strings.Split(s.String, tagSep)
+}
+
+// tagMatch returns a condition matching rows whose tags column contains the
+// tag given as the next argument, ignoring case.
+func tagMatch(col string) string {
+	return `instr(char(31) || lower(coalesce(` + col + `, '')) || char(31), char(31) || lower(?) || char(31)) > 0`
+}
+
+// ListTags counts the live notes and todos carrying each tag. Names that
+// differ only in case are counted together under the first spelling seen.
+func (s *Store) ListTags(userID string) ([]model.TagUsage, error) {
+	usage := map[string]*model.TagUsage{}
+	count := func(table string, add func(*model.TagUsage)) error {
+		rows, err := s.db.Query(
+			`SELECT tags FROM `+table+` WHERE user_id = ? AND deleted_at IS NULL AND tags != ''`,
+			userID,
+		)
+		if err != nil {
+			return fmt.Errorf("list %s tags: %w", table, err)
+		}
+		defer rows.Close()
+		for rows.Next() {
+			var tags sql.NullString
+			if err := rows.Scan(&tags); err != nil {
+				return fmt.Errorf("scan %s tags: %w", table, err)
+			}
+			for _, name := range splitTags(tags) {
+				key := strings.ToLower(name)
+				if usage[key] == nil {
+					usage[key] = &model.TagUsage{Name: name}
+				}
+				add(usage[key])
+			}
+		}
+		return rows.Err()
+	}
+	if err := count("notes", func(u *model.TagUsage) { u.Notes++ }); err != nil {
+		return nil, err
+	}
+	if err := count("todos", func(u *model.TagUsage) { u.Todos++ }); err != nil {
+		return nil, err
+	}
+
+	tags := make([]model.TagUsage, 0, len(usage))
+	for _, u := range usage {
+		tags = append(tags, *u)
+	}
+	sort.Slice(tags, func(i, j int) bool {
+		return strings.ToLower(tags[i].Name) < strings.ToLower(tags[j].Name)
+	})
+	return tags, nil
+}
@@ -2,22 +2,36 @@ package store
 
 import (
 	"database/sql"
+	"encoding/json"
 	"errors"
 	"fmt"
+	"time"
 
 	"github.com/c0dev0id/notesd/notes-cli/internal/model"
 )
 
+// CreateTodo inserts a todo and queues it for the next push.
 func (s *Store) CreateTodo(t *model.Todo) error {
-	_, err := s.db.Exec(
+	return s.withTx(func(tx *sql.Tx) error {
+		if err := insertTodo(tx, t); err != nil {
+			return err
+		}
+		return enqueue(tx, "todo", t.ID)
+	})
+}
+
+func insertTodo(db execer, t *model.Todo) error {
+	ft := fieldTimes(t)
+	_, err := db.Exec(
 		`INSERT INTO todos
-		 (id, user_id, note_id, line_ref, content, due_date, completed,
-		  modified_at, modified_by_device, deleted_at, created_at)
-		 VALUES (?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?)`,
+		 (`+todoColumns+`)
+		 VALUES (?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?)`,
 		t.ID, t.UserID, t.NoteID, t.LineRef, t.Content,
-		toNullMillis(t.DueDate), t.Completed,
+		toNullMillis(t.DueDate), t.AllDay, toNullMillis(t.ReminderAt), t.Completed, joinTags(t.Tags),
+		joinChecklist(t.Checklist),
 		toMillis(t.ModifiedAt), t.ModifiedByDevice,
 		toNullMillis(t.DeletedAt), toMillis(t.CreatedAt),
+		toMillis(ft.Content), toMillis(ft.DueDate), toMillis(ft.Completed), toMillis(ft.NoteID),
 	)
 	if err != nil {
 		return fmt.Errorf("create todo: %w", err)
@@ -27,8 +41,7 @@ func (s *Store) CreateTodo(t *model.Todo) error {
 
 func (s *Store) GetTodo(id, userID string) (*model.Todo, error) {
 	row := s.db.QueryRow(
-		`SELECT id, user_id, note_id, line_ref, content, due_date, completed,
-		 modified_at, modified_by_device, deleted_at, created_at
+		`SELECT `+todoColumns+`
 		 FROM todos WHERE id = ? AND user_id = ? AND deleted_at IS NULL`, id, userID,
 	)
 	return scanTodo(row)
@@ -36,28 +49,92 @@ func (s *Store) GetTodo(id, userID string) (*model.Todo, error) {
 
 func (s *Store) GetTodoAny(id, userID string) (*model.Todo, error) {
 	row := s.db.QueryRow(
-		`SELECT id, user_id, note_id, line_ref, content, due_date, completed,
-		 modified_at, modified_by_device, deleted_at, created_at
+		`SELECT `+todoColumns+`
 		 FROM todos WHERE id = ? AND user_id = ?`, id, userID,
 	)
 	return scanTodo(row)
 }
 
-func (s *Store) ListTodos(userID string, limit, offset int) ([]model.Todo, int, error) {
+// TodoFilter narrows todo listings. The zero value lists every live todo.
+type TodoFilter struct {
+	// DueAfter and DueBefore, when set, select todos due in
+	// [DueAfter, DueBefore), both days at midnight UTC: all-day todos by
+	// their day and timed ones by their local date. Todos without a due
+	// date are left out.
+	DueAfter  *time.Time
+	DueBefore *time.Time
+	// Completed, when set, selects only completed or only open todos.
+	Completed *bool
+	// NoteID, when set, selects only todos attached to that note.
+	NoteID string
+	// Orphaned selects only todos attached to a note that is deleted or
+	// not in the store.
+	Orphaned bool
+	// Contains, when set, selects only todos whose content contains it
+	// (ASCII case-insensitively).
+	Contains string
+}
+
+// where returns the SQL conditions for f (without a leading AND, or empty)
+// and appends their arguments to args.
+func (f TodoFilter) where(args *[]any) string {
+	cond := ""
+	if f.Completed != nil {
+		*args = append(*args, *f.Completed)
+		cond += ` AND completed = ?`
+	}
+	if f.NoteID != "" {
+		*args = append(*args, f.NoteID)
+		cond += ` AND note_id = ?`
+	}
+	if f.Orphaned {
+		cond += ` AND note_id IS NOT NULL AND NOT EXISTS (SELECT 1 FROM notes n
+		WHERE n.id = todos.note_id AND n.deleted_at IS NULL)`
+	}
+	if f.Contains != "" {
+		*args = append(*args, "%"+f.Contains+"%")
+		cond += ` AND content LIKE ?`
+	}
+	if f.DueAfter != nil {
+		*args = append(*args, toMillis(*f.DueAfter), toMillis(localDay(*f.DueAfter)))
+		cond += ` AND due_date >= CASE WHEN all_day THEN ? ELSE ? END`
+	}
+	if f.DueBefore != nil {
+		*args = append(*args, toMillis(*f.DueBefore), toMillis(localDay(*f.DueBefore)))
+		cond += ` AND due_date < CASE WHEN all_day THEN ? ELSE ? END`
+	}
+	return cond
+}
+
+// localDay returns the start of day, a day at midnight UTC, in local time.
+func localDay(day time.Time) time.Time {
+	return time.Date(day.Year(), day.Month(), day.Day(), 0, 0, 0, 0, time.Local)
+}
+
+// order returns the ORDER BY terms for f: by due date when filtering on it,
+// most recently modified first otherwise.
+func (f TodoFilter) order() string {
+	if f.DueAfter != nil || f.DueBefore != nil {
+		return `due_date ASC, created_at ASC`
+	}
+	return `modified_at DESC`
+}
+
+func (s *Store) ListTodos(userID string, f TodoFilter, limit, offset int) ([]model.Todo, int, error) {
+	args := []any{userID}
+	cond := `user_id = ? AND deleted_at IS NULL` + f.where(&args)
+
 	var total int
-	err := s.db.QueryRow(
-		`SELECT COUNT(*) FROM todos WHERE user_id = ? AND deleted_at IS NULL`, userID,
-	).Scan(&total)
+	err := s.db.QueryRow(`SELECT COUNT(*) FROM todos WHERE `+cond, args...).Scan(&total)
 	if err != nil {
 		return nil, 0, fmt.Errorf("count todos: %w", err)
 	}
 
 	rows, err := s.db.Query(
-		`SELECT id, user_id, note_id, line_ref, content, due_date, completed,
-		 modified_at, modified_by_device, deleted_at, created_at
-		 FROM todos WHERE user_id = ? AND deleted_at IS NULL
-		 ORDER BY modified_at DESC LIMIT ? OFFSET ?`,
-		userID, limit, offset,
+		`SELECT `+todoColumns+`
+		 FROM todos WHERE `+cond+`
+		 ORDER BY `+f.order()+` LIMIT ? OFFSET ?`,
+		append(args, limit, offset)...,
 	)
 	if err != nil {
 		return nil, 0, fmt.Errorf("list todos: %w", err)
@@ -67,13 +144,47 @@ func (s *Store) ListTodos(userID string, limit, offset int) ([]model.Todo, int,
 	return todos, total, err
 }
 
+// GetAllTodos returns every non-deleted todo, oldest first.
+func (s *Store) GetAllTodos(userID string) ([]model.Todo, error) {
+	rows, err := s.db.Query(
+		`SELECT `+todoColumns+`
+		 FROM todos WHERE user_id = ? AND deleted_at IS NULL
+		 ORDER BY created_at ASC, rowid ASC`,
+		userID,
+	)
+	if err != nil {
+		return nil, fmt.Errorf("get all todos: %w", err)
+	}
+	defer rows.Close()
+	return scanTodos(rows)
+}
+
+// UpdateTodo writes a local edit and queues it for the next push.
 func (s *Store) UpdateTodo(t *model.Todo) error {
-	res, err := s.db.Exec(
-		`UPDATE todos SET note_id = ?, line_ref = ?, content = ?, due_date = ?,
-		 completed = ?, modified_at = ?, modified_by_device = ?
+	return s.withTx(func(tx *sql.Tx) error {
+		if err := updateTodo(tx, t); err != nil {
+			return err
+		}
+		return enqueue(tx, "todo", t.ID)
+	})
+}
+
+// updateTodo writes a local edit. Fields whose value changes are dated
+// t.ModifiedAt so the server can merge them with edits from elsewhere.
+func updateTodo(db execer, t *model.Todo) error {
+	now := toMillis(t.ModifiedAt)
+	res, err := db.Exec(
+		`UPDATE todos SET
+		 content_modified_at = CASE WHEN content IS ? THEN `+fieldTime("content")+` ELSE ? END,
+		 due_date_modified_at = CASE WHEN due_date IS ? AND all_day = ? THEN `+fieldTime("due_date")+` ELSE ? END,
+		 completed_modified_at = CASE WHEN completed IS ? THEN `+fieldTime("completed")+` ELSE ? END,
+		 note_id_modified_at = CASE WHEN note_id IS ? AND line_ref IS ? THEN `+fieldTime("note_id")+` ELSE ? END,
+		 note_id = ?, line_ref = ?, content = ?, due_date = ?, all_day = ?,
+		 reminder_at = ?, completed = ?, tags = ?, checklist = ?, modified_at = ?, modified_by_device = ?
 		 WHERE id = ? AND user_id = ? AND deleted_at IS NULL`,
-		t.NoteID, t.LineRef, t.Content, toNullMillis(t.DueDate),
-		t.Completed, toMillis(t.ModifiedAt), t.ModifiedByDevice,
+		t.Content, now, toNullMillis(t.DueDate), t.AllDay, now, t.Completed, now, t.NoteID, t.LineRef, now,
+		t.NoteID, t.LineRef, t.Content, toNullMillis(t.DueDate), t.AllDay, toNullMillis(t.ReminderAt),
+		t.Completed, joinTags(t.Tags), joinChecklist(t.Checklist), now, t.ModifiedByDevice,
 		t.ID, t.UserID,
 	)
 	if err != nil {
@@ -82,28 +193,37 @@ func (s *Store) UpdateTodo(t *model.Todo) error {
 	return checkRowsAffected(res)
 }
 
+// DeleteTodo soft-deletes a todo and queues the deletion for the next push.
 func (s *Store) DeleteTodo(id, userID string, deletedAt int64, deviceID string) error {
-	res, err := s.db.Exec(
-		`UPDATE todos SET deleted_at = ?, modified_at = ?, modified_by_device = ?
-		 WHERE id = ? AND user_id = ? AND deleted_at IS NULL`,
-		deletedAt, deletedAt, deviceID, id, userID,
-	)
-	if err != nil {
-		return fmt.Errorf("delete todo: %w", err)
-	}
-	return checkRowsAffected(res)
+	return s.withTx(func(tx *sql.Tx) error {
+		res, err := tx.Exec(
+			`UPDATE todos SET deleted_at = ?, modified_at = ?, modified_by_device = ?
+			 WHERE id = ? AND user_id = ? AND deleted_at IS NULL`,
+			deletedAt, deletedAt, deviceID, id, userID,
+		)
+		if err != nil {
+			return fmt.Errorf("delete todo: %w", err)
+		}
+		if err := checkRowsAffected(res); err != nil {
+			return err
+		}
+		return enqueue(tx, "todo", id)
+	})
 }
 
+// GetOverdueTodos returns the open todos past their due time, or for
+// all-day todos due before the local date, oldest first.
 func (s *Store) GetOverdueTodos(userID string) ([]model.Todo, error) {
-	now := model.NowMillis().UnixMilli()
+	now := model.NowMillis()
+	y, m, d := time.Now().Date()
+	today := time.Date(y, m, d, 0, 0, 0, 0, time.UTC)
 	rows, err := s.db.Query(
-		`SELECT id, user_id, note_id, line_ref, content, due_date, completed,
-		 modified_at, modified_by_device, deleted_at, created_at
+		`SELECT `+todoColumns+`
 		 FROM todos
 		 WHERE user_id = ? AND deleted_at IS NULL AND completed = 0
-		   AND due_date IS NOT NULL AND due_date < ?
+		   AND due_date IS NOT NULL AND due_date < CASE WHEN all_day THEN ? ELSE ? END
 		 ORDER BY due_date ASC`,
-		userID, now,
+		userID, toMillis(today), toMillis(now),
 	)
 	if err != nil {
 		return nil, fmt.Errorf("get overdue todos: %w", err)
@@ -115,8 +235,7 @@ func (s *Store) GetOverdueTodos(userID string) ([]model.Todo, error) {
 // GetTodoChangesSince returns all todos (including deleted) modified after sinceMs.
 func (s *Store) GetTodoChangesSince(userID string, sinceMs int64) ([]model.Todo, error) {
 	rows, err := s.db.Query(
-		`SELECT id, user_id, note_id, line_ref, content, due_date, completed,
-		 modified_at, modified_by_device, deleted_at, created_at
+		`SELECT `+todoColumns+`
 		 FROM todos WHERE user_id = ? AND modified_at > ?
 		 ORDER BY modified_at ASC`,
 		userID, sinceMs,
@@ -128,76 +247,244 @@ func (s *Store) GetTodoChangesSince(userID string, sinceMs int64) ([]model.Todo,
 	return scanTodos(rows)
 }
 
-// UpsertTodo stores a todo using LWW conflict resolution.
-func (s *Store) UpsertTodo(t *model.Todo) (*model.Todo, error) {
+// UpsertTodo stores a server version of a todo, merged field by field with
+// the local one (see mergeTodo). Nothing is queued for push. It returns the
+// local version if it differs from t: with applied false t lost entirely,
+// with applied true t was merged with fields changed later locally.
+func (s *Store) UpsertTodo(t *model.Todo) (local *model.Todo, applied bool, err error) {
 	existing, err := s.GetTodoAny(t.ID, t.UserID)
 	if errors.Is(err, ErrNotFound) {
-		return nil, s.CreateTodo(t)
+		return nil, true, insertTodo(s.db, t)
 	}
 	if err != nil {
-		return nil, err
+		return nil, false, err
 	}
 
-	if t.ModifiedAt.After(existing.ModifiedAt) ||
-		(t.ModifiedAt.Equal(existing.ModifiedAt) && t.ModifiedByDevice > existing.ModifiedByDevice) {
-		_, err := s.db.Exec(
-			`UPDATE todos SET note_id = ?, line_ref = ?, content = ?, due_date = ?,
-			 completed = ?, modified_at = ?, modified_by_device = ?, deleted_at = ?
-			 WHERE id = ? AND user_id = ?`,
-			t.NoteID, t.LineRef, t.Content, toNullMillis(t.DueDate),
-			t.Completed, toMillis(t.ModifiedAt), t.ModifiedByDevice,
-			toNullMillis(t.DeletedAt),
-			t.ID, t.UserID,
-		)
-		if err != nil {
-			return nil, fmt.Errorf("upsert todo: %w", err)
+	m, took, kept := mergeTodo(existing, t)
+	if !took {
+		return existing, false, nil
+	}
+	ft := m.FieldTimes
+	_, err = s.db.Exec(
+		`UPDATE todos SET note_id = ?, line_ref = ?, content = ?, due_date = ?, all_day = ?,
+		 reminder_at = ?, completed = ?, tags = ?, checklist = ?, modified_at = ?, modified_by_device = ?,
+		 deleted_at = ?, content_modified_at = ?, due_date_modified_at = ?,
+		 completed_modified_at = ?, note_id_modified_at = ?
+		 WHERE id = ? AND user_id = ?`,
+		m.NoteID, m.LineRef, m.Content, toNullMillis(m.DueDate), m.AllDay, toNullMillis(m.ReminderAt),
+		m.Completed, joinTags(m.Tags), joinChecklist(m.Checklist), toMillis(m.ModifiedAt), m.ModifiedByDevice,
+		toNullMillis(m.DeletedAt), toMillis(ft.Content), toMillis(ft.DueDate),
+		toMillis(ft.Completed), toMillis(ft.NoteID),
+		t.ID, t.UserID,
+	)
+	if err != nil {
+		return nil, false, fmt.Errorf("upsert todo: %w", err)
+	}
+	if !kept {
+		return nil, true, nil
+	}
+	return &m, true, nil
+}
+
+// mergeTodo merges an incoming version of a todo into the stored one, the
+// same way the server does. The content, due date (with its all-day flag),
+// completion and note are each taken from whichever side changed them last;
+// the other fields follow the newer version as a whole, the checklist only
+// if it carries one. Whole versions of equal age go to the higher device
+// ID; a field changed at the same moment on both sides goes to the greater
+// value, as dueTieWins and noteTieWins say for the due date and note. took
+// reports whether anything of in was used, kept whether the result differs
+// from in.
+func mergeTodo(cur, in *model.Todo) (m model.Todo, took, kept bool) {
+	ct, it := fieldTimes(cur), fieldTimes(in)
+	newer := func(a, b time.Time, tie bool) bool {
+		return a.After(b) || (a.Equal(b) && tie)
+	}
+
+	if newer(in.ModifiedAt, cur.ModifiedAt, in.ModifiedByDevice > cur.ModifiedByDevice) {
+		took = true
+		m = *in
+		if in.Checklist == nil {
+			m.Checklist = cur.Checklist
 		}
-		return nil, nil
+	} else {
+		kept = true
+		m = *cur
 	}
 
-	return existing, nil
+	ft := it
+	if newer(it.Content, ct.Content, in.Content > cur.Content) {
+		m.Content, took = in.Content, true
+	} else {
+		m.Content, ft.Content = cur.Content, ct.Content
+		kept = kept || cur.Content != in.Content || !ct.Content.Equal(it.Content)
+	}
+	if newer(it.DueDate, ct.DueDate, dueTieWins(in, cur)) {
+		m.DueDate, m.AllDay, took = in.DueDate, in.AllDay, true
+	} else {
+		m.DueDate, m.AllDay, ft.DueDate = cur.DueDate, cur.AllDay, ct.DueDate
+		kept = kept || !sameTime(cur.DueDate, in.DueDate) || cur.AllDay != in.AllDay ||
+			!ct.DueDate.Equal(it.DueDate)
+	}
+	if newer(it.Completed, ct.Completed, in.Completed && !cur.Completed) {
+		m.Completed, took = in.Completed, true
+	} else {
+		m.Completed, ft.Completed = cur.Completed, ct.Completed
+		kept = kept || cur.Completed != in.Completed || !ct.Completed.Equal(it.Completed)
+	}
+	if newer(it.NoteID, ct.NoteID, noteTieWins(in, cur)) {
+		m.NoteID, m.LineRef, took = in.NoteID, in.LineRef, true
+	} else {
+		m.NoteID, m.LineRef, ft.NoteID = cur.NoteID, cur.LineRef, ct.NoteID
+		kept = kept || !sameString(cur.NoteID, in.NoteID) || !sameString(cur.LineRef, in.LineRef) ||
+			!ct.NoteID.Equal(it.NoteID)
+	}
+	m.FieldTimes = &ft
+	return m, took, kept
 }
 
-func scanTodo(row *sql.Row) (*model.Todo, error) {
+// dueTieWins reports whether the due date of a wins over that of b when
+// both changed at the same moment: a date wins over none, a later date
+// over an earlier one, and a due time over an all-day date.
+func dueTieWins(a, b *model.Todo) bool {
+	switch {
+	case a.DueDate == nil || b.DueDate == nil:
+		return b.DueDate == nil && a.DueDate != nil
+	case !a.DueDate.Equal(*b.DueDate):
+		return a.DueDate.After(*b.DueDate)
+	}
+	return !a.AllDay && b.AllDay
+}
+
+// noteTieWins reports whether the note of a wins over that of b when both
+// changed at the same moment: a note wins over none, then the greater note
+// ID and line reference.
+func noteTieWins(a, b *model.Todo) bool {
+	str := func(s *string) string {
+		if s == nil {
+			return ""
+		}
+		return "\x00" + *s
+	}
+	if an, bn := str(a.NoteID), str(b.NoteID); an != bn {
+		return an > bn
+	}
+	return str(a.LineRef) > str(b.LineRef)
+}
+
+// fieldTimes returns the field times of t, dating missing ones ModifiedAt.
+func fieldTimes(t *model.Todo) model.TodoFieldTimes {
+	ft := model.TodoFieldTimes{}
+	if t.FieldTimes != nil {
+		ft = *t.FieldTimes
+	}
+	for _, f := range []*time.Time{&ft.Content, &ft.DueDate, &ft.Completed, &ft.NoteID} {
+		if f.IsZero() {
+			*f = t.ModifiedAt
+		}
+	}
+	return ft
+}
+
+// fieldTime is the SQL for a stored field time, resolving 0 to modified_at.
+func fieldTime(field string) string {
+	col := field + "_modified_at"
+	return `CASE ` + col + ` WHEN 0 THEN modified_at ELSE ` + col + ` END`
+}
+
+func sameTime(a, b *time.Time) bool {
+	if a == nil || b == nil {
+		return a == b
+	}
+	return a.Equal(*b)
+}
+
+func sameString(a, b *string) bool {
+	if a == nil || b == nil {
+		return a == b
+	}
+	return *a == *b
+}
+
+// todoColumns is the column list matching scanTodoRow.
+const todoColumns = `id, user_id, note_id, line_ref, content, due_date, all_day, reminder_at, completed,
+	tags, checklist, modified_at, modified_by_device, deleted_at, created_at,
+	content_modified_at, due_date_modified_at, completed_modified_at, note_id_modified_at`
+
+func scanTodoRow(s rowScanner) (*model.Todo, error) {
 	var t model.Todo
 	var modifiedAt, createdAt int64
-	var deletedAt, dueDate sql.NullInt64
-	err := row.Scan(
+	var contentAt, dueDateAt, completedAt, noteIDAt int64
+	var deletedAt, dueDate, reminderAt sql.NullInt64
+	var tags, checklist sql.NullString
+	err := s.Scan(
 		&t.ID, &t.UserID, &t.NoteID, &t.LineRef, &t.Content,
-		&dueDate, &t.Completed,
+		&dueDate, &t.AllDay, &reminderAt, &t.Completed, &tags, &checklist,
 		&modifiedAt, &t.ModifiedByDevice, &deletedAt, &createdAt,
+		&contentAt, &dueDateAt, &completedAt, &noteIDAt,
 	)
-	if errors.Is(err, sql.ErrNoRows) {
-		return nil, ErrNotFound
-	}
 	if err != nil {
-		return nil, fmt.Errorf("scan todo: %w", err)
+		return nil, err
+	}
+	t.Tags = splitTags(tags)
+	if checklist.Valid {
+		if err := json.Unmarshal([]byte(checklist.String), &t.Checklist); err != nil {
+			return nil, fmt.Errorf("checklist of todo %s: %w", t.ID, err)
+		}
 	}
 	t.ModifiedAt = fromMillis(modifiedAt)
 	t.DeletedAt = fromNullMillis(deletedAt)
 	t.DueDate = fromNullMillis(dueDate)
+	t.ReminderAt = fromNullMillis(reminderAt)
 	t.CreatedAt = fromMillis(createdAt)
+	t.FieldTimes = &model.TodoFieldTimes{
+		Content:   fromFieldMillis(contentAt, modifiedAt),
+		DueDate:   fromFieldMillis(dueDateAt, modifiedAt),
+		Completed: fromFieldMillis(completedAt, modifiedAt),
+		NoteID:    fromFieldMillis(noteIDAt, modifiedAt),
+	}
 	return &t, nil
 }
 
+// joinChecklist encodes a checklist for the checklist column. nil is
+// stored as NULL, like unknown tags.
+func joinChecklist(items []model.ChecklistItem) sql.NullString {
+	if items == nil {
+		return sql.NullString{}
+	}
+	// Items hold only strings and booleans, which always marshal.
+	b, _ := json.Marshal(items)
+	return sql.NullString{String: string(b), Valid: true}
+}
+
+// fromFieldMillis converts a field time, where 0 means the todo's
+// modified_at.
+func fromFieldMillis(ms, modifiedAt int64) time.Time {
+	if ms == 0 {
+		ms = modifiedAt
+	}
+	return fromMillis(ms)
+}
+
+func scanTodo(row *sql.Row) (*model.Todo, error) {
+	t, err := scanTodoRow(row)
+	if errors.Is(err, sql.ErrNoRows) {
+		return nil, ErrNotFound
+	}
+	if err != nil {
+		return nil, fmt.Errorf("scan todo: %w", err)
+	}
+	return t, nil
+}
+
 func scanTodos(rows *sql.Rows) ([]model.Todo, error) {
 	var todos []model.Todo
 	for rows.Next() {
-		var t model.Todo
-		var modifiedAt, createdAt int64
-		var deletedAt, dueDate sql.NullInt64
-		if err := rows.Scan(
-			&t.ID, &t.UserID, &t.NoteID, &t.LineRef, &t.Content,
-			&dueDate, &t.Completed,
-			&modifiedAt, &t.ModifiedByDevice, &deletedAt, &createdAt,
-		); err != nil {
+		t, err := scanTodoRow(rows)
+		if err != nil {
 			return nil, fmt.Errorf("scan todo row: %w", err)
 		}
-		t.ModifiedAt = fromMillis(modifiedAt)
-		t.DeletedAt = fromNullMillis(deletedAt)
-		t.DueDate = fromNullMillis(dueDate)
-		t.CreatedAt = fromMillis(createdAt)
-		todos = append(todos, t)
+		todos = append(todos, *t)
 	}
 	return todos, rows.Err()
 }
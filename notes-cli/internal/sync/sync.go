@@ -1,21 +1,41 @@
 // Package sync implements pull-then-push synchronisation between the local
 // store and a notesd server. The algorithm:
 //
-//  1. Pull: fetch all server changes since last_sync_at, apply to local store
-//     via LWW upsert.
-//  2. Push: send all local items modified since last_sync_at to the server.
+//  1. Pull: fetch all server changes since last_sync_at, a page at a time,
+//     apply to local store via LWW upsert and drop items the server purged.
+//     If the server has collected tombstones this device never saw, it
+//     sends everything and cached items without local changes are dropped
+//     first.
+//  2. Push: send every item in the local pending queue to the server.
 //     The server applies its own LWW upsert and returns any conflicts.
 //  3. Resolve: for each conflict, apply the server's winning version to the
-//     local store so both sides converge.
+//     local store so both sides converge, and report the discarded local edit.
+//     Todos are merged field by field on both sides, so edits to different
+//     fields on different devices do not conflict.
 //  4. Record the sync timestamp returned by the server.
+//  5. Cache the note types the server accepts, so that commands can check
+//     a type before queueing a note the server would refuse.
+//
+// Note content travels as line patches where the server supports it: pulls
+// apply a patch to the content last synced, falling back to fetching the
+// whole note, and pushes send a patch against it, resending in full when
+// the server has moved on.
+//
+// Local writes are queued by the store, so changes made while offline are
+// pushed on the next successful sync regardless of clock skew.
 package sync
 
 import (
 	"encoding/json"
+	"errors"
 	"fmt"
+	"net"
 	"net/http"
+	"net/url"
+	"slices"
 	"time"
 
+	"github.com/c0dev0id/notesd/notes-cli/internal/delta"
 	"github.com/c0dev0id/notesd/notes-cli/internal/model"
 	"github.com/c0dev0id/notesd/notes-cli/internal/store"
 )
@@ -34,14 +54,33 @@ type Result struct {
 	TodosPulled    int
 	TodosPushed    int
 	TodosConflicts int
+	Conflicts      []Conflict
 	ServerTime     time.Time
 }
 
+// Conflict describes a local change that lost to a newer server version.
+// Title is taken from the discarded local version.
+type Conflict struct {
+	Type  string `json:"type"`
+	ID    string `json:"id"`
+	Title string `json:"title"`
+}
+
+// IsOffline reports whether err means the server could not be reached, as
+// opposed to the server rejecting the request.
+func IsOffline(err error) bool {
+	var urlErr *url.Error
+	var netErr net.Error
+	return errors.As(err, &urlErr) || errors.As(err, &netErr)
+}
+
 // Syncer holds the dependencies needed to run a sync.
 type Syncer struct {
 	store  *store.Store
 	client Client
 	userID string
+	// deltas caches whether the server accepts patches; nil until asked.
+	deltas *bool
 }
 
 func New(s *store.Store, c Client, userID string) *Syncer {
@@ -63,7 +102,7 @@ func (sy *Syncer) Sync() (*Result, error) {
 	}
 
 	// 2+3. Push and resolve conflicts
-	if err := sy.push(lastSync, res); err != nil {
+	if err := sy.push(res); err != nil {
 		return nil, fmt.Errorf("push: %w", err)
 	}
 
@@ -72,17 +111,44 @@ func (sy *Syncer) Sync() (*Result, error) {
 		return nil, fmt.Errorf("set last sync: %w", err)
 	}
 
+	// 5. Cache note types
+	if err := sy.cacheNoteTypes(); err != nil {
+		return nil, fmt.Errorf("cache note types: %w", err)
+	}
+
 	return res, nil
 }
 
+// cacheNoteTypes stores the note types the server accepts. Servers without
+// configurable types do not list them; the cache is left alone then.
+func (sy *Syncer) cacheNoteTypes() error {
+	var v struct {
+		Types []string `json:"types"`
+	}
+	status, err := sy.client.DoJSON("GET", "/api/v1/meta/note-types", nil, &v)
+	if err != nil || status != http.StatusOK || len(v.Types) == 0 {
+		return nil
+	}
+	return sy.store.SetNoteTypes(v.Types)
+}
+
 // --- server response types ---
 
 type syncChangesResponse struct {
 	Notes         []model.Note `json:"notes"`
 	Todos         []model.Todo `json:"todos"`
+	Purged        []purgedItem `json:"purged"`
+	Reset         bool         `json:"reset"`
+	HasMore       bool         `json:"has_more"`
+	NextCursor    string       `json:"next_cursor"`
 	SyncTimestamp int64        `json:"sync_timestamp"`
 }
 
+type purgedItem struct {
+	Type string `json:"type"`
+	ID   string `json:"id"`
+}
+
 type syncPushRequest struct {
 	Notes []model.Note `json:"notes"`
 	Todos []model.Todo `json:"todos"`
@@ -98,50 +164,190 @@ type syncConflict struct {
 type syncPushResponse struct {
 	Accepted  int            `json:"accepted"`
 	Conflicts []syncConflict `json:"conflicts"`
-	Timestamp int64          `json:"timestamp"`
+	NeedFull  []string       `json:"need_full"`
+	Merged    []model.Todo   `json:"merged"`
+	Timestamp int64          `json:"sync_timestamp"`
 }
 
-// pull fetches server changes and applies them to the local store.
+// pullPageSize is the number of items requested per page of changes.
+const pullPageSize = 500
+
+// pull fetches server changes page by page and applies them to the local
+// store. An interrupted pull starts over on the next sync, as last_sync_at
+// only moves once every page has been applied.
 func (sy *Syncer) pull(sinceMs int64, res *Result) error {
-	var changes syncChangesResponse
-	status, err := sy.client.DoJSON(
-		"GET",
-		fmt.Sprintf("/api/v1/sync/changes?since=%d", sinceMs),
-		nil, &changes,
-	)
-	if err != nil {
-		return err
+	path := fmt.Sprintf("/api/v1/sync/changes?since=%d&limit=%d&delta=true", sinceMs, pullPageSize)
+	for {
+		var changes syncChangesResponse
+		status, err := sy.client.DoJSON("GET", path, nil, &changes)
+		if err != nil {
+			return err
+		}
+		if status != http.StatusOK {
+			return fmt.Errorf("server returned %d", status)
+		}
+		if err := sy.applyChanges(&changes, res); err != nil {
+			return err
+		}
+		if !changes.HasMore {
+			res.ServerTime = time.UnixMilli(changes.SyncTimestamp).UTC()
+			return nil
+		}
+		path = fmt.Sprintf("/api/v1/sync/changes?cursor=%s&limit=%d&delta=true",
+			url.QueryEscape(changes.NextCursor), pullPageSize)
 	}
-	if status != http.StatusOK {
-		return fmt.Errorf("server returned %d", status)
+}
+
+// applyChanges applies one page of pulled changes.
+func (sy *Syncer) applyChanges(changes *syncChangesResponse, res *Result) error {
+
+	if changes.Reset {
+		if err := sy.store.DropSynced(sy.userID); err != nil {
+			return err
+		}
 	}
 
 	for i := range changes.Notes {
-		changes.Notes[i].UserID = sy.userID
-		if _, err := sy.store.UpsertNote(&changes.Notes[i]); err != nil {
-			return fmt.Errorf("upsert pulled note %s: %w", changes.Notes[i].ID, err)
+		n := &changes.Notes[i]
+		n.UserID = sy.userID
+		if n.BaseHash != "" {
+			found, err := sy.resolvePatch(n)
+			if err != nil {
+				return fmt.Errorf("patch pulled note %s: %w", n.ID, err)
+			}
+			if !found {
+				// Deleted since; a later pull brings the tombstone.
+				continue
+			}
+		}
+		if err := sy.applyNote(n, res); err != nil {
+			return fmt.Errorf("upsert pulled note %s: %w", n.ID, err)
 		}
 		res.NotesPulled++
 	}
 	for i := range changes.Todos {
-		changes.Todos[i].UserID = sy.userID
-		if _, err := sy.store.UpsertTodo(&changes.Todos[i]); err != nil {
-			return fmt.Errorf("upsert pulled todo %s: %w", changes.Todos[i].ID, err)
+		t := &changes.Todos[i]
+		t.UserID = sy.userID
+		if err := sy.applyTodo(t, res); err != nil {
+			return fmt.Errorf("upsert pulled todo %s: %w", t.ID, err)
 		}
 		res.TodosPulled++
 	}
 
-	res.ServerTime = time.UnixMilli(changes.SyncTimestamp).UTC()
+	for _, p := range changes.Purged {
+		if p.Type != "note" && p.Type != "todo" {
+			continue
+		}
+		if err := sy.applyPurge(p, res); err != nil {
+			return fmt.Errorf("purge %s %s: %w", p.Type, p.ID, err)
+		}
+	}
 	return nil
 }
 
-// push sends local changes to the server and resolves conflicts.
-func (sy *Syncer) push(sinceMs int64, res *Result) error {
-	notes, err := sy.store.GetNoteChangesSince(sy.userID, sinceMs)
+// resolvePatch fills in the content of a note pulled as a patch. If the
+// patch does not apply to the content last synced, the whole note is
+// fetched instead. It reports false if the note no longer exists.
+func (sy *Syncer) resolvePatch(n *model.Note) (bool, error) {
+	base, ok, err := sy.store.SyncedContent(n.ID)
+	if err != nil {
+		return false, err
+	}
+	if ok && delta.Hash(base) == n.BaseHash {
+		content, err := delta.Apply(base, n.ContentPatch)
+		if err == nil && (n.ContentHash == "" || delta.Hash(content) == n.ContentHash) {
+			n.Content = content
+			n.BaseHash, n.ContentPatch = "", nil
+			return true, nil
+		}
+	}
+
+	var full model.Note
+	status, err := sy.client.DoJSON("GET", "/api/v1/notes/"+url.PathEscape(n.ID), nil, &full)
+	if err != nil {
+		return false, err
+	}
+	if status == http.StatusNotFound {
+		return false, nil
+	}
+	if status != http.StatusOK {
+		return false, fmt.Errorf("server returned %d fetching note", status)
+	}
+	full.UserID = sy.userID
+	*n = full
+	return true, nil
+}
+
+// deltaSupported reports whether the server takes patches on push. Older
+// servers would store the empty content sent alongside a patch.
+func (sy *Syncer) deltaSupported() bool {
+	if sy.deltas == nil {
+		var v struct {
+			Capabilities []string `json:"capabilities"`
+		}
+		status, err := sy.client.DoJSON("GET", "/api/v1/version", nil, &v)
+		ok := err == nil && status == http.StatusOK && slices.Contains(v.Capabilities, "delta_sync")
+		sy.deltas = &ok
+	}
+	return *sy.deltas
+}
+
+// patched returns n with its content replaced by a patch against the content
+// last synced, or n itself if a patch would not be smaller.
+func (sy *Syncer) patched(n model.Note) (model.Note, error) {
+	if n.DeletedAt != nil {
+		return n, nil
+	}
+	base, ok, err := sy.store.SyncedContent(n.ID)
+	if err != nil || !ok || base == n.Content {
+		return n, err
+	}
+	p := delta.Diff(base, n.Content)
+	if b, err := json.Marshal(p); err != nil || len(b) >= len(n.Content) {
+		return n, nil
+	}
+	if !sy.deltaSupported() {
+		return n, nil
+	}
+	n.ContentHash = delta.Hash(n.Content)
+	n.BaseHash = delta.Hash(base)
+	n.ContentPatch = p
+	n.Content = ""
+	return n, nil
+}
+
+// applyPurge drops a permanently deleted item. A queued local edit to it is
+// lost and reported as a conflict.
+func (sy *Syncer) applyPurge(p purgedItem, res *Result) error {
+	pending, err := sy.store.IsPending(p.Type, p.ID)
+	if err != nil {
+		return err
+	}
+	if pending {
+		c := Conflict{Type: p.Type, ID: p.ID}
+		if p.Type == "note" {
+			if n, err := sy.store.GetNoteAny(p.ID, sy.userID); err == nil {
+				c.Title = n.Title
+			}
+			res.NotesConflicts++
+		} else {
+			if t, err := sy.store.GetTodoAny(p.ID, sy.userID); err == nil {
+				c.Title = t.Content
+			}
+			res.TodosConflicts++
+		}
+		res.Conflicts = append(res.Conflicts, c)
+	}
+	return sy.store.Purge(p.Type, p.ID)
+}
+
+// push sends queued local changes to the server and resolves conflicts.
+func (sy *Syncer) push(res *Result) error {
+	notes, err := sy.store.PendingNotes(sy.userID)
 	if err != nil {
 		return err
 	}
-	todos, err := sy.store.GetTodoChangesSince(sy.userID, sinceMs)
+	todos, err := sy.store.PendingTodos(sy.userID)
 	if err != nil {
 		return err
 	}
@@ -150,15 +356,31 @@ func (sy *Syncer) push(sinceMs int64, res *Result) error {
 		return nil
 	}
 
-	var pushResp syncPushResponse
-	status, err := sy.client.DoJSON("POST", "/api/v1/sync/push",
-		syncPushRequest{Notes: notes, Todos: todos}, &pushResp,
-	)
+	sent := make([]model.Note, len(notes))
+	for i, n := range notes {
+		if sent[i], err = sy.patched(n); err != nil {
+			return err
+		}
+	}
+	pushResp, err := sy.send(syncPushRequest{Notes: sent, Todos: todos})
 	if err != nil {
 		return err
 	}
-	if status != http.StatusOK {
-		return fmt.Errorf("server returned %d on push", status)
+
+	// Patches against content the server no longer has go again in full
+	if len(pushResp.NeedFull) > 0 {
+		var full []model.Note
+		for _, n := range notes {
+			if slices.Contains(pushResp.NeedFull, n.ID) {
+				full = append(full, n)
+			}
+		}
+		retry, err := sy.send(syncPushRequest{Notes: full})
+		if err != nil {
+			return err
+		}
+		pushResp.Conflicts = append(pushResp.Conflicts, retry.Conflicts...)
+		pushResp.Timestamp = retry.Timestamp
 	}
 
 	res.NotesPushed = len(notes)
@@ -170,22 +392,50 @@ func (sy *Syncer) push(sinceMs int64, res *Result) error {
 		case "note":
 			if c.ServerNote != nil {
 				c.ServerNote.UserID = sy.userID
-				if _, err := sy.store.UpsertNote(c.ServerNote); err != nil {
+				if err := sy.applyNote(c.ServerNote, res); err != nil {
 					return fmt.Errorf("resolve note conflict %s: %w", c.ID, err)
 				}
-				res.NotesConflicts++
 			}
 		case "todo":
 			if c.ServerTodo != nil {
 				c.ServerTodo.UserID = sy.userID
-				if _, err := sy.store.UpsertTodo(c.ServerTodo); err != nil {
+				if err := sy.applyTodo(c.ServerTodo, res); err != nil {
 					return fmt.Errorf("resolve todo conflict %s: %w", c.ID, err)
 				}
-				res.TodosConflicts++
 			}
 		}
 	}
 
+	// Accepted items leave the queue unless edited again during the push
+	for _, n := range notes {
+		lost := slices.ContainsFunc(pushResp.Conflicts, func(c syncConflict) bool {
+			return c.Type == "note" && c.ID == n.ID
+		})
+		if !lost {
+			if err := sy.store.SetSyncedContent(n.ID, n.Content); err != nil {
+				return fmt.Errorf("record pushed note %s: %w", n.ID, err)
+			}
+		}
+		if err := sy.store.ClearPushed("note", n.ID, n.ModifiedAt); err != nil {
+			return fmt.Errorf("clear pushed note %s: %w", n.ID, err)
+		}
+	}
+	for _, t := range todos {
+		if err := sy.store.ClearPushed("todo", t.ID, t.ModifiedAt); err != nil {
+			return fmt.Errorf("clear pushed todo %s: %w", t.ID, err)
+		}
+	}
+
+	// Accepted todos the server merged with newer fields of its own. Applied
+	// after clearing the queue, as the merge may move modified_at forward.
+	for i := range pushResp.Merged {
+		m := &pushResp.Merged[i]
+		m.UserID = sy.userID
+		if _, _, err := sy.store.UpsertTodo(m); err != nil {
+			return fmt.Errorf("apply merged todo %s: %w", m.ID, err)
+		}
+	}
+
 	// Server time from push response supersedes pull time
 	if pushResp.Timestamp > 0 {
 		res.ServerTime = time.UnixMilli(pushResp.Timestamp).UTC()
@@ -193,12 +443,73 @@ func (sy *Syncer) push(sinceMs int64, res *Result) error {
 	return nil
 }
 
+// send pushes one batch of changes.
+func (sy *Syncer) send(req syncPushRequest) (*syncPushResponse, error) {
+	var resp syncPushResponse
+	status, err := sy.client.DoJSON("POST", "/api/v1/sync/push", req, &resp)
+	if err != nil {
+		return nil, err
+	}
+	if status != http.StatusOK {
+		return nil, fmt.Errorf("server returned %d on push", status)
+	}
+	return &resp, nil
+}
+
+// applyNote stores a server version locally. If it replaces a queued local
+// edit, the edit is dropped from the queue and reported as a conflict.
+func (sy *Syncer) applyNote(n *model.Note, res *Result) error {
+	pending, err := sy.store.IsPending("note", n.ID)
+	if err != nil {
+		return err
+	}
+	var local *model.Note
+	if pending {
+		if local, err = sy.store.GetNoteAny(n.ID, sy.userID); err != nil {
+			return err
+		}
+	}
+	winner, err := sy.store.UpsertNote(n)
+	if err != nil || !pending || winner != nil {
+		return err
+	}
+	res.NotesConflicts++
+	res.Conflicts = append(res.Conflicts, Conflict{Type: "note", ID: n.ID, Title: local.Title})
+	return sy.store.Dequeue("note", n.ID)
+}
+
+// applyTodo is applyNote for todos. Todos are merged field by field, so a
+// local edit is only reported lost if nothing of it survives the merge.
+func (sy *Syncer) applyTodo(t *model.Todo, res *Result) error {
+	pending, err := sy.store.IsPending("todo", t.ID)
+	if err != nil {
+		return err
+	}
+	var local *model.Todo
+	if pending {
+		if local, err = sy.store.GetTodoAny(t.ID, sy.userID); err != nil {
+			return err
+		}
+	}
+	winner, _, err := sy.store.UpsertTodo(t)
+	if err != nil || !pending || winner != nil {
+		return err
+	}
+	res.TodosConflicts++
+	res.Conflicts = append(res.Conflicts, Conflict{Type: "todo", ID: t.ID, Title: local.Content})
+	return sy.store.Dequeue("todo", t.ID)
+}
+
 // FormatResult returns a human-readable sync summary.
 func FormatResult(r *Result) string {
-	b, _ := json.MarshalIndent(map[string]any{
+	summary := map[string]any{
 		"notes":  map[string]int{"pulled": r.NotesPulled, "pushed": r.NotesPushed, "conflicts": r.NotesConflicts},
 		"todos":  map[string]int{"pulled": r.TodosPulled, "pushed": r.TodosPushed, "conflicts": r.TodosConflicts},
 		"server_time": r.ServerTime.Format(time.RFC3339),
-	}, "", "  ")
+	}
+	if len(r.Conflicts) > 0 {
+		summary["discarded_local_changes"] = r.Conflicts
+	}
+	b, _ := json.MarshalIndent(summary, "", "  ")
 	return string(b)
 }
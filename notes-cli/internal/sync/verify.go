@@ -0,0 +1,135 @@
+package sync
+
+import (
+	"errors"
+	"fmt"
+	"net/http"
+	"time"
+
+	"github.com/c0dev0id/notesd/notes-cli/internal/delta"
+	"github.com/c0dev0id/notesd/notes-cli/internal/model"
+	"github.com/c0dev0id/notesd/notes-cli/internal/store"
+)
+
+type manifestEntry struct {
+	ID          string    `json:"id"`
+	ModifiedAt  time.Time `json:"modified_at"`
+	ContentHash string    `json:"content_hash"`
+	Deleted     bool      `json:"deleted"`
+}
+
+type syncManifest struct {
+	Notes []manifestEntry `json:"notes"`
+	Todos []manifestEntry `json:"todos"`
+}
+
+type syncFetchRequest struct {
+	NoteIDs []string `json:"note_ids"`
+	TodoIDs []string `json:"todo_ids"`
+}
+
+type syncFetchResponse struct {
+	Notes []model.Note `json:"notes"`
+	Todos []model.Todo `json:"todos"`
+}
+
+// fetchBatch is the number of IDs sent per fetch request, the server's
+// limit.
+const fetchBatch = 500
+
+// Verify compares the local store with the server's manifest and fetches
+// the notes and todos held locally in a different version, or not at all.
+// Items with queued local changes are left to the next push. It repairs a
+// store that incremental pulls cannot, e.g. after a restore from backup,
+// without downloading the unchanged bodies.
+func (sy *Syncer) Verify() (*Result, error) {
+	var m syncManifest
+	status, err := sy.client.DoJSON("GET", "/api/v1/sync/manifest", nil, &m)
+	if err != nil {
+		return nil, err
+	}
+	if status == http.StatusNotFound {
+		return nil, errors.New("server does not support sync manifests")
+	}
+	if status != http.StatusOK {
+		return nil, fmt.Errorf("server returned %d", status)
+	}
+
+	var noteIDs, todoIDs []string
+	for _, e := range m.Notes {
+		local, err := sy.store.GetNoteAny(e.ID, sy.userID)
+		diverged, err := sy.diverged("note", e, err, func() (time.Time, bool, string) {
+			return local.ModifiedAt, local.DeletedAt != nil, delta.Hash(local.Content)
+		})
+		if err != nil {
+			return nil, err
+		}
+		if diverged {
+			noteIDs = append(noteIDs, e.ID)
+		}
+	}
+	for _, e := range m.Todos {
+		local, err := sy.store.GetTodoAny(e.ID, sy.userID)
+		diverged, err := sy.diverged("todo", e, err, func() (time.Time, bool, string) {
+			return local.ModifiedAt, local.DeletedAt != nil, ""
+		})
+		if err != nil {
+			return nil, err
+		}
+		if diverged {
+			todoIDs = append(todoIDs, e.ID)
+		}
+	}
+
+	res := &Result{ServerTime: time.Now().UTC()}
+	for len(noteIDs)+len(todoIDs) > 0 {
+		var req syncFetchRequest
+		n := min(len(noteIDs), fetchBatch)
+		req.NoteIDs, noteIDs = noteIDs[:n], noteIDs[n:]
+		n = min(len(todoIDs), fetchBatch-len(req.NoteIDs))
+		req.TodoIDs, todoIDs = todoIDs[:n], todoIDs[n:]
+
+		var fetched syncFetchResponse
+		status, err := sy.client.DoJSON("POST", "/api/v1/sync/fetch", req, &fetched)
+		if err != nil {
+			return nil, err
+		}
+		if status != http.StatusOK {
+			return nil, fmt.Errorf("server returned %d on fetch", status)
+		}
+		// The server's copy is authoritative for items without queued
+		// changes, but a stale local copy with an equal or newer
+		// modified_at would win the usual merge, so it goes first.
+		for _, n := range fetched.Notes {
+			if err := sy.store.Purge("note", n.ID); err != nil {
+				return nil, err
+			}
+		}
+		for _, t := range fetched.Todos {
+			if err := sy.store.Purge("todo", t.ID); err != nil {
+				return nil, err
+			}
+		}
+		if err := sy.applyChanges(&syncChangesResponse{Notes: fetched.Notes, Todos: fetched.Todos}, res); err != nil {
+			return nil, err
+		}
+	}
+	return res, nil
+}
+
+// diverged reports whether the local copy of a manifest entry, looked up
+// with lookupErr, differs from the server's. local describes the copy.
+func (sy *Syncer) diverged(itemType string, e manifestEntry, lookupErr error, local func() (time.Time, bool, string)) (bool, error) {
+	if errors.Is(lookupErr, store.ErrNotFound) {
+		// Nothing to do for a tombstone of an item never seen here
+		return !e.Deleted, nil
+	}
+	if lookupErr != nil {
+		return false, lookupErr
+	}
+	if pending, err := sy.store.IsPending(itemType, e.ID); err != nil || pending {
+		return false, err
+	}
+	modifiedAt, deleted, hash := local()
+	return !modifiedAt.Equal(e.ModifiedAt) || deleted != e.Deleted || hash != e.ContentHash, nil
+}
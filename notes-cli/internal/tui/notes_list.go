@@ -14,6 +14,10 @@ type notesListModel struct {
 	offset   int
 	total    int
 	pageSize int
+
+	query       string // active search; empty lists all notes
+	searchInput string
+	searching   bool // typing a search query inline
 }
 
 func newNotesListModel() notesListModel {
@@ -47,10 +51,33 @@ func (m *notesListModel) MoveDown() {
 	}
 }
 
+// searchLine shows the search being typed or the active query.
+func (m notesListModel) searchLine() string {
+	if m.searching {
+		return styleSubtle.Render("Search: ") + m.searchInput + "█"
+	}
+	if m.query != "" {
+		return styleSubtle.Render(fmt.Sprintf("Search: %q (esc: clear)", m.query))
+	}
+	return ""
+}
+
 func (m notesListModel) View(width, height int) string {
+	search := m.searchLine()
+	if search != "" {
+		height -= 2
+	}
+
 	if len(m.notes) == 0 {
-		empty := styleSubtle.Render("No notes. Press 'n' to create one.")
-		return lipgloss.Place(width, height, lipgloss.Center, lipgloss.Center, empty)
+		msg := "No notes. Press 'n' to create one."
+		if m.query != "" {
+			msg = "No notes match."
+		}
+		empty := lipgloss.Place(width, height, lipgloss.Center, lipgloss.Center, styleSubtle.Render(msg))
+		if search != "" {
+			return empty + "\n\n" + search
+		}
+		return empty
 	}
 
 	// Leave room for header (2) and status bar (1)
@@ -118,5 +145,9 @@ func (m notesListModel) View(width, height int) string {
 		)
 	}
 
-	return header + "\n" + strings.Join(rows, "\n") + pagination
+	body := header + "\n" + strings.Join(rows, "\n") + pagination
+	if search != "" {
+		body += "\n\n" + search
+	}
+	return body
 }
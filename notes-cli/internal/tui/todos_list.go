@@ -81,7 +81,7 @@ func (m todosListModel) View(width, height int) string {
 		}
 		due := ""
 		if t.DueDate != nil {
-			due = styleSubtle.Render(" (" + t.DueDate.Local().Format("2006-01-02") + ")")
+			due = styleSubtle.Render(" (" + t.DueText() + ")")
 		}
 		content := t.Content
 		maxW := width - 6
@@ -3,6 +3,7 @@ package tui
 
 import (
 	"fmt"
+	"strings"
 	"time"
 
 	"github.com/charmbracelet/bubbles/spinner"
@@ -206,7 +207,37 @@ func (m *Model) handleKey(msg tea.KeyMsg) (tea.Model, tea.Cmd) {
 		return m, cmd
 
 	case screenNotesList:
+		if m.notesList.searching {
+			switch msg.Type {
+			case tea.KeyEnter:
+				m.notesList.searching = false
+				m.notesList.query = strings.TrimSpace(m.notesList.searchInput)
+				m.notesList.cursor = 0
+				return m, m.loadNotes()
+			case tea.KeyEsc:
+				m.notesList.searching = false
+			case tea.KeyBackspace, tea.KeyDelete:
+				if len(m.notesList.searchInput) > 0 {
+					r := []rune(m.notesList.searchInput)
+					m.notesList.searchInput = string(r[:len(r)-1])
+				}
+			case tea.KeySpace:
+				m.notesList.searchInput += " "
+			case tea.KeyRunes:
+				m.notesList.searchInput += string(msg.Runes)
+			}
+			return m, nil
+		}
 		switch msg.String() {
+		case "/":
+			m.notesList.searching = true
+			m.notesList.searchInput = m.notesList.query
+		case "esc":
+			if m.notesList.query != "" {
+				m.notesList.query = ""
+				m.notesList.cursor = 0
+				return m, m.loadNotes()
+			}
 		case "q":
 			return m, tea.Quit
 		case "j", "down":
@@ -326,7 +357,7 @@ func (m *Model) View() string {
 
 func (m *Model) notesView() string {
 	header := styleTitle.Render("Notes") + "  " +
-		styleSubtle.Render("j/k: move  enter/e: open  n: new  d: delete  t: todos  s: sync  q: quit")
+		styleSubtle.Render("j/k: move  enter/e: open  n: new  d: delete  /: search  t: todos  s: sync  q: quit")
 	body := m.notesList.View(m.width, m.height-2)
 	status := m.statusLine()
 	return lipgloss.JoinVertical(lipgloss.Left, header, body, status)
@@ -376,9 +407,19 @@ type loadTodosMsg struct {
 type saveNoteMsg struct{ err error }
 type deleteMsg struct{ err error }
 
+// loadNotes lists notes, or searches the local full-text index while a
+// search is active.
 func (m *Model) loadNotes() tea.Cmd {
+	query := m.notesList.query
 	return func() tea.Msg {
-		notes, total, err := m.st.ListNotes(m.userID, 200, 0)
+		var notes []model.Note
+		var total int
+		var err error
+		if query != "" {
+			notes, total, err = m.st.SearchNotes(m.userID, query, store.NoteFilter{}, 200, 0)
+		} else {
+			notes, total, err = m.st.ListNotes(m.userID, store.NoteFilter{}, 200, 0)
+		}
 		if err != nil {
 			return loadNotesMsg{}
 		}
@@ -388,7 +429,7 @@ func (m *Model) loadNotes() tea.Cmd {
 
 func (m *Model) loadTodos() tea.Cmd {
 	return func() tea.Msg {
-		todos, total, err := m.st.ListTodos(m.userID, 200, 0)
+		todos, total, err := m.st.ListTodos(m.userID, store.TodoFilter{}, 200, 0)
 		if err != nil {
 			return loadTodosMsg{}
 		}
@@ -452,7 +493,7 @@ func (m *Model) createTodo(content string) tea.Cmd {
 		if err := st.CreateTodo(t); err != nil {
 			return loadTodosMsg{}
 		}
-		todos, total, _ := st.ListTodos(userID, 200, 0)
+		todos, total, _ := st.ListTodos(userID, store.TodoFilter{}, 200, 0)
 		return loadTodosMsg{todos: todos, total: total}
 	}
 }
@@ -473,7 +514,7 @@ func (m *Model) toggleTodo(t *model.Todo) tea.Cmd {
 		todo.ModifiedAt = model.NowMillis()
 		todo.ModifiedByDevice = deviceID
 		st.UpdateTodo(todo)
-		todos, total, _ := st.ListTodos(userID, 200, 0)
+		todos, total, _ := st.ListTodos(userID, store.TodoFilter{}, 200, 0)
 		return loadTodosMsg{todos: todos, total: total}
 	}
 }
@@ -486,7 +527,7 @@ func (m *Model) deleteTodo(id string) tea.Cmd {
 	return func() tea.Msg {
 		now := model.NowMillis()
 		st.DeleteTodo(id, userID, now.UnixMilli(), deviceID)
-		todos, total, _ := st.ListTodos(userID, 200, 0)
+		todos, total, _ := st.ListTodos(userID, store.TodoFilter{}, 200, 0)
 		return loadTodosMsg{todos: todos, total: total}
 	}
 }
@@ -0,0 +1,224 @@
+package main
+
+import (
+	"bufio"
+	"errors"
+	"flag"
+	"fmt"
+	"io"
+	"os"
+	"strings"
+	"time"
+
+	"github.com/c0dev0id/notesd/server/internal/api"
+	"github.com/c0dev0id/notesd/server/internal/config"
+	"github.com/c0dev0id/notesd/server/internal/database"
+)
+
+// configFlag adds the -config flag shared by all commands.
+func configFlag(fs *flag.FlagSet) *string {
+	return fs.String("config", "", "read only this configuration `file`")
+}
+
+// openDatabase loads the configuration, from path if not empty, and opens
+// the configured database, applying pending migrations.
+func openDatabase(path string) (*database.DB, config.Config, error) {
+	cfg, err := config.Load(path)
+	if err != nil {
+		return nil, cfg, fmt.Errorf("load config: %w", err)
+	}
+	db, err := openConfigured(cfg.Database)
+	if err != nil {
+		return nil, cfg, err
+	}
+	return db, cfg, nil
+}
+
+// openConfigured opens the database described by the [database] section.
+func openConfigured(c config.DatabaseConfig) (*database.DB, error) {
+	opts := database.Options{
+		MaxOpenConns:    c.MaxOpenConns,
+		MaxIdleConns:    c.MaxIdleConns,
+		SerializeWrites: c.SerializeWrites,
+	}
+	if c.ConnMaxLifetime != "" {
+		d, err := time.ParseDuration(c.ConnMaxLifetime)
+		if err != nil || d < 0 {
+			return nil, fmt.Errorf("parse database.conn_max_lifetime: invalid duration %q", c.ConnMaxLifetime)
+		}
+		opts.ConnMaxLifetime = d
+	}
+	var err error
+	if opts.ContentKey, opts.PreviousContentKeys, err = c.ContentKeys(); err != nil {
+		return nil, err
+	}
+	db, err := database.OpenWith(c.Path, opts)
+	if err != nil {
+		return nil, fmt.Errorf("open database: %w", err)
+	}
+	return db, nil
+}
+
+// parseFlags parses a command's flags and checks the number of positional
+// arguments.
+func parseFlags(fs *flag.FlagSet, args []string, nargs int) []string {
+	fs.Parse(args)
+	if fs.NArg() != nargs {
+		fs.Usage()
+		os.Exit(2)
+	}
+	return fs.Args()
+}
+
+func migrate(args []string) error {
+	fs := flag.NewFlagSet("migrate", flag.ExitOnError)
+	conf := configFlag(fs)
+	fs.Usage = func() {
+		fmt.Fprint(fs.Output(), "Usage: notesd migrate [-config file]\n\n")
+		fs.PrintDefaults()
+	}
+	parseFlags(fs, args, 0)
+
+	db, cfg, err := openDatabase(*conf)
+	if err != nil {
+		return err
+	}
+	defer db.Close()
+	fmt.Printf("%s is at schema version %d\n", cfg.Database.Path, database.SchemaVersion)
+	return nil
+}
+
+func createUser(args []string) error {
+	fs := flag.NewFlagSet("create-user", flag.ExitOnError)
+	email := fs.String("email", "", "email address to log in with")
+	name := fs.String("name", "", "display name")
+	conf := configFlag(fs)
+	fs.Usage = func() {
+		fmt.Fprint(fs.Output(), `Usage: notesd create-user -email <email> -name <display name>
+
+Adds an account with the same checks as registration. The password is read
+from the first line of stdin, e.g.
+  echo "$PASSWORD" | notesd create-user -email a@example.com -name Alice
+
+`)
+		fs.PrintDefaults()
+	}
+	parseFlags(fs, args, 0)
+
+	if info, err := os.Stdin.Stat(); err == nil && info.Mode()&os.ModeCharDevice != 0 {
+		fmt.Fprint(os.Stderr, "Password (shown as typed): ")
+	}
+	password, err := bufio.NewReader(os.Stdin).ReadString('\n')
+	if err != nil && err != io.EOF {
+		return fmt.Errorf("read password: %w", err)
+	}
+	password = strings.TrimRight(password, "\r\n")
+
+	user, err := api.NewUser(*email, *name, password)
+	if err != nil {
+		return err
+	}
+	db, _, err := openDatabase(*conf)
+	if err != nil {
+		return err
+	}
+	defer db.Close()
+	if err := db.CreateUser(user); err != nil {
+		if errors.Is(err, database.ErrConflict) {
+			return fmt.Errorf("%s is already registered", user.Email)
+		}
+		return err
+	}
+	fmt.Printf("Created user %s (%s)\n", user.Email, user.ID)
+	return nil
+}
+
+func backup(args []string) error {
+	fs := flag.NewFlagSet("backup", flag.ExitOnError)
+	conf := configFlag(fs)
+	fs.Usage = func() {
+		fmt.Fprint(fs.Output(), `Usage: notesd backup [-config file] <path>
+
+Writes a consistent copy of the database to path, which must not exist. Safe
+to run while the server is up. Attachment files are not included; copy
+attachments.dir separately.
+
+`)
+		fs.PrintDefaults()
+	}
+	path := parseFlags(fs, args, 1)[0]
+
+	db, _, err := openDatabase(*conf)
+	if err != nil {
+		return err
+	}
+	defer db.Close()
+	if err := db.Backup(path); err != nil {
+		return err
+	}
+	info, err := os.Stat(path)
+	if err != nil {
+		return err
+	}
+	fmt.Printf("Wrote %s (%d bytes)\n", path, info.Size())
+	return nil
+}
+
+func vacuum(args []string) error {
+	fs := flag.NewFlagSet("vacuum", flag.ExitOnError)
+	conf := configFlag(fs)
+	fs.Usage = func() {
+		fmt.Fprint(fs.Output(), `Usage: notesd vacuum [-config file]
+
+Rebuilds the database file to return space freed by deleted rows. Writers,
+including a running server, wait until it finishes.
+
+`)
+		fs.PrintDefaults()
+	}
+	parseFlags(fs, args, 0)
+
+	db, cfg, err := openDatabase(*conf)
+	if err != nil {
+		return err
+	}
+	defer db.Close()
+	before, err := os.Stat(cfg.Database.Path)
+	if err != nil {
+		return err
+	}
+	if err := db.Vacuum(); err != nil {
+		return err
+	}
+	after, err := os.Stat(cfg.Database.Path)
+	if err != nil {
+		return err
+	}
+	fmt.Printf("Vacuumed %s: %d → %d bytes\n", cfg.Database.Path, before.Size(), after.Size())
+	return nil
+}
+
+func configCmd(args []string) error {
+	fs := flag.NewFlagSet("config check", flag.ExitOnError)
+	conf := configFlag(fs)
+	fs.Usage = func() {
+		fmt.Fprint(fs.Output(), `Usage: notesd config check [-config file]
+
+Loads the configuration as serve would, reports the first error and
+otherwise prints the effective settings as TOML, with secrets redacted.
+
+`)
+		fs.PrintDefaults()
+	}
+	if len(args) == 0 || args[0] != "check" {
+		fs.Usage()
+		os.Exit(2)
+	}
+	parseFlags(fs, args[1:], 0)
+
+	cfg, err := config.Load(*conf)
+	if err != nil {
+		return err
+	}
+	return cfg.Redacted().Write(os.Stdout)
+}
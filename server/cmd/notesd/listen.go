@@ -0,0 +1,47 @@
+package main
+
+import (
+	"errors"
+	"fmt"
+	"io/fs"
+	"net"
+	"os"
+
+	"github.com/c0dev0id/notesd/server/internal/config"
+)
+
+// listen opens the listener for server.listen: a Unix socket for
+// "unix:<path>", a TCP address otherwise. A socket file left behind by a
+// server that did not shut down cleanly is replaced, but not one another
+// server is still accepting on.
+func listen(c config.ServerConfig) (net.Listener, error) {
+	path, ok := c.UnixSocket()
+	if !ok {
+		return net.Listen("tcp", c.Listen)
+	}
+
+	if info, err := os.Lstat(path); err == nil {
+		if info.Mode().Type() != fs.ModeSocket {
+			return nil, fmt.Errorf("listen on %s: file exists and is not a socket", path)
+		}
+		if conn, err := net.Dial("unix", path); err == nil {
+			conn.Close()
+			return nil, fmt.Errorf("listen on %s: socket is in use", path)
+		}
+		if err := os.Remove(path); err != nil {
+			return nil, fmt.Errorf("remove stale socket: %w", err)
+		}
+	} else if !errors.Is(err, fs.ErrNotExist) {
+		return nil, err
+	}
+
+	ln, err := net.Listen("unix", path)
+	if err != nil {
+		return nil, err
+	}
+	if err := os.Chmod(path, c.SocketFileMode()); err != nil {
+		ln.Close()
+		return nil, fmt.Errorf("set socket permissions: %w", err)
+	}
+	return ln, nil
+}
@@ -0,0 +1,93 @@
+package main
+
+import (
+	"net"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+
+	"github.com/c0dev0id/notesd/server/internal/config"
+)
+
+func TestListenUnix(t *testing.T) {
+	// Arrange: a stale socket file from a server that was killed
+	path := filepath.Join(t.TempDir(), "notesd.sock")
+	stale, err := net.Listen("unix", path)
+	if err != nil {
+		t.Fatalf("listen: %v", err)
+	}
+	stale.(*net.UnixListener).SetUnlinkOnClose(false)
+	stale.Close()
+	c := config.ServerConfig{Listen: "unix:" + path, SocketMode: "0600"}
+
+	// Act
+	ln, err := listen(c)
+
+	// Assert
+	if err != nil {
+		t.Fatalf("listen over stale socket: %v", err)
+	}
+	defer ln.Close()
+	info, err := os.Stat(path)
+	if err != nil {
+		t.Fatalf("stat socket: %v", err)
+	}
+	t.Logf("%s: %v", path, info.Mode())
+	if info.Mode().Perm() != 0o600 {
+		t.Errorf("socket mode: got %o, want 600", info.Mode().Perm())
+	}
+	go func() {
+		if conn, err := ln.Accept(); err == nil {
+			conn.Close()
+		}
+	}()
+	if conn, err := net.Dial("unix", path); err != nil {
+		t.Errorf("dial socket: %v", err)
+	} else {
+		conn.Close()
+	}
+}
+
+func TestListenUnixRefuses(t *testing.T) {
+	dir := t.TempDir()
+	inUse := filepath.Join(dir, "in-use.sock")
+	ln, err := net.Listen("unix", inUse)
+	if err != nil {
+		t.Fatalf("listen: %v", err)
+	}
+	defer ln.Close()
+	go func() {
+		for {
+			conn, err := ln.Accept()
+			if err != nil {
+				return
+			}
+			conn.Close()
+		}
+	}()
+	regular := filepath.Join(dir, "notes.db")
+	os.WriteFile(regular, []byte("data"), 0o600)
+
+	cases := []struct {
+		path, errText string
+	}{
+		{inUse, "socket is in use"},
+		{regular, "not a socket"},
+	}
+	for _, c := range cases {
+		t.Run(filepath.Base(c.path), func(t *testing.T) {
+			// Act
+			_, err := listen(config.ServerConfig{Listen: "unix:" + c.path, SocketMode: "0660"})
+
+			// Assert
+			t.Logf("%s: %v", c.path, err)
+			if err == nil || !strings.Contains(err.Error(), c.errText) {
+				t.Errorf("expected error containing %q", c.errText)
+			}
+			if _, err := os.Lstat(c.path); err != nil {
+				t.Errorf("expected %s to be left alone: %v", c.path, err)
+			}
+		})
+	}
+}
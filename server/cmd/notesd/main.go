@@ -2,40 +2,112 @@ package main
 
 import (
 	"context"
+	"flag"
+	"fmt"
 	"log/slog"
+	"net"
 	"net/http"
 	"os"
 	"os/signal"
+	"strings"
 	"syscall"
 	"time"
 
 	"github.com/c0dev0id/notesd/server/internal/api"
 	"github.com/c0dev0id/notesd/server/internal/config"
-	"github.com/c0dev0id/notesd/server/internal/database"
+	"github.com/c0dev0id/notesd/server/internal/trace"
+	"github.com/c0dev0id/notesd/server/internal/version"
 )
 
+const usage = `Usage: notesd [command] [arguments]
+
+Commands:
+  serve          run the server (the default)
+  migrate        bring the database schema up to date and exit
+  create-user    add an account; the password is read from stdin
+  backup <path>  write a consistent copy of the database to path
+  vacuum         compact the database file
+  config check   validate the configuration and print the effective settings
+
+Configuration is read from $HOME/.notesd.conf and ./notesd.conf, or only
+from the file given with -config, then from NOTESD_* environment variables
+such as NOTESD_SERVER_LISTEN.
+`
+
 func main() {
+	cmd, args := "serve", os.Args[1:]
+	if len(args) > 0 && !strings.HasPrefix(args[0], "-") {
+		cmd, args = args[0], args[1:]
+	}
+	if cmd == "help" || len(args) == 1 && cmd == "serve" && (args[0] == "-h" || args[0] == "-help" || args[0] == "--help") {
+		fmt.Print(usage)
+		return
+	}
+
+	// Only the server logs at info level; the one-shot commands print
+	// their own results.
+	level := slog.LevelWarn
+	if cmd == "serve" {
+		level = slog.LevelInfo
+	}
 	slog.SetDefault(slog.New(slog.NewTextHandler(os.Stderr, &slog.HandlerOptions{
-		Level: slog.LevelInfo,
+		Level: level,
 	})))
 
-	cfg, err := config.Load()
-	if err != nil {
-		slog.Error("load config", "error", err)
+	run := map[string]func([]string) error{
+		"serve":       serve,
+		"migrate":     migrate,
+		"create-user": createUser,
+		"backup":      backup,
+		"vacuum":      vacuum,
+		"config":      configCmd,
+	}[cmd]
+	if run == nil {
+		fmt.Fprintf(os.Stderr, "notesd: unknown command %q\n\n%s", cmd, usage)
+		os.Exit(2)
+	}
+	if err := run(args); err != nil {
+		fmt.Fprintf(os.Stderr, "notesd %s: %v\n", cmd, err)
 		os.Exit(1)
 	}
+}
+
+// serve runs the HTTP server until SIGINT or SIGTERM.
+func serve(args []string) error {
+	fs := flag.NewFlagSet("serve", flag.ExitOnError)
+	conf := configFlag(fs)
+	fs.Usage = func() {
+		fmt.Fprint(fs.Output(), "Usage: notesd serve [-config file]\n\n")
+		fs.PrintDefaults()
+	}
+	fs.Parse(args)
 
-	db, err := database.Open(cfg.Database.Path)
+	cfg, err := config.Load(*conf)
 	if err != nil {
-		slog.Error("open database", "error", err)
-		os.Exit(1)
+		return fmt.Errorf("load config: %w", err)
+	}
+	slog.SetDefault(slog.New(cfg.Log.Handler(os.Stderr)))
+
+	tracer := trace.Init(trace.Config{
+		Endpoint:       cfg.Tracing.Endpoint,
+		Headers:        cfg.Tracing.Headers,
+		SampleRatio:    cfg.Tracing.SampleRatio,
+		ServiceName:    "notesd",
+		ServiceVersion: version.Version,
+	})
+	if tracer != nil {
+		slog.Info("tracing enabled", "endpoint", cfg.Tracing.Endpoint, "sample_ratio", cfg.Tracing.SampleRatio)
+	}
+
+	db, err := openConfigured(cfg.Database)
+	if err != nil {
+		return err
 	}
 	defer db.Close()
 
 	a, err := api.New(db, &cfg)
 	if err != nil {
-		slog.Error("init api", "error", err)
-		os.Exit(1)
+		return fmt.Errorf("init api: %w", err)
 	}
 
 	srv := &http.Server{
@@ -45,18 +117,67 @@ func main() {
 		WriteTimeout: 30 * time.Second,
 		IdleTimeout:  60 * time.Second,
 	}
+	var redirect *http.Server
+	if cfg.Server.TLS.Enabled() {
+		if redirect, err = configureTLS(cfg.Server.TLS, srv); err != nil {
+			return err
+		}
+	}
 
 	// Graceful shutdown on SIGINT/SIGTERM
 	ctx, stop := signal.NotifyContext(context.Background(), syscall.SIGINT, syscall.SIGTERM)
 	defer stop()
 
+	go a.RunDigests(ctx)
+	go a.RunReminders(ctx)
+	go a.RunWebhooks(ctx)
+	go a.RunTombstoneGC(ctx)
+	go a.RunAuditRetention(ctx)
+	go a.RunHistory(ctx)
+	go a.RunTelegram(ctx)
+	go a.RunBackups(ctx)
+	go a.RunMaintenance(ctx)
+	go a.RunKeyRotation(ctx)
+	go a.RunRelay(ctx)
+
+	ln, err := listen(cfg.Server)
+	if err != nil {
+		return err
+	}
+	if cfg.MailIn.Listen != "" {
+		mailLn, err := net.Listen("tcp", cfg.MailIn.Listen)
+		if err != nil {
+			return fmt.Errorf("mail-in listen: %w", err)
+		}
+		go func() {
+			slog.Info("mail-in starting", "addr", cfg.MailIn.Listen, "domain", cfg.MailIn.Domain)
+			if err := a.ServeMailIn(ctx, mailLn); err != nil {
+				slog.Error("mail-in server", "error", err)
+			}
+		}()
+	}
 	go func() {
-		slog.Info("server starting", "addr", cfg.Server.Listen)
-		if err := srv.ListenAndServe(); err != nil && err != http.ErrServerClosed {
+		slog.Info("server starting", "addr", cfg.Server.Listen, "tls", srv.TLSConfig != nil, "version", version.Version)
+		var err error
+		if srv.TLSConfig != nil {
+			err = srv.ServeTLS(ln, "", "")
+		} else {
+			err = srv.Serve(ln)
+		}
+		if err != nil && err != http.ErrServerClosed {
 			slog.Error("listen", "error", err)
 			os.Exit(1)
 		}
 	}()
+	if redirect != nil {
+		go func() {
+			slog.Info("redirecting HTTP to HTTPS", "addr", redirect.Addr)
+			if err := redirect.ListenAndServe(); err != nil && err != http.ErrServerClosed {
+				slog.Error("listen", "addr", redirect.Addr, "error", err)
+				os.Exit(1)
+			}
+		}()
+	}
 
 	<-ctx.Done()
 	slog.Info("shutting down")
@@ -67,4 +188,11 @@ func main() {
 	if err := srv.Shutdown(shutdownCtx); err != nil {
 		slog.Error("shutdown", "error", err)
 	}
+	if redirect != nil {
+		redirect.Shutdown(shutdownCtx)
+	}
+	if err := tracer.Shutdown(shutdownCtx); err != nil {
+		slog.Error("flush traces", "error", err)
+	}
+	return nil
 }
@@ -0,0 +1,74 @@
+package main
+
+import (
+	"crypto/tls"
+	"fmt"
+	"net"
+	"net/http"
+	"time"
+
+	"github.com/c0dev0id/notesd/server/internal/config"
+	"golang.org/x/crypto/acme"
+	"golang.org/x/crypto/acme/autocert"
+)
+
+// configureTLS sets up srv for HTTPS according to cfg. It returns the plain
+// HTTP server for redirect_listen, or nil when none is configured. For
+// ACME, that server also answers http-01 challenges; tls-alpn-01 works on
+// the HTTPS listener itself.
+func configureTLS(cfg config.TLSConfig, srv *http.Server) (*http.Server, error) {
+	var redirect http.Handler = httpsRedirect(srv.Addr)
+
+	if len(cfg.ACMEHosts) > 0 {
+		m := &autocert.Manager{
+			Prompt:     autocert.AcceptTOS,
+			Cache:      autocert.DirCache(cfg.ACMECacheDir),
+			HostPolicy: autocert.HostWhitelist(cfg.ACMEHosts...),
+			Email:      cfg.ACMEEmail,
+		}
+		if cfg.ACMEDirectory != "" {
+			m.Client = &acme.Client{DirectoryURL: cfg.ACMEDirectory}
+		}
+		srv.TLSConfig = m.TLSConfig()
+		redirect = m.HTTPHandler(redirect)
+	} else {
+		cert, err := tls.LoadX509KeyPair(cfg.CertFile, cfg.KeyFile)
+		if err != nil {
+			return nil, fmt.Errorf("load TLS certificate: %w", err)
+		}
+		srv.TLSConfig = &tls.Config{Certificates: []tls.Certificate{cert}}
+	}
+	srv.TLSConfig.MinVersion = tls.VersionTLS12
+
+	if cfg.RedirectListen == "" {
+		return nil, nil
+	}
+	return &http.Server{
+		Addr:         cfg.RedirectListen,
+		Handler:      redirect,
+		ReadTimeout:  10 * time.Second,
+		WriteTimeout: 10 * time.Second,
+		IdleTimeout:  60 * time.Second,
+	}, nil
+}
+
+// httpsRedirect sends every request to the same host and path over HTTPS on
+// the port of listen. GET and HEAD get 301; other methods get 308 so clients
+// repeat them with their body.
+func httpsRedirect(listen string) http.HandlerFunc {
+	_, port, _ := net.SplitHostPort(listen)
+	return func(w http.ResponseWriter, r *http.Request) {
+		host := r.Host
+		if h, _, err := net.SplitHostPort(host); err == nil {
+			host = h
+		}
+		if port != "" && port != "443" {
+			host = net.JoinHostPort(host, port)
+		}
+		code := http.StatusMovedPermanently
+		if r.Method != http.MethodGet && r.Method != http.MethodHead {
+			code = http.StatusPermanentRedirect
+		}
+		http.Redirect(w, r, "https://"+host+r.URL.RequestURI(), code)
+	}
+}
@@ -0,0 +1,105 @@
+package main
+
+import (
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/rand"
+	"crypto/tls"
+	"crypto/x509"
+	"crypto/x509/pkix"
+	"encoding/pem"
+	"math/big"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/c0dev0id/notesd/server/internal/config"
+)
+
+func TestHTTPSRedirect(t *testing.T) {
+	// Arrange
+	cases := []struct {
+		listen, method, host, target string
+		want                         int
+		location                     string
+	}{
+		{":443", "GET", "notes.example.com", "/api/v1/notes?limit=5", 301, "https://notes.example.com/api/v1/notes?limit=5"},
+		{":443", "GET", "notes.example.com:80", "/", 301, "https://notes.example.com/"},
+		{":8443", "HEAD", "notes.example.com", "/health", 301, "https://notes.example.com:8443/health"},
+		{"0.0.0.0:443", "POST", "notes.example.com", "/api/v1/sync/push", 308, "https://notes.example.com/api/v1/sync/push"},
+	}
+
+	for _, c := range cases {
+		req := httptest.NewRequest(c.method, "http://"+c.host+c.target, nil)
+		rec := httptest.NewRecorder()
+
+		// Act
+		httpsRedirect(c.listen).ServeHTTP(rec, req)
+
+		// Assert
+		loc := rec.Header().Get("Location")
+		t.Logf("%s %s%s via %s: %d %s", c.method, c.host, c.target, c.listen, rec.Code, loc)
+		if rec.Code != c.want || loc != c.location {
+			t.Errorf("got %d %s, want %d %s", rec.Code, loc, c.want, c.location)
+		}
+	}
+}
+
+func TestConfigureTLSCertFile(t *testing.T) {
+	// Arrange
+	dir := t.TempDir()
+	certFile, keyFile := filepath.Join(dir, "cert.pem"), filepath.Join(dir, "key.pem")
+	writeSelfSigned(t, certFile, keyFile)
+	srv := &http.Server{Addr: ":8443"}
+
+	// Act
+	redirect, err := configureTLS(config.TLSConfig{
+		CertFile: certFile, KeyFile: keyFile, RedirectListen: ":8080",
+	}, srv)
+
+	// Assert
+	if err != nil {
+		t.Fatalf("configureTLS: %v", err)
+	}
+	t.Logf("certificates: %d, min version: %#x, redirect on %s", len(srv.TLSConfig.Certificates), srv.TLSConfig.MinVersion, redirect.Addr)
+	if len(srv.TLSConfig.Certificates) != 1 || srv.TLSConfig.MinVersion != tls.VersionTLS12 {
+		t.Errorf("unexpected TLS config %+v", srv.TLSConfig)
+	}
+	if redirect == nil || redirect.Addr != ":8080" {
+		t.Errorf("redirect server %+v, want one on :8080", redirect)
+	}
+
+	// Act: a missing key file is reported
+	_, err = configureTLS(config.TLSConfig{CertFile: certFile, KeyFile: filepath.Join(dir, "missing.pem")}, &http.Server{})
+
+	// Assert
+	t.Logf("missing key: %v", err)
+	if err == nil {
+		t.Errorf("expected an error for a missing key file")
+	}
+}
+
+func writeSelfSigned(t *testing.T, certFile, keyFile string) {
+	t.Helper()
+	key, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	if err != nil {
+		t.Fatalf("generate key: %v", err)
+	}
+	tmpl := &x509.Certificate{
+		SerialNumber: big.NewInt(1),
+		Subject:      pkix.Name{CommonName: "localhost"},
+		DNSNames:     []string{"localhost"},
+		NotBefore:    time.Now().Add(-time.Hour),
+		NotAfter:     time.Now().Add(time.Hour),
+	}
+	der, err := x509.CreateCertificate(rand.Reader, tmpl, tmpl, &key.PublicKey, key)
+	if err != nil {
+		t.Fatalf("create certificate: %v", err)
+	}
+	keyDER, _ := x509.MarshalECPrivateKey(key)
+	os.WriteFile(certFile, pem.EncodeToMemory(&pem.Block{Type: "CERTIFICATE", Bytes: der}), 0600)
+	os.WriteFile(keyFile, pem.EncodeToMemory(&pem.Block{Type: "EC PRIVATE KEY", Bytes: keyDER}), 0600)
+}
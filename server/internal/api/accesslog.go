@@ -0,0 +1,287 @@
+package api
+
+import (
+	"bufio"
+	"context"
+	"fmt"
+	"log/slog"
+	"net"
+	"net/http"
+	"net/netip"
+	"os"
+	"strings"
+	"time"
+
+	"github.com/c0dev0id/notesd/server/internal/config"
+	"github.com/c0dev0id/notesd/server/internal/database"
+	"github.com/c0dev0id/notesd/server/internal/model"
+	"github.com/c0dev0id/notesd/server/internal/trace"
+)
+
+// accessFields lists every field an access log line can carry, in the order
+// they are written.
+var accessFields = []string{
+	"method", "path", "status", "duration", "size", "ip", "user_id", "device_id", "request_id",
+}
+
+// maxRequestIDLen bounds client-supplied X-Request-ID values.
+const maxRequestIDLen = 128
+
+// requestInfo collects per-request details for the access log. logRequests
+// stores a pointer in the context so inner middleware such as auth can fill
+// in what only they know.
+type requestInfo struct {
+	id       string
+	clientIP string
+	userID   string
+	deviceID string
+}
+
+const ctxRequestInfo contextKey = "request_info"
+
+func requestInfoFrom(ctx context.Context) *requestInfo {
+	v, _ := ctx.Value(ctxRequestInfo).(*requestInfo)
+	return v
+}
+
+// accessLogger writes one line per request with the configured fields.
+type accessLogger struct {
+	log            *slog.Logger // nil when access logging is off
+	fields         map[string]bool
+	trustedProxies []netip.Prefix
+}
+
+// newAccessLogger builds the access logger from config. An empty
+// access_log uses the application logger, "off" disables access lines and
+// anything else is opened as a file in append mode, written in log.format
+// whatever log.level says.
+func newAccessLogger(srv config.ServerConfig, cfg config.LogConfig) (*accessLogger, error) {
+	al := &accessLogger{fields: map[string]bool{}}
+
+	for _, s := range srv.TrustedProxies {
+		p, err := parsePrefix(s)
+		if err != nil {
+			return nil, fmt.Errorf("server.trusted_proxies: %w", err)
+		}
+		al.trustedProxies = append(al.trustedProxies, p)
+	}
+
+	fields := cfg.AccessFields
+	if len(fields) == 0 {
+		fields = accessFields
+	}
+	for _, f := range fields {
+		f = strings.ToLower(strings.TrimSpace(f))
+		if !isAccessField(f) {
+			return nil, fmt.Errorf("log.access_fields: unknown field %q", f)
+		}
+		al.fields[f] = true
+	}
+
+	switch cfg.AccessLog {
+	case "":
+		al.log = slog.Default()
+	case "off":
+	default:
+		f, err := os.OpenFile(cfg.AccessLog, os.O_APPEND|os.O_CREATE|os.O_WRONLY, 0640)
+		if err != nil {
+			return nil, fmt.Errorf("open access log: %w", err)
+		}
+		al.log = slog.New(config.LogConfig{Format: cfg.Format}.Handler(f))
+	}
+	return al, nil
+}
+
+func isAccessField(name string) bool {
+	for _, f := range accessFields {
+		if f == name {
+			return true
+		}
+	}
+	return false
+}
+
+// parsePrefix accepts a CIDR range or a single address.
+func parsePrefix(s string) (netip.Prefix, error) {
+	s = strings.TrimSpace(s)
+	if strings.Contains(s, "/") {
+		p, err := netip.ParsePrefix(s)
+		return p.Masked(), err
+	}
+	addr, err := netip.ParseAddr(s)
+	if err != nil {
+		return netip.Prefix{}, err
+	}
+	return netip.PrefixFrom(addr.Unmap(), addr.Unmap().BitLen()), nil
+}
+
+func (al *accessLogger) trusted(addr netip.Addr) bool {
+	addr = addr.Unmap()
+	for _, p := range al.trustedProxies {
+		if p.Contains(addr) {
+			return true
+		}
+	}
+	return false
+}
+
+// clientIP returns the address of the client. X-Forwarded-For is only
+// believed when the direct peer is a trusted proxy; it is then walked from
+// the right, skipping further trusted proxies, so clients cannot spoof an
+// address by sending the header themselves. X-Real-IP is used when a
+// trusted proxy sends no X-Forwarded-For. A peer on the server's Unix socket
+// is a local reverse proxy and always trusted.
+func (al *accessLogger) clientIP(r *http.Request) string {
+	host, _, err := net.SplitHostPort(r.RemoteAddr)
+	if err != nil {
+		host = r.RemoteAddr
+	}
+	_, local := r.Context().Value(http.LocalAddrContextKey).(*net.UnixAddr)
+	peer, err := netip.ParseAddr(host)
+	if !local && (err != nil || !al.trusted(peer)) {
+		return host
+	}
+
+	// Each proxy may add its own header line rather than extend the last
+	// one, so the lines are read as one list.
+	if xff := strings.Join(r.Header.Values("X-Forwarded-For"), ","); xff != "" {
+		hops := strings.Split(xff, ",")
+		for i := len(hops) - 1; i >= 0; i-- {
+			addr, err := netip.ParseAddr(strings.TrimSpace(hops[i]))
+			if err != nil {
+				break
+			}
+			host = addr.Unmap().String()
+			if !al.trusted(addr) {
+				break
+			}
+		}
+		return host
+	}
+	if addr, err := netip.ParseAddr(strings.TrimSpace(r.Header.Get("X-Real-IP"))); err == nil {
+		return addr.Unmap().String()
+	}
+	return host
+}
+
+// requestID returns the client's X-Request-ID if it is short and made of
+// safe characters, or a new ID otherwise.
+func requestID(r *http.Request) string {
+	id := r.Header.Get("X-Request-ID")
+	if id == "" || len(id) > maxRequestIDLen {
+		return model.NewID()
+	}
+	for _, c := range id {
+		if !(c >= 'a' && c <= 'z' || c >= 'A' && c <= 'Z' || c >= '0' && c <= '9' ||
+			c == '-' || c == '_' || c == '.') {
+			return model.NewID()
+		}
+	}
+	return id
+}
+
+// logRequests assigns a request ID, echoes it in the X-Request-ID response
+// header, traces the request as a server span continuing any traceparent
+// sent by the client and writes an access log line once the handler returns.
+// Requests taking log.slow_request or longer are also logged as a warning
+// with their route and database time.
+func (a *API) logRequests(next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		start := time.Now()
+		al := a.accessLog
+		info := &requestInfo{id: requestID(r), clientIP: al.clientIP(r)}
+		w.Header().Set("X-Request-ID", info.id)
+
+		ctx := trace.Extract(r.Context(), r.Header.Get("traceparent"))
+		ctx, span := trace.StartSpan(ctx, r.Method, trace.KindServer)
+		ctx = context.WithValue(ctx, ctxRequestInfo, info)
+		stats := &database.Stats{}
+		ctx = database.WithStats(ctx, stats)
+
+		sw := &statusWriter{ResponseWriter: w, status: 200}
+		r2 := r.WithContext(ctx)
+		next.ServeHTTP(sw, r2)
+		elapsed := time.Since(start)
+
+		if a.slowRequest > 0 && elapsed >= a.slowRequest {
+			route := r2.Pattern
+			if route == "" {
+				route = r.Method + " " + r.URL.Path
+			}
+			slog.Warn("slow request", "route", route, "status", sw.status, "duration", elapsed,
+				"db_time", stats.Duration(), "db_statements", stats.Statements(),
+				"user_id", info.userID, "request_id", info.id)
+		}
+
+		if span != nil {
+			// timeouts records the matched route on the request.
+			if r2.Pattern != "" {
+				span.SetName(r2.Pattern)
+				span.SetAttr("http.route", r2.Pattern)
+			}
+			span.SetAttr("http.request.method", r.Method)
+			span.SetAttr("url.path", r.URL.Path)
+			span.SetAttr("http.response.status_code", sw.status)
+			span.SetAttr("client.address", info.clientIP)
+			span.SetAttr("notesd.request_id", info.id)
+			if info.userID != "" {
+				span.SetAttr("enduser.id", info.userID)
+			}
+			if sw.status >= 500 {
+				span.SetError(fmt.Errorf("HTTP %d", sw.status))
+			}
+			span.End()
+		}
+
+		if al.log == nil {
+			return
+		}
+		values := map[string]any{
+			"method":     r.Method,
+			"path":       r.URL.Path,
+			"status":     sw.status,
+			"duration":   elapsed,
+			"size":       sw.size,
+			"ip":         info.clientIP,
+			"user_id":    info.userID,
+			"device_id":  info.deviceID,
+			"request_id": info.id,
+		}
+		attrs := make([]slog.Attr, 0, len(al.fields))
+		for _, f := range accessFields {
+			if al.fields[f] {
+				attrs = append(attrs, slog.Any(f, values[f]))
+			}
+		}
+		al.log.LogAttrs(r.Context(), slog.LevelInfo, "request", attrs...)
+	})
+}
+
+type statusWriter struct {
+	http.ResponseWriter
+	status int
+	size   int64
+}
+
+func (w *statusWriter) WriteHeader(code int) {
+	w.status = code
+	w.ResponseWriter.WriteHeader(code)
+}
+
+func (w *statusWriter) Write(b []byte) (int, error) {
+	n, err := w.ResponseWriter.Write(b)
+	w.size += int64(n)
+	return n, err
+}
+
+// Hijack records the protocol switch for the access log, as WebSocket
+// upgrades never call WriteHeader.
+func (w *statusWriter) Hijack() (net.Conn, *bufio.ReadWriter, error) {
+	w.status = http.StatusSwitchingProtocols
+	return http.NewResponseController(w.ResponseWriter).Hijack()
+}
+
+// Unwrap lets http.ResponseController reach the underlying writer.
+func (w *statusWriter) Unwrap() http.ResponseWriter {
+	return w.ResponseWriter
+}
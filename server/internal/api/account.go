@@ -0,0 +1,172 @@
+package api
+
+import (
+	"errors"
+	"log/slog"
+	"net/http"
+	"time"
+
+	"github.com/c0dev0id/notesd/server/internal/database"
+	"github.com/c0dev0id/notesd/server/internal/model"
+	"golang.org/x/crypto/bcrypt"
+)
+
+// handleDeleteAccount removes the account and all its data for good once
+// the password is confirmed. There is no grace period; clients should
+// offer GET /account/export first.
+func (a *API) handleDeleteAccount(w http.ResponseWriter, r *http.Request) {
+	userID := userIDFrom(r.Context())
+
+	var req model.DeleteAccountRequest
+	if err := decodeJSON(r, &req); err != nil {
+		writeError(w, http.StatusBadRequest, "invalid request body")
+		return
+	}
+	if req.Password == "" {
+		writeError(w, http.StatusBadRequest, "password is required")
+		return
+	}
+
+	db := a.dbFor(r)
+	user, err := db.GetUserByID(userID)
+	if err != nil {
+		slog.Error("get user for account deletion", "error", err)
+		writeError(w, http.StatusInternalServerError, "internal error")
+		return
+	}
+	if err := bcrypt.CompareHashAndPassword([]byte(user.PasswordHash), []byte(req.Password)); err != nil {
+		writeError(w, http.StatusForbidden, "password is incorrect")
+		return
+	}
+
+	attachmentIDs, err := db.DeleteAccount(userID)
+	if err != nil {
+		slog.Error("delete account", "error", err)
+		writeError(w, http.StatusInternalServerError, "internal error")
+		return
+	}
+	a.removeBlobs(attachmentIDs)
+	slog.Info("account deleted", "user_id", userID)
+	w.WriteHeader(http.StatusNoContent)
+}
+
+// handleAccountExport returns everything stored about the account as one
+// JSON document, for data portability requests.
+func (a *API) handleAccountExport(w http.ResponseWriter, r *http.Request) {
+	exp, err := a.accountExport(a.dbFor(r), userIDFrom(r.Context()))
+	if err != nil {
+		slog.Error("account export", "error", err)
+		writeError(w, http.StatusInternalServerError, "internal error")
+		return
+	}
+	w.Header().Set("Content-Disposition", `attachment; filename="notesd-account.json"`)
+	writeJSON(w, http.StatusOK, exp)
+}
+
+func (a *API) accountExport(db *database.DB, userID string) (*model.AccountExport, error) {
+	now := model.NowMillis()
+	exp := &model.AccountExport{Format: "notesd-account", Version: 1, ExportedAt: now}
+
+	user, err := db.GetUserByID(userID)
+	if err != nil {
+		return nil, err
+	}
+	exp.User = *user
+	if exp.Notes, err = db.GetNoteChangesSince(userID, 0); err != nil {
+		return nil, err
+	}
+	if exp.Todos, err = db.GetTodoChangesSince(userID, 0); err != nil {
+		return nil, err
+	}
+	if exp.Attachments, err = db.GetAttachmentChangesSince(userID, 0); err != nil {
+		return nil, err
+	}
+	if exp.Tags, err = db.ListTags(userID); err != nil {
+		return nil, err
+	}
+	if exp.PublicLinks, err = db.GetAllPublicLinks(userID, now.UnixMilli()); err != nil {
+		return nil, err
+	}
+	for i := range exp.PublicLinks {
+		exp.PublicLinks[i].URL = "/p/" + exp.PublicLinks[i].Slug
+	}
+	if exp.AccessTokens, err = db.ListAccessTokens(userID); err != nil {
+		return nil, err
+	}
+	if exp.PushSubscriptions, err = db.ListPushSubscriptions(userID); err != nil {
+		return nil, err
+	}
+	if exp.ReminderWebhook, err = db.GetReminderWebhook(userID); err != nil {
+		return nil, err
+	}
+	if exp.DigestSettings, err = db.GetDigestSettings(userID); err != nil {
+		return nil, err
+	}
+	if exp.AccountSettings, err = db.GetAccountSettings(userID); err != nil {
+		return nil, err
+	}
+	created, err := db.GetCalendarFeedCreated(userID)
+	if err == nil {
+		exp.CalendarFeedCreatedAt = &created
+	} else if !errors.Is(err, database.ErrNotFound) {
+		return nil, err
+	}
+
+	if exp.Notes == nil {
+		exp.Notes = []model.Note{}
+	}
+	if exp.Todos == nil {
+		exp.Todos = []model.Todo{}
+	}
+	if exp.Attachments == nil {
+		exp.Attachments = []model.Attachment{}
+	}
+	if exp.Tags == nil {
+		exp.Tags = []model.TagUsage{}
+	}
+	if exp.PublicLinks == nil {
+		exp.PublicLinks = []model.PublicLink{}
+	}
+	if exp.PushSubscriptions == nil {
+		exp.PushSubscriptions = []model.PushSubscription{}
+	}
+	return exp, nil
+}
+
+func (a *API) handleGetAccountSettings(w http.ResponseWriter, r *http.Request) {
+	userID := userIDFrom(r.Context())
+
+	s, err := a.dbFor(r).GetAccountSettings(userID)
+	if err != nil {
+		slog.Error("get account settings", "error", err)
+		writeError(w, http.StatusInternalServerError, "internal error")
+		return
+	}
+
+	writeJSON(w, http.StatusOK, s)
+}
+
+func (a *API) handleUpdateAccountSettings(w http.ResponseWriter, r *http.Request) {
+	userID := userIDFrom(r.Context())
+
+	var req model.AccountSettings
+	if err := decodeJSON(r, &req); err != nil {
+		writeError(w, http.StatusBadRequest, "invalid request body")
+		return
+	}
+	if req.Timezone == "" {
+		req.Timezone = "UTC"
+	}
+	if _, err := time.LoadLocation(req.Timezone); err != nil {
+		writeError(w, http.StatusBadRequest, "unknown timezone")
+		return
+	}
+
+	if err := a.dbFor(r).SaveAccountSettings(userID, req); err != nil {
+		slog.Error("save account settings", "error", err)
+		writeError(w, http.StatusInternalServerError, "internal error")
+		return
+	}
+
+	writeJSON(w, http.StatusOK, req)
+}
@@ -0,0 +1,60 @@
+package api
+
+import (
+	"log/slog"
+	"net/http"
+	"time"
+
+	"github.com/c0dev0id/notesd/server/internal/model"
+)
+
+// handleAdminOverview aggregates per-user usage plus daily sync traffic and
+// registrations over the last ?days= days (default 30, max 365).
+func (a *API) handleAdminOverview(w http.ResponseWriter, r *http.Request) {
+	days := queryInt(r, "days", 30)
+	if days < 1 {
+		days = 1
+	}
+	if days > 365 {
+		days = 365
+	}
+
+	now := model.NowMillis()
+	today := time.Date(now.Year(), now.Month(), now.Day(), 0, 0, 0, 0, time.UTC)
+	since := today.AddDate(0, 0, -(days - 1))
+
+	users, err := a.dbFor(r).GetUserStats(now)
+	if err != nil {
+		slog.Error("admin user stats", "error", err)
+		writeError(w, http.StatusInternalServerError, "internal error")
+		return
+	}
+	traffic, err := a.dbFor(r).GetSyncTraffic(since)
+	if err != nil {
+		slog.Error("admin sync traffic", "error", err)
+		writeError(w, http.StatusInternalServerError, "internal error")
+		return
+	}
+	registrations, err := a.dbFor(r).GetRegistrations(since)
+	if err != nil {
+		slog.Error("admin registrations", "error", err)
+		writeError(w, http.StatusInternalServerError, "internal error")
+		return
+	}
+
+	if users == nil {
+		users = []model.AdminUserStats{}
+	}
+	if traffic == nil {
+		traffic = []model.SyncTrafficDay{}
+	}
+	if registrations == nil {
+		registrations = []model.DailyCount{}
+	}
+
+	writeJSON(w, http.StatusOK, model.AdminOverview{
+		Users:         users,
+		SyncTraffic:   traffic,
+		Registrations: registrations,
+	})
+}
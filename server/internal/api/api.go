@@ -1,37 +1,82 @@
 package api
 
 import (
-	"crypto/rsa"
-	"crypto/x509"
 	"encoding/json"
-	"encoding/pem"
 	"fmt"
 	"io"
 	"log/slog"
 	"net/http"
-	"os"
 	"strconv"
+	"sync"
+	"sync/atomic"
 	"time"
 
-	"crypto/rand"
-
+	"github.com/c0dev0id/notesd/server/internal/blob"
 	"github.com/c0dev0id/notesd/server/internal/config"
 	"github.com/c0dev0id/notesd/server/internal/database"
+	"github.com/c0dev0id/notesd/server/internal/events"
+	"github.com/c0dev0id/notesd/server/internal/githistory"
+	"github.com/c0dev0id/notesd/server/internal/mail"
 	"github.com/c0dev0id/notesd/server/internal/model"
+	"github.com/c0dev0id/notesd/server/internal/pubsub"
+	"github.com/c0dev0id/notesd/server/internal/push"
+	"github.com/c0dev0id/notesd/server/internal/telegram"
+	"github.com/c0dev0id/notesd/server/internal/unfurl"
+	"golang.org/x/net/webdav"
 )
 
 type API struct {
 	db                 *database.DB
 	config             *config.Config
-	privateKey         *rsa.PrivateKey
+	keys               *keyring
+	signingAlg         string
+	keyRotation        time.Duration
 	accessTokenExpiry  time.Duration
 	refreshTokenExpiry time.Duration
+	tombstoneRetention time.Duration
+	auditRetention     time.Duration
+	backupInterval     time.Duration
+	backupMu           sync.Mutex // serialises snapshots and rotation
+	maintainInterval   time.Duration
+	lastMaintenance    atomic.Pointer[model.Maintenance] // nil before the first run
+	syncPageBytes      int
+	maxPushSize        int64
+	requestTimeout     time.Duration
+	longRequestTimeout time.Duration
+	slowRequest        time.Duration
 	authLimiter        *rateLimiter
+	writeLimiter       *rateLimiter
+	mailer             mail.Sender
+	accessLog          *accessLogger
+	cors               *corsPolicy
+	bus                *events.Bus
+	hub                *hub
+	broker             pubsub.Broker // nil while off
+	relayQueue         chan []byte
+	blobs              *blob.Store
+	webPush            pushSender
+	webhooks           webhookSender
+	telegram           telegramBot // nil while off
+	webhookPoster      webhookPoster
+	pages              pageFetcher
+	previews           *previewCache // nil while off
+	webhookWake        chan struct{}
+	reminderAlarm      *reminderAlarm
+	davMu              sync.Mutex
+	davLocks           map[string]webdav.LockSystem // by user ID
+	history            *githistory.Repo             // nil while off
+	historyMu          sync.Mutex
+	historyQueue       []historyBatch
+	historyWake        chan struct{}
 	startTime          time.Time
 }
 
 func New(db *database.DB, cfg *config.Config) (*API, error) {
-	key, err := loadOrGenerateKey(cfg.Auth.PrivateKeyPath)
+	signingAlg := cfg.Auth.SigningAlgorithm
+	if signingAlg == "" {
+		signingAlg = algRS256
+	}
+	keys, err := loadKeyring(cfg.Auth.PrivateKeyPath, signingAlg)
 	if err != nil {
 		return nil, fmt.Errorf("load key: %w", err)
 	}
@@ -44,25 +89,193 @@ func New(db *database.DB, cfg *config.Config) (*API, error) {
 	if err != nil {
 		return nil, fmt.Errorf("parse refresh_token_expiry: %w", err)
 	}
+	// A new algorithm takes effect with a new key; the old one keeps
+	// verifying until its tokens have expired.
+	if changed, err := keys.rotate(time.Now(), signingAlg, 0, max(accessExp, refreshExp)); err != nil {
+		return nil, fmt.Errorf("switch signing key: %w", err)
+	} else if changed {
+		slog.Info("signing keys updated", "current", keys.current().id, "alg", keys.current().alg)
+	}
+	var retention time.Duration
+	if cfg.Sync.TombstoneRetention != "" {
+		retention, err = time.ParseDuration(cfg.Sync.TombstoneRetention)
+		if err != nil || retention < 0 {
+			return nil, fmt.Errorf("parse tombstone_retention: invalid duration %q", cfg.Sync.TombstoneRetention)
+		}
+	}
+
+	var keyRotation time.Duration
+	if cfg.Auth.KeyRotation != "" {
+		keyRotation, err = time.ParseDuration(cfg.Auth.KeyRotation)
+		if err != nil || keyRotation < 0 {
+			return nil, fmt.Errorf("parse auth.key_rotation: invalid duration %q", cfg.Auth.KeyRotation)
+		}
+	}
+	var auditRetention time.Duration
+	if cfg.Audit.Retention != "" {
+		auditRetention, err = time.ParseDuration(cfg.Audit.Retention)
+		if err != nil || auditRetention < 0 {
+			return nil, fmt.Errorf("parse audit.retention: invalid duration %q", cfg.Audit.Retention)
+		}
+	}
+	var backupInterval time.Duration
+	if cfg.Backup.Interval != "" {
+		backupInterval, err = time.ParseDuration(cfg.Backup.Interval)
+		if err != nil || backupInterval < 0 {
+			return nil, fmt.Errorf("parse backup.interval: invalid duration %q", cfg.Backup.Interval)
+		}
+	}
+	var maintainInterval time.Duration
+	if cfg.Database.MaintenanceInterval != "" {
+		maintainInterval, err = time.ParseDuration(cfg.Database.MaintenanceInterval)
+		if err != nil || maintainInterval < 0 {
+			return nil, fmt.Errorf("parse database.maintenance_interval: invalid duration %q", cfg.Database.MaintenanceInterval)
+		}
+	}
+
+	var requestTimeout, longRequestTimeout, slowRequest, previewTTL time.Duration
+	for _, d := range []struct {
+		name, value string
+		dst         *time.Duration
+	}{
+		{"server.request_timeout", cfg.Server.RequestTimeout, &requestTimeout},
+		{"server.long_request_timeout", cfg.Server.LongRequestTimeout, &longRequestTimeout},
+		{"log.slow_request", cfg.Log.SlowRequest, &slowRequest},
+		{"unfurl.cache_ttl", cfg.Unfurl.CacheTTL, &previewTTL},
+	} {
+		if d.value == "" {
+			continue
+		}
+		if *d.dst, err = time.ParseDuration(d.value); err != nil || *d.dst < 0 {
+			return nil, fmt.Errorf("parse %s: invalid duration %q", d.name, d.value)
+		}
+	}
+
+	accessLog, err := newAccessLogger(cfg.Server, cfg.Log)
+	if err != nil {
+		return nil, err
+	}
 
-	// 20 requests per minute per IP for auth endpoints
-	limiter := newRateLimiter(20, time.Minute)
+	cors, err := newCORSPolicy(cfg.Server.CORSOrigins)
+	if err != nil {
+		return nil, err
+	}
+
+	blobs, err := blob.Open(cfg.Attachments.Dir)
+	if err != nil {
+		return nil, err
+	}
+
+	var webPush pushSender
+	if wp, err := push.NewWebPush(cfg.Push); err != nil {
+		return nil, err
+	} else if wp != nil {
+		webPush = wp
+	}
+
+	var bot telegramBot
+	if b := telegram.New(cfg.Telegram); b != nil {
+		bot = b
+	}
+
+	var broker pubsub.Broker
+	if cfg.Sync.PubSub != "" {
+		if broker, err = pubsub.Open(cfg.Sync.PubSub); err != nil {
+			return nil, err
+		}
+	}
+
+	var history *githistory.Repo
+	if cfg.History.GitDir != "" {
+		if history, err = githistory.Open(cfg.History.GitDir, cfg.History.Remote); err != nil {
+			return nil, fmt.Errorf("open note history: %w", err)
+		}
+	}
+
+	authWindow, err := parseWindow("rate_limit.auth_window", cfg.RateLimit.AuthWindow)
+	if err != nil {
+		return nil, err
+	}
+	writesWindow, err := parseWindow("rate_limit.writes_window", cfg.RateLimit.WritesWindow)
+	if err != nil {
+		return nil, err
+	}
+	authLimiter := newRateLimiter(cfg.RateLimit.Auth, authWindow)
+	writeLimiter := newRateLimiter(cfg.RateLimit.Writes, writesWindow)
+	if cfg.RateLimit.Backend == "redis" {
+		limits, err := pubsub.OpenRedis(cfg.RateLimit.Redis)
+		if err != nil {
+			return nil, err
+		}
+		authLimiter = authLimiter.sharedIn(limits, "auth")
+		writeLimiter = writeLimiter.sharedIn(limits, "writes")
+	}
 	go func() {
 		for {
 			time.Sleep(5 * time.Minute)
-			limiter.cleanup()
+			authLimiter.cleanup()
+			writeLimiter.cleanup()
 		}
 	}()
 
-	return &API{
+	maxPushSize := cfg.Sync.MaxPushSize
+	if maxPushSize <= 0 {
+		maxPushSize = defaultMaxPushSize
+	}
+
+	a := &API{
 		db:                 db,
 		config:             cfg,
-		privateKey:         key,
+		keys:               keys,
+		signingAlg:         signingAlg,
+		keyRotation:        keyRotation,
 		accessTokenExpiry:  accessExp,
 		refreshTokenExpiry: refreshExp,
-		authLimiter:        limiter,
+		tombstoneRetention: retention,
+		auditRetention:     auditRetention,
+		backupInterval:     backupInterval,
+		maintainInterval:   maintainInterval,
+		syncPageBytes:      syncPageBytes,
+		maxPushSize:        maxPushSize,
+		requestTimeout:     requestTimeout,
+		longRequestTimeout: longRequestTimeout,
+		slowRequest:        slowRequest,
+		authLimiter:        authLimiter,
+		writeLimiter:       writeLimiter,
+		mailer:             mail.New(cfg.SMTP),
+		accessLog:          accessLog,
+		cors:               cors,
+		bus:                events.NewBus(),
+		hub:                newHub(),
+		broker:             broker,
+		relayQueue:         make(chan []byte, relayQueueSize),
+		blobs:              blobs,
+		webPush:            webPush,
+		webhooks:           push.NewWebhook(),
+		telegram:           bot,
+		webhookPoster:      push.NewWebhook(),
+		pages:              unfurl.New(cfg.Unfurl.Allow, cfg.Unfurl.Deny),
+		previews:           newPreviewCache(previewTTL),
+		webhookWake:        make(chan struct{}, 1),
+		reminderAlarm:      &reminderAlarm{wake: make(chan struct{}, 1)},
+		history:            history,
+		historyWake:        make(chan struct{}, 1),
 		startTime:          time.Now(),
-	}, nil
+	}
+	a.subscribe()
+	return a, nil
+}
+
+// parseWindow parses a rate limit window, defaulting to one minute.
+func parseWindow(name, s string) (time.Duration, error) {
+	if s == "" {
+		return time.Minute, nil
+	}
+	d, err := time.ParseDuration(s)
+	if err != nil || d <= 0 {
+		return 0, fmt.Errorf("parse %s: invalid duration %q", name, s)
+	}
+	return d, nil
 }
 
 func (a *API) Routes() http.Handler {
@@ -70,51 +283,181 @@ func (a *API) Routes() http.Handler {
 
 	// Health check
 	mux.HandleFunc("GET /api/v1/health", a.handleHealth)
+	mux.HandleFunc("GET /api/v1/version", a.handleVersion)
+	mux.HandleFunc("GET /api/v1/meta/note-types", a.handleNoteTypes)
+	mux.HandleFunc("GET /api/v1/openapi.json", a.handleOpenAPI)
+	if a.config.Server.SwaggerUI {
+		mux.HandleFunc("GET /api/docs", a.handleAPIDocs)
+	}
+	if a.config.Server.WebUI {
+		mux.HandleFunc("GET /", a.handleWebUI)
+	}
+
+	mux.HandleFunc("GET /.well-known/jwks.json", a.handleJWKS)
 
 	// Public auth routes (rate limited)
 	mux.HandleFunc("POST /api/v1/auth/register", a.authLimiter.rateLimit(a.handleRegister))
 	mux.HandleFunc("POST /api/v1/auth/login", a.authLimiter.rateLimit(a.handleLogin))
 	mux.HandleFunc("POST /api/v1/auth/refresh", a.authLimiter.rateLimit(a.handleRefresh))
+	mux.HandleFunc("POST /api/v1/auth/reset-request", a.authLimiter.rateLimit(a.handleResetRequest))
+	mux.HandleFunc("POST /api/v1/auth/reset-confirm", a.authLimiter.rateLimit(a.handleResetConfirm))
 
 	// Protected auth routes
-	mux.HandleFunc("POST /api/v1/auth/logout", a.auth(a.handleLogout))
+	mux.HandleFunc("POST /api/v1/auth/logout", a.session(a.handleLogout))
+	mux.HandleFunc("POST /api/v1/auth/password", a.authLimiter.rateLimit(a.session(a.handleChangePassword)))
+	mux.HandleFunc("GET /api/v1/auth/tokens", a.session(a.handleListAccessTokens))
+	mux.HandleFunc("POST /api/v1/auth/tokens", a.session(a.handleCreateAccessToken))
+	mux.HandleFunc("DELETE /api/v1/auth/tokens/{id}", a.session(a.handleDeleteAccessToken))
+
+	mux.HandleFunc("GET /api/v1/search", a.auth(a.handleSearch))
+	mux.HandleFunc("GET /api/v1/search/validate", a.auth(a.handleValidateSearch))
 
 	// Notes
 	mux.HandleFunc("GET /api/v1/notes/search", a.auth(a.handleSearchNotes))
+	mux.HandleFunc("POST /api/v1/notes/bulk", a.auth(a.handleBulkNotes))
+	mux.HandleFunc("GET /api/v1/notes/duplicates", a.auth(a.handleFindDuplicates))
 	mux.HandleFunc("GET /api/v1/notes/{id}", a.auth(a.handleGetNote))
+	mux.HandleFunc("GET /api/v1/notes/{id}/html", a.auth(a.handleNoteHTML))
+	mux.HandleFunc("GET /api/v1/notes/{id}/backlinks", a.auth(a.handleBacklinks))
+	mux.HandleFunc("GET /api/v1/notes/{id}/history", a.auth(a.handleNoteHistory))
+	mux.HandleFunc("GET /api/v1/notes/{id}/diff", a.auth(a.handleNoteDiff))
 	mux.HandleFunc("GET /api/v1/notes", a.auth(a.handleListNotes))
 	mux.HandleFunc("POST /api/v1/notes", a.auth(a.handleCreateNote))
 	mux.HandleFunc("PUT /api/v1/notes/{id}", a.auth(a.handleUpdateNote))
+	mux.HandleFunc("PATCH /api/v1/notes/{id}", a.auth(a.handlePatchNote))
 	mux.HandleFunc("DELETE /api/v1/notes/{id}", a.auth(a.handleDeleteNote))
+	mux.HandleFunc("POST /api/v1/notes/{id}/merge", a.auth(a.handleMergeNotes))
+	mux.HandleFunc("POST /api/v1/notes/{id}/snooze", a.auth(a.handleSnoozeNote))
+	mux.HandleFunc("DELETE /api/v1/notes/{id}/snooze", a.auth(a.handleUnsnoozeNote))
+	mux.HandleFunc("POST /api/v1/notes/{id}/unfurl", a.auth(a.handleUnfurlNote))
+	mux.HandleFunc("POST /api/v1/bookmarks", a.auth(a.handleCreateBookmark))
+	mux.HandleFunc("GET /api/v1/unfurl", a.auth(a.handleUnfurl))
+
+	// Reminders
+	mux.HandleFunc("GET /api/v1/push/vapid-key", a.handleVAPIDKey)
+	mux.HandleFunc("GET /api/v1/push/subscriptions", a.auth(a.handleListPushSubscriptions))
+	mux.HandleFunc("POST /api/v1/push/subscriptions", a.auth(a.handleRegisterPushSubscription))
+	mux.HandleFunc("DELETE /api/v1/push/subscriptions/{id}", a.auth(a.handleDeletePushSubscription))
+	mux.HandleFunc("GET /api/v1/reminders/webhook", a.auth(a.handleGetReminderWebhook))
+	mux.HandleFunc("PUT /api/v1/reminders/webhook", a.auth(a.handleSetReminderWebhook))
+
+	// Webhooks
+	mux.HandleFunc("GET /api/v1/webhooks", a.auth(a.handleListWebhooks))
+	mux.HandleFunc("POST /api/v1/webhooks", a.auth(a.handleCreateWebhook))
+	mux.HandleFunc("DELETE /api/v1/webhooks/{id}", a.auth(a.handleDeleteWebhook))
+	mux.HandleFunc("GET /api/v1/webhooks/{id}/deliveries", a.auth(a.handleListWebhookDeliveries))
+
+	// Public links
+	mux.HandleFunc("POST /api/v1/notes/{id}/publish", a.auth(a.handlePublishNote))
+	mux.HandleFunc("GET /api/v1/notes/{id}/links", a.auth(a.handleListPublicLinks))
+	mux.HandleFunc("DELETE /api/v1/links/{slug}", a.auth(a.handleRevokePublicLink))
+	mux.HandleFunc("GET /p/{slug}", a.handlePublicNote)
+
+	// Attachments
+	mux.HandleFunc("GET /api/v1/notes/{id}/attachments", a.auth(a.handleListAttachments))
+	mux.HandleFunc("POST /api/v1/notes/{id}/attachments", a.auth(a.handleUploadAttachment))
+	mux.HandleFunc("GET /api/v1/attachments/{id}", a.auth(a.handleGetAttachment))
+	mux.HandleFunc("DELETE /api/v1/attachments/{id}", a.auth(a.handleDeleteAttachment))
+
+	// Tags
+	mux.HandleFunc("GET /api/v1/tags", a.auth(a.handleListTags))
+	mux.HandleFunc("POST /api/v1/tags/{name}/rename", a.auth(a.handleRenameTag))
+	mux.HandleFunc("POST /api/v1/tags/{name}/merge", a.auth(a.handleMergeTag))
+	mux.HandleFunc("DELETE /api/v1/tags/{name}", a.auth(a.handleDeleteTag))
+
+	// Note link graph
+	mux.HandleFunc("GET /api/v1/graph", a.auth(a.handleGraph))
 
 	// Todos
+	mux.HandleFunc("POST /api/v1/todos/bulk", a.auth(a.handleBulkTodos))
+	mux.HandleFunc("GET /api/v1/todos/search", a.auth(a.handleSearchTodos))
 	mux.HandleFunc("GET /api/v1/todos/overdue", a.auth(a.handleGetOverdueTodos))
+	mux.HandleFunc("GET /api/v1/todos/summary", a.auth(a.handleTodoSummary))
+	mux.HandleFunc("GET /api/v1/todos/today", a.auth(a.handleTodosToday))
+	mux.HandleFunc("GET /api/v1/todos/week", a.auth(a.handleTodosWeek))
+	mux.HandleFunc("GET /api/v1/todos/calendar", a.auth(a.handleTodoCalendar))
+	mux.HandleFunc("GET /api/v1/todos/calendar.ics", a.handleCalendarFeed)
+	mux.HandleFunc("GET /api/v1/todos/calendar/feed", a.auth(a.handleGetCalendarFeed))
+	mux.HandleFunc("POST /api/v1/todos/calendar/feed", a.auth(a.handleCreateCalendarFeed))
+	mux.HandleFunc("DELETE /api/v1/todos/calendar/feed", a.auth(a.handleDeleteCalendarFeed))
+	mux.HandleFunc("GET /api/v1/notes/feed.atom", a.handleNoteFeed)
+	mux.HandleFunc("GET /api/v1/notes/feed", a.auth(a.handleListNoteFeeds))
+	mux.HandleFunc("POST /api/v1/notes/feed", a.auth(a.handleCreateNoteFeed))
+	mux.HandleFunc("DELETE /api/v1/notes/feed", a.auth(a.handleDeleteNoteFeed))
+	mux.HandleFunc("GET /api/v1/mail-in", a.auth(a.handleGetMailIn))
+	mux.HandleFunc("POST /api/v1/mail-in", a.auth(a.handleCreateMailIn))
+	mux.HandleFunc("DELETE /api/v1/mail-in", a.auth(a.handleDeleteMailIn))
+	mux.HandleFunc("GET /api/v1/telegram", a.auth(a.handleGetTelegram))
+	mux.HandleFunc("POST /api/v1/telegram/link", a.auth(a.handleCreateTelegramLink))
+	mux.HandleFunc("DELETE /api/v1/telegram", a.auth(a.handleDeleteTelegram))
 	mux.HandleFunc("GET /api/v1/todos/{id}", a.auth(a.handleGetTodo))
 	mux.HandleFunc("GET /api/v1/todos", a.auth(a.handleListTodos))
 	mux.HandleFunc("POST /api/v1/todos", a.auth(a.handleCreateTodo))
 	mux.HandleFunc("PUT /api/v1/todos/{id}", a.auth(a.handleUpdateTodo))
+	mux.HandleFunc("PATCH /api/v1/todos/{id}", a.auth(a.handlePatchTodo))
+	mux.HandleFunc("POST /api/v1/todos/{id}/move", a.auth(a.handleMoveTodo))
 	mux.HandleFunc("DELETE /api/v1/todos/{id}", a.auth(a.handleDeleteTodo))
 
 	// Sync
 	mux.HandleFunc("GET /api/v1/sync/changes", a.auth(a.handleSyncChanges))
 	mux.HandleFunc("POST /api/v1/sync/push", a.auth(a.handleSyncPush))
+	mux.HandleFunc("GET /api/v1/sync/manifest", a.auth(a.handleSyncManifest))
+	mux.HandleFunc("POST /api/v1/sync/fetch", a.auth(a.handleSyncFetch))
+	mux.HandleFunc("GET /api/v1/sync/conflicts", a.auth(a.handleListConflicts))
+	mux.HandleFunc("GET /api/v1/sync/ws", tokenFromQuery(a.auth(a.handleSyncWS)))
+	mux.HandleFunc("GET /api/v1/sync/events", tokenFromQuery(a.auth(a.handleSyncEvents)))
+
+	// WebDAV; it is not part of the JSON API and so not in the OpenAPI
+	// document. Methods are listed so as not to clash with GET / of the web
+	// UI; GET covers HEAD.
+	for _, method := range davMethods {
+		mux.Handle(method+" "+davPrefix, a.davAuth(a.handleDAV))
+		mux.Handle(method+" "+davPrefix+"/", a.davAuth(a.handleDAV))
+	}
 
-	return logRequests(cors(mux))
+	// Import / export
+	mux.HandleFunc("GET /api/v1/export", a.auth(a.handleExport))
+	mux.HandleFunc("GET /api/v1/export/todos.csv", a.auth(a.handleExportTodosCSV))
+	mux.HandleFunc("POST /api/v1/import", a.auth(a.handleImport))
+	mux.HandleFunc("POST /api/v1/import/todos.csv", a.auth(a.handleImportTodosCSV))
+
+	// Journal
+	mux.HandleFunc("GET /api/v1/journal/settings", a.auth(a.handleGetJournalSettings))
+	mux.HandleFunc("PUT /api/v1/journal/settings", a.auth(a.handleUpdateJournalSettings))
+	mux.HandleFunc("GET /api/v1/journal/{date}", a.auth(a.handleGetJournalEntry))
+	mux.HandleFunc("POST /api/v1/journal/{date}", a.auth(a.handleCreateJournalEntry))
+
+	// Digest
+	mux.HandleFunc("GET /api/v1/digest/settings", a.auth(a.handleGetDigestSettings))
+	mux.HandleFunc("PUT /api/v1/digest/settings", a.auth(a.handleUpdateDigestSettings))
+
+	// Usage
+	mux.HandleFunc("GET /api/v1/usage", a.auth(a.handleGetUsage))
+	mux.HandleFunc("GET /api/v1/stats", a.auth(a.handleGetStatistics))
+	mux.HandleFunc("GET /api/v1/activity", a.auth(a.handleListActivity))
+
+	// Account
+	mux.HandleFunc("GET /api/v1/account/export", a.session(a.handleAccountExport))
+	mux.HandleFunc("GET /api/v1/account/settings", a.auth(a.handleGetAccountSettings))
+	mux.HandleFunc("PUT /api/v1/account/settings", a.auth(a.handleUpdateAccountSettings))
+	mux.HandleFunc("DELETE /api/v1/account", a.authLimiter.rateLimit(a.session(a.handleDeleteAccount)))
+
+	// Admin
+	mux.HandleFunc("GET /api/v1/admin/overview", a.admin(a.handleAdminOverview))
+	mux.HandleFunc("POST /api/v1/admin/backup", a.admin(a.handleAdminBackup))
+	mux.HandleFunc("GET /api/v1/admin/maintenance", a.admin(a.handleAdminMaintenance))
+	mux.HandleFunc("POST /api/v1/admin/maintenance", a.admin(a.handleAdminRunMaintenance))
+	mux.HandleFunc("GET /api/v1/admin/invites", a.admin(a.handleListInvites))
+	mux.HandleFunc("POST /api/v1/admin/invites", a.admin(a.handleCreateInvite))
+	mux.HandleFunc("GET /api/v1/admin/audit", a.admin(a.handleListAuditLog))
+
+	return a.logRequests(a.cors.handler(compress(a.timeouts(mux))))
 }
 
-// CORS middleware for web client cross-origin requests.
-func cors(next http.Handler) http.Handler {
-	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
-		w.Header().Set("Access-Control-Allow-Origin", "*")
-		w.Header().Set("Access-Control-Allow-Methods", "GET, POST, PUT, DELETE, OPTIONS")
-		w.Header().Set("Access-Control-Allow-Headers", "Content-Type, Authorization")
-
-		if r.Method == "OPTIONS" {
-			w.WriteHeader(http.StatusNoContent)
-			return
-		}
-		next.ServeHTTP(w, r)
-	})
+// dbFor returns the database handle for a request, so its statements are
+// traced under the request's span.
+func (a *API) dbFor(r *http.Request) *database.DB {
+	return a.db.WithContext(r.Context())
 }
 
 // Response helpers
@@ -142,11 +485,29 @@ func decodeJSON(r *http.Request, v any) error {
 	return dec.Decode(v)
 }
 
+// handleHealth reports the server as degraded, with 503, once the
+// integrity check of a maintenance run has found the database corrupt.
 func (a *API) handleHealth(w http.ResponseWriter, r *http.Request) {
-	writeJSON(w, http.StatusOK, map[string]any{
-		"status": "ok",
-		"uptime": time.Since(a.startTime).String(),
-	})
+	status, code := "ok", http.StatusOK
+	health := map[string]any{
+		"uptime":  time.Since(a.startTime).String(),
+		"version": a.versionInfo(),
+	}
+	if m := a.lastMaintenance.Load(); m != nil {
+		db := map[string]any{"last_maintenance": m.StartedAt}
+		switch {
+		case m.Error != "":
+			db["integrity"] = "unknown"
+		case m.OK:
+			db["integrity"] = "ok"
+		default:
+			db["integrity"] = "failed"
+			status, code = "degraded", http.StatusServiceUnavailable
+		}
+		health["database"] = db
+	}
+	health["status"] = status
+	writeJSON(w, code, health)
 }
 
 func queryInt(r *http.Request, key string, def int) int {
@@ -160,63 +521,3 @@ func queryInt(r *http.Request, key string, def int) int {
 	}
 	return v
 }
-
-// Request logging middleware
-
-func logRequests(next http.Handler) http.Handler {
-	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
-		start := time.Now()
-		sw := &statusWriter{ResponseWriter: w, status: 200}
-		next.ServeHTTP(sw, r)
-		slog.Info("request",
-			"method", r.Method,
-			"path", r.URL.Path,
-			"status", sw.status,
-			"duration", time.Since(start),
-		)
-	})
-}
-
-type statusWriter struct {
-	http.ResponseWriter
-	status int
-}
-
-func (w *statusWriter) WriteHeader(code int) {
-	w.status = code
-	w.ResponseWriter.WriteHeader(code)
-}
-
-// RSA key management
-
-func loadOrGenerateKey(path string) (*rsa.PrivateKey, error) {
-	data, err := os.ReadFile(path)
-	if err == nil {
-		return parsePrivateKey(data)
-	}
-	if !os.IsNotExist(err) {
-		return nil, fmt.Errorf("read key file: %w", err)
-	}
-
-	slog.Info("generating RSA key pair", "path", path)
-	key, err := rsa.GenerateKey(rand.Reader, 2048)
-	if err != nil {
-		return nil, fmt.Errorf("generate key: %w", err)
-	}
-
-	der := x509.MarshalPKCS1PrivateKey(key)
-	block := &pem.Block{Type: "RSA PRIVATE KEY", Bytes: der}
-	if err := os.WriteFile(path, pem.EncodeToMemory(block), 0600); err != nil {
-		return nil, fmt.Errorf("write key file: %w", err)
-	}
-
-	return key, nil
-}
-
-func parsePrivateKey(data []byte) (*rsa.PrivateKey, error) {
-	block, _ := pem.Decode(data)
-	if block == nil {
-		return nil, fmt.Errorf("no PEM block found")
-	}
-	return x509.ParsePKCS1PrivateKey(block.Bytes)
-}
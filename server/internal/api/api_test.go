@@ -1,20 +1,57 @@
 package api
 
 import (
+	"archive/tar"
+	"archive/zip"
+	"bufio"
 	"bytes"
+	"compress/gzip"
+	"context"
+	"crypto/hmac"
+	"crypto/rand"
+	"crypto/rsa"
+	"crypto/sha256"
+	"crypto/x509"
+	"encoding/base64"
+	"encoding/hex"
 	"encoding/json"
+	"encoding/pem"
+	"encoding/xml"
+	"errors"
 	"fmt"
 	"io"
+	"log/slog"
+	"mime/multipart"
+	"net"
 	"net/http"
 	"net/http/httptest"
+	"net/smtp"
+	"net/url"
 	"os"
+	"os/exec"
+	"path/filepath"
+	"reflect"
+	"regexp"
+	"slices"
+	"strconv"
 	"strings"
+	"sync"
 	"testing"
 	"time"
 
 	"github.com/c0dev0id/notesd/server/internal/config"
 	"github.com/c0dev0id/notesd/server/internal/database"
+	"github.com/c0dev0id/notesd/server/internal/delta"
+	"github.com/c0dev0id/notesd/server/internal/events"
+	"github.com/c0dev0id/notesd/server/internal/frontmatter"
+	"github.com/c0dev0id/notesd/server/internal/githistory"
 	"github.com/c0dev0id/notesd/server/internal/model"
+	"github.com/c0dev0id/notesd/server/internal/pubsub"
+	"github.com/c0dev0id/notesd/server/internal/push"
+	"github.com/c0dev0id/notesd/server/internal/telegram"
+	"github.com/c0dev0id/notesd/server/internal/trace"
+	"github.com/c0dev0id/notesd/server/internal/unfurl"
+	"github.com/golang-jwt/jwt/v5"
 )
 
 // testSetup creates a test API server with an in-memory-like temp database.
@@ -56,6 +93,10 @@ func setup(t *testing.T) *testEnv {
 			AccessTokenExpiry:  "15m",
 			RefreshTokenExpiry: "720h",
 		},
+		Attachments: config.AttachmentsConfig{
+			Dir:     t.TempDir(),
+			MaxSize: 1 << 20,
+		},
 	}
 
 	a, err := New(db, cfg)
@@ -274,6 +315,16 @@ func TestRefreshToken(t *testing.T) {
 		t.Errorf("expected 401 for reused token, got %d", resp.StatusCode)
 	}
 	resp.Body.Close()
+
+	// Reuse revokes the whole family, including the token it was rotated to
+	resp = e.doJSON(t, "POST", "/api/v1/auth/refresh", model.RefreshRequest{
+		RefreshToken: newAuth.RefreshToken,
+	}, "")
+	t.Logf("rotated token after reuse status: %d", resp.StatusCode)
+	if resp.StatusCode != http.StatusUnauthorized {
+		t.Errorf("expected 401 for the family's latest token after reuse, got %d", resp.StatusCode)
+	}
+	resp.Body.Close()
 }
 
 func TestUnauthorizedAccess(t *testing.T) {
@@ -570,6 +621,46 @@ func TestSyncPush(t *testing.T) {
 	resp.Body.Close()
 }
 
+func TestSyncPushTodoMerge(t *testing.T) {
+	e := setup(t)
+	token, _ := e.registerAndLogin(t)
+
+	// Arrange — a todo that another device completes after we copied it
+	var todo model.Todo
+	decodeBody(t, e.doJSON(t, "POST", "/api/v1/todos", model.CreateTodoRequest{
+		Content: "File taxes", DeviceID: "dev1",
+	}, token), &todo)
+	decodeBody(t, e.doJSON(t, "GET", "/api/v1/todos/"+todo.ID, nil, token), &todo)
+	done := true
+	resp := e.doJSON(t, "PUT", "/api/v1/todos/"+todo.ID, model.UpdateTodoRequest{
+		Completed: &done, DeviceID: "dev1",
+	}, token)
+	resp.Body.Close()
+
+	// Act — push a reschedule of the stale copy
+	due := model.NowMillis().Add(72 * time.Hour)
+	later := model.NowMillis().Add(time.Minute)
+	stale := todo
+	stale.DueDate = &due
+	stale.ModifiedAt = later
+	stale.ModifiedByDevice = "dev2"
+	stale.FieldTimes.DueDate = later
+	var pushResp model.SyncPushResponse
+	decodeBody(t, e.doJSON(t, "POST", "/api/v1/sync/push", model.SyncPushRequest{
+		Todos: []model.Todo{stale}, DeviceID: "dev2",
+	}, token), &pushResp)
+
+	// Assert — no conflict; the merged todo keeps both changes
+	t.Logf("push: accepted=%d conflicts=%d merged=%d", pushResp.Accepted, len(pushResp.Conflicts), len(pushResp.Merged))
+	if pushResp.Accepted != 1 || len(pushResp.Conflicts) != 0 || len(pushResp.Merged) != 1 {
+		t.Fatalf("expected one merged todo, got %+v", pushResp)
+	}
+	m := pushResp.Merged[0]
+	if !m.Completed || m.DueDate == nil || !m.DueDate.Equal(due) {
+		t.Errorf("merged todo lost a change: completed=%v due=%v", m.Completed, m.DueDate)
+	}
+}
+
 func TestSyncPushConflict(t *testing.T) {
 	e := setup(t)
 	token, _ := e.registerAndLogin(t)
@@ -815,6 +906,489 @@ func TestLogout(t *testing.T) {
 	resp.Body.Close()
 }
 
+func TestChangePassword(t *testing.T) {
+	e := setup(t)
+
+	// Arrange — one account logged in on two devices
+	e.doJSON(t, "POST", "/api/v1/auth/register", model.RegisterRequest{
+		Email: "passwd@example.com", Password: "oldpassword", DisplayName: "User",
+	}, "").Body.Close()
+	login := func(password, device string) (int, model.AuthResponse) {
+		resp := e.doJSON(t, "POST", "/api/v1/auth/login", model.LoginRequest{
+			Email: "passwd@example.com", Password: password, DeviceID: device,
+		}, "")
+		var auth model.AuthResponse
+		if resp.StatusCode != http.StatusOK {
+			resp.Body.Close()
+			return resp.StatusCode, auth
+		}
+		decodeBody(t, resp, &auth)
+		return resp.StatusCode, auth
+	}
+	_, laptop := login("oldpassword", "laptop")
+	_, phone := login("oldpassword", "phone")
+	var pat model.AccessToken
+	decodeBody(t, e.doJSON(t, "POST", "/api/v1/auth/tokens", model.CreateAccessTokenRequest{Name: "script"}, laptop.AccessToken), &pat)
+
+	// Act — bad requests are rejected
+	for _, c := range []struct {
+		req  model.ChangePasswordRequest
+		want int
+	}{
+		{model.ChangePasswordRequest{CurrentPassword: "wrongpassword", NewPassword: "newpassword"}, http.StatusForbidden},
+		{model.ChangePasswordRequest{CurrentPassword: "oldpassword", NewPassword: "short"}, http.StatusBadRequest},
+		{model.ChangePasswordRequest{NewPassword: "newpassword"}, http.StatusBadRequest},
+	} {
+		resp := e.doJSON(t, "POST", "/api/v1/auth/password", c.req, laptop.AccessToken)
+		resp.Body.Close()
+
+		// Assert
+		t.Logf("change with %+v: %d", c.req, resp.StatusCode)
+		if resp.StatusCode != c.want {
+			t.Errorf("expected %d, got %d", c.want, resp.StatusCode)
+		}
+	}
+
+	// Act — change it from the laptop
+	resp := e.doJSON(t, "POST", "/api/v1/auth/password", model.ChangePasswordRequest{
+		CurrentPassword: "oldpassword", NewPassword: "newpassword",
+	}, laptop.AccessToken)
+	resp.Body.Close()
+
+	// Assert
+	t.Logf("change password status: %d", resp.StatusCode)
+	if resp.StatusCode != http.StatusNoContent {
+		t.Fatalf("expected 204, got %d", resp.StatusCode)
+	}
+
+	for _, c := range []struct {
+		device string
+		token  string
+		want   int
+	}{
+		{"laptop", laptop.RefreshToken, http.StatusOK},
+		{"phone", phone.RefreshToken, http.StatusUnauthorized},
+	} {
+		resp := e.doJSON(t, "POST", "/api/v1/auth/refresh", model.RefreshRequest{RefreshToken: c.token}, "")
+		resp.Body.Close()
+		t.Logf("refresh on %s: %d", c.device, resp.StatusCode)
+		if resp.StatusCode != c.want {
+			t.Errorf("refresh on %s: expected %d, got %d", c.device, c.want, resp.StatusCode)
+		}
+	}
+
+	patResp := e.doJSON(t, "GET", "/api/v1/notes", nil, pat.Token)
+	patResp.Body.Close()
+	t.Logf("personal access token after change: %d", patResp.StatusCode)
+	if patResp.StatusCode != http.StatusUnauthorized {
+		t.Errorf("personal access token: expected 401, got %d", patResp.StatusCode)
+	}
+
+	oldStatus, _ := login("oldpassword", "tablet")
+	newStatus, _ := login("newpassword", "tablet")
+	t.Logf("login with old password: %d, new password: %d", oldStatus, newStatus)
+	if oldStatus != http.StatusUnauthorized || newStatus != http.StatusOK {
+		t.Errorf("expected 401 with the old and 200 with the new password")
+	}
+}
+
+func TestPasswordReset(t *testing.T) {
+	e := setup(t)
+	post := func(path string, body any) int {
+		resp := e.doJSON(t, "POST", path, body, "")
+		resp.Body.Close()
+		return resp.StatusCode
+	}
+
+	// Act — without mail and a reset URL the feature is off
+	status := post("/api/v1/auth/reset-request", model.PasswordResetRequest{Email: "reset@example.com"})
+
+	// Assert
+	t.Logf("reset request while unconfigured: %d", status)
+	if status != http.StatusNotFound {
+		t.Errorf("expected 404, got %d", status)
+	}
+
+	// Arrange — configure mail and log the account in
+	mailer := &fakeMailer{}
+	e.api.mailer = mailer
+	e.api.config.Auth.ResetURL = "https://notes.example.com/reset-password"
+	post("/api/v1/auth/register", model.RegisterRequest{
+		Email: "reset@example.com", Password: "oldpassword", DisplayName: "User",
+	})
+	resp := e.doJSON(t, "POST", "/api/v1/auth/login", model.LoginRequest{
+		Email: "reset@example.com", Password: "oldpassword", DeviceID: "laptop",
+	}, "")
+	var session model.AuthResponse
+	decodeBody(t, resp, &session)
+	var pat model.AccessToken
+	decodeBody(t, e.doJSON(t, "POST", "/api/v1/auth/tokens", model.CreateAccessTokenRequest{Name: "script"}, session.AccessToken), &pat)
+	tokenIn := regexp.MustCompile(`reset-password\?token=([A-Za-z0-9_-]+)`)
+
+	// Act — an unknown address gets the same answer and no mail
+	status = post("/api/v1/auth/reset-request", model.PasswordResetRequest{Email: "nobody@example.com"})
+
+	// Assert
+	t.Logf("reset request for unknown address: %d, mails sent: %d", status, len(mailer.to))
+	if status != http.StatusAccepted || len(mailer.to) != 0 {
+		t.Errorf("expected 202 and no mail, got %d and %d mails", status, len(mailer.to))
+	}
+
+	// Act — two requests; only the second link works
+	post("/api/v1/auth/reset-request", model.PasswordResetRequest{Email: "Reset@Example.com"})
+	status = post("/api/v1/auth/reset-request", model.PasswordResetRequest{Email: "reset@example.com"})
+
+	// Assert
+	t.Logf("reset request: %d, mails sent to %v", status, mailer.to)
+	if status != http.StatusAccepted || len(mailer.to) != 2 || mailer.to[1] != "reset@example.com" {
+		t.Fatalf("expected 202 and two mails to the account, got %d and %v", status, mailer.to)
+	}
+	first, second := tokenIn.FindStringSubmatch(mailer.body[0]), tokenIn.FindStringSubmatch(mailer.body[1])
+	if first == nil || second == nil {
+		t.Fatalf("no reset link in mail:\n%s", mailer.body[1])
+	}
+
+	for _, c := range []struct {
+		name string
+		req  model.PasswordResetConfirmRequest
+		want int
+	}{
+		{"replaced token", model.PasswordResetConfirmRequest{Token: first[1], NewPassword: "newpassword"}, http.StatusBadRequest},
+		{"short password", model.PasswordResetConfirmRequest{Token: second[1], NewPassword: "short"}, http.StatusBadRequest},
+		{"valid", model.PasswordResetConfirmRequest{Token: second[1], NewPassword: "newpassword"}, http.StatusNoContent},
+		{"reused token", model.PasswordResetConfirmRequest{Token: second[1], NewPassword: "otherpassword"}, http.StatusBadRequest},
+	} {
+		// Act
+		status := post("/api/v1/auth/reset-confirm", c.req)
+
+		// Assert
+		t.Logf("confirm with %s: %d", c.name, status)
+		if status != c.want {
+			t.Errorf("confirm with %s: expected %d, got %d", c.name, c.want, status)
+		}
+	}
+
+	refresh := post("/api/v1/auth/refresh", model.RefreshRequest{RefreshToken: session.RefreshToken})
+	oldLogin := post("/api/v1/auth/login", model.LoginRequest{Email: "reset@example.com", Password: "oldpassword", DeviceID: "laptop"})
+	newLogin := post("/api/v1/auth/login", model.LoginRequest{Email: "reset@example.com", Password: "newpassword", DeviceID: "laptop"})
+	patResp := e.doJSON(t, "GET", "/api/v1/notes", nil, pat.Token)
+	patResp.Body.Close()
+	t.Logf("after reset: refresh %d, personal access token %d, old password %d, new password %d",
+		refresh, patResp.StatusCode, oldLogin, newLogin)
+	if refresh != http.StatusUnauthorized || patResp.StatusCode != http.StatusUnauthorized ||
+		oldLogin != http.StatusUnauthorized || newLogin != http.StatusOK {
+		t.Errorf("expected sessions and tokens revoked and only the new password to work")
+	}
+
+	// Act — the mail server fails
+	mailer.err = errors.New("connection refused")
+	status = post("/api/v1/auth/reset-request", model.PasswordResetRequest{Email: "reset@example.com"})
+
+	// Assert: the answer does not give the account away
+	t.Logf("reset request with failing mail: %d", status)
+	if status != http.StatusAccepted {
+		t.Errorf("expected 202 when mail fails, got %d", status)
+	}
+}
+
+func TestAccessTokens(t *testing.T) {
+	e := setup(t)
+	session, user := e.registerAndLogin(t)
+	create := func(req model.CreateAccessTokenRequest) (int, model.AccessToken) {
+		resp := e.doJSON(t, "POST", "/api/v1/auth/tokens", req, session)
+		var tok model.AccessToken
+		if resp.StatusCode != http.StatusCreated {
+			resp.Body.Close()
+			return resp.StatusCode, tok
+		}
+		decodeBody(t, resp, &tok)
+		return resp.StatusCode, tok
+	}
+	status := func(method, path, token string, body any) int {
+		resp := e.doJSON(t, method, path, body, token)
+		resp.Body.Close()
+		return resp.StatusCode
+	}
+	past := time.Now().Add(-time.Hour)
+
+	// Act — invalid requests are rejected
+	for _, req := range []model.CreateAccessTokenRequest{
+		{Name: " "},
+		{Name: "cron", Scope: "admin"},
+		{Name: "cron", ExpiresAt: &past},
+	} {
+		code, _ := create(req)
+
+		// Assert
+		t.Logf("create %+v: %d", req, code)
+		if code != http.StatusBadRequest {
+			t.Errorf("expected 400, got %d", code)
+		}
+	}
+
+	// Arrange — one full and one read-only token
+	code, writeTok := create(model.CreateAccessTokenRequest{Name: "cron"})
+	future := time.Now().Add(24 * time.Hour)
+	_, readTok := create(model.CreateAccessTokenRequest{Name: "dashboard", Scope: "read", ExpiresAt: &future})
+	t.Logf("created %d: %s scope=%s token=%s...", code, writeTok.Name, writeTok.Scope, writeTok.Token[:len(accessTokenPrefix)])
+	if code != http.StatusCreated || writeTok.Scope != "write" || !strings.HasPrefix(writeTok.Token, accessTokenPrefix) {
+		t.Fatalf("unexpected token %+v", writeTok)
+	}
+
+	// Act — what each token may do
+	cases := []struct {
+		name, method, path, token string
+		body                      any
+		want                      int
+	}{
+		{"write token reads", "GET", "/api/v1/notes", writeTok.Token, nil, http.StatusOK},
+		{"write token writes", "POST", "/api/v1/notes", writeTok.Token, model.CreateNoteRequest{Title: "From cron", DeviceID: "cron"}, http.StatusCreated},
+		{"read token reads", "GET", "/api/v1/notes", readTok.Token, nil, http.StatusOK},
+		{"read token writes", "POST", "/api/v1/notes", readTok.Token, model.CreateNoteRequest{Title: "Nope", DeviceID: "cron"}, http.StatusForbidden},
+		{"token lists tokens", "GET", "/api/v1/auth/tokens", writeTok.Token, nil, http.StatusForbidden},
+		{"token logs out", "POST", "/api/v1/auth/logout", writeTok.Token, nil, http.StatusForbidden},
+		{"unknown token", "GET", "/api/v1/notes", accessTokenPrefix + "bogus", nil, http.StatusUnauthorized},
+	}
+	for _, c := range cases {
+		got := status(c.method, c.path, c.token, c.body)
+
+		// Assert
+		t.Logf("%s: %d", c.name, got)
+		if got != c.want {
+			t.Errorf("%s: expected %d, got %d", c.name, c.want, got)
+		}
+	}
+
+	// Act — list with the login session
+	resp := e.doJSON(t, "GET", "/api/v1/auth/tokens", nil, session)
+	var list []model.AccessToken
+	decodeBody(t, resp, &list)
+
+	// Assert
+	t.Logf("listed %d tokens", len(list))
+	if len(list) != 2 || list[0].Name != "dashboard" || list[1].Name != "cron" {
+		t.Fatalf("unexpected list %+v", list)
+	}
+	for _, tok := range list {
+		t.Logf("  %s scope=%s last_used=%v", tok.Name, tok.Scope, tok.LastUsedAt)
+		if tok.Token != "" || tok.LastUsedAt == nil {
+			t.Errorf("listed token %s: secret %q, last used %v", tok.Name, tok.Token, tok.LastUsedAt)
+		}
+	}
+
+	// Act — revoke the write token
+	deleted := status("DELETE", "/api/v1/auth/tokens/"+writeTok.ID, session, nil)
+	again := status("DELETE", "/api/v1/auth/tokens/"+writeTok.ID, session, nil)
+	after := status("GET", "/api/v1/notes", writeTok.Token, nil)
+
+	// Assert
+	t.Logf("delete: %d, delete again: %d, use after delete: %d", deleted, again, after)
+	if deleted != http.StatusNoContent || again != http.StatusNotFound || after != http.StatusUnauthorized {
+		t.Errorf("expected 204, 404 and 401")
+	}
+
+	// Arrange — an expired token
+	expired := model.AccessToken{
+		ID: model.NewID(), UserID: user.ID, Name: "old", Scope: "write",
+		Token: accessTokenPrefix + "expired", ExpiresAt: &past, CreatedAt: past.Add(-time.Hour),
+	}
+	if err := e.db.CreateAccessToken(&expired, database.HashToken(expired.Token)); err != nil {
+		t.Fatalf("CreateAccessToken: %v", err)
+	}
+
+	// Act
+	got := status("GET", "/api/v1/notes", expired.Token, nil)
+
+	// Assert
+	t.Logf("expired token: %d", got)
+	if got != http.StatusUnauthorized {
+		t.Errorf("expected 401 for an expired token, got %d", got)
+	}
+}
+
+func TestSigningKeyRotation(t *testing.T) {
+	e := setup(t)
+	jwks := func() []map[string]string {
+		resp := e.doJSON(t, "GET", "/.well-known/jwks.json", nil, "")
+		var set struct {
+			Keys []map[string]string `json:"keys"`
+		}
+		decodeBody(t, resp, &set)
+		return set.Keys
+	}
+	kidOf := func(token string) string {
+		header, _, _ := strings.Cut(token, ".")
+		raw, _ := base64.RawURLEncoding.DecodeString(header)
+		var h map[string]any
+		json.Unmarshal(raw, &h)
+		kid, _ := h["kid"].(string)
+		return kid
+	}
+	status := func(token string) int {
+		resp := e.doJSON(t, "GET", "/api/v1/notes", nil, token)
+		resp.Body.Close()
+		return resp.StatusCode
+	}
+	oldToken, _ := e.registerAndLogin(t)
+	oldKey := jwks()
+	t.Logf("initial jwks: %v", oldKey)
+	if len(oldKey) != 1 || oldKey[0]["kid"] != kidOf(oldToken) || oldKey[0]["alg"] != "RS256" {
+		t.Fatalf("expected one key matching the token's kid %q", kidOf(oldToken))
+	}
+
+	// Act — rotate once the key is due
+	every, retain := 24*time.Hour, 720*time.Hour
+	now := time.Now().Add(every)
+	changed, err := e.api.keys.rotate(now, algRS256, every, retain)
+
+	// Assert
+	if err != nil || !changed {
+		t.Fatalf("rotate: changed=%v err=%v", changed, err)
+	}
+	newToken, _ := e.registerAndLogin(t)
+	keys := jwks()
+	t.Logf("after rotation: %d keys, new token kid %s, old token %d", len(keys), kidOf(newToken), status(oldToken))
+	if len(keys) != 2 || keys[0]["kid"] != kidOf(newToken) || keys[1]["kid"] != oldKey[0]["kid"] {
+		t.Errorf("expected the new key first and the old one kept, got %v", keys)
+	}
+	if status(oldToken) != http.StatusOK || status(newToken) != http.StatusOK {
+		t.Errorf("tokens signed by either key should verify")
+	}
+
+	// Act — the file holds both keys in order
+	reloaded, err := loadKeyring(e.api.config.Auth.PrivateKeyPath, algRS256)
+
+	// Assert
+	if err != nil {
+		t.Fatalf("loadKeyring: %v", err)
+	}
+	t.Logf("reloaded %d keys, current %s", len(reloaded.all()), reloaded.current().id)
+	if len(reloaded.all()) != 2 || reloaded.current().id != kidOf(newToken) {
+		t.Errorf("key file does not match the keyring")
+	}
+
+	// Act — once the old key's tokens have expired, it is dropped
+	changed, err = e.api.keys.rotate(now.Add(retain), algRS256, 10*retain, retain)
+
+	// Assert
+	t.Logf("prune: changed=%v err=%v, keys=%d, old token %d", changed, err, len(jwks()), status(oldToken))
+	if !changed || len(jwks()) != 1 {
+		t.Errorf("expected the old key to be dropped")
+	}
+	if status(oldToken) != http.StatusUnauthorized || status(newToken) != http.StatusOK {
+		t.Errorf("expected only the new key to verify")
+	}
+}
+
+func TestSwitchToEdDSA(t *testing.T) {
+	// Arrange — a session signed with the RSA key
+	e := setup(t)
+	rsaToken, _ := e.registerAndLogin(t)
+	headerOf := func(token string) map[string]any {
+		header, _, _ := strings.Cut(token, ".")
+		raw, _ := base64.RawURLEncoding.DecodeString(header)
+		var h map[string]any
+		json.Unmarshal(raw, &h)
+		return h
+	}
+	status := func(token string) int {
+		resp := e.doJSON(t, "GET", "/api/v1/notes", nil, token)
+		resp.Body.Close()
+		return resp.StatusCode
+	}
+
+	// Act — what New does when auth.signing_algorithm changes
+	e.api.signingAlg = algEdDSA
+	e.api.rotateKeys(time.Now())
+	edToken, _ := e.registerAndLogin(t)
+
+	// Assert
+	h := headerOf(edToken)
+	t.Logf("new token header: %v; rsa token: %d, eddsa token: %d", h, status(rsaToken), status(edToken))
+	if h["alg"] != "EdDSA" || h["kid"] != e.api.keys.current().id {
+		t.Errorf("expected an EdDSA token with the new key's kid")
+	}
+	if status(rsaToken) != http.StatusOK || status(edToken) != http.StatusOK {
+		t.Errorf("tokens of both algorithms should verify during the migration")
+	}
+
+	resp := e.doJSON(t, "GET", "/.well-known/jwks.json", nil, "")
+	var set struct {
+		Keys []map[string]string `json:"keys"`
+	}
+	decodeBody(t, resp, &set)
+	t.Logf("jwks: %v", set.Keys)
+	if len(set.Keys) != 2 || set.Keys[0]["kty"] != "OKP" || set.Keys[0]["crv"] != "Ed25519" || set.Keys[1]["kty"] != "RSA" {
+		t.Errorf("expected the Ed25519 key first, then the RSA key")
+	}
+
+	// Act — a token claiming the Ed25519 key but signed as RS256 is refused
+	forged := jwt.NewWithClaims(jwt.SigningMethodRS256, jwt.MapClaims{
+		"sub": "x", "type": "access", "exp": time.Now().Add(time.Minute).Unix(),
+	})
+	forged.Header["kid"] = e.api.keys.current().id
+	priv, _ := rsa.GenerateKey(rand.Reader, 2048)
+	forgedToken, _ := forged.SignedString(priv)
+
+	// Assert
+	t.Logf("mismatched alg: %d", status(forgedToken))
+	if status(forgedToken) != http.StatusUnauthorized {
+		t.Errorf("expected 401 for a token whose alg does not match its key")
+	}
+
+	// Act — the key file round-trips both kinds
+	reloaded, err := loadKeyring(e.api.config.Auth.PrivateKeyPath, algEdDSA)
+
+	// Assert
+	if err != nil {
+		t.Fatalf("loadKeyring: %v", err)
+	}
+	keys := reloaded.all()
+	t.Logf("reloaded: %s %s, %s %s", keys[0].alg, keys[0].id, keys[1].alg, keys[1].id)
+	if len(keys) != 2 || keys[0].alg != algEdDSA || keys[0].id != e.api.keys.current().id || keys[1].alg != algRS256 {
+		t.Errorf("key file does not match the keyring")
+	}
+}
+
+func TestLegacySigningKey(t *testing.T) {
+	// Arrange — a key file and a token from before key IDs
+	e := setup(t)
+	_, user := e.registerAndLogin(t)
+	priv, _ := rsa.GenerateKey(rand.Reader, 2048)
+	path := filepath.Join(t.TempDir(), "legacy.key")
+	os.WriteFile(path, pem.EncodeToMemory(&pem.Block{Type: "RSA PRIVATE KEY", Bytes: x509.MarshalPKCS1PrivateKey(priv)}), 0600)
+	legacy, err := jwt.NewWithClaims(jwt.SigningMethodRS256, jwt.MapClaims{
+		"sub": user.ID, "device_id": "old", "type": "access", "exp": time.Now().Add(time.Minute).Unix(),
+	}).SignedString(priv)
+	if err != nil {
+		t.Fatalf("sign: %v", err)
+	}
+
+	// Act
+	keys, err := loadKeyring(path, algRS256)
+
+	// Assert
+	if err != nil {
+		t.Fatalf("loadKeyring: %v", err)
+	}
+	info, _ := os.Stat(path)
+	t.Logf("legacy key %s created %v (file modified %v)", keys.current().id, keys.current().created, info.ModTime())
+	if !keys.current().created.Equal(info.ModTime()) {
+		t.Errorf("legacy key should be dated by the file")
+	}
+
+	// Act — a token without kid verifies against the loaded keys
+	e.api.keys = keys
+	resp := e.doJSON(t, "GET", "/api/v1/notes", nil, legacy)
+	resp.Body.Close()
+
+	// Assert
+	t.Logf("token without kid: %d", resp.StatusCode)
+	if resp.StatusCode != http.StatusOK {
+		t.Errorf("expected 200, got %d", resp.StatusCode)
+	}
+}
+
 func TestRefreshTokenMissing(t *testing.T) {
 	e := setup(t)
 
@@ -881,6 +1455,38 @@ func TestGetNoteNotFound(t *testing.T) {
 	resp.Body.Close()
 }
 
+func TestNoteMetadata(t *testing.T) {
+	// Arrange: a short note, then a longer one edited from a short one
+	e := setup(t)
+	token, _ := e.registerAndLogin(t)
+	short := e.createNote(t, token, "Short", "one two")
+	long := e.createNote(t, token, "Long", "x")
+	content := "# Agenda\n\nDiscuss [[Short]] and https://example.com today."
+	var updated model.Note
+	decodeBody(t, e.doJSON(t, "PUT", "/api/v1/notes/"+long.ID, model.UpdateNoteRequest{Content: &content, DeviceID: "dev1"}, token), &updated)
+
+	// Act
+	var byWords model.NoteListResponse
+	decodeBody(t, e.doJSON(t, "GET", "/api/v1/notes?sort=words", nil, token), &byWords)
+	bad := e.doJSON(t, "GET", "/api/v1/notes?sort=size", nil, token)
+	bad.Body.Close()
+
+	// Assert
+	t.Logf("updated: words=%d minutes=%d heading=%q links=%d", updated.WordCount, updated.ReadingMinutes, updated.FirstHeading, updated.LinkCount)
+	if updated.WordCount != 6 || updated.ReadingMinutes != 1 || updated.FirstHeading != "Agenda" || updated.LinkCount != 2 {
+		t.Errorf("unexpected metadata after update: %+v", updated)
+	}
+	if short.WordCount != 2 {
+		t.Errorf("created note: got %d words, want 2", short.WordCount)
+	}
+	if len(byWords.Notes) != 2 || byWords.Notes[0].ID != short.ID || byWords.Notes[1].WordCount != 6 {
+		t.Errorf("sort=words: got %+v", byWords.Notes)
+	}
+	if bad.StatusCode != http.StatusBadRequest {
+		t.Errorf("unknown sort key: expected 400, got %d", bad.StatusCode)
+	}
+}
+
 func TestUpdateNoteNotFound(t *testing.T) {
 	e := setup(t)
 	token, _ := e.registerAndLogin(t)
@@ -997,6 +1603,58 @@ func TestTodoUserIsolation(t *testing.T) {
 	resp.Body.Close()
 }
 
+func TestSyncManifest(t *testing.T) {
+	e := setup(t)
+	token, _ := e.registerAndLogin(t)
+
+	// Arrange: a note, a live todo and a deleted one
+	note := e.createNote(t, token, "Manifest", "content")
+	var live, gone model.Todo
+	decodeBody(t, e.doJSON(t, "POST", "/api/v1/todos", model.CreateTodoRequest{Content: "live", DeviceID: "dev1"}, token), &live)
+	decodeBody(t, e.doJSON(t, "POST", "/api/v1/todos", model.CreateTodoRequest{Content: "gone", DeviceID: "dev1"}, token), &gone)
+	e.doJSON(t, "DELETE", "/api/v1/todos/"+gone.ID, nil, token).Body.Close()
+
+	// Act
+	var manifest model.SyncManifest
+	decodeBody(t, e.doJSON(t, "GET", "/api/v1/sync/manifest", nil, token), &manifest)
+	var fetched model.SyncFetchResponse
+	decodeBody(t, e.doJSON(t, "POST", "/api/v1/sync/fetch", model.SyncFetchRequest{
+		NoteIDs: []string{note.ID, "missing"}, TodoIDs: []string{gone.ID},
+	}, token), &fetched)
+	tooMany := e.doJSON(t, "POST", "/api/v1/sync/fetch", model.SyncFetchRequest{
+		NoteIDs: make([]string, 501),
+	}, token)
+	tooMany.Body.Close()
+
+	// Assert
+	t.Logf("manifest: %+v", manifest)
+	if len(manifest.Notes) != 1 || len(fetched.Notes) != 1 ||
+		manifest.Notes[0].ContentHash != fetched.Notes[0].ContentHash ||
+		!manifest.Notes[0].ModifiedAt.Equal(note.ModifiedAt) {
+		t.Errorf("expected the note with the hash of its content, got %+v", manifest.Notes)
+	}
+	deleted := map[string]bool{}
+	for _, e := range manifest.Todos {
+		deleted[e.ID] = e.Deleted
+	}
+	if len(manifest.Todos) != 2 || deleted[live.ID] || !deleted[gone.ID] {
+		t.Errorf("expected the live todo and the tombstone, got %+v", manifest.Todos)
+	}
+	if manifest.SyncTimestamp == 0 {
+		t.Error("expected a sync timestamp")
+	}
+	t.Logf("fetched: %d notes, %d todos; too many: %d", len(fetched.Notes), len(fetched.Todos), tooMany.StatusCode)
+	if len(fetched.Notes) != 1 || fetched.Notes[0].Content != "content" {
+		t.Errorf("expected the note in full, got %+v", fetched.Notes)
+	}
+	if len(fetched.Todos) != 1 || fetched.Todos[0].DeletedAt == nil {
+		t.Errorf("expected the todo tombstone, got %+v", fetched.Todos)
+	}
+	if tooMany.StatusCode != http.StatusBadRequest {
+		t.Errorf("501 ids: got %d, want 400", tooMany.StatusCode)
+	}
+}
+
 // --- Sync validation ---
 
 func TestSyncChangesMissingSince(t *testing.T) {
@@ -1041,56 +1699,303 @@ func TestSyncPushEmptyBody(t *testing.T) {
 	}
 }
 
-// --- CORS test ---
-
-func TestCORSPreflight(t *testing.T) {
+func TestSyncPushLarge(t *testing.T) {
 	e := setup(t)
+	token, user := e.registerAndLogin(t)
+	now := model.NowMillis()
 
-	req, _ := http.NewRequest("OPTIONS", e.server.URL+"/api/v1/notes", nil)
-	req.Header.Set("Origin", "http://localhost:5173")
-	req.Header.Set("Access-Control-Request-Method", "POST")
-	resp, err := http.DefaultClient.Do(req)
-	if err != nil {
-		t.Fatalf("preflight request: %v", err)
+	// Arrange — a first sync of several chunks, well over the 1 MB body
+	// limit of other endpoints, with a todo that precedes the note it
+	// belongs to
+	content := strings.Repeat("x", 5000)
+	var notes []model.Note
+	for i := 0; i < 3*pushChunk; i++ {
+		notes = append(notes, model.Note{ID: model.NewID(), UserID: user.ID, Title: fmt.Sprintf("Note %d", i),
+			Content: content, Type: "note", ModifiedAt: now, ModifiedByDevice: "phone", CreatedAt: now})
 	}
-	defer resp.Body.Close()
+	todo := model.Todo{ID: model.NewID(), UserID: user.ID, NoteID: &notes[len(notes)-1].ID,
+		Content: "Attached", ModifiedAt: now, ModifiedByDevice: "phone", CreatedAt: now}
+	todosJSON, _ := json.Marshal([]model.Todo{todo})
+	notesJSON, _ := json.Marshal(notes)
+	body := json.RawMessage(`{"todos":` + string(todosJSON) + `,"notes":` + string(notesJSON) + `,"device_id":"phone"}`)
 
-	t.Logf("CORS preflight status: %d", resp.StatusCode)
-	if resp.StatusCode != http.StatusNoContent {
-		t.Errorf("expected 204, got %d", resp.StatusCode)
-	}
+	// Act
+	resp := e.doJSON(t, "POST", "/api/v1/sync/push", body, token)
 
-	allow := resp.Header.Get("Access-Control-Allow-Origin")
-	t.Logf("Access-Control-Allow-Origin: %s", allow)
-	if allow != "*" {
-		t.Errorf("expected *, got %q", allow)
+	// Assert
+	t.Logf("push of %d bytes: status %d", len(body), resp.StatusCode)
+	if resp.StatusCode != http.StatusOK {
+		b, _ := io.ReadAll(resp.Body)
+		resp.Body.Close()
+		t.Fatalf("expected 200, got %d: %s", resp.StatusCode, b)
+	}
+	var pushResp model.SyncPushResponse
+	decodeBody(t, resp, &pushResp)
+	t.Logf("accepted=%d conflicts=%d", pushResp.Accepted, len(pushResp.Conflicts))
+	if pushResp.Accepted != len(notes)+1 {
+		t.Errorf("expected %d accepted, got %d", len(notes)+1, pushResp.Accepted)
+	}
+	var got model.Todo
+	decodeBody(t, e.doJSON(t, "GET", "/api/v1/todos/"+todo.ID, nil, token), &got)
+	if got.NoteID == nil || *got.NoteID != notes[len(notes)-1].ID {
+		t.Errorf("expected the todo attached to the last note, got %v", got.NoteID)
 	}
 }
 
-// --- Health check test ---
-
-func TestHealthCheck(t *testing.T) {
+func TestSyncPushLimits(t *testing.T) {
 	e := setup(t)
-
-	resp, err := http.Get(e.server.URL + "/api/v1/health")
-	if err != nil {
-		t.Fatalf("health check: %v", err)
+	token, user := e.registerAndLogin(t)
+	now := model.NowMillis()
+	note := func(title, content string) model.Note {
+		return model.Note{ID: model.NewID(), UserID: user.ID, Title: title, Content: content,
+			Type: "note", ModifiedAt: now, ModifiedByDevice: "phone", CreatedAt: now}
 	}
-	defer resp.Body.Close()
-
-	t.Logf("health check status: %d", resp.StatusCode)
-	if resp.StatusCode != http.StatusOK {
-		t.Errorf("expected 200, got %d", resp.StatusCode)
+	long := note("Long", strings.Repeat("x", maxContentLen+1))
+	noID := note("No ID", "ok")
+	noID.ID = ""
+	longID := note("Long ID", "ok")
+	longID.ID = strings.Repeat("a", maxPushIDLen+1)
+
+	cases := []struct {
+		name    string
+		maxSize int64
+		req     model.SyncPushRequest
+		status  int
+		errText string
+	}{
+		{"item too long", 0, model.SyncPushRequest{Notes: []model.Note{note("Fine", "ok"), long}},
+			http.StatusBadRequest, "note " + long.ID + ": content too long"},
+		{"todo too long", 0, model.SyncPushRequest{Todos: []model.Todo{{ID: "t1", Content: strings.Repeat("x", maxTodoContentLen+1),
+			ModifiedAt: now, ModifiedByDevice: "phone", CreatedAt: now}}},
+			http.StatusBadRequest, "todo t1: content too long"},
+		{"empty id", 0, model.SyncPushRequest{Notes: []model.Note{noID}},
+			http.StatusBadRequest, `note "": invalid id`},
+		{"id too long", 0, model.SyncPushRequest{Notes: []model.Note{longID}},
+			http.StatusBadRequest, `note "` + longID.ID + `": invalid id`},
+		{"body too large", 2000, model.SyncPushRequest{Notes: []model.Note{note("Big", strings.Repeat("x", 3000))}},
+			http.StatusRequestEntityTooLarge, "sync push too large (max 2000 bytes)"},
 	}
+	for _, c := range cases {
+		// Arrange
+		e.api.maxPushSize = defaultMaxPushSize
+		if c.maxSize > 0 {
+			e.api.maxPushSize = c.maxSize
+		}
 
-	var health map[string]any
-	if err := json.NewDecoder(resp.Body).Decode(&health); err != nil {
-		t.Fatalf("decode health: %v", err)
+		// Act
+		resp := e.doJSON(t, "POST", "/api/v1/sync/push", c.req, token)
+
+		// Assert
+		var errResp map[string]string
+		decodeBody(t, resp, &errResp)
+		t.Logf("%s: status %d, error %q", c.name, resp.StatusCode, errResp["error"])
+		if resp.StatusCode != c.status || errResp["error"] != c.errText {
+			t.Errorf("%s: expected %d %q", c.name, c.status, c.errText)
+		}
 	}
-	t.Logf("health: %v", health)
+}
+
+// --- CORS test ---
+
+func TestCORSPreflight(t *testing.T) {
+	e := setup(t)
+
+	cases := []struct {
+		origins     []string
+		origin      string
+		allow       string
+		credentials bool
+	}{
+		{nil, "http://localhost:5173", "", false},
+		{[]string{"https://notes.example.com"}, "https://notes.example.com", "https://notes.example.com", true},
+		{[]string{"https://notes.example.com/"}, "https://NOTES.example.com", "https://NOTES.example.com", true},
+		{[]string{"https://notes.example.com"}, "https://evil.example.com", "", false},
+		{[]string{"https://*.example.com"}, "https://a.b.example.com:8443", "https://a.b.example.com:8443", true},
+		{[]string{"https://*.example.com"}, "https://example.com", "", false},
+		{[]string{"https://*.example.com"}, "http://app.example.com", "", false},
+		{[]string{"https://*.example.com"}, "https://evilexample.com", "", false},
+		{[]string{"*", "https://app.example.com"}, "https://other.org", "*", false},
+		{[]string{"*", "https://app.example.com"}, "https://app.example.com", "https://app.example.com", true},
+	}
+	for _, c := range cases {
+		// Arrange
+		p, err := newCORSPolicy(c.origins)
+		if err != nil {
+			t.Fatalf("policy %v: %v", c.origins, err)
+		}
+		*e.api.cors = *p
+		req, _ := http.NewRequest("OPTIONS", e.server.URL+"/api/v1/notes", nil)
+		req.Header.Set("Origin", c.origin)
+		req.Header.Set("Access-Control-Request-Method", "POST")
+
+		// Act
+		resp, err := http.DefaultClient.Do(req)
+		if err != nil {
+			t.Fatalf("preflight request: %v", err)
+		}
+		resp.Body.Close()
+
+		// Assert
+		allow := resp.Header.Get("Access-Control-Allow-Origin")
+		creds := resp.Header.Get("Access-Control-Allow-Credentials") == "true"
+		t.Logf("origins %v, Origin %s: %d allow=%q credentials=%v methods=%q",
+			c.origins, c.origin, resp.StatusCode, allow, creds, resp.Header.Get("Access-Control-Allow-Methods"))
+		if resp.StatusCode != http.StatusNoContent {
+			t.Errorf("expected 204, got %d", resp.StatusCode)
+		}
+		if allow != c.allow || creds != c.credentials {
+			t.Errorf("got allow=%q credentials=%v, want %q %v", allow, creds, c.allow, c.credentials)
+		}
+		if (allow != "") != (resp.Header.Get("Access-Control-Allow-Methods") != "") {
+			t.Errorf("Allow-Methods sent for a refused origin or missing for an allowed one")
+		}
+	}
+}
+
+func TestCORSPassesOtherOptions(t *testing.T) {
+	// Arrange: an access token for WebDAV and a policy allowing every
+	// origin
+	e := setup(t)
+	session, _ := e.registerAndLogin(t)
+	var tok model.AccessToken
+	decodeBody(t, e.doJSON(t, "POST", "/api/v1/auth/tokens", model.CreateAccessTokenRequest{Name: "dav"}, session), &tok)
+	p, _ := newCORSPolicy([]string{"*"})
+	*e.api.cors = *p
+	req, _ := http.NewRequest("OPTIONS", e.server.URL+davPrefix+"/", nil)
+	req.SetBasicAuth("me", tok.Token)
+	req.Header.Set("Origin", "https://other.org")
+
+	// Act
+	resp, err := http.DefaultClient.Do(req)
+	if err != nil {
+		t.Fatalf("OPTIONS: %v", err)
+	}
+	resp.Body.Close()
+
+	// Assert: WebDAV answers, not the CORS preflight
+	t.Logf("OPTIONS %s: %d DAV=%q Allow=%q", davPrefix, resp.StatusCode, resp.Header.Get("DAV"), resp.Header.Get("Allow"))
+	if resp.Header.Get("DAV") == "" || !strings.Contains(resp.Header.Get("Allow"), "PROPFIND") {
+		t.Errorf("expected the DAV and Allow headers of WebDAV, got %v", resp.Header)
+	}
+	if resp.Header.Get("Access-Control-Allow-Origin") != "*" {
+		t.Errorf("expected CORS headers still added, got %q", resp.Header.Get("Access-Control-Allow-Origin"))
+	}
+}
+
+func TestCORSOriginsValidation(t *testing.T) {
+	// Arrange
+	bad := []string{"example.com", "https://", "ftp://example.com", "https://example.com/path", "https://a.*.example.com", "https://*."}
+
+	for _, o := range bad {
+		// Act
+		_, err := newCORSPolicy([]string{o})
+
+		// Assert
+		t.Logf("%q: %v", o, err)
+		if err == nil {
+			t.Errorf("%q accepted", o)
+		}
+	}
+}
+
+// --- Health check test ---
+
+func TestHealthCheck(t *testing.T) {
+	e := setup(t)
+
+	resp, err := http.Get(e.server.URL + "/api/v1/health")
+	if err != nil {
+		t.Fatalf("health check: %v", err)
+	}
+	defer resp.Body.Close()
+
+	t.Logf("health check status: %d", resp.StatusCode)
+	if resp.StatusCode != http.StatusOK {
+		t.Errorf("expected 200, got %d", resp.StatusCode)
+	}
+
+	var health map[string]any
+	if err := json.NewDecoder(resp.Body).Decode(&health); err != nil {
+		t.Fatalf("decode health: %v", err)
+	}
+	t.Logf("health: %v", health)
 	if health["status"] != "ok" {
 		t.Errorf("expected status=ok, got %v", health["status"])
 	}
+	if _, ok := health["version"].(map[string]any); !ok {
+		t.Errorf("expected version object in health, got %v", health["version"])
+	}
+}
+
+func TestVersion(t *testing.T) {
+	e := setup(t)
+
+	// Act: public, no token needed
+	resp := e.doJSON(t, "GET", "/api/v1/version", nil, "")
+
+	// Assert
+	var v model.VersionInfo
+	decodeBody(t, resp, &v)
+	t.Logf("version: %+v", v)
+	if v.Version == "" || v.GoVersion == "" {
+		t.Errorf("expected version and go_version, got %+v", v)
+	}
+	if v.SchemaVersion != database.SchemaVersion {
+		t.Errorf("schema_version: got %d, want %d", v.SchemaVersion, database.SchemaVersion)
+	}
+	if !slices.Contains(v.Capabilities, "tags") || slices.Contains(v.Capabilities, "digest") {
+		t.Errorf("capabilities: got %v, want tags without digest", v.Capabilities)
+	}
+}
+
+func TestNoteTypes(t *testing.T) {
+	e := setup(t)
+	e.api.config.Notes.Types = []string{"journal", "meeting"}
+	token, _ := e.registerAndLogin(t)
+
+	// Act: public, no token needed
+	var types model.NoteTypes
+	decodeBody(t, e.doJSON(t, "GET", "/api/v1/meta/note-types", nil, ""), &types)
+	t.Logf("note types: %v", types.Types)
+
+	// Assert
+	if want := []string{"note", "todo_list", "bookmark", "journal", "meeting"}; !slices.Equal(types.Types, want) {
+		t.Errorf("types: got %v, want %v", types.Types, want)
+	}
+
+	// Act: a configured type is accepted and searchable
+	resp := e.doJSON(t, "POST", "/api/v1/notes", model.CreateNoteRequest{
+		Title: "Standup", Type: "meeting", DeviceID: "dev1",
+	}, token)
+	var note model.Note
+	decodeBody(t, resp, &note)
+
+	// Assert
+	if resp.StatusCode != http.StatusCreated || note.Type != "meeting" {
+		t.Fatalf("create meeting: got %d %+v", resp.StatusCode, note)
+	}
+	var found model.SearchResponse
+	decodeBody(t, e.doJSON(t, "GET", "/api/v1/search?q=type:meeting", nil, token), &found)
+	t.Logf("type:meeting: %d results", found.Total)
+	if found.Total != 1 || found.Results[0].ID != note.ID {
+		t.Errorf("search type:meeting: got %+v", found)
+	}
+
+	// Act: an unknown type is refused with the configured list
+	resp = e.doJSON(t, "POST", "/api/v1/notes", model.CreateNoteRequest{
+		Title: "Dear diary", Type: "diary", DeviceID: "dev1",
+	}, token)
+	var body struct {
+		Error string `json:"error"`
+	}
+	decodeBody(t, resp, &body)
+	t.Logf("diary: %d %q", resp.StatusCode, body.Error)
+
+	// Assert
+	want := "type must be 'note', 'todo_list', 'bookmark', 'journal' or 'meeting'"
+	if resp.StatusCode != http.StatusBadRequest || body.Error != want {
+		t.Errorf("create diary: got %d %q, want 400 %q", resp.StatusCode, body.Error, want)
+	}
 }
 
 // --- Pagination test ---
@@ -1142,3 +2047,5101 @@ func TestNotesListLimitCap(t *testing.T) {
 		t.Errorf("expected limit capped to 200, got %d", listResp.Limit)
 	}
 }
+
+// createNote creates a note via the API and returns it.
+func (e *testEnv) createNote(t *testing.T, token, title, content string) model.Note {
+	t.Helper()
+	resp := e.doJSON(t, "POST", "/api/v1/notes", model.CreateNoteRequest{
+		Title: title, Content: content, Type: "note", DeviceID: "dev1",
+	}, token)
+	if resp.StatusCode != http.StatusCreated {
+		body, _ := io.ReadAll(resp.Body)
+		resp.Body.Close()
+		t.Fatalf("create note: status=%d body=%s", resp.StatusCode, body)
+	}
+	var n model.Note
+	decodeBody(t, resp, &n)
+	return n
+}
+
+// --- Duplicate detection and merge tests ---
+
+func TestFindDuplicates(t *testing.T) {
+	e := setup(t)
+	token, _ := e.registerAndLogin(t)
+
+	// Arrange: a/b share a title, b/c share content modulo whitespace and case,
+	// d is unique.
+	a := e.createNote(t, token, "Meeting", "first")
+	b := e.createNote(t, token, "meeting ", "Agenda:  item one")
+	c := e.createNote(t, token, "Imported", "agenda: item\none")
+	e.createNote(t, token, "Unique", "nothing alike")
+
+	// Act
+	resp := e.doJSON(t, "GET", "/api/v1/notes/duplicates", nil, token)
+
+	// Assert
+	var result model.DuplicatesResponse
+	decodeBody(t, resp, &result)
+	t.Logf("duplicate groups: %d", len(result.Groups))
+	if len(result.Groups) != 1 {
+		t.Fatalf("expected 1 group, got %d", len(result.Groups))
+	}
+	g := result.Groups[0]
+	t.Logf("group reasons=%v size=%d", g.Reasons, len(g.Notes))
+	ids := map[string]bool{}
+	for _, n := range g.Notes {
+		ids[n.ID] = true
+	}
+	if len(g.Notes) != 3 || !ids[a.ID] || !ids[b.ID] || !ids[c.ID] {
+		t.Errorf("expected group of a, b, c; got %v", ids)
+	}
+	if strings.Join(g.Reasons, ",") != "content,title" {
+		t.Errorf("reasons: got %v, want [content title]", g.Reasons)
+	}
+}
+
+func TestMergeNotes(t *testing.T) {
+	e := setup(t)
+	token, _ := e.registerAndLogin(t)
+
+	// Arrange: the source is older than the target and owns a todo.
+	src := e.createNote(t, token, "Trip", "pack bags")
+	time.Sleep(5 * time.Millisecond)
+	dst := e.createNote(t, token, "Trip", "book hotel")
+	resp := e.doJSON(t, "POST", "/api/v1/todos", model.CreateTodoRequest{
+		NoteID: &src.ID, Content: "passport", DeviceID: "dev1",
+	}, token)
+	var todo model.Todo
+	decodeBody(t, resp, &todo)
+
+	// Act
+	resp = e.doJSON(t, "POST", "/api/v1/notes/"+dst.ID+"/merge", model.MergeNotesRequest{
+		SourceIDs: []string{src.ID}, DeviceID: "dev1",
+	}, token)
+
+	// Assert
+	t.Logf("merge status: %d", resp.StatusCode)
+	if resp.StatusCode != http.StatusOK {
+		body, _ := io.ReadAll(resp.Body)
+		resp.Body.Close()
+		t.Fatalf("expected 200, got %d: %s", resp.StatusCode, body)
+	}
+	var merged model.Note
+	decodeBody(t, resp, &merged)
+	t.Logf("merged: content=%q created_at=%v", merged.Content, merged.CreatedAt)
+	if merged.Content != "book hotel\n\npack bags" {
+		t.Errorf("content: got %q", merged.Content)
+	}
+	if !merged.CreatedAt.Equal(src.CreatedAt) {
+		t.Errorf("created_at: got %v, want older %v", merged.CreatedAt, src.CreatedAt)
+	}
+
+	resp = e.doJSON(t, "GET", "/api/v1/notes/"+src.ID, nil, token)
+	resp.Body.Close()
+	if resp.StatusCode != http.StatusNotFound {
+		t.Errorf("source note: expected 404, got %d", resp.StatusCode)
+	}
+
+	resp = e.doJSON(t, "GET", "/api/v1/todos/"+todo.ID, nil, token)
+	var moved model.Todo
+	decodeBody(t, resp, &moved)
+	if moved.NoteID == nil || *moved.NoteID != dst.ID {
+		t.Errorf("todo note_id: got %v, want %s", moved.NoteID, dst.ID)
+	}
+}
+
+func TestMergeNotesKeepsAttachments(t *testing.T) {
+	e := setup(t)
+	token, _ := e.registerAndLogin(t)
+	e.api.tombstoneRetention = 24 * time.Hour
+
+	// Arrange: the source note owns an attachment.
+	src := e.createNote(t, token, "Scan", "")
+	dst := e.createNote(t, token, "Receipts", "")
+	var at model.Attachment
+	decodeBody(t, e.upload(t, token, src.ID, "receipt.txt", []byte("paid")), &at)
+
+	// Act: merge, then collect the source's tombstone
+	resp := e.doJSON(t, "POST", "/api/v1/notes/"+dst.ID+"/merge", model.MergeNotesRequest{
+		SourceIDs: []string{src.ID}, DeviceID: "dev1",
+	}, token)
+	resp.Body.Close()
+	e.api.collectTombstones(time.Now().Add(25 * time.Hour))
+
+	// Assert
+	resp = e.doJSON(t, "GET", "/api/v1/notes/"+dst.ID+"/attachments", nil, token)
+	var list []model.Attachment
+	decodeBody(t, resp, &list)
+	resp = e.doJSON(t, "GET", "/api/v1/attachments/"+at.ID, nil, token)
+	body, _ := io.ReadAll(resp.Body)
+	resp.Body.Close()
+	t.Logf("attachments on target: %d, download: status=%d body=%q", len(list), resp.StatusCode, body)
+	if len(list) != 1 || list[0].ID != at.ID {
+		t.Errorf("expected the attachment on the target, got %+v", list)
+	}
+	if resp.StatusCode != http.StatusOK || string(body) != "paid" {
+		t.Errorf("download after collection: status=%d body=%q", resp.StatusCode, body)
+	}
+}
+
+func TestMergeNotesRejectsSelf(t *testing.T) {
+	e := setup(t)
+	token, _ := e.registerAndLogin(t)
+	n := e.createNote(t, token, "Solo", "x")
+
+	// Act
+	resp := e.doJSON(t, "POST", "/api/v1/notes/"+n.ID+"/merge", model.MergeNotesRequest{
+		SourceIDs: []string{n.ID}, DeviceID: "dev1",
+	}, token)
+	resp.Body.Close()
+
+	// Assert
+	t.Logf("self-merge status: %d", resp.StatusCode)
+	if resp.StatusCode != http.StatusBadRequest {
+		t.Errorf("expected 400, got %d", resp.StatusCode)
+	}
+}
+
+// --- Link graph tests ---
+
+func TestParseWikiLinks(t *testing.T) {
+	// Act
+	got := database.WikiLinks("see [[Alpha]], [[beta|the b note]] and [[alpha]] again; [[ ]] [not a link]")
+
+	// Assert
+	t.Logf("parsed links: %v", got)
+	if strings.Join(got, ",") != "Alpha,beta" {
+		t.Errorf("got %v, want [Alpha beta]", got)
+	}
+}
+
+func TestGraph(t *testing.T) {
+	e := setup(t)
+	token, _ := e.registerAndLogin(t)
+
+	// Arrange: Alpha and Gamma are tagged work
+	var a, c model.Note
+	decodeBody(t, e.doJSON(t, "POST", "/api/v1/notes", model.CreateNoteRequest{
+		Title: "Alpha", Content: "links to [[Beta]] and [[Missing]] and [[alpha]]", Type: "note",
+		Tags: []string{"Work"}, DeviceID: "dev1",
+	}, token), &a)
+	b := e.createNote(t, token, "Beta", "back to [[ALPHA|home]]")
+	decodeBody(t, e.doJSON(t, "POST", "/api/v1/notes", model.CreateNoteRequest{
+		Title: "Gamma", Content: "see [[Alpha]]", Type: "note", Tags: []string{"work"}, DeviceID: "dev1",
+	}, token), &c)
+
+	// Act
+	var g, work model.GraphResponse
+	decodeBody(t, e.doJSON(t, "GET", "/api/v1/graph", nil, token), &g)
+	decodeBody(t, e.doJSON(t, "GET", "/api/v1/graph?tag=work", nil, token), &work)
+
+	// Assert
+	edgesOf := func(g model.GraphResponse) map[string]bool {
+		edges := map[string]bool{}
+		for _, ed := range g.Edges {
+			edges[ed.Source+">"+ed.Target] = true
+		}
+		return edges
+	}
+	t.Logf("graph: %d nodes, %d edges: %+v", len(g.Nodes), len(g.Edges), g.Edges)
+	if len(g.Nodes) != 3 {
+		t.Errorf("nodes: got %d, want 3", len(g.Nodes))
+	}
+	edges := edgesOf(g)
+	if len(g.Edges) != 3 || !edges[a.ID+">"+b.ID] || !edges[b.ID+">"+a.ID] || !edges[c.ID+">"+a.ID] {
+		t.Errorf("expected edges a>b, b>a and c>a, got %v", edges)
+	}
+	t.Logf("work graph: %+v, edges %+v", work.Nodes, work.Edges)
+	edges = edgesOf(work)
+	if len(work.Nodes) != 2 || len(work.Edges) != 1 || !edges[c.ID+">"+a.ID] {
+		t.Errorf("expected the work notes and edge c>a, got %+v", work)
+	}
+}
+
+// --- Tag management tests ---
+
+func TestTagManagement(t *testing.T) {
+	e := setup(t)
+	token, _ := e.registerAndLogin(t)
+
+	// Arrange
+	resp := e.doJSON(t, "POST", "/api/v1/notes", model.CreateNoteRequest{
+		Title: "A", Tags: []string{"work", " Work ", "urgent"}, DeviceID: "dev1",
+	}, token)
+	var note model.Note
+	decodeBody(t, resp, &note)
+	t.Logf("created note tags: %v", note.Tags)
+	if strings.Join(note.Tags, ",") != "urgent,work" {
+		t.Fatalf("note tags: got %v, want [urgent work]", note.Tags)
+	}
+	e.doJSON(t, "POST", "/api/v1/todos", model.CreateTodoRequest{
+		Content: "T", Tags: []string{"work", "job"}, DeviceID: "dev1",
+	}, token).Body.Close()
+
+	listTags := func() map[string]model.TagUsage {
+		resp := e.doJSON(t, "GET", "/api/v1/tags", nil, token)
+		var tags []model.TagUsage
+		decodeBody(t, resp, &tags)
+		t.Logf("tags: %+v", tags)
+		m := map[string]model.TagUsage{}
+		for _, tg := range tags {
+			m[tg.Name] = tg
+		}
+		return m
+	}
+	if tags := listTags(); tags["work"].Notes != 1 || tags["work"].Todos != 1 {
+		t.Errorf("work usage: got %+v, want 1 note, 1 todo", tags["work"])
+	}
+
+	// Act: rename onto an existing tag conflicts
+	resp = e.doJSON(t, "POST", "/api/v1/tags/work/rename", model.RenameTagRequest{Name: "job"}, token)
+	resp.Body.Close()
+	t.Logf("rename onto existing status: %d", resp.StatusCode)
+	if resp.StatusCode != http.StatusConflict {
+		t.Errorf("expected 409, got %d", resp.StatusCode)
+	}
+
+	// Act: merge job into work, rename urgent, delete work
+	resp = e.doJSON(t, "POST", "/api/v1/tags/job/merge", model.MergeTagRequest{Into: "work"}, token)
+	resp.Body.Close()
+	if resp.StatusCode != http.StatusNoContent {
+		t.Fatalf("merge: expected 204, got %d", resp.StatusCode)
+	}
+	// The path name is trimmed like the stored tag names
+	resp = e.doJSON(t, "POST", "/api/v1/tags/%20urgent%20/rename", model.RenameTagRequest{Name: "asap"}, token)
+	resp.Body.Close()
+	if resp.StatusCode != http.StatusNoContent {
+		t.Fatalf("rename: expected 204, got %d", resp.StatusCode)
+	}
+	tags := listTags()
+	if _, ok := tags["job"]; ok || tags["asap"].Notes != 1 || tags["work"].Todos != 1 {
+		t.Errorf("after merge/rename: got %+v", tags)
+	}
+
+	resp = e.doJSON(t, "DELETE", "/api/v1/tags/work", nil, token)
+	resp.Body.Close()
+	if resp.StatusCode != http.StatusNoContent {
+		t.Fatalf("delete: expected 204, got %d", resp.StatusCode)
+	}
+
+	// Assert: the note lost the tag and was bumped for sync
+	resp = e.doJSON(t, "GET", "/api/v1/notes/"+note.ID, nil, token)
+	var got model.Note
+	decodeBody(t, resp, &got)
+	t.Logf("note after delete: tags=%v modified_at=%v", got.Tags, got.ModifiedAt)
+	if strings.Join(got.Tags, ",") != "asap" {
+		t.Errorf("note tags: got %v, want [asap]", got.Tags)
+	}
+	if !got.ModifiedAt.After(note.ModifiedAt) {
+		t.Error("expected modified_at to advance after tag changes")
+	}
+
+	resp = e.doJSON(t, "DELETE", "/api/v1/tags/nope", nil, token)
+	resp.Body.Close()
+	if resp.StatusCode != http.StatusNotFound {
+		t.Errorf("delete missing tag: expected 404, got %d", resp.StatusCode)
+	}
+	resp = e.doJSON(t, "DELETE", "/api/v1/tags/%20", nil, token)
+	resp.Body.Close()
+	t.Logf("delete blank tag status: %d", resp.StatusCode)
+	if resp.StatusCode != http.StatusBadRequest {
+		t.Errorf("delete blank tag: expected 400, got %d", resp.StatusCode)
+	}
+}
+
+func TestTagFiltersAndSync(t *testing.T) {
+	e := setup(t)
+	token, _ := e.registerAndLogin(t)
+
+	// Arrange
+	for _, req := range []model.CreateNoteRequest{
+		{Title: "Standup", Content: "meeting notes", Tags: []string{"work"}, DeviceID: "dev1"},
+		{Title: "Groceries", Content: "meeting at the market", Tags: []string{"home"}, DeviceID: "dev1"},
+	} {
+		e.doJSON(t, "POST", "/api/v1/notes", req, token).Body.Close()
+	}
+	resp := e.doJSON(t, "POST", "/api/v1/todos", model.CreateTodoRequest{
+		Content: "File report", Tags: []string{"Work"}, DeviceID: "dev1",
+	}, token)
+	var todo model.Todo
+	decodeBody(t, resp, &todo)
+	e.doJSON(t, "POST", "/api/v1/todos", model.CreateTodoRequest{Content: "Untagged", DeviceID: "dev1"}, token).Body.Close()
+
+	// Act & Assert: filters on list, search and todos
+	var notes model.NoteListResponse
+	decodeBody(t, e.doJSON(t, "GET", "/api/v1/notes?tag=WORK", nil, token), &notes)
+	t.Logf("notes tagged work: %d", notes.Total)
+	if notes.Total != 1 || notes.Notes[0].Title != "Standup" {
+		t.Errorf("list ?tag=WORK: got %+v", notes)
+	}
+	decodeBody(t, e.doJSON(t, "GET", "/api/v1/notes/search?q=meeting&tag=home", nil, token), &notes)
+	t.Logf("search meeting tagged home: %d", notes.Total)
+	if notes.Total != 1 || notes.Notes[0].Title != "Groceries" {
+		t.Errorf("search ?tag=home: got %+v", notes)
+	}
+	var todos model.TodoListResponse
+	decodeBody(t, e.doJSON(t, "GET", "/api/v1/todos?tag=work", nil, token), &todos)
+	t.Logf("todos tagged work: %d", todos.Total)
+	if todos.Total != 1 || todos.Todos[0].ID != todo.ID {
+		t.Errorf("todos ?tag=work: got %+v", todos)
+	}
+
+	// Act: a newer version pushed through sync replaces the tags
+	todo.Tags = []string{"errands", " errands "}
+	todo.ModifiedAt = todo.ModifiedAt.Add(time.Second)
+	resp = e.doJSON(t, "POST", "/api/v1/sync/push", model.SyncPushRequest{
+		DeviceID: "dev2", Todos: []model.Todo{todo},
+	}, token)
+	resp.Body.Close()
+	if resp.StatusCode != http.StatusOK {
+		t.Fatalf("push: expected 200, got %d", resp.StatusCode)
+	}
+
+	// Assert
+	var got model.Todo
+	decodeBody(t, e.doJSON(t, "GET", "/api/v1/todos/"+todo.ID, nil, token), &got)
+	t.Logf("todo tags after push: %v", got.Tags)
+	if strings.Join(got.Tags, ",") != "errands" {
+		t.Errorf("expected [errands], got %v", got.Tags)
+	}
+}
+
+func TestNoteMetadataFilters(t *testing.T) {
+	e := setup(t)
+	token, _ := e.registerAndLogin(t)
+
+	// Arrange
+	launch := e.createNote(t, token, "Launch", "---\nproject: apollo\nteam: [ops, flight]\n---\nmeeting notes")
+	e.createNote(t, token, "Orbit", "---\nproject: gemini\n---\nmeeting notes")
+	e.createNote(t, token, "Plain", "meeting notes")
+
+	// Act & Assert: metadata is returned alongside the raw content
+	t.Logf("metadata: %v", launch.Metadata)
+	if launch.Metadata["project"] != "apollo" || !strings.HasPrefix(launch.Content, "---\n") {
+		t.Errorf("create: got metadata %v, content %q", launch.Metadata, launch.Content)
+	}
+
+	// Act & Assert: filters on list and search
+	var notes model.NoteListResponse
+	decodeBody(t, e.doJSON(t, "GET", "/api/v1/notes?meta.project=apollo", nil, token), &notes)
+	t.Logf("notes of project apollo: %d", notes.Total)
+	if notes.Total != 1 || notes.Notes[0].ID != launch.ID {
+		t.Errorf("list ?meta.project=apollo: got %+v", notes)
+	}
+	decodeBody(t, e.doJSON(t, "GET", "/api/v1/notes/search?q=meeting&meta.team=flight", nil, token), &notes)
+	t.Logf("search meeting of team flight: %d", notes.Total)
+	if notes.Total != 1 || notes.Notes[0].ID != launch.ID {
+		t.Errorf("search ?meta.team=flight: got %+v", notes)
+	}
+
+	// Act: metadata is derived, not set
+	resp := e.doJSON(t, "PATCH", "/api/v1/notes/"+launch.ID,
+		map[string]any{"metadata": map[string]string{"project": "x"}, "device_id": "dev1"}, token)
+	var body struct {
+		Error string `json:"error"`
+	}
+	decodeBody(t, resp, &body)
+
+	// Assert
+	t.Logf("patch metadata: %d %q", resp.StatusCode, body.Error)
+	if resp.StatusCode != http.StatusBadRequest || body.Error != "metadata: cannot be changed" {
+		t.Errorf("patch metadata: got %d %q", resp.StatusCode, body.Error)
+	}
+}
+
+type fakePages struct {
+	page *unfurl.Page
+	err  error
+	urls []string
+}
+
+func (p *fakePages) Fetch(ctx context.Context, rawURL string, text bool) (*unfurl.Page, error) {
+	p.urls = append(p.urls, rawURL)
+	if p.err != nil {
+		return nil, p.err
+	}
+	page := *p.page
+	if !text {
+		page.Text = ""
+	}
+	return &page, nil
+}
+
+func TestBookmarks(t *testing.T) {
+	e := setup(t)
+	token, _ := e.registerAndLogin(t)
+	pages := &fakePages{page: &unfurl.Page{
+		URL:         "https://example.com/post",
+		Title:       "A post",
+		Description: "What the post is about",
+		Favicon:     "https://example.com/favicon.ico",
+		Text:        "The readable text.",
+	}}
+	e.api.pages = pages
+
+	// Act: save a bookmark with its text archived
+	var note model.Note
+	resp := e.doJSON(t, "POST", "/api/v1/bookmarks", model.CreateBookmarkRequest{
+		URL: "https://example.com/post#intro", Tags: []string{"Reading"}, Archive: true, DeviceID: "dev1",
+	}, token)
+	code := resp.StatusCode
+	decodeBody(t, resp, &note)
+
+	// Assert: the page's card becomes metadata, the text is kept
+	t.Logf("bookmark: %d %q %v\n%s", code, note.Title, note.Metadata, note.Content)
+	if code != http.StatusCreated || note.Type != "bookmark" || note.Title != "A post" {
+		t.Fatalf("create: got %d %+v", code, note)
+	}
+	if note.Metadata["url"] != "https://example.com/post" || note.Metadata["description"] != "What the post is about" ||
+		note.Metadata["favicon"] != "https://example.com/favicon.ico" {
+		t.Errorf("metadata: got %v", note.Metadata)
+	}
+	if !strings.Contains(note.Content, "\nhttps://example.com/post\n\nThe readable text.\n") {
+		t.Errorf("content: got %q", note.Content)
+	}
+
+	// Act: a page that cannot be fetched is saved by its URL
+	pages.err = errors.New("connection refused")
+	var bare model.Note
+	decodeBody(t, e.doJSON(t, "POST", "/api/v1/bookmarks", model.CreateBookmarkRequest{
+		URL: "https://example.org/", DeviceID: "dev1",
+	}, token), &bare)
+
+	// Assert
+	t.Logf("unfetched bookmark: %q %q", bare.Title, bare.Content)
+	if bare.Title != "https://example.org/" || bare.Content != "https://example.org/\n" {
+		t.Errorf("unfetched: got %q %q", bare.Title, bare.Content)
+	}
+
+	// Act: unfurl it once the page can be fetched
+	pages.err = nil
+	var refreshed model.Note
+	resp = e.doJSON(t, "POST", "/api/v1/notes/"+bare.ID+"/unfurl", nil, token)
+	code = resp.StatusCode
+	decodeBody(t, resp, &refreshed)
+
+	// Assert: the title is kept, the card is filled in without text
+	t.Logf("unfurled: %d %q %v", code, refreshed.Title, refreshed.Metadata)
+	if code != http.StatusOK || refreshed.Metadata["title"] != "A post" || strings.Contains(refreshed.Content, "readable text") {
+		t.Errorf("unfurl: got %d %+v", code, refreshed)
+	}
+	if pages.urls[len(pages.urls)-1] != "https://example.org/" {
+		t.Errorf("unfurl fetched %v", pages.urls)
+	}
+
+	// Act & Assert: rejected bookmarks
+	pages.err = fmt.Errorf("dial: %w", unfurl.ErrBlocked)
+	plain := e.createNote(t, token, "Plain", "https://example.com")
+	for _, c := range []struct {
+		method, path string
+		body         any
+		want         int
+	}{
+		{"POST", "/api/v1/bookmarks", model.CreateBookmarkRequest{URL: "file:///etc/passwd", DeviceID: "dev1"}, http.StatusBadRequest},
+		{"POST", "/api/v1/bookmarks", model.CreateBookmarkRequest{URL: "http://10.0.0.1/", DeviceID: "dev1"}, http.StatusBadRequest},
+		{"POST", "/api/v1/notes/" + plain.ID + "/unfurl", nil, http.StatusBadRequest},
+		{"POST", "/api/v1/notes/missing/unfurl", nil, http.StatusNotFound},
+	} {
+		resp := e.doJSON(t, c.method, c.path, c.body, token)
+		resp.Body.Close()
+		t.Logf("%s %s: %d", c.method, c.path, resp.StatusCode)
+		if resp.StatusCode != c.want {
+			t.Errorf("%s %s: got %d, want %d", c.method, c.path, resp.StatusCode, c.want)
+		}
+	}
+}
+
+func TestLinkPreviews(t *testing.T) {
+	e := setup(t)
+	token, _ := e.registerAndLogin(t)
+	pages := &fakePages{page: &unfurl.Page{
+		URL:         "https://example.com/post",
+		Title:       "A post",
+		Description: "What the post is about",
+		Image:       "https://example.com/card.png",
+		Text:        "Never part of a preview.",
+	}}
+	e.api.pages = pages
+	e.api.previews = newPreviewCache(time.Hour) // the default unfurl.cache_ttl
+	preview := func() (*http.Response, model.LinkPreview) {
+		var p model.LinkPreview
+		resp := e.doJSON(t, "GET", "/api/v1/unfurl?url="+url.QueryEscape("https://example.com/old#top"), nil, token)
+		decodeBody(t, resp, &p)
+		return resp, p
+	}
+
+	// Act: preview the same link twice
+	first, p := preview()
+	second, cached := preview()
+
+	// Assert: the page is fetched once, without its text
+	t.Logf("preview: %d %+v, cache-control %q, fetches %v", first.StatusCode, p, first.Header.Get("Cache-Control"), pages.urls)
+	if first.StatusCode != http.StatusOK || p.Title != "A post" || p.Image != "https://example.com/card.png" || p.URL != "https://example.com/post" {
+		t.Fatalf("preview: got %d %+v", first.StatusCode, p)
+	}
+	if second.StatusCode != http.StatusOK || cached != p {
+		t.Errorf("cached preview: got %d %+v", second.StatusCode, cached)
+	}
+	if len(pages.urls) != 1 || pages.urls[0] != "https://example.com/old" {
+		t.Errorf("fetches: got %v, want one of https://example.com/old", pages.urls)
+	}
+	if first.Header.Get("Cache-Control") != "private, max-age=3600" {
+		t.Errorf("cache-control: got %q", first.Header.Get("Cache-Control"))
+	}
+
+	// Act & Assert: refused links, with the configured fetcher for the
+	// deny list
+	e.api.pages = unfurl.New(nil, []string{"example.net"})
+	for _, c := range []struct {
+		url  string
+		want int
+	}{
+		{"", http.StatusBadRequest},
+		{"javascript:alert(1)", http.StatusBadRequest},
+		{"https://www.example.net/", http.StatusForbidden},
+		{"http://127.0.0.1:1/", http.StatusBadRequest},
+	} {
+		resp := e.doJSON(t, "GET", "/api/v1/unfurl?url="+url.QueryEscape(c.url), nil, token)
+		resp.Body.Close()
+		t.Logf("%q: %d", c.url, resp.StatusCode)
+		if resp.StatusCode != c.want {
+			t.Errorf("%q: got %d, want %d", c.url, resp.StatusCode, c.want)
+		}
+	}
+
+	// Act & Assert: previews need a login
+	resp := e.doJSON(t, "GET", "/api/v1/unfurl?url=https://example.com/", nil, "")
+	resp.Body.Close()
+	t.Logf("without token: %d", resp.StatusCode)
+	if resp.StatusCode != http.StatusUnauthorized {
+		t.Errorf("without token: got %d, want 401", resp.StatusCode)
+	}
+}
+
+func TestPreviewCache(t *testing.T) {
+	// Arrange
+	now := time.Now()
+	c := newPreviewCache(time.Minute)
+	c.put("https://example.com/", model.LinkPreview{Title: "Example"}, now)
+
+	// Act
+	fresh, freshOK := c.get("https://example.com/", now.Add(59*time.Second))
+	_, staleOK := c.get("https://example.com/", now.Add(time.Minute))
+	_, offOK := (*previewCache)(nil).get("https://example.com/", now)
+
+	// Assert
+	t.Logf("fresh: %+v %v, stale: %v, off: %v", fresh, freshOK, staleOK, offOK)
+	if !freshOK || fresh.Title != "Example" || staleOK || offOK {
+		t.Errorf("got fresh %+v %v, stale %v, off %v", fresh, freshOK, staleOK, offOK)
+	}
+	for i := range maxCachedPreviews + 10 {
+		c.put("https://example.com/"+strconv.Itoa(i), model.LinkPreview{}, now)
+	}
+	if len(c.entries) > maxCachedPreviews {
+		t.Errorf("cache holds %d previews, more than %d", len(c.entries), maxCachedPreviews)
+	}
+}
+
+func TestSnoozeNote(t *testing.T) {
+	e := setup(t)
+	token, _ := e.registerAndLogin(t)
+
+	// Arrange
+	snoozed := e.createNote(t, token, "Later", "groceries")
+	e.createNote(t, token, "Now", "groceries")
+
+	listTitles := func(path string) []string {
+		resp := e.doJSON(t, "GET", path, nil, token)
+		var list model.NoteListResponse
+		decodeBody(t, resp, &list)
+		var titles []string
+		for _, n := range list.Notes {
+			titles = append(titles, n.Title)
+		}
+		t.Logf("%s -> %v (total %d)", path, titles, list.Total)
+		return titles
+	}
+
+	// Act: a date in the past is rejected
+	resp := e.doJSON(t, "POST", "/api/v1/notes/"+snoozed.ID+"/snooze", model.SnoozeNoteRequest{
+		Until: time.Now().Add(-time.Hour), DeviceID: "dev1",
+	}, token)
+	resp.Body.Close()
+	if resp.StatusCode != http.StatusBadRequest {
+		t.Errorf("past snooze: expected 400, got %d", resp.StatusCode)
+	}
+
+	resp = e.doJSON(t, "POST", "/api/v1/notes/"+snoozed.ID+"/snooze", model.SnoozeNoteRequest{
+		Until: time.Now().Add(24 * time.Hour), DeviceID: "dev1",
+	}, token)
+	var got model.Note
+	decodeBody(t, resp, &got)
+
+	// Assert: hidden from default list and search, shown with ?snoozed=true
+	if got.SnoozedUntil == nil {
+		t.Fatal("expected snoozed_until to be set")
+	}
+	if titles := listTitles("/api/v1/notes"); len(titles) != 1 || titles[0] != "Now" {
+		t.Errorf("default list: got %v, want [Now]", titles)
+	}
+	if titles := listTitles("/api/v1/notes/search?q=groceries"); len(titles) != 1 || titles[0] != "Now" {
+		t.Errorf("default search: got %v, want [Now]", titles)
+	}
+	if titles := listTitles("/api/v1/notes?snoozed=true"); len(titles) != 1 || titles[0] != "Later" {
+		t.Errorf("snoozed list: got %v, want [Later]", titles)
+	}
+
+	// Act: unsnooze brings it back
+	resp = e.doJSON(t, "DELETE", "/api/v1/notes/"+snoozed.ID+"/snooze", nil, token)
+	var woken model.Note
+	decodeBody(t, resp, &woken)
+	if woken.SnoozedUntil != nil {
+		t.Errorf("expected snoozed_until cleared, got %v", woken.SnoozedUntil)
+	}
+	if titles := listTitles("/api/v1/notes"); len(titles) != 2 {
+		t.Errorf("after unsnooze: got %v, want both notes", titles)
+	}
+}
+
+func TestTodoCalendar(t *testing.T) {
+	e := setup(t)
+	token, _ := e.registerAndLogin(t)
+
+	// Arrange: two todos on the 3rd, one on the 5th, one outside the window
+	due := func(day, hour int) *time.Time {
+		d := time.Date(2026, 3, day, hour, 0, 0, 0, time.UTC)
+		return &d
+	}
+	for _, td := range []struct {
+		content string
+		due     *time.Time
+	}{
+		{"late", due(3, 18)}, {"early", due(3, 9)}, {"fifth", due(5, 12)}, {"outside", due(9, 12)},
+	} {
+		e.doJSON(t, "POST", "/api/v1/todos", model.CreateTodoRequest{
+			Content: td.content, DueDate: td.due, DeviceID: "dev1",
+		}, token).Body.Close()
+	}
+
+	// Act
+	resp := e.doJSON(t, "GET", "/api/v1/todos/calendar?from=2026-03-01&to=2026-03-07", nil, token)
+
+	// Assert
+	var cal model.CalendarResponse
+	decodeBody(t, resp, &cal)
+	t.Logf("calendar %s..%s: %d days", cal.From, cal.To, len(cal.Days))
+	if len(cal.Days) != 2 {
+		t.Fatalf("expected 2 days, got %+v", cal.Days)
+	}
+	if cal.Days[0].Date != "2026-03-03" || len(cal.Days[0].Todos) != 2 || cal.Days[0].Todos[0].Content != "early" {
+		t.Errorf("first day: got %+v", cal.Days[0])
+	}
+	if cal.Days[1].Date != "2026-03-05" || len(cal.Days[1].Todos) != 1 {
+		t.Errorf("second day: got %+v", cal.Days[1])
+	}
+
+	resp = e.doJSON(t, "GET", "/api/v1/todos/calendar?from=2026-03-07&to=2026-03-01", nil, token)
+	resp.Body.Close()
+	if resp.StatusCode != http.StatusBadRequest {
+		t.Errorf("reversed window: expected 400, got %d", resp.StatusCode)
+	}
+}
+
+func TestSyncConflictsListedUntilResolved(t *testing.T) {
+	e := setup(t)
+	token, _ := e.registerAndLogin(t)
+
+	// Arrange: the server holds a newer version than the one pushed
+	note := e.createNote(t, token, "Server", "newer")
+	stale := note
+	stale.Content = "stale"
+	stale.ModifiedAt = note.ModifiedAt.Add(-time.Hour)
+	stale.ModifiedByDevice = "laptop"
+	e.doJSON(t, "POST", "/api/v1/sync/push", model.SyncPushRequest{
+		Notes: []model.Note{stale}, DeviceID: "laptop",
+	}, token).Body.Close()
+
+	listConflicts := func() []model.ConflictRecord {
+		resp := e.doJSON(t, "GET", "/api/v1/sync/conflicts", nil, token)
+		var conflicts []model.ConflictRecord
+		decodeBody(t, resp, &conflicts)
+		t.Logf("conflicts: %+v", conflicts)
+		return conflicts
+	}
+
+	// Act / Assert: recorded against the losing device
+	conflicts := listConflicts()
+	if len(conflicts) != 1 || conflicts[0].ItemID != note.ID || conflicts[0].DeviceID != "laptop" {
+		t.Fatalf("expected one conflict for %s from laptop, got %+v", note.ID, conflicts)
+	}
+
+	// Act / Assert: a later edit resolves it
+	time.Sleep(2 * time.Millisecond)
+	content := "reconciled"
+	e.doJSON(t, "PUT", "/api/v1/notes/"+note.ID, model.UpdateNoteRequest{
+		Content: &content, DeviceID: "laptop",
+	}, token).Body.Close()
+	if conflicts := listConflicts(); len(conflicts) != 0 {
+		t.Errorf("expected conflict resolved after edit, got %+v", conflicts)
+	}
+}
+
+type fakeMailer struct {
+	to, subject, body []string
+	err               error // returned by Send when set
+}
+
+func (m *fakeMailer) Send(to, subject, body string) error {
+	m.to = append(m.to, to)
+	m.subject = append(m.subject, subject)
+	m.body = append(m.body, body)
+	return m.err
+}
+
+func TestLastDigestSlot(t *testing.T) {
+	berlin, err := time.LoadLocation("Europe/Berlin")
+	if err != nil {
+		t.Skipf("no tzdata: %v", err)
+	}
+	monday8 := model.DigestSettings{Weekday: int(time.Monday), Hour: 8}
+
+	tests := []struct {
+		name string
+		now  time.Time
+		want time.Time
+	}{
+		{"just after slot", time.Date(2026, 3, 2, 8, 30, 0, 0, berlin), time.Date(2026, 3, 2, 8, 0, 0, 0, berlin)},
+		{"just before slot", time.Date(2026, 3, 2, 7, 59, 0, 0, berlin), time.Date(2026, 2, 23, 8, 0, 0, 0, berlin)},
+		{"midweek", time.Date(2026, 3, 5, 12, 0, 0, 0, berlin), time.Date(2026, 3, 2, 8, 0, 0, 0, berlin)},
+	}
+	for _, tt := range tests {
+		got := lastDigestSlot(monday8, berlin, tt.now.UTC())
+		t.Logf("%s: now=%v slot=%v", tt.name, tt.now, got)
+		if !got.Equal(tt.want) {
+			t.Errorf("%s: got %v, want %v", tt.name, got, tt.want)
+		}
+	}
+
+	daily8 := model.DigestSettings{Frequency: "daily", Weekday: int(time.Monday), Hour: 8}
+	for now, want := range map[time.Time]time.Time{
+		time.Date(2026, 3, 5, 12, 0, 0, 0, berlin): time.Date(2026, 3, 5, 8, 0, 0, 0, berlin),
+		time.Date(2026, 3, 5, 7, 0, 0, 0, berlin):  time.Date(2026, 3, 4, 8, 0, 0, 0, berlin),
+	} {
+		got := lastDigestSlot(daily8, berlin, now.UTC())
+		t.Logf("daily: now=%v slot=%v", now, got)
+		if !got.Equal(want) {
+			t.Errorf("daily at %v: got %v, want %v", now, got, want)
+		}
+	}
+}
+
+func TestWeeklyDigest(t *testing.T) {
+	e := setup(t)
+	token, user := e.registerAndLogin(t)
+
+	// Arrange: enabling without mail configured is refused
+	settings := model.DigestSettings{Enabled: true, Weekday: int(time.Monday), Hour: 8}
+	resp := e.doJSON(t, "PUT", "/api/v1/digest/settings", settings, token)
+	resp.Body.Close()
+	t.Logf("enable without mailer: %d", resp.StatusCode)
+	if resp.StatusCode != http.StatusBadRequest {
+		t.Errorf("expected 400 without mailer, got %d", resp.StatusCode)
+	}
+
+	mailer := &fakeMailer{}
+	e.api.mailer = mailer
+	resp = e.doJSON(t, "PUT", "/api/v1/digest/settings", settings, token)
+	resp.Body.Close()
+	if resp.StatusCode != http.StatusOK {
+		t.Fatalf("enable digest: expected 200, got %d", resp.StatusCode)
+	}
+
+	past := time.Now().Add(-48 * time.Hour)
+	e.doJSON(t, "POST", "/api/v1/todos", model.CreateTodoRequest{
+		Content: "file taxes", DueDate: &past, DeviceID: "dev1",
+	}, token).Body.Close()
+
+	// Act: nothing is due before the next slot, then one digest after it
+	now := time.Now()
+	e.api.sendDueDigests(now)
+	sentBefore := len(mailer.to)
+	e.api.sendDueDigests(now.Add(8 * 24 * time.Hour))
+	e.api.sendDueDigests(now.Add(8*24*time.Hour + time.Minute))
+
+	// Assert
+	t.Logf("sent before slot=%d, total=%d", sentBefore, len(mailer.to))
+	if sentBefore != 0 {
+		t.Errorf("expected no digest before the first slot, got %d", sentBefore)
+	}
+	if len(mailer.to) != 1 {
+		t.Fatalf("expected exactly 1 digest, got %d", len(mailer.to))
+	}
+	t.Logf("digest body:\n%s", mailer.body[0])
+	if mailer.to[0] != user.Email {
+		t.Errorf("recipient: got %q, want %q", mailer.to[0], user.Email)
+	}
+	if !strings.Contains(mailer.body[0], "file taxes") {
+		t.Error("digest should list the overdue todo")
+	}
+}
+
+func TestDailyDigest(t *testing.T) {
+	// Arrange: a daily digest at 08:00 UTC, sent at 10:00 two days on
+	e := setup(t)
+	token, _ := e.registerAndLogin(t)
+	mailer := &fakeMailer{}
+	e.api.mailer = mailer
+	settings := model.DigestSettings{Enabled: true, Frequency: "daily", Hour: 8}
+	resp := e.doJSON(t, "PUT", "/api/v1/digest/settings", settings, token)
+	var saved model.DigestSettings
+	decodeBody(t, resp, &saved)
+	day := time.Now().UTC().Truncate(24 * time.Hour).Add(48 * time.Hour)
+	sendAt := day.Add(10 * time.Hour)
+	for content, due := range map[string]time.Time{
+		"overdue":   day.Add(9 * time.Hour),
+		"today":     day.Add(15 * time.Hour),
+		"all day":   day,
+		"next week": day.Add(72 * time.Hour),
+	} {
+		e.doJSON(t, "POST", "/api/v1/todos", model.CreateTodoRequest{
+			Content: content, DueDate: &due, DeviceID: "dev1",
+		}, token).Body.Close()
+	}
+
+	// Act
+	e.api.sendDueDigests(sendAt)
+	e.api.sendDueDigests(sendAt.Add(time.Hour))
+
+	// Assert
+	t.Logf("saved: %+v, sent %d", saved, len(mailer.to))
+	if saved.Frequency != "daily" || len(mailer.to) != 1 {
+		t.Fatalf("expected one daily digest, got %d", len(mailer.to))
+	}
+	body := mailer.body[0]
+	t.Logf("subject: %s, body:\n%s", mailer.subject[0], body)
+	if !strings.Contains(mailer.subject[0], "daily") {
+		t.Errorf("subject %q should name the daily digest", mailer.subject[0])
+	}
+	if !strings.Contains(body, "Overdue todos (1)\n  - overdue") ||
+		!strings.Contains(body, "Due today (2)\n  - all day (due today)\n  - today (due 15:00)") {
+		t.Error("digest should list the overdue todo and the two due today")
+	}
+	if strings.Contains(body, "next week") {
+		t.Error("a daily digest should not list todos due after today")
+	}
+
+	// Act: an unknown frequency is refused
+	settings.Frequency = "hourly"
+	resp = e.doJSON(t, "PUT", "/api/v1/digest/settings", settings, token)
+	resp.Body.Close()
+
+	// Assert
+	if resp.StatusCode != http.StatusBadRequest {
+		t.Errorf("frequency hourly: expected 400, got %d", resp.StatusCode)
+	}
+}
+
+func TestAdminOverview(t *testing.T) {
+	e := setup(t)
+	token, user := e.registerAndLogin(t)
+	e.createNote(t, token, "Hello", "world")
+	e.doJSON(t, "GET", "/api/v1/sync/changes?since=0", nil, token).Body.Close()
+
+	// Act / Assert: non-admins are refused
+	resp := e.doJSON(t, "GET", "/api/v1/admin/overview", nil, token)
+	resp.Body.Close()
+	t.Logf("non-admin status: %d", resp.StatusCode)
+	if resp.StatusCode != http.StatusForbidden {
+		t.Fatalf("expected 403 for non-admin, got %d", resp.StatusCode)
+	}
+
+	// Arrange
+	e.api.config.Admin.Emails = []string{strings.ToUpper(user.Email)}
+
+	// Act
+	resp = e.doJSON(t, "GET", "/api/v1/admin/overview?days=7", nil, token)
+
+	// Assert
+	var ov model.AdminOverview
+	decodeBody(t, resp, &ov)
+	t.Logf("overview: %+v", ov)
+	if len(ov.Users) != 1 {
+		t.Fatalf("expected 1 user, got %d", len(ov.Users))
+	}
+	u := ov.Users[0]
+	if u.Notes != 1 || u.StorageBytes != int64(len("Hello")+len("world")) || u.ActiveDevices != 1 {
+		t.Errorf("user stats: got %+v", u)
+	}
+	if len(ov.SyncTraffic) != 1 || ov.SyncTraffic[0].Pulls != 1 || ov.SyncTraffic[0].ItemsPulled != 1 {
+		t.Errorf("sync traffic: got %+v", ov.SyncTraffic)
+	}
+	if len(ov.Registrations) != 1 || ov.Registrations[0].Count != 1 {
+		t.Errorf("registrations: got %+v", ov.Registrations)
+	}
+}
+
+func TestTodosCSVRoundTrip(t *testing.T) {
+	e := setup(t)
+	token, _ := e.registerAndLogin(t)
+	e.createNote(t, token, "Groceries", "")
+
+	postCSV := func(body string) *http.Response {
+		req, _ := http.NewRequest("POST", e.server.URL+"/api/v1/import/todos.csv", strings.NewReader(body))
+		req.Header.Set("Content-Type", "text/csv")
+		req.Header.Set("Authorization", "Bearer "+token)
+		resp, err := http.DefaultClient.Do(req)
+		if err != nil {
+			t.Fatalf("post csv: %v", err)
+		}
+		return resp
+	}
+
+	// Act: a bad row rejects the whole file
+	resp := postCSV("content,completed\nok,false\nbad,maybe\n")
+	body, _ := io.ReadAll(resp.Body)
+	resp.Body.Close()
+	t.Logf("bad import: %d %s", resp.StatusCode, body)
+	if resp.StatusCode != http.StatusBadRequest || !strings.Contains(string(body), "line 3") {
+		t.Errorf("expected 400 naming line 3, got %d %s", resp.StatusCode, body)
+	}
+
+	// Act: columns in any order, extra columns ignored
+	resp = postCSV("Note Title,Content,Due,Completed,Tags,Extra\n" +
+		"groceries,Buy milk,2026-03-05,no,\"home, errands\",x\n" +
+		",Call bank,2026-03-06T09:30:00Z,yes,,\n")
+	var result model.ImportResult
+	decodeBody(t, resp, &result)
+	t.Logf("imported %d", result.Imported)
+	if result.Imported != 2 {
+		t.Fatalf("expected 2 imported, got %d", result.Imported)
+	}
+
+	req, _ := http.NewRequest("GET", e.server.URL+"/api/v1/export/todos.csv", nil)
+	req.Header.Set("Authorization", "Bearer "+token)
+	resp, err := http.DefaultClient.Do(req)
+	if err != nil {
+		t.Fatalf("export: %v", err)
+	}
+	out, _ := io.ReadAll(resp.Body)
+	resp.Body.Close()
+
+	// Assert
+	t.Logf("export:\n%s", out)
+	want := "content,due,completed,priority,tags,note title\n" +
+		"Buy milk,2026-03-05,false,,\"errands,home\",Groceries\n" +
+		"Call bank,2026-03-06T09:30:00Z,true,,,\n"
+	if string(out) != want {
+		t.Errorf("export mismatch:\ngot:\n%s\nwant:\n%s", out, want)
+	}
+}
+
+func TestClientIP(t *testing.T) {
+	al, err := newAccessLogger(config.ServerConfig{TrustedProxies: []string{"10.0.0.0/8", "192.0.2.1"}}, config.LogConfig{AccessLog: "off"})
+	if err != nil {
+		t.Fatalf("newAccessLogger: %v", err)
+	}
+
+	cases := []struct {
+		name   string
+		remote string
+		xff    string
+		xff2   string // a second X-Forwarded-For line
+		realIP string
+		unix   bool
+		want   string
+	}{
+		{name: "direct client", remote: "203.0.113.5:4000", want: "203.0.113.5"},
+		{name: "untrusted peer ignores header", remote: "203.0.113.5:4000", xff: "1.2.3.4", want: "203.0.113.5"},
+		{name: "trusted proxy", remote: "192.0.2.1:80", xff: "198.51.100.7", want: "198.51.100.7"},
+		{name: "spoofed leftmost hop", remote: "10.1.1.1:80", xff: "1.2.3.4, 198.51.100.7, 10.2.2.2", want: "198.51.100.7"},
+		{name: "proxy adds a header line", remote: "10.1.1.1:80", xff: "1.2.3.4", xff2: "198.51.100.7", want: "198.51.100.7"},
+		{name: "x-real-ip", remote: "10.1.1.1:80", realIP: "198.51.100.8", want: "198.51.100.8"},
+		{name: "garbage header", remote: "10.1.1.1:80", xff: "nonsense", want: "10.1.1.1"},
+		{name: "unix socket proxy", remote: "@", xff: "198.51.100.9", unix: true, want: "198.51.100.9"},
+		{name: "unix socket without header", remote: "@", unix: true, want: "@"},
+	}
+	for _, tc := range cases {
+		t.Run(tc.name, func(t *testing.T) {
+			r := httptest.NewRequest("GET", "/", nil)
+			r.RemoteAddr = tc.remote
+			if tc.unix {
+				r = r.WithContext(context.WithValue(r.Context(), http.LocalAddrContextKey, &net.UnixAddr{Name: "notesd.sock", Net: "unix"}))
+			}
+			if tc.xff != "" {
+				r.Header.Set("X-Forwarded-For", tc.xff)
+			}
+			if tc.xff2 != "" {
+				r.Header.Add("X-Forwarded-For", tc.xff2)
+			}
+			if tc.realIP != "" {
+				r.Header.Set("X-Real-IP", tc.realIP)
+			}
+			got := al.clientIP(r)
+			t.Logf("remote=%s xff=%q real=%q -> %s", tc.remote, r.Header.Values("X-Forwarded-For"), tc.realIP, got)
+			if got != tc.want {
+				t.Errorf("got %s, want %s", got, tc.want)
+			}
+		})
+	}
+}
+
+func TestAccessLog(t *testing.T) {
+	// Arrange
+	e := setup(t)
+	token, user := e.registerAndLogin(t)
+	var buf bytes.Buffer
+	e.api.accessLog.log = slog.New(slog.NewTextHandler(&buf, nil))
+	e.api.accessLog.fields = map[string]bool{"status": true, "size": true, "user_id": true, "request_id": true}
+
+	// Act
+	req, _ := http.NewRequest("GET", e.server.URL+"/api/v1/notes", nil)
+	req.Header.Set("Authorization", "Bearer "+token)
+	req.Header.Set("X-Request-ID", "trace-123")
+	resp, err := http.DefaultClient.Do(req)
+	if err != nil {
+		t.Fatalf("request: %v", err)
+	}
+	body, _ := io.ReadAll(resp.Body)
+	resp.Body.Close()
+
+	// Assert
+	line := buf.String()
+	t.Logf("access log: %s", line)
+	if got := resp.Header.Get("X-Request-ID"); got != "trace-123" {
+		t.Errorf("expected request ID echoed, got %q", got)
+	}
+	for _, want := range []string{
+		"status=200",
+		fmt.Sprintf("size=%d", len(body)),
+		"user_id=" + user.ID,
+		"request_id=trace-123",
+	} {
+		if !strings.Contains(line, want) {
+			t.Errorf("access log missing %q", want)
+		}
+	}
+	if strings.Contains(line, "method=") || strings.Contains(line, "path=") {
+		t.Error("access log contains fields that were not selected")
+	}
+}
+
+func TestAccessLogJSON(t *testing.T) {
+	// Arrange
+	e := setup(t)
+	token, user := e.registerAndLogin(t)
+	var buf bytes.Buffer
+	e.api.accessLog.log = slog.New(config.LogConfig{Format: "json", Level: "info"}.Handler(&buf))
+
+	// Act
+	req, _ := http.NewRequest("GET", e.server.URL+"/api/v1/notes", nil)
+	req.Header.Set("Authorization", "Bearer "+token)
+	resp, err := http.DefaultClient.Do(req)
+	if err != nil {
+		t.Fatalf("request: %v", err)
+	}
+	resp.Body.Close()
+
+	// Assert
+	t.Logf("access log: %s", buf.String())
+	var line map[string]any
+	if err := json.Unmarshal(buf.Bytes(), &line); err != nil {
+		t.Fatalf("expected one JSON object per line: %v", err)
+	}
+	if line["msg"] != "request" || line["user_id"] != user.ID ||
+		line["request_id"] != resp.Header.Get("X-Request-ID") || line["status"] != float64(200) {
+		t.Errorf("unexpected access line %v", line)
+	}
+}
+
+func TestRequestTimeouts(t *testing.T) {
+	// Arrange: routes slower than the default timeout, one of them a long
+	// route with a timeout of its own
+	e := setup(t)
+	e.api.requestTimeout = 50 * time.Millisecond
+	e.api.longRequestTimeout = time.Second
+	e.api.slowRequest = 100 * time.Millisecond
+	var logBuf bytes.Buffer
+	defer slog.SetDefault(slog.Default())
+	slog.SetDefault(slog.New(slog.NewTextHandler(&logBuf, nil)))
+
+	mux := http.NewServeMux()
+	mux.HandleFunc("GET /slow", func(w http.ResponseWriter, r *http.Request) {
+		<-r.Context().Done()
+		time.Sleep(20 * time.Millisecond)
+		writeJSON(w, http.StatusOK, "late")
+	})
+	mux.HandleFunc("GET /started", func(w http.ResponseWriter, r *http.Request) {
+		w.WriteHeader(http.StatusOK)
+		w.(http.Flusher).Flush()
+		time.Sleep(100 * time.Millisecond)
+		io.WriteString(w, "done")
+	})
+	mux.HandleFunc("POST /api/v1/sync/push", func(w http.ResponseWriter, r *http.Request) {
+		time.Sleep(150 * time.Millisecond)
+		io.WriteString(w, "pushed")
+	})
+	srv := httptest.NewServer(e.api.logRequests(e.api.timeouts(mux)))
+	defer srv.Close()
+
+	cases := []struct {
+		method, path string
+		status       int
+		body         string
+	}{
+		{"GET", "/slow", http.StatusServiceUnavailable, `{"error":"request timed out"}` + "\n"},
+		{"GET", "/started", http.StatusOK, "done"},
+		{"POST", "/api/v1/sync/push", http.StatusOK, "pushed"},
+	}
+	for _, c := range cases {
+		// Act
+		req, _ := http.NewRequest(c.method, srv.URL+c.path, nil)
+		resp, err := http.DefaultClient.Do(req)
+		if err != nil {
+			t.Fatalf("%s: %v", c.path, err)
+		}
+		body, _ := io.ReadAll(resp.Body)
+		resp.Body.Close()
+
+		// Assert
+		t.Logf("%s %s: %d %q", c.method, c.path, resp.StatusCode, body)
+		if resp.StatusCode != c.status || string(body) != c.body {
+			t.Errorf("%s: expected %d %q", c.path, c.status, c.body)
+		}
+	}
+	logged := logBuf.String()
+	t.Logf("log: %s", logged)
+	if !strings.Contains(logged, `msg="slow request" route="POST /api/v1/sync/push"`) ||
+		!strings.Contains(logged, "db_time=") {
+		t.Error("expected the slow push to be logged with its route and database time")
+	}
+	if strings.Contains(logged, `route="GET /slow"`) {
+		t.Error("expected requests under log.slow_request not to be logged")
+	}
+}
+
+// dialWS performs a WebSocket handshake against the test server and returns
+// the raw connection positioned after the 101 response.
+func (e *testEnv) dialWS(t *testing.T, path string) (net.Conn, *bufio.Reader) {
+	t.Helper()
+	conn, err := net.Dial("tcp", strings.TrimPrefix(e.server.URL, "http://"))
+	if err != nil {
+		t.Fatalf("dial: %v", err)
+	}
+	t.Cleanup(func() { conn.Close() })
+	fmt.Fprintf(conn, "GET %s HTTP/1.1\r\nHost: test\r\nConnection: Upgrade\r\nUpgrade: websocket\r\n"+
+		"Sec-WebSocket-Version: 13\r\nSec-WebSocket-Key: dGhlIHNhbXBsZSBub25jZQ==\r\n\r\n", path)
+
+	br := bufio.NewReader(conn)
+	resp, err := http.ReadResponse(br, nil)
+	if err != nil {
+		t.Fatalf("read handshake: %v", err)
+	}
+	t.Logf("handshake: %d accept=%s", resp.StatusCode, resp.Header.Get("Sec-WebSocket-Accept"))
+	if resp.StatusCode != http.StatusSwitchingProtocols {
+		t.Fatalf("expected 101, got %d", resp.StatusCode)
+	}
+	// RFC 6455 section 1.3 example key and accept value
+	if resp.Header.Get("Sec-WebSocket-Accept") != "s3pPLMBiTxaQ9kYGzzhZRbK+xOo=" {
+		t.Errorf("wrong accept key")
+	}
+	return conn, br
+}
+
+// readWSText reads one unfragmented, unmasked server text frame.
+func readWSText(t *testing.T, conn net.Conn, br *bufio.Reader) []byte {
+	t.Helper()
+	conn.SetReadDeadline(time.Now().Add(5 * time.Second))
+	head := make([]byte, 2)
+	if _, err := io.ReadFull(br, head); err != nil {
+		t.Fatalf("read frame: %v", err)
+	}
+	if head[0] != 0x81 {
+		t.Fatalf("expected final text frame, got %#x", head[0])
+	}
+	size := int(head[1])
+	if size == 126 {
+		ext := make([]byte, 2)
+		io.ReadFull(br, ext)
+		size = int(ext[0])<<8 | int(ext[1])
+	}
+	payload := make([]byte, size)
+	if _, err := io.ReadFull(br, payload); err != nil {
+		t.Fatalf("read payload: %v", err)
+	}
+	return payload
+}
+
+// openEventStream connects to the SSE endpoint, resuming after lastID, and
+// returns a function reading the next event's ID and data.
+func (e *testEnv) openEventStream(t *testing.T, token, lastID string, userID string) (func() (string, model.ChangeEvent), func()) {
+	t.Helper()
+	before := e.api.hub.connections(userID)
+	req, _ := http.NewRequest("GET", e.server.URL+"/api/v1/sync/events?access_token="+token, nil)
+	if lastID != "" {
+		req.Header.Set("Last-Event-ID", lastID)
+	}
+	resp, err := http.DefaultClient.Do(req)
+	if err != nil {
+		t.Fatalf("open event stream: %v", err)
+	}
+	if resp.StatusCode != http.StatusOK || resp.Header.Get("Content-Type") != "text/event-stream" {
+		t.Fatalf("event stream: status %d, type %q", resp.StatusCode, resp.Header.Get("Content-Type"))
+	}
+	for i := 0; e.api.hub.connections(userID) == before; i++ {
+		if i > 100 {
+			t.Fatal("subscriber never registered")
+		}
+		time.Sleep(10 * time.Millisecond)
+	}
+	br := bufio.NewReader(resp.Body)
+	next := func() (string, model.ChangeEvent) {
+		t.Helper()
+		var id string
+		var ev model.ChangeEvent
+		for {
+			line, err := br.ReadString('\n')
+			if err != nil {
+				t.Fatalf("read event: %v", err)
+			}
+			line = strings.TrimSuffix(line, "\n")
+			switch {
+			case line == "" && id != "":
+				return id, ev
+			case strings.HasPrefix(line, "id: "):
+				id = strings.TrimPrefix(line, "id: ")
+			case strings.HasPrefix(line, "data: "):
+				if err := json.Unmarshal([]byte(strings.TrimPrefix(line, "data: ")), &ev); err != nil {
+					t.Fatalf("decode event %q: %v", line, err)
+				}
+			}
+		}
+	}
+	return next, func() { resp.Body.Close() }
+}
+
+func TestSyncEventStream(t *testing.T) {
+	// Arrange
+	e := setup(t)
+	token, user := e.registerAndLogin(t)
+	next, closeStream := e.openEventStream(t, token, "", user.ID)
+
+	// Act
+	first := e.createNote(t, token, "Streamed", "body")
+	id, ev := next()
+
+	// Assert
+	t.Logf("event %s: %+v", id, ev)
+	if ev.Type != "note" || ev.ID != first.ID || ev.ModifiedAt == nil || ev.Note != nil {
+		t.Errorf("unexpected event: %+v", ev)
+	}
+
+	// Act: changes made while disconnected are replayed on resume
+	closeStream()
+	missed := e.createNote(t, token, "Missed", "")
+	next, closeStream = e.openEventStream(t, token, id, user.ID)
+	defer closeStream()
+	resumedID, resumed := next()
+
+	// Assert
+	t.Logf("resumed %s: %+v", resumedID, resumed)
+	if resumed.ID != missed.ID || resumedID == id {
+		t.Errorf("resume: expected the missed note %s, got %+v", missed.ID, resumed)
+	}
+
+	// Act: an ID from another server run cannot be resumed
+	next, closeOld := e.openEventStream(t, token, "old-1", user.ID)
+	defer closeOld()
+	_, stale := next()
+
+	// Assert
+	if stale.Type != "resync" {
+		t.Errorf("stale ID: expected a resync event, got %+v", stale)
+	}
+}
+
+func TestLiveSyncWebSocket(t *testing.T) {
+	// Arrange
+	e := setup(t)
+	token, user := e.registerAndLogin(t)
+
+	resp := e.doJSON(t, "GET", "/api/v1/sync/ws", nil, token)
+	resp.Body.Close()
+	t.Logf("plain GET status: %d", resp.StatusCode)
+	if resp.StatusCode != http.StatusBadRequest {
+		t.Errorf("expected 400 without upgrade, got %d", resp.StatusCode)
+	}
+
+	conn, br := e.dialWS(t, "/api/v1/sync/ws?access_token="+token)
+	for i := 0; e.api.hub.connections(user.ID) == 0; i++ {
+		if i > 100 {
+			t.Fatal("subscriber never registered")
+		}
+		time.Sleep(10 * time.Millisecond)
+	}
+
+	// Act
+	note := e.createNote(t, token, "Live", "pushed")
+	e.doJSON(t, "DELETE", "/api/v1/notes/"+note.ID, nil, token).Body.Close()
+
+	// Assert
+	var ev model.ChangeEvent
+	if err := json.Unmarshal(readWSText(t, conn, br), &ev); err != nil {
+		t.Fatalf("decode event: %v", err)
+	}
+	t.Logf("event 1: %+v", ev)
+	if ev.Type != "note" || ev.ID != note.ID || ev.Deleted || ev.Note == nil || ev.Note.Title != "Live" {
+		t.Errorf("unexpected create event: %+v", ev)
+	}
+	ev = model.ChangeEvent{}
+	if err := json.Unmarshal(readWSText(t, conn, br), &ev); err != nil {
+		t.Fatalf("decode event: %v", err)
+	}
+	t.Logf("event 2: %+v", ev)
+	if ev.Type != "note" || ev.ID != note.ID || !ev.Deleted {
+		t.Errorf("unexpected delete event: %+v", ev)
+	}
+
+	// Closing the socket unregisters the subscriber
+	conn.Close()
+	for i := 0; e.api.hub.connections(user.ID) != 0; i++ {
+		if i > 100 {
+			t.Fatal("subscriber not removed after disconnect")
+		}
+		time.Sleep(10 * time.Millisecond)
+	}
+}
+
+func TestHubDropsSlowSubscriber(t *testing.T) {
+	h := newHub()
+	events, cancel := h.subscribe("u1")
+	defer cancel()
+	other, cancelOther := h.subscribe("u2")
+	defer cancelOther()
+
+	for i := 0; i <= subscriberBuffer; i++ {
+		h.publish("u1", model.ChangeEvent{Type: "resync"})
+	}
+
+	n := 0
+	for range events {
+		n++
+	}
+	t.Logf("received %d events before channel closed", n)
+	if n != subscriberBuffer {
+		t.Errorf("expected %d buffered events, got %d", subscriberBuffer, n)
+	}
+	if h.connections("u1") != 0 || h.connections("u2") != 1 || len(other) != 0 {
+		t.Error("publish affected the wrong subscribers")
+	}
+}
+
+func TestRelayBetweenServers(t *testing.T) {
+	// Arrange: two servers on one broker, a user connected to each
+	broker := pubsub.NewLocal()
+	e, other := setup(t), setup(t)
+	e.api.broker, other.api.broker = broker, broker
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+	go e.api.RunRelay(ctx)
+	go other.api.RunRelay(ctx)
+	for i := 0; broker.Subscribers(relayChannel) != 2; i++ {
+		if i > 100 {
+			t.Fatal("relays never subscribed")
+		}
+		time.Sleep(10 * time.Millisecond)
+	}
+	token, user := e.registerAndLogin(t)
+	here, cancelHere := e.api.hub.subscribe(user.ID)
+	defer cancelHere()
+	there, cancelThere := other.api.hub.subscribe(user.ID)
+	defer cancelThere()
+
+	// Act
+	note := e.createNote(t, token, "Relayed", "body")
+
+	// Assert
+	var relayed model.ChangeEvent
+	select {
+	case relayed = <-there:
+	case <-time.After(5 * time.Second):
+		t.Fatal("the other server got no event")
+	}
+	local := <-here
+	time.Sleep(50 * time.Millisecond)
+	t.Logf("relayed: %+v, local: %+v, local queued after: %d", relayed, local, len(here))
+	if relayed.Type != "note" || relayed.ID != note.ID || relayed.Note == nil || relayed.Note.Title != "Relayed" || relayed.ModifiedAt == nil {
+		t.Errorf("unexpected relayed event: %+v", relayed)
+	}
+	if local.ID != note.ID || len(here) != 0 {
+		t.Error("the origin server must deliver its event once")
+	}
+}
+
+// --- Attachment tests ---
+
+// upload posts content as a multipart file field to a note.
+func (e *testEnv) upload(t *testing.T, token, noteID, filename string, content []byte) *http.Response {
+	t.Helper()
+	var buf bytes.Buffer
+	mw := multipart.NewWriter(&buf)
+	fw, err := mw.CreateFormFile("file", filename)
+	if err != nil {
+		t.Fatalf("create form file: %v", err)
+	}
+	fw.Write(content)
+	mw.Close()
+
+	req, _ := http.NewRequest("POST", e.server.URL+"/api/v1/notes/"+noteID+"/attachments", &buf)
+	req.Header.Set("Content-Type", mw.FormDataContentType())
+	req.Header.Set("Authorization", "Bearer "+token)
+	resp, err := http.DefaultClient.Do(req)
+	if err != nil {
+		t.Fatalf("upload: %v", err)
+	}
+	return resp
+}
+
+func TestAttachments(t *testing.T) {
+	e := setup(t)
+	token, _ := e.registerAndLogin(t)
+	note := e.createNote(t, token, "With files", "")
+	since := model.NowMillis().UnixMilli() - 1
+
+	// Act: upload
+	resp := e.upload(t, token, note.ID, `C:\Users\me\report.txt`, []byte("hello attachment"))
+
+	// Assert: metadata
+	if resp.StatusCode != http.StatusCreated {
+		body, _ := io.ReadAll(resp.Body)
+		t.Fatalf("upload: status=%d body=%s", resp.StatusCode, body)
+	}
+	var at model.Attachment
+	decodeBody(t, resp, &at)
+	t.Logf("attachment: %+v", at)
+	if at.NoteID != note.ID || at.Filename != "report.txt" || at.Size != 16 || len(at.SHA256) != 64 {
+		t.Errorf("unexpected metadata: %+v", at)
+	}
+
+	// Listed on the note and included in sync changes
+	resp = e.doJSON(t, "GET", "/api/v1/notes/"+note.ID+"/attachments", nil, token)
+	var list []model.Attachment
+	decodeBody(t, resp, &list)
+	if len(list) != 1 || list[0].ID != at.ID {
+		t.Errorf("list: %+v", list)
+	}
+	resp = e.doJSON(t, "GET", fmt.Sprintf("/api/v1/sync/changes?since=%d", since), nil, token)
+	var changes model.SyncChangesResponse
+	decodeBody(t, resp, &changes)
+	t.Logf("sync attachments: %d", len(changes.Attachments))
+	if len(changes.Attachments) != 1 || changes.Attachments[0].ID != at.ID {
+		t.Errorf("sync changes: %+v", changes.Attachments)
+	}
+
+	// Download returns the bytes
+	resp = e.doJSON(t, "GET", "/api/v1/attachments/"+at.ID, nil, token)
+	body, _ := io.ReadAll(resp.Body)
+	resp.Body.Close()
+	t.Logf("download: status=%d disposition=%q", resp.StatusCode, resp.Header.Get("Content-Disposition"))
+	if resp.StatusCode != http.StatusOK || string(body) != "hello attachment" {
+		t.Errorf("download: status=%d body=%q", resp.StatusCode, body)
+	}
+
+	// Another user cannot see it
+	other, _ := e.registerAndLogin(t)
+	resp = e.doJSON(t, "GET", "/api/v1/attachments/"+at.ID, nil, other)
+	resp.Body.Close()
+	if resp.StatusCode != http.StatusNotFound {
+		t.Errorf("other user download: status=%d, want 404", resp.StatusCode)
+	}
+
+	// Delete leaves a tombstone for sync
+	resp = e.doJSON(t, "DELETE", "/api/v1/attachments/"+at.ID, nil, token)
+	resp.Body.Close()
+	if resp.StatusCode != http.StatusNoContent {
+		t.Fatalf("delete: status=%d", resp.StatusCode)
+	}
+	resp = e.doJSON(t, "GET", "/api/v1/attachments/"+at.ID, nil, token)
+	resp.Body.Close()
+	if resp.StatusCode != http.StatusNotFound {
+		t.Errorf("download after delete: status=%d, want 404", resp.StatusCode)
+	}
+	resp = e.doJSON(t, "GET", fmt.Sprintf("/api/v1/sync/changes?since=%d", since), nil, token)
+	decodeBody(t, resp, &changes)
+	if len(changes.Attachments) != 1 || changes.Attachments[0].DeletedAt == nil {
+		t.Errorf("expected tombstone in sync changes: %+v", changes.Attachments)
+	}
+}
+
+func TestAttachmentSizeLimit(t *testing.T) {
+	e := setup(t)
+	token, _ := e.registerAndLogin(t)
+	note := e.createNote(t, token, "Big", "")
+
+	resp := e.upload(t, token, note.ID, "big.bin", make([]byte, e.api.config.Attachments.MaxSize+1))
+	resp.Body.Close()
+
+	t.Logf("oversized upload: status=%d", resp.StatusCode)
+	if resp.StatusCode != http.StatusRequestEntityTooLarge {
+		t.Errorf("expected 413, got %d", resp.StatusCode)
+	}
+	if entries, _ := os.ReadDir(e.api.config.Attachments.Dir); len(entries) != 0 {
+		t.Errorf("rejected upload left %d files behind", len(entries))
+	}
+}
+
+// --- Public link tests ---
+
+func TestPublicLinks(t *testing.T) {
+	e := setup(t)
+	token, _ := e.registerAndLogin(t)
+	note := e.createNote(t, token, "Shared <plan>", "step one")
+
+	// Act: publish without a body
+	resp := e.doJSON(t, "POST", "/api/v1/notes/"+note.ID+"/publish", nil, token)
+	if resp.StatusCode != http.StatusCreated {
+		t.Fatalf("publish: status=%d", resp.StatusCode)
+	}
+	var link model.PublicLink
+	decodeBody(t, resp, &link)
+	t.Logf("link: %+v", link)
+	if len(link.Slug) < 22 || link.URL != "/p/"+link.Slug || link.ExpiresAt != nil {
+		t.Fatalf("unexpected link: %+v", link)
+	}
+
+	// Assert: readable without auth, as JSON and as escaped HTML
+	resp = e.doJSON(t, "GET", link.URL, nil, "")
+	var pub model.PublicNote
+	decodeBody(t, resp, &pub)
+	if pub.Title != note.Title || pub.Content != "step one" {
+		t.Errorf("public note: %+v", pub)
+	}
+	req, _ := http.NewRequest("GET", e.server.URL+link.URL, nil)
+	req.Header.Set("Accept", "text/html,application/xhtml+xml")
+	resp, err := http.DefaultClient.Do(req)
+	if err != nil {
+		t.Fatalf("get html: %v", err)
+	}
+	page, _ := io.ReadAll(resp.Body)
+	resp.Body.Close()
+	t.Logf("html: content-type=%q", resp.Header.Get("Content-Type"))
+	if !strings.Contains(string(page), "<h1>Shared &lt;plan&gt;</h1>") {
+		t.Errorf("title not escaped in page:\n%s", page)
+	}
+
+	// Listed for the owner
+	resp = e.doJSON(t, "GET", "/api/v1/notes/"+note.ID+"/links", nil, token)
+	var links []model.PublicLink
+	decodeBody(t, resp, &links)
+	if len(links) != 1 || links[0].Slug != link.Slug {
+		t.Errorf("links: %+v", links)
+	}
+
+	// Revoked links stop working
+	resp = e.doJSON(t, "DELETE", "/api/v1/links/"+link.Slug, nil, token)
+	resp.Body.Close()
+	if resp.StatusCode != http.StatusNoContent {
+		t.Fatalf("revoke: status=%d", resp.StatusCode)
+	}
+	resp = e.doJSON(t, "GET", link.URL, nil, "")
+	resp.Body.Close()
+	if resp.StatusCode != http.StatusNotFound {
+		t.Errorf("revoked link: status=%d, want 404", resp.StatusCode)
+	}
+}
+
+func TestNoteFeed(t *testing.T) {
+	e := setup(t)
+	token, _ := e.registerAndLogin(t)
+
+	// Arrange: a private note and a published one, and a feed of each kind
+	e.createNote(t, token, "Diary", "Dear **diary** about [[Plans]]")
+	shared := e.createNote(t, token, "Recipe & tips", "# Bread\n\nflour, <script>x</script>")
+	var link model.PublicLink
+	decodeBody(t, e.doJSON(t, "POST", "/api/v1/notes/"+shared.ID+"/publish", nil, token), &link)
+	var all, pub model.NoteFeed
+	decodeBody(t, e.doJSON(t, "POST", "/api/v1/notes/feed", nil, token), &all)
+	decodeBody(t, e.doJSON(t, "POST", "/api/v1/notes/feed", model.NoteFeedRequest{Published: true}, token), &pub)
+	fetch := func(url string, header ...string) (*http.Response, string) {
+		req, _ := http.NewRequest("GET", e.server.URL+url, nil)
+		if len(header) == 2 {
+			req.Header.Set(header[0], header[1])
+		}
+		resp, err := http.DefaultClient.Do(req)
+		if err != nil {
+			t.Fatalf("get %s: %v", url, err)
+		}
+		body, _ := io.ReadAll(resp.Body)
+		resp.Body.Close()
+		return resp, string(body)
+	}
+
+	// Act
+	allResp, allFeed := fetch(all.URL)
+	_, pubFeed := fetch(pub.URL)
+	cached, _ := fetch(all.URL, "If-None-Match", allResp.Header.Get("ETag"))
+	var feeds []model.NoteFeed
+	decodeBody(t, e.doJSON(t, "GET", "/api/v1/notes/feed", nil, token), &feeds)
+	e.doJSON(t, "DELETE", "/api/v1/notes/feed?published=true", nil, token).Body.Close()
+	revoked, _ := fetch(pub.URL)
+	noToken, _ := fetch("/api/v1/notes/feed.atom")
+
+	// Assert
+	t.Logf("all feed (%s):\n%s", allResp.Header.Get("Content-Type"), allFeed)
+	t.Logf("published feed:\n%s", pubFeed)
+	if allResp.StatusCode != http.StatusOK || !strings.HasPrefix(allResp.Header.Get("Content-Type"), "application/atom+xml") {
+		t.Fatalf("feed: status=%d content-type=%q", allResp.StatusCode, allResp.Header.Get("Content-Type"))
+	}
+	var doc struct {
+		Title   string `xml:"title"`
+		Entries []struct {
+			ID      string `xml:"id"`
+			Title   string `xml:"title"`
+			Content string `xml:"content"`
+			Link    struct {
+				Href string `xml:"href,attr"`
+			} `xml:"link"`
+		} `xml:"entry"`
+	}
+	if err := xml.Unmarshal([]byte(allFeed), &doc); err != nil {
+		t.Fatalf("parse feed: %v", err)
+	}
+	if doc.Title != "Recent notes by Test User" || len(doc.Entries) != 2 {
+		t.Fatalf("unexpected feed %+v", doc)
+	}
+	if doc.Entries[0].Title != "Recipe & tips" || !strings.Contains(doc.Entries[0].Content, "<h1>Bread</h1>") ||
+		strings.Contains(doc.Entries[0].Content, "<script>") {
+		t.Errorf("unexpected first entry %+v", doc.Entries[0])
+	}
+	if !strings.Contains(doc.Entries[1].Content, "<strong>diary</strong> about Plans") {
+		t.Errorf("unexpected second entry %+v", doc.Entries[1])
+	}
+	if strings.Contains(pubFeed, "Diary") || !strings.Contains(pubFeed, `href="/p/`+link.Slug+`"`) {
+		t.Errorf("published feed should hold only the shared note, linked to its page")
+	}
+	if cached.StatusCode != http.StatusNotModified {
+		t.Errorf("expected 304 for a matching ETag, got %d", cached.StatusCode)
+	}
+	if len(feeds) != 2 || feeds[0].Published || !feeds[1].Published || feeds[0].URL != "" {
+		t.Errorf("unexpected feed list %+v", feeds)
+	}
+	if revoked.StatusCode != http.StatusUnauthorized || noToken.StatusCode != http.StatusUnauthorized {
+		t.Errorf("expected 401 for revoked and missing tokens, got %d and %d", revoked.StatusCode, noToken.StatusCode)
+	}
+}
+
+func TestPublicLinkExpiry(t *testing.T) {
+	e := setup(t)
+	token, _ := e.registerAndLogin(t)
+	note := e.createNote(t, token, "Short lived", "")
+
+	// A past expiry is rejected
+	past := time.Now().Add(-time.Minute)
+	resp := e.doJSON(t, "POST", "/api/v1/notes/"+note.ID+"/publish",
+		model.PublishNoteRequest{ExpiresAt: &past}, token)
+	resp.Body.Close()
+	if resp.StatusCode != http.StatusBadRequest {
+		t.Errorf("past expiry: status=%d, want 400", resp.StatusCode)
+	}
+
+	// A link stops resolving once it expires
+	soon := time.Now().Add(50 * time.Millisecond)
+	resp = e.doJSON(t, "POST", "/api/v1/notes/"+note.ID+"/publish",
+		model.PublishNoteRequest{ExpiresAt: &soon}, token)
+	var link model.PublicLink
+	decodeBody(t, resp, &link)
+	time.Sleep(100 * time.Millisecond)
+
+	resp = e.doJSON(t, "GET", link.URL, nil, "")
+	resp.Body.Close()
+	t.Logf("expired link: status=%d", resp.StatusCode)
+	if resp.StatusCode != http.StatusNotFound {
+		t.Errorf("expired link: status=%d, want 404", resp.StatusCode)
+	}
+}
+
+type fakePush struct {
+	endpoints []string
+	payloads  [][]byte
+	gone      map[string]bool
+}
+
+func (p *fakePush) Send(sub push.Subscription, payload []byte) error {
+	if p.gone[sub.Endpoint] {
+		return push.ErrGone
+	}
+	p.endpoints = append(p.endpoints, sub.Endpoint)
+	p.payloads = append(p.payloads, payload)
+	return nil
+}
+
+func (p *fakePush) PublicKey() string { return "test-public-key" }
+
+type fakeWebhook struct {
+	urls     []string
+	payloads [][]byte
+}
+
+func (h *fakeWebhook) Send(url string, payload []byte) error {
+	h.urls = append(h.urls, url)
+	h.payloads = append(h.payloads, payload)
+	return nil
+}
+
+func TestReminders(t *testing.T) {
+	e := setup(t)
+	token, _ := e.registerAndLogin(t)
+
+	// Arrange: registering a device is refused while web push is off
+	sub := model.PushSubscriptionRequest{
+		Endpoint: "https://push.example.com/dev1",
+		Keys:     model.PushKeys{P256dh: "BPk", Auth: "c2VjcmV0"},
+		DeviceID: "dev1",
+	}
+	resp := e.doJSON(t, "POST", "/api/v1/push/subscriptions", sub, token)
+	resp.Body.Close()
+	t.Logf("subscribe without web push: %d", resp.StatusCode)
+	if resp.StatusCode != http.StatusBadRequest {
+		t.Errorf("expected 400 without web push, got %d", resp.StatusCode)
+	}
+
+	wp := &fakePush{gone: map[string]bool{"https://push.example.com/old": true}}
+	hooks := &fakeWebhook{}
+	e.api.webPush = wp
+	e.api.webhooks = hooks
+
+	for _, s := range []model.PushSubscriptionRequest{sub, {
+		Endpoint: "https://push.example.com/old", Keys: sub.Keys, DeviceID: "dev2",
+	}} {
+		resp := e.doJSON(t, "POST", "/api/v1/push/subscriptions", s, token)
+		resp.Body.Close()
+		if resp.StatusCode != http.StatusCreated {
+			t.Fatalf("subscribe %s: expected 201, got %d", s.DeviceID, resp.StatusCode)
+		}
+	}
+	private := e.doJSON(t, "POST", "/api/v1/push/subscriptions", model.PushSubscriptionRequest{
+		Endpoint: "https://169.254.169.254/push", Keys: sub.Keys, DeviceID: "dev3",
+	}, token)
+	private.Body.Close()
+	privateHook := e.doJSON(t, "PUT", "/api/v1/reminders/webhook",
+		model.ReminderWebhook{URL: "http://127.0.0.1:6379/"}, token)
+	privateHook.Body.Close()
+	t.Logf("private endpoint: %d, private webhook: %d", private.StatusCode, privateHook.StatusCode)
+	if private.StatusCode != http.StatusBadRequest || privateHook.StatusCode != http.StatusBadRequest {
+		t.Errorf("private addresses: expected 400 and 400, got %d and %d", private.StatusCode, privateHook.StatusCode)
+	}
+	resp = e.doJSON(t, "PUT", "/api/v1/reminders/webhook",
+		model.ReminderWebhook{URL: "https://hooks.example.com/notesd"}, token)
+	resp.Body.Close()
+	if resp.StatusCode != http.StatusOK {
+		t.Fatalf("set webhook: expected 200, got %d", resp.StatusCode)
+	}
+
+	now := time.Now()
+	remindAt := now.Add(5 * time.Minute)
+	e.doJSON(t, "POST", "/api/v1/todos", model.CreateTodoRequest{
+		Content: "call the dentist", ReminderAt: &remindAt, DeviceID: "dev1",
+	}, token).Body.Close()
+	e.doJSON(t, "POST", "/api/v1/todos", model.CreateTodoRequest{
+		Content: "no reminder", DeviceID: "dev1",
+	}, token).Body.Close()
+	note := e.createNote(t, token, "Read later", "")
+	e.doJSON(t, "POST", "/api/v1/notes/"+note.ID+"/snooze", model.SnoozeNoteRequest{
+		Until: now.Add(10 * time.Minute), DeviceID: "dev1",
+	}, token).Body.Close()
+
+	// Act: nothing is due yet, then the todo, then the snoozed note
+	e.api.sendDueReminders(now)
+	before := len(hooks.urls)
+	e.api.sendDueReminders(now.Add(6 * time.Minute))
+	e.api.sendDueReminders(now.Add(7 * time.Minute))
+	afterTodo := len(hooks.urls)
+	e.api.sendDueReminders(now.Add(11 * time.Minute))
+
+	// Assert
+	t.Logf("webhook calls: before=%d afterTodo=%d total=%d; push=%v", before, afterTodo, len(hooks.urls), wp.endpoints)
+	if before != 0 {
+		t.Errorf("expected nothing before the reminder time, got %d", before)
+	}
+	if afterTodo != 1 {
+		t.Fatalf("expected the todo reminder exactly once, got %d", afterTodo)
+	}
+	var r model.Reminder
+	if err := json.Unmarshal(hooks.payloads[0], &r); err != nil {
+		t.Fatalf("decode payload: %v", err)
+	}
+	t.Logf("payload: %s", hooks.payloads[0])
+	if r.Type != "todo" || r.Title != "call the dentist" {
+		t.Errorf("unexpected reminder %+v", r)
+	}
+	if len(hooks.payloads) != 2 || !strings.Contains(string(hooks.payloads[1]), `"type":"note"`) {
+		t.Errorf("expected a snooze reminder for the note, got %d calls", len(hooks.payloads))
+	}
+	if len(wp.endpoints) != 2 || wp.endpoints[0] != sub.Endpoint {
+		t.Errorf("expected 2 pushes to dev1, got %v", wp.endpoints)
+	}
+
+	// The expired subscription was dropped
+	var subs []model.PushSubscription
+	resp = e.doJSON(t, "GET", "/api/v1/push/subscriptions", nil, token)
+	decodeBody(t, resp, &subs)
+	if len(subs) != 1 || subs[0].DeviceID != "dev1" {
+		t.Errorf("expected only dev1 to remain subscribed, got %+v", subs)
+	}
+}
+
+func TestPurgeNote(t *testing.T) {
+	e := setup(t)
+	token, _ := e.registerAndLogin(t)
+	note := e.createNote(t, token, "Secret", "remove me for good")
+	at := e.upload(t, token, note.ID, "a.txt", []byte("x"))
+	var attachment model.Attachment
+	decodeBody(t, at, &attachment)
+	e.doJSON(t, "POST", "/api/v1/todos", model.CreateTodoRequest{
+		Content: "linked", NoteID: &note.ID, DeviceID: "dev1",
+	}, token).Body.Close()
+	since := model.NowMillis().UnixMilli() - 1
+
+	// Act
+	resp := e.doJSON(t, "DELETE", "/api/v1/notes/"+note.ID+"?purge=true", nil, token)
+	resp.Body.Close()
+
+	// Assert
+	t.Logf("purge: %d", resp.StatusCode)
+	if resp.StatusCode != http.StatusNoContent {
+		t.Fatalf("expected 204, got %d", resp.StatusCode)
+	}
+	resp = e.doJSON(t, "GET", "/api/v1/attachments/"+attachment.ID, nil, token)
+	resp.Body.Close()
+	if resp.StatusCode != http.StatusNotFound {
+		t.Errorf("attachment after purge: expected 404, got %d", resp.StatusCode)
+	}
+	resp = e.doJSON(t, "DELETE", "/api/v1/notes/"+note.ID+"?purge=true", nil, token)
+	resp.Body.Close()
+	if resp.StatusCode != http.StatusNotFound {
+		t.Errorf("second purge: expected 404, got %d", resp.StatusCode)
+	}
+
+	// Sync reports the purge instead of a tombstone and detaches the todo
+	var changes model.SyncChangesResponse
+	decodeBody(t, e.doJSON(t, "GET", fmt.Sprintf("/api/v1/sync/changes?since=%d", since), nil, token), &changes)
+	t.Logf("changes: notes=%d todos=%d purged=%+v", len(changes.Notes), len(changes.Todos), changes.Purged)
+	if len(changes.Notes) != 0 {
+		t.Errorf("expected no note tombstone, got %+v", changes.Notes)
+	}
+	if len(changes.Purged) != 2 || !slices.Contains(changes.Purged, model.PurgedItem{Type: "note", ID: note.ID}) {
+		t.Errorf("expected note and attachment purged, got %+v", changes.Purged)
+	}
+	if len(changes.Todos) != 1 || changes.Todos[0].NoteID != nil {
+		t.Errorf("expected the todo detached, got %+v", changes.Todos)
+	}
+
+	// A stale device cannot bring it back
+	note.Title = "revived"
+	note.ModifiedAt = time.Now().Add(time.Hour)
+	var pushResp model.SyncPushResponse
+	decodeBody(t, e.doJSON(t, "POST", "/api/v1/sync/push", model.SyncPushRequest{Notes: []model.Note{note}}, token), &pushResp)
+	resp = e.doJSON(t, "GET", "/api/v1/notes/"+note.ID, nil, token)
+	resp.Body.Close()
+	if pushResp.Accepted != 0 || resp.StatusCode != http.StatusNotFound {
+		t.Errorf("purged note revived: accepted=%d get=%d", pushResp.Accepted, resp.StatusCode)
+	}
+}
+
+func TestTombstoneGC(t *testing.T) {
+	e := setup(t)
+	token, _ := e.registerAndLogin(t)
+	e.api.tombstoneRetention = 24 * time.Hour
+	gone := e.createNote(t, token, "Old", "")
+	kept := e.createNote(t, token, "Kept", "")
+	var goneTodo model.Todo
+	decodeBody(t, e.doJSON(t, "POST", "/api/v1/todos", model.CreateTodoRequest{Content: "old", DeviceID: "dev1"}, token), &goneTodo)
+	beforeDelete := model.NowMillis().UnixMilli() - 1
+	e.doJSON(t, "DELETE", "/api/v1/notes/"+gone.ID, nil, token).Body.Close()
+	e.doJSON(t, "DELETE", "/api/v1/todos/"+goneTodo.ID, nil, token).Body.Close()
+
+	// Act: nothing is old enough yet, then the tombstone expires
+	e.api.collectTombstones(time.Now())
+	var early model.SyncChangesResponse
+	decodeBody(t, e.doJSON(t, "GET", fmt.Sprintf("/api/v1/sync/changes?since=%d", beforeDelete), nil, token), &early)
+	e.api.collectTombstones(time.Now().Add(25 * time.Hour))
+
+	// Assert
+	t.Logf("before gc: notes=%d reset=%v", len(early.Notes), early.Reset)
+	i := slices.IndexFunc(early.Notes, func(n model.Note) bool { return n.ID == gone.ID })
+	if i < 0 || early.Notes[i].DeletedAt == nil || early.Reset {
+		t.Errorf("expected the tombstone before collection, got %+v", early)
+	}
+	if _, err := e.db.GetNoteAny(gone.ID, gone.UserID); !errors.Is(err, database.ErrNotFound) {
+		t.Errorf("expected the tombstone removed, got %v", err)
+	}
+
+	// A device that synced before the collection gets a full reset
+	var stale model.SyncChangesResponse
+	decodeBody(t, e.doJSON(t, "GET", fmt.Sprintf("/api/v1/sync/changes?since=%d", beforeDelete), nil, token), &stale)
+	t.Logf("stale device: notes=%d reset=%v", len(stale.Notes), stale.Reset)
+	if !stale.Reset || len(stale.Notes) != 1 || stale.Notes[0].ID != kept.ID {
+		t.Errorf("expected a reset listing only the kept note, got %+v", stale)
+	}
+
+	// A device that synced after the cutoff (an hour ahead, as the clock
+	// was advanced) does not
+	var fresh model.SyncChangesResponse
+	after := time.Now().Add(2 * time.Hour).UnixMilli()
+	decodeBody(t, e.doJSON(t, "GET", fmt.Sprintf("/api/v1/sync/changes?since=%d", after), nil, token), &fresh)
+	if fresh.Reset {
+		t.Error("expected no reset for a device that synced after collection")
+	}
+
+	// A stale device cannot bring back what was collected, but newer
+	// changes still go through
+	newer := kept
+	newer.Title, newer.ModifiedAt = "Kept, edited", time.Now().Add(2*time.Hour)
+	var pushed model.SyncPushResponse
+	decodeBody(t, e.doJSON(t, "POST", "/api/v1/sync/push", model.SyncPushRequest{
+		DeviceID: "stale", Notes: []model.Note{gone, newer}, Todos: []model.Todo{goneTodo},
+	}, token), &pushed)
+	_, noteErr := e.db.GetNoteAny(gone.ID, gone.UserID)
+	_, todoErr := e.db.GetTodoAny(goneTodo.ID, goneTodo.UserID)
+	t.Logf("stale push: accepted=%d, note %v, todo %v", pushed.Accepted, noteErr, todoErr)
+	if pushed.Accepted != 1 || !errors.Is(noteErr, database.ErrNotFound) || !errors.Is(todoErr, database.ErrNotFound) {
+		t.Errorf("expected only the newer edit accepted, got %d accepted, note %v, todo %v", pushed.Accepted, noteErr, todoErr)
+	}
+}
+
+func TestSyncChangesPagination(t *testing.T) {
+	e := setup(t)
+	token, _ := e.registerAndLogin(t)
+	want := map[string]bool{}
+	for i := range 5 {
+		n := e.createNote(t, token, fmt.Sprintf("Note %d", i), strings.Repeat("x", 200))
+		want[n.ID] = true
+	}
+	for i := range 3 {
+		var todo model.Todo
+		decodeBody(t, e.doJSON(t, "POST", "/api/v1/todos", model.CreateTodoRequest{
+			Content: fmt.Sprintf("Todo %d", i), DeviceID: "dev1",
+		}, token), &todo)
+		want[todo.ID] = true
+	}
+
+	// Act: page through with a small limit
+	got := map[string]bool{}
+	pages := 0
+	path := "/api/v1/sync/changes?since=0&limit=3"
+	var page model.SyncChangesResponse
+	for {
+		page = model.SyncChangesResponse{}
+		decodeBody(t, e.doJSON(t, "GET", path, nil, token), &page)
+		pages++
+		t.Logf("page %d: notes=%d todos=%d has_more=%v ts=%d", pages, len(page.Notes), len(page.Todos), page.HasMore, page.SyncTimestamp)
+		for _, n := range page.Notes {
+			got[n.ID] = true
+		}
+		for _, td := range page.Todos {
+			got[td.ID] = true
+		}
+		if !page.HasMore || pages > 10 {
+			break
+		}
+		if page.SyncTimestamp != 0 || page.NextCursor == "" {
+			t.Errorf("intermediate page: ts=%d cursor=%q", page.SyncTimestamp, page.NextCursor)
+		}
+		path = "/api/v1/sync/changes?limit=3&cursor=" + page.NextCursor
+	}
+
+	// Assert
+	if pages != 3 {
+		t.Errorf("expected 3 pages of at most 3 items, got %d", pages)
+	}
+	if len(got) != len(want) {
+		t.Errorf("expected %d distinct items, got %d", len(want), len(got))
+	}
+	if page.SyncTimestamp == 0 {
+		t.Error("last page should carry the sync timestamp")
+	}
+
+	// The byte cap splits pages too, but a page holds at least one item
+	e.api.syncPageBytes = 100
+	decodeBody(t, e.doJSON(t, "GET", "/api/v1/sync/changes?since=0", nil, token), &page)
+	t.Logf("byte-capped page: notes=%d has_more=%v", len(page.Notes), page.HasMore)
+	if len(page.Notes) != 1 || !page.HasMore {
+		t.Errorf("expected a single oversized note per page, got %d", len(page.Notes))
+	}
+
+	resp := e.doJSON(t, "GET", "/api/v1/sync/changes?cursor=bogus", nil, token)
+	resp.Body.Close()
+	if resp.StatusCode != http.StatusBadRequest {
+		t.Errorf("bad cursor: expected 400, got %d", resp.StatusCode)
+	}
+}
+
+func TestDeltaSync(t *testing.T) {
+	// Arrange: a long note with one line edited afterwards
+	e := setup(t)
+	token, _ := e.registerAndLogin(t)
+	var b strings.Builder
+	for i := range 200 {
+		fmt.Fprintf(&b, "line %d of a long note\n", i)
+	}
+	base := b.String()
+	n := e.createNote(t, token, "Long", base)
+	edited := strings.Replace(base, "line 100 of", "LINE 100 OF", 1)
+	resp := e.doJSON(t, "PUT", "/api/v1/notes/"+n.ID, model.UpdateNoteRequest{
+		Content: &edited, DeviceID: "dev1",
+	}, token)
+	resp.Body.Close()
+
+	// Act: pull with deltas
+	var page model.SyncChangesResponse
+	decodeBody(t, e.doJSON(t, "GET", "/api/v1/sync/changes?since=0&delta=true", nil, token), &page)
+
+	// Assert: the note arrives as a patch against the content the client has
+	if len(page.Notes) != 1 {
+		t.Fatalf("expected 1 note, got %d", len(page.Notes))
+	}
+	got := page.Notes[0]
+	t.Logf("pulled: content=%d bytes, hunks=%d, base=%.8s", len(got.Content), len(got.ContentPatch), got.BaseHash)
+	if got.Content != "" || got.BaseHash != delta.Hash(base) || got.ContentHash != delta.Hash(edited) {
+		t.Fatalf("expected a patch from the original content, got %+v", got)
+	}
+	applied, err := delta.Apply(base, got.ContentPatch)
+	if err != nil || applied != edited {
+		t.Errorf("patch does not reproduce the edit: %v", err)
+	}
+
+	// Without delta=true the full content is sent
+	page = model.SyncChangesResponse{}
+	decodeBody(t, e.doJSON(t, "GET", "/api/v1/sync/changes?since=0", nil, token), &page)
+	if page.Notes[0].Content != edited || page.Notes[0].ContentPatch != nil {
+		t.Error("plain pull should carry full content")
+	}
+
+	// Act: push a patch on top of the server's copy
+	next := edited + "appended\n"
+	push := got
+	push.ModifiedAt = got.ModifiedAt.Add(time.Second)
+	push.ModifiedByDevice = "dev2"
+	push.BaseHash = delta.Hash(edited)
+	push.ContentHash = delta.Hash(next)
+	push.ContentPatch = delta.Diff(edited, next)
+	var pushResp model.SyncPushResponse
+	decodeBody(t, e.doJSON(t, "POST", "/api/v1/sync/push", model.SyncPushRequest{
+		Notes: []model.Note{push}, DeviceID: "dev2",
+	}, token), &pushResp)
+
+	// Assert
+	var stored model.Note
+	decodeBody(t, e.doJSON(t, "GET", "/api/v1/notes/"+n.ID, nil, token), &stored)
+	t.Logf("push: accepted=%d need_full=%v", pushResp.Accepted, pushResp.NeedFull)
+	if pushResp.Accepted != 1 || stored.Content != next || stored.ContentHash != delta.Hash(next) {
+		t.Errorf("patch push not applied: accepted=%d content ends %q", pushResp.Accepted, stored.Content[len(stored.Content)-20:])
+	}
+
+	// Act: a patch against stale content is refused
+	push.ModifiedAt = push.ModifiedAt.Add(time.Second)
+	push.BaseHash = delta.Hash(edited)
+	pushResp = model.SyncPushResponse{}
+	decodeBody(t, e.doJSON(t, "POST", "/api/v1/sync/push", model.SyncPushRequest{
+		Notes: []model.Note{push}, DeviceID: "dev2",
+	}, token), &pushResp)
+
+	// Assert: the client is asked for the full note and nothing changed
+	decodeBody(t, e.doJSON(t, "GET", "/api/v1/notes/"+n.ID, nil, token), &stored)
+	t.Logf("stale push: accepted=%d need_full=%v", pushResp.Accepted, pushResp.NeedFull)
+	if pushResp.Accepted != 0 || !slices.Equal(pushResp.NeedFull, []string{n.ID}) {
+		t.Errorf("expected need_full for %s, got %+v", n.ID, pushResp)
+	}
+	if stored.Content != next {
+		t.Error("stale patch must not change the note")
+	}
+}
+
+func TestNoteHTML(t *testing.T) {
+	// Arrange
+	e := setup(t)
+	token, _ := e.registerAndLogin(t)
+	n := e.createNote(t, token, "Rendered", "# Plan\n\n- [x] **done**\n- [ ] ~~later~~\n\n"+
+		"<script>alert(1)</script>\n\n[bad](javascript:alert(1)) [good](https://example.com)\n")
+
+	// Act
+	resp := e.doJSON(t, "GET", "/api/v1/notes/"+n.ID+"/html", nil, token)
+	body, _ := io.ReadAll(resp.Body)
+	resp.Body.Close()
+
+	// Assert
+	t.Logf("status=%d type=%q\n%s", resp.StatusCode, resp.Header.Get("Content-Type"), body)
+	if resp.StatusCode != http.StatusOK || !strings.HasPrefix(resp.Header.Get("Content-Type"), "text/html") {
+		t.Fatalf("expected 200 text/html, got %d %q", resp.StatusCode, resp.Header.Get("Content-Type"))
+	}
+	html := string(body)
+	for _, want := range []string{"<h1>Plan</h1>", "<strong>done</strong>", `type="checkbox"`, "<del>later</del>", `href="https://example.com"`} {
+		if !strings.Contains(html, want) {
+			t.Errorf("missing %q", want)
+		}
+	}
+	for _, bad := range []string{"<script>", "javascript:"} {
+		if strings.Contains(html, bad) {
+			t.Errorf("unsanitized %q in output", bad)
+		}
+	}
+
+	resp = e.doJSON(t, "GET", "/api/v1/notes/"+model.NewID()+"/html", nil, token)
+	resp.Body.Close()
+	if resp.StatusCode != http.StatusNotFound {
+		t.Errorf("unknown note: expected 404, got %d", resp.StatusCode)
+	}
+}
+
+func TestBacklinksAndLinkedHTML(t *testing.T) {
+	e := setup(t)
+	token, _ := e.registerAndLogin(t)
+
+	// Arrange
+	target := e.createNote(t, token, "Beta", "target")
+	linker := e.createNote(t, token, "Alpha", "see [[beta|the b note]] and [[Missing]]")
+
+	// Act
+	resp := e.doJSON(t, "GET", "/api/v1/notes/"+target.ID+"/backlinks", nil, token)
+	var back []model.Note
+	decodeBody(t, resp, &back)
+	resp = e.doJSON(t, "GET", "/api/v1/notes/"+linker.ID+"/html", nil, token)
+	body, _ := io.ReadAll(resp.Body)
+	resp.Body.Close()
+
+	// Assert
+	t.Logf("backlinks=%d html=%s", len(back), body)
+	if len(back) != 1 || back[0].ID != linker.ID {
+		t.Errorf("expected Alpha as only backlink, got %+v", back)
+	}
+	html := string(body)
+	want := `<a href="/api/v1/notes/` + target.ID + `/html">the b note</a>`
+	if !strings.Contains(html, want) {
+		t.Errorf("missing resolved link %q", want)
+	}
+	if !strings.Contains(html, "Missing") || strings.Contains(html, "[[") {
+		t.Errorf("unresolved link should render as plain text")
+	}
+
+	resp = e.doJSON(t, "GET", "/api/v1/notes/"+model.NewID()+"/backlinks", nil, token)
+	resp.Body.Close()
+	if resp.StatusCode != http.StatusNotFound {
+		t.Errorf("unknown note: expected 404, got %d", resp.StatusCode)
+	}
+}
+
+func TestTodoDueQueries(t *testing.T) {
+	e := setup(t)
+	token, _ := e.registerAndLogin(t)
+
+	// Arrange: due today, in three days, in ten days, and without a due date
+	today := time.Now().UTC().Truncate(24 * time.Hour).Add(12 * time.Hour)
+	for _, td := range []struct {
+		content string
+		days    int
+	}{
+		{"today", 0}, {"soon", 3}, {"later", 10},
+	} {
+		due := today.AddDate(0, 0, td.days)
+		e.doJSON(t, "POST", "/api/v1/todos", model.CreateTodoRequest{
+			Content: td.content, DueDate: &due, DeviceID: "dev1",
+		}, token).Body.Close()
+	}
+	e.doJSON(t, "POST", "/api/v1/todos", model.CreateTodoRequest{Content: "undated", DeviceID: "dev1"}, token).Body.Close()
+	contents := func(todos []model.Todo) string {
+		var s []string
+		for _, td := range todos {
+			s = append(s, td.Content)
+		}
+		return strings.Join(s, ",")
+	}
+
+	// Act
+	var dayTodos, weekTodos []model.Todo
+	decodeBody(t, e.doJSON(t, "GET", "/api/v1/todos/today", nil, token), &dayTodos)
+	decodeBody(t, e.doJSON(t, "GET", "/api/v1/todos/week?tz=UTC", nil, token), &weekTodos)
+	var ranged model.TodoListResponse
+	before := today.AddDate(0, 0, 5).Format("2006-01-02")
+	decodeBody(t, e.doJSON(t, "GET", "/api/v1/todos?due_after="+today.Format(time.RFC3339)+"&due_before="+before, nil, token), &ranged)
+	bad := e.doJSON(t, "GET", "/api/v1/todos?due_before=tomorrow", nil, token)
+	bad.Body.Close()
+	badTZ := e.doJSON(t, "GET", "/api/v1/todos/today?tz=Nowhere/City", nil, token)
+	badTZ.Body.Close()
+
+	// Assert
+	t.Logf("today=%s week=%s range=%s (total %d)", contents(dayTodos), contents(weekTodos), contents(ranged.Todos), ranged.Total)
+	if contents(dayTodos) != "today" {
+		t.Errorf("today: got %q", contents(dayTodos))
+	}
+	if contents(weekTodos) != "today,soon" {
+		t.Errorf("week: got %q", contents(weekTodos))
+	}
+	if contents(ranged.Todos) != "today,soon" || ranged.Total != 2 {
+		t.Errorf("range: got %q (total %d)", contents(ranged.Todos), ranged.Total)
+	}
+	if bad.StatusCode != http.StatusBadRequest || badTZ.StatusCode != http.StatusBadRequest {
+		t.Errorf("expected 400 for bad bound and zone, got %d and %d", bad.StatusCode, badTZ.StatusCode)
+	}
+}
+
+func TestUserTimezone(t *testing.T) {
+	e := setup(t)
+	token, _ := e.registerAndLogin(t)
+
+	// Arrange: a todo due yesterday at UTC+14, which is today or later at
+	// UTC-12, since the two are 26 hours apart
+	ahead, _ := time.LoadLocation("Etc/GMT-14")
+	due := dateOf(time.Now(), ahead).AddDate(0, 0, -1)
+	e.doJSON(t, "POST", "/api/v1/todos", model.CreateTodoRequest{
+		Content: "edge", DueDate: &due, DeviceID: "dev1",
+	}, token).Body.Close()
+	overdue := func(path string, header string) int {
+		req, _ := http.NewRequest("GET", e.server.URL+path, nil)
+		req.Header.Set("Authorization", "Bearer "+token)
+		if header != "" {
+			req.Header.Set("X-Timezone", header)
+		}
+		resp, err := http.DefaultClient.Do(req)
+		if err != nil {
+			t.Fatalf("get overdue: %v", err)
+		}
+		var todos []model.Todo
+		decodeBody(t, resp, &todos)
+		return len(todos)
+	}
+
+	// Act
+	resp := e.doJSON(t, "PUT", "/api/v1/account/settings", model.AccountSettings{Timezone: "Etc/GMT-14"}, token)
+	resp.Body.Close()
+	var saved model.AccountSettings
+	decodeBody(t, e.doJSON(t, "GET", "/api/v1/account/settings", nil, token), &saved)
+	stored := overdue("/api/v1/todos/overdue", "")
+	header := overdue("/api/v1/todos/overdue", "Etc/GMT+12")
+	query := overdue("/api/v1/todos/overdue?tz=Etc/GMT%2B12", "Etc/GMT-14")
+	bad := e.doJSON(t, "PUT", "/api/v1/account/settings", model.AccountSettings{Timezone: "Mars/Olympus"}, token)
+	bad.Body.Close()
+
+	// Assert
+	t.Logf("due %s, settings %+v: overdue stored=%d header=%d query=%d, bad zone %d",
+		due.Format(time.DateOnly), saved, stored, header, query, bad.StatusCode)
+	if resp.StatusCode != http.StatusOK || saved.Timezone != "Etc/GMT-14" {
+		t.Errorf("settings not saved: %d %+v", resp.StatusCode, saved)
+	}
+	if stored != 1 {
+		t.Error("the stored zone should make the todo overdue")
+	}
+	if header != 0 || query != 0 {
+		t.Error("X-Timezone and tz should override the stored zone")
+	}
+	if bad.StatusCode != http.StatusBadRequest {
+		t.Errorf("unknown zone: expected 400, got %d", bad.StatusCode)
+	}
+}
+
+func TestTodoDueTimes(t *testing.T) {
+	e := setup(t)
+	token, _ := e.registerAndLogin(t)
+	create := func(content string, due time.Time, allDay *bool) model.Todo {
+		var td model.Todo
+		decodeBody(t, e.doJSON(t, "POST", "/api/v1/todos", model.CreateTodoRequest{
+			Content: content, DueDate: &due, AllDay: allDay, DeviceID: "dev1",
+		}, token), &td)
+		return td
+	}
+
+	// Arrange: a todo due a minute ago, one due today without a time, and
+	// on March 1st one all-day todo given as a time and one at 23:30 UTC,
+	// which is March 2nd at UTC+2
+	yes := true
+	past := create("past", time.Now().Add(-time.Minute), nil)
+	today := create("today", time.Now().UTC().Truncate(24*time.Hour), nil)
+	allDay := create("all day", time.Date(2099, 3, 1, 23, 30, 0, 0, time.FixedZone("EST", -5*3600)), &yes)
+	late := create("late", time.Date(2099, 3, 1, 23, 30, 0, 0, time.UTC), nil)
+
+	// Act
+	var overdue []model.Todo
+	decodeBody(t, e.doJSON(t, "GET", "/api/v1/todos/overdue?tz=UTC", nil, token), &overdue)
+	var cal model.CalendarResponse
+	decodeBody(t, e.doJSON(t, "GET", "/api/v1/todos/calendar?from=2099-03-01&to=2099-03-02&tz=Etc/GMT-2", nil, token), &cal)
+	var patched model.Todo
+	req, _ := http.NewRequest("PATCH", e.server.URL+"/api/v1/todos/"+today.ID,
+		strings.NewReader(`{"all_day": false, "device_id": "dev1"}`))
+	req.Header.Set("Authorization", "Bearer "+token)
+	resp, err := http.DefaultClient.Do(req)
+	if err != nil {
+		t.Fatalf("patch todo: %v", err)
+	}
+	decodeBody(t, resp, &patched)
+	ics := buildICal([]model.Todo{allDay, late}, false)
+
+	// Assert
+	t.Logf("past all_day=%v, today all_day=%v, all day due %v, late all_day=%v",
+		past.AllDay, today.AllDay, allDay.DueDate, late.AllDay)
+	if past.AllDay || !today.AllDay || !allDay.AllDay || late.AllDay {
+		t.Error("only due dates at midnight UTC or marked all_day should be all-day")
+	}
+	if want := time.Date(2099, 3, 1, 0, 0, 0, 0, time.UTC); !allDay.DueDate.Equal(want) {
+		t.Errorf("all-day due date: expected %v, got %v", want, allDay.DueDate)
+	}
+	for _, d := range cal.Days {
+		t.Logf("calendar %s: %d todos, first %q", d.Date, len(d.Todos), d.Todos[0].Content)
+	}
+	t.Logf("overdue: %d", len(overdue))
+	if len(overdue) != 1 || overdue[0].ID != past.ID {
+		t.Error("only the timed todo should be overdue; all-day todos last the day")
+	}
+	if len(cal.Days) != 2 || cal.Days[0].Todos[0].ID != allDay.ID || cal.Days[1].Todos[0].ID != late.ID {
+		t.Error("timed todos should fall on their local date")
+	}
+	t.Logf("patched: all_day=%v due %v", patched.AllDay, patched.DueDate)
+	if patched.AllDay || !patched.DueDate.Equal(*today.DueDate) {
+		t.Error("clearing all_day should keep the due date as a time")
+	}
+	t.Logf("ical:\n%s", ics)
+	if !strings.Contains(ics, "DTSTART;VALUE=DATE:20990301\r\n") || !strings.Contains(ics, "DTSTART:20990301T233000Z\r\n") {
+		t.Error("ical should have an all-day event and one at the due time")
+	}
+}
+
+func TestTodoListFilters(t *testing.T) {
+	e := setup(t)
+	token, _ := e.registerAndLogin(t)
+
+	// Arrange: on a note, an open todo due later and a done one due sooner;
+	// elsewhere an open undated todo
+	note := e.createNote(t, token, "Plan", "")
+	now := time.Now().UTC().Truncate(24 * time.Hour)
+	later, sooner := now.AddDate(0, 0, 5), now.AddDate(0, 0, 1)
+	var done model.Todo
+	e.doJSON(t, "POST", "/api/v1/todos", model.CreateTodoRequest{
+		Content: "later", NoteID: &note.ID, DueDate: &later, DeviceID: "dev1",
+	}, token).Body.Close()
+	decodeBody(t, e.doJSON(t, "POST", "/api/v1/todos", model.CreateTodoRequest{
+		Content: "sooner", NoteID: &note.ID, DueDate: &sooner, DeviceID: "dev1",
+	}, token), &done)
+	completed := true
+	e.doJSON(t, "PUT", "/api/v1/todos/"+done.ID, model.UpdateTodoRequest{Completed: &completed, DeviceID: "dev1"}, token).Body.Close()
+	e.doJSON(t, "POST", "/api/v1/todos", model.CreateTodoRequest{Content: "loose", DeviceID: "dev1"}, token).Body.Close()
+	list := func(query string) string {
+		var resp model.TodoListResponse
+		decodeBody(t, e.doJSON(t, "GET", "/api/v1/todos?"+query, nil, token), &resp)
+		var s []string
+		for _, td := range resp.Todos {
+			s = append(s, td.Content)
+		}
+		return strings.Join(s, ",")
+	}
+
+	// Act
+	got := map[string]string{}
+	for _, q := range []string{
+		"completed=false&sort=due",
+		"completed=true",
+		"note_id=" + note.ID + "&sort=due",
+		"sort=completed,due",
+		"sort=-due",
+	} {
+		got[q] = list(q)
+	}
+	badSort := e.doJSON(t, "GET", "/api/v1/todos?sort=priority", nil, token)
+	badSort.Body.Close()
+	badDone := e.doJSON(t, "GET", "/api/v1/todos?completed=maybe", nil, token)
+	badDone.Body.Close()
+
+	// Assert
+	want := map[string]string{
+		"completed=false&sort=due":         "later,loose",
+		"completed=true":                   "sooner",
+		"note_id=" + note.ID + "&sort=due": "sooner,later",
+		"sort=completed,due":               "later,loose,sooner",
+		"sort=-due":                        "later,sooner,loose",
+	}
+	for q, w := range want {
+		t.Logf("%s -> %s", q, got[q])
+		if got[q] != w {
+			t.Errorf("%s: got %q, want %q", q, got[q], w)
+		}
+	}
+	if badSort.StatusCode != http.StatusBadRequest || badDone.StatusCode != http.StatusBadRequest {
+		t.Errorf("expected 400 for bad sort and completed, got %d and %d", badSort.StatusCode, badDone.StatusCode)
+	}
+}
+
+func TestSearchTodos(t *testing.T) {
+	e := setup(t)
+	token, _ := e.registerAndLogin(t)
+
+	// Arrange: two milk todos, one done and one due tomorrow, and one other
+	tomorrow := time.Now().UTC().Truncate(24*time.Hour).AddDate(0, 0, 1)
+	var done model.Todo
+	decodeBody(t, e.doJSON(t, "POST", "/api/v1/todos", model.CreateTodoRequest{Content: "buy milk", DeviceID: "dev1"}, token), &done)
+	completed := true
+	e.doJSON(t, "PUT", "/api/v1/todos/"+done.ID, model.UpdateTodoRequest{Completed: &completed, DeviceID: "dev1"}, token).Body.Close()
+	e.doJSON(t, "POST", "/api/v1/todos", model.CreateTodoRequest{Content: "Milk the cow", DueDate: &tomorrow, DeviceID: "dev1"}, token).Body.Close()
+	e.doJSON(t, "POST", "/api/v1/todos", model.CreateTodoRequest{Content: "call bob", DeviceID: "dev1"}, token).Body.Close()
+	search := func(query string) (int, string) {
+		var resp model.TodoListResponse
+		decodeBody(t, e.doJSON(t, "GET", "/api/v1/todos/search?"+query, nil, token), &resp)
+		var s []string
+		for _, td := range resp.Todos {
+			s = append(s, td.Content)
+		}
+		return resp.Total, strings.Join(s, ",")
+	}
+
+	// Act
+	allTotal, all := search("q=milk&sort=created")
+	_, open := search("q=milk&completed=false")
+	_, dueSoon := search("q=milk&due_before=" + tomorrow.AddDate(0, 0, 1).Format("2006-01-02"))
+	noQuery := e.doJSON(t, "GET", "/api/v1/todos/search", nil, token)
+	noQuery.Body.Close()
+
+	// Assert
+	t.Logf("all=%q (%d) open=%q dueSoon=%q", all, allTotal, open, dueSoon)
+	if all != "buy milk,Milk the cow" || allTotal != 2 {
+		t.Errorf("q=milk: got %q (total %d), want both milk todos", all, allTotal)
+	}
+	if open != "Milk the cow" {
+		t.Errorf("completed=false: got %q", open)
+	}
+	if dueSoon != "Milk the cow" {
+		t.Errorf("due_before: got %q", dueSoon)
+	}
+	if noQuery.StatusCode != http.StatusBadRequest {
+		t.Errorf("missing q: got %d, want 400", noQuery.StatusCode)
+	}
+}
+
+func TestUnifiedSearch(t *testing.T) {
+	e := setup(t)
+	token, _ := e.registerAndLogin(t)
+
+	// Arrange: "milk" in a note body, a note title, an open and a done todo
+	body := e.createNote(t, token, "Shopping", "eggs, bread\nand milk for the cake")
+	title := e.createNote(t, token, "Milk prices", "")
+	var open, done model.Todo
+	decodeBody(t, e.doJSON(t, "POST", "/api/v1/todos", model.CreateTodoRequest{Content: "buy milk", NoteID: &body.ID, DeviceID: "dev1"}, token), &open)
+	decodeBody(t, e.doJSON(t, "POST", "/api/v1/todos", model.CreateTodoRequest{Content: "spilt milk", DeviceID: "dev1"}, token), &done)
+	completed := true
+	e.doJSON(t, "PUT", "/api/v1/todos/"+done.ID, model.UpdateTodoRequest{Completed: &completed, DeviceID: "dev1"}, token).Body.Close()
+	e.createNote(t, token, "Unrelated", "water")
+
+	// Act
+	var resp model.SearchResponse
+	decodeBody(t, e.doJSON(t, "GET", "/api/v1/search?q=MILK", nil, token), &resp)
+	var page model.SearchResponse
+	decodeBody(t, e.doJSON(t, "GET", "/api/v1/search?q=milk&limit=1&offset=3", nil, token), &page)
+	noQuery := e.doJSON(t, "GET", "/api/v1/search", nil, token)
+	noQuery.Body.Close()
+
+	// Assert
+	var got []string
+	for _, r := range resp.Results {
+		got = append(got, r.Type+":"+r.ID)
+		t.Logf("%s %s %q snippet=%q", r.Type, r.ID, r.Title, r.Snippet)
+	}
+	// Title matches and open todos first (created within the same
+	// millisecond they may tie), then note bodies, then done todos
+	want := []string{"note:" + title.ID, "todo:" + open.ID, "note:" + body.ID, "todo:" + done.ID}
+	if len(got) == 4 {
+		slices.Sort(got[:2])
+		slices.Sort(want[:2])
+	}
+	if !slices.Equal(got, want) || resp.Total != 4 {
+		t.Fatalf("results: got %v (total %d), want %v", got, resp.Total, want)
+	}
+	if r := resp.Results[2]; r.Snippet != "eggs, bread and milk for the cake" || r.Completed != nil {
+		t.Errorf("note result: got %+v", r)
+	}
+	if r := resp.Results[slices.IndexFunc(resp.Results, func(r model.SearchResult) bool { return r.ID == open.ID })]; r.NoteID == nil || *r.NoteID != body.ID || r.Completed == nil || *r.Completed {
+		t.Errorf("todo result: got %+v", r)
+	}
+	if len(page.Results) != 1 || page.Results[0].ID != done.ID || page.Total != 4 {
+		t.Errorf("page: got %+v", page)
+	}
+	if noQuery.StatusCode != http.StatusBadRequest {
+		t.Errorf("missing q: got %d, want 400", noQuery.StatusCode)
+	}
+}
+
+func TestSearchQuerySyntax(t *testing.T) {
+	e := setup(t)
+	token, _ := e.registerAndLogin(t)
+
+	// Arrange: a work note, a todo list, a work todo and a private todo
+	var work, list model.Note
+	decodeBody(t, e.doJSON(t, "POST", "/api/v1/notes", model.CreateNoteRequest{
+		Title: "Weekly plan", Content: "ship the release", Type: "note", Tags: []string{"Work"}, DeviceID: "dev1",
+	}, token), &work)
+	decodeBody(t, e.doJSON(t, "POST", "/api/v1/notes", model.CreateNoteRequest{
+		Title: "Plan groceries", Content: "milk", Type: "todo_list", DeviceID: "dev1",
+	}, token), &list)
+	var workTodo, home model.Todo
+	decodeBody(t, e.doJSON(t, "POST", "/api/v1/todos", model.CreateTodoRequest{
+		Content: "plan the release party", Tags: []string{"work"}, DeviceID: "dev1",
+	}, token), &workTodo)
+	decodeBody(t, e.doJSON(t, "POST", "/api/v1/todos", model.CreateTodoRequest{
+		Content: "plan holiday", DeviceID: "dev1",
+	}, token), &home)
+	search := func(q string) []string {
+		var resp model.SearchResponse
+		decodeBody(t, e.doJSON(t, "GET", "/api/v1/search?q="+url.QueryEscape(q), nil, token), &resp)
+		var ids []string
+		for _, r := range resp.Results {
+			ids = append(ids, r.ID)
+		}
+		slices.Sort(ids)
+		return ids
+	}
+	sorted := func(ids ...string) []string {
+		slices.Sort(ids)
+		return ids
+	}
+	tomorrow := time.Now().UTC().AddDate(0, 0, 1).Format("2006-01-02")
+
+	// Act
+	got := map[string][]string{}
+	for _, q := range []string{
+		"tag:work",
+		"plan -tag:work",
+		"type:todo_list",
+		"type:todo -holiday",
+		`"the release"`,
+		"title:plan",
+		"plan before:2000-01-01",
+		"plan after:2000-01-01 -type:note",
+		"release before:" + tomorrow,
+	} {
+		got[q] = search(q)
+	}
+	bad := e.doJSON(t, "GET", "/api/v1/search?q="+url.QueryEscape("-after:2026-01-01"), nil, token)
+	bad.Body.Close()
+	var valid, invalid model.SearchQueryCheck
+	decodeBody(t, e.doJSON(t, "GET", "/api/v1/search/validate?q="+url.QueryEscape(`tag:work -"a b"`), nil, token), &valid)
+	decodeBody(t, e.doJSON(t, "GET", "/api/v1/search/validate?q="+url.QueryEscape("type:page"), nil, token), &invalid)
+
+	// Assert
+	want := map[string][]string{
+		"tag:work":                         sorted(work.ID, workTodo.ID),
+		"plan -tag:work":                   sorted(list.ID, home.ID),
+		"type:todo_list":                   {list.ID},
+		"type:todo -holiday":               {workTodo.ID},
+		`"the release"`:                    sorted(work.ID, workTodo.ID),
+		"title:plan":                       sorted(work.ID, list.ID, workTodo.ID, home.ID),
+		"plan before:2000-01-01":           nil,
+		"plan after:2000-01-01 -type:note": sorted(workTodo.ID, home.ID),
+		"release before:" + tomorrow:       sorted(work.ID, workTodo.ID),
+	}
+	for q, w := range want {
+		t.Logf("%s -> %v", q, got[q])
+		if !slices.Equal(got[q], w) {
+			t.Errorf("%s: got %v, want %v", q, got[q], w)
+		}
+	}
+	if bad.StatusCode != http.StatusBadRequest {
+		t.Errorf("negated date: got %d, want 400", bad.StatusCode)
+	}
+	t.Logf("valid: %+v", valid.Terms)
+	t.Logf("invalid: %s", invalid.Error)
+	if !valid.Valid || len(valid.Terms) != 2 || !valid.Terms[1].Negated || valid.Terms[1].Value != "a b" || valid.Grammar == "" {
+		t.Errorf("valid query: got %+v", valid)
+	}
+	if invalid.Valid || !strings.Contains(invalid.Error, "page") || invalid.Terms != nil {
+		t.Errorf("invalid query: got %+v", invalid)
+	}
+}
+
+func TestJournal(t *testing.T) {
+	e := setup(t)
+	token, _ := e.registerAndLogin(t)
+	entry := func(method, date string) (int, model.Note) {
+		resp := e.doJSON(t, method, "/api/v1/journal/"+date, model.JournalEntryRequest{DeviceID: "dev1"}, token)
+		var n model.Note
+		if resp.StatusCode == http.StatusOK || resp.StatusCode == http.StatusCreated {
+			decodeBody(t, resp, &n)
+		} else {
+			resp.Body.Close()
+		}
+		return resp.StatusCode, n
+	}
+
+	// Arrange
+	missing, _ := entry("GET", "2026-03-05")
+	resp := e.doJSON(t, "PUT", "/api/v1/journal/settings", model.JournalSettings{
+		TitleFormat: "Journal %A %e %B %Y", Template: "# %a %d.%m.\n\n100%% %q",
+	}, token)
+	resp.Body.Close()
+
+	// Act
+	createStatus, created := entry("POST", "2026-03-05")
+	againStatus, again := entry("POST", "2026-03-05")
+	getStatus, got := entry("GET", "2026-03-05")
+	e.doJSON(t, "DELETE", "/api/v1/notes/"+created.ID, nil, token).Body.Close()
+	afterDeleteStatus, afterDelete := entry("GET", "2026-03-05")
+	recreateStatus, recreated := entry("POST", "2026-03-05")
+	badDate, _ := entry("POST", "2026-3-5")
+
+	// Assert
+	t.Logf("created %d %q %q", createStatus, created.Title, created.Content)
+	if missing != http.StatusNotFound {
+		t.Errorf("before creation: got %d, want 404", missing)
+	}
+	if resp.StatusCode != http.StatusOK {
+		t.Fatalf("save settings: got %d", resp.StatusCode)
+	}
+	if createStatus != http.StatusCreated || created.Title != "Journal Thursday 5 March 2026" || created.Content != "# Thu 05.03.\n\n100% %q" {
+		t.Errorf("create: got %d %+v", createStatus, created)
+	}
+	if againStatus != http.StatusOK || again.ID != created.ID || getStatus != http.StatusOK || got.ID != created.ID {
+		t.Errorf("existing entry: got %d %s and %d %s, want 200 %s", againStatus, again.ID, getStatus, got.ID, created.ID)
+	}
+	t.Logf("after delete: %d %s, recreated: %d %s", afterDeleteStatus, afterDelete.ID, recreateStatus, recreated.ID)
+	if afterDeleteStatus != http.StatusNotFound || recreateStatus != http.StatusCreated || recreated.ID == created.ID {
+		t.Errorf("deleted entry: expected 404 and a new note, got %d and %d %s", afterDeleteStatus, recreateStatus, recreated.ID)
+	}
+	if badDate != http.StatusBadRequest {
+		t.Errorf("bad date: got %d, want 400", badDate)
+	}
+}
+
+func TestBulkNotes(t *testing.T) {
+	e := setup(t)
+	token, _ := e.registerAndLogin(t)
+
+	// Arrange
+	var a, b model.Note
+	decodeBody(t, e.doJSON(t, "POST", "/api/v1/notes", model.CreateNoteRequest{
+		Title: "A", Type: "note", Tags: []string{"old", "keep"}, DeviceID: "dev1",
+	}, token), &a)
+	b = e.createNote(t, token, "B", "")
+	bulk := func(items ...model.BulkItem) (int, model.BulkResponse) {
+		var resp model.BulkResponse
+		r := e.doJSON(t, "POST", "/api/v1/notes/bulk", model.BulkRequest{Items: items, DeviceID: "dev1"}, token)
+		status := r.StatusCode
+		decodeBody(t, r, &resp)
+		return status, resp
+	}
+
+	// Act: a failing request, then a valid one
+	badStatus, bad := bulk(
+		model.BulkItem{ID: a.ID, Action: "tag", Tags: []string{"new"}},
+		model.BulkItem{ID: b.ID, Action: "archive"},
+		model.BulkItem{ID: "missing", Action: "delete"},
+	)
+	var unchanged model.Note
+	decodeBody(t, e.doJSON(t, "GET", "/api/v1/notes/"+a.ID, nil, token), &unchanged)
+	okStatus, ok := bulk(
+		model.BulkItem{ID: a.ID, Action: "tag", Tags: []string{"New"}},
+		model.BulkItem{ID: a.ID, Action: "untag", Tags: []string{"OLD"}},
+		model.BulkItem{ID: b.ID, Action: "delete"},
+	)
+	var tagged model.Note
+	decodeBody(t, e.doJSON(t, "GET", "/api/v1/notes/"+a.ID, nil, token), &tagged)
+	gone := e.doJSON(t, "GET", "/api/v1/notes/"+b.ID, nil, token)
+	gone.Body.Close()
+
+	// Assert
+	t.Logf("bad: %d %+v", badStatus, bad)
+	if badStatus != http.StatusBadRequest || bad.Applied || bad.Results[0].Error != "" ||
+		bad.Results[1].Error != `unknown action "archive"` || bad.Results[2].Error != "note not found" {
+		t.Errorf("failing request: got %d %+v", badStatus, bad)
+	}
+	if !slices.Equal(unchanged.Tags, []string{"keep", "old"}) {
+		t.Errorf("failing request changed tags: %v", unchanged.Tags)
+	}
+	t.Logf("ok: %d %+v, tags %v", okStatus, ok, tagged.Tags)
+	if okStatus != http.StatusOK || !ok.Applied || len(ok.Results) != 3 {
+		t.Errorf("valid request: got %d %+v", okStatus, ok)
+	}
+	if !slices.Equal(tagged.Tags, []string{"New", "keep"}) {
+		t.Errorf("tags: got %v, want [New keep]", tagged.Tags)
+	}
+	if gone.StatusCode != http.StatusNotFound {
+		t.Errorf("deleted note: got %d, want 404", gone.StatusCode)
+	}
+}
+
+func TestBulkTodos(t *testing.T) {
+	e := setup(t)
+	token, _ := e.registerAndLogin(t)
+
+	// Arrange: two todos on one note, another note to move them to
+	from := e.createNote(t, token, "From", "")
+	to := e.createNote(t, token, "To", "")
+	var x, y model.Todo
+	decodeBody(t, e.doJSON(t, "POST", "/api/v1/todos", model.CreateTodoRequest{Content: "x", NoteID: &from.ID, DeviceID: "dev1"}, token), &x)
+	decodeBody(t, e.doJSON(t, "POST", "/api/v1/todos", model.CreateTodoRequest{Content: "y", NoteID: &from.ID, DeviceID: "dev1"}, token), &y)
+	missing := "missing"
+	items := func(req model.BulkRequest) (int, model.BulkResponse) {
+		var resp model.BulkResponse
+		r := e.doJSON(t, "POST", "/api/v1/todos/bulk", req, token)
+		status := r.StatusCode
+		decodeBody(t, r, &resp)
+		return status, resp
+	}
+
+	// Act
+	badStatus, bad := items(model.BulkRequest{DeviceID: "dev1", Items: []model.BulkItem{
+		{ID: x.ID, Action: "move", NoteID: &missing},
+	}})
+	okStatus, ok := items(model.BulkRequest{DeviceID: "dev1", Items: []model.BulkItem{
+		{ID: x.ID, Action: "complete"},
+		{ID: x.ID, Action: "move", NoteID: &to.ID},
+		{ID: y.ID, Action: "tag", Tags: []string{"later"}},
+		{ID: y.ID, Action: "move"},
+	}})
+	var gotX, gotY model.Todo
+	decodeBody(t, e.doJSON(t, "GET", "/api/v1/todos/"+x.ID, nil, token), &gotX)
+	decodeBody(t, e.doJSON(t, "GET", "/api/v1/todos/"+y.ID, nil, token), &gotY)
+	delStatus, _ := items(model.BulkRequest{DeviceID: "dev1", Items: []model.BulkItem{
+		{ID: x.ID, Action: "delete"}, {ID: x.ID, Action: "reopen"},
+	}})
+
+	// Assert
+	t.Logf("bad: %d %+v", badStatus, bad)
+	if badStatus != http.StatusBadRequest || bad.Results[0].Error != "target note not found" {
+		t.Errorf("move to missing note: got %d %+v", badStatus, bad)
+	}
+	t.Logf("ok: %d, x=%+v y=%+v", okStatus, gotX, gotY)
+	if okStatus != http.StatusOK || !ok.Applied {
+		t.Fatalf("valid request: got %d %+v", okStatus, ok)
+	}
+	if !gotX.Completed || gotX.NoteID == nil || *gotX.NoteID != to.ID {
+		t.Errorf("x: expected completed and moved to %s, got %+v", to.ID, gotX)
+	}
+	if gotY.NoteID != nil || !slices.Equal(gotY.Tags, []string{"later"}) {
+		t.Errorf("y: expected detached and tagged, got %+v", gotY)
+	}
+	if delStatus != http.StatusBadRequest {
+		t.Errorf("action after delete: got %d, want 400", delStatus)
+	}
+}
+
+func TestMoveTodo(t *testing.T) {
+	e := setup(t)
+	token, _ := e.registerAndLogin(t)
+	otherToken, _ := e.registerAndLogin(t)
+
+	// Arrange: a todo on a note, a note to move it to, another user's note
+	from := e.createNote(t, token, "From", "")
+	to := e.createNote(t, token, "To", "- [ ] x")
+	foreign := e.createNote(t, otherToken, "Foreign", "")
+	var x model.Todo
+	decodeBody(t, e.doJSON(t, "POST", "/api/v1/todos", model.CreateTodoRequest{Content: "x", NoteID: &from.ID, DeviceID: "dev1"}, token), &x)
+	line := "L1"
+	move := func(req model.MoveTodoRequest) (*http.Response, model.Todo) {
+		var got model.Todo
+		r := e.doJSON(t, "POST", "/api/v1/todos/"+x.ID+"/move", req, token)
+		if r.StatusCode == http.StatusOK {
+			decodeBody(t, r, &got)
+		} else {
+			r.Body.Close()
+		}
+		return r, got
+	}
+
+	// Act
+	foreignResp, _ := move(model.MoveTodoRequest{NoteID: &foreign.ID, DeviceID: "dev1"})
+	noDevice, _ := move(model.MoveTodoRequest{NoteID: &to.ID})
+	okResp, moved := move(model.MoveTodoRequest{NoteID: &to.ID, LineRef: &line, DeviceID: "dev1"})
+	_, detached := move(model.MoveTodoRequest{DeviceID: "dev1"})
+
+	// Assert
+	if foreignResp.StatusCode != http.StatusBadRequest {
+		t.Errorf("move to another user's note: got %d, want 400", foreignResp.StatusCode)
+	}
+	if noDevice.StatusCode != http.StatusBadRequest {
+		t.Errorf("move without device_id: got %d, want 400", noDevice.StatusCode)
+	}
+	t.Logf("moved: %+v", moved)
+	if okResp.StatusCode != http.StatusOK || moved.NoteID == nil || *moved.NoteID != to.ID ||
+		moved.LineRef == nil || *moved.LineRef != line {
+		t.Errorf("expected todo moved to %s at %s, got %d %+v", to.ID, line, okResp.StatusCode, moved)
+	}
+	t.Logf("detached: %+v", detached)
+	if detached.NoteID != nil || detached.LineRef != nil {
+		t.Errorf("expected todo detached, got %+v", detached)
+	}
+}
+
+func TestListOrphanedTodos(t *testing.T) {
+	e := setup(t)
+	token, _ := e.registerAndLogin(t)
+
+	// Arrange: todos on a live note, on a deleted note and on none
+	live := e.createNote(t, token, "Live", "")
+	gone := e.createNote(t, token, "Gone", "")
+	for _, req := range []model.CreateTodoRequest{
+		{Content: "kept", NoteID: &live.ID, DeviceID: "dev1"},
+		{Content: "orphan", NoteID: &gone.ID, DeviceID: "dev1"},
+		{Content: "loose", DeviceID: "dev1"},
+	} {
+		e.doJSON(t, "POST", "/api/v1/todos", req, token).Body.Close()
+	}
+	e.doJSON(t, "DELETE", "/api/v1/notes/"+gone.ID, nil, token).Body.Close()
+
+	// Act
+	var list model.TodoListResponse
+	decodeBody(t, e.doJSON(t, "GET", "/api/v1/todos?orphaned=true", nil, token), &list)
+	bad := e.doJSON(t, "GET", "/api/v1/todos?orphaned=maybe", nil, token)
+	bad.Body.Close()
+
+	// Assert
+	t.Logf("orphaned: %+v", list.Todos)
+	if list.Total != 1 || len(list.Todos) != 1 || list.Todos[0].Content != "orphan" {
+		t.Errorf("expected only the todo of the deleted note, got %+v", list.Todos)
+	}
+	if bad.StatusCode != http.StatusBadRequest {
+		t.Errorf("orphaned=maybe: got %d, want 400", bad.StatusCode)
+	}
+}
+
+func TestDeleteNoteTodos(t *testing.T) {
+	e := setup(t)
+	token, _ := e.registerAndLogin(t)
+	e.api.config.Notes.DeleteTodos = "delete"
+
+	// Arrange: a todo on each of two notes
+	single := e.createNote(t, token, "Single", "")
+	bulkNote := e.createNote(t, token, "Bulk", "")
+	var a, b model.Todo
+	decodeBody(t, e.doJSON(t, "POST", "/api/v1/todos", model.CreateTodoRequest{Content: "a", NoteID: &single.ID, DeviceID: "dev1"}, token), &a)
+	decodeBody(t, e.doJSON(t, "POST", "/api/v1/todos", model.CreateTodoRequest{Content: "b", NoteID: &bulkNote.ID, DeviceID: "dev1"}, token), &b)
+
+	// Act: delete one note directly, then detach while deleting the other in bulk
+	e.doJSON(t, "DELETE", "/api/v1/notes/"+single.ID, nil, token).Body.Close()
+	e.api.config.Notes.DeleteTodos = "detach"
+	e.doJSON(t, "POST", "/api/v1/notes/bulk", model.BulkRequest{DeviceID: "dev1",
+		Items: []model.BulkItem{{ID: bulkNote.ID, Action: "delete"}}}, token).Body.Close()
+	gotA := e.doJSON(t, "GET", "/api/v1/todos/"+a.ID, nil, token)
+	gotA.Body.Close()
+	var gotB model.Todo
+	decodeBody(t, e.doJSON(t, "GET", "/api/v1/todos/"+b.ID, nil, token), &gotB)
+	var changes model.SyncChangesResponse
+	decodeBody(t, e.doJSON(t, "GET", "/api/v1/sync/changes?since=0", nil, token), &changes)
+
+	// Assert
+	t.Logf("a: %d, b: %+v, %d todo changes", gotA.StatusCode, gotB, len(changes.Todos))
+	if gotA.StatusCode != http.StatusNotFound {
+		t.Errorf("delete: expected the todo deleted with its note, got %d", gotA.StatusCode)
+	}
+	if gotB.NoteID != nil {
+		t.Errorf("detach: expected the todo attached to no note, got %+v", gotB)
+	}
+	for _, td := range changes.Todos {
+		if (td.ID == a.ID && td.DeletedAt == nil) || (td.ID == b.ID && td.NoteID != nil) {
+			t.Errorf("sync changes: expected the cascade, got %+v", td)
+		}
+	}
+}
+
+func TestSyncPushDeleteNoteTodos(t *testing.T) {
+	e := setup(t)
+	token, _ := e.registerAndLogin(t)
+	e.api.config.Notes.DeleteTodos = "delete"
+
+	// Arrange: a todo on a note, and a pull by another device after a
+	// phone deleted the note offline
+	note := e.createNote(t, token, "Trip", "")
+	var todo model.Todo
+	decodeBody(t, e.doJSON(t, "POST", "/api/v1/todos", model.CreateTodoRequest{Content: "pack", NoteID: &note.ID, DeviceID: "dev1"}, token), &todo)
+	deleted := note.ModifiedAt.Add(time.Second)
+	note.DeletedAt, note.ModifiedAt, note.ModifiedByDevice = &deleted, deleted, "phone"
+	var before model.SyncChangesResponse
+	decodeBody(t, e.doJSON(t, "GET", "/api/v1/sync/changes?since=0", nil, token), &before)
+
+	// Act
+	var pushed model.SyncPushResponse
+	decodeBody(t, e.doJSON(t, "POST", "/api/v1/sync/push", model.SyncPushRequest{DeviceID: "phone", Notes: []model.Note{note}}, token), &pushed)
+	got := e.doJSON(t, "GET", "/api/v1/todos/"+todo.ID, nil, token)
+	got.Body.Close()
+	var after model.SyncChangesResponse
+	decodeBody(t, e.doJSON(t, "GET", fmt.Sprintf("/api/v1/sync/changes?since=%d", before.SyncTimestamp), nil, token), &after)
+
+	// Assert
+	t.Logf("todo: %d, merged: %+v, pulled after: %+v", got.StatusCode, pushed.Merged, after.Todos)
+	if got.StatusCode != http.StatusNotFound {
+		t.Errorf("expected the todo deleted with its note, got %d", got.StatusCode)
+	}
+	if len(pushed.Merged) != 1 || pushed.Merged[0].ID != todo.ID || pushed.Merged[0].DeletedAt == nil {
+		t.Errorf("expected the deleted todo returned to the pushing device, got %+v", pushed.Merged)
+	}
+	if len(after.Todos) != 1 || after.Todos[0].DeletedAt == nil {
+		t.Errorf("expected other devices to pull the deleted todo, got %+v", after.Todos)
+	}
+}
+
+func TestPatchTodo(t *testing.T) {
+	e := setup(t)
+	token, _ := e.registerAndLogin(t)
+
+	// Arrange: a todo with a due date, a reminder and tags
+	due := time.Date(2026, 3, 14, 0, 0, 0, 0, time.UTC)
+	var todo model.Todo
+	decodeBody(t, e.doJSON(t, "POST", "/api/v1/todos", model.CreateTodoRequest{
+		Content: "Pay rent", DueDate: &due, ReminderAt: &due, Tags: []string{"home"}, DeviceID: "dev1",
+	}, token), &todo)
+
+	// Act: clear the due date, complete it, leave the rest alone
+	resp := e.doJSON(t, "PATCH", "/api/v1/todos/"+todo.ID, map[string]any{
+		"due_date": nil, "completed": true, "device_id": "dev2",
+	}, token)
+	var got model.Todo
+	decodeBody(t, resp, &got)
+
+	// Assert
+	t.Logf("patched: %d %+v", resp.StatusCode, got)
+	if resp.StatusCode != http.StatusOK {
+		t.Fatalf("expected 200, got %d", resp.StatusCode)
+	}
+	if got.DueDate != nil || !got.Completed || got.ModifiedByDevice != "dev2" {
+		t.Errorf("expected due date cleared and completed by dev2, got %+v", got)
+	}
+	if got.Content != "Pay rent" || got.ReminderAt == nil || !slices.Equal(got.Tags, []string{"home"}) {
+		t.Errorf("expected other fields kept, got %+v", got)
+	}
+}
+
+func TestTodoChecklist(t *testing.T) {
+	e := setup(t)
+	token, _ := e.registerAndLogin(t)
+	patch := func(id string, body any) (int, model.Todo, string) {
+		resp := e.doJSON(t, "PATCH", "/api/v1/todos/"+id, body, token)
+		var got struct {
+			model.Todo
+			Error string `json:"error"`
+		}
+		decodeBody(t, resp, &got)
+		return resp.StatusCode, got.Todo, got.Error
+	}
+
+	// Arrange: a todo with three checklist items, one of them done
+	var todo model.Todo
+	decodeBody(t, e.doJSON(t, "POST", "/api/v1/todos", model.CreateTodoRequest{
+		Content: "Pack", DeviceID: "dev1", Checklist: []model.ChecklistItem{
+			{Text: " passport "}, {Text: "charger", Done: true}, {ID: "socks", Text: "socks"},
+		},
+	}, token), &todo)
+
+	// Act: toggle the passport, remove the charger and add a towel
+	passport := todo.Checklist[0].ID
+	status, got, _ := patch(todo.ID, map[string]any{"device_id": "dev1", "checklist": map[string]any{
+		passport:             map[string]any{"done": true},
+		todo.Checklist[1].ID: nil,
+		"towel":              map[string]any{"text": "towel"},
+	}})
+	badStatus, _, badErr := patch(todo.ID, map[string]any{"device_id": "dev1", "checklist": map[string]any{
+		"new": map[string]any{"done": true}, "socks": map[string]any{"text": 5},
+	}})
+	var stored model.Todo
+	decodeBody(t, e.doJSON(t, "GET", "/api/v1/todos/"+todo.ID, nil, token), &stored)
+	_, cleared, _ := patch(todo.ID, map[string]any{"device_id": "dev1", "checklist": nil})
+
+	// Assert
+	t.Logf("created: %+v progress %v", todo.Checklist, *todo.Progress)
+	if len(todo.Checklist) != 3 || todo.Checklist[0].Text != "passport" || passport == "" ||
+		todo.Checklist[2].ID != "socks" || *todo.Progress != 33 {
+		t.Error("expected trimmed items with IDs and a third done")
+	}
+	t.Logf("patched: %d %+v progress %v", status, got.Checklist, got.Progress)
+	if status != http.StatusOK || len(got.Checklist) != 3 || !got.Checklist[0].Done ||
+		got.Checklist[1].ID != "socks" || got.Checklist[2].Text != "towel" || *got.Progress != 33 {
+		t.Error("expected passport done, charger gone and towel added last")
+	}
+	t.Logf("invalid: %d %q", badStatus, badErr)
+	if badStatus != http.StatusBadRequest || badErr != "checklist.socks.text: must be a string" {
+		t.Errorf("expected the item error, got %d %q", badStatus, badErr)
+	}
+	if len(stored.Checklist) != 3 || *stored.Progress != 33 {
+		t.Errorf("expected the stored checklist unchanged by the invalid patch, got %+v", stored.Checklist)
+	}
+	t.Logf("cleared: %+v progress %v", cleared.Checklist, cleared.Progress)
+	if len(cleared.Checklist) != 0 || cleared.Progress != nil {
+		t.Error("expected null to remove the checklist and its progress")
+	}
+}
+
+func TestPatchValidation(t *testing.T) {
+	e := setup(t)
+	token, _ := e.registerAndLogin(t)
+	note := e.createNote(t, token, "Title", "body")
+
+	cases := []struct {
+		name    string
+		patch   any
+		status  int
+		wantErr string
+	}{
+		{"not an object", []string{"title"}, http.StatusBadRequest, "body must be a JSON object"},
+		{"empty device", map[string]any{"title": "x", "device_id": ""}, http.StatusBadRequest, "device_id is required"},
+		{"field errors", map[string]any{
+			"title": nil, "type": "diary", "tags": "work", "created_at": "2026-01-01T00:00:00Z",
+			"color": "red", "device_id": "dev1",
+		}, http.StatusBadRequest, "title: cannot be null; type: must be 'note', 'todo_list' or 'bookmark'; " +
+			"tags: must be an array of strings or null; color: unknown field; created_at: cannot be changed"},
+		{"tags cleared", map[string]any{"tags": nil, "content": "new", "device_id": "dev1"}, http.StatusOK, ""},
+	}
+
+	for _, tc := range cases {
+		t.Run(tc.name, func(t *testing.T) {
+			// Act
+			resp := e.doJSON(t, "PATCH", "/api/v1/notes/"+note.ID, tc.patch, token)
+			var body struct {
+				Error   string   `json:"error"`
+				Title   string   `json:"title"`
+				Content string   `json:"content"`
+				Tags    []string `json:"tags"`
+			}
+			decodeBody(t, resp, &body)
+
+			// Assert
+			t.Logf("%s: %d %+v", tc.name, resp.StatusCode, body)
+			if resp.StatusCode != tc.status || body.Error != tc.wantErr {
+				t.Errorf("got %d %q, want %d %q", resp.StatusCode, body.Error, tc.status, tc.wantErr)
+			}
+			if tc.status == http.StatusOK && (body.Title != "Title" || body.Content != "new" || len(body.Tags) != 0) {
+				t.Errorf("expected title kept, content replaced and tags cleared, got %+v", body)
+			}
+		})
+	}
+}
+
+func TestCalendarFeed(t *testing.T) {
+	e := setup(t)
+	token, _ := e.registerAndLogin(t)
+
+	// Arrange: a todo with a reminder, and one without a due date
+	due := time.Date(2026, 3, 14, 0, 0, 0, 0, time.UTC)
+	remind := time.Date(2026, 3, 13, 17, 30, 0, 0, time.UTC)
+	e.doJSON(t, "POST", "/api/v1/todos", model.CreateTodoRequest{
+		Content: "Pay rent, then; relax", DueDate: &due, ReminderAt: &remind, DeviceID: "dev1",
+	}, token).Body.Close()
+	e.doJSON(t, "POST", "/api/v1/todos", model.CreateTodoRequest{Content: "undated", DeviceID: "dev1"}, token).Body.Close()
+	fetch := func(url string) (int, string) {
+		resp := e.doJSON(t, "GET", url, nil, "")
+		body, _ := io.ReadAll(resp.Body)
+		resp.Body.Close()
+		return resp.StatusCode, string(body)
+	}
+
+	// Act
+	none := e.doJSON(t, "GET", "/api/v1/todos/calendar/feed", nil, token)
+	none.Body.Close()
+	var feed model.CalendarFeed
+	decodeBody(t, e.doJSON(t, "POST", "/api/v1/todos/calendar/feed", nil, token), &feed)
+	status, ics := fetch(feed.URL)
+	_, noAlarms := fetch(feed.URL + "&alarms=false")
+	var rotated model.CalendarFeed
+	decodeBody(t, e.doJSON(t, "POST", "/api/v1/todos/calendar/feed", nil, token), &rotated)
+	oldStatus, _ := fetch(feed.URL)
+	e.doJSON(t, "DELETE", "/api/v1/todos/calendar/feed", nil, token).Body.Close()
+	revokedStatus, _ := fetch(rotated.URL)
+
+	// Assert
+	t.Logf("feed url=%s status=%d\n%s", feed.URL, status, ics)
+	if none.StatusCode != http.StatusNotFound {
+		t.Errorf("before creation: expected 404, got %d", none.StatusCode)
+	}
+	if status != http.StatusOK {
+		t.Fatalf("expected 200, got %d", status)
+	}
+	for _, want := range []string{
+		"BEGIN:VCALENDAR\r\n", "DTSTART;VALUE=DATE:20260314\r\n", "DTEND;VALUE=DATE:20260315\r\n",
+		`SUMMARY:Pay rent\, then\; relax`, "TRIGGER;VALUE=DATE-TIME:20260313T173000Z",
+	} {
+		if !strings.Contains(ics, want) {
+			t.Errorf("missing %q", want)
+		}
+	}
+	if strings.Contains(ics, "undated") || strings.Count(ics, "BEGIN:VEVENT") != 1 {
+		t.Errorf("expected exactly one event")
+	}
+	if strings.Contains(noAlarms, "VALARM") {
+		t.Errorf("alarms=false should omit VALARM")
+	}
+	if oldStatus != http.StatusUnauthorized || revokedStatus != http.StatusUnauthorized {
+		t.Errorf("rotated and revoked tokens should be rejected, got %d and %d", oldStatus, revokedStatus)
+	}
+}
+
+func TestMailIn(t *testing.T) {
+	e := setup(t)
+	token, _ := e.registerAndLogin(t)
+	e.api.config.MailIn = config.MailInConfig{Listen: "127.0.0.1:0", Domain: "in.example.com", MaxSize: 1 << 20}
+
+	// Arrange: the mail-in server on a local port and the user's address
+	ln, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatalf("listen: %v", err)
+	}
+	ctx, cancel := context.WithCancel(context.Background())
+	done := make(chan struct{})
+	go func() {
+		defer close(done)
+		e.api.ServeMailIn(ctx, ln)
+	}()
+	t.Cleanup(func() { cancel(); <-done })
+	var addr model.MailInAddress
+	decodeBody(t, e.doJSON(t, "POST", "/api/v1/mail-in", nil, token), &addr)
+	msg := "From: Ann <ann@example.com>\r\nSubject: Receipt\r\nContent-Type: multipart/mixed; boundary=b\r\n\r\n" +
+		"--b\r\nContent-Type: text/plain\r\n\r\nLunch, 12 EUR\r\n" +
+		"--b\r\nContent-Type: image/png\r\nContent-Disposition: attachment; filename=receipt.png\r\n" +
+		"Content-Transfer-Encoding: base64\r\n\r\niVBORw0KGgo=\r\n--b--\r\n"
+	send := func(to string) error {
+		return smtp.SendMail(ln.Addr().String(), nil, "ann@example.com", []string{to}, []byte(msg))
+	}
+
+	// Act
+	sendErr := send(strings.ToUpper(addr.Address[:5]) + addr.Address[5:])
+	unknownErr := send("nobody@in.example.com")
+	var list model.NoteListResponse
+	decodeBody(t, e.doJSON(t, "GET", "/api/v1/notes", nil, token), &list)
+	var atts []model.Attachment
+	if len(list.Notes) == 1 {
+		decodeBody(t, e.doJSON(t, "GET", "/api/v1/notes/"+list.Notes[0].ID+"/attachments", nil, token), &atts)
+	}
+	e.doJSON(t, "DELETE", "/api/v1/mail-in", nil, token).Body.Close()
+	revokedErr := send(addr.Address)
+
+	// Assert
+	t.Logf("address %s, send: %v, unknown: %v, revoked: %v", addr.Address, sendErr, unknownErr, revokedErr)
+	if !strings.HasSuffix(addr.Address, "@in.example.com") {
+		t.Errorf("unexpected address %q", addr.Address)
+	}
+	if sendErr != nil {
+		t.Fatalf("send: %v", sendErr)
+	}
+	if unknownErr == nil || revokedErr == nil {
+		t.Errorf("mail to unknown and revoked addresses should be refused")
+	}
+	if len(list.Notes) != 1 {
+		t.Fatalf("expected 1 note, got %d", len(list.Notes))
+	}
+	n := list.Notes[0]
+	t.Logf("note %q %q by %s, attachments %+v", n.Title, n.Content, n.ModifiedByDevice, atts)
+	if n.Title != "Receipt" || n.Content != "Lunch, 12 EUR\n" || n.ModifiedByDevice != mailInDevice {
+		t.Errorf("unexpected note %+v", n)
+	}
+	if len(atts) != 1 || atts[0].Filename != "receipt.png" || atts[0].ContentType != "image/png" || atts[0].Size != 8 {
+		t.Errorf("unexpected attachments %+v", atts)
+	}
+}
+
+func TestMailInDisabled(t *testing.T) {
+	e := setup(t)
+	token, _ := e.registerAndLogin(t)
+
+	// Act
+	resp := e.doJSON(t, "POST", "/api/v1/mail-in", nil, token)
+	resp.Body.Close()
+
+	// Assert
+	t.Logf("status %d", resp.StatusCode)
+	if resp.StatusCode != http.StatusNotFound {
+		t.Errorf("expected 404 while mail-in is off, got %d", resp.StatusCode)
+	}
+}
+
+type fakeTelegram struct {
+	chats []int64
+	texts []string
+}
+
+func (b *fakeTelegram) Updates(ctx context.Context, offset int64) ([]telegram.Update, error) {
+	<-ctx.Done()
+	return nil, ctx.Err()
+}
+
+func (b *fakeTelegram) Send(_ context.Context, chatID int64, text string) error {
+	b.chats = append(b.chats, chatID)
+	b.texts = append(b.texts, text)
+	return nil
+}
+
+// last returns the last message sent.
+func (b *fakeTelegram) last() string {
+	if len(b.texts) == 0 {
+		return ""
+	}
+	return b.texts[len(b.texts)-1]
+}
+
+func TestTelegram(t *testing.T) {
+	e := setup(t)
+	token, _ := e.registerAndLogin(t)
+	bot := &fakeTelegram{}
+	e.api.telegram = bot
+	ctx := context.Background()
+	const chat = 4242
+	say := func(text string) string {
+		e.api.handleTelegramMessage(ctx, chat, text)
+		return bot.last()
+	}
+
+	// Arrange: a Berlin account, a link code, an overdue todo and one with a reminder
+	e.doJSON(t, "PUT", "/api/v1/account/settings", model.AccountSettings{Timezone: "Europe/Berlin"}, token).Body.Close()
+	var link model.TelegramLink
+	decodeBody(t, e.doJSON(t, "POST", "/api/v1/telegram/link", nil, token), &link)
+	due := time.Now().AddDate(0, 0, -2).UTC().Truncate(24 * time.Hour)
+	remind := time.Now().Add(time.Minute)
+	e.doJSON(t, "POST", "/api/v1/todos", model.CreateTodoRequest{Content: "file taxes", DueDate: &due, DeviceID: "dev1"}, token).Body.Close()
+	e.doJSON(t, "POST", "/api/v1/todos", model.CreateTodoRequest{Content: "call mum", ReminderAt: &remind, DeviceID: "dev1"}, token).Body.Close()
+
+	// Act
+	unlinked := say("hello")
+	badCode := say("/start nope")
+	linked := say("/start " + strings.ToUpper(link.Code))
+	noteReply := say("Groceries\nmilk\neggs")
+	todoReply := say("/todo@notesd_bot water plants")
+	todos := say("/todos")
+	var status model.TelegramChat
+	decodeBody(t, e.doJSON(t, "GET", "/api/v1/telegram", nil, token), &status)
+	sent := len(bot.texts)
+	morning := time.Now().UTC().Truncate(24 * time.Hour).Add(8*time.Hour + 30*time.Minute) // 09:30 or 10:30 in Berlin
+	e.api.sendOverdueNotices(ctx, morning)
+	e.api.sendOverdueNotices(ctx, morning.Add(time.Hour))
+	overdue := bot.texts[sent:]
+	e.api.sendDueReminders(remind.Add(time.Second))
+	reminder := bot.last()
+	unlink := say("/unlink")
+	after := say("more")
+
+	// Assert
+	t.Logf("replies: %q", bot.texts)
+	if !strings.Contains(unlinked, "not linked") || !strings.Contains(badCode, "unknown") || !strings.Contains(linked, "Linked") {
+		t.Errorf("unexpected linking replies %q %q %q", unlinked, badCode, linked)
+	}
+	if status.LinkedAt.IsZero() {
+		t.Errorf("unexpected chat status %+v", status)
+	}
+	if noteReply != `Saved note "Groceries".` || todoReply != "Added todo." {
+		t.Errorf("unexpected replies %q %q", noteReply, todoReply)
+	}
+	var list model.NoteListResponse
+	decodeBody(t, e.doJSON(t, "GET", "/api/v1/notes", nil, token), &list)
+	if len(list.Notes) != 1 || list.Notes[0].Title != "Groceries" || list.Notes[0].Content != "milk\neggs\n" ||
+		list.Notes[0].ModifiedByDevice != telegramDevice {
+		t.Errorf("unexpected notes %+v", list.Notes)
+	}
+	if !strings.HasPrefix(todos, "Open todos (3):\n• file taxes (due ") || !strings.Contains(todos, "overdue)") ||
+		!strings.Contains(todos, "• water plants") {
+		t.Errorf("unexpected todo list %q", todos)
+	}
+	if len(overdue) != 1 || !strings.Contains(overdue[0], "file taxes") || strings.Contains(overdue[0], "water plants") {
+		t.Errorf("expected one overdue notice listing file taxes, got %q", overdue)
+	}
+	if reminder != "Reminder: call mum" {
+		t.Errorf("unexpected reminder %q", reminder)
+	}
+	if !strings.HasPrefix(unlink, "Unlinked") || !strings.Contains(after, "not linked") {
+		t.Errorf("unexpected replies after unlinking %q %q", unlink, after)
+	}
+	for _, c := range bot.chats {
+		if c != chat {
+			t.Errorf("message sent to chat %d", c)
+		}
+	}
+}
+
+func TestICalLineFolding(t *testing.T) {
+	// Arrange
+	line := "SUMMARY:" + strings.Repeat("ä", 100)
+
+	// Act
+	var b strings.Builder
+	icalLine(&b, line)
+
+	// Assert
+	lines := strings.Split(strings.TrimSuffix(b.String(), "\r\n"), "\r\n")
+	t.Logf("folded into %d lines", len(lines))
+	var joined strings.Builder
+	for i, l := range lines {
+		if len(l) > 75 {
+			t.Errorf("line %d is %d octets", i, len(l))
+		}
+		if i > 0 {
+			l = strings.TrimPrefix(l, " ")
+		}
+		joined.WriteString(l)
+	}
+	if joined.String() != line {
+		t.Errorf("unfolded line differs from input")
+	}
+}
+
+func TestExport(t *testing.T) {
+	e := setup(t)
+	token, _ := e.registerAndLogin(t)
+
+	// Arrange
+	n := e.createNote(t, token, `Trip: "Rome" 2026`, "# Plan\n\nsee the forum\n")
+	e.upload(t, token, n.ID, "map.png", []byte("png bytes")).Body.Close()
+	e.doJSON(t, "POST", "/api/v1/todos", model.CreateTodoRequest{
+		Content: "book hotel", NoteID: &n.ID, DeviceID: "dev1",
+	}, token).Body.Close()
+
+	// Act
+	resp := e.doJSON(t, "GET", "/api/v1/export", nil, token)
+	zipped, _ := io.ReadAll(resp.Body)
+	resp.Body.Close()
+	resp = e.doJSON(t, "GET", "/api/v1/export?format=tar.gz", nil, token)
+	gz, err := gzip.NewReader(resp.Body)
+	if err != nil {
+		t.Fatalf("gzip: %v", err)
+	}
+	var tarNames []string
+	tr := tar.NewReader(gz)
+	for {
+		hdr, err := tr.Next()
+		if err == io.EOF {
+			break
+		}
+		if err != nil {
+			t.Fatalf("tar: %v", err)
+		}
+		tarNames = append(tarNames, hdr.Name)
+	}
+	resp.Body.Close()
+
+	// Assert
+	zr, err := zip.NewReader(bytes.NewReader(zipped), int64(len(zipped)))
+	if err != nil {
+		t.Fatalf("zip: %v", err)
+	}
+	files := map[string]string{}
+	for _, f := range zr.File {
+		rc, _ := f.Open()
+		b, _ := io.ReadAll(rc)
+		rc.Close()
+		files[f.Name] = string(b)
+	}
+	t.Logf("zip entries: %d, tar entries: %v", len(files), tarNames)
+	var manifest model.ExportManifest
+	if err := json.Unmarshal([]byte(files["manifest.json"]), &manifest); err != nil {
+		t.Fatalf("manifest: %v", err)
+	}
+	if manifest.Format != "notesd-export" || len(manifest.Notes) != 1 || len(manifest.Attachments) != 1 {
+		t.Fatalf("unexpected manifest %+v", manifest)
+	}
+	md := files[manifest.Notes[0].File]
+	t.Logf("%s:\n%s", manifest.Notes[0].File, md)
+	if manifest.Notes[0].File != "notes/Trip-Rome-2026-"+n.ID[:8]+".md" {
+		t.Errorf("note file name: %q", manifest.Notes[0].File)
+	}
+	if !strings.HasPrefix(md, "---\nid: \""+n.ID+"\"\ntitle: \"Trip: \\\"Rome\\\" 2026\"\n") ||
+		!strings.HasSuffix(md, "---\n# Plan\n\nsee the forum\n") {
+		t.Errorf("unexpected note markdown")
+	}
+	if files[manifest.Attachments[0].File] != "png bytes" || !strings.HasSuffix(manifest.Attachments[0].File, "/map.png") {
+		t.Errorf("attachment %q not exported", manifest.Attachments[0].File)
+	}
+	var todos []model.Todo
+	if err := json.Unmarshal([]byte(files["todos.json"]), &todos); err != nil || len(todos) != 1 {
+		t.Errorf("todos.json: %v, %d todos", err, len(todos))
+	}
+	if len(tarNames) != len(files) {
+		t.Errorf("tar.gz has %d entries, zip %d", len(tarNames), len(files))
+	}
+}
+
+func (e *testEnv) postImport(t *testing.T, token, format string, body []byte) *http.Response {
+	t.Helper()
+	req, _ := http.NewRequest("POST", e.server.URL+"/api/v1/import?format="+format, bytes.NewReader(body))
+	req.Header.Set("Authorization", "Bearer "+token)
+	resp, err := http.DefaultClient.Do(req)
+	if err != nil {
+		t.Fatalf("post import: %v", err)
+	}
+	return resp
+}
+
+func TestImportNotesdExport(t *testing.T) {
+	e := setup(t)
+	tokenA, _ := e.registerAndLogin(t)
+	tokenB, _ := e.registerAndLogin(t)
+
+	// Arrange: export an account with a note, a tagged todo and a file
+	n := e.createNote(t, tokenA, "Trip", "# Plan\n\nsee [[Packing]]\n")
+	e.doJSON(t, "PUT", "/api/v1/notes/"+n.ID, model.UpdateNoteRequest{
+		Tags: []string{"travel"}, DeviceID: "dev1",
+	}, tokenA).Body.Close()
+	e.upload(t, tokenA, n.ID, "map.png", []byte("png bytes")).Body.Close()
+	e.doJSON(t, "POST", "/api/v1/todos", model.CreateTodoRequest{
+		Content: "book hotel", NoteID: &n.ID, DeviceID: "dev1",
+	}, tokenA).Body.Close()
+	resp := e.doJSON(t, "GET", "/api/v1/export", nil, tokenA)
+	archive, _ := io.ReadAll(resp.Body)
+	resp.Body.Close()
+
+	// Act: import into another account, twice
+	var first, second model.ImportSummary
+	decodeBody(t, e.postImport(t, tokenB, "notesd", archive), &first)
+	decodeBody(t, e.postImport(t, tokenB, "notesd", archive), &second)
+
+	// Assert
+	t.Logf("first import: %+v, second: %+v", first, second)
+	if first != (model.ImportSummary{Notes: 1, Todos: 1, Attachments: 1}) {
+		t.Errorf("first import: %+v", first)
+	}
+	if second != (model.ImportSummary{Skipped: 2}) {
+		t.Errorf("re-import should skip everything, got %+v", second)
+	}
+	var list model.NoteListResponse
+	decodeBody(t, e.doJSON(t, "GET", "/api/v1/notes", nil, tokenB), &list)
+	notes := list.Notes
+	if len(notes) != 1 {
+		t.Fatalf("expected 1 note, got %d", len(notes))
+	}
+	got := notes[0]
+	t.Logf("imported note: id=%s title=%q tags=%v created=%v", got.ID, got.Title, got.Tags, got.CreatedAt)
+	if got.ID == n.ID || got.Content != n.Content || !slices.Equal(got.Tags, []string{"travel"}) ||
+		!got.CreatedAt.Equal(n.CreatedAt) {
+		t.Errorf("note not imported faithfully: %+v", got)
+	}
+	var todoList model.TodoListResponse
+	decodeBody(t, e.doJSON(t, "GET", "/api/v1/todos", nil, tokenB), &todoList)
+	todos := todoList.Todos
+	if len(todos) != 1 || todos[0].NoteID == nil || *todos[0].NoteID != got.ID {
+		t.Errorf("todo not attached to the imported note: %+v", todos)
+	}
+	var atts []model.Attachment
+	decodeBody(t, e.doJSON(t, "GET", "/api/v1/notes/"+got.ID+"/attachments", nil, tokenB), &atts)
+	if len(atts) != 1 || atts[0].Filename != "map.png" || atts[0].Size != int64(len("png bytes")) {
+		t.Errorf("attachment not imported: %+v", atts)
+	}
+}
+
+func TestImportENEXAndMarkdown(t *testing.T) {
+	e := setup(t)
+	token, _ := e.registerAndLogin(t)
+
+	// Arrange
+	enex := `<?xml version="1.0" encoding="UTF-8"?>
+<!DOCTYPE en-export SYSTEM "http://xml.evernote.com/pub/evernote-export4.dtd">
+<en-export export-date="20260301T120000Z" application="Evernote">
+  <note>
+    <title>Shopping</title>
+    <content><![CDATA[<?xml version="1.0" encoding="UTF-8"?><!DOCTYPE en-note SYSTEM "http://xml.evernote.com/pub/enml2.dtd"><en-note><div>Weekly&nbsp;list</div><div><en-todo checked="true"/>milk</div><div><en-todo/>eggs</div><en-media type="image/png" hash="abc"/></en-note>]]></content>
+    <created>20250102T080910Z</created>
+    <tag>home</tag>
+    <tag>errands</tag>
+    <resource>
+      <data encoding="base64">cG5n
+IGJ5dGVz</data>
+      <mime>image/png</mime>
+      <resource-attributes><file-name>list.png</file-name></resource-attributes>
+    </resource>
+  </note>
+</en-export>`
+	var zipped bytes.Buffer
+	zw := zip.NewWriter(&zipped)
+	for name, content := range map[string]string{
+		"vault/Ideas.md":            "# Ideas\n\nmore soon\n",
+		"vault/Daily.md":            "---\ntitle: \"Daily log\"\ntags: [work, \"#journal\"]\ncreated: 2024-05-06\n---\nfirst entry\n",
+		"vault/.obsidian/app.md":    "hidden",
+		"__MACOSX/vault/._Ideas.md": "junk",
+		"vault/photo.jpg":           "jpeg",
+	} {
+		w, _ := zw.Create(name)
+		w.Write([]byte(content))
+	}
+	zw.Close()
+
+	// Act
+	var fromENEX, fromMarkdown model.ImportSummary
+	decodeBody(t, e.postImport(t, token, "enex", []byte(enex)), &fromENEX)
+	decodeBody(t, e.postImport(t, token, "markdown", zipped.Bytes()), &fromMarkdown)
+	bad := e.postImport(t, token, "markdown", []byte("not a zip"))
+	bad.Body.Close()
+
+	// Assert
+	t.Logf("enex: %+v, markdown: %+v, bad zip: %d", fromENEX, fromMarkdown, bad.StatusCode)
+	if fromENEX != (model.ImportSummary{Notes: 1, Attachments: 1}) {
+		t.Errorf("enex import: %+v", fromENEX)
+	}
+	if fromMarkdown != (model.ImportSummary{Notes: 2}) {
+		t.Errorf("markdown import: %+v", fromMarkdown)
+	}
+	if bad.StatusCode != http.StatusBadRequest {
+		t.Errorf("bad zip: status %d, want 400", bad.StatusCode)
+	}
+	var list model.NoteListResponse
+	decodeBody(t, e.doJSON(t, "GET", "/api/v1/notes", nil, token), &list)
+	byTitle := map[string]model.Note{}
+	for _, n := range list.Notes {
+		t.Logf("note %q tags=%v created=%v:\n%s", n.Title, n.Tags, n.CreatedAt, n.Content)
+		byTitle[n.Title] = n
+	}
+	shop := byTitle["Shopping"]
+	if shop.Content != "Weekly list\n\n- [x] milk\n\n- [ ] eggs" ||
+		!slices.Equal(shop.Tags, []string{"errands", "home"}) ||
+		!shop.CreatedAt.Equal(time.Date(2025, 1, 2, 8, 9, 10, 0, time.UTC)) {
+		t.Errorf("enex note: %+v", shop)
+	}
+	daily := byTitle["Daily log"]
+	if daily.Content != "first entry\n" || !slices.Equal(daily.Tags, []string{"journal", "work"}) ||
+		!daily.CreatedAt.Equal(time.Date(2024, 5, 6, 0, 0, 0, 0, time.UTC)) {
+		t.Errorf("markdown note with front matter: %+v", daily)
+	}
+	if ideas := byTitle["Ideas"]; ideas.Content != "# Ideas\n\nmore soon\n" || time.Since(ideas.CreatedAt) > time.Minute {
+		t.Errorf("markdown note titled by file name: %+v", byTitle["Ideas"])
+	}
+}
+
+func TestENMLToMarkdown(t *testing.T) {
+	cases := []struct{ enml, want string }{
+		{`<en-note><h2>Title</h2><div>Some <b>bold</b> and <i>italic</i> text.</div></en-note>`,
+			"## Title\n\nSome **bold** and *italic* text."},
+		{`<en-note><ul><li><div>one</div></li><li>two<ol><li>nested</li></ol></li></ul></en-note>`,
+			"- one\n- two\n  1. nested"},
+		{`<en-note><div>see <a href="https://example.com">the site</a><br/>next</div></en-note>`,
+			"see [the site](https://example.com)\nnext"},
+		{`<en-note><pre>a  b
+c</pre><en-crypt cipher="AES">secret</en-crypt><hr/>end</en-note>`,
+			"```\na  b\nc\n```\n\n---\n\nend"},
+	}
+	for _, c := range cases {
+		// Act
+		got := enmlToMarkdown(c.enml)
+
+		// Assert
+		t.Logf("%s\n=> %q", c.enml, got)
+		if got != c.want {
+			t.Errorf("got %q, want %q", got, c.want)
+		}
+	}
+}
+
+func TestOpenAPICoversRoutes(t *testing.T) {
+	// Arrange: the registered patterns, read from Routes
+	src, err := os.ReadFile("api.go")
+	if err != nil {
+		t.Fatalf("read api.go: %v", err)
+	}
+	registered := map[string]bool{}
+	for _, line := range strings.Split(string(src), "\n") {
+		if _, rest, ok := strings.Cut(line, `mux.HandleFunc("`); ok {
+			pattern, _, _ := strings.Cut(rest, `"`)
+			registered[pattern] = true
+		}
+	}
+	documented := map[string]bool{}
+	for _, op := range apiOperations {
+		documented[op.pattern] = true
+	}
+
+	// Assert
+	t.Logf("%d routes registered, %d documented", len(registered), len(documented))
+	for p := range registered {
+		if !documented[p] {
+			t.Errorf("route %q is missing from apiOperations", p)
+		}
+	}
+	for p := range documented {
+		if !registered[p] {
+			t.Errorf("apiOperations documents unknown route %q", p)
+		}
+	}
+	for _, routes := range []map[string]bool{longRoutes, streamRoutes} {
+		for p := range routes {
+			if !registered[p] {
+				t.Errorf("timeouts name unknown route %q", p)
+			}
+		}
+	}
+}
+
+func TestOpenAPIDocument(t *testing.T) {
+	e := setup(t)
+
+	// Act
+	resp := e.doJSON(t, "GET", "/api/v1/openapi.json", nil, "")
+	raw, _ := io.ReadAll(resp.Body)
+	resp.Body.Close()
+	docs := e.doJSON(t, "GET", "/api/docs", nil, "")
+	docs.Body.Close()
+
+	e.api.config.Server.SwaggerUI = true
+	srv := httptest.NewServer(e.api.Routes())
+	defer srv.Close()
+	withUI, err := http.Get(srv.URL + "/api/docs")
+	if err != nil {
+		t.Fatalf("get docs: %v", err)
+	}
+	page, _ := io.ReadAll(withUI.Body)
+	withUI.Body.Close()
+
+	// Assert
+	var doc struct {
+		OpenAPI    string                               `json:"openapi"`
+		Paths      map[string]map[string]map[string]any `json:"paths"`
+		Components struct {
+			Schemas map[string]struct {
+				Properties map[string]any `json:"properties"`
+				Required   []string       `json:"required"`
+			} `json:"schemas"`
+		} `json:"components"`
+	}
+	if err := json.Unmarshal(raw, &doc); err != nil {
+		t.Fatalf("decode document: %v", err)
+	}
+	t.Logf("openapi %s: %d paths, %d schemas, %d bytes", doc.OpenAPI, len(doc.Paths), len(doc.Components.Schemas), len(raw))
+	if doc.OpenAPI != "3.1.0" || doc.Paths["/api/v1/notes/{id}"]["put"] == nil {
+		t.Fatalf("unexpected document")
+	}
+	note := doc.Components.Schemas["Note"]
+	t.Logf("Note properties: %d, required: %v", len(note.Properties), note.Required)
+	if note.Properties["snoozed_until"] == nil || slices.Contains(note.Required, "snoozed_until") ||
+		!slices.Contains(note.Required, "content_hash") {
+		t.Errorf("Note schema does not follow the json tags")
+	}
+	b := &schemaBuilder{components: map[string]any{}}
+	b.schema(reflect.TypeOf(model.ExportedAttachment{}))
+	flat := b.components["ExportedAttachment"].(map[string]any)["properties"].(map[string]any)
+	if len(flat) != len(doc.Components.Schemas["Attachment"].Properties)+1 || flat["file"] == nil {
+		t.Errorf("embedded Attachment not flattened into ExportedAttachment: %v", flat)
+	}
+	for _, ref := range regexp.MustCompile(`"#/components/schemas/([A-Za-z]+)"`).FindAllStringSubmatch(string(raw), -1) {
+		if _, ok := doc.Components.Schemas[ref[1]]; !ok {
+			t.Errorf("dangling reference to %s", ref[1])
+		}
+	}
+	t.Logf("swagger ui: off=%d on=%d", docs.StatusCode, withUI.StatusCode)
+	if docs.StatusCode != http.StatusNotFound || withUI.StatusCode != http.StatusOK ||
+		!strings.Contains(string(page), "/api/v1/openapi.json") {
+		t.Errorf("swagger ui should only be served when enabled")
+	}
+}
+
+func TestWebUI(t *testing.T) {
+	// Arrange
+	e := setup(t)
+	off := e.doJSON(t, "GET", "/", nil, "")
+	off.Body.Close()
+	e.api.config.Server.WebUI = true
+	srv := httptest.NewServer(e.api.Routes())
+	defer srv.Close()
+
+	cases := []struct {
+		path, contentType string
+		status            int
+	}{
+		{"/", "text/html", http.StatusOK},
+		{"/app.js", "text/javascript", http.StatusOK},
+		{"/style.css", "text/css", http.StatusOK},
+		{"/missing.js", "", http.StatusNotFound},
+		{"/api/v1/health", "application/json", http.StatusOK},
+	}
+	for _, c := range cases {
+		t.Run(c.path, func(t *testing.T) {
+			// Act
+			resp, err := http.Get(srv.URL + c.path)
+			if err != nil {
+				t.Fatalf("get %s: %v", c.path, err)
+			}
+			body, _ := io.ReadAll(resp.Body)
+			resp.Body.Close()
+
+			// Assert
+			ct := resp.Header.Get("Content-Type")
+			t.Logf("GET %s: %d %s, %d bytes, csp=%q", c.path, resp.StatusCode, ct, len(body), resp.Header.Get("Content-Security-Policy"))
+			if resp.StatusCode != c.status || !strings.HasPrefix(ct, c.contentType) {
+				t.Errorf("expected %d %s", c.status, c.contentType)
+			}
+		})
+	}
+	t.Logf("GET / with web_ui off: %d", off.StatusCode)
+	if off.StatusCode != http.StatusNotFound {
+		t.Errorf("expected 404 with web_ui off, got %d", off.StatusCode)
+	}
+	page, err := http.Get(srv.URL + "/")
+	if err != nil {
+		t.Fatalf("get /: %v", err)
+	}
+	page.Body.Close()
+	if csp := page.Header.Get("Content-Security-Policy"); !strings.Contains(csp, "default-src 'self'") {
+		t.Errorf("expected a same-origin CSP on the page, got %q", csp)
+	}
+}
+
+func TestTracingSyncPush(t *testing.T) {
+	// Arrange
+	e := setup(t)
+	token, user := e.registerAndLogin(t)
+	type span struct {
+		TraceID      string `json:"traceId"`
+		SpanID       string `json:"spanId"`
+		ParentSpanID string `json:"parentSpanId"`
+		Name         string `json:"name"`
+	}
+	var mu sync.Mutex
+	var spans []span
+	collector := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		var req struct {
+			ResourceSpans []struct {
+				ScopeSpans []struct {
+					Spans []span `json:"spans"`
+				} `json:"scopeSpans"`
+			} `json:"resourceSpans"`
+		}
+		json.NewDecoder(r.Body).Decode(&req)
+		mu.Lock()
+		defer mu.Unlock()
+		for _, rs := range req.ResourceSpans {
+			for _, ss := range rs.ScopeSpans {
+				spans = append(spans, ss.Spans...)
+			}
+		}
+	}))
+	defer collector.Close()
+	tracer := trace.Init(trace.Config{Endpoint: collector.URL, SampleRatio: 1})
+
+	now := model.NowMillis()
+	body, _ := json.Marshal(model.SyncPushRequest{
+		Notes: []model.Note{{
+			ID: model.NewID(), UserID: user.ID, Title: "Traced", Content: "push",
+			Type: "note", ModifiedAt: now, ModifiedByDevice: "phone", CreatedAt: now,
+		}},
+		DeviceID: "phone",
+	})
+	req, _ := http.NewRequest("POST", e.server.URL+"/api/v1/sync/push", bytes.NewReader(body))
+	req.Header.Set("Authorization", "Bearer "+token)
+	req.Header.Set("Content-Type", "application/json")
+	req.Header.Set("traceparent", "00-4bf92f3577b34da6a3ce929d0e0e4736-00f067aa0ba902b7-01")
+
+	// Act
+	resp, err := http.DefaultClient.Do(req)
+	if err != nil {
+		t.Fatalf("push: %v", err)
+	}
+	resp.Body.Close()
+	if err := tracer.Shutdown(context.Background()); err != nil {
+		t.Fatalf("flush traces: %v", err)
+	}
+
+	// Assert
+	mu.Lock()
+	defer mu.Unlock()
+	byID := map[string]span{}
+	var server span
+	for _, s := range spans {
+		t.Logf("span %-28s %s parent=%s", s.Name, s.SpanID, s.ParentSpanID)
+		byID[s.SpanID] = s
+		if s.Name == "POST /api/v1/sync/push" {
+			server = s
+		}
+	}
+	if resp.StatusCode != http.StatusOK {
+		t.Fatalf("push status %d", resp.StatusCode)
+	}
+	if server.ParentSpanID != "00f067aa0ba902b7" || server.TraceID != "4bf92f3577b34da6a3ce929d0e0e4736" {
+		t.Fatalf("server span %+v does not continue the client's trace", server)
+	}
+	inserts := 0
+	for _, s := range spans {
+		if s.Name != "INSERT" {
+			continue
+		}
+		// Walk up to the server span.
+		p := s
+		for p.SpanID != server.SpanID && p.ParentSpanID != "" {
+			p = byID[p.ParentSpanID]
+		}
+		if p.SpanID != server.SpanID || s.TraceID != server.TraceID {
+			t.Errorf("INSERT span %s is not below the request span", s.SpanID)
+		}
+		inserts++
+	}
+	if inserts == 0 {
+		t.Errorf("no INSERT spans recorded")
+	}
+}
+
+func TestAdminBackup(t *testing.T) {
+	e := setup(t)
+	token, user := e.registerAndLogin(t)
+	e.createNote(t, token, "Backed up", "content")
+	dir := t.TempDir()
+	e.api.config.Backup = config.BackupConfig{Dir: dir, Keep: 2}
+	os.WriteFile(filepath.Join(dir, "README"), []byte("not a backup"), 0600)
+
+	// Act / Assert: non-admins are refused
+	resp := e.doJSON(t, "POST", "/api/v1/admin/backup", nil, token)
+	resp.Body.Close()
+	t.Logf("non-admin status: %d", resp.StatusCode)
+	if resp.StatusCode != http.StatusForbidden {
+		t.Fatalf("expected 403 for non-admin, got %d", resp.StatusCode)
+	}
+
+	// Arrange
+	e.api.config.Admin.Emails = []string{user.Email}
+
+	// Act
+	var backups []model.Backup
+	for range 3 {
+		resp := e.doJSON(t, "POST", "/api/v1/admin/backup", nil, token)
+		if resp.StatusCode != http.StatusCreated {
+			t.Fatalf("expected 201, got %d", resp.StatusCode)
+		}
+		var b model.Backup
+		decodeBody(t, resp, &b)
+		backups = append(backups, b)
+		time.Sleep(2 * time.Millisecond)
+	}
+
+	// Assert
+	entries, _ := os.ReadDir(dir)
+	var names []string
+	for _, de := range entries {
+		names = append(names, de.Name())
+	}
+	t.Logf("backups: %+v", backups)
+	t.Logf("backup dir: %v", names)
+	want := []string{"README", backups[1].Name, backups[2].Name}
+	if !slices.Equal(names, want) {
+		t.Errorf("backup dir holds %v, want %v", names, want)
+	}
+	snap, err := database.Open(filepath.Join(dir, backups[2].Name))
+	if err != nil {
+		t.Fatalf("open snapshot: %v", err)
+	}
+	defer snap.Close()
+	notes, err := snap.GetAllNotes(user.ID)
+	if err != nil || len(notes) != 1 || notes[0].Title != "Backed up" {
+		t.Errorf("snapshot notes: %v, %v", notes, err)
+	}
+}
+
+func TestAdminMaintenance(t *testing.T) {
+	e := setup(t)
+	token, user := e.registerAndLogin(t)
+	e.createNote(t, token, "Maintained", "content")
+	e.api.config.Admin.Emails = []string{user.Email}
+
+	// Act: nothing to report before the first run
+	resp := e.doJSON(t, "GET", "/api/v1/admin/maintenance", nil, token)
+	resp.Body.Close()
+	t.Logf("before first run: %d", resp.StatusCode)
+	if resp.StatusCode != http.StatusNotFound {
+		t.Fatalf("expected 404 before the first run, got %d", resp.StatusCode)
+	}
+
+	// Act
+	resp = e.doJSON(t, "POST", "/api/v1/admin/maintenance", nil, token)
+	if resp.StatusCode != http.StatusOK {
+		t.Fatalf("expected 200, got %d", resp.StatusCode)
+	}
+	var m model.Maintenance
+	decodeBody(t, resp, &m)
+	resp = e.doJSON(t, "GET", "/api/v1/admin/maintenance", nil, token)
+	var last model.Maintenance
+	decodeBody(t, resp, &last)
+	resp, err := http.Get(e.server.URL + "/api/v1/health")
+	if err != nil {
+		t.Fatalf("health check: %v", err)
+	}
+	var health map[string]any
+	decodeBody(t, resp, &health)
+
+	// Assert
+	t.Logf("maintenance: %+v", m)
+	t.Logf("health: %v", health)
+	if !m.OK || m.Error != "" || !slices.Equal(m.Integrity, []string{"ok"}) {
+		t.Errorf("maintenance report %+v, want a sound database", m)
+	}
+	if !last.StartedAt.Equal(m.StartedAt) {
+		t.Errorf("last run started %v, want %v", last.StartedAt, m.StartedAt)
+	}
+	db, _ := health["database"].(map[string]any)
+	if health["status"] != "ok" || db["integrity"] != "ok" {
+		t.Errorf("health = %v, want ok with integrity ok", health)
+	}
+
+	// Arrange: a run that found corruption
+	e.api.lastMaintenance.Store(&model.Maintenance{StartedAt: time.Now(), Integrity: []string{"row 1 missing from index"}})
+
+	// Act
+	resp, err = http.Get(e.server.URL + "/api/v1/health")
+	if err != nil {
+		t.Fatalf("health check: %v", err)
+	}
+	decodeBody(t, resp, &health)
+
+	// Assert
+	t.Logf("degraded health: %d %v", resp.StatusCode, health)
+	if resp.StatusCode != http.StatusServiceUnavailable || health["status"] != "degraded" {
+		t.Errorf("expected 503 degraded, got %d %v", resp.StatusCode, health["status"])
+	}
+}
+
+func TestRegistrationInvites(t *testing.T) {
+	e := setup(t)
+	token, admin := e.registerAndLogin(t)
+	e.api.config.Admin.Emails = []string{admin.Email}
+	register := func(email, code string) int {
+		resp := e.doJSON(t, "POST", "/api/v1/auth/register", model.RegisterRequest{
+			Email: email, Password: "testpass1234", DisplayName: "New", InviteCode: code,
+		}, "")
+		resp.Body.Close()
+		return resp.StatusCode
+	}
+	createInvite := func(req model.CreateInviteRequest) model.Invite {
+		resp := e.doJSON(t, "POST", "/api/v1/admin/invites", req, token)
+		if resp.StatusCode != http.StatusCreated {
+			t.Fatalf("create invite: expected 201, got %d", resp.StatusCode)
+		}
+		var inv model.Invite
+		decodeBody(t, resp, &inv)
+		return inv
+	}
+
+	// Arrange: closed registration
+	e.api.config.Auth.Registration = "closed"
+
+	// Act
+	closed := register("closed@example.com", "")
+	adminAgain := register(strings.ToUpper(admin.Email), "")
+
+	// Assert: closed for everyone but admins, who get the usual conflict
+	t.Logf("closed: stranger %d, admin %d", closed, adminAgain)
+	if closed != http.StatusForbidden || adminAgain != http.StatusConflict {
+		t.Errorf("expected 403 for a stranger and 409 for the existing admin")
+	}
+
+	// Arrange: invite-only with a single-use and an expiring multi-use code
+	e.api.config.Auth.Registration = "invite"
+	two := 2
+	single := createInvite(model.CreateInviteRequest{})
+	multi := createInvite(model.CreateInviteRequest{MaxUses: &two})
+	soon := model.NowMillis().Add(50 * time.Millisecond)
+	expiring := createInvite(model.CreateInviteRequest{MaxUses: new(int), ExpiresAt: &soon})
+
+	// Act
+	statuses := map[string]int{
+		"no code":      register("a@example.com", ""),
+		"bad code":     register("b@example.com", "nope"),
+		"single":       register("c@example.com", single.Code),
+		"single again": register("d@example.com", single.Code),
+		"taken email":  register("c@example.com", multi.Code),
+		"multi 1":      register("e@example.com", multi.Code),
+		"multi 2":      register("f@example.com", multi.Code),
+		"multi 3":      register("g@example.com", multi.Code),
+	}
+	time.Sleep(60 * time.Millisecond)
+	statuses["expired"] = register("h@example.com", expiring.Code)
+
+	// Assert
+	t.Logf("statuses: %v", statuses)
+	want := map[string]int{
+		"no code": http.StatusForbidden, "bad code": http.StatusForbidden,
+		"single": http.StatusCreated, "single again": http.StatusForbidden,
+		"taken email": http.StatusConflict,
+		"multi 1":     http.StatusCreated, "multi 2": http.StatusCreated, "multi 3": http.StatusForbidden,
+		"expired": http.StatusForbidden,
+	}
+	for k, v := range want {
+		if statuses[k] != v {
+			t.Errorf("%s: expected %d, got %d", k, v, statuses[k])
+		}
+	}
+
+	resp := e.doJSON(t, "GET", "/api/v1/admin/invites", nil, token)
+	var invites []model.Invite
+	decodeBody(t, resp, &invites)
+	t.Logf("invites: %+v", invites)
+	if len(invites) != 3 || invites[0].Code != expiring.Code || invites[1].Uses != 2 || invites[2].Uses != 1 {
+		t.Errorf("unexpected invite list: %+v", invites)
+	}
+}
+
+func TestQuotas(t *testing.T) {
+	e := setup(t)
+	token, user := e.registerAndLogin(t)
+	e.api.config.Quota = config.QuotaConfig{Notes: 2, Todos: 1, ContentBytes: 30, AttachmentBytes: 10}
+	status := func(method, path string, body any) int {
+		resp := e.doJSON(t, method, path, body, token)
+		resp.Body.Close()
+		return resp.StatusCode
+	}
+	long, short := strings.Repeat("x", 40), "shorter"
+
+	// Arrange: two notes use up the note quota
+	first := e.createNote(t, token, "a", "1234567890")
+	e.createNote(t, token, "b", "")
+	now := model.NowMillis()
+
+	// Act
+	got := map[string]int{
+		"third note":  status("POST", "/api/v1/notes", model.CreateNoteRequest{Title: "c", DeviceID: "dev1"}),
+		"grow note":   status("PUT", "/api/v1/notes/"+first.ID, model.UpdateNoteRequest{Content: &long, DeviceID: "dev1"}),
+		"shrink note": status("PUT", "/api/v1/notes/"+first.ID, model.UpdateNoteRequest{Content: &short, DeviceID: "dev1"}),
+		"first todo":  status("POST", "/api/v1/todos", model.CreateTodoRequest{Content: "t", DeviceID: "dev1"}),
+		"second todo": status("POST", "/api/v1/todos", model.CreateTodoRequest{Content: "u", DeviceID: "dev1"}),
+		"push note": status("POST", "/api/v1/sync/push", model.SyncPushRequest{DeviceID: "phone", Notes: []model.Note{{
+			ID: model.NewID(), Title: "d", Type: "note", ModifiedAt: now, ModifiedByDevice: "phone", CreatedAt: now,
+		}}}),
+		"big attachment": func() int {
+			resp := e.upload(t, token, first.ID, "big.bin", make([]byte, 11))
+			resp.Body.Close()
+			return resp.StatusCode
+		}(),
+		"delete note": status("DELETE", "/api/v1/notes/"+first.ID, nil),
+		"note again":  status("POST", "/api/v1/notes", model.CreateNoteRequest{Title: "e", DeviceID: "dev1"}),
+	}
+
+	// Assert
+	t.Logf("statuses: %v", got)
+	want := map[string]int{
+		"third note": http.StatusForbidden, "grow note": http.StatusForbidden, "shrink note": http.StatusOK,
+		"first todo": http.StatusCreated, "second todo": http.StatusForbidden,
+		"push note": http.StatusForbidden, "big attachment": http.StatusForbidden,
+		"delete note": http.StatusNoContent, "note again": http.StatusCreated,
+	}
+	for k, v := range want {
+		if got[k] != v {
+			t.Errorf("%s: expected %d, got %d", k, v, got[k])
+		}
+	}
+	if entries, _ := os.ReadDir(e.api.config.Attachments.Dir); len(entries) != 0 {
+		t.Errorf("rejected attachment left %d files behind", len(entries))
+	}
+
+	var report model.UsageReport
+	decodeBody(t, e.doJSON(t, "GET", "/api/v1/usage", nil, token), &report)
+	t.Logf("usage for %s: %+v", user.ID, report)
+	wantUsed := model.Usage{Notes: 2, Todos: 1, ContentBytes: int64(len("b") + len("e") + len("t"))}
+	if report.Used != wantUsed || report.Limits.Notes != 2 || report.Limits.AttachmentBytes != 10 {
+		t.Errorf("usage: got %+v, want used %+v", report, wantUsed)
+	}
+}
+
+func TestStatistics(t *testing.T) {
+	// Arrange
+	e := setup(t)
+	token, _ := e.registerAndLogin(t)
+	otherToken, _ := e.registerAndLogin(t)
+	for _, req := range []model.CreateNoteRequest{
+		{Title: "Plan", Content: "one two three", Type: "note", Tags: []string{"work", "home"}},
+		{Title: "Log", Content: "four five", Type: "note", Tags: []string{"work"}},
+		{Title: "Groceries", Content: "milk", Type: "todo_list"},
+	} {
+		req.DeviceID = "dev1"
+		e.doJSON(t, "POST", "/api/v1/notes", req, token).Body.Close()
+	}
+	gone := e.createNote(t, token, "Gone", "not counted at all")
+	e.doJSON(t, "DELETE", "/api/v1/notes/"+gone.ID, nil, token).Body.Close()
+	e.createNote(t, otherToken, "Theirs", "someone else's words")
+	past := time.Now().Add(-48 * time.Hour)
+	var done model.Todo
+	decodeBody(t, e.doJSON(t, "POST", "/api/v1/todos", model.CreateTodoRequest{Content: "done", Tags: []string{"work"}, DeviceID: "dev1"}, token), &done)
+	completed := true
+	e.doJSON(t, "PUT", "/api/v1/todos/"+done.ID, model.UpdateTodoRequest{Completed: &completed, DeviceID: "dev1"}, token).Body.Close()
+	e.doJSON(t, "POST", "/api/v1/todos", model.CreateTodoRequest{Content: "late", DueDate: &past, DeviceID: "dev1"}, token).Body.Close()
+	e.doJSON(t, "POST", "/api/v1/todos", model.CreateTodoRequest{Content: "someday", DeviceID: "dev1"}, token).Body.Close()
+
+	// Act
+	var st model.Statistics
+	decodeBody(t, e.doJSON(t, "GET", "/api/v1/stats?weeks=3&tags=1", nil, token), &st)
+
+	// Assert
+	t.Logf("stats: %+v", st)
+	if st.NotesByType["note"] != 2 || st.NotesByType["todo_list"] != 1 || len(st.NotesByType) != 2 {
+		t.Errorf("notes by type: got %v", st.NotesByType)
+	}
+	if st.Todos != (model.TodoCounts{Open: 2, Completed: 1, Overdue: 1}) {
+		t.Errorf("todos: got %+v", st.Todos)
+	}
+	wantBytes := int64(len("Planone two threeLogfour fiveGroceriesmilk" + "donelatesomeday"))
+	if st.ContentBytes != wantBytes {
+		t.Errorf("content bytes: got %d, want %d", st.ContentBytes, wantBytes)
+	}
+	if len(st.WordsPerWeek) != 3 || st.WordsPerWeek[2].Words != 9 || st.WordsPerWeek[0].Words != 0 {
+		t.Errorf("words per week: got %+v, want 3 weeks ending with 9 words", st.WordsPerWeek)
+	}
+	if monday, err := time.Parse(time.DateOnly, st.WordsPerWeek[2].Week); err != nil || monday.Weekday() != time.Monday {
+		t.Errorf("week %q does not start on a Monday", st.WordsPerWeek[2].Week)
+	}
+	if len(st.TopTags) != 1 || st.TopTags[0] != (model.TagUsage{Name: "work", Notes: 2, Todos: 1}) {
+		t.Errorf("top tags: got %+v", st.TopTags)
+	}
+}
+
+func TestTodoSummary(t *testing.T) {
+	// Arrange: completions yesterday and two, three, five and forty days
+	// ago, none yet today
+	e := setup(t)
+	token, user := e.registerAndLogin(t)
+	now := model.NowMillis()
+	ago := func(days int) time.Time { return now.AddDate(0, 0, -days) }
+	dueAgo := func(days int) *time.Time { d := ago(days); return &d }
+	for _, c := range []struct {
+		doneAgo int
+		due     *time.Time
+		deleted bool
+	}{
+		{doneAgo: 1, due: dueAgo(1)},
+		{doneAgo: 2, due: dueAgo(4)},
+		{doneAgo: 3},
+		{doneAgo: 5},
+		{doneAgo: 40, due: dueAgo(50)},
+		{doneAgo: 4, deleted: true},
+	} {
+		td := &model.Todo{
+			ID: model.NewID(), UserID: user.ID, Content: "done", Completed: true, DueDate: c.due,
+			ModifiedAt: ago(c.doneAgo), CreatedAt: ago(60), ModifiedByDevice: "dev1",
+		}
+		if c.deleted {
+			td.DeletedAt = &td.ModifiedAt
+		}
+		if err := e.db.CreateTodo(td); err != nil {
+			t.Fatalf("create todo: %v", err)
+		}
+	}
+	e.doJSON(t, "POST", "/api/v1/todos", model.CreateTodoRequest{Content: "open", DeviceID: "dev1"}, token).Body.Close()
+
+	// Act
+	var before model.TodoSummary
+	decodeBody(t, e.doJSON(t, "GET", "/api/v1/todos/summary", nil, token), &before)
+	var today model.Todo
+	decodeBody(t, e.doJSON(t, "POST", "/api/v1/todos", model.CreateTodoRequest{Content: "today", DeviceID: "dev1"}, token), &today)
+	done := true
+	e.doJSON(t, "PUT", "/api/v1/todos/"+today.ID, model.UpdateTodoRequest{Completed: &done, DeviceID: "dev1"}, token).Body.Close()
+	var after model.TodoSummary
+	decodeBody(t, e.doJSON(t, "GET", "/api/v1/todos/summary?days=7", nil, token), &after)
+	badTZ := e.doJSON(t, "GET", "/api/v1/todos/summary?tz=Mars/Olympus", nil, token)
+	badTZ.Body.Close()
+
+	// Assert
+	t.Logf("before: completed=%d streak=%d on_time=%d late=%d avg=%v last days=%v",
+		before.Completed, before.CurrentStreak, before.OnTime, before.Late, before.AvgDaysFromDue, before.Days[len(before.Days)-6:])
+	if len(before.Days) != 30 || before.Days[29].Date != now.Format(time.DateOnly) {
+		t.Fatalf("expected 30 days ending today, got %d ending %+v", len(before.Days), before.Days[len(before.Days)-1])
+	}
+	if before.Completed != 4 || before.Days[28].Count != 1 || before.Days[29].Count != 0 || before.Days[26].Count != 1 {
+		t.Errorf("completions: got %d, days %v", before.Completed, before.Days[24:])
+	}
+	if before.CurrentStreak != 3 {
+		t.Errorf("streak without a completion today: got %d, want 3", before.CurrentStreak)
+	}
+	if before.OnTime != 1 || before.Late != 1 || before.AvgDaysFromDue == nil || *before.AvgDaysFromDue != 1 {
+		t.Errorf("due dates: on_time=%d late=%d avg=%v, want 1, 1 and 1", before.OnTime, before.Late, before.AvgDaysFromDue)
+	}
+	t.Logf("after: completed=%d streak=%d days=%v", after.Completed, after.CurrentStreak, after.Days)
+	if len(after.Days) != 7 || after.Days[6].Count != 1 || after.CurrentStreak != 4 {
+		t.Errorf("expected today's completion to extend the streak to 4")
+	}
+	if badTZ.StatusCode != http.StatusBadRequest {
+		t.Errorf("unknown time zone: got %d", badTZ.StatusCode)
+	}
+}
+
+func TestAccountExportAndDelete(t *testing.T) {
+	// Arrange: a second account whose data must survive
+	e := setup(t)
+	token, user := e.registerAndLogin(t)
+	otherToken, _ := e.registerAndLogin(t)
+	note := e.createNote(t, token, "Mine", "body")
+	e.upload(t, token, note.ID, "a.txt", []byte("hello")).Body.Close()
+	e.doJSON(t, "POST", "/api/v1/todos", model.CreateTodoRequest{Content: "todo", Tags: []string{"work"}, DeviceID: "dev1"}, token).Body.Close()
+	other := e.createNote(t, otherToken, "Theirs", "")
+
+	// Act: export
+	var exp model.AccountExport
+	decodeBody(t, e.doJSON(t, "GET", "/api/v1/account/export", nil, token), &exp)
+	t.Logf("export: user=%s notes=%d todos=%d attachments=%d tags=%d",
+		exp.User.Email, len(exp.Notes), len(exp.Todos), len(exp.Attachments), len(exp.Tags))
+
+	// Assert
+	if exp.Format != "notesd-account" || exp.User.ID != user.ID {
+		t.Errorf("export header: got %q for %s", exp.Format, exp.User.ID)
+	}
+	if len(exp.Notes) != 1 || len(exp.Todos) != 1 || len(exp.Attachments) != 1 || len(exp.Tags) != 1 {
+		t.Errorf("export contents: %+v", exp)
+	}
+
+	// Act: wrong password, then the right one
+	wrong := e.doJSON(t, "DELETE", "/api/v1/account", model.DeleteAccountRequest{Password: "nope"}, token)
+	wrong.Body.Close()
+	resp := e.doJSON(t, "DELETE", "/api/v1/account", model.DeleteAccountRequest{Password: "testpass1234"}, token)
+	resp.Body.Close()
+
+	// Assert
+	if wrong.StatusCode != http.StatusForbidden {
+		t.Errorf("wrong password: expected 403, got %d", wrong.StatusCode)
+	}
+	if resp.StatusCode != http.StatusNoContent {
+		t.Fatalf("delete account: expected 204, got %d", resp.StatusCode)
+	}
+	login := e.doJSON(t, "POST", "/api/v1/auth/login", model.LoginRequest{
+		Email: user.Email, Password: "testpass1234", DeviceID: "test-device",
+	}, "")
+	login.Body.Close()
+	if login.StatusCode != http.StatusUnauthorized {
+		t.Errorf("login after delete: expected 401, got %d", login.StatusCode)
+	}
+	if _, err := e.db.GetNote(note.ID, user.ID); !errors.Is(err, database.ErrNotFound) {
+		t.Errorf("note after delete: expected ErrNotFound, got %v", err)
+	}
+	if entries, _ := os.ReadDir(e.api.config.Attachments.Dir); len(entries) != 0 {
+		t.Errorf("attachment files left behind: %d", len(entries))
+	}
+	if _, err := e.db.GetNote(other.ID, other.UserID); err != nil {
+		t.Errorf("other user's note: %v", err)
+	}
+}
+
+func TestAuditLog(t *testing.T) {
+	// Arrange: a note created, edited and deleted, and a todo pushed by sync
+	e := setup(t)
+	token, user := e.registerAndLogin(t)
+	note := e.createNote(t, token, "Audited", "v1")
+	v2 := "v2"
+	e.doJSON(t, "PUT", "/api/v1/notes/"+note.ID, model.UpdateNoteRequest{Content: &v2, DeviceID: "dev1"}, token).Body.Close()
+	e.doJSON(t, "DELETE", "/api/v1/notes/"+note.ID, nil, token).Body.Close()
+	now := model.NowMillis()
+	todoID := model.NewID()
+	e.doJSON(t, "POST", "/api/v1/sync/push", model.SyncPushRequest{DeviceID: "phone", Todos: []model.Todo{{
+		ID: todoID, Content: "pushed", ModifiedAt: now, ModifiedByDevice: "phone", CreatedAt: now,
+	}}}, token).Body.Close()
+
+	// Act / Assert: non-admins are refused
+	resp := e.doJSON(t, "GET", "/api/v1/admin/audit", nil, token)
+	resp.Body.Close()
+	if resp.StatusCode != http.StatusForbidden {
+		t.Fatalf("expected 403 for non-admin, got %d", resp.StatusCode)
+	}
+
+	// Act
+	e.api.config.Admin.Emails = []string{user.Email}
+	var noteLog, todoLog, all model.AuditListResponse
+	decodeBody(t, e.doJSON(t, "GET", "/api/v1/admin/audit?entity_id="+note.ID, nil, token), &noteLog)
+	decodeBody(t, e.doJSON(t, "GET", "/api/v1/admin/audit?entity_type=todo&device_id=phone", nil, token), &todoLog)
+	decodeBody(t, e.doJSON(t, "GET", "/api/v1/admin/audit?user_id="+user.ID+"&limit=1", nil, token), &all)
+	bad := e.doJSON(t, "GET", "/api/v1/admin/audit?since=yesterday", nil, token)
+	bad.Body.Close()
+
+	// Assert
+	var actions []string
+	for _, en := range noteLog.Entries {
+		actions = append(actions, en.Action)
+	}
+	t.Logf("note actions: %v, todo entries: %+v, total: %d", actions, todoLog.Entries, all.Total)
+	if strings.Join(actions, ",") != "delete,update,create" {
+		t.Errorf("note actions: got %v, want newest first delete,update,create", actions)
+	}
+	if len(todoLog.Entries) != 1 || todoLog.Entries[0].EntityID != todoID || todoLog.Entries[0].Action != "create" {
+		t.Errorf("todo entries: got %+v", todoLog.Entries)
+	}
+	if all.Total != 4 || len(all.Entries) != 1 {
+		t.Errorf("user entries: got total %d with %d entries", all.Total, len(all.Entries))
+	}
+	if bad.StatusCode != http.StatusBadRequest {
+		t.Errorf("bad since: expected 400, got %d", bad.StatusCode)
+	}
+
+	// Act: retention
+	e.api.auditRetention = time.Hour
+	e.api.pruneAuditLog(time.Now().Add(2 * time.Hour))
+
+	// Assert
+	var pruned model.AuditListResponse
+	decodeBody(t, e.doJSON(t, "GET", "/api/v1/admin/audit", nil, token), &pruned)
+	if pruned.Total != 0 {
+		t.Errorf("after retention: expected 0 entries, got %d", pruned.Total)
+	}
+}
+
+func TestAuditBulkChanges(t *testing.T) {
+	// Arrange: a tagged note with a todo attached
+	e := setup(t)
+	token, user := e.registerAndLogin(t)
+	e.api.config.Admin.Emails = []string{user.Email}
+	var note model.Note
+	decodeBody(t, e.doJSON(t, "POST", "/api/v1/notes", model.CreateNoteRequest{
+		Title: "Tagged", Tags: []string{"work"}, DeviceID: "dev1",
+	}, token), &note)
+	var todo model.Todo
+	decodeBody(t, e.doJSON(t, "POST", "/api/v1/todos", model.CreateTodoRequest{
+		Content: "attached", NoteID: &note.ID, DeviceID: "dev1",
+	}, token), &todo)
+
+	// Act: rename the tag, then purge the note
+	e.doJSON(t, "POST", "/api/v1/tags/work/rename", model.RenameTagRequest{Name: "job"}, token).Body.Close()
+	e.doJSON(t, "DELETE", "/api/v1/notes/"+note.ID+"?purge=true", nil, token).Body.Close()
+	var noteLog, todoLog model.AuditListResponse
+	decodeBody(t, e.doJSON(t, "GET", "/api/v1/admin/audit?entity_id="+note.ID, nil, token), &noteLog)
+	decodeBody(t, e.doJSON(t, "GET", "/api/v1/admin/audit?entity_id="+todo.ID, nil, token), &todoLog)
+
+	// Assert
+	actions := func(l model.AuditListResponse) string {
+		var out []string
+		for _, en := range l.Entries {
+			out = append(out, en.Action)
+		}
+		return strings.Join(out, ",")
+	}
+	t.Logf("note: %s, todo: %s", actions(noteLog), actions(todoLog))
+	if got := actions(noteLog); got != "purge,update,create" {
+		t.Errorf("note actions: got %s, want purge,update,create", got)
+	}
+	if got := actions(todoLog); got != "update,create" {
+		t.Errorf("todo actions: got %s, want update,create", got)
+	}
+}
+
+func TestActivity(t *testing.T) {
+	// Arrange: a note and a todo changed by the user, and another user's note
+	e := setup(t)
+	token, _ := e.registerAndLogin(t)
+	note := e.createNote(t, token, "Plan", "")
+	var todo model.Todo
+	decodeBody(t, e.doJSON(t, "POST", "/api/v1/todos", model.CreateTodoRequest{Content: "Call", DeviceID: "dev1"}, token), &todo)
+	completed := true
+	e.doJSON(t, "PUT", "/api/v1/todos/"+todo.ID, model.UpdateTodoRequest{Completed: &completed, DeviceID: "dev1"}, token).Body.Close()
+	e.doJSON(t, "DELETE", "/api/v1/notes/"+note.ID, nil, token).Body.Close()
+	other, _ := e.registerAndLogin(t)
+	e.createNote(t, other, "Private", "")
+
+	// Act
+	var all, page model.ActivityResponse
+	decodeBody(t, e.doJSON(t, "GET", "/api/v1/activity", nil, token), &all)
+	decodeBody(t, e.doJSON(t, "GET", "/api/v1/activity?limit=1&offset=1", nil, token), &page)
+
+	// Assert
+	var got []string
+	for _, en := range all.Entries {
+		got = append(got, en.EntityType+" "+en.Action+" "+en.Title)
+	}
+	t.Logf("activity: %q, total %d, page %+v", got, all.Total, page.Entries)
+	want := []string{"note delete Plan", "todo complete Call", "todo create Call", "note create Plan"}
+	if strings.Join(got, ",") != strings.Join(want, ",") || all.Total != 4 {
+		t.Errorf("activity: got %q (total %d), want %q", got, all.Total, want)
+	}
+	if len(page.Entries) != 1 || page.Entries[0].Action != "complete" || page.Total != 4 {
+		t.Errorf("second page: got %+v (total %d)", page.Entries, page.Total)
+	}
+}
+
+func TestReminderAlarm(t *testing.T) {
+	// Arrange
+	e := setup(t)
+	token, _ := e.registerAndLogin(t)
+	later := time.Now().Add(time.Hour)
+	soon := time.Now().Add(200 * time.Millisecond)
+
+	// Act: a reminder beyond the next regular check leaves the alarm alone
+	e.doJSON(t, "POST", "/api/v1/todos", model.CreateTodoRequest{
+		Content: "later", ReminderAt: &later, DeviceID: "dev1",
+	}, token).Body.Close()
+	e.api.reminderAlarm.mu.Lock()
+	armed := !e.api.reminderAlarm.next.IsZero()
+	e.api.reminderAlarm.mu.Unlock()
+
+	// Assert
+	t.Logf("armed for a reminder in an hour: %v", armed)
+	if armed {
+		t.Error("expected no alarm for a reminder after the next check")
+	}
+
+	// Act: one due before the next check rings it
+	e.doJSON(t, "POST", "/api/v1/todos", model.CreateTodoRequest{
+		Content: "soon", ReminderAt: &soon, DeviceID: "dev1",
+	}, token).Body.Close()
+
+	// Assert
+	select {
+	case <-e.api.reminderAlarm.wake:
+		t.Logf("alarm rang %v after the reminder time", time.Since(soon))
+	case <-time.After(2 * time.Second):
+		t.Fatal("expected the alarm to ring for a reminder due before the next check")
+	}
+}
+
+type fakePoster struct {
+	status   int
+	events   []string
+	payloads [][]byte
+	headers  []http.Header
+}
+
+func (p *fakePoster) Post(url string, payload []byte, header http.Header) (int, error) {
+	p.events = append(p.events, header.Get("X-Notesd-Event"))
+	p.payloads = append(p.payloads, payload)
+	p.headers = append(p.headers, header)
+	if p.status >= 300 {
+		return p.status, fmt.Errorf("webhook returned %d", p.status)
+	}
+	return p.status, nil
+}
+
+func TestWebhooks(t *testing.T) {
+	// Arrange: a webhook for creations and completions only
+	e := setup(t)
+	token, _ := e.registerAndLogin(t)
+	poster := &fakePoster{status: http.StatusOK}
+	e.api.webhookPoster = poster
+	bad := e.doJSON(t, "POST", "/api/v1/webhooks", model.CreateWebhookRequest{URL: "https://example.com/hook", Events: []string{"note.exploded"}}, token)
+	bad.Body.Close()
+	var hook model.Webhook
+	decodeBody(t, e.doJSON(t, "POST", "/api/v1/webhooks", model.CreateWebhookRequest{
+		URL: "https://example.com/hook", Events: []string{"note.created", "todo.completed"},
+	}, token), &hook)
+
+	// Act: create a note, edit it, then create and complete a todo by sync
+	note := e.createNote(t, token, "Hooked", "")
+	title := "Renamed"
+	e.doJSON(t, "PUT", "/api/v1/notes/"+note.ID, model.UpdateNoteRequest{Title: &title, DeviceID: "dev1"}, token).Body.Close()
+	var todo model.Todo
+	decodeBody(t, e.doJSON(t, "POST", "/api/v1/todos", model.CreateTodoRequest{Content: "t", DeviceID: "dev1"}, token), &todo)
+	todo.Completed = true
+	todo.ModifiedAt = todo.ModifiedAt.Add(time.Second)
+	todo.ModifiedByDevice = "phone"
+	e.doJSON(t, "POST", "/api/v1/sync/push", model.SyncPushRequest{DeviceID: "phone", Todos: []model.Todo{todo}}, token).Body.Close()
+	e.api.deliverWebhooks(time.Now())
+
+	// Assert
+	t.Logf("bad event: %d, secret set: %v, delivered: %v", bad.StatusCode, hook.Secret != "", poster.events)
+	if bad.StatusCode != http.StatusBadRequest {
+		t.Errorf("unknown event: expected 400, got %d", bad.StatusCode)
+	}
+	if !strings.HasPrefix(hook.Secret, "whsec_") {
+		t.Fatalf("expected a secret on creation, got %q", hook.Secret)
+	}
+	if strings.Join(poster.events, ",") != "note.created,todo.completed" {
+		t.Fatalf("events: got %v, want note.created,todo.completed", poster.events)
+	}
+	h := poster.headers[0]
+	mac := hmac.New(sha256.New, []byte(hook.Secret))
+	mac.Write([]byte(h.Get("X-Notesd-Timestamp") + "."))
+	mac.Write(poster.payloads[0])
+	if h.Get("X-Notesd-Signature") != "sha256="+hex.EncodeToString(mac.Sum(nil)) {
+		t.Errorf("signature mismatch: %s", h.Get("X-Notesd-Signature"))
+	}
+	var payload model.WebhookPayload
+	if err := json.Unmarshal(poster.payloads[1], &payload); err != nil || payload.Todo == nil || !payload.Todo.Completed || payload.DeviceID != "phone" {
+		t.Errorf("todo payload: %s (%v)", poster.payloads[1], err)
+	}
+
+	// Act: a failing receiver is retried later
+	poster.status = http.StatusInternalServerError
+	e.createNote(t, token, "Unlucky", "")
+	e.api.deliverWebhooks(time.Now())
+	e.api.deliverWebhooks(time.Now())
+	var deliveries []model.WebhookDelivery
+	decodeBody(t, e.doJSON(t, "GET", "/api/v1/webhooks/"+hook.ID+"/deliveries", nil, token), &deliveries)
+
+	// Assert
+	if len(deliveries) != 3 {
+		t.Fatalf("deliveries: expected 3, got %d", len(deliveries))
+	}
+	d := deliveries[0]
+	t.Logf("failed delivery: %+v", d)
+	if d.Status != "pending" || d.Attempts != 1 || d.ResponseCode != 500 || d.NextAttemptAt == nil || !d.NextAttemptAt.After(time.Now()) {
+		t.Errorf("failed delivery: got %+v, want pending with a retry scheduled", d)
+	}
+	if deliveries[1].Status != "delivered" {
+		t.Errorf("earlier delivery: got status %q", deliveries[1].Status)
+	}
+
+	// Act: deleting the webhook drops its history
+	resp := e.doJSON(t, "DELETE", "/api/v1/webhooks/"+hook.ID, nil, token)
+	resp.Body.Close()
+	gone := e.doJSON(t, "GET", "/api/v1/webhooks/"+hook.ID+"/deliveries", nil, token)
+	gone.Body.Close()
+
+	// Assert
+	if resp.StatusCode != http.StatusNoContent || gone.StatusCode != http.StatusNotFound {
+		t.Errorf("delete: got %d, then deliveries %d", resp.StatusCode, gone.StatusCode)
+	}
+}
+
+func TestWebhookPrivateURLs(t *testing.T) {
+	e := setup(t)
+	token, _ := e.registerAndLogin(t)
+	for _, u := range []string{
+		"http://127.0.0.1:6379/",
+		"http://169.254.169.254/latest/meta-data/",
+		"http://10.0.0.1/hook",
+		"http://[::1]/hook",
+		"http://localhost:8080/hook",
+		"https://example.com/hook",
+	} {
+		// Act
+		resp := e.doJSON(t, "POST", "/api/v1/webhooks", model.CreateWebhookRequest{URL: u, Events: []string{"note.created"}}, token)
+		resp.Body.Close()
+
+		// Assert
+		t.Logf("%s: %d", u, resp.StatusCode)
+		want := http.StatusBadRequest
+		if u == "https://example.com/hook" {
+			want = http.StatusCreated
+		}
+		if resp.StatusCode != want {
+			t.Errorf("%s: expected %d, got %d", u, want, resp.StatusCode)
+		}
+	}
+}
+
+func TestRateLimits(t *testing.T) {
+	e := setup(t)
+	token, _ := e.registerAndLogin(t)
+	other, _ := e.registerAndLogin(t)
+	e.api.authLimiter = newRateLimiter(2, time.Minute)
+	e.api.writeLimiter = newRateLimiter(2, time.Minute)
+	e.server.Config.Handler = e.api.Routes()
+
+	// Act: auth requests per client IP
+	var auth []int
+	for range 3 {
+		resp := e.doJSON(t, "POST", "/api/v1/auth/login", model.LoginRequest{
+			Email: "nobody@example.com", Password: "wrongpass", DeviceID: "d",
+		}, "")
+		resp.Body.Close()
+		auth = append(auth, resp.StatusCode)
+		if resp.StatusCode == http.StatusTooManyRequests {
+			t.Logf("auth Retry-After: %s", resp.Header.Get("Retry-After"))
+			if ra, _ := strconv.Atoi(resp.Header.Get("Retry-After")); ra < 1 || ra > 60 {
+				t.Errorf("Retry-After %q, want 1..60", resp.Header.Get("Retry-After"))
+			}
+		}
+	}
+
+	// Act: writes per user
+	var writes []int
+	for i := range 3 {
+		resp := e.doJSON(t, "POST", "/api/v1/notes", model.CreateNoteRequest{
+			Title: fmt.Sprintf("n%d", i), Type: "note", DeviceID: "dev1",
+		}, token)
+		resp.Body.Close()
+		writes = append(writes, resp.StatusCode)
+	}
+	read := e.doJSON(t, "GET", "/api/v1/notes", nil, token)
+	read.Body.Close()
+	otherWrite := e.doJSON(t, "POST", "/api/v1/notes", model.CreateNoteRequest{
+		Title: "other", Type: "note", DeviceID: "dev1",
+	}, other)
+	otherWrite.Body.Close()
+
+	// Assert
+	t.Logf("auth: %v, writes: %v, read: %d, other user write: %d", auth, writes, read.StatusCode, otherWrite.StatusCode)
+	if !slices.Equal(auth, []int{401, 401, 429}) {
+		t.Errorf("auth statuses %v, want [401 401 429]", auth)
+	}
+	if !slices.Equal(writes, []int{201, 201, 429}) {
+		t.Errorf("write statuses %v, want [201 201 429]", writes)
+	}
+	if read.StatusCode != http.StatusOK {
+		t.Errorf("reads must not count against the write limit, got %d", read.StatusCode)
+	}
+	if otherWrite.StatusCode != http.StatusCreated {
+		t.Errorf("another user was limited: %d", otherWrite.StatusCode)
+	}
+}
+
+// startFakeRedis serves the EVAL of hitScript over the Redis protocol,
+// counting per key, and returns the server's redis:// URL.
+func startFakeRedis(t *testing.T) string {
+	t.Helper()
+	ln, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatalf("listen: %v", err)
+	}
+	t.Cleanup(func() { ln.Close() })
+	var mu sync.Mutex
+	counts := make(map[string]int)
+	go func() {
+		for {
+			nc, err := ln.Accept()
+			if err != nil {
+				return
+			}
+			go func() {
+				defer nc.Close()
+				br := bufio.NewReader(nc)
+				for {
+					// Commands are arrays of bulk strings: *n, then $len and
+					// the string n times.
+					var n int
+					if _, err := fmt.Fscanf(br, "*%d\r\n", &n); err != nil {
+						return
+					}
+					args := make([]string, n)
+					for i := range args {
+						var size int
+						if _, err := fmt.Fscanf(br, "$%d\r\n", &size); err != nil {
+							return
+						}
+						buf := make([]byte, size+2)
+						if _, err := io.ReadFull(br, buf); err != nil {
+							return
+						}
+						args[i] = string(buf[:size])
+					}
+					if args[0] != "EVAL" || args[1] != hitScript {
+						fmt.Fprint(nc, "-ERR unexpected command\r\n")
+						continue
+					}
+					mu.Lock()
+					counts[args[3]]++
+					fmt.Fprintf(nc, "*2\r\n:%d\r\n:%s\r\n", counts[args[3]], args[4])
+					mu.Unlock()
+				}
+			}()
+		}
+	}()
+	return "redis://" + ln.Addr().String()
+}
+
+func TestRateLimitSharedBackend(t *testing.T) {
+	// Arrange: two servers counting in one Redis
+	addr := startFakeRedis(t)
+	open := func() *pubsub.Redis {
+		r, err := pubsub.OpenRedis(addr)
+		if err != nil {
+			t.Fatalf("open redis: %v", err)
+		}
+		t.Cleanup(func() { r.Close() })
+		return r
+	}
+	one, two := open(), open()
+	a := newRateLimiter(3, time.Minute).sharedIn(one, "auth")
+	b := newRateLimiter(3, time.Minute).sharedIn(two, "auth")
+	writes := newRateLimiter(3, time.Minute).sharedIn(one, "writes")
+
+	// Act
+	var allowed []bool
+	for _, rl := range []*rateLimiter{a, b, a, b} {
+		ok, _ := rl.allow("10.0.0.1")
+		allowed = append(allowed, ok)
+	}
+	_, retry := b.allow("10.0.0.1")
+	other, _ := a.allow("10.0.0.2")
+	separate, _ := writes.allow("10.0.0.1")
+	ln, _ := net.Listen("tcp", "127.0.0.1:0")
+	ln.Close()
+	gone, _ := pubsub.OpenRedis("redis://" + ln.Addr().String())
+	down := newRateLimiter(1, time.Minute).sharedIn(gone, "auth")
+	unreachable, _ := down.allow("10.0.0.1")
+
+	// Assert
+	t.Logf("allowed: %v, retry after: %s, other key: %v, other limiter: %v, redis down: %v",
+		allowed, retry, other, separate, unreachable)
+	if !slices.Equal(allowed, []bool{true, true, true, false}) {
+		t.Errorf("allowed %v, want [true true true false]", allowed)
+	}
+	if retry <= 0 || retry > time.Minute {
+		t.Errorf("retry after %s, want within the window", retry)
+	}
+	if !other || !separate {
+		t.Error("keys and limiters must be counted separately")
+	}
+	if !unreachable {
+		t.Error("requests must be let through while Redis cannot be reached")
+	}
+}
+
+// --- Compression tests ---
+
+func TestGzipResponses(t *testing.T) {
+	e := setup(t)
+	token, _ := e.registerAndLogin(t)
+
+	// Arrange: enough notes that the list exceeds minGzipSize
+	for i := range 10 {
+		e.createNote(t, token, fmt.Sprintf("Note %d", i), strings.Repeat("text ", 40))
+	}
+	get := func(path, acceptEncoding string) (*http.Response, []byte) {
+		req, _ := http.NewRequest("GET", e.server.URL+path, nil)
+		req.Header.Set("Authorization", "Bearer "+token)
+		req.Header.Set("Accept-Encoding", acceptEncoding)
+		resp, err := http.DefaultClient.Do(req)
+		if err != nil {
+			t.Fatalf("get %s: %v", path, err)
+		}
+		defer resp.Body.Close()
+		body, _ := io.ReadAll(resp.Body)
+		return resp, body
+	}
+
+	// Act
+	big, bigBody := get("/api/v1/notes", "gzip")
+	small, smallBody := get("/api/v1/version", "gzip")
+	refused, refusedBody := get("/api/v1/notes", "gzip;q=0, identity")
+
+	// Assert
+	t.Logf("list: %s, %d bytes; version: %q; refused: %q, %d bytes",
+		big.Header.Get("Content-Encoding"), len(bigBody), small.Header.Get("Content-Encoding"),
+		refused.Header.Get("Content-Encoding"), len(refusedBody))
+	if big.Header.Get("Content-Encoding") != "gzip" || big.Header.Get("Vary") != "Accept-Encoding" {
+		t.Fatalf("expected a gzipped list varying on Accept-Encoding, got %v", big.Header)
+	}
+	zr, err := gzip.NewReader(bytes.NewReader(bigBody))
+	if err != nil {
+		t.Fatalf("gzip reader: %v", err)
+	}
+	plain, _ := io.ReadAll(zr)
+	if !bytes.Equal(plain, refusedBody) || len(bigBody) >= len(plain) {
+		t.Errorf("compressed body (%d bytes) does not inflate to the plain one (%d bytes)", len(bigBody), len(refusedBody))
+	}
+	if small.Header.Get("Content-Encoding") != "" || !json.Valid(smallBody) {
+		t.Errorf("small responses must be sent uncompressed")
+	}
+	if refused.Header.Get("Content-Encoding") != "" {
+		t.Errorf("gzip;q=0 must not be compressed")
+	}
+}
+
+func TestGzipRequestBody(t *testing.T) {
+	e := setup(t)
+	token, user := e.registerAndLogin(t)
+
+	// Arrange
+	now := time.Now().UTC().Truncate(time.Millisecond)
+	body, _ := json.Marshal(model.SyncPushRequest{
+		Notes: []model.Note{{
+			ID: model.NewID(), UserID: user.ID, Title: "Zipped", Content: "from client",
+			Type: "note", ModifiedAt: now, ModifiedByDevice: "phone", CreatedAt: now,
+		}},
+		DeviceID: "phone",
+	})
+	var zipped bytes.Buffer
+	zw := gzip.NewWriter(&zipped)
+	zw.Write(body)
+	zw.Close()
+	push := func(encoding string, body []byte) int {
+		req, _ := http.NewRequest("POST", e.server.URL+"/api/v1/sync/push", bytes.NewReader(body))
+		req.Header.Set("Authorization", "Bearer "+token)
+		req.Header.Set("Content-Type", "application/json")
+		req.Header.Set("Content-Encoding", encoding)
+		resp, err := http.DefaultClient.Do(req)
+		if err != nil {
+			t.Fatalf("push: %v", err)
+		}
+		resp.Body.Close()
+		return resp.StatusCode
+	}
+
+	// Act
+	gzipStatus := push("gzip", zipped.Bytes())
+	brStatus := push("br", zipped.Bytes())
+	corruptStatus := push("gzip", body)
+
+	// Assert
+	t.Logf("gzip: %d, br: %d, not gzip: %d", gzipStatus, brStatus, corruptStatus)
+	if gzipStatus != http.StatusOK {
+		t.Errorf("gzip push: got %d, want 200", gzipStatus)
+	}
+	if brStatus != http.StatusUnsupportedMediaType {
+		t.Errorf("unknown encoding: got %d, want 415", brStatus)
+	}
+	if corruptStatus != http.StatusBadRequest {
+		t.Errorf("body that is not gzip: got %d, want 400", corruptStatus)
+	}
+}
+
+// --- HTTP caching tests ---
+
+func TestConditionalGet(t *testing.T) {
+	e := setup(t)
+	token, _ := e.registerAndLogin(t)
+
+	// Arrange
+	note := e.createNote(t, token, "Cached", "body")
+	get := func(path string, header map[string]string) *http.Response {
+		req, _ := http.NewRequest("GET", e.server.URL+path, nil)
+		req.Header.Set("Authorization", "Bearer "+token)
+		for k, v := range header {
+			req.Header.Set(k, v)
+		}
+		resp, err := http.DefaultClient.Do(req)
+		if err != nil {
+			t.Fatalf("get %s: %v", path, err)
+		}
+		resp.Body.Close()
+		return resp
+	}
+	first := get("/api/v1/notes/"+note.ID, nil)
+	etag, lastModified := first.Header.Get("ETag"), first.Header.Get("Last-Modified")
+	list := get("/api/v1/notes", nil)
+
+	// Act
+	sameTag := get("/api/v1/notes/"+note.ID, map[string]string{"If-None-Match": etag})
+	sameTime := get("/api/v1/notes/"+note.ID, map[string]string{"If-Modified-Since": lastModified})
+	sameList := get("/api/v1/notes", map[string]string{"If-None-Match": list.Header.Get("ETag")})
+	e.createNote(t, token, "Another", "")
+	changedList := get("/api/v1/notes", map[string]string{"If-None-Match": list.Header.Get("ETag")})
+	otherTag := get("/api/v1/notes/"+note.ID, map[string]string{
+		"If-None-Match": `W/"0000"`, "If-Modified-Since": lastModified,
+	})
+
+	// Assert
+	t.Logf("etag=%s last-modified=%s list etag=%s", etag, lastModified, list.Header.Get("ETag"))
+	t.Logf("same tag: %d, same time: %d, same list: %d, changed list: %d, other tag: %d",
+		sameTag.StatusCode, sameTime.StatusCode, sameList.StatusCode, changedList.StatusCode, otherTag.StatusCode)
+	if etag == "" || lastModified == "" || list.Header.Get("Last-Modified") != "" {
+		t.Errorf("expected ETag and Last-Modified on the note and only an ETag on the list")
+	}
+	if sameTag.StatusCode != http.StatusNotModified || sameTime.StatusCode != http.StatusNotModified ||
+		sameList.StatusCode != http.StatusNotModified {
+		t.Errorf("unchanged data must answer 304")
+	}
+	if changedList.StatusCode != http.StatusOK {
+		t.Errorf("changed list: got %d, want 200", changedList.StatusCode)
+	}
+	if otherTag.StatusCode != http.StatusOK {
+		t.Errorf("If-None-Match must take precedence over If-Modified-Since, got %d", otherTag.StatusCode)
+	}
+}
+
+// --- WebDAV tests ---
+
+func TestWebDAV(t *testing.T) {
+	// Arrange: a note, a full access token and a read-only one
+	e := setup(t)
+	session, _ := e.registerAndLogin(t)
+	shopping := e.createNote(t, session, "Shopping", "milk\n")
+	token := func(scope string) string {
+		resp := e.doJSON(t, "POST", "/api/v1/auth/tokens", model.CreateAccessTokenRequest{Name: "dav " + scope, Scope: scope}, session)
+		var tok model.AccessToken
+		decodeBody(t, resp, &tok)
+		return tok.Token
+	}
+	writeTok, readTok := token(""), token("read")
+	dav := func(method, name, password, body string, header ...string) (int, string) {
+		t.Helper()
+		req, err := http.NewRequest(method, e.server.URL+davPrefix+"/"+name, strings.NewReader(body))
+		if err != nil {
+			t.Fatalf("new request: %v", err)
+		}
+		if password != "" {
+			req.SetBasicAuth("me", password)
+		}
+		for i := 0; i+1 < len(header); i += 2 {
+			req.Header.Set(header[i], header[i+1])
+		}
+		resp, err := http.DefaultClient.Do(req)
+		if err != nil {
+			t.Fatalf("%s %s: %v", method, name, err)
+		}
+		defer resp.Body.Close()
+		b, _ := io.ReadAll(resp.Body)
+		t.Logf("%s /%s: %d", method, name, resp.StatusCode)
+		return resp.StatusCode, string(b)
+	}
+	getNote := func(id string) (int, model.Note) {
+		resp := e.doJSON(t, "GET", "/api/v1/notes/"+id, nil, session)
+		var n model.Note
+		if resp.StatusCode == http.StatusOK {
+			decodeBody(t, resp, &n)
+		} else {
+			resp.Body.Close()
+		}
+		return resp.StatusCode, n
+	}
+
+	t.Run("needs an access token", func(t *testing.T) {
+		for _, password := range []string{"", session, "ndt_wrong"} {
+			// Act
+			code, _ := dav("PROPFIND", "", password, "", "Depth", "1")
+
+			// Assert
+			if code != http.StatusUnauthorized {
+				t.Errorf("expected 401, got %d", code)
+			}
+		}
+	})
+
+	t.Run("lists and reads notes", func(t *testing.T) {
+		// Act
+		listCode, list := dav("PROPFIND", "", writeTok, "", "Depth", "1")
+		getCode, file := dav("GET", "Shopping.md", readTok, "")
+
+		// Assert
+		if listCode != http.StatusMultiStatus || !strings.Contains(list, "/dav/notes/Shopping.md") {
+			t.Errorf("PROPFIND: %d\n%s", listCode, list)
+		}
+		t.Logf("Shopping.md:\n%s", file)
+		if getCode != http.StatusOK || !strings.Contains(file, `id: "`+shopping.ID+`"`) || !strings.HasSuffix(file, "---\nmilk\n") {
+			t.Errorf("GET: %d\n%s", getCode, file)
+		}
+	})
+
+	t.Run("writes update notes", func(t *testing.T) {
+		// Act: plain Markdown keeps the title and tags
+		code, _ := dav("PUT", "Shopping.md", writeTok, "milk\neggs\n")
+
+		// Assert
+		_, n := getNote(shopping.ID)
+		if code >= 300 || n.Title != "Shopping" || n.Content != "milk\neggs\n" {
+			t.Errorf("PUT: %d, note %q %q", code, n.Title, n.Content)
+		}
+	})
+
+	t.Run("read-only tokens cannot write", func(t *testing.T) {
+		// Act
+		code, _ := dav("PUT", "Shopping.md", readTok, "gone\n")
+
+		// Assert
+		if code != http.StatusForbidden {
+			t.Errorf("expected 403, got %d", code)
+		}
+	})
+
+	t.Run("new files, moves and deletes", func(t *testing.T) {
+		// Act
+		putCode, _ := dav("PUT", "Idea.md", writeTok, "---\ntags: [garden]\n---\nplant roses\n")
+		moveCode, _ := dav("MOVE", "Idea.md", writeTok, "", "Destination", e.server.URL+davPrefix+"/Roses.md")
+		_, moved := dav("GET", "Roses.md", writeTok, "")
+		fm, content := frontmatter.Parse(moved)
+		_, n := getNote(fm.Get("id"))
+		deleteCode, _ := dav("DELETE", "Roses.md", writeTok, "")
+		afterDelete, _ := getNote(n.ID)
+		otherCode, _ := dav("PUT", "notes.txt", writeTok, "x")
+
+		// Assert
+		if putCode != http.StatusCreated || moveCode != http.StatusCreated {
+			t.Errorf("PUT %d, MOVE %d", putCode, moveCode)
+		}
+		if n.Title != "Roses" || content != "plant roses\n" || !slices.Equal(n.Tags, []string{"garden"}) {
+			t.Errorf("moved note: %+v", n)
+		}
+		if deleteCode != http.StatusNoContent || afterDelete != http.StatusNotFound {
+			t.Errorf("DELETE %d, note afterwards %d", deleteCode, afterDelete)
+		}
+		if otherCode < 400 {
+			t.Errorf("PUT of a non-Markdown file: %d", otherCode)
+		}
+	})
+}
+
+func TestDAVFileNames(t *testing.T) {
+	// Arrange
+	titles := map[string]string{
+		"aaaaaaaa-1": "Plan",
+		"bbbbbbbb-2": "plan",
+		"cccccccc-3": "a/b: c?",
+		"dddddddd-4": "...",
+		"e5":         "Plan",
+	}
+
+	// Act
+	names := davFileNames(titles)
+
+	// Assert
+	t.Logf("names: %v", names)
+	want := map[string]string{
+		"aaaaaaaa-1": "Plan aaaaaaaa.md",
+		"bbbbbbbb-2": "plan bbbbbbbb.md",
+		"cccccccc-3": "a-b- c-.md",
+		"dddddddd-4": "dddddddd-4.md",
+		"e5":         "Plan e5.md",
+	}
+	if !reflect.DeepEqual(names, want) {
+		t.Errorf("got %v, want %v", names, want)
+	}
+}
+
+// --- Note history tests ---
+
+func TestNoteHistory(t *testing.T) {
+	// Arrange: a note created, edited and deleted with the history on
+	e := setup(t)
+	token, user := e.registerAndLogin(t)
+	otherToken, _ := e.registerAndLogin(t)
+	disabled := e.doJSON(t, "GET", "/api/v1/notes/x/history", nil, token)
+	disabled.Body.Close()
+	if _, err := exec.LookPath("git"); err != nil {
+		t.Skip("git not installed")
+	}
+	repo, err := githistory.Open(filepath.Join(t.TempDir(), "history.git"), "")
+	if err != nil {
+		t.Fatalf("open history: %v", err)
+	}
+	e.api.history = repo
+	ctx := context.Background()
+
+	note := e.createNote(t, token, "Shopping", "milk\n")
+	e.api.writeHistory(ctx)
+	content := "milk\neggs\n"
+	resp := e.doJSON(t, "PUT", "/api/v1/notes/"+note.ID, model.UpdateNoteRequest{Content: &content, DeviceID: "dev1"}, token)
+	resp.Body.Close()
+	e.api.writeHistory(ctx)
+	get := func(path, tok string, v any) int {
+		t.Helper()
+		resp := e.doJSON(t, "GET", path, nil, tok)
+		if resp.StatusCode != http.StatusOK || v == nil {
+			resp.Body.Close()
+			return resp.StatusCode
+		}
+		decodeBody(t, resp, v)
+		return resp.StatusCode
+	}
+
+	// Act
+	var history model.NoteHistoryResponse
+	historyCode := get("/api/v1/notes/"+note.ID+"/history", token, &history)
+	var latest, first model.NoteDiff
+	latestCode := get("/api/v1/notes/"+note.ID+"/diff", token, &latest)
+	firstCode := 0
+	if len(history.Revisions) == 2 {
+		firstCode = get("/api/v1/notes/"+note.ID+"/diff?rev="+history.Revisions[1].Rev[:10], token, &first)
+	}
+	badRevCode := get("/api/v1/notes/"+note.ID+"/diff?rev=HEAD~1", token, nil)
+	otherCode := get("/api/v1/notes/"+note.ID+"/history", otherToken, nil)
+	resp = e.doJSON(t, "DELETE", "/api/v1/notes/"+note.ID, nil, token)
+	resp.Body.Close()
+	e.api.writeHistory(ctx)
+	var deleted model.NoteDiff
+	deletedCode := get("/api/v1/notes/"+note.ID+"/diff", token, &deleted)
+
+	// Assert
+	if disabled.StatusCode != http.StatusNotFound {
+		t.Errorf("history while off: %d, want 404", disabled.StatusCode)
+	}
+	t.Logf("history: %+v", history.Revisions)
+	if historyCode != http.StatusOK || len(history.Revisions) != 2 {
+		t.Fatalf("history: %d, %d revisions, want 2", historyCode, len(history.Revisions))
+	}
+	if r := history.Revisions[0]; r.Message != "Update Shopping" || r.Author != user.DisplayName {
+		t.Errorf("latest revision %+v", r)
+	}
+	t.Logf("latest diff:\n%s", latest.Diff)
+	if latestCode != http.StatusOK || latest.Revision.Rev != history.Revisions[0].Rev || !strings.Contains(latest.Diff, "\n+eggs\n") {
+		t.Errorf("latest diff: %d %+v", latestCode, latest.Revision)
+	}
+	if firstCode != http.StatusOK || first.Revision.Message != "Create Shopping" || !strings.Contains(first.Diff, "+title: \"Shopping\"") {
+		t.Errorf("first diff: %d %+v\n%s", firstCode, first.Revision, first.Diff)
+	}
+	if badRevCode != http.StatusBadRequest || otherCode != http.StatusNotFound {
+		t.Errorf("bad rev %d (want 400), other user %d (want 404)", badRevCode, otherCode)
+	}
+	if deletedCode != http.StatusOK || deleted.Revision.Message != "Delete Shopping" || !strings.Contains(deleted.Diff, "deleted file") {
+		t.Errorf("delete diff: %d %+v\n%s", deletedCode, deleted.Revision, deleted.Diff)
+	}
+}
+
+func TestQueueHistoryBatches(t *testing.T) {
+	// Arrange
+	e := setup(t)
+	e.api.history = &githistory.Repo{} // only queued, never committed
+	evs := append([]events.Event{itemEvent(events.NoteUpdated, "u1", "a", "dev")},
+		bulkEvents(events.NoteDeleted, "u1", "dev", []string{"b", "c"})...)
+	evs = append(evs, itemEvent(events.TodoUpdated, "u1", "t", "dev"), itemEvent(events.NoteDeleted, "u2", "d", "dev"))
+
+	// Act
+	e.api.queueHistory(context.Background(), evs)
+
+	// Assert
+	var got []string
+	for _, b := range e.api.historyQueue {
+		got = append(got, fmt.Sprintf("%s %s %v", b.userID, b.kind, b.ids))
+	}
+	t.Logf("batches: %q", got)
+	want := []string{"u1 note.updated [a]", "u1 note.deleted [b c]", "u2 note.deleted [d]"}
+	if !slices.Equal(got, want) {
+		t.Errorf("got %q, want %q", got, want)
+	}
+	if msg := historyMessage(e.api.historyQueue[1], ""); !strings.HasPrefix(msg, "Delete 2 notes\n\nNote: b\nNote: c\nDevice: dev\n") {
+		t.Errorf("message %q", msg)
+	}
+}
@@ -0,0 +1,249 @@
+package api
+
+import (
+	"encoding/xml"
+	"errors"
+	"io"
+	"log/slog"
+	"net/http"
+	"strconv"
+	"time"
+
+	"github.com/c0dev0id/notesd/server/internal/database"
+	"github.com/c0dev0id/notesd/server/internal/model"
+)
+
+const (
+	noteFeedPath     = "/api/v1/notes/feed.atom"
+	defaultFeedItems = 50
+	maxFeedItems     = 200
+)
+
+// atomFeed and the types below encode an Atom feed (RFC 4287). Links are
+// relative, resolved by readers against the feed's own URL.
+type atomFeed struct {
+	XMLName xml.Name    `xml:"http://www.w3.org/2005/Atom feed"`
+	ID      string      `xml:"id"`
+	Title   string      `xml:"title"`
+	Updated string      `xml:"updated"`
+	Author  atomAuthor  `xml:"author"`
+	Links   []atomLink  `xml:"link"`
+	Entries []atomEntry `xml:"entry"`
+}
+
+type atomAuthor struct {
+	Name string `xml:"name"`
+}
+
+type atomLink struct {
+	Rel  string `xml:"rel,attr,omitempty"`
+	Type string `xml:"type,attr,omitempty"`
+	Href string `xml:"href,attr"`
+}
+
+type atomEntry struct {
+	ID         string         `xml:"id"`
+	Title      string         `xml:"title"`
+	Updated    string         `xml:"updated"`
+	Published  string         `xml:"published"`
+	Links      []atomLink     `xml:"link"`
+	Categories []atomCategory `xml:"category"`
+	Content    atomContent    `xml:"content"`
+}
+
+type atomCategory struct {
+	Term string `xml:"term,attr"`
+}
+
+type atomContent struct {
+	Type string `xml:"type,attr"`
+	Body string `xml:",chardata"`
+}
+
+func atomTime(t time.Time) string { return t.UTC().Format(time.RFC3339) }
+
+// noteFeedURL is the feed URL for token, the path served by handleNoteFeed.
+func noteFeedURL(token string) string { return noteFeedPath + "?token=" + token }
+
+func (a *API) handleListNoteFeeds(w http.ResponseWriter, r *http.Request) {
+	userID := userIDFrom(r.Context())
+
+	feeds, err := a.dbFor(r).ListNoteFeeds(userID)
+	if err != nil {
+		slog.Error("list note feeds", "error", err)
+		writeError(w, http.StatusInternalServerError, "internal error")
+		return
+	}
+	if feeds == nil {
+		feeds = []model.NoteFeed{}
+	}
+
+	writeJSON(w, http.StatusOK, feeds)
+}
+
+// handleCreateNoteFeed generates a feed token of the requested kind,
+// revoking the previous one. Only the hash is stored, so the URL is shown
+// this once.
+func (a *API) handleCreateNoteFeed(w http.ResponseWriter, r *http.Request) {
+	userID := userIDFrom(r.Context())
+
+	var req model.NoteFeedRequest
+	if err := decodeJSON(r, &req); err != nil && !errors.Is(err, io.EOF) {
+		writeError(w, http.StatusBadRequest, "invalid request body")
+		return
+	}
+
+	token := newSlug()
+	now := model.NowMillis()
+	if err := a.dbFor(r).SetNoteFeedToken(userID, req.Published, database.HashToken(token), now); err != nil {
+		slog.Error("create note feed", "error", err)
+		writeError(w, http.StatusInternalServerError, "internal error")
+		return
+	}
+
+	writeJSON(w, http.StatusCreated, model.NoteFeed{Published: req.Published, URL: noteFeedURL(token), CreatedAt: now})
+}
+
+// handleDeleteNoteFeed revokes the feed of all notes, or of published
+// notes with ?published=true.
+func (a *API) handleDeleteNoteFeed(w http.ResponseWriter, r *http.Request) {
+	userID := userIDFrom(r.Context())
+	published, _ := strconv.ParseBool(r.URL.Query().Get("published"))
+
+	err := a.dbFor(r).DeleteNoteFeed(userID, published)
+	if errors.Is(err, database.ErrNotFound) {
+		writeError(w, http.StatusNotFound, "no note feed")
+		return
+	}
+	if err != nil {
+		slog.Error("delete note feed", "error", err)
+		writeError(w, http.StatusInternalServerError, "internal error")
+		return
+	}
+
+	w.WriteHeader(http.StatusNoContent)
+}
+
+// handleNoteFeed serves the Atom feed of the most recently modified notes
+// (?limit=, default 50), rendered to HTML. Feed readers cannot send a
+// bearer token, so the request is authenticated by the feed token in the
+// query string instead. A published-notes feed lists only notes with a
+// live public link and links each entry to its public page.
+func (a *API) handleNoteFeed(w http.ResponseWriter, r *http.Request) {
+	token := r.URL.Query().Get("token")
+	if token == "" {
+		writeError(w, http.StatusUnauthorized, "missing feed token")
+		return
+	}
+	db := a.dbFor(r)
+	userID, published, err := db.GetNoteFeed(database.HashToken(token))
+	if errors.Is(err, database.ErrNotFound) {
+		writeError(w, http.StatusUnauthorized, "invalid feed token")
+		return
+	}
+	if err != nil {
+		slog.Error("get note feed", "error", err)
+		writeError(w, http.StatusInternalServerError, "internal error")
+		return
+	}
+
+	limit := queryInt(r, "limit", defaultFeedItems)
+	if limit < 1 || limit > maxFeedItems {
+		limit = maxFeedItems
+	}
+	notes, _, err := db.ListNotes(userID, database.NoteFilter{Published: published}, limit, 0)
+	if err != nil {
+		slog.Error("list notes for feed", "error", err)
+		writeError(w, http.StatusInternalServerError, "internal error")
+		return
+	}
+	slugs := map[string]string{}
+	if published {
+		links, err := db.GetAllPublicLinks(userID, model.NowMillis().UnixMilli())
+		if err != nil {
+			slog.Error("list public links for feed", "error", err)
+			writeError(w, http.StatusInternalServerError, "internal error")
+			return
+		}
+		for _, l := range links {
+			if _, ok := slugs[l.NoteID]; !ok {
+				slugs[l.NoteID] = l.Slug
+			}
+		}
+	}
+	user, err := db.GetUserByID(userID)
+	if err != nil {
+		slog.Error("get user for feed", "error", err)
+		writeError(w, http.StatusInternalServerError, "internal error")
+		return
+	}
+
+	feed, err := buildNoteFeed(user, notes, published, slugs)
+	if err != nil {
+		slog.Error("render note feed", "error", err)
+		writeError(w, http.StatusInternalServerError, "internal error")
+		return
+	}
+	feed.Links = append(feed.Links, atomLink{Rel: "self", Type: "application/atom+xml", Href: r.URL.RequestURI()})
+	body, err := xml.MarshalIndent(feed, "", "  ")
+	if err != nil {
+		slog.Error("encode note feed", "error", err)
+		writeError(w, http.StatusInternalServerError, "internal error")
+		return
+	}
+	var modified time.Time
+	if len(notes) > 0 {
+		modified = notes[0].ModifiedAt
+	}
+	writeTagged(w, r, modified, "application/atom+xml; charset=utf-8", append([]byte(xml.Header), append(body, '\n')...))
+}
+
+// buildNoteFeed renders notes, newest first, as a feed. Wiki links become
+// plain text, as their targets are not part of the feed.
+func buildNoteFeed(user *model.User, notes []model.Note, published bool, slugs map[string]string) (*atomFeed, error) {
+	author := user.DisplayName
+	if author == "" {
+		author = "notesd"
+	}
+	feed := &atomFeed{
+		ID:      "tag:notesd,2024:" + user.ID + "/notes",
+		Title:   "Recent notes",
+		Updated: atomTime(user.CreatedAt),
+		Author:  atomAuthor{Name: author},
+	}
+	if published {
+		feed.ID = "tag:notesd,2024:" + user.ID + "/published"
+		feed.Title = "Published notes"
+	}
+	if user.DisplayName != "" {
+		feed.Title += " by " + user.DisplayName
+	}
+	if len(notes) > 0 {
+		feed.Updated = atomTime(notes[0].ModifiedAt)
+	}
+	for _, n := range notes {
+		html, err := renderMarkdown(linkWikiLinks(n.Content, nil))
+		if err != nil {
+			return nil, err
+		}
+		title := n.Title
+		if title == "" {
+			title = "Untitled"
+		}
+		e := atomEntry{
+			ID:        "urn:uuid:" + n.ID,
+			Title:     title,
+			Updated:   atomTime(n.ModifiedAt),
+			Published: atomTime(n.CreatedAt),
+			Content:   atomContent{Type: "html", Body: string(html)},
+		}
+		if slug, ok := slugs[n.ID]; ok {
+			e.Links = []atomLink{{Rel: "alternate", Type: "text/html", Href: "/p/" + slug}}
+		}
+		for _, tag := range n.Tags {
+			e.Categories = append(e.Categories, atomCategory{Term: tag})
+		}
+		feed.Entries = append(feed.Entries, e)
+	}
+	return feed, nil
+}
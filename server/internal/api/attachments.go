@@ -0,0 +1,205 @@
+package api
+
+import (
+	"errors"
+	"io"
+	"log/slog"
+	"mime"
+	"mime/multipart"
+	"net/http"
+	"path"
+	"strings"
+	"unicode/utf8"
+
+	"github.com/c0dev0id/notesd/server/internal/blob"
+	"github.com/c0dev0id/notesd/server/internal/database"
+	"github.com/c0dev0id/notesd/server/internal/model"
+)
+
+const maxFilenameLen = 255
+
+// multipartOverhead is allowed on top of attachments.max_size for the
+// multipart framing around the file.
+const multipartOverhead = 64 << 10
+
+func (a *API) handleUploadAttachment(w http.ResponseWriter, r *http.Request) {
+	userID := userIDFrom(r.Context())
+	noteID := r.PathValue("id")
+
+	if !a.noteExists(w, r, noteID, userID) {
+		return
+	}
+
+	maxSize := a.config.Attachments.MaxSize
+	r.Body = http.MaxBytesReader(w, r.Body, maxSize+multipartOverhead)
+	mr, err := r.MultipartReader()
+	if err != nil {
+		writeError(w, http.StatusBadRequest, "multipart/form-data body required")
+		return
+	}
+	var part *multipart.Part
+	for part == nil {
+		p, err := mr.NextPart()
+		if err == io.EOF {
+			writeError(w, http.StatusBadRequest, "file field is required")
+			return
+		}
+		if err != nil {
+			writeError(w, http.StatusBadRequest, "invalid multipart body")
+			return
+		}
+		if p.FormName() == "file" {
+			part = p
+		}
+	}
+
+	filename := cleanFilename(part.FileName())
+	if filename == "" {
+		writeError(w, http.StatusBadRequest, "file name is required")
+		return
+	}
+	contentType := "application/octet-stream"
+	if mt, params, err := mime.ParseMediaType(part.Header.Get("Content-Type")); err == nil {
+		contentType = mime.FormatMediaType(mt, params)
+	}
+
+	id := model.NewID()
+	size, sum, err := a.blobs.Put(id, part, maxSize)
+	var maxErr *http.MaxBytesError
+	if errors.Is(err, blob.ErrTooLarge) || errors.As(err, &maxErr) {
+		writeError(w, http.StatusRequestEntityTooLarge, "attachment too large")
+		return
+	}
+	if err != nil {
+		slog.Error("store attachment", "error", err)
+		writeError(w, http.StatusInternalServerError, "internal error")
+		return
+	}
+	if !a.checkQuota(w, r, userID, usageDelta{attachmentBytes: size}) {
+		a.blobs.Remove(id)
+		return
+	}
+
+	now := model.NowMillis()
+	at := &model.Attachment{
+		ID:          id,
+		UserID:      userID,
+		NoteID:      noteID,
+		Filename:    filename,
+		ContentType: contentType,
+		Size:        size,
+		SHA256:      sum,
+		ModifiedAt:  now,
+		CreatedAt:   now,
+	}
+	if err := a.dbFor(r).CreateAttachment(at); err != nil {
+		a.blobs.Remove(id)
+		slog.Error("create attachment", "error", err)
+		writeError(w, http.StatusInternalServerError, "internal error")
+		return
+	}
+
+	writeJSON(w, http.StatusCreated, at)
+}
+
+func (a *API) handleListAttachments(w http.ResponseWriter, r *http.Request) {
+	userID := userIDFrom(r.Context())
+	noteID := r.PathValue("id")
+
+	if !a.noteExists(w, r, noteID, userID) {
+		return
+	}
+	list, err := a.dbFor(r).ListAttachments(noteID, userID)
+	if err != nil {
+		slog.Error("list attachments", "error", err)
+		writeError(w, http.StatusInternalServerError, "internal error")
+		return
+	}
+	if list == nil {
+		list = []model.Attachment{}
+	}
+
+	writeJSON(w, http.StatusOK, list)
+}
+
+// handleGetAttachment serves the file itself. Range and conditional
+// requests are handled by http.ServeContent.
+func (a *API) handleGetAttachment(w http.ResponseWriter, r *http.Request) {
+	userID := userIDFrom(r.Context())
+	id := r.PathValue("id")
+
+	at, err := a.dbFor(r).GetAttachment(id, userID)
+	if errors.Is(err, database.ErrNotFound) {
+		writeError(w, http.StatusNotFound, "attachment not found")
+		return
+	}
+	if err != nil {
+		slog.Error("get attachment", "error", err)
+		writeError(w, http.StatusInternalServerError, "internal error")
+		return
+	}
+	f, err := a.blobs.Open(at.ID)
+	if err != nil {
+		slog.Error("open attachment blob", "id", at.ID, "error", err)
+		writeError(w, http.StatusInternalServerError, "internal error")
+		return
+	}
+	defer f.Close()
+
+	w.Header().Set("Content-Type", at.ContentType)
+	w.Header().Set("Content-Disposition",
+		mime.FormatMediaType("attachment", map[string]string{"filename": at.Filename}))
+	w.Header().Set("X-Content-Type-Options", "nosniff")
+	http.ServeContent(w, r, "", at.ModifiedAt, f)
+}
+
+func (a *API) handleDeleteAttachment(w http.ResponseWriter, r *http.Request) {
+	userID := userIDFrom(r.Context())
+	id := r.PathValue("id")
+
+	err := a.dbFor(r).DeleteAttachment(id, userID, model.NowMillis().UnixMilli())
+	if errors.Is(err, database.ErrNotFound) {
+		writeError(w, http.StatusNotFound, "attachment not found")
+		return
+	}
+	if err != nil {
+		slog.Error("delete attachment", "error", err)
+		writeError(w, http.StatusInternalServerError, "internal error")
+		return
+	}
+	// The metadata row stays as a tombstone for sync; the bytes can go.
+	if err := a.blobs.Remove(id); err != nil {
+		slog.Error("remove attachment blob", "id", id, "error", err)
+	}
+
+	w.WriteHeader(http.StatusNoContent)
+}
+
+// noteExists writes a 404 or 500 and returns false unless the user has a
+// live note with the given ID.
+func (a *API) noteExists(w http.ResponseWriter, r *http.Request, noteID, userID string) bool {
+	_, err := a.dbFor(r).GetNote(noteID, userID)
+	if errors.Is(err, database.ErrNotFound) {
+		writeError(w, http.StatusNotFound, "note not found")
+		return false
+	}
+	if err != nil {
+		slog.Error("get note", "error", err)
+		writeError(w, http.StatusInternalServerError, "internal error")
+		return false
+	}
+	return true
+}
+
+// cleanFilename reduces an uploaded name to its last path element, as
+// browsers on Windows may send a full path.
+func cleanFilename(name string) string {
+	name = path.Base(strings.ReplaceAll(name, `\`, "/"))
+	if name == "." || name == "/" {
+		return ""
+	}
+	if utf8.RuneCountInString(name) > maxFilenameLen {
+		name = string([]rune(name)[:maxFilenameLen])
+	}
+	return name
+}
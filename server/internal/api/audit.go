@@ -0,0 +1,174 @@
+package api
+
+import (
+	"context"
+	"log/slog"
+	"net/http"
+	"time"
+
+	"github.com/c0dev0id/notesd/server/internal/database"
+	"github.com/c0dev0id/notesd/server/internal/events"
+	"github.com/c0dev0id/notesd/server/internal/model"
+)
+
+const auditPruneInterval = time.Hour
+
+// handleListAuditLog serves the audit log to admins, newest first. Every
+// query parameter narrows the result; since and until are RFC 3339 times.
+func (a *API) handleListAuditLog(w http.ResponseWriter, r *http.Request) {
+	q := r.URL.Query()
+	f := database.AuditFilter{
+		UserID:     q.Get("user_id"),
+		EntityType: q.Get("entity_type"),
+		EntityID:   q.Get("entity_id"),
+		Action:     q.Get("action"),
+		DeviceID:   q.Get("device_id"),
+	}
+	switch f.EntityType {
+	case "", "note", "todo":
+	default:
+		writeError(w, http.StatusBadRequest, "entity_type must be note or todo")
+		return
+	}
+	switch f.Action {
+	case "", database.AuditCreate, database.AuditUpdate, database.AuditComplete,
+		database.AuditDelete, database.AuditRestore, database.AuditPurge:
+	default:
+		writeError(w, http.StatusBadRequest, "action must be create, update, complete, delete, restore or purge")
+		return
+	}
+	for _, b := range []struct {
+		key string
+		dst **time.Time
+	}{{"since", &f.Since}, {"until", &f.Until}} {
+		s := q.Get(b.key)
+		if s == "" {
+			continue
+		}
+		t, err := time.Parse(time.RFC3339, s)
+		if err != nil {
+			writeError(w, http.StatusBadRequest, b.key+" must be an RFC 3339 time")
+			return
+		}
+		*b.dst = &t
+	}
+
+	limit := queryInt(r, "limit", 100)
+	offset := queryInt(r, "offset", 0)
+	if limit > 1000 {
+		limit = 1000
+	}
+
+	entries, total, err := a.dbFor(r).ListAuditLog(f, limit, offset)
+	if err != nil {
+		slog.Error("list audit log", "error", err)
+		writeError(w, http.StatusInternalServerError, "internal error")
+		return
+	}
+	if entries == nil {
+		entries = []model.AuditEntry{}
+	}
+
+	writeJSON(w, http.StatusOK, model.AuditListResponse{
+		Entries: entries,
+		Total:   total,
+		Limit:   limit,
+		Offset:  offset,
+	})
+}
+
+// handleListActivity serves the user's own changes to notes and todos,
+// newest first, as recorded in the audit log.
+func (a *API) handleListActivity(w http.ResponseWriter, r *http.Request) {
+	userID := userIDFrom(r.Context())
+
+	limit := queryInt(r, "limit", 50)
+	offset := queryInt(r, "offset", 0)
+	if limit > 200 {
+		limit = 200
+	}
+
+	entries, total, err := a.dbFor(r).ListActivity(userID, limit, offset)
+	if err != nil {
+		slog.Error("list activity", "error", err)
+		writeError(w, http.StatusInternalServerError, "internal error")
+		return
+	}
+	if entries == nil {
+		entries = []model.ActivityEntry{}
+	}
+
+	writeJSON(w, http.StatusOK, model.ActivityResponse{
+		Entries: entries,
+		Total:   total,
+		Limit:   limit,
+		Offset:  offset,
+	})
+}
+
+// auditActions maps domain events to audit log actions.
+var auditActions = map[events.Kind]string{
+	events.NoteCreated:   database.AuditCreate,
+	events.NoteUpdated:   database.AuditUpdate,
+	events.NoteDeleted:   database.AuditDelete,
+	events.NoteRestored:  database.AuditRestore,
+	events.NotePurged:    database.AuditPurge,
+	events.TodoCreated:   database.AuditCreate,
+	events.TodoUpdated:   database.AuditUpdate,
+	events.TodoCompleted: database.AuditComplete,
+	events.TodoDeleted:   database.AuditDelete,
+	events.TodoRestored:  database.AuditRestore,
+	events.TodoPurged:    database.AuditPurge,
+}
+
+// recordAudit is the bus subscriber that writes the audit log, bulk
+// changes included. Entries are written just after the change commits, so
+// a crash in between loses them.
+func (a *API) recordAudit(ctx context.Context, evs []events.Event) {
+	entries := make([]model.AuditEntry, 0, len(evs))
+	for _, ev := range evs {
+		action, ok := auditActions[ev.Kind]
+		if !ok {
+			continue
+		}
+		entries = append(entries, model.AuditEntry{
+			UserID: ev.UserID, EntityType: ev.Kind.Item(), EntityID: ev.ID,
+			Action: action, DeviceID: ev.DeviceID, At: ev.At,
+		})
+	}
+	if len(entries) == 0 {
+		return
+	}
+	if err := a.db.WithContext(ctx).AppendAudit(entries); err != nil {
+		slog.Error("append audit log", "entries", len(entries), "error", err)
+	}
+}
+
+// RunAuditRetention removes expired audit log entries hourly until ctx is
+// cancelled. It returns immediately when entries are kept forever.
+func (a *API) RunAuditRetention(ctx context.Context) {
+	if a.auditRetention == 0 {
+		return
+	}
+	ticker := time.NewTicker(auditPruneInterval)
+	defer ticker.Stop()
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case now := <-ticker.C:
+			a.pruneAuditLog(now)
+		}
+	}
+}
+
+func (a *API) pruneAuditLog(now time.Time) {
+	n, err := a.db.PruneAuditLog(now.Add(-a.auditRetention))
+	if err != nil {
+		slog.Error("prune audit log", "error", err)
+		return
+	}
+	if n > 0 {
+		slog.Info("audit log pruned", "entries", n)
+	}
+}
@@ -2,6 +2,7 @@ package api
 
 import (
 	"errors"
+	"fmt"
 	"log/slog"
 	"net/http"
 	"strings"
@@ -28,51 +29,41 @@ func (a *API) handleRegister(w http.ResponseWriter, r *http.Request) {
 		return
 	}
 
-	req.Email = strings.TrimSpace(strings.ToLower(req.Email))
-	req.DisplayName = strings.TrimSpace(req.DisplayName)
-	if req.Email == "" || req.Password == "" || req.DisplayName == "" {
-		writeError(w, http.StatusBadRequest, "email, password, and display_name are required")
-		return
-	}
-
-	if !isValidEmail(req.Email) {
-		writeError(w, http.StatusBadRequest, "invalid email address")
-		return
-	}
-	if utf8.RuneCountInString(req.Email) > maxEmailLen {
-		writeError(w, http.StatusBadRequest, "email too long")
-		return
-	}
-	if utf8.RuneCountInString(req.Password) < minPasswordLen {
-		writeError(w, http.StatusBadRequest, "password must be at least 8 characters")
-		return
-	}
-	if len(req.Password) > maxPasswordLen {
-		writeError(w, http.StatusBadRequest, "password too long")
+	user, err := NewUser(req.Email, req.DisplayName, req.Password)
+	var invalid InvalidUserError
+	if errors.As(err, &invalid) {
+		writeError(w, http.StatusBadRequest, invalid.Error())
 		return
 	}
-	if utf8.RuneCountInString(req.DisplayName) > maxDisplayName {
-		writeError(w, http.StatusBadRequest, "display name too long")
-		return
-	}
-
-	hash, err := bcrypt.GenerateFromPassword([]byte(req.Password), bcryptCost)
 	if err != nil {
-		slog.Error("bcrypt hash", "error", err)
+		slog.Error("new user", "error", err)
 		writeError(w, http.StatusInternalServerError, "internal error")
 		return
 	}
 
-	now := model.NowMillis()
-	user := &model.User{
-		ID:           model.NewID(),
-		Email:        req.Email,
-		PasswordHash: string(hash),
-		DisplayName:  strings.TrimSpace(req.DisplayName),
-		CreatedAt:    now,
+	mode := a.config.Auth.Registration
+	if a.isAdmin(user.Email) {
+		mode = "open" // so a closed instance can get its first admin
 	}
-
-	if err := a.db.CreateUser(user); err != nil {
+	switch mode {
+	case "closed":
+		writeError(w, http.StatusForbidden, "registration is closed")
+		return
+	case "invite":
+		code := strings.TrimSpace(req.InviteCode)
+		if code == "" {
+			writeError(w, http.StatusForbidden, "an invite code is required")
+			return
+		}
+		err = a.dbFor(r).CreateUserWithInvite(user, code)
+		if errors.Is(err, database.ErrNotFound) {
+			writeError(w, http.StatusForbidden, "invalid or expired invite code")
+			return
+		}
+	default:
+		err = a.dbFor(r).CreateUser(user)
+	}
+	if err != nil {
 		if errors.Is(err, database.ErrConflict) {
 			writeError(w, http.StatusConflict, "email already registered")
 			return
@@ -98,7 +89,7 @@ func (a *API) handleLogin(w http.ResponseWriter, r *http.Request) {
 		return
 	}
 
-	user, err := a.db.GetUserByEmail(req.Email)
+	user, err := a.dbFor(r).GetUserByEmail(req.Email)
 	if errors.Is(err, database.ErrNotFound) {
 		writeError(w, http.StatusUnauthorized, "invalid credentials")
 		return
@@ -114,7 +105,7 @@ func (a *API) handleLogin(w http.ResponseWriter, r *http.Request) {
 		return
 	}
 
-	resp, err := a.issueTokenPair(user, req.DeviceID)
+	resp, err := a.issueTokenPair(a.dbFor(r), user, req.DeviceID, nil)
 	if err != nil {
 		slog.Error("issue token pair", "error", err)
 		writeError(w, http.StatusInternalServerError, "internal error")
@@ -144,7 +135,7 @@ func (a *API) handleRefresh(w http.ResponseWriter, r *http.Request) {
 
 	// Look up stored token by hash
 	tokenHash := database.HashToken(req.RefreshToken)
-	stored, err := a.db.GetRefreshTokenByHash(tokenHash)
+	stored, err := a.dbFor(r).GetRefreshTokenByHash(tokenHash)
 	if errors.Is(err, database.ErrNotFound) {
 		writeError(w, http.StatusUnauthorized, "refresh token revoked")
 		return
@@ -159,19 +150,33 @@ func (a *API) handleRefresh(w http.ResponseWriter, r *http.Request) {
 		return
 	}
 
-	// Rotation: delete old token
-	if err := a.db.DeleteRefreshToken(stored.ID); err != nil {
-		slog.Error("delete old refresh token", "error", err)
+	// Rotation: a token is good for one refresh. Presenting it again means
+	// it was copied, so the whole family is revoked and whoever holds its
+	// latest token, legitimate client or thief, has to log in again.
+	reused := stored.RotatedAt != nil
+	if !reused {
+		err := a.dbFor(r).RotateRefreshToken(stored.ID)
+		reused = errors.Is(err, database.ErrNotFound) // lost a concurrent refresh
+		if err != nil && !reused {
+			slog.Error("rotate refresh token", "error", err)
+			writeError(w, http.StatusInternalServerError, "internal error")
+			return
+		}
+	}
+	if reused {
+		a.revokeRefreshFamily(r, stored)
+		writeError(w, http.StatusUnauthorized, "refresh token reused; session revoked")
+		return
 	}
 
-	user, err := a.db.GetUserByID(userID)
+	user, err := a.dbFor(r).GetUserByID(userID)
 	if err != nil {
 		slog.Error("get user for refresh", "error", err)
 		writeError(w, http.StatusUnauthorized, "user not found")
 		return
 	}
 
-	resp, err := a.issueTokenPair(user, deviceID)
+	resp, err := a.issueTokenPair(a.dbFor(r), user, deviceID, stored)
 	if err != nil {
 		slog.Error("issue token pair", "error", err)
 		writeError(w, http.StatusInternalServerError, "internal error")
@@ -181,9 +186,26 @@ func (a *API) handleRefresh(w http.ResponseWriter, r *http.Request) {
 	writeJSON(w, http.StatusOK, resp)
 }
 
+// revokeRefreshFamily deletes every token of stored's family after stored
+// was presented a second time, and logs the reuse as a security event.
+func (a *API) revokeRefreshFamily(r *http.Request, stored *model.RefreshToken) {
+	n, err := a.dbFor(r).DeleteRefreshTokenFamily(stored.FamilyID)
+	if err != nil {
+		slog.Error("revoke refresh token family", "error", err)
+	}
+	ip := r.RemoteAddr
+	if info := requestInfoFrom(r.Context()); info != nil {
+		ip = info.clientIP
+	}
+	slog.Warn("security: refresh token reuse, session revoked",
+		"user_id", stored.UserID, "device_id", stored.DeviceID,
+		"family_id", stored.FamilyID, "token_id", stored.ID,
+		"revoked", n, "client_ip", ip)
+}
+
 func (a *API) handleLogout(w http.ResponseWriter, r *http.Request) {
 	userID := userIDFrom(r.Context())
-	if err := a.db.DeleteRefreshTokensByUser(userID); err != nil {
+	if err := a.dbFor(r).DeleteRefreshTokensByUser(userID); err != nil {
 		slog.Error("delete refresh tokens on logout", "error", err)
 		writeError(w, http.StatusInternalServerError, "internal error")
 		return
@@ -191,6 +213,106 @@ func (a *API) handleLogout(w http.ResponseWriter, r *http.Request) {
 	w.WriteHeader(http.StatusNoContent)
 }
 
+// handleChangePassword replaces the user's password after checking the
+// current one. Refresh tokens of all other devices are revoked, so they have
+// to log in again once their access tokens expire, and so are personal
+// access tokens; the calling device stays logged in.
+func (a *API) handleChangePassword(w http.ResponseWriter, r *http.Request) {
+	userID := userIDFrom(r.Context())
+	deviceID := deviceIDFrom(r.Context())
+
+	var req model.ChangePasswordRequest
+	if err := decodeJSON(r, &req); err != nil {
+		writeError(w, http.StatusBadRequest, "invalid request body")
+		return
+	}
+	if req.CurrentPassword == "" || req.NewPassword == "" {
+		writeError(w, http.StatusBadRequest, "current_password and new_password are required")
+		return
+	}
+	if err := checkPassword(req.NewPassword); err != nil {
+		writeError(w, http.StatusBadRequest, err.Error())
+		return
+	}
+
+	db := a.dbFor(r)
+	user, err := db.GetUserByID(userID)
+	if err != nil {
+		slog.Error("get user for password change", "error", err)
+		writeError(w, http.StatusInternalServerError, "internal error")
+		return
+	}
+	if err := bcrypt.CompareHashAndPassword([]byte(user.PasswordHash), []byte(req.CurrentPassword)); err != nil {
+		writeError(w, http.StatusForbidden, "current password is incorrect")
+		return
+	}
+
+	hash, err := bcrypt.GenerateFromPassword([]byte(req.NewPassword), bcryptCost)
+	if err != nil {
+		slog.Error("bcrypt hash", "error", err)
+		writeError(w, http.StatusInternalServerError, "internal error")
+		return
+	}
+	if err := db.ChangePassword(userID, string(hash), deviceID); err != nil {
+		slog.Error("change password", "error", err)
+		writeError(w, http.StatusInternalServerError, "internal error")
+		return
+	}
+	slog.Info("password changed", "user_id", userID, "device_id", deviceID)
+	w.WriteHeader(http.StatusNoContent)
+}
+
+// InvalidUserError reports registration input that NewUser rejects. Its
+// message is meant for the person registering.
+type InvalidUserError string
+
+func (e InvalidUserError) Error() string { return string(e) }
+
+// NewUser checks registration input and returns a user with a new ID and a
+// hashed password, ready to be stored. The email is lowercased. Bad input
+// yields an InvalidUserError.
+func NewUser(email, displayName, password string) (*model.User, error) {
+	email = strings.TrimSpace(strings.ToLower(email))
+	displayName = strings.TrimSpace(displayName)
+	switch {
+	case email == "" || password == "" || displayName == "":
+		return nil, InvalidUserError("email, password, and display_name are required")
+	case !isValidEmail(email):
+		return nil, InvalidUserError("invalid email address")
+	case utf8.RuneCountInString(email) > maxEmailLen:
+		return nil, InvalidUserError("email too long")
+	case utf8.RuneCountInString(displayName) > maxDisplayName:
+		return nil, InvalidUserError("display name too long")
+	}
+	if err := checkPassword(password); err != nil {
+		return nil, err
+	}
+
+	hash, err := bcrypt.GenerateFromPassword([]byte(password), bcryptCost)
+	if err != nil {
+		return nil, fmt.Errorf("bcrypt hash: %w", err)
+	}
+	return &model.User{
+		ID:           model.NewID(),
+		Email:        email,
+		PasswordHash: string(hash),
+		DisplayName:  displayName,
+		CreatedAt:    model.NowMillis(),
+	}, nil
+}
+
+// checkPassword enforces the password length rules shared by registration
+// and password changes.
+func checkPassword(password string) error {
+	switch {
+	case utf8.RuneCountInString(password) < minPasswordLen:
+		return InvalidUserError("password must be at least 8 characters")
+	case len(password) > maxPasswordLen:
+		return InvalidUserError("password too long")
+	}
+	return nil
+}
+
 // isValidEmail checks for a basic valid email format (has exactly one @, non-empty parts).
 func isValidEmail(email string) bool {
 	at := strings.IndexByte(email, '@')
@@ -204,8 +326,10 @@ func isValidEmail(email string) bool {
 	return true
 }
 
-// issueTokenPair creates both access and refresh tokens and stores the refresh token.
-func (a *API) issueTokenPair(user *model.User, deviceID string) (*model.AuthResponse, error) {
+// issueTokenPair creates both access and refresh tokens and stores the refresh
+// token. parent is the refresh token being rotated, or nil at login, which
+// starts a new token family.
+func (a *API) issueTokenPair(db *database.DB, user *model.User, deviceID string, parent *model.RefreshToken) (*model.AuthResponse, error) {
 	accessToken, err := a.issueAccessToken(user.ID, deviceID)
 	if err != nil {
 		return nil, err
@@ -226,7 +350,10 @@ func (a *API) issueTokenPair(user *model.User, deviceID string) (*model.AuthResp
 		ExpiresAt: now.Add(a.refreshTokenExpiry),
 		CreatedAt: now,
 	}
-	if err := a.db.CreateRefreshToken(rt); err != nil {
+	if parent != nil {
+		rt.FamilyID, rt.ParentID = parent.FamilyID, parent.ID
+	}
+	if err := db.CreateRefreshToken(rt); err != nil {
 		return nil, err
 	}
 
@@ -0,0 +1,126 @@
+package api
+
+import (
+	"context"
+	"fmt"
+	"log/slog"
+	"net/http"
+	"os"
+	"path/filepath"
+	"slices"
+	"strings"
+	"time"
+
+	"github.com/c0dev0id/notesd/server/internal/database"
+	"github.com/c0dev0id/notesd/server/internal/model"
+)
+
+// Snapshots are named after their UTC creation time, so names sort in
+// creation order.
+const (
+	backupPrefix     = "notesd-"
+	backupSuffix     = ".db"
+	backupTimeLayout = "20060102T150405.000Z"
+)
+
+// handleAdminBackup writes a snapshot of the database to backup.dir and
+// rotates old snapshots.
+func (a *API) handleAdminBackup(w http.ResponseWriter, r *http.Request) {
+	if a.config.Backup.Dir == "" {
+		writeError(w, http.StatusNotFound, "backups are not configured on this server")
+		return
+	}
+	b, err := a.backup(a.dbFor(r), model.NowMillis())
+	if err != nil {
+		slog.Error("backup", "error", err)
+		writeError(w, http.StatusInternalServerError, "internal error")
+		return
+	}
+	writeJSON(w, http.StatusCreated, b)
+}
+
+// RunBackups writes a snapshot every backup.interval until ctx is
+// cancelled. It returns immediately when scheduled backups are off.
+func (a *API) RunBackups(ctx context.Context) {
+	if a.backupInterval == 0 || a.config.Backup.Dir == "" {
+		return
+	}
+	ticker := time.NewTicker(a.backupInterval)
+	defer ticker.Stop()
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case now := <-ticker.C:
+			b, err := a.backup(a.db, now)
+			if err != nil {
+				slog.Error("scheduled backup", "error", err)
+				continue
+			}
+			slog.Info("backup written", "name", b.Name, "size", b.Size)
+		}
+	}
+}
+
+// backup snapshots the database into backup.dir and deletes all but the
+// newest backup.keep snapshots. The snapshot is written under a temporary
+// name first, so rotation and restores never see a partial file.
+func (a *API) backup(db *database.DB, now time.Time) (model.Backup, error) {
+	a.backupMu.Lock()
+	defer a.backupMu.Unlock()
+
+	dir := a.config.Backup.Dir
+	if err := os.MkdirAll(dir, 0700); err != nil {
+		return model.Backup{}, fmt.Errorf("create backup dir: %w", err)
+	}
+	name := backupPrefix + now.UTC().Format(backupTimeLayout) + backupSuffix
+	path := filepath.Join(dir, name)
+	tmp := path + ".tmp"
+	os.Remove(tmp) // left over from a crash
+	if err := db.Backup(tmp); err != nil {
+		return model.Backup{}, err
+	}
+	if err := os.Rename(tmp, path); err != nil {
+		os.Remove(tmp)
+		return model.Backup{}, fmt.Errorf("rename backup: %w", err)
+	}
+	info, err := os.Stat(path)
+	if err != nil {
+		return model.Backup{}, err
+	}
+	if err := a.rotateBackups(); err != nil {
+		slog.Error("rotate backups", "error", err)
+	}
+	return model.Backup{Name: name, Size: info.Size(), CreatedAt: now}, nil
+}
+
+// rotateBackups removes the oldest snapshots beyond backup.keep. Files not
+// named like a snapshot are left alone.
+func (a *API) rotateBackups() error {
+	keep := a.config.Backup.Keep
+	if keep <= 0 {
+		return nil
+	}
+	entries, err := os.ReadDir(a.config.Backup.Dir)
+	if err != nil {
+		return err
+	}
+	var names []string
+	for _, e := range entries {
+		name := e.Name()
+		if e.Type().IsRegular() && strings.HasPrefix(name, backupPrefix) && strings.HasSuffix(name, backupSuffix) {
+			names = append(names, name)
+		}
+	}
+	if len(names) <= keep {
+		return nil
+	}
+	slices.Sort(names)
+	for _, name := range names[:len(names)-keep] {
+		if err := os.Remove(filepath.Join(a.config.Backup.Dir, name)); err != nil {
+			return err
+		}
+		slog.Info("backup rotated out", "name", name)
+	}
+	return nil
+}
@@ -0,0 +1,223 @@
+package api
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"log/slog"
+	"net/http"
+	"strconv"
+	"strings"
+	"time"
+	"unicode/utf8"
+
+	"github.com/c0dev0id/notesd/server/internal/database"
+	"github.com/c0dev0id/notesd/server/internal/events"
+	"github.com/c0dev0id/notesd/server/internal/frontmatter"
+	"github.com/c0dev0id/notesd/server/internal/model"
+	"github.com/c0dev0id/notesd/server/internal/unfurl"
+)
+
+// pageFetcher fetches the web pages of bookmarks and link previews;
+// *unfurl.Fetcher in production.
+type pageFetcher interface {
+	Fetch(ctx context.Context, rawURL string, text bool) (*unfurl.Page, error)
+}
+
+// handleCreateBookmark saves a web page as a bookmark note. A page that
+// cannot be fetched is saved by its URL alone and can be fetched again
+// with POST /notes/{id}/unfurl.
+func (a *API) handleCreateBookmark(w http.ResponseWriter, r *http.Request) {
+	userID := userIDFrom(r.Context())
+
+	var req model.CreateBookmarkRequest
+	if err := decodeJSON(r, &req); err != nil {
+		writeError(w, http.StatusBadRequest, "invalid request body")
+		return
+	}
+	if req.DeviceID == "" {
+		writeError(w, http.StatusBadRequest, "device_id is required")
+		return
+	}
+	u, err := unfurl.ParseURL(req.URL)
+	if err != nil {
+		writeError(w, http.StatusBadRequest, err.Error())
+		return
+	}
+	if utf8.RuneCountInString(req.Title) > maxTitleLen {
+		writeError(w, http.StatusBadRequest, "title too long")
+		return
+	}
+	tags, err := normalizeTags(req.Tags)
+	if err != nil {
+		writeError(w, http.StatusBadRequest, err.Error())
+		return
+	}
+	if tags == nil {
+		tags = []string{}
+	}
+
+	page, err := a.pages.Fetch(r.Context(), u.String(), req.Archive)
+	if writeFetchRefused(w, err) {
+		return
+	}
+	if err != nil {
+		slog.Info("fetch bookmark", "url", u.Redacted(), "error", err)
+		page = nil
+	}
+
+	now := model.NowMillis()
+	note := &model.Note{
+		ID:               model.NewID(),
+		UserID:           userID,
+		Title:            bookmarkTitle(req.Title, u.String(), page),
+		Content:          bookmarkContent(u.String(), page, now),
+		Type:             "bookmark",
+		Tags:             tags,
+		ModifiedAt:       now,
+		ModifiedByDevice: req.DeviceID,
+		CreatedAt:        now,
+	}
+	if !a.checkQuota(w, r, userID, usageDelta{notes: 1, contentBytes: noteBytes(note)}) {
+		return
+	}
+
+	if err := a.dbFor(r).CreateNote(note); err != nil {
+		slog.Error("create bookmark", "error", err)
+		writeError(w, http.StatusInternalServerError, "internal error")
+		return
+	}
+	a.bus.Publish(r.Context(), noteEvent(events.NoteCreated, userID, note))
+
+	writeJSON(w, http.StatusCreated, note)
+}
+
+// handleUnfurlNote fetches the page of a bookmark note again and rewrites
+// the note's content from it; ?archive=true keeps the page's text. The
+// URL is the url of the note's front matter or else its first line, so
+// bookmarks saved offline as a bare URL are filled in too. An untitled
+// note takes the page's title.
+func (a *API) handleUnfurlNote(w http.ResponseWriter, r *http.Request) {
+	userID := userIDFrom(r.Context())
+	id := r.PathValue("id")
+
+	note, err := a.dbFor(r).GetNote(id, userID)
+	if errors.Is(err, database.ErrNotFound) {
+		writeError(w, http.StatusNotFound, "note not found")
+		return
+	}
+	if err != nil {
+		slog.Error("get note for unfurl", "error", err)
+		writeError(w, http.StatusInternalServerError, "internal error")
+		return
+	}
+	if note.Type != "bookmark" {
+		writeError(w, http.StatusBadRequest, "note is not a bookmark")
+		return
+	}
+	u, err := unfurl.ParseURL(bookmarkURL(note))
+	if err != nil {
+		writeError(w, http.StatusBadRequest, "bookmark "+err.Error())
+		return
+	}
+
+	page, err := a.pages.Fetch(r.Context(), u.String(), r.URL.Query().Get("archive") == "true")
+	if writeFetchRefused(w, err) {
+		return
+	}
+	if err != nil {
+		writeError(w, http.StatusBadGateway, "fetch page: "+err.Error())
+		return
+	}
+
+	oldBytes := noteBytes(note)
+	now := model.NowMillis()
+	note.Title = bookmarkTitle(note.Title, u.String(), page)
+	note.Content = bookmarkContent(u.String(), page, now)
+	if !a.checkQuota(w, r, userID, usageDelta{contentBytes: noteBytes(note) - oldBytes}) {
+		return
+	}
+	note.ModifiedAt = now
+	note.ModifiedByDevice = deviceIDFrom(r.Context())
+
+	if err := a.dbFor(r).UpdateNote(note); err != nil {
+		slog.Error("update bookmark", "error", err)
+		writeError(w, http.StatusInternalServerError, "internal error")
+		return
+	}
+	a.bus.Publish(r.Context(), noteEvent(events.NoteUpdated, userID, note))
+
+	writeJSON(w, http.StatusOK, note)
+}
+
+// writeFetchRefused answers a URL the page fetcher refused, rather than
+// failed to fetch, and reports whether it was one: 400 for an address
+// that is not public and 403 for a host that unfurl.allow or unfurl.deny
+// excludes.
+func writeFetchRefused(w http.ResponseWriter, err error) bool {
+	switch {
+	case errors.Is(err, unfurl.ErrBlocked):
+		writeError(w, http.StatusBadRequest, "url must lead to a public address")
+	case errors.Is(err, unfurl.ErrDenied):
+		writeError(w, http.StatusForbidden, "url host is not allowed")
+	default:
+		return false
+	}
+	return true
+}
+
+// bookmarkURL returns the URL of a bookmark note: the url of its front
+// matter, or else the first line of its content.
+func bookmarkURL(n *model.Note) string {
+	if u, ok := n.Metadata["url"].(string); ok && u != "" {
+		return u
+	}
+	_, body := frontmatter.Parse(n.Content)
+	line, _, _ := strings.Cut(strings.TrimSpace(body), "\n")
+	return strings.TrimSpace(line)
+}
+
+// bookmarkTitle returns title, or else the title of the page, or else the
+// URL, cut to the longest title allowed.
+func bookmarkTitle(title, rawURL string, page *unfurl.Page) string {
+	if title == "" && page != nil {
+		title = page.Title
+	}
+	if title == "" {
+		title = rawURL
+	}
+	if utf8.RuneCountInString(title) > maxTitleLen {
+		title = string([]rune(title)[:maxTitleLen])
+	}
+	return title
+}
+
+// bookmarkContent renders a bookmark note: front matter with what the page
+// says about itself, which becomes the note's metadata, then the URL and
+// the archived text, if any. Without a page the content is the URL alone.
+// Strings are written as double-quoted scalars, as by the export.
+func bookmarkContent(rawURL string, page *unfurl.Page, fetched time.Time) string {
+	if page == nil {
+		return rawURL + "\n"
+	}
+	var b strings.Builder
+	b.WriteString("---\n")
+	fmt.Fprintf(&b, "url: %s\n", strconv.Quote(rawURL))
+	for _, f := range []struct{ key, value string }{
+		{"title", page.Title},
+		{"description", page.Description},
+		{"image", page.Image},
+		{"favicon", page.Favicon},
+	} {
+		if f.value != "" {
+			fmt.Fprintf(&b, "%s: %s\n", f.key, strconv.Quote(f.value))
+		}
+	}
+	fmt.Fprintf(&b, "fetched: %s\n", fetched.UTC().Format(time.RFC3339))
+	b.WriteString("---\n")
+	b.WriteString(rawURL + "\n")
+	if page.Text != "" {
+		b.WriteString("\n" + page.Text + "\n")
+	}
+	return b.String()
+}
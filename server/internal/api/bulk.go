@@ -0,0 +1,263 @@
+package api
+
+import (
+	"errors"
+	"fmt"
+	"log/slog"
+	"net/http"
+	"slices"
+	"strings"
+
+	"github.com/c0dev0id/notesd/server/internal/database"
+	"github.com/c0dev0id/notesd/server/internal/events"
+	"github.com/c0dev0id/notesd/server/internal/model"
+)
+
+// maxBulkItems limits the items of one bulk request.
+const maxBulkItems = 500
+
+// decodeBulk reads a bulk request, writing a 400 and returning false when
+// it is malformed.
+func decodeBulk(w http.ResponseWriter, r *http.Request) (model.BulkRequest, bool) {
+	var req model.BulkRequest
+	if err := decodeJSON(r, &req); err != nil {
+		writeError(w, http.StatusBadRequest, "invalid request body")
+		return req, false
+	}
+	if req.DeviceID == "" {
+		writeError(w, http.StatusBadRequest, "device_id is required")
+		return req, false
+	}
+	if len(req.Items) == 0 {
+		writeError(w, http.StatusBadRequest, "items is required")
+		return req, false
+	}
+	if len(req.Items) > maxBulkItems {
+		writeError(w, http.StatusBadRequest, fmt.Sprintf("too many items (max %d)", maxBulkItems))
+		return req, false
+	}
+	return req, true
+}
+
+// bulkTags returns tags with the item's tags added (action tag) or removed
+// (untag), ignoring case.
+func bulkTags(tags []string, it model.BulkItem) ([]string, error) {
+	if len(it.Tags) == 0 {
+		return nil, errors.New("tags is required")
+	}
+	change, err := normalizeTags(it.Tags)
+	if err != nil {
+		return nil, err
+	}
+	if it.Action == "tag" {
+		return normalizeTags(append(slices.Clone(tags), change...))
+	}
+	out := []string{}
+	for _, t := range tags {
+		if !slices.ContainsFunc(change, func(c string) bool { return strings.EqualFold(c, t) }) {
+			out = append(out, t)
+		}
+	}
+	return out, nil
+}
+
+// bulkFailed reports whether any item of a bulk request failed, answering
+// with the results if so.
+func bulkFailed(w http.ResponseWriter, results []model.BulkResult) bool {
+	if !slices.ContainsFunc(results, func(res model.BulkResult) bool { return res.Error != "" }) {
+		return false
+	}
+	writeJSON(w, http.StatusBadRequest, model.BulkResponse{
+		Error:   "some items failed; nothing was changed",
+		Results: results,
+	})
+	return true
+}
+
+// applyBulk writes the changes of a validated bulk request and answers it.
+// It returns false if nothing was written, and otherwise the todos changed
+// by deleting notes.
+func (a *API) applyBulk(w http.ResponseWriter, r *http.Request, bw database.BulkWrites, results []model.BulkResult) (database.CascadedTodos, bool) {
+	cascaded, err := a.dbFor(r).ApplyBulk(bw)
+	if errors.Is(err, database.ErrNotFound) {
+		writeError(w, http.StatusConflict, "an item was deleted meanwhile; nothing was changed")
+		return cascaded, false
+	}
+	if err != nil {
+		slog.Error("apply bulk", "error", err)
+		writeError(w, http.StatusInternalServerError, "internal error")
+		return cascaded, false
+	}
+	writeJSON(w, http.StatusOK, model.BulkResponse{Applied: true, Results: results})
+	return cascaded, true
+}
+
+// handleBulkNotes applies actions to many notes at once: delete, tag and
+// untag. The request is checked as a whole first; if any item fails,
+// nothing is changed.
+func (a *API) handleBulkNotes(w http.ResponseWriter, r *http.Request) {
+	userID := userIDFrom(r.Context())
+	req, ok := decodeBulk(w, r)
+	if !ok {
+		return
+	}
+
+	db := a.dbFor(r)
+	now := model.NowMillis()
+	var order []string
+	notes := map[string]*model.Note{}
+	prevs := map[string]model.Note{}
+	deleted, changed := map[string]bool{}, map[string]bool{}
+	results := make([]model.BulkResult, len(req.Items))
+	for i, it := range req.Items {
+		results[i] = model.BulkResult{ID: it.ID, Action: it.Action}
+		n, seen := notes[it.ID]
+		if !seen {
+			var err error
+			n, err = db.GetNote(it.ID, userID)
+			if err != nil && !errors.Is(err, database.ErrNotFound) {
+				slog.Error("get note for bulk", "error", err)
+				writeError(w, http.StatusInternalServerError, "internal error")
+				return
+			}
+			if n != nil {
+				order = append(order, n.ID)
+				notes[n.ID], prevs[n.ID] = n, *n
+			}
+		}
+		if n == nil || deleted[it.ID] {
+			results[i].Error = "note not found"
+			continue
+		}
+
+		switch it.Action {
+		case "delete":
+			deleted[n.ID] = true
+		case "tag", "untag":
+			tags, err := bulkTags(n.Tags, it)
+			if err != nil {
+				results[i].Error = err.Error()
+				continue
+			}
+			n.Tags = tags
+		default:
+			results[i].Error = fmt.Sprintf("unknown action %q", it.Action)
+			continue
+		}
+		n.ModifiedAt, n.ModifiedByDevice = now, req.DeviceID
+		changed[n.ID] = true
+	}
+	if bulkFailed(w, results) {
+		return
+	}
+
+	bw := database.BulkWrites{UserID: userID, DeviceID: req.DeviceID, At: now, NoteTodos: a.noteTodos()}
+	var evs []events.Event
+	for _, id := range order {
+		n, prev := notes[id], prevs[id]
+		switch {
+		case deleted[id]:
+			bw.DeleteNotes = append(bw.DeleteNotes, id)
+			evs = append(evs, itemEvent(events.NoteDeleted, userID, id, req.DeviceID))
+		case changed[id]:
+			bw.Notes = append(bw.Notes, n)
+			evs = append(evs, noteEvent(noteKind(&prev, n), userID, n))
+		}
+	}
+	if cascaded, ok := a.applyBulk(w, r, bw, results); ok {
+		a.bus.Publish(r.Context(), append(evs, cascadeEvents(userID, req.DeviceID, cascaded)...)...)
+	}
+}
+
+// handleBulkTodos applies actions to many todos at once: delete, complete,
+// reopen, tag, untag and move (to another note, or detached). The request
+// is checked as a whole first; if any item fails, nothing is changed.
+func (a *API) handleBulkTodos(w http.ResponseWriter, r *http.Request) {
+	userID := userIDFrom(r.Context())
+	req, ok := decodeBulk(w, r)
+	if !ok {
+		return
+	}
+
+	db := a.dbFor(r)
+	now := model.NowMillis()
+	var order []string
+	todos := map[string]*model.Todo{}
+	prevs := map[string]model.Todo{}
+	deleted, changed := map[string]bool{}, map[string]bool{}
+	results := make([]model.BulkResult, len(req.Items))
+	for i, it := range req.Items {
+		results[i] = model.BulkResult{ID: it.ID, Action: it.Action}
+		t, seen := todos[it.ID]
+		if !seen {
+			var err error
+			t, err = db.GetTodo(it.ID, userID)
+			if err != nil && !errors.Is(err, database.ErrNotFound) {
+				slog.Error("get todo for bulk", "error", err)
+				writeError(w, http.StatusInternalServerError, "internal error")
+				return
+			}
+			if t != nil {
+				order = append(order, t.ID)
+				todos[t.ID], prevs[t.ID] = t, *t
+			}
+		}
+		if t == nil || deleted[it.ID] {
+			results[i].Error = "todo not found"
+			continue
+		}
+
+		switch it.Action {
+		case "delete":
+			deleted[t.ID] = true
+		case "complete", "reopen":
+			t.Completed = it.Action == "complete"
+		case "tag", "untag":
+			tags, err := bulkTags(t.Tags, it)
+			if err != nil {
+				results[i].Error = err.Error()
+				continue
+			}
+			t.Tags = tags
+		case "move":
+			if it.NoteID == nil || *it.NoteID == "" {
+				t.NoteID = nil
+			} else if _, err := db.GetNote(*it.NoteID, userID); errors.Is(err, database.ErrNotFound) {
+				results[i].Error = "target note not found"
+				continue
+			} else if err != nil {
+				slog.Error("get note for bulk move", "error", err)
+				writeError(w, http.StatusInternalServerError, "internal error")
+				return
+			} else {
+				t.NoteID = it.NoteID
+			}
+			t.LineRef = nil
+		default:
+			results[i].Error = fmt.Sprintf("unknown action %q", it.Action)
+			continue
+		}
+		t.ModifiedAt, t.ModifiedByDevice = now, req.DeviceID
+		changed[t.ID] = true
+	}
+	if bulkFailed(w, results) {
+		return
+	}
+
+	bw := database.BulkWrites{UserID: userID, DeviceID: req.DeviceID, At: now}
+	var evs []events.Event
+	for _, id := range order {
+		t, prev := todos[id], prevs[id]
+		switch {
+		case deleted[id]:
+			bw.DeleteTodos = append(bw.DeleteTodos, id)
+			evs = append(evs, itemEvent(events.TodoDeleted, userID, id, req.DeviceID))
+		case changed[id]:
+			bw.Todos = append(bw.Todos, t)
+			evs = append(evs, todoEvent(todoKind(&prev, t), userID, t))
+		}
+	}
+	if _, ok := a.applyBulk(w, r, bw, results); ok {
+		a.bus.Publish(r.Context(), evs...)
+	}
+}
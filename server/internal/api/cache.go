@@ -0,0 +1,69 @@
+package api
+
+import (
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"log/slog"
+	"net/http"
+	"strings"
+	"time"
+)
+
+// writeCached answers a read with v as JSON, tagged with an ETag over the
+// body and, unless modified is zero, a Last-Modified time. A client whose
+// If-None-Match or If-Modified-Since shows it has this version already
+// gets a 304 without a body.
+//
+// Lists pass a zero modified: a deletion does not move the newest
+// modified_at forward, so only the ETag can tell their versions apart.
+func writeCached(w http.ResponseWriter, r *http.Request, modified time.Time, v any) {
+	body, err := json.Marshal(v)
+	if err != nil {
+		slog.Error("encode cached response", "error", err)
+		writeError(w, http.StatusInternalServerError, "internal error")
+		return
+	}
+	writeTagged(w, r, modified, "application/json", append(body, '\n'))
+}
+
+// writeTagged is writeCached for a body already encoded as contentType.
+func writeTagged(w http.ResponseWriter, r *http.Request, modified time.Time, contentType string, body []byte) {
+	sum := sha256.Sum256(body)
+	// Weak, as the compression middleware may re-encode the body.
+	etag := `W/"` + hex.EncodeToString(sum[:16]) + `"`
+
+	h := w.Header()
+	h.Set("ETag", etag)
+	h.Set("Cache-Control", "private, no-cache")
+	if !modified.IsZero() {
+		h.Set("Last-Modified", modified.UTC().Format(http.TimeFormat))
+	}
+	if notModified(r, etag, modified) {
+		w.WriteHeader(http.StatusNotModified)
+		return
+	}
+	h.Set("Content-Type", contentType)
+	w.WriteHeader(http.StatusOK)
+	w.Write(body)
+}
+
+// notModified evaluates the request's preconditions. If-None-Match takes
+// precedence and is compared weakly; If-Modified-Since has only second
+// precision, so clients should prefer the ETag.
+func notModified(r *http.Request, etag string, modified time.Time) bool {
+	if inm := r.Header.Get("If-None-Match"); inm != "" {
+		for _, tag := range strings.Split(inm, ",") {
+			tag = strings.TrimSpace(tag)
+			if tag == "*" || strings.TrimPrefix(tag, "W/") == strings.TrimPrefix(etag, "W/") {
+				return true
+			}
+		}
+		return false
+	}
+	if ims := r.Header.Get("If-Modified-Since"); ims != "" && !modified.IsZero() {
+		t, err := http.ParseTime(ims)
+		return err == nil && !modified.Truncate(time.Second).After(t)
+	}
+	return false
+}
@@ -0,0 +1,185 @@
+package api
+
+import (
+	"log/slog"
+	"net/http"
+	"time"
+
+	"github.com/c0dev0id/notesd/server/internal/model"
+)
+
+const (
+	calendarDateLayout = "2006-01-02"
+	calendarTimeLayout = "2006-01-02 15:04"
+	// maxCalendarDays bounds a single calendar request to roughly a year.
+	maxCalendarDays = 366
+)
+
+// queryDueBound parses a due-date filter bound from the query: a date
+// (midnight UTC) or an RFC 3339 time. It returns nil when key is absent.
+func queryDueBound(r *http.Request, key string) (*time.Time, error) {
+	s := r.URL.Query().Get(key)
+	if s == "" {
+		return nil, nil
+	}
+	t, err := time.Parse(calendarDateLayout, s)
+	if err != nil {
+		if t, err = time.Parse(time.RFC3339, s); err != nil {
+			return nil, err
+		}
+	}
+	return &t, nil
+}
+
+// dateOf returns the calendar date of t in loc as midnight UTC, the form in
+// which clients store due dates.
+func dateOf(t time.Time, loc *time.Location) time.Time {
+	y, m, d := t.In(loc).Date()
+	return time.Date(y, m, d, 0, 0, 0, 0, time.UTC)
+}
+
+// userLocation returns the time zone deciding which date is today for the
+// user: the tz query parameter, else the X-Timezone header, else the
+// account settings, UTC by default. It answers 400 for unknown zones.
+func (a *API) userLocation(w http.ResponseWriter, r *http.Request) (*time.Location, bool) {
+	tz := r.URL.Query().Get("tz")
+	if tz == "" {
+		tz = r.Header.Get("X-Timezone")
+	}
+	if tz == "" {
+		s, err := a.dbFor(r).GetAccountSettings(userIDFrom(r.Context()))
+		if err != nil {
+			slog.Error("get account settings", "error", err)
+			writeError(w, http.StatusInternalServerError, "internal error")
+			return nil, false
+		}
+		tz = s.Timezone
+	}
+	loc, err := time.LoadLocation(tz)
+	if err != nil {
+		writeError(w, http.StatusBadRequest, "unknown time zone")
+		return nil, false
+	}
+	return loc, true
+}
+
+// formatDue renders the due date of t: the day for all-day todos, the
+// day and time in loc otherwise.
+func formatDue(t *model.Todo, loc *time.Location) string {
+	if t.AllDay {
+		return t.DueDate.UTC().Format(calendarDateLayout)
+	}
+	return t.DueDate.In(loc).Format(calendarTimeLayout)
+}
+
+// isOverdue reports whether t, incomplete, is overdue at now: past its
+// due time, or for all-day todos due before the current date in loc.
+func isOverdue(t *model.Todo, now time.Time, loc *time.Location) bool {
+	if t.DueDate == nil {
+		return false
+	}
+	if t.AllDay {
+		return t.DueDate.Before(dateOf(now, loc))
+	}
+	return t.DueDate.Before(now)
+}
+
+// groupByDueDate buckets todos (already sorted by due day) into one entry
+// per calendar day: the day of all-day todos and the local date in loc of
+// timed ones. Days without todos are omitted.
+func groupByDueDate(todos []model.Todo, loc *time.Location) []model.CalendarDay {
+	days := []model.CalendarDay{}
+	for _, t := range todos {
+		if t.DueDate == nil {
+			continue
+		}
+		date := t.DueDay(loc).Format(calendarDateLayout)
+		if n := len(days); n > 0 && days[n-1].Date == date {
+			days[n-1].Todos = append(days[n-1].Todos, t)
+			continue
+		}
+		days = append(days, model.CalendarDay{Date: date, Todos: []model.Todo{t}})
+	}
+	return days
+}
+
+// handleTodoCalendar returns todos due between from and to (inclusive,
+// YYYY-MM-DD) grouped by day, so month and week views need one request.
+// Timed todos fall on their date in the user's time zone (userLocation).
+func (a *API) handleTodoCalendar(w http.ResponseWriter, r *http.Request) {
+	userID := userIDFrom(r.Context())
+	q := r.URL.Query()
+
+	from, err := time.Parse(calendarDateLayout, q.Get("from"))
+	if err != nil {
+		writeError(w, http.StatusBadRequest, "from must be a date (YYYY-MM-DD)")
+		return
+	}
+	to, err := time.Parse(calendarDateLayout, q.Get("to"))
+	if err != nil {
+		writeError(w, http.StatusBadRequest, "to must be a date (YYYY-MM-DD)")
+		return
+	}
+	if to.Before(from) {
+		writeError(w, http.StatusBadRequest, "to must not be before from")
+		return
+	}
+	end := to.AddDate(0, 0, 1)
+	if end.Sub(from) > maxCalendarDays*24*time.Hour {
+		writeError(w, http.StatusBadRequest, "calendar window too large")
+		return
+	}
+
+	loc, ok := a.userLocation(w, r)
+	if !ok {
+		return
+	}
+	todos, err := a.dbFor(r).GetTodosDueOnDays(userID, from, end, loc)
+	if err != nil {
+		slog.Error("get calendar todos", "error", err)
+		writeError(w, http.StatusInternalServerError, "internal error")
+		return
+	}
+
+	writeJSON(w, http.StatusOK, model.CalendarResponse{
+		From: from.Format(calendarDateLayout),
+		To:   to.Format(calendarDateLayout),
+		Days: groupByDueDate(todos, loc),
+	})
+}
+
+// handleTodosToday returns the todos due today.
+func (a *API) handleTodosToday(w http.ResponseWriter, r *http.Request) {
+	a.handleTodosDueDays(w, r, 1)
+}
+
+// handleTodosWeek returns the todos due in the seven days starting today.
+func (a *API) handleTodosWeek(w http.ResponseWriter, r *http.Request) {
+	a.handleTodosDueDays(w, r, 7)
+}
+
+// handleTodosDueDays returns live todos, completed or not, due within days
+// calendar days starting today. Which date is today follows userLocation,
+// as does the date timed todos fall on.
+func (a *API) handleTodosDueDays(w http.ResponseWriter, r *http.Request, days int) {
+	userID := userIDFrom(r.Context())
+
+	loc, ok := a.userLocation(w, r)
+	if !ok {
+		return
+	}
+	from := dateOf(model.NowMillis(), loc)
+	to := from.AddDate(0, 0, days)
+
+	todos, err := a.dbFor(r).GetTodosDueOnDays(userID, from, to, loc)
+	if err != nil {
+		slog.Error("get todos due", "days", days, "error", err)
+		writeError(w, http.StatusInternalServerError, "internal error")
+		return
+	}
+	if todos == nil {
+		todos = []model.Todo{}
+	}
+
+	writeJSON(w, http.StatusOK, todos)
+}
@@ -0,0 +1,126 @@
+package api
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"maps"
+	"slices"
+	"strings"
+	"unicode/utf8"
+
+	"github.com/c0dev0id/notesd/server/internal/model"
+)
+
+const (
+	maxChecklistItems   = 100
+	maxChecklistTextLen = 1000
+	maxChecklistIDLen   = 100
+)
+
+// normalizeChecklist trims the item texts and gives items without an ID a
+// new one. A nil input stays nil so that update requests can tell
+// "unchanged" from "clear".
+func normalizeChecklist(items []model.ChecklistItem) ([]model.ChecklistItem, error) {
+	if items == nil {
+		return nil, nil
+	}
+	if len(items) > maxChecklistItems {
+		return nil, fmt.Errorf("too many checklist items (max %d)", maxChecklistItems)
+	}
+	out := make([]model.ChecklistItem, 0, len(items))
+	seen := map[string]bool{}
+	for _, it := range items {
+		it.Text = strings.TrimSpace(it.Text)
+		switch {
+		case it.Text == "":
+			return nil, errors.New("checklist item text must not be empty")
+		case utf8.RuneCountInString(it.Text) > maxChecklistTextLen:
+			return nil, fmt.Errorf("checklist item text too long (max %d characters)", maxChecklistTextLen)
+		case len(it.ID) > maxChecklistIDLen:
+			return nil, errors.New("checklist item id too long")
+		case it.ID == "":
+			it.ID = model.NewID()
+		case seen[it.ID]:
+			return nil, fmt.Errorf("duplicate checklist item id %q", it.ID)
+		}
+		seen[it.ID] = true
+		out = append(out, it)
+	}
+	return out, nil
+}
+
+// checklist applies the checklist. An array replaces it as a whole and
+// null removes it. An object edits it item by item, keyed by item ID: a
+// member patches the text and done of that item, adding it at the end if
+// there is none, and null removes the item. Added items are appended in
+// the order of their IDs.
+func (p *mergePatch) checklist(dst *[]model.ChecklistItem) {
+	raw, present, null := p.take("checklist")
+	if !present {
+		return
+	}
+	var items []model.ChecklistItem
+	var edits map[string]json.RawMessage
+	switch {
+	case null:
+		items = []model.ChecklistItem{}
+	case json.Unmarshal(raw, &items) == nil:
+		if items == nil {
+			items = []model.ChecklistItem{}
+		}
+	case json.Unmarshal(raw, &edits) == nil:
+		items = slices.Clone(*dst)
+		errs := len(p.errs)
+		for _, id := range slices.Sorted(maps.Keys(edits)) {
+			items = p.checklistItem(items, id, edits[id])
+		}
+		if len(p.errs) > errs {
+			return
+		}
+	default:
+		p.fail("checklist", "must be an array, an object or null")
+		return
+	}
+	items, err := normalizeChecklist(items)
+	if err != nil {
+		p.fail("checklist", err.Error())
+		return
+	}
+	*dst = items
+}
+
+// checklistItem applies the patch of one checklist item to items.
+func (p *mergePatch) checklistItem(items []model.ChecklistItem, id string, raw json.RawMessage) []model.ChecklistItem {
+	name := "checklist." + id
+	i := slices.IndexFunc(items, func(it model.ChecklistItem) bool { return it.ID == id })
+	if string(raw) == "null" {
+		if i >= 0 {
+			items = slices.Delete(items, i, i+1)
+		}
+		return items
+	}
+	sub := &mergePatch{}
+	if err := json.Unmarshal(raw, &sub.fields); err != nil || sub.fields == nil {
+		p.fail(name, "must be an object or null")
+		return items
+	}
+	it := model.ChecklistItem{ID: id}
+	if i >= 0 {
+		it = items[i]
+	}
+	sub.string("text", &it.Text, maxChecklistTextLen)
+	sub.bool("done", &it.Done)
+	if err := sub.err("id"); err != nil {
+		for _, msg := range sub.errs {
+			p.errs = append(p.errs, name+"."+msg)
+		}
+		return items
+	}
+	if i >= 0 {
+		items[i] = it
+	} else {
+		items = append(items, it)
+	}
+	return items
+}
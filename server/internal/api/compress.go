@@ -0,0 +1,186 @@
+package api
+
+import (
+	"compress/gzip"
+	"io"
+	"mime"
+	"net/http"
+	"strconv"
+	"strings"
+	"sync"
+)
+
+// minGzipSize is the smallest response body worth compressing; smaller
+// ones go out as they are.
+const minGzipSize = 1024
+
+var gzipWriters = sync.Pool{New: func() any { return gzip.NewWriter(io.Discard) }}
+
+// compress gzips responses for clients that accept it and inflates gzip
+// request bodies. Body size limits such as decodeJSON's apply to the
+// inflated body, as handlers only ever read that.
+func compress(next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		switch enc := strings.ToLower(strings.TrimSpace(r.Header.Get("Content-Encoding"))); enc {
+		case "", "identity":
+		case "gzip":
+			zr, err := gzip.NewReader(r.Body)
+			if err != nil {
+				writeError(w, http.StatusBadRequest, "invalid gzip request body")
+				return
+			}
+			r.Body = struct {
+				io.Reader
+				io.Closer
+			}{zr, r.Body}
+			r.Header.Del("Content-Encoding")
+			r.Header.Del("Content-Length")
+			r.ContentLength = -1
+		default:
+			w.Header().Set("Accept-Encoding", "gzip")
+			writeError(w, http.StatusUnsupportedMediaType, "unsupported Content-Encoding "+strconv.Quote(enc))
+			return
+		}
+
+		w.Header().Add("Vary", "Accept-Encoding")
+		if r.Method == http.MethodHead || r.Header.Get("Upgrade") != "" || !acceptsGzip(r.Header.Get("Accept-Encoding")) {
+			next.ServeHTTP(w, r)
+			return
+		}
+		gw := &gzipWriter{ResponseWriter: w}
+		defer gw.close()
+		next.ServeHTTP(gw, r)
+	})
+}
+
+// acceptsGzip reports whether an Accept-Encoding header allows gzip, by
+// name or through "*", with a non-zero quality.
+func acceptsGzip(header string) bool {
+	anyOK := false
+	for _, part := range strings.Split(header, ",") {
+		coding, params, _ := strings.Cut(part, ";")
+		ok := true
+		if q, found := strings.CutPrefix(strings.ReplaceAll(params, " ", ""), "q="); found {
+			v, err := strconv.ParseFloat(q, 64)
+			ok = err == nil && v > 0
+		}
+		switch strings.ToLower(strings.TrimSpace(coding)) {
+		case "gzip":
+			return ok
+		case "*":
+			anyOK = ok
+		}
+	}
+	return anyOK
+}
+
+// compressible reports whether a response with header h and status code
+// should be gzipped: text and JSON that is not encoded already. Event
+// streams are left alone so that each event reaches the client at once.
+func compressible(h http.Header, code int) bool {
+	if code < 200 || code == http.StatusNoContent || code == http.StatusNotModified ||
+		h.Get("Content-Encoding") != "" {
+		return false
+	}
+	mt, _, err := mime.ParseMediaType(h.Get("Content-Type"))
+	if err != nil {
+		return false
+	}
+	switch {
+	case mt == "text/event-stream":
+		return false
+	case strings.HasPrefix(mt, "text/"), mt == "application/json", mt == "application/javascript",
+		mt == "application/xml", mt == "image/svg+xml", strings.HasSuffix(mt, "+json"):
+		return true
+	}
+	return false
+}
+
+// gzipWriter holds back the start of a compressible response until it is
+// known to reach minGzipSize, then compresses it; anything else passes
+// through untouched.
+type gzipWriter struct {
+	http.ResponseWriter
+	status int // 0 until WriteHeader
+	pass   bool
+	buf    []byte
+	gz     *gzip.Writer
+}
+
+func (w *gzipWriter) WriteHeader(code int) {
+	if w.status != 0 {
+		return
+	}
+	w.status = code
+	if !compressible(w.Header(), code) {
+		w.pass = true
+		w.ResponseWriter.WriteHeader(code)
+	}
+}
+
+func (w *gzipWriter) Write(b []byte) (int, error) {
+	if w.status == 0 {
+		w.WriteHeader(http.StatusOK)
+	}
+	switch {
+	case w.pass:
+		return w.ResponseWriter.Write(b)
+	case w.gz != nil:
+		return w.gz.Write(b)
+	}
+	w.buf = append(w.buf, b...)
+	if len(w.buf) >= minGzipSize {
+		if err := w.startGzip(); err != nil {
+			return 0, err
+		}
+	}
+	return len(b), nil
+}
+
+// startGzip sends the header of a compressed response and the body held
+// back so far.
+func (w *gzipWriter) startGzip() error {
+	h := w.Header()
+	h.Del("Content-Length")
+	h.Set("Content-Encoding", "gzip")
+	w.ResponseWriter.WriteHeader(w.status)
+	w.gz = gzipWriters.Get().(*gzip.Writer)
+	w.gz.Reset(w.ResponseWriter)
+	_, err := w.gz.Write(w.buf)
+	w.buf = nil
+	return err
+}
+
+// Flush sends what was written so far, compressing from then on if the
+// response is compressible.
+func (w *gzipWriter) Flush() {
+	if w.status == 0 {
+		w.WriteHeader(http.StatusOK)
+	}
+	if !w.pass {
+		if w.gz == nil {
+			w.startGzip()
+		}
+		w.gz.Flush()
+	}
+	http.NewResponseController(w.ResponseWriter).Flush()
+}
+
+// Unwrap lets http.ResponseController reach the connection, e.g. to set
+// deadlines.
+func (w *gzipWriter) Unwrap() http.ResponseWriter {
+	return w.ResponseWriter
+}
+
+// close finishes the response: a short body is sent uncompressed, a
+// compressed one is terminated.
+func (w *gzipWriter) close() {
+	switch {
+	case w.gz != nil:
+		w.gz.Close()
+		gzipWriters.Put(w.gz)
+	case w.status != 0 && !w.pass:
+		w.ResponseWriter.WriteHeader(w.status)
+		w.ResponseWriter.Write(w.buf)
+	}
+}
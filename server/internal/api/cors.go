@@ -0,0 +1,114 @@
+package api
+
+import (
+	"fmt"
+	"net/http"
+	"net/url"
+	"strings"
+)
+
+// corsPolicy decides which browser origins may call the API, from
+// server.cors_origins. Entries are origins such as "https://notes.example.com",
+// "https://*.example.com" for any subdomain, or "*" for every origin.
+// Listed origins are reflected and may send credentials; "*" allows other
+// origins without credentials only.
+type corsPolicy struct {
+	any       bool
+	exact     map[string]bool
+	wildcards []string // "https://.example.com": scheme plus host suffix
+}
+
+func newCORSPolicy(origins []string) (*corsPolicy, error) {
+	p := &corsPolicy{exact: map[string]bool{}}
+	for _, raw := range origins {
+		o := strings.ToLower(strings.TrimSuffix(strings.TrimSpace(raw), "/"))
+		if o == "*" {
+			p.any = true
+			continue
+		}
+		u, err := url.Parse(o)
+		if err != nil || (u.Scheme != "http" && u.Scheme != "https") || u.Host == "" ||
+			u.Path != "" || u.RawQuery != "" || u.User != nil {
+			return nil, fmt.Errorf("server.cors_origins: %q is not an origin like https://example.com", raw)
+		}
+		if rest, ok := strings.CutPrefix(u.Host, "*."); ok {
+			if rest == "" || strings.Contains(rest, "*") {
+				return nil, fmt.Errorf("server.cors_origins: bad wildcard in %q", raw)
+			}
+			p.wildcards = append(p.wildcards, u.Scheme+"://."+hostOnly(rest))
+			continue
+		}
+		if strings.Contains(u.Host, "*") {
+			return nil, fmt.Errorf("server.cors_origins: wildcards must start the host in %q", raw)
+		}
+		p.exact[o] = true
+	}
+	return p, nil
+}
+
+// listed reports whether origin matches an explicit or wildcard entry.
+func (p *corsPolicy) listed(origin string) bool {
+	origin = strings.ToLower(origin)
+	if p.exact[origin] {
+		return true
+	}
+	scheme, host, ok := strings.Cut(origin, "://")
+	if !ok {
+		return false
+	}
+	for _, w := range p.wildcards {
+		wScheme, suffix, _ := strings.Cut(w, "://")
+		// The suffix starts with a dot, so "example.com" itself does not
+		// match "*.example.com".
+		if scheme == wScheme && strings.HasSuffix(hostOnly(host), suffix) {
+			return true
+		}
+	}
+	return false
+}
+
+// hostOnly strips a port from host:port; wildcard entries match any port.
+func hostOnly(host string) string {
+	if i := strings.LastIndexByte(host, ':'); i >= 0 && !strings.Contains(host[i:], "]") {
+		return host[:i]
+	}
+	return host
+}
+
+// handler adds CORS headers for allowed origins and answers preflight
+// requests. Requests from other origins get no CORS headers, so browsers
+// keep their responses from the calling page. Other OPTIONS requests, such
+// as those of WebDAV clients, go on to next.
+func (p *corsPolicy) handler(next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		origin := r.Header.Get("Origin")
+		if p.any || len(p.exact) > 0 || len(p.wildcards) > 0 {
+			w.Header().Add("Vary", "Origin")
+		}
+		allowed := false
+		switch {
+		case origin == "":
+		case p.listed(origin):
+			w.Header().Set("Access-Control-Allow-Origin", origin)
+			w.Header().Set("Access-Control-Allow-Credentials", "true")
+			allowed = true
+		case p.any:
+			w.Header().Set("Access-Control-Allow-Origin", "*")
+			allowed = true
+		}
+		if allowed {
+			w.Header().Set("Access-Control-Expose-Headers", "X-Request-ID, Retry-After")
+		}
+
+		if r.Method == "OPTIONS" && origin != "" && r.Header.Get("Access-Control-Request-Method") != "" {
+			if allowed {
+				w.Header().Set("Access-Control-Allow-Methods", "GET, POST, PUT, PATCH, DELETE, OPTIONS")
+				w.Header().Set("Access-Control-Allow-Headers", "Content-Type, Content-Encoding, Authorization, traceparent, X-Timezone")
+				w.Header().Set("Access-Control-Max-Age", "600")
+			}
+			w.WriteHeader(http.StatusNoContent)
+			return
+		}
+		next.ServeHTTP(w, r)
+	})
+}
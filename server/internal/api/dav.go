@@ -0,0 +1,472 @@
+package api
+
+import (
+	"bytes"
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"io/fs"
+	"log/slog"
+	"net/http"
+	"os"
+	"slices"
+	"strings"
+	"time"
+	"unicode"
+	"unicode/utf8"
+
+	"github.com/c0dev0id/notesd/server/internal/database"
+	"github.com/c0dev0id/notesd/server/internal/events"
+	"github.com/c0dev0id/notesd/server/internal/frontmatter"
+	"github.com/c0dev0id/notesd/server/internal/model"
+	"golang.org/x/net/webdav"
+)
+
+// davPrefix is where the notes of the authenticated user appear as a WebDAV
+// collection, one Markdown file per note.
+const davPrefix = "/dav/notes"
+
+// maxDAVFile caps an uploaded file: the longest content in UTF-8 plus room
+// for the front matter.
+const maxDAVFile = 4*(maxContentLen+maxTitleLen) + 1<<16
+
+// davMethods are the methods the WebDAV handler serves.
+var davMethods = []string{
+	"GET", "PUT", "DELETE", "OPTIONS", "PROPFIND", "PROPPATCH",
+	"MKCOL", "COPY", "MOVE", "LOCK", "UNLOCK",
+}
+
+// davAuth lets WebDAV clients, which only speak basic auth, authenticate
+// with a personal access token as the password. The user name is ignored.
+// Sessions are not accepted, as passwords would then be sent on every
+// request.
+func (a *API) davAuth(next http.HandlerFunc) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Set("WWW-Authenticate", `Basic realm="notesd", charset="UTF-8"`)
+		_, token, ok := r.BasicAuth()
+		if !ok || !strings.HasPrefix(token, accessTokenPrefix) {
+			writeError(w, http.StatusUnauthorized, "a personal access token is required as the password")
+			return
+		}
+		r.Header.Set("Authorization", "Bearer "+token)
+		a.auth(func(w http.ResponseWriter, r *http.Request) {
+			w.Header().Del("WWW-Authenticate")
+			next(w, r)
+		})(w, r)
+	}
+}
+
+func (a *API) handleDAV(w http.ResponseWriter, r *http.Request) {
+	userID := userIDFrom(r.Context())
+	h := &webdav.Handler{
+		Prefix:     davPrefix,
+		FileSystem: &davFS{a: a, db: a.dbFor(r), userID: userID, deviceID: deviceIDFrom(r.Context())},
+		LockSystem: a.davLockSystem(userID),
+		Logger: func(r *http.Request, err error) {
+			if err != nil {
+				slog.Debug("webdav", "method", r.Method, "path", r.URL.Path, "error", err)
+			}
+		},
+	}
+	h.ServeHTTP(w, r)
+}
+
+// davLockSystem returns the WebDAV locks of a user. Lock paths are relative
+// to the user's own collection, so users cannot share one lock system.
+// Locks only live in memory; clients take them for the length of an edit.
+func (a *API) davLockSystem(userID string) webdav.LockSystem {
+	a.davMu.Lock()
+	defer a.davMu.Unlock()
+	if a.davLocks == nil {
+		a.davLocks = map[string]webdav.LockSystem{}
+	}
+	ls := a.davLocks[userID]
+	if ls == nil {
+		ls = webdav.NewMemLS()
+		a.davLocks[userID] = ls
+	}
+	return ls
+}
+
+// davFS is a webdav.FileSystem over the notes of one user, for one
+// request. The collection is flat: each note is a file named after its
+// title, holding the note as written by the export, front matter and all.
+// Writing a file updates its note, or creates one for a new name; the
+// front matter may be left out, and then the title, tags and type stay.
+// Only .md files can be created, and there are no subdirectories.
+type davFS struct {
+	a        *API
+	db       *database.DB
+	userID   string
+	deviceID string
+	names    map[string]string // lower-cased file name to note ID
+}
+
+// davStem turns a title into a file name stem, replacing the characters
+// that common file systems do not allow. Leading dots would hide the file.
+func davStem(title string) string {
+	var b strings.Builder
+	for _, r := range title {
+		if strings.ContainsRune(`/\:*?"<>|`, r) || unicode.IsControl(r) {
+			r = '-'
+		}
+		if b.Len()+utf8.RuneLen(r) > 80 {
+			break
+		}
+		b.WriteRune(r)
+	}
+	return strings.Trim(b.String(), " .")
+}
+
+// davFileNames names the notes with the given titles. Titles that lead to
+// the same name, ignoring case, get the start of the note ID added.
+func davFileNames(titles map[string]string) map[string]string {
+	stems := make(map[string]int, len(titles))
+	for _, t := range titles {
+		stems[strings.ToLower(davStem(t))]++
+	}
+	names := make(map[string]string, len(titles))
+	for id, t := range titles {
+		stem := davStem(t)
+		switch {
+		case stem == "":
+			names[id] = id + ".md"
+		case stems[strings.ToLower(stem)] > 1:
+			names[id] = stem + " " + id[:min(8, len(id))] + ".md"
+		default:
+			names[id] = stem + ".md"
+		}
+	}
+	return names
+}
+
+// davNewName reports whether a file of this name may be created.
+func davNewName(name string) bool {
+	return strings.HasSuffix(strings.ToLower(name), ".md") && !strings.HasPrefix(name, ".") && davTitle(name) != ""
+}
+
+// davTitle is the title of a note created or renamed as name, a .md file.
+func davTitle(name string) string {
+	return davStem(name[:len(name)-len(".md")])
+}
+
+// lookup returns the note a file name belongs to, or fs.ErrNotExist.
+func (f *davFS) lookup(name string) (*model.Note, error) {
+	if f.names == nil {
+		titles, err := f.db.NoteTitles(f.userID)
+		if err != nil {
+			return nil, err
+		}
+		f.names = map[string]string{}
+		for id, n := range davFileNames(titles) {
+			f.names[strings.ToLower(n)] = id
+		}
+	}
+	id, ok := f.names[strings.ToLower(name)]
+	if !ok {
+		return nil, fs.ErrNotExist
+	}
+	n, err := f.db.GetNote(id, f.userID)
+	if errors.Is(err, database.ErrNotFound) {
+		return nil, fs.ErrNotExist
+	}
+	return n, err
+}
+
+// davFileName splits a request path into the name of a file in the
+// collection, "" for the collection itself.
+func davFileName(name string) (string, error) {
+	name = strings.Trim(name, "/")
+	if strings.Contains(name, "/") {
+		return "", fs.ErrNotExist
+	}
+	return name, nil
+}
+
+func (f *davFS) Mkdir(ctx context.Context, name string, perm os.FileMode) error {
+	return fs.ErrPermission
+}
+
+func (f *davFS) OpenFile(ctx context.Context, name string, flag int, perm os.FileMode) (webdav.File, error) {
+	name, err := davFileName(name)
+	if err != nil {
+		return nil, err
+	}
+	write := flag&(os.O_WRONLY|os.O_RDWR) != 0
+	if name == "" {
+		if write {
+			return nil, fs.ErrPermission
+		}
+		return &davDir{fs: f}, nil
+	}
+	n, err := f.lookup(name)
+	switch {
+	case errors.Is(err, fs.ErrNotExist) && write && flag&os.O_CREATE != 0:
+		if !davNewName(name) {
+			return nil, fs.ErrPermission
+		}
+		return &davWriter{fs: f, ctx: ctx, name: name}, nil
+	case err != nil:
+		return nil, err
+	case write:
+		return &davWriter{fs: f, ctx: ctx, name: name, note: n}, nil
+	}
+	md := noteMarkdown(*n)
+	return &davReader{Reader: bytes.NewReader(md), info: davFileInfo(name, n, len(md))}, nil
+}
+
+func (f *davFS) RemoveAll(ctx context.Context, name string) error {
+	name, err := davFileName(name)
+	if err != nil {
+		return err
+	}
+	if name == "" {
+		return fs.ErrPermission
+	}
+	n, err := f.lookup(name)
+	if err != nil {
+		return err
+	}
+	cascaded, err := f.db.DeleteNote(n.ID, f.userID, model.NowMillis().UnixMilli(), f.deviceID, f.a.noteTodos())
+	if err != nil {
+		return err
+	}
+	f.names = nil
+	f.a.bus.Publish(ctx, append([]events.Event{itemEvent(events.NoteDeleted, f.userID, n.ID, f.deviceID)},
+		cascadeEvents(f.userID, f.deviceID, cascaded)...)...)
+	return nil
+}
+
+// Rename retitles a note after the new file name.
+func (f *davFS) Rename(ctx context.Context, oldName, newName string) error {
+	oldName, err := davFileName(oldName)
+	if err != nil {
+		return err
+	}
+	newName, err = davFileName(newName)
+	if err != nil {
+		return err
+	}
+	if oldName == "" || newName == "" || !davNewName(newName) {
+		return fs.ErrPermission
+	}
+	n, err := f.lookup(oldName)
+	if err != nil {
+		return err
+	}
+	if other, err := f.lookup(newName); err == nil && other.ID != n.ID {
+		return fs.ErrExist
+	}
+	title := davTitle(newName)
+	if title == n.Title {
+		return nil
+	}
+	n.Title = title
+	n.ModifiedAt, n.ModifiedByDevice = model.NowMillis(), f.deviceID
+	if err := f.db.UpdateNote(n); err != nil {
+		return err
+	}
+	f.names = nil
+	f.a.bus.Publish(ctx, noteEvent(events.NoteUpdated, f.userID, n))
+	return nil
+}
+
+func (f *davFS) Stat(ctx context.Context, name string) (os.FileInfo, error) {
+	name, err := davFileName(name)
+	if err != nil {
+		return nil, err
+	}
+	if name == "" {
+		return davInfo{name: "/", dir: true, modTime: time.Now()}, nil
+	}
+	n, err := f.lookup(name)
+	if err != nil {
+		return nil, err
+	}
+	return davFileInfo(name, n, len(noteMarkdown(*n))), nil
+}
+
+// save stores a written file in its note, or in a new note if there is
+// none.
+func (f *davFS) save(ctx context.Context, name string, n *model.Note, data string) error {
+	if !utf8.ValidString(data) {
+		return errors.New("file is not UTF-8 text")
+	}
+	fm, content := frontmatter.Parse(data)
+	d := usageDelta{}
+	if n == nil {
+		now := model.NowMillis()
+		n = &model.Note{
+			ID: model.NewID(), UserID: f.userID, Type: "note", Tags: []string{},
+			Title: davTitle(name), CreatedAt: now,
+		}
+		d.notes = 1
+	} else {
+		d.contentBytes = -noteBytes(n)
+	}
+	old := *n
+	n.Content = content
+	if t := fm.Get("title"); t != "" {
+		n.Title = t
+	}
+	if t := fm.Get("type"); f.a.validNoteType(t) {
+		n.Type = t
+	}
+	if _, ok := fm["tags"]; ok {
+		var tags []string
+		for _, t := range fm["tags"] {
+			if t = strings.TrimPrefix(strings.TrimSpace(t), "#"); t != "" {
+				tags = append(tags, t)
+			}
+		}
+		norm, err := normalizeTags(tags)
+		if err != nil {
+			return err
+		}
+		if norm == nil {
+			norm = []string{}
+		}
+		n.Tags = norm
+	}
+	if utf8.RuneCountInString(n.Title) > maxTitleLen {
+		return errors.New("title too long")
+	}
+	if utf8.RuneCountInString(n.Content) > maxContentLen {
+		return errors.New("content too long")
+	}
+	if d.notes == 0 && n.Title == old.Title && n.Content == old.Content && n.Type == old.Type &&
+		slices.Equal(n.Tags, old.Tags) {
+		return nil
+	}
+	d.contentBytes += noteBytes(n)
+	if msg, err := f.a.quotaExceeded(f.db, f.userID, d); err != nil {
+		return err
+	} else if msg != "" {
+		return errors.New(msg)
+	}
+
+	n.ModifiedAt, n.ModifiedByDevice = model.NowMillis(), f.deviceID
+	f.names = nil
+	if d.notes == 1 {
+		if err := f.db.CreateNote(n); err != nil {
+			return err
+		}
+		f.a.bus.Publish(ctx, noteEvent(events.NoteCreated, f.userID, n))
+		return nil
+	}
+	if err := f.db.UpdateNote(n); err != nil {
+		return err
+	}
+	f.a.bus.Publish(ctx, noteEvent(events.NoteUpdated, f.userID, n))
+	return nil
+}
+
+// davInfo describes a note file or the collection.
+type davInfo struct {
+	name    string
+	size    int64
+	modTime time.Time
+	dir     bool
+}
+
+func davFileInfo(name string, n *model.Note, size int) davInfo {
+	return davInfo{name: name, size: int64(size), modTime: n.ModifiedAt}
+}
+
+func (i davInfo) Name() string       { return i.name }
+func (i davInfo) Size() int64        { return i.size }
+func (i davInfo) ModTime() time.Time { return i.modTime }
+func (i davInfo) IsDir() bool        { return i.dir }
+func (i davInfo) Sys() any           { return nil }
+
+func (i davInfo) Mode() fs.FileMode {
+	if i.dir {
+		return fs.ModeDir | 0755
+	}
+	return 0644
+}
+
+// ContentType spares the webdav package from sniffing the content.
+func (i davInfo) ContentType(ctx context.Context) (string, error) {
+	return "text/markdown; charset=utf-8", nil
+}
+
+// davDir is the collection, listing every live note.
+type davDir struct {
+	fs   *davFS
+	done bool
+}
+
+func (d *davDir) Readdir(count int) ([]fs.FileInfo, error) {
+	if d.done {
+		if count > 0 {
+			return nil, io.EOF
+		}
+		return nil, nil
+	}
+	d.done = true
+	notes, err := d.fs.db.GetAllNotes(d.fs.userID)
+	if err != nil {
+		return nil, err
+	}
+	titles := make(map[string]string, len(notes))
+	for _, n := range notes {
+		titles[n.ID] = n.Title
+	}
+	names := davFileNames(titles)
+	infos := make([]fs.FileInfo, len(notes))
+	for i := range notes {
+		infos[i] = davFileInfo(names[notes[i].ID], &notes[i], len(noteMarkdown(notes[i])))
+	}
+	return infos, nil
+}
+
+func (d *davDir) Stat() (fs.FileInfo, error) {
+	return davInfo{name: "/", dir: true, modTime: time.Now()}, nil
+}
+
+func (d *davDir) Read(p []byte) (int, error)                   { return 0, fs.ErrInvalid }
+func (d *davDir) Write(p []byte) (int, error)                  { return 0, fs.ErrPermission }
+func (d *davDir) Seek(offset int64, whence int) (int64, error) { return 0, fs.ErrInvalid }
+func (d *davDir) Close() error                                 { return nil }
+
+// davReader is a note file opened for reading.
+type davReader struct {
+	*bytes.Reader
+	info davInfo
+}
+
+func (r *davReader) Readdir(count int) ([]fs.FileInfo, error) { return nil, fs.ErrInvalid }
+func (r *davReader) Stat() (fs.FileInfo, error)               { return r.info, nil }
+func (r *davReader) Write(p []byte) (int, error)              { return 0, fs.ErrPermission }
+func (r *davReader) Close() error                             { return nil }
+
+// davWriter collects a file being written and saves it to its note on
+// Close. Note is nil for a new file.
+type davWriter struct {
+	fs   *davFS
+	ctx  context.Context
+	name string
+	note *model.Note
+	buf  bytes.Buffer
+}
+
+func (w *davWriter) Write(p []byte) (int, error) {
+	if w.buf.Len()+len(p) > maxDAVFile {
+		return 0, fmt.Errorf("file larger than %d bytes", maxDAVFile)
+	}
+	return w.buf.Write(p)
+}
+
+func (w *davWriter) Close() error {
+	return w.fs.save(w.ctx, w.name, w.note, w.buf.String())
+}
+
+func (w *davWriter) Stat() (fs.FileInfo, error) {
+	return davInfo{name: w.name, size: int64(w.buf.Len()), modTime: time.Now()}, nil
+}
+
+func (w *davWriter) Read(p []byte) (int, error)                   { return 0, fs.ErrInvalid }
+func (w *davWriter) Seek(offset int64, whence int) (int64, error) { return 0, fs.ErrInvalid }
+func (w *davWriter) Readdir(count int) ([]fs.FileInfo, error)     { return nil, fs.ErrInvalid }
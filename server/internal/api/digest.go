@@ -0,0 +1,295 @@
+package api
+
+import (
+	"context"
+	"log/slog"
+	"math"
+	"net/http"
+	"strings"
+	"text/template"
+	"time"
+
+	"github.com/c0dev0id/notesd/server/internal/model"
+)
+
+const digestCheckInterval = time.Minute
+
+func (a *API) handleGetDigestSettings(w http.ResponseWriter, r *http.Request) {
+	userID := userIDFrom(r.Context())
+
+	s, err := a.dbFor(r).GetDigestSettings(userID)
+	if err != nil {
+		slog.Error("get digest settings", "error", err)
+		writeError(w, http.StatusInternalServerError, "internal error")
+		return
+	}
+
+	writeJSON(w, http.StatusOK, s)
+}
+
+func (a *API) handleUpdateDigestSettings(w http.ResponseWriter, r *http.Request) {
+	userID := userIDFrom(r.Context())
+
+	var req model.DigestSettings
+	if err := decodeJSON(r, &req); err != nil {
+		writeError(w, http.StatusBadRequest, "invalid request body")
+		return
+	}
+	if req.Frequency == "" {
+		req.Frequency = "weekly"
+	}
+	if req.Frequency != "daily" && req.Frequency != "weekly" {
+		writeError(w, http.StatusBadRequest, "frequency must be daily or weekly")
+		return
+	}
+	if req.Weekday < 0 || req.Weekday > 6 {
+		writeError(w, http.StatusBadRequest, "weekday must be 0 (Sunday) to 6 (Saturday)")
+		return
+	}
+	if req.Hour < 0 || req.Hour > 23 {
+		writeError(w, http.StatusBadRequest, "hour must be 0 to 23")
+		return
+	}
+	if req.Enabled && a.mailer == nil {
+		writeError(w, http.StatusBadRequest, "email is not configured on this server")
+		return
+	}
+
+	if err := a.dbFor(r).SaveDigestSettings(userID, req, model.NowMillis()); err != nil {
+		slog.Error("save digest settings", "error", err)
+		writeError(w, http.StatusInternalServerError, "internal error")
+		return
+	}
+
+	writeJSON(w, http.StatusOK, req)
+}
+
+// RunDigests sends due digests once a minute until ctx is cancelled. It
+// returns immediately when mail is not configured.
+func (a *API) RunDigests(ctx context.Context) {
+	if a.mailer == nil {
+		return
+	}
+	ticker := time.NewTicker(digestCheckInterval)
+	defer ticker.Stop()
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case now := <-ticker.C:
+			a.sendDueDigests(now)
+		}
+	}
+}
+
+// lastDigestSlot returns the most recent scheduled digest time at or before
+// now, in the user's timezone.
+func lastDigestSlot(s model.DigestSettings, loc *time.Location, now time.Time) time.Time {
+	local := now.In(loc)
+	days, back := 7, (int(local.Weekday())-s.Weekday+7)%7
+	if s.Frequency == "daily" {
+		days, back = 1, 0
+	}
+	slot := time.Date(local.Year(), local.Month(), local.Day()-back, s.Hour, 0, 0, 0, loc)
+	if slot.After(now) {
+		slot = slot.AddDate(0, 0, -days)
+	}
+	return slot
+}
+
+// sendDueDigests mails every user whose last scheduled slot has passed since
+// their previous digest. Empty digests are skipped but still marked as sent.
+func (a *API) sendDueDigests(now time.Time) {
+	recipients, err := a.db.ListDigestRecipients()
+	if err != nil {
+		slog.Error("list digest recipients", "error", err)
+		return
+	}
+
+	for _, rcpt := range recipients {
+		loc, err := time.LoadLocation(rcpt.Timezone)
+		if err != nil {
+			loc = time.UTC
+		}
+		if !rcpt.LastSentAt.Before(lastDigestSlot(rcpt.Settings, loc, now)) {
+			continue
+		}
+
+		d, err := a.collectDigest(rcpt.UserID, rcpt.Settings.Frequency == "daily", loc, now)
+		if err != nil {
+			slog.Error("collect digest", "user_id", rcpt.UserID, "error", err)
+			continue
+		}
+		if !d.empty() {
+			body, err := renderDigest(d, loc)
+			if err != nil {
+				slog.Error("render digest", "user_id", rcpt.UserID, "error", err)
+				continue
+			}
+			subject := "Your notesd " + d.kind() + " digest"
+			if err := a.mailer.Send(rcpt.Email, subject, body); err != nil {
+				slog.Error("send digest", "user_id", rcpt.UserID, "error", err)
+				continue
+			}
+		}
+		if err := a.db.MarkDigestSent(rcpt.UserID, now); err != nil {
+			slog.Error("mark digest sent", "user_id", rcpt.UserID, "error", err)
+		}
+	}
+}
+
+type digest struct {
+	daily     bool
+	overdue   []model.Todo
+	today     []model.Todo
+	upcoming  []model.Todo
+	notes     []model.Note
+	conflicts []model.ConflictRecord
+}
+
+func (d digest) empty() bool {
+	return len(d.overdue)+len(d.today)+len(d.upcoming)+len(d.notes)+len(d.conflicts) == 0
+}
+
+// kind returns "daily" or "weekly".
+func (d digest) kind() string {
+	if d.daily {
+		return "daily"
+	}
+	return "weekly"
+}
+
+// collectDigest gathers incomplete todos that are overdue, due later today
+// in loc and, for a weekly digest, due in the rest of the coming week; notes
+// created or edited since the previous digest; and unresolved conflicts.
+func (a *API) collectDigest(userID string, daily bool, loc *time.Location, now time.Time) (digest, error) {
+	d := digest{daily: daily}
+	period := 7 * 24 * time.Hour
+	if daily {
+		period = 24 * time.Hour
+	}
+	local := now.In(loc)
+	tomorrow := time.Date(local.Year(), local.Month(), local.Day()+1, 0, 0, 0, 0, loc)
+	end := tomorrow
+	if !daily {
+		end = now.Add(period)
+	}
+
+	// All-day todos count by their day: today's are due today until the
+	// day ends, and those on the day the window ends on are left out.
+	today, endDay := dateOf(now, loc), dateOf(end, loc)
+	due, err := a.db.GetTodosDueBetween(userID, math.MinInt64, max(end.UnixMilli(), endDay.UnixMilli()))
+	if err != nil {
+		return d, err
+	}
+	for _, t := range due {
+		switch {
+		case t.Completed:
+		case isOverdue(&t, now, loc):
+			d.overdue = append(d.overdue, t)
+		case t.AllDay && t.DueDate.Equal(today), !t.AllDay && t.DueDate.Before(tomorrow):
+			d.today = append(d.today, t)
+		case t.AllDay && t.DueDate.Before(endDay), !t.AllDay && t.DueDate.Before(end):
+			d.upcoming = append(d.upcoming, t)
+		}
+	}
+
+	changed, err := a.db.GetNoteChangesSince(userID, now.Add(-period).UnixMilli())
+	if err != nil {
+		return d, err
+	}
+	for _, n := range changed {
+		if n.DeletedAt == nil {
+			d.notes = append(d.notes, n)
+		}
+	}
+
+	if d.conflicts, err = a.db.GetUnresolvedConflicts(userID); err != nil {
+		return d, err
+	}
+	return d, nil
+}
+
+// digestTemplate renders a digest as plain text. Its data is a digestView.
+var digestTemplate = template.Must(template.New("digest").Parse(`Your notesd {{.Kind}} digest
+{{define "todos"}}{{range .}}  - {{.Content}} (due {{.Due}})
+{{end}}{{end}}
+{{- with .Overdue}}
+Overdue todos ({{len .}})
+{{template "todos" .}}{{end}}
+{{- with .Today}}
+Due today ({{len .}})
+{{template "todos" .}}{{end}}
+{{- with .Upcoming}}
+Due this week ({{len .}})
+{{template "todos" .}}{{end}}
+{{- with .Notes}}
+Notes created or edited this {{$.Period}} ({{len .}})
+{{range .}}  - {{.}}
+{{end}}{{end}}
+{{- with .Conflicts}}
+Unresolved sync conflicts ({{len .}})
+{{range .}}  - {{.ItemType}} {{.ItemID}}: changes from device {{.DeviceID}} on {{.At}} were overridden
+{{end}}{{end}}`))
+
+type digestView struct {
+	Kind, Period             string
+	Overdue, Today, Upcoming []digestTodo
+	Notes                    []string
+	Conflicts                []digestConflict
+}
+
+type digestTodo struct {
+	Content, Due string
+}
+
+type digestConflict struct {
+	ItemType, ItemID, DeviceID, At string
+}
+
+// renderDigest formats a digest with digestTemplate, times in loc. Todos
+// due today show their time, or "today" when all-day; others their day,
+// with the time unless all-day.
+func renderDigest(d digest, loc *time.Location) (string, error) {
+	v := digestView{Kind: d.kind(), Period: "week"}
+	if d.daily {
+		v.Period = "day"
+	}
+	// An empty dayLayout renders all-day todos as due "today".
+	todos := func(ts []model.Todo, dayLayout, timeLayout string) []digestTodo {
+		out := make([]digestTodo, len(ts))
+		for i, t := range ts {
+			due := t.DueDate.In(loc).Format(timeLayout)
+			switch {
+			case t.AllDay && dayLayout == "":
+				due = "today"
+			case t.AllDay:
+				due = t.DueDate.UTC().Format(dayLayout)
+			}
+			out[i] = digestTodo{Content: t.Content, Due: due}
+		}
+		return out
+	}
+	v.Overdue = todos(d.overdue, "Mon Jan 2", "Mon Jan 2 15:04")
+	v.Today = todos(d.today, "", "15:04")
+	v.Upcoming = todos(d.upcoming, "Mon Jan 2", "Mon Jan 2 15:04")
+	for _, n := range d.notes {
+		title := n.Title
+		if title == "" {
+			title = "(untitled)"
+		}
+		v.Notes = append(v.Notes, title)
+	}
+	for _, c := range d.conflicts {
+		v.Conflicts = append(v.Conflicts, digestConflict{
+			ItemType: c.ItemType, ItemID: c.ItemID, DeviceID: c.DeviceID,
+			At: c.CreatedAt.In(loc).Format("Mon Jan 2 15:04"),
+		})
+	}
+
+	var b strings.Builder
+	if err := digestTemplate.Execute(&b, v); err != nil {
+		return "", err
+	}
+	return b.String(), nil
+}
@@ -0,0 +1,219 @@
+package api
+
+import (
+	"crypto/sha256"
+	"encoding/hex"
+	"errors"
+	"log/slog"
+	"net/http"
+	"sort"
+	"strings"
+	"unicode/utf8"
+
+	"github.com/c0dev0id/notesd/server/internal/database"
+	"github.com/c0dev0id/notesd/server/internal/events"
+	"github.com/c0dev0id/notesd/server/internal/model"
+)
+
+// normalizedHash hashes text after lowercasing and collapsing whitespace, so
+// copies that differ only in formatting noise (typical after repeated
+// imports) hash identically. Empty text yields an empty hash.
+func normalizedHash(s string) string {
+	fields := strings.Fields(strings.ToLower(s))
+	if len(fields) == 0 {
+		return ""
+	}
+	h := sha256.Sum256([]byte(strings.Join(fields, " ")))
+	return hex.EncodeToString(h[:])
+}
+
+// findDuplicates groups notes sharing a normalized title or content hash.
+// Groups are transitive (union-find): if A matches B by title and B matches
+// C by content, all three land in one group.
+func findDuplicates(notes []model.Note) []model.DuplicateGroup {
+	parent := make([]int, len(notes))
+	for i := range parent {
+		parent[i] = i
+	}
+	var find func(int) int
+	find = func(i int) int {
+		if parent[i] != i {
+			parent[i] = find(parent[i])
+		}
+		return parent[i]
+	}
+
+	keys := []struct {
+		reason string
+		key    func(model.Note) string
+	}{
+		{"title", func(n model.Note) string { return strings.ToLower(strings.TrimSpace(n.Title)) }},
+		{"content", func(n model.Note) string { return normalizedHash(n.Content) }},
+	}
+
+	// First pass: union notes sharing any key.
+	buckets := make([]map[string][]int, len(keys))
+	for k, kf := range keys {
+		buckets[k] = map[string][]int{}
+		for i, n := range notes {
+			if key := kf.key(n); key != "" {
+				buckets[k][key] = append(buckets[k][key], i)
+			}
+		}
+		for _, idx := range buckets[k] {
+			for _, i := range idx[1:] {
+				parent[find(i)] = find(idx[0])
+			}
+		}
+	}
+
+	// Second pass: attribute match reasons to the final group roots.
+	reasons := map[int]map[string]bool{}
+	for k, kf := range keys {
+		for _, idx := range buckets[k] {
+			if len(idx) < 2 {
+				continue
+			}
+			root := find(idx[0])
+			if reasons[root] == nil {
+				reasons[root] = map[string]bool{}
+			}
+			reasons[root][kf.reason] = true
+		}
+	}
+
+	members := map[int][]model.Note{}
+	var roots []int
+	for i := range notes {
+		r := find(i)
+		if members[r] == nil {
+			roots = append(roots, r)
+		}
+		members[r] = append(members[r], notes[i])
+	}
+
+	groups := []model.DuplicateGroup{}
+	for _, r := range roots {
+		if len(members[r]) < 2 {
+			continue
+		}
+		g := model.DuplicateGroup{Notes: members[r]}
+		for reason := range reasons[r] {
+			g.Reasons = append(g.Reasons, reason)
+		}
+		sort.Strings(g.Reasons)
+		groups = append(groups, g)
+	}
+	return groups
+}
+
+func (a *API) handleFindDuplicates(w http.ResponseWriter, r *http.Request) {
+	userID := userIDFrom(r.Context())
+
+	notes, err := a.dbFor(r).GetAllNotes(userID)
+	if err != nil {
+		slog.Error("get notes for duplicates", "error", err)
+		writeError(w, http.StatusInternalServerError, "internal error")
+		return
+	}
+
+	writeJSON(w, http.StatusOK, model.DuplicatesResponse{Groups: findDuplicates(notes)})
+}
+
+// handleMergeNotes folds the source notes into the target note. The target
+// keeps its title (or adopts the first non-empty source title), gains every
+// source content that is not already a normalized duplicate of existing
+// content, the union of all tags, and inherits the oldest created_at.
+// Todos and attachments of a source are re-pointed at the target; sources
+// are soft-deleted.
+func (a *API) handleMergeNotes(w http.ResponseWriter, r *http.Request) {
+	userID := userIDFrom(r.Context())
+	id := r.PathValue("id")
+
+	var req model.MergeNotesRequest
+	if err := decodeJSON(r, &req); err != nil {
+		writeError(w, http.StatusBadRequest, "invalid request body")
+		return
+	}
+	if req.DeviceID == "" {
+		writeError(w, http.StatusBadRequest, "device_id is required")
+		return
+	}
+	if len(req.SourceIDs) == 0 {
+		writeError(w, http.StatusBadRequest, "source_ids is required")
+		return
+	}
+
+	target, err := a.dbFor(r).GetNote(id, userID)
+	if errors.Is(err, database.ErrNotFound) {
+		writeError(w, http.StatusNotFound, "note not found")
+		return
+	}
+	if err != nil {
+		slog.Error("get note for merge", "error", err)
+		writeError(w, http.StatusInternalServerError, "internal error")
+		return
+	}
+
+	seenContent := map[string]bool{normalizedHash(target.Content): true}
+	seenID := map[string]bool{target.ID: true}
+	for _, sid := range req.SourceIDs {
+		if seenID[sid] {
+			writeError(w, http.StatusBadRequest, "source_ids must be distinct and exclude the target")
+			return
+		}
+		seenID[sid] = true
+
+		src, err := a.dbFor(r).GetNote(sid, userID)
+		if errors.Is(err, database.ErrNotFound) {
+			writeError(w, http.StatusNotFound, "source note not found: "+sid)
+			return
+		}
+		if err != nil {
+			slog.Error("get source note for merge", "error", err)
+			writeError(w, http.StatusInternalServerError, "internal error")
+			return
+		}
+
+		if target.Title == "" {
+			target.Title = src.Title
+		}
+		if h := normalizedHash(src.Content); !seenContent[h] {
+			seenContent[h] = true
+			if target.Content == "" {
+				target.Content = src.Content
+			} else {
+				target.Content += "\n\n" + src.Content
+			}
+		}
+		target.Tags = append(target.Tags, src.Tags...)
+		if src.CreatedAt.Before(target.CreatedAt) {
+			target.CreatedAt = src.CreatedAt
+		}
+	}
+
+	if utf8.RuneCountInString(target.Content) > maxContentLen {
+		writeError(w, http.StatusBadRequest, "merged content too long")
+		return
+	}
+	if target.Tags, err = normalizeTags(target.Tags); err != nil {
+		writeError(w, http.StatusBadRequest, "merged "+err.Error())
+		return
+	}
+
+	target.ModifiedAt = model.NowMillis()
+	target.ModifiedByDevice = req.DeviceID
+	moved, err := a.dbFor(r).MergeNotes(target, req.SourceIDs)
+	if err != nil {
+		slog.Error("merge notes", "error", err)
+		writeError(w, http.StatusInternalServerError, "internal error")
+		return
+	}
+	evs := []events.Event{noteEvent(events.NoteUpdated, userID, target)}
+	for _, id := range req.SourceIDs {
+		evs = append(evs, itemEvent(events.NoteDeleted, userID, id, req.DeviceID))
+	}
+	a.bus.Publish(r.Context(), append(evs, bulkEvents(events.TodoUpdated, userID, req.DeviceID, moved)...)...)
+
+	writeJSON(w, http.StatusOK, target)
+}
@@ -0,0 +1,287 @@
+package api
+
+import (
+	"encoding/base64"
+	"encoding/xml"
+	"errors"
+	"fmt"
+	"io"
+	"mime"
+	"regexp"
+	"strings"
+
+	"github.com/c0dev0id/notesd/server/internal/model"
+)
+
+type enexNote struct {
+	Title     string         `xml:"title"`
+	Content   string         `xml:"content"`
+	Created   string         `xml:"created"`
+	Tags      []string       `xml:"tag"`
+	Resources []enexResource `xml:"resource"`
+}
+
+type enexResource struct {
+	Data     string `xml:"data"`
+	Mime     string `xml:"mime"`
+	FileName string `xml:"resource-attributes>file-name"`
+}
+
+// parseENEX reads an Evernote export. Note bodies are converted from ENML
+// to Markdown and resources become attachments.
+func parseENEX(r io.Reader) (*importData, error) {
+	dec := xml.NewDecoder(r)
+	data := &importData{}
+	root := false
+	for {
+		tok, err := dec.Token()
+		if err == io.EOF {
+			break
+		}
+		if err != nil {
+			return nil, fmt.Errorf("enex: %w", err)
+		}
+		se, ok := tok.(xml.StartElement)
+		if !ok {
+			continue
+		}
+		if se.Name.Local == "en-export" {
+			root = true
+			continue
+		}
+		if se.Name.Local != "note" {
+			continue
+		}
+		var en enexNote
+		if err := dec.DecodeElement(&en, &se); err != nil {
+			return nil, fmt.Errorf("enex: %w", err)
+		}
+		n := importNote{Note: model.Note{
+			Title:   strings.TrimSpace(en.Title),
+			Content: enmlToMarkdown(en.Content),
+			Tags:    en.Tags,
+		}}
+		if t, ok := parseImportTime(en.Created); ok {
+			n.CreatedAt = t
+		}
+		for i, res := range en.Resources {
+			name := res.FileName
+			if name == "" {
+				name = fmt.Sprintf("attachment-%d", i+1)
+				if exts, _ := mime.ExtensionsByType(res.Mime); len(exts) > 0 {
+					name += exts[0]
+				}
+			}
+			// Evernote wraps the base64 data in lines.
+			encoded := strings.Join(strings.Fields(res.Data), "")
+			n.attachments = append(n.attachments, importFile{
+				name:        name,
+				contentType: res.Mime,
+				size:        int64(base64.StdEncoding.DecodedLen(len(encoded))),
+				open: func() (io.ReadCloser, error) {
+					return io.NopCloser(base64.NewDecoder(base64.StdEncoding, strings.NewReader(encoded))), nil
+				},
+			})
+		}
+		data.notes = append(data.notes, n)
+	}
+	if !root {
+		return nil, errors.New("not an enex file")
+	}
+	return data, nil
+}
+
+var (
+	enmlTrailingSpace = regexp.MustCompile(`[ \t]+\n`)
+	enmlBlankLines    = regexp.MustCompile(`\n{3,}`)
+)
+
+// enmlToMarkdown converts an ENML note body (XHTML) to Markdown. It covers
+// the markup the Evernote editor produces: blocks, headings, lists,
+// checkboxes, emphasis, links and code. Encrypted sections and media
+// references are dropped; media arrive as attachments.
+func enmlToMarkdown(enml string) string {
+	dec := xml.NewDecoder(strings.NewReader(enml))
+	dec.Strict = false
+	dec.AutoClose = xml.HTMLAutoClose
+	dec.Entity = xml.HTMLEntity
+
+	var b strings.Builder
+	var lists, hrefs []string
+	skip, pre, item := 0, 0, 0
+
+	// endLine makes the output end with at least n newlines.
+	endLine := func(n int) {
+		s := b.String()
+		if s == "" {
+			return
+		}
+		for have := len(s) - len(strings.TrimRight(s, "\n")); have < n; have++ {
+			b.WriteByte('\n')
+		}
+	}
+	// spaced reports whether the output ends where a space is redundant.
+	spaced := func() bool {
+		s := b.String()
+		return s == "" || s[len(s)-1] == '\n' || s[len(s)-1] == ' '
+	}
+
+	for {
+		tok, err := dec.Token()
+		if err != nil {
+			break
+		}
+		switch t := tok.(type) {
+		case xml.StartElement:
+			name := strings.ToLower(t.Name.Local)
+			if skip > 0 || name == "en-crypt" || name == "style" || name == "script" {
+				skip++
+				continue
+			}
+			switch name {
+			case "div", "p", "blockquote":
+				if item == 0 {
+					endLine(1)
+				}
+			case "br":
+				if item == 0 {
+					b.WriteByte('\n')
+				}
+			case "h1", "h2", "h3", "h4", "h5", "h6":
+				endLine(2)
+				b.WriteString(strings.Repeat("#", int(name[1]-'0')) + " ")
+			case "hr":
+				endLine(2)
+				b.WriteString("---\n\n")
+			case "ul", "ol":
+				endLine(1)
+				lists = append(lists, name)
+			case "li":
+				endLine(1)
+				marker := "- "
+				if len(lists) > 0 && lists[len(lists)-1] == "ol" {
+					marker = "1. "
+				}
+				b.WriteString(strings.Repeat("  ", max(len(lists)-1, 0)) + marker)
+				item++
+			case "en-todo":
+				if item == 0 {
+					endLine(1)
+					b.WriteString("- ")
+				}
+				checked := false
+				for _, attr := range t.Attr {
+					if attr.Name.Local == "checked" && attr.Value == "true" {
+						checked = true
+					}
+				}
+				if checked {
+					b.WriteString("[x] ")
+				} else {
+					b.WriteString("[ ] ")
+				}
+			case "b", "strong":
+				b.WriteString("**")
+			case "i", "em":
+				b.WriteString("*")
+			case "s", "strike", "del":
+				b.WriteString("~~")
+			case "code":
+				if pre == 0 {
+					b.WriteString("`")
+				}
+			case "pre":
+				endLine(2)
+				b.WriteString("```\n")
+				pre++
+			case "a":
+				href := ""
+				for _, attr := range t.Attr {
+					if attr.Name.Local == "href" {
+						href = attr.Value
+					}
+				}
+				hrefs = append(hrefs, href)
+				b.WriteString("[")
+			}
+		case xml.EndElement:
+			name := strings.ToLower(t.Name.Local)
+			if skip > 0 {
+				skip--
+				continue
+			}
+			switch name {
+			case "div", "p", "blockquote", "h1", "h2", "h3", "h4", "h5", "h6":
+				if item == 0 {
+					endLine(2)
+				}
+			case "ul", "ol":
+				if len(lists) > 0 {
+					lists = lists[:len(lists)-1]
+				}
+				if len(lists) == 0 {
+					endLine(2)
+				}
+			case "li":
+				item = max(item-1, 0)
+				endLine(1)
+			case "b", "strong":
+				b.WriteString("**")
+			case "i", "em":
+				b.WriteString("*")
+			case "s", "strike", "del":
+				b.WriteString("~~")
+			case "code":
+				if pre == 0 {
+					b.WriteString("`")
+				}
+			case "pre":
+				endLine(1)
+				b.WriteString("```\n\n")
+				pre = max(pre-1, 0)
+			case "a":
+				href := ""
+				if len(hrefs) > 0 {
+					href = hrefs[len(hrefs)-1]
+					hrefs = hrefs[:len(hrefs)-1]
+				}
+				if href != "" {
+					b.WriteString("](" + href + ")")
+				} else {
+					b.WriteString("]")
+				}
+			}
+		case xml.CharData:
+			if skip > 0 {
+				continue
+			}
+			s := string(t)
+			if pre > 0 {
+				b.WriteString(s)
+				continue
+			}
+			words := strings.Fields(s)
+			if len(words) == 0 {
+				if s != "" && !spaced() {
+					b.WriteByte(' ')
+				}
+				continue
+			}
+			if isSpaceByte(s[0]) && !spaced() {
+				b.WriteByte(' ')
+			}
+			b.WriteString(strings.Join(words, " "))
+			if isSpaceByte(s[len(s)-1]) {
+				b.WriteByte(' ')
+			}
+		}
+	}
+
+	out := enmlTrailingSpace.ReplaceAllString(b.String(), "\n")
+	out = enmlBlankLines.ReplaceAllString(out, "\n\n")
+	return strings.TrimSpace(out)
+}
+
+func isSpaceByte(c byte) bool {
+	return c == ' ' || c == '\t' || c == '\n' || c == '\r'
+}
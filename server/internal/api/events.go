@@ -0,0 +1,419 @@
+package api
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"log/slog"
+	"net/http"
+	"slices"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/c0dev0id/notesd/server/internal/events"
+	"github.com/c0dev0id/notesd/server/internal/model"
+	"github.com/c0dev0id/notesd/server/internal/websocket"
+)
+
+const (
+	// subscriberBuffer is how many events may queue for one connection.
+	// A subscriber that falls further behind is dropped and reconnects.
+	subscriberBuffer = 64
+	// hubHistory is how many recent events per user are at least kept for
+	// event stream clients resuming with Last-Event-ID.
+	hubHistory     = 256
+	wsPingInterval = 30 * time.Second
+	wsWriteTimeout = 10 * time.Second
+)
+
+// hub fans change events out to the live connections of each user and
+// remembers the latest ones so event streams can resume. Event IDs are
+// "<epoch>-<seq>"; the epoch changes with every server start.
+type hub struct {
+	mu      sync.Mutex
+	subs    map[string]map[chan model.ChangeEvent]struct{}
+	epoch   string
+	seq     uint64
+	history map[string][]model.ChangeEvent
+	dropped map[string]uint64 // last sequence evicted from a history
+}
+
+func newHub() *hub {
+	return &hub{
+		subs:    make(map[string]map[chan model.ChangeEvent]struct{}),
+		epoch:   strconv.FormatInt(time.Now().UnixNano(), 36),
+		history: make(map[string][]model.ChangeEvent),
+		dropped: make(map[string]uint64),
+	}
+}
+
+// subscribe registers a connection of userID. The returned channel is
+// closed when cancel is called or the subscriber falls behind.
+func (h *hub) subscribe(userID string) (<-chan model.ChangeEvent, func()) {
+	h.mu.Lock()
+	defer h.mu.Unlock()
+	return h.add(userID)
+}
+
+// subscribeFrom is subscribe for a client that last saw the event with ID
+// lastID ("" for none). It also returns the events the client missed, or
+// gap true if they are no longer known, and the current event sequence.
+func (h *hub) subscribeFrom(userID, lastID string) (missed []model.ChangeEvent, gap bool, seq uint64, events <-chan model.ChangeEvent, cancel func()) {
+	h.mu.Lock()
+	defer h.mu.Unlock()
+
+	if lastID != "" {
+		epoch, s, _ := strings.Cut(lastID, "-")
+		last, err := strconv.ParseUint(s, 10, 64)
+		switch {
+		case err != nil || epoch != h.epoch || last > h.seq || h.dropped[userID] > last:
+			gap = true
+		default:
+			for _, ev := range h.history[userID] {
+				if ev.Seq > last {
+					missed = append(missed, ev)
+				}
+			}
+		}
+	}
+	events, cancel = h.add(userID)
+	return missed, gap, h.seq, events, cancel
+}
+
+// add registers a subscriber; h.mu must be held.
+func (h *hub) add(userID string) (<-chan model.ChangeEvent, func()) {
+	ch := make(chan model.ChangeEvent, subscriberBuffer)
+	if h.subs[userID] == nil {
+		h.subs[userID] = make(map[chan model.ChangeEvent]struct{})
+	}
+	h.subs[userID][ch] = struct{}{}
+
+	return ch, func() {
+		h.mu.Lock()
+		defer h.mu.Unlock()
+		h.remove(userID, ch)
+	}
+}
+
+// eventID returns the event stream ID of sequence number seq.
+func (h *hub) eventID(seq uint64) string {
+	return h.epoch + "-" + strconv.FormatUint(seq, 10)
+}
+
+// remove drops a subscriber; h.mu must be held.
+func (h *hub) remove(userID string, ch chan model.ChangeEvent) {
+	if _, ok := h.subs[userID][ch]; !ok {
+		return
+	}
+	delete(h.subs[userID], ch)
+	close(ch)
+	if len(h.subs[userID]) == 0 {
+		delete(h.subs, userID)
+	}
+}
+
+// publish sends ev to every connection of userID without blocking and
+// records it, without item contents, in the user's history.
+func (h *hub) publish(userID string, ev model.ChangeEvent) {
+	h.mu.Lock()
+	defer h.mu.Unlock()
+	h.seq++
+	ev.Seq = h.seq
+	// Trimmed in batches so publishing stays cheap.
+	hist := append(h.history[userID], streamEvent(ev))
+	if len(hist) >= 2*hubHistory {
+		cut := len(hist) - hubHistory
+		h.dropped[userID] = hist[cut-1].Seq
+		hist = slices.Clone(hist[cut:])
+	}
+	h.history[userID] = hist
+	for ch := range h.subs[userID] {
+		select {
+		case ch <- ev:
+		default:
+			slog.Warn("dropping slow live sync subscriber", "user_id", userID)
+			h.remove(userID, ch)
+		}
+	}
+}
+
+// resyncAll tells every live connection to pull.
+func (h *hub) resyncAll() {
+	h.mu.Lock()
+	users := make([]string, 0, len(h.subs))
+	for userID := range h.subs {
+		users = append(users, userID)
+	}
+	h.mu.Unlock()
+	for _, userID := range users {
+		h.publish(userID, model.ChangeEvent{Type: "resync"})
+	}
+}
+
+// connections returns the number of live connections of userID.
+func (h *hub) connections(userID string) int {
+	h.mu.Lock()
+	defer h.mu.Unlock()
+	return len(h.subs[userID])
+}
+
+// Writes publish domain events on a.bus once they have committed; the
+// side effects subscribe to it. The helpers below build the events.
+
+// subscribe registers the side effects of writes on the bus.
+func (a *API) subscribe() {
+	a.bus.Subscribe(a.broadcast)
+	a.bus.Subscribe(a.queueWebhooks)
+	a.bus.Subscribe(a.recordAudit)
+	a.bus.Subscribe(a.scheduleReminders)
+	a.bus.Subscribe(a.queueHistory)
+}
+
+// noteKind names what storing n over prev did; prev is nil when n is new.
+func noteKind(prev, n *model.Note) events.Kind {
+	switch {
+	case prev == nil:
+		return events.NoteCreated
+	case n.DeletedAt != nil && prev.DeletedAt == nil:
+		return events.NoteDeleted
+	case n.DeletedAt == nil && prev.DeletedAt != nil:
+		return events.NoteRestored
+	}
+	return events.NoteUpdated
+}
+
+// todoKind is noteKind for todos; a change that completes the todo is
+// TodoCompleted instead of TodoUpdated.
+func todoKind(prev, t *model.Todo) events.Kind {
+	switch {
+	case prev == nil:
+		return events.TodoCreated
+	case t.DeletedAt != nil && prev.DeletedAt == nil:
+		return events.TodoDeleted
+	case t.DeletedAt == nil && prev.DeletedAt != nil:
+		return events.TodoRestored
+	case t.DeletedAt == nil && t.Completed && !prev.Completed:
+		return events.TodoCompleted
+	}
+	return events.TodoUpdated
+}
+
+func noteEvent(kind events.Kind, userID string, n *model.Note) events.Event {
+	return events.Event{Kind: kind, UserID: userID, ID: n.ID, DeviceID: n.ModifiedByDevice, Note: n}
+}
+
+func todoEvent(kind events.Kind, userID string, t *model.Todo) events.Event {
+	return events.Event{Kind: kind, UserID: userID, ID: t.ID, DeviceID: t.ModifiedByDevice, Todo: t}
+}
+
+// itemEvent returns an event for an item known only by its ID.
+func itemEvent(kind events.Kind, userID, id, deviceID string) events.Event {
+	return events.Event{Kind: kind, UserID: userID, ID: id, DeviceID: deviceID}
+}
+
+// resyncEvent ends the events of a bulk change that clients must pull.
+func resyncEvent(userID, deviceID string) events.Event {
+	return events.Event{Kind: events.Resync, UserID: userID, DeviceID: deviceID}
+}
+
+// bulk marks ev as part of a bulk change.
+func bulk(ev events.Event) events.Event {
+	ev.Bulk = true
+	return ev
+}
+
+// bulkEvents returns an event of kind for each item of a bulk change.
+func bulkEvents(kind events.Kind, userID, deviceID string, ids []string) []events.Event {
+	evs := make([]events.Event, len(ids))
+	for i, id := range ids {
+		evs[i] = events.Event{Kind: kind, UserID: userID, ID: id, DeviceID: deviceID, Bulk: true}
+	}
+	return evs
+}
+
+// broadcast is the bus subscriber that sends changes to the user's live
+// connections, here and through the relay on other servers. A bulk change
+// reaches them as its Resync event.
+func (a *API) broadcast(_ context.Context, evs []events.Event) {
+	for _, ev := range evs {
+		switch {
+		case ev.Bulk:
+			continue
+		case ev.Kind == events.Resync:
+			ce := model.ChangeEvent{Type: "resync", DeviceID: ev.DeviceID}
+			a.hub.publish(ev.UserID, ce)
+			a.relay(ev.UserID, ce)
+			continue
+		}
+		ce := model.ChangeEvent{Type: ev.Kind.Item(), ID: ev.ID, DeviceID: ev.DeviceID, Note: ev.Note, Todo: ev.Todo}
+		at := ev.At
+		switch {
+		case ev.Note != nil:
+			ce.Deleted, at = ev.Note.DeletedAt != nil, ev.Note.ModifiedAt
+		case ev.Todo != nil:
+			ce.Deleted, at = ev.Todo.DeletedAt != nil, ev.Todo.ModifiedAt
+		default:
+			// Only deletions are published without the item.
+			ce.Deleted = true
+		}
+		ce.ModifiedAt = &at
+		a.hub.publish(ev.UserID, ce)
+		a.relay(ev.UserID, ce)
+	}
+}
+
+// handleSyncWS upgrades to a WebSocket and streams the user's change
+// events as JSON text messages until either side closes. Browsers cannot
+// set headers on WebSocket requests, so the access token may also be passed
+// as ?access_token=.
+func (a *API) handleSyncWS(w http.ResponseWriter, r *http.Request) {
+	userID := userIDFrom(r.Context())
+
+	conn, err := websocket.Upgrade(w, r)
+	if errors.Is(err, websocket.ErrNotWebSocket) {
+		writeError(w, http.StatusBadRequest, "websocket upgrade required")
+		return
+	}
+	if err != nil {
+		slog.Error("websocket upgrade", "error", err)
+		return
+	}
+
+	events, cancel := a.hub.subscribe(userID)
+	defer cancel()
+
+	// The reader only services control frames and notices disconnects.
+	done := make(chan struct{})
+	go func() {
+		defer close(done)
+		for {
+			if _, err := conn.ReadMessage(); err != nil {
+				return
+			}
+		}
+	}()
+
+	ping := time.NewTicker(wsPingInterval)
+	defer ping.Stop()
+	for {
+		select {
+		case <-done:
+			conn.Close(websocket.CloseNormal)
+			return
+		case ev, ok := <-events:
+			if !ok {
+				conn.Close(websocket.ClosePolicyViolation)
+				return
+			}
+			data, err := json.Marshal(ev)
+			if err != nil {
+				slog.Error("marshal change event", "error", err)
+				continue
+			}
+			if err := conn.WriteText(data, wsWriteTimeout); err != nil {
+				conn.Close(websocket.CloseGoingAway)
+				return
+			}
+		case <-ping.C:
+			if err := conn.Ping(wsWriteTimeout); err != nil {
+				conn.Close(websocket.CloseGoingAway)
+				return
+			}
+		}
+	}
+}
+
+// streamEvent strips the item contents from ev for the event stream. The
+// time is copied so a kept event does not hold on to the item.
+func streamEvent(ev model.ChangeEvent) model.ChangeEvent {
+	ev.Note, ev.Todo = nil, nil
+	if ev.ModifiedAt != nil {
+		t := *ev.ModifiedAt
+		ev.ModifiedAt = &t
+	}
+	return ev
+}
+
+// handleSyncEvents streams the user's change events as Server-Sent Events,
+// for clients that cannot use WebSockets. Each event carries an ID; a
+// client reconnecting with Last-Event-ID (or ?last_event_id=) first gets
+// the events it missed, or a resync event if they are no longer known.
+func (a *API) handleSyncEvents(w http.ResponseWriter, r *http.Request) {
+	userID := userIDFrom(r.Context())
+	lastID := r.Header.Get("Last-Event-ID")
+	if lastID == "" {
+		lastID = r.URL.Query().Get("last_event_id")
+	}
+
+	missed, gap, seq, events, cancel := a.hub.subscribeFrom(userID, lastID)
+	defer cancel()
+
+	// The stream outlives the server's write timeout.
+	rc := http.NewResponseController(w)
+	rc.SetWriteDeadline(time.Time{})
+	w.Header().Set("Content-Type", "text/event-stream")
+	w.Header().Set("Cache-Control", "no-cache")
+	w.Header().Set("X-Accel-Buffering", "no")
+	w.WriteHeader(http.StatusOK)
+
+	send := func(ev model.ChangeEvent) error {
+		data, err := json.Marshal(streamEvent(ev))
+		if err != nil {
+			return err
+		}
+		if _, err := fmt.Fprintf(w, "id: %s\ndata: %s\n\n", a.hub.eventID(ev.Seq), data); err != nil {
+			return err
+		}
+		return rc.Flush()
+	}
+
+	if gap {
+		missed = []model.ChangeEvent{{Type: "resync", Seq: seq}}
+	}
+	for _, ev := range missed {
+		if err := send(ev); err != nil {
+			return
+		}
+	}
+	if err := rc.Flush(); err != nil {
+		return
+	}
+
+	ping := time.NewTicker(wsPingInterval)
+	defer ping.Stop()
+	for {
+		select {
+		case <-r.Context().Done():
+			return
+		case ev, ok := <-events:
+			if !ok {
+				return
+			}
+			if err := send(ev); err != nil {
+				return
+			}
+		case <-ping.C:
+			if _, err := io.WriteString(w, ": ping\n\n"); err != nil {
+				return
+			}
+			if err := rc.Flush(); err != nil {
+				return
+			}
+		}
+	}
+}
+
+// tokenFromQuery copies ?access_token= into the Authorization header when
+// the request has none, for clients that cannot set headers.
+func tokenFromQuery(next http.HandlerFunc) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		if tok := r.URL.Query().Get("access_token"); tok != "" && r.Header.Get("Authorization") == "" {
+			r.Header.Set("Authorization", "Bearer "+tok)
+		}
+		next(w, r)
+	}
+}
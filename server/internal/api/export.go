@@ -0,0 +1,250 @@
+package api
+
+import (
+	"archive/tar"
+	"archive/zip"
+	"bytes"
+	"compress/gzip"
+	"encoding/json"
+	"fmt"
+	"io"
+	"log/slog"
+	"net/http"
+	"strconv"
+	"strings"
+	"time"
+	"unicode"
+
+	"github.com/c0dev0id/notesd/server/internal/model"
+)
+
+// exportFormat and exportVersion identify the archive layout in
+// manifest.json so importers can recognise it.
+const (
+	exportFormat  = "notesd-export"
+	exportVersion = 1
+)
+
+// archiveWriter is the common part of zip and tar.gz output.
+type archiveWriter interface {
+	add(name string, modTime time.Time, size int64, r io.Reader) error
+	Close() error
+}
+
+type zipArchive struct{ zw *zip.Writer }
+
+func (z zipArchive) add(name string, modTime time.Time, size int64, r io.Reader) error {
+	f, err := z.zw.CreateHeader(&zip.FileHeader{Name: name, Method: zip.Deflate, Modified: modTime})
+	if err != nil {
+		return err
+	}
+	_, err = io.Copy(f, r)
+	return err
+}
+
+func (z zipArchive) Close() error { return z.zw.Close() }
+
+type tarArchive struct {
+	tw *tar.Writer
+	gz *gzip.Writer
+}
+
+func (t tarArchive) add(name string, modTime time.Time, size int64, r io.Reader) error {
+	hdr := &tar.Header{Name: name, Mode: 0644, Size: size, ModTime: modTime, Typeflag: tar.TypeReg}
+	if err := t.tw.WriteHeader(hdr); err != nil {
+		return err
+	}
+	_, err := io.CopyN(t.tw, r, size)
+	return err
+}
+
+func (t tarArchive) Close() error {
+	if err := t.tw.Close(); err != nil {
+		return err
+	}
+	return t.gz.Close()
+}
+
+// fileName turns a title into a portable file name stem: letters, digits,
+// dashes and underscores, at most 60 bytes.
+func fileName(title string) string {
+	var b strings.Builder
+	dash := false
+	for _, r := range strings.TrimSpace(title) {
+		switch {
+		case unicode.IsLetter(r) || unicode.IsDigit(r) || r == '_':
+			if b.Len()+len(string(r)) > 60 {
+				return strings.TrimRight(b.String(), "-")
+			}
+			b.WriteRune(r)
+			dash = false
+		case !dash && b.Len() > 0:
+			b.WriteByte('-')
+			dash = true
+		}
+	}
+	return strings.TrimRight(b.String(), "-")
+}
+
+// noteFile returns the archive path of a note. The ID prefix keeps names
+// unique when titles repeat.
+func noteFile(n model.Note) string {
+	if stem := fileName(n.Title); stem != "" {
+		return "notes/" + stem + "-" + n.ID[:8] + ".md"
+	}
+	return "notes/" + n.ID + ".md"
+}
+
+// noteMarkdown renders a note as Markdown with YAML front matter. Strings
+// are written as double-quoted scalars, which YAML shares with JSON.
+func noteMarkdown(n model.Note) []byte {
+	var b bytes.Buffer
+	b.WriteString("---\n")
+	fmt.Fprintf(&b, "id: %s\n", strconv.Quote(n.ID))
+	fmt.Fprintf(&b, "title: %s\n", strconv.Quote(n.Title))
+	fmt.Fprintf(&b, "type: %s\n", strconv.Quote(n.Type))
+	quoted := make([]string, len(n.Tags))
+	for i, t := range n.Tags {
+		quoted[i] = strconv.Quote(t)
+	}
+	fmt.Fprintf(&b, "tags: [%s]\n", strings.Join(quoted, ", "))
+	fmt.Fprintf(&b, "created: %s\n", n.CreatedAt.UTC().Format(time.RFC3339Nano))
+	fmt.Fprintf(&b, "modified: %s\n", n.ModifiedAt.UTC().Format(time.RFC3339Nano))
+	if n.SnoozedUntil != nil {
+		fmt.Fprintf(&b, "snoozed_until: %s\n", n.SnoozedUntil.UTC().Format(time.RFC3339Nano))
+	}
+	b.WriteString("---\n")
+	b.WriteString(n.Content)
+	return b.Bytes()
+}
+
+// handleExport streams every live note, todo and attachment of the user as
+// a zip (default) or, with format=tar.gz, a gzipped tarball that can be
+// unpacked while it downloads.
+func (a *API) handleExport(w http.ResponseWriter, r *http.Request) {
+	userID := userIDFrom(r.Context())
+	format := r.URL.Query().Get("format")
+	if format == "" {
+		format = "zip"
+	}
+	if format != "zip" && format != "tar.gz" {
+		writeError(w, http.StatusBadRequest, "format must be zip or tar.gz")
+		return
+	}
+
+	notes, err := a.dbFor(r).GetAllNotes(userID)
+	if err != nil {
+		slog.Error("get notes for export", "error", err)
+		writeError(w, http.StatusInternalServerError, "internal error")
+		return
+	}
+	todos, err := a.dbFor(r).GetAllTodos(userID)
+	if err != nil {
+		slog.Error("get todos for export", "error", err)
+		writeError(w, http.StatusInternalServerError, "internal error")
+		return
+	}
+	attachments, err := a.dbFor(r).GetAllAttachments(userID)
+	if err != nil {
+		slog.Error("get attachments for export", "error", err)
+		writeError(w, http.StatusInternalServerError, "internal error")
+		return
+	}
+	if todos == nil {
+		todos = []model.Todo{}
+	}
+
+	now := model.NowMillis()
+	manifest := model.ExportManifest{
+		Format:      exportFormat,
+		Version:     exportVersion,
+		ExportedAt:  now,
+		Notes:       make([]model.ExportedNote, 0, len(notes)),
+		Attachments: make([]model.ExportedAttachment, 0, len(attachments)),
+	}
+	for _, n := range notes {
+		manifest.Notes = append(manifest.Notes, model.ExportedNote{
+			ID: n.ID, Title: n.Title, Type: n.Type, Tags: n.Tags, ContentHash: n.ContentHash,
+			SnoozedUntil: n.SnoozedUntil, ModifiedAt: n.ModifiedAt, CreatedAt: n.CreatedAt,
+			File: noteFile(n),
+		})
+	}
+	for _, at := range attachments {
+		manifest.Attachments = append(manifest.Attachments, model.ExportedAttachment{
+			Attachment: at, File: attachmentFile(at),
+		})
+	}
+
+	stamp := now.UTC().Format("20060102-150405")
+	var out archiveWriter
+	if format == "zip" {
+		w.Header().Set("Content-Type", "application/zip")
+		out = zipArchive{zip.NewWriter(w)}
+	} else {
+		w.Header().Set("Content-Type", "application/gzip")
+		gz := gzip.NewWriter(w)
+		out = tarArchive{tar.NewWriter(gz), gz}
+	}
+	w.Header().Set("Content-Disposition", `attachment; filename="notesd-export-`+stamp+`.`+format+`"`)
+
+	// Headers are sent with the first write; from here on errors can only
+	// be logged and the truncated archive fails to unpack.
+	if err := a.writeExport(out, manifest, notes, todos); err != nil {
+		slog.Error("write export", "error", err)
+		return
+	}
+	if err := out.Close(); err != nil {
+		slog.Error("finish export", "error", err)
+	}
+}
+
+func (a *API) writeExport(out archiveWriter, manifest model.ExportManifest, notes []model.Note, todos []model.Todo) error {
+	addBytes := func(name string, modTime time.Time, data []byte) error {
+		return out.add(name, modTime, int64(len(data)), bytes.NewReader(data))
+	}
+
+	data, err := json.MarshalIndent(manifest, "", "  ")
+	if err != nil {
+		return err
+	}
+	if err := addBytes("manifest.json", manifest.ExportedAt, data); err != nil {
+		return err
+	}
+	for i, n := range notes {
+		if err := addBytes(manifest.Notes[i].File, n.ModifiedAt, noteMarkdown(n)); err != nil {
+			return err
+		}
+	}
+	if data, err = json.MarshalIndent(todos, "", "  "); err != nil {
+		return err
+	}
+	if err := addBytes("todos.json", manifest.ExportedAt, data); err != nil {
+		return err
+	}
+	for _, at := range manifest.Attachments {
+		f, err := a.blobs.Open(at.ID)
+		if err != nil {
+			return fmt.Errorf("open attachment %s: %w", at.ID, err)
+		}
+		err = out.add(at.File, at.CreatedAt, at.Size, f)
+		f.Close()
+		if err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// attachmentFile returns the archive path of an attachment, keeping a plain
+// extension of the original file name.
+func attachmentFile(at model.Attachment) string {
+	ext := ""
+	if i := strings.LastIndexByte(at.Filename, '.'); i >= 0 && i < len(at.Filename)-1 && fileName(at.Filename[i+1:]) == at.Filename[i+1:] {
+		ext = at.Filename[i:]
+	}
+	stem := fileName(strings.TrimSuffix(at.Filename, ext))
+	if stem == "" {
+		stem = "file"
+	}
+	return "attachments/" + at.ID + "/" + stem + ext
+}
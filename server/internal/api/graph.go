@@ -0,0 +1,43 @@
+package api
+
+import (
+	"errors"
+	"log/slog"
+	"net/http"
+	"strings"
+
+	"github.com/c0dev0id/notesd/server/internal/database"
+)
+
+// handleGraph returns the graph of wiki links between the user's notes;
+// ?tag= limits it to the notes with that tag and the links between them.
+func (a *API) handleGraph(w http.ResponseWriter, r *http.Request) {
+	userID := userIDFrom(r.Context())
+
+	g, err := a.dbFor(r).NoteGraph(userID, strings.TrimSpace(r.URL.Query().Get("tag")))
+	if err != nil {
+		slog.Error("get note graph", "error", err)
+		writeError(w, http.StatusInternalServerError, "internal error")
+		return
+	}
+
+	writeJSON(w, http.StatusOK, g)
+}
+
+// handleBacklinks lists the notes whose wiki links point at the note.
+func (a *API) handleBacklinks(w http.ResponseWriter, r *http.Request) {
+	userID := userIDFrom(r.Context())
+
+	notes, err := a.dbFor(r).GetBacklinks(r.PathValue("id"), userID)
+	if errors.Is(err, database.ErrNotFound) {
+		writeError(w, http.StatusNotFound, "note not found")
+		return
+	}
+	if err != nil {
+		slog.Error("get backlinks", "error", err)
+		writeError(w, http.StatusInternalServerError, "internal error")
+		return
+	}
+
+	writeJSON(w, http.StatusOK, notes)
+}
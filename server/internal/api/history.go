@@ -0,0 +1,255 @@
+package api
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"log/slog"
+	"net/http"
+	"time"
+
+	"github.com/c0dev0id/notesd/server/internal/database"
+	"github.com/c0dev0id/notesd/server/internal/events"
+	"github.com/c0dev0id/notesd/server/internal/githistory"
+	"github.com/c0dev0id/notesd/server/internal/model"
+)
+
+const (
+	historyPushInterval    = time.Minute
+	historyCompactInterval = time.Hour
+)
+
+// historyBatch is the changes of one kind to a user's notes published
+// together; it becomes one commit.
+type historyBatch struct {
+	userID   string
+	deviceID string
+	kind     events.Kind
+	ids      []string
+	at       time.Time
+}
+
+// historyVerbs starts the commit subjects of each kind of change.
+var historyVerbs = map[events.Kind]string{
+	events.NoteCreated:  "Create",
+	events.NoteUpdated:  "Update",
+	events.NoteDeleted:  "Delete",
+	events.NoteRestored: "Restore",
+	events.NotePurged:   "Purge",
+}
+
+// historyPath is the file of a note in the history.
+func historyPath(userID, noteID string) string {
+	return userID + "/" + noteID + ".md"
+}
+
+// queueHistory is the bus subscriber that queues note changes for the git
+// history, bulk changes included. Running git is too slow for a request,
+// so RunHistory commits them; changes still queued when the server stops
+// are recorded with the next change of their note.
+func (a *API) queueHistory(_ context.Context, evs []events.Event) {
+	if a.history == nil {
+		return
+	}
+	var batches []historyBatch
+	for _, ev := range evs {
+		if historyVerbs[ev.Kind] == "" {
+			continue
+		}
+		if n := len(batches); n > 0 && batches[n-1].userID == ev.UserID && batches[n-1].kind == ev.Kind {
+			batches[n-1].ids = append(batches[n-1].ids, ev.ID)
+			batches[n-1].at = ev.At
+			continue
+		}
+		batches = append(batches, historyBatch{userID: ev.UserID, deviceID: ev.DeviceID, kind: ev.Kind, ids: []string{ev.ID}, at: ev.At})
+	}
+	if len(batches) == 0 {
+		return
+	}
+	a.historyMu.Lock()
+	a.historyQueue = append(a.historyQueue, batches...)
+	a.historyMu.Unlock()
+	select {
+	case a.historyWake <- struct{}{}:
+	default:
+	}
+}
+
+// RunHistory commits queued note changes to the git history until ctx is
+// cancelled, pushes new commits to history.remote every minute and packs
+// the repository hourly. It returns immediately when the history is off.
+func (a *API) RunHistory(ctx context.Context) {
+	if a.history == nil {
+		return
+	}
+	push := time.NewTicker(historyPushInterval)
+	defer push.Stop()
+	compact := time.NewTicker(historyCompactInterval)
+	defer compact.Stop()
+	unpushed := false
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case <-a.historyWake:
+			if a.writeHistory(ctx) {
+				unpushed = true
+			}
+		case <-push.C:
+			if !unpushed {
+				continue
+			}
+			if err := a.history.Push(ctx); err != nil {
+				slog.Error("push note history", "error", err)
+				continue
+			}
+			unpushed = false
+		case <-compact.C:
+			if err := a.history.Compact(ctx); err != nil {
+				slog.Error("compact note history", "error", err)
+			}
+		}
+	}
+}
+
+// writeHistory commits the queued changes, reading each note as it is now,
+// and reports whether a commit was made.
+func (a *API) writeHistory(ctx context.Context) bool {
+	a.historyMu.Lock()
+	batches := a.historyQueue
+	a.historyQueue = nil
+	a.historyMu.Unlock()
+
+	db := a.db.WithContext(ctx)
+	committed := false
+	for _, b := range batches {
+		changes := make([]githistory.Change, 0, len(b.ids))
+		var title string
+		for _, id := range b.ids {
+			c := githistory.Change{Path: historyPath(b.userID, id)}
+			n, err := db.GetNoteAny(id, b.userID)
+			switch {
+			case errors.Is(err, database.ErrNotFound):
+				// Purged; the file goes.
+			case err != nil:
+				slog.Error("read note for history", "note_id", id, "error", err)
+				continue
+			default:
+				title = n.Title
+				if n.DeletedAt == nil {
+					c.Content = noteMarkdown(*n)
+				}
+			}
+			changes = append(changes, c)
+		}
+		if len(changes) == 0 {
+			continue
+		}
+		author := githistory.Signature{Name: b.userID, Email: b.userID, At: b.at}
+		if u, err := db.GetUserByID(b.userID); err == nil {
+			author.Name, author.Email = u.Email, u.Email
+			if u.DisplayName != "" {
+				author.Name = u.DisplayName
+			}
+		}
+		if _, err := a.history.Commit(ctx, changes, author, historyMessage(b, title)); err != nil {
+			slog.Error("commit note history", "user_id", b.userID, "notes", len(changes), "error", err)
+			continue
+		}
+		committed = true
+	}
+	return committed
+}
+
+// historyMessage is the commit message of b: "Update Shopping" for one
+// note, or "Update 12 notes", with the note IDs and device below.
+func historyMessage(b historyBatch, title string) string {
+	subject := fmt.Sprintf("%s %d notes", historyVerbs[b.kind], len(b.ids))
+	if len(b.ids) == 1 {
+		if title == "" {
+			title = "untitled note"
+		}
+		subject = historyVerbs[b.kind] + " " + title
+	}
+	msg := subject + "\n\n"
+	for _, id := range b.ids {
+		msg += "Note: " + id + "\n"
+	}
+	if b.deviceID != "" {
+		msg += "Device: " + b.deviceID + "\n"
+	}
+	return msg
+}
+
+// handleNoteHistory lists the revisions of a note in the git history,
+// newest first.
+func (a *API) handleNoteHistory(w http.ResponseWriter, r *http.Request) {
+	userID := userIDFrom(r.Context())
+	if !a.historyNoteExists(w, r, userID) {
+		return
+	}
+	limit := queryInt(r, "limit", 50)
+	if limit > 1000 {
+		limit = 1000
+	}
+	revs, err := a.history.Log(r.Context(), historyPath(userID, r.PathValue("id")), limit)
+	if err != nil {
+		slog.Error("note history", "error", err)
+		writeError(w, http.StatusInternalServerError, "internal error")
+		return
+	}
+	resp := model.NoteHistoryResponse{Revisions: make([]model.NoteRevision, len(revs))}
+	for i, rev := range revs {
+		resp.Revisions[i] = noteRevision(rev)
+	}
+	writeJSON(w, http.StatusOK, resp)
+}
+
+// handleNoteDiff returns the diff of the latest change to a note at or
+// before the revision ?rev=, or of the latest change of all without it.
+func (a *API) handleNoteDiff(w http.ResponseWriter, r *http.Request) {
+	userID := userIDFrom(r.Context())
+	if !a.historyNoteExists(w, r, userID) {
+		return
+	}
+	rev := r.URL.Query().Get("rev")
+	if rev != "" && !githistory.ValidRev(rev) {
+		writeError(w, http.StatusBadRequest, "rev must be a commit hash")
+		return
+	}
+	revision, diff, err := a.history.Diff(r.Context(), historyPath(userID, r.PathValue("id")), rev)
+	if errors.Is(err, githistory.ErrNotFound) {
+		writeError(w, http.StatusNotFound, "revision not found")
+		return
+	}
+	if err != nil {
+		slog.Error("note diff", "error", err)
+		writeError(w, http.StatusInternalServerError, "internal error")
+		return
+	}
+	writeJSON(w, http.StatusOK, model.NoteDiff{Revision: noteRevision(revision), Diff: diff})
+}
+
+// historyNoteExists answers 404 unless the history is on and the note,
+// deleted or not, belongs to userID.
+func (a *API) historyNoteExists(w http.ResponseWriter, r *http.Request, userID string) bool {
+	if a.history == nil {
+		writeError(w, http.StatusNotFound, "note history is not configured on this server")
+		return false
+	}
+	_, err := a.dbFor(r).GetNoteAny(r.PathValue("id"), userID)
+	if errors.Is(err, database.ErrNotFound) {
+		writeError(w, http.StatusNotFound, "note not found")
+		return false
+	}
+	if err != nil {
+		slog.Error("get note", "error", err)
+		writeError(w, http.StatusInternalServerError, "internal error")
+		return false
+	}
+	return true
+}
+
+func noteRevision(r githistory.Revision) model.NoteRevision {
+	return model.NoteRevision{Rev: r.Rev, Author: r.Author, At: r.At, Message: r.Message}
+}
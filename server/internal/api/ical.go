@@ -0,0 +1,162 @@
+package api
+
+import (
+	"errors"
+	"log/slog"
+	"net/http"
+	"strings"
+
+	"github.com/c0dev0id/notesd/server/internal/database"
+	"github.com/c0dev0id/notesd/server/internal/model"
+)
+
+const calendarFeedPath = "/api/v1/todos/calendar.ics"
+
+// icalEscaper escapes TEXT values as required by RFC 5545 section 3.3.11.
+var icalEscaper = strings.NewReplacer(`\`, `\\`, ";", `\;`, ",", `\,`, "\r\n", `\n`, "\n", `\n`)
+
+// icalLine writes one content line, folded at 75 octets (counting the
+// leading space of continuation lines) without splitting UTF-8 sequences.
+func icalLine(b *strings.Builder, line string) {
+	for limit := 75; len(line) > limit; limit = 74 {
+		cut := limit
+		for line[cut]&0xC0 == 0x80 {
+			cut--
+		}
+		b.WriteString(line[:cut])
+		b.WriteString("\r\n ")
+		line = line[cut:]
+	}
+	b.WriteString(line)
+	b.WriteString("\r\n")
+}
+
+// buildICal renders todos as events on their due date: all-day events for
+// all-day todos, events without duration at the due time for the others.
+// Todos with a reminder get a display alarm at reminder_at unless alarms is
+// false or the todo is completed.
+func buildICal(todos []model.Todo, alarms bool) string {
+	const stamp = "20060102T150405Z"
+	var b strings.Builder
+	icalLine(&b, "BEGIN:VCALENDAR")
+	icalLine(&b, "VERSION:2.0")
+	icalLine(&b, "PRODID:-//notesd//todos//EN")
+	icalLine(&b, "CALSCALE:GREGORIAN")
+	icalLine(&b, "X-WR-CALNAME:notesd todos")
+	for _, t := range todos {
+		if t.DueDate == nil {
+			continue
+		}
+		due := t.DueDate.UTC()
+		summary := icalEscaper.Replace(t.Content)
+		if t.Completed {
+			summary = "✓ " + summary
+		}
+		icalLine(&b, "BEGIN:VEVENT")
+		icalLine(&b, "UID:"+t.ID+"@notesd")
+		icalLine(&b, "DTSTAMP:"+t.ModifiedAt.UTC().Format(stamp))
+		if t.AllDay {
+			icalLine(&b, "DTSTART;VALUE=DATE:"+due.Format("20060102"))
+			icalLine(&b, "DTEND;VALUE=DATE:"+due.AddDate(0, 0, 1).Format("20060102"))
+		} else {
+			icalLine(&b, "DTSTART:"+due.Format(stamp))
+		}
+		icalLine(&b, "SUMMARY:"+summary)
+		icalLine(&b, "TRANSP:TRANSPARENT")
+		if alarms && t.ReminderAt != nil && !t.Completed {
+			icalLine(&b, "BEGIN:VALARM")
+			icalLine(&b, "ACTION:DISPLAY")
+			icalLine(&b, "DESCRIPTION:"+summary)
+			icalLine(&b, "TRIGGER;VALUE=DATE-TIME:"+t.ReminderAt.UTC().Format(stamp))
+			icalLine(&b, "END:VALARM")
+		}
+		icalLine(&b, "END:VEVENT")
+	}
+	icalLine(&b, "END:VCALENDAR")
+	return b.String()
+}
+
+// handleCalendarFeed serves the iCalendar feed. Calendar apps cannot send a
+// bearer token, so the request is authenticated by the feed token in the
+// query string instead.
+func (a *API) handleCalendarFeed(w http.ResponseWriter, r *http.Request) {
+	token := r.URL.Query().Get("token")
+	if token == "" {
+		writeError(w, http.StatusUnauthorized, "missing feed token")
+		return
+	}
+	userID, err := a.dbFor(r).GetCalendarFeedUser(database.HashToken(token))
+	if errors.Is(err, database.ErrNotFound) {
+		writeError(w, http.StatusUnauthorized, "invalid feed token")
+		return
+	}
+	if err != nil {
+		slog.Error("get calendar feed user", "error", err)
+		writeError(w, http.StatusInternalServerError, "internal error")
+		return
+	}
+
+	todos, err := a.dbFor(r).GetDatedTodos(userID)
+	if err != nil {
+		slog.Error("get calendar feed todos", "error", err)
+		writeError(w, http.StatusInternalServerError, "internal error")
+		return
+	}
+
+	w.Header().Set("Content-Type", "text/calendar; charset=utf-8")
+	w.Header().Set("Cache-Control", "private, no-cache")
+	w.Write([]byte(buildICal(todos, r.URL.Query().Get("alarms") != "false")))
+}
+
+func (a *API) handleGetCalendarFeed(w http.ResponseWriter, r *http.Request) {
+	userID := userIDFrom(r.Context())
+
+	created, err := a.dbFor(r).GetCalendarFeedCreated(userID)
+	if errors.Is(err, database.ErrNotFound) {
+		writeError(w, http.StatusNotFound, "no calendar feed")
+		return
+	}
+	if err != nil {
+		slog.Error("get calendar feed", "error", err)
+		writeError(w, http.StatusInternalServerError, "internal error")
+		return
+	}
+
+	writeJSON(w, http.StatusOK, model.CalendarFeed{CreatedAt: created})
+}
+
+// handleCreateCalendarFeed generates a new feed token, revoking the previous
+// one. Only the hash is stored, so the URL is shown this once.
+func (a *API) handleCreateCalendarFeed(w http.ResponseWriter, r *http.Request) {
+	userID := userIDFrom(r.Context())
+
+	token := newSlug()
+	now := model.NowMillis()
+	if err := a.dbFor(r).SetCalendarFeedToken(userID, database.HashToken(token), now); err != nil {
+		slog.Error("create calendar feed", "error", err)
+		writeError(w, http.StatusInternalServerError, "internal error")
+		return
+	}
+
+	writeJSON(w, http.StatusCreated, model.CalendarFeed{
+		URL:       calendarFeedPath + "?token=" + token,
+		CreatedAt: now,
+	})
+}
+
+func (a *API) handleDeleteCalendarFeed(w http.ResponseWriter, r *http.Request) {
+	userID := userIDFrom(r.Context())
+
+	err := a.dbFor(r).DeleteCalendarFeed(userID)
+	if errors.Is(err, database.ErrNotFound) {
+		writeError(w, http.StatusNotFound, "no calendar feed")
+		return
+	}
+	if err != nil {
+		slog.Error("delete calendar feed", "error", err)
+		writeError(w, http.StatusInternalServerError, "internal error")
+		return
+	}
+
+	w.WriteHeader(http.StatusNoContent)
+}
@@ -0,0 +1,535 @@
+package api
+
+import (
+	"archive/zip"
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"log/slog"
+	"net/http"
+	"os"
+	"path"
+	"slices"
+	"strconv"
+	"strings"
+	"time"
+	"unicode/utf8"
+
+	"github.com/c0dev0id/notesd/server/internal/blob"
+	"github.com/c0dev0id/notesd/server/internal/database"
+	"github.com/c0dev0id/notesd/server/internal/delta"
+	"github.com/c0dev0id/notesd/server/internal/events"
+	"github.com/c0dev0id/notesd/server/internal/frontmatter"
+	"github.com/c0dev0id/notesd/server/internal/model"
+)
+
+// maxArchiveImportSize limits POST /import bodies, which may carry
+// attachments. The body is spooled to a temporary file because zip archives
+// are read from the end.
+const maxArchiveImportSize = 256 << 20
+
+// importNote is a note read from an import file. sourceID is its ID in a
+// notesd export and reattaches the exported todos and attachments.
+type importNote struct {
+	model.Note
+	sourceID    string
+	attachments []importFile
+}
+
+// importFile is an attachment read from an import file. size is what the
+// file says it holds, for the quota check.
+type importFile struct {
+	name        string
+	contentType string
+	size        int64
+	open        func() (io.ReadCloser, error)
+}
+
+type importData struct {
+	notes []importNote
+	todos []model.Todo
+}
+
+// usage is how much storing everything in d would add. Notes skipped as
+// already present make it an overestimate.
+func (d *importData) usage() usageDelta {
+	u := usageDelta{notes: int64(len(d.notes)), todos: int64(len(d.todos))}
+	for i := range d.notes {
+		u.contentBytes += noteBytes(&d.notes[i].Note)
+		for _, f := range d.notes[i].attachments {
+			u.attachmentBytes += f.size
+		}
+	}
+	for i := range d.todos {
+		u.contentBytes += todoBytes(&d.todos[i])
+	}
+	return u
+}
+
+// handleImport creates notes from a notesd export (zip), an Evernote ENEX
+// file or a zip of Markdown files. Notes whose title and content match an
+// existing note are skipped, so importing the same file twice is harmless.
+func (a *API) handleImport(w http.ResponseWriter, r *http.Request) {
+	userID := userIDFrom(r.Context())
+	deviceID := deviceIDFrom(r.Context())
+
+	format := r.URL.Query().Get("format")
+	if format != "notesd" && format != "enex" && format != "markdown" {
+		writeError(w, http.StatusBadRequest, "format must be notesd, enex or markdown")
+		return
+	}
+
+	f, err := os.CreateTemp("", "notesd-import-*")
+	if err != nil {
+		slog.Error("create import spool", "error", err)
+		writeError(w, http.StatusInternalServerError, "internal error")
+		return
+	}
+	defer os.Remove(f.Name())
+	defer f.Close()
+
+	size, err := io.Copy(f, http.MaxBytesReader(w, r.Body, maxArchiveImportSize))
+	var maxErr *http.MaxBytesError
+	if errors.As(err, &maxErr) {
+		writeError(w, http.StatusRequestEntityTooLarge, "import too large")
+		return
+	}
+	if err != nil {
+		writeError(w, http.StatusBadRequest, "invalid request body")
+		return
+	}
+
+	var data *importData
+	if format == "enex" {
+		if _, err = f.Seek(0, io.SeekStart); err == nil {
+			data, err = parseENEX(f)
+		}
+	} else {
+		var zr *zip.Reader
+		if zr, err = zip.NewReader(f, size); err != nil {
+			writeError(w, http.StatusBadRequest, "invalid zip archive")
+			return
+		}
+		if format == "notesd" {
+			data, err = parseNotesdExport(zr)
+		} else {
+			data, err = parseMarkdownZip(zr, a.config.Notes.NoteTypes())
+		}
+	}
+	if err == nil {
+		err = validateImport(data, a.config.Notes.NoteTypes())
+	}
+	if err != nil {
+		writeError(w, http.StatusBadRequest, "invalid import: "+err.Error())
+		return
+	}
+	if !a.checkQuota(w, r, userID, data.usage()) {
+		return
+	}
+
+	summary, err := a.storeImport(r.Context(), a.dbFor(r), userID, deviceID, data)
+	if errors.Is(err, blob.ErrTooLarge) {
+		writeError(w, http.StatusRequestEntityTooLarge, "attachment too large")
+		return
+	}
+	if err != nil {
+		slog.Error("import", "error", err)
+		writeError(w, http.StatusInternalServerError, "internal error")
+		return
+	}
+	writeJSON(w, http.StatusOK, summary)
+}
+
+// validateImport applies the limits of the note and todo endpoints. As with
+// CSV imports, a single invalid item rejects the whole file.
+func validateImport(data *importData, noteTypes []string) error {
+	for i := range data.notes {
+		n := &data.notes[i].Note
+		if utf8.RuneCountInString(n.Title) > maxTitleLen {
+			return fmt.Errorf("note %d: title too long", i+1)
+		}
+		if utf8.RuneCountInString(n.Content) > maxContentLen {
+			return fmt.Errorf("note %q: content too long", n.Title)
+		}
+		if n.Type == "" {
+			n.Type = "note"
+		}
+		if !slices.Contains(noteTypes, n.Type) {
+			return fmt.Errorf("note %q: unknown type %q", n.Title, n.Type)
+		}
+		tags, err := normalizeTags(n.Tags)
+		if err != nil {
+			return fmt.Errorf("note %q: %w", n.Title, err)
+		}
+		if tags == nil {
+			tags = []string{}
+		}
+		n.Tags = tags
+	}
+	for i := range data.todos {
+		t := &data.todos[i]
+		if utf8.RuneCountInString(t.Content) > maxTodoContentLen {
+			return fmt.Errorf("todo %d: content too long", i+1)
+		}
+		tags, err := normalizeTags(t.Tags)
+		if err != nil {
+			return fmt.Errorf("todo %d: %w", i+1, err)
+		}
+		if tags == nil {
+			tags = []string{}
+		}
+		t.Tags = tags
+		checklist, err := normalizeChecklist(t.Checklist)
+		if err != nil {
+			return fmt.Errorf("todo %d: %w", i+1, err)
+		}
+		t.SetChecklist(checklist)
+		t.NormalizeDue()
+	}
+	return nil
+}
+
+// storeImport gives the imported items new IDs and stores them, skipping
+// notes with the title and content hash of an existing note and todos with
+// the content, due date and note of an existing todo. Attachments of
+// skipped notes are skipped with them.
+func (a *API) storeImport(ctx context.Context, db *database.DB, userID, deviceID string, data *importData) (model.ImportSummary, error) {
+	var summary model.ImportSummary
+
+	existing, err := db.GetAllNotes(userID)
+	if err != nil {
+		return summary, err
+	}
+	seenNotes := make(map[string]string, len(existing))
+	for _, n := range existing {
+		seenNotes[importNoteKey(n.Title, n.ContentHash)] = n.ID
+	}
+	existingTodos, err := db.GetAllTodos(userID)
+	if err != nil {
+		return summary, err
+	}
+	seenTodos := make(map[string]bool, len(existingTodos))
+	for i := range existingTodos {
+		seenTodos[importTodoKey(&existingTodos[i])] = true
+	}
+
+	now := model.NowMillis()
+	noteIDs := map[string]string{}
+	var notes []*model.Note
+	var todos []*model.Todo
+	type pendingFile struct {
+		noteID string
+		file   importFile
+	}
+	var files []pendingFile
+
+	for _, in := range data.notes {
+		key := importNoteKey(in.Title, delta.Hash(in.Content))
+		if id, ok := seenNotes[key]; ok {
+			summary.Skipped++
+			if in.sourceID != "" {
+				noteIDs[in.sourceID] = id
+			}
+			continue
+		}
+		n := in.Note
+		n.ID = model.NewID()
+		n.UserID = userID
+		n.ModifiedAt = now
+		n.ModifiedByDevice = deviceID
+		n.DeletedAt = nil
+		if n.CreatedAt.IsZero() || n.CreatedAt.After(now) {
+			n.CreatedAt = now
+		}
+		seenNotes[key] = n.ID
+		if in.sourceID != "" {
+			noteIDs[in.sourceID] = n.ID
+		}
+		notes = append(notes, &n)
+		for _, f := range in.attachments {
+			files = append(files, pendingFile{n.ID, f})
+		}
+	}
+
+	for _, in := range data.todos {
+		t := in
+		t.NoteID, t.LineRef = nil, nil
+		if in.NoteID != nil {
+			if id, ok := noteIDs[*in.NoteID]; ok {
+				t.NoteID, t.LineRef = &id, in.LineRef
+			}
+		}
+		key := importTodoKey(&t)
+		if seenTodos[key] {
+			summary.Skipped++
+			continue
+		}
+		seenTodos[key] = true
+		t.ID = model.NewID()
+		t.UserID = userID
+		t.ModifiedAt = now
+		t.ModifiedByDevice = deviceID
+		t.DeletedAt = nil
+		t.FieldTimes = nil
+		if t.CreatedAt.IsZero() || t.CreatedAt.After(now) {
+			t.CreatedAt = now
+		}
+		todos = append(todos, &t)
+	}
+
+	// Blobs go first; if anything fails the stored ones are removed again.
+	var attachments []*model.Attachment
+	removeBlobs := func() {
+		for _, at := range attachments {
+			a.blobs.Remove(at.ID)
+		}
+	}
+	for _, p := range files {
+		at, err := a.storeImportFile(userID, p.noteID, p.file, now)
+		if err != nil {
+			removeBlobs()
+			return summary, err
+		}
+		attachments = append(attachments, at)
+	}
+
+	if err := db.Import(notes, todos, attachments); err != nil {
+		removeBlobs()
+		return summary, err
+	}
+	summary.Notes = len(notes)
+	summary.Todos = len(todos)
+	summary.Attachments = len(attachments)
+
+	evs := make([]events.Event, 0, len(notes)+len(todos)+1)
+	for _, n := range notes {
+		evs = append(evs, bulk(noteEvent(events.NoteCreated, userID, n)))
+	}
+	for _, t := range todos {
+		evs = append(evs, bulk(todoEvent(events.TodoCreated, userID, t)))
+	}
+	if len(evs) > 0 {
+		a.bus.Publish(ctx, append(evs, resyncEvent(userID, deviceID))...)
+	}
+	return summary, nil
+}
+
+func (a *API) storeImportFile(userID, noteID string, f importFile, now time.Time) (*model.Attachment, error) {
+	rc, err := f.open()
+	if err != nil {
+		return nil, fmt.Errorf("open %s: %w", f.name, err)
+	}
+	defer rc.Close()
+
+	id := model.NewID()
+	size, sum, err := a.blobs.Put(id, rc, a.config.Attachments.MaxSize)
+	if err != nil {
+		return nil, err
+	}
+	name := cleanFilename(f.name)
+	if name == "" {
+		name = "file"
+	}
+	contentType := f.contentType
+	if contentType == "" {
+		contentType = "application/octet-stream"
+	}
+	return &model.Attachment{
+		ID:          id,
+		UserID:      userID,
+		NoteID:      noteID,
+		Filename:    name,
+		ContentType: contentType,
+		Size:        size,
+		SHA256:      sum,
+		ModifiedAt:  now,
+		CreatedAt:   now,
+	}, nil
+}
+
+func importNoteKey(title, contentHash string) string {
+	return strings.TrimSpace(title) + "\x00" + contentHash
+}
+
+func importTodoKey(t *model.Todo) string {
+	var noteID, due string
+	if t.NoteID != nil {
+		noteID = *t.NoteID
+	}
+	if t.DueDate != nil {
+		due = strconv.FormatInt(t.DueDate.UnixMilli(), 10)
+	}
+	return t.Content + "\x00" + due + "\x00" + noteID
+}
+
+// parseNotesdExport reads an archive written by GET /export. Metadata comes
+// from manifest.json; the front matter of the note files is only a copy.
+func parseNotesdExport(zr *zip.Reader) (*importData, error) {
+	files := make(map[string]*zip.File, len(zr.File))
+	for _, f := range zr.File {
+		files[f.Name] = f
+	}
+
+	var manifest model.ExportManifest
+	if err := readZipJSON(files, "manifest.json", &manifest); err != nil {
+		return nil, err
+	}
+	if manifest.Format != exportFormat {
+		return nil, errors.New("not a notesd export")
+	}
+	if manifest.Version > exportVersion {
+		return nil, fmt.Errorf("unsupported export version %d", manifest.Version)
+	}
+
+	byNote := map[string][]importFile{}
+	for _, at := range manifest.Attachments {
+		f, ok := files[at.File]
+		if !ok {
+			return nil, fmt.Errorf("missing %s", at.File)
+		}
+		byNote[at.NoteID] = append(byNote[at.NoteID], importFile{
+			name: at.Filename, contentType: at.ContentType, size: int64(f.UncompressedSize64), open: f.Open,
+		})
+	}
+
+	data := &importData{}
+	for _, en := range manifest.Notes {
+		f, ok := files[en.File]
+		if !ok {
+			return nil, fmt.Errorf("missing %s", en.File)
+		}
+		body, err := readZipFile(f)
+		if err != nil {
+			return nil, err
+		}
+		_, content := frontmatter.Parse(body)
+		data.notes = append(data.notes, importNote{
+			Note: model.Note{
+				Title: en.Title, Content: content, Type: en.Type, Tags: en.Tags,
+				SnoozedUntil: en.SnoozedUntil, CreatedAt: en.CreatedAt,
+			},
+			sourceID:    en.ID,
+			attachments: byNote[en.ID],
+		})
+	}
+	if _, ok := files["todos.json"]; ok {
+		if err := readZipJSON(files, "todos.json", &data.todos); err != nil {
+			return nil, err
+		}
+	}
+	return data, nil
+}
+
+// parseMarkdownZip turns every .md file of a zip into a note. The title
+// comes from a front matter title or else the file name; front matter tags
+// and created (or date) are used when present.
+func parseMarkdownZip(zr *zip.Reader, noteTypes []string) (*importData, error) {
+	data := &importData{}
+	for _, f := range zr.File {
+		if f.FileInfo().IsDir() || !isMarkdownFile(f.Name) {
+			continue
+		}
+		body, err := readZipFile(f)
+		if err != nil {
+			return nil, err
+		}
+		fm, content := frontmatter.Parse(body)
+
+		n := model.Note{Title: fm.Get("title"), Content: content}
+		// Entries without a time carry the zero DOS date, around 1980.
+		if f.Modified.After(dosEpoch) {
+			n.CreatedAt = f.Modified
+		}
+		if n.Title == "" {
+			n.Title = strings.TrimSuffix(path.Base(f.Name), path.Ext(f.Name))
+		}
+		if t := fm.Get("type"); slices.Contains(noteTypes, t) {
+			n.Type = t
+		}
+		for _, t := range fm["tags"] {
+			if t = strings.TrimPrefix(strings.TrimSpace(t), "#"); t != "" {
+				n.Tags = append(n.Tags, t)
+			}
+		}
+		for _, key := range []string{"created", "date"} {
+			if t, ok := parseImportTime(fm.Get(key)); ok {
+				n.CreatedAt = t
+				break
+			}
+		}
+		data.notes = append(data.notes, importNote{Note: n})
+	}
+	if len(data.notes) == 0 {
+		return nil, errors.New("no markdown files found")
+	}
+	return data, nil
+}
+
+var dosEpoch = time.Date(1980, 1, 2, 0, 0, 0, 0, time.UTC)
+
+// isMarkdownFile reports whether a zip entry is a Markdown file, leaving
+// out hidden files and the resource forks macOS adds to zips.
+func isMarkdownFile(name string) bool {
+	for _, part := range strings.Split(name, "/") {
+		if strings.HasPrefix(part, ".") || part == "__MACOSX" {
+			return false
+		}
+	}
+	ext := strings.ToLower(path.Ext(name))
+	return ext == ".md" || ext == ".markdown"
+}
+
+func readZipFile(f *zip.File) (string, error) {
+	rc, err := f.Open()
+	if err != nil {
+		return "", fmt.Errorf("read %s: %w", f.Name, err)
+	}
+	defer rc.Close()
+	data, err := io.ReadAll(io.LimitReader(rc, maxImportSize+1))
+	if err != nil {
+		return "", fmt.Errorf("read %s: %w", f.Name, err)
+	}
+	if len(data) > maxImportSize {
+		return "", fmt.Errorf("%s is too large", f.Name)
+	}
+	return string(data), nil
+}
+
+func readZipJSON(files map[string]*zip.File, name string, v any) error {
+	f, ok := files[name]
+	if !ok {
+		return fmt.Errorf("missing %s", name)
+	}
+	data, err := readZipFile(f)
+	if err != nil {
+		return err
+	}
+	if err := json.Unmarshal([]byte(data), v); err != nil {
+		return fmt.Errorf("%s: %w", name, err)
+	}
+	return nil
+}
+
+// importTimeLayouts are the date formats accepted in front matter and ENEX.
+var importTimeLayouts = []string{
+	time.RFC3339Nano,
+	"2006-01-02T15:04:05",
+	"2006-01-02 15:04:05",
+	"2006-01-02 15:04",
+	"2006-01-02",
+	"20060102T150405Z",
+}
+
+func parseImportTime(s string) (time.Time, bool) {
+	if s = strings.TrimSpace(s); s == "" {
+		return time.Time{}, false
+	}
+	for _, layout := range importTimeLayouts {
+		if t, err := time.Parse(layout, s); err == nil {
+			return t, true
+		}
+	}
+	return time.Time{}, false
+}
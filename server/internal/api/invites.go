@@ -0,0 +1,62 @@
+package api
+
+import (
+	"log/slog"
+	"net/http"
+	"time"
+
+	"github.com/c0dev0id/notesd/server/internal/model"
+)
+
+func (a *API) handleListInvites(w http.ResponseWriter, r *http.Request) {
+	invites, err := a.dbFor(r).ListInvites()
+	if err != nil {
+		slog.Error("list invites", "error", err)
+		writeError(w, http.StatusInternalServerError, "internal error")
+		return
+	}
+	writeJSON(w, http.StatusOK, invites)
+}
+
+// handleCreateInvite issues a registration code for auth.registration =
+// "invite". Codes can be created in any mode, so they are ready before an
+// instance is switched over.
+func (a *API) handleCreateInvite(w http.ResponseWriter, r *http.Request) {
+	var req model.CreateInviteRequest
+	if err := decodeJSON(r, &req); err != nil {
+		writeError(w, http.StatusBadRequest, "invalid request body")
+		return
+	}
+	maxUses := 1
+	if req.MaxUses != nil {
+		maxUses = *req.MaxUses
+	}
+	if maxUses < 0 {
+		writeError(w, http.StatusBadRequest, "max_uses must not be negative")
+		return
+	}
+	now := model.NowMillis()
+	if req.ExpiresAt != nil {
+		if !req.ExpiresAt.After(now) {
+			writeError(w, http.StatusBadRequest, "expires_at must be in the future")
+			return
+		}
+		exp := req.ExpiresAt.UTC().Truncate(time.Millisecond)
+		req.ExpiresAt = &exp
+	}
+
+	inv := model.Invite{
+		Code:      newSlug(),
+		MaxUses:   maxUses,
+		ExpiresAt: req.ExpiresAt,
+		CreatedBy: userIDFrom(r.Context()),
+		CreatedAt: now,
+	}
+	if err := a.dbFor(r).CreateInvite(&inv); err != nil {
+		slog.Error("create invite", "error", err)
+		writeError(w, http.StatusInternalServerError, "internal error")
+		return
+	}
+	slog.Info("invite created", "user_id", inv.CreatedBy, "max_uses", inv.MaxUses)
+	writeJSON(w, http.StatusCreated, inv)
+}
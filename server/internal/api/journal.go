@@ -0,0 +1,197 @@
+package api
+
+import (
+	"errors"
+	"log/slog"
+	"net/http"
+	"strconv"
+	"strings"
+	"time"
+	"unicode/utf8"
+
+	"github.com/c0dev0id/notesd/server/internal/database"
+	"github.com/c0dev0id/notesd/server/internal/events"
+	"github.com/c0dev0id/notesd/server/internal/model"
+)
+
+func (a *API) handleGetJournalSettings(w http.ResponseWriter, r *http.Request) {
+	userID := userIDFrom(r.Context())
+
+	s, err := a.dbFor(r).GetJournalSettings(userID)
+	if err != nil {
+		slog.Error("get journal settings", "error", err)
+		writeError(w, http.StatusInternalServerError, "internal error")
+		return
+	}
+
+	writeJSON(w, http.StatusOK, s)
+}
+
+func (a *API) handleUpdateJournalSettings(w http.ResponseWriter, r *http.Request) {
+	userID := userIDFrom(r.Context())
+
+	var req model.JournalSettings
+	if err := decodeJSON(r, &req); err != nil {
+		writeError(w, http.StatusBadRequest, "invalid request body")
+		return
+	}
+	if strings.TrimSpace(req.TitleFormat) == "" {
+		req.TitleFormat = "%Y-%m-%d"
+	}
+	if utf8.RuneCountInString(req.TitleFormat) > maxTitleLen {
+		writeError(w, http.StatusBadRequest, "title_format too long")
+		return
+	}
+	if utf8.RuneCountInString(req.Template) > maxContentLen {
+		writeError(w, http.StatusBadRequest, "template too long")
+		return
+	}
+
+	if err := a.dbFor(r).SaveJournalSettings(userID, req); err != nil {
+		slog.Error("save journal settings", "error", err)
+		writeError(w, http.StatusInternalServerError, "internal error")
+		return
+	}
+
+	writeJSON(w, http.StatusOK, req)
+}
+
+// journalDay reads the {date} of a journal path. It writes a 400 and
+// returns false when the date is not YYYY-MM-DD.
+func journalDay(w http.ResponseWriter, r *http.Request) (string, time.Time, bool) {
+	day := r.PathValue("date")
+	t, err := time.Parse(calendarDateLayout, day)
+	if err != nil {
+		writeError(w, http.StatusBadRequest, "date must be YYYY-MM-DD")
+		return "", time.Time{}, false
+	}
+	return day, t, true
+}
+
+func (a *API) handleGetJournalEntry(w http.ResponseWriter, r *http.Request) {
+	userID := userIDFrom(r.Context())
+	day, _, ok := journalDay(w, r)
+	if !ok {
+		return
+	}
+
+	note, err := a.dbFor(r).GetJournalEntry(userID, day)
+	if errors.Is(err, database.ErrNotFound) {
+		writeError(w, http.StatusNotFound, "no journal entry for this date")
+		return
+	}
+	if err != nil {
+		slog.Error("get journal entry", "error", err)
+		writeError(w, http.StatusInternalServerError, "internal error")
+		return
+	}
+
+	writeJSON(w, http.StatusOK, note)
+}
+
+// handleCreateJournalEntry returns the journal note for a date, creating
+// it from the user's journal settings if there is none: 201 when created,
+// 200 when it existed.
+func (a *API) handleCreateJournalEntry(w http.ResponseWriter, r *http.Request) {
+	userID := userIDFrom(r.Context())
+	day, date, ok := journalDay(w, r)
+	if !ok {
+		return
+	}
+
+	var req model.JournalEntryRequest
+	if err := decodeJSON(r, &req); err != nil {
+		writeError(w, http.StatusBadRequest, "invalid request body")
+		return
+	}
+	if req.DeviceID == "" {
+		writeError(w, http.StatusBadRequest, "device_id is required")
+		return
+	}
+
+	db := a.dbFor(r)
+	note, err := db.GetJournalEntry(userID, day)
+	if err == nil {
+		writeJSON(w, http.StatusOK, note)
+		return
+	}
+	if !errors.Is(err, database.ErrNotFound) {
+		slog.Error("get journal entry", "error", err)
+		writeError(w, http.StatusInternalServerError, "internal error")
+		return
+	}
+
+	s, err := db.GetJournalSettings(userID)
+	if err != nil {
+		slog.Error("get journal settings", "error", err)
+		writeError(w, http.StatusInternalServerError, "internal error")
+		return
+	}
+	now := model.NowMillis()
+	note = &model.Note{
+		ID:               model.NewID(),
+		UserID:           userID,
+		Title:            formatJournalDate(s.TitleFormat, date),
+		Content:          formatJournalDate(s.Template, date),
+		Type:             "note",
+		Tags:             []string{},
+		ModifiedAt:       now,
+		ModifiedByDevice: req.DeviceID,
+		CreatedAt:        now,
+	}
+	if !a.checkQuota(w, r, userID, usageDelta{notes: 1, contentBytes: noteBytes(note)}) {
+		return
+	}
+
+	// Another device may have created the entry since the lookup.
+	note, created, err := db.CreateJournalEntry(day, note)
+	if err != nil {
+		slog.Error("create journal entry", "error", err)
+		writeError(w, http.StatusInternalServerError, "internal error")
+		return
+	}
+	if !created {
+		writeJSON(w, http.StatusOK, note)
+		return
+	}
+	a.bus.Publish(r.Context(), noteEvent(events.NoteCreated, userID, note))
+
+	writeJSON(w, http.StatusCreated, note)
+}
+
+// formatJournalDate expands the strftime-style directives of
+// model.JournalSettings in s for date d. Unknown directives are kept.
+func formatJournalDate(s string, d time.Time) string {
+	var b strings.Builder
+	for {
+		i := strings.IndexByte(s, '%')
+		if i < 0 || i == len(s)-1 {
+			b.WriteString(s)
+			return b.String()
+		}
+		b.WriteString(s[:i])
+		switch s[i+1] {
+		case 'Y':
+			b.WriteString(strconv.Itoa(d.Year()))
+		case 'm':
+			b.WriteString(d.Format("01"))
+		case 'd':
+			b.WriteString(d.Format("02"))
+		case 'e':
+			b.WriteString(strconv.Itoa(d.Day()))
+		case 'A':
+			b.WriteString(d.Weekday().String())
+		case 'a':
+			b.WriteString(d.Format("Mon"))
+		case 'B':
+			b.WriteString(d.Month().String())
+		case 'b':
+			b.WriteString(d.Format("Jan"))
+		case '%':
+			b.WriteByte('%')
+		default:
+			b.WriteString(s[i : i+2])
+		}
+		s = s[i+2:]
+	}
+}
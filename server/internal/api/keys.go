@@ -0,0 +1,339 @@
+package api
+
+import (
+	"context"
+	"crypto"
+	"crypto/ed25519"
+	"crypto/rand"
+	"crypto/rsa"
+	"crypto/sha256"
+	"crypto/x509"
+	"encoding/base64"
+	"encoding/json"
+	"encoding/pem"
+	"fmt"
+	"log/slog"
+	"math/big"
+	"net/http"
+	"os"
+	"sync"
+	"time"
+
+	"github.com/golang-jwt/jwt/v5"
+)
+
+// keyCheckInterval is how often RunKeyRotation checks whether the signing
+// key is due for rotation.
+const keyCheckInterval = time.Hour
+
+// Token signing algorithms, as named in auth.signing_algorithm and the JWT
+// alg header.
+const (
+	algRS256 = "RS256"
+	algEdDSA = "EdDSA"
+)
+
+// signingKey is one key of the keyring: RSA-2048 for RS256 or Ed25519 for
+// EdDSA. id is the RFC 7638 thumbprint of its public key and goes into the
+// kid header of the tokens it signs.
+type signingKey struct {
+	id      string
+	alg     string
+	private crypto.Signer
+	created time.Time
+}
+
+func (k *signingKey) public() crypto.PublicKey { return k.private.Public() }
+
+func (k *signingKey) method() jwt.SigningMethod {
+	if k.alg == algEdDSA {
+		return jwt.SigningMethodEdDSA
+	}
+	return jwt.SigningMethodRS256
+}
+
+// keyring holds the token signing keys, newest first. The newest key signs;
+// older ones only verify tokens issued before the last rotation, until
+// those tokens have expired, whatever their algorithm. All keys live in the
+// auth.private_key file as PEM blocks in the same order, each with a
+// Created header: PKCS #1 for RSA, PKCS #8 for Ed25519.
+type keyring struct {
+	path string
+	mu   sync.RWMutex
+	keys []*signingKey
+}
+
+// loadKeyring reads the key file, or creates it with a new alg key. A key
+// written before rotation existed has no Created header and is dated by
+// the file's modification time.
+func loadKeyring(path, alg string) (*keyring, error) {
+	k := &keyring{path: path}
+	data, err := os.ReadFile(path)
+	if os.IsNotExist(err) {
+		slog.Info("generating signing key", "path", path, "alg", alg)
+		key, err := newSigningKey(alg, time.Now())
+		if err != nil {
+			return nil, err
+		}
+		k.keys = []*signingKey{key}
+		return k, k.save()
+	}
+	if err != nil {
+		return nil, fmt.Errorf("read key file: %w", err)
+	}
+
+	var modTime time.Time
+	if info, err := os.Stat(path); err == nil {
+		modTime = info.ModTime()
+	}
+	for rest := data; ; {
+		var block *pem.Block
+		block, rest = pem.Decode(rest)
+		if block == nil {
+			break
+		}
+		key, err := parseSigningKey(block)
+		if err != nil {
+			return nil, err
+		}
+		key.created = modTime
+		if s := block.Headers["Created"]; s != "" {
+			if key.created, err = time.Parse(time.RFC3339, s); err != nil {
+				return nil, fmt.Errorf("key file: bad Created header %q", s)
+			}
+		}
+		k.keys = append(k.keys, key)
+	}
+	if len(k.keys) == 0 {
+		return nil, fmt.Errorf("no PEM block found")
+	}
+	return k, nil
+}
+
+func newSigningKey(alg string, now time.Time) (*signingKey, error) {
+	var priv crypto.Signer
+	var err error
+	switch alg {
+	case algRS256:
+		priv, err = rsa.GenerateKey(rand.Reader, 2048)
+	case algEdDSA:
+		_, priv, err = ed25519.GenerateKey(rand.Reader)
+	default:
+		return nil, fmt.Errorf("unknown signing algorithm %q", alg)
+	}
+	if err != nil {
+		return nil, fmt.Errorf("generate key: %w", err)
+	}
+	return &signingKey{id: keyID(priv.Public()), alg: alg, private: priv, created: now.UTC().Truncate(time.Second)}, nil
+}
+
+func parseSigningKey(block *pem.Block) (*signingKey, error) {
+	var priv any
+	var err error
+	switch block.Type {
+	case "RSA PRIVATE KEY":
+		priv, err = x509.ParsePKCS1PrivateKey(block.Bytes)
+	case "PRIVATE KEY":
+		priv, err = x509.ParsePKCS8PrivateKey(block.Bytes)
+	default:
+		return nil, fmt.Errorf("key file: unexpected PEM block %q", block.Type)
+	}
+	if err != nil {
+		return nil, err
+	}
+	switch priv := priv.(type) {
+	case *rsa.PrivateKey:
+		return &signingKey{id: keyID(&priv.PublicKey), alg: algRS256, private: priv}, nil
+	case ed25519.PrivateKey:
+		return &signingKey{id: keyID(priv.Public()), alg: algEdDSA, private: priv}, nil
+	}
+	return nil, fmt.Errorf("key file: unsupported key type %T", priv)
+}
+
+// jwk returns the public JWK members of pub that RFC 7638 hashes for the
+// thumbprint.
+func jwk(pub crypto.PublicKey) map[string]string {
+	switch pub := pub.(type) {
+	case *rsa.PublicKey:
+		return map[string]string{"kty": "RSA", "n": b64(pub.N.Bytes()), "e": b64(big.NewInt(int64(pub.E)).Bytes())}
+	case ed25519.PublicKey:
+		return map[string]string{"kty": "OKP", "crv": "Ed25519", "x": b64(pub)}
+	}
+	return nil
+}
+
+// keyID is the RFC 7638 JWK thumbprint of pub.
+func keyID(pub crypto.PublicKey) string {
+	// json.Marshal sorts map keys, as the thumbprint requires.
+	b, _ := json.Marshal(jwk(pub))
+	sum := sha256.Sum256(b)
+	return b64(sum[:])
+}
+
+func b64(b []byte) string { return base64.RawURLEncoding.EncodeToString(b) }
+
+// save writes all keys to the key file, replacing it atomically.
+func (k *keyring) save() error {
+	var out []byte
+	for _, key := range k.keys {
+		block := &pem.Block{Headers: map[string]string{"Created": key.created.UTC().Format(time.RFC3339)}}
+		if priv, ok := key.private.(*rsa.PrivateKey); ok {
+			block.Type, block.Bytes = "RSA PRIVATE KEY", x509.MarshalPKCS1PrivateKey(priv)
+		} else {
+			der, err := x509.MarshalPKCS8PrivateKey(key.private)
+			if err != nil {
+				return fmt.Errorf("encode key: %w", err)
+			}
+			block.Type, block.Bytes = "PRIVATE KEY", der
+		}
+		out = append(out, pem.EncodeToMemory(block)...)
+	}
+	tmp := k.path + ".tmp"
+	if err := os.WriteFile(tmp, out, 0600); err != nil {
+		return fmt.Errorf("write key file: %w", err)
+	}
+	if err := os.Rename(tmp, k.path); err != nil {
+		os.Remove(tmp)
+		return fmt.Errorf("write key file: %w", err)
+	}
+	return nil
+}
+
+// current returns the key that signs new tokens.
+func (k *keyring) current() *signingKey {
+	k.mu.RLock()
+	defer k.mu.RUnlock()
+	return k.keys[0]
+}
+
+// lookup returns the key with the given ID, or nil.
+func (k *keyring) lookup(id string) *signingKey {
+	k.mu.RLock()
+	defer k.mu.RUnlock()
+	for _, key := range k.keys {
+		if key.id == id {
+			return key
+		}
+	}
+	return nil
+}
+
+func (k *keyring) all() []*signingKey {
+	k.mu.RLock()
+	defer k.mu.RUnlock()
+	return append([]*signingKey(nil), k.keys...)
+}
+
+// rotate adds a new alg signing key when the current one uses another
+// algorithm or is older than every (0: never), and drops keys whose
+// successor has signed for longer than retain, the lifetime of the longest
+// token an old key may have issued. It reports whether the keyring changed.
+func (k *keyring) rotate(now time.Time, alg string, every, retain time.Duration) (bool, error) {
+	k.mu.Lock()
+	defer k.mu.Unlock()
+
+	keys := k.keys
+	if keys[0].alg != alg || (every > 0 && now.Sub(keys[0].created) >= every) {
+		key, err := newSigningKey(alg, now)
+		if err != nil {
+			return false, err
+		}
+		keys = append([]*signingKey{key}, keys...)
+	}
+	kept := []*signingKey{keys[0]}
+	for i := 1; i < len(keys); i++ {
+		if now.Sub(keys[i-1].created) < retain {
+			kept = append(kept, keys[i])
+		}
+	}
+	if len(kept) == len(k.keys) && kept[0] == k.keys[0] {
+		return false, nil
+	}
+
+	prev := k.keys
+	k.keys = kept
+	if err := k.save(); err != nil {
+		k.keys = prev
+		return false, err
+	}
+	return true, nil
+}
+
+// verificationKey is the jwt.Keyfunc for tokens this server issued. The
+// token's alg must be the one of the key its kid names. Tokens from before
+// key IDs existed carry no kid and are checked against every RSA key.
+func (a *API) verificationKey(t *jwt.Token) (any, error) {
+	if kid, ok := t.Header["kid"].(string); ok {
+		key := a.keys.lookup(kid)
+		if key == nil {
+			return nil, jwt.ErrTokenUnverifiable
+		}
+		if t.Method != key.method() {
+			return nil, jwt.ErrSignatureInvalid
+		}
+		return key.public(), nil
+	}
+	if t.Method != jwt.SigningMethodRS256 {
+		return nil, jwt.ErrSignatureInvalid
+	}
+	var set jwt.VerificationKeySet
+	for _, key := range a.keys.all() {
+		if key.alg == algRS256 {
+			set.Keys = append(set.Keys, key.public())
+		}
+	}
+	return set, nil
+}
+
+// signToken signs claims with the current key.
+func (a *API) signToken(claims jwt.MapClaims) (string, error) {
+	key := a.keys.current()
+	token := jwt.NewWithClaims(key.method(), claims)
+	token.Header["kid"] = key.id
+	return token.SignedString(key.private)
+}
+
+// RunKeyRotation rotates the signing key every auth.key_rotation until ctx
+// is cancelled. It returns immediately when rotation is off; New has
+// already switched keys if auth.signing_algorithm changed.
+func (a *API) RunKeyRotation(ctx context.Context) {
+	if a.keyRotation == 0 {
+		return
+	}
+	a.rotateKeys(time.Now())
+	ticker := time.NewTicker(min(keyCheckInterval, a.keyRotation))
+	defer ticker.Stop()
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case now := <-ticker.C:
+			a.rotateKeys(now)
+		}
+	}
+}
+
+func (a *API) rotateKeys(now time.Time) {
+	changed, err := a.keys.rotate(now, a.signingAlg, a.keyRotation, max(a.accessTokenExpiry, a.refreshTokenExpiry))
+	if err != nil {
+		slog.Error("rotate signing keys", "error", err)
+		return
+	}
+	if changed {
+		key := a.keys.current()
+		slog.Info("signing keys rotated", "current", key.id, "alg", key.alg, "keys", len(a.keys.all()))
+	}
+}
+
+// handleJWKS publishes the public halves of all signing keys, so other
+// services can verify access tokens.
+func (a *API) handleJWKS(w http.ResponseWriter, r *http.Request) {
+	keys := []map[string]string{}
+	for _, key := range a.keys.all() {
+		k := jwk(key.public())
+		k["use"], k["alg"], k["kid"] = "sig", key.alg, key.id
+		keys = append(keys, k)
+	}
+	w.Header().Set("Cache-Control", "public, max-age=300")
+	writeJSON(w, http.StatusOK, map[string]any{"keys": keys})
+}
@@ -0,0 +1,210 @@
+package api
+
+import (
+	"bytes"
+	"context"
+	"crypto/rand"
+	"encoding/base32"
+	"errors"
+	"io"
+	"log/slog"
+	"net"
+	"net/http"
+	"strings"
+	"unicode/utf8"
+
+	"github.com/c0dev0id/notesd/server/internal/blob"
+	"github.com/c0dev0id/notesd/server/internal/database"
+	"github.com/c0dev0id/notesd/server/internal/events"
+	"github.com/c0dev0id/notesd/server/internal/mail"
+	"github.com/c0dev0id/notesd/server/internal/model"
+)
+
+// mailInDevice is the device recorded for notes created from email.
+const mailInDevice = "mail-in"
+
+// mailInEncoding writes tokens in lower case without padding: mail systems
+// may change the case of an address's local part.
+var mailInEncoding = base32.NewEncoding("abcdefghijklmnopqrstuvwxyz234567").WithPadding(base32.NoPadding)
+
+func newMailInToken() string {
+	b := make([]byte, 16)
+	if _, err := rand.Read(b); err != nil {
+		panic(err)
+	}
+	return mailInEncoding.EncodeToString(b)
+}
+
+// mailInEnabled answers 404 and returns false while mail-in is off.
+func (a *API) mailInEnabled(w http.ResponseWriter) bool {
+	if a.config.MailIn.Listen == "" {
+		writeError(w, http.StatusNotFound, "mail-in is not configured on this server")
+		return false
+	}
+	return true
+}
+
+func (a *API) handleGetMailIn(w http.ResponseWriter, r *http.Request) {
+	if !a.mailInEnabled(w) {
+		return
+	}
+	userID := userIDFrom(r.Context())
+
+	created, err := a.dbFor(r).GetMailInCreated(userID)
+	if errors.Is(err, database.ErrNotFound) {
+		writeError(w, http.StatusNotFound, "no mail-in address")
+		return
+	}
+	if err != nil {
+		slog.Error("get mail-in address", "error", err)
+		writeError(w, http.StatusInternalServerError, "internal error")
+		return
+	}
+
+	writeJSON(w, http.StatusOK, model.MailInAddress{CreatedAt: created})
+}
+
+// handleCreateMailIn generates a new secret address, revoking the previous
+// one. Only the hash of its token is stored, so it is shown this once.
+func (a *API) handleCreateMailIn(w http.ResponseWriter, r *http.Request) {
+	if !a.mailInEnabled(w) {
+		return
+	}
+	userID := userIDFrom(r.Context())
+
+	token := newMailInToken()
+	now := model.NowMillis()
+	if err := a.dbFor(r).SetMailInToken(userID, database.HashToken(token), now); err != nil {
+		slog.Error("create mail-in address", "error", err)
+		writeError(w, http.StatusInternalServerError, "internal error")
+		return
+	}
+
+	writeJSON(w, http.StatusCreated, model.MailInAddress{
+		Address:   token + "@" + a.config.MailIn.Domain,
+		CreatedAt: now,
+	})
+}
+
+func (a *API) handleDeleteMailIn(w http.ResponseWriter, r *http.Request) {
+	if !a.mailInEnabled(w) {
+		return
+	}
+	userID := userIDFrom(r.Context())
+
+	err := a.dbFor(r).DeleteMailIn(userID)
+	if errors.Is(err, database.ErrNotFound) {
+		writeError(w, http.StatusNotFound, "no mail-in address")
+		return
+	}
+	if err != nil {
+		slog.Error("delete mail-in address", "error", err)
+		writeError(w, http.StatusInternalServerError, "internal error")
+		return
+	}
+
+	w.WriteHeader(http.StatusNoContent)
+}
+
+// ServeMailIn runs the mail-in SMTP server on ln until ctx is cancelled.
+func (a *API) ServeMailIn(ctx context.Context, ln net.Listener) error {
+	srv := &mail.Server{
+		Hostname: a.config.MailIn.Domain,
+		MaxSize:  a.config.MailIn.MaxSize,
+		Accept: func(ctx context.Context, rcpt string) bool {
+			_, err := a.mailInUser(ctx, rcpt)
+			return err == nil
+		},
+		Deliver: a.deliverMail,
+	}
+	return srv.Serve(ctx, ln)
+}
+
+// mailInUser returns the user whose secret address is rcpt.
+func (a *API) mailInUser(ctx context.Context, rcpt string) (string, error) {
+	local, domain, ok := strings.Cut(rcpt, "@")
+	if !ok || !strings.EqualFold(domain, a.config.MailIn.Domain) || local == "" {
+		return "", database.ErrNotFound
+	}
+	return a.db.WithContext(ctx).GetMailInUser(database.HashToken(strings.ToLower(local)))
+}
+
+// deliverMail turns a message for rcpt into a note: the subject is its
+// title, the text its content and the attached files its attachments.
+func (a *API) deliverMail(ctx context.Context, rcpt string, data []byte) error {
+	userID, err := a.mailInUser(ctx, rcpt)
+	if errors.Is(err, database.ErrNotFound) {
+		return mail.Reject("No such mailbox")
+	}
+	if err != nil {
+		return err
+	}
+	msg, err := mail.Parse(data)
+	if err != nil {
+		return mail.Reject("Malformed message")
+	}
+
+	title := msg.Subject
+	if utf8.RuneCountInString(title) > maxTitleLen {
+		title = string([]rune(title)[:maxTitleLen])
+	}
+	if utf8.RuneCountInString(msg.Text) > maxContentLen {
+		return mail.Reject("Message text too long")
+	}
+	now := model.NowMillis()
+	n := &model.Note{
+		ID:               model.NewID(),
+		UserID:           userID,
+		Title:            title,
+		Content:          msg.Text,
+		Type:             "note",
+		Tags:             []string{},
+		ModifiedAt:       now,
+		ModifiedByDevice: mailInDevice,
+		CreatedAt:        now,
+	}
+
+	db := a.db.WithContext(ctx)
+	usage := usageDelta{notes: 1, contentBytes: noteBytes(n)}
+	for _, at := range msg.Attachments {
+		usage.attachmentBytes += int64(len(at.Data))
+	}
+	reason, err := a.quotaExceeded(db, userID, usage)
+	if err != nil {
+		return err
+	}
+	if reason != "" {
+		return mail.Reject("%s", reason)
+	}
+
+	var attachments []*model.Attachment
+	removeBlobs := func() {
+		for _, at := range attachments {
+			a.blobs.Remove(at.ID)
+		}
+	}
+	for _, f := range msg.Attachments {
+		at, err := a.storeImportFile(userID, n.ID, importFile{
+			name:        f.Filename,
+			contentType: f.ContentType,
+			size:        int64(len(f.Data)),
+			open:        func() (io.ReadCloser, error) { return io.NopCloser(bytes.NewReader(f.Data)), nil },
+		}, now)
+		if err != nil {
+			removeBlobs()
+			if errors.Is(err, blob.ErrTooLarge) {
+				return mail.Reject("Attachment %s too large", f.Filename)
+			}
+			return err
+		}
+		attachments = append(attachments, at)
+	}
+	if err := db.Import([]*model.Note{n}, nil, attachments); err != nil {
+		removeBlobs()
+		return err
+	}
+
+	slog.Info("note created from mail", "user_id", userID, "note_id", n.ID, "attachments", len(attachments))
+	a.bus.Publish(ctx, noteEvent(events.NoteCreated, userID, n))
+	return nil
+}
@@ -0,0 +1,62 @@
+package api
+
+import (
+	"context"
+	"log/slog"
+	"net/http"
+	"time"
+
+	"github.com/c0dev0id/notesd/server/internal/database"
+	"github.com/c0dev0id/notesd/server/internal/model"
+)
+
+// RunMaintenance maintains the database every database.maintenance_interval
+// until ctx is cancelled. It returns immediately when the job is off.
+func (a *API) RunMaintenance(ctx context.Context) {
+	if a.maintainInterval == 0 {
+		return
+	}
+	ticker := time.NewTicker(a.maintainInterval)
+	defer ticker.Stop()
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case <-ticker.C:
+			a.maintain(a.db)
+		}
+	}
+}
+
+// maintain runs the maintenance job and keeps its report for
+// /api/v1/health and GET /api/v1/admin/maintenance.
+func (a *API) maintain(db *database.DB) model.Maintenance {
+	m, err := db.Maintain()
+	switch {
+	case err != nil:
+		m.Error = err.Error()
+		slog.Error("database maintenance", "error", err)
+	case !m.OK:
+		slog.Error("database integrity check failed", "problems", m.Integrity)
+	default:
+		slog.Info("database maintained", "freed_pages", m.FreedPages,
+			"checkpointed", m.Checkpointed, "duration_ms", m.DurationMs)
+	}
+	a.lastMaintenance.Store(&m)
+	return m
+}
+
+// handleAdminMaintenance returns the report of the last maintenance run.
+func (a *API) handleAdminMaintenance(w http.ResponseWriter, r *http.Request) {
+	m := a.lastMaintenance.Load()
+	if m == nil {
+		writeError(w, http.StatusNotFound, "database maintenance has not run yet")
+		return
+	}
+	writeJSON(w, http.StatusOK, m)
+}
+
+// handleAdminRunMaintenance maintains the database now.
+func (a *API) handleAdminRunMaintenance(w http.ResponseWriter, r *http.Request) {
+	writeJSON(w, http.StatusOK, a.maintain(a.dbFor(r)))
+}
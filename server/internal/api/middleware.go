@@ -2,19 +2,23 @@ package api
 
 import (
 	"context"
+	"errors"
 	"log/slog"
 	"net/http"
 	"strings"
 	"time"
 
+	"github.com/c0dev0id/notesd/server/internal/database"
+	"github.com/c0dev0id/notesd/server/internal/model"
 	"github.com/golang-jwt/jwt/v5"
 )
 
 type contextKey string
 
 const (
-	ctxUserID   contextKey = "user_id"
-	ctxDeviceID contextKey = "device_id"
+	ctxUserID        contextKey = "user_id"
+	ctxDeviceID      contextKey = "device_id"
+	ctxAccessTokenID contextKey = "access_token_id"
 )
 
 func userIDFrom(ctx context.Context) string {
@@ -27,7 +31,15 @@ func deviceIDFrom(ctx context.Context) string {
 	return v
 }
 
-// auth wraps a handler with JWT access token verification.
+// accessTokenIDFrom returns the ID of the personal access token that
+// authenticated the request, or "" for a JWT session.
+func accessTokenIDFrom(ctx context.Context) string {
+	v, _ := ctx.Value(ctxAccessTokenID).(string)
+	return v
+}
+
+// auth wraps a handler with verification of a JWT access token or a
+// personal access token.
 func (a *API) auth(next http.HandlerFunc) http.HandlerFunc {
 	return func(w http.ResponseWriter, r *http.Request) {
 		header := r.Header.Get("Authorization")
@@ -42,13 +54,22 @@ func (a *API) auth(next http.HandlerFunc) http.HandlerFunc {
 			return
 		}
 
-		claims := jwt.MapClaims{}
-		parsed, err := jwt.ParseWithClaims(token, claims, func(t *jwt.Token) (any, error) {
-			if _, ok := t.Method.(*jwt.SigningMethodRSA); !ok {
-				return nil, jwt.ErrSignatureInvalid
+		if strings.HasPrefix(token, accessTokenPrefix) {
+			pat := a.lookupAccessToken(w, r, token)
+			if pat == nil {
+				return
+			}
+			if pat.Scope == model.ScopeRead && isWrite(r) {
+				writeError(w, http.StatusForbidden, "token is read-only")
+				return
 			}
-			return &a.privateKey.PublicKey, nil
-		})
+			ctx := context.WithValue(r.Context(), ctxAccessTokenID, pat.ID)
+			a.authenticated(w, r.WithContext(ctx), next, pat.UserID, "token:"+pat.ID)
+			return
+		}
+
+		claims := jwt.MapClaims{}
+		parsed, err := jwt.ParseWithClaims(token, claims, a.verificationKey)
 		if err != nil || !parsed.Valid {
 			slog.Debug("jwt validation failed", "error", err)
 			writeError(w, http.StatusUnauthorized, "invalid token")
@@ -68,12 +89,70 @@ func (a *API) auth(next http.HandlerFunc) http.HandlerFunc {
 			return
 		}
 
-		ctx := context.WithValue(r.Context(), ctxUserID, sub)
-		ctx = context.WithValue(ctx, ctxDeviceID, deviceID)
-		next(w, r.WithContext(ctx))
+		a.authenticated(w, r, next, sub, deviceID)
 	}
 }
 
+// authenticated runs next as user sub on device deviceID, after the write
+// rate limit.
+func (a *API) authenticated(w http.ResponseWriter, r *http.Request, next http.HandlerFunc, sub, deviceID string) {
+	if info := requestInfoFrom(r.Context()); info != nil {
+		info.userID = sub
+		info.deviceID = deviceID
+	}
+
+	if isWrite(r) {
+		if ok, retry := a.writeLimiter.allow(sub); !ok {
+			tooManyRequests(w, retry)
+			return
+		}
+	}
+
+	ctx := context.WithValue(r.Context(), ctxUserID, sub)
+	ctx = context.WithValue(ctx, ctxDeviceID, deviceID)
+	next(w, r.WithContext(ctx))
+}
+
+// session wraps a handler that needs a login session: personal access
+// tokens may not log out, change the password or manage tokens, so a
+// leaked token cannot be used to take over the account.
+func (a *API) session(next http.HandlerFunc) http.HandlerFunc {
+	return a.auth(func(w http.ResponseWriter, r *http.Request) {
+		if accessTokenIDFrom(r.Context()) != "" {
+			writeError(w, http.StatusForbidden, "not allowed with a personal access token")
+			return
+		}
+		next(w, r)
+	})
+}
+
+// admin wraps a handler so that only accounts listed in admin.emails may
+// call it. Everyone else gets 403.
+func (a *API) admin(next http.HandlerFunc) http.HandlerFunc {
+	return a.auth(func(w http.ResponseWriter, r *http.Request) {
+		user, err := a.dbFor(r).GetUserByID(userIDFrom(r.Context()))
+		if err != nil && !errors.Is(err, database.ErrNotFound) {
+			slog.Error("get user for admin check", "error", err)
+			writeError(w, http.StatusInternalServerError, "internal error")
+			return
+		}
+		if user == nil || !a.isAdmin(user.Email) {
+			writeError(w, http.StatusForbidden, "admin access required")
+			return
+		}
+		next(w, r)
+	})
+}
+
+func (a *API) isAdmin(email string) bool {
+	for _, e := range a.config.Admin.Emails {
+		if strings.EqualFold(strings.TrimSpace(e), email) {
+			return true
+		}
+	}
+	return false
+}
+
 // issueAccessToken creates a short-lived JWT access token.
 func (a *API) issueAccessToken(userID, deviceID string) (string, error) {
 	now := time.Now().UTC()
@@ -84,8 +163,7 @@ func (a *API) issueAccessToken(userID, deviceID string) (string, error) {
 		"iat":       now.Unix(),
 		"exp":       now.Add(a.accessTokenExpiry).Unix(),
 	}
-	token := jwt.NewWithClaims(jwt.SigningMethodRS256, claims)
-	return token.SignedString(a.privateKey)
+	return a.signToken(claims)
 }
 
 // issueRefreshToken creates a long-lived JWT refresh token.
@@ -99,19 +177,13 @@ func (a *API) issueRefreshToken(tokenID, userID, deviceID string) (string, error
 		"iat":       now.Unix(),
 		"exp":       now.Add(a.refreshTokenExpiry).Unix(),
 	}
-	token := jwt.NewWithClaims(jwt.SigningMethodRS256, claims)
-	return token.SignedString(a.privateKey)
+	return a.signToken(claims)
 }
 
 // parseRefreshToken validates a refresh JWT and extracts claims.
 func (a *API) parseRefreshToken(tokenStr string) (userID, tokenID, deviceID string, err error) {
 	claims := jwt.MapClaims{}
-	parsed, err := jwt.ParseWithClaims(tokenStr, claims, func(t *jwt.Token) (any, error) {
-		if _, ok := t.Method.(*jwt.SigningMethodRSA); !ok {
-			return nil, jwt.ErrSignatureInvalid
-		}
-		return &a.privateKey.PublicKey, nil
-	})
+	parsed, err := jwt.ParseWithClaims(tokenStr, claims, a.verificationKey)
 	if err != nil || !parsed.Valid {
 		return "", "", "", jwt.ErrSignatureInvalid
 	}
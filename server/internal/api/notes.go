@@ -4,9 +4,13 @@ import (
 	"errors"
 	"log/slog"
 	"net/http"
+	"slices"
+	"strings"
+	"time"
 	"unicode/utf8"
 
 	"github.com/c0dev0id/notesd/server/internal/database"
+	"github.com/c0dev0id/notesd/server/internal/events"
 	"github.com/c0dev0id/notesd/server/internal/model"
 )
 
@@ -15,6 +19,27 @@ const (
 	maxContentLen = 500000 // 500KB of text
 )
 
+// noteFilterFrom reads the listing filters shared by list and search.
+// ?snoozed=true returns only notes that are currently snoozed, ?tag= only
+// notes with that tag and ?meta.<key>= only notes whose front matter has
+// the key with that value.
+func noteFilterFrom(r *http.Request) database.NoteFilter {
+	q := r.URL.Query()
+	f := database.NoteFilter{
+		Snoozed: q.Get("snoozed") == "true",
+		Tag:     strings.TrimSpace(q.Get("tag")),
+	}
+	for k, v := range q {
+		if key, ok := strings.CutPrefix(k, "meta."); ok && key != "" {
+			if f.Meta == nil {
+				f.Meta = map[string]string{}
+			}
+			f.Meta[key] = strings.TrimSpace(v[0])
+		}
+	}
+	return f
+}
+
 func (a *API) handleListNotes(w http.ResponseWriter, r *http.Request) {
 	userID := userIDFrom(r.Context())
 	limit := queryInt(r, "limit", 50)
@@ -24,7 +49,14 @@ func (a *API) handleListNotes(w http.ResponseWriter, r *http.Request) {
 		limit = 200
 	}
 
-	notes, total, err := a.db.ListNotes(userID, limit, offset)
+	f := noteFilterFrom(r)
+	var err error
+	if f.Sort, err = database.ParseNoteSort(r.URL.Query().Get("sort")); err != nil {
+		writeError(w, http.StatusBadRequest, err.Error())
+		return
+	}
+
+	notes, total, err := a.dbFor(r).ListNotes(userID, f, limit, offset)
 	if err != nil {
 		slog.Error("list notes", "error", err)
 		writeError(w, http.StatusInternalServerError, "internal error")
@@ -34,7 +66,7 @@ func (a *API) handleListNotes(w http.ResponseWriter, r *http.Request) {
 		notes = []model.Note{}
 	}
 
-	writeJSON(w, http.StatusOK, model.NoteListResponse{
+	writeCached(w, r, time.Time{}, model.NoteListResponse{
 		Notes:  notes,
 		Total:  total,
 		Limit:  limit,
@@ -46,7 +78,7 @@ func (a *API) handleGetNote(w http.ResponseWriter, r *http.Request) {
 	userID := userIDFrom(r.Context())
 	id := r.PathValue("id")
 
-	note, err := a.db.GetNote(id, userID)
+	note, err := a.dbFor(r).GetNote(id, userID)
 	if errors.Is(err, database.ErrNotFound) {
 		writeError(w, http.StatusNotFound, "note not found")
 		return
@@ -57,7 +89,7 @@ func (a *API) handleGetNote(w http.ResponseWriter, r *http.Request) {
 		return
 	}
 
-	writeJSON(w, http.StatusOK, note)
+	writeCached(w, r, note.ModifiedAt, note)
 }
 
 func (a *API) handleCreateNote(w http.ResponseWriter, r *http.Request) {
@@ -83,12 +115,21 @@ func (a *API) handleCreateNote(w http.ResponseWriter, r *http.Request) {
 		return
 	}
 
+	tags, err := normalizeTags(req.Tags)
+	if err != nil {
+		writeError(w, http.StatusBadRequest, err.Error())
+		return
+	}
+	if tags == nil {
+		tags = []string{}
+	}
+
 	noteType := req.Type
 	if noteType == "" {
 		noteType = "note"
 	}
-	if noteType != "note" && noteType != "todo_list" {
-		writeError(w, http.StatusBadRequest, "type must be 'note' or 'todo_list'")
+	if !a.validNoteType(noteType) {
+		writeError(w, http.StatusBadRequest, "type "+a.noteTypeRule())
 		return
 	}
 
@@ -99,16 +140,21 @@ func (a *API) handleCreateNote(w http.ResponseWriter, r *http.Request) {
 		Title:            req.Title,
 		Content:          req.Content,
 		Type:             noteType,
+		Tags:             tags,
 		ModifiedAt:       now,
 		ModifiedByDevice: req.DeviceID,
 		CreatedAt:        now,
 	}
+	if !a.checkQuota(w, r, userID, usageDelta{notes: 1, contentBytes: noteBytes(note)}) {
+		return
+	}
 
-	if err := a.db.CreateNote(note); err != nil {
+	if err := a.dbFor(r).CreateNote(note); err != nil {
 		slog.Error("create note", "error", err)
 		writeError(w, http.StatusInternalServerError, "internal error")
 		return
 	}
+	a.bus.Publish(r.Context(), noteEvent(events.NoteCreated, userID, note))
 
 	writeJSON(w, http.StatusCreated, note)
 }
@@ -137,7 +183,13 @@ func (a *API) handleUpdateNote(w http.ResponseWriter, r *http.Request) {
 		return
 	}
 
-	note, err := a.db.GetNote(id, userID)
+	tags, err := normalizeTags(req.Tags)
+	if err != nil {
+		writeError(w, http.StatusBadRequest, err.Error())
+		return
+	}
+
+	note, err := a.dbFor(r).GetNote(id, userID)
 	if errors.Is(err, database.ErrNotFound) {
 		writeError(w, http.StatusNotFound, "note not found")
 		return
@@ -148,38 +200,51 @@ func (a *API) handleUpdateNote(w http.ResponseWriter, r *http.Request) {
 		return
 	}
 
+	oldBytes := noteBytes(note)
 	if req.Title != nil {
 		note.Title = *req.Title
 	}
 	if req.Content != nil {
 		note.Content = *req.Content
 	}
+	if !a.checkQuota(w, r, userID, usageDelta{contentBytes: noteBytes(note) - oldBytes}) {
+		return
+	}
 	if req.Type != nil {
-		if *req.Type != "note" && *req.Type != "todo_list" {
-			writeError(w, http.StatusBadRequest, "type must be 'note' or 'todo_list'")
+		if !a.validNoteType(*req.Type) {
+			writeError(w, http.StatusBadRequest, "type "+a.noteTypeRule())
 			return
 		}
 		note.Type = *req.Type
 	}
+	if tags != nil {
+		note.Tags = tags
+	}
 	note.ModifiedAt = model.NowMillis()
 	note.ModifiedByDevice = req.DeviceID
 
-	if err := a.db.UpdateNote(note); err != nil {
+	if err := a.dbFor(r).UpdateNote(note); err != nil {
 		slog.Error("update note", "error", err)
 		writeError(w, http.StatusInternalServerError, "internal error")
 		return
 	}
+	a.bus.Publish(r.Context(), noteEvent(events.NoteUpdated, userID, note))
 
 	writeJSON(w, http.StatusOK, note)
 }
 
 func (a *API) handleDeleteNote(w http.ResponseWriter, r *http.Request) {
+	if r.URL.Query().Get("purge") == "true" {
+		a.handlePurgeNote(w, r)
+		return
+	}
+
 	userID := userIDFrom(r.Context())
 	id := r.PathValue("id")
 	deviceID := deviceIDFrom(r.Context())
 
 	now := model.NowMillis().UnixMilli()
-	err := a.db.DeleteNote(id, userID, now, deviceID)
+	cascaded, err := a.dbFor(r).DeleteNote(id, userID, now, deviceID, a.noteTodos())
 	if errors.Is(err, database.ErrNotFound) {
 		writeError(w, http.StatusNotFound, "note not found")
 		return
@@ -189,10 +254,47 @@ func (a *API) handleDeleteNote(w http.ResponseWriter, r *http.Request) {
 		writeError(w, http.StatusInternalServerError, "internal error")
 		return
 	}
+	a.bus.Publish(r.Context(), append([]events.Event{itemEvent(events.NoteDeleted, userID, id, deviceID)},
+		cascadeEvents(userID, deviceID, cascaded)...)...)
 
 	w.WriteHeader(http.StatusNoContent)
 }
 
+// noteTodos returns what deleting a note does to its todos.
+func (a *API) noteTodos() database.TodoCascade {
+	return database.TodoCascade(a.config.Notes.DeleteTodos)
+}
+
+// validNoteType reports whether notes may have type t on this server.
+func (a *API) validNoteType(t string) bool {
+	return slices.Contains(a.config.Notes.NoteTypes(), t)
+}
+
+// noteTypeRule names the valid note types, as in "must be 'note' or
+// 'todo_list'".
+func (a *API) noteTypeRule() string {
+	var quoted []string
+	for _, t := range a.config.Notes.NoteTypes() {
+		quoted = append(quoted, "'"+t+"'")
+	}
+	last := len(quoted) - 1
+	return "must be " + strings.Join(quoted[:last], ", ") + " or " + quoted[last]
+}
+
+// cascadeEvents returns the events of the todos changed by deleting notes.
+// Detached todos are published as a bulk change.
+func cascadeEvents(userID, deviceID string, c database.CascadedTodos) []events.Event {
+	var evs []events.Event
+	for _, id := range c.Deleted {
+		evs = append(evs, itemEvent(events.TodoDeleted, userID, id, deviceID))
+	}
+	if len(c.Detached) > 0 {
+		evs = append(evs, bulkEvents(events.TodoUpdated, userID, deviceID, c.Detached)...)
+		evs = append(evs, resyncEvent(userID, deviceID))
+	}
+	return evs
+}
+
 func (a *API) handleSearchNotes(w http.ResponseWriter, r *http.Request) {
 	userID := userIDFrom(r.Context())
 	query := r.URL.Query().Get("q")
@@ -207,7 +309,7 @@ func (a *API) handleSearchNotes(w http.ResponseWriter, r *http.Request) {
 		limit = 200
 	}
 
-	notes, total, err := a.db.SearchNotes(userID, query, limit, offset)
+	notes, total, err := a.dbFor(r).SearchNotes(userID, query, noteFilterFrom(r), limit, offset)
 	if err != nil {
 		slog.Error("search notes", "error", err)
 		writeError(w, http.StatusInternalServerError, "internal error")
@@ -224,3 +326,60 @@ func (a *API) handleSearchNotes(w http.ResponseWriter, r *http.Request) {
 		Offset: offset,
 	})
 }
+
+// handleSnoozeNote hides a note from default listings and search until the
+// given time. Snoozing again replaces the previous date.
+func (a *API) handleSnoozeNote(w http.ResponseWriter, r *http.Request) {
+	userID := userIDFrom(r.Context())
+	id := r.PathValue("id")
+
+	var req model.SnoozeNoteRequest
+	if err := decodeJSON(r, &req); err != nil {
+		writeError(w, http.StatusBadRequest, "invalid request body")
+		return
+	}
+	if req.DeviceID == "" {
+		writeError(w, http.StatusBadRequest, "device_id is required")
+		return
+	}
+	now := model.NowMillis()
+	if !req.Until.After(now) {
+		writeError(w, http.StatusBadRequest, "until must be in the future")
+		return
+	}
+
+	until := req.Until.Truncate(time.Millisecond)
+	a.setNoteSnooze(w, r, userID, id, &until, req.DeviceID, now)
+}
+
+func (a *API) handleUnsnoozeNote(w http.ResponseWriter, r *http.Request) {
+	userID := userIDFrom(r.Context())
+	id := r.PathValue("id")
+	a.setNoteSnooze(w, r, userID, id, nil, deviceIDFrom(r.Context()), model.NowMillis())
+}
+
+func (a *API) setNoteSnooze(w http.ResponseWriter, r *http.Request, userID, id string, until *time.Time, deviceID string, now time.Time) {
+	note, err := a.dbFor(r).GetNote(id, userID)
+	if errors.Is(err, database.ErrNotFound) {
+		writeError(w, http.StatusNotFound, "note not found")
+		return
+	}
+	if err != nil {
+		slog.Error("get note for snooze", "error", err)
+		writeError(w, http.StatusInternalServerError, "internal error")
+		return
+	}
+
+	note.SnoozedUntil = until
+	note.ModifiedAt = now
+	note.ModifiedByDevice = deviceID
+
+	if err := a.dbFor(r).UpdateNote(note); err != nil {
+		slog.Error("snooze note", "error", err)
+		writeError(w, http.StatusInternalServerError, "internal error")
+		return
+	}
+	a.bus.Publish(r.Context(), noteEvent(events.NoteUpdated, userID, note))
+
+	writeJSON(w, http.StatusOK, note)
+}
@@ -0,0 +1,397 @@
+package api
+
+import (
+	"encoding/json"
+	"net/http"
+	"reflect"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/c0dev0id/notesd/server/internal/model"
+	"github.com/c0dev0id/notesd/server/internal/version"
+)
+
+// apiOperation describes one route for the OpenAPI document. request and
+// response are zero values of the JSON body types, or a content type
+// string for other bodies; a nil response means no body. Query parameters
+// are "name" for strings or "name:integer" and "name:boolean".
+type apiOperation struct {
+	pattern  string
+	summary  string
+	auth     string // "" bearer token, "none", "admin" or "feed token"
+	query    []string
+	request  any
+	status   int // success status, 200 when zero
+	response any
+}
+
+// apiOperations lists every route registered in Routes. A test keeps the
+// two in step.
+var apiOperations = []apiOperation{
+	{pattern: "GET /api/v1/health", summary: "Health check with uptime and version", auth: "none", response: map[string]any{}},
+	{pattern: "GET /api/v1/version", summary: "Server version and capabilities", auth: "none", response: model.VersionInfo{}},
+	{pattern: "GET /api/v1/meta/note-types", summary: "The types notes may have: note, todo_list and those configured in notes.types", auth: "none", response: model.NoteTypes{}},
+	{pattern: "GET /api/v1/openapi.json", summary: "This OpenAPI document", auth: "none", response: map[string]any{}},
+	{pattern: "GET /api/docs", summary: "Swagger UI for this document, when server.swagger_ui is set", auth: "none", response: "text/html"},
+	{pattern: "GET /", summary: "Embedded web client, when server.web_ui is set", auth: "none", response: "text/html"},
+
+	{pattern: "GET /.well-known/jwks.json", summary: "Public keys that verify access tokens", auth: "none", response: map[string]any{}},
+	{pattern: "POST /api/v1/auth/register", summary: "Register an account", auth: "none", request: model.RegisterRequest{}, status: http.StatusCreated, response: model.User{}},
+	{pattern: "POST /api/v1/auth/login", summary: "Log in and get tokens", auth: "none", request: model.LoginRequest{}, response: model.AuthResponse{}},
+	{pattern: "POST /api/v1/auth/refresh", summary: "Exchange a refresh token for new tokens", auth: "none", request: model.RefreshRequest{}, response: model.AuthResponse{}},
+	{pattern: "POST /api/v1/auth/reset-request", summary: "Mail a password reset link", auth: "none", request: model.PasswordResetRequest{}, status: http.StatusAccepted},
+	{pattern: "POST /api/v1/auth/reset-confirm", summary: "Set a new password with a reset token and revoke all sessions", auth: "none", request: model.PasswordResetConfirmRequest{}, status: http.StatusNoContent},
+	{pattern: "POST /api/v1/auth/logout", summary: "Revoke the device's refresh tokens", status: http.StatusNoContent},
+	{pattern: "GET /api/v1/auth/tokens", summary: "List personal access tokens", response: []model.AccessToken{}},
+	{pattern: "POST /api/v1/auth/tokens", summary: "Create a personal access token; the secret is only returned here", request: model.CreateAccessTokenRequest{}, status: http.StatusCreated, response: model.AccessToken{}},
+	{pattern: "DELETE /api/v1/auth/tokens/{id}", summary: "Revoke a personal access token", status: http.StatusNoContent},
+	{pattern: "POST /api/v1/auth/password", summary: "Change the password and revoke other devices' refresh tokens and all personal access tokens", request: model.ChangePasswordRequest{}, status: http.StatusNoContent},
+
+	{pattern: "GET /api/v1/search", summary: "Search notes and todos together", query: []string{"q", "limit:integer", "offset:integer"}, response: model.SearchResponse{}},
+	{pattern: "GET /api/v1/search/validate", summary: "Parse a search query and return the query grammar", query: []string{"q"}, response: model.SearchQueryCheck{}},
+
+	{pattern: "GET /api/v1/notes/search", summary: "Full-text search notes; meta.<key>=<value> filters by front matter", query: []string{"q", "snoozed:boolean", "tag", "limit:integer", "offset:integer"}, response: model.NoteListResponse{}},
+	{pattern: "POST /api/v1/notes/bulk", summary: "Delete, tag or untag many notes in one transaction", request: model.BulkRequest{}, response: model.BulkResponse{}},
+	{pattern: "GET /api/v1/notes/duplicates", summary: "Find groups of duplicate notes", response: model.DuplicatesResponse{}},
+	{pattern: "GET /api/v1/notes/{id}", summary: "Get a note", response: model.Note{}},
+	{pattern: "GET /api/v1/notes/{id}/html", summary: "Render a note as HTML", response: "text/html"},
+	{pattern: "GET /api/v1/notes/{id}/backlinks", summary: "Notes linking to this note with [[Title]]", response: []model.Note{}},
+	{pattern: "GET /api/v1/notes/{id}/history", summary: "Revisions of a note in the git history, newest first", query: []string{"limit:integer"}, response: model.NoteHistoryResponse{}},
+	{pattern: "GET /api/v1/notes/{id}/diff", summary: "Diff of the latest change to a note at or before a revision", query: []string{"rev"}, response: model.NoteDiff{}},
+	{pattern: "GET /api/v1/notes", summary: "List notes; meta.<key>=<value> filters by front matter", query: []string{"snoozed:boolean", "tag", "sort", "limit:integer", "offset:integer"}, response: model.NoteListResponse{}},
+	{pattern: "POST /api/v1/notes", summary: "Create a note", request: model.CreateNoteRequest{}, status: http.StatusCreated, response: model.Note{}},
+	{pattern: "PUT /api/v1/notes/{id}", summary: "Update a note", request: model.UpdateNoteRequest{}, response: model.Note{}},
+	{pattern: "PATCH /api/v1/notes/{id}", summary: "Update a note with a JSON merge patch (RFC 7386)", request: model.Note{}, response: model.Note{}},
+	{pattern: "DELETE /api/v1/notes/{id}", summary: "Delete a note; purge=true deletes it for good with its attachments", query: []string{"purge:boolean"}, status: http.StatusNoContent},
+	{pattern: "POST /api/v1/notes/{id}/merge", summary: "Merge other notes into this one", request: model.MergeNotesRequest{}, response: model.Note{}},
+	{pattern: "POST /api/v1/notes/{id}/snooze", summary: "Snooze a note", request: model.SnoozeNoteRequest{}, response: model.Note{}},
+	{pattern: "DELETE /api/v1/notes/{id}/snooze", summary: "Wake a snoozed note", response: model.Note{}},
+	{pattern: "POST /api/v1/notes/{id}/unfurl", summary: "Fetch the page of a bookmark note again; archive=true keeps its text", query: []string{"archive:boolean"}, response: model.Note{}},
+	{pattern: "POST /api/v1/bookmarks", summary: "Save a web page as a bookmark note", request: model.CreateBookmarkRequest{}, status: http.StatusCreated, response: model.Note{}},
+	{pattern: "GET /api/v1/unfurl", summary: "Preview a web page: title, description, image and favicon", query: []string{"url:string"}, response: model.LinkPreview{}},
+
+	{pattern: "GET /api/v1/push/vapid-key", summary: "VAPID public key for Web Push", auth: "none", response: map[string]string{}},
+	{pattern: "GET /api/v1/push/subscriptions", summary: "List Web Push subscriptions", response: []model.PushSubscription{}},
+	{pattern: "POST /api/v1/push/subscriptions", summary: "Register a Web Push subscription", request: model.PushSubscriptionRequest{}, status: http.StatusCreated, response: model.PushSubscription{}},
+	{pattern: "DELETE /api/v1/push/subscriptions/{id}", summary: "Remove a Web Push subscription", status: http.StatusNoContent},
+	{pattern: "GET /api/v1/reminders/webhook", summary: "Get the reminder webhook", response: model.ReminderWebhook{}},
+	{pattern: "PUT /api/v1/reminders/webhook", summary: "Set or clear the reminder webhook", request: model.ReminderWebhook{}, response: model.ReminderWebhook{}},
+
+	{pattern: "GET /api/v1/webhooks", summary: "List webhooks", response: []model.Webhook{}},
+	{pattern: "POST /api/v1/webhooks", summary: "Register a webhook for note and todo events; the secret is only returned here", request: model.CreateWebhookRequest{}, status: http.StatusCreated, response: model.Webhook{}},
+	{pattern: "DELETE /api/v1/webhooks/{id}", summary: "Delete a webhook and its delivery history", status: http.StatusNoContent},
+	{pattern: "GET /api/v1/webhooks/{id}/deliveries", summary: "Recent deliveries of a webhook, newest first", query: []string{"limit:integer"}, response: []model.WebhookDelivery{}},
+
+	{pattern: "POST /api/v1/notes/{id}/publish", summary: "Publish a note under a public link", request: model.PublishNoteRequest{}, status: http.StatusCreated, response: model.PublicLink{}},
+	{pattern: "GET /api/v1/notes/{id}/links", summary: "List a note's public links", response: []model.PublicLink{}},
+	{pattern: "DELETE /api/v1/links/{slug}", summary: "Revoke a public link", status: http.StatusNoContent},
+	{pattern: "GET /p/{slug}", summary: "Read a published note, as HTML for browsers or with format=html", auth: "none", query: []string{"format"}, response: model.PublicNote{}},
+
+	{pattern: "GET /api/v1/notes/{id}/attachments", summary: "List a note's attachments", response: []model.Attachment{}},
+	{pattern: "POST /api/v1/notes/{id}/attachments", summary: "Upload an attachment as the multipart field file", request: "multipart/form-data", status: http.StatusCreated, response: model.Attachment{}},
+	{pattern: "GET /api/v1/attachments/{id}", summary: "Download an attachment", response: "application/octet-stream"},
+	{pattern: "DELETE /api/v1/attachments/{id}", summary: "Delete an attachment", status: http.StatusNoContent},
+
+	{pattern: "GET /api/v1/tags", summary: "List tags with usage counts", response: []model.TagUsage{}},
+	{pattern: "POST /api/v1/tags/{name}/rename", summary: "Rename a tag everywhere", request: model.RenameTagRequest{}, status: http.StatusNoContent},
+	{pattern: "POST /api/v1/tags/{name}/merge", summary: "Merge a tag into another", request: model.MergeTagRequest{}, status: http.StatusNoContent},
+	{pattern: "DELETE /api/v1/tags/{name}", summary: "Remove a tag from all notes and todos", status: http.StatusNoContent},
+
+	{pattern: "GET /api/v1/graph", summary: "Graph of wiki links between notes", query: []string{"tag"}, response: model.GraphResponse{}},
+
+	{pattern: "POST /api/v1/todos/bulk", summary: "Delete, complete, reopen, tag, untag or move many todos in one transaction", request: model.BulkRequest{}, response: model.BulkResponse{}},
+	{pattern: "GET /api/v1/todos/search", summary: "Search todos", query: []string{"q", "completed:boolean", "note_id", "orphaned:boolean", "tag", "due_after", "due_before", "sort", "limit:integer", "offset:integer"}, response: model.TodoListResponse{}},
+	{pattern: "GET /api/v1/todos/overdue", summary: "Todos past their due time, or due before today when all-day", query: []string{"tz"}, response: []model.Todo{}},
+	{pattern: "GET /api/v1/todos/summary", summary: "Completions per day, current streak and timeliness against due dates", query: []string{"days:integer", "tz"}, response: model.TodoSummary{}},
+	{pattern: "GET /api/v1/todos/today", summary: "Todos due today and overdue", query: []string{"tz"}, response: []model.Todo{}},
+	{pattern: "GET /api/v1/todos/week", summary: "Todos due in the next seven days and overdue", query: []string{"tz"}, response: []model.Todo{}},
+	{pattern: "GET /api/v1/todos/calendar", summary: "Todos grouped by due date", query: []string{"from", "to", "tz"}, response: model.CalendarResponse{}},
+	{pattern: "GET /api/v1/todos/calendar.ics", summary: "iCalendar feed of dated todos", auth: "feed token", query: []string{"token", "alarms:boolean"}, response: "text/calendar"},
+	{pattern: "GET /api/v1/todos/calendar/feed", summary: "Get the calendar feed status", response: model.CalendarFeed{}},
+	{pattern: "POST /api/v1/todos/calendar/feed", summary: "Create or rotate the calendar feed URL", status: http.StatusCreated, response: model.CalendarFeed{}},
+	{pattern: "DELETE /api/v1/todos/calendar/feed", summary: "Revoke the calendar feed URL", status: http.StatusNoContent},
+	{pattern: "GET /api/v1/notes/feed.atom", summary: "Atom feed of recently modified notes", auth: "feed token", query: []string{"token", "limit:integer"}, response: "application/atom+xml"},
+	{pattern: "GET /api/v1/notes/feed", summary: "List the note feeds", response: []model.NoteFeed{}},
+	{pattern: "POST /api/v1/notes/feed", summary: "Create or rotate a note feed URL", request: model.NoteFeedRequest{}, status: http.StatusCreated, response: model.NoteFeed{}},
+	{pattern: "DELETE /api/v1/notes/feed", summary: "Revoke a note feed URL", query: []string{"published:boolean"}, status: http.StatusNoContent},
+	{pattern: "GET /api/v1/mail-in", summary: "Get the mail-in address status", response: model.MailInAddress{}},
+	{pattern: "POST /api/v1/mail-in", summary: "Create or rotate the secret mail-in address", status: http.StatusCreated, response: model.MailInAddress{}},
+	{pattern: "DELETE /api/v1/mail-in", summary: "Revoke the mail-in address", status: http.StatusNoContent},
+	{pattern: "GET /api/v1/telegram", summary: "Get the linked Telegram chat", response: model.TelegramChat{}},
+	{pattern: "POST /api/v1/telegram/link", summary: "Create a one-time code to link a Telegram chat", status: http.StatusCreated, response: model.TelegramLink{}},
+	{pattern: "DELETE /api/v1/telegram", summary: "Unlink the Telegram chat", status: http.StatusNoContent},
+	{pattern: "GET /api/v1/todos/{id}", summary: "Get a todo", response: model.Todo{}},
+	{pattern: "GET /api/v1/todos", summary: "List todos", query: []string{"completed:boolean", "note_id", "orphaned:boolean", "tag", "due_after", "due_before", "sort", "limit:integer", "offset:integer"}, response: model.TodoListResponse{}},
+	{pattern: "POST /api/v1/todos", summary: "Create a todo", request: model.CreateTodoRequest{}, status: http.StatusCreated, response: model.Todo{}},
+	{pattern: "PUT /api/v1/todos/{id}", summary: "Update a todo", request: model.UpdateTodoRequest{}, response: model.Todo{}},
+	{pattern: "PATCH /api/v1/todos/{id}", summary: "Update a todo with a JSON merge patch (RFC 7386); null clears due_date, reminder_at, note_id and line_ref; checklist takes an array, or an object of item patches keyed by item id where null removes an item", request: model.Todo{}, response: model.Todo{}},
+	{pattern: "POST /api/v1/todos/{id}/move", summary: "Attach a todo to another note, or detach it", request: model.MoveTodoRequest{}, response: model.Todo{}},
+	{pattern: "DELETE /api/v1/todos/{id}", summary: "Delete a todo", status: http.StatusNoContent},
+
+	{pattern: "GET /api/v1/sync/changes", summary: "Pull changes since a time or cursor", query: []string{"since", "cursor", "limit:integer", "delta:boolean"}, response: model.SyncChangesResponse{}},
+	{pattern: "POST /api/v1/sync/push", summary: "Push local changes", request: model.SyncPushRequest{}, response: model.SyncPushResponse{}},
+	{pattern: "GET /api/v1/sync/manifest", summary: "List the id, modified_at and content_hash of every note and todo", response: model.SyncManifest{}},
+	{pattern: "POST /api/v1/sync/fetch", summary: "Fetch notes and todos by ID, tombstones included", request: model.SyncFetchRequest{}, response: model.SyncFetchResponse{}},
+	{pattern: "GET /api/v1/sync/conflicts", summary: "List unresolved sync conflicts", response: []model.ConflictRecord{}},
+	{pattern: "GET /api/v1/sync/ws", summary: "WebSocket of change events; the token may be passed as access_token", query: []string{"access_token"}, status: http.StatusSwitchingProtocols},
+	{pattern: "GET /api/v1/sync/events", summary: "Server-Sent Events stream of change events, resumable with Last-Event-ID", query: []string{"access_token", "last_event_id"}, response: "text/event-stream"},
+
+	{pattern: "GET /api/v1/export", summary: "Export everything as an archive", query: []string{"format"}, response: "application/zip"},
+	{pattern: "GET /api/v1/export/todos.csv", summary: "Export todos as CSV", response: "text/csv"},
+	{pattern: "POST /api/v1/import", summary: "Import a notesd export, ENEX file or zip of Markdown files", query: []string{"format"}, request: "application/octet-stream", response: model.ImportSummary{}},
+	{pattern: "POST /api/v1/import/todos.csv", summary: "Import todos from CSV", request: "text/csv", response: model.ImportResult{}},
+
+	{pattern: "GET /api/v1/journal/settings", summary: "Get the title format and template of journal entries", response: model.JournalSettings{}},
+	{pattern: "PUT /api/v1/journal/settings", summary: "Set the title format and template of journal entries", request: model.JournalSettings{}, response: model.JournalSettings{}},
+	{pattern: "GET /api/v1/journal/{date}", summary: "Get the journal note for a date (YYYY-MM-DD)", response: model.Note{}},
+	{pattern: "POST /api/v1/journal/{date}", summary: "Get or create the journal note for a date (YYYY-MM-DD)", request: model.JournalEntryRequest{}, status: http.StatusCreated, response: model.Note{}},
+	{pattern: "GET /api/v1/digest/settings", summary: "Get digest settings", response: model.DigestSettings{}},
+	{pattern: "PUT /api/v1/digest/settings", summary: "Set digest settings", request: model.DigestSettings{}, response: model.DigestSettings{}},
+
+	{pattern: "GET /api/v1/usage", summary: "Storage used by the account and the server's quotas", response: model.UsageReport{}},
+	{pattern: "GET /api/v1/stats", summary: "Note and todo counts, words written per week and most used tags", query: []string{"weeks:integer", "tags:integer"}, response: model.Statistics{}},
+	{pattern: "GET /api/v1/activity", summary: "The user's changes to notes and todos, newest first", query: []string{"limit:integer", "offset:integer"}, response: model.ActivityResponse{}},
+
+	{pattern: "GET /api/v1/account/settings", summary: "Get account settings", response: model.AccountSettings{}},
+	{pattern: "PUT /api/v1/account/settings", summary: "Set account settings such as the timezone", request: model.AccountSettings{}, response: model.AccountSettings{}},
+	{pattern: "GET /api/v1/account/export", summary: "Everything stored about the account as JSON", response: model.AccountExport{}},
+	{pattern: "DELETE /api/v1/account", summary: "Delete the account and all its data; needs the password", request: model.DeleteAccountRequest{}, status: http.StatusNoContent},
+
+	{pattern: "GET /api/v1/admin/overview", summary: "Usage overview", auth: "admin", query: []string{"days:integer"}, response: model.AdminOverview{}},
+	{pattern: "POST /api/v1/admin/backup", summary: "Write a database snapshot to the backup directory", auth: "admin", status: http.StatusCreated, response: model.Backup{}},
+	{pattern: "GET /api/v1/admin/maintenance", summary: "Report of the last database maintenance run", auth: "admin", response: model.Maintenance{}},
+	{pattern: "POST /api/v1/admin/maintenance", summary: "Checkpoint, vacuum, optimize and integrity-check the database now", auth: "admin", response: model.Maintenance{}},
+	{pattern: "GET /api/v1/admin/invites", summary: "List registration invites", auth: "admin", response: []model.Invite{}},
+	{pattern: "POST /api/v1/admin/invites", summary: "Create a registration invite code", auth: "admin", request: model.CreateInviteRequest{}, status: http.StatusCreated, response: model.Invite{}},
+	{pattern: "GET /api/v1/admin/audit", summary: "Query the audit log of note and todo changes, newest first", auth: "admin", query: []string{"user_id", "entity_type", "entity_id", "action", "device_id", "since", "until", "limit:integer", "offset:integer"}, response: model.AuditListResponse{}},
+}
+
+var timeType = reflect.TypeOf(time.Time{})
+
+// schemaBuilder derives JSON schemas from Go types, following encoding/json
+// field naming. Named structs become components referenced by name.
+type schemaBuilder struct {
+	components map[string]any
+}
+
+func (b *schemaBuilder) schema(t reflect.Type) map[string]any {
+	if t == timeType {
+		return map[string]any{"type": "string", "format": "date-time"}
+	}
+	switch t.Kind() {
+	case reflect.Pointer:
+		return b.schema(t.Elem())
+	case reflect.String:
+		return map[string]any{"type": "string"}
+	case reflect.Bool:
+		return map[string]any{"type": "boolean"}
+	case reflect.Int, reflect.Int8, reflect.Int16, reflect.Int32, reflect.Int64,
+		reflect.Uint, reflect.Uint8, reflect.Uint16, reflect.Uint32, reflect.Uint64:
+		return map[string]any{"type": "integer"}
+	case reflect.Float32, reflect.Float64:
+		return map[string]any{"type": "number"}
+	case reflect.Slice, reflect.Array:
+		if t.Elem().Kind() == reflect.Uint8 {
+			return map[string]any{"type": "string", "contentEncoding": "base64"}
+		}
+		return map[string]any{"type": "array", "items": b.schema(t.Elem())}
+	case reflect.Map:
+		return map[string]any{"type": "object", "additionalProperties": b.schema(t.Elem())}
+	case reflect.Struct:
+		if t.Name() == "" {
+			return b.object(t)
+		}
+		if _, ok := b.components[t.Name()]; !ok {
+			b.components[t.Name()] = nil // placeholder against recursion
+			b.components[t.Name()] = b.object(t)
+		}
+		return map[string]any{"$ref": "#/components/schemas/" + t.Name()}
+	}
+	return map[string]any{}
+}
+
+func (b *schemaBuilder) object(t reflect.Type) map[string]any {
+	props := map[string]any{}
+	var required []string
+	b.fields(t, props, &required)
+	s := map[string]any{"type": "object", "properties": props}
+	if len(required) > 0 {
+		s["required"] = required
+	}
+	return s
+}
+
+// fields adds the JSON properties of t, flattening embedded structs. Fields
+// that are neither pointers nor omitempty are always present and listed as
+// required.
+func (b *schemaBuilder) fields(t reflect.Type, props map[string]any, required *[]string) {
+	for i := range t.NumField() {
+		f := t.Field(i)
+		tag := f.Tag.Get("json")
+		if !f.IsExported() || tag == "-" {
+			continue
+		}
+		name, opts, _ := strings.Cut(tag, ",")
+		if f.Anonymous && name == "" && f.Type.Kind() == reflect.Struct {
+			b.fields(f.Type, props, required)
+			continue
+		}
+		if name == "" {
+			name = f.Name
+		}
+		props[name] = b.schema(f.Type)
+		if !strings.Contains(opts, "omitempty") && f.Type.Kind() != reflect.Pointer {
+			*required = append(*required, name)
+		}
+	}
+}
+
+// body returns the content map of a request or response body.
+func (b *schemaBuilder) body(v any) map[string]any {
+	if ct, ok := v.(string); ok {
+		return map[string]any{ct: map[string]any{}}
+	}
+	return map[string]any{"application/json": map[string]any{"schema": b.schema(reflect.TypeOf(v))}}
+}
+
+// buildOpenAPI assembles the OpenAPI 3.1 document from apiOperations and
+// the model types.
+func buildOpenAPI() map[string]any {
+	b := &schemaBuilder{components: map[string]any{}}
+	errorBody := b.body(model.ErrorResponse{})
+	paths := map[string]any{}
+
+	for _, op := range apiOperations {
+		method, path, _ := strings.Cut(op.pattern, " ")
+		item, _ := paths[path].(map[string]any)
+		if item == nil {
+			item = map[string]any{}
+			paths[path] = item
+		}
+
+		var params []any
+		for _, seg := range strings.Split(path, "/") {
+			if strings.HasPrefix(seg, "{") && strings.HasSuffix(seg, "}") {
+				params = append(params, map[string]any{
+					"name": seg[1 : len(seg)-1], "in": "path", "required": true,
+					"schema": map[string]any{"type": "string"},
+				})
+			}
+		}
+		for _, q := range op.query {
+			name, typ, ok := strings.Cut(q, ":")
+			if !ok {
+				typ = "string"
+			}
+			params = append(params, map[string]any{
+				"name": name, "in": "query", "schema": map[string]any{"type": typ},
+			})
+		}
+
+		status := op.status
+		if status == 0 {
+			status = http.StatusOK
+		}
+		success := map[string]any{"description": http.StatusText(status)}
+		if op.response != nil {
+			success["content"] = b.body(op.response)
+		}
+		responses := map[string]any{
+			strconv.Itoa(status): success,
+			"default":            map[string]any{"description": "Error", "content": errorBody},
+		}
+
+		o := map[string]any{
+			"summary":     op.summary,
+			"operationId": operationID(method, path),
+			"tags":        []string{operationTag(path)},
+			"responses":   responses,
+		}
+		if len(params) > 0 {
+			o["parameters"] = params
+		}
+		if op.request != nil {
+			o["requestBody"] = map[string]any{"required": true, "content": b.body(op.request)}
+		}
+		switch op.auth {
+		case "none", "feed token":
+			o["security"] = []any{}
+		case "admin":
+			o["description"] = "Only for accounts listed in admin.emails."
+		}
+		item[strings.ToLower(method)] = o
+	}
+
+	return map[string]any{
+		"openapi": "3.1.0",
+		"info": map[string]any{
+			"title":   "notesd API",
+			"version": version.Version,
+		},
+		"paths": paths,
+		"components": map[string]any{
+			"schemas": b.components,
+			"securitySchemes": map[string]any{
+				"bearerAuth": map[string]any{
+					"type": "http", "scheme": "bearer", "bearerFormat": "JWT",
+					"description": "A JWT access token from login, or a personal access token (notesd_pat_...)",
+				},
+			},
+		},
+		"security": []any{map[string]any{"bearerAuth": []string{}}},
+	}
+}
+
+// operationID turns "GET /api/v1/notes/{id}/html" into
+// "get_notes_id_html".
+func operationID(method, path string) string {
+	path = strings.TrimPrefix(path, "/api/v1")
+	r := strings.NewReplacer("/", "_", "{", "", "}", "", ".", "_", "-", "_")
+	return strings.ToLower(method) + r.Replace(path)
+}
+
+// operationTag groups operations by the first path segment after /api/v1.
+func operationTag(path string) string {
+	rest := strings.TrimPrefix(path, "/api/v1/")
+	if rest == path {
+		return "public"
+	}
+	tag, _, _ := strings.Cut(rest, "/")
+	return strings.TrimSuffix(tag, ".json")
+}
+
+var openAPIDocument = sync.OnceValue(func() []byte {
+	data, err := json.Marshal(buildOpenAPI())
+	if err != nil {
+		panic(err)
+	}
+	return data
+})
+
+func (a *API) handleOpenAPI(w http.ResponseWriter, r *http.Request) {
+	w.Header().Set("Content-Type", "application/json")
+	w.Write(openAPIDocument())
+}
+
+// swaggerUIPage loads Swagger UI from a CDN; the server only hosts this page.
+const swaggerUIPage = `<!DOCTYPE html>
+<html lang="en">
+<head>
+<meta charset="utf-8">
+<title>notesd API</title>
+<link rel="stylesheet" href="https://cdn.jsdelivr.net/npm/swagger-ui-dist@5/swagger-ui.css">
+</head>
+<body>
+<div id="swagger-ui"></div>
+<script src="https://cdn.jsdelivr.net/npm/swagger-ui-dist@5/swagger-ui-bundle.js"></script>
+<script>SwaggerUIBundle({url: "/api/v1/openapi.json", dom_id: "#swagger-ui"});</script>
+</body>
+</html>
+`
+
+func (a *API) handleAPIDocs(w http.ResponseWriter, r *http.Request) {
+	w.Header().Set("Content-Type", "text/html; charset=utf-8")
+	w.Write([]byte(swaggerUIPage))
+}
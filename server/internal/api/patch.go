@@ -0,0 +1,292 @@
+package api
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"log/slog"
+	"maps"
+	"mime"
+	"net/http"
+	"slices"
+	"strings"
+	"time"
+	"unicode/utf8"
+
+	"github.com/c0dev0id/notesd/server/internal/database"
+	"github.com/c0dev0id/notesd/server/internal/events"
+	"github.com/c0dev0id/notesd/server/internal/model"
+)
+
+// mergePatch is an RFC 7386 JSON merge patch of a flat entity: each member
+// replaces the field of that name, and null clears it. Fields are taken out
+// of the patch as they are applied; problems are collected in errs so that
+// one response can name all of them.
+type mergePatch struct {
+	fields map[string]json.RawMessage
+	errs   []string
+}
+
+// decodePatch reads a merge patch, writing a 4xx and returning false when
+// it is malformed. device_id may be given in the patch and otherwise comes
+// from the access token.
+func decodePatch(w http.ResponseWriter, r *http.Request) (*mergePatch, string, bool) {
+	if ct := r.Header.Get("Content-Type"); ct != "" {
+		mt, _, err := mime.ParseMediaType(ct)
+		if err != nil || mt != "application/merge-patch+json" && mt != "application/json" {
+			writeError(w, http.StatusUnsupportedMediaType, "Content-Type must be application/merge-patch+json")
+			return nil, "", false
+		}
+	}
+	p := &mergePatch{}
+	if err := decodeJSON(r, &p.fields); err != nil || p.fields == nil {
+		writeError(w, http.StatusBadRequest, "body must be a JSON object")
+		return nil, "", false
+	}
+
+	deviceID := deviceIDFrom(r.Context())
+	p.string("device_id", &deviceID, maxTitleLen)
+	if deviceID == "" && len(p.errs) == 0 {
+		writeError(w, http.StatusBadRequest, "device_id is required")
+		return nil, "", false
+	}
+	return p, deviceID, true
+}
+
+// take removes and returns the member name. present is false when the
+// patch does not mention it, null is true when it is JSON null.
+func (p *mergePatch) take(name string) (raw json.RawMessage, present, null bool) {
+	raw, present = p.fields[name]
+	delete(p.fields, name)
+	return raw, present, present && string(raw) == "null"
+}
+
+func (p *mergePatch) fail(name, msg string) {
+	p.errs = append(p.errs, name+": "+msg)
+}
+
+// string applies a non-nullable string of at most max characters.
+func (p *mergePatch) string(name string, dst *string, max int) {
+	raw, present, null := p.take(name)
+	if !present {
+		return
+	}
+	var s string
+	switch {
+	case null:
+		p.fail(name, "cannot be null")
+	case json.Unmarshal(raw, &s) != nil:
+		p.fail(name, "must be a string")
+	case utf8.RuneCountInString(s) > max:
+		p.fail(name, fmt.Sprintf("too long (max %d characters)", max))
+	default:
+		*dst = s
+	}
+}
+
+// optString applies a nullable string; null clears it.
+func (p *mergePatch) optString(name string, dst **string) {
+	raw, present, null := p.take(name)
+	if !present {
+		return
+	}
+	var s string
+	switch {
+	case null:
+		*dst = nil
+	case json.Unmarshal(raw, &s) != nil:
+		p.fail(name, "must be a string or null")
+	default:
+		*dst = &s
+	}
+}
+
+// optTime applies a nullable RFC 3339 time; null clears it.
+func (p *mergePatch) optTime(name string, dst **time.Time) {
+	raw, present, null := p.take(name)
+	if !present {
+		return
+	}
+	var t time.Time
+	switch {
+	case null:
+		*dst = nil
+	case json.Unmarshal(raw, &t) != nil:
+		p.fail(name, "must be an RFC 3339 time or null")
+	default:
+		*dst = &t
+	}
+}
+
+func (p *mergePatch) bool(name string, dst *bool) {
+	raw, present, null := p.take(name)
+	if !present {
+		return
+	}
+	var b bool
+	if null || json.Unmarshal(raw, &b) != nil {
+		p.fail(name, "must be true or false")
+		return
+	}
+	*dst = b
+}
+
+// tags replaces the tags as a whole, as merge patches do with arrays; null
+// removes them all.
+func (p *mergePatch) tags(dst *[]string) {
+	raw, present, null := p.take("tags")
+	if !present {
+		return
+	}
+	if null {
+		*dst = []string{}
+		return
+	}
+	var tags []string
+	if err := json.Unmarshal(raw, &tags); err != nil {
+		p.fail("tags", "must be an array of strings or null")
+		return
+	}
+	if tags == nil {
+		tags = []string{}
+	}
+	tags, err := normalizeTags(tags)
+	if err != nil {
+		p.fail("tags", err.Error())
+		return
+	}
+	*dst = tags
+}
+
+// err reports the collected problems, after rejecting the members that
+// were not applied: readOnly ones and unknown ones.
+func (p *mergePatch) err(readOnly ...string) error {
+	for _, name := range slices.Sorted(maps.Keys(p.fields)) {
+		if slices.Contains(readOnly, name) {
+			p.fail(name, "cannot be changed")
+		} else {
+			p.fail(name, "unknown field")
+		}
+	}
+	if len(p.errs) == 0 {
+		return nil
+	}
+	return errors.New(strings.Join(p.errs, "; "))
+}
+
+// handlePatchNote updates a note from a merge patch: title, content, type
+// and tags.
+func (a *API) handlePatchNote(w http.ResponseWriter, r *http.Request) {
+	userID := userIDFrom(r.Context())
+	id := r.PathValue("id")
+	p, deviceID, ok := decodePatch(w, r)
+	if !ok {
+		return
+	}
+
+	db := a.dbFor(r)
+	note, err := db.GetNote(id, userID)
+	if errors.Is(err, database.ErrNotFound) {
+		writeError(w, http.StatusNotFound, "note not found")
+		return
+	}
+	if err != nil {
+		slog.Error("get note for patch", "error", err)
+		writeError(w, http.StatusInternalServerError, "internal error")
+		return
+	}
+
+	oldBytes := noteBytes(note)
+	p.string("title", &note.Title, maxTitleLen)
+	p.string("content", &note.Content, maxContentLen)
+	if p.string("type", &note.Type, maxTitleLen); !a.validNoteType(note.Type) {
+		p.fail("type", a.noteTypeRule())
+	}
+	p.tags(&note.Tags)
+	if err := p.err("id", "user_id", "content_hash", "snoozed_until", "modified_at",
+		"modified_by_device", "deleted_at", "created_at",
+		"word_count", "reading_minutes", "first_heading", "link_count", "metadata"); err != nil {
+		writeError(w, http.StatusBadRequest, err.Error())
+		return
+	}
+	if !a.checkQuota(w, r, userID, usageDelta{contentBytes: noteBytes(note) - oldBytes}) {
+		return
+	}
+	note.ModifiedAt = model.NowMillis()
+	note.ModifiedByDevice = deviceID
+
+	if err := db.UpdateNote(note); err != nil {
+		slog.Error("patch note", "error", err)
+		writeError(w, http.StatusInternalServerError, "internal error")
+		return
+	}
+	a.bus.Publish(r.Context(), noteEvent(events.NoteUpdated, userID, note))
+
+	writeJSON(w, http.StatusOK, note)
+}
+
+// handlePatchTodo updates a todo from a merge patch: content, completed,
+// all_day, tags and checklist, and the nullable due_date, reminder_at,
+// note_id and line_ref. A due_date without all_day is all-day if it is
+// midnight UTC. See checklist for editing single checklist items.
+func (a *API) handlePatchTodo(w http.ResponseWriter, r *http.Request) {
+	userID := userIDFrom(r.Context())
+	id := r.PathValue("id")
+	p, deviceID, ok := decodePatch(w, r)
+	if !ok {
+		return
+	}
+
+	db := a.dbFor(r)
+	todo, err := db.GetTodo(id, userID)
+	if errors.Is(err, database.ErrNotFound) {
+		writeError(w, http.StatusNotFound, "todo not found")
+		return
+	}
+	if err != nil {
+		slog.Error("get todo for patch", "error", err)
+		writeError(w, http.StatusInternalServerError, "internal error")
+		return
+	}
+
+	prev := *todo
+	p.string("content", &todo.Content, maxTodoContentLen)
+	_, dueSet := p.fields["due_date"]
+	_, allDaySet := p.fields["all_day"]
+	p.optTime("due_date", &todo.DueDate)
+	allDay := todo.AllDay
+	p.bool("all_day", &allDay)
+	switch {
+	case allDaySet:
+		todo.SetDue(todo.DueDate, &allDay)
+	case dueSet:
+		todo.SetDue(todo.DueDate, nil)
+	}
+	p.optTime("reminder_at", &todo.ReminderAt)
+	p.bool("completed", &todo.Completed)
+	p.optString("note_id", &todo.NoteID)
+	p.optString("line_ref", &todo.LineRef)
+	p.tags(&todo.Tags)
+	checklist := todo.Checklist
+	p.checklist(&checklist)
+	todo.SetChecklist(checklist)
+	if err := p.err("id", "user_id", "progress", "modified_at", "modified_by_device", "deleted_at",
+		"created_at", "field_times"); err != nil {
+		writeError(w, http.StatusBadRequest, err.Error())
+		return
+	}
+	if !a.checkQuota(w, r, userID, usageDelta{contentBytes: todoBytes(todo) - todoBytes(&prev)}) {
+		return
+	}
+	todo.ModifiedAt = model.NowMillis()
+	todo.ModifiedByDevice = deviceID
+
+	if err := db.UpdateTodo(todo); err != nil {
+		slog.Error("patch todo", "error", err)
+		writeError(w, http.StatusInternalServerError, "internal error")
+		return
+	}
+	a.bus.Publish(r.Context(), todoEvent(todoKind(&prev, todo), userID, todo))
+
+	writeJSON(w, http.StatusOK, todo)
+}
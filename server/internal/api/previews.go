@@ -0,0 +1,112 @@
+package api
+
+import (
+	"net/http"
+	"strconv"
+	"sync"
+	"time"
+
+	"github.com/c0dev0id/notesd/server/internal/model"
+	"github.com/c0dev0id/notesd/server/internal/unfurl"
+)
+
+// maxCachedPreviews bounds the link previews kept in memory.
+const maxCachedPreviews = 10000
+
+// previewCache keeps link previews by the URL asked for until they are ttl
+// old. Previews of public pages are the same for everyone, so the cache is
+// shared between users. A nil cache keeps nothing.
+type previewCache struct {
+	mu      sync.Mutex
+	ttl     time.Duration
+	entries map[string]cachedPreview
+}
+
+type cachedPreview struct {
+	preview model.LinkPreview
+	expires time.Time
+}
+
+// newPreviewCache returns a cache keeping previews for ttl, or nil when ttl
+// is not positive.
+func newPreviewCache(ttl time.Duration) *previewCache {
+	if ttl <= 0 {
+		return nil
+	}
+	return &previewCache{ttl: ttl, entries: make(map[string]cachedPreview)}
+}
+
+// get returns the preview of rawURL if one is kept and has not expired.
+func (c *previewCache) get(rawURL string, now time.Time) (model.LinkPreview, bool) {
+	if c == nil {
+		return model.LinkPreview{}, false
+	}
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	e, ok := c.entries[rawURL]
+	if !ok || !now.Before(e.expires) {
+		return model.LinkPreview{}, false
+	}
+	return e.preview, true
+}
+
+// put keeps the preview of rawURL. When the cache is full, expired previews
+// are dropped first, then arbitrary ones.
+func (c *previewCache) put(rawURL string, p model.LinkPreview, now time.Time) {
+	if c == nil {
+		return
+	}
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	if len(c.entries) >= maxCachedPreviews {
+		for k, e := range c.entries {
+			if !now.Before(e.expires) {
+				delete(c.entries, k)
+			}
+		}
+		for k := range c.entries {
+			if len(c.entries) < maxCachedPreviews {
+				break
+			}
+			delete(c.entries, k)
+		}
+	}
+	c.entries[rawURL] = cachedPreview{preview: p, expires: now.Add(c.ttl)}
+}
+
+// handleUnfurl returns the preview of the web page at ?url=, so clients can
+// show links in notes without fetching other sites themselves. Previews
+// are cached for unfurl.cache_ttl.
+func (a *API) handleUnfurl(w http.ResponseWriter, r *http.Request) {
+	u, err := unfurl.ParseURL(r.URL.Query().Get("url"))
+	if err != nil {
+		writeError(w, http.StatusBadRequest, err.Error())
+		return
+	}
+	key := u.String()
+
+	p, ok := a.previews.get(key, time.Now())
+	if !ok {
+		page, err := a.pages.Fetch(r.Context(), key, false)
+		if writeFetchRefused(w, err) {
+			return
+		}
+		if err != nil {
+			writeError(w, http.StatusBadGateway, "fetch page: "+err.Error())
+			return
+		}
+		p = model.LinkPreview{
+			URL:         page.URL,
+			Title:       page.Title,
+			Description: page.Description,
+			Image:       page.Image,
+			Favicon:     page.Favicon,
+		}
+		a.previews.put(key, p, time.Now())
+	}
+
+	if a.previews != nil {
+		w.Header().Set("Cache-Control", "private, max-age="+strconv.Itoa(int(a.previews.ttl.Seconds())))
+	}
+	writeJSON(w, http.StatusOK, p)
+}
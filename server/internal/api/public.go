@@ -0,0 +1,167 @@
+package api
+
+import (
+	"crypto/rand"
+	"encoding/base64"
+	"errors"
+	"html/template"
+	"io"
+	"log/slog"
+	"net/http"
+	"strings"
+	"time"
+
+	"github.com/c0dev0id/notesd/server/internal/database"
+	"github.com/c0dev0id/notesd/server/internal/model"
+)
+
+// newSlug returns 128 random bits, URL-safe. Links are only as private as
+// their slug is unguessable.
+func newSlug() string {
+	b := make([]byte, 16)
+	if _, err := rand.Read(b); err != nil {
+		panic(err)
+	}
+	return base64.RawURLEncoding.EncodeToString(b)
+}
+
+func withURL(l model.PublicLink) model.PublicLink {
+	l.URL = "/p/" + l.Slug
+	return l
+}
+
+func (a *API) handlePublishNote(w http.ResponseWriter, r *http.Request) {
+	userID := userIDFrom(r.Context())
+	noteID := r.PathValue("id")
+
+	var req model.PublishNoteRequest
+	if err := decodeJSON(r, &req); err != nil && !errors.Is(err, io.EOF) {
+		writeError(w, http.StatusBadRequest, "invalid request body")
+		return
+	}
+	now := model.NowMillis()
+	if req.ExpiresAt != nil {
+		if !req.ExpiresAt.After(now) {
+			writeError(w, http.StatusBadRequest, "expires_at must be in the future")
+			return
+		}
+		exp := req.ExpiresAt.UTC().Truncate(time.Millisecond)
+		req.ExpiresAt = &exp
+	}
+	if !a.noteExists(w, r, noteID, userID) {
+		return
+	}
+
+	link := model.PublicLink{
+		Slug:      newSlug(),
+		NoteID:    noteID,
+		ExpiresAt: req.ExpiresAt,
+		CreatedAt: now,
+	}
+	if err := a.dbFor(r).CreatePublicLink(userID, &link); err != nil {
+		slog.Error("create public link", "error", err)
+		writeError(w, http.StatusInternalServerError, "internal error")
+		return
+	}
+
+	writeJSON(w, http.StatusCreated, withURL(link))
+}
+
+func (a *API) handleListPublicLinks(w http.ResponseWriter, r *http.Request) {
+	userID := userIDFrom(r.Context())
+	noteID := r.PathValue("id")
+
+	if !a.noteExists(w, r, noteID, userID) {
+		return
+	}
+	links, err := a.dbFor(r).ListPublicLinks(noteID, userID, model.NowMillis().UnixMilli())
+	if err != nil {
+		slog.Error("list public links", "error", err)
+		writeError(w, http.StatusInternalServerError, "internal error")
+		return
+	}
+	for i := range links {
+		links[i] = withURL(links[i])
+	}
+	if links == nil {
+		links = []model.PublicLink{}
+	}
+
+	writeJSON(w, http.StatusOK, links)
+}
+
+func (a *API) handleRevokePublicLink(w http.ResponseWriter, r *http.Request) {
+	userID := userIDFrom(r.Context())
+	slug := r.PathValue("slug")
+
+	err := a.dbFor(r).RevokePublicLink(slug, userID, model.NowMillis().UnixMilli())
+	if errors.Is(err, database.ErrNotFound) {
+		writeError(w, http.StatusNotFound, "link not found")
+		return
+	}
+	if err != nil {
+		slog.Error("revoke public link", "error", err)
+		writeError(w, http.StatusInternalServerError, "internal error")
+		return
+	}
+
+	w.WriteHeader(http.StatusNoContent)
+}
+
+var publicNotePage = template.Must(template.New("note").Parse(`<!DOCTYPE html>
+<html lang="en">
+<head>
+<meta charset="utf-8">
+<meta name="viewport" content="width=device-width, initial-scale=1">
+<meta name="robots" content="noindex">
+<title>{{.Title}}</title>
+<style>
+body { max-width: 42rem; margin: 2rem auto; padding: 0 1rem; font: 16px/1.5 system-ui, sans-serif; color: #222; }
+pre { white-space: pre-wrap; font: inherit; }
+footer { margin-top: 2rem; color: #888; font-size: 0.85em; }
+</style>
+</head>
+<body>
+<h1>{{.Title}}</h1>
+<pre>{{.Content}}</pre>
+<footer>Last updated {{.ModifiedAt.Format "2006-01-02 15:04 MST"}}</footer>
+</body>
+</html>
+`))
+
+// handlePublicNote serves a published note without auth: HTML to browsers
+// (or with ?format=html), JSON otherwise. Missing, revoked and expired
+// links are indistinguishable.
+func (a *API) handlePublicNote(w http.ResponseWriter, r *http.Request) {
+	note, err := a.dbFor(r).GetPublishedNote(r.PathValue("slug"), model.NowMillis().UnixMilli())
+	if errors.Is(err, database.ErrNotFound) {
+		writeError(w, http.StatusNotFound, "not found")
+		return
+	}
+	if err != nil {
+		slog.Error("get published note", "error", err)
+		writeError(w, http.StatusInternalServerError, "internal error")
+		return
+	}
+
+	pub := model.PublicNote{
+		Title:      note.Title,
+		Content:    note.Content,
+		Type:       note.Type,
+		ModifiedAt: note.ModifiedAt.UTC(),
+	}
+	w.Header().Set("Cache-Control", "no-cache")
+	w.Header().Set("X-Robots-Tag", "noindex")
+
+	html := r.URL.Query().Get("format") == "html" ||
+		(r.URL.Query().Get("format") == "" && strings.Contains(r.Header.Get("Accept"), "text/html"))
+	if !html {
+		writeJSON(w, http.StatusOK, pub)
+		return
+	}
+	w.Header().Set("Content-Type", "text/html; charset=utf-8")
+	w.Header().Set("Content-Security-Policy", "default-src 'none'; style-src 'unsafe-inline'")
+	if err := publicNotePage.Execute(w, pub); err != nil {
+		slog.Error("render public note", "error", err)
+	}
+}
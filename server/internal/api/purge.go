@@ -0,0 +1,90 @@
+package api
+
+import (
+	"context"
+	"errors"
+	"log/slog"
+	"net/http"
+	"time"
+
+	"github.com/c0dev0id/notesd/server/internal/database"
+	"github.com/c0dev0id/notesd/server/internal/events"
+	"github.com/c0dev0id/notesd/server/internal/model"
+)
+
+const tombstoneGCInterval = time.Hour
+
+// handlePurgeNote serves DELETE /notes/{id}?purge=true. Unlike a normal
+// delete it leaves no tombstone and also works on already deleted notes.
+func (a *API) handlePurgeNote(w http.ResponseWriter, r *http.Request) {
+	userID := userIDFrom(r.Context())
+	id := r.PathValue("id")
+	deviceID := deviceIDFrom(r.Context())
+
+	attachmentIDs, detached, err := a.dbFor(r).PurgeNote(id, userID, model.NowMillis(), deviceID)
+	if errors.Is(err, database.ErrNotFound) {
+		writeError(w, http.StatusNotFound, "note not found")
+		return
+	}
+	if err != nil {
+		slog.Error("purge note", "error", err)
+		writeError(w, http.StatusInternalServerError, "internal error")
+		return
+	}
+	a.removeBlobs(attachmentIDs)
+	a.bus.Publish(r.Context(), append([]events.Event{itemEvent(events.NotePurged, userID, id, deviceID)},
+		bulkEvents(events.TodoUpdated, userID, deviceID, detached)...)...)
+
+	w.WriteHeader(http.StatusNoContent)
+}
+
+func (a *API) removeBlobs(ids []string) {
+	for _, id := range ids {
+		if err := a.blobs.Remove(id); err != nil {
+			slog.Error("remove attachment file", "id", id, "error", err)
+		}
+	}
+}
+
+// RunTombstoneGC collects expired tombstones hourly until ctx is cancelled.
+// It returns immediately when tombstones are kept forever.
+func (a *API) RunTombstoneGC(ctx context.Context) {
+	if a.tombstoneRetention == 0 {
+		return
+	}
+	ticker := time.NewTicker(tombstoneGCInterval)
+	defer ticker.Stop()
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case now := <-ticker.C:
+			a.collectTombstones(now)
+		}
+	}
+}
+
+func (a *API) collectTombstones(now time.Time) {
+	res, err := a.db.CollectTombstones(now.Add(-a.tombstoneRetention), now)
+	if err != nil {
+		slog.Error("collect tombstones", "error", err)
+		return
+	}
+	a.removeBlobs(res.AttachmentIDs)
+	evs := make([]events.Event, 0, len(res.Purged)+len(res.Detached))
+	for _, p := range res.Purged {
+		kind := events.NotePurged
+		if p.Type == "todo" {
+			kind = events.TodoPurged
+		}
+		evs = append(evs, events.Event{Kind: kind, UserID: p.UserID, ID: p.ID, Bulk: true})
+	}
+	for _, d := range res.Detached {
+		evs = append(evs, events.Event{Kind: events.TodoUpdated, UserID: d.UserID, ID: d.ID, Bulk: true})
+	}
+	a.bus.Publish(context.Background(), evs...)
+	if res.Notes+res.Todos+res.Attachments+res.PurgeRecords > 0 {
+		slog.Info("tombstones collected", "notes", res.Notes, "todos", res.Todos,
+			"attachments", res.Attachments, "purge_records", res.PurgeRecords)
+	}
+}
@@ -0,0 +1,194 @@
+package api
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"slices"
+	"strconv"
+	"unicode/utf8"
+
+	"github.com/c0dev0id/notesd/server/internal/model"
+)
+
+const (
+	// pushChunk is the number of pushed items checked against the quota
+	// and stored together while the rest of the body is still being read.
+	pushChunk = 100
+	// defaultMaxPushSize limits a sync push body unless
+	// sync.max_push_size is set.
+	defaultMaxPushSize = 64 << 20
+)
+
+// invalidPush is a pushed item that fails validation. Its text is meant for
+// the client.
+type invalidPush string
+
+func (e invalidPush) Error() string { return string(e) }
+
+// readPush decodes a sync push body from r one item at a time, validates
+// each and hands them to apply in chunks of up to pushChunk, so that a
+// large first sync is never held in memory as a whole. Todos that precede
+// the notes array are held back until the notes are applied, as they may
+// refer to them. Notes must have one of noteTypes.
+func readPush(r io.Reader, noteTypes []string, apply func(*model.SyncPushRequest) error) error {
+	dec := json.NewDecoder(r)
+	dec.DisallowUnknownFields()
+	if err := expectDelim(dec, '{'); err != nil {
+		return err
+	}
+
+	var notes []model.Note
+	var todos []model.Todo
+	notesDone := false
+	flushNotes := func() error {
+		if len(notes) == 0 {
+			return nil
+		}
+		err := apply(&model.SyncPushRequest{Notes: notes})
+		notes = nil
+		return err
+	}
+	flushTodos := func() error {
+		for len(todos) > 0 {
+			n := min(len(todos), pushChunk)
+			if err := apply(&model.SyncPushRequest{Todos: todos[:n]}); err != nil {
+				return err
+			}
+			todos = todos[n:]
+		}
+		return nil
+	}
+
+	for dec.More() {
+		key, err := dec.Token()
+		if err != nil {
+			return err
+		}
+		switch key {
+		case "notes":
+			err = readItems(dec, func() error {
+				var n model.Note
+				if err := dec.Decode(&n); err != nil {
+					return err
+				}
+				if err := checkPushNote(&n, noteTypes); err != nil {
+					return err
+				}
+				if notes = append(notes, n); len(notes) >= pushChunk {
+					return flushNotes()
+				}
+				return nil
+			})
+			if err == nil {
+				err = flushNotes()
+			}
+			notesDone = true
+		case "todos":
+			err = readItems(dec, func() error {
+				var t model.Todo
+				if err := dec.Decode(&t); err != nil {
+					return err
+				}
+				if err := checkPushTodo(&t); err != nil {
+					return err
+				}
+				if todos = append(todos, t); notesDone && len(todos) >= pushChunk {
+					return flushTodos()
+				}
+				return nil
+			})
+		case "device_id":
+			var deviceID string
+			err = dec.Decode(&deviceID)
+		default:
+			return fmt.Errorf("unknown field %v", key)
+		}
+		if err != nil {
+			return err
+		}
+	}
+	if err := expectDelim(dec, '}'); err != nil {
+		return err
+	}
+	return flushTodos()
+}
+
+// readItems calls each for every element of the JSON array (or null) next
+// in dec; each decodes the element.
+func readItems(dec *json.Decoder, each func() error) error {
+	tok, err := dec.Token()
+	if err != nil || tok == nil {
+		return err
+	}
+	if tok != json.Delim('[') {
+		return errors.New("expected an array")
+	}
+	for dec.More() {
+		if err := each(); err != nil {
+			return err
+		}
+	}
+	_, err = dec.Token()
+	return err
+}
+
+func expectDelim(dec *json.Decoder, d json.Delim) error {
+	tok, err := dec.Token()
+	if err != nil {
+		return err
+	}
+	if tok != d {
+		return fmt.Errorf("expected %v", d)
+	}
+	return nil
+}
+
+// maxPushIDLen bounds the client-chosen IDs of pushed items.
+const maxPushIDLen = 128
+
+// checkPushNote applies the limits of the note endpoints to a pushed note
+// and normalizes its tags.
+func checkPushNote(n *model.Note, noteTypes []string) error {
+	if n.ID == "" || len(n.ID) > maxPushIDLen {
+		return invalidPush("note " + strconv.Quote(n.ID) + ": invalid id")
+	}
+	if utf8.RuneCountInString(n.Title) > maxTitleLen {
+		return invalidPush("note " + n.ID + ": title too long")
+	}
+	if utf8.RuneCountInString(n.Content) > maxContentLen {
+		return invalidPush("note " + n.ID + ": content too long")
+	}
+	if !slices.Contains(noteTypes, n.Type) {
+		return invalidPush("note " + n.ID + ": unknown type " + strconv.Quote(n.Type))
+	}
+	tags, err := normalizeTags(n.Tags)
+	if err != nil {
+		return invalidPush("note " + n.ID + ": " + err.Error())
+	}
+	n.Tags = tags
+	return nil
+}
+
+// checkPushTodo applies the limits of the todo endpoints to a pushed todo
+// and normalizes its tags and checklist.
+func checkPushTodo(t *model.Todo) error {
+	if t.ID == "" || len(t.ID) > maxPushIDLen {
+		return invalidPush("todo " + strconv.Quote(t.ID) + ": invalid id")
+	}
+	if utf8.RuneCountInString(t.Content) > maxTodoContentLen {
+		return invalidPush("todo " + t.ID + ": content too long")
+	}
+	tags, err := normalizeTags(t.Tags)
+	if err != nil {
+		return invalidPush("todo " + t.ID + ": " + err.Error())
+	}
+	t.Tags = tags
+	checklist, err := normalizeChecklist(t.Checklist)
+	if err != nil {
+		return invalidPush("todo " + t.ID + ": " + err.Error())
+	}
+	t.SetChecklist(checklist)
+	return nil
+}
@@ -0,0 +1,143 @@
+package api
+
+import (
+	"errors"
+	"fmt"
+	"log/slog"
+	"net/http"
+
+	"github.com/c0dev0id/notesd/server/internal/config"
+	"github.com/c0dev0id/notesd/server/internal/database"
+	"github.com/c0dev0id/notesd/server/internal/model"
+)
+
+// usageDelta is how much a write changes the user's usage.
+type usageDelta struct {
+	notes, todos, contentBytes, attachmentBytes int64
+}
+
+func noteBytes(n *model.Note) int64 { return int64(len(n.Title) + len(n.Content)) }
+
+func todoBytes(t *model.Todo) int64 {
+	n := len(t.Content)
+	for _, it := range t.Checklist {
+		n += len(it.Text)
+	}
+	return int64(n)
+}
+
+func (a *API) quotasEnabled() bool {
+	return a.config.Quota != config.QuotaConfig{}
+}
+
+func (a *API) quotaLimits() model.Usage {
+	q := a.config.Quota
+	return model.Usage{
+		Notes:           q.Notes,
+		Todos:           q.Todos,
+		ContentBytes:    q.ContentBytes,
+		AttachmentBytes: q.AttachmentBytes,
+	}
+}
+
+// checkQuota answers 403 and returns false when d would take the user past
+// a limit. Only growth is checked, so someone above a lowered limit can
+// still edit, shrink and delete.
+func (a *API) checkQuota(w http.ResponseWriter, r *http.Request, userID string, d usageDelta) bool {
+	msg, err := a.quotaExceeded(a.dbFor(r), userID, d)
+	if err != nil {
+		slog.Error("get usage for quota", "error", err)
+		writeError(w, http.StatusInternalServerError, "internal error")
+		return false
+	}
+	if msg != "" {
+		writeError(w, http.StatusForbidden, msg)
+		return false
+	}
+	return true
+}
+
+// quotaExceeded is checkQuota for callers without a JSON response: it
+// returns why d is refused, or "" if it is not.
+func (a *API) quotaExceeded(db *database.DB, userID string, d usageDelta) (string, error) {
+	if !a.quotasEnabled() {
+		return "", nil
+	}
+	u, err := db.GetUsage(userID)
+	if err != nil {
+		return "", err
+	}
+	limits := a.quotaLimits()
+	for _, c := range []struct {
+		what               string
+		used, delta, limit int64
+	}{
+		{"notes", u.Notes, d.notes, limits.Notes},
+		{"todos", u.Todos, d.todos, limits.Todos},
+		{"content bytes", u.ContentBytes, d.contentBytes, limits.ContentBytes},
+		{"attachment bytes", u.AttachmentBytes, d.attachmentBytes, limits.AttachmentBytes},
+	} {
+		if c.limit > 0 && c.delta > 0 && c.used+c.delta > c.limit {
+			return fmt.Sprintf("quota exceeded: %d %s allowed", c.limit, c.what), nil
+		}
+	}
+	return "", nil
+}
+
+// syncPushDelta works out how a push changes usage if every item is
+// accepted. Items that lose to the server's version make it an
+// overestimate.
+func (a *API) syncPushDelta(db *database.DB, userID string, req *model.SyncPushRequest) (usageDelta, error) {
+	var d usageDelta
+	for i := range req.Notes {
+		n := req.Notes[i]
+		n.UserID = userID
+		if n.BaseHash != "" {
+			ok, err := a.applyNotePatch(db, &n)
+			if err != nil {
+				return d, err
+			}
+			if !ok {
+				continue // answered with need_full, not stored
+			}
+		}
+		old, err := db.GetNoteAny(n.ID, userID)
+		if err != nil && !errors.Is(err, database.ErrNotFound) {
+			return d, err
+		}
+		if old != nil && old.DeletedAt == nil {
+			d.notes--
+			d.contentBytes -= noteBytes(old)
+		}
+		if n.DeletedAt == nil {
+			d.notes++
+			d.contentBytes += noteBytes(&n)
+		}
+	}
+	for i := range req.Todos {
+		t := &req.Todos[i]
+		old, err := db.GetTodoAny(t.ID, userID)
+		if err != nil && !errors.Is(err, database.ErrNotFound) {
+			return d, err
+		}
+		if old != nil && old.DeletedAt == nil {
+			d.todos--
+			d.contentBytes -= todoBytes(old)
+		}
+		if t.DeletedAt == nil {
+			d.todos++
+			d.contentBytes += todoBytes(t)
+		}
+	}
+	return d, nil
+}
+
+func (a *API) handleGetUsage(w http.ResponseWriter, r *http.Request) {
+	u, err := a.dbFor(r).GetUsage(userIDFrom(r.Context()))
+	if err != nil {
+		slog.Error("get usage", "error", err)
+		writeError(w, http.StatusInternalServerError, "internal error")
+		return
+	}
+	writeJSON(w, http.StatusOK, model.UsageReport{Used: *u, Limits: a.quotaLimits()})
+}
@@ -1,69 +1,188 @@
 package api
 
 import (
+	"context"
+	"fmt"
+	"log/slog"
 	"net/http"
+	"strconv"
 	"sync"
 	"time"
+
+	"github.com/c0dev0id/notesd/server/internal/pubsub"
 )
 
-// rateLimiter implements a simple fixed-window rate limiter keyed by IP.
+// rateLimiter implements a simple fixed-window rate limiter. A nil limiter
+// allows everything, so a limit of 0 in the config disables it.
 type rateLimiter struct {
-	mu      sync.Mutex
-	windows map[string]*window
-	limit   int
-	period  time.Duration
+	store  limitStore
+	limit  int
+	period time.Duration
 }
 
-type window struct {
-	count   int
-	resetAt time.Time
+// limitStore keeps the request counts of a rateLimiter.
+type limitStore interface {
+	// hit counts a request for key and returns the requests counted in
+	// key's current window, which starts now if the last one has ended,
+	// and when that window ends.
+	hit(key string, period time.Duration, now time.Time) (count int, resetAt time.Time, err error)
+	// cleanup forgets the windows that have ended.
+	cleanup(now time.Time)
 }
 
+// newRateLimiter returns a limiter allowing limit requests per period and
+// key, counted in memory, or nil when limit is not positive.
 func newRateLimiter(limit int, period time.Duration) *rateLimiter {
+	if limit <= 0 || period <= 0 {
+		return nil
+	}
 	return &rateLimiter{
-		windows: make(map[string]*window),
-		limit:   limit,
-		period:  period,
+		store:  &memoryLimits{windows: make(map[string]*window)},
+		limit:  limit,
+		period: period,
 	}
 }
 
-// allow checks if a request from the given key is allowed.
-func (rl *rateLimiter) allow(key string) bool {
-	rl.mu.Lock()
-	defer rl.mu.Unlock()
+// sharedIn makes rl count in Redis under name instead of in memory, so
+// every server using it enforces one limit together.
+func (rl *rateLimiter) sharedIn(r *pubsub.Redis, name string) *rateLimiter {
+	if rl != nil {
+		rl.store = redisLimits{redis: r, prefix: "notesd:ratelimit:" + name + ":"}
+	}
+	return rl
+}
 
+// allow checks if a request from the given key is allowed. When it is not,
+// it also returns how long until the key's window resets. Requests are let
+// through when the counts cannot be reached.
+func (rl *rateLimiter) allow(key string) (bool, time.Duration) {
+	if rl == nil {
+		return true, 0
+	}
 	now := time.Now()
-	w, ok := rl.windows[key]
-	if !ok || now.After(w.resetAt) {
-		rl.windows[key] = &window{count: 1, resetAt: now.Add(rl.period)}
-		return true
+	count, resetAt, err := rl.store.hit(key, rl.period, now)
+	if err != nil {
+		slog.Error("rate limit", "error", err)
+		return true, 0
 	}
-
-	w.count++
-	return w.count <= rl.limit
+	if count <= rl.limit {
+		return true, 0
+	}
+	return false, resetAt.Sub(now)
 }
 
 // cleanup removes expired entries. Called periodically.
 func (rl *rateLimiter) cleanup() {
-	rl.mu.Lock()
-	defer rl.mu.Unlock()
+	if rl == nil {
+		return
+	}
+	rl.store.cleanup(time.Now())
+}
 
-	now := time.Now()
-	for k, w := range rl.windows {
+// memoryLimits counts requests in this process only.
+type memoryLimits struct {
+	mu      sync.Mutex
+	windows map[string]*window
+}
+
+type window struct {
+	count   int
+	resetAt time.Time
+}
+
+func (m *memoryLimits) hit(key string, period time.Duration, now time.Time) (int, time.Time, error) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	w, ok := m.windows[key]
+	if !ok || now.After(w.resetAt) {
+		w = &window{resetAt: now.Add(period)}
+		m.windows[key] = w
+	}
+	w.count++
+	return w.count, w.resetAt, nil
+}
+
+func (m *memoryLimits) cleanup(now time.Time) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	for k, w := range m.windows {
 		if now.After(w.resetAt) {
-			delete(rl.windows, k)
+			delete(m.windows, k)
 		}
 	}
 }
 
-// rateLimit wraps a handler with rate limiting.
+// hitScript counts a request in a key that expires when its window ends
+// and returns the count and the milliseconds left in the window.
+const hitScript = `
+local n = redis.call('INCR', KEYS[1])
+if n == 1 then redis.call('PEXPIRE', KEYS[1], ARGV[1]) end
+return {n, redis.call('PTTL', KEYS[1])}
+`
+
+// redisLimits counts requests in Redis, shared by the servers using it.
+// Keys are prefixed with the limiter's name and expire with their
+// window, so there is nothing to clean up.
+type redisLimits struct {
+	redis  *pubsub.Redis
+	prefix string
+}
+
+func (l redisLimits) hit(key string, period time.Duration, now time.Time) (int, time.Time, error) {
+	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancel()
+	reply, err := l.redis.Do(ctx, "EVAL", hitScript, "1", l.prefix+key, strconv.FormatInt(period.Milliseconds(), 10))
+	if err != nil {
+		return 0, time.Time{}, fmt.Errorf("count rate limit: %w", err)
+	}
+	a, _ := reply.([]any)
+	if len(a) != 2 {
+		return 0, time.Time{}, fmt.Errorf("count rate limit: unexpected reply %v", reply)
+	}
+	count, _ := a[0].(int64)
+	ttl, _ := a[1].(int64)
+	if ttl < 0 {
+		ttl = period.Milliseconds()
+	}
+	return int(count), now.Add(time.Duration(ttl) * time.Millisecond), nil
+}
+
+func (redisLimits) cleanup(time.Time) {}
+
+// rateLimit wraps a handler with rate limiting keyed by client IP.
 func (rl *rateLimiter) rateLimit(next http.HandlerFunc) http.HandlerFunc {
+	if rl == nil {
+		return next
+	}
 	return func(w http.ResponseWriter, r *http.Request) {
 		key := r.RemoteAddr
-		if !rl.allow(key) {
-			writeError(w, http.StatusTooManyRequests, "rate limit exceeded")
+		if info := requestInfoFrom(r.Context()); info != nil {
+			key = info.clientIP
+		}
+		if ok, retry := rl.allow(key); !ok {
+			tooManyRequests(w, retry)
 			return
 		}
 		next(w, r)
 	}
 }
+
+// tooManyRequests answers 429 with a Retry-After header in whole seconds,
+// rounded up.
+func tooManyRequests(w http.ResponseWriter, retry time.Duration) {
+	secs := int((retry + time.Second - 1) / time.Second)
+	w.Header().Set("Retry-After", strconv.Itoa(max(secs, 1)))
+	writeError(w, http.StatusTooManyRequests, "rate limit exceeded")
+}
+
+// isWrite reports whether a request changes data and counts against the
+// per-user write limit. PROPFIND is WebDAV's directory listing.
+func isWrite(r *http.Request) bool {
+	switch r.Method {
+	case http.MethodGet, http.MethodHead, http.MethodOptions, "PROPFIND":
+		return false
+	}
+	return true
+}
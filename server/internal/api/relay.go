@@ -0,0 +1,106 @@
+package api
+
+import (
+	"context"
+	"encoding/json"
+	"log/slog"
+	"time"
+
+	"github.com/c0dev0id/notesd/server/internal/model"
+)
+
+const (
+	// relayChannel is the broker channel carrying live sync events.
+	relayChannel = "notesd:changes"
+	// relayQueueSize is how many events may wait to be published. More
+	// are dropped rather than slowing down writes.
+	relayQueueSize  = 1024
+	relayRetryDelay = 5 * time.Second
+)
+
+// relayMessage is a change event passed to the other servers. Origin is
+// the sending server's hub epoch, so a server skips its own events, which
+// its connections already have.
+type relayMessage struct {
+	Origin string            `json:"origin"`
+	UserID string            `json:"user_id"`
+	Event  model.ChangeEvent `json:"event"`
+}
+
+// relay queues ce for the other servers when a broker is configured.
+func (a *API) relay(userID string, ce model.ChangeEvent) {
+	if a.broker == nil {
+		return
+	}
+	data, err := json.Marshal(relayMessage{Origin: a.hub.epoch, UserID: userID, Event: ce})
+	if err != nil {
+		slog.Error("marshal relayed event", "error", err)
+		return
+	}
+	select {
+	case a.relayQueue <- data:
+	default:
+		slog.Warn("live sync relay queue full, dropping event", "user_id", userID)
+	}
+}
+
+// RunRelay passes live sync events between this server and the others on
+// the broker until ctx is done. When the subscription has to be renewed,
+// events may have been missed, so every connected client is told to
+// resync.
+func (a *API) RunRelay(ctx context.Context) {
+	if a.broker == nil {
+		return
+	}
+	go a.publishRelayed(ctx)
+	for resync := false; ctx.Err() == nil; resync = true {
+		err := a.receiveRelayed(ctx, resync)
+		if ctx.Err() != nil {
+			return
+		}
+		slog.Error("live sync relay", "error", err)
+		select {
+		case <-ctx.Done():
+		case <-time.After(relayRetryDelay):
+		}
+	}
+}
+
+// publishRelayed sends queued events to the broker.
+func (a *API) publishRelayed(ctx context.Context) {
+	defer a.broker.Close()
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case data := <-a.relayQueue:
+			if err := a.broker.Publish(ctx, relayChannel, data); err != nil {
+				slog.Error("publish live sync event", "error", err)
+			}
+		}
+	}
+}
+
+// receiveRelayed subscribes to the broker and hands the other servers'
+// events to the local connections until the subscription ends.
+func (a *API) receiveRelayed(ctx context.Context, resync bool) error {
+	sub, err := a.broker.Subscribe(ctx, relayChannel)
+	if err != nil {
+		return err
+	}
+	defer sub.Close()
+	if resync {
+		a.hub.resyncAll()
+	}
+	for data := range sub.C {
+		var m relayMessage
+		if err := json.Unmarshal(data, &m); err != nil {
+			slog.Warn("invalid relayed event", "error", err)
+			continue
+		}
+		if m.Origin != a.hub.epoch {
+			a.hub.publish(m.UserID, m.Event)
+		}
+	}
+	return sub.Err()
+}
@@ -0,0 +1,300 @@
+package api
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"log/slog"
+	"net/http"
+	"net/url"
+	"sync"
+	"time"
+
+	"github.com/c0dev0id/notesd/server/internal/database"
+	"github.com/c0dev0id/notesd/server/internal/events"
+	"github.com/c0dev0id/notesd/server/internal/model"
+	"github.com/c0dev0id/notesd/server/internal/netguard"
+	"github.com/c0dev0id/notesd/server/internal/push"
+)
+
+const (
+	reminderCheckInterval = 30 * time.Second
+	// reminderMaxDelay bounds how late a reminder may still fire, so a
+	// server that was down for a week does not replay a week of them.
+	reminderMaxDelay = 24 * time.Hour
+)
+
+// pushSender delivers to Web Push subscriptions; *push.WebPush in
+// production.
+type pushSender interface {
+	Send(sub push.Subscription, payload []byte) error
+	PublicKey() string
+}
+
+// webhookSender posts to user webhooks; *push.Webhook in production.
+type webhookSender interface {
+	Send(url string, payload []byte) error
+}
+
+func (a *API) handleVAPIDKey(w http.ResponseWriter, r *http.Request) {
+	if a.webPush == nil {
+		writeError(w, http.StatusNotFound, "web push is not configured on this server")
+		return
+	}
+	writeJSON(w, http.StatusOK, map[string]string{"public_key": a.webPush.PublicKey()})
+}
+
+func (a *API) handleRegisterPushSubscription(w http.ResponseWriter, r *http.Request) {
+	userID := userIDFrom(r.Context())
+
+	var req model.PushSubscriptionRequest
+	if err := decodeJSON(r, &req); err != nil {
+		writeError(w, http.StatusBadRequest, "invalid request body")
+		return
+	}
+	if a.webPush == nil {
+		writeError(w, http.StatusBadRequest, "web push is not configured on this server")
+		return
+	}
+	if req.DeviceID == "" {
+		writeError(w, http.StatusBadRequest, "device_id is required")
+		return
+	}
+	u, err := url.Parse(req.Endpoint)
+	if err != nil || u.Scheme != "https" || u.Host == "" {
+		writeError(w, http.StatusBadRequest, "endpoint must be an https URL")
+		return
+	}
+	if !netguard.PublicHost(u) {
+		writeError(w, http.StatusBadRequest, "endpoint must lead to a public address")
+		return
+	}
+	if req.Keys.P256dh == "" || req.Keys.Auth == "" {
+		writeError(w, http.StatusBadRequest, "keys.p256dh and keys.auth are required")
+		return
+	}
+
+	sub := &model.PushSubscription{
+		ID:        model.NewID(),
+		UserID:    userID,
+		DeviceID:  req.DeviceID,
+		Endpoint:  req.Endpoint,
+		Keys:      req.Keys,
+		CreatedAt: model.NowMillis(),
+	}
+	if err := a.dbFor(r).SavePushSubscription(sub); err != nil {
+		slog.Error("save push subscription", "error", err)
+		writeError(w, http.StatusInternalServerError, "internal error")
+		return
+	}
+
+	writeJSON(w, http.StatusCreated, sub)
+}
+
+func (a *API) handleListPushSubscriptions(w http.ResponseWriter, r *http.Request) {
+	userID := userIDFrom(r.Context())
+
+	subs, err := a.dbFor(r).ListPushSubscriptions(userID)
+	if err != nil {
+		slog.Error("list push subscriptions", "error", err)
+		writeError(w, http.StatusInternalServerError, "internal error")
+		return
+	}
+	if subs == nil {
+		subs = []model.PushSubscription{}
+	}
+
+	writeJSON(w, http.StatusOK, subs)
+}
+
+func (a *API) handleDeletePushSubscription(w http.ResponseWriter, r *http.Request) {
+	userID := userIDFrom(r.Context())
+
+	err := a.dbFor(r).DeletePushSubscription(r.PathValue("id"), userID)
+	if errors.Is(err, database.ErrNotFound) {
+		writeError(w, http.StatusNotFound, "subscription not found")
+		return
+	}
+	if err != nil {
+		slog.Error("delete push subscription", "error", err)
+		writeError(w, http.StatusInternalServerError, "internal error")
+		return
+	}
+
+	w.WriteHeader(http.StatusNoContent)
+}
+
+func (a *API) handleGetReminderWebhook(w http.ResponseWriter, r *http.Request) {
+	userID := userIDFrom(r.Context())
+
+	u, err := a.dbFor(r).GetReminderWebhook(userID)
+	if err != nil {
+		slog.Error("get reminder webhook", "error", err)
+		writeError(w, http.StatusInternalServerError, "internal error")
+		return
+	}
+
+	writeJSON(w, http.StatusOK, model.ReminderWebhook{URL: u})
+}
+
+// handleSetReminderWebhook sets the webhook URL; an empty url removes it.
+func (a *API) handleSetReminderWebhook(w http.ResponseWriter, r *http.Request) {
+	userID := userIDFrom(r.Context())
+
+	var req model.ReminderWebhook
+	if err := decodeJSON(r, &req); err != nil {
+		writeError(w, http.StatusBadRequest, "invalid request body")
+		return
+	}
+	if req.URL != "" {
+		u, err := url.Parse(req.URL)
+		if err != nil || (u.Scheme != "http" && u.Scheme != "https") || u.Host == "" {
+			writeError(w, http.StatusBadRequest, "url must be an http or https URL")
+			return
+		}
+		if !netguard.PublicHost(u) {
+			writeError(w, http.StatusBadRequest, "url must lead to a public address")
+			return
+		}
+	}
+
+	if err := a.dbFor(r).SetReminderWebhook(userID, req.URL, model.NowMillis()); err != nil {
+		slog.Error("set reminder webhook", "error", err)
+		writeError(w, http.StatusInternalServerError, "internal error")
+		return
+	}
+
+	writeJSON(w, http.StatusOK, req)
+}
+
+// reminderAlarm wakes the reminder worker for a reminder due before its
+// next regular check.
+type reminderAlarm struct {
+	mu    sync.Mutex
+	next  time.Time
+	timer *time.Timer
+	wake  chan struct{}
+}
+
+// set arms the alarm for at unless it is already armed earlier.
+func (al *reminderAlarm) set(at, now time.Time) {
+	al.mu.Lock()
+	defer al.mu.Unlock()
+	if !al.next.IsZero() && !at.Before(al.next) {
+		return
+	}
+	al.next = at
+	if al.timer == nil {
+		al.timer = time.AfterFunc(at.Sub(now), al.ring)
+	} else {
+		al.timer.Reset(at.Sub(now))
+	}
+}
+
+func (al *reminderAlarm) ring() {
+	al.mu.Lock()
+	al.next = time.Time{}
+	al.mu.Unlock()
+	select {
+	case al.wake <- struct{}{}:
+	default:
+	}
+}
+
+// scheduleReminders is the bus subscriber that sets the reminder alarm for
+// reminders and snoozes ending before the next regular check, so they do
+// not fire up to reminderCheckInterval late. The regular check still
+// catches anything the alarm misses.
+func (a *API) scheduleReminders(_ context.Context, evs []events.Event) {
+	now := time.Now()
+	for _, ev := range evs {
+		var at *time.Time
+		switch {
+		case ev.Todo != nil && ev.Todo.DeletedAt == nil && !ev.Todo.Completed:
+			at = ev.Todo.ReminderAt
+		case ev.Note != nil && ev.Note.DeletedAt == nil:
+			at = ev.Note.SnoozedUntil
+		}
+		if at != nil && at.After(now) && at.Sub(now) < reminderCheckInterval {
+			a.reminderAlarm.set(*at, now)
+		}
+	}
+}
+
+// RunReminders delivers due reminders every 30 seconds, and when the
+// reminder alarm rings, until ctx is cancelled.
+func (a *API) RunReminders(ctx context.Context) {
+	ticker := time.NewTicker(reminderCheckInterval)
+	defer ticker.Stop()
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case <-a.reminderAlarm.wake:
+			a.sendDueReminders(time.Now())
+		case now := <-ticker.C:
+			a.sendDueReminders(now)
+		}
+	}
+}
+
+// sendDueReminders delivers each due reminder to all of the user's
+// channels and marks it sent. A failed delivery is logged, not retried, so
+// one broken endpoint cannot cause a reminder storm.
+func (a *API) sendDueReminders(now time.Time) {
+	due, err := a.db.ListDueReminders(now.Add(-reminderMaxDelay), now)
+	if err != nil {
+		slog.Error("list due reminders", "error", err)
+		return
+	}
+
+	for _, r := range due {
+		a.deliverReminder(r)
+		if err := a.db.MarkReminderSent(r, now); err != nil {
+			slog.Error("mark reminder sent", "id", r.ID, "error", err)
+		}
+	}
+}
+
+func (a *API) deliverReminder(r database.DueReminder) {
+	payload, err := json.Marshal(model.Reminder{
+		Type: r.Type, ID: r.ID, Title: r.Title, FireAt: r.FireAt.UTC(),
+	})
+	if err != nil {
+		slog.Error("encode reminder", "error", err)
+		return
+	}
+
+	if a.webPush != nil {
+		subs, err := a.db.ListPushSubscriptions(r.UserID)
+		if err != nil {
+			slog.Error("list push subscriptions", "user_id", r.UserID, "error", err)
+		}
+		for _, s := range subs {
+			err := a.webPush.Send(push.Subscription{
+				Endpoint: s.Endpoint, P256dh: s.Keys.P256dh, Auth: s.Keys.Auth,
+			}, payload)
+			if errors.Is(err, push.ErrGone) {
+				slog.Info("push subscription expired", "user_id", r.UserID, "device_id", s.DeviceID)
+				a.db.DeletePushSubscription(s.ID, r.UserID)
+			} else if err != nil {
+				slog.Error("web push", "user_id", r.UserID, "device_id", s.DeviceID, "error", err)
+			}
+		}
+	}
+
+	if a.telegram != nil {
+		a.sendTelegramReminder(r)
+	}
+
+	hook, err := a.db.GetReminderWebhook(r.UserID)
+	if err != nil {
+		slog.Error("get reminder webhook", "user_id", r.UserID, "error", err)
+		return
+	}
+	if hook != "" {
+		if err := a.webhooks.Send(hook, payload); err != nil {
+			slog.Error("reminder webhook", "user_id", r.UserID, "error", err)
+		}
+	}
+}
@@ -0,0 +1,82 @@
+package api
+
+import (
+	"bytes"
+	"errors"
+	"log/slog"
+	"net/http"
+	"strings"
+
+	"github.com/c0dev0id/notesd/server/internal/database"
+	"github.com/yuin/goldmark"
+	"github.com/yuin/goldmark/extension"
+)
+
+// markdown renders note content as GitHub-flavoured markdown. Raw HTML is
+// dropped and links with unsafe schemes such as javascript: are emptied,
+// so the output can be embedded as is.
+var markdown = goldmark.New(goldmark.WithExtensions(extension.GFM))
+
+// linkEscaper escapes the characters that would end a markdown link label.
+var linkEscaper = strings.NewReplacer(`\`, `\\`, `[`, `\[`, `]`, `\]`)
+
+// linkWikiLinks turns [[wiki links]] into markdown links to the rendered
+// target note. ids maps title keys to note IDs as returned by
+// database.ResolveTitles; links to unknown titles become plain text.
+func linkWikiLinks(content string, ids map[string]string) string {
+	return database.ReplaceWikiLinks(content, func(target, label string) string {
+		if label == "" {
+			label = target
+		}
+		label = linkEscaper.Replace(label)
+		id, ok := ids[database.TitleKey(target)]
+		if !ok {
+			return label
+		}
+		return "[" + label + "](/api/v1/notes/" + id + "/html)"
+	})
+}
+
+func renderMarkdown(src string) ([]byte, error) {
+	var buf bytes.Buffer
+	if err := markdown.Convert([]byte(src), &buf); err != nil {
+		return nil, err
+	}
+	return buf.Bytes(), nil
+}
+
+// handleNoteHTML returns the note content rendered to an HTML fragment.
+// Wiki links point at the rendered HTML of their target note.
+func (a *API) handleNoteHTML(w http.ResponseWriter, r *http.Request) {
+	userID := userIDFrom(r.Context())
+	id := r.PathValue("id")
+
+	note, err := a.dbFor(r).GetNote(id, userID)
+	if errors.Is(err, database.ErrNotFound) {
+		writeError(w, http.StatusNotFound, "note not found")
+		return
+	}
+	if err != nil {
+		slog.Error("get note for html", "error", err)
+		writeError(w, http.StatusInternalServerError, "internal error")
+		return
+	}
+
+	ids, err := a.dbFor(r).ResolveTitles(userID, database.WikiLinks(note.Content))
+	if err != nil {
+		slog.Error("resolve wiki links", "error", err)
+		writeError(w, http.StatusInternalServerError, "internal error")
+		return
+	}
+
+	html, err := renderMarkdown(linkWikiLinks(note.Content, ids))
+	if err != nil {
+		slog.Error("render note", "id", id, "error", err)
+		writeError(w, http.StatusInternalServerError, "internal error")
+		return
+	}
+
+	w.Header().Set("Content-Type", "text/html; charset=utf-8")
+	w.Header().Set("Content-Security-Policy", "default-src 'none'; img-src *; style-src 'unsafe-inline'")
+	w.Write(html)
+}
@@ -0,0 +1,119 @@
+package api
+
+import (
+	"errors"
+	"fmt"
+	"log/slog"
+	"net/http"
+	"net/url"
+	"strings"
+	"time"
+
+	"github.com/c0dev0id/notesd/server/internal/database"
+	"github.com/c0dev0id/notesd/server/internal/model"
+	"golang.org/x/crypto/bcrypt"
+)
+
+// resetTokenExpiry bounds how long a mailed reset link works.
+const resetTokenExpiry = time.Hour
+
+// handleResetRequest mails a password reset link to the account's address.
+// The response is the same whether or not the address has an account.
+func (a *API) handleResetRequest(w http.ResponseWriter, r *http.Request) {
+	if a.mailer == nil || a.config.Auth.ResetURL == "" {
+		writeError(w, http.StatusNotFound, "password reset is not configured on this server")
+		return
+	}
+
+	var req model.PasswordResetRequest
+	if err := decodeJSON(r, &req); err != nil {
+		writeError(w, http.StatusBadRequest, "invalid request body")
+		return
+	}
+	email := strings.TrimSpace(strings.ToLower(req.Email))
+	if email == "" {
+		writeError(w, http.StatusBadRequest, "email is required")
+		return
+	}
+
+	db := a.dbFor(r)
+	user, err := db.GetUserByEmail(email)
+	if errors.Is(err, database.ErrNotFound) {
+		w.WriteHeader(http.StatusAccepted)
+		return
+	}
+	if err != nil {
+		slog.Error("get user for password reset", "error", err)
+		writeError(w, http.StatusInternalServerError, "internal error")
+		return
+	}
+
+	token := newSlug()
+	if err := db.CreatePasswordReset(user.ID, database.HashToken(token), model.NowMillis().Add(resetTokenExpiry)); err != nil {
+		slog.Error("create password reset", "error", err)
+		writeError(w, http.StatusInternalServerError, "internal error")
+		return
+	}
+	// A failed send is only logged: an error here would tell the caller
+	// that the address has an account.
+	if err := a.mailer.Send(user.Email, "Reset your notesd password", resetMail(a.config.Auth.ResetURL, token)); err != nil {
+		slog.Error("send password reset", "user_id", user.ID, "error", err)
+	} else {
+		slog.Info("password reset requested", "user_id", user.ID)
+	}
+	w.WriteHeader(http.StatusAccepted)
+}
+
+// handleResetConfirm sets a new password with a mailed reset token. The
+// token works once, and every session of the account is revoked.
+func (a *API) handleResetConfirm(w http.ResponseWriter, r *http.Request) {
+	var req model.PasswordResetConfirmRequest
+	if err := decodeJSON(r, &req); err != nil {
+		writeError(w, http.StatusBadRequest, "invalid request body")
+		return
+	}
+	if req.Token == "" || req.NewPassword == "" {
+		writeError(w, http.StatusBadRequest, "token and new_password are required")
+		return
+	}
+	if err := checkPassword(req.NewPassword); err != nil {
+		writeError(w, http.StatusBadRequest, err.Error())
+		return
+	}
+
+	hash, err := bcrypt.GenerateFromPassword([]byte(req.NewPassword), bcryptCost)
+	if err != nil {
+		slog.Error("bcrypt hash", "error", err)
+		writeError(w, http.StatusInternalServerError, "internal error")
+		return
+	}
+	userID, err := a.dbFor(r).ResetPassword(database.HashToken(req.Token), string(hash))
+	if errors.Is(err, database.ErrNotFound) {
+		writeError(w, http.StatusBadRequest, "invalid or expired reset token")
+		return
+	}
+	if err != nil {
+		slog.Error("reset password", "error", err)
+		writeError(w, http.StatusInternalServerError, "internal error")
+		return
+	}
+	slog.Info("password reset", "user_id", userID)
+	w.WriteHeader(http.StatusNoContent)
+}
+
+// resetMail renders the reset message with the link to the web client.
+func resetMail(resetURL, token string) string {
+	u, _ := url.Parse(resetURL) // validated by config
+	q := u.Query()
+	q.Set("token", token)
+	u.RawQuery = q.Encode()
+	return fmt.Sprintf(`Someone asked to reset the password of your notesd account.
+
+To choose a new password, open this link within an hour:
+
+%s
+
+If this wasn't you, ignore this message; your password stays the same.
+Resetting logs out all of your devices.
+`, u)
+}
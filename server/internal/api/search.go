@@ -0,0 +1,61 @@
+package api
+
+import (
+	"log/slog"
+	"net/http"
+
+	"github.com/c0dev0id/notesd/server/internal/database"
+	"github.com/c0dev0id/notesd/server/internal/model"
+)
+
+// handleSearch searches notes and todos together and returns one ranked
+// list, each result marked with its type. q uses the syntax of
+// database.QueryGrammar.
+func (a *API) handleSearch(w http.ResponseWriter, r *http.Request) {
+	userID := userIDFrom(r.Context())
+	if r.URL.Query().Get("q") == "" {
+		writeError(w, http.StatusBadRequest, "q parameter is required")
+		return
+	}
+	query, err := database.ParseQuery(r.URL.Query().Get("q"), a.config.Notes.NoteTypes())
+	if err != nil {
+		writeError(w, http.StatusBadRequest, "invalid query: "+err.Error())
+		return
+	}
+
+	limit := queryInt(r, "limit", 50)
+	offset := queryInt(r, "offset", 0)
+	if limit > 200 {
+		limit = 200
+	}
+
+	results, total, err := a.dbFor(r).Search(userID, query, limit, offset)
+	if err != nil {
+		slog.Error("search", "error", err)
+		writeError(w, http.StatusInternalServerError, "internal error")
+		return
+	}
+	if results == nil {
+		results = []model.SearchResult{}
+	}
+
+	writeJSON(w, http.StatusOK, model.SearchResponse{
+		Results: results,
+		Total:   total,
+		Limit:   limit,
+		Offset:  offset,
+	})
+}
+
+// handleValidateSearch parses q without running it, so clients can check a
+// query as it is typed. The grammar is returned either way.
+func (a *API) handleValidateSearch(w http.ResponseWriter, r *http.Request) {
+	check := model.SearchQueryCheck{Grammar: database.QueryGrammar}
+	query, err := database.ParseQuery(r.URL.Query().Get("q"), a.config.Notes.NoteTypes())
+	if err != nil {
+		check.Error = err.Error()
+	} else {
+		check.Valid, check.Terms = true, query
+	}
+	writeJSON(w, http.StatusOK, check)
+}
@@ -0,0 +1,102 @@
+package api
+
+import (
+	"log/slog"
+	"math"
+	"net/http"
+	"time"
+
+	"github.com/c0dev0id/notesd/server/internal/model"
+)
+
+const (
+	defaultStatsWeeks = 12
+	maxStatsWeeks     = 104
+	defaultStatsTags  = 10
+	maxStatsTags      = 100
+
+	defaultSummaryDays = 30
+	maxSummaryDays     = 366
+)
+
+// handleGetStatistics reports counts and writing activity for dashboards
+// and `notesd stats`. weeks sets how many weeks words_per_week covers
+// and tags how many of the most used tags are listed.
+func (a *API) handleGetStatistics(w http.ResponseWriter, r *http.Request) {
+	weeks := min(queryInt(r, "weeks", defaultStatsWeeks), maxStatsWeeks)
+	tags := min(queryInt(r, "tags", defaultStatsTags), maxStatsTags)
+	st, err := a.dbFor(r).GetStatistics(userIDFrom(r.Context()), weeks, tags)
+	if err != nil {
+		slog.Error("get statistics", "error", err)
+		writeError(w, http.StatusInternalServerError, "internal error")
+		return
+	}
+	writeJSON(w, http.StatusOK, st)
+}
+
+// handleTodoSummary reports todo completions per day over the last days
+// days, by date in the user's time zone (see userLocation), with the current streak and how the
+// completions compare with their due dates. Due dates are UTC days, as in
+// the calendar.
+func (a *API) handleTodoSummary(w http.ResponseWriter, r *http.Request) {
+	loc, ok := a.userLocation(w, r)
+	if !ok {
+		return
+	}
+	days := max(min(queryInt(r, "days", defaultSummaryDays), maxSummaryDays), 1)
+	today := dateOf(model.NowMillis(), loc)
+	first := today.AddDate(0, 0, 1-days)
+
+	sum := model.TodoSummary{Days: make([]model.DayCount, days)}
+	for i := range sum.Days {
+		sum.Days[i].Date = first.AddDate(0, 0, i).Format(time.DateOnly)
+	}
+	// Completions arrive newest first, so the streak is counted back from
+	// today until a day without one; reading stops once that day and the
+	// window are both passed.
+	streaking, next := true, today
+	var daysFromDue int
+	err := a.dbFor(r).EachCompletion(userIDFrom(r.Context()), func(done time.Time, due *time.Time) bool {
+		day := dateOf(done, loc)
+		if streaking && !day.After(next) {
+			switch {
+			case day.Equal(next):
+				sum.CurrentStreak++
+				next = day.AddDate(0, 0, -1)
+			case sum.CurrentStreak == 0 && next.Equal(today) && day.Equal(today.AddDate(0, 0, -1)):
+				// Nothing done yet today: the streak is still alive.
+				sum.CurrentStreak = 1
+				next = day.AddDate(0, 0, -1)
+			default:
+				streaking = false
+			}
+		}
+		if day.Before(first) {
+			return streaking
+		}
+		if i := int(day.Sub(first) / (24 * time.Hour)); i < days {
+			sum.Days[i].Count++
+			sum.Completed++
+			if due != nil {
+				d := int(day.Sub(dateOf(*due, time.UTC)) / (24 * time.Hour))
+				if d <= 0 {
+					sum.OnTime++
+				} else {
+					sum.Late++
+				}
+				daysFromDue += d
+			}
+		}
+		return true
+	})
+	if err != nil {
+		slog.Error("todo summary", "error", err)
+		writeError(w, http.StatusInternalServerError, "internal error")
+		return
+	}
+	if n := sum.OnTime + sum.Late; n > 0 {
+		avg := math.Round(float64(daysFromDue)/float64(n)*10) / 10
+		sum.AvgDaysFromDue = &avg
+	}
+	writeJSON(w, http.StatusOK, sum)
+}
@@ -1,108 +1,571 @@
 package api
 
 import (
+	"encoding/base64"
+	"encoding/json"
+	"errors"
+	"fmt"
 	"log/slog"
 	"net/http"
 	"strconv"
+	"time"
 
+	"github.com/c0dev0id/notesd/server/internal/database"
+	"github.com/c0dev0id/notesd/server/internal/delta"
+	"github.com/c0dev0id/notesd/server/internal/events"
 	"github.com/c0dev0id/notesd/server/internal/model"
 )
 
+const (
+	syncPageDefault = 500
+	syncPageMax     = 2000
+	// syncPageBytes caps the encoded items of one page, so a first sync
+	// over a slow link arrives in pieces.
+	syncPageBytes = 4 << 20
+)
+
+// Change kinds in the order a pull pages through them.
+const (
+	kindNotes = iota
+	kindTodos
+	kindAttachments
+	kindPurged
+	kindDone
+)
+
+// syncCursor is the position in a paginated pull. Clients treat the encoded
+// form as opaque.
+type syncCursor struct {
+	Since int64  `json:"s"`
+	Until int64  `json:"u"`
+	Kind  int    `json:"k"`
+	Time  int64  `json:"t"`
+	ID    string `json:"i"`
+}
+
+func (c syncCursor) encode() string {
+	b, _ := json.Marshal(c)
+	return base64.RawURLEncoding.EncodeToString(b)
+}
+
+func decodeSyncCursor(s string) (syncCursor, error) {
+	var c syncCursor
+	b, err := base64.RawURLEncoding.DecodeString(s)
+	if err != nil {
+		return c, err
+	}
+	if err := json.Unmarshal(b, &c); err != nil {
+		return c, err
+	}
+	if c.Kind < kindNotes || c.Kind > kindDone || c.Since > c.Until {
+		return c, errors.New("cursor out of range")
+	}
+	return c, nil
+}
+
+// syncPager fills one page of a pull within the item and byte limits.
+type syncPager struct {
+	cur   syncCursor
+	limit int
+	max   int
+	n     int
+	bytes int
+	full  bool
+}
+
+// page returns the next query window, one row larger than the room left
+// so a full page knows whether more rows follow.
+func (p *syncPager) page() database.ChangePage {
+	return database.ChangePage{
+		Since: p.cur.Since, Until: p.cur.Until,
+		AfterTime: p.cur.Time, AfterID: p.cur.ID,
+		Limit: p.limit - p.n + 1,
+	}
+}
+
+// take reports whether item fits on the page and, if so, advances the
+// cursor past it. A page always holds at least one item.
+func (p *syncPager) take(item any, t int64, id string) bool {
+	b, err := json.Marshal(item)
+	if err != nil {
+		slog.Error("encode sync item", "id", id, "error", err)
+	}
+	if p.n > 0 && (p.n >= p.limit || p.bytes+len(b) > p.max) {
+		p.full = true
+		return false
+	}
+	p.n++
+	p.bytes += len(b)
+	p.cur.Time, p.cur.ID = t, id
+	return true
+}
+
+// finish moves on to the next kind if fetched rows did not fill the window.
+func (p *syncPager) finish(fetched, window int) {
+	if !p.full && fetched < window {
+		p.cur = syncCursor{Since: p.cur.Since, Until: p.cur.Until, Kind: p.cur.Kind + 1, Time: p.cur.Since}
+	}
+}
+
 func (a *API) handleSyncChanges(w http.ResponseWriter, r *http.Request) {
 	userID := userIDFrom(r.Context())
 
-	sinceStr := r.URL.Query().Get("since")
-	if sinceStr == "" {
-		writeError(w, http.StatusBadRequest, "since parameter is required")
-		return
+	limit := queryInt(r, "limit", syncPageDefault)
+	if limit < 1 || limit > syncPageMax {
+		limit = syncPageMax
 	}
 
-	sinceMs, err := strconv.ParseInt(sinceStr, 10, 64)
-	if err != nil {
-		writeError(w, http.StatusBadRequest, "since must be a unix timestamp in milliseconds")
-		return
+	// With delta=true, notes whose last change is stored as a patch are sent
+	// as that patch instead of their full content.
+	deltas := r.URL.Query().Get("delta") == "true"
+
+	var cur syncCursor
+	reset := false
+	if c := r.URL.Query().Get("cursor"); c != "" {
+		var err error
+		if cur, err = decodeSyncCursor(c); err != nil {
+			writeError(w, http.StatusBadRequest, "invalid cursor")
+			return
+		}
+	} else {
+		sinceStr := r.URL.Query().Get("since")
+		if sinceStr == "" {
+			writeError(w, http.StatusBadRequest, "since parameter is required")
+			return
+		}
+
+		sinceMs, err := strconv.ParseInt(sinceStr, 10, 64)
+		if err != nil {
+			writeError(w, http.StatusBadRequest, "since must be a unix timestamp in milliseconds")
+			return
+		}
+
+		// Tombstones the client has not seen may be gone; send everything.
+		compactedBefore, err := a.dbFor(r).CompactedBefore(userID)
+		if err != nil {
+			slog.Error("get compaction marker", "error", err)
+			writeError(w, http.StatusInternalServerError, "internal error")
+			return
+		}
+		reset = sinceMs > 0 && sinceMs < compactedBefore
+		if reset {
+			sinceMs = 0
+		}
+		cur = syncCursor{Since: sinceMs, Until: model.NowMillis().UnixMilli(), Time: sinceMs}
+	}
+
+	resp := model.SyncChangesResponse{
+		Notes:       []model.Note{},
+		Todos:       []model.Todo{},
+		Attachments: []model.Attachment{},
+		Purged:      []model.PurgedItem{},
+		Reset:       reset,
+	}
+	p := &syncPager{cur: cur, limit: limit, max: a.syncPageBytes}
+	for !p.full && p.cur.Kind != kindDone {
+		page := p.page()
+		var fetched int
+		var err error
+		switch p.cur.Kind {
+		case kindNotes:
+			var notes []model.Note
+			notes, err = a.dbFor(r).GetNoteChangesPage(userID, page)
+			if err == nil && deltas {
+				err = a.patchNotes(a.dbFor(r), userID, notes)
+			}
+			for _, n := range notes {
+				if !p.take(n, n.ModifiedAt.UnixMilli(), n.ID) {
+					break
+				}
+				resp.Notes = append(resp.Notes, n)
+			}
+			fetched = len(notes)
+		case kindTodos:
+			var todos []model.Todo
+			todos, err = a.dbFor(r).GetTodoChangesPage(userID, page)
+			for _, t := range todos {
+				if !p.take(t, t.ModifiedAt.UnixMilli(), t.ID) {
+					break
+				}
+				resp.Todos = append(resp.Todos, t)
+			}
+			fetched = len(todos)
+		case kindAttachments:
+			var attachments []model.Attachment
+			attachments, err = a.dbFor(r).GetAttachmentChangesPage(userID, page)
+			for _, at := range attachments {
+				if !p.take(at, at.ModifiedAt.UnixMilli(), at.ID) {
+					break
+				}
+				resp.Attachments = append(resp.Attachments, at)
+			}
+			fetched = len(attachments)
+		case kindPurged:
+			var purged []database.PurgedChange
+			purged, err = a.dbFor(r).GetPurgedPage(userID, page)
+			for _, pc := range purged {
+				if !p.take(pc.PurgedItem, pc.PurgedAt, pc.ID) {
+					break
+				}
+				resp.Purged = append(resp.Purged, pc.PurgedItem)
+			}
+			fetched = len(purged)
+		}
+		if err != nil {
+			slog.Error("get sync changes", "error", err)
+			writeError(w, http.StatusInternalServerError, "internal error")
+			return
+		}
+		p.finish(fetched, page.Limit)
+	}
+
+	if p.cur.Kind == kindDone {
+		resp.SyncTimestamp = p.cur.Until
+	} else {
+		resp.HasMore = true
+		resp.NextCursor = p.cur.encode()
+	}
+
+	if err := a.dbFor(r).RecordSyncPull(userID, len(resp.Notes)+len(resp.Todos), model.NowMillis()); err != nil {
+		slog.Error("record sync pull", "error", err)
 	}
 
-	notes, err := a.db.GetNoteChangesSince(userID, sinceMs)
+	writeJSON(w, http.StatusOK, resp)
+}
+
+// maxSyncFetch limits the IDs of one fetch request.
+const maxSyncFetch = 500
+
+// handleSyncManifest lists the version of every note and todo, so a client
+// can find the items it holds in a different version and fetch just those.
+func (a *API) handleSyncManifest(w http.ResponseWriter, r *http.Request) {
+	userID := userIDFrom(r.Context())
+
+	// Taken first: a change made during the query is pulled again.
+	now := model.NowMillis().UnixMilli()
+	notes, todos, err := a.dbFor(r).GetSyncManifest(userID)
 	if err != nil {
-		slog.Error("get note changes", "error", err)
+		slog.Error("get sync manifest", "error", err)
 		writeError(w, http.StatusInternalServerError, "internal error")
 		return
 	}
-	if notes == nil {
-		notes = []model.Note{}
+
+	writeJSON(w, http.StatusOK, model.SyncManifest{Notes: notes, Todos: todos, SyncTimestamp: now})
+}
+
+// handleSyncFetch returns the notes and todos with the requested IDs in
+// full, tombstones included.
+func (a *API) handleSyncFetch(w http.ResponseWriter, r *http.Request) {
+	userID := userIDFrom(r.Context())
+
+	var req model.SyncFetchRequest
+	if err := decodeJSON(r, &req); err != nil {
+		writeError(w, http.StatusBadRequest, "invalid request body")
+		return
+	}
+	if len(req.NoteIDs)+len(req.TodoIDs) > maxSyncFetch {
+		writeError(w, http.StatusBadRequest, fmt.Sprintf("too many ids (max %d)", maxSyncFetch))
+		return
 	}
 
-	todos, err := a.db.GetTodoChangesSince(userID, sinceMs)
+	db := a.dbFor(r)
+	notes, err := db.GetNotesByID(userID, req.NoteIDs)
+	if err != nil {
+		slog.Error("fetch notes", "error", err)
+		writeError(w, http.StatusInternalServerError, "internal error")
+		return
+	}
+	todos, err := db.GetTodosByID(userID, req.TodoIDs)
 	if err != nil {
-		slog.Error("get todo changes", "error", err)
+		slog.Error("fetch todos", "error", err)
 		writeError(w, http.StatusInternalServerError, "internal error")
 		return
 	}
+	if notes == nil {
+		notes = []model.Note{}
+	}
 	if todos == nil {
 		todos = []model.Todo{}
 	}
 
-	writeJSON(w, http.StatusOK, model.SyncChangesResponse{
-		Notes:         notes,
-		Todos:         todos,
-		SyncTimestamp: model.NowMillis().UnixMilli(),
-	})
+	writeJSON(w, http.StatusOK, model.SyncFetchResponse{Notes: notes, Todos: todos})
+}
+
+// patchNotes replaces the content of live notes that have a stored patch
+// with that patch. Clients whose copy does not hash to BaseHash fetch the
+// note in full.
+func (a *API) patchNotes(db *database.DB, userID string, notes []model.Note) error {
+	ids := make([]string, 0, len(notes))
+	for _, n := range notes {
+		if n.DeletedAt == nil {
+			ids = append(ids, n.ID)
+		}
+	}
+	patches, err := db.GetNotePatches(userID, ids)
+	if err != nil {
+		return err
+	}
+	for i := range notes {
+		if p, ok := patches[notes[i].ID]; ok {
+			notes[i].Content = ""
+			notes[i].BaseHash = p.BaseHash
+			notes[i].ContentPatch = p.Patch
+		}
+	}
+	return nil
+}
+
+// applyNotePatch turns a note pushed as a patch into one with full content.
+// It reports false if the patch does not fit the server's copy.
+func (a *API) applyNotePatch(db *database.DB, n *model.Note) (bool, error) {
+	existing, err := db.GetNoteAny(n.ID, n.UserID)
+	if errors.Is(err, database.ErrNotFound) {
+		return false, nil
+	}
+	if err != nil {
+		return false, err
+	}
+	if existing.ContentHash != n.BaseHash {
+		return false, nil
+	}
+	content, err := delta.Apply(existing.Content, n.ContentPatch)
+	if err != nil {
+		return false, nil
+	}
+	if n.ContentHash != "" && delta.Hash(content) != n.ContentHash {
+		return false, nil
+	}
+	n.Content = content
+	n.BaseHash, n.ContentPatch = "", nil
+	return true, nil
 }
 
+// pushResult collects the outcome of a sync push across its chunks.
+type pushResult struct {
+	conflicts []model.SyncConflict
+	needFull  []string
+	merged    []model.Todo
+	accepted  int
+	items     int
+	// Events are published together, also for the items stored before a
+	// failure.
+	evs []events.Event
+}
+
+// errPushAborted stops reading a push whose failure has been answered.
+var errPushAborted = errors.New("sync push aborted")
+
 func (a *API) handleSyncPush(w http.ResponseWriter, r *http.Request) {
 	userID := userIDFrom(r.Context())
 
-	var req model.SyncPushRequest
-	if err := decodeJSON(r, &req); err != nil {
+	res := &pushResult{}
+	defer func() { a.bus.Publish(r.Context(), res.evs...) }()
+
+	body := http.MaxBytesReader(w, r.Body, a.maxPushSize)
+	defer body.Close()
+	err := readPush(body, a.config.Notes.NoteTypes(), func(chunk *model.SyncPushRequest) error {
+		if !a.applyPush(w, r, userID, chunk, res) {
+			return errPushAborted
+		}
+		return nil
+	})
+	var invalid invalidPush
+	var maxErr *http.MaxBytesError
+	switch {
+	case errors.Is(err, errPushAborted):
+		return
+	case errors.As(err, &invalid):
+		writeError(w, http.StatusBadRequest, invalid.Error())
+		return
+	case errors.As(err, &maxErr):
+		writeError(w, http.StatusRequestEntityTooLarge, fmt.Sprintf("sync push too large (max %d bytes)", maxErr.Limit))
+		return
+	case err != nil:
 		writeError(w, http.StatusBadRequest, "invalid request body")
 		return
 	}
 
-	var conflicts []model.SyncConflict
-	accepted := 0
+	if err := a.dbFor(r).RecordSyncPush(userID, res.items, model.NowMillis()); err != nil {
+		slog.Error("record sync push", "error", err)
+	}
 
-	for i := range req.Notes {
-		req.Notes[i].UserID = userID
-		serverVersion, err := a.db.UpsertNote(&req.Notes[i])
+	writeJSON(w, http.StatusOK, model.SyncPushResponse{
+		Conflicts: res.conflicts,
+		NeedFull:  res.needFull,
+		Merged:    res.merged,
+		Accepted:  res.accepted,
+		Timestamp: model.NowMillis().UnixMilli(),
+	})
+}
+
+// applyPush stores one chunk of a sync push atomically, adding the outcome
+// to res. On failure it answers the request and returns false; earlier
+// chunks stay stored, and pushing them again is harmless.
+func (a *API) applyPush(w http.ResponseWriter, r *http.Request, userID string, req *model.SyncPushRequest, res *pushResult) bool {
+	if a.quotasEnabled() {
+		d, err := a.syncPushDelta(a.dbFor(r), userID, req)
 		if err != nil {
-			slog.Error("sync upsert note", "id", req.Notes[i].ID, "error", err)
+			slog.Error("sync push quota", "error", err)
 			writeError(w, http.StatusInternalServerError, "internal error")
-			return
+			return false
 		}
-		if serverVersion != nil {
-			conflicts = append(conflicts, model.SyncConflict{
-				Type:       "note",
-				ID:         req.Notes[i].ID,
-				ServerNote: serverVersion,
-			})
-		} else {
-			accepted++
+		if !a.checkQuota(w, r, userID, d) {
+			return false
 		}
 	}
+	res.items += len(req.Notes) + len(req.Todos)
 
-	for i := range req.Todos {
-		req.Todos[i].UserID = userID
-		serverVersion, err := a.db.UpsertTodo(&req.Todos[i])
+	// The chunk is stored in one transaction, so a failure leaves none of
+	// it behind and c only reaches res once it is committed.
+	var c pushResult
+	err := a.dbFor(r).WithTx(r.Context(), func(tx *database.Tx) error {
+		compactedBefore, err := tx.CompactedBefore(userID)
 		if err != nil {
-			slog.Error("sync upsert todo", "id", req.Todos[i].ID, "error", err)
-			writeError(w, http.StatusInternalServerError, "internal error")
-			return
+			return err
 		}
-		if serverVersion != nil {
-			conflicts = append(conflicts, model.SyncConflict{
-				Type:       "todo",
-				ID:         req.Todos[i].ID,
-				ServerTodo: serverVersion,
-			})
-		} else {
-			accepted++
+		for i := range req.Notes {
+			req.Notes[i].UserID = userID
+			gone, err := ignoredPush(tx, "note", req.Notes[i].ID, userID, req.Notes[i].ModifiedAt, compactedBefore)
+			if err != nil {
+				return err
+			}
+			if gone {
+				continue
+			}
+			if req.Notes[i].BaseHash != "" {
+				ok, err := a.applyNotePatch(tx.DB, &req.Notes[i])
+				if err != nil {
+					return fmt.Errorf("apply note patch %s: %w", req.Notes[i].ID, err)
+				}
+				if !ok {
+					c.needFull = append(c.needFull, req.Notes[i].ID)
+					continue
+				}
+			}
+			serverVersion, prev, err := tx.UpsertNote(&req.Notes[i])
+			if err != nil {
+				return fmt.Errorf("upsert note %s: %w", req.Notes[i].ID, err)
+			}
+			if serverVersion != nil {
+				a.recordConflict(tx.DB, userID, "note", req.Notes[i].ID, req.Notes[i].ModifiedByDevice)
+				c.conflicts = append(c.conflicts, model.SyncConflict{
+					Type:       "note",
+					ID:         req.Notes[i].ID,
+					ServerNote: serverVersion,
+				})
+			} else {
+				c.accepted++
+				kind := noteKind(prev, &req.Notes[i])
+				c.evs = append(c.evs, noteEvent(kind, userID, &req.Notes[i]))
+				if kind == events.NoteDeleted {
+					if err := a.cascadePushedDelete(tx, userID, &req.Notes[i], &c); err != nil {
+						return err
+					}
+				}
+			}
 		}
-	}
 
-	writeJSON(w, http.StatusOK, model.SyncPushResponse{
-		Conflicts: conflicts,
-		Accepted:  accepted,
-		Timestamp: model.NowMillis().UnixMilli(),
+		for i := range req.Todos {
+			req.Todos[i].UserID = userID
+			gone, err := ignoredPush(tx, "todo", req.Todos[i].ID, userID, req.Todos[i].ModifiedAt, compactedBefore)
+			if err != nil {
+				return err
+			}
+			if gone {
+				continue
+			}
+			req.Todos[i].NormalizeDue()
+			serverVersion, prev, applied, err := tx.UpsertTodo(&req.Todos[i])
+			if err != nil {
+				return fmt.Errorf("upsert todo %s: %w", req.Todos[i].ID, err)
+			}
+			if applied && serverVersion != nil {
+				c.accepted++
+				c.merged = append(c.merged, *serverVersion)
+				c.evs = append(c.evs, todoEvent(todoKind(prev, serverVersion), userID, serverVersion))
+			} else if serverVersion != nil {
+				a.recordConflict(tx.DB, userID, "todo", req.Todos[i].ID, req.Todos[i].ModifiedByDevice)
+				c.conflicts = append(c.conflicts, model.SyncConflict{
+					Type:       "todo",
+					ID:         req.Todos[i].ID,
+					ServerTodo: serverVersion,
+				})
+			} else {
+				c.accepted++
+				c.evs = append(c.evs, todoEvent(todoKind(prev, &req.Todos[i]), userID, &req.Todos[i]))
+			}
+		}
+		return nil
 	})
+	if err != nil {
+		slog.Error("sync push", "error", err)
+		writeError(w, http.StatusInternalServerError, "internal error")
+		return false
+	}
+
+	res.conflicts = append(res.conflicts, c.conflicts...)
+	res.needFull = append(res.needFull, c.needFull...)
+	res.merged = append(res.merged, c.merged...)
+	res.accepted += c.accepted
+	res.evs = append(res.evs, c.evs...)
+	return true
+}
+
+// ignoredPush reports whether a pushed item is dropped unseen, so that a
+// device that has not pulled its removal yet cannot bring it back: the
+// item was purged, or it was last changed before the user's tombstones
+// were collected, when the server may have forgotten it was deleted.
+func ignoredPush(tx *database.Tx, itemType, id, userID string, modifiedAt time.Time, compactedBefore int64) (bool, error) {
+	if modifiedAt.UnixMilli() < compactedBefore {
+		return true, nil
+	}
+	purged, err := tx.IsPurged(itemType, id, userID)
+	if err != nil {
+		return false, fmt.Errorf("check purged %s %s: %w", itemType, id, err)
+	}
+	return purged, nil
+}
+
+// cascadePushedDelete applies notes.delete_todos to the todos of a note
+// deleted by a sync push, as for other deletions. The todos are changed at
+// the server's time, so that devices that synced after the deletion was
+// made still pull them, and are returned as merged so the pushing device,
+// whose next pull starts after the push, gets them too.
+func (a *API) cascadePushedDelete(tx *database.Tx, userID string, n *model.Note, c *pushResult) error {
+	cascaded, err := tx.CascadeNoteTodos(n.ID, userID, model.NowMillis().UnixMilli(), n.ModifiedByDevice, a.noteTodos())
+	if err != nil {
+		return fmt.Errorf("cascade note %s: %w", n.ID, err)
+	}
+	todos, err := tx.GetTodosByID(userID, append(cascaded.Deleted, cascaded.Detached...))
+	if err != nil {
+		return fmt.Errorf("get cascaded todos of note %s: %w", n.ID, err)
+	}
+	c.merged = append(c.merged, todos...)
+	c.evs = append(c.evs, cascadeEvents(userID, n.ModifiedByDevice, cascaded)...)
+	return nil
+}
+
+// recordConflict remembers a lost push so it can be surfaced later. Failing
+// to record it does not fail the push; the client already gets the conflict
+// in the response.
+func (a *API) recordConflict(db *database.DB, userID, itemType, itemID, deviceID string) {
+	if err := db.RecordConflict(userID, itemType, itemID, deviceID, model.NowMillis()); err != nil {
+		slog.Error("record sync conflict", "id", itemID, "error", err)
+	}
+}
+
+func (a *API) handleListConflicts(w http.ResponseWriter, r *http.Request) {
+	userID := userIDFrom(r.Context())
+
+	conflicts, err := a.dbFor(r).GetUnresolvedConflicts(userID)
+	if err != nil {
+		slog.Error("list conflicts", "error", err)
+		writeError(w, http.StatusInternalServerError, "internal error")
+		return
+	}
+	if conflicts == nil {
+		conflicts = []model.ConflictRecord{}
+	}
+
+	writeJSON(w, http.StatusOK, conflicts)
 }
@@ -0,0 +1,156 @@
+package api
+
+import (
+	"errors"
+	"fmt"
+	"log/slog"
+	"net/http"
+	"sort"
+	"strings"
+	"unicode/utf8"
+
+	"github.com/c0dev0id/notesd/server/internal/database"
+	"github.com/c0dev0id/notesd/server/internal/events"
+	"github.com/c0dev0id/notesd/server/internal/model"
+)
+
+const (
+	maxTagLen      = 100
+	maxTagsPerItem = 50
+)
+
+// normalizeTags trims tag names, drops case-insensitive duplicates and sorts
+// the result the same way stored tags are returned. A nil
+// input stays nil so that update requests can tell "unchanged" from "clear".
+func normalizeTags(tags []string) ([]string, error) {
+	if tags == nil {
+		return nil, nil
+	}
+	if len(tags) > maxTagsPerItem {
+		return nil, fmt.Errorf("too many tags (max %d)", maxTagsPerItem)
+	}
+	out := []string{}
+	seen := map[string]bool{}
+	for _, t := range tags {
+		t, err := normalizeTagName(t)
+		if err != nil {
+			return nil, err
+		}
+		key := strings.ToLower(t)
+		if seen[key] {
+			continue
+		}
+		seen[key] = true
+		out = append(out, t)
+	}
+	sort.Strings(out)
+	return out, nil
+}
+
+func normalizeTagName(name string) (string, error) {
+	name = strings.TrimSpace(name)
+	if name == "" {
+		return "", errors.New("tag name must not be empty")
+	}
+	if utf8.RuneCountInString(name) > maxTagLen {
+		return "", errors.New("tag name too long")
+	}
+	return name, nil
+}
+
+func (a *API) handleListTags(w http.ResponseWriter, r *http.Request) {
+	userID := userIDFrom(r.Context())
+
+	tags, err := a.dbFor(r).ListTags(userID)
+	if err != nil {
+		slog.Error("list tags", "error", err)
+		writeError(w, http.StatusInternalServerError, "internal error")
+		return
+	}
+	if tags == nil {
+		tags = []model.TagUsage{}
+	}
+
+	writeJSON(w, http.StatusOK, tags)
+}
+
+func (a *API) handleRenameTag(w http.ResponseWriter, r *http.Request) {
+	userID := userIDFrom(r.Context())
+
+	var req model.RenameTagRequest
+	if err := decodeJSON(r, &req); err != nil {
+		writeError(w, http.StatusBadRequest, "invalid request body")
+		return
+	}
+	name, err := normalizeTagName(r.PathValue("name"))
+	if err != nil {
+		writeError(w, http.StatusBadRequest, err.Error())
+		return
+	}
+	newName, err := normalizeTagName(req.Name)
+	if err != nil {
+		writeError(w, http.StatusBadRequest, err.Error())
+		return
+	}
+
+	now := model.NowMillis().UnixMilli()
+	touched, err := a.dbFor(r).RenameTag(userID, name, newName, now, deviceIDFrom(r.Context()))
+	a.writeTagResult(w, r, touched, err, "rename tag")
+}
+
+func (a *API) handleMergeTag(w http.ResponseWriter, r *http.Request) {
+	userID := userIDFrom(r.Context())
+
+	var req model.MergeTagRequest
+	if err := decodeJSON(r, &req); err != nil {
+		writeError(w, http.StatusBadRequest, "invalid request body")
+		return
+	}
+	name, err := normalizeTagName(r.PathValue("name"))
+	if err != nil {
+		writeError(w, http.StatusBadRequest, err.Error())
+		return
+	}
+	into, err := normalizeTagName(req.Into)
+	if err != nil {
+		writeError(w, http.StatusBadRequest, err.Error())
+		return
+	}
+
+	now := model.NowMillis().UnixMilli()
+	touched, err := a.dbFor(r).MergeTag(userID, name, into, now, deviceIDFrom(r.Context()))
+	a.writeTagResult(w, r, touched, err, "merge tag")
+}
+
+func (a *API) handleDeleteTag(w http.ResponseWriter, r *http.Request) {
+	userID := userIDFrom(r.Context())
+	name, err := normalizeTagName(r.PathValue("name"))
+	if err != nil {
+		writeError(w, http.StatusBadRequest, err.Error())
+		return
+	}
+
+	now := model.NowMillis().UnixMilli()
+	touched, err := a.dbFor(r).DeleteTag(userID, name, now, deviceIDFrom(r.Context()))
+	a.writeTagResult(w, r, touched, err, "delete tag")
+}
+
+// writeTagResult answers a tag operation. Success publishes the touched
+// items as a bulk change, as every item carrying the tag was modified.
+func (a *API) writeTagResult(w http.ResponseWriter, r *http.Request, touched database.Touched, err error, op string) {
+	switch {
+	case err == nil:
+		userID, deviceID := userIDFrom(r.Context()), deviceIDFrom(r.Context())
+		evs := append(bulkEvents(events.NoteUpdated, userID, deviceID, touched.NoteIDs),
+			bulkEvents(events.TodoUpdated, userID, deviceID, touched.TodoIDs)...)
+		a.bus.Publish(r.Context(), append(evs, resyncEvent(userID, deviceID))...)
+		w.WriteHeader(http.StatusNoContent)
+	case errors.Is(err, database.ErrNotFound):
+		writeError(w, http.StatusNotFound, "tag not found")
+	case errors.Is(err, database.ErrConflict):
+		writeError(w, http.StatusConflict, "tag name already in use; merge instead")
+	default:
+		slog.Error(op, "error", err)
+		writeError(w, http.StatusInternalServerError, "internal error")
+	}
+}
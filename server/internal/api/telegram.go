@@ -0,0 +1,403 @@
+package api
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"log/slog"
+	"net/http"
+	"strings"
+	"time"
+	"unicode/utf8"
+
+	"github.com/c0dev0id/notesd/server/internal/database"
+	"github.com/c0dev0id/notesd/server/internal/events"
+	"github.com/c0dev0id/notesd/server/internal/model"
+	"github.com/c0dev0id/notesd/server/internal/telegram"
+)
+
+const (
+	// telegramDevice is the device recorded for changes made from Telegram.
+	telegramDevice       = "telegram"
+	telegramLinkLifetime = 15 * time.Minute
+	// telegramOverdueHour is the local hour from which the daily overdue
+	// notice is sent.
+	telegramOverdueHour   = 8
+	telegramCheckInterval = time.Minute
+	telegramRetryDelay    = 5 * time.Second
+	telegramMaxTodos      = 20
+)
+
+// telegramBot talks to Telegram; *telegram.Bot in production.
+type telegramBot interface {
+	Updates(ctx context.Context, offset int64) ([]telegram.Update, error)
+	Send(ctx context.Context, chatID int64, text string) error
+}
+
+const telegramHelp = `Send any text to save it as a note; its first line is the title.
+/todo <text> adds a todo
+/todos lists your open todos
+/unlink disconnects this chat`
+
+// telegramEnabled answers 404 and returns false while the bot is off.
+func (a *API) telegramEnabled(w http.ResponseWriter) bool {
+	if a.telegram == nil {
+		writeError(w, http.StatusNotFound, "telegram is not configured on this server")
+		return false
+	}
+	return true
+}
+
+func (a *API) handleGetTelegram(w http.ResponseWriter, r *http.Request) {
+	if !a.telegramEnabled(w) {
+		return
+	}
+	userID := userIDFrom(r.Context())
+
+	c, err := a.dbFor(r).GetTelegramChat(userID)
+	if errors.Is(err, database.ErrNotFound) {
+		writeError(w, http.StatusNotFound, "no linked chat")
+		return
+	}
+	if err != nil {
+		slog.Error("get telegram chat", "error", err)
+		writeError(w, http.StatusInternalServerError, "internal error")
+		return
+	}
+
+	writeJSON(w, http.StatusOK, model.TelegramChat{LinkedAt: c.LinkedAt})
+}
+
+// handleCreateTelegramLink issues a one-time code that links the chat it
+// is sent from, replacing any pending code. Only its hash is stored.
+func (a *API) handleCreateTelegramLink(w http.ResponseWriter, r *http.Request) {
+	if !a.telegramEnabled(w) {
+		return
+	}
+	userID := userIDFrom(r.Context())
+
+	code := newMailInToken()
+	expires := model.NowMillis().Add(telegramLinkLifetime)
+	if err := a.dbFor(r).SetTelegramLinkCode(userID, database.HashToken(code), expires); err != nil {
+		slog.Error("create telegram link code", "error", err)
+		writeError(w, http.StatusInternalServerError, "internal error")
+		return
+	}
+
+	writeJSON(w, http.StatusCreated, model.TelegramLink{Code: code, ExpiresAt: expires})
+}
+
+func (a *API) handleDeleteTelegram(w http.ResponseWriter, r *http.Request) {
+	if !a.telegramEnabled(w) {
+		return
+	}
+	userID := userIDFrom(r.Context())
+
+	err := a.dbFor(r).DeleteTelegramChat(userID)
+	if errors.Is(err, database.ErrNotFound) {
+		writeError(w, http.StatusNotFound, "no linked chat")
+		return
+	}
+	if err != nil {
+		slog.Error("delete telegram chat", "error", err)
+		writeError(w, http.StatusInternalServerError, "internal error")
+		return
+	}
+
+	w.WriteHeader(http.StatusNoContent)
+}
+
+// RunTelegram answers messages to the bot and sends the daily overdue
+// notices until ctx is cancelled. It returns immediately when the bot is
+// off.
+func (a *API) RunTelegram(ctx context.Context) {
+	if a.telegram == nil {
+		return
+	}
+	go a.pollTelegram(ctx)
+	ticker := time.NewTicker(telegramCheckInterval)
+	defer ticker.Stop()
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case now := <-ticker.C:
+			a.sendOverdueNotices(ctx, now)
+		}
+	}
+}
+
+// pollTelegram long-polls for messages and handles them one at a time.
+func (a *API) pollTelegram(ctx context.Context) {
+	var offset int64
+	for ctx.Err() == nil {
+		updates, err := a.telegram.Updates(ctx, offset)
+		if err != nil {
+			if ctx.Err() != nil {
+				return
+			}
+			slog.Error("telegram updates", "error", err)
+			select {
+			case <-ctx.Done():
+			case <-time.After(telegramRetryDelay):
+			}
+			continue
+		}
+		for _, u := range updates {
+			offset = u.UpdateID + 1
+			if u.Message != nil && u.Message.Text != "" {
+				a.handleTelegramMessage(ctx, u.Message.Chat.ID, u.Message.Text)
+			}
+		}
+	}
+}
+
+// handleTelegramMessage acts on a message from chatID and replies to it.
+func (a *API) handleTelegramMessage(ctx context.Context, chatID int64, text string) {
+	reply, err := a.telegramReply(ctx, chatID, strings.TrimSpace(text))
+	if err != nil {
+		slog.Error("telegram message", "chat_id", chatID, "error", err)
+		reply = "Something went wrong; please try again later."
+	}
+	if err := a.telegram.Send(ctx, chatID, reply); err != nil {
+		slog.Error("telegram reply", "chat_id", chatID, "error", err)
+	}
+}
+
+func (a *API) telegramReply(ctx context.Context, chatID int64, text string) (string, error) {
+	db := a.db.WithContext(ctx)
+	cmd, arg := telegramCommand(text)
+
+	if cmd == "/start" || cmd == "/link" {
+		if arg == "" {
+			return "Create a link code in notesd, then send /start <code>.", nil
+		}
+		userID, err := db.LinkTelegramChat(database.HashToken(strings.ToLower(arg)), chatID, model.NowMillis())
+		if errors.Is(err, database.ErrNotFound) {
+			return "That code is unknown or has expired.", nil
+		}
+		if err != nil {
+			return "", err
+		}
+		slog.Info("telegram chat linked", "user_id", userID)
+		return "Linked to notesd.\n\n" + telegramHelp, nil
+	}
+
+	userID, err := db.GetTelegramChatUser(chatID)
+	if errors.Is(err, database.ErrNotFound) {
+		return "This chat is not linked to a notesd account. Create a link code in notesd, then send /start <code>.", nil
+	}
+	if err != nil {
+		return "", err
+	}
+
+	switch cmd {
+	case "":
+		return a.telegramNote(ctx, userID, text)
+	case "/todo":
+		return a.telegramTodo(ctx, userID, arg)
+	case "/todos":
+		return a.telegramTodos(db, userID)
+	case "/unlink":
+		if err := db.DeleteTelegramChat(userID); err != nil && !errors.Is(err, database.ErrNotFound) {
+			return "", err
+		}
+		return "Unlinked. You will get no more messages from notesd here.", nil
+	}
+	return telegramHelp, nil
+}
+
+// telegramCommand splits "/cmd@bot arg" into "/cmd" and "arg"; cmd is ""
+// for text that is not a command.
+func telegramCommand(text string) (cmd, arg string) {
+	if !strings.HasPrefix(text, "/") {
+		return "", text
+	}
+	cmd = text
+	if i := strings.IndexAny(text, " \t\n"); i >= 0 {
+		cmd, arg = text[:i], text[i+1:]
+	}
+	cmd, _, _ = strings.Cut(cmd, "@")
+	return strings.ToLower(cmd), strings.TrimSpace(arg)
+}
+
+// telegramNote saves text as a note titled after its first line.
+func (a *API) telegramNote(ctx context.Context, userID, text string) (string, error) {
+	title, content, _ := strings.Cut(text, "\n")
+	title = strings.TrimSpace(title)
+	if utf8.RuneCountInString(title) > maxTitleLen {
+		title = string([]rune(title)[:maxTitleLen])
+	}
+	content = strings.TrimSpace(content)
+	if content != "" {
+		content += "\n"
+	}
+	if utf8.RuneCountInString(content) > maxContentLen {
+		return "That message is too long for a note.", nil
+	}
+	now := model.NowMillis()
+	n := &model.Note{
+		ID:               model.NewID(),
+		UserID:           userID,
+		Title:            title,
+		Content:          content,
+		Type:             "note",
+		Tags:             []string{},
+		ModifiedAt:       now,
+		ModifiedByDevice: telegramDevice,
+		CreatedAt:        now,
+	}
+	db := a.db.WithContext(ctx)
+	reason, err := a.quotaExceeded(db, userID, usageDelta{notes: 1, contentBytes: noteBytes(n)})
+	if err != nil {
+		return "", err
+	}
+	if reason != "" {
+		return "Not saved: " + reason, nil
+	}
+	if err := db.CreateNote(n); err != nil {
+		return "", err
+	}
+	a.bus.Publish(ctx, noteEvent(events.NoteCreated, userID, n))
+	return fmt.Sprintf("Saved note %q.", title), nil
+}
+
+func (a *API) telegramTodo(ctx context.Context, userID, text string) (string, error) {
+	if text == "" {
+		return "Send /todo followed by what to do.", nil
+	}
+	if utf8.RuneCountInString(text) > maxTodoContentLen {
+		return "That todo is too long.", nil
+	}
+	now := model.NowMillis()
+	t := &model.Todo{
+		ID:               model.NewID(),
+		UserID:           userID,
+		Content:          text,
+		Tags:             []string{},
+		ModifiedAt:       now,
+		ModifiedByDevice: telegramDevice,
+		CreatedAt:        now,
+	}
+	db := a.db.WithContext(ctx)
+	reason, err := a.quotaExceeded(db, userID, usageDelta{todos: 1, contentBytes: todoBytes(t)})
+	if err != nil {
+		return "", err
+	}
+	if reason != "" {
+		return "Not saved: " + reason, nil
+	}
+	if err := db.CreateTodo(t); err != nil {
+		return "", err
+	}
+	a.bus.Publish(ctx, todoEvent(events.TodoCreated, userID, t))
+	return "Added todo.", nil
+}
+
+// telegramTodos lists the open todos, soonest due first.
+func (a *API) telegramTodos(db *database.DB, userID string) (string, error) {
+	open := false
+	todos, total, err := db.ListTodos(userID, database.TodoFilter{Completed: &open, Sort: []string{"due", "created"}}, telegramMaxTodos, 0)
+	if err != nil {
+		return "", err
+	}
+	if total == 0 {
+		return "No open todos.", nil
+	}
+	loc := time.UTC
+	if c, err := db.GetTelegramChat(userID); err == nil {
+		if l, err := time.LoadLocation(c.Timezone); err == nil {
+			loc = l
+		}
+	}
+	var b strings.Builder
+	fmt.Fprintf(&b, "Open todos (%d):", total)
+	writeTelegramTodos(&b, todos, model.NowMillis(), loc)
+	if total > len(todos) {
+		fmt.Fprintf(&b, "\n…and %d more", total-len(todos))
+	}
+	return b.String(), nil
+}
+
+// writeTelegramTodos lists todos with their due dates, timed ones in loc,
+// which also decides whether all-day todos are overdue.
+func writeTelegramTodos(b *strings.Builder, todos []model.Todo, now time.Time, loc *time.Location) {
+	for _, t := range todos {
+		b.WriteString("\n• " + t.Content)
+		if t.DueDate != nil {
+			b.WriteString(" (due " + formatDue(&t, loc))
+			if isOverdue(&t, now, loc) {
+				b.WriteString(", overdue")
+			}
+			b.WriteString(")")
+		}
+	}
+}
+
+// sendOverdueNotices sends each linked chat the list of its overdue todos
+// once a day, from telegramOverdueHour in the chat's time zone, which also
+// decides which todos are overdue. Days without overdue todos send nothing.
+func (a *API) sendOverdueNotices(ctx context.Context, now time.Time) {
+	db := a.db.WithContext(ctx)
+	chats, err := db.ListTelegramChats()
+	if err != nil {
+		slog.Error("list telegram chats", "error", err)
+		return
+	}
+	for _, c := range chats {
+		loc, err := time.LoadLocation(c.Timezone)
+		if err != nil {
+			loc = time.UTC
+		}
+		local := now.In(loc)
+		today := local.Format(calendarDateLayout)
+		if local.Hour() < telegramOverdueHour || c.OverdueSentOn == today {
+			continue
+		}
+		todos, err := db.GetOverdueTodos(c.UserID, now, dateOf(now, loc))
+		if err != nil {
+			slog.Error("get overdue todos", "user_id", c.UserID, "error", err)
+			continue
+		}
+		// Marked first: a failed send is not retried every minute.
+		if err := db.SetTelegramOverdueSent(c.UserID, today); err != nil {
+			slog.Error("mark telegram overdue notice", "user_id", c.UserID, "error", err)
+			continue
+		}
+		if len(todos) == 0 {
+			continue
+		}
+		var b strings.Builder
+		fmt.Fprintf(&b, "Overdue todos (%d):", len(todos))
+		writeTelegramTodos(&b, todos[:min(len(todos), telegramMaxTodos)], now, loc)
+		if len(todos) > telegramMaxTodos {
+			fmt.Fprintf(&b, "\n…and %d more", len(todos)-telegramMaxTodos)
+		}
+		if err := a.telegram.Send(ctx, c.ChatID, b.String()); err != nil {
+			slog.Error("telegram overdue notice", "user_id", c.UserID, "error", err)
+		}
+	}
+}
+
+// sendTelegramReminder sends r to the user's linked chat, if any.
+func (a *API) sendTelegramReminder(r database.DueReminder) {
+	c, err := a.db.GetTelegramChat(r.UserID)
+	if errors.Is(err, database.ErrNotFound) {
+		return
+	}
+	if err != nil {
+		slog.Error("get telegram chat", "user_id", r.UserID, "error", err)
+		return
+	}
+	title := r.Title
+	if title == "" {
+		title = "untitled " + r.Type
+	}
+	text := "Reminder: " + title
+	if r.Type == "note" {
+		text = "Snoozed note is back: " + title
+	}
+	if err := a.telegram.Send(context.Background(), c.ChatID, text); err != nil {
+		slog.Error("telegram reminder", "user_id", r.UserID, "error", err)
+	}
+}
@@ -0,0 +1,157 @@
+package api
+
+import (
+	"context"
+	"net/http"
+	"sync"
+	"time"
+)
+
+// longRoutes run under server.long_request_timeout instead of
+// server.request_timeout: they move many items, whole accounts or large
+// files.
+var longRoutes = map[string]bool{
+	"GET /api/v1/sync/changes":            true,
+	"POST /api/v1/sync/push":              true,
+	"GET /api/v1/sync/manifest":           true,
+	"POST /api/v1/sync/fetch":             true,
+	"GET /api/v1/export":                  true,
+	"GET /api/v1/export/todos.csv":        true,
+	"POST /api/v1/import":                 true,
+	"POST /api/v1/import/todos.csv":       true,
+	"GET /api/v1/account/export":          true,
+	"POST /api/v1/admin/backup":           true,
+	"POST /api/v1/admin/maintenance":      true,
+	"POST /api/v1/notes/{id}/attachments": true,
+	"GET /api/v1/attachments/{id}":        true,
+}
+
+// streamRoutes keep the connection for as long as the client listens, so
+// no timeout applies to them.
+var streamRoutes = map[string]bool{
+	"GET /api/v1/sync/ws":     true,
+	"GET /api/v1/sync/events": true,
+}
+
+// deadlineSlack is added to a long route's timeout for the connection's
+// read and write deadlines, so that the 503 can still be sent.
+const deadlineSlack = 10 * time.Second
+
+// timeouts runs each request under the timeout of its route. A handler
+// that has not started its response by then is abandoned: the client gets
+// 503, and whatever the handler writes afterwards is discarded. Its
+// context is cancelled, but statements it has begun run to completion. A
+// response already under way is left to finish.
+//
+// The matched pattern is recorded on the request, as the mux only sets it
+// on the copy carrying the timeout.
+func (a *API) timeouts(mux *http.ServeMux) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		_, r.Pattern = mux.Handler(r)
+		d := a.requestTimeout
+		switch {
+		case streamRoutes[r.Pattern]:
+			d = 0
+		case longRoutes[r.Pattern]:
+			d = a.longRequestTimeout
+			// The server's own read and write timeouts suit quick
+			// requests only.
+			var deadline time.Time
+			if d > 0 {
+				deadline = time.Now().Add(d + deadlineSlack)
+			}
+			rc := http.NewResponseController(w)
+			rc.SetReadDeadline(deadline)
+			rc.SetWriteDeadline(deadline)
+		}
+		if d <= 0 {
+			mux.ServeHTTP(w, r)
+			return
+		}
+
+		ctx, cancel := context.WithTimeout(r.Context(), d)
+		defer cancel()
+		tw := &timeoutWriter{w: w, h: w.Header().Clone()}
+		done := make(chan struct{})
+		var panicked any
+		go func() {
+			defer close(done)
+			defer func() { panicked = recover() }()
+			mux.ServeHTTP(tw, r.WithContext(ctx))
+		}()
+
+		select {
+		case <-done:
+		case <-ctx.Done():
+			tw.mu.Lock()
+			started := tw.started
+			tw.timedOut = !started
+			tw.mu.Unlock()
+			if !started {
+				w.Header().Set("Retry-After", "60")
+				writeError(w, http.StatusServiceUnavailable, "request timed out")
+				return
+			}
+			<-done
+		}
+		if panicked != nil {
+			panic(panicked)
+		}
+	})
+}
+
+// timeoutWriter passes a handler's response on until the request has timed
+// out without one. The handler gets a header map of its own, so that it
+// cannot race with the 503.
+type timeoutWriter struct {
+	w        http.ResponseWriter
+	h        http.Header
+	mu       sync.Mutex
+	started  bool
+	timedOut bool
+}
+
+func (tw *timeoutWriter) Header() http.Header {
+	return tw.h
+}
+
+func (tw *timeoutWriter) WriteHeader(code int) {
+	tw.mu.Lock()
+	defer tw.mu.Unlock()
+	tw.writeHeader(code)
+}
+
+// writeHeader sends the handler's header, unless the request has timed out
+// or the header was sent already. tw.mu is held.
+func (tw *timeoutWriter) writeHeader(code int) {
+	if tw.timedOut || tw.started {
+		return
+	}
+	tw.started = true
+	dst := tw.w.Header()
+	for k, v := range tw.h {
+		dst[k] = v
+	}
+	tw.w.WriteHeader(code)
+}
+
+func (tw *timeoutWriter) Write(b []byte) (int, error) {
+	tw.mu.Lock()
+	defer tw.mu.Unlock()
+	if tw.timedOut {
+		return 0, http.ErrHandlerTimeout
+	}
+	tw.writeHeader(http.StatusOK)
+	return tw.w.Write(b)
+}
+
+// Flush lets handlers stream their response.
+func (tw *timeoutWriter) Flush() {
+	tw.mu.Lock()
+	defer tw.mu.Unlock()
+	if tw.timedOut {
+		return
+	}
+	tw.writeHeader(http.StatusOK)
+	http.NewResponseController(tw.w).Flush()
+}
@@ -4,14 +4,51 @@ import (
 	"errors"
 	"log/slog"
 	"net/http"
+	"strings"
+	"time"
 	"unicode/utf8"
 
 	"github.com/c0dev0id/notesd/server/internal/database"
+	"github.com/c0dev0id/notesd/server/internal/events"
 	"github.com/c0dev0id/notesd/server/internal/model"
 )
 
 const maxTodoContentLen = 10000
 
+// todoFilterFrom reads the listing filters shared by list and search:
+// ?completed=, ?note_id=, ?orphaned=, ?tag=, ?due_after=, ?due_before= and
+// ?sort=. The error is meant for the client.
+func todoFilterFrom(r *http.Request) (database.TodoFilter, error) {
+	q := r.URL.Query()
+	f := database.TodoFilter{Tag: strings.TrimSpace(q.Get("tag")), NoteID: q.Get("note_id")}
+	var err error
+	switch q.Get("completed") {
+	case "":
+	case "true", "false":
+		done := q.Get("completed") == "true"
+		f.Completed = &done
+	default:
+		return f, errors.New("completed must be true or false")
+	}
+	switch q.Get("orphaned") {
+	case "", "false":
+	case "true":
+		f.Orphaned = true
+	default:
+		return f, errors.New("orphaned must be true or false")
+	}
+	if f.Sort, err = database.ParseTodoSort(q.Get("sort")); err != nil {
+		return f, err
+	}
+	if f.DueAfter, err = queryDueBound(r, "due_after"); err != nil {
+		return f, errors.New("due_after must be a date (YYYY-MM-DD) or RFC 3339 time")
+	}
+	if f.DueBefore, err = queryDueBound(r, "due_before"); err != nil {
+		return f, errors.New("due_before must be a date (YYYY-MM-DD) or RFC 3339 time")
+	}
+	return f, nil
+}
+
 func (a *API) handleListTodos(w http.ResponseWriter, r *http.Request) {
 	userID := userIDFrom(r.Context())
 	limit := queryInt(r, "limit", 50)
@@ -21,7 +58,12 @@ func (a *API) handleListTodos(w http.ResponseWriter, r *http.Request) {
 		limit = 200
 	}
 
-	todos, total, err := a.db.ListTodos(userID, limit, offset)
+	f, err := todoFilterFrom(r)
+	if err != nil {
+		writeError(w, http.StatusBadRequest, err.Error())
+		return
+	}
+	todos, total, err := a.dbFor(r).ListTodos(userID, f, limit, offset)
 	if err != nil {
 		slog.Error("list todos", "error", err)
 		writeError(w, http.StatusInternalServerError, "internal error")
@@ -31,6 +73,45 @@ func (a *API) handleListTodos(w http.ResponseWriter, r *http.Request) {
 		todos = []model.Todo{}
 	}
 
+	writeCached(w, r, time.Time{}, model.TodoListResponse{
+		Todos:  todos,
+		Total:  total,
+		Limit:  limit,
+		Offset: offset,
+	})
+}
+
+// handleSearchTodos matches q against todo content, within the same
+// filters as the list.
+func (a *API) handleSearchTodos(w http.ResponseWriter, r *http.Request) {
+	userID := userIDFrom(r.Context())
+	query := r.URL.Query().Get("q")
+	if query == "" {
+		writeError(w, http.StatusBadRequest, "q parameter is required")
+		return
+	}
+
+	limit := queryInt(r, "limit", 50)
+	offset := queryInt(r, "offset", 0)
+	if limit > 200 {
+		limit = 200
+	}
+
+	f, err := todoFilterFrom(r)
+	if err != nil {
+		writeError(w, http.StatusBadRequest, err.Error())
+		return
+	}
+	todos, total, err := a.dbFor(r).SearchTodos(userID, query, f, limit, offset)
+	if err != nil {
+		slog.Error("search todos", "error", err)
+		writeError(w, http.StatusInternalServerError, "internal error")
+		return
+	}
+	if todos == nil {
+		todos = []model.Todo{}
+	}
+
 	writeJSON(w, http.StatusOK, model.TodoListResponse{
 		Todos:  todos,
 		Total:  total,
@@ -43,7 +124,7 @@ func (a *API) handleGetTodo(w http.ResponseWriter, r *http.Request) {
 	userID := userIDFrom(r.Context())
 	id := r.PathValue("id")
 
-	todo, err := a.db.GetTodo(id, userID)
+	todo, err := a.dbFor(r).GetTodo(id, userID)
 	if errors.Is(err, database.ErrNotFound) {
 		writeError(w, http.StatusNotFound, "todo not found")
 		return
@@ -54,7 +135,7 @@ func (a *API) handleGetTodo(w http.ResponseWriter, r *http.Request) {
 		return
 	}
 
-	writeJSON(w, http.StatusOK, todo)
+	writeCached(w, r, todo.ModifiedAt, todo)
 }
 
 func (a *API) handleCreateTodo(w http.ResponseWriter, r *http.Request) {
@@ -76,6 +157,23 @@ func (a *API) handleCreateTodo(w http.ResponseWriter, r *http.Request) {
 		return
 	}
 
+	tags, err := normalizeTags(req.Tags)
+	if err != nil {
+		writeError(w, http.StatusBadRequest, err.Error())
+		return
+	}
+	if tags == nil {
+		tags = []string{}
+	}
+	checklist, err := normalizeChecklist(req.Checklist)
+	if err != nil {
+		writeError(w, http.StatusBadRequest, err.Error())
+		return
+	}
+	if checklist == nil {
+		checklist = []model.ChecklistItem{}
+	}
+
 	now := model.NowMillis()
 	todo := &model.Todo{
 		ID:               model.NewID(),
@@ -83,18 +181,25 @@ func (a *API) handleCreateTodo(w http.ResponseWriter, r *http.Request) {
 		NoteID:           req.NoteID,
 		LineRef:          req.LineRef,
 		Content:          req.Content,
-		DueDate:          req.DueDate,
+		ReminderAt:       req.ReminderAt,
 		Completed:        false,
+		Tags:             tags,
 		ModifiedAt:       now,
 		ModifiedByDevice: req.DeviceID,
 		CreatedAt:        now,
 	}
+	todo.SetDue(req.DueDate, req.AllDay)
+	todo.SetChecklist(checklist)
+	if !a.checkQuota(w, r, userID, usageDelta{todos: 1, contentBytes: todoBytes(todo)}) {
+		return
+	}
 
-	if err := a.db.CreateTodo(todo); err != nil {
+	if err := a.dbFor(r).CreateTodo(todo); err != nil {
 		slog.Error("create todo", "error", err)
 		writeError(w, http.StatusInternalServerError, "internal error")
 		return
 	}
+	a.bus.Publish(r.Context(), todoEvent(events.TodoCreated, userID, todo))
 
 	writeJSON(w, http.StatusCreated, todo)
 }
@@ -119,7 +224,18 @@ func (a *API) handleUpdateTodo(w http.ResponseWriter, r *http.Request) {
 		return
 	}
 
-	todo, err := a.db.GetTodo(id, userID)
+	tags, err := normalizeTags(req.Tags)
+	if err != nil {
+		writeError(w, http.StatusBadRequest, err.Error())
+		return
+	}
+	checklist, err := normalizeChecklist(req.Checklist)
+	if err != nil {
+		writeError(w, http.StatusBadRequest, err.Error())
+		return
+	}
+
+	todo, err := a.dbFor(r).GetTodo(id, userID)
 	if errors.Is(err, database.ErrNotFound) {
 		writeError(w, http.StatusNotFound, "todo not found")
 		return
@@ -130,11 +246,24 @@ func (a *API) handleUpdateTodo(w http.ResponseWriter, r *http.Request) {
 		return
 	}
 
+	prev := *todo
 	if req.Content != nil {
 		todo.Content = *req.Content
 	}
-	if req.DueDate != nil {
-		todo.DueDate = req.DueDate
+	if checklist != nil {
+		todo.SetChecklist(checklist)
+	}
+	if !a.checkQuota(w, r, userID, usageDelta{contentBytes: todoBytes(todo) - todoBytes(&prev)}) {
+		return
+	}
+	switch {
+	case req.DueDate != nil:
+		todo.SetDue(req.DueDate, req.AllDay)
+	case req.AllDay != nil:
+		todo.SetDue(todo.DueDate, req.AllDay)
+	}
+	if req.ReminderAt != nil {
+		todo.ReminderAt = req.ReminderAt
 	}
 	if req.Completed != nil {
 		todo.Completed = *req.Completed
@@ -145,14 +274,72 @@ func (a *API) handleUpdateTodo(w http.ResponseWriter, r *http.Request) {
 	if req.LineRef != nil {
 		todo.LineRef = req.LineRef
 	}
+	if tags != nil {
+		todo.Tags = tags
+	}
 	todo.ModifiedAt = model.NowMillis()
 	todo.ModifiedByDevice = req.DeviceID
 
-	if err := a.db.UpdateTodo(todo); err != nil {
+	if err := a.dbFor(r).UpdateTodo(todo); err != nil {
 		slog.Error("update todo", "error", err)
 		writeError(w, http.StatusInternalServerError, "internal error")
 		return
 	}
+	a.bus.Publish(r.Context(), todoEvent(todoKind(&prev, todo), userID, todo))
+
+	writeJSON(w, http.StatusOK, todo)
+}
+
+// handleMoveTodo attaches a todo to another of the user's notes at
+// line_ref, or detaches it when note_id is null or empty.
+func (a *API) handleMoveTodo(w http.ResponseWriter, r *http.Request) {
+	userID := userIDFrom(r.Context())
+	id := r.PathValue("id")
+
+	var req model.MoveTodoRequest
+	if err := decodeJSON(r, &req); err != nil {
+		writeError(w, http.StatusBadRequest, "invalid request body")
+		return
+	}
+	if req.DeviceID == "" {
+		writeError(w, http.StatusBadRequest, "device_id is required")
+		return
+	}
+
+	db := a.dbFor(r)
+	todo, err := db.GetTodo(id, userID)
+	if errors.Is(err, database.ErrNotFound) {
+		writeError(w, http.StatusNotFound, "todo not found")
+		return
+	}
+	if err != nil {
+		slog.Error("get todo for move", "error", err)
+		writeError(w, http.StatusInternalServerError, "internal error")
+		return
+	}
+
+	prev := *todo
+	todo.NoteID, todo.LineRef = nil, nil
+	if req.NoteID != nil && *req.NoteID != "" {
+		if _, err := db.GetNote(*req.NoteID, userID); errors.Is(err, database.ErrNotFound) {
+			writeError(w, http.StatusBadRequest, "target note not found")
+			return
+		} else if err != nil {
+			slog.Error("get note for move", "error", err)
+			writeError(w, http.StatusInternalServerError, "internal error")
+			return
+		}
+		todo.NoteID, todo.LineRef = req.NoteID, req.LineRef
+	}
+	todo.ModifiedAt = model.NowMillis()
+	todo.ModifiedByDevice = req.DeviceID
+
+	if err := db.UpdateTodo(todo); err != nil {
+		slog.Error("move todo", "error", err)
+		writeError(w, http.StatusInternalServerError, "internal error")
+		return
+	}
+	a.bus.Publish(r.Context(), todoEvent(todoKind(&prev, todo), userID, todo))
 
 	writeJSON(w, http.StatusOK, todo)
 }
@@ -163,7 +350,7 @@ func (a *API) handleDeleteTodo(w http.ResponseWriter, r *http.Request) {
 	deviceID := deviceIDFrom(r.Context())
 
 	now := model.NowMillis().UnixMilli()
-	err := a.db.DeleteTodo(id, userID, now, deviceID)
+	err := a.dbFor(r).DeleteTodo(id, userID, now, deviceID)
 	if errors.Is(err, database.ErrNotFound) {
 		writeError(w, http.StatusNotFound, "todo not found")
 		return
@@ -173,14 +360,24 @@ func (a *API) handleDeleteTodo(w http.ResponseWriter, r *http.Request) {
 		writeError(w, http.StatusInternalServerError, "internal error")
 		return
 	}
+	a.bus.Publish(r.Context(), itemEvent(events.TodoDeleted, userID, id, deviceID))
 
 	w.WriteHeader(http.StatusNoContent)
 }
 
+// handleGetOverdueTodos returns the incomplete todos that are overdue:
+// timed todos past their due time and all-day todos due before today,
+// which follows userLocation. An all-day todo due today is not overdue
+// until the user's day has ended.
 func (a *API) handleGetOverdueTodos(w http.ResponseWriter, r *http.Request) {
 	userID := userIDFrom(r.Context())
 
-	todos, err := a.db.GetOverdueTodos(userID)
+	loc, ok := a.userLocation(w, r)
+	if !ok {
+		return
+	}
+	now := model.NowMillis()
+	todos, err := a.dbFor(r).GetOverdueTodos(userID, now, dateOf(now, loc))
 	if err != nil {
 		slog.Error("get overdue todos", "error", err)
 		writeError(w, http.StatusInternalServerError, "internal error")
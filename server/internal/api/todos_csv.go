@@ -0,0 +1,250 @@
+package api
+
+import (
+	"encoding/csv"
+	"errors"
+	"fmt"
+	"io"
+	"log/slog"
+	"net/http"
+	"strings"
+	"time"
+	"unicode/utf8"
+
+	"github.com/c0dev0id/notesd/server/internal/events"
+	"github.com/c0dev0id/notesd/server/internal/model"
+)
+
+// todoCSVHeader is the column order written on export. Imports match
+// columns by name, so any order and extra columns are accepted. Todos have
+// no priority yet; the column is exported empty and ignored on import.
+var todoCSVHeader = []string{"content", "due", "completed", "priority", "tags", "note title"}
+
+// maxImportSize limits import bodies to 10MB.
+const maxImportSize = 10 << 20
+
+func (a *API) handleExportTodosCSV(w http.ResponseWriter, r *http.Request) {
+	userID := userIDFrom(r.Context())
+
+	todos, err := a.dbFor(r).GetAllTodos(userID)
+	if err != nil {
+		slog.Error("get todos for export", "error", err)
+		writeError(w, http.StatusInternalServerError, "internal error")
+		return
+	}
+	notes, err := a.dbFor(r).GetAllNotes(userID)
+	if err != nil {
+		slog.Error("get notes for export", "error", err)
+		writeError(w, http.StatusInternalServerError, "internal error")
+		return
+	}
+	titles := make(map[string]string, len(notes))
+	for _, n := range notes {
+		titles[n.ID] = n.Title
+	}
+
+	w.Header().Set("Content-Type", "text/csv; charset=utf-8")
+	w.Header().Set("Content-Disposition", `attachment; filename="todos.csv"`)
+	if err := writeTodosCSV(w, todos, titles); err != nil {
+		slog.Error("write todos csv", "error", err)
+	}
+}
+
+// writeTodosCSV writes todos with the note titles looked up by note ID.
+func writeTodosCSV(w io.Writer, todos []model.Todo, noteTitles map[string]string) error {
+	cw := csv.NewWriter(w)
+	if err := cw.Write(todoCSVHeader); err != nil {
+		return err
+	}
+	for _, t := range todos {
+		due := ""
+		switch {
+		case t.DueDate == nil:
+		case t.AllDay:
+			due = t.DueDate.UTC().Format(calendarDateLayout)
+		default:
+			due = t.DueDate.UTC().Format(time.RFC3339)
+		}
+		noteTitle := ""
+		if t.NoteID != nil {
+			noteTitle = noteTitles[*t.NoteID]
+		}
+		record := []string{
+			t.Content, due, fmt.Sprint(t.Completed), "", strings.Join(t.Tags, ","), noteTitle,
+		}
+		if err := cw.Write(record); err != nil {
+			return err
+		}
+	}
+	cw.Flush()
+	return cw.Error()
+}
+
+// csvTodo is one parsed import row.
+type csvTodo struct {
+	content   string
+	due       *time.Time
+	allDay    bool
+	completed bool
+	tags      []string
+	noteTitle string
+}
+
+// parseTodosCSV reads an import file. The first record is the header; a
+// content column is required. Errors name the offending line.
+func parseTodosCSV(r io.Reader) ([]csvTodo, error) {
+	cr := csv.NewReader(r)
+	cr.FieldsPerRecord = -1
+	cr.TrimLeadingSpace = true
+
+	header, err := cr.Read()
+	if errors.Is(err, io.EOF) {
+		return nil, errors.New("empty file")
+	}
+	if err != nil {
+		return nil, err
+	}
+	col := map[string]int{}
+	for i, name := range header {
+		col[strings.ToLower(strings.TrimSpace(name))] = i
+	}
+	if _, ok := col["content"]; !ok {
+		return nil, errors.New("missing content column")
+	}
+	field := func(rec []string, name string) string {
+		if i, ok := col[name]; ok && i < len(rec) {
+			return strings.TrimSpace(rec[i])
+		}
+		return ""
+	}
+
+	var rows []csvTodo
+	for {
+		rec, err := cr.Read()
+		if errors.Is(err, io.EOF) {
+			break
+		}
+		if err != nil {
+			return nil, err
+		}
+		line, _ := cr.FieldPos(0)
+
+		row := csvTodo{content: field(rec, "content"), noteTitle: field(rec, "note title")}
+		if row.content == "" {
+			return nil, fmt.Errorf("line %d: content is empty", line)
+		}
+		if utf8.RuneCountInString(row.content) > maxTodoContentLen {
+			return nil, fmt.Errorf("line %d: content too long", line)
+		}
+		if s := field(rec, "due"); s != "" {
+			due, allDay, err := parseCSVDate(s)
+			if err != nil {
+				return nil, fmt.Errorf("line %d: %w", line, err)
+			}
+			row.due, row.allDay = &due, allDay
+		}
+		switch strings.ToLower(field(rec, "completed")) {
+		case "", "false", "no", "0":
+		case "true", "yes", "x", "1":
+			row.completed = true
+		default:
+			return nil, fmt.Errorf("line %d: completed must be true or false", line)
+		}
+		if s := field(rec, "tags"); s != "" {
+			tags, err := normalizeTags(strings.Split(s, ","))
+			if err != nil {
+				return nil, fmt.Errorf("line %d: %w", line, err)
+			}
+			row.tags = tags
+		}
+		rows = append(rows, row)
+	}
+	return rows, nil
+}
+
+// parseCSVDate accepts RFC 3339 timestamps or plain dates, which are
+// all-day (UTC midnight).
+func parseCSVDate(s string) (due time.Time, allDay bool, err error) {
+	if t, err := time.Parse(time.RFC3339, s); err == nil {
+		return t.UTC().Truncate(time.Millisecond), false, nil
+	}
+	if t, err := time.Parse(calendarDateLayout, s); err == nil {
+		return t, true, nil
+	}
+	return time.Time{}, false, fmt.Errorf("invalid due date %q", s)
+}
+
+// handleImportTodosCSV creates one todo per CSV row. The whole file is
+// validated before anything is written, and all rows are inserted in one
+// transaction. A note title attaches the todo to the oldest note with that
+// title; unknown titles leave the todo standalone.
+func (a *API) handleImportTodosCSV(w http.ResponseWriter, r *http.Request) {
+	userID := userIDFrom(r.Context())
+	deviceID := deviceIDFrom(r.Context())
+
+	defer r.Body.Close()
+	rows, err := parseTodosCSV(io.LimitReader(r.Body, maxImportSize))
+	if err != nil {
+		writeError(w, http.StatusBadRequest, "invalid csv: "+err.Error())
+		return
+	}
+
+	notes, err := a.dbFor(r).GetAllNotes(userID)
+	if err != nil {
+		slog.Error("get notes for import", "error", err)
+		writeError(w, http.StatusInternalServerError, "internal error")
+		return
+	}
+	// GetAllNotes is newest first; iterate so the oldest note wins.
+	noteByTitle := map[string]string{}
+	for i := len(notes) - 1; i >= 0; i-- {
+		key := strings.ToLower(strings.TrimSpace(notes[i].Title))
+		if _, ok := noteByTitle[key]; !ok && key != "" {
+			noteByTitle[key] = notes[i].ID
+		}
+	}
+
+	now := model.NowMillis()
+	todos := make([]*model.Todo, 0, len(rows))
+	for _, row := range rows {
+		t := &model.Todo{
+			ID:               model.NewID(),
+			UserID:           userID,
+			Content:          row.content,
+			DueDate:          row.due,
+			AllDay:           row.allDay,
+			Completed:        row.completed,
+			Tags:             row.tags,
+			ModifiedAt:       now,
+			ModifiedByDevice: deviceID,
+			CreatedAt:        now,
+		}
+		if t.Tags == nil {
+			t.Tags = []string{}
+		}
+		if id, ok := noteByTitle[strings.ToLower(row.noteTitle)]; ok {
+			t.NoteID = &id
+		}
+		todos = append(todos, t)
+	}
+	d := usageDelta{todos: int64(len(todos))}
+	for _, t := range todos {
+		d.contentBytes += todoBytes(t)
+	}
+	if !a.checkQuota(w, r, userID, d) {
+		return
+	}
+
+	if err := a.dbFor(r).CreateTodos(todos); err != nil {
+		slog.Error("import todos", "error", err)
+		writeError(w, http.StatusInternalServerError, "internal error")
+		return
+	}
+	evs := make([]events.Event, 0, len(todos)+1)
+	for _, t := range todos {
+		evs = append(evs, bulk(todoEvent(events.TodoCreated, userID, t)))
+	}
+	a.bus.Publish(r.Context(), append(evs, resyncEvent(userID, deviceID))...)
+
+	writeJSON(w, http.StatusOK, model.ImportResult{Imported: len(todos)})
+}
@@ -0,0 +1,128 @@
+package api
+
+import (
+	"errors"
+	"log/slog"
+	"net/http"
+	"strings"
+	"time"
+	"unicode/utf8"
+
+	"github.com/c0dev0id/notesd/server/internal/database"
+	"github.com/c0dev0id/notesd/server/internal/model"
+)
+
+// accessTokenPrefix marks personal access tokens, so the auth middleware
+// can tell them from JWTs without parsing.
+const accessTokenPrefix = "notesd_pat_"
+
+const maxAccessTokenName = 100
+
+// lookupAccessToken resolves a personal access token, answering 401 and
+// returning nil when it is unknown or expired. Use is recorded at most
+// once a minute to keep writes off the read path.
+func (a *API) lookupAccessToken(w http.ResponseWriter, r *http.Request, token string) *model.AccessToken {
+	db := a.dbFor(r)
+	pat, err := db.GetAccessTokenByHash(database.HashToken(token))
+	if errors.Is(err, database.ErrNotFound) {
+		writeError(w, http.StatusUnauthorized, "invalid token")
+		return nil
+	}
+	if err != nil {
+		slog.Error("get access token", "error", err)
+		writeError(w, http.StatusInternalServerError, "internal error")
+		return nil
+	}
+	now := model.NowMillis()
+	if pat.ExpiresAt != nil && !pat.ExpiresAt.After(now) {
+		writeError(w, http.StatusUnauthorized, "token expired")
+		return nil
+	}
+	if pat.LastUsedAt == nil || now.Sub(*pat.LastUsedAt) > time.Minute {
+		if err := db.TouchAccessToken(pat.ID, now.UnixMilli()); err != nil {
+			slog.Error("touch access token", "error", err)
+		}
+	}
+	return pat
+}
+
+func (a *API) handleListAccessTokens(w http.ResponseWriter, r *http.Request) {
+	userID := userIDFrom(r.Context())
+	tokens, err := a.dbFor(r).ListAccessTokens(userID)
+	if err != nil {
+		slog.Error("list access tokens", "error", err)
+		writeError(w, http.StatusInternalServerError, "internal error")
+		return
+	}
+	writeJSON(w, http.StatusOK, tokens)
+}
+
+// handleCreateAccessToken issues a personal access token. The secret is
+// in this response only; the server keeps its hash.
+func (a *API) handleCreateAccessToken(w http.ResponseWriter, r *http.Request) {
+	userID := userIDFrom(r.Context())
+
+	var req model.CreateAccessTokenRequest
+	if err := decodeJSON(r, &req); err != nil {
+		writeError(w, http.StatusBadRequest, "invalid request body")
+		return
+	}
+	req.Name = strings.TrimSpace(req.Name)
+	if req.Name == "" {
+		writeError(w, http.StatusBadRequest, "name is required")
+		return
+	}
+	if utf8.RuneCountInString(req.Name) > maxAccessTokenName {
+		writeError(w, http.StatusBadRequest, "name too long")
+		return
+	}
+	switch req.Scope {
+	case "":
+		req.Scope = model.ScopeWrite
+	case model.ScopeRead, model.ScopeWrite:
+	default:
+		writeError(w, http.StatusBadRequest, "scope must be read or write")
+		return
+	}
+	now := model.NowMillis()
+	if req.ExpiresAt != nil {
+		if !req.ExpiresAt.After(now) {
+			writeError(w, http.StatusBadRequest, "expires_at must be in the future")
+			return
+		}
+		exp := req.ExpiresAt.UTC().Truncate(time.Millisecond)
+		req.ExpiresAt = &exp
+	}
+
+	t := model.AccessToken{
+		ID:        model.NewID(),
+		UserID:    userID,
+		Name:      req.Name,
+		Scope:     req.Scope,
+		Token:     accessTokenPrefix + newSlug(),
+		ExpiresAt: req.ExpiresAt,
+		CreatedAt: now,
+	}
+	if err := a.dbFor(r).CreateAccessToken(&t, database.HashToken(t.Token)); err != nil {
+		slog.Error("create access token", "error", err)
+		writeError(w, http.StatusInternalServerError, "internal error")
+		return
+	}
+	slog.Info("access token created", "user_id", userID, "token_id", t.ID, "scope", t.Scope)
+	writeJSON(w, http.StatusCreated, t)
+}
+
+func (a *API) handleDeleteAccessToken(w http.ResponseWriter, r *http.Request) {
+	userID := userIDFrom(r.Context())
+	err := a.dbFor(r).DeleteAccessToken(r.PathValue("id"), userID)
+	if errors.Is(err, database.ErrNotFound) {
+		writeError(w, http.StatusNotFound, "token not found")
+		return
+	}
+	if err != nil {
+		slog.Error("delete access token", "error", err)
+		writeError(w, http.StatusInternalServerError, "internal error")
+		return
+	}
+	w.WriteHeader(http.StatusNoContent)
+}
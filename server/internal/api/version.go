@@ -0,0 +1,98 @@
+package api
+
+import (
+	"net/http"
+	"runtime"
+
+	"github.com/c0dev0id/notesd/server/internal/database"
+	"github.com/c0dev0id/notesd/server/internal/model"
+	"github.com/c0dev0id/notesd/server/internal/version"
+)
+
+// capabilities lists optional features this server supports. Clients check
+// for a name before using the matching endpoints.
+func (a *API) capabilities() []string {
+	caps := []string{
+		"access_tokens",
+		"account",
+		"activity",
+		"attachments",
+		"audit_log",
+		"backlinks",
+		"bookmarks",
+		"bulk",
+		"calendar",
+		"calendar_feed",
+		"csv",
+		"delta_sync",
+		"due_filters",
+		"duplicates",
+		"export",
+		"graph",
+		"gzip",
+		"html",
+		"import",
+		"invites",
+		"journal",
+		"link_previews",
+		"live_sync",
+		"merge_patch",
+		"note_stats",
+		"note_feed",
+		"note_types",
+		"openapi",
+		"public_links",
+		"purge",
+		"reminders",
+		"search",
+		"search_syntax",
+		"snooze",
+		"sse",
+		"stats",
+		"sync_conflicts",
+		"sync_manifest",
+		"tags",
+		"todo_move",
+		"todo_search",
+		"todo_summary",
+		"usage",
+		"webdav",
+		"webhooks",
+	}
+	if a.mailer != nil {
+		caps = append(caps, "digest")
+	}
+	if a.history != nil {
+		caps = append(caps, "history")
+	}
+	if a.config.MailIn.Listen != "" {
+		caps = append(caps, "mail_in")
+	}
+	if a.telegram != nil {
+		caps = append(caps, "telegram")
+	}
+	if a.webPush != nil {
+		caps = append(caps, "web_push")
+	}
+	return caps
+}
+
+func (a *API) versionInfo() model.VersionInfo {
+	return model.VersionInfo{
+		Version:       version.Version,
+		Commit:        version.GetCommit(),
+		GoVersion:     runtime.Version(),
+		SchemaVersion: database.SchemaVersion,
+		Capabilities:  a.capabilities(),
+	}
+}
+
+func (a *API) handleVersion(w http.ResponseWriter, r *http.Request) {
+	writeJSON(w, http.StatusOK, a.versionInfo())
+}
+
+// handleNoteTypes lists the types notes may have, so that clients can
+// check a type before sending it.
+func (a *API) handleNoteTypes(w http.ResponseWriter, r *http.Request) {
+	writeJSON(w, http.StatusOK, model.NoteTypes{Types: a.config.Notes.NoteTypes()})
+}
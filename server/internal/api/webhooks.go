@@ -0,0 +1,258 @@
+package api
+
+import (
+	"context"
+	"crypto/hmac"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"log/slog"
+	"net/http"
+	"net/url"
+	"slices"
+	"strconv"
+	"time"
+
+	"github.com/c0dev0id/notesd/server/internal/database"
+	"github.com/c0dev0id/notesd/server/internal/events"
+	"github.com/c0dev0id/notesd/server/internal/model"
+	"github.com/c0dev0id/notesd/server/internal/netguard"
+)
+
+const (
+	webhookPollInterval  = 5 * time.Second
+	webhookBatchSize     = 100
+	maxWebhookAttempts   = 8
+	webhookRetryBase     = time.Minute
+	webhookHistory       = 7 * 24 * time.Hour
+	maxWebhooksPerUser   = 20
+	webhookDeliveryLimit = 50
+)
+
+// webhookPoster posts signed event deliveries; *push.Webhook in production.
+type webhookPoster interface {
+	Post(url string, payload []byte, header http.Header) (int, error)
+}
+
+func (a *API) handleListWebhooks(w http.ResponseWriter, r *http.Request) {
+	hooks, err := a.dbFor(r).ListWebhooks(userIDFrom(r.Context()))
+	if err != nil {
+		slog.Error("list webhooks", "error", err)
+		writeError(w, http.StatusInternalServerError, "internal error")
+		return
+	}
+	writeJSON(w, http.StatusOK, hooks)
+}
+
+// handleCreateWebhook registers a webhook and returns its signing secret,
+// which is not shown again.
+func (a *API) handleCreateWebhook(w http.ResponseWriter, r *http.Request) {
+	userID := userIDFrom(r.Context())
+
+	var req model.CreateWebhookRequest
+	if err := decodeJSON(r, &req); err != nil {
+		writeError(w, http.StatusBadRequest, "invalid request body")
+		return
+	}
+	u, err := url.Parse(req.URL)
+	if err != nil || (u.Scheme != "http" && u.Scheme != "https") || u.Host == "" {
+		writeError(w, http.StatusBadRequest, "url must be an http or https URL")
+		return
+	}
+	if !netguard.PublicHost(u) {
+		writeError(w, http.StatusBadRequest, "url must lead to a public address")
+		return
+	}
+	events := []string{}
+	for _, ev := range req.Events {
+		if !slices.Contains(model.WebhookEvents, ev) {
+			writeError(w, http.StatusBadRequest, "unknown event "+strconv.Quote(ev))
+			return
+		}
+		if !slices.Contains(events, ev) {
+			events = append(events, ev)
+		}
+	}
+
+	db := a.dbFor(r)
+	hooks, err := db.ListWebhooks(userID)
+	if err != nil {
+		slog.Error("list webhooks", "error", err)
+		writeError(w, http.StatusInternalServerError, "internal error")
+		return
+	}
+	if len(hooks) >= maxWebhooksPerUser {
+		writeError(w, http.StatusConflict, "too many webhooks")
+		return
+	}
+
+	hook := &model.Webhook{
+		ID:        model.NewID(),
+		URL:       req.URL,
+		Events:    events,
+		Secret:    "whsec_" + newSlug(),
+		CreatedAt: model.NowMillis(),
+	}
+	if err := db.CreateWebhook(userID, hook); err != nil {
+		slog.Error("create webhook", "error", err)
+		writeError(w, http.StatusInternalServerError, "internal error")
+		return
+	}
+	writeJSON(w, http.StatusCreated, hook)
+}
+
+func (a *API) handleDeleteWebhook(w http.ResponseWriter, r *http.Request) {
+	err := a.dbFor(r).DeleteWebhook(r.PathValue("id"), userIDFrom(r.Context()))
+	if errors.Is(err, database.ErrNotFound) {
+		writeError(w, http.StatusNotFound, "webhook not found")
+		return
+	}
+	if err != nil {
+		slog.Error("delete webhook", "error", err)
+		writeError(w, http.StatusInternalServerError, "internal error")
+		return
+	}
+	w.WriteHeader(http.StatusNoContent)
+}
+
+// handleListWebhookDeliveries returns the newest deliveries of a webhook,
+// for debugging a receiver.
+func (a *API) handleListWebhookDeliveries(w http.ResponseWriter, r *http.Request) {
+	limit := queryInt(r, "limit", webhookDeliveryLimit)
+	if limit > 200 {
+		limit = 200
+	}
+	deliveries, err := a.dbFor(r).ListWebhookDeliveries(r.PathValue("id"), userIDFrom(r.Context()), limit)
+	if errors.Is(err, database.ErrNotFound) {
+		writeError(w, http.StatusNotFound, "webhook not found")
+		return
+	}
+	if err != nil {
+		slog.Error("list webhook deliveries", "error", err)
+		writeError(w, http.StatusInternalServerError, "internal error")
+		return
+	}
+	writeJSON(w, http.StatusOK, deliveries)
+}
+
+// webhookEvents maps domain events to the webhook events they send.
+var webhookEvents = map[events.Kind]string{
+	events.NoteCreated:   model.EventNoteCreated,
+	events.NoteUpdated:   model.EventNoteUpdated,
+	events.NoteRestored:  model.EventNoteUpdated,
+	events.NoteDeleted:   model.EventNoteDeleted,
+	events.NotePurged:    model.EventNoteDeleted,
+	events.TodoCreated:   model.EventTodoCreated,
+	events.TodoUpdated:   model.EventTodoUpdated,
+	events.TodoRestored:  model.EventTodoUpdated,
+	events.TodoCompleted: model.EventTodoCompleted,
+	events.TodoDeleted:   model.EventTodoDeleted,
+	events.TodoPurged:    model.EventTodoDeleted,
+}
+
+// queueWebhooks is the bus subscriber that queues events for the user's
+// webhooks subscribed to them and wakes the delivery worker. Bulk changes
+// and changes to deleted items send none.
+func (a *API) queueWebhooks(ctx context.Context, evs []events.Event) {
+	queued := false
+	for _, ev := range evs {
+		event := webhookEvents[ev.Kind]
+		if event == "" || ev.Bulk {
+			continue
+		}
+		p := model.WebhookPayload{Event: event, ID: ev.ID, DeviceID: ev.DeviceID, At: ev.At}
+		if event != model.EventNoteDeleted && event != model.EventTodoDeleted {
+			if (ev.Note != nil && ev.Note.DeletedAt != nil) || (ev.Todo != nil && ev.Todo.DeletedAt != nil) {
+				continue
+			}
+			p.Note, p.Todo = ev.Note, ev.Todo
+		}
+		payload, err := json.Marshal(p)
+		if err != nil {
+			slog.Error("marshal webhook payload", "error", err)
+			continue
+		}
+		n, err := a.db.WithContext(ctx).QueueWebhookEvent(ev.UserID, event, payload, ev.At)
+		if err != nil {
+			slog.Error("queue webhook event", "event", event, "error", err)
+			continue
+		}
+		queued = queued || n > 0
+	}
+	if queued {
+		select {
+		case a.webhookWake <- struct{}{}:
+		default:
+		}
+	}
+}
+
+// RunWebhooks delivers queued webhook events until ctx is cancelled. It
+// wakes when an event is queued and every few seconds for retries, and
+// prunes old delivery history hourly.
+func (a *API) RunWebhooks(ctx context.Context) {
+	poll := time.NewTicker(webhookPollInterval)
+	defer poll.Stop()
+	prune := time.NewTicker(time.Hour)
+	defer prune.Stop()
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case <-a.webhookWake:
+			a.deliverWebhooks(time.Now())
+		case now := <-poll.C:
+			a.deliverWebhooks(now)
+		case now := <-prune.C:
+			if _, err := a.db.PruneWebhookDeliveries(now.Add(-webhookHistory)); err != nil {
+				slog.Error("prune webhook deliveries", "error", err)
+			}
+		}
+	}
+}
+
+// deliverWebhooks attempts every delivery due at now. Failed attempts are
+// retried with exponential backoff until maxWebhookAttempts.
+func (a *API) deliverWebhooks(now time.Time) {
+	due, err := a.db.DueWebhookDeliveries(now, webhookBatchSize)
+	if err != nil {
+		slog.Error("due webhook deliveries", "error", err)
+		return
+	}
+	for _, d := range due {
+		sentAt := time.Now()
+		code, err := a.webhookPoster.Post(d.URL, d.Payload, signWebhook(d, sentAt))
+		status, errMsg, next := database.DeliveryDelivered, "", (*time.Time)(nil)
+		if err != nil {
+			errMsg = err.Error()
+			if d.Attempts+1 >= maxWebhookAttempts {
+				status = database.DeliveryFailed
+				slog.Warn("webhook delivery failed", "delivery", d.ID, "event", d.Event, "error", err)
+			} else {
+				status = database.DeliveryPending
+				t := sentAt.Add(webhookRetryBase << d.Attempts)
+				next = &t
+			}
+		}
+		if err := a.db.RecordWebhookAttempt(d.ID, status, code, errMsg, sentAt, next); err != nil {
+			slog.Error("record webhook attempt", "delivery", d.ID, "error", err)
+		}
+	}
+}
+
+// signWebhook returns the headers of a delivery. The signature is the hex
+// HMAC-SHA256, keyed with the webhook's secret, of the timestamp, a dot and
+// the body, so receivers can reject replays of old deliveries.
+func signWebhook(d database.DueDelivery, at time.Time) http.Header {
+	ts := strconv.FormatInt(at.Unix(), 10)
+	mac := hmac.New(sha256.New, []byte(d.Secret))
+	mac.Write([]byte(ts + "."))
+	mac.Write(d.Payload)
+	h := http.Header{}
+	h.Set("X-Notesd-Event", d.Event)
+	h.Set("X-Notesd-Delivery", d.ID)
+	h.Set("X-Notesd-Timestamp", ts)
+	h.Set("X-Notesd-Signature", "sha256="+hex.EncodeToString(mac.Sum(nil)))
+	return h
+}
@@ -0,0 +1,33 @@
+package api
+
+import (
+	"embed"
+	"io/fs"
+	"net/http"
+)
+
+// webUIFiles is the minimal browser client served at / when
+// server.web_ui is set. It uses the REST API like any other client.
+//
+//go:embed webui
+var webUIFiles embed.FS
+
+var webUI = http.FileServerFS(must(fs.Sub(webUIFiles, "webui")))
+
+func must[T any](v T, err error) T {
+	if err != nil {
+		panic(err)
+	}
+	return v
+}
+
+// handleWebUI serves the embedded client's files. They carry no
+// modification time, so browsers are told to revalidate, and the page may
+// only load its own scripts and styles and talk to this server.
+func (a *API) handleWebUI(w http.ResponseWriter, r *http.Request) {
+	h := w.Header()
+	h.Set("Cache-Control", "no-cache")
+	h.Set("Content-Security-Policy", "default-src 'self'; frame-ancestors 'none'")
+	h.Set("X-Content-Type-Options", "nosniff")
+	webUI.ServeHTTP(w, r)
+}
@@ -0,0 +1,251 @@
+// Minimal web client embedded in the server: log in, edit notes as
+// Markdown, check off todos and look back over recent changes. It talks to the REST API directly and keeps
+// nothing but the session in the browser; the full offline client lives in
+// web/.
+'use strict';
+
+const API = '/api/v1';
+const $ = (id) => document.getElementById(id);
+
+let session = JSON.parse(localStorage.getItem('notesd.session') || 'null');
+let notes = [];
+let current = null;
+
+function deviceID() {
+	let id = localStorage.getItem('notesd.device');
+	if (!id) {
+		// crypto.randomUUID needs HTTPS; a server on the LAN may not have it.
+		const bytes = crypto.getRandomValues(new Uint8Array(4));
+		id = 'web-' + [...bytes].map((b) => b.toString(16).padStart(2, '0')).join('');
+		localStorage.setItem('notesd.device', id);
+	}
+	return id;
+}
+
+function saveSession(s) {
+	session = s;
+	if (s) {
+		localStorage.setItem('notesd.session', JSON.stringify(s));
+	} else {
+		localStorage.removeItem('notesd.session');
+	}
+}
+
+// Requests that fail with 401 at the same time share one refresh: the
+// server takes a second use of a refresh token as theft.
+let refreshing = null;
+
+function refresh() {
+	if (!refreshing) {
+		refreshing = fetch(API + '/auth/refresh', {
+			method: 'POST',
+			headers: { 'Content-Type': 'application/json' },
+			body: JSON.stringify({ refresh_token: session.refresh_token })
+		})
+			.then(async (resp) => {
+				if (!resp.ok) {
+					saveSession(null);
+					return false;
+				}
+				saveSession(await resp.json());
+				return true;
+			})
+			.finally(() => (refreshing = null));
+	}
+	return refreshing;
+}
+
+async function api(method, path, body) {
+	const send = () =>
+		fetch(API + path, {
+			method,
+			headers: {
+				'Content-Type': 'application/json',
+				Authorization: 'Bearer ' + (session ? session.access_token : '')
+			},
+			body: body ? JSON.stringify(body) : undefined
+		});
+	let resp = await send();
+	if (resp.status === 401 && session && (await refresh())) {
+		resp = await send();
+	}
+	if (resp.status === 401) {
+		saveSession(null);
+		show();
+		throw new Error('session expired');
+	}
+	if (resp.status === 204) return null;
+	const data = await resp.json();
+	if (!resp.ok) throw new Error(data.error || 'HTTP ' + resp.status);
+	return data;
+}
+
+function fail(err) {
+	$('error').textContent = err.message;
+}
+
+// show displays the login form or the page named by the location hash.
+function show() {
+	$('error').textContent = '';
+	$('login').hidden = !!session;
+	$('app').hidden = !session;
+	if (!session) return;
+
+	$('user').textContent = session.user.email;
+	const pages = { notes: loadNotes, todos: loadTodos, activity: loadActivity };
+	const page = location.hash.slice(1) in pages ? location.hash.slice(1) : 'notes';
+	for (const p of Object.keys(pages)) {
+		$(p).hidden = p !== page;
+		document.querySelector(`nav a[href="#${p}"]`).classList.toggle('active', p === page);
+	}
+	pages[page]().catch(fail);
+}
+
+async function loadNotes() {
+	const data = await api('GET', '/notes?limit=200');
+	notes = data.notes;
+	const list = $('note-list');
+	list.replaceChildren(
+		...notes.map((n) => {
+			const li = document.createElement('li');
+			li.textContent = n.title || '(untitled)';
+			li.classList.toggle('active', current !== null && n.id === current.id);
+			li.onclick = () => openNote(n);
+			return li;
+		})
+	);
+}
+
+function openNote(n) {
+	current = n;
+	const form = $('note');
+	form.hidden = false;
+	form.elements.title.value = n.title;
+	form.elements.content.value = n.content;
+	$('note-status').textContent = n.id ? 'Modified ' + new Date(n.modified_at).toLocaleString() : 'New note';
+	for (const [i, li] of [...$('note-list').children].entries()) {
+		li.classList.toggle('active', notes[i] === n);
+	}
+}
+
+async function saveNote(e) {
+	e.preventDefault();
+	const form = $('note');
+	const body = { title: form.elements.title.value, content: form.elements.content.value, device_id: deviceID() };
+	current = current.id
+		? await api('PUT', '/notes/' + current.id, body)
+		: await api('POST', '/notes', { ...body, type: 'note' });
+	$('note-status').textContent = 'Saved';
+	await loadNotes();
+}
+
+async function loadTodos() {
+	const data = await api('GET', '/todos?limit=200');
+	const todos = data.todos.sort((a, b) => a.completed - b.completed);
+	$('todo-list').replaceChildren(
+		...todos.map((t) => {
+			const li = document.createElement('li');
+			li.classList.toggle('done', t.completed);
+			const box = document.createElement('input');
+			box.type = 'checkbox';
+			box.checked = t.completed;
+			box.onchange = () =>
+				api('PUT', '/todos/' + t.id, { completed: box.checked, device_id: deviceID() })
+					.then(loadTodos)
+					.catch(fail);
+			const text = document.createElement('span');
+			text.textContent = t.content;
+			li.append(box, text);
+			if (t.due_date) {
+				const due = document.createElement('small');
+				due.textContent = new Date(t.due_date).toLocaleDateString();
+				li.append(due);
+			}
+			return li;
+		})
+	);
+}
+
+const activityPage = 50;
+const activityVerbs = {
+	create: 'Created',
+	update: 'Edited',
+	complete: 'Completed',
+	delete: 'Deleted',
+	restore: 'Restored',
+	purge: 'Purged'
+};
+
+// loadActivity shows the newest changes, or with more the page after those
+// already listed.
+async function loadActivity(more) {
+	const list = $('activity-list');
+	const offset = more ? list.children.length : 0;
+	const data = await api('GET', `/activity?limit=${activityPage}&offset=${offset}`);
+	const items = data.entries.map((e) => {
+		const li = document.createElement('li');
+		const at = document.createElement('time');
+		at.dateTime = e.at;
+		at.textContent = new Date(e.at).toLocaleString();
+		const action = document.createElement('span');
+		action.className = 'action';
+		action.textContent = activityVerbs[e.action] || e.action;
+		const title = document.createElement('span');
+		title.textContent = (e.entity_type === 'todo' ? 'Todo: ' : '') + (e.title || '(untitled)');
+		title.classList.toggle('deleted', e.action === 'delete' || e.action === 'purge');
+		li.append(at, action, title);
+		return li;
+	});
+	if (more) {
+		list.append(...items);
+	} else {
+		list.replaceChildren(...items);
+	}
+	$('more-activity').hidden = list.children.length >= data.total;
+}
+
+$('login').onsubmit = async (e) => {
+	e.preventDefault();
+	const form = e.target;
+	const resp = await fetch(API + '/auth/login', {
+		method: 'POST',
+		headers: { 'Content-Type': 'application/json' },
+		body: JSON.stringify({ email: form.elements.email.value, password: form.elements.password.value, device_id: deviceID() })
+	});
+	const data = await resp.json();
+	if (!resp.ok) {
+		$('login-error').textContent = data.error || 'HTTP ' + resp.status;
+		return;
+	}
+	form.elements.password.value = '';
+	$('login-error').textContent = '';
+	saveSession(data);
+	show();
+};
+
+$('logout').onclick = async () => {
+	await api('POST', '/auth/logout').catch(() => {});
+	saveSession(null);
+	current = null;
+	$('note').hidden = true;
+	show();
+};
+
+$('new-note').onclick = () => openNote({ title: '', content: '' });
+$('note').onsubmit = (e) => saveNote(e).catch(fail);
+$('new-todo').onsubmit = async (e) => {
+	e.preventDefault();
+	const input = e.target.elements.content;
+	try {
+		await api('POST', '/todos', { content: input.value, device_id: deviceID() });
+		input.value = '';
+		await loadTodos();
+	} catch (err) {
+		fail(err);
+	}
+};
+
+$('more-activity').onclick = () => loadActivity(true).catch(fail);
+
+window.onhashchange = show;
+show();
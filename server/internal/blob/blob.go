@@ -0,0 +1,75 @@
+// Package blob stores attachment contents as plain files in one directory,
+// named by attachment ID. Metadata lives in the database; this package only
+// moves bytes.
+package blob
+
+import (
+	"crypto/sha256"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"io"
+	"os"
+	"path/filepath"
+)
+
+// ErrTooLarge is returned by Put when the content exceeds the size limit.
+var ErrTooLarge = errors.New("blob too large")
+
+type Store struct {
+	dir string
+}
+
+// Open returns a store rooted at dir, creating the directory if needed.
+func Open(dir string) (*Store, error) {
+	if err := os.MkdirAll(dir, 0700); err != nil {
+		return nil, fmt.Errorf("create blob dir: %w", err)
+	}
+	return &Store{dir: dir}, nil
+}
+
+// Put writes r to the blob id, reading at most max bytes. It returns the
+// size and hex SHA-256 of the content. The blob appears atomically: readers
+// never see a partial file.
+func (s *Store) Put(id string, r io.Reader, max int64) (int64, string, error) {
+	f, err := os.CreateTemp(s.dir, ".upload-*")
+	if err != nil {
+		return 0, "", fmt.Errorf("create temp blob: %w", err)
+	}
+	defer os.Remove(f.Name()) // no-op after the rename
+
+	h := sha256.New()
+	n, err := io.Copy(io.MultiWriter(f, h), io.LimitReader(r, max+1))
+	if err == nil && n > max {
+		err = ErrTooLarge
+	}
+	if cerr := f.Close(); err == nil {
+		err = cerr
+	}
+	if err != nil {
+		return 0, "", err
+	}
+	if err := os.Rename(f.Name(), s.path(id)); err != nil {
+		return 0, "", fmt.Errorf("store blob: %w", err)
+	}
+	return n, hex.EncodeToString(h.Sum(nil)), nil
+}
+
+// Open returns the content of blob id.
+func (s *Store) Open(id string) (*os.File, error) {
+	return os.Open(s.path(id))
+}
+
+// Remove deletes blob id. Removing a missing blob is not an error.
+func (s *Store) Remove(id string) error {
+	if err := os.Remove(s.path(id)); err != nil && !errors.Is(err, os.ErrNotExist) {
+		return err
+	}
+	return nil
+}
+
+// path maps an ID to its file. IDs are server-generated UUIDs, but Base
+// keeps a malformed one from escaping the directory.
+func (s *Store) path(id string) string {
+	return filepath.Join(s.dir, filepath.Base(id))
+}
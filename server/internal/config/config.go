@@ -1,63 +1,460 @@
 package config
 
 import (
+	"encoding/base64"
+	"errors"
 	"fmt"
+	"io"
+	"io/fs"
+	"log/slog"
+	"net/url"
 	"os"
 	"path/filepath"
+	"slices"
+	"strconv"
+	"strings"
 
 	"github.com/BurntSushi/toml"
 )
 
 type Config struct {
-	Server   ServerConfig   `toml:"server"`
-	Database DatabaseConfig `toml:"database"`
-	Auth     AuthConfig     `toml:"auth"`
+	Server      ServerConfig      `toml:"server"`
+	Database    DatabaseConfig    `toml:"database"`
+	Auth        AuthConfig        `toml:"auth"`
+	SMTP        SMTPConfig        `toml:"smtp"`
+	MailIn      MailInConfig      `toml:"mail_in"`
+	Telegram    TelegramConfig    `toml:"telegram"`
+	Admin       AdminConfig       `toml:"admin"`
+	Log         LogConfig         `toml:"log"`
+	Attachments AttachmentsConfig `toml:"attachments"`
+	Push        PushConfig        `toml:"push"`
+	Sync        SyncConfig        `toml:"sync"`
+	Tracing     TracingConfig     `toml:"tracing"`
+	Backup      BackupConfig      `toml:"backup"`
+	RateLimit   RateLimitConfig   `toml:"rate_limit"`
+	Quota       QuotaConfig       `toml:"quota"`
+	Audit       AuditConfig       `toml:"audit"`
+	History     HistoryConfig     `toml:"history"`
+	Notes       NotesConfig       `toml:"notes"`
+	Unfurl      UnfurlConfig      `toml:"unfurl"`
 }
 
 type ServerConfig struct {
-	Listen string `toml:"listen"`
+	// Listen is a TCP address such as "127.0.0.1:8080", or
+	// "unix:/run/notesd.sock" to listen on a Unix socket created with
+	// SocketMode, e.g. "0660".
+	Listen     string `toml:"listen"`
+	SocketMode string `toml:"socket_mode"`
+	// TrustedProxies lists proxy addresses or CIDR ranges whose
+	// X-Forwarded-For header is believed when determining the client IP.
+	TrustedProxies []string `toml:"trusted_proxies"`
+	// CORSOrigins lists the browser origins allowed to call the API from
+	// other sites, e.g. "https://notes.example.com" or
+	// "https://*.example.com"; "*" allows any origin without credentials.
+	// Empty allows same-origin requests only.
+	CORSOrigins []string `toml:"cors_origins"`
+	// SwaggerUI serves an interactive API browser at /api/docs.
+	SwaggerUI bool `toml:"swagger_ui"`
+	// WebUI serves a minimal browser client for notes and todos at /.
+	WebUI bool      `toml:"web_ui"`
+	TLS   TLSConfig `toml:"tls"`
+	// RequestTimeout bounds the handling of a request and
+	// LongRequestTimeout that of sync, import, export and backup requests,
+	// e.g. "15s"; empty or "0" disables the limit. A request over it is
+	// answered with 503.
+	RequestTimeout     string `toml:"request_timeout"`
+	LongRequestTimeout string `toml:"long_request_timeout"`
 }
 
+// UnixSocket returns the socket path when Listen names a Unix socket.
+func (c ServerConfig) UnixSocket() (string, bool) {
+	return strings.CutPrefix(c.Listen, "unix:")
+}
+
+// SocketFileMode returns the permissions of the Unix socket. validate
+// checks that SocketMode parses.
+func (c ServerConfig) SocketFileMode() os.FileMode {
+	m, _ := strconv.ParseUint(c.SocketMode, 8, 32)
+	return os.FileMode(m)
+}
+
+// TLSConfig makes the server speak HTTPS on Listen, either with the
+// certificate in CertFile/KeyFile or with certificates obtained from an
+// ACME CA (Let's Encrypt by default) for the names in ACMEHosts. Certificates
+// from ACME are cached in ACMECacheDir. RedirectListen, e.g. ":80", opens a
+// plain HTTP listener that redirects to HTTPS and answers ACME http-01
+// challenges.
+type TLSConfig struct {
+	CertFile       string   `toml:"cert_file"`
+	KeyFile        string   `toml:"key_file"`
+	ACMEHosts      []string `toml:"acme_hosts"`
+	ACMEEmail      string   `toml:"acme_email"`
+	ACMECacheDir   string   `toml:"acme_cache_dir"`
+	ACMEDirectory  string   `toml:"acme_directory"`
+	RedirectListen string   `toml:"redirect_listen"`
+}
+
+// Enabled reports whether the server serves HTTPS.
+func (c TLSConfig) Enabled() bool {
+	return c.CertFile != "" || len(c.ACMEHosts) > 0
+}
+
+// DatabaseConfig locates the SQLite database and sizes its connection
+// pool. MaxOpenConns 0 is unlimited and MaxIdleConns 0 keeps Go's default
+// of two; ConnMaxLifetime is a duration such as "1h", empty or "0" for no
+// limit. SerializeWrites runs one write at a time, which avoids SQLITE_BUSY
+// errors under concurrent sync pushes. MaintenanceInterval is how often the
+// WAL is checkpointed, free pages are vacuumed and the database is checked
+// for corruption, e.g. "24h"; empty or "0" disables the job.
 type DatabaseConfig struct {
-	Path string `toml:"path"`
+	Path                string `toml:"path"`
+	MaxOpenConns        int    `toml:"max_open_conns"`
+	MaxIdleConns        int    `toml:"max_idle_conns"`
+	ConnMaxLifetime     string `toml:"conn_max_lifetime"`
+	SerializeWrites     bool   `toml:"serialize_writes"`
+	MaintenanceInterval string `toml:"maintenance_interval"`
+	// EncryptionKey, the base64 of 32 random bytes, encrypts note and todo
+	// text in the database file. PreviousEncryptionKeys still decrypt text
+	// stored under earlier keys, which is rewritten at startup under
+	// EncryptionKey, or stored plain when it is empty.
+	EncryptionKey          string   `toml:"encryption_key"`
+	PreviousEncryptionKeys []string `toml:"previous_encryption_keys"`
+}
+
+// ContentKeys decodes EncryptionKey, nil when it is empty, and
+// PreviousEncryptionKeys.
+func (c DatabaseConfig) ContentKeys() (key []byte, previous [][]byte, err error) {
+	decode := func(name, s string) ([]byte, error) {
+		b, err := base64.StdEncoding.DecodeString(s)
+		if err != nil || len(b) != 32 {
+			return nil, fmt.Errorf("database.%s must be the base64 of 32 bytes", name)
+		}
+		return b, nil
+	}
+	if c.EncryptionKey != "" {
+		if key, err = decode("encryption_key", c.EncryptionKey); err != nil {
+			return nil, nil, err
+		}
+	}
+	for _, s := range c.PreviousEncryptionKeys {
+		b, err := decode("previous_encryption_keys", s)
+		if err != nil {
+			return nil, nil, err
+		}
+		previous = append(previous, b)
+	}
+	return key, previous, nil
 }
 
 type AuthConfig struct {
-	PrivateKeyPath      string `toml:"private_key"`
-	AccessTokenExpiry   string `toml:"access_token_expiry"`
-	RefreshTokenExpiry  string `toml:"refresh_token_expiry"`
+	PrivateKeyPath     string `toml:"private_key"`
+	AccessTokenExpiry  string `toml:"access_token_expiry"`
+	RefreshTokenExpiry string `toml:"refresh_token_expiry"`
+	// KeyRotation is how often a new signing key replaces the current one,
+	// e.g. "2160h"; empty never rotates. Old keys keep verifying until the
+	// tokens they signed have expired.
+	KeyRotation string `toml:"key_rotation"`
+	// SigningAlgorithm is RS256 or EdDSA (Ed25519). Changing it adds a key
+	// of the new kind; tokens signed with the old one stay valid.
+	SigningAlgorithm string `toml:"signing_algorithm"`
+	// ResetURL is the web client page that completes a password reset; the
+	// mailed link is this URL with ?token= appended. Password reset is off
+	// while it or smtp.host is empty.
+	ResetURL string `toml:"reset_url"`
+	// Registration is "open", "invite" (an admin-issued invite code is
+	// required) or "closed". Accounts in admin.emails can always register.
+	Registration string `toml:"registration"`
+}
+
+// SMTPConfig configures outgoing mail. Mail is disabled while Host is empty.
+type SMTPConfig struct {
+	Host     string `toml:"host"`
+	Port     int    `toml:"port"`
+	Username string `toml:"username"`
+	Password string `toml:"password"`
+	From     string `toml:"from"`
+}
+
+// MailInConfig runs an SMTP server on Listen, e.g. ":25", that turns mail
+// sent to a user's secret address at Domain into a note. The MX record of
+// Domain must point at this server. MaxSize caps a message, in bytes.
+// Mail-in is off while Listen is empty.
+type MailInConfig struct {
+	Listen  string `toml:"listen"`
+	Domain  string `toml:"domain"`
+	MaxSize int64  `toml:"max_size"`
+}
+
+// TelegramConfig runs a Telegram bot with the token from @BotFather. Users
+// link a chat with a one-time code, then send it notes and todos and get
+// their reminders there. The bot polls Telegram, so the server needs no
+// public address. APIURL is the Bot API server. The bot is off while Token
+// is empty.
+type TelegramConfig struct {
+	Token  string `toml:"token"`
+	APIURL string `toml:"api_url"`
+}
+
+// LogConfig controls the server's logs. Format is "text" or "json" and
+// Level the lowest level logged: "debug", "info", "warn" or "error".
+// AccessLog is empty to write access lines to the application log, "off" to
+// disable them, or a file path, written in the same format. AccessFields
+// selects the logged fields; empty means all of them.
+//
+// SlowRequest, a duration such as "1s", logs a warning with the route, user
+// and database time of every request taking at least as long; empty or "0"
+// disables it.
+type LogConfig struct {
+	Format       string   `toml:"format"`
+	Level        string   `toml:"level"`
+	AccessLog    string   `toml:"access_log"`
+	AccessFields []string `toml:"access_fields"`
+	SlowRequest  string   `toml:"slow_request"`
+}
+
+// Handler returns a slog handler writing records of Level and above to w
+// in Format. An empty Level logs info and above.
+func (c LogConfig) Handler(w io.Writer) slog.Handler {
+	var level slog.Level
+	level.UnmarshalText([]byte(c.Level)) // checked by validate
+	opts := &slog.HandlerOptions{Level: level}
+	if c.Format == "json" {
+		return slog.NewJSONHandler(w, opts)
+	}
+	return slog.NewTextHandler(w, opts)
+}
+
+// AttachmentsConfig sets where note attachments are stored and how large a
+// single upload may be, in bytes.
+type AttachmentsConfig struct {
+	Dir     string `toml:"dir"`
+	MaxSize int64  `toml:"max_size"`
+}
+
+// PushConfig configures Web Push for reminders. Web Push is disabled while
+// Subject (a mailto: or https: contact URL required by push services) is
+// empty. The VAPID key is generated on first start if missing.
+type PushConfig struct {
+	VAPIDKeyPath string `toml:"vapid_key"`
+	Subject      string `toml:"subject"`
+}
+
+// SyncConfig controls tombstone garbage collection and pushes. Deleted
+// items are removed for good once TombstoneRetention has passed; empty or
+// "0" keeps them forever. MaxPushSize limits the body of a sync push, in
+// bytes; 0 allows 64 MB. PubSub is the URL of a broker (redis:// or
+// rediss://) through which servers sharing the database pass live sync
+// events to each other; empty keeps them within the process.
+type SyncConfig struct {
+	TombstoneRetention string `toml:"tombstone_retention"`
+	MaxPushSize        int64  `toml:"max_push_size"`
+	PubSub             string `toml:"pubsub"`
+}
+
+// TracingConfig sends OpenTelemetry spans of requests and SQL statements to
+// an OTLP/HTTP collector. Tracing is off while Endpoint is empty.
+// SampleRatio is the share of traces started here that are recorded;
+// requests with a traceparent header follow the caller's decision.
+type TracingConfig struct {
+	Endpoint    string            `toml:"endpoint"`
+	SampleRatio float64           `toml:"sample_ratio"`
+	Headers     map[string]string `toml:"headers"`
+}
+
+// BackupConfig controls database snapshots. Dir receives the snapshots
+// written by POST /api/v1/admin/backup and by the scheduler, which runs every
+// Interval ("" or "0" disables it). Only the newest Keep snapshots are
+// retained; 0 keeps all. An empty Dir disables backups.
+type BackupConfig struct {
+	Dir      string `toml:"dir"`
+	Interval string `toml:"interval"`
+	Keep     int    `toml:"keep"`
+}
+
+// RateLimitConfig bounds request rates with fixed windows. Auth counts
+// register, login, refresh and password requests per client IP (see
+// server.trusted_proxies); Writes counts authenticated POST, PUT and DELETE
+// requests per user. A limit of 0 disables it. Backend "memory" counts in
+// each server process; "redis" counts in the Redis server at Redis
+// (redis:// or rediss://), so servers sharing it enforce the limits
+// together.
+type RateLimitConfig struct {
+	Auth         int    `toml:"auth"`
+	AuthWindow   string `toml:"auth_window"`
+	Writes       int    `toml:"writes"`
+	WritesWindow string `toml:"writes_window"`
+	Backend      string `toml:"backend"`
+	Redis        string `toml:"redis"`
+}
+
+// QuotaConfig limits what each account may store. Content counts the bytes
+// of note titles and contents and todo texts; deleted items do not count.
+// A limit of 0 means unlimited.
+type QuotaConfig struct {
+	Notes           int64 `toml:"notes"`
+	Todos           int64 `toml:"todos"`
+	ContentBytes    int64 `toml:"content_bytes"`
+	AttachmentBytes int64 `toml:"attachment_bytes"`
+}
+
+// AuditConfig controls the audit log of note and todo changes. Entries are
+// removed once Retention has passed; empty or "0" keeps them forever.
+type AuditConfig struct {
+	Retention string `toml:"retention"`
+}
+
+// HistoryConfig keeps every version of every note as a commit in the bare
+// git repository GitDir, one <user id>/<note id>.md file per note, which
+// needs the git command. Remote, a remote configured in that repository or
+// a URL, receives the history with git push every minute; empty keeps it
+// local. History is off while GitDir is empty.
+type HistoryConfig struct {
+	GitDir string `toml:"git_dir"`
+	Remote string `toml:"remote"`
+}
+
+// NotesConfig controls what deleting a note does to the todos attached to
+// it. DeleteTodos is "keep" (they stay, pointing at the deleted note),
+// "delete" (they are deleted with it) or "detach" (they stay, attached to
+// no note). Types lists the note types allowed besides BuiltinNoteTypes,
+// such as "journal" or "meeting".
+type NotesConfig struct {
+	DeleteTodos string   `toml:"delete_todos"`
+	Types       []string `toml:"types"`
+}
+
+// BuiltinNoteTypes are the note types every instance allows.
+var BuiltinNoteTypes = []string{"note", "todo_list", "bookmark"}
+
+// NoteTypes returns the allowed note types, the built-in ones first.
+func (c NotesConfig) NoteTypes() []string {
+	return append(slices.Clone(BuiltinNoteTypes), c.Types...)
+}
+
+// maxNoteTypeLen bounds the length of a configured note type.
+const maxNoteTypeLen = 32
+
+// UnfurlConfig controls the web pages the server fetches for bookmarks and
+// link previews. Allow lists the only hosts fetched from, or is empty for
+// any, and Deny hosts never fetched from; "example.com" covers its
+// subdomains too. Addresses that are not public are always refused.
+// CacheTTL is how long a link preview is kept, e.g. "1h"; empty or "0"
+// fetches the page every time.
+type UnfurlConfig struct {
+	Allow    []string `toml:"allow"`
+	Deny     []string `toml:"deny"`
+	CacheTTL string   `toml:"cache_ttl"`
+}
+
+// AdminConfig lists the accounts allowed to use the /api/v1/admin endpoints.
+type AdminConfig struct {
+	Emails []string `toml:"emails"`
 }
 
 func defaults() Config {
 	return Config{
 		Server: ServerConfig{
-			Listen: "127.0.0.1:8080",
+			Listen:             "127.0.0.1:8080",
+			SocketMode:         "0660",
+			WebUI:              true,
+			RequestTimeout:     "15s",
+			LongRequestTimeout: "5m",
+			TLS: TLSConfig{
+				ACMECacheDir: "acme-cache",
+			},
 		},
 		Database: DatabaseConfig{
-			Path: "notesd.db",
+			Path:                "notesd.db",
+			SerializeWrites:     true,
+			MaintenanceInterval: "24h",
 		},
 		Auth: AuthConfig{
 			PrivateKeyPath:     "notesd.key",
 			AccessTokenExpiry:  "15m",
 			RefreshTokenExpiry: "720h",
+			SigningAlgorithm:   "RS256",
+			Registration:       "open",
+		},
+		SMTP: SMTPConfig{
+			Port: 587,
+		},
+		MailIn: MailInConfig{
+			MaxSize: 25 << 20,
+		},
+		Telegram: TelegramConfig{
+			APIURL: "https://api.telegram.org",
+		},
+		Attachments: AttachmentsConfig{
+			Dir:     "attachments",
+			MaxSize: 10 << 20,
+		},
+		Push: PushConfig{
+			VAPIDKeyPath: "notesd-vapid.key",
+		},
+		Sync: SyncConfig{
+			TombstoneRetention: "2160h",
+		},
+		Tracing: TracingConfig{
+			SampleRatio: 1,
+		},
+		Audit: AuditConfig{
+			Retention: "8760h",
+		},
+		Notes: NotesConfig{
+			DeleteTodos: "keep",
+		},
+		Unfurl: UnfurlConfig{
+			CacheTTL: "1h",
+		},
+		Log: LogConfig{
+			Format:      "text",
+			Level:       "info",
+			SlowRequest: "1s",
+		},
+		Backup: BackupConfig{
+			Dir:  "backups",
+			Keep: 7,
+		},
+		RateLimit: RateLimitConfig{
+			Auth:         20,
+			AuthWindow:   "1m",
+			Writes:       600,
+			WritesWindow: "1m",
+			Backend:      "memory",
 		},
 	}
 }
 
-// Load reads configuration from TOML files.
-// It checks $HOME/.notesd.conf first, then $PWD/notesd.conf.
-// Values from the later file override the earlier one.
-func Load() (Config, error) {
+// Load reads configuration from TOML files and the environment. With an
+// empty path it reads $HOME/.notesd.conf and then $PWD/notesd.conf, skipping
+// those that do not exist; otherwise it reads only path, which must exist.
+// NOTESD_* variables (see LoadFromEnv) are applied last. Later sources
+// override earlier ones.
+func Load(path string) (Config, error) {
 	cfg := defaults()
 
-	home, err := os.UserHomeDir()
-	if err == nil {
-		_ = loadFile(filepath.Join(home, ".notesd.conf"), &cfg)
+	var paths []string
+	if path != "" {
+		paths = append(paths, path)
+	} else {
+		if home, err := os.UserHomeDir(); err == nil {
+			paths = append(paths, filepath.Join(home, ".notesd.conf"))
+		}
+		if pwd, err := os.Getwd(); err == nil {
+			paths = append(paths, filepath.Join(pwd, "notesd.conf"))
+		}
 	}
-
-	pwd, err := os.Getwd()
-	if err == nil {
-		_ = loadFile(filepath.Join(pwd, "notesd.conf"), &cfg)
+	for _, p := range paths {
+		err := loadFile(p, &cfg)
+		if path == "" && errors.Is(err, fs.ErrNotExist) {
+			continue
+		}
+		if err != nil {
+			return Config{}, err
+		}
+	}
+	if err := cfg.LoadFromEnv(); err != nil {
+		return Config{}, err
 	}
 
 	if err := validate(cfg); err != nil {
@@ -66,6 +463,8 @@ func Load() (Config, error) {
 	return cfg, nil
 }
 
+// loadFile decodes the TOML file at path into cfg. A key that is not a
+// setting is an error, so that a misspelt one is not ignored.
 func loadFile(path string, cfg *Config) error {
 	f, err := os.Open(path)
 	if err != nil {
@@ -73,19 +472,200 @@ func loadFile(path string, cfg *Config) error {
 	}
 	defer f.Close()
 
-	_, err = toml.NewDecoder(f).Decode(cfg)
-	return err
+	md, err := toml.NewDecoder(f).Decode(cfg)
+	if err != nil {
+		return fmt.Errorf("%s: %w", path, err)
+	}
+	if keys := md.Undecoded(); len(keys) > 0 {
+		return fmt.Errorf("%s: unknown setting %s", path, keys[0])
+	}
+	return nil
+}
+
+// Redacted returns a copy of c with secrets replaced, for printing.
+func (c Config) Redacted() Config {
+	if c.SMTP.Password != "" {
+		c.SMTP.Password = redacted
+	}
+	if len(c.Tracing.Headers) > 0 {
+		h := make(map[string]string, len(c.Tracing.Headers))
+		for k := range c.Tracing.Headers {
+			h[k] = redacted
+		}
+		c.Tracing.Headers = h
+	}
+	if c.Telegram.Token != "" {
+		c.Telegram.Token = redacted
+	}
+	if u, err := url.Parse(c.History.Remote); err == nil {
+		c.History.Remote = u.Redacted()
+	}
+	return c
+}
+
+const redacted = "(redacted)"
+
+// Write prints c as TOML.
+func (c Config) Write(w io.Writer) error {
+	return toml.NewEncoder(w).Encode(c)
 }
 
 func validate(cfg Config) error {
 	if cfg.Server.Listen == "" {
 		return fmt.Errorf("server.listen must not be empty")
 	}
+	if path, ok := cfg.Server.UnixSocket(); ok {
+		if path == "" {
+			return fmt.Errorf("server.listen: unix: needs a socket path")
+		}
+		if cfg.Server.TLS.Enabled() {
+			return fmt.Errorf("server.tls cannot be used with a Unix socket; terminate TLS at the proxy")
+		}
+	}
+	if m, err := strconv.ParseUint(cfg.Server.SocketMode, 8, 32); err != nil || m > 0o777 {
+		return fmt.Errorf("server.socket_mode: %q is not an octal file mode such as \"0660\"", cfg.Server.SocketMode)
+	}
+	if tls := cfg.Server.TLS; tls.Enabled() {
+		if (tls.CertFile == "") != (tls.KeyFile == "") {
+			return fmt.Errorf("server.tls.cert_file and server.tls.key_file must be set together")
+		}
+		if tls.CertFile != "" && len(tls.ACMEHosts) > 0 {
+			return fmt.Errorf("server.tls: use either cert_file/key_file or acme_hosts, not both")
+		}
+		if len(tls.ACMEHosts) > 0 && tls.ACMECacheDir == "" {
+			return fmt.Errorf("server.tls.acme_cache_dir must be set when acme_hosts is set")
+		}
+	} else if cfg.Server.TLS.KeyFile != "" {
+		return fmt.Errorf("server.tls.cert_file and server.tls.key_file must be set together")
+	} else if cfg.Server.TLS.RedirectListen != "" {
+		return fmt.Errorf("server.tls.redirect_listen needs cert_file/key_file or acme_hosts")
+	}
 	if cfg.Database.Path == "" {
 		return fmt.Errorf("database.path must not be empty")
 	}
+	if cfg.Database.MaxOpenConns < 0 || cfg.Database.MaxIdleConns < 0 {
+		return fmt.Errorf("database.max_open_conns and database.max_idle_conns must not be negative")
+	}
 	if cfg.Auth.PrivateKeyPath == "" {
 		return fmt.Errorf("auth.private_key must not be empty")
 	}
+	if a := cfg.Auth.SigningAlgorithm; a != "RS256" && a != "EdDSA" {
+		return fmt.Errorf("auth.signing_algorithm must be RS256 or EdDSA")
+	}
+	switch cfg.Auth.Registration {
+	case "open", "invite", "closed":
+	default:
+		return fmt.Errorf("auth.registration must be open, invite or closed")
+	}
+	switch cfg.Notes.DeleteTodos {
+	case "keep", "delete", "detach":
+	default:
+		return fmt.Errorf("notes.delete_todos must be keep, delete or detach")
+	}
+	for i, t := range cfg.Notes.Types {
+		switch {
+		case !validNoteType(t):
+			return fmt.Errorf("notes.types: %q must be up to %d lowercase letters, digits and underscores, starting with a letter", t, maxNoteTypeLen)
+		case t == "todo":
+			return fmt.Errorf("notes.types: \"todo\" is reserved for todos in searches")
+		case slices.Contains(BuiltinNoteTypes, t):
+			return fmt.Errorf("notes.types: %q is built in", t)
+		case slices.Contains(cfg.Notes.Types[:i], t):
+			return fmt.Errorf("notes.types: %q is listed twice", t)
+		}
+	}
+	for _, h := range append(slices.Clone(cfg.Unfurl.Allow), cfg.Unfurl.Deny...) {
+		if h == "" || strings.ContainsAny(h, "/:@ \t") {
+			return fmt.Errorf("unfurl: %q is not a host name", h)
+		}
+	}
+	if cfg.Auth.ResetURL != "" {
+		u, err := url.Parse(cfg.Auth.ResetURL)
+		if err != nil || (u.Scheme != "http" && u.Scheme != "https") || u.Host == "" {
+			return fmt.Errorf("auth.reset_url must be an absolute http(s) URL")
+		}
+	}
+	if _, _, err := cfg.Database.ContentKeys(); err != nil {
+		return err
+	}
+	if cfg.Attachments.Dir == "" {
+		return fmt.Errorf("attachments.dir must not be empty")
+	}
+	if f := cfg.Log.Format; f != "" && f != "text" && f != "json" {
+		return fmt.Errorf("log.format must be text or json")
+	}
+	if cfg.Log.Level != "" {
+		var level slog.Level
+		if err := level.UnmarshalText([]byte(cfg.Log.Level)); err != nil {
+			return fmt.Errorf("log.level must be debug, info, warn or error")
+		}
+	}
+	if cfg.Sync.MaxPushSize < 0 {
+		return fmt.Errorf("sync.max_push_size must not be negative")
+	}
+	if cfg.Attachments.MaxSize <= 0 {
+		return fmt.Errorf("attachments.max_size must be positive")
+	}
+	if cfg.Push.Subject != "" && cfg.Push.VAPIDKeyPath == "" {
+		return fmt.Errorf("push.vapid_key must be set when push.subject is set")
+	}
+	if cfg.Tracing.SampleRatio < 0 || cfg.Tracing.SampleRatio > 1 {
+		return fmt.Errorf("tracing.sample_ratio must be between 0 and 1")
+	}
+	if cfg.RateLimit.Auth < 0 || cfg.RateLimit.Writes < 0 {
+		return fmt.Errorf("rate_limit.auth and rate_limit.writes must not be negative")
+	}
+	if b := cfg.RateLimit.Backend; b != "memory" && b != "redis" {
+		return fmt.Errorf("rate_limit.backend must be memory or redis")
+	}
+	if cfg.RateLimit.Backend == "redis" {
+		if u, err := url.Parse(cfg.RateLimit.Redis); err != nil || (u.Scheme != "redis" && u.Scheme != "rediss") || u.Hostname() == "" {
+			return fmt.Errorf("rate_limit.redis must be a redis:// or rediss:// URL")
+		}
+	}
+	if q := cfg.Quota; q.Notes < 0 || q.Todos < 0 || q.ContentBytes < 0 || q.AttachmentBytes < 0 {
+		return fmt.Errorf("quota limits must not be negative")
+	}
+	if cfg.Backup.Keep < 0 {
+		return fmt.Errorf("backup.keep must not be negative")
+	}
+	if cfg.History.Remote != "" && cfg.History.GitDir == "" {
+		return fmt.Errorf("history.git_dir must be set when history.remote is set")
+	}
+	if cfg.MailIn.Listen != "" {
+		if cfg.MailIn.Domain == "" {
+			return fmt.Errorf("mail_in.domain must be set when mail_in.listen is set")
+		}
+		if cfg.MailIn.MaxSize <= 0 {
+			return fmt.Errorf("mail_in.max_size must be positive")
+		}
+	}
+	if cfg.Sync.PubSub != "" {
+		if u, err := url.Parse(cfg.Sync.PubSub); err != nil || (u.Scheme != "redis" && u.Scheme != "rediss") || u.Hostname() == "" {
+			return fmt.Errorf("sync.pubsub must be a redis:// or rediss:// URL")
+		}
+	}
+	if cfg.Telegram.Token != "" {
+		if u, err := url.Parse(cfg.Telegram.APIURL); err != nil || (u.Scheme != "http" && u.Scheme != "https") || u.Host == "" {
+			return fmt.Errorf("telegram.api_url must be an http or https URL")
+		}
+	}
+	if cfg.SMTP.Host != "" && cfg.SMTP.From == "" {
+		return fmt.Errorf("smtp.from must be set when smtp.host is set")
+	}
 	return nil
 }
+
+// validNoteType reports whether t is a lowercase name that can serve as a
+// note type.
+func validNoteType(t string) bool {
+	if t == "" || len(t) > maxNoteTypeLen || t[0] < 'a' || t[0] > 'z' {
+		return false
+	}
+	for _, r := range t {
+		if (r < 'a' || r > 'z') && (r < '0' || r > '9') && r != '_' {
+			return false
+		}
+	}
+	return true
+}
@@ -0,0 +1,92 @@
+package database
+
+import (
+	"database/sql"
+	"errors"
+	"fmt"
+
+	"github.com/c0dev0id/notesd/server/internal/model"
+)
+
+const accessTokenColumns = `id, user_id, name, scope, expires_at, last_used_at, created_at`
+
+func (db *DB) CreateAccessToken(t *model.AccessToken, tokenHash string) error {
+	_, err := db.exec(
+		`INSERT INTO access_tokens (id, user_id, name, scope, token_hash, expires_at, created_at)
+		 VALUES (?, ?, ?, ?, ?, ?, ?)`,
+		t.ID, t.UserID, t.Name, t.Scope, tokenHash, toNullMillis(t.ExpiresAt), toMillis(t.CreatedAt),
+	)
+	if err != nil {
+		return fmt.Errorf("create access token: %w", err)
+	}
+	return nil
+}
+
+// ListAccessTokens returns the user's tokens, newest first, including
+// expired ones so they can be recognised and deleted.
+func (db *DB) ListAccessTokens(userID string) ([]model.AccessToken, error) {
+	rows, err := db.query(
+		`SELECT `+accessTokenColumns+` FROM access_tokens
+		 WHERE user_id = ? ORDER BY created_at DESC, rowid DESC`, userID,
+	)
+	if err != nil {
+		return nil, fmt.Errorf("list access tokens: %w", err)
+	}
+	defer rows.Close()
+
+	tokens := []model.AccessToken{}
+	for rows.Next() {
+		t, err := scanAccessToken(rows)
+		if err != nil {
+			return nil, err
+		}
+		tokens = append(tokens, *t)
+	}
+	return tokens, rows.Err()
+}
+
+// GetAccessTokenByHash looks up a token presented by a client. Expiry is
+// left to the caller.
+func (db *DB) GetAccessTokenByHash(tokenHash string) (*model.AccessToken, error) {
+	t, err := scanAccessToken(db.queryRow(
+		`SELECT `+accessTokenColumns+` FROM access_tokens WHERE token_hash = ?`, tokenHash,
+	))
+	if errors.Is(err, sql.ErrNoRows) {
+		return nil, ErrNotFound
+	}
+	return t, err
+}
+
+// TouchAccessToken records that a token was used at nowMs.
+func (db *DB) TouchAccessToken(id string, nowMs int64) error {
+	_, err := db.exec(`UPDATE access_tokens SET last_used_at = ? WHERE id = ?`, nowMs, id)
+	if err != nil {
+		return fmt.Errorf("touch access token: %w", err)
+	}
+	return nil
+}
+
+func (db *DB) DeleteAccessToken(id, userID string) error {
+	res, err := db.exec(`DELETE FROM access_tokens WHERE id = ? AND user_id = ?`, id, userID)
+	if err != nil {
+		return fmt.Errorf("delete access token: %w", err)
+	}
+	return checkRowsAffected(res)
+}
+
+func scanAccessToken(s rowScanner) (*model.AccessToken, error) {
+	var t model.AccessToken
+	var expiresAt, lastUsedAt sql.NullInt64
+	var createdAt int64
+	err := s.Scan(&t.ID, &t.UserID, &t.Name, &t.Scope, &expiresAt, &lastUsedAt, &createdAt)
+	if errors.Is(err, sql.ErrNoRows) {
+		return nil, err
+	}
+	if err != nil {
+		return nil, fmt.Errorf("scan access token: %w", err)
+	}
+	t.ExpiresAt = fromNullMillis(expiresAt)
+	t.LastUsedAt = fromNullMillis(lastUsedAt)
+	t.CreatedAt = fromMillis(createdAt)
+	return &t, nil
+}
@@ -0,0 +1,126 @@
+package database
+
+import (
+	"fmt"
+	"time"
+
+	"github.com/c0dev0id/notesd/server/internal/model"
+)
+
+// statsDay is the UTC date key used by sync_stats.
+func statsDay(t time.Time) string {
+	return t.UTC().Format("2006-01-02")
+}
+
+// RecordSyncPull counts one pull returning items changes.
+func (db *DB) RecordSyncPull(userID string, items int, at time.Time) error {
+	_, err := db.exec(
+		`INSERT INTO sync_stats (day, user_id, pulls, items_pulled) VALUES (?, ?, 1, ?)
+		 ON CONFLICT(day, user_id) DO UPDATE SET
+		   pulls = pulls + 1, items_pulled = items_pulled + excluded.items_pulled`,
+		statsDay(at), userID, items,
+	)
+	if err != nil {
+		return fmt.Errorf("record sync pull: %w", err)
+	}
+	return nil
+}
+
+// RecordSyncPush counts one push carrying items changes.
+func (db *DB) RecordSyncPush(userID string, items int, at time.Time) error {
+	_, err := db.exec(
+		`INSERT INTO sync_stats (day, user_id, pushes, items_pushed) VALUES (?, ?, 1, ?)
+		 ON CONFLICT(day, user_id) DO UPDATE SET
+		   pushes = pushes + 1, items_pushed = items_pushed + excluded.items_pushed`,
+		statsDay(at), userID, items,
+	)
+	if err != nil {
+		return fmt.Errorf("record sync push: %w", err)
+	}
+	return nil
+}
+
+// GetUserStats returns per-user counts for every account, oldest first.
+// A device is active while it holds an unexpired refresh token.
+func (db *DB) GetUserStats(now time.Time) ([]model.AdminUserStats, error) {
+	rows, err := db.query(
+		`SELECT u.id, u.email, u.display_name, u.created_at,
+		   (SELECT COUNT(*) FROM notes n WHERE n.user_id = u.id AND n.deleted_at IS NULL),
+		   (SELECT COUNT(*) FROM todos t WHERE t.user_id = u.id AND t.deleted_at IS NULL),
+		   (SELECT COALESCE(SUM(length(CAST(n.title AS BLOB)) + length(CAST(plaintext(n.content) AS BLOB))), 0)
+		      FROM notes n WHERE n.user_id = u.id)
+		   + (SELECT COALESCE(SUM(length(CAST(plaintext(t.content) AS BLOB))), 0)
+		      FROM todos t WHERE t.user_id = u.id),
+		   (SELECT COUNT(DISTINCT r.device_id) FROM refresh_tokens r
+		      WHERE r.user_id = u.id AND r.expires_at > ?)
+		 FROM users u ORDER BY u.created_at ASC`,
+		toMillis(now),
+	)
+	if err != nil {
+		return nil, fmt.Errorf("get user stats: %w", err)
+	}
+	defer rows.Close()
+
+	var stats []model.AdminUserStats
+	for rows.Next() {
+		var s model.AdminUserStats
+		var createdAt int64
+		if err := rows.Scan(&s.ID, &s.Email, &s.DisplayName, &createdAt,
+			&s.Notes, &s.Todos, &s.StorageBytes, &s.ActiveDevices); err != nil {
+			return nil, fmt.Errorf("scan user stats: %w", err)
+		}
+		s.CreatedAt = fromMillis(createdAt)
+		stats = append(stats, s)
+	}
+	return stats, rows.Err()
+}
+
+// GetSyncTraffic returns instance-wide sync counters per day since the
+// given time, oldest first. Days without traffic are omitted.
+func (db *DB) GetSyncTraffic(since time.Time) ([]model.SyncTrafficDay, error) {
+	rows, err := db.query(
+		`SELECT day, SUM(pulls), SUM(pushes), SUM(items_pulled), SUM(items_pushed)
+		 FROM sync_stats WHERE day >= ?
+		 GROUP BY day ORDER BY day ASC`,
+		statsDay(since),
+	)
+	if err != nil {
+		return nil, fmt.Errorf("get sync traffic: %w", err)
+	}
+	defer rows.Close()
+
+	var days []model.SyncTrafficDay
+	for rows.Next() {
+		var d model.SyncTrafficDay
+		if err := rows.Scan(&d.Date, &d.Pulls, &d.Pushes, &d.ItemsPulled, &d.ItemsPushed); err != nil {
+			return nil, fmt.Errorf("scan sync traffic: %w", err)
+		}
+		days = append(days, d)
+	}
+	return days, rows.Err()
+}
+
+// GetRegistrations returns the number of accounts created per UTC day since
+// the given time. Days without registrations are omitted.
+func (db *DB) GetRegistrations(since time.Time) ([]model.DailyCount, error) {
+	rows, err := db.query(
+		`SELECT date(created_at / 1000, 'unixepoch') AS day, COUNT(*)
+		 FROM users WHERE created_at >= ?
+		 GROUP BY day ORDER BY day ASC`,
+		toMillis(since),
+	)
+	if err != nil {
+		return nil, fmt.Errorf("get registrations: %w", err)
+	}
+	defer rows.Close()
+
+	var days []model.DailyCount
+	for rows.Next() {
+		var d model.DailyCount
+		if err := rows.Scan(&d.Date, &d.Count); err != nil {
+			return nil, fmt.Errorf("scan registrations: %w", err)
+		}
+		days = append(days, d)
+	}
+	return days, rows.Err()
+}
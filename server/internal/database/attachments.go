@@ -0,0 +1,133 @@
+package database
+
+import (
+	"database/sql"
+	"errors"
+	"fmt"
+
+	"github.com/c0dev0id/notesd/server/internal/model"
+)
+
+const attachmentColumns = `id, user_id, note_id, filename, content_type, size, sha256,
+	modified_at, deleted_at, created_at`
+
+func (db *DB) CreateAttachment(at *model.Attachment) error {
+	return db.withTx(func(tx *txn) error {
+		return insertAttachment(tx, at)
+	})
+}
+
+func insertAttachment(tx *txn, at *model.Attachment) error {
+	_, err := tx.Exec(
+		`INSERT INTO attachments (`+attachmentColumns+`)
+		 VALUES (?, ?, ?, ?, ?, ?, ?, ?, ?, ?)`,
+		at.ID, at.UserID, at.NoteID, at.Filename, at.ContentType, at.Size, at.SHA256,
+		toMillis(at.ModifiedAt), toNullMillis(at.DeletedAt), toMillis(at.CreatedAt),
+	)
+	if err != nil {
+		return fmt.Errorf("create attachment: %w", err)
+	}
+	return nil
+}
+
+func (db *DB) GetAttachment(id, userID string) (*model.Attachment, error) {
+	row := db.queryRow(
+		`SELECT `+attachmentColumns+`
+		 FROM attachments WHERE id = ? AND user_id = ? AND deleted_at IS NULL`, id, userID,
+	)
+	at, err := scanAttachmentRow(row)
+	if errors.Is(err, sql.ErrNoRows) {
+		return nil, ErrNotFound
+	}
+	if err != nil {
+		return nil, fmt.Errorf("scan attachment: %w", err)
+	}
+	return at, nil
+}
+
+// ListAttachments returns the live attachments of a note, oldest first.
+func (db *DB) ListAttachments(noteID, userID string) ([]model.Attachment, error) {
+	rows, err := db.query(
+		`SELECT `+attachmentColumns+`
+		 FROM attachments WHERE note_id = ? AND user_id = ? AND deleted_at IS NULL
+		 ORDER BY created_at ASC`, noteID, userID,
+	)
+	if err != nil {
+		return nil, fmt.Errorf("list attachments: %w", err)
+	}
+	defer rows.Close()
+	return scanAttachments(rows)
+}
+
+// GetAllAttachments returns the live attachments of a user's live notes,
+// oldest first.
+func (db *DB) GetAllAttachments(userID string) ([]model.Attachment, error) {
+	rows, err := db.query(
+		`SELECT `+attachmentColumns+`
+		 FROM attachments WHERE user_id = ? AND deleted_at IS NULL
+		 AND note_id IN (SELECT id FROM notes WHERE deleted_at IS NULL)
+		 ORDER BY created_at ASC`, userID,
+	)
+	if err != nil {
+		return nil, fmt.Errorf("get all attachments: %w", err)
+	}
+	defer rows.Close()
+	return scanAttachments(rows)
+}
+
+// DeleteAttachment soft-deletes an attachment so the removal syncs.
+func (db *DB) DeleteAttachment(id, userID string, deletedAt int64) error {
+	res, err := db.exec(
+		`UPDATE attachments SET deleted_at = ?, modified_at = ?
+		 WHERE id = ? AND user_id = ? AND deleted_at IS NULL`,
+		deletedAt, deletedAt, id, userID,
+	)
+	if err != nil {
+		return fmt.Errorf("delete attachment: %w", err)
+	}
+	return checkRowsAffected(res)
+}
+
+// GetAttachmentChangesSince returns all attachments (including deleted)
+// modified after sinceMs.
+func (db *DB) GetAttachmentChangesSince(userID string, sinceMs int64) ([]model.Attachment, error) {
+	rows, err := db.query(
+		`SELECT `+attachmentColumns+`
+		 FROM attachments WHERE user_id = ? AND modified_at > ?
+		 ORDER BY modified_at ASC`, userID, sinceMs,
+	)
+	if err != nil {
+		return nil, fmt.Errorf("get attachment changes: %w", err)
+	}
+	defer rows.Close()
+	return scanAttachments(rows)
+}
+
+func scanAttachmentRow(s rowScanner) (*model.Attachment, error) {
+	var at model.Attachment
+	var modifiedAt, createdAt int64
+	var deletedAt sql.NullInt64
+	err := s.Scan(
+		&at.ID, &at.UserID, &at.NoteID, &at.Filename, &at.ContentType, &at.Size, &at.SHA256,
+		&modifiedAt, &deletedAt, &createdAt,
+	)
+	if err != nil {
+		return nil, err
+	}
+	at.ModifiedAt = fromMillis(modifiedAt)
+	at.DeletedAt = fromNullMillis(deletedAt)
+	at.CreatedAt = fromMillis(createdAt)
+	return &at, nil
+}
+
+func scanAttachments(rows *sql.Rows) ([]model.Attachment, error) {
+	var list []model.Attachment
+	for rows.Next() {
+		at, err := scanAttachmentRow(rows)
+		if err != nil {
+			return nil, fmt.Errorf("scan attachment row: %w", err)
+		}
+		list = append(list, *at)
+	}
+	return list, rows.Err()
+}
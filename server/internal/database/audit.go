@@ -0,0 +1,211 @@
+package database
+
+import (
+	"database/sql"
+	"fmt"
+	"time"
+
+	"github.com/c0dev0id/notesd/server/internal/model"
+)
+
+// Audit log actions.
+const (
+	AuditCreate   = "create"
+	AuditUpdate   = "update"
+	AuditComplete = "complete"
+	AuditDelete   = "delete"
+	AuditRestore  = "restore"
+	AuditPurge    = "purge"
+)
+
+// AppendAudit writes entries to the audit log in one transaction.
+func (db *DB) AppendAudit(entries []model.AuditEntry) error {
+	return db.withTx(func(tx *txn) error {
+		for _, e := range entries {
+			if _, err := tx.Exec(
+				`INSERT INTO audit_log (user_id, entity_type, entity_id, action, device_id, at)
+				 VALUES (?, ?, ?, ?, ?, ?)`,
+				e.UserID, e.EntityType, e.EntityID, e.Action, e.DeviceID, toMillis(e.At),
+			); err != nil {
+				return fmt.Errorf("audit %s %s: %w", e.EntityType, e.Action, err)
+			}
+		}
+		return nil
+	})
+}
+
+// AuditFilter narrows an audit log query. Empty fields match everything;
+// Since is inclusive, Until exclusive.
+type AuditFilter struct {
+	UserID     string
+	EntityType string
+	EntityID   string
+	Action     string
+	DeviceID   string
+	Since      *time.Time
+	Until      *time.Time
+}
+
+func (f AuditFilter) where(args *[]any) string {
+	cond := "true"
+	for _, c := range []struct{ col, val string }{
+		{"user_id", f.UserID},
+		{"entity_type", f.EntityType},
+		{"entity_id", f.EntityID},
+		{"action", f.Action},
+		{"device_id", f.DeviceID},
+	} {
+		if c.val != "" {
+			cond += " AND " + c.col + " = ?"
+			*args = append(*args, c.val)
+		}
+	}
+	if f.Since != nil {
+		cond += " AND at >= ?"
+		*args = append(*args, toMillis(*f.Since))
+	}
+	if f.Until != nil {
+		cond += " AND at < ?"
+		*args = append(*args, toMillis(*f.Until))
+	}
+	return cond
+}
+
+// ListAuditLog returns matching entries, newest first, and their total.
+func (db *DB) ListAuditLog(f AuditFilter, limit, offset int) ([]model.AuditEntry, int, error) {
+	var args []any
+	cond := f.where(&args)
+
+	var (
+		entries []model.AuditEntry
+		total   int
+	)
+	err := db.readTx(func(db *DB) error {
+		if err := db.queryRow(`SELECT COUNT(*) FROM audit_log WHERE `+cond, args...).Scan(&total); err != nil {
+			return fmt.Errorf("count audit log: %w", err)
+		}
+
+		rows, err := db.query(
+			`SELECT id, user_id, entity_type, entity_id, action, device_id, at
+			 FROM audit_log WHERE `+cond+`
+			 ORDER BY id DESC LIMIT ? OFFSET ?`,
+			append(args, limit, offset)...,
+		)
+		if err != nil {
+			return fmt.Errorf("list audit log: %w", err)
+		}
+		defer rows.Close()
+
+		entries, err = scanAuditEntries(rows)
+		return err
+	})
+	if err != nil {
+		return nil, 0, err
+	}
+	return entries, total, nil
+}
+
+func scanAuditEntries(rows *sql.Rows) ([]model.AuditEntry, error) {
+	var entries []model.AuditEntry
+	for rows.Next() {
+		var e model.AuditEntry
+		var at int64
+		if err := rows.Scan(&e.ID, &e.UserID, &e.EntityType, &e.EntityID,
+			&e.Action, &e.DeviceID, &at); err != nil {
+			return nil, fmt.Errorf("scan audit entry: %w", err)
+		}
+		e.At = fromMillis(at)
+		entries = append(entries, e)
+	}
+	return entries, rows.Err()
+}
+
+// ListActivity returns the user's entries, newest first, with the title of
+// the note or the text of the todo, and their total.
+func (db *DB) ListActivity(userID string, limit, offset int) ([]model.ActivityEntry, int, error) {
+	var (
+		entries []model.ActivityEntry
+		total   int
+	)
+	err := db.readTx(func(db *DB) error {
+		if err := db.queryRow(`SELECT COUNT(*) FROM audit_log WHERE user_id = ?`, userID).Scan(&total); err != nil {
+			return fmt.Errorf("count activity: %w", err)
+		}
+
+		// Purged items have no row left to join, so their title is empty.
+		rows, err := db.query(
+			`SELECT a.id, a.entity_type, a.entity_id, a.action, COALESCE(n.title, plaintext(t.content), ''), a.device_id, a.at
+			 FROM audit_log a
+			 LEFT JOIN notes n ON a.entity_type = 'note' AND n.id = a.entity_id AND n.user_id = a.user_id
+			 LEFT JOIN todos t ON a.entity_type = 'todo' AND t.id = a.entity_id AND t.user_id = a.user_id
+			 WHERE a.user_id = ?
+			 ORDER BY a.at DESC, a.id DESC LIMIT ? OFFSET ?`,
+			userID, limit, offset,
+		)
+		if err != nil {
+			return fmt.Errorf("list activity: %w", err)
+		}
+		defer rows.Close()
+
+		for rows.Next() {
+			var e model.ActivityEntry
+			var at int64
+			if err := rows.Scan(&e.ID, &e.EntityType, &e.EntityID, &e.Action, &e.Title, &e.DeviceID, &at); err != nil {
+				return fmt.Errorf("scan activity entry: %w", err)
+			}
+			e.At = fromMillis(at)
+			entries = append(entries, e)
+		}
+		if err := rows.Err(); err != nil {
+			return fmt.Errorf("list activity: %w", err)
+		}
+		return nil
+	})
+	if err != nil {
+		return nil, 0, err
+	}
+	return entries, total, nil
+}
+
+// addAuditComplete rebuilds audit_log to allow the complete action;
+// SQLite cannot change a CHECK constraint in place. The indexes dropped
+// with the old table are recreated by schema, and so is the table of a
+// database from before the audit log.
+func (db *DB) addAuditComplete() error {
+	return db.withTx(func(tx *txn) error {
+		var tables, n int
+		if err := tx.QueryRow(
+			`SELECT COUNT(*), COUNT(*) FILTER (WHERE sql LIKE '%''complete''%') FROM sqlite_master WHERE name = 'audit_log'`,
+		).Scan(&tables, &n); err != nil || tables == 0 || n > 0 {
+			return err
+		}
+		for _, stmt := range []string{
+			`CREATE TABLE audit_log_new (
+				id          INTEGER PRIMARY KEY AUTOINCREMENT,
+				user_id     TEXT NOT NULL,
+				entity_type TEXT NOT NULL CHECK(entity_type IN ('note', 'todo')),
+				entity_id   TEXT NOT NULL,
+				action      TEXT NOT NULL CHECK(action IN ('create', 'update', 'complete', 'delete', 'restore', 'purge')),
+				device_id   TEXT NOT NULL,
+				at          INTEGER NOT NULL
+			)`,
+			`INSERT INTO audit_log_new SELECT id, user_id, entity_type, entity_id, action, device_id, at FROM audit_log`,
+			`DROP TABLE audit_log`,
+			`ALTER TABLE audit_log_new RENAME TO audit_log`,
+		} {
+			if _, err := tx.Exec(stmt); err != nil {
+				return fmt.Errorf("add audit complete action: %w", err)
+			}
+		}
+		return nil
+	})
+}
+
+// PruneAuditLog removes entries older than cutoff and returns how many.
+func (db *DB) PruneAuditLog(cutoff time.Time) (int64, error) {
+	res, err := db.exec(`DELETE FROM audit_log WHERE at < ?`, toMillis(cutoff))
+	if err != nil {
+		return 0, fmt.Errorf("prune audit log: %w", err)
+	}
+	return res.RowsAffected()
+}
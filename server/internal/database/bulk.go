@@ -0,0 +1,67 @@
+package database
+
+import (
+	"fmt"
+	"time"
+
+	"github.com/c0dev0id/notesd/server/internal/model"
+)
+
+// BulkWrites are the changes of one bulk operation: notes and todos to
+// write in their new state, and the IDs of those to soft-delete.
+type BulkWrites struct {
+	UserID      string
+	DeviceID    string
+	At          time.Time
+	Notes       []*model.Note
+	Todos       []*model.Todo
+	DeleteNotes []string
+	DeleteTodos []string
+	// NoteTodos is applied to the todos of the deleted notes.
+	NoteTodos TodoCascade
+}
+
+// ApplyBulk applies w in one transaction: either every change is made or,
+// on error, none. An item that is missing or already deleted fails it with
+// ErrNotFound. It returns the todos changed by deleting notes.
+func (db *DB) ApplyBulk(w BulkWrites) (CascadedTodos, error) {
+	var c CascadedTodos
+	err := db.withTx(func(tx *txn) error {
+		for _, n := range w.Notes {
+			if err := updateNote(tx, n); err != nil {
+				return err
+			}
+		}
+		for _, t := range w.Todos {
+			if err := updateTodo(tx, t); err != nil {
+				return err
+			}
+		}
+		at := toMillis(w.At)
+		for _, del := range []struct {
+			table string
+			ids   []string
+		}{{"todos", w.DeleteTodos}, {"notes", w.DeleteNotes}} {
+			for _, id := range del.ids {
+				res, err := tx.Exec(
+					`UPDATE `+del.table+` SET deleted_at = ?, modified_at = ?, modified_by_device = ?
+					 WHERE id = ? AND user_id = ? AND deleted_at IS NULL`,
+					at, at, w.DeviceID, id, w.UserID,
+				)
+				if err != nil {
+					return fmt.Errorf("bulk delete %s: %w", del.table, err)
+				}
+				if err := checkRowsAffected(res); err != nil {
+					return err
+				}
+				if del.table == "notes" {
+					if err := cascadeNoteTodos(tx, &c, id, w.UserID, at, w.DeviceID, w.NoteTodos); err != nil {
+						return err
+					}
+				}
+			}
+		}
+		return nil
+	})
+	return c, err
+}
@@ -0,0 +1,81 @@
+package database
+
+import (
+	"database/sql"
+	"errors"
+	"fmt"
+	"time"
+
+	"github.com/c0dev0id/notesd/server/internal/model"
+)
+
+// SetCalendarFeedToken stores the hash of a user's calendar feed token,
+// replacing any previous one so the old feed URL stops working.
+func (db *DB) SetCalendarFeedToken(userID, tokenHash string, now time.Time) error {
+	_, err := db.exec(
+		`INSERT INTO calendar_feeds (user_id, token_hash, created_at) VALUES (?, ?, ?)
+		 ON CONFLICT(user_id) DO UPDATE SET
+		   token_hash = excluded.token_hash, created_at = excluded.created_at`,
+		userID, tokenHash, toMillis(now),
+	)
+	if err != nil {
+		return fmt.Errorf("set calendar feed token: %w", err)
+	}
+	return nil
+}
+
+// GetCalendarFeedCreated returns when the user's current feed token was
+// generated, or ErrNotFound if there is none.
+func (db *DB) GetCalendarFeedCreated(userID string) (time.Time, error) {
+	var created int64
+	err := db.queryRow(
+		`SELECT created_at FROM calendar_feeds WHERE user_id = ?`, userID,
+	).Scan(&created)
+	if errors.Is(err, sql.ErrNoRows) {
+		return time.Time{}, ErrNotFound
+	}
+	if err != nil {
+		return time.Time{}, fmt.Errorf("get calendar feed: %w", err)
+	}
+	return fromMillis(created), nil
+}
+
+func (db *DB) DeleteCalendarFeed(userID string) error {
+	res, err := db.exec(`DELETE FROM calendar_feeds WHERE user_id = ?`, userID)
+	if err != nil {
+		return fmt.Errorf("delete calendar feed: %w", err)
+	}
+	return checkRowsAffected(res)
+}
+
+// GetCalendarFeedUser returns the user owning the feed token hash.
+func (db *DB) GetCalendarFeedUser(tokenHash string) (string, error) {
+	var userID string
+	err := db.queryRow(
+		`SELECT user_id FROM calendar_feeds WHERE token_hash = ?`, tokenHash,
+	).Scan(&userID)
+	if errors.Is(err, sql.ErrNoRows) {
+		return "", ErrNotFound
+	}
+	if err != nil {
+		return "", fmt.Errorf("get calendar feed user: %w", err)
+	}
+	return userID, nil
+}
+
+// GetDatedTodos returns every live todo of a user that has a due date,
+// ordered by due date.
+func (db *DB) GetDatedTodos(userID string) ([]model.Todo, error) {
+	rows, err := db.query(
+		`SELECT `+todoColumns+`
+		 FROM todos
+		 WHERE user_id = ? AND deleted_at IS NULL AND due_date IS NOT NULL
+		 ORDER BY due_date ASC, created_at ASC`,
+		userID,
+	)
+	if err != nil {
+		return nil, fmt.Errorf("get dated todos: %w", err)
+	}
+	defer rows.Close()
+	return scanTodos(rows)
+}
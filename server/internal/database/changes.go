@@ -0,0 +1,92 @@
+package database
+
+import (
+	"fmt"
+
+	"github.com/c0dev0id/notesd/server/internal/model"
+)
+
+// ChangePage selects one page of a user's changes: rows changed in
+// (Since, Until], in (time, id) order, starting after (AfterTime, AfterID),
+// at most Limit rows. Until is fixed for all pages of one pull, so rows
+// changing while a client pages through are picked up by its next pull.
+type ChangePage struct {
+	Since     int64
+	Until     int64
+	AfterTime int64
+	AfterID   string
+	Limit     int
+}
+
+func (p ChangePage) where(timeCol, idCol string) (string, []any) {
+	return timeCol + ` > ? AND ` + timeCol + ` <= ? AND (` + timeCol + `, ` + idCol + `) > (?, ?)
+		 ORDER BY ` + timeCol + ` ASC, ` + idCol + ` ASC LIMIT ?`,
+		[]any{p.Since, p.Until, p.AfterTime, p.AfterID, p.Limit}
+}
+
+func (db *DB) GetNoteChangesPage(userID string, p ChangePage) ([]model.Note, error) {
+	where, args := p.where("modified_at", "id")
+	rows, err := db.query(
+		`SELECT `+noteColumns+` FROM notes WHERE user_id = ? AND `+where,
+		append([]any{userID}, args...)...,
+	)
+	if err != nil {
+		return nil, fmt.Errorf("get note changes: %w", err)
+	}
+	defer rows.Close()
+	return scanNotes(rows)
+}
+
+func (db *DB) GetTodoChangesPage(userID string, p ChangePage) ([]model.Todo, error) {
+	where, args := p.where("modified_at", "id")
+	rows, err := db.query(
+		`SELECT `+todoColumns+` FROM todos WHERE user_id = ? AND `+where,
+		append([]any{userID}, args...)...,
+	)
+	if err != nil {
+		return nil, fmt.Errorf("get todo changes: %w", err)
+	}
+	defer rows.Close()
+	return scanTodos(rows)
+}
+
+func (db *DB) GetAttachmentChangesPage(userID string, p ChangePage) ([]model.Attachment, error) {
+	where, args := p.where("modified_at", "id")
+	rows, err := db.query(
+		`SELECT `+attachmentColumns+` FROM attachments WHERE user_id = ? AND `+where,
+		append([]any{userID}, args...)...,
+	)
+	if err != nil {
+		return nil, fmt.Errorf("get attachment changes: %w", err)
+	}
+	defer rows.Close()
+	return scanAttachments(rows)
+}
+
+// PurgedChange is a purge record with the time used for paging.
+type PurgedChange struct {
+	model.PurgedItem
+	PurgedAt int64
+}
+
+func (db *DB) GetPurgedPage(userID string, p ChangePage) ([]PurgedChange, error) {
+	where, args := p.where("purged_at", "item_id")
+	rows, err := db.query(
+		`SELECT item_type, item_id, purged_at FROM purged_items WHERE user_id = ? AND `+where,
+		append([]any{userID}, args...)...,
+	)
+	if err != nil {
+		return nil, fmt.Errorf("get purged items: %w", err)
+	}
+	defer rows.Close()
+
+	var items []PurgedChange
+	for rows.Next() {
+		var c PurgedChange
+		if err := rows.Scan(&c.Type, &c.ID, &c.PurgedAt); err != nil {
+			return nil, fmt.Errorf("scan purged item: %w", err)
+		}
+		items = append(items, c)
+	}
+	return items, rows.Err()
+}
@@ -0,0 +1,54 @@
+package database
+
+import (
+	"fmt"
+	"time"
+
+	"github.com/c0dev0id/notesd/server/internal/model"
+)
+
+// RecordConflict stores a sync push that lost LWW resolution.
+func (db *DB) RecordConflict(userID, itemType, itemID, deviceID string, at time.Time) error {
+	_, err := db.exec(
+		`INSERT INTO sync_conflicts (id, user_id, item_type, item_id, device_id, created_at)
+		 VALUES (?, ?, ?, ?, ?, ?)`,
+		model.NewID(), userID, itemType, itemID, deviceID, toMillis(at),
+	)
+	if err != nil {
+		return fmt.Errorf("record conflict: %w", err)
+	}
+	return nil
+}
+
+// GetUnresolvedConflicts returns recorded conflicts whose item has not been
+// modified since, oldest first. Any later write to the item, from any device,
+// counts as resolving it.
+func (db *DB) GetUnresolvedConflicts(userID string) ([]model.ConflictRecord, error) {
+	rows, err := db.query(
+		`SELECT c.item_type, c.item_id, c.device_id, c.created_at
+		 FROM sync_conflicts c
+		 WHERE c.user_id = ?
+		   AND NOT EXISTS (SELECT 1 FROM notes n WHERE c.item_type = 'note'
+		       AND n.id = c.item_id AND n.modified_at > c.created_at)
+		   AND NOT EXISTS (SELECT 1 FROM todos t WHERE c.item_type = 'todo'
+		       AND t.id = c.item_id AND t.modified_at > c.created_at)
+		 ORDER BY c.created_at ASC`,
+		userID,
+	)
+	if err != nil {
+		return nil, fmt.Errorf("get unresolved conflicts: %w", err)
+	}
+	defer rows.Close()
+
+	var conflicts []model.ConflictRecord
+	for rows.Next() {
+		var c model.ConflictRecord
+		var createdAt int64
+		if err := rows.Scan(&c.ItemType, &c.ItemID, &c.DeviceID, &createdAt); err != nil {
+			return nil, fmt.Errorf("scan conflict row: %w", err)
+		}
+		c.CreatedAt = fromMillis(createdAt)
+		conflicts = append(conflicts, c)
+	}
+	return conflicts, rows.Err()
+}
@@ -0,0 +1,227 @@
+package database
+
+import (
+	"crypto/aes"
+	"crypto/cipher"
+	"crypto/rand"
+	"crypto/sha256"
+	"database/sql"
+	"database/sql/driver"
+	"encoding/base64"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"log/slog"
+	"strings"
+	"sync"
+
+	"modernc.org/sqlite"
+)
+
+// Note and todo text, and the webhook payloads that carry it, are stored
+// encrypted when a content key is configured (see Options.ContentKey). An
+// encrypted value is encPrefix, the ID of its key, ":" and the base64 of
+// nonce and AES-256-GCM ciphertext; values without the prefix are plain.
+// Statements read such columns through the SQL function plaintext(), so
+// LIKE searches, sizes and comparisons keep working on the text.
+const encPrefix = "\x01enc1:"
+
+// contentKeys holds the AEAD of every key opened by this process, by key
+// ID. plaintext() is registered once for all connections, so it finds the
+// key of a value here rather than on a DB.
+var contentKeys sync.Map
+
+func init() {
+	sqlite.MustRegisterDeterministicScalarFunction("plaintext", 1, sqlPlaintext)
+}
+
+// contentKey encrypts text for storage. A nil *contentKey stores it plain.
+type contentKey struct {
+	id   string
+	aead cipher.AEAD
+}
+
+// openContentKey makes key, 32 bytes for AES-256, known to plaintext().
+func openContentKey(key []byte) (*contentKey, error) {
+	if len(key) != 32 {
+		return nil, fmt.Errorf("content key must be 32 bytes, got %d", len(key))
+	}
+	block, err := aes.NewCipher(key)
+	if err != nil {
+		return nil, err
+	}
+	aead, err := cipher.NewGCM(block)
+	if err != nil {
+		return nil, err
+	}
+	sum := sha256.Sum256(key)
+	k := &contentKey{id: hex.EncodeToString(sum[:4]), aead: aead}
+	contentKeys.Store(k.id, aead)
+	return k, nil
+}
+
+// prefix is what the values encrypted with k start with.
+func (k *contentKey) prefix() string {
+	return encPrefix + k.id + ":"
+}
+
+// seal returns s as it is stored. Empty text stays empty.
+func (k *contentKey) seal(s string) string {
+	if k == nil || s == "" {
+		return s
+	}
+	nonce := make([]byte, k.aead.NonceSize())
+	rand.Read(nonce)
+	return k.prefix() + base64.RawStdEncoding.EncodeToString(k.aead.Seal(nonce, nonce, []byte(s), nil))
+}
+
+// sealNull is seal for a nullable column.
+func (k *contentKey) sealNull(s *string) *string {
+	if s == nil {
+		return nil
+	}
+	sealed := k.seal(*s)
+	return &sealed
+}
+
+// openContent returns the text of a stored value.
+func openContent(s string) (string, error) {
+	rest, ok := strings.CutPrefix(s, encPrefix)
+	if !ok {
+		return s, nil
+	}
+	id, data, ok := strings.Cut(rest, ":")
+	if !ok {
+		return "", errors.New("malformed encrypted value")
+	}
+	aead, ok := contentKeys.Load(id)
+	if !ok {
+		return "", fmt.Errorf("text encrypted with unknown key %s", id)
+	}
+	b, err := base64.RawStdEncoding.DecodeString(data)
+	if err != nil {
+		return "", fmt.Errorf("malformed encrypted value: %w", err)
+	}
+	a := aead.(cipher.AEAD)
+	if len(b) < a.NonceSize() {
+		return "", errors.New("malformed encrypted value")
+	}
+	plain, err := a.Open(nil, b[:a.NonceSize()], b[a.NonceSize():], nil)
+	if err != nil {
+		return "", fmt.Errorf("decrypt text with key %s: %w", id, err)
+	}
+	return string(plain), nil
+}
+
+func sqlPlaintext(_ *sqlite.FunctionContext, args []driver.Value) (driver.Value, error) {
+	if s, ok := args[0].(string); ok {
+		return openContent(s)
+	}
+	return args[0], nil
+}
+
+// encryptedColumns lists the columns that hold encrypted text, by table.
+var encryptedColumns = []struct {
+	table   string
+	columns []string
+}{
+	{"notes", []string{"content", "content_patch", "first_heading", "metadata"}},
+	{"todos", []string{"content", "checklist"}},
+	{"webhook_deliveries", []string{"payload"}},
+}
+
+// rekeyBatch is the number of rows rewritten per transaction by rekey.
+const rekeyBatch = 500
+
+// rekey rewrites the text not stored under the current key: plain text
+// when a key is set, text under a previous key, and encrypted text when
+// encryption has been turned off. It runs in batches, so an interrupted
+// run leaves readable rows behind and the next start finishes it. The
+// rewritten rows' old text lingers in free pages and the WAL, so after a
+// run that changed rows the file is vacuumed and the WAL truncated.
+func (db *DB) rekey() error {
+	changed := false
+	for _, t := range encryptedColumns {
+		var stale []string
+		var args []any
+		for _, c := range t.columns {
+			if db.key != nil {
+				stale = append(stale, fmt.Sprintf(`(%s != '' AND substr(%s, 1, ?) != ?)`, c, c))
+				args = append(args, len(db.key.prefix()), db.key.prefix())
+			} else {
+				stale = append(stale, fmt.Sprintf(`substr(%s, 1, ?) = ?`, c))
+				args = append(args, len(encPrefix), encPrefix)
+			}
+		}
+		query := `SELECT rowid, plaintext(` + strings.Join(t.columns, `), plaintext(`) + `)
+			FROM ` + t.table + ` WHERE (` + strings.Join(stale, ` OR `) + `) AND rowid > ?
+			ORDER BY rowid LIMIT ?`
+		update := `UPDATE ` + t.table + ` SET ` + strings.Join(t.columns, ` = ?, `) + ` = ? WHERE rowid = ?`
+
+		var last int64
+		total := 0
+		for {
+			n := 0
+			err := db.withTx(func(tx *txn) error {
+				rows, err := tx.Query(query, append(args, last, rekeyBatch)...)
+				if err != nil {
+					return err
+				}
+				var batch [][]any
+				for rows.Next() {
+					vals := make([]sql.NullString, len(t.columns))
+					dest := []any{&last}
+					for i := range vals {
+						dest = append(dest, &vals[i])
+					}
+					if err := rows.Scan(dest...); err != nil {
+						rows.Close()
+						return err
+					}
+					row := make([]any, 0, len(vals)+1)
+					for _, v := range vals {
+						if v.Valid {
+							row = append(row, db.key.seal(v.String))
+						} else {
+							row = append(row, nil)
+						}
+					}
+					batch = append(batch, append(row, last))
+				}
+				rows.Close()
+				if err := rows.Err(); err != nil {
+					return err
+				}
+				for _, row := range batch {
+					if _, err := tx.Exec(update, row...); err != nil {
+						return err
+					}
+				}
+				n = len(batch)
+				return nil
+			})
+			if err != nil {
+				return fmt.Errorf("rekey %s: %w", t.table, err)
+			}
+			total += n
+			if n < rekeyBatch {
+				break
+			}
+		}
+		if total > 0 {
+			slog.Info("stored text rekeyed", "table", t.table, "rows", total, "encrypted", db.key != nil)
+			changed = true
+		}
+	}
+	if !changed {
+		return nil
+	}
+	if _, err := db.exec(`VACUUM`); err != nil {
+		return fmt.Errorf("rekey vacuum: %w", err)
+	}
+	var busy, logPages, checkpointed int64
+	if err := db.queryRow(`PRAGMA wal_checkpoint(TRUNCATE)`).Scan(&busy, &logPages, &checkpointed); err != nil {
+		return fmt.Errorf("rekey checkpoint: %w", err)
+	}
+	return nil
+}
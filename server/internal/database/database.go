@@ -1,53 +1,242 @@
 package database
 
 import (
+	"context"
 	"database/sql"
 	"fmt"
 	"log/slog"
+	"strings"
+	"sync"
 	"time"
 
+	"github.com/c0dev0id/notesd/server/internal/trace"
 	_ "modernc.org/sqlite"
 )
 
 type DB struct {
-	sql *sql.DB
+	sql   *sql.DB
+	ctx   context.Context // parent of the spans traced by this handle
+	stmts *stmtCache
+	// writeMu serializes writes when Options.SerializeWrites is set, nil
+	// otherwise.
+	writeMu *sync.Mutex
+	// tx is the transaction that statements of a Tx run in, nil otherwise.
+	tx *txn
+	// key encrypts note and todo text, nil while it is stored plain.
+	key *contentKey
 }
 
+// Options tunes the connection pool. Zero values keep database/sql's
+// defaults: unlimited open connections, two idle ones, no maximum lifetime.
+type Options struct {
+	MaxOpenConns    int
+	MaxIdleConns    int
+	ConnMaxLifetime time.Duration
+	// SerializeWrites lets one write statement or transaction run at a
+	// time, so concurrent writers queue here instead of contending for
+	// SQLite's lock and failing with SQLITE_BUSY once busy_timeout runs
+	// out.
+	SerializeWrites bool
+	// ContentKey, 32 bytes, encrypts note and todo text (see crypt.go).
+	// PreviousContentKeys still decrypt text stored under earlier keys,
+	// which Open rewrites under ContentKey, or stores plain without one.
+	ContentKey          []byte
+	PreviousContentKeys [][]byte
+}
+
+// DefaultOptions are used by Open.
+var DefaultOptions = Options{SerializeWrites: true}
+
+// Open opens the database at path with DefaultOptions.
 func Open(path string) (*DB, error) {
-	sqldb, err := sql.Open("sqlite", path)
+	return OpenWith(path, DefaultOptions)
+}
+
+// OpenWith opens the database at path, creating or migrating its schema.
+func OpenWith(path string, opts Options) (*DB, error) {
+	// Pragmas in the DSN are applied to every connection the pool opens,
+	// not only the first. Transactions take the write lock when they
+	// begin, so that busy_timeout covers them rather than failing on a
+	// lock upgrade.
+	sep := "?"
+	if strings.Contains(path, "?") {
+		sep = "&"
+	}
+	dsn := path + sep + "_pragma=busy_timeout(5000)&_pragma=foreign_keys(1)&_pragma=journal_mode(WAL)&_txlock=immediate"
+	sqldb, err := sql.Open("sqlite", dsn)
 	if err != nil {
 		return nil, fmt.Errorf("open database: %w", err)
 	}
+	sqldb.SetMaxOpenConns(opts.MaxOpenConns)
+	if opts.MaxIdleConns != 0 {
+		sqldb.SetMaxIdleConns(opts.MaxIdleConns)
+	}
+	sqldb.SetConnMaxLifetime(opts.ConnMaxLifetime)
+	if err := sqldb.Ping(); err != nil {
+		sqldb.Close()
+		return nil, fmt.Errorf("open database: %w", err)
+	}
 
-	// SQLite pragmas for performance and correctness
-	pragmas := []string{
-		"PRAGMA journal_mode=WAL",
-		"PRAGMA foreign_keys=ON",
-		"PRAGMA busy_timeout=5000",
+	db := &DB{sql: sqldb, ctx: context.Background(), stmts: newStmtCache(sqldb)}
+	if opts.SerializeWrites {
+		db.writeMu = &sync.Mutex{}
 	}
-	for _, p := range pragmas {
-		if _, err := sqldb.Exec(p); err != nil {
+	for _, k := range opts.PreviousContentKeys {
+		if _, err := openContentKey(k); err != nil {
 			sqldb.Close()
-			return nil, fmt.Errorf("exec %q: %w", p, err)
+			return nil, fmt.Errorf("previous content key: %w", err)
+		}
+	}
+	if opts.ContentKey != nil {
+		if db.key, err = openContentKey(opts.ContentKey); err != nil {
+			sqldb.Close()
+			return nil, fmt.Errorf("content key: %w", err)
 		}
 	}
-
-	db := &DB{sql: sqldb}
 	if err := db.migrate(); err != nil {
-		sqldb.Close()
+		db.Close()
 		return nil, fmt.Errorf("migrate: %w", err)
 	}
+	if err := db.rekey(); err != nil {
+		db.Close()
+		return nil, err
+	}
 
 	slog.Info("database opened", "path", path)
 	return db, nil
 }
 
 func (db *DB) Close() error {
+	db.stmts.close()
 	return db.sql.Close()
 }
 
+// baselineVersion stands for the schema of the first release, which left
+// user_version at 0.
+const baselineVersion = 1
+
+// SchemaVersion identifies the layout created by schema. It is stored in
+// PRAGMA user_version and bumped whenever the schema changes.
+const SchemaVersion = 28
+
 func (db *DB) migrate() error {
-	_, err := db.sql.Exec(schema)
+	var prev int
+	if err := db.queryRow(`PRAGMA user_version`).Scan(&prev); err != nil {
+		return err
+	}
+	// The first release did not set user_version; its tables are there,
+	// and need every upgrade.
+	if prev == 0 {
+		var tables int
+		if err := db.queryRow(
+			`SELECT COUNT(*) FROM sqlite_master WHERE type = 'table' AND name = 'notes'`,
+		).Scan(&tables); err != nil {
+			return err
+		}
+		if tables > 0 {
+			prev = baselineVersion
+		}
+	}
+	// Snoozing came before version 1 and added snoozed_until only to
+	// schema, which leaves existing tables as they are.
+	if prev == baselineVersion {
+		if err := db.addNoteSnooze(); err != nil {
+			return err
+		}
+	}
+	// Version 4 added reminder_at to todos; schema adds its index.
+	if prev > 0 && prev < 4 {
+		if err := db.addTodoReminders(); err != nil {
+			return err
+		}
+	}
+	// Version 6 added the delta sync columns to notes.
+	if prev > 0 && prev < 6 {
+		if err := db.addNoteContentDeltas(); err != nil {
+			return err
+		}
+	}
+	// Version 7 added the times of the fields merged by sync to todos.
+	if prev > 0 && prev < 7 {
+		if err := db.addTodoFieldTimes(); err != nil {
+			return err
+		}
+	}
+	// Version 13 added refresh token families; existing tokens each start
+	// their own.
+	if prev > 0 && prev < 13 {
+		if err := db.addRefreshTokenFamilies(); err != nil {
+			return err
+		}
+	}
+	// Version 21 added the complete action to the audit log.
+	if prev > 0 && prev < 21 {
+		if err := db.addAuditComplete(); err != nil {
+			return err
+		}
+	}
+	// Version 22 stored word counts, first headings and link counts.
+	if prev > 0 && prev < 22 {
+		if err := db.addNoteStats(); err != nil {
+			return err
+		}
+	}
+	// Version 23 added daily digests.
+	if prev > 0 && prev < 23 {
+		if err := db.addDigestFrequency(); err != nil {
+			return err
+		}
+	}
+	// Version 25 added due times and the all-day flag to todos.
+	if prev > 0 && prev < 25 {
+		if err := db.addTodoAllDay(); err != nil {
+			return err
+		}
+	}
+	// Version 26 added checklists to todos.
+	if prev > 0 && prev < 26 {
+		if err := db.addTodoChecklist(); err != nil {
+			return err
+		}
+	}
+	// Version 27 made note types configurable.
+	if prev > 0 && prev < 27 {
+		if err := db.dropNoteTypeCheck(); err != nil {
+			return err
+		}
+	}
+	// Version 28 stored the front matter of notes as metadata.
+	if prev > 0 && prev < 28 {
+		if err := db.addNoteMetadata(); err != nil {
+			return err
+		}
+	}
+	// A new database frees pages with incremental vacuum. Switching the
+	// mode takes a VACUUM, which costs nothing while the file is empty;
+	// older databases switch with notesd vacuum.
+	if prev == 0 {
+		if err := db.Vacuum(); err != nil {
+			return err
+		}
+	}
+	if _, err := db.exec(schema); err != nil {
+		return err
+	}
+	// Version 8 added note_links; index the notes written before it.
+	if prev > 0 && prev < 8 {
+		if err := db.indexNoteLinks(); err != nil {
+			return err
+		}
+	}
+	// Version 24 added account settings and moved the time zones of digest
+	// settings and Telegram chats into them. Run after schema, which
+	// creates the account_settings table.
+	if prev > 0 && prev < 24 {
+		if err := db.moveTimezones(); err != nil {
+			return err
+		}
+	}
+	_, err := db.exec(fmt.Sprintf("PRAGMA user_version = %d", SchemaVersion))
 	return err
 }
 
@@ -65,7 +254,20 @@ CREATE TABLE IF NOT EXISTS notes (
 	user_id           TEXT NOT NULL REFERENCES users(id),
 	title             TEXT NOT NULL DEFAULT '',
 	content           TEXT NOT NULL DEFAULT '',
-	type              TEXT NOT NULL DEFAULT 'note' CHECK(type IN ('note', 'todo_list')),
+	-- content_hash identifies the content; base_hash and content_patch
+	-- describe the last content change, for delta sync.
+	content_hash      TEXT NOT NULL DEFAULT '',
+	base_hash         TEXT,
+	content_patch     TEXT,
+	-- note, todo_list, bookmark or a type from the notes.types setting.
+	type              TEXT NOT NULL DEFAULT 'note',
+	snoozed_until     INTEGER,
+	-- word_count, first_heading, link_count and metadata (the front
+	-- matter as a JSON object, or empty) are derived from content.
+	word_count        INTEGER NOT NULL DEFAULT 0,
+	first_heading     TEXT NOT NULL DEFAULT '',
+	link_count        INTEGER NOT NULL DEFAULT 0,
+	metadata          TEXT NOT NULL DEFAULT '',
 	modified_at       INTEGER NOT NULL,
 	modified_by_device TEXT NOT NULL,
 	deleted_at        INTEGER,
@@ -82,28 +284,393 @@ CREATE TABLE IF NOT EXISTS todos (
 	line_ref          TEXT,
 	content           TEXT NOT NULL DEFAULT '',
 	due_date          INTEGER,
+	-- 1 when due_date is a calendar day at midnight UTC, not a moment.
+	all_day           INTEGER NOT NULL DEFAULT 0,
+	reminder_at       INTEGER,
 	completed         INTEGER NOT NULL DEFAULT 0,
+	-- The checklist items as a JSON array, or '' for none.
+	checklist         TEXT NOT NULL DEFAULT '',
 	modified_at       INTEGER NOT NULL,
 	modified_by_device TEXT NOT NULL,
 	deleted_at        INTEGER,
-	created_at        INTEGER NOT NULL
+	created_at        INTEGER NOT NULL,
+	-- When each field merged on its own by sync last changed.
+	content_modified_at   INTEGER NOT NULL DEFAULT 0,
+	due_date_modified_at  INTEGER NOT NULL DEFAULT 0,
+	completed_modified_at INTEGER NOT NULL DEFAULT 0,
+	note_id_modified_at   INTEGER NOT NULL DEFAULT 0
 );
 CREATE INDEX IF NOT EXISTS idx_todos_user_id ON todos(user_id);
 CREATE INDEX IF NOT EXISTS idx_todos_modified_at ON todos(modified_at);
 CREATE INDEX IF NOT EXISTS idx_todos_deleted_at ON todos(deleted_at);
 CREATE INDEX IF NOT EXISTS idx_todos_due_date ON todos(due_date);
+CREATE INDEX IF NOT EXISTS idx_todos_user_due ON todos(user_id, due_date) WHERE deleted_at IS NULL;
+CREATE INDEX IF NOT EXISTS idx_todos_reminder_at ON todos(reminder_at) WHERE reminder_at IS NOT NULL;
 
+-- A login starts a token family; every refresh adds a child of the token
+-- it used and marks that one rotated. Rotated tokens are kept until they
+-- expire so that presenting one again is recognised as reuse.
 CREATE TABLE IF NOT EXISTS refresh_tokens (
 	id         TEXT PRIMARY KEY,
 	user_id    TEXT NOT NULL REFERENCES users(id),
 	device_id  TEXT NOT NULL,
 	token_hash TEXT NOT NULL,
+	family_id  TEXT NOT NULL,
+	parent_id  TEXT,
+	rotated_at INTEGER,
 	expires_at INTEGER NOT NULL,
 	created_at INTEGER NOT NULL
 );
 CREATE INDEX IF NOT EXISTS idx_refresh_tokens_user_id ON refresh_tokens(user_id);
+CREATE INDEX IF NOT EXISTS idx_refresh_tokens_family_id ON refresh_tokens(family_id);
+
+CREATE TABLE IF NOT EXISTS password_resets (
+	token_hash TEXT PRIMARY KEY,
+	user_id    TEXT NOT NULL REFERENCES users(id),
+	expires_at INTEGER NOT NULL,
+	created_at INTEGER NOT NULL
+);
+CREATE INDEX IF NOT EXISTS idx_password_resets_user_id ON password_resets(user_id);
+
+CREATE TABLE IF NOT EXISTS access_tokens (
+	id           TEXT PRIMARY KEY,
+	user_id      TEXT NOT NULL REFERENCES users(id),
+	name         TEXT NOT NULL,
+	scope        TEXT NOT NULL,
+	token_hash   TEXT NOT NULL UNIQUE,
+	expires_at   INTEGER,
+	last_used_at INTEGER,
+	created_at   INTEGER NOT NULL
+);
+CREATE INDEX IF NOT EXISTS idx_access_tokens_user_id ON access_tokens(user_id);
+
+-- Registration codes handed out by admins. max_uses 0 is unlimited.
+CREATE TABLE IF NOT EXISTS invites (
+	code       TEXT PRIMARY KEY,
+	max_uses   INTEGER NOT NULL,
+	uses       INTEGER NOT NULL DEFAULT 0,
+	expires_at INTEGER,
+	created_by TEXT NOT NULL REFERENCES users(id),
+	created_at INTEGER NOT NULL
+);
+
+CREATE TABLE IF NOT EXISTS tags (
+	id         TEXT PRIMARY KEY,
+	user_id    TEXT NOT NULL REFERENCES users(id),
+	name       TEXT NOT NULL COLLATE NOCASE,
+	created_at INTEGER NOT NULL,
+	UNIQUE(user_id, name)
+);
+
+CREATE TABLE IF NOT EXISTS note_tags (
+	note_id TEXT NOT NULL REFERENCES notes(id),
+	tag_id  TEXT NOT NULL REFERENCES tags(id),
+	PRIMARY KEY (note_id, tag_id)
+);
+CREATE INDEX IF NOT EXISTS idx_note_tags_tag_id ON note_tags(tag_id);
+
+CREATE TABLE IF NOT EXISTS note_links (
+	note_id TEXT NOT NULL REFERENCES notes(id),
+	target  TEXT NOT NULL,
+	PRIMARY KEY (note_id, target)
+);
+CREATE INDEX IF NOT EXISTS idx_note_links_target ON note_links(target);
+
+CREATE TABLE IF NOT EXISTS todo_tags (
+	todo_id TEXT NOT NULL REFERENCES todos(id),
+	tag_id  TEXT NOT NULL REFERENCES tags(id),
+	PRIMARY KEY (todo_id, tag_id)
+);
+CREATE INDEX IF NOT EXISTS idx_todo_tags_tag_id ON todo_tags(tag_id);
+
+CREATE TABLE IF NOT EXISTS attachments (
+	id           TEXT PRIMARY KEY,
+	user_id      TEXT NOT NULL REFERENCES users(id),
+	note_id      TEXT NOT NULL REFERENCES notes(id),
+	filename     TEXT NOT NULL,
+	content_type TEXT NOT NULL,
+	size         INTEGER NOT NULL,
+	sha256       TEXT NOT NULL,
+	modified_at  INTEGER NOT NULL,
+	deleted_at   INTEGER,
+	created_at   INTEGER NOT NULL
+);
+CREATE INDEX IF NOT EXISTS idx_attachments_note_id ON attachments(note_id);
+CREATE INDEX IF NOT EXISTS idx_attachments_user_modified ON attachments(user_id, modified_at);
+
+CREATE TABLE IF NOT EXISTS public_links (
+	slug       TEXT PRIMARY KEY,
+	user_id    TEXT NOT NULL REFERENCES users(id),
+	note_id    TEXT NOT NULL REFERENCES notes(id),
+	expires_at INTEGER,
+	revoked_at INTEGER,
+	created_at INTEGER NOT NULL
+);
+CREATE INDEX IF NOT EXISTS idx_public_links_note_id ON public_links(note_id);
+
+CREATE TABLE IF NOT EXISTS push_subscriptions (
+	id         TEXT PRIMARY KEY,
+	user_id    TEXT NOT NULL REFERENCES users(id),
+	device_id  TEXT NOT NULL,
+	endpoint   TEXT NOT NULL,
+	p256dh     TEXT NOT NULL,
+	auth       TEXT NOT NULL,
+	created_at INTEGER NOT NULL,
+	UNIQUE(user_id, device_id)
+);
+
+CREATE TABLE IF NOT EXISTS reminder_webhooks (
+	user_id    TEXT PRIMARY KEY REFERENCES users(id),
+	url        TEXT NOT NULL,
+	created_at INTEGER NOT NULL
+);
+
+-- One row per delivered reminder. Keyed by the fire time so moving a
+-- reminder re-arms it.
+CREATE TABLE IF NOT EXISTS reminders_sent (
+	item_type TEXT NOT NULL CHECK(item_type IN ('note', 'todo')),
+	item_id   TEXT NOT NULL,
+	fire_at   INTEGER NOT NULL,
+	sent_at   INTEGER NOT NULL,
+	PRIMARY KEY (item_type, item_id, fire_at)
+);
+
+CREATE TABLE IF NOT EXISTS sync_conflicts (
+	id         TEXT PRIMARY KEY,
+	user_id    TEXT NOT NULL REFERENCES users(id),
+	item_type  TEXT NOT NULL CHECK(item_type IN ('note', 'todo')),
+	item_id    TEXT NOT NULL,
+	device_id  TEXT NOT NULL,
+	created_at INTEGER NOT NULL
+);
+CREATE INDEX IF NOT EXISTS idx_sync_conflicts_user_id ON sync_conflicts(user_id);
+
+-- purged_items remembers hard-deleted items so other devices drop them;
+-- sync_compactions marks how far tombstones were collected per user.
+CREATE TABLE IF NOT EXISTS purged_items (
+	item_type TEXT NOT NULL CHECK(item_type IN ('note', 'todo', 'attachment')),
+	item_id   TEXT NOT NULL,
+	user_id   TEXT NOT NULL REFERENCES users(id),
+	purged_at INTEGER NOT NULL,
+	PRIMARY KEY (item_type, item_id)
+);
+CREATE INDEX IF NOT EXISTS idx_purged_items_user_id ON purged_items(user_id, purged_at);
+
+CREATE TABLE IF NOT EXISTS sync_compactions (
+	user_id          TEXT PRIMARY KEY REFERENCES users(id),
+	compacted_before INTEGER NOT NULL
+);
+
+CREATE TABLE IF NOT EXISTS sync_stats (
+	day          TEXT NOT NULL,
+	user_id      TEXT NOT NULL REFERENCES users(id),
+	pulls        INTEGER NOT NULL DEFAULT 0,
+	pushes       INTEGER NOT NULL DEFAULT 0,
+	items_pulled INTEGER NOT NULL DEFAULT 0,
+	items_pushed INTEGER NOT NULL DEFAULT 0,
+	PRIMARY KEY (day, user_id)
+);
+
+CREATE TABLE IF NOT EXISTS account_settings (
+	user_id  TEXT PRIMARY KEY REFERENCES users(id),
+	timezone TEXT NOT NULL DEFAULT 'UTC'
+);
+
+CREATE TABLE IF NOT EXISTS digest_settings (
+	user_id      TEXT PRIMARY KEY REFERENCES users(id),
+	enabled      INTEGER NOT NULL DEFAULT 0,
+	frequency    TEXT NOT NULL DEFAULT 'weekly',
+	weekday      INTEGER NOT NULL DEFAULT 1,
+	hour         INTEGER NOT NULL DEFAULT 8,
+	last_sent_at INTEGER
+);
+
+-- audit_log is append-only: rows are only ever removed by retention or
+-- account deletion. It has no foreign keys so it outlives purged items.
+CREATE TABLE IF NOT EXISTS audit_log (
+	id          INTEGER PRIMARY KEY AUTOINCREMENT,
+	user_id     TEXT NOT NULL,
+	entity_type TEXT NOT NULL CHECK(entity_type IN ('note', 'todo')),
+	entity_id   TEXT NOT NULL,
+	action      TEXT NOT NULL CHECK(action IN ('create', 'update', 'complete', 'delete', 'restore', 'purge')),
+	device_id   TEXT NOT NULL,
+	at          INTEGER NOT NULL
+);
+CREATE INDEX IF NOT EXISTS idx_audit_log_at ON audit_log(at);
+CREATE INDEX IF NOT EXISTS idx_audit_log_user_id ON audit_log(user_id, at);
+CREATE INDEX IF NOT EXISTS idx_audit_log_entity_id ON audit_log(entity_id);
+
+-- webhooks receive note and todo events; events is a comma-separated
+-- filter, empty for all. webhook_deliveries is the delivery queue and its
+-- history.
+CREATE TABLE IF NOT EXISTS webhooks (
+	id         TEXT PRIMARY KEY,
+	user_id    TEXT NOT NULL REFERENCES users(id),
+	url        TEXT NOT NULL,
+	events     TEXT NOT NULL,
+	secret     TEXT NOT NULL,
+	created_at INTEGER NOT NULL
+);
+CREATE INDEX IF NOT EXISTS idx_webhooks_user_id ON webhooks(user_id);
+
+CREATE TABLE IF NOT EXISTS webhook_deliveries (
+	id              TEXT PRIMARY KEY,
+	webhook_id      TEXT NOT NULL REFERENCES webhooks(id),
+	event           TEXT NOT NULL,
+	payload         TEXT NOT NULL,
+	status          TEXT NOT NULL CHECK(status IN ('pending', 'delivered', 'failed')),
+	attempts        INTEGER NOT NULL DEFAULT 0,
+	response_code   INTEGER NOT NULL DEFAULT 0,
+	error           TEXT NOT NULL DEFAULT '',
+	next_attempt_at INTEGER,
+	last_attempt_at INTEGER,
+	created_at      INTEGER NOT NULL
+);
+CREATE INDEX IF NOT EXISTS idx_webhook_deliveries_due ON webhook_deliveries(status, next_attempt_at);
+CREATE INDEX IF NOT EXISTS idx_webhook_deliveries_webhook_id ON webhook_deliveries(webhook_id, created_at);
+
+CREATE TABLE IF NOT EXISTS calendar_feeds (
+	user_id    TEXT PRIMARY KEY REFERENCES users(id),
+	token_hash TEXT NOT NULL UNIQUE,
+	created_at INTEGER NOT NULL
+);
+
+-- note_feeds holds the hash of each user's Atom feed tokens, one for all
+-- notes and one for published notes only.
+CREATE TABLE IF NOT EXISTS note_feeds (
+	user_id    TEXT NOT NULL REFERENCES users(id),
+	published  INTEGER NOT NULL,
+	token_hash TEXT NOT NULL UNIQUE,
+	created_at INTEGER NOT NULL,
+	PRIMARY KEY (user_id, published)
+);
+
+-- mail_in_addresses holds the hash of the secret local part of each
+-- user's mail-in address.
+CREATE TABLE IF NOT EXISTS mail_in_addresses (
+	user_id    TEXT PRIMARY KEY REFERENCES users(id),
+	token_hash TEXT NOT NULL UNIQUE,
+	created_at INTEGER NOT NULL
+);
+
+-- telegram_chats links a user to the Telegram chat their bot messages go
+-- to. overdue_sent_on is the local date (YYYY-MM-DD) of the last overdue
+-- notice, so it is sent once a day.
+CREATE TABLE IF NOT EXISTS telegram_chats (
+	user_id         TEXT PRIMARY KEY REFERENCES users(id),
+	chat_id         INTEGER NOT NULL UNIQUE,
+	overdue_sent_on TEXT NOT NULL DEFAULT '',
+	linked_at       INTEGER NOT NULL
+);
+
+-- telegram_link_codes holds the hash of each user's pending one-time code
+-- for linking a chat.
+CREATE TABLE IF NOT EXISTS telegram_link_codes (
+	code_hash  TEXT PRIMARY KEY,
+	user_id    TEXT NOT NULL UNIQUE REFERENCES users(id),
+	expires_at INTEGER NOT NULL
+);
+
+-- journal_entries maps a calendar day (YYYY-MM-DD) to the user's journal
+-- note for it, so entries are found however their titles are formatted.
+CREATE TABLE IF NOT EXISTS journal_entries (
+	user_id TEXT NOT NULL REFERENCES users(id),
+	day     TEXT NOT NULL,
+	note_id TEXT NOT NULL REFERENCES notes(id),
+	PRIMARY KEY (user_id, day)
+);
+CREATE INDEX IF NOT EXISTS idx_journal_entries_note_id ON journal_entries(note_id);
+
+CREATE TABLE IF NOT EXISTS journal_settings (
+	user_id      TEXT PRIMARY KEY REFERENCES users(id),
+	title_format TEXT NOT NULL,
+	template     TEXT NOT NULL DEFAULT ''
+);
 `
 
+// Tx is a DB whose statements run inside one transaction. Methods that
+// open a transaction of their own join it instead, so several of them
+// commit or roll back together. A Tx is valid only until the function
+// passed to WithTx returns.
+type Tx struct {
+	*DB
+}
+
+// WithTx runs fn in a transaction traced under ctx, committing if fn
+// returns nil and rolling back otherwise. Inside a Tx, fn joins it.
+func (db *DB) WithTx(ctx context.Context, fn func(tx *Tx) error) error {
+	db = db.WithContext(ctx)
+	return db.withTx(func(t *txn) error {
+		c := *db
+		c.ctx, c.tx = t.ctx, t
+		return fn(&Tx{&c})
+	})
+}
+
+// withTx runs fn inside a transaction, committing on success and rolling
+// back on error. The transaction is traced as one span with a child span per
+// statement. Inside a Tx, fn runs in its transaction.
+func (db *DB) withTx(fn func(tx *txn) error) (err error) {
+	if db.tx != nil {
+		return fn(db.tx)
+	}
+	start := time.Now()
+	ctx, span := trace.StartSpan(db.ctx, "TRANSACTION", trace.KindClient)
+	span.SetAttr("db.system.name", "sqlite")
+	defer func() {
+		record(db.ctx, 0, time.Since(start))
+		span.SetError(err)
+		span.End()
+	}()
+
+	unlock := db.lockWrites()
+	defer unlock()
+	sqltx, err := db.sql.Begin()
+	if err != nil {
+		return fmt.Errorf("begin tx: %w", err)
+	}
+	defer sqltx.Rollback()
+
+	if err := fn(&txn{tx: sqltx, ctx: ctx, stmts: db.stmts, key: db.key}); err != nil {
+		return err
+	}
+	if err := sqltx.Commit(); err != nil {
+		return fmt.Errorf("commit tx: %w", err)
+	}
+	return nil
+}
+
+// readTx runs fn with a handle whose queries share a read transaction, so
+// that they see one snapshot, e.g. a count and the page it counts. It does
+// not take the write lock. Inside a Tx, fn runs in its transaction.
+func (db *DB) readTx(fn func(db *DB) error) (err error) {
+	if db.tx != nil {
+		return fn(db)
+	}
+	start := time.Now()
+	ctx, span := trace.StartSpan(db.ctx, "TRANSACTION", trace.KindClient)
+	span.SetAttr("db.system.name", "sqlite")
+	defer func() {
+		record(db.ctx, 0, time.Since(start))
+		span.SetError(err)
+		span.End()
+	}()
+
+	// A read-only transaction begins deferred despite _txlock=immediate.
+	sqltx, err := db.sql.BeginTx(context.Background(), &sql.TxOptions{ReadOnly: true})
+	if err != nil {
+		return fmt.Errorf("begin read tx: %w", err)
+	}
+	defer sqltx.Rollback()
+
+	c := *db
+	c.ctx, c.tx = ctx, &txn{tx: sqltx, ctx: ctx, stmts: db.stmts, key: db.key}
+	if err := fn(&c); err != nil {
+		return err
+	}
+	return sqltx.Commit()
+}
+
 // Timestamp helpers for DB ↔ time.Time conversion.
 
 func toMillis(t time.Time) int64 {
@@ -1,10 +1,18 @@
 package database
 
 import (
+	"bytes"
+	"context"
+	"database/sql"
+	"errors"
+	"fmt"
 	"os"
+	"path/filepath"
+	"strings"
 	"testing"
 	"time"
 
+	"github.com/c0dev0id/notesd/server/internal/delta"
 	"github.com/c0dev0id/notesd/server/internal/model"
 )
 
@@ -187,7 +195,7 @@ func TestListNotes(t *testing.T) {
 	}
 
 	// Act
-	notes, total, err := db.ListNotes(u.ID, 10, 0)
+	notes, total, err := db.ListNotes(u.ID, NoteFilter{}, 10, 0)
 
 	// Assert
 	if err != nil {
@@ -221,7 +229,7 @@ func TestListNotesPagination(t *testing.T) {
 	}
 
 	// Act
-	notes, total, err := db.ListNotes(u.ID, 2, 0)
+	notes, total, err := db.ListNotes(u.ID, NoteFilter{}, 2, 0)
 
 	// Assert
 	if err != nil {
@@ -284,7 +292,7 @@ func TestDeleteNote(t *testing.T) {
 	}
 
 	// Act — soft delete
-	err := db.DeleteNote(n.ID, u.ID, model.NowMillis().UnixMilli(), "dev1")
+	_, err := db.DeleteNote(n.ID, u.ID, model.NowMillis().UnixMilli(), "dev1", KeepTodos)
 
 	// Assert
 	if err != nil {
@@ -307,6 +315,111 @@ func TestDeleteNote(t *testing.T) {
 	}
 }
 
+
+func TestDeleteNoteCascade(t *testing.T) {
+	for _, cascade := range []TodoCascade{KeepTodos, DeleteTodos, DetachTodos} {
+		db := testDB(t)
+		u := testUser(t, db)
+		now := model.NowMillis()
+
+		// Arrange: a note with a todo, and a todo on another note
+		n := &model.Note{ID: model.NewID(), UserID: u.ID, Title: "Trip", Type: "note",
+			ModifiedAt: now, ModifiedByDevice: "dev1", CreatedAt: now}
+		other := &model.Note{ID: model.NewID(), UserID: u.ID, Title: "Other", Type: "note",
+			ModifiedAt: now, ModifiedByDevice: "dev1", CreatedAt: now}
+		for _, note := range []*model.Note{n, other} {
+			if err := db.CreateNote(note); err != nil {
+				t.Fatalf("CreateNote: %v", err)
+			}
+		}
+		line := "L1"
+		todo := &model.Todo{ID: model.NewID(), UserID: u.ID, NoteID: &n.ID, LineRef: &line, Content: "pack",
+			ModifiedAt: now, ModifiedByDevice: "dev1", CreatedAt: now}
+		kept := &model.Todo{ID: model.NewID(), UserID: u.ID, NoteID: &other.ID, Content: "unrelated",
+			ModifiedAt: now, ModifiedByDevice: "dev1", CreatedAt: now}
+		for _, td := range []*model.Todo{todo, kept} {
+			if err := db.CreateTodo(td); err != nil {
+				t.Fatalf("CreateTodo: %v", err)
+			}
+		}
+
+		// Act
+		at := now.Add(time.Second)
+		c, err := db.DeleteNote(n.ID, u.ID, at.UnixMilli(), "dev2", cascade)
+		if err != nil {
+			t.Fatalf("%s: DeleteNote: %v", cascade, err)
+		}
+		got, _ := db.GetTodoAny(todo.ID, u.ID)
+		changes, _ := db.GetTodoChangesSince(u.ID, now.UnixMilli())
+
+		// Assert
+		t.Logf("%s: cascaded %+v, todo %+v, %d changes", cascade, c, got, len(changes))
+		switch cascade {
+		case KeepTodos:
+			if len(c.Deleted)+len(c.Detached) != 0 || got.DeletedAt != nil || got.NoteID == nil || len(changes) != 0 {
+				t.Errorf("keep: expected the todo untouched")
+			}
+		case DeleteTodos:
+			if len(c.Deleted) != 1 || got.DeletedAt == nil || !got.ModifiedAt.Equal(at) {
+				t.Errorf("delete: expected the todo deleted at %v", at)
+			}
+		case DetachTodos:
+			if len(c.Detached) != 1 || got.DeletedAt != nil || got.NoteID != nil || got.LineRef != nil ||
+				!got.FieldTimes.NoteID.Equal(at) {
+				t.Errorf("detach: expected the todo detached at %v", at)
+			}
+		}
+		if cascade != KeepTodos && (len(changes) != 1 || changes[0].ID != todo.ID || changes[0].ModifiedByDevice != "dev2") {
+			t.Errorf("%s: expected only the cascaded todo in the change stream, got %d changes", cascade, len(changes))
+		}
+	}
+}
+func TestWithTx(t *testing.T) {
+	db := testDB(t)
+	u := testUser(t, db)
+	now := model.NowMillis()
+	note := func(title string) *model.Note {
+		return &model.Note{ID: model.NewID(), UserID: u.ID, Title: title, Type: "note",
+			ModifiedAt: now, ModifiedByDevice: "dev1", CreatedAt: now}
+	}
+	kept, lost := note("Kept"), note("Lost")
+	errFail := errors.New("fail")
+
+	// Act: one transaction commits, one fails after a nested write
+	err := db.WithTx(context.Background(), func(tx *Tx) error {
+		return tx.CreateNote(kept)
+	})
+	if err != nil {
+		t.Fatalf("WithTx: %v", err)
+	}
+	failed := db.WithTx(context.Background(), func(tx *Tx) error {
+		if err := tx.CreateNote(lost); err != nil {
+			return err
+		}
+		// UpsertNote opens a transaction of its own, which joins tx.
+		lost.Title = "Lost again"
+		lost.ModifiedAt = now.Add(time.Second)
+		if _, _, err := tx.UpsertNote(lost); err != nil {
+			return err
+		}
+		return errFail
+	})
+	_, keptErr := db.GetNote(kept.ID, u.ID)
+	_, lostErr := db.GetNoteAny(lost.ID, u.ID)
+
+	// Assert
+	t.Logf("commit: %v, rollback: %v, kept: %v, lost: %v", err, failed, keptErr, lostErr)
+	if !errors.Is(failed, errFail) {
+		t.Errorf("WithTx returned %v, want %v", failed, errFail)
+	}
+	if keptErr != nil {
+		t.Errorf("committed note: %v", keptErr)
+	}
+	if !errors.Is(lostErr, ErrNotFound) {
+		t.Errorf("rolled back note: got %v, want ErrNotFound", lostErr)
+	}
+}
+
 func TestSearchNotes(t *testing.T) {
 	db := testDB(t)
 	u := testUser(t, db)
@@ -330,7 +443,7 @@ func TestSearchNotes(t *testing.T) {
 	}
 
 	// Act
-	results, total, err := db.SearchNotes(u.ID, "milk", 10, 0)
+	results, total, err := db.SearchNotes(u.ID, "milk", NoteFilter{}, 10, 0)
 
 	// Assert
 	if err != nil {
@@ -480,25 +593,31 @@ func TestGetOverdueTodos(t *testing.T) {
 	db := testDB(t)
 	u := testUser(t, db)
 	now := model.NowMillis()
+	today := now.Truncate(24 * time.Hour)
 
-	// Arrange — one overdue, one future, one no due date, one completed overdue
+	// Arrange — one overdue, one future, one no due date, one completed
+	// overdue, and all-day todos due yesterday and today
 	past := now.Add(-24 * time.Hour)
 	future := now.Add(24 * time.Hour)
+	yesterday := today.AddDate(0, 0, -1)
 
 	todos := []struct {
 		content   string
 		dueDate   *time.Time
+		allDay    bool
 		completed bool
 	}{
-		{"overdue task", &past, false},
-		{"future task", &future, false},
-		{"no due date", nil, false},
-		{"completed overdue", &past, true},
+		{"overdue task", &past, false, false},
+		{"future task", &future, false, false},
+		{"no due date", nil, false, false},
+		{"completed overdue", &past, false, true},
+		{"all day yesterday", &yesterday, true, false},
+		{"all day today", &today, true, false},
 	}
 	for _, td := range todos {
 		todo := &model.Todo{
 			ID: model.NewID(), UserID: u.ID,
-			Content: td.content, DueDate: td.dueDate, Completed: td.completed,
+			Content: td.content, DueDate: td.dueDate, AllDay: td.allDay, Completed: td.completed,
 			ModifiedAt: now, ModifiedByDevice: "dev1", CreatedAt: now,
 		}
 		if err := db.CreateTodo(todo); err != nil {
@@ -507,21 +626,20 @@ func TestGetOverdueTodos(t *testing.T) {
 	}
 
 	// Act
-	overdue, err := db.GetOverdueTodos(u.ID)
+	overdue, err := db.GetOverdueTodos(u.ID, now, today)
 
 	// Assert
 	if err != nil {
 		t.Fatalf("GetOverdueTodos: %v", err)
 	}
 	t.Logf("overdue todos: %d", len(overdue))
+	got := map[string]bool{}
 	for _, td := range overdue {
-		t.Logf("  - %q due=%v completed=%v", td.Content, td.DueDate, td.Completed)
-	}
-	if len(overdue) != 1 {
-		t.Errorf("expected 1 overdue todo, got %d", len(overdue))
+		t.Logf("  - %q due=%v all_day=%v completed=%v", td.Content, td.DueDate, td.AllDay, td.Completed)
+		got[td.Content] = true
 	}
-	if len(overdue) > 0 && overdue[0].Content != "overdue task" {
-		t.Errorf("expected 'overdue task', got %q", overdue[0].Content)
+	if len(overdue) != 2 || !got["overdue task"] || !got["all day yesterday"] {
+		t.Errorf("expected 'overdue task' and 'all day yesterday', got %v", got)
 	}
 }
 
@@ -583,7 +701,7 @@ func TestUpsertNoteLWW(t *testing.T) {
 		Type: "note", ModifiedAt: now.Add(-1 * time.Hour), ModifiedByDevice: "client",
 		CreatedAt: now,
 	}
-	conflict, err := db.UpsertNote(older)
+	conflict, _, err := db.UpsertNote(older)
 
 	// Assert
 	if err != nil {
@@ -604,7 +722,7 @@ func TestUpsertNoteLWW(t *testing.T) {
 		Type: "note", ModifiedAt: now.Add(1 * time.Hour), ModifiedByDevice: "client",
 		CreatedAt: now,
 	}
-	conflict, err = db.UpsertNote(newer)
+	conflict, _, err = db.UpsertNote(newer)
 
 	// Assert
 	if err != nil {
@@ -691,6 +809,54 @@ func TestDeleteRefreshTokensByUser(t *testing.T) {
 	t.Logf("deleted all tokens for user %s", u.ID)
 }
 
+func TestRefreshTokenFamily(t *testing.T) {
+	db := testDB(t)
+	u := testUser(t, db)
+	now := model.NowMillis()
+
+	// Arrange — a login token, its rotated child, and another login
+	newToken := func(parent *model.RefreshToken) *model.RefreshToken {
+		rt := &model.RefreshToken{
+			ID: model.NewID(), UserID: u.ID, DeviceID: "dev",
+			TokenHash: HashToken(model.NewID()), ExpiresAt: now.Add(time.Hour), CreatedAt: now,
+		}
+		if parent != nil {
+			rt.FamilyID, rt.ParentID = parent.FamilyID, parent.ID
+		}
+		if err := db.CreateRefreshToken(rt); err != nil {
+			t.Fatalf("CreateRefreshToken: %v", err)
+		}
+		return rt
+	}
+	root := newToken(nil)
+	child := newToken(root)
+	other := newToken(nil)
+
+	// Act
+	first := db.RotateRefreshToken(root.ID)
+	second := db.RotateRefreshToken(root.ID)
+	rotated, _ := db.GetRefreshTokenByHash(root.TokenHash)
+	n, err := db.DeleteRefreshTokenFamily(root.FamilyID)
+
+	// Assert
+	t.Logf("rotate: first=%v second=%v; family %s deleted %d (err %v)", first, second, root.FamilyID, n, err)
+	if first != nil || second != ErrNotFound {
+		t.Errorf("expected the first rotation to succeed and the second to fail with ErrNotFound")
+	}
+	if rotated == nil || rotated.RotatedAt == nil {
+		t.Errorf("rotated token has no rotated_at")
+	}
+	if err != nil || n != 2 {
+		t.Errorf("expected 2 tokens deleted, got %d (err %v)", n, err)
+	}
+	if _, err := db.GetRefreshTokenByHash(child.TokenHash); err != ErrNotFound {
+		t.Errorf("child token survived family revocation: %v", err)
+	}
+	if got, err := db.GetRefreshTokenByHash(other.TokenHash); err != nil || got.FamilyID != other.ID {
+		t.Errorf("unrelated token: got %+v (err %v)", got, err)
+	}
+}
+
 func TestDeleteExpiredRefreshTokens(t *testing.T) {
 	db := testDB(t)
 	u := testUser(t, db)
@@ -788,7 +954,7 @@ func TestUpsertTodoLWW(t *testing.T) {
 		Content: "Client Version", ModifiedAt: now.Add(-1 * time.Hour),
 		ModifiedByDevice: "client", CreatedAt: now,
 	}
-	conflict, err := db.UpsertTodo(older)
+	conflict, _, _, err := db.UpsertTodo(older)
 
 	// Assert
 	if err != nil {
@@ -805,7 +971,7 @@ func TestUpsertTodoLWW(t *testing.T) {
 		Content: "Client Wins", ModifiedAt: now.Add(1 * time.Hour),
 		ModifiedByDevice: "client", CreatedAt: now,
 	}
-	conflict, err = db.UpsertTodo(newer)
+	conflict, _, _, err = db.UpsertTodo(newer)
 
 	// Assert
 	if err != nil {
@@ -834,7 +1000,7 @@ func TestUpsertTodoInsertNew(t *testing.T) {
 		Content: "New via upsert", ModifiedAt: now,
 		ModifiedByDevice: "phone", CreatedAt: now,
 	}
-	conflict, err := db.UpsertTodo(todo)
+	conflict, _, _, err := db.UpsertTodo(todo)
 
 	// Assert
 	if err != nil {
@@ -854,6 +1020,141 @@ func TestUpsertTodoInsertNew(t *testing.T) {
 	}
 }
 
+func TestUpsertTodoFieldMerge(t *testing.T) {
+	db := testDB(t)
+	u := testUser(t, db)
+	now := model.NowMillis()
+	due := now.Add(48 * time.Hour)
+
+	// Arrange — a todo completed on the server after the client last synced
+	todo := &model.Todo{
+		ID: model.NewID(), UserID: u.ID,
+		Content: "Pay rent", ModifiedAt: now,
+		ModifiedByDevice: "phone", CreatedAt: now,
+	}
+	if err := db.CreateTodo(todo); err != nil {
+		t.Fatalf("CreateTodo: %v", err)
+	}
+	todo.Completed = true
+	todo.ModifiedAt = now.Add(time.Minute)
+	if err := db.UpdateTodo(todo); err != nil {
+		t.Fatalf("UpdateTodo: %v", err)
+	}
+
+	// Act — the client reschedules its stale copy later still
+	resched := &model.Todo{
+		ID: todo.ID, UserID: u.ID,
+		Content: "Pay rent", DueDate: &due, ModifiedAt: now.Add(2 * time.Minute),
+		ModifiedByDevice: "laptop", CreatedAt: now,
+		FieldTimes: &model.TodoFieldTimes{
+			Content: now, DueDate: now.Add(2 * time.Minute), Completed: now, NoteID: now,
+		},
+	}
+	stored, _, applied, err := db.UpsertTodo(resched)
+
+	// Assert — both edits survive and the client is told about the merge
+	if err != nil {
+		t.Fatalf("UpsertTodo: %v", err)
+	}
+	got, _ := db.GetTodo(todo.ID, u.ID)
+	t.Logf("merged: completed=%v due=%v applied=%v stored=%v", got.Completed, got.DueDate, applied, stored != nil)
+	if !got.Completed || got.DueDate == nil || !got.DueDate.Equal(due) {
+		t.Errorf("expected completion and due date kept, got completed=%v due=%v", got.Completed, got.DueDate)
+	}
+	if !applied || stored == nil || !stored.Completed {
+		t.Errorf("expected merged version returned, got applied=%v stored=%+v", applied, stored)
+	}
+	if !got.FieldTimes.Completed.Equal(now.Add(time.Minute)) || !got.FieldTimes.DueDate.Equal(now.Add(2*time.Minute)) {
+		t.Errorf("field times: %+v", got.FieldTimes)
+	}
+
+	// Act — a push whose every field is older than the stored ones loses
+	stale := *resched
+	stale.Content = "Stale"
+	stale.ModifiedAt = now.Add(-time.Second)
+	stale.FieldTimes = nil
+	stored, _, applied, err = db.UpsertTodo(&stale)
+
+	// Assert
+	if err != nil || applied || stored == nil {
+		t.Errorf("expected stale push to conflict, got applied=%v err=%v", applied, err)
+	}
+}
+
+func TestMergeTodoTies(t *testing.T) {
+	// Arrange — two versions whose every field changed at the same moment
+	now := model.NowMillis()
+	due, later := now.Add(24*time.Hour), now.Add(48*time.Hour)
+	note := "n1"
+	times := &model.TodoFieldTimes{Content: now, DueDate: now, Completed: now, NoteID: now}
+	phone := &model.Todo{ID: "t1", Content: "Pay rent in cash", DueDate: &later, Completed: true,
+		ModifiedAt: now, ModifiedByDevice: "phone", FieldTimes: times}
+	laptop := &model.Todo{ID: "t1", Content: "Pay rent", DueDate: &due, NoteID: &note,
+		ModifiedAt: now, ModifiedByDevice: "laptop", FieldTimes: times}
+
+	// Act — merge either way round
+	a, _, _ := mergeTodo(phone, laptop)
+	b, _, _ := mergeTodo(laptop, phone)
+
+	// Assert — the same greater value of each field wins both times
+	for _, m := range []model.Todo{a, b} {
+		t.Logf("merged: content=%q due=%v completed=%v note=%v", m.Content, m.DueDate, m.Completed, m.NoteID)
+		if m.Content != "Pay rent in cash" || m.DueDate == nil || !m.DueDate.Equal(later) || !m.Completed ||
+			m.NoteID == nil || *m.NoteID != "n1" {
+			t.Errorf("unexpected merge: %+v", m)
+		}
+	}
+}
+
+func TestUpsertTodoChecklist(t *testing.T) {
+	db := testDB(t)
+	u := testUser(t, db)
+	now := model.NowMillis()
+
+	// Arrange — a todo with a checklist, half of it done
+	todo := &model.Todo{
+		ID: model.NewID(), UserID: u.ID,
+		Content: "Pack", ModifiedAt: now,
+		ModifiedByDevice: "phone", CreatedAt: now,
+		Checklist: []model.ChecklistItem{{ID: "a", Text: "passport", Done: true}, {ID: "b", Text: "socks"}},
+	}
+	if err := db.CreateTodo(todo); err != nil {
+		t.Fatalf("CreateTodo: %v", err)
+	}
+
+	// Act — a client that knows no checklists pushes a newer version
+	old := &model.Todo{
+		ID: todo.ID, UserID: u.ID,
+		Content: "Pack bags", ModifiedAt: now.Add(time.Minute),
+		ModifiedByDevice: "laptop", CreatedAt: now,
+	}
+	_, _, applied, err := db.UpsertTodo(old)
+
+	// Assert — the checklist is kept
+	if err != nil || !applied {
+		t.Fatalf("UpsertTodo: applied=%v err=%v", applied, err)
+	}
+	got, _ := db.GetTodo(todo.ID, u.ID)
+	t.Logf("after old client: %q %+v progress %v", got.Content, got.Checklist, *got.Progress)
+	if got.Content != "Pack bags" || len(got.Checklist) != 2 || *got.Progress != 50 {
+		t.Errorf("expected content taken and checklist kept, got %+v", got)
+	}
+
+	// Act — a newer version with an emptied checklist
+	old.Checklist = []model.ChecklistItem{}
+	old.ModifiedAt = now.Add(2 * time.Minute)
+	if _, _, _, err := db.UpsertTodo(old); err != nil {
+		t.Fatalf("UpsertTodo: %v", err)
+	}
+
+	// Assert
+	got, _ = db.GetTodo(todo.ID, u.ID)
+	t.Logf("after clearing: %+v progress %v", got.Checklist, got.Progress)
+	if len(got.Checklist) != 0 || got.Progress != nil {
+		t.Errorf("expected the checklist cleared, got %+v", got.Checklist)
+	}
+}
+
 func TestListTodosPagination(t *testing.T) {
 	db := testDB(t)
 	u := testUser(t, db)
@@ -872,7 +1173,7 @@ func TestListTodosPagination(t *testing.T) {
 	}
 
 	// Act
-	todos, total, err := db.ListTodos(u.ID, 2, 0)
+	todos, total, err := db.ListTodos(u.ID, TodoFilter{}, 2, 0)
 
 	// Assert
 	if err != nil {
@@ -887,7 +1188,7 @@ func TestListTodosPagination(t *testing.T) {
 	}
 
 	// Second page
-	todos2, _, err := db.ListTodos(u.ID, 2, 2)
+	todos2, _, err := db.ListTodos(u.ID, TodoFilter{}, 2, 2)
 	if err != nil {
 		t.Fatalf("ListTodos page 2: %v", err)
 	}
@@ -953,7 +1254,7 @@ func TestSearchNotesNoResults(t *testing.T) {
 	u := testUser(t, db)
 
 	// Act — search with no notes in DB
-	results, total, err := db.SearchNotes(u.ID, "nonexistent", 10, 0)
+	results, total, err := db.SearchNotes(u.ID, "nonexistent", NoteFilter{}, 10, 0)
 
 	// Assert
 	if err != nil {
@@ -965,6 +1266,66 @@ func TestSearchNotesNoResults(t *testing.T) {
 	}
 }
 
+func TestSnippet(t *testing.T) {
+	long := strings.Repeat("a ", 40) + "needle" + strings.Repeat(" b", 80)
+	for _, tc := range []struct{ content, query, want string }{
+		{"short\ntext with Needle", "needle", "short text with Needle"},
+		{"no match here", "title", "no match here"},
+		{long, "NEEDLE", "…" + long[80-40:80] + "needle" + long[86:86+94] + "…"},
+	} {
+		// Act
+		got := snippet(tc.content, tc.query)
+
+		// Assert
+		t.Logf("%q -> %q", tc.query, got)
+		if got != tc.want {
+			t.Errorf("snippet(%q): got %q, want %q", tc.query, got, tc.want)
+		}
+	}
+}
+
+func TestParseQuery(t *testing.T) {
+	for _, tc := range []struct{ in, want, err string }{
+		{in: `milk`, want: `=milk`},
+		{in: `Tag:Work -type:todo "buy milk" -eggs`, want: `tag=Work -type=todo ="buy milk" -=eggs`},
+		{in: `title:"two words" after:2026-01-02 http://x`, want: `title="two words" after=2026-01-02 =http://x`},
+		{in: `- foo:bar`, want: `=- =foo:bar`},
+		{in: `"open`, err: "unterminated quoted phrase"},
+		{in: `tag:`, err: "tag: needs a value"},
+		{in: `-before:2026-01-01`, err: "before: cannot be negated"},
+		{in: `after:soon`, err: `after: "soon" is not a date (YYYY-MM-DD) or RFC 3339 time`},
+		{in: `type:page`, err: `type: "page" is not todo or a note type (note, todo_list)`},
+		{in: `  `, err: "empty query"},
+	} {
+		// Act
+		q, err := ParseQuery(tc.in, []string{"note", "todo_list"})
+
+		// Assert
+		var terms []string
+		for _, term := range q {
+			s := term.Field + "=" + term.Value
+			if strings.Contains(term.Value, " ") {
+				s = term.Field + `="` + term.Value + `"`
+			}
+			if term.Negated {
+				s = "-" + s
+			}
+			terms = append(terms, s)
+		}
+		got := strings.Join(terms, " ")
+		t.Logf("%q -> %q, %v", tc.in, got, err)
+		if tc.err != "" {
+			if err == nil || err.Error() != tc.err {
+				t.Errorf("%q: got error %v, want %q", tc.in, err, tc.err)
+			}
+			continue
+		}
+		if err != nil || got != tc.want {
+			t.Errorf("%q: got %q, %v, want %q", tc.in, got, err, tc.want)
+		}
+	}
+}
+
 func TestGetNoteAnyNotFound(t *testing.T) {
 	db := testDB(t)
 	u := testUser(t, db)
@@ -996,3 +1357,1324 @@ func TestHashToken(t *testing.T) {
 		t.Errorf("expected 64 char hex string, got %d", len(hash1))
 	}
 }
+
+func TestMergeNotesRollsBackOnMissingSource(t *testing.T) {
+	db := testDB(t)
+	u := testUser(t, db)
+	now := model.NowMillis()
+
+	// Arrange
+	target := &model.Note{
+		ID: model.NewID(), UserID: u.ID, Title: "Target", Content: "a",
+		Type: "note", ModifiedAt: now, ModifiedByDevice: "dev1", CreatedAt: now,
+	}
+	if err := db.CreateNote(target); err != nil {
+		t.Fatalf("CreateNote: %v", err)
+	}
+	target.Content = "changed"
+
+	// Act
+	_, err := db.MergeNotes(target, []string{"does-not-exist"})
+
+	// Assert
+	t.Logf("merge with missing source: err=%v", err)
+	if err != ErrNotFound {
+		t.Fatalf("expected ErrNotFound, got %v", err)
+	}
+	got, _ := db.GetNote(target.ID, u.ID)
+	if got.Content != "a" {
+		t.Errorf("target content should be rolled back: got %q", got.Content)
+	}
+}
+
+func TestUpdateNoteKeepsTagsWhenNil(t *testing.T) {
+	db := testDB(t)
+	u := testUser(t, db)
+	now := model.NowMillis()
+
+	// Arrange
+	n := &model.Note{
+		ID: model.NewID(), UserID: u.ID, Title: "Tagged", Type: "note",
+		Tags: []string{"a", "b"}, ModifiedAt: now, ModifiedByDevice: "dev1", CreatedAt: now,
+	}
+	if err := db.CreateNote(n); err != nil {
+		t.Fatalf("CreateNote: %v", err)
+	}
+
+	// Act: nil tags leave links alone, an empty slice clears them
+	n.Tags = nil
+	n.Title = "Renamed"
+	if err := db.UpdateNote(n); err != nil {
+		t.Fatalf("UpdateNote: %v", err)
+	}
+	kept, _ := db.GetNote(n.ID, u.ID)
+	n.Tags = []string{}
+	if err := db.UpdateNote(n); err != nil {
+		t.Fatalf("UpdateNote: %v", err)
+	}
+	cleared, _ := db.GetNote(n.ID, u.ID)
+
+	// Assert
+	t.Logf("kept=%v cleared=%v", kept.Tags, cleared.Tags)
+	if len(kept.Tags) != 2 {
+		t.Errorf("nil tags should keep links, got %v", kept.Tags)
+	}
+	if cleared.Tags == nil || len(cleared.Tags) != 0 {
+		t.Errorf("empty tags should clear links to [], got %#v", cleared.Tags)
+	}
+}
+
+func TestListNotesSnoozeFilter(t *testing.T) {
+	db := testDB(t)
+	u := testUser(t, db)
+	now := model.NowMillis()
+	future := now.Add(time.Hour)
+	past := now.Add(-time.Hour)
+
+	// Arrange: one note snoozed into the future, one whose snooze has lapsed
+	for _, until := range []*time.Time{&future, &past} {
+		n := &model.Note{
+			ID: model.NewID(), UserID: u.ID, Title: "Note", Type: "note",
+			SnoozedUntil: until, ModifiedAt: now, ModifiedByDevice: "dev1", CreatedAt: now,
+		}
+		if err := db.CreateNote(n); err != nil {
+			t.Fatalf("CreateNote: %v", err)
+		}
+	}
+
+	// Act
+	_, visible, err := db.ListNotes(u.ID, NoteFilter{}, 10, 0)
+	if err != nil {
+		t.Fatalf("ListNotes: %v", err)
+	}
+	snoozed, total, err := db.ListNotes(u.ID, NoteFilter{Snoozed: true}, 10, 0)
+	if err != nil {
+		t.Fatalf("ListNotes snoozed: %v", err)
+	}
+
+	// Assert
+	t.Logf("visible=%d snoozed=%d", visible, total)
+	if visible != 1 || total != 1 {
+		t.Errorf("expected 1 visible and 1 snoozed, got %d and %d", visible, total)
+	}
+	if len(snoozed) == 1 && !snoozed[0].SnoozedUntil.Equal(future) {
+		t.Errorf("snoozed_until: got %v, want %v", snoozed[0].SnoozedUntil, future)
+	}
+}
+
+// reopenAs runs stmts on the database at path, which db has open, to take
+// it back to an earlier layout, and opens it again.
+func reopenAs(t *testing.T, db *DB, path string, stmts ...string) *DB {
+	t.Helper()
+	for _, stmt := range stmts {
+		if _, err := db.sql.Exec(stmt); err != nil {
+			t.Fatalf("downgrade: %s: %v", stmt, err)
+		}
+	}
+	db.Close()
+	db, err := Open(path)
+	if err != nil {
+		t.Fatalf("reopen database: %v", err)
+	}
+	t.Cleanup(func() { db.Close() })
+	return db
+}
+
+// tempDBPath returns the path of a new database file removed after the
+// test.
+func tempDBPath(t *testing.T) string {
+	t.Helper()
+	f, err := os.CreateTemp("", "notesd-test-*.db")
+	if err != nil {
+		t.Fatalf("create temp file: %v", err)
+	}
+	f.Close()
+	t.Cleanup(func() { os.Remove(f.Name()) })
+	return f.Name()
+}
+
+// baselineSchema is the schema of the first release, which left
+// user_version at 0.
+const baselineSchema = `
+CREATE TABLE IF NOT EXISTS users (
+	id           TEXT PRIMARY KEY,
+	email        TEXT UNIQUE NOT NULL,
+	password_hash TEXT NOT NULL,
+	display_name TEXT NOT NULL,
+	created_at   INTEGER NOT NULL
+);
+
+CREATE TABLE IF NOT EXISTS notes (
+	id                TEXT PRIMARY KEY,
+	user_id           TEXT NOT NULL REFERENCES users(id),
+	title             TEXT NOT NULL DEFAULT '',
+	content           TEXT NOT NULL DEFAULT '',
+	type              TEXT NOT NULL DEFAULT 'note' CHECK(type IN ('note', 'todo_list')),
+	modified_at       INTEGER NOT NULL,
+	modified_by_device TEXT NOT NULL,
+	deleted_at        INTEGER,
+	created_at        INTEGER NOT NULL
+);
+CREATE INDEX IF NOT EXISTS idx_notes_user_id ON notes(user_id);
+CREATE INDEX IF NOT EXISTS idx_notes_modified_at ON notes(modified_at);
+CREATE INDEX IF NOT EXISTS idx_notes_deleted_at ON notes(deleted_at);
+
+CREATE TABLE IF NOT EXISTS todos (
+	id                TEXT PRIMARY KEY,
+	user_id           TEXT NOT NULL REFERENCES users(id),
+	note_id           TEXT REFERENCES notes(id),
+	line_ref          TEXT,
+	content           TEXT NOT NULL DEFAULT '',
+	due_date          INTEGER,
+	completed         INTEGER NOT NULL DEFAULT 0,
+	modified_at       INTEGER NOT NULL,
+	modified_by_device TEXT NOT NULL,
+	deleted_at        INTEGER,
+	created_at        INTEGER NOT NULL
+);
+CREATE INDEX IF NOT EXISTS idx_todos_user_id ON todos(user_id);
+CREATE INDEX IF NOT EXISTS idx_todos_modified_at ON todos(modified_at);
+CREATE INDEX IF NOT EXISTS idx_todos_deleted_at ON todos(deleted_at);
+CREATE INDEX IF NOT EXISTS idx_todos_due_date ON todos(due_date);
+
+CREATE TABLE IF NOT EXISTS refresh_tokens (
+	id         TEXT PRIMARY KEY,
+	user_id    TEXT NOT NULL REFERENCES users(id),
+	device_id  TEXT NOT NULL,
+	token_hash TEXT NOT NULL,
+	expires_at INTEGER NOT NULL,
+	created_at INTEGER NOT NULL
+);
+CREATE INDEX IF NOT EXISTS idx_refresh_tokens_user_id ON refresh_tokens(user_id);
+`
+
+// schemaOf lists the columns of every table and the names of the indexes
+// of the database.
+func schemaOf(t *testing.T, db *DB) []string {
+	t.Helper()
+	rows, err := db.sql.Query(`SELECT m.type || ' ' || m.name || COALESCE(' ' || c.name, '')
+		FROM sqlite_master m LEFT JOIN pragma_table_info(m.name) c ON m.type = 'table'
+		WHERE m.type IN ('table', 'index') AND m.name NOT LIKE 'sqlite_%'
+		ORDER BY 1`)
+	if err != nil {
+		t.Fatalf("read schema: %v", err)
+	}
+	defer rows.Close()
+	var out []string
+	for rows.Next() {
+		var s string
+		if err := rows.Scan(&s); err != nil {
+			t.Fatalf("read schema: %v", err)
+		}
+		out = append(out, s)
+	}
+	return out
+}
+
+func TestMigrateBaselineSchema(t *testing.T) {
+	// Arrange: a database of the first release, with a user, a note, a
+	// todo and a refresh token
+	path := tempDBPath(t)
+	raw, err := sql.Open("sqlite", path)
+	if err != nil {
+		t.Fatalf("open raw database: %v", err)
+	}
+	for _, stmt := range []string{
+		baselineSchema,
+		`INSERT INTO users VALUES ('u1', 'old@example.com', 'x', 'Old', 1000)`,
+		`INSERT INTO notes (id, user_id, title, content, type, modified_at, modified_by_device, created_at)
+			VALUES ('n1', 'u1', 'Old note', 'See [[Other]]', 'todo_list', 2000, 'dev1', 1000)`,
+		`INSERT INTO todos (id, user_id, note_id, content, due_date, modified_at, modified_by_device, created_at)
+			VALUES ('t1', 'u1', 'n1', 'Old todo', 5000, 3000, 'dev1', 1000)`,
+		`INSERT INTO refresh_tokens VALUES ('r1', 'u1', 'dev1', 'hash', 9000000000000, 1000)`,
+	} {
+		if _, err := raw.Exec(stmt); err != nil {
+			t.Fatalf("baseline: %v", err)
+		}
+	}
+	raw.Close()
+	fresh := testDB(t)
+
+	// Act
+	db, err := Open(path)
+	if err != nil {
+		t.Fatalf("open baseline database: %v", err)
+	}
+	defer db.Close()
+	notes, total, listErr := db.ListNotes("u1", NoteFilter{}, 10, 0)
+	todo, todoErr := db.GetTodo("t1", "u1")
+	var version int
+	db.sql.QueryRow(`PRAGMA user_version`).Scan(&version)
+
+	// Assert
+	if listErr != nil || todoErr != nil {
+		t.Fatalf("after upgrade: ListNotes %v, GetTodo %v", listErr, todoErr)
+	}
+	t.Logf("after upgrade: version %d, %d notes, todo %q", version, total, todo.Content)
+	if version != SchemaVersion {
+		t.Errorf("user_version %d, want %d", version, SchemaVersion)
+	}
+	if total != 1 || notes[0].Content != "See [[Other]]" || notes[0].ContentHash != delta.Hash(notes[0].Content) {
+		t.Errorf("expected the old note, got %+v", notes)
+	}
+	if todo.Content != "Old todo" || todo.DueDate == nil || todo.NoteID == nil || *todo.NoteID != "n1" {
+		t.Errorf("expected the old todo, got %+v", todo)
+	}
+	got, want := schemaOf(t, db), schemaOf(t, fresh)
+	if strings.Join(got, "\n") != strings.Join(want, "\n") {
+		t.Errorf("schema after upgrade differs from a new database:\ngot  %v\nwant %v", got, want)
+	}
+}
+
+func TestTodoRemindersOnUpgrade(t *testing.T) {
+	// Arrange: a version 3 database whose todos table predates reminders
+	path := tempDBPath(t)
+	db, err := Open(path)
+	if err != nil {
+		t.Fatalf("open database: %v", err)
+	}
+	u := testUser(t, db)
+	now := model.NowMillis()
+	td := &model.Todo{ID: model.NewID(), UserID: u.ID, Content: "Call back",
+		ModifiedAt: now, ModifiedByDevice: "dev1", CreatedAt: now}
+	if err := db.CreateTodo(td); err != nil {
+		t.Fatalf("CreateTodo: %v", err)
+	}
+
+	// Act
+	db = reopenAs(t, db, path,
+		`DROP INDEX idx_todos_reminder_at`,
+		`ALTER TABLE todos DROP COLUMN reminder_at`,
+		`PRAGMA user_version = 3`,
+	)
+	remind := now.Add(time.Hour)
+	td.ReminderAt = &remind
+	td.ModifiedAt = now.Add(time.Second)
+	updateErr := db.UpdateTodo(td)
+	got, err := db.GetTodo(td.ID, u.ID)
+
+	// Assert
+	if updateErr != nil || err != nil {
+		t.Fatalf("after upgrade: UpdateTodo %v, GetTodo %v", updateErr, err)
+	}
+	var idx int
+	db.sql.QueryRow(`SELECT COUNT(*) FROM sqlite_master WHERE name = 'idx_todos_reminder_at'`).Scan(&idx)
+	t.Logf("after upgrade: reminder_at=%v, index=%d", got.ReminderAt, idx)
+	if got.ReminderAt == nil || !got.ReminderAt.Equal(remind) || idx != 1 {
+		t.Errorf("expected the reminder stored and indexed, got %v (index %d)", got.ReminderAt, idx)
+	}
+}
+
+func TestNoteContentDeltasOnUpgrade(t *testing.T) {
+	// Arrange: a version 5 database whose notes table predates delta sync
+	path := tempDBPath(t)
+	db, err := Open(path)
+	if err != nil {
+		t.Fatalf("open database: %v", err)
+	}
+	u := testUser(t, db)
+	now := model.NowMillis()
+	n := &model.Note{ID: model.NewID(), UserID: u.ID, Title: "Old", Content: strings.Repeat("old line\n", 20),
+		Type: "note", ModifiedAt: now, ModifiedByDevice: "dev1", CreatedAt: now}
+	if err := db.CreateNote(n); err != nil {
+		t.Fatalf("CreateNote: %v", err)
+	}
+
+	// Act
+	db = reopenAs(t, db, path,
+		`ALTER TABLE notes DROP COLUMN content_hash`,
+		`ALTER TABLE notes DROP COLUMN base_hash`,
+		`ALTER TABLE notes DROP COLUMN content_patch`,
+		`PRAGMA user_version = 5`,
+	)
+	old, getErr := db.GetNote(n.ID, u.ID)
+	n.Content += "new line\n"
+	n.ModifiedAt = now.Add(time.Second)
+	updateErr := db.UpdateNote(n)
+	patches, err := db.GetNotePatches(u.ID, []string{n.ID})
+
+	// Assert
+	if getErr != nil || updateErr != nil || err != nil {
+		t.Fatalf("after upgrade: GetNote %v, UpdateNote %v, GetNotePatches %v", getErr, updateErr, err)
+	}
+	t.Logf("after upgrade: hash=%s, patch base=%s", old.ContentHash, patches[n.ID].BaseHash)
+	if old.ContentHash != delta.Hash(old.Content) {
+		t.Errorf("hash of the old note: got %q", old.ContentHash)
+	}
+	if patches[n.ID].BaseHash != old.ContentHash {
+		t.Errorf("expected a patch from the old content, got %+v", patches)
+	}
+}
+
+func TestTodoFieldTimesOnUpgrade(t *testing.T) {
+	// Arrange: a version 6 database whose todos table predates per-field
+	// merging
+	path := tempDBPath(t)
+	db, err := Open(path)
+	if err != nil {
+		t.Fatalf("open database: %v", err)
+	}
+	u := testUser(t, db)
+	now := model.NowMillis()
+	td := &model.Todo{ID: model.NewID(), UserID: u.ID, Content: "Pay rent",
+		ModifiedAt: now, ModifiedByDevice: "phone", CreatedAt: now}
+	if err := db.CreateTodo(td); err != nil {
+		t.Fatalf("CreateTodo: %v", err)
+	}
+
+	// Act
+	db = reopenAs(t, db, path,
+		`ALTER TABLE todos DROP COLUMN content_modified_at`,
+		`ALTER TABLE todos DROP COLUMN due_date_modified_at`,
+		`ALTER TABLE todos DROP COLUMN completed_modified_at`,
+		`ALTER TABLE todos DROP COLUMN note_id_modified_at`,
+		`PRAGMA user_version = 6`,
+	)
+	done := *td
+	done.Completed = true
+	done.ModifiedAt = now.Add(time.Minute)
+	done.ModifiedByDevice = "laptop"
+	_, _, applied, err := db.UpsertTodo(&done)
+	got, getErr := db.GetTodo(td.ID, u.ID)
+
+	// Assert
+	if err != nil || getErr != nil {
+		t.Fatalf("after upgrade: UpsertTodo %v, GetTodo %v", err, getErr)
+	}
+	t.Logf("after upgrade: applied=%v completed=%v times=%+v", applied, got.Completed, got.FieldTimes)
+	if !applied || !got.Completed || !got.FieldTimes.Completed.Equal(done.ModifiedAt) {
+		t.Errorf("expected the completion merged, got %+v", got)
+	}
+}
+
+func TestBacklinks(t *testing.T) {
+	db := testDB(t)
+	u := testUser(t, db)
+	now := model.NowMillis()
+	newNote := func(title, content string) *model.Note {
+		n := &model.Note{
+			ID: model.NewID(), UserID: u.ID, Title: title, Content: content, Type: "note",
+			ModifiedAt: now, ModifiedByDevice: "dev1", CreatedAt: now,
+		}
+		if err := db.CreateNote(n); err != nil {
+			t.Fatalf("CreateNote: %v", err)
+		}
+		return n
+	}
+
+	// Arrange: two live linkers, one that drops its link, one deleted, one self-link
+	target := newNote("Beta", "see [[Beta]] itself")
+	linker := newNote("Alpha", "links to [[beta]]")
+	labelled := newNote("Gamma", "and [[ BETA |the b note]]")
+	dropped := newNote("Delta", "was [[Beta]]")
+	deleted := newNote("Epsilon", "[[Beta]] too")
+	dropped.Content = "no longer linked"
+	if err := db.UpdateNote(dropped); err != nil {
+		t.Fatalf("UpdateNote: %v", err)
+	}
+	if _, err := db.DeleteNote(deleted.ID, u.ID, now.UnixMilli(), "dev1", KeepTodos); err != nil {
+		t.Fatalf("DeleteNote: %v", err)
+	}
+
+	// Act
+	back, err := db.GetBacklinks(target.ID, u.ID)
+	if err != nil {
+		t.Fatalf("GetBacklinks: %v", err)
+	}
+	_, missingErr := db.GetBacklinks(model.NewID(), u.ID)
+
+	// Assert
+	var ids []string
+	for _, n := range back {
+		ids = append(ids, n.Title)
+	}
+	t.Logf("backlinks: %v", ids)
+	if len(back) != 2 {
+		t.Fatalf("expected 2 backlinks, got %v", ids)
+	}
+	for _, n := range back {
+		if n.ID != linker.ID && n.ID != labelled.ID {
+			t.Errorf("unexpected backlink %q", n.Title)
+		}
+	}
+	if missingErr != ErrNotFound {
+		t.Errorf("unknown note: expected ErrNotFound, got %v", missingErr)
+	}
+}
+
+func TestNoteStats(t *testing.T) {
+	cases := []struct {
+		name, content, heading string
+		words, minutes, links  int
+	}{
+		{"empty", "", "", 0, 0, 0},
+		{"markup is not words", "## Plan ##\n\n- one\n- **two**\n", "Plan", 3, 1, 0},
+		{"heading in code", "```\n# not this\n```\n#tag\n### Real one", "Real one", 5, 1, 0},
+		{"links", "[[A]] [b](http://x) ![img](i.png) <https://y> https://z [[C|c]]", "", 6, 1, 5},
+		{"reading time", strings.Repeat("word ", 401), "", 401, 3, 0},
+	}
+	for _, c := range cases {
+		// Arrange
+		n := &model.Note{Content: c.content}
+
+		// Act
+		setNoteStats(n)
+
+		// Assert
+		t.Logf("%s: words=%d minutes=%d heading=%q links=%d", c.name, n.WordCount, n.ReadingMinutes, n.FirstHeading, n.LinkCount)
+		if n.WordCount != c.words || n.ReadingMinutes != c.minutes || n.FirstHeading != c.heading || n.LinkCount != c.links {
+			t.Errorf("%s: got %d words, %d minutes, heading %q, %d links; want %d, %d, %q, %d", c.name,
+				n.WordCount, n.ReadingMinutes, n.FirstHeading, n.LinkCount, c.words, c.minutes, c.heading, c.links)
+		}
+	}
+}
+
+func TestNoteMetadata(t *testing.T) {
+	db := testDB(t)
+	u := testUser(t, db)
+	now := model.NowMillis()
+
+	// Arrange
+	apollo := &model.Note{ID: model.NewID(), UserID: u.ID, Title: "Launch",
+		Content: "---\nProject: Apollo\nstatus: draft\nteam: [ops, Flight]\n---\nbody",
+		Type: "note", ModifiedAt: now, ModifiedByDevice: "dev1", CreatedAt: now}
+	gemini := &model.Note{ID: model.NewID(), UserID: u.ID, Title: "Orbit",
+		Content: "---\nproject: gemini\n---\n", Type: "note", ModifiedAt: now, ModifiedByDevice: "dev1", CreatedAt: now}
+	plain := &model.Note{ID: model.NewID(), UserID: u.ID, Title: "Plain", Content: "no front matter",
+		Type: "note", ModifiedAt: now, ModifiedByDevice: "dev1", CreatedAt: now}
+	for _, n := range []*model.Note{apollo, gemini, plain} {
+		if err := db.CreateNote(n); err != nil {
+			t.Fatalf("CreateNote: %v", err)
+		}
+	}
+
+	// Act
+	got, err := db.GetNote(apollo.ID, u.ID)
+	if err != nil {
+		t.Fatalf("GetNote: %v", err)
+	}
+	byProject, _, err := db.ListNotes(u.ID, NoteFilter{Meta: map[string]string{"project": "apollo"}}, 10, 0)
+	if err != nil {
+		t.Fatalf("ListNotes project: %v", err)
+	}
+	byTeam, _, err := db.ListNotes(u.ID, NoteFilter{Meta: map[string]string{"Team": "flight", "status": "draft"}}, 10, 0)
+	if err != nil {
+		t.Fatalf("ListNotes team: %v", err)
+	}
+	none, _, err := db.ListNotes(u.ID, NoteFilter{Meta: map[string]string{"team": "ops", "project": "gemini"}}, 10, 0)
+	if err != nil {
+		t.Fatalf("ListNotes none: %v", err)
+	}
+	searched, _, err := db.SearchNotes(u.ID, "body", NoteFilter{Meta: map[string]string{"project": "Apollo"}}, 10, 0)
+	if err != nil {
+		t.Fatalf("SearchNotes: %v", err)
+	}
+
+	// Assert
+	t.Logf("metadata: %v", got.Metadata)
+	want := map[string]any{"project": "Apollo", "status": "draft", "team": []any{"ops", "Flight"}}
+	if fmt.Sprint(got.Metadata) != fmt.Sprint(want) {
+		t.Errorf("metadata: got %v, want %v", got.Metadata, want)
+	}
+	t.Logf("project=apollo: %d, team=flight status=draft: %d, no match: %d, search: %d",
+		len(byProject), len(byTeam), len(none), len(searched))
+	for name, notes := range map[string][]model.Note{"project": byProject, "team": byTeam, "search": searched} {
+		if len(notes) != 1 || notes[0].ID != apollo.ID {
+			t.Errorf("%s filter: got %d notes, want the Apollo note", name, len(notes))
+		}
+	}
+	if len(none) != 0 {
+		t.Errorf("conflicting filters: got %d notes, want none", len(none))
+	}
+
+	// Act: new content without front matter drops the metadata
+	apollo.Content = "body only"
+	apollo.ModifiedAt = now.Add(time.Second)
+	if err := db.UpdateNote(apollo); err != nil {
+		t.Fatalf("UpdateNote: %v", err)
+	}
+	got, _ = db.GetNote(apollo.ID, u.ID)
+
+	// Assert
+	t.Logf("metadata after update: %v", got.Metadata)
+	if got.Metadata != nil {
+		t.Errorf("metadata after update: got %v, want none", got.Metadata)
+	}
+}
+
+func TestListNotesSort(t *testing.T) {
+	db := testDB(t)
+	u := testUser(t, db)
+	now := model.NowMillis()
+
+	// Arrange
+	for _, content := range []string{"one two", "one two three", "one"} {
+		if err := db.CreateNote(&model.Note{ID: model.NewID(), UserID: u.ID, Title: content, Content: content,
+			Type: "note", ModifiedAt: now, ModifiedByDevice: "dev1", CreatedAt: now}); err != nil {
+			t.Fatalf("create note: %v", err)
+		}
+	}
+	keys, err := ParseNoteSort("-words")
+	if err != nil {
+		t.Fatalf("ParseNoteSort: %v", err)
+	}
+
+	// Act
+	notes, _, err := db.ListNotes(u.ID, NoteFilter{Sort: keys}, 10, 0)
+	_, badErr := ParseNoteSort("size")
+
+	// Assert
+	var counts []int
+	for _, n := range notes {
+		counts = append(counts, n.WordCount)
+	}
+	t.Logf("word counts: %v, err=%v, bad key: %v", counts, err, badErr)
+	if err != nil || len(counts) != 3 || counts[0] != 3 || counts[1] != 2 || counts[2] != 1 {
+		t.Errorf("expected notes by word count descending, got %v (err %v)", counts, err)
+	}
+	if badErr == nil {
+		t.Error("expected an unknown sort key to be refused")
+	}
+}
+
+func TestNoteLinksIndexedOnUpgrade(t *testing.T) {
+	// Arrange: a version 7 database whose notes predate note_links
+	f, err := os.CreateTemp("", "notesd-test-*.db")
+	if err != nil {
+		t.Fatalf("create temp file: %v", err)
+	}
+	path := f.Name()
+	f.Close()
+	t.Cleanup(func() { os.Remove(path) })
+
+	db, err := Open(path)
+	if err != nil {
+		t.Fatalf("open database: %v", err)
+	}
+	u := testUser(t, db)
+	now := model.NowMillis()
+	target := &model.Note{ID: model.NewID(), UserID: u.ID, Title: "Target", Type: "note",
+		ModifiedAt: now, ModifiedByDevice: "dev1", CreatedAt: now}
+	linker := &model.Note{ID: model.NewID(), UserID: u.ID, Title: "Linker", Content: "[[Target]]",
+		Type: "note", ModifiedAt: now, ModifiedByDevice: "dev1", CreatedAt: now}
+	for _, n := range []*model.Note{target, linker} {
+		if err := db.CreateNote(n); err != nil {
+			t.Fatalf("CreateNote: %v", err)
+		}
+	}
+	if _, err := db.sql.Exec(`DELETE FROM note_links`); err != nil {
+		t.Fatalf("clear links: %v", err)
+	}
+	if _, err := db.sql.Exec(`PRAGMA user_version = 7`); err != nil {
+		t.Fatalf("set version: %v", err)
+	}
+	db.Close()
+
+	// Act
+	db, err = Open(path)
+	if err != nil {
+		t.Fatalf("reopen database: %v", err)
+	}
+	defer db.Close()
+	back, err := db.GetBacklinks(target.ID, u.ID)
+
+	// Assert
+	t.Logf("backlinks after upgrade: %d, err=%v", len(back), err)
+	if err != nil || len(back) != 1 || back[0].ID != linker.ID {
+		t.Errorf("expected the linker as backlink, got %v (err %v)", back, err)
+	}
+}
+
+func TestRefreshTokenFamiliesOnUpgrade(t *testing.T) {
+	// Arrange: a version 12 database whose refresh tokens predate families
+	f, err := os.CreateTemp("", "notesd-test-*.db")
+	if err != nil {
+		t.Fatalf("create temp file: %v", err)
+	}
+	path := f.Name()
+	f.Close()
+	t.Cleanup(func() { os.Remove(path) })
+
+	db, err := Open(path)
+	if err != nil {
+		t.Fatalf("open database: %v", err)
+	}
+	u := testUser(t, db)
+	now := model.NowMillis().UnixMilli()
+	for _, stmt := range []string{
+		`DROP TABLE refresh_tokens`,
+		`CREATE TABLE refresh_tokens (
+			id         TEXT PRIMARY KEY,
+			user_id    TEXT NOT NULL REFERENCES users(id),
+			device_id  TEXT NOT NULL,
+			token_hash TEXT NOT NULL,
+			expires_at INTEGER NOT NULL,
+			created_at INTEGER NOT NULL
+		)`,
+		`PRAGMA user_version = 12`,
+	} {
+		if _, err := db.sql.Exec(stmt); err != nil {
+			t.Fatalf("downgrade: %v", err)
+		}
+	}
+	if _, err := db.sql.Exec(`INSERT INTO refresh_tokens VALUES ('old', ?, 'dev', 'hash', ?, ?)`,
+		u.ID, now+3600000, now); err != nil {
+		t.Fatalf("insert old token: %v", err)
+	}
+	db.Close()
+
+	// Act
+	db, err = Open(path)
+	if err != nil {
+		t.Fatalf("reopen database: %v", err)
+	}
+	defer db.Close()
+	rt, err := db.GetRefreshTokenByHash("hash")
+
+	// Assert
+	t.Logf("token after upgrade: %+v, err=%v", rt, err)
+	if err != nil || rt.FamilyID != "old" || rt.RotatedAt != nil {
+		t.Errorf("expected the old token to start its own family, got %+v (err %v)", rt, err)
+	}
+}
+
+func TestAuditCompleteOnUpgrade(t *testing.T) {
+	// Arrange: a version 20 database whose audit log predates the complete action
+	f, err := os.CreateTemp("", "notesd-test-*.db")
+	if err != nil {
+		t.Fatalf("create temp file: %v", err)
+	}
+	path := f.Name()
+	f.Close()
+	t.Cleanup(func() { os.Remove(path) })
+
+	db, err := Open(path)
+	if err != nil {
+		t.Fatalf("open database: %v", err)
+	}
+	u := testUser(t, db)
+	for _, stmt := range []string{
+		`DROP TABLE audit_log`,
+		`CREATE TABLE audit_log (
+			id          INTEGER PRIMARY KEY AUTOINCREMENT,
+			user_id     TEXT NOT NULL,
+			entity_type TEXT NOT NULL CHECK(entity_type IN ('note', 'todo')),
+			entity_id   TEXT NOT NULL,
+			action      TEXT NOT NULL CHECK(action IN ('create', 'update', 'delete', 'restore', 'purge')),
+			device_id   TEXT NOT NULL,
+			at          INTEGER NOT NULL
+		)`,
+		`PRAGMA user_version = 20`,
+	} {
+		if _, err := db.sql.Exec(stmt); err != nil {
+			t.Fatalf("downgrade: %v", err)
+		}
+	}
+	old := model.AuditEntry{UserID: u.ID, EntityType: "todo", EntityID: "t1", Action: AuditCreate, DeviceID: "dev1", At: model.NowMillis()}
+	if err := db.AppendAudit([]model.AuditEntry{old}); err != nil {
+		t.Fatalf("append old entry: %v", err)
+	}
+	db.Close()
+
+	// Act
+	db, err = Open(path)
+	if err != nil {
+		t.Fatalf("reopen database: %v", err)
+	}
+	defer db.Close()
+	done := old
+	done.Action = AuditComplete
+	appendErr := db.AppendAudit([]model.AuditEntry{done})
+	entries, total, listErr := db.ListAuditLog(AuditFilter{EntityID: "t1"}, 10, 0)
+
+	// Assert
+	t.Logf("append: %v, entries: %+v, total %d, err=%v", appendErr, entries, total, listErr)
+	if appendErr != nil {
+		t.Errorf("expected the complete action to be accepted, got %v", appendErr)
+	}
+	if listErr != nil || total != 2 || entries[1].Action != AuditCreate {
+		t.Errorf("expected the old entry to survive the upgrade, got %+v (err %v)", entries, listErr)
+	}
+}
+
+func TestNoteStatsOnUpgrade(t *testing.T) {
+	// Arrange: a version 21 database whose notes table predates stored stats
+	f, err := os.CreateTemp("", "notesd-test-*.db")
+	if err != nil {
+		t.Fatalf("create temp file: %v", err)
+	}
+	path := f.Name()
+	f.Close()
+	t.Cleanup(func() { os.Remove(path) })
+
+	db, err := Open(path)
+	if err != nil {
+		t.Fatalf("open database: %v", err)
+	}
+	u := testUser(t, db)
+	now := model.NowMillis()
+	n := &model.Note{ID: model.NewID(), UserID: u.ID, Title: "Old", Content: "# Old\n\nsee [[Other]]",
+		Type: "note", ModifiedAt: now, ModifiedByDevice: "dev1", CreatedAt: now}
+	if err := db.CreateNote(n); err != nil {
+		t.Fatalf("CreateNote: %v", err)
+	}
+	for _, stmt := range []string{
+		`ALTER TABLE notes DROP COLUMN word_count`,
+		`ALTER TABLE notes DROP COLUMN first_heading`,
+		`ALTER TABLE notes DROP COLUMN link_count`,
+		`PRAGMA user_version = 21`,
+	} {
+		if _, err := db.sql.Exec(stmt); err != nil {
+			t.Fatalf("downgrade: %v", err)
+		}
+	}
+	db.Close()
+
+	// Act
+	db, err = Open(path)
+	if err != nil {
+		t.Fatalf("reopen database: %v", err)
+	}
+	defer db.Close()
+	got, err := db.GetNote(n.ID, u.ID)
+
+	// Assert
+	if err != nil {
+		t.Fatalf("GetNote: %v", err)
+	}
+	t.Logf("after upgrade: words=%d heading=%q links=%d", got.WordCount, got.FirstHeading, got.LinkCount)
+	if got.WordCount != 3 || got.FirstHeading != "Old" || got.LinkCount != 1 || got.ReadingMinutes != 1 {
+		t.Errorf("expected stats computed for the old note, got %+v", got)
+	}
+}
+
+func TestDigestFrequencyOnUpgrade(t *testing.T) {
+	// Arrange: a version 22 database with weekly digest settings
+	f, err := os.CreateTemp("", "notesd-test-*.db")
+	if err != nil {
+		t.Fatalf("create temp file: %v", err)
+	}
+	path := f.Name()
+	f.Close()
+	t.Cleanup(func() { os.Remove(path) })
+
+	db, err := Open(path)
+	if err != nil {
+		t.Fatalf("open database: %v", err)
+	}
+	u := testUser(t, db)
+	s := model.DigestSettings{Enabled: true, Frequency: "weekly", Weekday: 3, Hour: 7}
+	if err := db.SaveDigestSettings(u.ID, s, time.Now()); err != nil {
+		t.Fatalf("SaveDigestSettings: %v", err)
+	}
+	for _, stmt := range []string{
+		`ALTER TABLE digest_settings DROP COLUMN frequency`,
+		`PRAGMA user_version = 22`,
+	} {
+		if _, err := db.sql.Exec(stmt); err != nil {
+			t.Fatalf("downgrade: %v", err)
+		}
+	}
+	db.Close()
+
+	// Act
+	db, err = Open(path)
+	if err != nil {
+		t.Fatalf("reopen database: %v", err)
+	}
+	defer db.Close()
+	got, err := db.GetDigestSettings(u.ID)
+
+	// Assert
+	t.Logf("after upgrade: %+v (err %v)", got, err)
+	if err != nil || got != s {
+		t.Errorf("expected %+v, got %+v", s, got)
+	}
+}
+
+func TestTodoAllDayOnUpgrade(t *testing.T) {
+	// Arrange: a version 24 database with a todo due on a day and one due
+	// at a time
+	f, err := os.CreateTemp("", "notesd-test-*.db")
+	if err != nil {
+		t.Fatalf("create temp file: %v", err)
+	}
+	path := f.Name()
+	f.Close()
+	t.Cleanup(func() { os.Remove(path) })
+
+	db, err := Open(path)
+	if err != nil {
+		t.Fatalf("open database: %v", err)
+	}
+	u := testUser(t, db)
+	now := model.NowMillis()
+	day := time.Date(2025, 3, 1, 0, 0, 0, 0, time.UTC)
+	at := time.Date(2025, 3, 1, 14, 0, 0, 0, time.UTC)
+	ids := map[string]*time.Time{}
+	for _, due := range []*time.Time{&day, &at} {
+		todo := &model.Todo{
+			ID: model.NewID(), UserID: u.ID, Content: "due", DueDate: due,
+			ModifiedAt: now, ModifiedByDevice: "dev1", CreatedAt: now,
+		}
+		if err := db.CreateTodo(todo); err != nil {
+			t.Fatalf("create todo: %v", err)
+		}
+		ids[todo.ID] = due
+	}
+	for _, stmt := range []string{
+		`ALTER TABLE todos DROP COLUMN all_day`,
+		`PRAGMA user_version = 24`,
+	} {
+		if _, err := db.sql.Exec(stmt); err != nil {
+			t.Fatalf("downgrade: %v", err)
+		}
+	}
+	db.Close()
+
+	// Act
+	db, err = Open(path)
+	if err != nil {
+		t.Fatalf("reopen database: %v", err)
+	}
+	defer db.Close()
+
+	// Assert
+	for id, due := range ids {
+		got, err := db.GetTodo(id, u.ID)
+		if err != nil {
+			t.Fatalf("GetTodo: %v", err)
+		}
+		t.Logf("due %v: all_day=%v", got.DueDate, got.AllDay)
+		if want := due == &day; got.AllDay != want {
+			t.Errorf("todo due %v: expected all_day %v, got %v", due, want, got.AllDay)
+		}
+	}
+}
+
+func TestNoteTypeCheckOnUpgrade(t *testing.T) {
+	// Arrange: a version 26 database whose notes still have the check
+	// allowing only the built-in types
+	f, err := os.CreateTemp("", "notesd-test-*.db")
+	if err != nil {
+		t.Fatalf("create temp file: %v", err)
+	}
+	path := f.Name()
+	f.Close()
+	t.Cleanup(func() { os.Remove(path) })
+
+	db, err := Open(path)
+	if err != nil {
+		t.Fatalf("open database: %v", err)
+	}
+	u := testUser(t, db)
+	err = db.withTx(func(tx *txn) error {
+		var version int
+		if err := tx.QueryRow(`PRAGMA schema_version`).Scan(&version); err != nil {
+			return err
+		}
+		if _, err := tx.Exec(`PRAGMA writable_schema = ON`); err != nil {
+			return err
+		}
+		if _, err := tx.Exec(
+			`UPDATE sqlite_master SET sql = replace(sql, ?, ?) WHERE type = 'table' AND name = 'notes'`,
+			`DEFAULT 'note',`, `DEFAULT 'note'`+noteTypeCheck+`,`,
+		); err != nil {
+			return err
+		}
+		for _, stmt := range []string{
+			fmt.Sprintf(`PRAGMA schema_version = %d`, version+1),
+			`PRAGMA writable_schema = OFF`,
+			`PRAGMA user_version = 26`,
+		} {
+			if _, err := tx.Exec(stmt); err != nil {
+				return err
+			}
+		}
+		return nil
+	})
+	if err != nil {
+		t.Fatalf("downgrade: %v", err)
+	}
+	now := model.NowMillis()
+	journal := func() *model.Note {
+		return &model.Note{
+			ID: model.NewID(), UserID: u.ID, Title: "Monday", Type: "journal",
+			ModifiedAt: now, ModifiedByDevice: "dev1", CreatedAt: now,
+		}
+	}
+	before := db.CreateNote(journal())
+	db.Close()
+
+	// Act
+	db, err = Open(path)
+	if err != nil {
+		t.Fatalf("reopen database: %v", err)
+	}
+	defer db.Close()
+	n := journal()
+	after := db.CreateNote(n)
+
+	// Assert
+	t.Logf("before: %v, after: %v", before, after)
+	if before == nil {
+		t.Fatal("expected the downgraded database to refuse the type")
+	}
+	if after != nil {
+		t.Fatalf("expected the upgraded database to take any type, got %v", after)
+	}
+	got, err := db.GetNote(n.ID, u.ID)
+	if err != nil || got.Type != "journal" {
+		t.Errorf("expected the journal note stored, got %+v, %v", got, err)
+	}
+}
+
+// --- Maintenance tests ---
+
+func TestNoteMetadataOnUpgrade(t *testing.T) {
+	// Arrange: a version 27 database whose notes table predates metadata
+	f, err := os.CreateTemp("", "notesd-test-*.db")
+	if err != nil {
+		t.Fatalf("create temp file: %v", err)
+	}
+	path := f.Name()
+	f.Close()
+	t.Cleanup(func() { os.Remove(path) })
+
+	db, err := Open(path)
+	if err != nil {
+		t.Fatalf("open database: %v", err)
+	}
+	u := testUser(t, db)
+	now := model.NowMillis()
+	n := &model.Note{ID: model.NewID(), UserID: u.ID, Title: "Old", Content: "---\nproject: apollo\n---\nold",
+		Type: "note", ModifiedAt: now, ModifiedByDevice: "dev1", CreatedAt: now}
+	if err := db.CreateNote(n); err != nil {
+		t.Fatalf("CreateNote: %v", err)
+	}
+	for _, stmt := range []string{
+		`ALTER TABLE notes DROP COLUMN metadata`,
+		`PRAGMA user_version = 27`,
+	} {
+		if _, err := db.sql.Exec(stmt); err != nil {
+			t.Fatalf("downgrade: %v", err)
+		}
+	}
+	db.Close()
+
+	// Act
+	db, err = Open(path)
+	if err != nil {
+		t.Fatalf("reopen database: %v", err)
+	}
+	defer db.Close()
+	got, err := db.GetNote(n.ID, u.ID)
+
+	// Assert
+	if err != nil {
+		t.Fatalf("GetNote: %v", err)
+	}
+	t.Logf("after upgrade: metadata=%v", got.Metadata)
+	if got.Metadata["project"] != "apollo" {
+		t.Errorf("expected metadata read from the old note, got %v", got.Metadata)
+	}
+}
+
+func TestBackup(t *testing.T) {
+	db := testDB(t)
+
+	// Arrange
+	u := testUser(t, db)
+	path := filepath.Join(t.TempDir(), "backup.db")
+
+	// Act
+	err := db.Backup(path)
+	again := db.Backup(path)
+
+	// Assert
+	if err != nil {
+		t.Fatalf("backup: %v", err)
+	}
+	t.Logf("second backup to same path: %v", again)
+	if again == nil {
+		t.Errorf("backup overwrote %s", path)
+	}
+	snap, err := Open(path)
+	if err != nil {
+		t.Fatalf("open backup: %v", err)
+	}
+	defer snap.Close()
+	got, err := snap.GetUserByEmail(u.Email)
+	if err != nil {
+		t.Fatalf("user missing from backup: %v", err)
+	}
+	t.Logf("backup has user %s", got.Email)
+}
+
+func TestVacuum(t *testing.T) {
+	db := testDB(t)
+
+	// Arrange
+	testUser(t, db)
+
+	// Act
+	err := db.Vacuum()
+
+	// Assert
+	t.Logf("vacuum: %v", err)
+	if err != nil {
+		t.Fatalf("vacuum: %v", err)
+	}
+}
+
+func TestConcurrentWrites(t *testing.T) {
+	// Arrange: a pool of several connections, as under concurrent pushes
+	f, err := os.CreateTemp("", "notesd-test-*.db")
+	if err != nil {
+		t.Fatalf("create temp file: %v", err)
+	}
+	path := f.Name()
+	f.Close()
+	t.Cleanup(func() { os.Remove(path) })
+	db, err := OpenWith(path, Options{MaxOpenConns: 8, MaxIdleConns: 8, SerializeWrites: true})
+	if err != nil {
+		t.Fatalf("open database: %v", err)
+	}
+	defer db.Close()
+	u := testUser(t, db)
+
+	// Act
+	const writers = 32
+	errs := make(chan error, writers)
+	for i := 0; i < writers; i++ {
+		go func() {
+			now := model.NowMillis()
+			_, _, err := db.UpsertNote(&model.Note{ID: model.NewID(), UserID: u.ID, Title: "Concurrent",
+				Type: "note", ModifiedAt: now, ModifiedByDevice: "dev1", CreatedAt: now})
+			errs <- err
+		}()
+	}
+	failed := 0
+	for i := 0; i < writers; i++ {
+		if err := <-errs; err != nil {
+			t.Logf("write failed: %v", err)
+			failed++
+		}
+	}
+	_, total, err := db.ListNotes(u.ID, NoteFilter{}, 100, 0)
+	orphan := db.CreateNote(&model.Note{ID: model.NewID(), UserID: "no-such-user", Type: "note",
+		ModifiedAt: model.NowMillis(), ModifiedByDevice: "dev1", CreatedAt: model.NowMillis()})
+
+	// Assert
+	t.Logf("failed writes: %d, notes: %d (err %v), open connections: %d",
+		failed, total, err, db.sql.Stats().OpenConnections)
+	if failed != 0 || err != nil || total != writers {
+		t.Errorf("expected %d notes and no failed writes", writers)
+	}
+	// Foreign keys are enforced on every pooled connection, not only the first
+	if orphan == nil {
+		t.Error("expected a note of an unknown user to be rejected")
+	}
+}
+
+func TestMaintain(t *testing.T) {
+	db := testDB(t)
+	u := testUser(t, db)
+	now := model.NowMillis()
+
+	// Arrange: notes whose removal leaves free pages behind
+	content := strings.Repeat("lorem ipsum ", 2000)
+	for i := 0; i < 20; i++ {
+		n := &model.Note{ID: model.NewID(), UserID: u.ID, Title: "Big", Content: content, Type: "note",
+			ModifiedAt: now, ModifiedByDevice: "dev1", CreatedAt: now}
+		if err := db.CreateNote(n); err != nil {
+			t.Fatalf("CreateNote: %v", err)
+		}
+	}
+	if _, err := db.exec(`DELETE FROM notes`); err != nil {
+		t.Fatalf("delete notes: %v", err)
+	}
+
+	// Act
+	m, err := db.Maintain()
+	if err != nil {
+		t.Fatalf("Maintain: %v", err)
+	}
+	var free int64
+	db.queryRow(`PRAGMA freelist_count`).Scan(&free)
+
+	// Assert
+	t.Logf("maintenance %+v, %d free pages left", m, free)
+	if !m.OK || !m.Checkpointed || len(m.Integrity) != 1 || m.Integrity[0] != "ok" {
+		t.Errorf("integrity = %v, ok %v, checkpointed %v", m.Integrity, m.OK, m.Checkpointed)
+	}
+	if m.FreedPages == 0 || free != 0 {
+		t.Errorf("freed %d pages, %d left; want all freed", m.FreedPages, free)
+	}
+}
+
+func TestContentEncryption(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "notesd.db")
+	open := func(opts Options) *DB {
+		t.Helper()
+		db, err := OpenWith(path, opts)
+		if err != nil {
+			t.Fatalf("open database: %v", err)
+		}
+		return db
+	}
+	// stored returns the raw content of the note.
+	stored := func(db *DB, id string) string {
+		var s string
+		db.queryRow(`SELECT content FROM notes WHERE id = ?`, id).Scan(&s)
+		return s
+	}
+	oldKey := []byte(strings.Repeat("k", 32))
+	newKey := []byte(strings.Repeat("n", 32))
+
+	// Arrange: a note written while the text was stored plain
+	db := open(DefaultOptions)
+	u := testUser(t, db)
+	now := model.NowMillis()
+	n := &model.Note{ID: model.NewID(), UserID: u.ID, Title: "Diary", Content: "# Secret\nmeet at dawn",
+		Type: "note", ModifiedAt: now, ModifiedByDevice: "dev1", CreatedAt: now}
+	if err := db.CreateNote(n); err != nil {
+		t.Fatalf("CreateNote: %v", err)
+	}
+	db.Close()
+
+	// Act: turn encryption on, write a todo, then rotate the key
+	db = open(Options{SerializeWrites: true, ContentKey: oldKey})
+	encrypted := stored(db, n.ID)
+	todo := &model.Todo{ID: model.NewID(), UserID: u.ID, Content: "buy a lantern",
+		ModifiedAt: now, ModifiedByDevice: "dev1", CreatedAt: now}
+	if err := db.CreateTodo(todo); err != nil {
+		t.Fatalf("CreateTodo: %v", err)
+	}
+	var todoStored string
+	db.queryRow(`SELECT content FROM todos WHERE id = ?`, todo.ID).Scan(&todoStored)
+	got, _ := db.GetNote(n.ID, u.ID)
+	found, total, _ := db.SearchNotes(u.ID, "dawn", NoteFilter{}, 10, 0)
+	db.Close()
+
+	db = open(Options{SerializeWrites: true, ContentKey: newKey, PreviousContentKeys: [][]byte{oldKey}})
+	rotated := stored(db, n.ID)
+	gotTodo, _ := db.GetTodo(todo.ID, u.ID)
+	db.Close()
+
+	db = open(Options{SerializeWrites: true, PreviousContentKeys: [][]byte{newKey}})
+	defer db.Close()
+	plain := stored(db, n.ID)
+
+	// Assert
+	t.Logf("encrypted: %q", encrypted)
+	t.Logf("rotated: %q, plain again: %q", rotated, plain)
+	t.Logf("read back: %+v, search found %d", got, total)
+	if !strings.HasPrefix(encrypted, encPrefix) || strings.Contains(encrypted, "dawn") {
+		t.Errorf("content stored as %q, want it encrypted", encrypted)
+	}
+	if !strings.HasPrefix(todoStored, encPrefix) {
+		t.Errorf("todo stored as %q, want it encrypted", todoStored)
+	}
+	if got == nil || got.Content != n.Content || got.FirstHeading != "Secret" {
+		t.Errorf("read back %+v, want the plain content and heading", got)
+	}
+	if total != 1 || len(found) != 1 {
+		t.Errorf("search for encrypted content found %d notes, want 1", total)
+	}
+	if rotated == encrypted || !strings.HasPrefix(rotated, encPrefix) {
+		t.Errorf("content after rotation %q, want it under the new key", rotated)
+	}
+	if gotTodo == nil || gotTodo.Content != todo.Content {
+		t.Errorf("todo after rotation %+v, want its content", gotTodo)
+	}
+	if plain != n.Content {
+		t.Errorf("content without a key %q, want %q", plain, n.Content)
+	}
+}
+
+func TestRekeyLeavesNoPlainText(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "notesd.db")
+
+	// Arrange: notes written plain
+	db, err := Open(path)
+	if err != nil {
+		t.Fatalf("open database: %v", err)
+	}
+	u := testUser(t, db)
+	now := model.NowMillis()
+	for i := range 20 {
+		n := &model.Note{ID: model.NewID(), UserID: u.ID, Title: "Diary",
+			Content: fmt.Sprintf("meet at dawn %d %s", i, strings.Repeat("x", 2000)),
+			Type:    "note", ModifiedAt: now, ModifiedByDevice: "dev1", CreatedAt: now}
+		if err := db.CreateNote(n); err != nil {
+			t.Fatalf("CreateNote: %v", err)
+		}
+	}
+	db.Close()
+
+	// Act: turn encryption on
+	db, err = OpenWith(path, Options{SerializeWrites: true, ContentKey: []byte(strings.Repeat("k", 32))})
+	if err != nil {
+		t.Fatalf("open database with key: %v", err)
+	}
+	defer db.Close()
+
+	// Assert: neither the file nor its log still holds the old text
+	for _, name := range []string{path, path + "-wal"} {
+		data, err := os.ReadFile(name)
+		if err != nil && !os.IsNotExist(err) {
+			t.Fatalf("read %s: %v", name, err)
+		}
+		t.Logf("%s: %d bytes", filepath.Base(name), len(data))
+		if bytes.Contains(data, []byte("meet at dawn")) {
+			t.Errorf("%s still contains the plain text", filepath.Base(name))
+		}
+	}
+}
+
+func TestTimezonesOnUpgrade(t *testing.T) {
+	// Arrange: a version 23 database keeping time zones in digest settings
+	// and Telegram chats, one user with each and one with both
+	path := tempDBPath(t)
+	db, err := Open(path)
+	if err != nil {
+		t.Fatalf("open database: %v", err)
+	}
+	digestUser, chatUser, both := testUser(t, db), testUser(t, db), testUser(t, db)
+
+	// Act
+	db = reopenAs(t, db, path,
+		`DROP TABLE account_settings`,
+		`ALTER TABLE digest_settings ADD COLUMN timezone TEXT NOT NULL DEFAULT 'UTC'`,
+		`ALTER TABLE telegram_chats ADD COLUMN timezone TEXT NOT NULL DEFAULT 'UTC'`,
+		`ALTER TABLE telegram_link_codes ADD COLUMN timezone TEXT NOT NULL DEFAULT 'UTC'`,
+		`INSERT INTO digest_settings (user_id, timezone) VALUES ('`+digestUser.ID+`', 'Europe/Berlin')`,
+		`INSERT INTO telegram_chats (user_id, chat_id, linked_at, timezone) VALUES ('`+chatUser.ID+`', 1, 1, 'America/Chicago')`,
+		`INSERT INTO digest_settings (user_id, timezone) VALUES ('`+both.ID+`', 'Europe/Paris')`,
+		`INSERT INTO telegram_chats (user_id, chat_id, linked_at, timezone) VALUES ('`+both.ID+`', 2, 1, 'Asia/Tokyo')`,
+		`PRAGMA user_version = 23`,
+	)
+	var got []string
+	for _, u := range []*model.User{digestUser, chatUser, both} {
+		s, err := db.GetAccountSettings(u.ID)
+		if err != nil {
+			t.Fatalf("GetAccountSettings: %v", err)
+		}
+		got = append(got, s.Timezone)
+	}
+	var columns int
+	db.sql.QueryRow(
+		`SELECT COUNT(*) FROM pragma_table_info('digest_settings') WHERE name = 'timezone'`,
+	).Scan(&columns)
+
+	// Assert
+	t.Logf("after upgrade: time zones %v, %d digest time zone columns", got, columns)
+	want := []string{"Europe/Berlin", "America/Chicago", "Europe/Paris"}
+	if strings.Join(got, " ") != strings.Join(want, " ") {
+		t.Errorf("time zones %v, want %v", got, want)
+	}
+	if columns != 0 {
+		t.Errorf("digest_settings still has a timezone column")
+	}
+}
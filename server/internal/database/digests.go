@@ -0,0 +1,113 @@
+package database
+
+import (
+	"database/sql"
+	"errors"
+	"fmt"
+	"time"
+
+	"github.com/c0dev0id/notesd/server/internal/model"
+)
+
+// defaultDigestSettings applies to users who never saved digest settings.
+var defaultDigestSettings = model.DigestSettings{Frequency: "weekly", Weekday: int(time.Monday), Hour: 8}
+
+func (db *DB) GetDigestSettings(userID string) (model.DigestSettings, error) {
+	s := defaultDigestSettings
+	err := db.queryRow(
+		`SELECT enabled, frequency, weekday, hour FROM digest_settings WHERE user_id = ?`, userID,
+	).Scan(&s.Enabled, &s.Frequency, &s.Weekday, &s.Hour)
+	if errors.Is(err, sql.ErrNoRows) {
+		return defaultDigestSettings, nil
+	}
+	if err != nil {
+		return s, fmt.Errorf("get digest settings: %w", err)
+	}
+	return s, nil
+}
+
+// SaveDigestSettings stores a user's digest settings. When the digest goes
+// from disabled to enabled, the last-sent marker is reset to now so the first
+// digest goes out at the next scheduled slot rather than immediately.
+func (db *DB) SaveDigestSettings(userID string, s model.DigestSettings, now time.Time) error {
+	_, err := db.exec(
+		`INSERT INTO digest_settings (user_id, enabled, frequency, weekday, hour, last_sent_at)
+		 VALUES (?, ?, ?, ?, ?, ?)
+		 ON CONFLICT(user_id) DO UPDATE SET
+		   last_sent_at = CASE WHEN excluded.enabled AND NOT enabled
+		                  THEN excluded.last_sent_at ELSE last_sent_at END,
+		   enabled = excluded.enabled, frequency = excluded.frequency,
+		   weekday = excluded.weekday, hour = excluded.hour`,
+		userID, s.Enabled, s.Frequency, s.Weekday, s.Hour, toMillis(now),
+	)
+	if err != nil {
+		return fmt.Errorf("save digest settings: %w", err)
+	}
+	return nil
+}
+
+// DigestRecipient is a user with digests enabled. Timezone is that of
+// the account settings.
+type DigestRecipient struct {
+	UserID     string
+	Email      string
+	Settings   model.DigestSettings
+	Timezone   string
+	LastSentAt time.Time
+}
+
+func (db *DB) ListDigestRecipients() ([]DigestRecipient, error) {
+	rows, err := db.query(
+		`SELECT d.user_id, u.email, d.frequency, d.weekday, d.hour, COALESCE(s.timezone, 'UTC'), d.last_sent_at
+		 FROM digest_settings d JOIN users u ON u.id = d.user_id
+		 LEFT JOIN account_settings s ON s.user_id = d.user_id
+		 WHERE d.enabled = 1`,
+	)
+	if err != nil {
+		return nil, fmt.Errorf("list digest recipients: %w", err)
+	}
+	defer rows.Close()
+
+	var out []DigestRecipient
+	for rows.Next() {
+		r := DigestRecipient{Settings: model.DigestSettings{Enabled: true}}
+		var lastSent sql.NullInt64
+		if err := rows.Scan(&r.UserID, &r.Email, &r.Settings.Frequency, &r.Settings.Weekday,
+			&r.Settings.Hour, &r.Timezone, &lastSent); err != nil {
+			return nil, fmt.Errorf("scan digest recipient: %w", err)
+		}
+		if lastSent.Valid {
+			r.LastSentAt = fromMillis(lastSent.Int64)
+		}
+		out = append(out, r)
+	}
+	return out, rows.Err()
+}
+
+func (db *DB) MarkDigestSent(userID string, at time.Time) error {
+	_, err := db.exec(
+		`UPDATE digest_settings SET last_sent_at = ? WHERE user_id = ?`, toMillis(at), userID,
+	)
+	if err != nil {
+		return fmt.Errorf("mark digest sent: %w", err)
+	}
+	return nil
+}
+
+// addDigestFrequency adds the frequency column; digests saved before it
+// are weekly. A database without digest settings gets the table from the
+// schema.
+func (db *DB) addDigestFrequency() error {
+	return db.withTx(func(tx *txn) error {
+		var cols, n int
+		if err := tx.QueryRow(
+			`SELECT COUNT(*), COUNT(*) FILTER (WHERE name = 'frequency') FROM pragma_table_info('digest_settings')`,
+		).Scan(&cols, &n); err != nil || cols == 0 || n > 0 {
+			return err
+		}
+		if _, err := tx.Exec(`ALTER TABLE digest_settings ADD COLUMN frequency TEXT NOT NULL DEFAULT 'weekly'`); err != nil {
+			return fmt.Errorf("add digest frequency: %w", err)
+		}
+		return nil
+	})
+}
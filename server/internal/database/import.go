@@ -0,0 +1,28 @@
+package database
+
+import (
+	"github.com/c0dev0id/notesd/server/internal/model"
+)
+
+// Import inserts the notes, todos and attachment records of an import in
+// one transaction, so a failed import leaves nothing behind.
+func (db *DB) Import(notes []*model.Note, todos []*model.Todo, attachments []*model.Attachment) error {
+	return db.withTx(func(tx *txn) error {
+		for _, n := range notes {
+			if err := insertNote(tx, n); err != nil {
+				return err
+			}
+		}
+		for _, t := range todos {
+			if err := insertTodo(tx, t); err != nil {
+				return err
+			}
+		}
+		for _, at := range attachments {
+			if err := insertAttachment(tx, at); err != nil {
+				return err
+			}
+		}
+		return nil
+	})
+}
@@ -0,0 +1,80 @@
+package database
+
+import (
+	"database/sql"
+	"fmt"
+
+	"github.com/c0dev0id/notesd/server/internal/model"
+)
+
+func (db *DB) CreateInvite(inv *model.Invite) error {
+	_, err := db.exec(
+		`INSERT INTO invites (code, max_uses, uses, expires_at, created_by, created_at)
+		 VALUES (?, ?, 0, ?, ?, ?)`,
+		inv.Code, inv.MaxUses, toNullMillis(inv.ExpiresAt), inv.CreatedBy, toMillis(inv.CreatedAt),
+	)
+	if err != nil {
+		return fmt.Errorf("create invite: %w", err)
+	}
+	return nil
+}
+
+// ListInvites returns all invites, newest first, including used up and
+// expired ones.
+func (db *DB) ListInvites() ([]model.Invite, error) {
+	rows, err := db.query(
+		`SELECT code, max_uses, uses, expires_at, created_by, created_at
+		 FROM invites ORDER BY created_at DESC, rowid DESC`,
+	)
+	if err != nil {
+		return nil, fmt.Errorf("list invites: %w", err)
+	}
+	defer rows.Close()
+
+	invites := []model.Invite{}
+	for rows.Next() {
+		var inv model.Invite
+		var expiresAt sql.NullInt64
+		var createdAt int64
+		if err := rows.Scan(&inv.Code, &inv.MaxUses, &inv.Uses, &expiresAt, &inv.CreatedBy, &createdAt); err != nil {
+			return nil, fmt.Errorf("scan invite: %w", err)
+		}
+		inv.ExpiresAt = fromNullMillis(expiresAt)
+		inv.CreatedAt = fromMillis(createdAt)
+		invites = append(invites, inv)
+	}
+	return invites, rows.Err()
+}
+
+// CreateUserWithInvite creates u and counts one use of the invite code in
+// the same transaction. It returns ErrNotFound when the code is unknown,
+// used up or expired, and ErrConflict when the email is taken, in which
+// case the invite is not used.
+func (db *DB) CreateUserWithInvite(u *model.User, code string) error {
+	return db.withTx(func(tx *txn) error {
+		res, err := tx.Exec(
+			`UPDATE invites SET uses = uses + 1
+			 WHERE code = ? AND (max_uses = 0 OR uses < max_uses)
+			   AND (expires_at IS NULL OR expires_at >= ?)`,
+			code, model.NowMillis().UnixMilli(),
+		)
+		if err != nil {
+			return fmt.Errorf("use invite: %w", err)
+		}
+		if err := checkRowsAffected(res); err != nil {
+			return err
+		}
+		_, err = tx.Exec(
+			`INSERT INTO users (id, email, password_hash, display_name, created_at)
+			 VALUES (?, ?, ?, ?, ?)`,
+			u.ID, u.Email, u.PasswordHash, u.DisplayName, toMillis(u.CreatedAt),
+		)
+		if isConstraintError(err) {
+			return fmt.Errorf("email already registered: %w", ErrConflict)
+		}
+		if err != nil {
+			return fmt.Errorf("create user: %w", err)
+		}
+		return nil
+	})
+}
@@ -0,0 +1,79 @@
+package database
+
+import (
+	"database/sql"
+	"errors"
+	"fmt"
+
+	"github.com/c0dev0id/notesd/server/internal/model"
+)
+
+// defaultJournalSettings applies to users who never saved journal settings.
+var defaultJournalSettings = model.JournalSettings{TitleFormat: "%Y-%m-%d"}
+
+func (db *DB) GetJournalSettings(userID string) (model.JournalSettings, error) {
+	s := defaultJournalSettings
+	err := db.queryRow(
+		`SELECT title_format, template FROM journal_settings WHERE user_id = ?`, userID,
+	).Scan(&s.TitleFormat, &s.Template)
+	if errors.Is(err, sql.ErrNoRows) {
+		return defaultJournalSettings, nil
+	}
+	if err != nil {
+		return s, fmt.Errorf("get journal settings: %w", err)
+	}
+	return s, nil
+}
+
+func (db *DB) SaveJournalSettings(userID string, s model.JournalSettings) error {
+	_, err := db.exec(
+		`INSERT INTO journal_settings (user_id, title_format, template) VALUES (?, ?, ?)
+		 ON CONFLICT(user_id) DO UPDATE SET
+		   title_format = excluded.title_format, template = excluded.template`,
+		userID, s.TitleFormat, s.Template,
+	)
+	if err != nil {
+		return fmt.Errorf("save journal settings: %w", err)
+	}
+	return nil
+}
+
+// GetJournalEntry returns the user's live journal note for day
+// (YYYY-MM-DD), or ErrNotFound.
+func (db *DB) GetJournalEntry(userID, day string) (*model.Note, error) {
+	return journalEntry(db.queryRow, userID, day)
+}
+
+// CreateJournalEntry returns the journal note for day if there is a live
+// one, and otherwise creates n as that note. created reports which.
+func (db *DB) CreateJournalEntry(day string, n *model.Note) (entry *model.Note, created bool, err error) {
+	err = db.withTx(func(tx *txn) error {
+		entry, err = journalEntry(tx.QueryRow, n.UserID, day)
+		if !errors.Is(err, ErrNotFound) {
+			return err
+		}
+		if err := insertNote(tx, n); err != nil {
+			return err
+		}
+		// A deleted entry's note is replaced.
+		if _, err := tx.Exec(
+			`INSERT INTO journal_entries (user_id, day, note_id) VALUES (?, ?, ?)
+			 ON CONFLICT(user_id, day) DO UPDATE SET note_id = excluded.note_id`,
+			n.UserID, day, n.ID,
+		); err != nil {
+			return fmt.Errorf("create journal entry: %w", err)
+		}
+		entry, created = n, true
+		return nil
+	})
+	return entry, created, err
+}
+
+func journalEntry(queryRow func(string, ...any) *sql.Row, userID, day string) (*model.Note, error) {
+	return scanNote(queryRow(
+		`SELECT `+noteColumns+`
+		 FROM notes WHERE deleted_at IS NULL AND id =
+		   (SELECT note_id FROM journal_entries WHERE user_id = ? AND day = ?)`,
+		userID, day,
+	))
+}
@@ -0,0 +1,230 @@
+package database
+
+import (
+	"fmt"
+	"regexp"
+	"strings"
+
+	"github.com/c0dev0id/notesd/server/internal/model"
+)
+
+// wikiLinkRe matches [[Target]] and [[Target|label]] links.
+var wikiLinkRe = regexp.MustCompile(`\[\[([^\[\]|]+)(?:\|([^\[\]]*))?\]\]`)
+
+// WikiLinks returns the distinct link targets in content, in order of first
+// appearance. Targets are note titles, trimmed but not case-folded.
+func WikiLinks(content string) []string {
+	var targets []string
+	seen := map[string]bool{}
+	for _, m := range wikiLinkRe.FindAllStringSubmatch(content, -1) {
+		t := strings.TrimSpace(m[1])
+		key := TitleKey(t)
+		if t == "" || seen[key] {
+			continue
+		}
+		seen[key] = true
+		targets = append(targets, t)
+	}
+	return targets
+}
+
+// ReplaceWikiLinks calls fn for every [[Target|label]] link in content and
+// substitutes its result. label is empty when the link has none. Links with
+// a blank target are left as written.
+func ReplaceWikiLinks(content string, fn func(target, label string) string) string {
+	return wikiLinkRe.ReplaceAllStringFunc(content, func(m string) string {
+		sub := wikiLinkRe.FindStringSubmatch(m)
+		target := strings.TrimSpace(sub[1])
+		if target == "" {
+			return m
+		}
+		return fn(target, strings.TrimSpace(sub[2]))
+	})
+}
+
+// TitleKey is the form in which link targets and titles are compared.
+func TitleKey(title string) string {
+	return strings.ToLower(strings.TrimSpace(title))
+}
+
+// setNoteLinks replaces the outgoing wiki links of a note. Targets are stored
+// by title key rather than note ID, so a link starts resolving as soon as a
+// note with that title exists.
+func setNoteLinks(tx *txn, noteID, content string) error {
+	if _, err := tx.Exec(`DELETE FROM note_links WHERE note_id = ?`, noteID); err != nil {
+		return fmt.Errorf("clear note links: %w", err)
+	}
+	for _, t := range WikiLinks(content) {
+		if _, err := tx.Exec(
+			`INSERT OR IGNORE INTO note_links (note_id, target) VALUES (?, ?)`, noteID, TitleKey(t),
+		); err != nil {
+			return fmt.Errorf("insert note link: %w", err)
+		}
+	}
+	return nil
+}
+
+// indexNoteLinks rebuilds note_links for every note. It runs once when a
+// database from before link indexing is opened.
+func (db *DB) indexNoteLinks() error {
+	return db.withTx(func(tx *txn) error {
+		rows, err := tx.Query(`SELECT id, plaintext(content) FROM notes`)
+		if err != nil {
+			return fmt.Errorf("list notes for links: %w", err)
+		}
+		contents := map[string]string{}
+		for rows.Next() {
+			var id, content string
+			if err := rows.Scan(&id, &content); err != nil {
+				rows.Close()
+				return fmt.Errorf("scan note for links: %w", err)
+			}
+			contents[id] = content
+		}
+		rows.Close()
+		if err := rows.Err(); err != nil {
+			return err
+		}
+		for id, content := range contents {
+			if err := setNoteLinks(tx, id, content); err != nil {
+				return err
+			}
+		}
+		return nil
+	})
+}
+
+// GetBacklinks returns the live notes that link to the given note by its
+// title, most recently modified first. Self-links are left out.
+func (db *DB) GetBacklinks(id, userID string) ([]model.Note, error) {
+	note, err := db.GetNote(id, userID)
+	if err != nil {
+		return nil, err
+	}
+	key := TitleKey(note.Title)
+	if key == "" {
+		return []model.Note{}, nil
+	}
+
+	rows, err := db.query(
+		`SELECT `+noteColumns+`
+		 FROM notes WHERE user_id = ? AND deleted_at IS NULL AND id != ?
+		 AND id IN (SELECT note_id FROM note_links WHERE target = ?)
+		 ORDER BY modified_at DESC`,
+		userID, id, key,
+	)
+	if err != nil {
+		return nil, fmt.Errorf("get backlinks: %w", err)
+	}
+	defer rows.Close()
+	notes, err := scanNotes(rows)
+	if err != nil {
+		return nil, err
+	}
+	if notes == nil {
+		notes = []model.Note{}
+	}
+	return notes, nil
+}
+
+// ResolveTitles maps the title keys of targets to the IDs of the user's live
+// notes with those titles. When several notes share a title the oldest one
+// wins, as in the note graph. Unknown titles are absent from the result.
+func (db *DB) ResolveTitles(userID string, targets []string) (map[string]string, error) {
+	ids := map[string]string{}
+	if len(targets) == 0 {
+		return ids, nil
+	}
+	want := map[string]bool{}
+	for _, t := range targets {
+		want[TitleKey(t)] = true
+	}
+
+	rows, err := db.query(
+		`SELECT id, title FROM notes WHERE user_id = ? AND deleted_at IS NULL
+		 ORDER BY created_at DESC`,
+		userID,
+	)
+	if err != nil {
+		return nil, fmt.Errorf("resolve titles: %w", err)
+	}
+	defer rows.Close()
+	for rows.Next() {
+		var id, title string
+		if err := rows.Scan(&id, &title); err != nil {
+			return nil, fmt.Errorf("scan title: %w", err)
+		}
+		if key := TitleKey(title); want[key] {
+			ids[key] = id
+		}
+	}
+	return ids, rows.Err()
+}
+
+// NoteGraph returns the graph of wiki links between the user's live notes,
+// with edges read from note_links. Links resolve as in ResolveTitles; those
+// to unknown titles and self-links are dropped. When tag is set only the
+// notes carrying it (any case) are nodes, and only links between two of
+// them are edges.
+func (db *DB) NoteGraph(userID, tag string) (model.GraphResponse, error) {
+	args := []any{}
+	tagged := `1`
+	if tag != "" {
+		tagged = `EXISTS (SELECT 1 FROM note_tags nt JOIN tags t ON t.id = nt.tag_id
+			WHERE nt.note_id = notes.id AND t.name = ?)`
+		args = append(args, tag)
+	}
+	rows, err := db.query(
+		`SELECT id, title, type, `+tagged+` FROM notes WHERE user_id = ? AND deleted_at IS NULL
+		 ORDER BY created_at DESC`,
+		append(args, userID)...,
+	)
+	if err != nil {
+		return model.GraphResponse{}, fmt.Errorf("graph notes: %w", err)
+	}
+	g := model.GraphResponse{Nodes: []model.GraphNode{}, Edges: []model.GraphEdge{}}
+	byTitle := map[string]string{}
+	nodes := map[string]bool{}
+	for rows.Next() {
+		var n model.GraphNode
+		var in bool
+		if err := rows.Scan(&n.ID, &n.Title, &n.Type, &in); err != nil {
+			rows.Close()
+			return model.GraphResponse{}, fmt.Errorf("scan graph note: %w", err)
+		}
+		if key := TitleKey(n.Title); key != "" {
+			byTitle[key] = n.ID
+		}
+		if in {
+			nodes[n.ID] = true
+			g.Nodes = append(g.Nodes, n)
+		}
+	}
+	rows.Close()
+	if err := rows.Err(); err != nil {
+		return model.GraphResponse{}, err
+	}
+
+	rows, err = db.query(
+		`SELECT l.note_id, l.target FROM note_links l JOIN notes n ON n.id = l.note_id
+		 WHERE n.user_id = ? AND n.deleted_at IS NULL
+		 ORDER BY l.note_id, l.target`,
+		userID,
+	)
+	if err != nil {
+		return model.GraphResponse{}, fmt.Errorf("graph links: %w", err)
+	}
+	defer rows.Close()
+	for rows.Next() {
+		var source, target string
+		if err := rows.Scan(&source, &target); err != nil {
+			return model.GraphResponse{}, fmt.Errorf("scan graph link: %w", err)
+		}
+		id, ok := byTitle[target]
+		if !ok || id == source || !nodes[source] || !nodes[id] {
+			continue
+		}
+		g.Edges = append(g.Edges, model.GraphEdge{Source: source, Target: id})
+	}
+	return g, rows.Err()
+}
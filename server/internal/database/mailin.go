@@ -0,0 +1,62 @@
+package database
+
+import (
+	"database/sql"
+	"errors"
+	"fmt"
+	"time"
+)
+
+// SetMailInToken stores the hash of a user's mail-in token, replacing any
+// previous one so the old address stops working.
+func (db *DB) SetMailInToken(userID, tokenHash string, now time.Time) error {
+	_, err := db.exec(
+		`INSERT INTO mail_in_addresses (user_id, token_hash, created_at) VALUES (?, ?, ?)
+		 ON CONFLICT(user_id) DO UPDATE SET
+		   token_hash = excluded.token_hash, created_at = excluded.created_at`,
+		userID, tokenHash, toMillis(now),
+	)
+	if err != nil {
+		return fmt.Errorf("set mail-in token: %w", err)
+	}
+	return nil
+}
+
+// GetMailInCreated returns when the user's current mail-in token was
+// generated, or ErrNotFound if there is none.
+func (db *DB) GetMailInCreated(userID string) (time.Time, error) {
+	var created int64
+	err := db.queryRow(
+		`SELECT created_at FROM mail_in_addresses WHERE user_id = ?`, userID,
+	).Scan(&created)
+	if errors.Is(err, sql.ErrNoRows) {
+		return time.Time{}, ErrNotFound
+	}
+	if err != nil {
+		return time.Time{}, fmt.Errorf("get mail-in address: %w", err)
+	}
+	return fromMillis(created), nil
+}
+
+func (db *DB) DeleteMailIn(userID string) error {
+	res, err := db.exec(`DELETE FROM mail_in_addresses WHERE user_id = ?`, userID)
+	if err != nil {
+		return fmt.Errorf("delete mail-in address: %w", err)
+	}
+	return checkRowsAffected(res)
+}
+
+// GetMailInUser returns the user owning the mail-in token hash.
+func (db *DB) GetMailInUser(tokenHash string) (string, error) {
+	var userID string
+	err := db.queryRow(
+		`SELECT user_id FROM mail_in_addresses WHERE token_hash = ?`, tokenHash,
+	).Scan(&userID)
+	if errors.Is(err, sql.ErrNoRows) {
+		return "", ErrNotFound
+	}
+	if err != nil {
+		return "", fmt.Errorf("get mail-in user: %w", err)
+	}
+	return userID, nil
+}
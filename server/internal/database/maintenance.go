@@ -0,0 +1,121 @@
+package database
+
+import (
+	"fmt"
+	"os"
+	"time"
+
+	"github.com/c0dev0id/notesd/server/internal/model"
+)
+
+// Backup writes a consistent snapshot of the database to path with VACUUM
+// INTO. It runs alongside readers and writers; the snapshot is compacted
+// and has no WAL file. path must not exist yet.
+func (db *DB) Backup(path string) error {
+	if _, err := os.Stat(path); err == nil {
+		return fmt.Errorf("%s already exists", path)
+	}
+	if _, err := db.exec(`VACUUM INTO ?`, path); err != nil {
+		return fmt.Errorf("backup: %w", err)
+	}
+	return nil
+}
+
+// Vacuum rebuilds the database file, returning the space left by deleted
+// rows to the file system. It needs free disk space about the size of the
+// database and blocks writers while it runs. It also switches databases
+// created before incremental auto-vacuum to it, so Maintain can free pages.
+func (db *DB) Vacuum() error {
+	if _, err := db.exec(`PRAGMA auto_vacuum = INCREMENTAL`); err != nil {
+		return fmt.Errorf("vacuum: %w", err)
+	}
+	if _, err := db.exec(`VACUUM`); err != nil {
+		return fmt.Errorf("vacuum: %w", err)
+	}
+	if _, err := db.exec(`PRAGMA wal_checkpoint(TRUNCATE)`); err != nil {
+		return fmt.Errorf("checkpoint: %w", err)
+	}
+	return nil
+}
+
+// autoVacuumIncremental is PRAGMA auto_vacuum's value for INCREMENTAL.
+const autoVacuumIncremental = 2
+
+// Maintain checkpoints the write-ahead log and truncates it, returns free
+// pages to the file system where the database uses incremental
+// auto-vacuum, refreshes the query planner's statistics and checks the
+// database for corruption. A failed integrity check is reported in the
+// result, not as an error.
+func (db *DB) Maintain() (m model.Maintenance, err error) {
+	start := time.Now()
+	m.StartedAt = start
+	defer func() { m.DurationMs = time.Since(start).Milliseconds() }()
+
+	// A reader still on an older snapshot keeps the checkpoint from
+	// reaching the end of the log; the next run catches up.
+	var busy, logPages, checkpointed int64
+	err = db.queryRow(`PRAGMA wal_checkpoint(TRUNCATE)`).Scan(&busy, &logPages, &checkpointed)
+	if err != nil {
+		return m, fmt.Errorf("checkpoint: %w", err)
+	}
+	m.Checkpointed = busy == 0
+	if err := db.incrementalVacuum(&m); err != nil {
+		return m, err
+	}
+	if _, err := db.exec(`PRAGMA optimize`); err != nil {
+		return m, fmt.Errorf("optimize: %w", err)
+	}
+
+	rows, err := db.query(`PRAGMA integrity_check`)
+	if err != nil {
+		return m, fmt.Errorf("integrity check: %w", err)
+	}
+	defer rows.Close()
+	for rows.Next() {
+		var line string
+		if err := rows.Scan(&line); err != nil {
+			return m, fmt.Errorf("integrity check: %w", err)
+		}
+		m.Integrity = append(m.Integrity, line)
+	}
+	if err := rows.Err(); err != nil {
+		return m, fmt.Errorf("integrity check: %w", err)
+	}
+	m.OK = len(m.Integrity) == 1 && m.Integrity[0] == "ok"
+	return m, nil
+}
+
+// incrementalVacuum returns the free pages to the file system, counting
+// them into m. Databases without incremental auto-vacuum are left alone.
+func (db *DB) incrementalVacuum(m *model.Maintenance) error {
+	var mode int
+	if err := db.queryRow(`PRAGMA auto_vacuum`).Scan(&mode); err != nil {
+		return fmt.Errorf("auto vacuum: %w", err)
+	}
+	if mode != autoVacuumIncremental {
+		return nil
+	}
+	var before, after int64
+	if err := db.queryRow(`PRAGMA freelist_count`).Scan(&before); err != nil {
+		return fmt.Errorf("freelist: %w", err)
+	}
+	// The pragma frees a page per step, so it is read to the end like a
+	// query; that also bypasses exec's write lock, taken here instead.
+	unlock := db.lockWrites()
+	rows, err := db.query(`PRAGMA incremental_vacuum`)
+	if err == nil {
+		for rows.Next() {
+		}
+		err = rows.Err()
+		rows.Close()
+	}
+	unlock()
+	if err != nil {
+		return fmt.Errorf("incremental vacuum: %w", err)
+	}
+	if err := db.queryRow(`PRAGMA freelist_count`).Scan(&after); err != nil {
+		return fmt.Errorf("freelist: %w", err)
+	}
+	m.FreedPages = before - after
+	return nil
+}
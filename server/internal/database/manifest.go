@@ -0,0 +1,104 @@
+package database
+
+import (
+	"database/sql"
+	"fmt"
+	"strings"
+
+	"github.com/c0dev0id/notesd/server/internal/delta"
+	"github.com/c0dev0id/notesd/server/internal/model"
+)
+
+// GetSyncManifest returns the version of every note and todo of a user,
+// tombstones included, sorted by ID.
+func (db *DB) GetSyncManifest(userID string) (notes, todos []model.ManifestEntry, err error) {
+	// Content is only read for notes written before hashes were stored.
+	rows, err := db.query(
+		`SELECT id, modified_at, deleted_at IS NOT NULL, content_hash,
+		   CASE WHEN content_hash = '' THEN plaintext(content) END
+		 FROM notes WHERE user_id = ? ORDER BY id`, userID,
+	)
+	if err != nil {
+		return nil, nil, fmt.Errorf("get note manifest: %w", err)
+	}
+	defer rows.Close()
+	notes = []model.ManifestEntry{}
+	for rows.Next() {
+		var e model.ManifestEntry
+		var modifiedAt int64
+		var content sql.NullString
+		if err := rows.Scan(&e.ID, &modifiedAt, &e.Deleted, &e.ContentHash, &content); err != nil {
+			return nil, nil, fmt.Errorf("scan note manifest: %w", err)
+		}
+		e.ModifiedAt = fromMillis(modifiedAt)
+		if e.ContentHash == "" {
+			e.ContentHash = delta.Hash(content.String)
+		}
+		notes = append(notes, e)
+	}
+	if err := rows.Err(); err != nil {
+		return nil, nil, fmt.Errorf("get note manifest: %w", err)
+	}
+
+	rows, err = db.query(
+		`SELECT id, modified_at, deleted_at IS NOT NULL FROM todos WHERE user_id = ? ORDER BY id`, userID,
+	)
+	if err != nil {
+		return nil, nil, fmt.Errorf("get todo manifest: %w", err)
+	}
+	defer rows.Close()
+	todos = []model.ManifestEntry{}
+	for rows.Next() {
+		var e model.ManifestEntry
+		var modifiedAt int64
+		if err := rows.Scan(&e.ID, &modifiedAt, &e.Deleted); err != nil {
+			return nil, nil, fmt.Errorf("scan todo manifest: %w", err)
+		}
+		e.ModifiedAt = fromMillis(modifiedAt)
+		todos = append(todos, e)
+	}
+	if err := rows.Err(); err != nil {
+		return nil, nil, fmt.Errorf("get todo manifest: %w", err)
+	}
+	return notes, todos, nil
+}
+
+// GetNotesByID returns the user's notes with the given IDs, tombstones
+// included. Unknown IDs are skipped.
+func (db *DB) GetNotesByID(userID string, ids []string) ([]model.Note, error) {
+	if len(ids) == 0 {
+		return nil, nil
+	}
+	marks, args := idList(userID, ids)
+	rows, err := db.query(`SELECT `+noteColumns+` FROM notes WHERE user_id = ? AND id IN (`+marks+`)`, args...)
+	if err != nil {
+		return nil, fmt.Errorf("get notes by id: %w", err)
+	}
+	defer rows.Close()
+	return scanNotes(rows)
+}
+
+// GetTodosByID is GetNotesByID for todos.
+func (db *DB) GetTodosByID(userID string, ids []string) ([]model.Todo, error) {
+	if len(ids) == 0 {
+		return nil, nil
+	}
+	marks, args := idList(userID, ids)
+	rows, err := db.query(`SELECT `+todoColumns+` FROM todos WHERE user_id = ? AND id IN (`+marks+`)`, args...)
+	if err != nil {
+		return nil, fmt.Errorf("get todos by id: %w", err)
+	}
+	defer rows.Close()
+	return scanTodos(rows)
+}
+
+// idList returns the placeholders for ids and the query arguments: userID
+// followed by the ids.
+func idList(userID string, ids []string) (string, []any) {
+	args := make([]any, 0, len(ids)+1)
+	args = append(args, userID)
+	for _, id := range ids {
+		args = append(args, id)
+	}
+	return strings.TrimSuffix(strings.Repeat("?,", len(ids)), ","), args
+}
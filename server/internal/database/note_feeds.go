@@ -0,0 +1,70 @@
+package database
+
+import (
+	"database/sql"
+	"errors"
+	"fmt"
+	"time"
+
+	"github.com/c0dev0id/notesd/server/internal/model"
+)
+
+// SetNoteFeedToken stores the hash of a user's note feed token, replacing
+// the previous one of the same kind so its URL stops working.
+func (db *DB) SetNoteFeedToken(userID string, published bool, tokenHash string, now time.Time) error {
+	_, err := db.exec(
+		`INSERT INTO note_feeds (user_id, published, token_hash, created_at) VALUES (?, ?, ?, ?)
+		 ON CONFLICT(user_id, published) DO UPDATE SET
+		   token_hash = excluded.token_hash, created_at = excluded.created_at`,
+		userID, published, tokenHash, toMillis(now),
+	)
+	if err != nil {
+		return fmt.Errorf("set note feed token: %w", err)
+	}
+	return nil
+}
+
+// ListNoteFeeds returns the user's note feeds, without their URLs.
+func (db *DB) ListNoteFeeds(userID string) ([]model.NoteFeed, error) {
+	rows, err := db.query(
+		`SELECT published, created_at FROM note_feeds WHERE user_id = ? ORDER BY published`, userID,
+	)
+	if err != nil {
+		return nil, fmt.Errorf("list note feeds: %w", err)
+	}
+	defer rows.Close()
+	var feeds []model.NoteFeed
+	for rows.Next() {
+		var f model.NoteFeed
+		var created int64
+		if err := rows.Scan(&f.Published, &created); err != nil {
+			return nil, fmt.Errorf("scan note feed: %w", err)
+		}
+		f.CreatedAt = fromMillis(created)
+		feeds = append(feeds, f)
+	}
+	return feeds, rows.Err()
+}
+
+func (db *DB) DeleteNoteFeed(userID string, published bool) error {
+	res, err := db.exec(`DELETE FROM note_feeds WHERE user_id = ? AND published = ?`, userID, published)
+	if err != nil {
+		return fmt.Errorf("delete note feed: %w", err)
+	}
+	return checkRowsAffected(res)
+}
+
+// GetNoteFeed returns the user owning the feed token hash and whether the
+// feed is of published notes only.
+func (db *DB) GetNoteFeed(tokenHash string) (userID string, published bool, err error) {
+	err = db.queryRow(
+		`SELECT user_id, published FROM note_feeds WHERE token_hash = ?`, tokenHash,
+	).Scan(&userID, &published)
+	if errors.Is(err, sql.ErrNoRows) {
+		return "", false, ErrNotFound
+	}
+	if err != nil {
+		return "", false, fmt.Errorf("get note feed: %w", err)
+	}
+	return userID, published, nil
+}
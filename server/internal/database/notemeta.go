@@ -0,0 +1,105 @@
+package database
+
+import (
+	"encoding/json"
+	"fmt"
+	"strings"
+
+	"github.com/c0dev0id/notesd/server/internal/frontmatter"
+	"github.com/c0dev0id/notesd/server/internal/model"
+)
+
+// noteMetadata returns the keys of the YAML front matter of content: a
+// string for one value and an array for several. Content without front
+// matter has none.
+func noteMetadata(content string) map[string]any {
+	fm, _ := frontmatter.Parse(content)
+	if len(fm) == 0 {
+		return nil
+	}
+	meta := make(map[string]any, len(fm))
+	for k, v := range fm {
+		switch len(v) {
+		case 0:
+			meta[k] = ""
+		case 1:
+			meta[k] = v[0]
+		default:
+			meta[k] = v
+		}
+	}
+	return meta
+}
+
+// metadataJSON returns the stored form of note metadata: a JSON object, or
+// an empty string for none.
+func metadataJSON(meta map[string]any) string {
+	if len(meta) == 0 {
+		return ""
+	}
+	// Values are strings and string slices, which always marshal.
+	b, _ := json.Marshal(meta)
+	return string(b)
+}
+
+// storeNoteMetadata derives and stores the front matter metadata of a
+// note that was written with new content.
+func storeNoteMetadata(tx *txn, n *model.Note) error {
+	n.Metadata = noteMetadata(n.Content)
+	_, err := tx.Exec(`UPDATE notes SET metadata = ? WHERE id = ?`,
+		tx.key.seal(metadataJSON(n.Metadata)), n.ID)
+	if err != nil {
+		return fmt.Errorf("store note metadata: %w", err)
+	}
+	return nil
+}
+
+// metaWhere returns the SQL condition matching notes whose metadata key
+// has value, or holds it in a list, ignoring case, and appends its
+// arguments to args.
+func metaWhere(key, value string, args *[]any) string {
+	*args = append(*args, strings.ToLower(key), value, value)
+	return `EXISTS (SELECT 1 FROM json_each(coalesce(nullif(plaintext(notes.metadata), ''), '{}')) m
+		WHERE m.key = ? AND (m.value = ? COLLATE NOCASE
+		  OR (m.type = 'array' AND EXISTS (SELECT 1 FROM json_each(m.value) v WHERE v.value = ? COLLATE NOCASE))))`
+}
+
+// addNoteMetadata adds the metadata column to notes and fills it in. It
+// runs once when a database from before metadata was stored is opened.
+func (db *DB) addNoteMetadata() error {
+	return db.withTx(func(tx *txn) error {
+		var n int
+		if err := tx.QueryRow(
+			`SELECT COUNT(*) FROM pragma_table_info('notes') WHERE name = 'metadata'`,
+		).Scan(&n); err != nil || n > 0 {
+			return err
+		}
+		if _, err := tx.Exec(`ALTER TABLE notes ADD COLUMN metadata TEXT NOT NULL DEFAULT ''`); err != nil {
+			return fmt.Errorf("add note metadata: %w", err)
+		}
+
+		rows, err := tx.Query(`SELECT id, plaintext(content) FROM notes`)
+		if err != nil {
+			return fmt.Errorf("list notes for metadata: %w", err)
+		}
+		var notes []*model.Note
+		for rows.Next() {
+			var n model.Note
+			if err := rows.Scan(&n.ID, &n.Content); err != nil {
+				rows.Close()
+				return fmt.Errorf("scan note for metadata: %w", err)
+			}
+			notes = append(notes, &n)
+		}
+		rows.Close()
+		if err := rows.Err(); err != nil {
+			return err
+		}
+		for _, n := range notes {
+			if err := storeNoteMetadata(tx, n); err != nil {
+				return err
+			}
+		}
+		return nil
+	})
+}
@@ -2,29 +2,47 @@ package database
 
 import (
 	"database/sql"
+	"encoding/json"
 	"errors"
 	"fmt"
+	"maps"
+	"slices"
+	"strings"
 
+	"github.com/c0dev0id/notesd/server/internal/delta"
 	"github.com/c0dev0id/notesd/server/internal/model"
 )
 
+// CreateNote inserts a note together with its tag links.
 func (db *DB) CreateNote(n *model.Note) error {
-	_, err := db.sql.Exec(
-		`INSERT INTO notes (id, user_id, title, content, type, modified_at, modified_by_device, deleted_at, created_at)
-		 VALUES (?, ?, ?, ?, ?, ?, ?, ?, ?)`,
-		n.ID, n.UserID, n.Title, n.Content, n.Type,
-		toMillis(n.ModifiedAt), n.ModifiedByDevice,
-		toNullMillis(n.DeletedAt), toMillis(n.CreatedAt),
+	return db.withTx(func(tx *txn) error {
+		return insertNote(tx, n)
+	})
+}
+
+func insertNote(tx *txn, n *model.Note) error {
+	setNoteStats(n)
+	n.Metadata = noteMetadata(n.Content)
+	_, err := tx.Exec(
+		`INSERT INTO notes (id, user_id, title, content, content_hash, type, snoozed_until,
+		 word_count, first_heading, link_count, metadata, modified_at, modified_by_device, deleted_at, created_at)
+		 VALUES (?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?)`,
+		n.ID, n.UserID, n.Title, tx.key.seal(n.Content), delta.Hash(n.Content), n.Type, toNullMillis(n.SnoozedUntil),
+		n.WordCount, tx.key.seal(n.FirstHeading), n.LinkCount, tx.key.seal(metadataJSON(n.Metadata)),
+		toMillis(n.ModifiedAt), n.ModifiedByDevice, toNullMillis(n.DeletedAt), toMillis(n.CreatedAt),
 	)
 	if err != nil {
 		return fmt.Errorf("create note: %w", err)
 	}
-	return nil
+	if err := setNoteLinks(tx, n.ID, n.Content); err != nil {
+		return err
+	}
+	return setNoteTags(tx, n.UserID, n.ID, n.Tags)
 }
 
 func (db *DB) GetNote(id, userID string) (*model.Note, error) {
-	row := db.sql.QueryRow(
-		`SELECT id, user_id, title, content, type, modified_at, modified_by_device, deleted_at, created_at
+	row := db.queryRow(
+		`SELECT `+noteColumns+`
 		 FROM notes WHERE id = ? AND user_id = ? AND deleted_at IS NULL`, id, userID,
 	)
 	return scanNote(row)
@@ -32,90 +50,499 @@ func (db *DB) GetNote(id, userID string) (*model.Note, error) {
 
 // GetNoteAny returns a note regardless of soft-delete state. Used by sync.
 func (db *DB) GetNoteAny(id, userID string) (*model.Note, error) {
-	row := db.sql.QueryRow(
-		`SELECT id, user_id, title, content, type, modified_at, modified_by_device, deleted_at, created_at
+	row := db.queryRow(
+		`SELECT `+noteColumns+`
 		 FROM notes WHERE id = ? AND user_id = ?`, id, userID,
 	)
 	return scanNote(row)
 }
 
-func (db *DB) ListNotes(userID string, limit, offset int) ([]model.Note, int, error) {
-	var total int
-	err := db.sql.QueryRow(
-		`SELECT COUNT(*) FROM notes WHERE user_id = ? AND deleted_at IS NULL`, userID,
-	).Scan(&total)
+// NoteFilter narrows note listings. The zero value lists every live note
+// that is not currently snoozed.
+type NoteFilter struct {
+	// Snoozed selects only currently snoozed notes instead of hiding them.
+	Snoozed bool
+	// Tag, when set, selects only notes carrying that tag (any case).
+	Tag string
+	// Published selects only notes with a public link that is neither
+	// revoked nor expired.
+	Published bool
+	// Sort lists sort keys (see ParseNoteSort), most significant first.
+	// Empty lists the most recently modified first.
+	Sort []string
+	// Meta selects only notes whose front matter metadata has each key
+	// with the value, or holds it in a list (keys and values in any case).
+	Meta map[string]string
+}
+
+// noteSortColumns maps the sort keys of note listings to their columns.
+var noteSortColumns = map[string]string{
+	"title":    "title COLLATE NOCASE",
+	"created":  "created_at",
+	"modified": "modified_at",
+	"words":    "word_count",
+	"links":    "link_count",
+}
+
+// ParseNoteSort splits a comma-separated list of sort keys: title,
+// created, modified, words and links, each ascending unless prefixed with
+// "-".
+func ParseNoteSort(s string) ([]string, error) {
+	if s == "" {
+		return nil, nil
+	}
+	keys := strings.Split(s, ",")
+	for _, k := range keys {
+		if _, ok := noteSortColumns[strings.TrimPrefix(k, "-")]; !ok {
+			return nil, fmt.Errorf("unknown sort key %q", k)
+		}
+	}
+	return keys, nil
+}
+
+// order returns the ORDER BY terms for f, ties going to the most recently
+// modified.
+func (f NoteFilter) order() string {
+	var terms []string
+	for _, k := range f.Sort {
+		dir := " ASC"
+		if strings.HasPrefix(k, "-") {
+			k, dir = k[1:], " DESC"
+		}
+		terms = append(terms, noteSortColumns[k]+dir)
+	}
+	return strings.Join(append(terms, "modified_at DESC"), ", ")
+}
+
+// where returns the SQL conditions for f (without a leading AND) and appends
+// their arguments to args.
+func (f NoteFilter) where(args *[]any) string {
+	*args = append(*args, model.NowMillis().UnixMilli())
+	cond := `(snoozed_until IS NULL OR snoozed_until <= ?)`
+	if f.Snoozed {
+		cond = `snoozed_until > ?`
+	}
+	if f.Tag != "" {
+		cond += ` AND EXISTS (SELECT 1 FROM note_tags nt JOIN tags t ON t.id = nt.tag_id
+			WHERE nt.note_id = notes.id AND t.name = ?)`
+		*args = append(*args, f.Tag)
+	}
+	if f.Published {
+		cond += ` AND EXISTS (SELECT 1 FROM public_links pl
+			WHERE pl.note_id = notes.id AND pl.revoked_at IS NULL
+			  AND (pl.expires_at IS NULL OR pl.expires_at > ?))`
+		*args = append(*args, model.NowMillis().UnixMilli())
+	}
+	for _, k := range slices.Sorted(maps.Keys(f.Meta)) {
+		cond += ` AND ` + metaWhere(k, f.Meta[k], args)
+	}
+	return cond
+}
+
+func (db *DB) ListNotes(userID string, f NoteFilter, limit, offset int) ([]model.Note, int, error) {
+	args := []any{userID}
+	cond := `user_id = ? AND deleted_at IS NULL AND ` + f.where(&args)
+
+	var (
+		notes []model.Note
+		total int
+	)
+	err := db.readTx(func(db *DB) error {
+		err := db.queryRow(`SELECT COUNT(*) FROM notes WHERE `+cond, args...).Scan(&total)
+		if err != nil {
+			return fmt.Errorf("count notes: %w", err)
+		}
+
+		rows, err := db.query(
+			`SELECT `+noteColumns+`
+			 FROM notes WHERE `+cond+`
+			 ORDER BY `+f.order()+` LIMIT ? OFFSET ?`,
+			append(args, limit, offset)...,
+		)
+		if err != nil {
+			return fmt.Errorf("list notes: %w", err)
+		}
+		defer rows.Close()
+
+		notes, err = scanNotes(rows)
+		return err
+	})
 	if err != nil {
-		return nil, 0, fmt.Errorf("count notes: %w", err)
+		return nil, 0, err
 	}
+	return notes, total, nil
+}
 
-	rows, err := db.sql.Query(
-		`SELECT id, user_id, title, content, type, modified_at, modified_by_device, deleted_at, created_at
+// GetAllNotes returns every non-deleted note of a user, newest first.
+func (db *DB) GetAllNotes(userID string) ([]model.Note, error) {
+	rows, err := db.query(
+		`SELECT `+noteColumns+`
 		 FROM notes WHERE user_id = ? AND deleted_at IS NULL
-		 ORDER BY modified_at DESC LIMIT ? OFFSET ?`,
-		userID, limit, offset,
+		 ORDER BY modified_at DESC`,
+		userID,
 	)
 	if err != nil {
-		return nil, 0, fmt.Errorf("list notes: %w", err)
+		return nil, fmt.Errorf("get all notes: %w", err)
 	}
 	defer rows.Close()
+	return scanNotes(rows)
+}
 
-	notes, err := scanNotes(rows)
+// NoteTitles returns the title of every non-deleted note of a user by ID,
+// without loading their content.
+func (db *DB) NoteTitles(userID string) (map[string]string, error) {
+	rows, err := db.query(`SELECT id, title FROM notes WHERE user_id = ? AND deleted_at IS NULL`, userID)
 	if err != nil {
-		return nil, 0, err
+		return nil, fmt.Errorf("note titles: %w", err)
 	}
-	return notes, total, nil
+	defer rows.Close()
+	titles := map[string]string{}
+	for rows.Next() {
+		var id, title string
+		if err := rows.Scan(&id, &title); err != nil {
+			return nil, fmt.Errorf("scan note title: %w", err)
+		}
+		titles[id] = title
+	}
+	return titles, rows.Err()
 }
 
+// UpdateNote writes a note. Tags are replaced only when n.Tags is non-nil.
 func (db *DB) UpdateNote(n *model.Note) error {
-	res, err := db.sql.Exec(
-		`UPDATE notes SET title = ?, content = ?, type = ?, modified_at = ?, modified_by_device = ?
+	return db.withTx(func(tx *txn) error {
+		return updateNote(tx, n)
+	})
+}
+
+func updateNote(tx *txn, n *model.Note) error {
+	prev, err := noteContent(tx, n.ID, n.UserID)
+	if err != nil {
+		return err
+	}
+	res, err := tx.Exec(
+		`UPDATE notes SET title = ?, content = ?, type = ?, snoozed_until = ?,
+		 modified_at = ?, modified_by_device = ?
 		 WHERE id = ? AND user_id = ? AND deleted_at IS NULL`,
-		n.Title, n.Content, n.Type, toMillis(n.ModifiedAt), n.ModifiedByDevice,
+		n.Title, tx.key.seal(n.Content), n.Type, toNullMillis(n.SnoozedUntil),
+		toMillis(n.ModifiedAt), n.ModifiedByDevice,
 		n.ID, n.UserID,
 	)
 	if err != nil {
 		return fmt.Errorf("update note: %w", err)
 	}
-	return checkRowsAffected(res)
+	if err := checkRowsAffected(res); err != nil {
+		return err
+	}
+	if err := storeContentDelta(tx, n.ID, prev, n.Content); err != nil {
+		return err
+	}
+	if err := setNoteLinks(tx, n.ID, n.Content); err != nil {
+		return err
+	}
+	if err := storeNoteStats(tx, n); err != nil {
+		return err
+	}
+	if err := storeNoteMetadata(tx, n); err != nil {
+		return err
+	}
+	if n.Tags == nil {
+		return nil
+	}
+	return setNoteTags(tx, n.UserID, n.ID, n.Tags)
 }
 
-func (db *DB) DeleteNote(id, userID string, deletedAt int64, deviceID string) error {
-	res, err := db.sql.Exec(
-		`UPDATE notes SET deleted_at = ?, modified_at = ?, modified_by_device = ?
-		 WHERE id = ? AND user_id = ? AND deleted_at IS NULL`,
-		deletedAt, deletedAt, deviceID, id, userID,
+// noteContent returns the stored content of a note, or "" if it does not
+// exist.
+func noteContent(tx *txn, id, userID string) (string, error) {
+	var content string
+	err := tx.QueryRow(`SELECT plaintext(content) FROM notes WHERE id = ? AND user_id = ?`, id, userID).Scan(&content)
+	if err != nil && !errors.Is(err, sql.ErrNoRows) {
+		return "", fmt.Errorf("get note content: %w", err)
+	}
+	return content, nil
+}
+
+// storeContentDelta records the hash of a note's new content and, if it
+// changed, a patch from the previous content for delta sync. The patch is
+// kept only when it is smaller than the content itself.
+func storeContentDelta(tx *txn, id, prev, content string) error {
+	if prev == content {
+		return nil
+	}
+	var base, patch sql.NullString
+	if b, err := json.Marshal(delta.Diff(prev, content)); err == nil && len(b) < len(content) {
+		base = sql.NullString{String: delta.Hash(prev), Valid: true}
+		patch = sql.NullString{String: tx.key.seal(string(b)), Valid: true}
+	}
+	_, err := tx.Exec(
+		`UPDATE notes SET content_hash = ?, base_hash = ?, content_patch = ? WHERE id = ?`,
+		delta.Hash(content), base, patch, id,
 	)
 	if err != nil {
-		return fmt.Errorf("delete note: %w", err)
+		return fmt.Errorf("store content delta: %w", err)
 	}
-	return checkRowsAffected(res)
+	return nil
 }
 
-func (db *DB) SearchNotes(userID, query string, limit, offset int) ([]model.Note, int, error) {
-	pattern := "%" + query + "%"
+// addNoteContentDeltas adds the delta sync columns to notes. The hashes of
+// the existing notes are left empty and computed when the notes are read,
+// and they have no patch until their next change. It runs once when a
+// database from before delta sync is opened.
+func (db *DB) addNoteContentDeltas() error {
+	return db.withTx(func(tx *txn) error {
+		var n int
+		if err := tx.QueryRow(
+			`SELECT COUNT(*) FROM pragma_table_info('notes') WHERE name = 'content_hash'`,
+		).Scan(&n); err != nil || n > 0 {
+			return err
+		}
+		for _, stmt := range []string{
+			`ALTER TABLE notes ADD COLUMN content_hash TEXT NOT NULL DEFAULT ''`,
+			`ALTER TABLE notes ADD COLUMN base_hash TEXT`,
+			`ALTER TABLE notes ADD COLUMN content_patch TEXT`,
+		} {
+			if _, err := tx.Exec(stmt); err != nil {
+				return fmt.Errorf("add note content deltas: %w", err)
+			}
+		}
+		return nil
+	})
+}
 
-	var total int
-	err := db.sql.QueryRow(
-		`SELECT COUNT(*) FROM notes
-		 WHERE user_id = ? AND deleted_at IS NULL AND (title LIKE ? OR content LIKE ?)`,
-		userID, pattern, pattern,
-	).Scan(&total)
-	if err != nil {
-		return nil, 0, fmt.Errorf("count search: %w", err)
-	}
+// NotePatch is the last content change of a note: a patch from the content
+// with hash BaseHash to the current content.
+type NotePatch struct {
+	BaseHash string
+	Patch    delta.Patch
+}
 
-	rows, err := db.sql.Query(
-		`SELECT id, user_id, title, content, type, modified_at, modified_by_device, deleted_at, created_at
-		 FROM notes WHERE user_id = ? AND deleted_at IS NULL AND (title LIKE ? OR content LIKE ?)
-		 ORDER BY modified_at DESC LIMIT ? OFFSET ?`,
-		userID, pattern, pattern, limit, offset,
+// GetNotePatches returns the stored patches of the given notes. Notes
+// without one are absent from the map.
+func (db *DB) GetNotePatches(userID string, ids []string) (map[string]NotePatch, error) {
+	patches := make(map[string]NotePatch)
+	if len(ids) == 0 {
+		return patches, nil
+	}
+	marks, args := idList(userID, ids)
+	rows, err := db.query(
+		`SELECT id, base_hash, plaintext(content_patch) FROM notes
+		 WHERE user_id = ? AND content_patch IS NOT NULL AND id IN (`+marks+`)`,
+		args...,
 	)
 	if err != nil {
-		return nil, 0, fmt.Errorf("search notes: %w", err)
+		return nil, fmt.Errorf("get note patches: %w", err)
 	}
 	defer rows.Close()
 
-	notes, err := scanNotes(rows)
+	for rows.Next() {
+		var id, base, raw string
+		if err := rows.Scan(&id, &base, &raw); err != nil {
+			return nil, fmt.Errorf("scan note patch: %w", err)
+		}
+		var p NotePatch
+		if err := json.Unmarshal([]byte(raw), &p.Patch); err != nil {
+			return nil, fmt.Errorf("decode note patch %s: %w", id, err)
+		}
+		p.BaseHash = base
+		patches[id] = p
+	}
+	return patches, rows.Err()
+}
+
+// TodoCascade says what deleting a note does to the todos attached to it.
+type TodoCascade string
+
+const (
+	// KeepTodos leaves them pointing at the deleted note.
+	KeepTodos TodoCascade = "keep"
+	// DeleteTodos soft-deletes them along with the note.
+	DeleteTodos TodoCascade = "delete"
+	// DetachTodos clears their note_id and line_ref.
+	DetachTodos TodoCascade = "detach"
+)
+
+// CascadedTodos lists the todos changed by deleting notes.
+type CascadedTodos struct {
+	Deleted  []string
+	Detached []string
+}
+
+// DeleteNote soft-deletes a note and applies cascade to its todos in the
+// same transaction. It returns the todos changed.
+func (db *DB) DeleteNote(id, userID string, deletedAt int64, deviceID string, cascade TodoCascade) (CascadedTodos, error) {
+	var c CascadedTodos
+	err := db.withTx(func(tx *txn) error {
+		res, err := tx.Exec(
+			`UPDATE notes SET deleted_at = ?, modified_at = ?, modified_by_device = ?
+			 WHERE id = ? AND user_id = ? AND deleted_at IS NULL`,
+			deletedAt, deletedAt, deviceID, id, userID,
+		)
+		if err != nil {
+			return fmt.Errorf("delete note: %w", err)
+		}
+		if err := checkRowsAffected(res); err != nil {
+			return err
+		}
+		return cascadeNoteTodos(tx, &c, id, userID, deletedAt, deviceID, cascade)
+	})
+	return c, err
+}
+
+// CascadeNoteTodos applies cascade to the todos of a note deleted by other
+// means than DeleteNote, such as a sync push, and returns the todos
+// changed.
+func (db *DB) CascadeNoteTodos(noteID, userID string, deletedAt int64, deviceID string, cascade TodoCascade) (CascadedTodos, error) {
+	var c CascadedTodos
+	err := db.withTx(func(tx *txn) error {
+		return cascadeNoteTodos(tx, &c, noteID, userID, deletedAt, deviceID, cascade)
+	})
+	return c, err
+}
+
+// cascadeNoteTodos applies cascade to the live todos of a note deleted at
+// deletedAt and adds them to c. Their modified_at is bumped so the change
+// propagates via sync.
+func cascadeNoteTodos(tx *txn, c *CascadedTodos, noteID, userID string, deletedAt int64, deviceID string, cascade TodoCascade) error {
+	var set string
+	switch cascade {
+	case DeleteTodos:
+		set = `deleted_at = ?1`
+	case DetachTodos:
+		set = `note_id = NULL, line_ref = NULL, note_id_modified_at = ?1`
+	default:
+		return nil
+	}
+	ids, err := queryIDs(tx,
+		`SELECT id FROM todos WHERE note_id = ? AND user_id = ? AND deleted_at IS NULL`,
+		noteID, userID)
+	if err != nil || len(ids) == 0 {
+		return err
+	}
+	if _, err := tx.Exec(
+		`UPDATE todos SET `+set+`, modified_at = ?1, modified_by_device = ?2
+		 WHERE note_id = ?3 AND user_id = ?4 AND deleted_at IS NULL`,
+		deletedAt, deviceID, noteID, userID,
+	); err != nil {
+		return fmt.Errorf("cascade note todos: %w", err)
+	}
+	if cascade == DeleteTodos {
+		c.Deleted = append(c.Deleted, ids...)
+	} else {
+		c.Detached = append(c.Detached, ids...)
+	}
+	return nil
+}
+
+// MergeNotes writes the merged target note, re-points all todos and
+// attachments of the source notes at the target and soft-deletes the
+// sources, atomically. It returns the IDs of the re-pointed todos.
+func (db *DB) MergeNotes(target *model.Note, sourceIDs []string) ([]string, error) {
+	var moved []string
+	err := db.withTx(func(tx *txn) error {
+		prev, err := noteContent(tx, target.ID, target.UserID)
+		if err != nil {
+			return err
+		}
+		res, err := tx.Exec(
+			`UPDATE notes SET title = ?, content = ?, modified_at = ?, modified_by_device = ?, created_at = ?
+			 WHERE id = ? AND user_id = ? AND deleted_at IS NULL`,
+			target.Title, tx.key.seal(target.Content), toMillis(target.ModifiedAt), target.ModifiedByDevice,
+			toMillis(target.CreatedAt), target.ID, target.UserID,
+		)
+		if err != nil {
+			return fmt.Errorf("merge update target: %w", err)
+		}
+		if err := checkRowsAffected(res); err != nil {
+			return err
+		}
+		if err := storeContentDelta(tx, target.ID, prev, target.Content); err != nil {
+			return err
+		}
+		if err := setNoteLinks(tx, target.ID, target.Content); err != nil {
+			return err
+		}
+		if err := storeNoteStats(tx, target); err != nil {
+			return err
+		}
+		if err := storeNoteMetadata(tx, target); err != nil {
+			return err
+		}
+		if err := setNoteTags(tx, target.UserID, target.ID, target.Tags); err != nil {
+			return err
+		}
+
+		now := toMillis(target.ModifiedAt)
+		for _, id := range sourceIDs {
+			ids, err := queryIDs(tx,
+				`SELECT id FROM todos WHERE note_id = ? AND user_id = ? AND deleted_at IS NULL`,
+				id, target.UserID)
+			if err != nil {
+				return err
+			}
+			moved = append(moved, ids...)
+			// Todo modified_at is bumped so the re-pointing propagates via sync.
+			if _, err := tx.Exec(
+				`UPDATE todos SET note_id = ?, note_id_modified_at = ?, modified_at = ?, modified_by_device = ?
+				 WHERE note_id = ? AND user_id = ? AND deleted_at IS NULL`,
+				target.ID, now, now, target.ModifiedByDevice, id, target.UserID,
+			); err != nil {
+				return fmt.Errorf("merge move todos: %w", err)
+			}
+			// Attachments follow too, or collecting the source's tombstone
+			// would delete their files.
+			if _, err := tx.Exec(
+				`UPDATE attachments SET note_id = ?, modified_at = ?
+				 WHERE note_id = ? AND user_id = ? AND deleted_at IS NULL`,
+				target.ID, now, id, target.UserID,
+			); err != nil {
+				return fmt.Errorf("merge move attachments: %w", err)
+			}
+			res, err := tx.Exec(
+				`UPDATE notes SET deleted_at = ?, modified_at = ?, modified_by_device = ?
+				 WHERE id = ? AND user_id = ? AND deleted_at IS NULL`,
+				now, now, target.ModifiedByDevice, id, target.UserID,
+			)
+			if err != nil {
+				return fmt.Errorf("merge delete source: %w", err)
+			}
+			if err := checkRowsAffected(res); err != nil {
+				return err
+			}
+		}
+		return nil
+	})
+	return moved, err
+}
+
+func (db *DB) SearchNotes(userID, query string, f NoteFilter, limit, offset int) ([]model.Note, int, error) {
+	pattern := "%" + query + "%"
+	args := []any{userID, pattern, pattern}
+	cond := `user_id = ? AND deleted_at IS NULL AND (title LIKE ? OR plaintext(content) LIKE ?) AND ` + f.where(&args)
+
+	var (
+		notes []model.Note
+		total int
+	)
+	err := db.readTx(func(db *DB) error {
+		err := db.queryRow(`SELECT COUNT(*) FROM notes WHERE `+cond, args...).Scan(&total)
+		if err != nil {
+			return fmt.Errorf("count search: %w", err)
+		}
+
+		rows, err := db.query(
+			`SELECT `+noteColumns+`
+			 FROM notes WHERE `+cond+`
+			 ORDER BY modified_at DESC LIMIT ? OFFSET ?`,
+			append(args, limit, offset)...,
+		)
+		if err != nil {
+			return fmt.Errorf("search notes: %w", err)
+		}
+		defer rows.Close()
+
+		notes, err = scanNotes(rows)
+		return err
+	})
 	if err != nil {
 		return nil, 0, err
 	}
@@ -125,8 +552,8 @@ func (db *DB) SearchNotes(userID, query string, limit, offset int) ([]model.Note
 // GetNoteChangesSince returns all notes modified after the given timestamp (unix ms),
 // including soft-deleted notes. Used by the sync endpoint.
 func (db *DB) GetNoteChangesSince(userID string, sinceMs int64) ([]model.Note, error) {
-	rows, err := db.sql.Query(
-		`SELECT id, user_id, title, content, type, modified_at, modified_by_device, deleted_at, created_at
+	rows, err := db.query(
+		`SELECT `+noteColumns+`
 		 FROM notes WHERE user_id = ? AND modified_at > ?
 		 ORDER BY modified_at ASC`,
 		userID, sinceMs,
@@ -139,78 +566,148 @@ func (db *DB) GetNoteChangesSince(userID string, sinceMs int64) ([]model.Note, e
 }
 
 // UpsertNote inserts or updates a note using LWW conflict resolution.
-// Returns the server's version if the incoming note loses the conflict.
-func (db *DB) UpsertNote(n *model.Note) (*model.Note, error) {
+// Returns the server's version as conflict if the incoming note loses, and
+// otherwise the version it replaced, if any, as prev.
+// The comparison and the write share a transaction, so a concurrent write
+// cannot slip in between.
+func (db *DB) UpsertNote(n *model.Note) (conflict, prev *model.Note, err error) {
+	err = db.WithTx(db.ctx, func(tx *Tx) error {
+		conflict, prev, err = tx.upsertNote(n)
+		return err
+	})
+	return conflict, prev, err
+}
+
+func (db *DB) upsertNote(n *model.Note) (conflict, prev *model.Note, err error) {
 	existing, err := db.GetNoteAny(n.ID, n.UserID)
 	if errors.Is(err, ErrNotFound) {
-		return nil, db.CreateNote(n)
+		return nil, nil, db.CreateNote(n)
 	}
 	if err != nil {
-		return nil, err
+		return nil, nil, err
 	}
 
 	// LWW: accept if incoming timestamp is newer, or equal with higher device ID
+	// Tags are replaced only when the incoming note carries them, so clients
+	// that predate tags do not clear them.
 	if n.ModifiedAt.After(existing.ModifiedAt) ||
 		(n.ModifiedAt.Equal(existing.ModifiedAt) && n.ModifiedByDevice > existing.ModifiedByDevice) {
-		_, err := db.sql.Exec(
-			`UPDATE notes SET title = ?, content = ?, type = ?, modified_at = ?,
-			 modified_by_device = ?, deleted_at = ?
-			 WHERE id = ? AND user_id = ?`,
-			n.Title, n.Content, n.Type, toMillis(n.ModifiedAt),
-			n.ModifiedByDevice, toNullMillis(n.DeletedAt),
-			n.ID, n.UserID,
-		)
-		if err != nil {
-			return nil, fmt.Errorf("upsert note: %w", err)
-		}
-		return nil, nil
+		return nil, existing, db.withTx(func(tx *txn) error {
+			_, err := tx.Exec(
+				`UPDATE notes SET title = ?, content = ?, type = ?, snoozed_until = ?, modified_at = ?,
+				 modified_by_device = ?, deleted_at = ?
+				 WHERE id = ? AND user_id = ?`,
+				n.Title, tx.key.seal(n.Content), n.Type, toNullMillis(n.SnoozedUntil), toMillis(n.ModifiedAt),
+				n.ModifiedByDevice, toNullMillis(n.DeletedAt),
+				n.ID, n.UserID,
+			)
+			if err != nil {
+				return fmt.Errorf("upsert note: %w", err)
+			}
+			if err := storeContentDelta(tx, n.ID, existing.Content, n.Content); err != nil {
+				return err
+			}
+			if err := setNoteLinks(tx, n.ID, n.Content); err != nil {
+				return err
+			}
+			if err := storeNoteStats(tx, n); err != nil {
+				return err
+			}
+			if err := storeNoteMetadata(tx, n); err != nil {
+				return err
+			}
+			if n.Tags == nil {
+				return nil
+			}
+			return setNoteTags(tx, n.UserID, n.ID, n.Tags)
+		})
 	}
 
 	// Server version wins — return it as conflict
-	return existing, nil
+	return existing, nil, nil
 }
 
-func scanNote(row *sql.Row) (*model.Note, error) {
+// noteColumns is the select list matching scanNoteRow. Tags are folded into
+// one unit-separator-delimited column to avoid a query per note.
+const noteColumns = `id, user_id, title, plaintext(content), content_hash, type, snoozed_until,
+	word_count, plaintext(first_heading), link_count, plaintext(metadata), modified_at, modified_by_device, deleted_at, created_at,
+	(SELECT group_concat(t.name, char(31)) FROM note_tags nt JOIN tags t ON t.id = nt.tag_id
+	 WHERE nt.note_id = notes.id)`
+
+// rowScanner is satisfied by both *sql.Row and *sql.Rows.
+type rowScanner interface {
+	Scan(dest ...any) error
+}
+
+func scanNoteRow(s rowScanner) (*model.Note, error) {
 	var n model.Note
 	var modifiedAt, createdAt int64
-	var deletedAt sql.NullInt64
-	err := row.Scan(
-		&n.ID, &n.UserID, &n.Title, &n.Content, &n.Type,
-		&modifiedAt, &n.ModifiedByDevice, &deletedAt, &createdAt,
+	var deletedAt, snoozedUntil sql.NullInt64
+	var tags sql.NullString
+	var metadata string
+	err := s.Scan(
+		&n.ID, &n.UserID, &n.Title, &n.Content, &n.ContentHash, &n.Type, &snoozedUntil,
+		&n.WordCount, &n.FirstHeading, &n.LinkCount, &metadata, &modifiedAt, &n.ModifiedByDevice, &deletedAt, &createdAt, &tags,
 	)
-	if errors.Is(err, sql.ErrNoRows) {
-		return nil, ErrNotFound
-	}
 	if err != nil {
-		return nil, fmt.Errorf("scan note: %w", err)
+		return nil, err
+	}
+	if metadata != "" {
+		if err := json.Unmarshal([]byte(metadata), &n.Metadata); err != nil {
+			return nil, fmt.Errorf("metadata of note %s: %w", n.ID, err)
+		}
 	}
 	n.ModifiedAt = fromMillis(modifiedAt)
+	n.SnoozedUntil = fromNullMillis(snoozedUntil)
 	n.DeletedAt = fromNullMillis(deletedAt)
 	n.CreatedAt = fromMillis(createdAt)
+	n.Tags = splitTags(tags)
+	n.ReadingMinutes = readingMinutes(n.WordCount)
+	if n.ContentHash == "" {
+		// Written before content hashes were stored
+		n.ContentHash = delta.Hash(n.Content)
+	}
 	return &n, nil
 }
 
+func scanNote(row *sql.Row) (*model.Note, error) {
+	n, err := scanNoteRow(row)
+	if errors.Is(err, sql.ErrNoRows) {
+		return nil, ErrNotFound
+	}
+	if err != nil {
+		return nil, fmt.Errorf("scan note: %w", err)
+	}
+	return n, nil
+}
+
 func scanNotes(rows *sql.Rows) ([]model.Note, error) {
 	var notes []model.Note
 	for rows.Next() {
-		var n model.Note
-		var modifiedAt, createdAt int64
-		var deletedAt sql.NullInt64
-		err := rows.Scan(
-			&n.ID, &n.UserID, &n.Title, &n.Content, &n.Type,
-			&modifiedAt, &n.ModifiedByDevice, &deletedAt, &createdAt,
-		)
+		n, err := scanNoteRow(rows)
 		if err != nil {
 			return nil, fmt.Errorf("scan note row: %w", err)
 		}
-		n.ModifiedAt = fromMillis(modifiedAt)
-		n.DeletedAt = fromNullMillis(deletedAt)
-		n.CreatedAt = fromMillis(createdAt)
-		notes = append(notes, n)
+		notes = append(notes, *n)
 	}
 	return notes, rows.Err()
 }
 
+// addNoteSnooze adds the snoozed_until column to a notes table from before
+// snoozing. A database without notes gets the table from the schema.
+func (db *DB) addNoteSnooze() error {
+	var cols, n int
+	if err := db.queryRow(
+		`SELECT COUNT(*), COUNT(*) FILTER (WHERE name = 'snoozed_until') FROM pragma_table_info('notes')`,
+	).Scan(&cols, &n); err != nil || cols == 0 || n > 0 {
+		return err
+	}
+	if _, err := db.exec(`ALTER TABLE notes ADD COLUMN snoozed_until INTEGER`); err != nil {
+		return fmt.Errorf("add note snooze: %w", err)
+	}
+	return nil
+}
+
 func checkRowsAffected(res sql.Result) error {
 	n, err := res.RowsAffected()
 	if err != nil {
@@ -221,3 +718,36 @@ func checkRowsAffected(res sql.Result) error {
 	}
 	return nil
 }
+
+// noteTypeCheck is the constraint that limited notes to the built-in types
+// before the types became configurable.
+const noteTypeCheck = ` CHECK(type IN ('note', 'todo_list'))`
+
+// dropNoteTypeCheck removes noteTypeCheck from the notes table. SQLite
+// cannot drop a constraint in place, and rebuilding the table would trip
+// the foreign keys pointing at it, so the stored definition is edited
+// instead, as SQLite allows for removing constraints. It runs once when a
+// database from before configurable note types is opened.
+func (db *DB) dropNoteTypeCheck() error {
+	return db.withTx(func(tx *txn) error {
+		var version int
+		if err := tx.QueryRow(`PRAGMA schema_version`).Scan(&version); err != nil {
+			return err
+		}
+		for _, stmt := range []struct {
+			sql  string
+			args []any
+		}{
+			{`PRAGMA writable_schema = ON`, nil},
+			{`UPDATE sqlite_master SET sql = replace(sql, ?, '') WHERE type = 'table' AND name = 'notes'`,
+				[]any{noteTypeCheck}},
+			{fmt.Sprintf(`PRAGMA schema_version = %d`, version+1), nil},
+			{`PRAGMA writable_schema = OFF`, nil},
+		} {
+			if _, err := tx.Exec(stmt.sql, stmt.args...); err != nil {
+				return fmt.Errorf("drop note type check: %w", err)
+			}
+		}
+		return nil
+	})
+}
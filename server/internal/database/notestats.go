@@ -0,0 +1,122 @@
+package database
+
+import (
+	"fmt"
+	"regexp"
+	"strings"
+	"unicode"
+
+	"github.com/c0dev0id/notesd/server/internal/model"
+)
+
+// wordsPerMinute is the reading speed behind reading times.
+const wordsPerMinute = 200
+
+// noteLinkRe matches what renders as a link: wiki links, Markdown links
+// and images, autolinks and bare URLs, which GFM links too. Images are told
+// apart by their leading "!".
+var noteLinkRe = regexp.MustCompile(`\[\[[^\[\]|]+(?:\|[^\[\]]*)?\]\]` +
+	`|(!?)\[[^\[\]]*\]\([^()\s]*(?:\s+"[^"]*")?\)` +
+	`|<https?://[^<>\s]+>` +
+	`|https?://[^\s<>()\[\]]+`)
+
+// headingRe matches an ATX heading line and captures its text without the
+// optional closing hashes.
+var headingRe = regexp.MustCompile(`^ {0,3}#{1,6}[ \t]+(.*?)(?:[ \t]+#+)?[ \t]*$`)
+
+// setNoteStats derives the metadata of n from its content: words (runs
+// holding a letter or digit, so Markdown markers do not count), reading
+// time, first heading outside code blocks and links.
+func setNoteStats(n *model.Note) {
+	n.WordCount, n.FirstHeading, n.LinkCount = 0, "", 0
+	for _, f := range strings.Fields(n.Content) {
+		if strings.IndexFunc(f, func(r rune) bool { return unicode.IsLetter(r) || unicode.IsDigit(r) }) >= 0 {
+			n.WordCount++
+		}
+	}
+	n.ReadingMinutes = readingMinutes(n.WordCount)
+
+	fenced := false
+	for _, line := range strings.Split(n.Content, "\n") {
+		trimmed := strings.TrimLeft(line, " ")
+		if strings.HasPrefix(trimmed, "```") || strings.HasPrefix(trimmed, "~~~") {
+			fenced = !fenced
+			continue
+		}
+		if m := headingRe.FindStringSubmatch(strings.TrimSuffix(line, "\r")); !fenced && m != nil && m[1] != "" {
+			n.FirstHeading = m[1]
+			break
+		}
+	}
+
+	for _, m := range noteLinkRe.FindAllStringSubmatch(n.Content, -1) {
+		if m[1] != "!" {
+			n.LinkCount++
+		}
+	}
+}
+
+// readingMinutes is the time to read words at wordsPerMinute, rounded up.
+func readingMinutes(words int) int {
+	return (words + wordsPerMinute - 1) / wordsPerMinute
+}
+
+// storeNoteStats derives and stores the metadata of a note that was
+// written with new content.
+func storeNoteStats(tx *txn, n *model.Note) error {
+	setNoteStats(n)
+	_, err := tx.Exec(
+		`UPDATE notes SET word_count = ?, first_heading = ?, link_count = ? WHERE id = ?`,
+		n.WordCount, tx.key.seal(n.FirstHeading), n.LinkCount, n.ID,
+	)
+	if err != nil {
+		return fmt.Errorf("store note stats: %w", err)
+	}
+	return nil
+}
+
+// addNoteStats adds the metadata columns to notes and fills them in. It
+// runs once when a database from before they were stored is opened.
+func (db *DB) addNoteStats() error {
+	return db.withTx(func(tx *txn) error {
+		var n int
+		if err := tx.QueryRow(
+			`SELECT COUNT(*) FROM pragma_table_info('notes') WHERE name = 'word_count'`,
+		).Scan(&n); err != nil || n > 0 {
+			return err
+		}
+		for _, stmt := range []string{
+			`ALTER TABLE notes ADD COLUMN word_count INTEGER NOT NULL DEFAULT 0`,
+			`ALTER TABLE notes ADD COLUMN first_heading TEXT NOT NULL DEFAULT ''`,
+			`ALTER TABLE notes ADD COLUMN link_count INTEGER NOT NULL DEFAULT 0`,
+		} {
+			if _, err := tx.Exec(stmt); err != nil {
+				return fmt.Errorf("add note stats: %w", err)
+			}
+		}
+
+		rows, err := tx.Query(`SELECT id, plaintext(content) FROM notes`)
+		if err != nil {
+			return fmt.Errorf("list notes for stats: %w", err)
+		}
+		var notes []*model.Note
+		for rows.Next() {
+			var n model.Note
+			if err := rows.Scan(&n.ID, &n.Content); err != nil {
+				rows.Close()
+				return fmt.Errorf("scan note for stats: %w", err)
+			}
+			notes = append(notes, &n)
+		}
+		rows.Close()
+		if err := rows.Err(); err != nil {
+			return err
+		}
+		for _, n := range notes {
+			if err := storeNoteStats(tx, n); err != nil {
+				return err
+			}
+		}
+		return nil
+	})
+}
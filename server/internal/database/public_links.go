@@ -0,0 +1,101 @@
+package database
+
+import (
+	"database/sql"
+	"errors"
+	"fmt"
+
+	"github.com/c0dev0id/notesd/server/internal/model"
+)
+
+func (db *DB) CreatePublicLink(userID string, l *model.PublicLink) error {
+	_, err := db.exec(
+		`INSERT INTO public_links (slug, user_id, note_id, expires_at, created_at)
+		 VALUES (?, ?, ?, ?, ?)`,
+		l.Slug, userID, l.NoteID, toNullMillis(l.ExpiresAt), toMillis(l.CreatedAt),
+	)
+	if err != nil {
+		return fmt.Errorf("create public link: %w", err)
+	}
+	return nil
+}
+
+// ListPublicLinks returns the note's links that are neither revoked nor
+// expired at nowMs, newest first.
+func (db *DB) ListPublicLinks(noteID, userID string, nowMs int64) ([]model.PublicLink, error) {
+	rows, err := db.query(
+		`SELECT slug, note_id, expires_at, created_at FROM public_links
+		 WHERE note_id = ? AND user_id = ? AND revoked_at IS NULL
+		   AND (expires_at IS NULL OR expires_at > ?)
+		 ORDER BY created_at DESC`, noteID, userID, nowMs,
+	)
+	if err != nil {
+		return nil, fmt.Errorf("list public links: %w", err)
+	}
+	defer rows.Close()
+	return scanPublicLinks(rows)
+}
+
+// GetAllPublicLinks returns all of the user's links that are neither
+// revoked nor expired at nowMs, newest first.
+func (db *DB) GetAllPublicLinks(userID string, nowMs int64) ([]model.PublicLink, error) {
+	rows, err := db.query(
+		`SELECT slug, note_id, expires_at, created_at FROM public_links
+		 WHERE user_id = ? AND revoked_at IS NULL
+		   AND (expires_at IS NULL OR expires_at > ?)
+		 ORDER BY created_at DESC`, userID, nowMs,
+	)
+	if err != nil {
+		return nil, fmt.Errorf("get all public links: %w", err)
+	}
+	defer rows.Close()
+	return scanPublicLinks(rows)
+}
+
+func scanPublicLinks(rows *sql.Rows) ([]model.PublicLink, error) {
+	var links []model.PublicLink
+	for rows.Next() {
+		var l model.PublicLink
+		var expiresAt sql.NullInt64
+		var createdAt int64
+		if err := rows.Scan(&l.Slug, &l.NoteID, &expiresAt, &createdAt); err != nil {
+			return nil, fmt.Errorf("scan public link: %w", err)
+		}
+		l.ExpiresAt = fromNullMillis(expiresAt)
+		l.CreatedAt = fromMillis(createdAt)
+		links = append(links, l)
+	}
+	return links, rows.Err()
+}
+
+// RevokePublicLink disables a link. Revoked links are kept so a slug is
+// never handed out twice.
+func (db *DB) RevokePublicLink(slug, userID string, nowMs int64) error {
+	res, err := db.exec(
+		`UPDATE public_links SET revoked_at = ?
+		 WHERE slug = ? AND user_id = ? AND revoked_at IS NULL`,
+		nowMs, slug, userID,
+	)
+	if err != nil {
+		return fmt.Errorf("revoke public link: %w", err)
+	}
+	return checkRowsAffected(res)
+}
+
+// GetPublishedNote returns the note behind a live link: not revoked, not
+// expired at nowMs, and the note itself not deleted.
+func (db *DB) GetPublishedNote(slug string, nowMs int64) (*model.Note, error) {
+	var noteID, userID string
+	err := db.queryRow(
+		`SELECT note_id, user_id FROM public_links
+		 WHERE slug = ? AND revoked_at IS NULL AND (expires_at IS NULL OR expires_at > ?)`,
+		slug, nowMs,
+	).Scan(&noteID, &userID)
+	if errors.Is(err, sql.ErrNoRows) {
+		return nil, ErrNotFound
+	}
+	if err != nil {
+		return nil, fmt.Errorf("get public link: %w", err)
+	}
+	return db.GetNote(noteID, userID)
+}
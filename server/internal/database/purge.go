@@ -0,0 +1,277 @@
+package database
+
+import (
+	"database/sql"
+	"errors"
+	"fmt"
+	"time"
+)
+
+// PurgeNote permanently deletes a note, live or soft-deleted, together with
+// its attachments, public links and tag assignments. Todos attached to it
+// are detached. The note and its attachments are recorded as purged so
+// other devices drop them. It returns the IDs of the removed attachments,
+// so their files can be deleted, and of the detached todos.
+func (db *DB) PurgeNote(id, userID string, now time.Time, deviceID string) (attachmentIDs, detached []string, err error) {
+	err = db.withTx(func(tx *txn) error {
+		var exists int
+		err := tx.QueryRow(`SELECT 1 FROM notes WHERE id = ? AND user_id = ?`, id, userID).Scan(&exists)
+		if errors.Is(err, sql.ErrNoRows) {
+			return ErrNotFound
+		}
+		if err != nil {
+			return fmt.Errorf("purge note: %w", err)
+		}
+
+		attachmentIDs, detached, err = purgeNote(tx, id, toMillis(now), deviceID)
+		if err != nil {
+			return err
+		}
+
+		ms := toMillis(now)
+		if err := recordPurge(tx, "note", id, userID, ms); err != nil {
+			return err
+		}
+		for _, aid := range attachmentIDs {
+			if err := recordPurge(tx, "attachment", aid, userID, ms); err != nil {
+				return err
+			}
+		}
+		return nil
+	})
+	return attachmentIDs, detached, err
+}
+
+// purgeNote hard-deletes a note and everything hanging off it and returns
+// the IDs of its attachments and of the todos it detached.
+func purgeNote(tx *txn, id string, now int64, deviceID string) (attachmentIDs, detached []string, err error) {
+	rows, err := tx.Query(`SELECT id FROM attachments WHERE note_id = ?`, id)
+	if err != nil {
+		return nil, nil, fmt.Errorf("list note attachments: %w", err)
+	}
+	for rows.Next() {
+		var aid string
+		if err := rows.Scan(&aid); err != nil {
+			rows.Close()
+			return nil, nil, fmt.Errorf("scan attachment id: %w", err)
+		}
+		attachmentIDs = append(attachmentIDs, aid)
+	}
+	rows.Close()
+	if err := rows.Err(); err != nil {
+		return nil, nil, err
+	}
+
+	if detached, err = queryIDs(tx, `SELECT id FROM todos WHERE note_id = ?`, id); err != nil {
+		return nil, nil, err
+	}
+	// Detached todos are touched so the change reaches other devices.
+	if _, err := tx.Exec(
+		`UPDATE todos SET note_id = NULL, note_id_modified_at = ?, modified_at = ?, modified_by_device = ?
+		 WHERE note_id = ?`,
+		now, now, deviceID, id,
+	); err != nil {
+		return nil, nil, fmt.Errorf("detach todos: %w", err)
+	}
+
+	for _, stmt := range []string{
+		`DELETE FROM attachments WHERE note_id = ?`,
+		`DELETE FROM public_links WHERE note_id = ?`,
+		`DELETE FROM note_tags WHERE note_id = ?`,
+		`DELETE FROM note_links WHERE note_id = ?`,
+		`DELETE FROM journal_entries WHERE note_id = ?`,
+		`DELETE FROM sync_conflicts WHERE item_type = 'note' AND item_id = ?`,
+		`DELETE FROM reminders_sent WHERE item_type = 'note' AND item_id = ?`,
+		`DELETE FROM notes WHERE id = ?`,
+	} {
+		if _, err := tx.Exec(stmt, id); err != nil {
+			return nil, nil, fmt.Errorf("purge note: %w", err)
+		}
+	}
+	return attachmentIDs, detached, nil
+}
+
+func recordPurge(tx *txn, itemType, id, userID string, now int64) error {
+	_, err := tx.Exec(
+		`INSERT OR REPLACE INTO purged_items (item_type, item_id, user_id, purged_at)
+		 VALUES (?, ?, ?, ?)`,
+		itemType, id, userID, now,
+	)
+	if err != nil {
+		return fmt.Errorf("record purge: %w", err)
+	}
+	return nil
+}
+
+// IsPurged reports whether an item was permanently deleted, so a device
+// that has not heard about it yet cannot bring it back by pushing.
+func (db *DB) IsPurged(itemType, id, userID string) (bool, error) {
+	var n int
+	err := db.queryRow(
+		`SELECT COUNT(*) FROM purged_items WHERE item_type = ? AND item_id = ? AND user_id = ?`,
+		itemType, id, userID,
+	).Scan(&n)
+	if err != nil {
+		return false, fmt.Errorf("check purged: %w", err)
+	}
+	return n > 0, nil
+}
+
+// CompactedBefore returns the time (unix ms) before which the user's
+// tombstones and purge records may have been collected, or 0.
+func (db *DB) CompactedBefore(userID string) (int64, error) {
+	var ms int64
+	err := db.queryRow(
+		`SELECT compacted_before FROM sync_compactions WHERE user_id = ?`, userID,
+	).Scan(&ms)
+	if errors.Is(err, sql.ErrNoRows) {
+		return 0, nil
+	}
+	if err != nil {
+		return 0, fmt.Errorf("get compaction marker: %w", err)
+	}
+	return ms, nil
+}
+
+// ItemRef names one of a user's notes or todos.
+type ItemRef struct {
+	UserID string
+	Type   string
+	ID     string
+}
+
+// GCResult counts what CollectTombstones removed. AttachmentIDs lists
+// attachments whose files must be deleted; Purged the removed notes and
+// todos and Detached the live todos whose note was removed.
+type GCResult struct {
+	Notes         int
+	Todos         int
+	Attachments   int
+	PurgeRecords  int
+	AttachmentIDs []string
+	Purged        []ItemRef
+	Detached      []ItemRef
+}
+
+// CollectTombstones hard-deletes notes, todos and attachments soft-deleted
+// before cutoff, and purge records older than cutoff. Every affected user
+// gets a compaction marker at cutoff so devices that last synced earlier
+// are told to resync in full.
+func (db *DB) CollectTombstones(cutoff, now time.Time) (*GCResult, error) {
+	res := &GCResult{}
+	cut := toMillis(cutoff)
+	err := db.withTx(func(tx *txn) error {
+		if _, err := tx.Exec(
+			`INSERT INTO sync_compactions (user_id, compacted_before)
+			 SELECT user_id, ? FROM (
+			   SELECT user_id FROM notes WHERE deleted_at < ?
+			   UNION SELECT user_id FROM todos WHERE deleted_at < ?
+			   UNION SELECT user_id FROM attachments WHERE deleted_at < ?
+			   UNION SELECT user_id FROM purged_items WHERE purged_at < ?)
+			 WHERE true
+			 ON CONFLICT(user_id) DO UPDATE SET
+			   compacted_before = MAX(compacted_before, excluded.compacted_before)`,
+			cut, cut, cut, cut, cut,
+		); err != nil {
+			return fmt.Errorf("record compaction: %w", err)
+		}
+
+		notes, err := queryRefs(tx, "note", `SELECT user_id, id FROM notes WHERE deleted_at < ?`, cut)
+		if err != nil {
+			return err
+		}
+		for _, n := range notes {
+			ids, detached, err := purgeNote(tx, n.ID, toMillis(now), "")
+			if err != nil {
+				return err
+			}
+			res.AttachmentIDs = append(res.AttachmentIDs, ids...)
+			for _, id := range detached {
+				res.Detached = append(res.Detached, ItemRef{UserID: n.UserID, Type: "todo", ID: id})
+			}
+		}
+		res.Notes = len(notes)
+		res.Purged = notes
+		res.Attachments = len(res.AttachmentIDs)
+
+		// Attachment files are removed at soft delete; only rows remain.
+		n, err := execCount(tx, `DELETE FROM attachments WHERE deleted_at < ?`, cut)
+		if err != nil {
+			return err
+		}
+		res.Attachments += n
+
+		for _, stmt := range []string{
+			`DELETE FROM todo_tags WHERE todo_id IN (SELECT id FROM todos WHERE deleted_at < ?)`,
+			`DELETE FROM sync_conflicts WHERE item_type = 'todo'
+			 AND item_id IN (SELECT id FROM todos WHERE deleted_at < ?)`,
+			`DELETE FROM reminders_sent WHERE item_type = 'todo'
+			 AND item_id IN (SELECT id FROM todos WHERE deleted_at < ?)`,
+		} {
+			if _, err := tx.Exec(stmt, cut); err != nil {
+				return fmt.Errorf("collect todo tombstones: %w", err)
+			}
+		}
+		todos, err := queryRefs(tx, "todo", `SELECT user_id, id FROM todos WHERE deleted_at < ?`, cut)
+		if err != nil {
+			return err
+		}
+		res.Purged = append(res.Purged, todos...)
+		if res.Todos, err = execCount(tx, `DELETE FROM todos WHERE deleted_at < ?`, cut); err != nil {
+			return err
+		}
+		res.PurgeRecords, err = execCount(tx, `DELETE FROM purged_items WHERE purged_at < ?`, cut)
+		return err
+	})
+	if err != nil {
+		return nil, err
+	}
+	return res, nil
+}
+
+func queryIDs(tx *txn, query string, args ...any) ([]string, error) {
+	rows, err := tx.Query(query, args...)
+	if err != nil {
+		return nil, fmt.Errorf("query ids: %w", err)
+	}
+	defer rows.Close()
+
+	var ids []string
+	for rows.Next() {
+		var id string
+		if err := rows.Scan(&id); err != nil {
+			return nil, fmt.Errorf("scan id: %w", err)
+		}
+		ids = append(ids, id)
+	}
+	return ids, rows.Err()
+}
+
+// queryRefs returns the items of type itemType selected by a query for
+// user_id and id.
+func queryRefs(tx *txn, itemType, query string, args ...any) ([]ItemRef, error) {
+	rows, err := tx.Query(query, args...)
+	if err != nil {
+		return nil, fmt.Errorf("query %ss: %w", itemType, err)
+	}
+	defer rows.Close()
+
+	var refs []ItemRef
+	for rows.Next() {
+		r := ItemRef{Type: itemType}
+		if err := rows.Scan(&r.UserID, &r.ID); err != nil {
+			return nil, fmt.Errorf("scan %s: %w", itemType, err)
+		}
+		refs = append(refs, r)
+	}
+	return refs, rows.Err()
+}
+
+func execCount(tx *txn, query string, args ...any) (int, error) {
+	res, err := tx.Exec(query, args...)
+	if err != nil {
+		return 0, fmt.Errorf("collect tombstones: %w", err)
+	}
+	n, err := res.RowsAffected()
+	return int(n), err
+}
@@ -0,0 +1,161 @@
+package database
+
+import (
+	"errors"
+	"fmt"
+	"slices"
+	"strings"
+	"time"
+
+	"github.com/c0dev0id/notesd/server/internal/model"
+)
+
+// QueryGrammar describes the search query syntax accepted by ParseQuery.
+const QueryGrammar = `query  = term { " " term } .
+term   = [ "-" ] [ field ":" ] value .
+field  = "tag" | "type" | "title" | "before" | "after" .
+value  = word | '"' phrase '"' .
+
+A bare value must appear in a note's title or content or a todo's content;
+title: restricts it to note titles and todo content. tag: selects items with
+the tag, type: notes ("note"), todos ("todo") or notes of a type
+("todo_list" or one the server is configured with). before: and after: take a date (YYYY-MM-DD) or RFC 3339 time
+and select items last modified before it or at or after it. All terms must
+match; "-" negates a term other than before: and after:. Matching ignores
+ASCII case. A word with an unknown field, such as "http://x", is a bare
+value.`
+
+// Query is a parsed search query. All of its terms must match.
+type Query []model.SearchTerm
+
+var queryFields = map[string]bool{"tag": true, "type": true, "title": true, "before": true, "after": true}
+
+// ParseQuery parses a search query; see QueryGrammar. noteTypes are the
+// note types type: accepts besides "todo".
+func ParseQuery(s string, noteTypes []string) (Query, error) {
+	var q Query
+	for s = strings.TrimSpace(s); s != ""; s = strings.TrimSpace(s) {
+		var t model.SearchTerm
+		if len(s) > 1 && s[0] == '-' && s[1] != ' ' {
+			t.Negated = true
+			s = s[1:]
+		}
+		if i := strings.IndexAny(s, ": \""); i > 0 && s[i] == ':' && queryFields[strings.ToLower(s[:i])] {
+			t.Field = strings.ToLower(s[:i])
+			s = s[i+1:]
+		}
+		var err error
+		if t.Value, s, err = queryValue(s); err != nil {
+			return nil, err
+		}
+		if t.Value == "" {
+			if t.Field != "" {
+				return nil, fmt.Errorf("%s: needs a value", t.Field)
+			}
+			return nil, errors.New("empty phrase")
+		}
+		switch t.Field {
+		case "before", "after":
+			if t.Negated {
+				return nil, fmt.Errorf("%s: cannot be negated", t.Field)
+			}
+			at, err := time.Parse("2006-01-02", t.Value)
+			if err != nil {
+				if at, err = time.Parse(time.RFC3339, t.Value); err != nil {
+					return nil, fmt.Errorf("%s: %q is not a date (YYYY-MM-DD) or RFC 3339 time", t.Field, t.Value)
+				}
+			}
+			t.Time = &at
+		case "type":
+			t.Value = strings.ToLower(t.Value)
+			if t.Value != "todo" && !slices.Contains(noteTypes, t.Value) {
+				return nil, fmt.Errorf("type: %q is not todo or a note type (%s)", t.Value, strings.Join(noteTypes, ", "))
+			}
+		}
+		q = append(q, t)
+	}
+	if len(q) == 0 {
+		return nil, errors.New("empty query")
+	}
+	return q, nil
+}
+
+// queryValue reads a word or quoted phrase from the start of s and returns
+// it with the rest of s.
+func queryValue(s string) (value, rest string, err error) {
+	if strings.HasPrefix(s, `"`) {
+		end := strings.IndexByte(s[1:], '"')
+		if end < 0 {
+			return "", "", errors.New("unterminated quoted phrase")
+		}
+		return s[1 : end+1], s[end+2:], nil
+	}
+	if end := strings.IndexByte(s, ' '); end >= 0 {
+		return s[:end], s[end:], nil
+	}
+	return s, "", nil
+}
+
+// text returns the value of the first term that is searched for as text,
+// used to rank title matches and place snippets, or "".
+func (q Query) text() string {
+	for _, t := range q {
+		if !t.Negated && (t.Field == "" || t.Field == "title") {
+			return t.Value
+		}
+	}
+	return ""
+}
+
+// where returns the SQL conditions of q on the table of itemType, "note"
+// or "todo" (each with a leading AND), and appends their arguments to args.
+func (q Query) where(itemType string, args *[]any) string {
+	cond := ""
+	for _, t := range q {
+		var c string
+		switch t.Field {
+		case "":
+			*args = append(*args, "%"+t.Value+"%")
+			c = `plaintext(content) LIKE ?`
+			if itemType == "note" {
+				*args = append(*args, "%"+t.Value+"%")
+				c = `(title LIKE ? OR plaintext(content) LIKE ?)`
+			}
+		case "title":
+			*args = append(*args, "%"+t.Value+"%")
+			c = `plaintext(content) LIKE ?`
+			if itemType == "note" {
+				c = `title LIKE ?`
+			}
+		case "tag":
+			*args = append(*args, t.Value)
+			c = `EXISTS (SELECT 1 FROM todo_tags it JOIN tags t ON t.id = it.tag_id
+				WHERE it.todo_id = todos.id AND t.name = ?)`
+			if itemType == "note" {
+				c = `EXISTS (SELECT 1 FROM note_tags it JOIN tags t ON t.id = it.tag_id
+				WHERE it.note_id = notes.id AND t.name = ?)`
+			}
+		case "type":
+			switch {
+			case t.Value == itemType:
+				c = `true`
+			case itemType == "note" && t.Value != "todo":
+				*args = append(*args, t.Value)
+				c = `type = ?`
+			default:
+				c = `false`
+			}
+		case "before":
+			*args = append(*args, toMillis(*t.Time))
+			c = `modified_at < ?`
+		case "after":
+			*args = append(*args, toMillis(*t.Time))
+			c = `modified_at >= ?`
+		}
+		if t.Negated {
+			c = `NOT ` + c
+		}
+		cond += ` AND ` + c
+	}
+	return cond
+}
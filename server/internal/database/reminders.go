@@ -0,0 +1,151 @@
+package database
+
+import (
+	"database/sql"
+	"errors"
+	"fmt"
+	"time"
+
+	"github.com/c0dev0id/notesd/server/internal/model"
+)
+
+// SavePushSubscription stores a device's push subscription, replacing any
+// earlier one for the same device.
+func (db *DB) SavePushSubscription(s *model.PushSubscription) error {
+	_, err := db.exec(
+		`INSERT INTO push_subscriptions (id, user_id, device_id, endpoint, p256dh, auth, created_at)
+		 VALUES (?, ?, ?, ?, ?, ?, ?)
+		 ON CONFLICT(user_id, device_id) DO UPDATE SET
+		   id = excluded.id, endpoint = excluded.endpoint, p256dh = excluded.p256dh,
+		   auth = excluded.auth, created_at = excluded.created_at`,
+		s.ID, s.UserID, s.DeviceID, s.Endpoint, s.Keys.P256dh, s.Keys.Auth, toMillis(s.CreatedAt),
+	)
+	if err != nil {
+		return fmt.Errorf("save push subscription: %w", err)
+	}
+	return nil
+}
+
+func (db *DB) ListPushSubscriptions(userID string) ([]model.PushSubscription, error) {
+	rows, err := db.query(
+		`SELECT id, user_id, device_id, endpoint, p256dh, auth, created_at
+		 FROM push_subscriptions WHERE user_id = ? ORDER BY created_at ASC`, userID,
+	)
+	if err != nil {
+		return nil, fmt.Errorf("list push subscriptions: %w", err)
+	}
+	defer rows.Close()
+
+	var subs []model.PushSubscription
+	for rows.Next() {
+		var s model.PushSubscription
+		var createdAt int64
+		if err := rows.Scan(&s.ID, &s.UserID, &s.DeviceID, &s.Endpoint,
+			&s.Keys.P256dh, &s.Keys.Auth, &createdAt); err != nil {
+			return nil, fmt.Errorf("scan push subscription: %w", err)
+		}
+		s.CreatedAt = fromMillis(createdAt)
+		subs = append(subs, s)
+	}
+	return subs, rows.Err()
+}
+
+func (db *DB) DeletePushSubscription(id, userID string) error {
+	res, err := db.exec(
+		`DELETE FROM push_subscriptions WHERE id = ? AND user_id = ?`, id, userID,
+	)
+	if err != nil {
+		return fmt.Errorf("delete push subscription: %w", err)
+	}
+	return checkRowsAffected(res)
+}
+
+// GetReminderWebhook returns the user's webhook URL, or "" if none is set.
+func (db *DB) GetReminderWebhook(userID string) (string, error) {
+	var url string
+	err := db.queryRow(
+		`SELECT url FROM reminder_webhooks WHERE user_id = ?`, userID,
+	).Scan(&url)
+	if errors.Is(err, sql.ErrNoRows) {
+		return "", nil
+	}
+	if err != nil {
+		return "", fmt.Errorf("get reminder webhook: %w", err)
+	}
+	return url, nil
+}
+
+// SetReminderWebhook stores the user's webhook URL; "" removes it.
+func (db *DB) SetReminderWebhook(userID, url string, now time.Time) error {
+	var err error
+	if url == "" {
+		_, err = db.exec(`DELETE FROM reminder_webhooks WHERE user_id = ?`, userID)
+	} else {
+		_, err = db.exec(
+			`INSERT INTO reminder_webhooks (user_id, url, created_at) VALUES (?, ?, ?)
+			 ON CONFLICT(user_id) DO UPDATE SET url = excluded.url`,
+			userID, url, toMillis(now),
+		)
+	}
+	if err != nil {
+		return fmt.Errorf("set reminder webhook: %w", err)
+	}
+	return nil
+}
+
+// DueReminder is a reminder whose time has come and that was not yet
+// delivered. Type "todo" is a todo's reminder_at, "note" a note whose
+// snooze ended.
+type DueReminder struct {
+	UserID string
+	Type   string
+	ID     string
+	Title  string
+	FireAt time.Time
+}
+
+// ListDueReminders returns undelivered reminders that fell due in
+// (since, now]. Completed and deleted todos and deleted notes are skipped.
+func (db *DB) ListDueReminders(since, now time.Time) ([]DueReminder, error) {
+	rows, err := db.query(
+		`SELECT user_id, 'todo', id, plaintext(content), reminder_at FROM todos
+		 WHERE reminder_at > ? AND reminder_at <= ? AND completed = 0 AND deleted_at IS NULL
+		   AND NOT EXISTS (SELECT 1 FROM reminders_sent s WHERE s.item_type = 'todo'
+		                   AND s.item_id = todos.id AND s.fire_at = todos.reminder_at)
+		 UNION ALL
+		 SELECT user_id, 'note', id, title, snoozed_until FROM notes
+		 WHERE snoozed_until > ? AND snoozed_until <= ? AND deleted_at IS NULL
+		   AND NOT EXISTS (SELECT 1 FROM reminders_sent s WHERE s.item_type = 'note'
+		                   AND s.item_id = notes.id AND s.fire_at = notes.snoozed_until)
+		 ORDER BY 5`,
+		toMillis(since), toMillis(now), toMillis(since), toMillis(now),
+	)
+	if err != nil {
+		return nil, fmt.Errorf("list due reminders: %w", err)
+	}
+	defer rows.Close()
+
+	var out []DueReminder
+	for rows.Next() {
+		var r DueReminder
+		var fireAt int64
+		if err := rows.Scan(&r.UserID, &r.Type, &r.ID, &r.Title, &fireAt); err != nil {
+			return nil, fmt.Errorf("scan due reminder: %w", err)
+		}
+		r.FireAt = fromMillis(fireAt)
+		out = append(out, r)
+	}
+	return out, rows.Err()
+}
+
+func (db *DB) MarkReminderSent(r DueReminder, now time.Time) error {
+	_, err := db.exec(
+		`INSERT OR IGNORE INTO reminders_sent (item_type, item_id, fire_at, sent_at)
+		 VALUES (?, ?, ?, ?)`,
+		r.Type, r.ID, toMillis(r.FireAt), toMillis(now),
+	)
+	if err != nil {
+		return fmt.Errorf("mark reminder sent: %w", err)
+	}
+	return nil
+}
@@ -0,0 +1,124 @@
+package database
+
+import (
+	"database/sql"
+	"fmt"
+	"strings"
+	"unicode/utf8"
+
+	"github.com/c0dev0id/notesd/server/internal/model"
+)
+
+// Search finds the live notes and todos matching q and returns them as one
+// list. Notes whose title contains q's first text term and open todos come
+// first, then other notes, then completed todos; each group most recently
+// modified first. Snoozed notes are left out as in note search.
+func (db *DB) Search(userID string, q Query, limit, offset int) ([]model.SearchResult, int, error) {
+	text := q.text()
+	args := []any{userID, model.NowMillis().UnixMilli()}
+	noteCond := `user_id = ? AND deleted_at IS NULL AND (snoozed_until IS NULL OR snoozed_until <= ?)` +
+		q.where("note", &args)
+	args = append(args, userID)
+	todoCond := `user_id = ? AND deleted_at IS NULL` + q.where("todo", &args)
+
+	var (
+		results []model.SearchResult
+		total   int
+	)
+	err := db.readTx(func(db *DB) error {
+		err := db.queryRow(
+			`SELECT (SELECT COUNT(*) FROM notes WHERE `+noteCond+`)
+			      + (SELECT COUNT(*) FROM todos WHERE `+todoCond+`)`,
+			args...,
+		).Scan(&total)
+		if err != nil {
+			return fmt.Errorf("count search: %w", err)
+		}
+
+		rows, err := db.query(
+			`SELECT type, id, title, content, note_id, completed, due_date, all_day, modified_at FROM (
+			   SELECT 'note' AS type, id, title, plaintext(content) AS content, NULL AS note_id, NULL AS completed,
+			          NULL AS due_date, 0 AS all_day, modified_at,
+			          CASE WHEN title LIKE ? THEN 2 ELSE 1 END AS rank
+			   FROM notes WHERE `+noteCond+`
+			   UNION ALL
+			   SELECT 'todo', id, plaintext(content), plaintext(content), note_id, completed, due_date, all_day, modified_at,
+			          CASE WHEN completed THEN 0 ELSE 2 END
+			   FROM todos WHERE `+todoCond+`)
+			 ORDER BY rank DESC, modified_at DESC, id LIMIT ? OFFSET ?`,
+			append(append([]any{"%" + text + "%"}, args...), limit, offset)...,
+		)
+		if err != nil {
+			return fmt.Errorf("search: %w", err)
+		}
+		defer rows.Close()
+
+		for rows.Next() {
+			var (
+				r          model.SearchResult
+				content    string
+				noteID     sql.NullString
+				completed  sql.NullBool
+				dueDate    sql.NullInt64
+				modifiedAt int64
+			)
+			if err := rows.Scan(&r.Type, &r.ID, &r.Title, &content, &noteID, &completed, &dueDate, &r.AllDay, &modifiedAt); err != nil {
+				return fmt.Errorf("scan search result: %w", err)
+			}
+			r.Snippet = snippet(content, text)
+			if r.Type == "todo" {
+				if noteID.Valid {
+					r.NoteID = &noteID.String
+				}
+				r.Completed = &completed.Bool
+				r.DueDate = fromNullMillis(dueDate)
+			}
+			r.ModifiedAt = fromMillis(modifiedAt)
+			results = append(results, r)
+		}
+		if err := rows.Err(); err != nil {
+			return err
+		}
+		return nil
+	})
+	if err != nil {
+		return nil, 0, err
+	}
+	return results, total, nil
+}
+
+// snippetBefore and snippetAfter bound, in runes, the text kept around a
+// match in a snippet.
+const (
+	snippetBefore = 40
+	snippetAfter  = 100
+)
+
+// snippet returns the text of content around the first occurrence of query,
+// ignoring case, on one line and marked with "…" where it was cut. Without
+// an occurrence, as when only a note's title matched, it starts at the
+// beginning.
+func snippet(content, query string) string {
+	text := strings.Join(strings.Fields(content), " ")
+	start := 0
+	// Lowering can change the byte length of non-ASCII text; then the
+	// index would not point into text and the snippet starts at the top.
+	if lower := strings.ToLower(text); len(lower) == len(text) {
+		if i := strings.Index(lower, strings.ToLower(query)); i > 0 {
+			start = i
+		}
+	}
+
+	runes := utf8.RuneCountInString(text[:start])
+	from := max(runes-snippetBefore, 0)
+	r := []rune(text)
+	to := min(runes+snippetAfter, len(r))
+	s := string(r[from:to])
+	if from > 0 {
+		s = "…" + s
+	}
+	if to < len(r) {
+		s += "…"
+	}
+	return s
+}
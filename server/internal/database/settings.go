@@ -0,0 +1,71 @@
+package database
+
+import (
+	"database/sql"
+	"errors"
+	"fmt"
+
+	"github.com/c0dev0id/notesd/server/internal/model"
+)
+
+// defaultAccountSettings applies to users who never saved settings.
+var defaultAccountSettings = model.AccountSettings{Timezone: "UTC"}
+
+func (db *DB) GetAccountSettings(userID string) (model.AccountSettings, error) {
+	s := defaultAccountSettings
+	err := db.queryRow(
+		`SELECT timezone FROM account_settings WHERE user_id = ?`, userID,
+	).Scan(&s.Timezone)
+	if errors.Is(err, sql.ErrNoRows) {
+		return defaultAccountSettings, nil
+	}
+	if err != nil {
+		return s, fmt.Errorf("get account settings: %w", err)
+	}
+	return s, nil
+}
+
+func (db *DB) SaveAccountSettings(userID string, s model.AccountSettings) error {
+	_, err := db.exec(
+		`INSERT INTO account_settings (user_id, timezone) VALUES (?, ?)
+		 ON CONFLICT(user_id) DO UPDATE SET timezone = excluded.timezone`,
+		userID, s.Timezone,
+	)
+	if err != nil {
+		return fmt.Errorf("save account settings: %w", err)
+	}
+	return nil
+}
+
+// moveTimezones keeps the time zone in account settings only. Digest
+// settings and Telegram chats had their own; the first of them that is not
+// UTC becomes the account's.
+func (db *DB) moveTimezones() error {
+	return db.withTx(func(tx *txn) error {
+		for _, table := range []string{"digest_settings", "telegram_chats", "telegram_link_codes"} {
+			var n int
+			if err := tx.QueryRow(
+				`SELECT COUNT(*) FROM pragma_table_info(?) WHERE name = 'timezone'`, table,
+			).Scan(&n); err != nil {
+				return err
+			}
+			if n == 0 {
+				continue
+			}
+			if table != "telegram_link_codes" {
+				if _, err := tx.Exec(
+					`INSERT INTO account_settings (user_id, timezone)
+					 SELECT user_id, timezone FROM ` + table + ` WHERE timezone != 'UTC'
+					 ON CONFLICT(user_id) DO UPDATE SET timezone = excluded.timezone
+					 WHERE account_settings.timezone = 'UTC'`,
+				); err != nil {
+					return fmt.Errorf("move %s time zones: %w", table, err)
+				}
+			}
+			if _, err := tx.Exec(`ALTER TABLE ` + table + ` DROP COLUMN timezone`); err != nil {
+				return fmt.Errorf("drop %s time zone: %w", table, err)
+			}
+		}
+		return nil
+	})
+}
@@ -0,0 +1,143 @@
+package database
+
+import (
+	"database/sql"
+	"fmt"
+	"slices"
+	"strings"
+	"time"
+
+	"github.com/c0dev0id/notesd/server/internal/model"
+)
+
+// GetStatistics summarises the user's live notes and todos. WordsPerWeek
+// covers the given number of weeks up to the current one, oldest first,
+// and TopTags holds at most topTags tags, most used first.
+func (db *DB) GetStatistics(userID string, weeks, topTags int) (*model.Statistics, error) {
+	now := model.NowMillis()
+	st := &model.Statistics{NotesByType: map[string]int64{}, TopTags: []model.TagUsage{}}
+
+	rows, err := db.query(
+		`SELECT type, COUNT(*) FROM notes WHERE user_id = ? AND deleted_at IS NULL GROUP BY type`,
+		userID,
+	)
+	if err != nil {
+		return nil, fmt.Errorf("count notes: %w", err)
+	}
+	for rows.Next() {
+		var typ string
+		var n int64
+		if err := rows.Scan(&typ, &n); err != nil {
+			rows.Close()
+			return nil, fmt.Errorf("scan note count: %w", err)
+		}
+		st.NotesByType[typ] = n
+	}
+	rows.Close()
+	if err := rows.Err(); err != nil {
+		return nil, fmt.Errorf("count notes: %w", err)
+	}
+
+	err = db.queryRow(
+		`SELECT COALESCE(SUM(completed = 0), 0), COALESCE(SUM(completed = 1), 0),
+		   COALESCE(SUM(completed = 0 AND due_date IS NOT NULL AND due_date < ?), 0)
+		 FROM todos WHERE user_id = ? AND deleted_at IS NULL`,
+		now.UnixMilli(), userID,
+	).Scan(&st.Todos.Open, &st.Todos.Completed, &st.Todos.Overdue)
+	if err != nil {
+		return nil, fmt.Errorf("count todos: %w", err)
+	}
+
+	u, err := db.GetUsage(userID)
+	if err != nil {
+		return nil, err
+	}
+	st.ContentBytes, st.AttachmentBytes = u.ContentBytes, u.AttachmentBytes
+
+	if st.WordsPerWeek, err = db.wordsPerWeek(userID, now, weeks); err != nil {
+		return nil, err
+	}
+
+	tags, err := db.ListTags(userID)
+	if err != nil {
+		return nil, err
+	}
+	for _, t := range tags {
+		if t.Notes+t.Todos > 0 {
+			st.TopTags = append(st.TopTags, t)
+		}
+	}
+	slices.SortStableFunc(st.TopTags, func(a, b model.TagUsage) int {
+		return (b.Notes + b.Todos) - (a.Notes + a.Todos)
+	})
+	if len(st.TopTags) > topTags {
+		st.TopTags = st.TopTags[:topTags]
+	}
+	return st, nil
+}
+
+// wordsPerWeek counts the words of the notes created in each of the last
+// weeks weeks up to now.
+func (db *DB) wordsPerWeek(userID string, now time.Time, weeks int) ([]model.WeekWords, error) {
+	result := make([]model.WeekWords, weeks)
+	if weeks == 0 {
+		return result, nil
+	}
+	day := now.UTC().Truncate(24 * time.Hour)
+	monday := day.AddDate(0, 0, -(int(day.Weekday())+6)%7)
+	start := monday.AddDate(0, 0, -7*(weeks-1))
+	for i := range result {
+		result[i].Week = start.AddDate(0, 0, 7*i).Format(time.DateOnly)
+	}
+
+	rows, err := db.query(
+		`SELECT created_at, title, plaintext(content) FROM notes
+		 WHERE user_id = ? AND deleted_at IS NULL AND created_at >= ?`,
+		userID, start.UnixMilli(),
+	)
+	if err != nil {
+		return nil, fmt.Errorf("words per week: %w", err)
+	}
+	defer rows.Close()
+	for rows.Next() {
+		var created int64
+		var title, content string
+		if err := rows.Scan(&created, &title, &content); err != nil {
+			return nil, fmt.Errorf("scan note words: %w", err)
+		}
+		i := int(time.UnixMilli(created).Sub(start) / (7 * 24 * time.Hour))
+		if i < weeks {
+			result[i].Words += int64(len(strings.Fields(title)) + len(strings.Fields(content)))
+		}
+	}
+	return result, rows.Err()
+}
+
+// EachCompletion calls fn with the completion time and due date of the
+// user's live completed todos, most recently completed first, until fn
+// returns false. Todos completed before completion times were kept count
+// as completed when last modified.
+func (db *DB) EachCompletion(userID string, fn func(completedAt time.Time, due *time.Time) bool) error {
+	rows, err := db.query(
+		`SELECT CASE WHEN completed_modified_at > 0 THEN completed_modified_at ELSE modified_at END AS done,
+		   due_date
+		 FROM todos WHERE user_id = ? AND deleted_at IS NULL AND completed = 1
+		 ORDER BY done DESC`,
+		userID,
+	)
+	if err != nil {
+		return fmt.Errorf("list completions: %w", err)
+	}
+	defer rows.Close()
+	for rows.Next() {
+		var done int64
+		var due sql.NullInt64
+		if err := rows.Scan(&done, &due); err != nil {
+			return fmt.Errorf("scan completion: %w", err)
+		}
+		if !fn(time.UnixMilli(done).UTC(), fromNullMillis(due)) {
+			return nil
+		}
+	}
+	return rows.Err()
+}
@@ -0,0 +1,41 @@
+package database
+
+import (
+	"context"
+	"sync/atomic"
+	"time"
+)
+
+// Stats adds up the statements of one request and the time spent on them,
+// for the slow request log. A transaction counts as a whole, including the
+// wait for the write lock.
+type Stats struct {
+	statements atomic.Int64
+	nanos      atomic.Int64
+}
+
+// Statements returns the number of statements run.
+func (s *Stats) Statements() int64 {
+	return s.statements.Load()
+}
+
+// Duration returns the time spent in the database.
+func (s *Stats) Duration() time.Duration {
+	return time.Duration(s.nanos.Load())
+}
+
+type statsKey struct{}
+
+// WithStats returns a context under which handles from WithContext add
+// their statements to s.
+func WithStats(ctx context.Context, s *Stats) context.Context {
+	return context.WithValue(ctx, statsKey{}, s)
+}
+
+// record adds statements that took d to the Stats in ctx, if any.
+func record(ctx context.Context, statements int64, d time.Duration) {
+	if s, _ := ctx.Value(statsKey{}).(*Stats); s != nil {
+		s.statements.Add(statements)
+		s.nanos.Add(int64(d))
+	}
+}
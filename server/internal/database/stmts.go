@@ -0,0 +1,69 @@
+package database
+
+import (
+	"database/sql"
+	"sync"
+)
+
+// maxCachedStmts bounds the statement cache. Queries built at run time,
+// such as those with an IN list per number of IDs, would otherwise grow
+// it without limit; once it is full, new statements run unprepared.
+const maxCachedStmts = 512
+
+// stmtCache keeps a prepared statement per query text, so that each is
+// parsed once per connection rather than on every call.
+type stmtCache struct {
+	sql   *sql.DB
+	mu    sync.RWMutex
+	stmts map[string]*sql.Stmt
+}
+
+func newStmtCache(sqldb *sql.DB) *stmtCache {
+	return &stmtCache{sql: sqldb, stmts: map[string]*sql.Stmt{}}
+}
+
+// get returns the prepared statement for query, preparing it on first
+// use, or nil if the cache is full or the statement cannot be prepared;
+// the caller then runs the query directly, which reports any error.
+func (c *stmtCache) get(query string) *sql.Stmt {
+	c.mu.RLock()
+	stmt, ok := c.stmts[query]
+	c.mu.RUnlock()
+	if ok {
+		return stmt
+	}
+
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	if stmt, ok := c.stmts[query]; ok {
+		return stmt
+	}
+	if len(c.stmts) >= maxCachedStmts {
+		return nil
+	}
+	stmt, err := c.sql.Prepare(query)
+	if err != nil {
+		return nil
+	}
+	c.stmts[query] = stmt
+	return stmt
+}
+
+func (c *stmtCache) close() {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	for query, stmt := range c.stmts {
+		stmt.Close()
+		delete(c.stmts, query)
+	}
+}
+
+// lockWrites takes the write lock if writes are serialized and returns
+// the function releasing it.
+func (db *DB) lockWrites() func() {
+	if db.writeMu == nil {
+		return func() {}
+	}
+	db.writeMu.Lock()
+	return db.writeMu.Unlock
+}
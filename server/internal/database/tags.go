@@ -0,0 +1,236 @@
+package database
+
+import (
+	"database/sql"
+	"errors"
+	"fmt"
+	"sort"
+	"strings"
+
+	"github.com/c0dev0id/notesd/server/internal/model"
+)
+
+// splitTags decodes the group_concat tag column of noteColumns/todoColumns.
+// It never returns nil so that JSON always carries an array.
+func splitTags(s sql.NullString) []string {
+	if !s.Valid || s.String == "" {
+		return []string{}
+	}
+	tags := strings.Split(s.String, "\x1f")
+	sort.Strings(tags)
+	return tags
+}
+
+// ensureTag returns the ID of the user's tag with the given name (matched
+// case-insensitively), creating it if needed.
+func ensureTag(tx *txn, userID, name string) (string, error) {
+	_, err := tx.Exec(
+		`INSERT INTO tags (id, user_id, name, created_at) VALUES (?, ?, ?, ?)
+		 ON CONFLICT(user_id, name) DO NOTHING`,
+		model.NewID(), userID, name, model.NowMillis().UnixMilli(),
+	)
+	if err != nil {
+		return "", fmt.Errorf("insert tag: %w", err)
+	}
+	var id string
+	err = tx.QueryRow(`SELECT id FROM tags WHERE user_id = ? AND name = ?`, userID, name).Scan(&id)
+	if err != nil {
+		return "", fmt.Errorf("get tag id: %w", err)
+	}
+	return id, nil
+}
+
+// setItemTags replaces the tag links of one note or todo. table is the join
+// table ("note_tags" or "todo_tags") and col its item column.
+func setItemTags(tx *txn, table, col, userID, itemID string, names []string) error {
+	if _, err := tx.Exec(`DELETE FROM `+table+` WHERE `+col+` = ?`, itemID); err != nil {
+		return fmt.Errorf("clear %s: %w", table, err)
+	}
+	for _, name := range names {
+		tagID, err := ensureTag(tx, userID, name)
+		if err != nil {
+			return err
+		}
+		if _, err := tx.Exec(
+			`INSERT OR IGNORE INTO `+table+` (`+col+`, tag_id) VALUES (?, ?)`, itemID, tagID,
+		); err != nil {
+			return fmt.Errorf("link %s: %w", table, err)
+		}
+	}
+	return nil
+}
+
+func setNoteTags(tx *txn, userID, noteID string, names []string) error {
+	return setItemTags(tx, "note_tags", "note_id", userID, noteID, names)
+}
+
+func setTodoTags(tx *txn, userID, todoID string, names []string) error {
+	return setItemTags(tx, "todo_tags", "todo_id", userID, todoID, names)
+}
+
+// ListTags returns all tags of a user with the number of live notes and
+// todos carrying each.
+func (db *DB) ListTags(userID string) ([]model.TagUsage, error) {
+	rows, err := db.query(
+		`SELECT t.name,
+		   (SELECT COUNT(*) FROM note_tags nt JOIN notes n ON n.id = nt.note_id
+		    WHERE nt.tag_id = t.id AND n.deleted_at IS NULL),
+		   (SELECT COUNT(*) FROM todo_tags tt JOIN todos d ON d.id = tt.todo_id
+		    WHERE tt.tag_id = t.id AND d.deleted_at IS NULL)
+		 FROM tags t WHERE t.user_id = ?
+		 ORDER BY t.name`,
+		userID,
+	)
+	if err != nil {
+		return nil, fmt.Errorf("list tags: %w", err)
+	}
+	defer rows.Close()
+
+	var tags []model.TagUsage
+	for rows.Next() {
+		var t model.TagUsage
+		if err := rows.Scan(&t.Name, &t.Notes, &t.Todos); err != nil {
+			return nil, fmt.Errorf("scan tag: %w", err)
+		}
+		tags = append(tags, t)
+	}
+	return tags, rows.Err()
+}
+
+func getTagID(tx *txn, userID, name string) (string, error) {
+	var id string
+	err := tx.QueryRow(`SELECT id FROM tags WHERE user_id = ? AND name = ?`, userID, name).Scan(&id)
+	if errors.Is(err, sql.ErrNoRows) {
+		return "", ErrNotFound
+	}
+	if err != nil {
+		return "", fmt.Errorf("get tag: %w", err)
+	}
+	return id, nil
+}
+
+// Touched lists the notes and todos changed by a tag operation.
+type Touched struct {
+	NoteIDs []string
+	TodoIDs []string
+}
+
+// touchTagged bumps modified_at on every live note and todo carrying the tag,
+// so tag changes propagate to other devices through sync.
+func touchTagged(tx *txn, tagID string, now int64, deviceID string) (Touched, error) {
+	var t Touched
+	var err error
+	if t.NoteIDs, err = queryIDs(tx,
+		`SELECT id FROM notes WHERE deleted_at IS NULL
+		 AND id IN (SELECT note_id FROM note_tags WHERE tag_id = ?)`, tagID); err != nil {
+		return t, err
+	}
+	if t.TodoIDs, err = queryIDs(tx,
+		`SELECT id FROM todos WHERE deleted_at IS NULL
+		 AND id IN (SELECT todo_id FROM todo_tags WHERE tag_id = ?)`, tagID); err != nil {
+		return t, err
+	}
+	if _, err := tx.Exec(
+		`UPDATE notes SET modified_at = ?, modified_by_device = ?
+		 WHERE deleted_at IS NULL AND id IN (SELECT note_id FROM note_tags WHERE tag_id = ?)`,
+		now, deviceID, tagID,
+	); err != nil {
+		return t, fmt.Errorf("touch tagged notes: %w", err)
+	}
+	if _, err := tx.Exec(
+		`UPDATE todos SET modified_at = ?, modified_by_device = ?
+		 WHERE deleted_at IS NULL AND id IN (SELECT todo_id FROM todo_tags WHERE tag_id = ?)`,
+		now, deviceID, tagID,
+	); err != nil {
+		return t, fmt.Errorf("touch tagged todos: %w", err)
+	}
+	return t, nil
+}
+
+// RenameTag renames a tag across all of a user's items. Renaming onto the
+// name of a different existing tag returns ErrConflict; use MergeTag instead.
+// It returns the items it touched.
+func (db *DB) RenameTag(userID, oldName, newName string, now int64, deviceID string) (Touched, error) {
+	var touched Touched
+	err := db.withTx(func(tx *txn) error {
+		id, err := getTagID(tx, userID, oldName)
+		if err != nil {
+			return err
+		}
+		if otherID, err := getTagID(tx, userID, newName); err == nil && otherID != id {
+			return fmt.Errorf("tag %q exists: %w", newName, ErrConflict)
+		} else if err != nil && !errors.Is(err, ErrNotFound) {
+			return err
+		}
+		if _, err := tx.Exec(`UPDATE tags SET name = ? WHERE id = ?`, newName, id); err != nil {
+			return fmt.Errorf("rename tag: %w", err)
+		}
+		touched, err = touchTagged(tx, id, now, deviceID)
+		return err
+	})
+	return touched, err
+}
+
+// MergeTag moves every item tagged src onto dst and removes src. A missing
+// dst is created, which makes the merge equivalent to a rename. It returns
+// the items it touched.
+func (db *DB) MergeTag(userID, src, dst string, now int64, deviceID string) (Touched, error) {
+	var touched Touched
+	err := db.withTx(func(tx *txn) error {
+		srcID, err := getTagID(tx, userID, src)
+		if err != nil {
+			return err
+		}
+		dstID, err := ensureTag(tx, userID, dst)
+		if err != nil {
+			return err
+		}
+		if srcID == dstID {
+			return nil
+		}
+		if touched, err = touchTagged(tx, srcID, now, deviceID); err != nil {
+			return err
+		}
+		for _, table := range []struct{ name, col string }{{"note_tags", "note_id"}, {"todo_tags", "todo_id"}} {
+			if _, err := tx.Exec(
+				`INSERT OR IGNORE INTO `+table.name+` (`+table.col+`, tag_id)
+				 SELECT `+table.col+`, ? FROM `+table.name+` WHERE tag_id = ?`,
+				dstID, srcID,
+			); err != nil {
+				return fmt.Errorf("merge %s: %w", table.name, err)
+			}
+		}
+		return deleteTagByID(tx, srcID)
+	})
+	return touched, err
+}
+
+// DeleteTag removes a tag from all items and deletes it. It returns the
+// items it touched.
+func (db *DB) DeleteTag(userID, name string, now int64, deviceID string) (Touched, error) {
+	var touched Touched
+	err := db.withTx(func(tx *txn) error {
+		id, err := getTagID(tx, userID, name)
+		if err != nil {
+			return err
+		}
+		if touched, err = touchTagged(tx, id, now, deviceID); err != nil {
+			return err
+		}
+		return deleteTagByID(tx, id)
+	})
+	return touched, err
+}
+
+func deleteTagByID(tx *txn, id string) error {
+	for _, q := range []string{
+		`DELETE FROM note_tags WHERE tag_id = ?`,
+		`DELETE FROM todo_tags WHERE tag_id = ?`,
+		`DELETE FROM tags WHERE id = ?`,
+	} {
+		if _, err := tx.Exec(q, id); err != nil {
+			return fmt.Errorf("delete tag: %w", err)
+		}
+	}
+	return nil
+}
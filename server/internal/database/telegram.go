@@ -0,0 +1,147 @@
+package database
+
+import (
+	"database/sql"
+	"errors"
+	"fmt"
+	"time"
+)
+
+// TelegramChat is the Telegram chat linked to a user. Timezone is that of
+// the account settings.
+type TelegramChat struct {
+	UserID        string
+	ChatID        int64
+	Timezone      string
+	OverdueSentOn string
+	LinkedAt      time.Time
+}
+
+// SetTelegramLinkCode stores the hash of a user's one-time link code,
+// replacing any pending one.
+func (db *DB) SetTelegramLinkCode(userID, codeHash string, expiresAt time.Time) error {
+	_, err := db.exec(
+		`INSERT INTO telegram_link_codes (code_hash, user_id, expires_at) VALUES (?, ?, ?)
+		 ON CONFLICT(user_id) DO UPDATE SET
+		   code_hash = excluded.code_hash, expires_at = excluded.expires_at`,
+		codeHash, userID, toMillis(expiresAt),
+	)
+	if err != nil {
+		return fmt.Errorf("set telegram link code: %w", err)
+	}
+	return nil
+}
+
+// LinkTelegramChat uses up the link code with hash codeHash and links
+// chatID to its user, replacing the user's previous chat and unlinking
+// chatID from anyone else. It returns the user, or ErrNotFound if the code
+// is unknown or expired.
+func (db *DB) LinkTelegramChat(codeHash string, chatID int64, now time.Time) (string, error) {
+	var userID string
+	err := db.withTx(func(tx *txn) error {
+		err := tx.QueryRow(
+			`SELECT user_id FROM telegram_link_codes WHERE code_hash = ? AND expires_at > ?`,
+			codeHash, toMillis(now),
+		).Scan(&userID)
+		if errors.Is(err, sql.ErrNoRows) {
+			return ErrNotFound
+		}
+		if err != nil {
+			return fmt.Errorf("get telegram link code: %w", err)
+		}
+		for _, stmt := range []string{
+			`DELETE FROM telegram_link_codes WHERE user_id = ?1`,
+			`DELETE FROM telegram_chats WHERE user_id = ?1 OR chat_id = ?2`,
+		} {
+			if _, err := tx.Exec(stmt, userID, chatID); err != nil {
+				return fmt.Errorf("link telegram chat: %w", err)
+			}
+		}
+		_, err = tx.Exec(
+			`INSERT INTO telegram_chats (user_id, chat_id, linked_at) VALUES (?, ?, ?)`,
+			userID, chatID, toMillis(now),
+		)
+		if err != nil {
+			return fmt.Errorf("link telegram chat: %w", err)
+		}
+		return nil
+	})
+	return userID, err
+}
+
+// telegramChats selects chats with the time zone of their user.
+const telegramChats = `SELECT c.user_id, c.chat_id, COALESCE(s.timezone, 'UTC'), c.overdue_sent_on, c.linked_at
+	FROM telegram_chats c LEFT JOIN account_settings s ON s.user_id = c.user_id`
+
+func scanTelegramChat(row interface{ Scan(...any) error }) (*TelegramChat, error) {
+	var c TelegramChat
+	var linked int64
+	if err := row.Scan(&c.UserID, &c.ChatID, &c.Timezone, &c.OverdueSentOn, &linked); err != nil {
+		return nil, err
+	}
+	c.LinkedAt = fromMillis(linked)
+	return &c, nil
+}
+
+// GetTelegramChat returns the chat linked to userID, or ErrNotFound.
+func (db *DB) GetTelegramChat(userID string) (*TelegramChat, error) {
+	c, err := scanTelegramChat(db.queryRow(
+		telegramChats+` WHERE c.user_id = ?`, userID,
+	))
+	if errors.Is(err, sql.ErrNoRows) {
+		return nil, ErrNotFound
+	}
+	if err != nil {
+		return nil, fmt.Errorf("get telegram chat: %w", err)
+	}
+	return c, nil
+}
+
+// GetTelegramChatUser returns the user linked to chatID, or ErrNotFound.
+func (db *DB) GetTelegramChatUser(chatID int64) (string, error) {
+	var userID string
+	err := db.queryRow(`SELECT user_id FROM telegram_chats WHERE chat_id = ?`, chatID).Scan(&userID)
+	if errors.Is(err, sql.ErrNoRows) {
+		return "", ErrNotFound
+	}
+	if err != nil {
+		return "", fmt.Errorf("get telegram chat user: %w", err)
+	}
+	return userID, nil
+}
+
+// ListTelegramChats returns every linked chat.
+func (db *DB) ListTelegramChats() ([]TelegramChat, error) {
+	rows, err := db.query(telegramChats + ` ORDER BY c.user_id`)
+	if err != nil {
+		return nil, fmt.Errorf("list telegram chats: %w", err)
+	}
+	defer rows.Close()
+	var chats []TelegramChat
+	for rows.Next() {
+		c, err := scanTelegramChat(rows)
+		if err != nil {
+			return nil, fmt.Errorf("scan telegram chat: %w", err)
+		}
+		chats = append(chats, *c)
+	}
+	return chats, rows.Err()
+}
+
+// SetTelegramOverdueSent records the local date of the last overdue notice.
+func (db *DB) SetTelegramOverdueSent(userID, date string) error {
+	_, err := db.exec(`UPDATE telegram_chats SET overdue_sent_on = ? WHERE user_id = ?`, date, userID)
+	if err != nil {
+		return fmt.Errorf("set telegram overdue date: %w", err)
+	}
+	return nil
+}
+
+// DeleteTelegramChat unlinks the user's chat.
+func (db *DB) DeleteTelegramChat(userID string) error {
+	res, err := db.exec(`DELETE FROM telegram_chats WHERE user_id = ?`, userID)
+	if err != nil {
+		return fmt.Errorf("delete telegram chat: %w", err)
+	}
+	return checkRowsAffected(res)
+}
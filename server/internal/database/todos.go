@@ -2,32 +2,58 @@ package database
 
 import (
 	"database/sql"
+	"encoding/json"
 	"errors"
 	"fmt"
+	"sort"
+	"strings"
+	"time"
 
 	"github.com/c0dev0id/notesd/server/internal/model"
 )
 
+// CreateTodo inserts a todo together with its tag links.
 func (db *DB) CreateTodo(t *model.Todo) error {
-	_, err := db.sql.Exec(
-		`INSERT INTO todos (id, user_id, note_id, line_ref, content, due_date, completed,
-		 modified_at, modified_by_device, deleted_at, created_at)
-		 VALUES (?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?)`,
-		t.ID, t.UserID, t.NoteID, t.LineRef, t.Content,
-		toNullMillis(t.DueDate), t.Completed,
+	return db.withTx(func(tx *txn) error {
+		return insertTodo(tx, t)
+	})
+}
+
+// CreateTodos inserts several todos atomically. Used by imports.
+func (db *DB) CreateTodos(todos []*model.Todo) error {
+	return db.withTx(func(tx *txn) error {
+		for _, t := range todos {
+			if err := insertTodo(tx, t); err != nil {
+				return err
+			}
+		}
+		return nil
+	})
+}
+
+func insertTodo(tx *txn, t *model.Todo) error {
+	ft := fieldTimes(t)
+	_, err := tx.Exec(
+		`INSERT INTO todos (id, user_id, note_id, line_ref, content, due_date, all_day, reminder_at,
+		 completed, checklist, modified_at, modified_by_device, deleted_at, created_at,
+		 content_modified_at, due_date_modified_at, completed_modified_at, note_id_modified_at)
+		 VALUES (?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?)`,
+		t.ID, t.UserID, t.NoteID, t.LineRef, tx.key.seal(t.Content),
+		toNullMillis(t.DueDate), t.AllDay, toNullMillis(t.ReminderAt), t.Completed,
+		tx.key.seal(checklistJSON(t.Checklist)),
 		toMillis(t.ModifiedAt), t.ModifiedByDevice,
 		toNullMillis(t.DeletedAt), toMillis(t.CreatedAt),
+		toMillis(ft.Content), toMillis(ft.DueDate), toMillis(ft.Completed), toMillis(ft.NoteID),
 	)
 	if err != nil {
 		return fmt.Errorf("create todo: %w", err)
 	}
-	return nil
+	return setTodoTags(tx, t.UserID, t.ID, t.Tags)
 }
 
 func (db *DB) GetTodo(id, userID string) (*model.Todo, error) {
-	row := db.sql.QueryRow(
-		`SELECT id, user_id, note_id, line_ref, content, due_date, completed,
-		 modified_at, modified_by_device, deleted_at, created_at
+	row := db.queryRow(
+		`SELECT `+todoColumns+`
 		 FROM todos WHERE id = ? AND user_id = ? AND deleted_at IS NULL`, id, userID,
 	)
 	return scanTodo(row)
@@ -35,59 +61,235 @@ func (db *DB) GetTodo(id, userID string) (*model.Todo, error) {
 
 // GetTodoAny returns a todo regardless of soft-delete state. Used by sync.
 func (db *DB) GetTodoAny(id, userID string) (*model.Todo, error) {
-	row := db.sql.QueryRow(
-		`SELECT id, user_id, note_id, line_ref, content, due_date, completed,
-		 modified_at, modified_by_device, deleted_at, created_at
+	row := db.queryRow(
+		`SELECT `+todoColumns+`
 		 FROM todos WHERE id = ? AND user_id = ?`, id, userID,
 	)
 	return scanTodo(row)
 }
 
-func (db *DB) ListTodos(userID string, limit, offset int) ([]model.Todo, int, error) {
-	var total int
-	err := db.sql.QueryRow(
-		`SELECT COUNT(*) FROM todos WHERE user_id = ? AND deleted_at IS NULL`, userID,
-	).Scan(&total)
-	if err != nil {
-		return nil, 0, fmt.Errorf("count todos: %w", err)
+// TodoFilter narrows todo listings. The zero value lists every live todo.
+type TodoFilter struct {
+	// Tag, when set, selects only todos carrying that tag (any case).
+	Tag string
+	// DueAfter and DueBefore, when set, select todos due in
+	// [DueAfter, DueBefore). Todos without a due date are left out.
+	DueAfter  *time.Time
+	DueBefore *time.Time
+	// Completed, when set, selects only completed or only open todos.
+	Completed *bool
+	// NoteID, when set, selects only todos attached to that note.
+	NoteID string
+	// Orphaned selects only todos attached to a note that is deleted or
+	// missing.
+	Orphaned bool
+	// Sort lists sort keys (see ParseTodoSort), most significant first.
+	// Empty keeps the default order.
+	Sort []string
+}
+
+// todoSortColumns maps the sort keys of todo listings to their columns.
+var todoSortColumns = map[string]string{
+	"due":       "due_date",
+	"created":   "created_at",
+	"modified":  "modified_at",
+	"completed": "completed",
+}
+
+// ParseTodoSort splits a comma-separated list of sort keys: due, created,
+// modified and completed (open first), each ascending unless prefixed with
+// "-". Todos without a due date sort last either way.
+func ParseTodoSort(s string) ([]string, error) {
+	if s == "" {
+		return nil, nil
+	}
+	keys := strings.Split(s, ",")
+	for _, k := range keys {
+		if _, ok := todoSortColumns[strings.TrimPrefix(k, "-")]; !ok {
+			return nil, fmt.Errorf("unknown sort key %q", k)
+		}
 	}
+	return keys, nil
+}
 
-	rows, err := db.sql.Query(
-		`SELECT id, user_id, note_id, line_ref, content, due_date, completed,
-		 modified_at, modified_by_device, deleted_at, created_at
-		 FROM todos WHERE user_id = ? AND deleted_at IS NULL
-		 ORDER BY modified_at DESC LIMIT ? OFFSET ?`,
-		userID, limit, offset,
+// where returns the SQL conditions for f (without a leading AND, or empty)
+// and appends their arguments to args.
+func (f TodoFilter) where(args *[]any) string {
+	cond := ""
+	if f.Completed != nil {
+		*args = append(*args, *f.Completed)
+		cond += ` AND completed = ?`
+	}
+	if f.NoteID != "" {
+		*args = append(*args, f.NoteID)
+		cond += ` AND note_id = ?`
+	}
+	if f.Orphaned {
+		cond += ` AND note_id IS NOT NULL AND NOT EXISTS (SELECT 1 FROM notes n
+		WHERE n.id = todos.note_id AND n.user_id = todos.user_id AND n.deleted_at IS NULL)`
+	}
+	if f.Tag != "" {
+		*args = append(*args, f.Tag)
+		cond += ` AND EXISTS (SELECT 1 FROM todo_tags tt JOIN tags t ON t.id = tt.tag_id
+		WHERE tt.todo_id = todos.id AND t.name = ?)`
+	}
+	if f.DueAfter != nil {
+		*args = append(*args, toMillis(*f.DueAfter))
+		cond += ` AND due_date >= ?`
+	}
+	if f.DueBefore != nil {
+		*args = append(*args, toMillis(*f.DueBefore))
+		cond += ` AND due_date < ?`
+	}
+	return cond
+}
+
+// order returns the ORDER BY terms for f: its sort keys if any, else by due
+// date when filtering on it and most recently modified first otherwise.
+func (f TodoFilter) order() string {
+	if len(f.Sort) > 0 {
+		var terms []string
+		for _, k := range f.Sort {
+			dir := " ASC"
+			if strings.HasPrefix(k, "-") {
+				k, dir = k[1:], " DESC"
+			}
+			if k == "due" {
+				terms = append(terms, "due_date IS NULL")
+			}
+			terms = append(terms, todoSortColumns[k]+dir)
+		}
+		return strings.Join(append(terms, "created_at ASC"), ", ")
+	}
+	if f.DueAfter != nil || f.DueBefore != nil {
+		return `due_date ASC, created_at ASC`
+	}
+	return `modified_at DESC`
+}
+
+func (db *DB) ListTodos(userID string, f TodoFilter, limit, offset int) ([]model.Todo, int, error) {
+	args := []any{userID}
+	cond := `user_id = ? AND deleted_at IS NULL` + f.where(&args)
+
+	var (
+		todos []model.Todo
+		total int
 	)
+	err := db.readTx(func(db *DB) error {
+		err := db.queryRow(`SELECT COUNT(*) FROM todos WHERE `+cond, args...).Scan(&total)
+		if err != nil {
+			return fmt.Errorf("count todos: %w", err)
+		}
+
+		rows, err := db.query(
+			`SELECT `+todoColumns+`
+			 FROM todos WHERE `+cond+`
+			 ORDER BY `+f.order()+` LIMIT ? OFFSET ?`,
+			append(args, limit, offset)...,
+		)
+		if err != nil {
+			return fmt.Errorf("list todos: %w", err)
+		}
+		defer rows.Close()
+
+		todos, err = scanTodos(rows)
+		return err
+	})
 	if err != nil {
-		return nil, 0, fmt.Errorf("list todos: %w", err)
+		return nil, 0, err
 	}
-	defer rows.Close()
+	return todos, total, nil
+}
 
-	todos, err := scanTodos(rows)
+// SearchTodos returns the todos whose content contains query, within f.
+func (db *DB) SearchTodos(userID, query string, f TodoFilter, limit, offset int) ([]model.Todo, int, error) {
+	args := []any{userID, "%" + query + "%"}
+	cond := `user_id = ? AND deleted_at IS NULL AND plaintext(content) LIKE ?` + f.where(&args)
+
+	var (
+		todos []model.Todo
+		total int
+	)
+	err := db.readTx(func(db *DB) error {
+		err := db.queryRow(`SELECT COUNT(*) FROM todos WHERE `+cond, args...).Scan(&total)
+		if err != nil {
+			return fmt.Errorf("count todo search: %w", err)
+		}
+
+		rows, err := db.query(
+			`SELECT `+todoColumns+`
+			 FROM todos WHERE `+cond+`
+			 ORDER BY `+f.order()+` LIMIT ? OFFSET ?`,
+			append(args, limit, offset)...,
+		)
+		if err != nil {
+			return fmt.Errorf("search todos: %w", err)
+		}
+		defer rows.Close()
+
+		todos, err = scanTodos(rows)
+		return err
+	})
 	if err != nil {
 		return nil, 0, err
 	}
 	return todos, total, nil
 }
 
+// GetAllTodos returns every non-deleted todo of a user, oldest first.
+func (db *DB) GetAllTodos(userID string) ([]model.Todo, error) {
+	rows, err := db.query(
+		`SELECT `+todoColumns+`
+		 FROM todos WHERE user_id = ? AND deleted_at IS NULL
+		 ORDER BY created_at ASC, rowid ASC`,
+		userID,
+	)
+	if err != nil {
+		return nil, fmt.Errorf("get all todos: %w", err)
+	}
+	defer rows.Close()
+	return scanTodos(rows)
+}
+
+// UpdateTodo writes a todo. Tags are replaced only when t.Tags is non-nil.
+// Fields whose value changes are dated t.ModifiedAt for sync merges.
 func (db *DB) UpdateTodo(t *model.Todo) error {
-	res, err := db.sql.Exec(
-		`UPDATE todos SET note_id = ?, line_ref = ?, content = ?, due_date = ?,
-		 completed = ?, modified_at = ?, modified_by_device = ?
+	return db.withTx(func(tx *txn) error {
+		return updateTodo(tx, t)
+	})
+}
+
+func updateTodo(tx *txn, t *model.Todo) error {
+	now := toMillis(t.ModifiedAt)
+	res, err := tx.Exec(
+		`UPDATE todos SET
+		 content_modified_at = CASE WHEN plaintext(content) IS ? THEN content_modified_at ELSE ? END,
+		 due_date_modified_at = CASE WHEN due_date IS ? AND all_day = ? THEN due_date_modified_at ELSE ? END,
+		 completed_modified_at = CASE WHEN completed IS ? THEN completed_modified_at ELSE ? END,
+		 note_id_modified_at = CASE WHEN note_id IS ? AND line_ref IS ? THEN note_id_modified_at ELSE ? END,
+		 note_id = ?, line_ref = ?, content = ?, due_date = ?, all_day = ?,
+		 reminder_at = ?, completed = ?, checklist = ?, modified_at = ?, modified_by_device = ?
 		 WHERE id = ? AND user_id = ? AND deleted_at IS NULL`,
-		t.NoteID, t.LineRef, t.Content, toNullMillis(t.DueDate),
-		t.Completed, toMillis(t.ModifiedAt), t.ModifiedByDevice,
+		t.Content, now, toNullMillis(t.DueDate), t.AllDay, now, t.Completed, now, t.NoteID, t.LineRef, now,
+		t.NoteID, t.LineRef, tx.key.seal(t.Content), toNullMillis(t.DueDate), t.AllDay,
+		toNullMillis(t.ReminderAt), t.Completed, tx.key.seal(checklistJSON(t.Checklist)),
+		now, t.ModifiedByDevice,
 		t.ID, t.UserID,
 	)
 	if err != nil {
 		return fmt.Errorf("update todo: %w", err)
 	}
-	return checkRowsAffected(res)
+	if err := checkRowsAffected(res); err != nil {
+		return err
+	}
+	if t.Tags == nil {
+		return nil
+	}
+	return setTodoTags(tx, t.UserID, t.ID, t.Tags)
 }
 
 func (db *DB) DeleteTodo(id, userID string, deletedAt int64, deviceID string) error {
-	res, err := db.sql.Exec(
+	res, err := db.exec(
 		`UPDATE todos SET deleted_at = ?, modified_at = ?, modified_by_device = ?
 		 WHERE id = ? AND user_id = ? AND deleted_at IS NULL`,
 		deletedAt, deletedAt, deviceID, id, userID,
@@ -98,16 +300,17 @@ func (db *DB) DeleteTodo(id, userID string, deletedAt int64, deviceID string) er
 	return checkRowsAffected(res)
 }
 
-func (db *DB) GetOverdueTodos(userID string) ([]model.Todo, error) {
-	now := model.NowMillis().UnixMilli()
-	rows, err := db.sql.Query(
-		`SELECT id, user_id, note_id, line_ref, content, due_date, completed,
-		 modified_at, modified_by_device, deleted_at, created_at
+// GetOverdueTodos returns the user's live, incomplete todos that are
+// overdue, oldest first: timed todos due before now and all-day todos due
+// before today, the user's current date at midnight UTC.
+func (db *DB) GetOverdueTodos(userID string, now, today time.Time) ([]model.Todo, error) {
+	rows, err := db.query(
+		`SELECT `+todoColumns+`
 		 FROM todos
 		 WHERE user_id = ? AND deleted_at IS NULL AND completed = 0
-		   AND due_date IS NOT NULL AND due_date < ?
+		   AND due_date IS NOT NULL AND due_date < CASE WHEN all_day THEN ? ELSE ? END
 		 ORDER BY due_date ASC`,
-		userID, now,
+		userID, toMillis(today), toMillis(now),
 	)
 	if err != nil {
 		return nil, fmt.Errorf("get overdue todos: %w", err)
@@ -116,12 +319,68 @@ func (db *DB) GetOverdueTodos(userID string) ([]model.Todo, error) {
 	return scanTodos(rows)
 }
 
+// GetTodosDueBetween returns live todos, completed or not, with a due date in
+// [fromMs, toMs), ordered by due date.
+func (db *DB) GetTodosDueBetween(userID string, fromMs, toMs int64) ([]model.Todo, error) {
+	rows, err := db.query(
+		`SELECT `+todoColumns+`
+		 FROM todos
+		 WHERE user_id = ? AND deleted_at IS NULL
+		   AND due_date >= ? AND due_date < ?
+		 ORDER BY due_date ASC, created_at ASC`,
+		userID, fromMs, toMs,
+	)
+	if err != nil {
+		return nil, fmt.Errorf("get todos due between: %w", err)
+	}
+	defer rows.Close()
+	return scanTodos(rows)
+}
+
+// GetTodosDueOnDays returns live todos, completed or not, due on the days
+// from from to to (exclusive), both midnight UTC: all-day todos by their
+// day and timed todos by their local date in loc. They are ordered by the
+// day, all-day todos first, then by due date.
+func (db *DB) GetTodosDueOnDays(userID string, from, to time.Time, loc *time.Location) ([]model.Todo, error) {
+	localFrom := time.Date(from.Year(), from.Month(), from.Day(), 0, 0, 0, 0, loc)
+	localTo := time.Date(to.Year(), to.Month(), to.Day(), 0, 0, 0, 0, loc)
+	rows, err := db.query(
+		`SELECT `+todoColumns+`
+		 FROM todos
+		 WHERE user_id = ? AND deleted_at IS NULL
+		   AND ((all_day AND due_date >= ? AND due_date < ?)
+		     OR (NOT all_day AND due_date >= ? AND due_date < ?))`,
+		userID, toMillis(from), toMillis(to), toMillis(localFrom), toMillis(localTo),
+	)
+	if err != nil {
+		return nil, fmt.Errorf("get todos due on days: %w", err)
+	}
+	defer rows.Close()
+	todos, err := scanTodos(rows)
+	if err != nil {
+		return nil, err
+	}
+	sort.SliceStable(todos, func(i, j int) bool {
+		a, b := todos[i], todos[j]
+		if da, dbb := a.DueDay(loc), b.DueDay(loc); !da.Equal(dbb) {
+			return da.Before(dbb)
+		}
+		if a.AllDay != b.AllDay {
+			return a.AllDay
+		}
+		if !a.DueDate.Equal(*b.DueDate) {
+			return a.DueDate.Before(*b.DueDate)
+		}
+		return a.CreatedAt.Before(b.CreatedAt)
+	})
+	return todos, nil
+}
+
 // GetTodoChangesSince returns all todos modified after the given timestamp (unix ms),
 // including soft-deleted todos. Used by the sync endpoint.
 func (db *DB) GetTodoChangesSince(userID string, sinceMs int64) ([]model.Todo, error) {
-	rows, err := db.sql.Query(
-		`SELECT id, user_id, note_id, line_ref, content, due_date, completed,
-		 modified_at, modified_by_device, deleted_at, created_at
+	rows, err := db.query(
+		`SELECT `+todoColumns+`
 		 FROM todos WHERE user_id = ? AND modified_at > ?
 		 ORDER BY modified_at ASC`,
 		userID, sinceMs,
@@ -133,79 +392,344 @@ func (db *DB) GetTodoChangesSince(userID string, sinceMs int64) ([]model.Todo, e
 	return scanTodos(rows)
 }
 
-// UpsertTodo inserts or updates a todo using LWW conflict resolution.
-// Returns the server's version if the incoming todo loses the conflict.
-func (db *DB) UpsertTodo(t *model.Todo) (*model.Todo, error) {
+// UpsertTodo stores an incoming version of a todo, merged field by field
+// with the stored one (see mergeTodo). It returns the stored version if it
+// differs from t: with applied false t lost entirely and nothing changed,
+// with applied true t was merged with fields changed later on the server.
+// When applied, prev is the version it replaced, if any.
+// The merge and the write share a transaction, so a concurrent write
+// cannot slip in between.
+func (db *DB) UpsertTodo(t *model.Todo) (stored, prev *model.Todo, applied bool, err error) {
+	err = db.WithTx(db.ctx, func(tx *Tx) error {
+		stored, prev, applied, err = tx.upsertTodo(t)
+		return err
+	})
+	return stored, prev, applied, err
+}
+
+func (db *DB) upsertTodo(t *model.Todo) (stored, prev *model.Todo, applied bool, err error) {
 	existing, err := db.GetTodoAny(t.ID, t.UserID)
 	if errors.Is(err, ErrNotFound) {
-		return nil, db.CreateTodo(t)
+		return nil, nil, true, db.CreateTodo(t)
 	}
 	if err != nil {
-		return nil, err
+		return nil, nil, false, err
 	}
 
-	// LWW: accept if incoming timestamp is newer, or equal with higher device ID
-	if t.ModifiedAt.After(existing.ModifiedAt) ||
-		(t.ModifiedAt.Equal(existing.ModifiedAt) && t.ModifiedByDevice > existing.ModifiedByDevice) {
-		_, err := db.sql.Exec(
-			`UPDATE todos SET note_id = ?, line_ref = ?, content = ?, due_date = ?,
-			 completed = ?, modified_at = ?, modified_by_device = ?, deleted_at = ?
+	m, took, kept := mergeTodo(existing, t)
+	if !took {
+		return existing, nil, false, nil
+	}
+	ft := m.FieldTimes
+	err = db.withTx(func(tx *txn) error {
+		_, err := tx.Exec(
+			`UPDATE todos SET note_id = ?, line_ref = ?, content = ?, due_date = ?, all_day = ?,
+			 reminder_at = ?, completed = ?, checklist = ?, modified_at = ?, modified_by_device = ?,
+			 deleted_at = ?, content_modified_at = ?, due_date_modified_at = ?,
+			 completed_modified_at = ?, note_id_modified_at = ?
 			 WHERE id = ? AND user_id = ?`,
-			t.NoteID, t.LineRef, t.Content, toNullMillis(t.DueDate),
-			t.Completed, toMillis(t.ModifiedAt), t.ModifiedByDevice,
-			toNullMillis(t.DeletedAt),
+			m.NoteID, m.LineRef, tx.key.seal(m.Content), toNullMillis(m.DueDate), m.AllDay,
+			toNullMillis(m.ReminderAt), m.Completed, tx.key.seal(checklistJSON(m.Checklist)),
+			toMillis(m.ModifiedAt), m.ModifiedByDevice,
+			toNullMillis(m.DeletedAt), toMillis(ft.Content), toMillis(ft.DueDate),
+			toMillis(ft.Completed), toMillis(ft.NoteID),
 			t.ID, t.UserID,
 		)
 		if err != nil {
-			return nil, fmt.Errorf("upsert todo: %w", err)
+			return fmt.Errorf("upsert todo: %w", err)
 		}
-		return nil, nil
+		if m.Tags == nil {
+			return nil
+		}
+		return setTodoTags(tx, t.UserID, t.ID, m.Tags)
+	})
+	if err != nil || !kept {
+		return nil, existing, true, err
 	}
+	return &m, existing, true, nil
+}
 
-	return existing, nil
+// mergeTodo merges an incoming version of a todo into the stored one. The
+// content, due date (with its all-day flag), completion and note are each
+// taken from whichever side changed them last; the other fields follow the
+// newer version as a whole, the checklist and tags only if it carries
+// them. Whole versions of equal age go to the higher device ID, as for
+// whole notes. A field changed at the same moment on both sides goes to
+// the greater value: completed over open, the content that sorts last,
+// and the due date and note as dueTieWins and noteTieWins say. The merge
+// of two versions thus comes out the same whichever of them is stored.
+// took reports whether anything of in was used, kept whether the result
+// differs from in.
+func mergeTodo(cur, in *model.Todo) (m model.Todo, took, kept bool) {
+	ct, it := fieldTimes(cur), fieldTimes(in)
+	newer := func(a, b time.Time, tie bool) bool {
+		return a.After(b) || (a.Equal(b) && tie)
+	}
+
+	// Tags and the checklist follow the newer version, and only if it
+	// carries them.
+	if newer(in.ModifiedAt, cur.ModifiedAt, in.ModifiedByDevice > cur.ModifiedByDevice) {
+		took = true
+		m = *in
+		if in.Tags == nil {
+			m.Tags = cur.Tags
+		}
+		if in.Checklist == nil {
+			m.Checklist = cur.Checklist
+		}
+	} else {
+		kept = true
+		m = *cur
+	}
+
+	ft := it
+	if newer(it.Content, ct.Content, in.Content > cur.Content) {
+		m.Content, took = in.Content, true
+	} else {
+		m.Content, ft.Content = cur.Content, ct.Content
+		kept = kept || cur.Content != in.Content || !ct.Content.Equal(it.Content)
+	}
+	if newer(it.DueDate, ct.DueDate, dueTieWins(in, cur)) {
+		m.DueDate, m.AllDay, took = in.DueDate, in.AllDay, true
+	} else {
+		m.DueDate, m.AllDay, ft.DueDate = cur.DueDate, cur.AllDay, ct.DueDate
+		kept = kept || !sameTime(cur.DueDate, in.DueDate) || cur.AllDay != in.AllDay ||
+			!ct.DueDate.Equal(it.DueDate)
+	}
+	if newer(it.Completed, ct.Completed, in.Completed && !cur.Completed) {
+		m.Completed, took = in.Completed, true
+	} else {
+		m.Completed, ft.Completed = cur.Completed, ct.Completed
+		kept = kept || cur.Completed != in.Completed || !ct.Completed.Equal(it.Completed)
+	}
+	if newer(it.NoteID, ct.NoteID, noteTieWins(in, cur)) {
+		m.NoteID, m.LineRef, took = in.NoteID, in.LineRef, true
+	} else {
+		m.NoteID, m.LineRef, ft.NoteID = cur.NoteID, cur.LineRef, ct.NoteID
+		kept = kept || !sameString(cur.NoteID, in.NoteID) || !sameString(cur.LineRef, in.LineRef) ||
+			!ct.NoteID.Equal(it.NoteID)
+	}
+	m.FieldTimes = &ft
+	m.SetChecklist(m.Checklist)
+	return m, took, kept
 }
 
-func scanTodo(row *sql.Row) (*model.Todo, error) {
+// dueTieWins reports whether the due date of a wins over that of b when
+// both changed at the same moment: a date wins over none, a later date
+// over an earlier one, and a due time over an all-day date.
+func dueTieWins(a, b *model.Todo) bool {
+	switch {
+	case a.DueDate == nil || b.DueDate == nil:
+		return b.DueDate == nil && a.DueDate != nil
+	case !a.DueDate.Equal(*b.DueDate):
+		return a.DueDate.After(*b.DueDate)
+	}
+	return !a.AllDay && b.AllDay
+}
+
+// noteTieWins reports whether the note of a wins over that of b when both
+// changed at the same moment: a note wins over none, then the greater note
+// ID and line reference.
+func noteTieWins(a, b *model.Todo) bool {
+	str := func(s *string) string {
+		if s == nil {
+			return ""
+		}
+		return "\x00" + *s
+	}
+	if an, bn := str(a.NoteID), str(b.NoteID); an != bn {
+		return an > bn
+	}
+	return str(a.LineRef) > str(b.LineRef)
+}
+
+// fieldTimes returns the field times of t, dating missing ones ModifiedAt.
+func fieldTimes(t *model.Todo) model.TodoFieldTimes {
+	ft := model.TodoFieldTimes{}
+	if t.FieldTimes != nil {
+		ft = *t.FieldTimes
+	}
+	for _, f := range []*time.Time{&ft.Content, &ft.DueDate, &ft.Completed, &ft.NoteID} {
+		if f.IsZero() {
+			*f = t.ModifiedAt
+		}
+	}
+	return ft
+}
+
+func sameTime(a, b *time.Time) bool {
+	if a == nil || b == nil {
+		return a == b
+	}
+	return a.Equal(*b)
+}
+
+func sameString(a, b *string) bool {
+	if a == nil || b == nil {
+		return a == b
+	}
+	return *a == *b
+}
+
+// todoColumns is the select list matching scanTodoRow.
+const todoColumns = `id, user_id, note_id, line_ref, plaintext(content), due_date, all_day, reminder_at, completed,
+	plaintext(checklist), modified_at, modified_by_device, deleted_at, created_at,
+	content_modified_at, due_date_modified_at, completed_modified_at, note_id_modified_at,
+	(SELECT group_concat(t.name, char(31)) FROM todo_tags tt JOIN tags t ON t.id = tt.tag_id
+	 WHERE tt.todo_id = todos.id)`
+
+func scanTodoRow(s rowScanner) (*model.Todo, error) {
 	var t model.Todo
 	var modifiedAt, createdAt int64
-	var deletedAt, dueDate sql.NullInt64
-	err := row.Scan(
+	var contentAt, dueDateAt, completedAt, noteIDAt int64
+	var deletedAt, dueDate, reminderAt sql.NullInt64
+	var tags sql.NullString
+	var checklist string
+	err := s.Scan(
 		&t.ID, &t.UserID, &t.NoteID, &t.LineRef, &t.Content,
-		&dueDate, &t.Completed,
-		&modifiedAt, &t.ModifiedByDevice, &deletedAt, &createdAt,
+		&dueDate, &t.AllDay, &reminderAt, &t.Completed,
+		&checklist, &modifiedAt, &t.ModifiedByDevice, &deletedAt, &createdAt,
+		&contentAt, &dueDateAt, &completedAt, &noteIDAt, &tags,
 	)
-	if errors.Is(err, sql.ErrNoRows) {
-		return nil, ErrNotFound
-	}
 	if err != nil {
-		return nil, fmt.Errorf("scan todo: %w", err)
+		return nil, err
 	}
 	t.ModifiedAt = fromMillis(modifiedAt)
 	t.DeletedAt = fromNullMillis(deletedAt)
 	t.DueDate = fromNullMillis(dueDate)
+	t.ReminderAt = fromNullMillis(reminderAt)
 	t.CreatedAt = fromMillis(createdAt)
+	t.Tags = splitTags(tags)
+	items := []model.ChecklistItem{}
+	if checklist != "" {
+		if err := json.Unmarshal([]byte(checklist), &items); err != nil {
+			return nil, fmt.Errorf("checklist of todo %s: %w", t.ID, err)
+		}
+	}
+	t.SetChecklist(items)
+	t.FieldTimes = &model.TodoFieldTimes{
+		Content:   fromFieldMillis(contentAt, modifiedAt),
+		DueDate:   fromFieldMillis(dueDateAt, modifiedAt),
+		Completed: fromFieldMillis(completedAt, modifiedAt),
+		NoteID:    fromFieldMillis(noteIDAt, modifiedAt),
+	}
 	return &t, nil
 }
 
+// fromFieldMillis converts a field time, where 0 means the todo's
+// modified_at.
+func fromFieldMillis(ms, modifiedAt int64) time.Time {
+	if ms == 0 {
+		ms = modifiedAt
+	}
+	return fromMillis(ms)
+}
+
+// checklistJSON returns the stored form of a checklist: a JSON array, or
+// an empty string for none.
+func checklistJSON(items []model.ChecklistItem) string {
+	if len(items) == 0 {
+		return ""
+	}
+	// Items hold only strings and booleans, which always marshal.
+	b, _ := json.Marshal(items)
+	return string(b)
+}
+
+func scanTodo(row *sql.Row) (*model.Todo, error) {
+	t, err := scanTodoRow(row)
+	if errors.Is(err, sql.ErrNoRows) {
+		return nil, ErrNotFound
+	}
+	if err != nil {
+		return nil, fmt.Errorf("scan todo: %w", err)
+	}
+	return t, nil
+}
+
 func scanTodos(rows *sql.Rows) ([]model.Todo, error) {
 	var todos []model.Todo
 	for rows.Next() {
-		var t model.Todo
-		var modifiedAt, createdAt int64
-		var deletedAt, dueDate sql.NullInt64
-		err := rows.Scan(
-			&t.ID, &t.UserID, &t.NoteID, &t.LineRef, &t.Content,
-			&dueDate, &t.Completed,
-			&modifiedAt, &t.ModifiedByDevice, &deletedAt, &createdAt,
-		)
+		t, err := scanTodoRow(rows)
 		if err != nil {
 			return nil, fmt.Errorf("scan todo row: %w", err)
 		}
-		t.ModifiedAt = fromMillis(modifiedAt)
-		t.DeletedAt = fromNullMillis(deletedAt)
-		t.DueDate = fromNullMillis(dueDate)
-		t.CreatedAt = fromMillis(createdAt)
-		todos = append(todos, t)
+		todos = append(todos, *t)
 	}
 	return todos, rows.Err()
 }
+
+// addTodoFieldTimes adds the times of the fields merged by sync to todos.
+// They start at 0, which merges as the todo's modified_at. It runs once
+// when a database from before per-field merging is opened.
+func (db *DB) addTodoFieldTimes() error {
+	return db.withTx(func(tx *txn) error {
+		var n int
+		if err := tx.QueryRow(
+			`SELECT COUNT(*) FROM pragma_table_info('todos') WHERE name = 'content_modified_at'`,
+		).Scan(&n); err != nil || n > 0 {
+			return err
+		}
+		for _, col := range []string{"content", "due_date", "completed", "note_id"} {
+			if _, err := tx.Exec(`ALTER TABLE todos ADD COLUMN ` + col + `_modified_at INTEGER NOT NULL DEFAULT 0`); err != nil {
+				return fmt.Errorf("add todo field times: %w", err)
+			}
+		}
+		return nil
+	})
+}
+
+// addTodoAllDay adds the all-day flag to todos. Due dates were days until
+// then, sent at midnight UTC, so those are marked all-day. It runs once
+// when a database from before due times is opened.
+func (db *DB) addTodoAllDay() error {
+	return db.withTx(func(tx *txn) error {
+		var n int
+		if err := tx.QueryRow(
+			`SELECT COUNT(*) FROM pragma_table_info('todos') WHERE name = 'all_day'`,
+		).Scan(&n); err != nil || n > 0 {
+			return err
+		}
+		for _, stmt := range []string{
+			`ALTER TABLE todos ADD COLUMN all_day INTEGER NOT NULL DEFAULT 0`,
+			`UPDATE todos SET all_day = 1 WHERE due_date % 86400000 = 0`,
+		} {
+			if _, err := tx.Exec(stmt); err != nil {
+				return fmt.Errorf("add todo all-day flag: %w", err)
+			}
+		}
+		return nil
+	})
+}
+
+// addTodoChecklist adds the checklist column to todos. It runs once when a
+// database from before checklists is opened.
+func (db *DB) addTodoChecklist() error {
+	return db.withTx(func(tx *txn) error {
+		var n int
+		if err := tx.QueryRow(
+			`SELECT COUNT(*) FROM pragma_table_info('todos') WHERE name = 'checklist'`,
+		).Scan(&n); err != nil || n > 0 {
+			return err
+		}
+		if _, err := tx.Exec(`ALTER TABLE todos ADD COLUMN checklist TEXT NOT NULL DEFAULT ''`); err != nil {
+			return fmt.Errorf("add todo checklist: %w", err)
+		}
+		return nil
+	})
+}
+
+// addTodoReminders adds the reminder_at column to a todos table from
+// before reminders.
+func (db *DB) addTodoReminders() error {
+	var n int
+	if err := db.queryRow(
+		`SELECT COUNT(*) FROM pragma_table_info('todos') WHERE name = 'reminder_at'`,
+	).Scan(&n); err != nil || n > 0 {
+		return err
+	}
+	if _, err := db.exec(`ALTER TABLE todos ADD COLUMN reminder_at INTEGER`); err != nil {
+		return fmt.Errorf("add todo reminders: %w", err)
+	}
+	return nil
+}
@@ -6,6 +6,7 @@ import (
 	"encoding/hex"
 	"errors"
 	"fmt"
+	"time"
 
 	"github.com/c0dev0id/notesd/server/internal/model"
 )
@@ -16,11 +17,16 @@ func HashToken(token string) string {
 	return hex.EncodeToString(h[:])
 }
 
+// CreateRefreshToken stores rt. A token without a FamilyID starts a new
+// family of its own.
 func (db *DB) CreateRefreshToken(rt *model.RefreshToken) error {
-	_, err := db.sql.Exec(
-		`INSERT INTO refresh_tokens (id, user_id, device_id, token_hash, expires_at, created_at)
-		 VALUES (?, ?, ?, ?, ?, ?)`,
-		rt.ID, rt.UserID, rt.DeviceID, rt.TokenHash,
+	if rt.FamilyID == "" {
+		rt.FamilyID = rt.ID
+	}
+	_, err := db.exec(
+		`INSERT INTO refresh_tokens (id, user_id, device_id, token_hash, family_id, parent_id, expires_at, created_at)
+		 VALUES (?, ?, ?, ?, ?, ?, ?, ?)`,
+		rt.ID, rt.UserID, rt.DeviceID, rt.TokenHash, rt.FamilyID, sql.NullString{String: rt.ParentID, Valid: rt.ParentID != ""},
 		toMillis(rt.ExpiresAt), toMillis(rt.CreatedAt),
 	)
 	if err != nil {
@@ -31,32 +37,85 @@ func (db *DB) CreateRefreshToken(rt *model.RefreshToken) error {
 
 func (db *DB) GetRefreshTokenByHash(tokenHash string) (*model.RefreshToken, error) {
 	var rt model.RefreshToken
+	var parentID sql.NullString
+	var rotatedAt sql.NullInt64
 	var expiresAt, createdAt int64
-	err := db.sql.QueryRow(
-		`SELECT id, user_id, device_id, token_hash, expires_at, created_at
+	err := db.queryRow(
+		`SELECT id, user_id, device_id, token_hash, family_id, parent_id, rotated_at, expires_at, created_at
 		 FROM refresh_tokens WHERE token_hash = ?`, tokenHash,
-	).Scan(&rt.ID, &rt.UserID, &rt.DeviceID, &rt.TokenHash, &expiresAt, &createdAt)
+	).Scan(&rt.ID, &rt.UserID, &rt.DeviceID, &rt.TokenHash, &rt.FamilyID, &parentID, &rotatedAt, &expiresAt, &createdAt)
 	if errors.Is(err, sql.ErrNoRows) {
 		return nil, ErrNotFound
 	}
 	if err != nil {
 		return nil, fmt.Errorf("get refresh token: %w", err)
 	}
+	rt.ParentID = parentID.String
+	rt.RotatedAt = fromNullMillis(rotatedAt)
 	rt.ExpiresAt = fromMillis(expiresAt)
 	rt.CreatedAt = fromMillis(createdAt)
 	return &rt, nil
 }
 
 func (db *DB) DeleteRefreshToken(id string) error {
-	_, err := db.sql.Exec(`DELETE FROM refresh_tokens WHERE id = ?`, id)
+	_, err := db.exec(`DELETE FROM refresh_tokens WHERE id = ?`, id)
 	if err != nil {
 		return fmt.Errorf("delete refresh token: %w", err)
 	}
 	return nil
 }
 
+// RotateRefreshToken marks the token as used up by a refresh. It returns
+// ErrNotFound when the token is gone or was already rotated, so of two
+// concurrent refreshes with the same token only one succeeds.
+func (db *DB) RotateRefreshToken(id string) error {
+	res, err := db.exec(
+		`UPDATE refresh_tokens SET rotated_at = ? WHERE id = ? AND rotated_at IS NULL`,
+		model.NowMillis().UnixMilli(), id,
+	)
+	if err != nil {
+		return fmt.Errorf("rotate refresh token: %w", err)
+	}
+	return checkRowsAffected(res)
+}
+
+// DeleteRefreshTokenFamily revokes every token descended from the same
+// login and returns how many were removed.
+func (db *DB) DeleteRefreshTokenFamily(familyID string) (int64, error) {
+	res, err := db.exec(`DELETE FROM refresh_tokens WHERE family_id = ?`, familyID)
+	if err != nil {
+		return 0, fmt.Errorf("delete refresh token family: %w", err)
+	}
+	return res.RowsAffected()
+}
+
+// addRefreshTokenFamilies adds the family columns to a refresh_tokens
+// table from before version 13, making each existing token the root of
+// its own family. A table that already has them is left alone.
+func (db *DB) addRefreshTokenFamilies() error {
+	return db.withTx(func(tx *txn) error {
+		var n int
+		if err := tx.QueryRow(
+			`SELECT COUNT(*) FROM pragma_table_info('refresh_tokens') WHERE name = 'family_id'`,
+		).Scan(&n); err != nil || n > 0 {
+			return err
+		}
+		for _, stmt := range []string{
+			`ALTER TABLE refresh_tokens ADD COLUMN family_id TEXT NOT NULL DEFAULT ''`,
+			`ALTER TABLE refresh_tokens ADD COLUMN parent_id TEXT`,
+			`ALTER TABLE refresh_tokens ADD COLUMN rotated_at INTEGER`,
+			`UPDATE refresh_tokens SET family_id = id`,
+		} {
+			if _, err := tx.Exec(stmt); err != nil {
+				return fmt.Errorf("add refresh token families: %w", err)
+			}
+		}
+		return nil
+	})
+}
+
 func (db *DB) DeleteRefreshTokensByUser(userID string) error {
-	_, err := db.sql.Exec(`DELETE FROM refresh_tokens WHERE user_id = ?`, userID)
+	_, err := db.exec(`DELETE FROM refresh_tokens WHERE user_id = ?`, userID)
 	if err != nil {
 		return fmt.Errorf("delete user refresh tokens: %w", err)
 	}
@@ -65,9 +124,64 @@ func (db *DB) DeleteRefreshTokensByUser(userID string) error {
 
 func (db *DB) DeleteExpiredRefreshTokens() (int64, error) {
 	now := model.NowMillis().UnixMilli()
-	res, err := db.sql.Exec(`DELETE FROM refresh_tokens WHERE expires_at < ?`, now)
+	res, err := db.exec(`DELETE FROM refresh_tokens WHERE expires_at < ?`, now)
 	if err != nil {
 		return 0, fmt.Errorf("delete expired tokens: %w", err)
 	}
 	return res.RowsAffected()
 }
+
+// CreatePasswordReset stores a reset token for userID, replacing any
+// earlier one, so only the most recently mailed link works.
+func (db *DB) CreatePasswordReset(userID, tokenHash string, expiresAt time.Time) error {
+	return db.withTx(func(tx *txn) error {
+		if _, err := tx.Exec(`DELETE FROM password_resets WHERE user_id = ?`, userID); err != nil {
+			return fmt.Errorf("delete old password resets: %w", err)
+		}
+		if _, err := tx.Exec(
+			`INSERT INTO password_resets (token_hash, user_id, expires_at, created_at)
+			 VALUES (?, ?, ?, ?)`,
+			tokenHash, userID, toMillis(expiresAt), model.NowMillis().UnixMilli(),
+		); err != nil {
+			return fmt.Errorf("create password reset: %w", err)
+		}
+		return nil
+	})
+}
+
+// ResetPassword consumes the reset token with tokenHash, stores the new
+// password hash and revokes all of the user's refresh and personal access
+// tokens and any other reset tokens. It returns the user's ID, or
+// ErrNotFound when the token is unknown or expired.
+func (db *DB) ResetPassword(tokenHash, passwordHash string) (string, error) {
+	var userID string
+	err := db.withTx(func(tx *txn) error {
+		err := tx.QueryRow(
+			`DELETE FROM password_resets WHERE token_hash = ? AND expires_at >= ?
+			 RETURNING user_id`, tokenHash, model.NowMillis().UnixMilli(),
+		).Scan(&userID)
+		if errors.Is(err, sql.ErrNoRows) {
+			return ErrNotFound
+		}
+		if err != nil {
+			return fmt.Errorf("consume password reset: %w", err)
+		}
+		if _, err := tx.Exec(`DELETE FROM password_resets WHERE user_id = ?`, userID); err != nil {
+			return fmt.Errorf("delete password resets: %w", err)
+		}
+		if _, err := tx.Exec(`UPDATE users SET password_hash = ? WHERE id = ?`, passwordHash, userID); err != nil {
+			return fmt.Errorf("update password: %w", err)
+		}
+		if _, err := tx.Exec(`DELETE FROM refresh_tokens WHERE user_id = ?`, userID); err != nil {
+			return fmt.Errorf("revoke refresh tokens: %w", err)
+		}
+		if _, err := tx.Exec(`DELETE FROM access_tokens WHERE user_id = ?`, userID); err != nil {
+			return fmt.Errorf("revoke access tokens: %w", err)
+		}
+		return nil
+	})
+	if err != nil {
+		return "", err
+	}
+	return userID, nil
+}
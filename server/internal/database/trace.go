@@ -0,0 +1,149 @@
+package database
+
+import (
+	"context"
+	"database/sql"
+	"strings"
+	"time"
+
+	"github.com/c0dev0id/notesd/server/internal/trace"
+)
+
+// WithContext returns a handle whose statements are traced as children of
+// the span in ctx. Statements are not cancelled with ctx: a sync push that
+// has started writing finishes even if the client goes away.
+func (db *DB) WithContext(ctx context.Context) *DB {
+	c := *db
+	c.ctx = ctx
+	return &c
+}
+
+// txn is a transaction whose statements are traced under the span opened by
+// withTx.
+type txn struct {
+	tx    *sql.Tx
+	ctx   context.Context
+	stmts *stmtCache
+	key   *contentKey
+}
+
+func (t *txn) Exec(query string, args ...any) (sql.Result, error) {
+	span := startSQLSpan(t.ctx, query)
+	var res sql.Result
+	var err error
+	if stmt := t.stmts.get(query); stmt != nil {
+		res, err = t.tx.Stmt(stmt).Exec(args...)
+	} else {
+		res, err = t.tx.Exec(query, args...)
+	}
+	record(t.ctx, 1, 0)
+	span.SetError(err)
+	span.End()
+	return res, err
+}
+
+func (t *txn) Query(query string, args ...any) (*sql.Rows, error) {
+	span := startSQLSpan(t.ctx, query)
+	var rows *sql.Rows
+	var err error
+	if stmt := t.stmts.get(query); stmt != nil {
+		rows, err = t.tx.Stmt(stmt).Query(args...)
+	} else {
+		rows, err = t.tx.Query(query, args...)
+	}
+	record(t.ctx, 1, 0)
+	span.SetError(err)
+	span.End()
+	return rows, err
+}
+
+func (t *txn) QueryRow(query string, args ...any) *sql.Row {
+	span := startSQLSpan(t.ctx, query)
+	var row *sql.Row
+	if stmt := t.stmts.get(query); stmt != nil {
+		row = t.tx.Stmt(stmt).QueryRow(args...)
+	} else {
+		row = t.tx.QueryRow(query, args...)
+	}
+	record(t.ctx, 1, 0)
+	span.SetError(row.Err())
+	span.End()
+	return row
+}
+
+// exec runs a statement that writes, so it waits for the write lock. The
+// statements of a Tx run in its transaction, which holds the lock already.
+func (db *DB) exec(query string, args ...any) (sql.Result, error) {
+	if db.tx != nil {
+		return db.tx.Exec(query, args...)
+	}
+	start := time.Now()
+	unlock := db.lockWrites()
+	defer unlock()
+	span := startSQLSpan(db.ctx, query)
+	var res sql.Result
+	var err error
+	if stmt := db.stmts.get(query); stmt != nil {
+		res, err = stmt.Exec(args...)
+	} else {
+		res, err = db.sql.Exec(query, args...)
+	}
+	record(db.ctx, 1, time.Since(start))
+	span.SetError(err)
+	span.End()
+	return res, err
+}
+
+// query times the statement up to its first result; the caller's row loop
+// is not part of the span.
+func (db *DB) query(query string, args ...any) (*sql.Rows, error) {
+	if db.tx != nil {
+		return db.tx.Query(query, args...)
+	}
+	start := time.Now()
+	span := startSQLSpan(db.ctx, query)
+	var rows *sql.Rows
+	var err error
+	if stmt := db.stmts.get(query); stmt != nil {
+		rows, err = stmt.Query(args...)
+	} else {
+		rows, err = db.sql.Query(query, args...)
+	}
+	record(db.ctx, 1, time.Since(start))
+	span.SetError(err)
+	span.End()
+	return rows, err
+}
+
+func (db *DB) queryRow(query string, args ...any) *sql.Row {
+	if db.tx != nil {
+		return db.tx.QueryRow(query, args...)
+	}
+	start := time.Now()
+	span := startSQLSpan(db.ctx, query)
+	var row *sql.Row
+	if stmt := db.stmts.get(query); stmt != nil {
+		row = stmt.QueryRow(args...)
+	} else {
+		row = db.sql.QueryRow(query, args...)
+	}
+	record(db.ctx, 1, time.Since(start))
+	span.SetError(row.Err())
+	span.End()
+	return row
+}
+
+// startSQLSpan opens a span named after the statement's verb (SELECT,
+// INSERT, ...) carrying the statement text. Arguments are never recorded.
+func startSQLSpan(ctx context.Context, query string) *trace.Span {
+	_, span := trace.StartSpan(ctx, "SQL", trace.KindClient)
+	if span != nil {
+		words := strings.Fields(query)
+		if len(words) > 0 {
+			span.SetName(strings.ToUpper(words[0]))
+		}
+		span.SetAttr("db.system.name", "sqlite")
+		span.SetAttr("db.query.text", strings.Join(words, " "))
+	}
+	return span
+}
@@ -0,0 +1,28 @@
+package database
+
+import (
+	"fmt"
+
+	"github.com/c0dev0id/notesd/server/internal/model"
+)
+
+// GetUsage counts the user's live notes, todos and attachments and the
+// bytes they hold.
+func (db *DB) GetUsage(userID string) (*model.Usage, error) {
+	var u model.Usage
+	err := db.queryRow(
+		`SELECT
+		   (SELECT COUNT(*) FROM notes WHERE user_id = ? AND deleted_at IS NULL),
+		   (SELECT COUNT(*) FROM todos WHERE user_id = ? AND deleted_at IS NULL),
+		   (SELECT COALESCE(SUM(length(CAST(title AS BLOB)) + length(CAST(plaintext(content) AS BLOB))), 0)
+		      FROM notes WHERE user_id = ? AND deleted_at IS NULL)
+		   + (SELECT COALESCE(SUM(length(CAST(plaintext(content) AS BLOB))), 0)
+		      FROM todos WHERE user_id = ? AND deleted_at IS NULL),
+		   (SELECT COALESCE(SUM(size), 0) FROM attachments WHERE user_id = ? AND deleted_at IS NULL)`,
+		userID, userID, userID, userID, userID,
+	).Scan(&u.Notes, &u.Todos, &u.ContentBytes, &u.AttachmentBytes)
+	if err != nil {
+		return nil, fmt.Errorf("get usage: %w", err)
+	}
+	return &u, nil
+}
@@ -12,7 +12,7 @@ var ErrNotFound = errors.New("not found")
 var ErrConflict = errors.New("conflict")
 
 func (db *DB) CreateUser(u *model.User) error {
-	_, err := db.sql.Exec(
+	_, err := db.exec(
 		`INSERT INTO users (id, email, password_hash, display_name, created_at)
 		 VALUES (?, ?, ?, ?, ?)`,
 		u.ID, u.Email, u.PasswordHash, u.DisplayName, toMillis(u.CreatedAt),
@@ -28,7 +28,7 @@ func (db *DB) CreateUser(u *model.User) error {
 }
 
 func (db *DB) GetUserByID(id string) (*model.User, error) {
-	row := db.sql.QueryRow(
+	row := db.queryRow(
 		`SELECT id, email, password_hash, display_name, created_at
 		 FROM users WHERE id = ?`, id,
 	)
@@ -36,7 +36,7 @@ func (db *DB) GetUserByID(id string) (*model.User, error) {
 }
 
 func (db *DB) GetUserByEmail(email string) (*model.User, error) {
-	row := db.sql.QueryRow(
+	row := db.queryRow(
 		`SELECT id, email, password_hash, display_name, created_at
 		 FROM users WHERE email = ?`, email,
 	)
@@ -79,3 +79,104 @@ func searchString(s, substr string) bool {
 	}
 	return false
 }
+
+// ChangePassword stores a new password hash and revokes the user's refresh
+// tokens on every device except keepDeviceID and all personal access
+// tokens, in one transaction.
+func (db *DB) ChangePassword(userID, passwordHash, keepDeviceID string) error {
+	return db.withTx(func(tx *txn) error {
+		res, err := tx.Exec(`UPDATE users SET password_hash = ? WHERE id = ?`, passwordHash, userID)
+		if err != nil {
+			return fmt.Errorf("update password: %w", err)
+		}
+		if n, _ := res.RowsAffected(); n == 0 {
+			return ErrNotFound
+		}
+		if _, err := tx.Exec(
+			`DELETE FROM refresh_tokens WHERE user_id = ? AND device_id != ?`,
+			userID, keepDeviceID,
+		); err != nil {
+			return fmt.Errorf("revoke refresh tokens: %w", err)
+		}
+		if _, err := tx.Exec(`DELETE FROM access_tokens WHERE user_id = ?`, userID); err != nil {
+			return fmt.Errorf("revoke access tokens: %w", err)
+		}
+		return nil
+	})
+}
+
+// DeleteAccount permanently removes the user and everything they own:
+// notes, todos, attachments, tags, tokens, settings and statistics, and
+// the invites they created. It returns the IDs of the removed attachments
+// so their files can be deleted.
+func (db *DB) DeleteAccount(userID string) ([]string, error) {
+	var attachmentIDs []string
+	err := db.withTx(func(tx *txn) error {
+		rows, err := tx.Query(`SELECT id FROM attachments WHERE user_id = ?`, userID)
+		if err != nil {
+			return fmt.Errorf("list attachments: %w", err)
+		}
+		for rows.Next() {
+			var id string
+			if err := rows.Scan(&id); err != nil {
+				rows.Close()
+				return fmt.Errorf("scan attachment id: %w", err)
+			}
+			attachmentIDs = append(attachmentIDs, id)
+		}
+		rows.Close()
+		if err := rows.Err(); err != nil {
+			return err
+		}
+
+		// Children before parents, for the foreign keys.
+		for _, stmt := range []string{
+			`DELETE FROM reminders_sent WHERE
+			   (item_type = 'note' AND item_id IN (SELECT id FROM notes WHERE user_id = ?1))
+			   OR (item_type = 'todo' AND item_id IN (SELECT id FROM todos WHERE user_id = ?1))`,
+			`DELETE FROM journal_entries WHERE user_id = ?1`,
+			`DELETE FROM journal_settings WHERE user_id = ?1`,
+			`DELETE FROM note_tags WHERE note_id IN (SELECT id FROM notes WHERE user_id = ?1)`,
+			`DELETE FROM note_links WHERE note_id IN (SELECT id FROM notes WHERE user_id = ?1)`,
+			`DELETE FROM todo_tags WHERE todo_id IN (SELECT id FROM todos WHERE user_id = ?1)`,
+			`DELETE FROM attachments WHERE user_id = ?1`,
+			`DELETE FROM public_links WHERE user_id = ?1`,
+			`DELETE FROM todos WHERE user_id = ?1`,
+			`DELETE FROM notes WHERE user_id = ?1`,
+			`DELETE FROM tags WHERE user_id = ?1`,
+			`DELETE FROM refresh_tokens WHERE user_id = ?1`,
+			`DELETE FROM password_resets WHERE user_id = ?1`,
+			`DELETE FROM access_tokens WHERE user_id = ?1`,
+			`DELETE FROM invites WHERE created_by = ?1`,
+			`DELETE FROM push_subscriptions WHERE user_id = ?1`,
+			`DELETE FROM reminder_webhooks WHERE user_id = ?1`,
+			`DELETE FROM sync_conflicts WHERE user_id = ?1`,
+			`DELETE FROM purged_items WHERE user_id = ?1`,
+			`DELETE FROM sync_compactions WHERE user_id = ?1`,
+			`DELETE FROM sync_stats WHERE user_id = ?1`,
+			`DELETE FROM digest_settings WHERE user_id = ?1`,
+			`DELETE FROM account_settings WHERE user_id = ?1`,
+			`DELETE FROM calendar_feeds WHERE user_id = ?1`,
+			`DELETE FROM note_feeds WHERE user_id = ?1`,
+			`DELETE FROM mail_in_addresses WHERE user_id = ?1`,
+			`DELETE FROM telegram_chats WHERE user_id = ?1`,
+			`DELETE FROM telegram_link_codes WHERE user_id = ?1`,
+			`DELETE FROM webhook_deliveries WHERE webhook_id IN (SELECT id FROM webhooks WHERE user_id = ?1)`,
+			`DELETE FROM webhooks WHERE user_id = ?1`,
+			`DELETE FROM audit_log WHERE user_id = ?1`,
+		} {
+			if _, err := tx.Exec(stmt, userID); err != nil {
+				return fmt.Errorf("delete account data: %w", err)
+			}
+		}
+		res, err := tx.Exec(`DELETE FROM users WHERE id = ?`, userID)
+		if err != nil {
+			return fmt.Errorf("delete user: %w", err)
+		}
+		return checkRowsAffected(res)
+	})
+	if err != nil {
+		return nil, err
+	}
+	return attachmentIDs, nil
+}
@@ -0,0 +1,237 @@
+package database
+
+import (
+	"database/sql"
+	"errors"
+	"fmt"
+	"slices"
+	"strings"
+	"time"
+
+	"github.com/c0dev0id/notesd/server/internal/model"
+)
+
+// Webhook delivery states.
+const (
+	DeliveryPending   = "pending"
+	DeliveryDelivered = "delivered"
+	DeliveryFailed    = "failed"
+)
+
+func (db *DB) CreateWebhook(userID string, h *model.Webhook) error {
+	_, err := db.exec(
+		`INSERT INTO webhooks (id, user_id, url, events, secret, created_at)
+		 VALUES (?, ?, ?, ?, ?, ?)`,
+		h.ID, userID, h.URL, strings.Join(h.Events, ","), h.Secret, toMillis(h.CreatedAt),
+	)
+	if err != nil {
+		return fmt.Errorf("create webhook: %w", err)
+	}
+	return nil
+}
+
+// ListWebhooks returns the user's webhooks, oldest first, without their
+// secrets.
+func (db *DB) ListWebhooks(userID string) ([]model.Webhook, error) {
+	rows, err := db.query(
+		`SELECT id, url, events, created_at FROM webhooks
+		 WHERE user_id = ? ORDER BY created_at ASC, rowid ASC`, userID,
+	)
+	if err != nil {
+		return nil, fmt.Errorf("list webhooks: %w", err)
+	}
+	defer rows.Close()
+
+	hooks := []model.Webhook{}
+	for rows.Next() {
+		var h model.Webhook
+		var events string
+		var createdAt int64
+		if err := rows.Scan(&h.ID, &h.URL, &events, &createdAt); err != nil {
+			return nil, fmt.Errorf("scan webhook: %w", err)
+		}
+		h.Events = splitEvents(events)
+		h.CreatedAt = fromMillis(createdAt)
+		hooks = append(hooks, h)
+	}
+	return hooks, rows.Err()
+}
+
+func splitEvents(s string) []string {
+	if s == "" {
+		return []string{}
+	}
+	return strings.Split(s, ",")
+}
+
+// DeleteWebhook removes a webhook and its delivery history.
+func (db *DB) DeleteWebhook(id, userID string) error {
+	return db.withTx(func(tx *txn) error {
+		if _, err := tx.Exec(
+			`DELETE FROM webhook_deliveries WHERE webhook_id IN
+			 (SELECT id FROM webhooks WHERE id = ? AND user_id = ?)`, id, userID,
+		); err != nil {
+			return fmt.Errorf("delete webhook deliveries: %w", err)
+		}
+		res, err := tx.Exec(`DELETE FROM webhooks WHERE id = ? AND user_id = ?`, id, userID)
+		if err != nil {
+			return fmt.Errorf("delete webhook: %w", err)
+		}
+		return checkRowsAffected(res)
+	})
+}
+
+// QueueWebhookEvent queues a delivery of payload to each of the user's
+// webhooks subscribed to event and reports how many were queued.
+func (db *DB) QueueWebhookEvent(userID, event string, payload []byte, now time.Time) (int, error) {
+	rows, err := db.query(`SELECT id, events FROM webhooks WHERE user_id = ?`, userID)
+	if err != nil {
+		return 0, fmt.Errorf("match webhooks: %w", err)
+	}
+	var ids []string
+	for rows.Next() {
+		var id, events string
+		if err := rows.Scan(&id, &events); err != nil {
+			rows.Close()
+			return 0, fmt.Errorf("scan webhook: %w", err)
+		}
+		if events == "" || slices.Contains(strings.Split(events, ","), event) {
+			ids = append(ids, id)
+		}
+	}
+	rows.Close()
+	if err := rows.Err(); err != nil {
+		return 0, err
+	}
+	if len(ids) == 0 {
+		return 0, nil
+	}
+
+	ms := toMillis(now)
+	err = db.withTx(func(tx *txn) error {
+		for _, id := range ids {
+			if _, err := tx.Exec(
+				`INSERT INTO webhook_deliveries (id, webhook_id, event, payload, status, next_attempt_at, created_at)
+				 VALUES (?, ?, ?, ?, ?, ?, ?)`,
+				model.NewID(), id, event, tx.key.seal(string(payload)), DeliveryPending, ms, ms,
+			); err != nil {
+				return fmt.Errorf("queue webhook delivery: %w", err)
+			}
+		}
+		return nil
+	})
+	if err != nil {
+		return 0, err
+	}
+	return len(ids), nil
+}
+
+// DueDelivery is a pending delivery whose next attempt is due, with what
+// is needed to send it.
+type DueDelivery struct {
+	ID       string
+	URL      string
+	Secret   string
+	Event    string
+	Payload  []byte
+	Attempts int
+}
+
+// DueWebhookDeliveries returns up to limit pending deliveries due at now,
+// oldest first.
+func (db *DB) DueWebhookDeliveries(now time.Time, limit int) ([]DueDelivery, error) {
+	rows, err := db.query(
+		`SELECT d.id, w.url, w.secret, d.event, plaintext(d.payload), d.attempts
+		 FROM webhook_deliveries d JOIN webhooks w ON w.id = d.webhook_id
+		 WHERE d.status = ? AND d.next_attempt_at <= ?
+		 ORDER BY d.next_attempt_at ASC, d.created_at ASC LIMIT ?`,
+		DeliveryPending, toMillis(now), limit,
+	)
+	if err != nil {
+		return nil, fmt.Errorf("due webhook deliveries: %w", err)
+	}
+	defer rows.Close()
+
+	var due []DueDelivery
+	for rows.Next() {
+		var d DueDelivery
+		var payload string
+		if err := rows.Scan(&d.ID, &d.URL, &d.Secret, &d.Event, &payload, &d.Attempts); err != nil {
+			return nil, fmt.Errorf("scan webhook delivery: %w", err)
+		}
+		d.Payload = []byte(payload)
+		due = append(due, d)
+	}
+	return due, rows.Err()
+}
+
+// RecordWebhookAttempt stores the outcome of one delivery attempt. A nil
+// next leaves the delivery finished with the given status.
+func (db *DB) RecordWebhookAttempt(id, status string, code int, errMsg string, at time.Time, next *time.Time) error {
+	res, err := db.exec(
+		`UPDATE webhook_deliveries SET status = ?, attempts = attempts + 1, response_code = ?,
+		 error = ?, last_attempt_at = ?, next_attempt_at = ?
+		 WHERE id = ?`,
+		status, code, errMsg, toMillis(at), toNullMillis(next), id,
+	)
+	if err != nil {
+		return fmt.Errorf("record webhook attempt: %w", err)
+	}
+	return checkRowsAffected(res)
+}
+
+// ListWebhookDeliveries returns the newest deliveries of one of the user's
+// webhooks.
+func (db *DB) ListWebhookDeliveries(webhookID, userID string, limit int) ([]model.WebhookDelivery, error) {
+	var exists int
+	err := db.queryRow(`SELECT 1 FROM webhooks WHERE id = ? AND user_id = ?`, webhookID, userID).Scan(&exists)
+	if errors.Is(err, sql.ErrNoRows) {
+		return nil, ErrNotFound
+	}
+	if err != nil {
+		return nil, fmt.Errorf("get webhook: %w", err)
+	}
+
+	rows, err := db.query(
+		`SELECT id, webhook_id, event, payload, status, attempts, response_code, error,
+		 next_attempt_at, last_attempt_at, created_at
+		 FROM webhook_deliveries WHERE webhook_id = ?
+		 ORDER BY created_at DESC, rowid DESC LIMIT ?`,
+		webhookID, limit,
+	)
+	if err != nil {
+		return nil, fmt.Errorf("list webhook deliveries: %w", err)
+	}
+	defer rows.Close()
+
+	deliveries := []model.WebhookDelivery{}
+	for rows.Next() {
+		var d model.WebhookDelivery
+		var payload string
+		var next, last sql.NullInt64
+		var createdAt int64
+		if err := rows.Scan(&d.ID, &d.WebhookID, &d.Event, &payload, &d.Status, &d.Attempts,
+			&d.ResponseCode, &d.Error, &next, &last, &createdAt); err != nil {
+			return nil, fmt.Errorf("scan webhook delivery: %w", err)
+		}
+		d.Payload = []byte(payload)
+		d.NextAttemptAt = fromNullMillis(next)
+		d.LastAttemptAt = fromNullMillis(last)
+		d.CreatedAt = fromMillis(createdAt)
+		deliveries = append(deliveries, d)
+	}
+	return deliveries, rows.Err()
+}
+
+// PruneWebhookDeliveries removes finished deliveries created before cutoff
+// and returns how many.
+func (db *DB) PruneWebhookDeliveries(cutoff time.Time) (int64, error) {
+	res, err := db.exec(
+		`DELETE FROM webhook_deliveries WHERE status != ? AND created_at < ?`,
+		DeliveryPending, toMillis(cutoff),
+	)
+	if err != nil {
+		return 0, fmt.Errorf("prune webhook deliveries: %w", err)
+	}
+	return res.RowsAffected()
+}
@@ -0,0 +1,81 @@
+package delta
+
+import (
+	"fmt"
+	"math/rand"
+	"strings"
+	"testing"
+)
+
+func TestDiffApply(t *testing.T) {
+	tests := []struct {
+		name string
+		a, b string
+	}{
+		{"empty to text", "", "one\ntwo\n"},
+		{"text to empty", "one\ntwo\n", ""},
+		{"unchanged", "same\n", "same\n"},
+		{"edit middle", "a\nb\nc\nd\n", "a\nB\nc\nd\n"},
+		{"insert and delete", "a\nb\nc\nd\ne\n", "x\na\nc\nd\ne\ny\n"},
+		{"no trailing newline", "a\nb", "a\nb\nc"},
+		{"two far edits", "1\n2\n3\n4\n5\n6\n7\n8\n", "1\nX\n3\n4\n5\n6\nY\n8\n"},
+	}
+	for _, tt := range tests {
+		p := Diff(tt.a, tt.b)
+		got, err := Apply(tt.a, p)
+		t.Logf("%s: %d hunks %+v", tt.name, len(p), p)
+		if err != nil || got != tt.b {
+			t.Errorf("%s: got %q, %v; want %q", tt.name, got, err, tt.b)
+		}
+	}
+
+	// Separate edits make separate hunks rather than one spanning both
+	if p := Diff("1\n2\n3\n4\n5\n6\n7\n8\n", "1\nX\n3\n4\n5\n6\nY\n8\n"); len(p) != 2 {
+		t.Errorf("expected 2 hunks, got %+v", p)
+	}
+}
+
+func TestDiffApplyRandom(t *testing.T) {
+	r := rand.New(rand.NewSource(1))
+	words := []string{"alpha\n", "beta\n", "gamma\n", "delta\n", "eps"}
+	gen := func() string {
+		var b strings.Builder
+		for range r.Intn(30) {
+			b.WriteString(words[r.Intn(len(words))])
+		}
+		return b.String()
+	}
+	for i := range 500 {
+		a, b := gen(), gen()
+		got, err := Apply(a, Diff(a, b))
+		if err != nil || got != b {
+			t.Fatalf("case %d: Apply(Diff(%q, %q)) = %q, %v", i, a, b, got, err)
+		}
+	}
+}
+
+func TestApplyMismatch(t *testing.T) {
+	p := Diff("a\nb\nc\n", "a\nc\n")
+	if _, err := Apply("a\n", p); err != ErrMismatch {
+		t.Errorf("expected ErrMismatch on shorter base, got %v", err)
+	}
+}
+
+func TestDiffLargeFallback(t *testing.T) {
+	// Arrange: completely different content beyond the edit budget
+	var a, b strings.Builder
+	for i := range 2 * maxEdits {
+		fmt.Fprintf(&a, "a%d\n", i)
+		fmt.Fprintf(&b, "b%d\n", i)
+	}
+
+	// Act
+	p := Diff(a.String(), b.String())
+
+	// Assert
+	got, err := Apply(a.String(), p)
+	t.Logf("hunks: %d", len(p))
+	if err != nil || got != b.String() || len(p) != 1 {
+		t.Errorf("expected one replacing hunk, got %d hunks, err=%v", len(p), err)
+	}
+}
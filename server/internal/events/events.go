@@ -0,0 +1,104 @@
+// Package events is the in-process bus on which the API publishes domain
+// events once a write has committed. Side effects of writes — live sync,
+// webhooks, the audit log, reminder scheduling — subscribe to the bus
+// instead of being called from every handler.
+package events
+
+import (
+	"context"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/c0dev0id/notesd/server/internal/model"
+)
+
+// Kind names what happened to an item.
+type Kind string
+
+const (
+	NoteCreated  Kind = "note.created"
+	NoteUpdated  Kind = "note.updated"
+	NoteDeleted  Kind = "note.deleted"
+	NoteRestored Kind = "note.restored"
+	NotePurged   Kind = "note.purged"
+
+	TodoCreated   Kind = "todo.created"
+	TodoUpdated   Kind = "todo.updated"
+	TodoCompleted Kind = "todo.completed"
+	TodoDeleted   Kind = "todo.deleted"
+	TodoRestored  Kind = "todo.restored"
+	TodoPurged    Kind = "todo.purged"
+
+	// Resync follows a bulk change: clients should pull rather than
+	// expect an event per item.
+	Resync Kind = "resync"
+)
+
+// Item returns "note" or "todo", or "" for Resync.
+func (k Kind) Item() string {
+	item, _, ok := strings.Cut(string(k), ".")
+	if !ok {
+		return ""
+	}
+	return item
+}
+
+// Event is a change to one of a user's items. Note or Todo is the stored
+// version when the publisher has it; deletions by ID carry neither.
+type Event struct {
+	Kind     Kind
+	UserID   string
+	ID       string
+	DeviceID string
+	Note     *model.Note
+	Todo     *model.Todo
+	// Bulk marks a change made by an operation on many items, such as an
+	// import or a tag rename. Subscribers that notify clients or users
+	// skip these; the operation publishes Resync where clients must pull.
+	Bulk bool
+	At   time.Time
+}
+
+// Handler receives the events of one Publish call, in order. ctx is the
+// publisher's, so work done for a request is traced as part of it.
+type Handler func(ctx context.Context, evs []Event)
+
+// Bus delivers published events to its subscribers synchronously, in
+// subscription order, so every subscriber sees a user's changes in the
+// order they were made. Handlers must not block for long.
+type Bus struct {
+	mu   sync.RWMutex
+	subs []Handler
+}
+
+func NewBus() *Bus {
+	return &Bus{}
+}
+
+// Subscribe registers h for every event published from now on.
+func (b *Bus) Subscribe(h Handler) {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	b.subs = append(b.subs, h)
+}
+
+// Publish hands evs to every subscriber. Events without a time get the
+// current one.
+func (b *Bus) Publish(ctx context.Context, evs ...Event) {
+	if len(evs) == 0 {
+		return
+	}
+	now := model.NowMillis()
+	for i := range evs {
+		if evs[i].At.IsZero() {
+			evs[i].At = now
+		}
+	}
+	b.mu.RLock()
+	subs := b.subs
+	b.mu.RUnlock()
+	for _, h := range subs {
+		h(ctx, evs)
+	}
+}
@@ -0,0 +1,69 @@
+package events
+
+import (
+	"context"
+	"testing"
+	"time"
+)
+
+func TestPublishOrder(t *testing.T) {
+	// Arrange: two subscribers recording what they see
+	b := NewBus()
+	var seen []string
+	b.Subscribe(func(_ context.Context, evs []Event) {
+		for _, ev := range evs {
+			seen = append(seen, "a:"+ev.ID)
+		}
+	})
+	b.Subscribe(func(_ context.Context, evs []Event) {
+		for _, ev := range evs {
+			seen = append(seen, "b:"+ev.ID)
+		}
+	})
+	at := time.UnixMilli(1000)
+
+	// Act
+	b.Publish(context.Background(), Event{Kind: NoteCreated, ID: "1"}, Event{Kind: NoteUpdated, ID: "2", At: at})
+	b.Publish(context.Background())
+
+	// Assert
+	t.Logf("seen: %v", seen)
+	want := []string{"a:1", "a:2", "b:1", "b:2"}
+	if len(seen) != len(want) {
+		t.Fatalf("got %v, want %v", seen, want)
+	}
+	for i := range want {
+		if seen[i] != want[i] {
+			t.Fatalf("got %v, want %v", seen, want)
+		}
+	}
+}
+
+func TestPublishStampsTime(t *testing.T) {
+	// Arrange
+	b := NewBus()
+	var got []Event
+	b.Subscribe(func(_ context.Context, evs []Event) { got = evs })
+	at := time.UnixMilli(1000)
+
+	// Act
+	b.Publish(context.Background(), Event{Kind: TodoCompleted, ID: "1"}, Event{Kind: Resync, At: at})
+
+	// Assert
+	t.Logf("times: %v, %v", got[0].At, got[1].At)
+	if got[0].At.IsZero() {
+		t.Error("expected the current time on an unstamped event")
+	}
+	if !got[1].At.Equal(at) {
+		t.Errorf("stamped event: got %v, want %v", got[1].At, at)
+	}
+}
+
+func TestKindItem(t *testing.T) {
+	for kind, want := range map[Kind]string{NotePurged: "note", TodoCompleted: "todo", Resync: ""} {
+		t.Logf("%s -> %q", kind, kind.Item())
+		if got := kind.Item(); got != want {
+			t.Errorf("%s: got %q, want %q", kind, got, want)
+		}
+	}
+}
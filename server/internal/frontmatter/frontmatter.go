@@ -0,0 +1,108 @@
+// Package frontmatter reads the YAML front matter of Markdown notes, as
+// written by the export and by note apps, without a YAML library.
+package frontmatter
+
+import (
+	"strconv"
+	"strings"
+)
+
+// Fields holds the keys of a YAML front matter block, lower-cased.
+// Scalars have one value and lists several. Only the flat key/value and
+// list forms that note apps write are understood.
+type Fields map[string][]string
+
+// Get returns the first value of key, or "" if there is none.
+func (fm Fields) Get(key string) string {
+	if v := fm[key]; len(v) > 0 {
+		return v[0]
+	}
+	return ""
+}
+
+// Parse splits a leading ---/--- block off a Markdown document. Without a
+// terminated block it returns the document unchanged.
+func Parse(s string) (Fields, string) {
+	s = strings.TrimPrefix(s, "\ufeff")
+	lines := strings.SplitAfter(s, "\n")
+	if strings.TrimRight(lines[0], "\r\n") != "---" {
+		return nil, s
+	}
+	fm := Fields{}
+	offset := len(lines[0])
+	var last string
+	for _, line := range lines[1:] {
+		offset += len(line)
+		l := strings.TrimRight(line, "\r\n")
+		if l == "---" || l == "..." {
+			return fm, s[offset:]
+		}
+		trimmed := strings.TrimSpace(l)
+		switch {
+		case trimmed == "" || strings.HasPrefix(trimmed, "#"):
+		case strings.HasPrefix(trimmed, "- ") && last != "":
+			fm[last] = append(fm[last], scalar(trimmed[2:]))
+		default:
+			key, value, ok := strings.Cut(l, ":")
+			if !ok {
+				continue
+			}
+			last = strings.ToLower(strings.TrimSpace(key))
+			value = strings.TrimSpace(value)
+			switch {
+			case value == "":
+				fm[last] = nil
+			case strings.HasPrefix(value, "[") && strings.HasSuffix(value, "]"):
+				fm[last] = flowList(value[1 : len(value)-1])
+			default:
+				fm[last] = []string{scalar(value)}
+			}
+		}
+	}
+	return nil, s
+}
+
+// flowList splits the inside of [a, "b, c"] at commas outside quotes.
+func flowList(s string) []string {
+	var items []string
+	var quote byte
+	start := 0
+	for i := 0; i <= len(s); i++ {
+		if i < len(s) {
+			c := s[i]
+			switch {
+			case quote != 0 && c == '\\' && quote == '"':
+				i++
+				continue
+			case quote != 0 && c == quote:
+				quote = 0
+				continue
+			case quote == 0 && (c == '"' || c == '\''):
+				quote = c
+				continue
+			case quote != 0 || c != ',':
+				continue
+			}
+		}
+		if item := strings.TrimSpace(s[start:i]); item != "" {
+			items = append(items, scalar(item))
+		}
+		start = i + 1
+	}
+	return items
+}
+
+// scalar unquotes a single- or double-quoted YAML scalar. Double-quoted
+// escapes are read as Go escapes, which cover those the export writes.
+func scalar(v string) string {
+	if len(v) >= 2 && v[0] == '"' && v[len(v)-1] == '"' {
+		if s, err := strconv.Unquote(v); err == nil {
+			return s
+		}
+		return v[1 : len(v)-1]
+	}
+	if len(v) >= 2 && v[0] == '\'' && v[len(v)-1] == '\'' {
+		return strings.ReplaceAll(v[1:len(v)-1], "''", "'")
+	}
+	return v
+}
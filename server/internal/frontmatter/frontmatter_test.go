@@ -0,0 +1,27 @@
+package frontmatter
+
+import (
+	"slices"
+	"testing"
+)
+
+func TestParse(t *testing.T) {
+	// Arrange
+	doc := "---\r\ntitle: 'It''s here'\ntags:\n  - a\n  - \"b, c\"\nempty:\n---\nbody\n---\n"
+
+	// Act
+	fm, body := Parse(doc)
+	_, plain := Parse("--- not front matter\n")
+
+	// Assert
+	t.Logf("front matter: %q, body: %q", fm, body)
+	if fm.Get("title") != "It's here" || !slices.Equal(fm["tags"], []string{"a", "b, c"}) || body != "body\n---\n" {
+		t.Errorf("unexpected parse: %q %q", fm, body)
+	}
+	if got := flowList(`work, "x, y", 'z'`); !slices.Equal(got, []string{"work", "x, y", "z"}) {
+		t.Errorf("flow list: %q", got)
+	}
+	if plain != "--- not front matter\n" {
+		t.Errorf("document without front matter changed: %q", plain)
+	}
+}
@@ -0,0 +1,296 @@
+// Package githistory keeps the versions of notes as commits in a bare git
+// repository, one file per note, so that their history can be read with
+// git's own tools and replicated elsewhere with git push. It drives the git
+// command with plumbing commands instead of reimplementing git; the
+// repository has no work tree, only an index of its own.
+package githistory
+
+import (
+	"bytes"
+	"context"
+	"errors"
+	"fmt"
+	"io/fs"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+)
+
+const (
+	branch    = "refs/heads/main"
+	indexFile = "notesd-index"
+	zeroHash  = "0000000000000000000000000000000000000000"
+)
+
+// ErrNotFound is returned for a revision or file the history does not have.
+var ErrNotFound = errors.New("not in history")
+
+// Repo is a bare repository holding the history on one branch.
+type Repo struct {
+	dir    string
+	remote string
+	mu     sync.Mutex // commits share the index
+}
+
+// Change sets the file at Path to Content, or removes it when Content is
+// nil.
+type Change struct {
+	Path    string
+	Content []byte
+}
+
+// Signature names the author of a commit.
+type Signature struct {
+	Name  string
+	Email string
+	At    time.Time
+}
+
+// Revision is a commit of the history.
+type Revision struct {
+	Rev     string
+	Author  string
+	At      time.Time
+	Message string
+}
+
+// Open returns the repository in dir, creating a bare repository there if
+// there is none. remote, a remote name or URL, is where Push sends the
+// history; empty for none.
+func Open(dir, remote string) (*Repo, error) {
+	if _, err := exec.LookPath("git"); err != nil {
+		return nil, fmt.Errorf("note history needs git: %w", err)
+	}
+	dir, err := filepath.Abs(dir)
+	if err != nil {
+		return nil, err
+	}
+	r := &Repo{dir: dir, remote: remote}
+	ctx := context.Background()
+	if _, err := os.Stat(filepath.Join(dir, "HEAD")); errors.Is(err, fs.ErrNotExist) {
+		if err := os.MkdirAll(dir, 0700); err != nil {
+			return nil, fmt.Errorf("create history dir: %w", err)
+		}
+		if _, err := r.git(ctx, nil, nil, "init", "--quiet", "--bare"); err != nil {
+			return nil, err
+		}
+		if _, err := r.git(ctx, nil, nil, "symbolic-ref", "HEAD", branch); err != nil {
+			return nil, err
+		}
+	} else if err != nil {
+		return nil, err
+	}
+	if _, err := os.Stat(filepath.Join(dir, indexFile)); errors.Is(err, fs.ErrNotExist) {
+		if err := r.resetIndex(ctx); err != nil {
+			return nil, err
+		}
+	}
+	return r, nil
+}
+
+// Commit applies changes to the files of the history and records them as
+// one commit. It returns the new revision, or "" when the changes leave
+// the files as they were.
+func (r *Repo) Commit(ctx context.Context, changes []Change, author Signature, message string) (string, error) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	var info strings.Builder
+	for _, c := range changes {
+		if c.Path == "" || strings.ContainsAny(c.Path, "\t\n\x00") || strings.HasPrefix(c.Path, "/") {
+			return "", fmt.Errorf("invalid history path %q", c.Path)
+		}
+		if c.Content == nil {
+			fmt.Fprintf(&info, "0 %s\t%s\n", zeroHash, c.Path)
+			continue
+		}
+		hash, err := r.git(ctx, nil, c.Content, "hash-object", "-w", "--stdin")
+		if err != nil {
+			return "", err
+		}
+		fmt.Fprintf(&info, "100644 %s\t%s\n", hash, c.Path)
+	}
+	rev, err := r.commitIndex(ctx, info.String(), author, message)
+	if err != nil {
+		// Leave no half-applied changes in the index for the next commit.
+		if rerr := r.resetIndex(ctx); rerr != nil {
+			err = errors.Join(err, rerr)
+		}
+	}
+	return rev, err
+}
+
+func (r *Repo) commitIndex(ctx context.Context, info string, author Signature, message string) (string, error) {
+	if _, err := r.git(ctx, nil, []byte(info), "update-index", "--index-info"); err != nil {
+		return "", err
+	}
+	tree, err := r.git(ctx, nil, nil, "write-tree")
+	if err != nil {
+		return "", err
+	}
+	parent := r.head(ctx)
+	args := []string{"commit-tree", tree, "-F", "-"}
+	if parent != "" {
+		parentTree, err := r.git(ctx, nil, nil, "rev-parse", parent+"^{tree}")
+		if err != nil {
+			return "", err
+		}
+		if parentTree == tree {
+			return "", nil
+		}
+		args = append(args, "-p", parent)
+	}
+
+	date := strconv.FormatInt(author.At.Unix(), 10) + " +0000"
+	env := []string{
+		"GIT_AUTHOR_NAME=" + author.Name, "GIT_AUTHOR_EMAIL=" + author.Email, "GIT_AUTHOR_DATE=" + date,
+		"GIT_COMMITTER_NAME=" + author.Name, "GIT_COMMITTER_EMAIL=" + author.Email, "GIT_COMMITTER_DATE=" + date,
+	}
+	rev, err := r.git(ctx, env, []byte(message), args...)
+	if err != nil {
+		return "", err
+	}
+	// The old value makes the update fail rather than lose a commit should
+	// another process have moved the branch.
+	if _, err := r.git(ctx, nil, nil, "update-ref", branch, rev, parent); err != nil {
+		return "", err
+	}
+	return rev, nil
+}
+
+// Log returns the last limit revisions that changed the file at path,
+// newest first.
+func (r *Repo) Log(ctx context.Context, path string, limit int) ([]Revision, error) {
+	if r.head(ctx) == "" {
+		return nil, nil
+	}
+	out, err := r.git(ctx, nil, nil, "log", "-n", strconv.Itoa(limit), logFormat, branch, "--", path)
+	if err != nil {
+		return nil, err
+	}
+	return parseLog(out), nil
+}
+
+// Diff returns the latest revision at or before rev that changed the file
+// at path, and the unified diff of that change. An empty rev means the
+// latest change of all.
+func (r *Repo) Diff(ctx context.Context, path, rev string) (Revision, string, error) {
+	if rev == "" {
+		rev = branch
+	} else if !ValidRev(rev) {
+		return Revision{}, "", ErrNotFound
+	}
+	if r.head(ctx) == "" {
+		return Revision{}, "", ErrNotFound
+	}
+	if _, err := r.git(ctx, nil, nil, "rev-parse", "--verify", "--quiet", rev+"^{commit}"); err != nil {
+		return Revision{}, "", ErrNotFound
+	}
+	out, err := r.git(ctx, nil, nil, "log", "-n", "1", logFormat, rev, "--", path)
+	if err != nil {
+		return Revision{}, "", err
+	}
+	revs := parseLog(out)
+	if len(revs) == 0 {
+		return Revision{}, "", ErrNotFound
+	}
+	diff, err := r.git(ctx, nil, nil, "show", "--format=", "--no-color", "--no-ext-diff", "--no-textconv", revs[0].Rev, "--", path)
+	if err != nil {
+		return Revision{}, "", err
+	}
+	if diff != "" {
+		diff += "\n"
+	}
+	return revs[0], diff, nil
+}
+
+// Push sends the history to the remote. It does nothing without one.
+func (r *Repo) Push(ctx context.Context) error {
+	if r.remote == "" || r.head(ctx) == "" {
+		return nil
+	}
+	_, err := r.git(ctx, nil, nil, "push", "--quiet", r.remote, branch+":"+branch)
+	return err
+}
+
+// Compact packs loose objects once enough have gathered, as plumbing
+// commands never do it on their own.
+func (r *Repo) Compact(ctx context.Context) error {
+	_, err := r.git(ctx, nil, nil, "gc", "--auto", "--quiet")
+	return err
+}
+
+// ValidRev reports whether rev is an abbreviated or full commit hash, the
+// only revisions clients may name.
+func ValidRev(rev string) bool {
+	if len(rev) < 4 || len(rev) > 64 {
+		return false
+	}
+	for _, c := range rev {
+		if (c < '0' || c > '9') && (c < 'a' || c > 'f') {
+			return false
+		}
+	}
+	return true
+}
+
+// logFormat separates the fields of a commit by NUL and commits by a
+// record separator, as subjects may contain anything else.
+const logFormat = "--format=%H%x00%an%x00%at%x00%s%x1e"
+
+func parseLog(out string) []Revision {
+	var revs []Revision
+	for _, rec := range strings.Split(out, "\x1e") {
+		f := strings.Split(strings.TrimSpace(rec), "\x00")
+		if len(f) != 4 {
+			continue
+		}
+		at, _ := strconv.ParseInt(f[2], 10, 64)
+		revs = append(revs, Revision{Rev: f[0], Author: f[1], At: time.Unix(at, 0).UTC(), Message: f[3]})
+	}
+	return revs
+}
+
+// head returns the commit at the tip of the branch, or "" before the first
+// commit.
+func (r *Repo) head(ctx context.Context) string {
+	rev, err := r.git(ctx, nil, nil, "rev-parse", "--verify", "--quiet", branch)
+	if err != nil {
+		return ""
+	}
+	return rev
+}
+
+// resetIndex makes the index match the tip of the branch.
+func (r *Repo) resetIndex(ctx context.Context) error {
+	if r.head(ctx) == "" {
+		_, err := r.git(ctx, nil, nil, "read-tree", "--empty")
+		return err
+	}
+	_, err := r.git(ctx, nil, nil, "read-tree", branch)
+	return err
+}
+
+// git runs a git command on the repository with the extra environment env
+// and stdin, and returns its output without the trailing newline.
+func (r *Repo) git(ctx context.Context, env []string, stdin []byte, args ...string) (string, error) {
+	cmd := exec.CommandContext(ctx, "git", args...)
+	cmd.Env = append(os.Environ(), "GIT_DIR="+r.dir, "GIT_INDEX_FILE="+filepath.Join(r.dir, indexFile))
+	cmd.Env = append(cmd.Env, env...)
+	if stdin != nil {
+		cmd.Stdin = bytes.NewReader(stdin)
+	}
+	var stdout, stderr bytes.Buffer
+	cmd.Stdout, cmd.Stderr = &stdout, &stderr
+	if err := cmd.Run(); err != nil {
+		if msg := strings.TrimSpace(stderr.String()); msg != "" {
+			return "", fmt.Errorf("git %s: %w: %s", args[0], err, msg)
+		}
+		return "", fmt.Errorf("git %s: %w", args[0], err)
+	}
+	return strings.TrimSuffix(stdout.String(), "\n"), nil
+}
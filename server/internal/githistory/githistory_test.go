@@ -0,0 +1,119 @@
+package githistory
+
+import (
+	"context"
+	"errors"
+	"os/exec"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+)
+
+func openTestRepo(t *testing.T) *Repo {
+	t.Helper()
+	if _, err := exec.LookPath("git"); err != nil {
+		t.Skip("git not installed")
+	}
+	r, err := Open(filepath.Join(t.TempDir(), "history.git"), "")
+	if err != nil {
+		t.Fatalf("Open: %v", err)
+	}
+	return r
+}
+
+func TestCommitLogDiff(t *testing.T) {
+	// Arrange
+	ctx := context.Background()
+	r := openTestRepo(t)
+	author := Signature{Name: "Ann", Email: "ann@example.com", At: time.Date(2026, 3, 1, 8, 0, 0, 0, time.UTC)}
+	commit := func(msg string, changes ...Change) string {
+		t.Helper()
+		rev, err := r.Commit(ctx, changes, author, msg)
+		if err != nil {
+			t.Fatalf("Commit %q: %v", msg, err)
+		}
+		author.At = author.At.Add(time.Minute)
+		return rev
+	}
+
+	// Act
+	first := commit("Create a", Change{Path: "u/a.md", Content: []byte("one\n")}, Change{Path: "u/b.md", Content: []byte("b\n")})
+	second := commit("Update a", Change{Path: "u/a.md", Content: []byte("one\ntwo\n")})
+	unchanged := commit("Update a again", Change{Path: "u/a.md", Content: []byte("one\ntwo\n")})
+	third := commit("Delete b", Change{Path: "u/b.md"})
+	log, logErr := r.Log(ctx, "u/a.md", 10)
+	latest, latestDiff, latestErr := r.Diff(ctx, "u/a.md", "")
+	old, oldDiff, oldErr := r.Diff(ctx, "u/a.md", first[:8])
+	_, deleteDiff, deleteErr := r.Diff(ctx, "u/b.md", third)
+	_, _, missingErr := r.Diff(ctx, "u/a.md", "deadbeef")
+
+	// Assert
+	t.Logf("revisions %s %s %q %s, log %+v", first, second, unchanged, third, log)
+	if first == "" || second == "" || third == "" || unchanged != "" {
+		t.Fatalf("expected a commit for every change but the empty one")
+	}
+	if logErr != nil || len(log) != 2 || log[0].Rev != second || log[1].Rev != first {
+		t.Fatalf("Log: %v %+v", logErr, log)
+	}
+	if log[0].Message != "Update a" || log[0].Author != "Ann" || !log[0].At.Equal(time.Date(2026, 3, 1, 8, 1, 0, 0, time.UTC)) {
+		t.Errorf("unexpected revision %+v", log[0])
+	}
+	t.Logf("latest diff:\n%s", latestDiff)
+	if latestErr != nil || latest.Rev != second || !strings.Contains(latestDiff, "\n+two\n") {
+		t.Errorf("Diff latest: %v %+v", latestErr, latest)
+	}
+	if oldErr != nil || old.Rev != first || !strings.Contains(oldDiff, "new file mode") {
+		t.Errorf("Diff first: %v %+v\n%s", oldErr, old, oldDiff)
+	}
+	if deleteErr != nil || !strings.Contains(deleteDiff, "deleted file mode") {
+		t.Errorf("Diff delete: %v\n%s", deleteErr, deleteDiff)
+	}
+	if !errors.Is(missingErr, ErrNotFound) {
+		t.Errorf("Diff of an unknown revision: %v, want ErrNotFound", missingErr)
+	}
+}
+
+func TestPush(t *testing.T) {
+	// Arrange: a repository with a commit and an empty one as its remote
+	ctx := context.Background()
+	src := openTestRepo(t)
+	dst := openTestRepo(t)
+	src.remote = dst.dir
+	rev, err := src.Commit(ctx, []Change{{Path: "u/a.md", Content: []byte("a\n")}}, Signature{Name: "Ann", Email: "ann@example.com", At: time.Now()}, "Create a")
+	if err != nil {
+		t.Fatalf("Commit: %v", err)
+	}
+
+	// Act
+	err = src.Push(ctx)
+
+	// Assert
+	if err != nil {
+		t.Fatalf("Push: %v", err)
+	}
+	if got := dst.head(ctx); got != rev {
+		t.Errorf("remote is at %q, want %q", got, rev)
+	}
+}
+
+func TestValidRev(t *testing.T) {
+	cases := map[string]bool{
+		"0a1b2c3d":              true,
+		"abc":                   false,
+		"HEAD":                  false,
+		"--output=x":            false,
+		"main":                  false,
+		strings.Repeat("f", 40): true,
+	}
+	for rev, want := range cases {
+		// Act
+		got := ValidRev(rev)
+
+		// Assert
+		t.Logf("%q -> %v", rev, got)
+		if got != want {
+			t.Errorf("ValidRev(%q) = %v, want %v", rev, got, want)
+		}
+	}
+}
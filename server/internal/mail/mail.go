@@ -0,0 +1,68 @@
+// Package mail sends plain-text email over SMTP, and receives it with a
+// minimal SMTP server for mail-in.
+package mail
+
+import (
+	"fmt"
+	"mime"
+	"net"
+	"net/smtp"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/c0dev0id/notesd/server/internal/config"
+)
+
+// Sender delivers a single plain-text message.
+type Sender interface {
+	Send(to, subject, body string) error
+}
+
+// SMTP sends mail through the configured relay. The connection is upgraded
+// with STARTTLS when the server offers it.
+type SMTP struct {
+	cfg config.SMTPConfig
+}
+
+// New returns an SMTP sender, or nil if mail is not configured.
+func New(cfg config.SMTPConfig) Sender {
+	if cfg.Host == "" {
+		return nil
+	}
+	return &SMTP{cfg: cfg}
+}
+
+func (s *SMTP) Send(to, subject, body string) error {
+	addr := net.JoinHostPort(s.cfg.Host, strconv.Itoa(s.cfg.Port))
+
+	var auth smtp.Auth
+	if s.cfg.Username != "" {
+		auth = smtp.PlainAuth("", s.cfg.Username, s.cfg.Password, s.cfg.Host)
+	}
+
+	msg := compose(s.cfg.From, to, subject, body, time.Now())
+	if err := smtp.SendMail(addr, auth, s.cfg.From, []string{to}, msg); err != nil {
+		return fmt.Errorf("send mail: %w", err)
+	}
+	return nil
+}
+
+// compose renders an RFC 5322 message with a UTF-8 text body.
+func compose(from, to, subject, body string, date time.Time) []byte {
+	var b strings.Builder
+	fmt.Fprintf(&b, "From: %s\r\n", from)
+	fmt.Fprintf(&b, "To: %s\r\n", to)
+	fmt.Fprintf(&b, "Subject: %s\r\n", mime.QEncoding.Encode("utf-8", subject))
+	fmt.Fprintf(&b, "Date: %s\r\n", date.Format(time.RFC1123Z))
+	b.WriteString("MIME-Version: 1.0\r\n")
+	b.WriteString("Content-Type: text/plain; charset=utf-8\r\n")
+	b.WriteString("Content-Transfer-Encoding: 8bit\r\n")
+	b.WriteString("\r\n")
+	for _, line := range strings.Split(body, "\n") {
+		// Dot-stuffing is handled by net/smtp; only normalize line endings.
+		b.WriteString(strings.TrimRight(line, "\r"))
+		b.WriteString("\r\n")
+	}
+	return []byte(b.String())
+}
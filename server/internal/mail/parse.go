@@ -0,0 +1,245 @@
+package mail
+
+import (
+	"bytes"
+	"encoding/base64"
+	"fmt"
+	"io"
+	"mime"
+	"mime/multipart"
+	"mime/quotedprintable"
+	netmail "net/mail"
+	"net/textproto"
+	"strings"
+	"unicode"
+
+	"golang.org/x/net/html"
+	"golang.org/x/text/encoding/htmlindex"
+)
+
+// Message is what is kept of a received email: the sender's address, the
+// subject, the text and the attached files.
+type Message struct {
+	From        string
+	Subject     string
+	Text        string
+	Attachments []Attachment
+}
+
+type Attachment struct {
+	Filename    string
+	ContentType string
+	Data        []byte
+}
+
+// maxDepth bounds the nesting of multipart bodies.
+const maxDepth = 10
+
+var wordDecoder = &mime.WordDecoder{CharsetReader: charsetReader}
+
+// charsetReader decodes input from any charset a browser knows.
+func charsetReader(charset string, input io.Reader) (io.Reader, error) {
+	enc, err := htmlindex.Get(charset)
+	if err != nil {
+		return nil, err
+	}
+	return enc.NewDecoder().Reader(input), nil
+}
+
+// Parse reads an RFC 5322 message. The text is made of its text/plain
+// parts, or of the first text/html part reduced to plain text when there
+// are none; parts with a file name or of another type are attachments.
+func Parse(data []byte) (*Message, error) {
+	m, err := netmail.ReadMessage(bytes.NewReader(data))
+	if err != nil {
+		return nil, fmt.Errorf("read message: %w", err)
+	}
+	msg := &Message{Subject: decodeHeader(m.Header.Get("Subject"))}
+	if from, err := m.Header.AddressList("From"); err == nil && len(from) > 0 {
+		msg.From = from[0].Address
+	}
+
+	p := &parser{msg: msg}
+	if err := p.part(textproto.MIMEHeader(m.Header), m.Body, 0); err != nil {
+		return nil, err
+	}
+	text := strings.Join(p.plain, "\n\n")
+	if len(p.plain) == 0 && p.html != nil {
+		text = htmlText(*p.html)
+	}
+	msg.Text = strings.TrimSpace(strings.ReplaceAll(text, "\r\n", "\n"))
+	if msg.Text != "" {
+		msg.Text += "\n"
+	}
+	return msg, nil
+}
+
+type parser struct {
+	msg   *Message
+	plain []string
+	html  *string
+}
+
+func (p *parser) part(h textproto.MIMEHeader, body io.Reader, depth int) error {
+	mediaType, params, err := mime.ParseMediaType(h.Get("Content-Type"))
+	if err != nil {
+		mediaType, params = "text/plain", nil
+	}
+	disposition, dparams, _ := mime.ParseMediaType(h.Get("Content-Disposition"))
+	filename := dparams["filename"]
+	if filename == "" {
+		filename = params["name"]
+	}
+	filename = decodeHeader(filename)
+
+	if strings.HasPrefix(mediaType, "multipart/") && params["boundary"] != "" && depth < maxDepth {
+		mr := multipart.NewReader(body, params["boundary"])
+		for {
+			part, err := mr.NextRawPart()
+			if err == io.EOF {
+				return nil
+			}
+			if err != nil {
+				return fmt.Errorf("read multipart body: %w", err)
+			}
+			if err := p.part(part.Header, part, depth+1); err != nil {
+				return err
+			}
+		}
+	}
+
+	data, err := io.ReadAll(decodeTransfer(h.Get("Content-Transfer-Encoding"), body))
+	if err != nil {
+		return fmt.Errorf("decode body: %w", err)
+	}
+	if filename == "" && disposition != "attachment" {
+		switch mediaType {
+		case "text/plain":
+			p.plain = append(p.plain, decodeCharset(params["charset"], data))
+			return nil
+		case "text/html":
+			if p.html == nil {
+				s := decodeCharset(params["charset"], data)
+				p.html = &s
+			}
+			return nil
+		}
+	}
+	if filename == "" {
+		filename = "attachment"
+		if exts, _ := mime.ExtensionsByType(mediaType); len(exts) > 0 {
+			filename += exts[0]
+		}
+	}
+	p.msg.Attachments = append(p.msg.Attachments, Attachment{Filename: filename, ContentType: mediaType, Data: data})
+	return nil
+}
+
+func decodeHeader(s string) string {
+	if d, err := wordDecoder.DecodeHeader(s); err == nil {
+		return strings.TrimSpace(d)
+	}
+	return strings.TrimSpace(s)
+}
+
+func decodeTransfer(encoding string, r io.Reader) io.Reader {
+	switch strings.ToLower(strings.TrimSpace(encoding)) {
+	case "base64":
+		return base64.NewDecoder(base64.StdEncoding, r)
+	case "quoted-printable":
+		return quotedprintable.NewReader(r)
+	}
+	return r
+}
+
+// decodeCharset returns data as UTF-8. Text in an unknown charset, or not
+// in the one it claims, keeps its valid UTF-8.
+func decodeCharset(charset string, data []byte) string {
+	switch strings.ToLower(charset) {
+	case "", "utf-8", "utf8", "us-ascii":
+	default:
+		if r, err := charsetReader(charset, bytes.NewReader(data)); err == nil {
+			if b, err := io.ReadAll(r); err == nil {
+				data = b
+			}
+		}
+	}
+	return strings.ToValidUTF8(string(data), "\ufffd")
+}
+
+// htmlText reduces an HTML body to its text, with a line break for each
+// block element and "- " before list items.
+func htmlText(s string) string {
+	z := html.NewTokenizer(strings.NewReader(s))
+	var b strings.Builder
+	skip := 0
+	for {
+		tt := z.Next()
+		switch tt {
+		case html.ErrorToken:
+			return tidyText(b.String())
+		case html.TextToken:
+			if skip == 0 {
+				writeCollapsed(&b, string(z.Text()))
+			}
+		case html.StartTagToken, html.EndTagToken, html.SelfClosingTagToken:
+			name, _ := z.TagName()
+			switch string(name) {
+			case "head", "script", "style", "title":
+				if tt == html.StartTagToken {
+					skip++
+				} else if tt == html.EndTagToken && skip > 0 {
+					skip--
+				}
+			case "br":
+				b.WriteString("\n")
+			case "li":
+				if tt == html.StartTagToken {
+					b.WriteString("\n- ")
+				}
+			case "p", "div", "tr", "table", "ul", "ol", "blockquote", "pre", "hr",
+				"h1", "h2", "h3", "h4", "h5", "h6":
+				b.WriteString("\n\n")
+			}
+		}
+	}
+}
+
+// writeCollapsed writes t with each run of white space as one space, as a
+// browser shows it.
+func writeCollapsed(b *strings.Builder, t string) {
+	space := false
+	for _, r := range t {
+		if unicode.IsSpace(r) {
+			space = true
+			continue
+		}
+		if space {
+			b.WriteByte(' ')
+			space = false
+		}
+		b.WriteRune(r)
+	}
+	if space {
+		b.WriteByte(' ')
+	}
+}
+
+// tidyText trims each line and keeps at most one blank line in a row.
+func tidyText(s string) string {
+	var out []string
+	blank := true
+	for _, line := range strings.Split(s, "\n") {
+		line = strings.TrimSpace(line)
+		if line == "" {
+			if !blank {
+				out = append(out, "")
+			}
+			blank = true
+			continue
+		}
+		out = append(out, line)
+		blank = false
+	}
+	return strings.TrimSpace(strings.Join(out, "\n"))
+}
@@ -0,0 +1,75 @@
+package mail
+
+import (
+	"strings"
+	"testing"
+)
+
+func TestParse(t *testing.T) {
+	cases := []struct {
+		name        string
+		msg         string
+		subject     string
+		text        string
+		attachments []string
+	}{
+		{
+			name:    "plain",
+			msg:     "From: Ann <ann@example.com>\r\nSubject: Shopping\r\n\r\nMilk\r\nEggs\r\n",
+			subject: "Shopping",
+			text:    "Milk\nEggs\n",
+		},
+		{
+			name: "encoded subject and latin-1 quoted-printable body",
+			msg: "Subject: =?UTF-8?B?R3LDvMOfZQ==?=\r\nContent-Type: text/plain; charset=iso-8859-1\r\n" +
+				"Content-Transfer-Encoding: quoted-printable\r\n\r\nSch=F6ne Gr=FC=DFe\r\n",
+			subject: "Grüße",
+			text:    "Schöne Grüße\n",
+		},
+		{
+			name: "alternative prefers plain text, attachment kept",
+			msg: "Subject: Report\r\nContent-Type: multipart/mixed; boundary=outer\r\n\r\n" +
+				"--outer\r\nContent-Type: multipart/alternative; boundary=inner\r\n\r\n" +
+				"--inner\r\nContent-Type: text/plain\r\n\r\nSee attached.\r\n" +
+				"--inner\r\nContent-Type: text/html\r\n\r\n<p>See <b>attached</b>.</p>\r\n" +
+				"--inner--\r\n" +
+				"--outer\r\nContent-Type: application/pdf; name=\"report.pdf\"\r\nContent-Transfer-Encoding: base64\r\n\r\nJVBERi0=\r\n" +
+				"--outer--\r\n",
+			subject:     "Report",
+			text:        "See attached.\n",
+			attachments: []string{"report.pdf:application/pdf:%PDF-"},
+		},
+		{
+			name: "html only",
+			msg: "Subject: Hi\r\nContent-Type: text/html\r\n\r\n" +
+				"<html><head><style>p{}</style></head><body><p>Hello <b>you</b> there</p><ul><li>one</li><li>two</li></ul></body></html>",
+			subject: "Hi",
+			text:    "Hello you there\n\n- one\n- two\n",
+		},
+	}
+	for _, tc := range cases {
+		t.Run(tc.name, func(t *testing.T) {
+			// Act
+			msg, err := Parse([]byte(tc.msg))
+
+			// Assert
+			if err != nil {
+				t.Fatalf("Parse: %v", err)
+			}
+			t.Logf("subject %q text %q attachments %d", msg.Subject, msg.Text, len(msg.Attachments))
+			if msg.Subject != tc.subject {
+				t.Errorf("subject = %q, want %q", msg.Subject, tc.subject)
+			}
+			if msg.Text != tc.text {
+				t.Errorf("text = %q, want %q", msg.Text, tc.text)
+			}
+			var got []string
+			for _, at := range msg.Attachments {
+				got = append(got, at.Filename+":"+at.ContentType+":"+string(at.Data))
+			}
+			if strings.Join(got, ",") != strings.Join(tc.attachments, ",") {
+				t.Errorf("attachments = %v, want %v", got, tc.attachments)
+			}
+		})
+	}
+}
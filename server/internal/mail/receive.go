@@ -0,0 +1,240 @@
+package mail
+
+import (
+	"bufio"
+	"bytes"
+	"context"
+	"errors"
+	"fmt"
+	"log/slog"
+	"net"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+)
+
+const (
+	maxLineLen    = 4096
+	maxRecipients = 10
+	idleTimeout   = 5 * time.Minute
+)
+
+// Server is a minimal SMTP server for mail relays delivering to an MX. It
+// accepts mail for the recipients Accept approves and hands each message
+// to Deliver. There is no authentication or relaying, and no STARTTLS, so
+// messages arrive as they would at any MX without it.
+type Server struct {
+	Hostname string
+	MaxSize  int64
+	// Accept reports whether mail for the address rcpt is taken.
+	Accept func(ctx context.Context, rcpt string) bool
+	// Deliver stores a message for rcpt. An error made by Reject is a
+	// permanent failure; others ask the sender to try again later.
+	Deliver func(ctx context.Context, rcpt string, data []byte) error
+}
+
+// rejection is a Deliver error the sender should not retry.
+type rejection struct{ msg string }
+
+func (r *rejection) Error() string { return r.msg }
+
+// Reject returns a Deliver error that refuses the message for good.
+func Reject(format string, args ...any) error {
+	return &rejection{msg: fmt.Sprintf(format, args...)}
+}
+
+// Serve accepts connections on ln until ctx is cancelled.
+func (s *Server) Serve(ctx context.Context, ln net.Listener) error {
+	go func() {
+		<-ctx.Done()
+		ln.Close()
+	}()
+	var wg sync.WaitGroup
+	defer wg.Wait()
+	for {
+		conn, err := ln.Accept()
+		if err != nil {
+			if ctx.Err() != nil {
+				return nil
+			}
+			var ne net.Error
+			if errors.As(err, &ne) && ne.Timeout() {
+				time.Sleep(100 * time.Millisecond)
+				continue
+			}
+			return err
+		}
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			defer conn.Close()
+			s.serveConn(ctx, conn)
+		}()
+	}
+}
+
+// session is the state of one SMTP conversation.
+type session struct {
+	conn  net.Conn
+	r     *bufio.Reader
+	from  bool
+	rcpts []string
+}
+
+func (c *session) reply(code int, text string) error {
+	c.conn.SetWriteDeadline(time.Now().Add(idleTimeout))
+	_, err := fmt.Fprintf(c.conn, "%d %s\r\n", code, text)
+	return err
+}
+
+// readLine returns the next line without its line ending.
+func (c *session) readLine() (string, error) {
+	c.conn.SetReadDeadline(time.Now().Add(idleTimeout))
+	line, err := c.r.ReadSlice('\n')
+	if errors.Is(err, bufio.ErrBufferFull) {
+		return "", errLineTooLong
+	}
+	if err != nil {
+		return "", err
+	}
+	return strings.TrimRight(string(line), "\r\n"), nil
+}
+
+var errLineTooLong = errors.New("line too long")
+
+func (s *Server) serveConn(ctx context.Context, conn net.Conn) {
+	c := &session{conn: conn, r: bufio.NewReaderSize(conn, maxLineLen)}
+	if c.reply(220, s.Hostname+" ESMTP notesd") != nil {
+		return
+	}
+	for {
+		line, err := c.readLine()
+		if errors.Is(err, errLineTooLong) {
+			c.reply(500, "Line too long")
+			return
+		}
+		if err != nil {
+			return
+		}
+		verb, arg, _ := strings.Cut(line, " ")
+		switch strings.ToUpper(verb) {
+		case "HELO":
+			err = c.reply(250, s.Hostname)
+		case "EHLO":
+			c.conn.SetWriteDeadline(time.Now().Add(idleTimeout))
+			_, err = fmt.Fprintf(conn, "250-%s\r\n250-8BITMIME\r\n250-PIPELINING\r\n250 SIZE %d\r\n", s.Hostname, s.MaxSize)
+		case "MAIL":
+			err = s.mail(c, arg)
+		case "RCPT":
+			err = s.rcpt(ctx, c, arg)
+		case "DATA":
+			err = s.data(ctx, c)
+		case "RSET":
+			c.from, c.rcpts = false, nil
+			err = c.reply(250, "OK")
+		case "NOOP":
+			err = c.reply(250, "OK")
+		case "VRFY":
+			err = c.reply(252, "Cannot verify users")
+		case "QUIT":
+			c.reply(221, "Bye")
+			return
+		default:
+			err = c.reply(502, "Command not implemented")
+		}
+		if err != nil {
+			return
+		}
+	}
+}
+
+func (s *Server) mail(c *session, arg string) error {
+	if !strings.HasPrefix(strings.ToUpper(arg), "FROM:") {
+		return c.reply(501, "Syntax: MAIL FROM:<address>")
+	}
+	if c.from {
+		return c.reply(503, "Sender already given")
+	}
+	for _, param := range strings.Fields(arg)[1:] {
+		key, value, _ := strings.Cut(param, "=")
+		if strings.EqualFold(key, "SIZE") {
+			if size, err := strconv.ParseInt(value, 10, 64); err == nil && size > s.MaxSize {
+				return c.reply(552, "Message too large")
+			}
+		}
+	}
+	c.from, c.rcpts = true, nil
+	return c.reply(250, "OK")
+}
+
+func (s *Server) rcpt(ctx context.Context, c *session, arg string) error {
+	if !c.from {
+		return c.reply(503, "Need MAIL first")
+	}
+	if !strings.HasPrefix(strings.ToUpper(arg), "TO:") {
+		return c.reply(501, "Syntax: RCPT TO:<address>")
+	}
+	addr, _, _ := strings.Cut(strings.TrimSpace(arg[3:]), " ")
+	addr = strings.TrimSuffix(strings.TrimPrefix(addr, "<"), ">")
+	if len(c.rcpts) >= maxRecipients {
+		return c.reply(452, "Too many recipients")
+	}
+	if !s.Accept(ctx, addr) {
+		return c.reply(550, "No such mailbox")
+	}
+	c.rcpts = append(c.rcpts, addr)
+	return c.reply(250, "OK")
+}
+
+func (s *Server) data(ctx context.Context, c *session) error {
+	if len(c.rcpts) == 0 {
+		return c.reply(503, "Need RCPT first")
+	}
+	if err := c.reply(354, "End data with <CR><LF>.<CR><LF>"); err != nil {
+		return err
+	}
+	var msg bytes.Buffer
+	tooLarge, lineStart := false, true
+	for {
+		c.conn.SetReadDeadline(time.Now().Add(idleTimeout))
+		line, err := c.r.ReadSlice('\n')
+		if err != nil && !errors.Is(err, bufio.ErrBufferFull) {
+			return err
+		}
+		// A line longer than the buffer arrives in pieces; only the first
+		// can end the message or be dot-stuffed.
+		atStart := lineStart
+		lineStart = err == nil
+		if atStart && lineStart && (string(line) == ".\r\n" || string(line) == ".\n") {
+			break
+		}
+		if atStart && line[0] == '.' {
+			line = line[1:]
+		}
+		if int64(msg.Len()+len(line)) > s.MaxSize {
+			tooLarge = true
+		}
+		if !tooLarge {
+			msg.Write(line)
+		}
+	}
+	rcpts := c.rcpts
+	c.from, c.rcpts = false, nil
+	if tooLarge {
+		return c.reply(552, "Message too large")
+	}
+
+	for _, rcpt := range rcpts {
+		err := s.Deliver(ctx, rcpt, msg.Bytes())
+		var rej *rejection
+		if errors.As(err, &rej) {
+			return c.reply(554, rej.msg)
+		}
+		if err != nil {
+			slog.Error("deliver mail", "error", err)
+			return c.reply(451, "Temporary failure, try again later")
+		}
+	}
+	return c.reply(250, "OK")
+}
@@ -2,8 +2,11 @@ package model
 
 import (
 	"crypto/rand"
+	"encoding/json"
 	"fmt"
 	"time"
+
+	"github.com/c0dev0id/notesd/server/internal/delta"
 )
 
 // NewID generates a UUID v4 string.
@@ -31,40 +34,156 @@ type User struct {
 	CreatedAt    time.Time `json:"created_at"`
 }
 
+// Note is a note as stored and synced. ContentHash is the hex SHA-256 of
+// Content. In delta sync a note may instead carry ContentPatch, which turns
+// the content with hash BaseHash into the current one; Content is then
+// empty.
 type Note struct {
-	ID               string     `json:"id"`
-	UserID           string     `json:"user_id"`
-	Title            string     `json:"title"`
-	Content          string     `json:"content"`
-	Type             string     `json:"type"`
-	ModifiedAt       time.Time  `json:"modified_at"`
-	ModifiedByDevice string     `json:"modified_by_device"`
-	DeletedAt        *time.Time `json:"deleted_at,omitempty"`
-	CreatedAt        time.Time  `json:"created_at"`
+	ID               string      `json:"id"`
+	UserID           string      `json:"user_id"`
+	Title            string      `json:"title"`
+	Content          string      `json:"content"`
+	ContentHash      string      `json:"content_hash"`
+	BaseHash         string      `json:"base_hash,omitempty"`
+	ContentPatch     delta.Patch `json:"content_patch,omitempty"`
+	Type             string      `json:"type"`
+	Tags             []string    `json:"tags"`
+	SnoozedUntil     *time.Time  `json:"snoozed_until,omitempty"`
+	ModifiedAt       time.Time   `json:"modified_at"`
+	ModifiedByDevice string      `json:"modified_by_device"`
+	DeletedAt        *time.Time  `json:"deleted_at,omitempty"`
+	CreatedAt        time.Time   `json:"created_at"`
+	// WordCount, ReadingMinutes, FirstHeading, LinkCount and Metadata are
+	// derived from Content when the note is saved; values sent by clients
+	// are ignored. Metadata holds the keys of the YAML front matter, a
+	// string for one value and an array for several.
+	WordCount      int            `json:"word_count"`
+	ReadingMinutes int            `json:"reading_minutes"`
+	FirstHeading   string         `json:"first_heading"`
+	LinkCount      int            `json:"link_count"`
+	Metadata       map[string]any `json:"metadata,omitempty"`
 }
 
+// Todo is a todo as stored and synced. An AllDay due date is a calendar
+// day, stored at midnight UTC, rather than a moment; see SetDue. Progress
+// is the percentage of Checklist items done, nil without a checklist; it
+// is computed by the server and ignored when sent.
 type Todo struct {
-	ID               string     `json:"id"`
-	UserID           string     `json:"user_id"`
-	NoteID           *string    `json:"note_id,omitempty"`
-	LineRef          *string    `json:"line_ref,omitempty"`
-	Content          string     `json:"content"`
-	DueDate          *time.Time `json:"due_date,omitempty"`
-	Completed        bool       `json:"completed"`
-	ModifiedAt       time.Time  `json:"modified_at"`
-	ModifiedByDevice string     `json:"modified_by_device"`
-	DeletedAt        *time.Time `json:"deleted_at,omitempty"`
-	CreatedAt        time.Time  `json:"created_at"`
+	ID               string          `json:"id"`
+	UserID           string          `json:"user_id"`
+	NoteID           *string         `json:"note_id,omitempty"`
+	LineRef          *string         `json:"line_ref,omitempty"`
+	Content          string          `json:"content"`
+	DueDate          *time.Time      `json:"due_date,omitempty"`
+	AllDay           bool            `json:"all_day"`
+	ReminderAt       *time.Time      `json:"reminder_at,omitempty"`
+	Completed        bool            `json:"completed"`
+	Tags             []string        `json:"tags"`
+	Checklist        []ChecklistItem `json:"checklist"`
+	Progress         *int            `json:"progress,omitempty"`
+	ModifiedAt       time.Time       `json:"modified_at"`
+	ModifiedByDevice string          `json:"modified_by_device"`
+	DeletedAt        *time.Time      `json:"deleted_at,omitempty"`
+	CreatedAt        time.Time       `json:"created_at"`
+	// FieldTimes records when the fields merged one by one during sync
+	// were last changed. Clients that omit it have every field dated
+	// ModifiedAt.
+	FieldTimes *TodoFieldTimes `json:"field_times,omitempty"`
+}
+
+// TodoFieldTimes holds per-field modification times of a todo. The note
+// time covers both note_id and line_ref.
+type TodoFieldTimes struct {
+	Content   time.Time `json:"content"`
+	DueDate   time.Time `json:"due_date"`
+	Completed time.Time `json:"completed"`
+	NoteID    time.Time `json:"note_id"`
+}
+
+// SetDue sets the due date and whether it is all-day. A nil allDay means
+// all-day if due is midnight UTC, the way due dates were sent before
+// times of day. An all-day due date keeps the calendar day it names in
+// its own offset and is stored at midnight UTC.
+func (t *Todo) SetDue(due *time.Time, allDay *bool) {
+	t.DueDate, t.AllDay = due, false
+	if due == nil {
+		return
+	}
+	utc := due.UTC()
+	if allDay == nil {
+		t.AllDay = utc.Equal(utc.Truncate(24 * time.Hour))
+	} else {
+		t.AllDay = *allDay
+	}
+	if t.AllDay {
+		y, m, d := due.Date()
+		day := time.Date(y, m, d, 0, 0, 0, 0, time.UTC)
+		t.DueDate = &day
+	} else {
+		t.DueDate = &utc
+	}
+}
+
+// DueDay returns the day t is due on in loc, at midnight UTC: the day
+// itself when all-day, the local date of the due time otherwise. It must
+// only be called when t has a due date.
+func (t *Todo) DueDay(loc *time.Location) time.Time {
+	due := *t.DueDate
+	if !t.AllDay {
+		due = due.In(loc)
+	}
+	y, m, d := due.Date()
+	return time.Date(y, m, d, 0, 0, 0, 0, time.UTC)
+}
+
+// NormalizeDue applies SetDue to a whole todo as sent by a client, such as
+// in a sync push or an import, where a missing all_day reads as false and
+// so is taken as unknown.
+func (t *Todo) NormalizeDue() {
+	var allDay *bool
+	if t.AllDay {
+		allDay = new(bool)
+		*allDay = true
+	}
+	t.SetDue(t.DueDate, allDay)
+}
+
+// ChecklistItem is a step of a todo, checked off on its own.
+type ChecklistItem struct {
+	ID   string `json:"id"`
+	Text string `json:"text"`
+	Done bool   `json:"done"`
+}
+
+// SetChecklist replaces the checklist and recomputes Progress.
+func (t *Todo) SetChecklist(items []ChecklistItem) {
+	t.Checklist, t.Progress = items, nil
+	if len(items) == 0 {
+		return
+	}
+	done := 0
+	for _, it := range items {
+		if it.Done {
+			done++
+		}
+	}
+	p := done * 100 / len(items)
+	t.Progress = &p
 }
 
 // RefreshToken tracks issued refresh tokens for rotation and revocation.
 type RefreshToken struct {
-	ID        string    `json:"id"`
-	UserID    string    `json:"user_id"`
-	DeviceID  string    `json:"device_id"`
-	TokenHash string    `json:"-"`
-	ExpiresAt time.Time `json:"expires_at"`
-	CreatedAt time.Time `json:"created_at"`
+	ID        string `json:"id"`
+	UserID    string `json:"user_id"`
+	DeviceID  string `json:"device_id"`
+	TokenHash string `json:"-"`
+	// FamilyID is the ID of the token issued at login; ParentID is the
+	// token this one replaced on refresh, empty for the first.
+	FamilyID  string     `json:"family_id"`
+	ParentID  string     `json:"parent_id,omitempty"`
+	RotatedAt *time.Time `json:"rotated_at,omitempty"`
+	ExpiresAt time.Time  `json:"expires_at"`
+	CreatedAt time.Time  `json:"created_at"`
 }
 
 // API request types
@@ -73,6 +192,8 @@ type RegisterRequest struct {
 	Email       string `json:"email"`
 	Password    string `json:"password"`
 	DisplayName string `json:"display_name"`
+	// InviteCode is required while auth.registration is "invite".
+	InviteCode string `json:"invite_code,omitempty"`
 }
 
 type LoginRequest struct {
@@ -85,35 +206,461 @@ type RefreshRequest struct {
 	RefreshToken string `json:"refresh_token"`
 }
 
+type PasswordResetRequest struct {
+	Email string `json:"email"`
+}
+
+type PasswordResetConfirmRequest struct {
+	Token       string `json:"token"`
+	NewPassword string `json:"new_password"`
+}
+
+// Scopes of personal access tokens. A read token may only make GET and
+// HEAD requests.
+const (
+	ScopeRead  = "read"
+	ScopeWrite = "write"
+)
+
+// AccessToken is a long-lived personal access token for scripts. Token is
+// only set in the response that creates it.
+type AccessToken struct {
+	ID         string     `json:"id"`
+	UserID     string     `json:"-"`
+	Name       string     `json:"name"`
+	Scope      string     `json:"scope"`
+	Token      string     `json:"token,omitempty"`
+	ExpiresAt  *time.Time `json:"expires_at,omitempty"`
+	LastUsedAt *time.Time `json:"last_used_at,omitempty"`
+	CreatedAt  time.Time  `json:"created_at"`
+}
+
+// CreateAccessTokenRequest names a new token. Scope defaults to write; a
+// nil ExpiresAt makes a token that lasts until it is deleted.
+type CreateAccessTokenRequest struct {
+	Name      string     `json:"name"`
+	Scope     string     `json:"scope,omitempty"`
+	ExpiresAt *time.Time `json:"expires_at,omitempty"`
+}
+
+type ChangePasswordRequest struct {
+	CurrentPassword string `json:"current_password"`
+	NewPassword     string `json:"new_password"`
+}
+
+// DeleteAccountRequest confirms account deletion with the password.
+type DeleteAccountRequest struct {
+	Password string `json:"password"`
+}
+
+// AccountExport is everything the server stores about an account. Notes,
+// todos and attachments include deleted ones that are not purged yet;
+// attachment contents are in the GET /export archive.
+type AccountExport struct {
+	Format                string             `json:"format"`
+	Version               int                `json:"version"`
+	ExportedAt            time.Time          `json:"exported_at"`
+	User                  User               `json:"user"`
+	Notes                 []Note             `json:"notes"`
+	Todos                 []Todo             `json:"todos"`
+	Attachments           []Attachment       `json:"attachments"`
+	Tags                  []TagUsage         `json:"tags"`
+	PublicLinks           []PublicLink       `json:"public_links"`
+	AccessTokens          []AccessToken      `json:"access_tokens"`
+	PushSubscriptions     []PushSubscription `json:"push_subscriptions"`
+	ReminderWebhook       string             `json:"reminder_webhook,omitempty"`
+	DigestSettings        DigestSettings     `json:"digest_settings"`
+	AccountSettings       AccountSettings    `json:"account_settings"`
+	CalendarFeedCreatedAt *time.Time         `json:"calendar_feed_created_at,omitempty"`
+}
+
 type CreateNoteRequest struct {
-	Title    string `json:"title"`
-	Content  string `json:"content"`
-	Type     string `json:"type"`
-	DeviceID string `json:"device_id"`
+	Title    string   `json:"title"`
+	Content  string   `json:"content"`
+	Type     string   `json:"type"`
+	Tags     []string `json:"tags,omitempty"`
+	DeviceID string   `json:"device_id"`
 }
 
+// UpdateNoteRequest leaves tags untouched when Tags is omitted; an empty
+// array clears them.
 type UpdateNoteRequest struct {
-	Title    *string `json:"title"`
-	Content  *string `json:"content"`
-	Type     *string `json:"type"`
-	DeviceID string  `json:"device_id"`
+	Title    *string  `json:"title"`
+	Content  *string  `json:"content"`
+	Type     *string  `json:"type"`
+	Tags     []string `json:"tags,omitempty"`
+	DeviceID string   `json:"device_id"`
 }
 
 type CreateTodoRequest struct {
-	NoteID   *string    `json:"note_id,omitempty"`
-	LineRef  *string    `json:"line_ref,omitempty"`
-	Content  string     `json:"content"`
-	DueDate  *time.Time `json:"due_date,omitempty"`
-	DeviceID string     `json:"device_id"`
+	NoteID     *string         `json:"note_id,omitempty"`
+	LineRef    *string         `json:"line_ref,omitempty"`
+	Content    string          `json:"content"`
+	DueDate    *time.Time      `json:"due_date,omitempty"`
+	AllDay     *bool           `json:"all_day,omitempty"`
+	ReminderAt *time.Time      `json:"reminder_at,omitempty"`
+	Tags       []string        `json:"tags,omitempty"`
+	Checklist  []ChecklistItem `json:"checklist,omitempty"`
+	DeviceID   string          `json:"device_id"`
 }
 
 type UpdateTodoRequest struct {
-	Content   *string    `json:"content,omitempty"`
-	DueDate   *time.Time `json:"due_date,omitempty"`
-	Completed *bool      `json:"completed,omitempty"`
-	NoteID    *string    `json:"note_id,omitempty"`
-	LineRef   *string    `json:"line_ref,omitempty"`
-	DeviceID  string     `json:"device_id"`
+	Content    *string         `json:"content,omitempty"`
+	DueDate    *time.Time      `json:"due_date,omitempty"`
+	AllDay     *bool           `json:"all_day,omitempty"`
+	ReminderAt *time.Time      `json:"reminder_at,omitempty"`
+	Completed  *bool           `json:"completed,omitempty"`
+	NoteID     *string         `json:"note_id,omitempty"`
+	LineRef    *string         `json:"line_ref,omitempty"`
+	Tags       []string        `json:"tags,omitempty"`
+	Checklist  []ChecklistItem `json:"checklist,omitempty"`
+	DeviceID   string          `json:"device_id"`
+}
+
+// MoveTodoRequest attaches a todo to NoteID at LineRef; a null or empty
+// NoteID detaches it.
+type MoveTodoRequest struct {
+	NoteID   *string `json:"note_id"`
+	LineRef  *string `json:"line_ref,omitempty"`
+	DeviceID string  `json:"device_id"`
+}
+
+type MergeNotesRequest struct {
+	SourceIDs []string `json:"source_ids"`
+	DeviceID  string   `json:"device_id"`
+}
+
+// ConflictRecord is a sync push that lost LWW resolution. It stays
+// unresolved until the item is modified again.
+type ConflictRecord struct {
+	ItemType  string    `json:"item_type"`
+	ItemID    string    `json:"item_id"`
+	DeviceID  string    `json:"device_id"`
+	CreatedAt time.Time `json:"created_at"`
+}
+
+// AccountSettings are a user's preferences. Timezone, an IANA zone name,
+// decides which date is today for the due-date views, digests and the
+// Telegram bot.
+type AccountSettings struct {
+	Timezone string `json:"timezone"`
+}
+
+// DigestSettings controls the digest email. Frequency is "daily" or
+// "weekly"; weekly digests go out on Weekday, which follows time.Weekday
+// (0 = Sunday). Hour is in the time zone of the account settings.
+type DigestSettings struct {
+	Enabled   bool   `json:"enabled"`
+	Frequency string `json:"frequency"`
+	Weekday   int    `json:"weekday"`
+	Hour      int    `json:"hour"`
+}
+
+// JournalSettings shape new journal entries. TitleFormat and Template may
+// use %Y, %m, %d, %e, %A, %a, %B, %b and %% for the entry's date.
+type JournalSettings struct {
+	TitleFormat string `json:"title_format"`
+	Template    string `json:"template"`
+}
+
+type JournalEntryRequest struct {
+	DeviceID string `json:"device_id"`
+}
+
+// AdminOverview aggregates instance usage for operators.
+type AdminOverview struct {
+	Users         []AdminUserStats `json:"users"`
+	SyncTraffic   []SyncTrafficDay `json:"sync_traffic"`
+	Registrations []DailyCount     `json:"registrations"`
+}
+
+// Usage counts what an account stores. ContentBytes covers note titles and
+// contents and todo texts; deleted items are not counted.
+type Usage struct {
+	Notes           int64 `json:"notes"`
+	Todos           int64 `json:"todos"`
+	ContentBytes    int64 `json:"content_bytes"`
+	AttachmentBytes int64 `json:"attachment_bytes"`
+}
+
+// UsageReport compares an account's usage with the server's quotas. A
+// limit of 0 means unlimited.
+type UsageReport struct {
+	Used   Usage `json:"used"`
+	Limits Usage `json:"limits"`
+}
+
+// Statistics summarises an account for GET /stats. Deleted items are not
+// counted.
+type Statistics struct {
+	NotesByType     map[string]int64 `json:"notes_by_type"`
+	Todos           TodoCounts       `json:"todos"`
+	ContentBytes    int64            `json:"content_bytes"`
+	AttachmentBytes int64            `json:"attachment_bytes"`
+	WordsPerWeek    []WeekWords      `json:"words_per_week"`
+	TopTags         []TagUsage       `json:"top_tags"`
+}
+
+// TodoCounts splits todos by state. Overdue todos are also counted as open.
+type TodoCounts struct {
+	Open      int64 `json:"open"`
+	Completed int64 `json:"completed"`
+	Overdue   int64 `json:"overdue"`
+}
+
+// TodoSummary is the completion record of GET /todos/summary. Days lists
+// the todos completed on each of the last days, oldest first, by date in
+// the requested time zone. CurrentStreak counts the consecutive days with
+// a completion up to today, or up to yesterday while today has none yet.
+// Of the todos completed in Days that have a due date, OnTime were
+// completed on or before it and Late after it; AvgDaysFromDue is their
+// mean distance from the due date in days, negative when early, and null
+// when there are none.
+type TodoSummary struct {
+	Days           []DayCount `json:"days"`
+	Completed      int        `json:"completed"`
+	CurrentStreak  int        `json:"current_streak"`
+	OnTime         int        `json:"on_time"`
+	Late           int        `json:"late"`
+	AvgDaysFromDue *float64   `json:"avg_days_from_due"`
+}
+
+// DayCount is a number of items for one date (YYYY-MM-DD).
+type DayCount struct {
+	Date  string `json:"date"`
+	Count int    `json:"count"`
+}
+
+// WeekWords counts the words in the notes created in the week starting on
+// Monday Week (YYYY-MM-DD, UTC), as they read now.
+type WeekWords struct {
+	Week  string `json:"week"`
+	Words int64  `json:"words"`
+}
+
+// Invite is a registration code created by an admin. MaxUses 0 allows any
+// number of registrations.
+type Invite struct {
+	Code      string     `json:"code"`
+	MaxUses   int        `json:"max_uses"`
+	Uses      int        `json:"uses"`
+	ExpiresAt *time.Time `json:"expires_at,omitempty"`
+	CreatedBy string     `json:"created_by"`
+	CreatedAt time.Time  `json:"created_at"`
+}
+
+// CreateInviteRequest describes a new invite. A nil MaxUses makes a
+// single-use code; a nil ExpiresAt one that never expires.
+type CreateInviteRequest struct {
+	MaxUses   *int       `json:"max_uses,omitempty"`
+	ExpiresAt *time.Time `json:"expires_at,omitempty"`
+}
+
+// AuditEntry records one change to a note or todo. Action is create,
+// update, complete, delete, restore or purge; DeviceID is the device that made the
+// change, empty for server-side maintenance.
+type AuditEntry struct {
+	ID         int64     `json:"id"`
+	UserID     string    `json:"user_id"`
+	EntityType string    `json:"entity_type"`
+	EntityID   string    `json:"entity_id"`
+	Action     string    `json:"action"`
+	DeviceID   string    `json:"device_id"`
+	At         time.Time `json:"at"`
+}
+
+type AuditListResponse struct {
+	Entries []AuditEntry `json:"entries"`
+	Total   int          `json:"total"`
+	Limit   int          `json:"limit"`
+	Offset  int          `json:"offset"`
+}
+
+// ActivityEntry is a change in the user's activity feed, an audit log
+// entry with the note's title or the todo's text, which is empty once the
+// item has been purged.
+type ActivityEntry struct {
+	ID         int64     `json:"id"`
+	EntityType string    `json:"entity_type"`
+	EntityID   string    `json:"entity_id"`
+	Action     string    `json:"action"`
+	Title      string    `json:"title"`
+	DeviceID   string    `json:"device_id"`
+	At         time.Time `json:"at"`
+}
+
+type ActivityResponse struct {
+	Entries []ActivityEntry `json:"entries"`
+	Total   int             `json:"total"`
+	Limit   int             `json:"limit"`
+	Offset  int             `json:"offset"`
+}
+
+// Backup describes a database snapshot in the backup directory.
+type Backup struct {
+	Name      string    `json:"name"`
+	Size      int64     `json:"size"`
+	CreatedAt time.Time `json:"created_at"`
+}
+
+// Maintenance reports a run of the database maintenance job.
+// Checkpointed is false when readers kept the write-ahead log from being
+// emptied, FreedPages counts the pages returned to the file system and
+// Integrity holds the result of PRAGMA integrity_check, ["ok"] for a sound
+// database. Error is set when the run failed before it finished.
+type Maintenance struct {
+	StartedAt    time.Time `json:"started_at"`
+	DurationMs   int64     `json:"duration_ms"`
+	Checkpointed bool      `json:"checkpointed"`
+	FreedPages   int64     `json:"freed_pages"`
+	Integrity    []string  `json:"integrity"`
+	OK           bool      `json:"ok"`
+	Error        string    `json:"error,omitempty"`
+}
+
+// AdminUserStats describes one account. StorageBytes counts note and todo
+// text including soft-deleted items that have not been purged.
+type AdminUserStats struct {
+	ID            string    `json:"id"`
+	Email         string    `json:"email"`
+	DisplayName   string    `json:"display_name"`
+	CreatedAt     time.Time `json:"created_at"`
+	Notes         int       `json:"notes"`
+	Todos         int       `json:"todos"`
+	StorageBytes  int64     `json:"storage_bytes"`
+	ActiveDevices int       `json:"active_devices"`
+}
+
+type SyncTrafficDay struct {
+	Date        string `json:"date"`
+	Pulls       int    `json:"pulls"`
+	Pushes      int    `json:"pushes"`
+	ItemsPulled int    `json:"items_pulled"`
+	ItemsPushed int    `json:"items_pushed"`
+}
+
+type DailyCount struct {
+	Date  string `json:"date"`
+	Count int    `json:"count"`
+}
+
+// VersionInfo describes the running server so clients can adapt to it.
+type VersionInfo struct {
+	Version       string   `json:"version"`
+	Commit        string   `json:"commit,omitempty"`
+	GoVersion     string   `json:"go_version"`
+	SchemaVersion int      `json:"schema_version"`
+	Capabilities  []string `json:"capabilities"`
+}
+
+// NoteTypes lists the types notes may have on this server, the built-in
+// note and todo_list first.
+type NoteTypes struct {
+	Types []string `json:"types"`
+}
+
+type ImportResult struct {
+	Imported int `json:"imported"`
+}
+
+// ImportSummary reports what an archive import created. Skipped counts
+// notes and todos left out because an identical one already existed.
+type ImportSummary struct {
+	Notes       int `json:"notes"`
+	Todos       int `json:"todos"`
+	Attachments int `json:"attachments"`
+	Skipped     int `json:"skipped"`
+}
+
+// CalendarDay holds the todos due on one UTC date (YYYY-MM-DD).
+type CalendarDay struct {
+	Date  string `json:"date"`
+	Todos []Todo `json:"todos"`
+}
+
+type CalendarResponse struct {
+	From string        `json:"from"`
+	To   string        `json:"to"`
+	Days []CalendarDay `json:"days"`
+}
+
+// CalendarFeed describes a user's iCalendar feed. URL carries the secret
+// feed token and is only returned when the token is generated.
+type CalendarFeed struct {
+	URL       string    `json:"url,omitempty"`
+	CreatedAt time.Time `json:"created_at"`
+}
+
+// NoteFeed describes one of a user's Atom feeds of recently modified
+// notes: all of them, or only published ones when Published is set. URL
+// carries the secret feed token and is only returned when it is generated.
+type NoteFeed struct {
+	Published bool      `json:"published"`
+	URL       string    `json:"url,omitempty"`
+	CreatedAt time.Time `json:"created_at"`
+}
+
+// NoteFeedRequest is the optional body of POST /notes/feed.
+type NoteFeedRequest struct {
+	Published bool `json:"published"`
+}
+
+// MailInAddress describes a user's secret mail-in address. Address is only
+// returned when it is generated.
+type MailInAddress struct {
+	Address   string    `json:"address,omitempty"`
+	CreatedAt time.Time `json:"created_at"`
+}
+
+// TelegramLink is a one-time code to send to the bot as "/start <code>".
+type TelegramLink struct {
+	Code      string    `json:"code"`
+	ExpiresAt time.Time `json:"expires_at"`
+}
+
+// TelegramChat describes the user's linked Telegram chat.
+type TelegramChat struct {
+	LinkedAt time.Time `json:"linked_at"`
+}
+
+// CreateBookmarkRequest saves a web page as a bookmark note. Title, when
+// set, replaces the title of the page; Archive keeps its readable text in
+// the note as well.
+type CreateBookmarkRequest struct {
+	URL      string   `json:"url"`
+	Title    string   `json:"title,omitempty"`
+	Tags     []string `json:"tags,omitempty"`
+	Archive  bool     `json:"archive,omitempty"`
+	DeviceID string   `json:"device_id"`
+}
+
+// LinkPreview is what a web page says about itself, for showing a link in
+// a note. URL is where the page was found after redirects.
+type LinkPreview struct {
+	URL         string `json:"url"`
+	Title       string `json:"title,omitempty"`
+	Description string `json:"description,omitempty"`
+	Image       string `json:"image,omitempty"`
+	Favicon     string `json:"favicon,omitempty"`
+}
+
+type SnoozeNoteRequest struct {
+	Until    time.Time `json:"until"`
+	DeviceID string    `json:"device_id"`
+}
+
+// PublishNoteRequest is the optional body of POST /notes/{id}/publish. A
+// nil ExpiresAt publishes until the link is revoked.
+type PublishNoteRequest struct {
+	ExpiresAt *time.Time `json:"expires_at"`
+}
+
+type RenameTagRequest struct {
+	Name string `json:"name"`
+}
+
+type MergeTagRequest struct {
+	Into string `json:"into"`
 }
 
 type SyncPushRequest struct {
@@ -122,6 +669,20 @@ type SyncPushRequest struct {
 	DeviceID string `json:"device_id"`
 }
 
+// BulkItem is one action of a bulk request. Tags is used by tag and
+// untag, NoteID by moving a todo (null or "" detaches it).
+type BulkItem struct {
+	ID     string   `json:"id"`
+	Action string   `json:"action"`
+	Tags   []string `json:"tags,omitempty"`
+	NoteID *string  `json:"note_id,omitempty"`
+}
+
+type BulkRequest struct {
+	Items    []BulkItem `json:"items"`
+	DeviceID string     `json:"device_id"`
+}
+
 // API response types
 
 type AuthResponse struct {
@@ -137,6 +698,26 @@ type NoteListResponse struct {
 	Offset int    `json:"offset"`
 }
 
+// NoteRevision is a commit of the git history that changed a note. Message
+// is the commit subject, such as "Update Shopping".
+type NoteRevision struct {
+	Rev     string    `json:"rev"`
+	Author  string    `json:"author"`
+	At      time.Time `json:"at"`
+	Message string    `json:"message"`
+}
+
+type NoteHistoryResponse struct {
+	Revisions []NoteRevision `json:"revisions"`
+}
+
+// NoteDiff is the unified diff of the Markdown file of a note, front
+// matter included, made by one revision.
+type NoteDiff struct {
+	Revision NoteRevision `json:"revision"`
+	Diff     string       `json:"diff"`
+}
+
 type TodoListResponse struct {
 	Todos  []Todo `json:"todos"`
 	Total  int    `json:"total"`
@@ -144,14 +725,259 @@ type TodoListResponse struct {
 	Offset int    `json:"offset"`
 }
 
+// SearchResult is one match of GET /search. Type is "note" or "todo";
+// Title is a note's title or a todo's content, and Snippet the text around
+// the first match. NoteID, Completed, DueDate and AllDay are set for todos
+// only.
+type SearchResult struct {
+	Type       string     `json:"type"`
+	ID         string     `json:"id"`
+	Title      string     `json:"title"`
+	Snippet    string     `json:"snippet"`
+	NoteID     *string    `json:"note_id,omitempty"`
+	Completed  *bool      `json:"completed,omitempty"`
+	DueDate    *time.Time `json:"due_date,omitempty"`
+	AllDay     bool       `json:"all_day,omitempty"`
+	ModifiedAt time.Time  `json:"modified_at"`
+}
+
+type SearchResponse struct {
+	Results []SearchResult `json:"results"`
+	Total   int            `json:"total"`
+	Limit   int            `json:"limit"`
+	Offset  int            `json:"offset"`
+}
+
+// SearchTerm is one term of a search query. Field is "" for a bare value;
+// Time is set for before: and after:.
+type SearchTerm struct {
+	Field   string     `json:"field,omitempty"`
+	Value   string     `json:"value"`
+	Negated bool       `json:"negated,omitempty"`
+	Time    *time.Time `json:"time,omitempty"`
+}
+
+// SearchQueryCheck is the result of GET /search/validate. Terms is the
+// parsed query when it is valid, Error why not otherwise.
+type SearchQueryCheck struct {
+	Valid   bool         `json:"valid"`
+	Error   string       `json:"error,omitempty"`
+	Terms   []SearchTerm `json:"terms,omitempty"`
+	Grammar string       `json:"grammar"`
+}
+
+// BulkResult reports on one item of a bulk request; Error is empty when
+// the action was valid.
+type BulkResult struct {
+	ID     string `json:"id"`
+	Action string `json:"action"`
+	Error  string `json:"error,omitempty"`
+}
+
+// BulkResponse answers a bulk request. Applied is false, with Error set,
+// when any item failed; then nothing was changed.
+type BulkResponse struct {
+	Applied bool         `json:"applied"`
+	Error   string       `json:"error,omitempty"`
+	Results []BulkResult `json:"results"`
+}
+
+// DuplicateGroup is a set of notes that look like copies of each other.
+// Reasons lists what matched: "title" and/or "content".
+type DuplicateGroup struct {
+	Reasons []string `json:"reasons"`
+	Notes   []Note   `json:"notes"`
+}
+
+type DuplicatesResponse struct {
+	Groups []DuplicateGroup `json:"groups"`
+}
+
+// ExportManifest is manifest.json of a GET /export archive. Note content
+// lives in the Markdown files named by File, todos in todos.json.
+type ExportManifest struct {
+	Format      string               `json:"format"`
+	Version     int                  `json:"version"`
+	ExportedAt  time.Time            `json:"exported_at"`
+	Notes       []ExportedNote       `json:"notes"`
+	Attachments []ExportedAttachment `json:"attachments"`
+}
+
+type ExportedNote struct {
+	ID           string     `json:"id"`
+	Title        string     `json:"title"`
+	Type         string     `json:"type"`
+	Tags         []string   `json:"tags"`
+	ContentHash  string     `json:"content_hash"`
+	SnoozedUntil *time.Time `json:"snoozed_until,omitempty"`
+	ModifiedAt   time.Time  `json:"modified_at"`
+	CreatedAt    time.Time  `json:"created_at"`
+	File         string     `json:"file"`
+}
+
+type ExportedAttachment struct {
+	Attachment
+	File string `json:"file"`
+}
+
+// Attachment is the metadata of a file attached to a note. The content is
+// served by GET /api/v1/attachments/{id}.
+type Attachment struct {
+	ID          string     `json:"id"`
+	UserID      string     `json:"user_id"`
+	NoteID      string     `json:"note_id"`
+	Filename    string     `json:"filename"`
+	ContentType string     `json:"content_type"`
+	Size        int64      `json:"size"`
+	SHA256      string     `json:"sha256"`
+	ModifiedAt  time.Time  `json:"modified_at"`
+	DeletedAt   *time.Time `json:"deleted_at,omitempty"`
+	CreatedAt   time.Time  `json:"created_at"`
+}
+
+// PublicLink makes one note readable without auth at /p/{slug}.
+type PublicLink struct {
+	Slug      string     `json:"slug"`
+	NoteID    string     `json:"note_id"`
+	URL       string     `json:"url"`
+	ExpiresAt *time.Time `json:"expires_at,omitempty"`
+	CreatedAt time.Time  `json:"created_at"`
+}
+
+// PublicNote is the JSON form of a published note.
+type PublicNote struct {
+	Title      string    `json:"title"`
+	Content    string    `json:"content"`
+	Type       string    `json:"type"`
+	ModifiedAt time.Time `json:"modified_at"`
+}
+
+// PushKeys are a push subscription's encryption keys, base64url.
+type PushKeys struct {
+	P256dh string `json:"p256dh"`
+	Auth   string `json:"auth"`
+}
+
+// PushSubscriptionRequest registers a device for Web Push. Endpoint,
+// ExpirationTime and Keys are PushSubscription.toJSON() from the browser.
+type PushSubscriptionRequest struct {
+	Endpoint       string   `json:"endpoint"`
+	ExpirationTime *int64   `json:"expirationTime,omitempty"`
+	Keys           PushKeys `json:"keys"`
+	DeviceID       string   `json:"device_id"`
+}
+
+// PushSubscription is a registered device. Keys are never returned.
+type PushSubscription struct {
+	ID        string    `json:"id"`
+	UserID    string    `json:"user_id"`
+	DeviceID  string    `json:"device_id"`
+	Endpoint  string    `json:"endpoint"`
+	Keys      PushKeys  `json:"-"`
+	CreatedAt time.Time `json:"created_at"`
+}
+
+type ReminderWebhook struct {
+	URL string `json:"url"`
+}
+
+// Reminder is the payload delivered by Web Push and webhooks. Type "todo"
+// is a todo reminder, "note" a note whose snooze ended.
+type Reminder struct {
+	Type   string    `json:"type"`
+	ID     string    `json:"id"`
+	Title  string    `json:"title"`
+	FireAt time.Time `json:"fire_at"`
+}
+
+// TagUsage is a tag with the number of live notes and todos carrying it.
+type TagUsage struct {
+	Name  string `json:"name"`
+	Notes int    `json:"notes"`
+	Todos int    `json:"todos"`
+}
+
+// GraphResponse is the note-link graph: one node per note, one edge per
+// resolved [[wiki link]] from Source to Target.
+type GraphResponse struct {
+	Nodes []GraphNode `json:"nodes"`
+	Edges []GraphEdge `json:"edges"`
+}
+
+type GraphNode struct {
+	ID    string `json:"id"`
+	Title string `json:"title"`
+	Type  string `json:"type"`
+}
+
+type GraphEdge struct {
+	Source string `json:"source"`
+	Target string `json:"target"`
+}
+
+// SyncChangesResponse is one page of changes since the requested time.
+// Purged items were permanently deleted and should be dropped. Reset is set
+// on the first page when tombstones the client may not have seen were
+// collected; the pull then holds every item and the client should drop
+// cached items missing from it. While HasMore is set, NextCursor fetches
+// the next page and SyncTimestamp is 0; the last page carries the time to
+// pull from next.
 type SyncChangesResponse struct {
-	Notes         []Note `json:"notes"`
-	Todos         []Todo `json:"todos"`
-	SyncTimestamp int64  `json:"sync_timestamp"`
+	Notes         []Note       `json:"notes"`
+	Todos         []Todo       `json:"todos"`
+	Attachments   []Attachment `json:"attachments"`
+	Purged        []PurgedItem `json:"purged"`
+	Reset         bool         `json:"reset,omitempty"`
+	HasMore       bool         `json:"has_more"`
+	NextCursor    string       `json:"next_cursor,omitempty"`
+	SyncTimestamp int64        `json:"sync_timestamp"`
+}
+
+// ManifestEntry is the version of one note or todo in a sync manifest.
+// Todos carry no content hash; their modified_at covers every field.
+type ManifestEntry struct {
+	ID          string    `json:"id"`
+	ModifiedAt  time.Time `json:"modified_at"`
+	ContentHash string    `json:"content_hash,omitempty"`
+	Deleted     bool      `json:"deleted,omitempty"`
+}
+
+// SyncManifest lists every note and todo of a user, tombstones included,
+// sorted by ID. Items changed after SyncTimestamp are not reflected.
+type SyncManifest struct {
+	Notes         []ManifestEntry `json:"notes"`
+	Todos         []ManifestEntry `json:"todos"`
+	SyncTimestamp int64           `json:"sync_timestamp"`
+}
+
+// SyncFetchRequest asks for the full notes and todos with these IDs.
+type SyncFetchRequest struct {
+	NoteIDs []string `json:"note_ids"`
+	TodoIDs []string `json:"todo_ids"`
+}
+
+// SyncFetchResponse holds the requested items that exist, tombstones
+// included; purged ones are left out.
+type SyncFetchResponse struct {
+	Notes []Note `json:"notes"`
+	Todos []Todo `json:"todos"`
 }
 
+// PurgedItem identifies a permanently deleted note, todo or attachment.
+type PurgedItem struct {
+	Type string `json:"type"`
+	ID   string `json:"id"`
+}
+
+// SyncPushResponse reports the outcome of a push. NeedFull lists notes
+// pushed as patches against content the server no longer has; they were
+// not applied and must be pushed again in full. Merged holds accepted todos
+// combined with fields changed later on the server, and the todos of
+// pushed note deletions changed by notes.delete_todos, as now stored.
 type SyncPushResponse struct {
 	Conflicts []SyncConflict `json:"conflicts,omitempty"`
+	NeedFull  []string       `json:"need_full,omitempty"`
+	Merged    []Todo         `json:"merged,omitempty"`
 	Accepted  int            `json:"accepted"`
 	Timestamp int64          `json:"sync_timestamp"`
 }
@@ -163,6 +989,81 @@ type SyncConflict struct {
 	ServerTodo *Todo  `json:"server_todo,omitempty"`
 }
 
+// ChangeEvent is sent over GET /api/v1/sync/ws and /sync/events when a
+// note or todo changes. Type "resync" means many items changed at once (or
+// events were missed) and the client should pull /api/v1/sync/changes
+// instead. The event stream leaves out Note and Todo.
+type ChangeEvent struct {
+	Type       string     `json:"type"` // "note", "todo" or "resync"
+	ID         string     `json:"id,omitempty"`
+	Deleted    bool       `json:"deleted,omitempty"`
+	DeviceID   string     `json:"device_id,omitempty"`
+	ModifiedAt *time.Time `json:"modified_at,omitempty"`
+	Note       *Note      `json:"note,omitempty"`
+	Todo       *Todo      `json:"todo,omitempty"`
+	// Seq orders events within one server process.
+	Seq uint64 `json:"-"`
+}
+
+// Webhook events.
+const (
+	EventNoteCreated   = "note.created"
+	EventNoteUpdated   = "note.updated"
+	EventNoteDeleted   = "note.deleted"
+	EventTodoCreated   = "todo.created"
+	EventTodoUpdated   = "todo.updated"
+	EventTodoCompleted = "todo.completed"
+	EventTodoDeleted   = "todo.deleted"
+)
+
+// WebhookEvents lists every event a webhook can subscribe to.
+var WebhookEvents = []string{
+	EventNoteCreated, EventNoteUpdated, EventNoteDeleted,
+	EventTodoCreated, EventTodoUpdated, EventTodoCompleted, EventTodoDeleted,
+}
+
+// Webhook receives signed POSTs for the listed events; no events means
+// all of them. Secret is only returned when the webhook is created.
+type Webhook struct {
+	ID        string    `json:"id"`
+	URL       string    `json:"url"`
+	Events    []string  `json:"events"`
+	Secret    string    `json:"secret,omitempty"`
+	CreatedAt time.Time `json:"created_at"`
+}
+
+type CreateWebhookRequest struct {
+	URL    string   `json:"url"`
+	Events []string `json:"events"`
+}
+
+// WebhookPayload is the body of a webhook delivery. Deletions carry only
+// the ID.
+type WebhookPayload struct {
+	Event    string    `json:"event"`
+	ID       string    `json:"id"`
+	DeviceID string    `json:"device_id,omitempty"`
+	Note     *Note     `json:"note,omitempty"`
+	Todo     *Todo     `json:"todo,omitempty"`
+	At       time.Time `json:"at"`
+}
+
+// WebhookDelivery is one queued or attempted delivery. Status is pending
+// until it succeeds (delivered) or runs out of attempts (failed).
+type WebhookDelivery struct {
+	ID            string          `json:"id"`
+	WebhookID     string          `json:"webhook_id"`
+	Event         string          `json:"event"`
+	Payload       json.RawMessage `json:"payload"`
+	Status        string          `json:"status"`
+	Attempts      int             `json:"attempts"`
+	ResponseCode  int             `json:"response_code,omitempty"`
+	Error         string          `json:"error,omitempty"`
+	NextAttemptAt *time.Time      `json:"next_attempt_at,omitempty"`
+	LastAttemptAt *time.Time      `json:"last_attempt_at,omitempty"`
+	CreatedAt     time.Time       `json:"created_at"`
+}
+
 type ErrorResponse struct {
 	Error string `json:"error"`
 }
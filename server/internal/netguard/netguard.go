@@ -0,0 +1,122 @@
+// Package netguard keeps requests to URLs that users give, such as
+// webhooks and push endpoints, off the server's own networks. Its clients
+// only connect to public addresses; the check is made on every
+// connection, after DNS, so redirects and rebinding names are covered
+// too.
+package netguard
+
+import (
+	"errors"
+	"fmt"
+	"net"
+	"net/http"
+	"net/netip"
+	"net/url"
+	"strings"
+	"syscall"
+	"time"
+)
+
+const maxRedirects = 5
+
+// ErrBlocked is returned for a URL that leads to an address that is not
+// public, such as loopback, private and link-local ones.
+var ErrBlocked = errors.New("address is not public")
+
+// Client returns an HTTP client that only connects to public addresses,
+// on redirects too, and fails with ErrBlocked otherwise.
+func Client(timeout time.Duration) *http.Client {
+	return NewClient(timeout, Public, nil)
+}
+
+// NewClient returns a client that connects only to the addresses allowed
+// admits and follows http and https redirects that checkRedirect, if
+// set, admits.
+func NewClient(timeout time.Duration, allowed func(netip.Addr) bool, checkRedirect func(*url.URL) error) *http.Client {
+	dialer := &net.Dialer{
+		Timeout: timeout,
+		Control: func(network, address string, _ syscall.RawConn) error {
+			ap, err := netip.ParseAddrPort(address)
+			if err != nil {
+				return err
+			}
+			if !allowed(ap.Addr()) {
+				return fmt.Errorf("%w: %s", ErrBlocked, ap.Addr().Unmap())
+			}
+			return nil
+		},
+	}
+	return &http.Client{
+		Timeout: timeout,
+		// No proxy: the address check would see the proxy, not the
+		// host asked for.
+		Transport: &http.Transport{
+			DialContext:           dialer.DialContext,
+			TLSHandshakeTimeout:   timeout,
+			ResponseHeaderTimeout: timeout,
+			MaxIdleConns:          10,
+			IdleConnTimeout:       30 * time.Second,
+		},
+		CheckRedirect: func(req *http.Request, via []*http.Request) error {
+			if len(via) >= maxRedirects {
+				return errors.New("too many redirects")
+			}
+			if req.URL.Scheme != "http" && req.URL.Scheme != "https" {
+				return fmt.Errorf("redirect to %s URL", req.URL.Scheme)
+			}
+			if checkRedirect != nil {
+				return checkRedirect(req.URL)
+			}
+			return nil
+		},
+	}
+}
+
+// PublicHost reports whether the host of u may be public: it is not
+// localhost nor an address that is not public. Names are only resolved
+// when connecting, where the clients refuse the same addresses.
+func PublicHost(u *url.URL) bool {
+	host := strings.TrimSuffix(strings.ToLower(u.Hostname()), ".")
+	if host == "localhost" || strings.HasSuffix(host, ".localhost") {
+		return false
+	}
+	addr, err := netip.ParseAddr(host)
+	return err != nil || Public(addr)
+}
+
+// reserved lists ranges that Public refuses beyond those netip names:
+// "this network", shared address space, protocol assignments,
+// documentation, benchmarking, the former class E, and IPv6 prefixes that
+// embed IPv4 addresses or are deprecated site-local ones.
+var reserved = []netip.Prefix{
+	netip.MustParsePrefix("0.0.0.0/8"),
+	netip.MustParsePrefix("100.64.0.0/10"),
+	netip.MustParsePrefix("192.0.0.0/24"),
+	netip.MustParsePrefix("192.0.2.0/24"),
+	netip.MustParsePrefix("198.18.0.0/15"),
+	netip.MustParsePrefix("198.51.100.0/24"),
+	netip.MustParsePrefix("203.0.113.0/24"),
+	netip.MustParsePrefix("240.0.0.0/4"),
+	netip.MustParsePrefix("64:ff9b::/96"),
+	netip.MustParsePrefix("64:ff9b:1::/48"),
+	netip.MustParsePrefix("100::/64"),
+	netip.MustParsePrefix("2001::/32"),
+	netip.MustParsePrefix("2001:db8::/32"),
+	netip.MustParsePrefix("2002::/16"),
+	netip.MustParsePrefix("fec0::/10"),
+}
+
+// Public reports whether addr is a public unicast address.
+func Public(addr netip.Addr) bool {
+	addr = addr.Unmap()
+	if !addr.IsValid() || addr.IsUnspecified() || addr.IsLoopback() || addr.IsPrivate() ||
+		addr.IsLinkLocalUnicast() || addr.IsMulticast() || addr.IsInterfaceLocalMulticast() {
+		return false
+	}
+	for _, p := range reserved {
+		if p.Contains(addr) {
+			return false
+		}
+	}
+	return true
+}
@@ -0,0 +1,98 @@
+package netguard
+
+import (
+	"errors"
+	"net/http"
+	"net/http/httptest"
+	"net/netip"
+	"net/url"
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestPublic(t *testing.T) {
+	cases := []struct {
+		addr string
+		want bool
+	}{
+		{"93.184.216.34", true},
+		{"2606:4700::6810:85e5", true},
+		{"127.0.0.1", false},
+		{"::1", false},
+		{"10.1.2.3", false},
+		{"172.16.0.1", false},
+		{"192.168.1.1", false},
+		{"169.254.169.254", false},
+		{"100.64.0.1", false},
+		{"0.0.0.0", false},
+		{"255.255.255.255", false},
+		{"fd00::1", false},
+		{"fe80::1", false},
+		{"::ffff:127.0.0.1", false},
+		{"64:ff9b::a00:1", false},
+		{"224.0.0.1", false},
+	}
+	for _, c := range cases {
+		// Act
+		got := Public(netip.MustParseAddr(c.addr))
+
+		// Assert
+		t.Logf("%s: public=%v", c.addr, got)
+		if got != c.want {
+			t.Errorf("Public(%s) = %v, want %v", c.addr, got, c.want)
+		}
+	}
+}
+
+func TestPublicHost(t *testing.T) {
+	cases := []struct {
+		url  string
+		want bool
+	}{
+		{"https://example.com/hook", true},
+		{"https://93.184.216.34/hook", true},
+		{"http://localhost:8080/hook", false},
+		{"http://api.LOCALHOST./hook", false},
+		{"http://127.0.0.1:6379/", false},
+		{"http://[::1]/hook", false},
+		{"http://169.254.169.254/latest/meta-data/", false},
+	}
+	for _, c := range cases {
+		// Act
+		u, _ := url.Parse(c.url)
+		got := PublicHost(u)
+
+		// Assert
+		t.Logf("%s: public=%v", c.url, got)
+		if got != c.want {
+			t.Errorf("PublicHost(%s) = %v, want %v", c.url, got, c.want)
+		}
+	}
+}
+
+func TestClientBlocksPrivateAddresses(t *testing.T) {
+	// Arrange: a server on loopback, reached by address and by name
+	hits := 0
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		hits++
+	}))
+	defer srv.Close()
+
+	for _, u := range []string{
+		srv.URL,
+		strings.Replace(srv.URL, "127.0.0.1", "localhost", 1),
+	} {
+		// Act
+		_, err := Client(time.Second).Get(u)
+
+		// Assert
+		t.Logf("%s: %v", u, err)
+		if !errors.Is(err, ErrBlocked) {
+			t.Errorf("%s: got %v, want ErrBlocked", u, err)
+		}
+	}
+	if hits != 0 {
+		t.Errorf("the loopback server was reached %d times", hits)
+	}
+}
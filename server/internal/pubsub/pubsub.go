@@ -0,0 +1,130 @@
+// Package pubsub relays messages between notesd servers through a message
+// broker, so that what one server publishes reaches the subscribers of all.
+// Local is an in-process broker; Redis speaks the Redis protocol and also
+// runs other commands, such as counting shared rate limits.
+package pubsub
+
+import (
+	"context"
+	"errors"
+	"sync"
+)
+
+// localBuffer is how many messages may queue for one Local subscriber.
+const localBuffer = 256
+
+// ErrSlow ends a subscription that fell too far behind.
+var ErrSlow = errors.New("pubsub: subscriber too slow")
+
+// Broker delivers each message published on a channel to every current
+// subscriber of that channel, including those of the publisher.
+type Broker interface {
+	// Publish sends msg on channel.
+	Publish(ctx context.Context, channel string, msg []byte) error
+	// Subscribe returns once the subscription is active. It ends when ctx
+	// is done, Close is called or the connection to the broker fails.
+	Subscribe(ctx context.Context, channel string) (*Subscription, error)
+	// Close releases the connections used for publishing.
+	Close() error
+}
+
+// Subscription receives the messages of one channel on C, which is closed
+// when the subscription ends.
+type Subscription struct {
+	C      <-chan []byte
+	err    error // set before C is closed
+	cancel func()
+}
+
+// Err returns why the subscription ended. It is valid once C is closed.
+func (s *Subscription) Err() error {
+	return s.err
+}
+
+// Close ends the subscription.
+func (s *Subscription) Close() {
+	s.cancel()
+}
+
+// Open returns the broker at rawURL, which is redis://[[user]:password@]
+// host[:port] or rediss:// for Redis over TLS.
+func Open(rawURL string) (Broker, error) {
+	r, err := OpenRedis(rawURL)
+	if err != nil {
+		return nil, err
+	}
+	return r, nil
+}
+
+// Local is a broker within one process.
+type Local struct {
+	mu   sync.Mutex
+	subs map[string]map[chan []byte]*Subscription
+}
+
+func NewLocal() *Local {
+	return &Local{subs: make(map[string]map[chan []byte]*Subscription)}
+}
+
+// Publish hands msg to the subscribers of channel without blocking; one
+// that has fallen behind is dropped with ErrSlow.
+func (l *Local) Publish(_ context.Context, channel string, msg []byte) error {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	for c := range l.subs[channel] {
+		select {
+		case c <- msg:
+		default:
+			l.drop(channel, c, ErrSlow)
+		}
+	}
+	return nil
+}
+
+func (l *Local) Subscribe(ctx context.Context, channel string) (*Subscription, error) {
+	c := make(chan []byte, localBuffer)
+	s := &Subscription{C: c}
+	l.mu.Lock()
+	if l.subs[channel] == nil {
+		l.subs[channel] = make(map[chan []byte]*Subscription)
+	}
+	l.subs[channel][c] = s
+	l.mu.Unlock()
+
+	end := func(err error) {
+		l.mu.Lock()
+		defer l.mu.Unlock()
+		l.drop(channel, c, err)
+	}
+	stop := context.AfterFunc(ctx, func() { end(ctx.Err()) })
+	s.cancel = func() {
+		stop()
+		end(context.Canceled)
+	}
+	return s, nil
+}
+
+// drop ends the subscription receiving on c; l.mu must be held.
+func (l *Local) drop(channel string, c chan []byte, err error) {
+	s, ok := l.subs[channel][c]
+	if !ok {
+		return
+	}
+	delete(l.subs[channel], c)
+	if len(l.subs[channel]) == 0 {
+		delete(l.subs, channel)
+	}
+	s.err = err
+	close(c)
+}
+
+// Subscribers returns the number of subscriptions to channel.
+func (l *Local) Subscribers(channel string) int {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	return len(l.subs[channel])
+}
+
+func (l *Local) Close() error {
+	return nil
+}
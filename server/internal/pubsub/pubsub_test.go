@@ -0,0 +1,259 @@
+package pubsub
+
+import (
+	"bufio"
+	"context"
+	"errors"
+	"fmt"
+	"net"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+)
+
+func TestLocal(t *testing.T) {
+	// Arrange
+	l := NewLocal()
+	a, _ := l.Subscribe(context.Background(), "changes")
+	b, _ := l.Subscribe(context.Background(), "changes")
+	other, _ := l.Subscribe(context.Background(), "other")
+	defer other.Close()
+
+	// Act
+	l.Publish(context.Background(), "changes", []byte("one"))
+	a.Close()
+	l.Publish(context.Background(), "changes", []byte("two"))
+	b.Close()
+
+	// Assert
+	var gotA, gotB []string
+	for msg := range a.C {
+		gotA = append(gotA, string(msg))
+	}
+	for msg := range b.C {
+		gotB = append(gotB, string(msg))
+	}
+	t.Logf("a: %v (%v), b: %v, other: %d queued", gotA, a.Err(), gotB, len(other.C))
+	if strings.Join(gotA, ",") != "one" || strings.Join(gotB, ",") != "one,two" {
+		t.Errorf("got a %v and b %v, want [one] and [one two]", gotA, gotB)
+	}
+	if len(other.C) != 0 || l.Subscribers("changes") != 0 {
+		t.Error("messages leaked to another channel or subscriptions were kept")
+	}
+}
+
+func TestLocalDropsSlowSubscriber(t *testing.T) {
+	// Arrange
+	l := NewLocal()
+	s, _ := l.Subscribe(context.Background(), "changes")
+
+	// Act
+	for range localBuffer + 1 {
+		l.Publish(context.Background(), "changes", []byte("x"))
+	}
+
+	// Assert
+	n := 0
+	for range s.C {
+		n++
+	}
+	t.Logf("received %d messages, then %v", n, s.Err())
+	if n != localBuffer || !errors.Is(s.Err(), ErrSlow) {
+		t.Errorf("got %d messages and %v, want %d and ErrSlow", n, s.Err(), localBuffer)
+	}
+}
+
+// fakeRedis serves enough of the Redis protocol for the broker: AUTH,
+// PUBLISH, SUBSCRIBE and PING, and INCR for commands run with Do.
+type fakeRedis struct {
+	ln       net.Listener
+	password string
+	mu       sync.Mutex
+	subs     map[string][]net.Conn
+	conns    []net.Conn
+	counts   map[string]int
+}
+
+func startFakeRedis(t *testing.T, password string) *fakeRedis {
+	t.Helper()
+	ln, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatalf("listen: %v", err)
+	}
+	f := &fakeRedis{ln: ln, password: password, subs: make(map[string][]net.Conn), counts: make(map[string]int)}
+	t.Cleanup(func() { ln.Close(); f.dropAll() })
+	go func() {
+		for {
+			nc, err := ln.Accept()
+			if err != nil {
+				return
+			}
+			f.mu.Lock()
+			f.conns = append(f.conns, nc)
+			f.mu.Unlock()
+			go f.serve(nc)
+		}
+	}()
+	return f
+}
+
+func (f *fakeRedis) serve(nc net.Conn) {
+	defer nc.Close()
+	c := &redisConn{nc: nc, br: bufio.NewReader(nc)}
+	authed := f.password == ""
+	for {
+		req, err := c.read()
+		if err != nil {
+			return
+		}
+		args, _ := req.([]any)
+		if len(args) == 0 {
+			return
+		}
+		cmd := args[0].(string)
+		f.mu.Lock()
+		switch {
+		case cmd == "AUTH":
+			authed = args[len(args)-1] == f.password
+			if authed {
+				fmt.Fprint(nc, "+OK\r\n")
+			} else {
+				fmt.Fprint(nc, "-WRONGPASS invalid password\r\n")
+			}
+		case !authed:
+			fmt.Fprint(nc, "-NOAUTH Authentication required.\r\n")
+		case cmd == "SUBSCRIBE":
+			ch := args[1].(string)
+			f.subs[ch] = append(f.subs[ch], nc)
+			fmt.Fprintf(nc, "*3\r\n$9\r\nsubscribe\r\n$%d\r\n%s\r\n:1\r\n", len(ch), ch)
+		case cmd == "PUBLISH":
+			ch, msg := args[1].(string), args[2].(string)
+			for _, sub := range f.subs[ch] {
+				fmt.Fprintf(sub, "*3\r\n$7\r\nmessage\r\n$%d\r\n%s\r\n$%d\r\n%s\r\n", len(ch), ch, len(msg), msg)
+			}
+			fmt.Fprintf(nc, ":%d\r\n", len(f.subs[ch]))
+		case cmd == "PING":
+			fmt.Fprint(nc, "*2\r\n$4\r\npong\r\n$0\r\n\r\n")
+		case cmd == "INCR":
+			f.counts[args[1].(string)]++
+			fmt.Fprintf(nc, ":%d\r\n", f.counts[args[1].(string)])
+		default:
+			fmt.Fprintf(nc, "-ERR unknown command '%s'\r\n", cmd)
+		}
+		f.mu.Unlock()
+	}
+}
+
+// dropAll closes every client connection, as a restarting server would.
+func (f *fakeRedis) dropAll() {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	for _, nc := range f.conns {
+		nc.Close()
+	}
+	f.conns = nil
+	f.subs = make(map[string][]net.Conn)
+}
+
+func TestRedis(t *testing.T) {
+	// Arrange
+	f := startFakeRedis(t, "secret")
+	b, err := Open("redis://:secret@" + f.ln.Addr().String())
+	if err != nil {
+		t.Fatalf("open: %v", err)
+	}
+	defer b.Close()
+	ctx := context.Background()
+	s, err := b.Subscribe(ctx, "changes")
+	if err != nil {
+		t.Fatalf("subscribe: %v", err)
+	}
+
+	// Act
+	pubErr := b.Publish(ctx, "changes", []byte("line 1\r\nline 2"))
+	var got string
+	select {
+	case msg := <-s.C:
+		got = string(msg)
+	case <-time.After(5 * time.Second):
+		t.Fatal("no message received")
+	}
+
+	// Assert
+	t.Logf("publish: %v, received: %q", pubErr, got)
+	if pubErr != nil || got != "line 1\r\nline 2" {
+		t.Errorf("got %q, %v", got, pubErr)
+	}
+
+	// Act: the server goes away
+	f.dropAll()
+	for range s.C {
+	}
+
+	// Assert
+	t.Logf("after disconnect: %v", s.Err())
+	if s.Err() == nil {
+		t.Error("expected the subscription to end with an error")
+	}
+}
+
+func TestRedisAuthFailure(t *testing.T) {
+	// Arrange
+	f := startFakeRedis(t, "secret")
+	b, _ := Open("redis://:wrong@" + f.ln.Addr().String())
+	defer b.Close()
+
+	// Act
+	_, err := b.Subscribe(context.Background(), "changes")
+
+	// Assert
+	t.Logf("subscribe: %v", err)
+	var reply Error
+	if !errors.As(err, &reply) || !strings.HasPrefix(string(reply), "WRONGPASS") {
+		t.Errorf("expected the WRONGPASS reply, got %v", err)
+	}
+}
+
+func TestRedisDo(t *testing.T) {
+	// Arrange
+	f := startFakeRedis(t, "")
+	r, err := OpenRedis("redis://" + f.ln.Addr().String())
+	if err != nil {
+		t.Fatalf("open: %v", err)
+	}
+	defer r.Close()
+	ctx := context.Background()
+
+	// Act
+	first, err1 := r.Do(ctx, "INCR", "hits")
+	second, err2 := r.Do(ctx, "INCR", "hits")
+	_, unknown := r.Do(ctx, "FLY")
+	third, err3 := r.Do(ctx, "INCR", "hits")
+
+	// Assert
+	t.Logf("replies: %v %v %v, errors: %v %v %v, unknown: %v", first, second, third, err1, err2, err3, unknown)
+	if err1 != nil || err2 != nil || err3 != nil || first != int64(1) || second != int64(2) || third != int64(3) {
+		t.Errorf("got %v, %v, %v, want 1, 2, 3", first, second, third)
+	}
+	var reply Error
+	if !errors.As(unknown, &reply) || !strings.HasPrefix(string(reply), "ERR unknown") {
+		t.Errorf("expected the ERR reply, got %v", unknown)
+	}
+}
+
+func TestOpen(t *testing.T) {
+	for url, ok := range map[string]bool{
+		"redis://localhost":           true,
+		"rediss://user:pw@redis:6380": true,
+		"redis://":                    false,
+		"nats://localhost:4222":       false,
+		"localhost:6379":              false,
+	} {
+		_, err := Open(url)
+		t.Logf("%s: %v", url, err)
+		if (err == nil) != ok {
+			t.Errorf("%s: got %v", url, err)
+		}
+	}
+}
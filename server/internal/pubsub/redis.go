@@ -0,0 +1,299 @@
+package pubsub
+
+import (
+	"bufio"
+	"context"
+	"crypto/tls"
+	"errors"
+	"fmt"
+	"io"
+	"net"
+	"net/url"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+)
+
+const (
+	redisTimeout = 5 * time.Second
+	// redisPingInterval is how often an idle subscription checks that the
+	// server is still there; Redis itself sends nothing while idle.
+	redisPingInterval = 30 * time.Second
+	// redisBuffer is how many received messages may wait for the
+	// subscriber before reading from the server pauses.
+	redisBuffer = 256
+	// maxBulkLen is Redis' default proto-max-bulk-len.
+	maxBulkLen = 512 << 20
+)
+
+// Error is an error reply from the Redis server.
+type Error string
+
+func (e Error) Error() string {
+	return "redis: " + string(e)
+}
+
+// Redis is a broker using the publish/subscribe commands of a Redis server
+// (or one compatible with it). Publishing uses one connection, dialed on
+// demand; every subscription has its own.
+type Redis struct {
+	addr     string
+	username string
+	password string
+	tls      *tls.Config // nil for plain TCP
+
+	mu   sync.Mutex
+	conn *redisConn
+}
+
+func newRedis(u *url.URL) (*Redis, error) {
+	if u.Hostname() == "" {
+		return nil, fmt.Errorf("pubsub: redis URL needs a host")
+	}
+	port := u.Port()
+	if port == "" {
+		port = "6379"
+	}
+	r := &Redis{addr: net.JoinHostPort(u.Hostname(), port)}
+	if u.User != nil {
+		r.username = u.User.Username()
+		r.password, _ = u.User.Password()
+	}
+	if u.Scheme == "rediss" {
+		r.tls = &tls.Config{ServerName: u.Hostname()}
+	}
+	return r, nil
+}
+
+// OpenRedis returns a client of the Redis server at rawURL, which is
+// redis://[[user]:password@]host[:port] or rediss:// for Redis over TLS.
+// No connection is made until the first command.
+func OpenRedis(rawURL string) (*Redis, error) {
+	u, err := url.Parse(rawURL)
+	if err != nil {
+		return nil, fmt.Errorf("pubsub: invalid URL")
+	}
+	if u.Scheme != "redis" && u.Scheme != "rediss" {
+		return nil, fmt.Errorf("pubsub: unsupported scheme %q", u.Scheme)
+	}
+	return newRedis(u)
+}
+
+// Publish sends msg on channel; a message nobody subscribes to is dropped
+// by the server.
+func (r *Redis) Publish(ctx context.Context, channel string, msg []byte) error {
+	if _, err := r.Do(ctx, "PUBLISH", channel, string(msg)); err != nil {
+		return fmt.Errorf("redis publish: %w", err)
+	}
+	return nil
+}
+
+// Do runs a command on the connection shared with Publish and returns its
+// reply, as read by redisConn.read. Commands run one at a time; a
+// connection that fails is dropped and dialed again by the next one.
+func (r *Redis) Do(ctx context.Context, args ...string) (any, error) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	if r.conn == nil {
+		c, err := r.dial(ctx)
+		if err != nil {
+			return nil, err
+		}
+		r.conn = c
+	}
+	r.conn.nc.SetDeadline(time.Now().Add(redisTimeout))
+	reply, err := r.conn.do(args...)
+	if err != nil {
+		var e Error
+		if !errors.As(err, &e) {
+			r.conn.nc.Close()
+			r.conn = nil
+		}
+		return nil, err
+	}
+	return reply, nil
+}
+
+func (r *Redis) Subscribe(ctx context.Context, channel string) (*Subscription, error) {
+	c, err := r.dial(ctx)
+	if err != nil {
+		return nil, err
+	}
+	c.nc.SetDeadline(time.Now().Add(redisTimeout))
+	reply, err := c.do("SUBSCRIBE", channel)
+	if a, ok := reply.([]any); err == nil && (!ok || len(a) != 3 || a[0] != "subscribe") {
+		err = fmt.Errorf("unexpected reply %v", reply)
+	}
+	if err != nil {
+		c.nc.Close()
+		return nil, fmt.Errorf("redis subscribe: %w", err)
+	}
+	c.nc.SetDeadline(time.Time{})
+
+	out := make(chan []byte, redisBuffer)
+	s := &Subscription{C: out}
+	ctx, s.cancel = context.WithCancel(ctx)
+	context.AfterFunc(ctx, func() { c.nc.Close() })
+	go c.ping(ctx)
+	go func() {
+		defer close(out)
+		defer s.cancel()
+		for {
+			c.nc.SetReadDeadline(time.Now().Add(2 * redisPingInterval))
+			reply, err := c.read()
+			if err != nil {
+				if ctx.Err() != nil {
+					err = ctx.Err()
+				}
+				s.err = fmt.Errorf("redis subscription: %w", err)
+				return
+			}
+			// Anything else is the answer to a ping.
+			a, _ := reply.([]any)
+			if len(a) != 3 || a[0] != "message" {
+				continue
+			}
+			msg, _ := a[2].(string)
+			select {
+			case out <- []byte(msg):
+			case <-ctx.Done():
+				s.err = fmt.Errorf("redis subscription: %w", ctx.Err())
+				return
+			}
+		}
+	}()
+	return s, nil
+}
+
+func (r *Redis) Close() error {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	if r.conn == nil {
+		return nil
+	}
+	err := r.conn.nc.Close()
+	r.conn = nil
+	return err
+}
+
+// dial connects to the server and authenticates if a password is set.
+func (r *Redis) dial(ctx context.Context) (*redisConn, error) {
+	d := &net.Dialer{Timeout: redisTimeout}
+	var nc net.Conn
+	var err error
+	if r.tls != nil {
+		nc, err = (&tls.Dialer{NetDialer: d, Config: r.tls}).DialContext(ctx, "tcp", r.addr)
+	} else {
+		nc, err = d.DialContext(ctx, "tcp", r.addr)
+	}
+	if err != nil {
+		return nil, fmt.Errorf("redis dial: %w", err)
+	}
+	c := &redisConn{nc: nc, br: bufio.NewReader(nc)}
+	if r.password != "" {
+		args := []string{"AUTH", r.password}
+		if r.username != "" {
+			args = []string{"AUTH", r.username, r.password}
+		}
+		nc.SetDeadline(time.Now().Add(redisTimeout))
+		if _, err := c.do(args...); err != nil {
+			nc.Close()
+			return nil, fmt.Errorf("redis auth: %w", err)
+		}
+		nc.SetDeadline(time.Time{})
+	}
+	return c, nil
+}
+
+// redisConn is a connection speaking RESP2.
+type redisConn struct {
+	nc net.Conn
+	br *bufio.Reader
+}
+
+// do sends a command and reads its reply.
+func (c *redisConn) do(args ...string) (any, error) {
+	if err := c.write(args...); err != nil {
+		return nil, err
+	}
+	return c.read()
+}
+
+func (c *redisConn) write(args ...string) error {
+	b := fmt.Appendf(nil, "*%d\r\n", len(args))
+	for _, a := range args {
+		b = fmt.Appendf(b, "$%d\r\n%s\r\n", len(a), a)
+	}
+	_, err := c.nc.Write(b)
+	return err
+}
+
+// read returns the next reply: a string for simple and bulk strings, an
+// int64, a []any for arrays, nil for null, or an Error.
+func (c *redisConn) read() (any, error) {
+	line, err := c.br.ReadString('\n')
+	if err != nil {
+		return nil, err
+	}
+	if len(line) < 3 || !strings.HasSuffix(line, "\r\n") {
+		return nil, fmt.Errorf("malformed reply %q", line)
+	}
+	kind, rest := line[0], line[1:len(line)-2]
+	switch kind {
+	case '+':
+		return rest, nil
+	case '-':
+		return nil, Error(rest)
+	case ':':
+		return strconv.ParseInt(rest, 10, 64)
+	case '$':
+		n, err := strconv.Atoi(rest)
+		if err != nil || n > maxBulkLen {
+			return nil, fmt.Errorf("malformed bulk length %q", rest)
+		}
+		if n < 0 {
+			return nil, nil
+		}
+		buf := make([]byte, n+2)
+		if _, err := io.ReadFull(c.br, buf); err != nil {
+			return nil, err
+		}
+		return string(buf[:n]), nil
+	case '*':
+		n, err := strconv.Atoi(rest)
+		if err != nil || n > maxBulkLen {
+			return nil, fmt.Errorf("malformed array length %q", rest)
+		}
+		if n < 0 {
+			return nil, nil
+		}
+		a := make([]any, n)
+		for i := range a {
+			if a[i], err = c.read(); err != nil {
+				return nil, err
+			}
+		}
+		return a, nil
+	}
+	return nil, fmt.Errorf("unknown reply type %q", kind)
+}
+
+// ping keeps a subscribed connection checked until ctx is done. The
+// subscription's reader sees the answers.
+func (c *redisConn) ping(ctx context.Context) {
+	ticker := time.NewTicker(redisPingInterval)
+	defer ticker.Stop()
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case <-ticker.C:
+			c.nc.SetWriteDeadline(time.Now().Add(redisTimeout))
+			if err := c.write("PING"); err != nil {
+				c.nc.Close()
+				return
+			}
+		}
+	}
+}
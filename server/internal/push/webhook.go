@@ -0,0 +1,66 @@
+package push
+
+import (
+	"bytes"
+	"errors"
+	"fmt"
+	"io"
+	"net/http"
+	"time"
+
+	"github.com/c0dev0id/notesd/server/internal/netguard"
+)
+
+// Webhook posts JSON payloads to user-configured URLs. It only connects
+// to public addresses, so users cannot reach the server's own networks
+// through it.
+type Webhook struct {
+	client *http.Client
+}
+
+func NewWebhook() *Webhook {
+	return &Webhook{client: netguard.Client(10 * time.Second)}
+}
+
+// Send posts payload to url. Any 2xx response counts as delivered.
+func (h *Webhook) Send(url string, payload []byte) error {
+	_, err := h.Post(url, payload, nil)
+	return err
+}
+
+// Post posts payload to url with the extra headers in header and returns
+// the response status. Any 2xx response counts as delivered; others are
+// returned together with an error.
+func (h *Webhook) Post(url string, payload []byte, header http.Header) (int, error) {
+	req, err := http.NewRequest("POST", url, bytes.NewReader(payload))
+	if err != nil {
+		return 0, fmt.Errorf("create webhook request: %w", err)
+	}
+	for k, v := range header {
+		req.Header[k] = v
+	}
+	req.Header.Set("Content-Type", "application/json")
+	req.Header.Set("User-Agent", "notesd")
+
+	resp, err := h.client.Do(req)
+	if err != nil {
+		return 0, deliveryError(err)
+	}
+	defer resp.Body.Close()
+	io.Copy(io.Discard, io.LimitReader(resp.Body, 4096))
+
+	if resp.StatusCode < 200 || resp.StatusCode >= 300 {
+		return resp.StatusCode, fmt.Errorf("webhook returned %d", resp.StatusCode)
+	}
+	return resp.StatusCode, nil
+}
+
+// deliveryError describes a failed request without the details of the
+// dial error, which is shown to users and would tell them which ports of
+// a host are open.
+func deliveryError(err error) error {
+	if errors.Is(err, netguard.ErrBlocked) {
+		return fmt.Errorf("webhook: %w", netguard.ErrBlocked)
+	}
+	return errors.New("webhook: no response")
+}
@@ -0,0 +1,34 @@
+package push
+
+import (
+	"errors"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+
+	"github.com/c0dev0id/notesd/server/internal/netguard"
+)
+
+func TestWebhookBlocksPrivateAddresses(t *testing.T) {
+	// Arrange: a receiver on loopback, where only the server's own
+	// services should be
+	hit := false
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) { hit = true }))
+	defer srv.Close()
+	closed := httptest.NewServer(http.NotFoundHandler())
+	closed.Close()
+
+	// Act
+	_, err := NewWebhook().Post(srv.URL+"/hook", []byte("{}"), nil)
+	_, closedErr := NewWebhook().Post(closed.URL, []byte("{}"), nil)
+
+	// Assert
+	t.Logf("open port: %v, closed port: %v, reached: %v", err, closedErr, hit)
+	if !errors.Is(err, netguard.ErrBlocked) || hit {
+		t.Errorf("expected ErrBlocked without reaching the receiver, got %v", err)
+	}
+	if closedErr == nil || closedErr.Error() != err.Error() || strings.Contains(err.Error(), "127.0.0.1") {
+		t.Errorf("expected the same error for open and closed ports without the address, got %q and %v", err, closedErr)
+	}
+}
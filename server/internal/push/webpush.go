@@ -0,0 +1,247 @@
+// Package push delivers reminder notifications to devices via Web Push and
+// to user-configured webhooks.
+package push
+
+import (
+	"bytes"
+	"crypto/aes"
+	"crypto/cipher"
+	"crypto/ecdh"
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/hkdf"
+	"crypto/rand"
+	"crypto/sha256"
+	"crypto/x509"
+	"encoding/base64"
+	"encoding/binary"
+	"encoding/pem"
+	"errors"
+	"fmt"
+	"io"
+	"log/slog"
+	"net/http"
+	"net/url"
+	"os"
+	"time"
+
+	"github.com/c0dev0id/notesd/server/internal/config"
+	"github.com/c0dev0id/notesd/server/internal/netguard"
+	"github.com/golang-jwt/jwt/v5"
+)
+
+// ErrGone means the push service no longer knows the subscription; it
+// should be deleted.
+var ErrGone = errors.New("push subscription gone")
+
+// Subscription is a browser push subscription (PushSubscription.toJSON).
+// P256dh and Auth are base64url as the browser reports them.
+type Subscription struct {
+	Endpoint string
+	P256dh   string
+	Auth     string
+}
+
+// WebPush sends encrypted messages (RFC 8291) authenticated with VAPID
+// (RFC 8292). Endpoints come from browsers via users, so like webhooks
+// they are only reached at public addresses.
+type WebPush struct {
+	key     *ecdsa.PrivateKey
+	subject string
+	client  *http.Client
+}
+
+// NewWebPush loads the VAPID key, generating it on first use. It returns
+// nil when Web Push is not configured.
+func NewWebPush(cfg config.PushConfig) (*WebPush, error) {
+	if cfg.Subject == "" {
+		return nil, nil
+	}
+	key, err := loadOrGenerateVAPIDKey(cfg.VAPIDKeyPath)
+	if err != nil {
+		return nil, fmt.Errorf("vapid key: %w", err)
+	}
+	return &WebPush{key: key, subject: cfg.Subject, client: netguard.Client(30 * time.Second)}, nil
+}
+
+// PublicKey returns the VAPID public key in the form browsers expect for
+// applicationServerKey: an uncompressed P-256 point, base64url.
+func (p *WebPush) PublicKey() string {
+	pub, _ := p.key.PublicKey.ECDH()
+	return base64.RawURLEncoding.EncodeToString(pub.Bytes())
+}
+
+// Send encrypts payload for sub and posts it to the push service.
+func (p *WebPush) Send(sub Subscription, payload []byte) error {
+	body, err := encrypt(sub, payload)
+	if err != nil {
+		return err
+	}
+	auth, err := p.vapidHeader(sub.Endpoint)
+	if err != nil {
+		return err
+	}
+
+	req, err := http.NewRequest("POST", sub.Endpoint, bytes.NewReader(body))
+	if err != nil {
+		return fmt.Errorf("create push request: %w", err)
+	}
+	req.Header.Set("Content-Type", "application/octet-stream")
+	req.Header.Set("Content-Encoding", "aes128gcm")
+	req.Header.Set("TTL", "86400")
+	req.Header.Set("Urgency", "high")
+	req.Header.Set("Authorization", auth)
+
+	resp, err := p.client.Do(req)
+	if err != nil {
+		return fmt.Errorf("push: %w", err)
+	}
+	defer resp.Body.Close()
+	io.Copy(io.Discard, io.LimitReader(resp.Body, 4096))
+
+	switch {
+	case resp.StatusCode == http.StatusNotFound || resp.StatusCode == http.StatusGone:
+		return ErrGone
+	case resp.StatusCode >= 300:
+		return fmt.Errorf("push service returned %d", resp.StatusCode)
+	}
+	return nil
+}
+
+// vapidHeader signs a short-lived JWT for the push service's origin.
+func (p *WebPush) vapidHeader(endpoint string) (string, error) {
+	u, err := url.Parse(endpoint)
+	if err != nil || u.Scheme == "" || u.Host == "" {
+		return "", fmt.Errorf("invalid push endpoint %q", endpoint)
+	}
+	token := jwt.NewWithClaims(jwt.SigningMethodES256, jwt.MapClaims{
+		"aud": u.Scheme + "://" + u.Host,
+		"exp": time.Now().Add(12 * time.Hour).Unix(),
+		"sub": p.subject,
+	})
+	signed, err := token.SignedString(p.key)
+	if err != nil {
+		return "", fmt.Errorf("sign vapid token: %w", err)
+	}
+	return "vapid t=" + signed + ", k=" + p.PublicKey(), nil
+}
+
+// recordSize is the aes128gcm record size advertised in the header. The
+// payload always fits in a single record.
+const recordSize = 4096
+
+// encrypt implements the aes128gcm content coding of RFC 8188 with the key
+// derivation of RFC 8291.
+func encrypt(sub Subscription, payload []byte) ([]byte, error) {
+	uaPublic, err := base64.RawURLEncoding.DecodeString(trimPadding(sub.P256dh))
+	if err != nil {
+		return nil, fmt.Errorf("decode p256dh: %w", err)
+	}
+	authSecret, err := base64.RawURLEncoding.DecodeString(trimPadding(sub.Auth))
+	if err != nil {
+		return nil, fmt.Errorf("decode auth: %w", err)
+	}
+	uaKey, err := ecdh.P256().NewPublicKey(uaPublic)
+	if err != nil {
+		return nil, fmt.Errorf("parse p256dh: %w", err)
+	}
+	if len(payload)+17 > recordSize-16 {
+		return nil, errors.New("push payload too large")
+	}
+
+	asKey, err := ecdh.P256().GenerateKey(rand.Reader)
+	if err != nil {
+		return nil, err
+	}
+	asPublic := asKey.PublicKey().Bytes()
+	shared, err := asKey.ECDH(uaKey)
+	if err != nil {
+		return nil, err
+	}
+
+	// IKM = HKDF(auth_secret, ecdh_secret, "WebPush: info" || 0 || ua_public || as_public)
+	info := append([]byte("WebPush: info\x00"), uaPublic...)
+	info = append(info, asPublic...)
+	prk, err := hkdf.Extract(sha256.New, shared, authSecret)
+	if err != nil {
+		return nil, err
+	}
+	ikm, err := hkdf.Expand(sha256.New, prk, string(info), 32)
+	if err != nil {
+		return nil, err
+	}
+
+	salt := make([]byte, 16)
+	if _, err := rand.Read(salt); err != nil {
+		return nil, err
+	}
+	prk, err = hkdf.Extract(sha256.New, ikm, salt)
+	if err != nil {
+		return nil, err
+	}
+	cek, err := hkdf.Expand(sha256.New, prk, "Content-Encoding: aes128gcm\x00", 16)
+	if err != nil {
+		return nil, err
+	}
+	nonce, err := hkdf.Expand(sha256.New, prk, "Content-Encoding: nonce\x00", 12)
+	if err != nil {
+		return nil, err
+	}
+
+	block, err := aes.NewCipher(cek)
+	if err != nil {
+		return nil, err
+	}
+	gcm, err := cipher.NewGCM(block)
+	if err != nil {
+		return nil, err
+	}
+
+	// Header: salt || rs || idlen || keyid, then the single record with
+	// its 0x02 last-record delimiter.
+	var buf bytes.Buffer
+	buf.Write(salt)
+	binary.Write(&buf, binary.BigEndian, uint32(recordSize))
+	buf.WriteByte(byte(len(asPublic)))
+	buf.Write(asPublic)
+	plaintext := append(append([]byte{}, payload...), 0x02)
+	buf.Write(gcm.Seal(nil, nonce, plaintext, nil))
+	return buf.Bytes(), nil
+}
+
+// trimPadding accepts keys with or without base64 padding.
+func trimPadding(s string) string {
+	for len(s) > 0 && s[len(s)-1] == '=' {
+		s = s[:len(s)-1]
+	}
+	return s
+}
+
+func loadOrGenerateVAPIDKey(path string) (*ecdsa.PrivateKey, error) {
+	data, err := os.ReadFile(path)
+	if err == nil {
+		block, _ := pem.Decode(data)
+		if block == nil {
+			return nil, errors.New("no PEM block found")
+		}
+		return x509.ParseECPrivateKey(block.Bytes)
+	}
+	if !os.IsNotExist(err) {
+		return nil, err
+	}
+
+	slog.Info("generating VAPID key", "path", path)
+	key, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	if err != nil {
+		return nil, err
+	}
+	der, err := x509.MarshalECPrivateKey(key)
+	if err != nil {
+		return nil, err
+	}
+	block := &pem.Block{Type: "EC PRIVATE KEY", Bytes: der}
+	if err := os.WriteFile(path, pem.EncodeToMemory(block), 0600); err != nil {
+		return nil, err
+	}
+	return key, nil
+}
@@ -0,0 +1,148 @@
+package push
+
+import (
+	"crypto/aes"
+	"crypto/cipher"
+	"crypto/ecdh"
+	"crypto/hkdf"
+	"crypto/rand"
+	"crypto/sha256"
+	"encoding/base64"
+	"encoding/binary"
+	"errors"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"path/filepath"
+	"strings"
+	"testing"
+
+	"github.com/c0dev0id/notesd/server/internal/config"
+	"github.com/c0dev0id/notesd/server/internal/netguard"
+	"github.com/golang-jwt/jwt/v5"
+)
+
+// decrypt is the user agent's side of RFC 8291, used to check encrypt.
+func decrypt(t *testing.T, uaKey *ecdh.PrivateKey, authSecret, body []byte) []byte {
+	t.Helper()
+	salt, rs, idlen := body[:16], binary.BigEndian.Uint32(body[16:20]), int(body[20])
+	asPublic := body[21 : 21+idlen]
+	record := body[21+idlen:]
+	if rs != recordSize {
+		t.Errorf("record size %d", rs)
+	}
+
+	asKey, err := ecdh.P256().NewPublicKey(asPublic)
+	if err != nil {
+		t.Fatalf("parse as key: %v", err)
+	}
+	shared, _ := uaKey.ECDH(asKey)
+	info := append([]byte("WebPush: info\x00"), uaKey.PublicKey().Bytes()...)
+	info = append(info, asPublic...)
+	prk, _ := hkdf.Extract(sha256.New, shared, authSecret)
+	ikm, _ := hkdf.Expand(sha256.New, prk, string(info), 32)
+	prk, _ = hkdf.Extract(sha256.New, ikm, salt)
+	cek, _ := hkdf.Expand(sha256.New, prk, "Content-Encoding: aes128gcm\x00", 16)
+	nonce, _ := hkdf.Expand(sha256.New, prk, "Content-Encoding: nonce\x00", 12)
+
+	block, _ := aes.NewCipher(cek)
+	gcm, _ := cipher.NewGCM(block)
+	plain, err := gcm.Open(nil, nonce, record, nil)
+	if err != nil {
+		t.Fatalf("open record: %v", err)
+	}
+	if plain[len(plain)-1] != 0x02 {
+		t.Fatalf("missing last-record delimiter")
+	}
+	return plain[:len(plain)-1]
+}
+
+func newSubscription(t *testing.T, endpoint string) (Subscription, *ecdh.PrivateKey, []byte) {
+	t.Helper()
+	uaKey, err := ecdh.P256().GenerateKey(rand.Reader)
+	if err != nil {
+		t.Fatalf("generate ua key: %v", err)
+	}
+	auth := make([]byte, 16)
+	rand.Read(auth)
+	return Subscription{
+		Endpoint: endpoint,
+		P256dh:   base64.RawURLEncoding.EncodeToString(uaKey.PublicKey().Bytes()),
+		Auth:     base64.URLEncoding.EncodeToString(auth), // padded, as some browsers send
+	}, uaKey, auth
+}
+
+func TestEncryptRoundTrip(t *testing.T) {
+	sub, uaKey, auth := newSubscription(t, "https://push.example.com/x")
+	payload := []byte(`{"type":"todo","title":"Buy milk"}`)
+
+	body, err := encrypt(sub, payload)
+	if err != nil {
+		t.Fatalf("encrypt: %v", err)
+	}
+
+	got := decrypt(t, uaKey, auth, body)
+	t.Logf("body %d bytes, plaintext %q", len(body), got)
+	if string(got) != string(payload) {
+		t.Errorf("round trip: got %q", got)
+	}
+}
+
+func TestWebPushSend(t *testing.T) {
+	// Arrange: a push service that checks VAPID and then reports the
+	// subscription gone on the second request.
+	keyPath := filepath.Join(t.TempDir(), "vapid.key")
+	wp, err := NewWebPush(config.PushConfig{
+		VAPIDKeyPath: keyPath,
+		Subject:      "mailto:admin@example.com",
+	})
+	if err != nil {
+		t.Fatalf("NewWebPush: %v", err)
+	}
+	requests := 0
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		requests++
+		auth := r.Header.Get("Authorization")
+		t.Logf("request %d: encoding=%q auth=%.40q", requests, r.Header.Get("Content-Encoding"), auth)
+		tok, _, ok := strings.Cut(strings.TrimPrefix(auth, "vapid t="), ", k=")
+		if !ok || !strings.HasSuffix(auth, wp.PublicKey()) {
+			t.Errorf("malformed vapid header %q", auth)
+		}
+		claims := jwt.MapClaims{}
+		if _, err := jwt.ParseWithClaims(tok, claims, func(*jwt.Token) (any, error) {
+			return &wp.key.PublicKey, nil
+		}, jwt.WithValidMethods([]string{"ES256"})); err != nil {
+			t.Errorf("vapid token: %v", err)
+		}
+		if claims["aud"] != "http://"+r.Host || claims["sub"] != "mailto:admin@example.com" {
+			t.Errorf("claims: %v", claims)
+		}
+		io.Copy(io.Discard, r.Body)
+		if requests > 1 {
+			w.WriteHeader(http.StatusGone)
+			return
+		}
+		w.WriteHeader(http.StatusCreated)
+	}))
+	defer srv.Close()
+	sub, _, _ := newSubscription(t, srv.URL+"/push/abc")
+
+	// Act / Assert: the service is on loopback, which only the test's
+	// client may reach
+	if err := wp.Send(sub, []byte("hi")); !errors.Is(err, netguard.ErrBlocked) || requests != 0 {
+		t.Fatalf("send to loopback: got %v after %d requests, want ErrBlocked", err, requests)
+	}
+	wp.client = srv.Client()
+	if err := wp.Send(sub, []byte("hi")); err != nil {
+		t.Fatalf("first send: %v", err)
+	}
+	if err := wp.Send(sub, []byte("hi")); !errors.Is(err, ErrGone) {
+		t.Errorf("second send: got %v, want ErrGone", err)
+	}
+
+	// The generated key is reused on restart
+	again, err := NewWebPush(config.PushConfig{VAPIDKeyPath: keyPath, Subject: "mailto:admin@example.com"})
+	if err != nil || again.PublicKey() != wp.PublicKey() {
+		t.Errorf("key not persisted: %v", err)
+	}
+}
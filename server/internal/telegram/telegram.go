@@ -0,0 +1,114 @@
+// Package telegram is a minimal client for the Telegram Bot API: it
+// receives messages by long polling and sends plain-text replies.
+package telegram
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"fmt"
+	"io"
+	"net/http"
+	"strings"
+	"time"
+
+	"github.com/c0dev0id/notesd/server/internal/config"
+)
+
+// pollTimeout is how long a getUpdates request waits for a message.
+const pollTimeout = 50 * time.Second
+
+// Bot calls the Bot API with a bot token.
+type Bot struct {
+	url    string // API URL with the token, without the method
+	client *http.Client
+}
+
+// Update is an incoming update; only messages are requested.
+type Update struct {
+	UpdateID int64    `json:"update_id"`
+	Message  *Message `json:"message"`
+}
+
+type Message struct {
+	Chat Chat   `json:"chat"`
+	Text string `json:"text"`
+}
+
+type Chat struct {
+	ID int64 `json:"id"`
+}
+
+// New returns a bot for cfg, or nil when no token is configured.
+func New(cfg config.TelegramConfig) *Bot {
+	if cfg.Token == "" {
+		return nil
+	}
+	return &Bot{
+		url:    strings.TrimSuffix(cfg.APIURL, "/") + "/bot" + cfg.Token + "/",
+		client: &http.Client{Timeout: pollTimeout + 20*time.Second},
+	}
+}
+
+// Updates waits up to 50 seconds for updates after offset, the ID of the
+// last one handled plus one, and returns them.
+func (b *Bot) Updates(ctx context.Context, offset int64) ([]Update, error) {
+	var updates []Update
+	err := b.call(ctx, "getUpdates", map[string]any{
+		"offset":          offset,
+		"timeout":         int(pollTimeout / time.Second),
+		"allowed_updates": []string{"message"},
+	}, &updates)
+	return updates, err
+}
+
+// Send sends text to the chat chatID.
+func (b *Bot) Send(ctx context.Context, chatID int64, text string) error {
+	return b.call(ctx, "sendMessage", map[string]any{
+		"chat_id":                  chatID,
+		"text":                     text,
+		"disable_web_page_preview": true,
+	}, nil)
+}
+
+// call posts params to method and decodes the result into result.
+func (b *Bot) call(ctx context.Context, method string, params any, result any) error {
+	body, err := json.Marshal(params)
+	if err != nil {
+		return err
+	}
+	req, err := http.NewRequestWithContext(ctx, "POST", b.url+method, bytes.NewReader(body))
+	if err != nil {
+		// The URL holds the token; keep it out of the error.
+		return fmt.Errorf("telegram %s: invalid API URL", method)
+	}
+	req.Header.Set("Content-Type", "application/json")
+
+	resp, err := b.client.Do(req)
+	if err != nil {
+		if ctx.Err() != nil {
+			return ctx.Err()
+		}
+		return fmt.Errorf("telegram %s: request failed", method)
+	}
+	defer resp.Body.Close()
+
+	var out struct {
+		OK          bool            `json:"ok"`
+		Description string          `json:"description"`
+		Result      json.RawMessage `json:"result"`
+	}
+	if err := json.NewDecoder(io.LimitReader(resp.Body, 16<<20)).Decode(&out); err != nil {
+		return fmt.Errorf("telegram %s: %s", method, resp.Status)
+	}
+	if !out.OK {
+		return fmt.Errorf("telegram %s: %s", method, out.Description)
+	}
+	if result == nil {
+		return nil
+	}
+	if err := json.Unmarshal(out.Result, result); err != nil {
+		return fmt.Errorf("telegram %s: decode result: %w", method, err)
+	}
+	return nil
+}
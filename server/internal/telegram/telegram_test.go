@@ -0,0 +1,59 @@
+package telegram
+
+import (
+	"context"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+
+	"github.com/c0dev0id/notesd/server/internal/config"
+)
+
+func TestBot(t *testing.T) {
+	// Arrange: a fake Bot API answering getUpdates and sendMessage
+	var sent map[string]any
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		var params map[string]any
+		json.NewDecoder(r.Body).Decode(&params)
+		switch r.URL.Path {
+		case "/bot123:secret/getUpdates":
+			if params["offset"] != float64(7) {
+				t.Errorf("offset = %v, want 7", params["offset"])
+			}
+			w.Write([]byte(`{"ok":true,"result":[{"update_id":7,"message":{"chat":{"id":42},"text":"hello"}}]}`))
+		case "/bot123:secret/sendMessage":
+			sent = params
+			w.Write([]byte(`{"ok":true,"result":{}}`))
+		default:
+			w.WriteHeader(http.StatusNotFound)
+			w.Write([]byte(`{"ok":false,"description":"Not Found"}`))
+		}
+	}))
+	defer srv.Close()
+	bot := New(config.TelegramConfig{Token: "123:secret", APIURL: srv.URL + "/"})
+	other := New(config.TelegramConfig{Token: "123:wrong", APIURL: srv.URL})
+	ctx := context.Background()
+
+	// Act
+	updates, updErr := bot.Updates(ctx, 7)
+	sendErr := bot.Send(ctx, 42, "hi")
+	badErr := other.Send(ctx, 42, "hi")
+
+	// Assert
+	t.Logf("updates %+v, errors %v %v %v", updates, updErr, sendErr, badErr)
+	if updErr != nil || len(updates) != 1 || updates[0].Message == nil ||
+		updates[0].Message.Chat.ID != 42 || updates[0].Message.Text != "hello" {
+		t.Errorf("unexpected updates %+v", updates)
+	}
+	if sendErr != nil || sent["chat_id"] != float64(42) || sent["text"] != "hi" {
+		t.Errorf("unexpected send: %v %v", sendErr, sent)
+	}
+	if badErr == nil || !strings.Contains(badErr.Error(), "Not Found") || strings.Contains(badErr.Error(), "wrong") {
+		t.Errorf("expected an API error without the token, got %v", badErr)
+	}
+	if New(config.TelegramConfig{}) != nil {
+		t.Error("expected no bot without a token")
+	}
+}
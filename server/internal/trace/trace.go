@@ -0,0 +1,378 @@
+// Package trace records spans of HTTP requests and database operations and
+// exports them to an OpenTelemetry collector as OTLP/HTTP JSON. Trace
+// context travels in the W3C traceparent header. Until Init is called with
+// an endpoint, StartSpan returns nil spans and costs next to nothing.
+package trace
+
+import (
+	"bytes"
+	"context"
+	"crypto/rand"
+	"encoding/binary"
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"io"
+	"log/slog"
+	"math"
+	"net/http"
+	"strconv"
+	"strings"
+	"sync"
+	"sync/atomic"
+	"time"
+)
+
+// Kind is the OTLP span kind.
+type Kind int
+
+const (
+	KindInternal Kind = 1
+	KindServer   Kind = 2
+	KindClient   Kind = 3
+)
+
+const (
+	batchSize     = 256
+	queueSize     = 4096
+	flushInterval = 5 * time.Second
+)
+
+// Config selects where spans go. Endpoint is the collector's base URL
+// (e.g. http://localhost:4318); spans are posted to Endpoint/v1/traces.
+// SampleRatio is the share of new traces recorded; requests carrying a
+// traceparent follow the caller's decision.
+type Config struct {
+	Endpoint       string
+	Headers        map[string]string
+	SampleRatio    float64
+	ServiceName    string
+	ServiceVersion string
+}
+
+// spanContext identifies a span, local or from a traceparent header.
+type spanContext struct {
+	traceID [16]byte
+	spanID  [8]byte
+	sampled bool
+}
+
+type ctxKey struct{}
+
+// Span is an operation being timed. All methods accept a nil receiver, so
+// callers need not check whether tracing is on.
+type Span struct {
+	sc       spanContext
+	parentID [8]byte
+	name     string
+	kind     Kind
+	start    time.Time
+	end      time.Time
+	attrs    []attr
+	errMsg   string
+	exp      *Exporter
+}
+
+type attr struct {
+	key   string
+	value any
+}
+
+var current atomic.Pointer[Exporter]
+
+// Init starts exporting spans to cfg.Endpoint. It returns nil and leaves
+// tracing off when the endpoint is empty.
+func Init(cfg Config) *Exporter {
+	if cfg.Endpoint == "" {
+		return nil
+	}
+	url := strings.TrimSuffix(cfg.Endpoint, "/")
+	if !strings.HasSuffix(url, "/v1/traces") {
+		url += "/v1/traces"
+	}
+	e := &Exporter{
+		url:     url,
+		headers: cfg.Headers,
+		ratio:   cfg.SampleRatio,
+		client:  &http.Client{Timeout: 10 * time.Second},
+		queue:   make(chan *Span, queueSize),
+		done:    make(chan struct{}),
+	}
+	e.resource = []attr{{"service.name", cfg.ServiceName}, {"service.version", cfg.ServiceVersion}}
+	e.wg.Add(1)
+	go e.run()
+	current.Store(e)
+	return e
+}
+
+// Exporter batches finished spans and posts them to the collector.
+type Exporter struct {
+	url      string
+	headers  map[string]string
+	ratio    float64
+	resource []attr
+	client   *http.Client
+	queue    chan *Span
+	done     chan struct{}
+	wg       sync.WaitGroup
+	dropped  atomic.Int64
+}
+
+// Shutdown stops tracing and sends the spans still queued.
+func (e *Exporter) Shutdown(ctx context.Context) error {
+	if e == nil {
+		return nil
+	}
+	current.CompareAndSwap(e, nil)
+	close(e.done)
+	finished := make(chan struct{})
+	go func() {
+		e.wg.Wait()
+		close(finished)
+	}()
+	select {
+	case <-finished:
+		return nil
+	case <-ctx.Done():
+		return ctx.Err()
+	}
+}
+
+func (e *Exporter) run() {
+	defer e.wg.Done()
+	ticker := time.NewTicker(flushInterval)
+	defer ticker.Stop()
+	var batch []*Span
+	for {
+		select {
+		case s := <-e.queue:
+			if batch = append(batch, s); len(batch) >= batchSize {
+				e.send(batch)
+				batch = nil
+			}
+		case <-ticker.C:
+			if len(batch) > 0 {
+				e.send(batch)
+				batch = nil
+			}
+		case <-e.done:
+			for {
+				select {
+				case s := <-e.queue:
+					batch = append(batch, s)
+				default:
+					if len(batch) > 0 {
+						e.send(batch)
+					}
+					return
+				}
+			}
+		}
+	}
+}
+
+func (e *Exporter) send(batch []*Span) {
+	body, err := json.Marshal(e.payload(batch))
+	if err != nil {
+		slog.Error("encode spans", "error", err)
+		return
+	}
+	req, err := http.NewRequest("POST", e.url, bytes.NewReader(body))
+	if err != nil {
+		slog.Error("export spans", "error", err)
+		return
+	}
+	req.Header.Set("Content-Type", "application/json")
+	for k, v := range e.headers {
+		req.Header.Set(k, v)
+	}
+	resp, err := e.client.Do(req)
+	if err != nil {
+		slog.Warn("export spans", "error", err, "spans", len(batch))
+		return
+	}
+	io.Copy(io.Discard, resp.Body)
+	resp.Body.Close()
+	if resp.StatusCode >= 300 {
+		slog.Warn("export spans", "status", resp.StatusCode, "spans", len(batch))
+	}
+	if n := e.dropped.Swap(0); n > 0 {
+		slog.Warn("trace queue full, spans dropped", "count", n)
+	}
+}
+
+// payload builds an OTLP ExportTraceServiceRequest in its JSON encoding:
+// IDs are hex, 64-bit integers are strings.
+func (e *Exporter) payload(batch []*Span) map[string]any {
+	spans := make([]map[string]any, len(batch))
+	for i, s := range batch {
+		span := map[string]any{
+			"traceId":           hex.EncodeToString(s.sc.traceID[:]),
+			"spanId":            hex.EncodeToString(s.sc.spanID[:]),
+			"name":              s.name,
+			"kind":              int(s.kind),
+			"startTimeUnixNano": strconv.FormatInt(s.start.UnixNano(), 10),
+			"endTimeUnixNano":   strconv.FormatInt(s.end.UnixNano(), 10),
+			"attributes":        otlpAttrs(s.attrs),
+		}
+		if s.parentID != ([8]byte{}) {
+			span["parentSpanId"] = hex.EncodeToString(s.parentID[:])
+		}
+		if s.errMsg != "" {
+			span["status"] = map[string]any{"code": 2, "message": s.errMsg}
+		}
+		spans[i] = span
+	}
+	return map[string]any{"resourceSpans": []any{map[string]any{
+		"resource": map[string]any{"attributes": otlpAttrs(e.resource)},
+		"scopeSpans": []any{map[string]any{
+			"scope": map[string]any{"name": "notesd"},
+			"spans": spans,
+		}},
+	}}}
+}
+
+func otlpAttrs(attrs []attr) []any {
+	out := make([]any, 0, len(attrs))
+	for _, a := range attrs {
+		var v map[string]any
+		switch x := a.value.(type) {
+		case bool:
+			v = map[string]any{"boolValue": x}
+		case int:
+			v = map[string]any{"intValue": strconv.Itoa(x)}
+		case int64:
+			v = map[string]any{"intValue": strconv.FormatInt(x, 10)}
+		default:
+			v = map[string]any{"stringValue": fmt.Sprint(x)}
+		}
+		out = append(out, map[string]any{"key": a.key, "value": v})
+	}
+	return out
+}
+
+// StartSpan begins a span as a child of the span or remote parent in ctx and
+// returns a context carrying it. It returns a nil span when tracing is off
+// or the trace is not sampled.
+func StartSpan(ctx context.Context, name string, kind Kind) (context.Context, *Span) {
+	e := current.Load()
+	if e == nil {
+		return ctx, nil
+	}
+	s := &Span{name: name, kind: kind, start: time.Now(), exp: e}
+	if parent, ok := ctx.Value(ctxKey{}).(spanContext); ok {
+		if !parent.sampled {
+			return ctx, nil
+		}
+		s.sc.traceID = parent.traceID
+		s.parentID = parent.spanID
+	} else {
+		rand.Read(s.sc.traceID[:])
+		if !e.sample(s.sc.traceID) {
+			// Remember the decision so child spans are skipped too.
+			return context.WithValue(ctx, ctxKey{}, spanContext{traceID: s.sc.traceID}), nil
+		}
+	}
+	rand.Read(s.sc.spanID[:])
+	s.sc.sampled = true
+	return context.WithValue(ctx, ctxKey{}, s.sc), s
+}
+
+// sample decides from the trace ID, so all services sampling at the same
+// ratio agree.
+func (e *Exporter) sample(traceID [16]byte) bool {
+	if e.ratio >= 1 {
+		return true
+	}
+	if e.ratio <= 0 {
+		return false
+	}
+	return binary.BigEndian.Uint64(traceID[8:]) < uint64(e.ratio*math.MaxUint64)
+}
+
+// Extract returns ctx with the remote parent named by a traceparent header
+// value. Invalid values are ignored and start a new trace.
+func Extract(ctx context.Context, traceparent string) context.Context {
+	sc, ok := parseTraceparent(traceparent)
+	if !ok {
+		return ctx
+	}
+	return context.WithValue(ctx, ctxKey{}, sc)
+}
+
+// parseTraceparent reads "00-<trace id>-<parent id>-<flags>".
+func parseTraceparent(v string) (spanContext, bool) {
+	var sc spanContext
+	parts := strings.Split(v, "-")
+	if len(parts) < 4 || len(parts[0]) != 2 || parts[0] == "ff" ||
+		len(parts[1]) != 32 || len(parts[2]) != 16 || len(parts[3]) != 2 {
+		return sc, false
+	}
+	if parts[0] == "00" && len(parts) != 4 {
+		return sc, false
+	}
+	flags, err := hex.DecodeString(parts[3])
+	if err != nil {
+		return sc, false
+	}
+	if _, err := hex.Decode(sc.traceID[:], []byte(parts[1])); err != nil || sc.traceID == ([16]byte{}) {
+		return sc, false
+	}
+	if _, err := hex.Decode(sc.spanID[:], []byte(parts[2])); err != nil || sc.spanID == ([8]byte{}) {
+		return sc, false
+	}
+	sc.sampled = flags[0]&1 == 1
+	return sc, true
+}
+
+// Traceparent returns the header value that makes s the parent of a
+// downstream request.
+func (s *Span) Traceparent() string {
+	if s == nil {
+		return ""
+	}
+	return "00-" + hex.EncodeToString(s.sc.traceID[:]) + "-" + hex.EncodeToString(s.sc.spanID[:]) + "-01"
+}
+
+// TraceID returns the hex trace ID, or "" for a nil span.
+func (s *Span) TraceID() string {
+	if s == nil {
+		return ""
+	}
+	return hex.EncodeToString(s.sc.traceID[:])
+}
+
+// SetName renames the span, e.g. once the matched route is known.
+func (s *Span) SetName(name string) {
+	if s != nil {
+		s.name = name
+	}
+}
+
+// SetAttr records an attribute. Values are strings, ints or bools.
+func (s *Span) SetAttr(key string, value any) {
+	if s != nil {
+		s.attrs = append(s.attrs, attr{key, value})
+	}
+}
+
+// SetError marks the span failed when err is not nil.
+func (s *Span) SetError(err error) {
+	if s != nil && err != nil {
+		s.errMsg = err.Error()
+	}
+}
+
+// End finishes the span and queues it for export. A full queue drops it
+// rather than slowing down the request.
+func (s *Span) End() {
+	if s == nil {
+		return
+	}
+	s.end = time.Now()
+	select {
+	case s.exp.queue <- s:
+	default:
+		s.exp.dropped.Add(1)
+	}
+}
@@ -0,0 +1,197 @@
+package trace
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"net/http"
+	"net/http/httptest"
+	"sync"
+	"testing"
+	"time"
+)
+
+// collector is a fake OTLP/HTTP endpoint keeping the spans it receives.
+type collector struct {
+	mu      sync.Mutex
+	spans   []otlpSpan
+	headers http.Header
+}
+
+type otlpSpan struct {
+	TraceID      string `json:"traceId"`
+	SpanID       string `json:"spanId"`
+	ParentSpanID string `json:"parentSpanId"`
+	Name         string `json:"name"`
+	Kind         int    `json:"kind"`
+	Attributes   []struct {
+		Key   string         `json:"key"`
+		Value map[string]any `json:"value"`
+	} `json:"attributes"`
+	Status *struct {
+		Code    int    `json:"code"`
+		Message string `json:"message"`
+	} `json:"status"`
+}
+
+func newCollector(t *testing.T) (*collector, *httptest.Server) {
+	t.Helper()
+	c := &collector{}
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		var req struct {
+			ResourceSpans []struct {
+				ScopeSpans []struct {
+					Spans []otlpSpan `json:"spans"`
+				} `json:"scopeSpans"`
+			} `json:"resourceSpans"`
+		}
+		if r.URL.Path != "/v1/traces" {
+			t.Errorf("collector got path %s", r.URL.Path)
+		}
+		if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+			t.Errorf("decode export: %v", err)
+		}
+		c.mu.Lock()
+		defer c.mu.Unlock()
+		c.headers = r.Header.Clone()
+		for _, rs := range req.ResourceSpans {
+			for _, ss := range rs.ScopeSpans {
+				c.spans = append(c.spans, ss.Spans...)
+			}
+		}
+	}))
+	t.Cleanup(srv.Close)
+	return c, srv
+}
+
+func TestParseTraceparent(t *testing.T) {
+	// Arrange
+	cases := []struct {
+		in      string
+		ok      bool
+		sampled bool
+	}{
+		{"00-4bf92f3577b34da6a3ce929d0e0e4736-00f067aa0ba902b7-01", true, true},
+		{"00-4bf92f3577b34da6a3ce929d0e0e4736-00f067aa0ba902b7-00", true, false},
+		{"01-4bf92f3577b34da6a3ce929d0e0e4736-00f067aa0ba902b7-01-future", true, true},
+		{"00-4bf92f3577b34da6a3ce929d0e0e4736-00f067aa0ba902b7-01-extra", false, false},
+		{"ff-4bf92f3577b34da6a3ce929d0e0e4736-00f067aa0ba902b7-01", false, false},
+		{"00-00000000000000000000000000000000-00f067aa0ba902b7-01", false, false},
+		{"00-4bf92f3577b34da6a3ce929d0e0e4736-0000000000000000-01", false, false},
+		{"00-4bf92f3577b34da6a3ce929d0e0e473-00f067aa0ba902b7-01", false, false},
+		{"00-4bf92f3577b34da6a3ce929d0e0e47zz-00f067aa0ba902b7-01", false, false},
+		{"", false, false},
+	}
+
+	for _, c := range cases {
+		// Act
+		sc, ok := parseTraceparent(c.in)
+
+		// Assert
+		t.Logf("%q: ok=%v sampled=%v", c.in, ok, sc.sampled)
+		if ok != c.ok || (ok && sc.sampled != c.sampled) {
+			t.Errorf("%q: got ok=%v sampled=%v, want ok=%v sampled=%v", c.in, ok, sc.sampled, c.ok, c.sampled)
+		}
+	}
+}
+
+func TestExportSpans(t *testing.T) {
+	// Arrange
+	c, srv := newCollector(t)
+	e := Init(Config{
+		Endpoint:    srv.URL,
+		Headers:     map[string]string{"X-Api-Key": "secret"},
+		SampleRatio: 1,
+		ServiceName: "notesd",
+	})
+	parent := "00-4bf92f3577b34da6a3ce929d0e0e4736-00f067aa0ba902b7-01"
+
+	// Act
+	ctx := Extract(context.Background(), parent)
+	ctx, server := StartSpan(ctx, "POST /api/v1/sync/push", KindServer)
+	server.SetAttr("http.response.status_code", 200)
+	_, query := StartSpan(ctx, "UPDATE", KindClient)
+	query.SetError(errors.New("database is locked"))
+	query.End()
+	server.End()
+	if err := e.Shutdown(context.Background()); err != nil {
+		t.Fatalf("shutdown: %v", err)
+	}
+
+	// Assert
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	for _, s := range c.spans {
+		t.Logf("span %s %s parent=%s kind=%d status=%v", s.Name, s.SpanID, s.ParentSpanID, s.Kind, s.Status)
+	}
+	if len(c.spans) != 2 {
+		t.Fatalf("got %d spans, want 2", len(c.spans))
+	}
+	if got := c.headers.Get("X-Api-Key"); got != "secret" {
+		t.Errorf("X-Api-Key = %q", got)
+	}
+	q, s := c.spans[0], c.spans[1]
+	if s.TraceID != "4bf92f3577b34da6a3ce929d0e0e4736" || s.ParentSpanID != "00f067aa0ba902b7" || s.Kind != int(KindServer) {
+		t.Errorf("server span %+v does not continue the traceparent", s)
+	}
+	if q.TraceID != s.TraceID || q.ParentSpanID != s.SpanID {
+		t.Errorf("query span %+v is not a child of the server span", q)
+	}
+	if q.Status == nil || q.Status.Code != 2 || q.Status.Message != "database is locked" {
+		t.Errorf("query span status %+v, want error", q.Status)
+	}
+	if len(s.Attributes) != 1 || s.Attributes[0].Value["intValue"] != "200" {
+		t.Errorf("server span attributes %+v", s.Attributes)
+	}
+}
+
+func TestSamplingAndDisabled(t *testing.T) {
+	// Arrange
+	_, srv := newCollector(t)
+
+	// Act
+	_, off := StartSpan(context.Background(), "off", KindInternal)
+	e := Init(Config{Endpoint: srv.URL, SampleRatio: 0})
+	ctx, root := StartSpan(context.Background(), "root", KindServer)
+	_, child := StartSpan(ctx, "child", KindClient)
+	remote := Extract(context.Background(), "00-4bf92f3577b34da6a3ce929d0e0e4736-00f067aa0ba902b7-01")
+	_, followed := StartSpan(remote, "followed", KindServer)
+	followed.End()
+	e.Shutdown(context.Background())
+
+	// Assert
+	t.Logf("off=%v root=%v child=%v followed=%v", off != nil, root != nil, child != nil, followed != nil)
+	if off != nil {
+		t.Errorf("span started before Init")
+	}
+	if root != nil || child != nil {
+		t.Errorf("spans recorded at sample_ratio 0")
+	}
+	if followed == nil {
+		t.Errorf("sampled traceparent not followed")
+	}
+}
+
+func TestEndDoesNotBlock(t *testing.T) {
+	// Arrange: a collector that never answers keeps the exporter busy.
+	block := make(chan struct{})
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) { <-block }))
+	defer srv.Close()
+	e := Init(Config{Endpoint: srv.URL, SampleRatio: 1})
+	defer e.Shutdown(context.Background())
+	defer close(block)
+
+	// Act
+	start := time.Now()
+	for range queueSize + 2*batchSize {
+		_, s := StartSpan(context.Background(), "span", KindInternal)
+		s.End()
+	}
+	elapsed := time.Since(start)
+
+	// Assert
+	t.Logf("%d spans ended in %v, %d dropped", queueSize+2*batchSize, elapsed, e.dropped.Load())
+	if elapsed > time.Second {
+		t.Errorf("End blocked for %v", elapsed)
+	}
+}
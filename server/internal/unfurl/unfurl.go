@@ -0,0 +1,371 @@
+// Package unfurl fetches web pages on behalf of users and reads what they
+// say about themselves: title, description, preview image and favicon, and
+// on request their readable text. Fetches go through a netguard client and
+// so only reach public addresses: users cannot probe the server's own
+// networks through it, by redirects and rebinding names neither. Operators
+// can further restrict the hosts fetched from with allow and deny lists,
+// which are checked on every redirect.
+package unfurl
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"mime"
+	"net/http"
+	"net/netip"
+	"net/url"
+	"strings"
+	"time"
+	"unicode"
+	"unicode/utf8"
+
+	"github.com/c0dev0id/notesd/server/internal/netguard"
+	"golang.org/x/net/html"
+	"golang.org/x/net/html/charset"
+)
+
+const (
+	// fetchTimeout bounds a whole fetch, redirects and body included. It
+	// stays below the default request timeout of the API.
+	fetchTimeout = 10 * time.Second
+	// maxPageBytes is the most of a page that is read; what follows is
+	// ignored.
+	maxPageBytes = 2 << 20
+	maxTitleLen  = 500
+	maxDescLen   = 1000
+	maxURLLen    = 2000
+	// MaxTextLen caps the readable text of a page, in characters.
+	MaxTextLen = 100000
+)
+
+// ErrBlocked is returned for a URL that leads to an address that is not
+// public, such as loopback, private and link-local ones.
+var ErrBlocked = netguard.ErrBlocked
+
+// ErrDenied is returned for a URL whose host the allow and deny lists of
+// the Fetcher exclude.
+var ErrDenied = errors.New("host is not allowed")
+
+// Page is what a page says about itself. URL is where it was found after
+// redirects; the other URLs are absolute. Fields the page does not have
+// are empty, and so are all but URL and Favicon for other content than
+// HTML.
+type Page struct {
+	URL         string
+	Title       string
+	Description string
+	Image       string
+	Favicon     string
+	Text        string
+}
+
+// Fetcher fetches pages from public addresses.
+type Fetcher struct {
+	client *http.Client
+	// allowed reports whether an address may be connected to;
+	// netguard.Public unless a test swaps it.
+	allowed     func(netip.Addr) bool
+	allow, deny []string
+}
+
+// New returns a Fetcher for the hosts in allow, or any host if it is
+// empty, except those in deny. A listed name such as "example.com" also
+// covers its subdomains.
+func New(allow, deny []string) *Fetcher {
+	f := &Fetcher{allowed: netguard.Public, allow: allow, deny: deny}
+	f.client = netguard.NewClient(fetchTimeout, func(a netip.Addr) bool { return f.allowed(a) }, f.checkHost)
+	return f
+}
+
+// checkHost returns ErrDenied unless the allow and deny lists admit the
+// host of u.
+func (f *Fetcher) checkHost(u *url.URL) error {
+	host := strings.TrimSuffix(strings.ToLower(u.Hostname()), ".")
+	if (len(f.allow) > 0 && !listed(f.allow, host)) || listed(f.deny, host) {
+		return fmt.Errorf("%w: %s", ErrDenied, host)
+	}
+	return nil
+}
+
+// listed reports whether host is one of names or a subdomain of one.
+func listed(names []string, host string) bool {
+	for _, n := range names {
+		n = strings.TrimSuffix(strings.ToLower(n), ".")
+		if host == n || strings.HasSuffix(host, "."+n) {
+			return true
+		}
+	}
+	return false
+}
+
+// ParseURL checks that s is an absolute http or https URL and returns it
+// without its fragment.
+func ParseURL(s string) (*url.URL, error) {
+	if len(s) > maxURLLen {
+		return nil, errors.New("url too long")
+	}
+	u, err := url.Parse(strings.TrimSpace(s))
+	if err != nil || (u.Scheme != "http" && u.Scheme != "https") || u.Hostname() == "" {
+		return nil, errors.New("url must be an http or https URL")
+	}
+	u.Fragment, u.RawFragment = "", ""
+	return u, nil
+}
+
+// Fetch fetches rawURL and reads the page, with its readable text if text
+// is set. Pages that answer with an error status are an error, and so are
+// hosts the Fetcher does not admit, with ErrDenied.
+func (f *Fetcher) Fetch(ctx context.Context, rawURL string, text bool) (*Page, error) {
+	u, err := ParseURL(rawURL)
+	if err != nil {
+		return nil, err
+	}
+	if err := f.checkHost(u); err != nil {
+		return nil, err
+	}
+	req, err := http.NewRequestWithContext(ctx, "GET", u.String(), nil)
+	if err != nil {
+		return nil, err
+	}
+	req.Header.Set("User-Agent", "notesd (link preview)")
+	req.Header.Set("Accept", "text/html,application/xhtml+xml;q=0.9,*/*;q=0.1")
+
+	resp, err := f.client.Do(req)
+	if err != nil {
+		return nil, err
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode < 200 || resp.StatusCode >= 300 {
+		return nil, fmt.Errorf("%s answered %d", u.Host, resp.StatusCode)
+	}
+
+	base := resp.Request.URL
+	page := &Page{URL: base.String()}
+	ct := resp.Header.Get("Content-Type")
+	if mt, _, _ := mime.ParseMediaType(ct); mt != "text/html" && mt != "application/xhtml+xml" {
+		page.Favicon = resolve(base, "/favicon.ico")
+		return page, nil
+	}
+	body, err := charset.NewReader(io.LimitReader(resp.Body, maxPageBytes), ct)
+	if err != nil {
+		return nil, fmt.Errorf("decode page: %w", err)
+	}
+	doc, err := html.Parse(body)
+	if err != nil {
+		return nil, fmt.Errorf("parse page: %w", err)
+	}
+	read(page, base, doc, text)
+	return page, nil
+}
+
+// read fills in page from the parsed document. Open Graph and Twitter
+// card properties win over the title element and the plain description.
+func read(page *Page, base *url.URL, doc *html.Node, text bool) {
+	var title, ogTitle, desc, ogDesc, icon, touchIcon string
+	for n := range doc.Descendants() {
+		if n.Type != html.ElementNode {
+			continue
+		}
+		switch n.Data {
+		case "base":
+			if href := attr(n, "href"); href != "" {
+				if u, err := base.Parse(href); err == nil {
+					base = u
+				}
+			}
+		case "title":
+			if title == "" && n.FirstChild != nil {
+				title = n.FirstChild.Data
+			}
+		case "meta":
+			key := strings.ToLower(attr(n, "property"))
+			if key == "" {
+				key = strings.ToLower(attr(n, "name"))
+			}
+			content := attr(n, "content")
+			switch key {
+			case "og:title", "twitter:title":
+				ogTitle = first(ogTitle, content)
+			case "og:description", "twitter:description":
+				ogDesc = first(ogDesc, content)
+			case "description":
+				desc = first(desc, content)
+			case "og:image", "og:image:url", "og:image:secure_url", "twitter:image", "twitter:image:src":
+				page.Image = first(page.Image, resolve(base, content))
+			}
+		case "link":
+			for _, rel := range strings.Fields(strings.ToLower(attr(n, "rel"))) {
+				switch rel {
+				case "icon":
+					icon = first(icon, resolve(base, attr(n, "href")))
+				case "apple-touch-icon":
+					touchIcon = first(touchIcon, resolve(base, attr(n, "href")))
+				}
+			}
+		}
+	}
+	page.Title = clip(collapse(first(ogTitle, title)), maxTitleLen)
+	page.Description = clip(collapse(first(ogDesc, desc)), maxDescLen)
+	page.Favicon = first(icon, touchIcon, resolve(base, "/favicon.ico"))
+	if text {
+		page.Text = clip(readableText(doc), MaxTextLen)
+	}
+}
+
+// skipped are the elements left out of the readable text: code, forms and
+// the furniture around the content.
+var skipped = map[string]bool{
+	"head": true, "script": true, "style": true, "noscript": true, "template": true,
+	"svg": true, "iframe": true, "nav": true, "header": true, "footer": true,
+	"aside": true, "form": true, "button": true, "select": true,
+}
+
+// blocks are the elements that start a new paragraph.
+var blocks = map[string]bool{
+	"p": true, "div": true, "section": true, "article": true, "main": true,
+	"table": true, "tr": true, "ul": true, "ol": true, "dl": true,
+	"blockquote": true, "pre": true, "figure": true, "hr": true,
+	"h1": true, "h2": true, "h3": true, "h4": true, "h5": true, "h6": true,
+}
+
+// readableText returns the text of the page's article, or of its main
+// element, or else of its body, one paragraph per block element and list
+// items marked with "- ".
+func readableText(doc *html.Node) string {
+	root := doc
+	for _, tag := range []string{"article", "main", "body"} {
+		if n := find(doc, tag); n != nil {
+			root = n
+			break
+		}
+	}
+	var b strings.Builder
+	var walk func(*html.Node)
+	walk = func(n *html.Node) {
+		switch {
+		case n.Type == html.TextNode:
+			writeCollapsed(&b, n.Data)
+			return
+		case n.Type != html.ElementNode && n.Type != html.DocumentNode:
+			return
+		case skipped[n.Data]:
+			return
+		case n.Data == "br":
+			b.WriteString("\n")
+		case n.Data == "li":
+			b.WriteString("\n- ")
+		case blocks[n.Data]:
+			b.WriteString("\n\n")
+		}
+		for c := n.FirstChild; c != nil; c = c.NextSibling {
+			walk(c)
+		}
+		if blocks[n.Data] {
+			b.WriteString("\n\n")
+		}
+	}
+	walk(root)
+	return tidyText(b.String())
+}
+
+// find returns the first element named tag, outside skipped elements.
+func find(n *html.Node, tag string) *html.Node {
+	if n.Type == html.ElementNode && skipped[n.Data] {
+		return nil
+	}
+	if n.Type == html.ElementNode && n.Data == tag {
+		return n
+	}
+	for c := n.FirstChild; c != nil; c = c.NextSibling {
+		if f := find(c, tag); f != nil {
+			return f
+		}
+	}
+	return nil
+}
+
+func attr(n *html.Node, key string) string {
+	for _, a := range n.Attr {
+		if a.Key == key {
+			return strings.TrimSpace(a.Val)
+		}
+	}
+	return ""
+}
+
+// first returns the first of values that is not empty.
+func first(values ...string) string {
+	for _, v := range values {
+		if v != "" {
+			return v
+		}
+	}
+	return ""
+}
+
+// resolve returns ref made absolute against base, or "" unless it is an
+// http or https URL of reasonable length.
+func resolve(base *url.URL, ref string) string {
+	if ref == "" {
+		return ""
+	}
+	u, err := base.Parse(ref)
+	if err != nil || (u.Scheme != "http" && u.Scheme != "https") || len(u.String()) > maxURLLen {
+		return ""
+	}
+	return u.String()
+}
+
+// collapse trims s and turns each run of white space into one space.
+func collapse(s string) string {
+	return strings.Join(strings.Fields(s), " ")
+}
+
+// clip cuts s to at most n characters.
+func clip(s string, n int) string {
+	if utf8.RuneCountInString(s) <= n {
+		return s
+	}
+	return strings.TrimSpace(string([]rune(s)[:n]))
+}
+
+// writeCollapsed writes t with each run of white space as one space, as a
+// browser shows it.
+func writeCollapsed(b *strings.Builder, t string) {
+	space := false
+	for _, r := range t {
+		if unicode.IsSpace(r) {
+			space = true
+			continue
+		}
+		if space {
+			b.WriteByte(' ')
+			space = false
+		}
+		b.WriteRune(r)
+	}
+	if space {
+		b.WriteByte(' ')
+	}
+}
+
+// tidyText trims each line and keeps at most one blank line in a row.
+func tidyText(s string) string {
+	var out []string
+	blank := true
+	for _, line := range strings.Split(s, "\n") {
+		line = strings.TrimSpace(line)
+		if line == "" {
+			if !blank {
+				out = append(out, "")
+			}
+			blank = true
+			continue
+		}
+		out = append(out, line)
+		blank = false
+	}
+	return strings.TrimSpace(strings.Join(out, "\n"))
+}
@@ -0,0 +1,180 @@
+package unfurl
+
+import (
+	"context"
+	"errors"
+	"net/http"
+	"net/http/httptest"
+	"net/netip"
+	"strings"
+	"testing"
+)
+
+const testPage = `<!DOCTYPE html>
+<html><head>
+<meta charset="iso-8859-1">
+<title>  Plain
+ title </title>
+<meta property="og:title" content="Card title">
+<meta name="description" content="Plain description">
+<meta property="og:image" content="/img/card.png">
+<link rel="shortcut icon" href="static/icon.ico">
+<script>var x = "<p>not text</p>";</script>
+</head><body>
+<nav><a href="/">Home</a></nav>
+<article><h1>Caf` + "\xe9" + `</h1><p>First   paragraph
+with a line break.</p><ul><li>one</li><li>two</li></ul></article>
+<footer>Copyright</footer>
+</body></html>`
+
+func TestFetch(t *testing.T) {
+	// Arrange: a page behind a redirect, on loopback, which the test allows
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.URL.Path == "/old" {
+			http.Redirect(w, r, "/blog/post", http.StatusMovedPermanently)
+			return
+		}
+		w.Header().Set("Content-Type", "text/html; charset=iso-8859-1")
+		w.Write([]byte(testPage))
+	}))
+	defer srv.Close()
+	f := New(nil, nil)
+	f.allowed = func(netip.Addr) bool { return true }
+
+	// Act
+	page, err := f.Fetch(context.Background(), srv.URL+"/old#frag", true)
+
+	// Assert
+	if err != nil {
+		t.Fatalf("Fetch: %v", err)
+	}
+	t.Logf("page: %+v", page)
+	want := Page{
+		URL:         srv.URL + "/blog/post",
+		Title:       "Card title",
+		Description: "Plain description",
+		Image:       srv.URL + "/img/card.png",
+		Favicon:     srv.URL + "/blog/static/icon.ico",
+		Text:        "Café\n\nFirst paragraph with a line break.\n\n- one\n- two",
+	}
+	if *page != want {
+		t.Errorf("got %+v\nwant %+v", *page, want)
+	}
+}
+
+func TestFetchWithoutText(t *testing.T) {
+	// Arrange: a page without card properties or icon links
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Set("Content-Type", "text/html")
+		w.Write([]byte(`<title>Just a title</title><p>Body</p>`))
+	}))
+	defer srv.Close()
+	f := New(nil, nil)
+	f.allowed = func(netip.Addr) bool { return true }
+
+	// Act
+	page, err := f.Fetch(context.Background(), srv.URL, false)
+
+	// Assert
+	if err != nil {
+		t.Fatalf("Fetch: %v", err)
+	}
+	t.Logf("page: %+v", page)
+	if page.Title != "Just a title" || page.Favicon != srv.URL+"/favicon.ico" || page.Text != "" {
+		t.Errorf("unexpected page: %+v", page)
+	}
+}
+
+func TestFetchBlocksPrivateAddresses(t *testing.T) {
+	// Arrange: a server on loopback, reached by address and by name
+	hits := 0
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		hits++
+	}))
+	defer srv.Close()
+
+	cases := []string{
+		srv.URL,
+		strings.Replace(srv.URL, "127.0.0.1", "localhost", 1),
+	}
+	for _, u := range cases {
+		// Act
+		_, err := New(nil, nil).Fetch(context.Background(), u, false)
+
+		// Assert
+		t.Logf("%s: %v", u, err)
+		if !errors.Is(err, ErrBlocked) {
+			t.Errorf("%s: got %v, want ErrBlocked", u, err)
+		}
+	}
+	if hits != 0 {
+		t.Errorf("the loopback server was reached %d times", hits)
+	}
+}
+
+func TestFetchHostLists(t *testing.T) {
+	// Arrange: a page on loopback, which the test allows, and a redirect
+	// from it to the same server by another name
+	var srv *httptest.Server
+	srv = httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.URL.Path == "/away" {
+			http.Redirect(w, r, strings.Replace(srv.URL, "127.0.0.1", "localhost", 1)+"/", http.StatusFound)
+			return
+		}
+		w.Header().Set("Content-Type", "text/html")
+		w.Write([]byte(`<title>Listed</title>`))
+	}))
+	defer srv.Close()
+
+	cases := []struct {
+		name        string
+		allow, deny []string
+		path        string
+		denied      bool
+	}{
+		{"allowed host", []string{"127.0.0.1"}, nil, "/", false},
+		{"host not allowed", []string{"example.com"}, nil, "/", true},
+		{"denied host", nil, []string{"127.0.0.1"}, "/", true},
+		{"redirect to a host not allowed", []string{"127.0.0.1"}, nil, "/away", true},
+		{"redirect to a denied host", nil, []string{"LocalHost."}, "/away", true},
+	}
+	for _, c := range cases {
+		f := New(c.allow, c.deny)
+		f.allowed = func(netip.Addr) bool { return true }
+
+		// Act
+		page, err := f.Fetch(context.Background(), srv.URL+c.path, false)
+
+		// Assert
+		t.Logf("%s: %+v %v", c.name, page, err)
+		if errors.Is(err, ErrDenied) != c.denied || (!c.denied && err != nil) {
+			t.Errorf("%s: got %v, want denied=%v", c.name, err, c.denied)
+		}
+	}
+}
+
+func TestParseURL(t *testing.T) {
+	cases := []struct {
+		in, want, err string
+	}{
+		{"https://example.com/a?b=c#top", "https://example.com/a?b=c", ""},
+		{" http://example.com ", "http://example.com", ""},
+		{"ftp://example.com/file", "", "url must be an http or https URL"},
+		{"example.com", "", "url must be an http or https URL"},
+		{"https:///path", "", "url must be an http or https URL"},
+		{"https://example.com/" + strings.Repeat("a", maxURLLen), "", "url too long"},
+	}
+	for _, c := range cases {
+		// Act
+		u, err := ParseURL(c.in)
+
+		// Assert
+		t.Logf("%q: %v %v", c.in, u, err)
+		switch {
+		case c.err != "" && (err == nil || err.Error() != c.err):
+			t.Errorf("%q: got error %v, want %q", c.in, err, c.err)
+		case c.err == "" && (err != nil || u.String() != c.want):
+			t.Errorf("%q: got %v %v, want %s", c.in, u, err, c.want)
+		}
+	}
+}
@@ -0,0 +1,30 @@
+// Package version reports build information. Version and Commit are set at
+// link time by the Makefile; Commit falls back to the VCS revision the Go
+// toolchain embeds when building from a checkout.
+package version
+
+import (
+	"runtime/debug"
+)
+
+var (
+	Version = "dev"
+	Commit  = ""
+)
+
+// GetCommit returns the build commit, or "" if unknown.
+func GetCommit() string {
+	if Commit != "" {
+		return Commit
+	}
+	info, ok := debug.ReadBuildInfo()
+	if !ok {
+		return ""
+	}
+	for _, s := range info.Settings {
+		if s.Key == "vcs.revision" {
+			return s.Value
+		}
+	}
+	return ""
+}
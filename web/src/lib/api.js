@@ -31,7 +31,24 @@ async function request(method, path, body) {
 	return resp;
 }
 
+// Requests that fail with 401 at the same time share one refresh: the server
+// takes a second use of a refresh token as theft and ends the session.
+let refreshing = null;
+
 async function refreshTokens(refreshToken) {
+	const current = get(auth);
+	if (current?.refreshToken && current.refreshToken !== refreshToken) {
+		return { accessToken: current.accessToken };
+	}
+	if (!refreshing) {
+		refreshing = doRefresh(refreshToken).finally(() => {
+			refreshing = null;
+		});
+	}
+	return refreshing;
+}
+
+async function doRefresh(refreshToken) {
 	const resp = await fetch(BASE + '/auth/refresh', {
 		method: 'POST',
 		headers: { 'Content-Type': 'application/json' },
@@ -78,11 +95,31 @@ export async function login(email, password, deviceId) {
 	return data.user;
 }
 
-export async function register(email, password, displayName) {
+export async function register(email, password, displayName, inviteCode) {
+	const body = { email, password, display_name: displayName };
+	if (inviteCode) body.invite_code = inviteCode;
 	const resp = await fetch(BASE + '/auth/register', {
 		method: 'POST',
 		headers: { 'Content-Type': 'application/json' },
-		body: JSON.stringify({ email, password, display_name: displayName })
+		body: JSON.stringify(body)
+	});
+	return jsonOrError(resp);
+}
+
+export async function requestPasswordReset(email) {
+	const resp = await fetch(BASE + '/auth/reset-request', {
+		method: 'POST',
+		headers: { 'Content-Type': 'application/json' },
+		body: JSON.stringify({ email })
+	});
+	if (!resp.ok) await jsonOrError(resp);
+}
+
+export async function confirmPasswordReset(token, newPassword) {
+	const resp = await fetch(BASE + '/auth/reset-confirm', {
+		method: 'POST',
+		headers: { 'Content-Type': 'application/json' },
+		body: JSON.stringify({ token, new_password: newPassword })
 	});
 	return jsonOrError(resp);
 }
@@ -171,8 +208,13 @@ export async function getOverdueTodos() {
 
 // Sync
 
-export async function syncChanges(sinceMs) {
-	const resp = await request('GET', `/sync/changes?since=${sinceMs}`);
+// syncChanges fetches one page of changes: pass sinceMs for the first page
+// and the previous page's next_cursor for the following ones.
+export async function syncChanges(sinceMs, cursor = '') {
+	const query = cursor
+		? `cursor=${encodeURIComponent(cursor)}`
+		: `since=${sinceMs}`;
+	const resp = await request('GET', `/sync/changes?${query}`);
 	return jsonOrError(resp);
 }
 
@@ -120,6 +120,26 @@ export async function applyServerChanges(notes, todos) {
 	});
 }
 
+// Drop items the server purged for good; attachments are not kept locally.
+export async function applyPurged(purged) {
+	await db.transaction('rw', db.notes, db.todos, async () => {
+		for (const p of purged) {
+			if (p.type === 'note') await db.notes.delete(p.id);
+			else if (p.type === 'todo') await db.todos.delete(p.id);
+		}
+	});
+}
+
+// Drop everything not changed locally since sinceMs, before a full listing
+// replaces it. The server sends one (reset) when it collected tombstones
+// this device has not seen, so kept items may be deleted ones.
+export async function dropSynced(sinceMs) {
+	await db.transaction('rw', db.notes, db.todos, async () => {
+		await db.notes.filter(n => new Date(n.modified_at).getTime() <= sinceMs).delete();
+		await db.todos.filter(t => new Date(t.modified_at).getTime() <= sinceMs).delete();
+	});
+}
+
 export async function clearLocalData() {
 	await db.notes.clear();
 	await db.todos.clear();
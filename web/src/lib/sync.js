@@ -1,5 +1,5 @@
 import { syncChanges, syncPush } from './api.js';
-import { getLastSync, setLastSync, getLocalChanges, applyServerChanges } from './db.js';
+import { getLastSync, setLastSync, getLocalChanges, applyServerChanges, applyPurged, dropSynced } from './db.js';
 import { auth } from './stores/auth.js';
 import { get } from 'svelte/store';
 import { writable } from 'svelte/store';
@@ -21,6 +21,11 @@ export function stopSync() {
 	}
 }
 
+async function applyPulled(changes) {
+	await applyServerChanges(changes.notes, changes.todos);
+	await applyPurged(changes.purged || []);
+}
+
 export async function doSync() {
 	const session = get(auth);
 	if (!session?.accessToken) return;
@@ -33,10 +38,16 @@ export async function doSync() {
 	syncStatus.set('syncing');
 
 	try {
-		// Pull server changes
+		// Pull server changes, a page at a time. A reset comes with the
+		// first page of a full listing.
 		const lastSync = await getLastSync();
-		const changes = await syncChanges(lastSync);
-		await applyServerChanges(changes.notes, changes.todos);
+		let changes = await syncChanges(lastSync);
+		if (changes.reset) await dropSynced(lastSync);
+		await applyPulled(changes);
+		while (changes.has_more) {
+			changes = await syncChanges(lastSync, changes.next_cursor);
+			await applyPulled(changes);
+		}
 
 		// Push local changes
 		const local = await getLocalChanges(lastSync);